@@ -27,6 +27,14 @@ type Chunk struct {
 	Size uint32 `json:"size,omitempty"`
 	// CompressedSize holds the value of the "compressed_size" field.
 	CompressedSize uint32 `json:"compressed_size,omitempty"`
+	// RefCount holds the value of the "ref_count" field.
+	RefCount int64 `json:"ref_count,omitempty"`
+	// AccessCount holds the value of the "access_count" field.
+	AccessCount int64 `json:"access_count,omitempty"`
+	// LastAccessedAt holds the value of the "last_accessed_at" field.
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+	// Cold holds the value of the "cold" field.
+	Cold bool `json:"cold,omitempty"`
 	// Edges holds the relations/edges for other nodes in the graph.
 	// The values are being populated by the ChunkQuery when eager-loading is set.
 	Edges        ChunkEdges `json:"edges"`
@@ -56,11 +64,13 @@ func (*Chunk) scanValues(columns []string) ([]any, error) {
 	values := make([]any, len(columns))
 	for i := range columns {
 		switch columns[i] {
-		case chunk.FieldID, chunk.FieldSize, chunk.FieldCompressedSize:
+		case chunk.FieldCold:
+			values[i] = new(sql.NullBool)
+		case chunk.FieldID, chunk.FieldSize, chunk.FieldCompressedSize, chunk.FieldRefCount, chunk.FieldAccessCount:
 			values[i] = new(sql.NullInt64)
 		case chunk.FieldHash:
 			values[i] = new(sql.NullString)
-		case chunk.FieldCreatedAt, chunk.FieldUpdatedAt:
+		case chunk.FieldCreatedAt, chunk.FieldUpdatedAt, chunk.FieldLastAccessedAt:
 			values[i] = new(sql.NullTime)
 		default:
 			values[i] = new(sql.UnknownType)
@@ -114,6 +124,31 @@ func (_m *Chunk) assignValues(columns []string, values []any) error {
 			} else if value.Valid {
 				_m.CompressedSize = uint32(value.Int64)
 			}
+		case chunk.FieldRefCount:
+			if value, ok := values[i].(*sql.NullInt64); !ok {
+				return fmt.Errorf("unexpected type %T for field ref_count", values[i])
+			} else if value.Valid {
+				_m.RefCount = value.Int64
+			}
+		case chunk.FieldAccessCount:
+			if value, ok := values[i].(*sql.NullInt64); !ok {
+				return fmt.Errorf("unexpected type %T for field access_count", values[i])
+			} else if value.Valid {
+				_m.AccessCount = value.Int64
+			}
+		case chunk.FieldLastAccessedAt:
+			if value, ok := values[i].(*sql.NullTime); !ok {
+				return fmt.Errorf("unexpected type %T for field last_accessed_at", values[i])
+			} else if value.Valid {
+				_m.LastAccessedAt = new(time.Time)
+				*_m.LastAccessedAt = value.Time
+			}
+		case chunk.FieldCold:
+			if value, ok := values[i].(*sql.NullBool); !ok {
+				return fmt.Errorf("unexpected type %T for field cold", values[i])
+			} else if value.Valid {
+				_m.Cold = value.Bool
+			}
 		default:
 			_m.selectValues.Set(columns[i], values[i])
 		}
@@ -171,6 +206,20 @@ func (_m *Chunk) String() string {
 	builder.WriteString(", ")
 	builder.WriteString("compressed_size=")
 	builder.WriteString(fmt.Sprintf("%v", _m.CompressedSize))
+	builder.WriteString(", ")
+	builder.WriteString("ref_count=")
+	builder.WriteString(fmt.Sprintf("%v", _m.RefCount))
+	builder.WriteString(", ")
+	builder.WriteString("access_count=")
+	builder.WriteString(fmt.Sprintf("%v", _m.AccessCount))
+	builder.WriteString(", ")
+	if v := _m.LastAccessedAt; v != nil {
+		builder.WriteString("last_accessed_at=")
+		builder.WriteString(v.Format(time.ANSIC))
+	}
+	builder.WriteString(", ")
+	builder.WriteString("cold=")
+	builder.WriteString(fmt.Sprintf("%v", _m.Cold))
 	builder.WriteByte(')')
 	return builder.String()
 }
@@ -24,6 +24,14 @@ const (
 	FieldSize = "size"
 	// FieldCompressedSize holds the string denoting the compressed_size field in the database.
 	FieldCompressedSize = "compressed_size"
+	// FieldRefCount holds the string denoting the ref_count field in the database.
+	FieldRefCount = "ref_count"
+	// FieldAccessCount holds the string denoting the access_count field in the database.
+	FieldAccessCount = "access_count"
+	// FieldLastAccessedAt holds the string denoting the last_accessed_at field in the database.
+	FieldLastAccessedAt = "last_accessed_at"
+	// FieldCold holds the string denoting the cold field in the database.
+	FieldCold = "cold"
 	// EdgeNarFileLinks holds the string denoting the nar_file_links edge name in mutations.
 	EdgeNarFileLinks = "nar_file_links"
 	// Table holds the table name of the chunk in the database.
@@ -45,6 +53,10 @@ var Columns = []string{
 	FieldHash,
 	FieldSize,
 	FieldCompressedSize,
+	FieldRefCount,
+	FieldAccessCount,
+	FieldLastAccessedAt,
+	FieldCold,
 }
 
 // ValidColumn reports if the column name is valid (part of the table columns).
@@ -64,6 +76,12 @@ var (
 	HashValidator func(string) error
 	// DefaultCompressedSize holds the default value on creation for the "compressed_size" field.
 	DefaultCompressedSize uint32
+	// DefaultRefCount holds the default value on creation for the "ref_count" field.
+	DefaultRefCount int64
+	// DefaultAccessCount holds the default value on creation for the "access_count" field.
+	DefaultAccessCount int64
+	// DefaultCold holds the default value on creation for the "cold" field.
+	DefaultCold bool
 )
 
 // OrderOption defines the ordering options for the Chunk queries.
@@ -99,6 +117,26 @@ func ByCompressedSize(opts ...sql.OrderTermOption) OrderOption {
 	return sql.OrderByField(FieldCompressedSize, opts...).ToFunc()
 }
 
+// ByRefCount orders the results by the ref_count field.
+func ByRefCount(opts ...sql.OrderTermOption) OrderOption {
+	return sql.OrderByField(FieldRefCount, opts...).ToFunc()
+}
+
+// ByAccessCount orders the results by the access_count field.
+func ByAccessCount(opts ...sql.OrderTermOption) OrderOption {
+	return sql.OrderByField(FieldAccessCount, opts...).ToFunc()
+}
+
+// ByLastAccessedAt orders the results by the last_accessed_at field.
+func ByLastAccessedAt(opts ...sql.OrderTermOption) OrderOption {
+	return sql.OrderByField(FieldLastAccessedAt, opts...).ToFunc()
+}
+
+// ByCold orders the results by the cold field.
+func ByCold(opts ...sql.OrderTermOption) OrderOption {
+	return sql.OrderByField(FieldCold, opts...).ToFunc()
+}
+
 // ByNarFileLinksCount orders the results by nar_file_links count.
 func ByNarFileLinksCount(opts ...sql.OrderTermOption) OrderOption {
 	return func(s *sql.Selector) {
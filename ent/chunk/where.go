@@ -80,6 +80,26 @@ func CompressedSize(v uint32) predicate.Chunk {
 	return predicate.Chunk(sql.FieldEQ(FieldCompressedSize, v))
 }
 
+// RefCount applies equality check predicate on the "ref_count" field. It's identical to RefCountEQ.
+func RefCount(v int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldEQ(FieldRefCount, v))
+}
+
+// AccessCount applies equality check predicate on the "access_count" field. It's identical to AccessCountEQ.
+func AccessCount(v int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldEQ(FieldAccessCount, v))
+}
+
+// LastAccessedAt applies equality check predicate on the "last_accessed_at" field. It's identical to LastAccessedAtEQ.
+func LastAccessedAt(v time.Time) predicate.Chunk {
+	return predicate.Chunk(sql.FieldEQ(FieldLastAccessedAt, v))
+}
+
+// Cold applies equality check predicate on the "cold" field. It's identical to ColdEQ.
+func Cold(v bool) predicate.Chunk {
+	return predicate.Chunk(sql.FieldEQ(FieldCold, v))
+}
+
 // CreatedAtEQ applies the EQ predicate on the "created_at" field.
 func CreatedAtEQ(v time.Time) predicate.Chunk {
 	return predicate.Chunk(sql.FieldEQ(FieldCreatedAt, v))
@@ -315,6 +335,146 @@ func CompressedSizeLTE(v uint32) predicate.Chunk {
 	return predicate.Chunk(sql.FieldLTE(FieldCompressedSize, v))
 }
 
+// RefCountEQ applies the EQ predicate on the "ref_count" field.
+func RefCountEQ(v int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldEQ(FieldRefCount, v))
+}
+
+// RefCountNEQ applies the NEQ predicate on the "ref_count" field.
+func RefCountNEQ(v int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldNEQ(FieldRefCount, v))
+}
+
+// RefCountIn applies the In predicate on the "ref_count" field.
+func RefCountIn(vs ...int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldIn(FieldRefCount, vs...))
+}
+
+// RefCountNotIn applies the NotIn predicate on the "ref_count" field.
+func RefCountNotIn(vs ...int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldNotIn(FieldRefCount, vs...))
+}
+
+// RefCountGT applies the GT predicate on the "ref_count" field.
+func RefCountGT(v int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldGT(FieldRefCount, v))
+}
+
+// RefCountGTE applies the GTE predicate on the "ref_count" field.
+func RefCountGTE(v int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldGTE(FieldRefCount, v))
+}
+
+// RefCountLT applies the LT predicate on the "ref_count" field.
+func RefCountLT(v int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldLT(FieldRefCount, v))
+}
+
+// RefCountLTE applies the LTE predicate on the "ref_count" field.
+func RefCountLTE(v int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldLTE(FieldRefCount, v))
+}
+
+// AccessCountEQ applies the EQ predicate on the "access_count" field.
+func AccessCountEQ(v int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldEQ(FieldAccessCount, v))
+}
+
+// AccessCountNEQ applies the NEQ predicate on the "access_count" field.
+func AccessCountNEQ(v int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldNEQ(FieldAccessCount, v))
+}
+
+// AccessCountIn applies the In predicate on the "access_count" field.
+func AccessCountIn(vs ...int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldIn(FieldAccessCount, vs...))
+}
+
+// AccessCountNotIn applies the NotIn predicate on the "access_count" field.
+func AccessCountNotIn(vs ...int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldNotIn(FieldAccessCount, vs...))
+}
+
+// AccessCountGT applies the GT predicate on the "access_count" field.
+func AccessCountGT(v int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldGT(FieldAccessCount, v))
+}
+
+// AccessCountGTE applies the GTE predicate on the "access_count" field.
+func AccessCountGTE(v int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldGTE(FieldAccessCount, v))
+}
+
+// AccessCountLT applies the LT predicate on the "access_count" field.
+func AccessCountLT(v int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldLT(FieldAccessCount, v))
+}
+
+// AccessCountLTE applies the LTE predicate on the "access_count" field.
+func AccessCountLTE(v int64) predicate.Chunk {
+	return predicate.Chunk(sql.FieldLTE(FieldAccessCount, v))
+}
+
+// LastAccessedAtEQ applies the EQ predicate on the "last_accessed_at" field.
+func LastAccessedAtEQ(v time.Time) predicate.Chunk {
+	return predicate.Chunk(sql.FieldEQ(FieldLastAccessedAt, v))
+}
+
+// LastAccessedAtNEQ applies the NEQ predicate on the "last_accessed_at" field.
+func LastAccessedAtNEQ(v time.Time) predicate.Chunk {
+	return predicate.Chunk(sql.FieldNEQ(FieldLastAccessedAt, v))
+}
+
+// LastAccessedAtIn applies the In predicate on the "last_accessed_at" field.
+func LastAccessedAtIn(vs ...time.Time) predicate.Chunk {
+	return predicate.Chunk(sql.FieldIn(FieldLastAccessedAt, vs...))
+}
+
+// LastAccessedAtNotIn applies the NotIn predicate on the "last_accessed_at" field.
+func LastAccessedAtNotIn(vs ...time.Time) predicate.Chunk {
+	return predicate.Chunk(sql.FieldNotIn(FieldLastAccessedAt, vs...))
+}
+
+// LastAccessedAtGT applies the GT predicate on the "last_accessed_at" field.
+func LastAccessedAtGT(v time.Time) predicate.Chunk {
+	return predicate.Chunk(sql.FieldGT(FieldLastAccessedAt, v))
+}
+
+// LastAccessedAtGTE applies the GTE predicate on the "last_accessed_at" field.
+func LastAccessedAtGTE(v time.Time) predicate.Chunk {
+	return predicate.Chunk(sql.FieldGTE(FieldLastAccessedAt, v))
+}
+
+// LastAccessedAtLT applies the LT predicate on the "last_accessed_at" field.
+func LastAccessedAtLT(v time.Time) predicate.Chunk {
+	return predicate.Chunk(sql.FieldLT(FieldLastAccessedAt, v))
+}
+
+// LastAccessedAtLTE applies the LTE predicate on the "last_accessed_at" field.
+func LastAccessedAtLTE(v time.Time) predicate.Chunk {
+	return predicate.Chunk(sql.FieldLTE(FieldLastAccessedAt, v))
+}
+
+// LastAccessedAtIsNil applies the IsNil predicate on the "last_accessed_at" field.
+func LastAccessedAtIsNil() predicate.Chunk {
+	return predicate.Chunk(sql.FieldIsNull(FieldLastAccessedAt))
+}
+
+// LastAccessedAtNotNil applies the NotNil predicate on the "last_accessed_at" field.
+func LastAccessedAtNotNil() predicate.Chunk {
+	return predicate.Chunk(sql.FieldNotNull(FieldLastAccessedAt))
+}
+
+// ColdEQ applies the EQ predicate on the "cold" field.
+func ColdEQ(v bool) predicate.Chunk {
+	return predicate.Chunk(sql.FieldEQ(FieldCold, v))
+}
+
+// ColdNEQ applies the NEQ predicate on the "cold" field.
+func ColdNEQ(v bool) predicate.Chunk {
+	return predicate.Chunk(sql.FieldNEQ(FieldCold, v))
+}
+
 // HasNarFileLinks applies the HasEdge predicate on the "nar_file_links" edge.
 func HasNarFileLinks() predicate.Chunk {
 	return predicate.Chunk(func(s *sql.Selector) {
@@ -77,6 +77,62 @@ func (_c *ChunkCreate) SetNillableCompressedSize(v *uint32) *ChunkCreate {
 	return _c
 }
 
+// SetRefCount sets the "ref_count" field.
+func (_c *ChunkCreate) SetRefCount(v int64) *ChunkCreate {
+	_c.mutation.SetRefCount(v)
+	return _c
+}
+
+// SetNillableRefCount sets the "ref_count" field if the given value is not nil.
+func (_c *ChunkCreate) SetNillableRefCount(v *int64) *ChunkCreate {
+	if v != nil {
+		_c.SetRefCount(*v)
+	}
+	return _c
+}
+
+// SetAccessCount sets the "access_count" field.
+func (_c *ChunkCreate) SetAccessCount(v int64) *ChunkCreate {
+	_c.mutation.SetAccessCount(v)
+	return _c
+}
+
+// SetNillableAccessCount sets the "access_count" field if the given value is not nil.
+func (_c *ChunkCreate) SetNillableAccessCount(v *int64) *ChunkCreate {
+	if v != nil {
+		_c.SetAccessCount(*v)
+	}
+	return _c
+}
+
+// SetLastAccessedAt sets the "last_accessed_at" field.
+func (_c *ChunkCreate) SetLastAccessedAt(v time.Time) *ChunkCreate {
+	_c.mutation.SetLastAccessedAt(v)
+	return _c
+}
+
+// SetNillableLastAccessedAt sets the "last_accessed_at" field if the given value is not nil.
+func (_c *ChunkCreate) SetNillableLastAccessedAt(v *time.Time) *ChunkCreate {
+	if v != nil {
+		_c.SetLastAccessedAt(*v)
+	}
+	return _c
+}
+
+// SetCold sets the "cold" field.
+func (_c *ChunkCreate) SetCold(v bool) *ChunkCreate {
+	_c.mutation.SetCold(v)
+	return _c
+}
+
+// SetNillableCold sets the "cold" field if the given value is not nil.
+func (_c *ChunkCreate) SetNillableCold(v *bool) *ChunkCreate {
+	if v != nil {
+		_c.SetCold(*v)
+	}
+	return _c
+}
+
 // AddNarFileLinkIDs adds the "nar_file_links" edge to the NarFileChunk entity by IDs.
 func (_c *ChunkCreate) AddNarFileLinkIDs(ids ...int) *ChunkCreate {
 	_c.mutation.AddNarFileLinkIDs(ids...)
@@ -135,6 +191,18 @@ func (_c *ChunkCreate) defaults() {
 		v := chunk.DefaultCompressedSize
 		_c.mutation.SetCompressedSize(v)
 	}
+	if _, ok := _c.mutation.RefCount(); !ok {
+		v := chunk.DefaultRefCount
+		_c.mutation.SetRefCount(v)
+	}
+	if _, ok := _c.mutation.AccessCount(); !ok {
+		v := chunk.DefaultAccessCount
+		_c.mutation.SetAccessCount(v)
+	}
+	if _, ok := _c.mutation.Cold(); !ok {
+		v := chunk.DefaultCold
+		_c.mutation.SetCold(v)
+	}
 }
 
 // check runs all checks and user-defined validators on the builder.
@@ -156,6 +224,15 @@ func (_c *ChunkCreate) check() error {
 	if _, ok := _c.mutation.CompressedSize(); !ok {
 		return &ValidationError{Name: "compressed_size", err: errors.New(`ent: missing required field "Chunk.compressed_size"`)}
 	}
+	if _, ok := _c.mutation.RefCount(); !ok {
+		return &ValidationError{Name: "ref_count", err: errors.New(`ent: missing required field "Chunk.ref_count"`)}
+	}
+	if _, ok := _c.mutation.AccessCount(); !ok {
+		return &ValidationError{Name: "access_count", err: errors.New(`ent: missing required field "Chunk.access_count"`)}
+	}
+	if _, ok := _c.mutation.Cold(); !ok {
+		return &ValidationError{Name: "cold", err: errors.New(`ent: missing required field "Chunk.cold"`)}
+	}
 	return nil
 }
 
@@ -203,6 +280,22 @@ func (_c *ChunkCreate) createSpec() (*Chunk, *sqlgraph.CreateSpec) {
 		_spec.SetField(chunk.FieldCompressedSize, field.TypeUint32, value)
 		_node.CompressedSize = value
 	}
+	if value, ok := _c.mutation.RefCount(); ok {
+		_spec.SetField(chunk.FieldRefCount, field.TypeInt64, value)
+		_node.RefCount = value
+	}
+	if value, ok := _c.mutation.AccessCount(); ok {
+		_spec.SetField(chunk.FieldAccessCount, field.TypeInt64, value)
+		_node.AccessCount = value
+	}
+	if value, ok := _c.mutation.LastAccessedAt(); ok {
+		_spec.SetField(chunk.FieldLastAccessedAt, field.TypeTime, value)
+		_node.LastAccessedAt = &value
+	}
+	if value, ok := _c.mutation.Cold(); ok {
+		_spec.SetField(chunk.FieldCold, field.TypeBool, value)
+		_node.Cold = value
+	}
 	if nodes := _c.mutation.NarFileLinksIDs(); len(nodes) > 0 {
 		edge := &sqlgraph.EdgeSpec{
 			Rel:     sqlgraph.O2M,
@@ -337,6 +430,72 @@ func (u *ChunkUpsert) AddCompressedSize(v uint32) *ChunkUpsert {
 	return u
 }
 
+// SetRefCount sets the "ref_count" field.
+func (u *ChunkUpsert) SetRefCount(v int64) *ChunkUpsert {
+	u.Set(chunk.FieldRefCount, v)
+	return u
+}
+
+// UpdateRefCount sets the "ref_count" field to the value that was provided on create.
+func (u *ChunkUpsert) UpdateRefCount() *ChunkUpsert {
+	u.SetExcluded(chunk.FieldRefCount)
+	return u
+}
+
+// AddRefCount adds v to the "ref_count" field.
+func (u *ChunkUpsert) AddRefCount(v int64) *ChunkUpsert {
+	u.Add(chunk.FieldRefCount, v)
+	return u
+}
+
+// SetAccessCount sets the "access_count" field.
+func (u *ChunkUpsert) SetAccessCount(v int64) *ChunkUpsert {
+	u.Set(chunk.FieldAccessCount, v)
+	return u
+}
+
+// UpdateAccessCount sets the "access_count" field to the value that was provided on create.
+func (u *ChunkUpsert) UpdateAccessCount() *ChunkUpsert {
+	u.SetExcluded(chunk.FieldAccessCount)
+	return u
+}
+
+// AddAccessCount adds v to the "access_count" field.
+func (u *ChunkUpsert) AddAccessCount(v int64) *ChunkUpsert {
+	u.Add(chunk.FieldAccessCount, v)
+	return u
+}
+
+// SetLastAccessedAt sets the "last_accessed_at" field.
+func (u *ChunkUpsert) SetLastAccessedAt(v time.Time) *ChunkUpsert {
+	u.Set(chunk.FieldLastAccessedAt, v)
+	return u
+}
+
+// UpdateLastAccessedAt sets the "last_accessed_at" field to the value that was provided on create.
+func (u *ChunkUpsert) UpdateLastAccessedAt() *ChunkUpsert {
+	u.SetExcluded(chunk.FieldLastAccessedAt)
+	return u
+}
+
+// ClearLastAccessedAt clears the value of the "last_accessed_at" field.
+func (u *ChunkUpsert) ClearLastAccessedAt() *ChunkUpsert {
+	u.SetNull(chunk.FieldLastAccessedAt)
+	return u
+}
+
+// SetCold sets the "cold" field.
+func (u *ChunkUpsert) SetCold(v bool) *ChunkUpsert {
+	u.Set(chunk.FieldCold, v)
+	return u
+}
+
+// UpdateCold sets the "cold" field to the value that was provided on create.
+func (u *ChunkUpsert) UpdateCold() *ChunkUpsert {
+	u.SetExcluded(chunk.FieldCold)
+	return u
+}
+
 // UpdateNewValues updates the mutable fields using the new values that were set on create.
 // Using this option is equivalent to using:
 //
@@ -459,6 +618,83 @@ func (u *ChunkUpsertOne) UpdateCompressedSize() *ChunkUpsertOne {
 	})
 }
 
+// SetRefCount sets the "ref_count" field.
+func (u *ChunkUpsertOne) SetRefCount(v int64) *ChunkUpsertOne {
+	return u.Update(func(s *ChunkUpsert) {
+		s.SetRefCount(v)
+	})
+}
+
+// AddRefCount adds v to the "ref_count" field.
+func (u *ChunkUpsertOne) AddRefCount(v int64) *ChunkUpsertOne {
+	return u.Update(func(s *ChunkUpsert) {
+		s.AddRefCount(v)
+	})
+}
+
+// UpdateRefCount sets the "ref_count" field to the value that was provided on create.
+func (u *ChunkUpsertOne) UpdateRefCount() *ChunkUpsertOne {
+	return u.Update(func(s *ChunkUpsert) {
+		s.UpdateRefCount()
+	})
+}
+
+// SetAccessCount sets the "access_count" field.
+func (u *ChunkUpsertOne) SetAccessCount(v int64) *ChunkUpsertOne {
+	return u.Update(func(s *ChunkUpsert) {
+		s.SetAccessCount(v)
+	})
+}
+
+// AddAccessCount adds v to the "access_count" field.
+func (u *ChunkUpsertOne) AddAccessCount(v int64) *ChunkUpsertOne {
+	return u.Update(func(s *ChunkUpsert) {
+		s.AddAccessCount(v)
+	})
+}
+
+// UpdateAccessCount sets the "access_count" field to the value that was provided on create.
+func (u *ChunkUpsertOne) UpdateAccessCount() *ChunkUpsertOne {
+	return u.Update(func(s *ChunkUpsert) {
+		s.UpdateAccessCount()
+	})
+}
+
+// SetLastAccessedAt sets the "last_accessed_at" field.
+func (u *ChunkUpsertOne) SetLastAccessedAt(v time.Time) *ChunkUpsertOne {
+	return u.Update(func(s *ChunkUpsert) {
+		s.SetLastAccessedAt(v)
+	})
+}
+
+// UpdateLastAccessedAt sets the "last_accessed_at" field to the value that was provided on create.
+func (u *ChunkUpsertOne) UpdateLastAccessedAt() *ChunkUpsertOne {
+	return u.Update(func(s *ChunkUpsert) {
+		s.UpdateLastAccessedAt()
+	})
+}
+
+// ClearLastAccessedAt clears the value of the "last_accessed_at" field.
+func (u *ChunkUpsertOne) ClearLastAccessedAt() *ChunkUpsertOne {
+	return u.Update(func(s *ChunkUpsert) {
+		s.ClearLastAccessedAt()
+	})
+}
+
+// SetCold sets the "cold" field.
+func (u *ChunkUpsertOne) SetCold(v bool) *ChunkUpsertOne {
+	return u.Update(func(s *ChunkUpsert) {
+		s.SetCold(v)
+	})
+}
+
+// UpdateCold sets the "cold" field to the value that was provided on create.
+func (u *ChunkUpsertOne) UpdateCold() *ChunkUpsertOne {
+	return u.Update(func(s *ChunkUpsert) {
+		s.UpdateCold()
+	})
+}
+
 // Exec executes the query.
 func (u *ChunkUpsertOne) Exec(ctx context.Context) error {
 	if len(u.create.conflict) == 0 {
@@ -747,6 +983,83 @@ func (u *ChunkUpsertBulk) UpdateCompressedSize() *ChunkUpsertBulk {
 	})
 }
 
+// SetRefCount sets the "ref_count" field.
+func (u *ChunkUpsertBulk) SetRefCount(v int64) *ChunkUpsertBulk {
+	return u.Update(func(s *ChunkUpsert) {
+		s.SetRefCount(v)
+	})
+}
+
+// AddRefCount adds v to the "ref_count" field.
+func (u *ChunkUpsertBulk) AddRefCount(v int64) *ChunkUpsertBulk {
+	return u.Update(func(s *ChunkUpsert) {
+		s.AddRefCount(v)
+	})
+}
+
+// UpdateRefCount sets the "ref_count" field to the value that was provided on create.
+func (u *ChunkUpsertBulk) UpdateRefCount() *ChunkUpsertBulk {
+	return u.Update(func(s *ChunkUpsert) {
+		s.UpdateRefCount()
+	})
+}
+
+// SetAccessCount sets the "access_count" field.
+func (u *ChunkUpsertBulk) SetAccessCount(v int64) *ChunkUpsertBulk {
+	return u.Update(func(s *ChunkUpsert) {
+		s.SetAccessCount(v)
+	})
+}
+
+// AddAccessCount adds v to the "access_count" field.
+func (u *ChunkUpsertBulk) AddAccessCount(v int64) *ChunkUpsertBulk {
+	return u.Update(func(s *ChunkUpsert) {
+		s.AddAccessCount(v)
+	})
+}
+
+// UpdateAccessCount sets the "access_count" field to the value that was provided on create.
+func (u *ChunkUpsertBulk) UpdateAccessCount() *ChunkUpsertBulk {
+	return u.Update(func(s *ChunkUpsert) {
+		s.UpdateAccessCount()
+	})
+}
+
+// SetLastAccessedAt sets the "last_accessed_at" field.
+func (u *ChunkUpsertBulk) SetLastAccessedAt(v time.Time) *ChunkUpsertBulk {
+	return u.Update(func(s *ChunkUpsert) {
+		s.SetLastAccessedAt(v)
+	})
+}
+
+// UpdateLastAccessedAt sets the "last_accessed_at" field to the value that was provided on create.
+func (u *ChunkUpsertBulk) UpdateLastAccessedAt() *ChunkUpsertBulk {
+	return u.Update(func(s *ChunkUpsert) {
+		s.UpdateLastAccessedAt()
+	})
+}
+
+// ClearLastAccessedAt clears the value of the "last_accessed_at" field.
+func (u *ChunkUpsertBulk) ClearLastAccessedAt() *ChunkUpsertBulk {
+	return u.Update(func(s *ChunkUpsert) {
+		s.ClearLastAccessedAt()
+	})
+}
+
+// SetCold sets the "cold" field.
+func (u *ChunkUpsertBulk) SetCold(v bool) *ChunkUpsertBulk {
+	return u.Update(func(s *ChunkUpsert) {
+		s.SetCold(v)
+	})
+}
+
+// UpdateCold sets the "cold" field to the value that was provided on create.
+func (u *ChunkUpsertBulk) UpdateCold() *ChunkUpsertBulk {
+	return u.Update(func(s *ChunkUpsert) {
+		s.UpdateCold()
+	})
+}
+
 // Exec executes the query.
 func (u *ChunkUpsertBulk) Exec(ctx context.Context) error {
 	if u.create.err != nil {
@@ -105,6 +105,82 @@ func (_u *ChunkUpdate) AddCompressedSize(v int32) *ChunkUpdate {
 	return _u
 }
 
+// SetRefCount sets the "ref_count" field.
+func (_u *ChunkUpdate) SetRefCount(v int64) *ChunkUpdate {
+	_u.mutation.ResetRefCount()
+	_u.mutation.SetRefCount(v)
+	return _u
+}
+
+// SetNillableRefCount sets the "ref_count" field if the given value is not nil.
+func (_u *ChunkUpdate) SetNillableRefCount(v *int64) *ChunkUpdate {
+	if v != nil {
+		_u.SetRefCount(*v)
+	}
+	return _u
+}
+
+// AddRefCount adds value to the "ref_count" field.
+func (_u *ChunkUpdate) AddRefCount(v int64) *ChunkUpdate {
+	_u.mutation.AddRefCount(v)
+	return _u
+}
+
+// SetAccessCount sets the "access_count" field.
+func (_u *ChunkUpdate) SetAccessCount(v int64) *ChunkUpdate {
+	_u.mutation.ResetAccessCount()
+	_u.mutation.SetAccessCount(v)
+	return _u
+}
+
+// SetNillableAccessCount sets the "access_count" field if the given value is not nil.
+func (_u *ChunkUpdate) SetNillableAccessCount(v *int64) *ChunkUpdate {
+	if v != nil {
+		_u.SetAccessCount(*v)
+	}
+	return _u
+}
+
+// AddAccessCount adds value to the "access_count" field.
+func (_u *ChunkUpdate) AddAccessCount(v int64) *ChunkUpdate {
+	_u.mutation.AddAccessCount(v)
+	return _u
+}
+
+// SetLastAccessedAt sets the "last_accessed_at" field.
+func (_u *ChunkUpdate) SetLastAccessedAt(v time.Time) *ChunkUpdate {
+	_u.mutation.SetLastAccessedAt(v)
+	return _u
+}
+
+// SetNillableLastAccessedAt sets the "last_accessed_at" field if the given value is not nil.
+func (_u *ChunkUpdate) SetNillableLastAccessedAt(v *time.Time) *ChunkUpdate {
+	if v != nil {
+		_u.SetLastAccessedAt(*v)
+	}
+	return _u
+}
+
+// ClearLastAccessedAt clears the value of the "last_accessed_at" field.
+func (_u *ChunkUpdate) ClearLastAccessedAt() *ChunkUpdate {
+	_u.mutation.ClearLastAccessedAt()
+	return _u
+}
+
+// SetCold sets the "cold" field.
+func (_u *ChunkUpdate) SetCold(v bool) *ChunkUpdate {
+	_u.mutation.SetCold(v)
+	return _u
+}
+
+// SetNillableCold sets the "cold" field if the given value is not nil.
+func (_u *ChunkUpdate) SetNillableCold(v *bool) *ChunkUpdate {
+	if v != nil {
+		_u.SetCold(*v)
+	}
+	return _u
+}
+
 // AddNarFileLinkIDs adds the "nar_file_links" edge to the NarFileChunk entity by IDs.
 func (_u *ChunkUpdate) AddNarFileLinkIDs(ids ...int) *ChunkUpdate {
 	_u.mutation.AddNarFileLinkIDs(ids...)
@@ -216,6 +292,27 @@ func (_u *ChunkUpdate) sqlSave(ctx context.Context) (_node int, err error) {
 	if value, ok := _u.mutation.AddedCompressedSize(); ok {
 		_spec.AddField(chunk.FieldCompressedSize, field.TypeUint32, value)
 	}
+	if value, ok := _u.mutation.RefCount(); ok {
+		_spec.SetField(chunk.FieldRefCount, field.TypeInt64, value)
+	}
+	if value, ok := _u.mutation.AddedRefCount(); ok {
+		_spec.AddField(chunk.FieldRefCount, field.TypeInt64, value)
+	}
+	if value, ok := _u.mutation.AccessCount(); ok {
+		_spec.SetField(chunk.FieldAccessCount, field.TypeInt64, value)
+	}
+	if value, ok := _u.mutation.AddedAccessCount(); ok {
+		_spec.AddField(chunk.FieldAccessCount, field.TypeInt64, value)
+	}
+	if value, ok := _u.mutation.LastAccessedAt(); ok {
+		_spec.SetField(chunk.FieldLastAccessedAt, field.TypeTime, value)
+	}
+	if _u.mutation.LastAccessedAtCleared() {
+		_spec.ClearField(chunk.FieldLastAccessedAt, field.TypeTime)
+	}
+	if value, ok := _u.mutation.Cold(); ok {
+		_spec.SetField(chunk.FieldCold, field.TypeBool, value)
+	}
 	if _u.mutation.NarFileLinksCleared() {
 		edge := &sqlgraph.EdgeSpec{
 			Rel:     sqlgraph.O2M,
@@ -357,6 +454,82 @@ func (_u *ChunkUpdateOne) AddCompressedSize(v int32) *ChunkUpdateOne {
 	return _u
 }
 
+// SetRefCount sets the "ref_count" field.
+func (_u *ChunkUpdateOne) SetRefCount(v int64) *ChunkUpdateOne {
+	_u.mutation.ResetRefCount()
+	_u.mutation.SetRefCount(v)
+	return _u
+}
+
+// SetNillableRefCount sets the "ref_count" field if the given value is not nil.
+func (_u *ChunkUpdateOne) SetNillableRefCount(v *int64) *ChunkUpdateOne {
+	if v != nil {
+		_u.SetRefCount(*v)
+	}
+	return _u
+}
+
+// AddRefCount adds value to the "ref_count" field.
+func (_u *ChunkUpdateOne) AddRefCount(v int64) *ChunkUpdateOne {
+	_u.mutation.AddRefCount(v)
+	return _u
+}
+
+// SetAccessCount sets the "access_count" field.
+func (_u *ChunkUpdateOne) SetAccessCount(v int64) *ChunkUpdateOne {
+	_u.mutation.ResetAccessCount()
+	_u.mutation.SetAccessCount(v)
+	return _u
+}
+
+// SetNillableAccessCount sets the "access_count" field if the given value is not nil.
+func (_u *ChunkUpdateOne) SetNillableAccessCount(v *int64) *ChunkUpdateOne {
+	if v != nil {
+		_u.SetAccessCount(*v)
+	}
+	return _u
+}
+
+// AddAccessCount adds value to the "access_count" field.
+func (_u *ChunkUpdateOne) AddAccessCount(v int64) *ChunkUpdateOne {
+	_u.mutation.AddAccessCount(v)
+	return _u
+}
+
+// SetLastAccessedAt sets the "last_accessed_at" field.
+func (_u *ChunkUpdateOne) SetLastAccessedAt(v time.Time) *ChunkUpdateOne {
+	_u.mutation.SetLastAccessedAt(v)
+	return _u
+}
+
+// SetNillableLastAccessedAt sets the "last_accessed_at" field if the given value is not nil.
+func (_u *ChunkUpdateOne) SetNillableLastAccessedAt(v *time.Time) *ChunkUpdateOne {
+	if v != nil {
+		_u.SetLastAccessedAt(*v)
+	}
+	return _u
+}
+
+// ClearLastAccessedAt clears the value of the "last_accessed_at" field.
+func (_u *ChunkUpdateOne) ClearLastAccessedAt() *ChunkUpdateOne {
+	_u.mutation.ClearLastAccessedAt()
+	return _u
+}
+
+// SetCold sets the "cold" field.
+func (_u *ChunkUpdateOne) SetCold(v bool) *ChunkUpdateOne {
+	_u.mutation.SetCold(v)
+	return _u
+}
+
+// SetNillableCold sets the "cold" field if the given value is not nil.
+func (_u *ChunkUpdateOne) SetNillableCold(v *bool) *ChunkUpdateOne {
+	if v != nil {
+		_u.SetCold(*v)
+	}
+	return _u
+}
+
 // AddNarFileLinkIDs adds the "nar_file_links" edge to the NarFileChunk entity by IDs.
 func (_u *ChunkUpdateOne) AddNarFileLinkIDs(ids ...int) *ChunkUpdateOne {
 	_u.mutation.AddNarFileLinkIDs(ids...)
@@ -498,6 +671,27 @@ func (_u *ChunkUpdateOne) sqlSave(ctx context.Context) (_node *Chunk, err error)
 	if value, ok := _u.mutation.AddedCompressedSize(); ok {
 		_spec.AddField(chunk.FieldCompressedSize, field.TypeUint32, value)
 	}
+	if value, ok := _u.mutation.RefCount(); ok {
+		_spec.SetField(chunk.FieldRefCount, field.TypeInt64, value)
+	}
+	if value, ok := _u.mutation.AddedRefCount(); ok {
+		_spec.AddField(chunk.FieldRefCount, field.TypeInt64, value)
+	}
+	if value, ok := _u.mutation.AccessCount(); ok {
+		_spec.SetField(chunk.FieldAccessCount, field.TypeInt64, value)
+	}
+	if value, ok := _u.mutation.AddedAccessCount(); ok {
+		_spec.AddField(chunk.FieldAccessCount, field.TypeInt64, value)
+	}
+	if value, ok := _u.mutation.LastAccessedAt(); ok {
+		_spec.SetField(chunk.FieldLastAccessedAt, field.TypeTime, value)
+	}
+	if _u.mutation.LastAccessedAtCleared() {
+		_spec.ClearField(chunk.FieldLastAccessedAt, field.TypeTime)
+	}
+	if value, ok := _u.mutation.Cold(); ok {
+		_spec.SetField(chunk.FieldCold, field.TypeBool, value)
+	}
 	if _u.mutation.NarFileLinksCleared() {
 		edge := &sqlgraph.EdgeSpec{
 			Rel:     sqlgraph.O2M,
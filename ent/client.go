@@ -19,6 +19,7 @@ import (
 	"github.com/kalbasit/ncps/ent/buildtracesignature"
 	"github.com/kalbasit/ncps/ent/chunk"
 	"github.com/kalbasit/ncps/ent/configentry"
+	"github.com/kalbasit/ncps/ent/inlinenar"
 	"github.com/kalbasit/ncps/ent/narfile"
 	"github.com/kalbasit/ncps/ent/narfilechunk"
 	"github.com/kalbasit/ncps/ent/narinfo"
@@ -42,6 +43,8 @@ type Client struct {
 	Chunk *ChunkClient
 	// ConfigEntry is the client for interacting with the ConfigEntry builders.
 	ConfigEntry *ConfigEntryClient
+	// InlineNar is the client for interacting with the InlineNar builders.
+	InlineNar *InlineNarClient
 	// NarFile is the client for interacting with the NarFile builders.
 	NarFile *NarFileClient
 	// NarFileChunk is the client for interacting with the NarFileChunk builders.
@@ -73,6 +76,7 @@ func (c *Client) init() {
 	c.BuildTraceSignature = NewBuildTraceSignatureClient(c.config)
 	c.Chunk = NewChunkClient(c.config)
 	c.ConfigEntry = NewConfigEntryClient(c.config)
+	c.InlineNar = NewInlineNarClient(c.config)
 	c.NarFile = NewNarFileClient(c.config)
 	c.NarFileChunk = NewNarFileChunkClient(c.config)
 	c.NarInfo = NewNarInfoClient(c.config)
@@ -177,6 +181,7 @@ func (c *Client) Tx(ctx context.Context) (*Tx, error) {
 		BuildTraceSignature: NewBuildTraceSignatureClient(cfg),
 		Chunk:               NewChunkClient(cfg),
 		ConfigEntry:         NewConfigEntryClient(cfg),
+		InlineNar:           NewInlineNarClient(cfg),
 		NarFile:             NewNarFileClient(cfg),
 		NarFileChunk:        NewNarFileChunkClient(cfg),
 		NarInfo:             NewNarInfoClient(cfg),
@@ -208,6 +213,7 @@ func (c *Client) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error)
 		BuildTraceSignature: NewBuildTraceSignatureClient(cfg),
 		Chunk:               NewChunkClient(cfg),
 		ConfigEntry:         NewConfigEntryClient(cfg),
+		InlineNar:           NewInlineNarClient(cfg),
 		NarFile:             NewNarFileClient(cfg),
 		NarFileChunk:        NewNarFileChunkClient(cfg),
 		NarInfo:             NewNarInfoClient(cfg),
@@ -245,8 +251,8 @@ func (c *Client) Close() error {
 // In order to add hooks to a specific client, call: `client.Node.Use(...)`.
 func (c *Client) Use(hooks ...Hook) {
 	for _, n := range []interface{ Use(...Hook) }{
-		c.BuildTraceEntry, c.BuildTraceSignature, c.Chunk, c.ConfigEntry, c.NarFile,
-		c.NarFileChunk, c.NarInfo, c.NarInfoNarFile, c.NarInfoReference,
+		c.BuildTraceEntry, c.BuildTraceSignature, c.Chunk, c.ConfigEntry, c.InlineNar,
+		c.NarFile, c.NarFileChunk, c.NarInfo, c.NarInfoNarFile, c.NarInfoReference,
 		c.NarInfoSignature, c.PinnedClosure, c.StagingState,
 	} {
 		n.Use(hooks...)
@@ -257,8 +263,8 @@ func (c *Client) Use(hooks ...Hook) {
 // In order to add interceptors to a specific client, call: `client.Node.Intercept(...)`.
 func (c *Client) Intercept(interceptors ...Interceptor) {
 	for _, n := range []interface{ Intercept(...Interceptor) }{
-		c.BuildTraceEntry, c.BuildTraceSignature, c.Chunk, c.ConfigEntry, c.NarFile,
-		c.NarFileChunk, c.NarInfo, c.NarInfoNarFile, c.NarInfoReference,
+		c.BuildTraceEntry, c.BuildTraceSignature, c.Chunk, c.ConfigEntry, c.InlineNar,
+		c.NarFile, c.NarFileChunk, c.NarInfo, c.NarInfoNarFile, c.NarInfoReference,
 		c.NarInfoSignature, c.PinnedClosure, c.StagingState,
 	} {
 		n.Intercept(interceptors...)
@@ -276,6 +282,8 @@ func (c *Client) Mutate(ctx context.Context, m Mutation) (Value, error) {
 		return c.Chunk.mutate(ctx, m)
 	case *ConfigEntryMutation:
 		return c.ConfigEntry.mutate(ctx, m)
+	case *InlineNarMutation:
+		return c.InlineNar.mutate(ctx, m)
 	case *NarFileMutation:
 		return c.NarFile.mutate(ctx, m)
 	case *NarFileChunkMutation:
@@ -877,6 +885,139 @@ func (c *ConfigEntryClient) mutate(ctx context.Context, m *ConfigEntryMutation)
 	}
 }
 
+// InlineNarClient is a client for the InlineNar schema.
+type InlineNarClient struct {
+	config
+}
+
+// NewInlineNarClient returns a client for the InlineNar from the given config.
+func NewInlineNarClient(c config) *InlineNarClient {
+	return &InlineNarClient{config: c}
+}
+
+// Use adds a list of mutation hooks to the hooks stack.
+// A call to `Use(f, g, h)` equals to `inlinenar.Hooks(f(g(h())))`.
+func (c *InlineNarClient) Use(hooks ...Hook) {
+	c.hooks.InlineNar = append(c.hooks.InlineNar, hooks...)
+}
+
+// Intercept adds a list of query interceptors to the interceptors stack.
+// A call to `Intercept(f, g, h)` equals to `inlinenar.Intercept(f(g(h())))`.
+func (c *InlineNarClient) Intercept(interceptors ...Interceptor) {
+	c.inters.InlineNar = append(c.inters.InlineNar, interceptors...)
+}
+
+// Create returns a builder for creating a InlineNar entity.
+func (c *InlineNarClient) Create() *InlineNarCreate {
+	mutation := newInlineNarMutation(c.config, OpCreate)
+	return &InlineNarCreate{config: c.config, hooks: c.Hooks(), mutation: mutation}
+}
+
+// CreateBulk returns a builder for creating a bulk of InlineNar entities.
+func (c *InlineNarClient) CreateBulk(builders ...*InlineNarCreate) *InlineNarCreateBulk {
+	return &InlineNarCreateBulk{config: c.config, builders: builders}
+}
+
+// MapCreateBulk creates a bulk creation builder from the given slice. For each item in the slice, the function creates
+// a builder and applies setFunc on it.
+func (c *InlineNarClient) MapCreateBulk(slice any, setFunc func(*InlineNarCreate, int)) *InlineNarCreateBulk {
+	rv := reflect.ValueOf(slice)
+	if rv.Kind() != reflect.Slice {
+		return &InlineNarCreateBulk{err: fmt.Errorf("calling to InlineNarClient.MapCreateBulk with wrong type %T, need slice", slice)}
+	}
+	builders := make([]*InlineNarCreate, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		builders[i] = c.Create()
+		setFunc(builders[i], i)
+	}
+	return &InlineNarCreateBulk{config: c.config, builders: builders}
+}
+
+// Update returns an update builder for InlineNar.
+func (c *InlineNarClient) Update() *InlineNarUpdate {
+	mutation := newInlineNarMutation(c.config, OpUpdate)
+	return &InlineNarUpdate{config: c.config, hooks: c.Hooks(), mutation: mutation}
+}
+
+// UpdateOne returns an update builder for the given entity.
+func (c *InlineNarClient) UpdateOne(_m *InlineNar) *InlineNarUpdateOne {
+	mutation := newInlineNarMutation(c.config, OpUpdateOne, withInlineNar(_m))
+	return &InlineNarUpdateOne{config: c.config, hooks: c.Hooks(), mutation: mutation}
+}
+
+// UpdateOneID returns an update builder for the given id.
+func (c *InlineNarClient) UpdateOneID(id int) *InlineNarUpdateOne {
+	mutation := newInlineNarMutation(c.config, OpUpdateOne, withInlineNarID(id))
+	return &InlineNarUpdateOne{config: c.config, hooks: c.Hooks(), mutation: mutation}
+}
+
+// Delete returns a delete builder for InlineNar.
+func (c *InlineNarClient) Delete() *InlineNarDelete {
+	mutation := newInlineNarMutation(c.config, OpDelete)
+	return &InlineNarDelete{config: c.config, hooks: c.Hooks(), mutation: mutation}
+}
+
+// DeleteOne returns a builder for deleting the given entity.
+func (c *InlineNarClient) DeleteOne(_m *InlineNar) *InlineNarDeleteOne {
+	return c.DeleteOneID(_m.ID)
+}
+
+// DeleteOneID returns a builder for deleting the given entity by its id.
+func (c *InlineNarClient) DeleteOneID(id int) *InlineNarDeleteOne {
+	builder := c.Delete().Where(inlinenar.ID(id))
+	builder.mutation.id = &id
+	builder.mutation.op = OpDeleteOne
+	return &InlineNarDeleteOne{builder}
+}
+
+// Query returns a query builder for InlineNar.
+func (c *InlineNarClient) Query() *InlineNarQuery {
+	return &InlineNarQuery{
+		config: c.config,
+		ctx:    &QueryContext{Type: TypeInlineNar},
+		inters: c.Interceptors(),
+	}
+}
+
+// Get returns a InlineNar entity by its id.
+func (c *InlineNarClient) Get(ctx context.Context, id int) (*InlineNar, error) {
+	return c.Query().Where(inlinenar.ID(id)).Only(ctx)
+}
+
+// GetX is like Get, but panics if an error occurs.
+func (c *InlineNarClient) GetX(ctx context.Context, id int) *InlineNar {
+	obj, err := c.Get(ctx, id)
+	if err != nil {
+		panic(err)
+	}
+	return obj
+}
+
+// Hooks returns the client hooks.
+func (c *InlineNarClient) Hooks() []Hook {
+	return c.hooks.InlineNar
+}
+
+// Interceptors returns the client interceptors.
+func (c *InlineNarClient) Interceptors() []Interceptor {
+	return c.inters.InlineNar
+}
+
+func (c *InlineNarClient) mutate(ctx context.Context, m *InlineNarMutation) (Value, error) {
+	switch m.Op() {
+	case OpCreate:
+		return (&InlineNarCreate{config: c.config, hooks: c.Hooks(), mutation: m}).Save(ctx)
+	case OpUpdate:
+		return (&InlineNarUpdate{config: c.config, hooks: c.Hooks(), mutation: m}).Save(ctx)
+	case OpUpdateOne:
+		return (&InlineNarUpdateOne{config: c.config, hooks: c.Hooks(), mutation: m}).Save(ctx)
+	case OpDelete, OpDeleteOne:
+		return (&InlineNarDelete{config: c.config, hooks: c.Hooks(), mutation: m}).Exec(ctx)
+	default:
+		return nil, fmt.Errorf("ent: unknown InlineNar mutation op: %q", m.Op())
+	}
+}
+
 // NarFileClient is a client for the NarFile schema.
 type NarFileClient struct {
 	config
@@ -2120,13 +2261,13 @@ func (c *StagingStateClient) mutate(ctx context.Context, m *StagingStateMutation
 // hooks and interceptors per client, for fast access.
 type (
 	hooks struct {
-		BuildTraceEntry, BuildTraceSignature, Chunk, ConfigEntry, NarFile, NarFileChunk,
-		NarInfo, NarInfoNarFile, NarInfoReference, NarInfoSignature, PinnedClosure,
-		StagingState []ent.Hook
+		BuildTraceEntry, BuildTraceSignature, Chunk, ConfigEntry, InlineNar, NarFile,
+		NarFileChunk, NarInfo, NarInfoNarFile, NarInfoReference, NarInfoSignature,
+		PinnedClosure, StagingState []ent.Hook
 	}
 	inters struct {
-		BuildTraceEntry, BuildTraceSignature, Chunk, ConfigEntry, NarFile, NarFileChunk,
-		NarInfo, NarInfoNarFile, NarInfoReference, NarInfoSignature, PinnedClosure,
-		StagingState []ent.Interceptor
+		BuildTraceEntry, BuildTraceSignature, Chunk, ConfigEntry, InlineNar, NarFile,
+		NarFileChunk, NarInfo, NarInfoNarFile, NarInfoReference, NarInfoSignature,
+		PinnedClosure, StagingState []ent.Interceptor
 	}
 )
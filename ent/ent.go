@@ -16,6 +16,7 @@ import (
 	"github.com/kalbasit/ncps/ent/buildtracesignature"
 	"github.com/kalbasit/ncps/ent/chunk"
 	"github.com/kalbasit/ncps/ent/configentry"
+	"github.com/kalbasit/ncps/ent/inlinenar"
 	"github.com/kalbasit/ncps/ent/narfile"
 	"github.com/kalbasit/ncps/ent/narfilechunk"
 	"github.com/kalbasit/ncps/ent/narinfo"
@@ -88,6 +89,7 @@ func checkColumn(t, c string) error {
 			buildtracesignature.Table: buildtracesignature.ValidColumn,
 			chunk.Table:               chunk.ValidColumn,
 			configentry.Table:         configentry.ValidColumn,
+			inlinenar.Table:           inlinenar.ValidColumn,
 			narfile.Table:             narfile.ValidColumn,
 			narfilechunk.Table:        narfilechunk.ValidColumn,
 			narinfo.Table:             narinfo.ValidColumn,
@@ -57,6 +57,18 @@ func (f ConfigEntryFunc) Mutate(ctx context.Context, m ent.Mutation) (ent.Value,
 	return nil, fmt.Errorf("unexpected mutation type %T. expect *ent.ConfigEntryMutation", m)
 }
 
+// The InlineNarFunc type is an adapter to allow the use of ordinary
+// function as InlineNar mutator.
+type InlineNarFunc func(context.Context, *ent.InlineNarMutation) (ent.Value, error)
+
+// Mutate calls f(ctx, m).
+func (f InlineNarFunc) Mutate(ctx context.Context, m ent.Mutation) (ent.Value, error) {
+	if mv, ok := m.(*ent.InlineNarMutation); ok {
+		return f(ctx, mv)
+	}
+	return nil, fmt.Errorf("unexpected mutation type %T. expect *ent.InlineNarMutation", m)
+}
+
 // The NarFileFunc type is an adapter to allow the use of ordinary
 // function as NarFile mutator.
 type NarFileFunc func(context.Context, *ent.NarFileMutation) (ent.Value, error)
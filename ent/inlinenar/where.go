@@ -0,0 +1,355 @@
+// Code generated by ent, DO NOT EDIT.
+
+package inlinenar
+
+import (
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+	"github.com/kalbasit/ncps/ent/predicate"
+)
+
+// ID filters vertices based on their ID field.
+func ID(id int) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldEQ(FieldID, id))
+}
+
+// IDEQ applies the EQ predicate on the ID field.
+func IDEQ(id int) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldEQ(FieldID, id))
+}
+
+// IDNEQ applies the NEQ predicate on the ID field.
+func IDNEQ(id int) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldNEQ(FieldID, id))
+}
+
+// IDIn applies the In predicate on the ID field.
+func IDIn(ids ...int) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldIn(FieldID, ids...))
+}
+
+// IDNotIn applies the NotIn predicate on the ID field.
+func IDNotIn(ids ...int) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldNotIn(FieldID, ids...))
+}
+
+// IDGT applies the GT predicate on the ID field.
+func IDGT(id int) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldGT(FieldID, id))
+}
+
+// IDGTE applies the GTE predicate on the ID field.
+func IDGTE(id int) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldGTE(FieldID, id))
+}
+
+// IDLT applies the LT predicate on the ID field.
+func IDLT(id int) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldLT(FieldID, id))
+}
+
+// IDLTE applies the LTE predicate on the ID field.
+func IDLTE(id int) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldLTE(FieldID, id))
+}
+
+// CreatedAt applies equality check predicate on the "created_at" field. It's identical to CreatedAtEQ.
+func CreatedAt(v time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldEQ(FieldCreatedAt, v))
+}
+
+// UpdatedAt applies equality check predicate on the "updated_at" field. It's identical to UpdatedAtEQ.
+func UpdatedAt(v time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldEQ(FieldUpdatedAt, v))
+}
+
+// Hash applies equality check predicate on the "hash" field. It's identical to HashEQ.
+func Hash(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldEQ(FieldHash, v))
+}
+
+// Compression applies equality check predicate on the "compression" field. It's identical to CompressionEQ.
+func Compression(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldEQ(FieldCompression, v))
+}
+
+// Data applies equality check predicate on the "data" field. It's identical to DataEQ.
+func Data(v []byte) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldEQ(FieldData, v))
+}
+
+// CreatedAtEQ applies the EQ predicate on the "created_at" field.
+func CreatedAtEQ(v time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldEQ(FieldCreatedAt, v))
+}
+
+// CreatedAtNEQ applies the NEQ predicate on the "created_at" field.
+func CreatedAtNEQ(v time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldNEQ(FieldCreatedAt, v))
+}
+
+// CreatedAtIn applies the In predicate on the "created_at" field.
+func CreatedAtIn(vs ...time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldIn(FieldCreatedAt, vs...))
+}
+
+// CreatedAtNotIn applies the NotIn predicate on the "created_at" field.
+func CreatedAtNotIn(vs ...time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldNotIn(FieldCreatedAt, vs...))
+}
+
+// CreatedAtGT applies the GT predicate on the "created_at" field.
+func CreatedAtGT(v time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldGT(FieldCreatedAt, v))
+}
+
+// CreatedAtGTE applies the GTE predicate on the "created_at" field.
+func CreatedAtGTE(v time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldGTE(FieldCreatedAt, v))
+}
+
+// CreatedAtLT applies the LT predicate on the "created_at" field.
+func CreatedAtLT(v time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldLT(FieldCreatedAt, v))
+}
+
+// CreatedAtLTE applies the LTE predicate on the "created_at" field.
+func CreatedAtLTE(v time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldLTE(FieldCreatedAt, v))
+}
+
+// UpdatedAtEQ applies the EQ predicate on the "updated_at" field.
+func UpdatedAtEQ(v time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldEQ(FieldUpdatedAt, v))
+}
+
+// UpdatedAtNEQ applies the NEQ predicate on the "updated_at" field.
+func UpdatedAtNEQ(v time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldNEQ(FieldUpdatedAt, v))
+}
+
+// UpdatedAtIn applies the In predicate on the "updated_at" field.
+func UpdatedAtIn(vs ...time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldIn(FieldUpdatedAt, vs...))
+}
+
+// UpdatedAtNotIn applies the NotIn predicate on the "updated_at" field.
+func UpdatedAtNotIn(vs ...time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldNotIn(FieldUpdatedAt, vs...))
+}
+
+// UpdatedAtGT applies the GT predicate on the "updated_at" field.
+func UpdatedAtGT(v time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldGT(FieldUpdatedAt, v))
+}
+
+// UpdatedAtGTE applies the GTE predicate on the "updated_at" field.
+func UpdatedAtGTE(v time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldGTE(FieldUpdatedAt, v))
+}
+
+// UpdatedAtLT applies the LT predicate on the "updated_at" field.
+func UpdatedAtLT(v time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldLT(FieldUpdatedAt, v))
+}
+
+// UpdatedAtLTE applies the LTE predicate on the "updated_at" field.
+func UpdatedAtLTE(v time.Time) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldLTE(FieldUpdatedAt, v))
+}
+
+// UpdatedAtIsNil applies the IsNil predicate on the "updated_at" field.
+func UpdatedAtIsNil() predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldIsNull(FieldUpdatedAt))
+}
+
+// UpdatedAtNotNil applies the NotNil predicate on the "updated_at" field.
+func UpdatedAtNotNil() predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldNotNull(FieldUpdatedAt))
+}
+
+// HashEQ applies the EQ predicate on the "hash" field.
+func HashEQ(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldEQ(FieldHash, v))
+}
+
+// HashNEQ applies the NEQ predicate on the "hash" field.
+func HashNEQ(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldNEQ(FieldHash, v))
+}
+
+// HashIn applies the In predicate on the "hash" field.
+func HashIn(vs ...string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldIn(FieldHash, vs...))
+}
+
+// HashNotIn applies the NotIn predicate on the "hash" field.
+func HashNotIn(vs ...string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldNotIn(FieldHash, vs...))
+}
+
+// HashGT applies the GT predicate on the "hash" field.
+func HashGT(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldGT(FieldHash, v))
+}
+
+// HashGTE applies the GTE predicate on the "hash" field.
+func HashGTE(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldGTE(FieldHash, v))
+}
+
+// HashLT applies the LT predicate on the "hash" field.
+func HashLT(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldLT(FieldHash, v))
+}
+
+// HashLTE applies the LTE predicate on the "hash" field.
+func HashLTE(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldLTE(FieldHash, v))
+}
+
+// HashContains applies the Contains predicate on the "hash" field.
+func HashContains(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldContains(FieldHash, v))
+}
+
+// HashHasPrefix applies the HasPrefix predicate on the "hash" field.
+func HashHasPrefix(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldHasPrefix(FieldHash, v))
+}
+
+// HashHasSuffix applies the HasSuffix predicate on the "hash" field.
+func HashHasSuffix(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldHasSuffix(FieldHash, v))
+}
+
+// HashEqualFold applies the EqualFold predicate on the "hash" field.
+func HashEqualFold(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldEqualFold(FieldHash, v))
+}
+
+// HashContainsFold applies the ContainsFold predicate on the "hash" field.
+func HashContainsFold(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldContainsFold(FieldHash, v))
+}
+
+// CompressionEQ applies the EQ predicate on the "compression" field.
+func CompressionEQ(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldEQ(FieldCompression, v))
+}
+
+// CompressionNEQ applies the NEQ predicate on the "compression" field.
+func CompressionNEQ(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldNEQ(FieldCompression, v))
+}
+
+// CompressionIn applies the In predicate on the "compression" field.
+func CompressionIn(vs ...string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldIn(FieldCompression, vs...))
+}
+
+// CompressionNotIn applies the NotIn predicate on the "compression" field.
+func CompressionNotIn(vs ...string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldNotIn(FieldCompression, vs...))
+}
+
+// CompressionGT applies the GT predicate on the "compression" field.
+func CompressionGT(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldGT(FieldCompression, v))
+}
+
+// CompressionGTE applies the GTE predicate on the "compression" field.
+func CompressionGTE(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldGTE(FieldCompression, v))
+}
+
+// CompressionLT applies the LT predicate on the "compression" field.
+func CompressionLT(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldLT(FieldCompression, v))
+}
+
+// CompressionLTE applies the LTE predicate on the "compression" field.
+func CompressionLTE(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldLTE(FieldCompression, v))
+}
+
+// CompressionContains applies the Contains predicate on the "compression" field.
+func CompressionContains(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldContains(FieldCompression, v))
+}
+
+// CompressionHasPrefix applies the HasPrefix predicate on the "compression" field.
+func CompressionHasPrefix(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldHasPrefix(FieldCompression, v))
+}
+
+// CompressionHasSuffix applies the HasSuffix predicate on the "compression" field.
+func CompressionHasSuffix(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldHasSuffix(FieldCompression, v))
+}
+
+// CompressionEqualFold applies the EqualFold predicate on the "compression" field.
+func CompressionEqualFold(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldEqualFold(FieldCompression, v))
+}
+
+// CompressionContainsFold applies the ContainsFold predicate on the "compression" field.
+func CompressionContainsFold(v string) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldContainsFold(FieldCompression, v))
+}
+
+// DataEQ applies the EQ predicate on the "data" field.
+func DataEQ(v []byte) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldEQ(FieldData, v))
+}
+
+// DataNEQ applies the NEQ predicate on the "data" field.
+func DataNEQ(v []byte) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldNEQ(FieldData, v))
+}
+
+// DataIn applies the In predicate on the "data" field.
+func DataIn(vs ...[]byte) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldIn(FieldData, vs...))
+}
+
+// DataNotIn applies the NotIn predicate on the "data" field.
+func DataNotIn(vs ...[]byte) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldNotIn(FieldData, vs...))
+}
+
+// DataGT applies the GT predicate on the "data" field.
+func DataGT(v []byte) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldGT(FieldData, v))
+}
+
+// DataGTE applies the GTE predicate on the "data" field.
+func DataGTE(v []byte) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldGTE(FieldData, v))
+}
+
+// DataLT applies the LT predicate on the "data" field.
+func DataLT(v []byte) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldLT(FieldData, v))
+}
+
+// DataLTE applies the LTE predicate on the "data" field.
+func DataLTE(v []byte) predicate.InlineNar {
+	return predicate.InlineNar(sql.FieldLTE(FieldData, v))
+}
+
+// And groups predicates with the AND operator between them.
+func And(predicates ...predicate.InlineNar) predicate.InlineNar {
+	return predicate.InlineNar(sql.AndPredicates(predicates...))
+}
+
+// Or groups predicates with the OR operator between them.
+func Or(predicates ...predicate.InlineNar) predicate.InlineNar {
+	return predicate.InlineNar(sql.OrPredicates(predicates...))
+}
+
+// Not applies the not operator on the given predicate.
+func Not(p predicate.InlineNar) predicate.InlineNar {
+	return predicate.InlineNar(sql.NotPredicates(p))
+}
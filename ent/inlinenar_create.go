@@ -0,0 +1,699 @@
+// Code generated by ent, DO NOT EDIT.
+
+package ent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/sqlgraph"
+	"entgo.io/ent/schema/field"
+	"github.com/kalbasit/ncps/ent/inlinenar"
+)
+
+// InlineNarCreate is the builder for creating a InlineNar entity.
+type InlineNarCreate struct {
+	config
+	mutation *InlineNarMutation
+	hooks    []Hook
+	conflict []sql.ConflictOption
+}
+
+// SetCreatedAt sets the "created_at" field.
+func (_c *InlineNarCreate) SetCreatedAt(v time.Time) *InlineNarCreate {
+	_c.mutation.SetCreatedAt(v)
+	return _c
+}
+
+// SetNillableCreatedAt sets the "created_at" field if the given value is not nil.
+func (_c *InlineNarCreate) SetNillableCreatedAt(v *time.Time) *InlineNarCreate {
+	if v != nil {
+		_c.SetCreatedAt(*v)
+	}
+	return _c
+}
+
+// SetUpdatedAt sets the "updated_at" field.
+func (_c *InlineNarCreate) SetUpdatedAt(v time.Time) *InlineNarCreate {
+	_c.mutation.SetUpdatedAt(v)
+	return _c
+}
+
+// SetNillableUpdatedAt sets the "updated_at" field if the given value is not nil.
+func (_c *InlineNarCreate) SetNillableUpdatedAt(v *time.Time) *InlineNarCreate {
+	if v != nil {
+		_c.SetUpdatedAt(*v)
+	}
+	return _c
+}
+
+// SetHash sets the "hash" field.
+func (_c *InlineNarCreate) SetHash(v string) *InlineNarCreate {
+	_c.mutation.SetHash(v)
+	return _c
+}
+
+// SetCompression sets the "compression" field.
+func (_c *InlineNarCreate) SetCompression(v string) *InlineNarCreate {
+	_c.mutation.SetCompression(v)
+	return _c
+}
+
+// SetNillableCompression sets the "compression" field if the given value is not nil.
+func (_c *InlineNarCreate) SetNillableCompression(v *string) *InlineNarCreate {
+	if v != nil {
+		_c.SetCompression(*v)
+	}
+	return _c
+}
+
+// SetData sets the "data" field.
+func (_c *InlineNarCreate) SetData(v []byte) *InlineNarCreate {
+	_c.mutation.SetData(v)
+	return _c
+}
+
+// Mutation returns the InlineNarMutation object of the builder.
+func (_c *InlineNarCreate) Mutation() *InlineNarMutation {
+	return _c.mutation
+}
+
+// Save creates the InlineNar in the database.
+func (_c *InlineNarCreate) Save(ctx context.Context) (*InlineNar, error) {
+	_c.defaults()
+	return withHooks(ctx, _c.sqlSave, _c.mutation, _c.hooks)
+}
+
+// SaveX calls Save and panics if Save returns an error.
+func (_c *InlineNarCreate) SaveX(ctx context.Context) *InlineNar {
+	v, err := _c.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Exec executes the query.
+func (_c *InlineNarCreate) Exec(ctx context.Context) error {
+	_, err := _c.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (_c *InlineNarCreate) ExecX(ctx context.Context) {
+	if err := _c.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// defaults sets the default values of the builder before save.
+func (_c *InlineNarCreate) defaults() {
+	if _, ok := _c.mutation.CreatedAt(); !ok {
+		v := inlinenar.DefaultCreatedAt()
+		_c.mutation.SetCreatedAt(v)
+	}
+	if _, ok := _c.mutation.Compression(); !ok {
+		v := inlinenar.DefaultCompression
+		_c.mutation.SetCompression(v)
+	}
+}
+
+// check runs all checks and user-defined validators on the builder.
+func (_c *InlineNarCreate) check() error {
+	if _, ok := _c.mutation.CreatedAt(); !ok {
+		return &ValidationError{Name: "created_at", err: errors.New(`ent: missing required field "InlineNar.created_at"`)}
+	}
+	if _, ok := _c.mutation.Hash(); !ok {
+		return &ValidationError{Name: "hash", err: errors.New(`ent: missing required field "InlineNar.hash"`)}
+	}
+	if v, ok := _c.mutation.Hash(); ok {
+		if err := inlinenar.HashValidator(v); err != nil {
+			return &ValidationError{Name: "hash", err: fmt.Errorf(`ent: validator failed for field "InlineNar.hash": %w`, err)}
+		}
+	}
+	if _, ok := _c.mutation.Compression(); !ok {
+		return &ValidationError{Name: "compression", err: errors.New(`ent: missing required field "InlineNar.compression"`)}
+	}
+	if _, ok := _c.mutation.Data(); !ok {
+		return &ValidationError{Name: "data", err: errors.New(`ent: missing required field "InlineNar.data"`)}
+	}
+	return nil
+}
+
+func (_c *InlineNarCreate) sqlSave(ctx context.Context) (*InlineNar, error) {
+	if err := _c.check(); err != nil {
+		return nil, err
+	}
+	_node, _spec := _c.createSpec()
+	if err := sqlgraph.CreateNode(ctx, _c.driver, _spec); err != nil {
+		if sqlgraph.IsConstraintError(err) {
+			err = &ConstraintError{msg: err.Error(), wrap: err}
+		}
+		return nil, err
+	}
+	id := _spec.ID.Value.(int64)
+	_node.ID = int(id)
+	_c.mutation.id = &_node.ID
+	_c.mutation.done = true
+	return _node, nil
+}
+
+func (_c *InlineNarCreate) createSpec() (*InlineNar, *sqlgraph.CreateSpec) {
+	var (
+		_node = &InlineNar{config: _c.config}
+		_spec = sqlgraph.NewCreateSpec(inlinenar.Table, sqlgraph.NewFieldSpec(inlinenar.FieldID, field.TypeInt))
+	)
+	_spec.OnConflict = _c.conflict
+	if value, ok := _c.mutation.CreatedAt(); ok {
+		_spec.SetField(inlinenar.FieldCreatedAt, field.TypeTime, value)
+		_node.CreatedAt = value
+	}
+	if value, ok := _c.mutation.UpdatedAt(); ok {
+		_spec.SetField(inlinenar.FieldUpdatedAt, field.TypeTime, value)
+		_node.UpdatedAt = &value
+	}
+	if value, ok := _c.mutation.Hash(); ok {
+		_spec.SetField(inlinenar.FieldHash, field.TypeString, value)
+		_node.Hash = value
+	}
+	if value, ok := _c.mutation.Compression(); ok {
+		_spec.SetField(inlinenar.FieldCompression, field.TypeString, value)
+		_node.Compression = value
+	}
+	if value, ok := _c.mutation.Data(); ok {
+		_spec.SetField(inlinenar.FieldData, field.TypeBytes, value)
+		_node.Data = value
+	}
+	return _node, _spec
+}
+
+// OnConflict allows configuring the `ON CONFLICT` / `ON DUPLICATE KEY` clause
+// of the `INSERT` statement. For example:
+//
+//	client.InlineNar.Create().
+//		SetCreatedAt(v).
+//		OnConflict(
+//			// Update the row with the new values
+//			// the was proposed for insertion.
+//			sql.ResolveWithNewValues(),
+//		).
+//		// Override some of the fields with custom
+//		// update values.
+//		Update(func(u *ent.InlineNarUpsert) {
+//			SetCreatedAt(v+v).
+//		}).
+//		Exec(ctx)
+func (_c *InlineNarCreate) OnConflict(opts ...sql.ConflictOption) *InlineNarUpsertOne {
+	_c.conflict = opts
+	return &InlineNarUpsertOne{
+		create: _c,
+	}
+}
+
+// OnConflictColumns calls `OnConflict` and configures the columns
+// as conflict target. Using this option is equivalent to using:
+//
+//	client.InlineNar.Create().
+//		OnConflict(sql.ConflictColumns(columns...)).
+//		Exec(ctx)
+func (_c *InlineNarCreate) OnConflictColumns(columns ...string) *InlineNarUpsertOne {
+	_c.conflict = append(_c.conflict, sql.ConflictColumns(columns...))
+	return &InlineNarUpsertOne{
+		create: _c,
+	}
+}
+
+type (
+	// InlineNarUpsertOne is the builder for "upsert"-ing
+	//  one InlineNar node.
+	InlineNarUpsertOne struct {
+		create *InlineNarCreate
+	}
+
+	// InlineNarUpsert is the "OnConflict" setter.
+	InlineNarUpsert struct {
+		*sql.UpdateSet
+	}
+)
+
+// SetUpdatedAt sets the "updated_at" field.
+func (u *InlineNarUpsert) SetUpdatedAt(v time.Time) *InlineNarUpsert {
+	u.Set(inlinenar.FieldUpdatedAt, v)
+	return u
+}
+
+// UpdateUpdatedAt sets the "updated_at" field to the value that was provided on create.
+func (u *InlineNarUpsert) UpdateUpdatedAt() *InlineNarUpsert {
+	u.SetExcluded(inlinenar.FieldUpdatedAt)
+	return u
+}
+
+// ClearUpdatedAt clears the value of the "updated_at" field.
+func (u *InlineNarUpsert) ClearUpdatedAt() *InlineNarUpsert {
+	u.SetNull(inlinenar.FieldUpdatedAt)
+	return u
+}
+
+// SetHash sets the "hash" field.
+func (u *InlineNarUpsert) SetHash(v string) *InlineNarUpsert {
+	u.Set(inlinenar.FieldHash, v)
+	return u
+}
+
+// UpdateHash sets the "hash" field to the value that was provided on create.
+func (u *InlineNarUpsert) UpdateHash() *InlineNarUpsert {
+	u.SetExcluded(inlinenar.FieldHash)
+	return u
+}
+
+// SetCompression sets the "compression" field.
+func (u *InlineNarUpsert) SetCompression(v string) *InlineNarUpsert {
+	u.Set(inlinenar.FieldCompression, v)
+	return u
+}
+
+// UpdateCompression sets the "compression" field to the value that was provided on create.
+func (u *InlineNarUpsert) UpdateCompression() *InlineNarUpsert {
+	u.SetExcluded(inlinenar.FieldCompression)
+	return u
+}
+
+// SetData sets the "data" field.
+func (u *InlineNarUpsert) SetData(v []byte) *InlineNarUpsert {
+	u.Set(inlinenar.FieldData, v)
+	return u
+}
+
+// UpdateData sets the "data" field to the value that was provided on create.
+func (u *InlineNarUpsert) UpdateData() *InlineNarUpsert {
+	u.SetExcluded(inlinenar.FieldData)
+	return u
+}
+
+// UpdateNewValues updates the mutable fields using the new values that were set on create.
+// Using this option is equivalent to using:
+//
+//	client.InlineNar.Create().
+//		OnConflict(
+//			sql.ResolveWithNewValues(),
+//		).
+//		Exec(ctx)
+func (u *InlineNarUpsertOne) UpdateNewValues() *InlineNarUpsertOne {
+	u.create.conflict = append(u.create.conflict, sql.ResolveWithNewValues())
+	u.create.conflict = append(u.create.conflict, sql.ResolveWith(func(s *sql.UpdateSet) {
+		if _, exists := u.create.mutation.CreatedAt(); exists {
+			s.SetIgnore(inlinenar.FieldCreatedAt)
+		}
+	}))
+	return u
+}
+
+// Ignore sets each column to itself in case of conflict.
+// Using this option is equivalent to using:
+//
+//	client.InlineNar.Create().
+//	    OnConflict(sql.ResolveWithIgnore()).
+//	    Exec(ctx)
+func (u *InlineNarUpsertOne) Ignore() *InlineNarUpsertOne {
+	u.create.conflict = append(u.create.conflict, sql.ResolveWithIgnore())
+	return u
+}
+
+// DoNothing configures the conflict_action to `DO NOTHING`.
+// Supported only by SQLite and PostgreSQL.
+func (u *InlineNarUpsertOne) DoNothing() *InlineNarUpsertOne {
+	u.create.conflict = append(u.create.conflict, sql.DoNothing())
+	return u
+}
+
+// Update allows overriding fields `UPDATE` values. See the InlineNarCreate.OnConflict
+// documentation for more info.
+func (u *InlineNarUpsertOne) Update(set func(*InlineNarUpsert)) *InlineNarUpsertOne {
+	u.create.conflict = append(u.create.conflict, sql.ResolveWith(func(update *sql.UpdateSet) {
+		set(&InlineNarUpsert{UpdateSet: update})
+	}))
+	return u
+}
+
+// SetUpdatedAt sets the "updated_at" field.
+func (u *InlineNarUpsertOne) SetUpdatedAt(v time.Time) *InlineNarUpsertOne {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.SetUpdatedAt(v)
+	})
+}
+
+// UpdateUpdatedAt sets the "updated_at" field to the value that was provided on create.
+func (u *InlineNarUpsertOne) UpdateUpdatedAt() *InlineNarUpsertOne {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.UpdateUpdatedAt()
+	})
+}
+
+// ClearUpdatedAt clears the value of the "updated_at" field.
+func (u *InlineNarUpsertOne) ClearUpdatedAt() *InlineNarUpsertOne {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.ClearUpdatedAt()
+	})
+}
+
+// SetHash sets the "hash" field.
+func (u *InlineNarUpsertOne) SetHash(v string) *InlineNarUpsertOne {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.SetHash(v)
+	})
+}
+
+// UpdateHash sets the "hash" field to the value that was provided on create.
+func (u *InlineNarUpsertOne) UpdateHash() *InlineNarUpsertOne {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.UpdateHash()
+	})
+}
+
+// SetCompression sets the "compression" field.
+func (u *InlineNarUpsertOne) SetCompression(v string) *InlineNarUpsertOne {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.SetCompression(v)
+	})
+}
+
+// UpdateCompression sets the "compression" field to the value that was provided on create.
+func (u *InlineNarUpsertOne) UpdateCompression() *InlineNarUpsertOne {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.UpdateCompression()
+	})
+}
+
+// SetData sets the "data" field.
+func (u *InlineNarUpsertOne) SetData(v []byte) *InlineNarUpsertOne {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.SetData(v)
+	})
+}
+
+// UpdateData sets the "data" field to the value that was provided on create.
+func (u *InlineNarUpsertOne) UpdateData() *InlineNarUpsertOne {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.UpdateData()
+	})
+}
+
+// Exec executes the query.
+func (u *InlineNarUpsertOne) Exec(ctx context.Context) error {
+	if len(u.create.conflict) == 0 {
+		return errors.New("ent: missing options for InlineNarCreate.OnConflict")
+	}
+	return u.create.Exec(ctx)
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (u *InlineNarUpsertOne) ExecX(ctx context.Context) {
+	if err := u.create.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// Exec executes the UPSERT query and returns the inserted/updated ID.
+func (u *InlineNarUpsertOne) ID(ctx context.Context) (id int, err error) {
+	node, err := u.create.Save(ctx)
+	if err != nil {
+		return id, err
+	}
+	return node.ID, nil
+}
+
+// IDX is like ID, but panics if an error occurs.
+func (u *InlineNarUpsertOne) IDX(ctx context.Context) int {
+	id, err := u.ID(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// InlineNarCreateBulk is the builder for creating many InlineNar entities in bulk.
+type InlineNarCreateBulk struct {
+	config
+	err      error
+	builders []*InlineNarCreate
+	conflict []sql.ConflictOption
+}
+
+// Save creates the InlineNar entities in the database.
+func (_c *InlineNarCreateBulk) Save(ctx context.Context) ([]*InlineNar, error) {
+	if _c.err != nil {
+		return nil, _c.err
+	}
+	specs := make([]*sqlgraph.CreateSpec, len(_c.builders))
+	nodes := make([]*InlineNar, len(_c.builders))
+	mutators := make([]Mutator, len(_c.builders))
+	for i := range _c.builders {
+		func(i int, root context.Context) {
+			builder := _c.builders[i]
+			builder.defaults()
+			var mut Mutator = MutateFunc(func(ctx context.Context, m Mutation) (Value, error) {
+				mutation, ok := m.(*InlineNarMutation)
+				if !ok {
+					return nil, fmt.Errorf("unexpected mutation type %T", m)
+				}
+				if err := builder.check(); err != nil {
+					return nil, err
+				}
+				builder.mutation = mutation
+				var err error
+				nodes[i], specs[i] = builder.createSpec()
+				if i < len(mutators)-1 {
+					_, err = mutators[i+1].Mutate(root, _c.builders[i+1].mutation)
+				} else {
+					spec := &sqlgraph.BatchCreateSpec{Nodes: specs}
+					spec.OnConflict = _c.conflict
+					// Invoke the actual operation on the latest mutation in the chain.
+					if err = sqlgraph.BatchCreate(ctx, _c.driver, spec); err != nil {
+						if sqlgraph.IsConstraintError(err) {
+							err = &ConstraintError{msg: err.Error(), wrap: err}
+						}
+					}
+				}
+				if err != nil {
+					return nil, err
+				}
+				mutation.id = &nodes[i].ID
+				if specs[i].ID.Value != nil {
+					id := specs[i].ID.Value.(int64)
+					nodes[i].ID = int(id)
+				}
+				mutation.done = true
+				return nodes[i], nil
+			})
+			for i := len(builder.hooks) - 1; i >= 0; i-- {
+				mut = builder.hooks[i](mut)
+			}
+			mutators[i] = mut
+		}(i, ctx)
+	}
+	if len(mutators) > 0 {
+		if _, err := mutators[0].Mutate(ctx, _c.builders[0].mutation); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// SaveX is like Save, but panics if an error occurs.
+func (_c *InlineNarCreateBulk) SaveX(ctx context.Context) []*InlineNar {
+	v, err := _c.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Exec executes the query.
+func (_c *InlineNarCreateBulk) Exec(ctx context.Context) error {
+	_, err := _c.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (_c *InlineNarCreateBulk) ExecX(ctx context.Context) {
+	if err := _c.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// OnConflict allows configuring the `ON CONFLICT` / `ON DUPLICATE KEY` clause
+// of the `INSERT` statement. For example:
+//
+//	client.InlineNar.CreateBulk(builders...).
+//		OnConflict(
+//			// Update the row with the new values
+//			// the was proposed for insertion.
+//			sql.ResolveWithNewValues(),
+//		).
+//		// Override some of the fields with custom
+//		// update values.
+//		Update(func(u *ent.InlineNarUpsert) {
+//			SetCreatedAt(v+v).
+//		}).
+//		Exec(ctx)
+func (_c *InlineNarCreateBulk) OnConflict(opts ...sql.ConflictOption) *InlineNarUpsertBulk {
+	_c.conflict = opts
+	return &InlineNarUpsertBulk{
+		create: _c,
+	}
+}
+
+// OnConflictColumns calls `OnConflict` and configures the columns
+// as conflict target. Using this option is equivalent to using:
+//
+//	client.InlineNar.Create().
+//		OnConflict(sql.ConflictColumns(columns...)).
+//		Exec(ctx)
+func (_c *InlineNarCreateBulk) OnConflictColumns(columns ...string) *InlineNarUpsertBulk {
+	_c.conflict = append(_c.conflict, sql.ConflictColumns(columns...))
+	return &InlineNarUpsertBulk{
+		create: _c,
+	}
+}
+
+// InlineNarUpsertBulk is the builder for "upsert"-ing
+// a bulk of InlineNar nodes.
+type InlineNarUpsertBulk struct {
+	create *InlineNarCreateBulk
+}
+
+// UpdateNewValues updates the mutable fields using the new values that
+// were set on create. Using this option is equivalent to using:
+//
+//	client.InlineNar.Create().
+//		OnConflict(
+//			sql.ResolveWithNewValues(),
+//		).
+//		Exec(ctx)
+func (u *InlineNarUpsertBulk) UpdateNewValues() *InlineNarUpsertBulk {
+	u.create.conflict = append(u.create.conflict, sql.ResolveWithNewValues())
+	u.create.conflict = append(u.create.conflict, sql.ResolveWith(func(s *sql.UpdateSet) {
+		for _, b := range u.create.builders {
+			if _, exists := b.mutation.CreatedAt(); exists {
+				s.SetIgnore(inlinenar.FieldCreatedAt)
+			}
+		}
+	}))
+	return u
+}
+
+// Ignore sets each column to itself in case of conflict.
+// Using this option is equivalent to using:
+//
+//	client.InlineNar.Create().
+//		OnConflict(sql.ResolveWithIgnore()).
+//		Exec(ctx)
+func (u *InlineNarUpsertBulk) Ignore() *InlineNarUpsertBulk {
+	u.create.conflict = append(u.create.conflict, sql.ResolveWithIgnore())
+	return u
+}
+
+// DoNothing configures the conflict_action to `DO NOTHING`.
+// Supported only by SQLite and PostgreSQL.
+func (u *InlineNarUpsertBulk) DoNothing() *InlineNarUpsertBulk {
+	u.create.conflict = append(u.create.conflict, sql.DoNothing())
+	return u
+}
+
+// Update allows overriding fields `UPDATE` values. See the InlineNarCreateBulk.OnConflict
+// documentation for more info.
+func (u *InlineNarUpsertBulk) Update(set func(*InlineNarUpsert)) *InlineNarUpsertBulk {
+	u.create.conflict = append(u.create.conflict, sql.ResolveWith(func(update *sql.UpdateSet) {
+		set(&InlineNarUpsert{UpdateSet: update})
+	}))
+	return u
+}
+
+// SetUpdatedAt sets the "updated_at" field.
+func (u *InlineNarUpsertBulk) SetUpdatedAt(v time.Time) *InlineNarUpsertBulk {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.SetUpdatedAt(v)
+	})
+}
+
+// UpdateUpdatedAt sets the "updated_at" field to the value that was provided on create.
+func (u *InlineNarUpsertBulk) UpdateUpdatedAt() *InlineNarUpsertBulk {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.UpdateUpdatedAt()
+	})
+}
+
+// ClearUpdatedAt clears the value of the "updated_at" field.
+func (u *InlineNarUpsertBulk) ClearUpdatedAt() *InlineNarUpsertBulk {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.ClearUpdatedAt()
+	})
+}
+
+// SetHash sets the "hash" field.
+func (u *InlineNarUpsertBulk) SetHash(v string) *InlineNarUpsertBulk {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.SetHash(v)
+	})
+}
+
+// UpdateHash sets the "hash" field to the value that was provided on create.
+func (u *InlineNarUpsertBulk) UpdateHash() *InlineNarUpsertBulk {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.UpdateHash()
+	})
+}
+
+// SetCompression sets the "compression" field.
+func (u *InlineNarUpsertBulk) SetCompression(v string) *InlineNarUpsertBulk {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.SetCompression(v)
+	})
+}
+
+// UpdateCompression sets the "compression" field to the value that was provided on create.
+func (u *InlineNarUpsertBulk) UpdateCompression() *InlineNarUpsertBulk {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.UpdateCompression()
+	})
+}
+
+// SetData sets the "data" field.
+func (u *InlineNarUpsertBulk) SetData(v []byte) *InlineNarUpsertBulk {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.SetData(v)
+	})
+}
+
+// UpdateData sets the "data" field to the value that was provided on create.
+func (u *InlineNarUpsertBulk) UpdateData() *InlineNarUpsertBulk {
+	return u.Update(func(s *InlineNarUpsert) {
+		s.UpdateData()
+	})
+}
+
+// Exec executes the query.
+func (u *InlineNarUpsertBulk) Exec(ctx context.Context) error {
+	if u.create.err != nil {
+		return u.create.err
+	}
+	for i, b := range u.create.builders {
+		if len(b.conflict) != 0 {
+			return fmt.Errorf("ent: OnConflict was set for builder %d. Set it on the InlineNarCreateBulk instead", i)
+		}
+	}
+	if len(u.create.conflict) == 0 {
+		return errors.New("ent: missing options for InlineNarCreateBulk.OnConflict")
+	}
+	return u.create.Exec(ctx)
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (u *InlineNarUpsertBulk) ExecX(ctx context.Context) {
+	if err := u.create.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,340 @@
+// Code generated by ent, DO NOT EDIT.
+
+package ent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/sqlgraph"
+	"entgo.io/ent/schema/field"
+	"github.com/kalbasit/ncps/ent/inlinenar"
+	"github.com/kalbasit/ncps/ent/predicate"
+)
+
+// InlineNarUpdate is the builder for updating InlineNar entities.
+type InlineNarUpdate struct {
+	config
+	hooks    []Hook
+	mutation *InlineNarMutation
+}
+
+// Where appends a list predicates to the InlineNarUpdate builder.
+func (_u *InlineNarUpdate) Where(ps ...predicate.InlineNar) *InlineNarUpdate {
+	_u.mutation.Where(ps...)
+	return _u
+}
+
+// SetUpdatedAt sets the "updated_at" field.
+func (_u *InlineNarUpdate) SetUpdatedAt(v time.Time) *InlineNarUpdate {
+	_u.mutation.SetUpdatedAt(v)
+	return _u
+}
+
+// SetNillableUpdatedAt sets the "updated_at" field if the given value is not nil.
+func (_u *InlineNarUpdate) SetNillableUpdatedAt(v *time.Time) *InlineNarUpdate {
+	if v != nil {
+		_u.SetUpdatedAt(*v)
+	}
+	return _u
+}
+
+// ClearUpdatedAt clears the value of the "updated_at" field.
+func (_u *InlineNarUpdate) ClearUpdatedAt() *InlineNarUpdate {
+	_u.mutation.ClearUpdatedAt()
+	return _u
+}
+
+// SetHash sets the "hash" field.
+func (_u *InlineNarUpdate) SetHash(v string) *InlineNarUpdate {
+	_u.mutation.SetHash(v)
+	return _u
+}
+
+// SetNillableHash sets the "hash" field if the given value is not nil.
+func (_u *InlineNarUpdate) SetNillableHash(v *string) *InlineNarUpdate {
+	if v != nil {
+		_u.SetHash(*v)
+	}
+	return _u
+}
+
+// SetCompression sets the "compression" field.
+func (_u *InlineNarUpdate) SetCompression(v string) *InlineNarUpdate {
+	_u.mutation.SetCompression(v)
+	return _u
+}
+
+// SetNillableCompression sets the "compression" field if the given value is not nil.
+func (_u *InlineNarUpdate) SetNillableCompression(v *string) *InlineNarUpdate {
+	if v != nil {
+		_u.SetCompression(*v)
+	}
+	return _u
+}
+
+// SetData sets the "data" field.
+func (_u *InlineNarUpdate) SetData(v []byte) *InlineNarUpdate {
+	_u.mutation.SetData(v)
+	return _u
+}
+
+// Mutation returns the InlineNarMutation object of the builder.
+func (_u *InlineNarUpdate) Mutation() *InlineNarMutation {
+	return _u.mutation
+}
+
+// Save executes the query and returns the number of nodes affected by the update operation.
+func (_u *InlineNarUpdate) Save(ctx context.Context) (int, error) {
+	return withHooks(ctx, _u.sqlSave, _u.mutation, _u.hooks)
+}
+
+// SaveX is like Save, but panics if an error occurs.
+func (_u *InlineNarUpdate) SaveX(ctx context.Context) int {
+	affected, err := _u.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return affected
+}
+
+// Exec executes the query.
+func (_u *InlineNarUpdate) Exec(ctx context.Context) error {
+	_, err := _u.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (_u *InlineNarUpdate) ExecX(ctx context.Context) {
+	if err := _u.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// check runs all checks and user-defined validators on the builder.
+func (_u *InlineNarUpdate) check() error {
+	if v, ok := _u.mutation.Hash(); ok {
+		if err := inlinenar.HashValidator(v); err != nil {
+			return &ValidationError{Name: "hash", err: fmt.Errorf(`ent: validator failed for field "InlineNar.hash": %w`, err)}
+		}
+	}
+	return nil
+}
+
+func (_u *InlineNarUpdate) sqlSave(ctx context.Context) (_node int, err error) {
+	if err := _u.check(); err != nil {
+		return _node, err
+	}
+	_spec := sqlgraph.NewUpdateSpec(inlinenar.Table, inlinenar.Columns, sqlgraph.NewFieldSpec(inlinenar.FieldID, field.TypeInt))
+	if ps := _u.mutation.predicates; len(ps) > 0 {
+		_spec.Predicate = func(selector *sql.Selector) {
+			for i := range ps {
+				ps[i](selector)
+			}
+		}
+	}
+	if value, ok := _u.mutation.UpdatedAt(); ok {
+		_spec.SetField(inlinenar.FieldUpdatedAt, field.TypeTime, value)
+	}
+	if _u.mutation.UpdatedAtCleared() {
+		_spec.ClearField(inlinenar.FieldUpdatedAt, field.TypeTime)
+	}
+	if value, ok := _u.mutation.Hash(); ok {
+		_spec.SetField(inlinenar.FieldHash, field.TypeString, value)
+	}
+	if value, ok := _u.mutation.Compression(); ok {
+		_spec.SetField(inlinenar.FieldCompression, field.TypeString, value)
+	}
+	if value, ok := _u.mutation.Data(); ok {
+		_spec.SetField(inlinenar.FieldData, field.TypeBytes, value)
+	}
+	if _node, err = sqlgraph.UpdateNodes(ctx, _u.driver, _spec); err != nil {
+		if _, ok := err.(*sqlgraph.NotFoundError); ok {
+			err = &NotFoundError{inlinenar.Label}
+		} else if sqlgraph.IsConstraintError(err) {
+			err = &ConstraintError{msg: err.Error(), wrap: err}
+		}
+		return 0, err
+	}
+	_u.mutation.done = true
+	return _node, nil
+}
+
+// InlineNarUpdateOne is the builder for updating a single InlineNar entity.
+type InlineNarUpdateOne struct {
+	config
+	fields   []string
+	hooks    []Hook
+	mutation *InlineNarMutation
+}
+
+// SetUpdatedAt sets the "updated_at" field.
+func (_u *InlineNarUpdateOne) SetUpdatedAt(v time.Time) *InlineNarUpdateOne {
+	_u.mutation.SetUpdatedAt(v)
+	return _u
+}
+
+// SetNillableUpdatedAt sets the "updated_at" field if the given value is not nil.
+func (_u *InlineNarUpdateOne) SetNillableUpdatedAt(v *time.Time) *InlineNarUpdateOne {
+	if v != nil {
+		_u.SetUpdatedAt(*v)
+	}
+	return _u
+}
+
+// ClearUpdatedAt clears the value of the "updated_at" field.
+func (_u *InlineNarUpdateOne) ClearUpdatedAt() *InlineNarUpdateOne {
+	_u.mutation.ClearUpdatedAt()
+	return _u
+}
+
+// SetHash sets the "hash" field.
+func (_u *InlineNarUpdateOne) SetHash(v string) *InlineNarUpdateOne {
+	_u.mutation.SetHash(v)
+	return _u
+}
+
+// SetNillableHash sets the "hash" field if the given value is not nil.
+func (_u *InlineNarUpdateOne) SetNillableHash(v *string) *InlineNarUpdateOne {
+	if v != nil {
+		_u.SetHash(*v)
+	}
+	return _u
+}
+
+// SetCompression sets the "compression" field.
+func (_u *InlineNarUpdateOne) SetCompression(v string) *InlineNarUpdateOne {
+	_u.mutation.SetCompression(v)
+	return _u
+}
+
+// SetNillableCompression sets the "compression" field if the given value is not nil.
+func (_u *InlineNarUpdateOne) SetNillableCompression(v *string) *InlineNarUpdateOne {
+	if v != nil {
+		_u.SetCompression(*v)
+	}
+	return _u
+}
+
+// SetData sets the "data" field.
+func (_u *InlineNarUpdateOne) SetData(v []byte) *InlineNarUpdateOne {
+	_u.mutation.SetData(v)
+	return _u
+}
+
+// Mutation returns the InlineNarMutation object of the builder.
+func (_u *InlineNarUpdateOne) Mutation() *InlineNarMutation {
+	return _u.mutation
+}
+
+// Where appends a list predicates to the InlineNarUpdate builder.
+func (_u *InlineNarUpdateOne) Where(ps ...predicate.InlineNar) *InlineNarUpdateOne {
+	_u.mutation.Where(ps...)
+	return _u
+}
+
+// Select allows selecting one or more fields (columns) of the returned entity.
+// The default is selecting all fields defined in the entity schema.
+func (_u *InlineNarUpdateOne) Select(field string, fields ...string) *InlineNarUpdateOne {
+	_u.fields = append([]string{field}, fields...)
+	return _u
+}
+
+// Save executes the query and returns the updated InlineNar entity.
+func (_u *InlineNarUpdateOne) Save(ctx context.Context) (*InlineNar, error) {
+	return withHooks(ctx, _u.sqlSave, _u.mutation, _u.hooks)
+}
+
+// SaveX is like Save, but panics if an error occurs.
+func (_u *InlineNarUpdateOne) SaveX(ctx context.Context) *InlineNar {
+	node, err := _u.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return node
+}
+
+// Exec executes the query on the entity.
+func (_u *InlineNarUpdateOne) Exec(ctx context.Context) error {
+	_, err := _u.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (_u *InlineNarUpdateOne) ExecX(ctx context.Context) {
+	if err := _u.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// check runs all checks and user-defined validators on the builder.
+func (_u *InlineNarUpdateOne) check() error {
+	if v, ok := _u.mutation.Hash(); ok {
+		if err := inlinenar.HashValidator(v); err != nil {
+			return &ValidationError{Name: "hash", err: fmt.Errorf(`ent: validator failed for field "InlineNar.hash": %w`, err)}
+		}
+	}
+	return nil
+}
+
+func (_u *InlineNarUpdateOne) sqlSave(ctx context.Context) (_node *InlineNar, err error) {
+	if err := _u.check(); err != nil {
+		return _node, err
+	}
+	_spec := sqlgraph.NewUpdateSpec(inlinenar.Table, inlinenar.Columns, sqlgraph.NewFieldSpec(inlinenar.FieldID, field.TypeInt))
+	id, ok := _u.mutation.ID()
+	if !ok {
+		return nil, &ValidationError{Name: "id", err: errors.New(`ent: missing "InlineNar.id" for update`)}
+	}
+	_spec.Node.ID.Value = id
+	if fields := _u.fields; len(fields) > 0 {
+		_spec.Node.Columns = make([]string, 0, len(fields))
+		_spec.Node.Columns = append(_spec.Node.Columns, inlinenar.FieldID)
+		for _, f := range fields {
+			if !inlinenar.ValidColumn(f) {
+				return nil, &ValidationError{Name: f, err: fmt.Errorf("ent: invalid field %q for query", f)}
+			}
+			if f != inlinenar.FieldID {
+				_spec.Node.Columns = append(_spec.Node.Columns, f)
+			}
+		}
+	}
+	if ps := _u.mutation.predicates; len(ps) > 0 {
+		_spec.Predicate = func(selector *sql.Selector) {
+			for i := range ps {
+				ps[i](selector)
+			}
+		}
+	}
+	if value, ok := _u.mutation.UpdatedAt(); ok {
+		_spec.SetField(inlinenar.FieldUpdatedAt, field.TypeTime, value)
+	}
+	if _u.mutation.UpdatedAtCleared() {
+		_spec.ClearField(inlinenar.FieldUpdatedAt, field.TypeTime)
+	}
+	if value, ok := _u.mutation.Hash(); ok {
+		_spec.SetField(inlinenar.FieldHash, field.TypeString, value)
+	}
+	if value, ok := _u.mutation.Compression(); ok {
+		_spec.SetField(inlinenar.FieldCompression, field.TypeString, value)
+	}
+	if value, ok := _u.mutation.Data(); ok {
+		_spec.SetField(inlinenar.FieldData, field.TypeBytes, value)
+	}
+	_node = &InlineNar{config: _u.config}
+	_spec.Assign = _node.assignValues
+	_spec.ScanValues = _node.scanValues
+	if err = sqlgraph.UpdateNode(ctx, _u.driver, _spec); err != nil {
+		if _, ok := err.(*sqlgraph.NotFoundError); ok {
+			err = &NotFoundError{inlinenar.Label}
+		} else if sqlgraph.IsConstraintError(err) {
+			err = &ConstraintError{msg: err.Error(), wrap: err}
+		}
+		return nil, err
+	}
+	_u.mutation.done = true
+	return _node, nil
+}
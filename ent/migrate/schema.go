@@ -73,6 +73,10 @@ var (
 		{Name: "hash", Type: field.TypeString},
 		{Name: "size", Type: field.TypeUint32},
 		{Name: "compressed_size", Type: field.TypeUint32, Default: 0},
+		{Name: "ref_count", Type: field.TypeInt64, Default: 0},
+		{Name: "access_count", Type: field.TypeInt64, Default: 0},
+		{Name: "last_accessed_at", Type: field.TypeTime, Nullable: true},
+		{Name: "cold", Type: field.TypeBool, Default: false},
 	}
 	// ChunksTable holds the schema information for the "chunks" table.
 	ChunksTable = &schema.Table{
@@ -85,6 +89,16 @@ var (
 				Unique:  true,
 				Columns: []*schema.Column{ChunksColumns[3]},
 			},
+			{
+				Name:    "chunk_ref_count",
+				Unique:  false,
+				Columns: []*schema.Column{ChunksColumns[6]},
+			},
+			{
+				Name:    "chunk_cold_access_count",
+				Unique:  false,
+				Columns: []*schema.Column{ChunksColumns[9], ChunksColumns[7]},
+			},
 		},
 	}
 	// ConfigColumns holds the columns for the "config" table.
@@ -93,7 +107,7 @@ var (
 		{Name: "created_at", Type: field.TypeTime, Default: "CURRENT_TIMESTAMP"},
 		{Name: "updated_at", Type: field.TypeTime, Nullable: true},
 		{Name: "key", Type: field.TypeString},
-		{Name: "value", Type: field.TypeString},
+		{Name: "value", Type: field.TypeString, Size: 2147483647},
 	}
 	// ConfigTable holds the schema information for the "config" table.
 	ConfigTable = &schema.Table{
@@ -108,6 +122,28 @@ var (
 			},
 		},
 	}
+	// InlineNarsColumns holds the columns for the "inline_nars" table.
+	InlineNarsColumns = []*schema.Column{
+		{Name: "id", Type: field.TypeInt, Increment: true},
+		{Name: "created_at", Type: field.TypeTime, Default: "CURRENT_TIMESTAMP"},
+		{Name: "updated_at", Type: field.TypeTime, Nullable: true},
+		{Name: "hash", Type: field.TypeString},
+		{Name: "compression", Type: field.TypeString, Default: ""},
+		{Name: "data", Type: field.TypeBytes},
+	}
+	// InlineNarsTable holds the schema information for the "inline_nars" table.
+	InlineNarsTable = &schema.Table{
+		Name:       "inline_nars",
+		Columns:    InlineNarsColumns,
+		PrimaryKey: []*schema.Column{InlineNarsColumns[0]},
+		Indexes: []*schema.Index{
+			{
+				Name:    "inlinenar_hash_compression",
+				Unique:  true,
+				Columns: []*schema.Column{InlineNarsColumns[3], InlineNarsColumns[4]},
+			},
+		},
+	}
 	// NarFilesColumns holds the columns for the "nar_files" table.
 	NarFilesColumns = []*schema.Column{
 		{Name: "id", Type: field.TypeInt, Increment: true},
@@ -122,6 +158,10 @@ var (
 		{Name: "verified_at", Type: field.TypeTime, Nullable: true},
 		{Name: "bytes_stored_at", Type: field.TypeTime, Nullable: true},
 		{Name: "dechunk_residue_flagged_at", Type: field.TypeTime, Nullable: true},
+		{Name: "chunk_min_size", Type: field.TypeUint32, Nullable: true},
+		{Name: "chunk_avg_size", Type: field.TypeUint32, Nullable: true},
+		{Name: "chunk_max_size", Type: field.TypeUint32, Nullable: true},
+		{Name: "stored_raw", Type: field.TypeBool, Default: false},
 		{Name: "last_accessed_at", Type: field.TypeTime, Nullable: true, Default: "CURRENT_TIMESTAMP"},
 	}
 	// NarFilesTable holds the schema information for the "nar_files" table.
@@ -138,7 +178,7 @@ var (
 			{
 				Name:    "narfile_last_accessed_at",
 				Unique:  false,
-				Columns: []*schema.Column{NarFilesColumns[12]},
+				Columns: []*schema.Column{NarFilesColumns[16]},
 			},
 		},
 	}
@@ -199,6 +239,7 @@ var (
 		{Name: "system", Type: field.TypeString, Nullable: true},
 		{Name: "ca", Type: field.TypeString, Nullable: true},
 		{Name: "last_accessed_at", Type: field.TypeTime, Nullable: true, Default: "CURRENT_TIMESTAMP"},
+		{Name: "revalidated_at", Type: field.TypeTime, Nullable: true},
 	}
 	// NarinfosTable holds the schema information for the "narinfos" table.
 	NarinfosTable = &schema.Table{
@@ -380,6 +421,7 @@ var (
 		BuildTraceSignaturesTable,
 		ChunksTable,
 		ConfigTable,
+		InlineNarsTable,
 		NarFilesTable,
 		NarFileChunksTable,
 		NarinfosTable,
@@ -407,6 +449,9 @@ func init() {
 	ConfigTable.Annotation = &entsql.Annotation{
 		Table: "config",
 	}
+	InlineNarsTable.Annotation = &entsql.Annotation{
+		Table: "inline_nars",
+	}
 	NarFilesTable.Annotation = &entsql.Annotation{
 		Table: "nar_files",
 	}
@@ -15,6 +15,7 @@ import (
 	"github.com/kalbasit/ncps/ent/buildtracesignature"
 	"github.com/kalbasit/ncps/ent/chunk"
 	"github.com/kalbasit/ncps/ent/configentry"
+	"github.com/kalbasit/ncps/ent/inlinenar"
 	"github.com/kalbasit/ncps/ent/narfile"
 	"github.com/kalbasit/ncps/ent/narfilechunk"
 	"github.com/kalbasit/ncps/ent/narinfo"
@@ -39,6 +40,7 @@ const (
 	TypeBuildTraceSignature = "BuildTraceSignature"
 	TypeChunk               = "Chunk"
 	TypeConfigEntry         = "ConfigEntry"
+	TypeInlineNar           = "InlineNar"
 	TypeNarFile             = "NarFile"
 	TypeNarFileChunk        = "NarFileChunk"
 	TypeNarInfo             = "NarInfo"
@@ -1264,6 +1266,12 @@ type ChunkMutation struct {
 	addsize               *int32
 	compressed_size       *uint32
 	addcompressed_size    *int32
+	ref_count             *int64
+	addref_count          *int64
+	access_count          *int64
+	addaccess_count       *int64
+	last_accessed_at      *time.Time
+	cold                  *bool
 	clearedFields         map[string]struct{}
 	nar_file_links        map[int]struct{}
 	removednar_file_links map[int]struct{}
@@ -1604,6 +1612,203 @@ func (m *ChunkMutation) ResetCompressedSize() {
 	m.addcompressed_size = nil
 }
 
+// SetRefCount sets the "ref_count" field.
+func (m *ChunkMutation) SetRefCount(i int64) {
+	m.ref_count = &i
+	m.addref_count = nil
+}
+
+// RefCount returns the value of the "ref_count" field in the mutation.
+func (m *ChunkMutation) RefCount() (r int64, exists bool) {
+	v := m.ref_count
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// OldRefCount returns the old "ref_count" field's value of the Chunk entity.
+// If the Chunk object wasn't provided to the builder, the object is fetched from the database.
+// An error is returned if the mutation operation is not UpdateOne, or the database query fails.
+func (m *ChunkMutation) OldRefCount(ctx context.Context) (v int64, err error) {
+	if !m.op.Is(OpUpdateOne) {
+		return v, errors.New("OldRefCount is only allowed on UpdateOne operations")
+	}
+	if m.id == nil || m.oldValue == nil {
+		return v, errors.New("OldRefCount requires an ID field in the mutation")
+	}
+	oldValue, err := m.oldValue(ctx)
+	if err != nil {
+		return v, fmt.Errorf("querying old value for OldRefCount: %w", err)
+	}
+	return oldValue.RefCount, nil
+}
+
+// AddRefCount adds i to the "ref_count" field.
+func (m *ChunkMutation) AddRefCount(i int64) {
+	if m.addref_count != nil {
+		*m.addref_count += i
+	} else {
+		m.addref_count = &i
+	}
+}
+
+// AddedRefCount returns the value that was added to the "ref_count" field in this mutation.
+func (m *ChunkMutation) AddedRefCount() (r int64, exists bool) {
+	v := m.addref_count
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// ResetRefCount resets all changes to the "ref_count" field.
+func (m *ChunkMutation) ResetRefCount() {
+	m.ref_count = nil
+	m.addref_count = nil
+}
+
+// SetAccessCount sets the "access_count" field.
+func (m *ChunkMutation) SetAccessCount(i int64) {
+	m.access_count = &i
+	m.addaccess_count = nil
+}
+
+// AccessCount returns the value of the "access_count" field in the mutation.
+func (m *ChunkMutation) AccessCount() (r int64, exists bool) {
+	v := m.access_count
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// OldAccessCount returns the old "access_count" field's value of the Chunk entity.
+// If the Chunk object wasn't provided to the builder, the object is fetched from the database.
+// An error is returned if the mutation operation is not UpdateOne, or the database query fails.
+func (m *ChunkMutation) OldAccessCount(ctx context.Context) (v int64, err error) {
+	if !m.op.Is(OpUpdateOne) {
+		return v, errors.New("OldAccessCount is only allowed on UpdateOne operations")
+	}
+	if m.id == nil || m.oldValue == nil {
+		return v, errors.New("OldAccessCount requires an ID field in the mutation")
+	}
+	oldValue, err := m.oldValue(ctx)
+	if err != nil {
+		return v, fmt.Errorf("querying old value for OldAccessCount: %w", err)
+	}
+	return oldValue.AccessCount, nil
+}
+
+// AddAccessCount adds i to the "access_count" field.
+func (m *ChunkMutation) AddAccessCount(i int64) {
+	if m.addaccess_count != nil {
+		*m.addaccess_count += i
+	} else {
+		m.addaccess_count = &i
+	}
+}
+
+// AddedAccessCount returns the value that was added to the "access_count" field in this mutation.
+func (m *ChunkMutation) AddedAccessCount() (r int64, exists bool) {
+	v := m.addaccess_count
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// ResetAccessCount resets all changes to the "access_count" field.
+func (m *ChunkMutation) ResetAccessCount() {
+	m.access_count = nil
+	m.addaccess_count = nil
+}
+
+// SetLastAccessedAt sets the "last_accessed_at" field.
+func (m *ChunkMutation) SetLastAccessedAt(t time.Time) {
+	m.last_accessed_at = &t
+}
+
+// LastAccessedAt returns the value of the "last_accessed_at" field in the mutation.
+func (m *ChunkMutation) LastAccessedAt() (r time.Time, exists bool) {
+	v := m.last_accessed_at
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// OldLastAccessedAt returns the old "last_accessed_at" field's value of the Chunk entity.
+// If the Chunk object wasn't provided to the builder, the object is fetched from the database.
+// An error is returned if the mutation operation is not UpdateOne, or the database query fails.
+func (m *ChunkMutation) OldLastAccessedAt(ctx context.Context) (v *time.Time, err error) {
+	if !m.op.Is(OpUpdateOne) {
+		return v, errors.New("OldLastAccessedAt is only allowed on UpdateOne operations")
+	}
+	if m.id == nil || m.oldValue == nil {
+		return v, errors.New("OldLastAccessedAt requires an ID field in the mutation")
+	}
+	oldValue, err := m.oldValue(ctx)
+	if err != nil {
+		return v, fmt.Errorf("querying old value for OldLastAccessedAt: %w", err)
+	}
+	return oldValue.LastAccessedAt, nil
+}
+
+// ClearLastAccessedAt clears the value of the "last_accessed_at" field.
+func (m *ChunkMutation) ClearLastAccessedAt() {
+	m.last_accessed_at = nil
+	m.clearedFields[chunk.FieldLastAccessedAt] = struct{}{}
+}
+
+// LastAccessedAtCleared returns if the "last_accessed_at" field was cleared in this mutation.
+func (m *ChunkMutation) LastAccessedAtCleared() bool {
+	_, ok := m.clearedFields[chunk.FieldLastAccessedAt]
+	return ok
+}
+
+// ResetLastAccessedAt resets all changes to the "last_accessed_at" field.
+func (m *ChunkMutation) ResetLastAccessedAt() {
+	m.last_accessed_at = nil
+	delete(m.clearedFields, chunk.FieldLastAccessedAt)
+}
+
+// SetCold sets the "cold" field.
+func (m *ChunkMutation) SetCold(b bool) {
+	m.cold = &b
+}
+
+// Cold returns the value of the "cold" field in the mutation.
+func (m *ChunkMutation) Cold() (r bool, exists bool) {
+	v := m.cold
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// OldCold returns the old "cold" field's value of the Chunk entity.
+// If the Chunk object wasn't provided to the builder, the object is fetched from the database.
+// An error is returned if the mutation operation is not UpdateOne, or the database query fails.
+func (m *ChunkMutation) OldCold(ctx context.Context) (v bool, err error) {
+	if !m.op.Is(OpUpdateOne) {
+		return v, errors.New("OldCold is only allowed on UpdateOne operations")
+	}
+	if m.id == nil || m.oldValue == nil {
+		return v, errors.New("OldCold requires an ID field in the mutation")
+	}
+	oldValue, err := m.oldValue(ctx)
+	if err != nil {
+		return v, fmt.Errorf("querying old value for OldCold: %w", err)
+	}
+	return oldValue.Cold, nil
+}
+
+// ResetCold resets all changes to the "cold" field.
+func (m *ChunkMutation) ResetCold() {
+	m.cold = nil
+}
+
 // AddNarFileLinkIDs adds the "nar_file_links" edge to the NarFileChunk entity by ids.
 func (m *ChunkMutation) AddNarFileLinkIDs(ids ...int) {
 	if m.nar_file_links == nil {
@@ -1692,7 +1897,7 @@ func (m *ChunkMutation) Type() string {
 // order to get all numeric fields that were incremented/decremented, call
 // AddedFields().
 func (m *ChunkMutation) Fields() []string {
-	fields := make([]string, 0, 5)
+	fields := make([]string, 0, 9)
 	if m.created_at != nil {
 		fields = append(fields, chunk.FieldCreatedAt)
 	}
@@ -1708,6 +1913,18 @@ func (m *ChunkMutation) Fields() []string {
 	if m.compressed_size != nil {
 		fields = append(fields, chunk.FieldCompressedSize)
 	}
+	if m.ref_count != nil {
+		fields = append(fields, chunk.FieldRefCount)
+	}
+	if m.access_count != nil {
+		fields = append(fields, chunk.FieldAccessCount)
+	}
+	if m.last_accessed_at != nil {
+		fields = append(fields, chunk.FieldLastAccessedAt)
+	}
+	if m.cold != nil {
+		fields = append(fields, chunk.FieldCold)
+	}
 	return fields
 }
 
@@ -1726,6 +1943,14 @@ func (m *ChunkMutation) Field(name string) (ent.Value, bool) {
 		return m.Size()
 	case chunk.FieldCompressedSize:
 		return m.CompressedSize()
+	case chunk.FieldRefCount:
+		return m.RefCount()
+	case chunk.FieldAccessCount:
+		return m.AccessCount()
+	case chunk.FieldLastAccessedAt:
+		return m.LastAccessedAt()
+	case chunk.FieldCold:
+		return m.Cold()
 	}
 	return nil, false
 }
@@ -1745,6 +1970,14 @@ func (m *ChunkMutation) OldField(ctx context.Context, name string) (ent.Value, e
 		return m.OldSize(ctx)
 	case chunk.FieldCompressedSize:
 		return m.OldCompressedSize(ctx)
+	case chunk.FieldRefCount:
+		return m.OldRefCount(ctx)
+	case chunk.FieldAccessCount:
+		return m.OldAccessCount(ctx)
+	case chunk.FieldLastAccessedAt:
+		return m.OldLastAccessedAt(ctx)
+	case chunk.FieldCold:
+		return m.OldCold(ctx)
 	}
 	return nil, fmt.Errorf("unknown Chunk field %s", name)
 }
@@ -1789,6 +2022,34 @@ func (m *ChunkMutation) SetField(name string, value ent.Value) error {
 		}
 		m.SetCompressedSize(v)
 		return nil
+	case chunk.FieldRefCount:
+		v, ok := value.(int64)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.SetRefCount(v)
+		return nil
+	case chunk.FieldAccessCount:
+		v, ok := value.(int64)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.SetAccessCount(v)
+		return nil
+	case chunk.FieldLastAccessedAt:
+		v, ok := value.(time.Time)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.SetLastAccessedAt(v)
+		return nil
+	case chunk.FieldCold:
+		v, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.SetCold(v)
+		return nil
 	}
 	return fmt.Errorf("unknown Chunk field %s", name)
 }
@@ -1803,6 +2064,12 @@ func (m *ChunkMutation) AddedFields() []string {
 	if m.addcompressed_size != nil {
 		fields = append(fields, chunk.FieldCompressedSize)
 	}
+	if m.addref_count != nil {
+		fields = append(fields, chunk.FieldRefCount)
+	}
+	if m.addaccess_count != nil {
+		fields = append(fields, chunk.FieldAccessCount)
+	}
 	return fields
 }
 
@@ -1815,6 +2082,10 @@ func (m *ChunkMutation) AddedField(name string) (ent.Value, bool) {
 		return m.AddedSize()
 	case chunk.FieldCompressedSize:
 		return m.AddedCompressedSize()
+	case chunk.FieldRefCount:
+		return m.AddedRefCount()
+	case chunk.FieldAccessCount:
+		return m.AddedAccessCount()
 	}
 	return nil, false
 }
@@ -1838,6 +2109,20 @@ func (m *ChunkMutation) AddField(name string, value ent.Value) error {
 		}
 		m.AddCompressedSize(v)
 		return nil
+	case chunk.FieldRefCount:
+		v, ok := value.(int64)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.AddRefCount(v)
+		return nil
+	case chunk.FieldAccessCount:
+		v, ok := value.(int64)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.AddAccessCount(v)
+		return nil
 	}
 	return fmt.Errorf("unknown Chunk numeric field %s", name)
 }
@@ -1849,6 +2134,9 @@ func (m *ChunkMutation) ClearedFields() []string {
 	if m.FieldCleared(chunk.FieldUpdatedAt) {
 		fields = append(fields, chunk.FieldUpdatedAt)
 	}
+	if m.FieldCleared(chunk.FieldLastAccessedAt) {
+		fields = append(fields, chunk.FieldLastAccessedAt)
+	}
 	return fields
 }
 
@@ -1866,6 +2154,9 @@ func (m *ChunkMutation) ClearField(name string) error {
 	case chunk.FieldUpdatedAt:
 		m.ClearUpdatedAt()
 		return nil
+	case chunk.FieldLastAccessedAt:
+		m.ClearLastAccessedAt()
+		return nil
 	}
 	return fmt.Errorf("unknown Chunk nullable field %s", name)
 }
@@ -1889,6 +2180,18 @@ func (m *ChunkMutation) ResetField(name string) error {
 	case chunk.FieldCompressedSize:
 		m.ResetCompressedSize()
 		return nil
+	case chunk.FieldRefCount:
+		m.ResetRefCount()
+		return nil
+	case chunk.FieldAccessCount:
+		m.ResetAccessCount()
+		return nil
+	case chunk.FieldLastAccessedAt:
+		m.ResetLastAccessedAt()
+		return nil
+	case chunk.FieldCold:
+		m.ResetCold()
+		return nil
 	}
 	return fmt.Errorf("unknown Chunk field %s", name)
 }
@@ -2349,142 +2652,706 @@ func (m *ConfigEntryMutation) SetField(name string, value ent.Value) error {
 		if !ok {
 			return fmt.Errorf("unexpected type %T for field %s", value, name)
 		}
-		m.SetUpdatedAt(v)
+		m.SetUpdatedAt(v)
+		return nil
+	case configentry.FieldKey:
+		v, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.SetKey(v)
+		return nil
+	case configentry.FieldValue:
+		v, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.SetValue(v)
+		return nil
+	}
+	return fmt.Errorf("unknown ConfigEntry field %s", name)
+}
+
+// AddedFields returns all numeric fields that were incremented/decremented during
+// this mutation.
+func (m *ConfigEntryMutation) AddedFields() []string {
+	return nil
+}
+
+// AddedField returns the numeric value that was incremented/decremented on a field
+// with the given name. The second boolean return value indicates that this field
+// was not set, or was not defined in the schema.
+func (m *ConfigEntryMutation) AddedField(name string) (ent.Value, bool) {
+	return nil, false
+}
+
+// AddField adds the value to the field with the given name. It returns an error if
+// the field is not defined in the schema, or if the type mismatched the field
+// type.
+func (m *ConfigEntryMutation) AddField(name string, value ent.Value) error {
+	switch name {
+	}
+	return fmt.Errorf("unknown ConfigEntry numeric field %s", name)
+}
+
+// ClearedFields returns all nullable fields that were cleared during this
+// mutation.
+func (m *ConfigEntryMutation) ClearedFields() []string {
+	var fields []string
+	if m.FieldCleared(configentry.FieldUpdatedAt) {
+		fields = append(fields, configentry.FieldUpdatedAt)
+	}
+	return fields
+}
+
+// FieldCleared returns a boolean indicating if a field with the given name was
+// cleared in this mutation.
+func (m *ConfigEntryMutation) FieldCleared(name string) bool {
+	_, ok := m.clearedFields[name]
+	return ok
+}
+
+// ClearField clears the value of the field with the given name. It returns an
+// error if the field is not defined in the schema.
+func (m *ConfigEntryMutation) ClearField(name string) error {
+	switch name {
+	case configentry.FieldUpdatedAt:
+		m.ClearUpdatedAt()
+		return nil
+	}
+	return fmt.Errorf("unknown ConfigEntry nullable field %s", name)
+}
+
+// ResetField resets all changes in the mutation for the field with the given name.
+// It returns an error if the field is not defined in the schema.
+func (m *ConfigEntryMutation) ResetField(name string) error {
+	switch name {
+	case configentry.FieldCreatedAt:
+		m.ResetCreatedAt()
+		return nil
+	case configentry.FieldUpdatedAt:
+		m.ResetUpdatedAt()
+		return nil
+	case configentry.FieldKey:
+		m.ResetKey()
+		return nil
+	case configentry.FieldValue:
+		m.ResetValue()
+		return nil
+	}
+	return fmt.Errorf("unknown ConfigEntry field %s", name)
+}
+
+// AddedEdges returns all edge names that were set/added in this mutation.
+func (m *ConfigEntryMutation) AddedEdges() []string {
+	edges := make([]string, 0, 0)
+	return edges
+}
+
+// AddedIDs returns all IDs (to other nodes) that were added for the given edge
+// name in this mutation.
+func (m *ConfigEntryMutation) AddedIDs(name string) []ent.Value {
+	return nil
+}
+
+// RemovedEdges returns all edge names that were removed in this mutation.
+func (m *ConfigEntryMutation) RemovedEdges() []string {
+	edges := make([]string, 0, 0)
+	return edges
+}
+
+// RemovedIDs returns all IDs (to other nodes) that were removed for the edge with
+// the given name in this mutation.
+func (m *ConfigEntryMutation) RemovedIDs(name string) []ent.Value {
+	return nil
+}
+
+// ClearedEdges returns all edge names that were cleared in this mutation.
+func (m *ConfigEntryMutation) ClearedEdges() []string {
+	edges := make([]string, 0, 0)
+	return edges
+}
+
+// EdgeCleared returns a boolean which indicates if the edge with the given name
+// was cleared in this mutation.
+func (m *ConfigEntryMutation) EdgeCleared(name string) bool {
+	return false
+}
+
+// ClearEdge clears the value of the edge with the given name. It returns an error
+// if that edge is not defined in the schema.
+func (m *ConfigEntryMutation) ClearEdge(name string) error {
+	return fmt.Errorf("unknown ConfigEntry unique edge %s", name)
+}
+
+// ResetEdge resets all changes to the edge with the given name in this mutation.
+// It returns an error if the edge is not defined in the schema.
+func (m *ConfigEntryMutation) ResetEdge(name string) error {
+	return fmt.Errorf("unknown ConfigEntry edge %s", name)
+}
+
+// InlineNarMutation represents an operation that mutates the InlineNar nodes in the graph.
+type InlineNarMutation struct {
+	config
+	op            Op
+	typ           string
+	id            *int
+	created_at    *time.Time
+	updated_at    *time.Time
+	hash          *string
+	compression   *string
+	data          *[]byte
+	clearedFields map[string]struct{}
+	done          bool
+	oldValue      func(context.Context) (*InlineNar, error)
+	predicates    []predicate.InlineNar
+}
+
+var _ ent.Mutation = (*InlineNarMutation)(nil)
+
+// inlinenarOption allows management of the mutation configuration using functional options.
+type inlinenarOption func(*InlineNarMutation)
+
+// newInlineNarMutation creates new mutation for the InlineNar entity.
+func newInlineNarMutation(c config, op Op, opts ...inlinenarOption) *InlineNarMutation {
+	m := &InlineNarMutation{
+		config:        c,
+		op:            op,
+		typ:           TypeInlineNar,
+		clearedFields: make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// withInlineNarID sets the ID field of the mutation.
+func withInlineNarID(id int) inlinenarOption {
+	return func(m *InlineNarMutation) {
+		var (
+			err   error
+			once  sync.Once
+			value *InlineNar
+		)
+		m.oldValue = func(ctx context.Context) (*InlineNar, error) {
+			once.Do(func() {
+				if m.done {
+					err = errors.New("querying old values post mutation is not allowed")
+				} else {
+					value, err = m.Client().InlineNar.Get(ctx, id)
+				}
+			})
+			return value, err
+		}
+		m.id = &id
+	}
+}
+
+// withInlineNar sets the old InlineNar of the mutation.
+func withInlineNar(node *InlineNar) inlinenarOption {
+	return func(m *InlineNarMutation) {
+		m.oldValue = func(context.Context) (*InlineNar, error) {
+			return node, nil
+		}
+		m.id = &node.ID
+	}
+}
+
+// Client returns a new `ent.Client` from the mutation. If the mutation was
+// executed in a transaction (ent.Tx), a transactional client is returned.
+func (m InlineNarMutation) Client() *Client {
+	client := &Client{config: m.config}
+	client.init()
+	return client
+}
+
+// Tx returns an `ent.Tx` for mutations that were executed in transactions;
+// it returns an error otherwise.
+func (m InlineNarMutation) Tx() (*Tx, error) {
+	if _, ok := m.driver.(*txDriver); !ok {
+		return nil, errors.New("ent: mutation is not running in a transaction")
+	}
+	tx := &Tx{config: m.config}
+	tx.init()
+	return tx, nil
+}
+
+// ID returns the ID value in the mutation. Note that the ID is only available
+// if it was provided to the builder or after it was returned from the database.
+func (m *InlineNarMutation) ID() (id int, exists bool) {
+	if m.id == nil {
+		return
+	}
+	return *m.id, true
+}
+
+// IDs queries the database and returns the entity ids that match the mutation's predicate.
+// That means, if the mutation is applied within a transaction with an isolation level such
+// as sql.LevelSerializable, the returned ids match the ids of the rows that will be updated
+// or updated by the mutation.
+func (m *InlineNarMutation) IDs(ctx context.Context) ([]int, error) {
+	switch {
+	case m.op.Is(OpUpdateOne | OpDeleteOne):
+		id, exists := m.ID()
+		if exists {
+			return []int{id}, nil
+		}
+		fallthrough
+	case m.op.Is(OpUpdate | OpDelete):
+		return m.Client().InlineNar.Query().Where(m.predicates...).IDs(ctx)
+	default:
+		return nil, fmt.Errorf("IDs is not allowed on %s operations", m.op)
+	}
+}
+
+// SetCreatedAt sets the "created_at" field.
+func (m *InlineNarMutation) SetCreatedAt(t time.Time) {
+	m.created_at = &t
+}
+
+// CreatedAt returns the value of the "created_at" field in the mutation.
+func (m *InlineNarMutation) CreatedAt() (r time.Time, exists bool) {
+	v := m.created_at
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// OldCreatedAt returns the old "created_at" field's value of the InlineNar entity.
+// If the InlineNar object wasn't provided to the builder, the object is fetched from the database.
+// An error is returned if the mutation operation is not UpdateOne, or the database query fails.
+func (m *InlineNarMutation) OldCreatedAt(ctx context.Context) (v time.Time, err error) {
+	if !m.op.Is(OpUpdateOne) {
+		return v, errors.New("OldCreatedAt is only allowed on UpdateOne operations")
+	}
+	if m.id == nil || m.oldValue == nil {
+		return v, errors.New("OldCreatedAt requires an ID field in the mutation")
+	}
+	oldValue, err := m.oldValue(ctx)
+	if err != nil {
+		return v, fmt.Errorf("querying old value for OldCreatedAt: %w", err)
+	}
+	return oldValue.CreatedAt, nil
+}
+
+// ResetCreatedAt resets all changes to the "created_at" field.
+func (m *InlineNarMutation) ResetCreatedAt() {
+	m.created_at = nil
+}
+
+// SetUpdatedAt sets the "updated_at" field.
+func (m *InlineNarMutation) SetUpdatedAt(t time.Time) {
+	m.updated_at = &t
+}
+
+// UpdatedAt returns the value of the "updated_at" field in the mutation.
+func (m *InlineNarMutation) UpdatedAt() (r time.Time, exists bool) {
+	v := m.updated_at
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// OldUpdatedAt returns the old "updated_at" field's value of the InlineNar entity.
+// If the InlineNar object wasn't provided to the builder, the object is fetched from the database.
+// An error is returned if the mutation operation is not UpdateOne, or the database query fails.
+func (m *InlineNarMutation) OldUpdatedAt(ctx context.Context) (v *time.Time, err error) {
+	if !m.op.Is(OpUpdateOne) {
+		return v, errors.New("OldUpdatedAt is only allowed on UpdateOne operations")
+	}
+	if m.id == nil || m.oldValue == nil {
+		return v, errors.New("OldUpdatedAt requires an ID field in the mutation")
+	}
+	oldValue, err := m.oldValue(ctx)
+	if err != nil {
+		return v, fmt.Errorf("querying old value for OldUpdatedAt: %w", err)
+	}
+	return oldValue.UpdatedAt, nil
+}
+
+// ClearUpdatedAt clears the value of the "updated_at" field.
+func (m *InlineNarMutation) ClearUpdatedAt() {
+	m.updated_at = nil
+	m.clearedFields[inlinenar.FieldUpdatedAt] = struct{}{}
+}
+
+// UpdatedAtCleared returns if the "updated_at" field was cleared in this mutation.
+func (m *InlineNarMutation) UpdatedAtCleared() bool {
+	_, ok := m.clearedFields[inlinenar.FieldUpdatedAt]
+	return ok
+}
+
+// ResetUpdatedAt resets all changes to the "updated_at" field.
+func (m *InlineNarMutation) ResetUpdatedAt() {
+	m.updated_at = nil
+	delete(m.clearedFields, inlinenar.FieldUpdatedAt)
+}
+
+// SetHash sets the "hash" field.
+func (m *InlineNarMutation) SetHash(s string) {
+	m.hash = &s
+}
+
+// Hash returns the value of the "hash" field in the mutation.
+func (m *InlineNarMutation) Hash() (r string, exists bool) {
+	v := m.hash
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// OldHash returns the old "hash" field's value of the InlineNar entity.
+// If the InlineNar object wasn't provided to the builder, the object is fetched from the database.
+// An error is returned if the mutation operation is not UpdateOne, or the database query fails.
+func (m *InlineNarMutation) OldHash(ctx context.Context) (v string, err error) {
+	if !m.op.Is(OpUpdateOne) {
+		return v, errors.New("OldHash is only allowed on UpdateOne operations")
+	}
+	if m.id == nil || m.oldValue == nil {
+		return v, errors.New("OldHash requires an ID field in the mutation")
+	}
+	oldValue, err := m.oldValue(ctx)
+	if err != nil {
+		return v, fmt.Errorf("querying old value for OldHash: %w", err)
+	}
+	return oldValue.Hash, nil
+}
+
+// ResetHash resets all changes to the "hash" field.
+func (m *InlineNarMutation) ResetHash() {
+	m.hash = nil
+}
+
+// SetCompression sets the "compression" field.
+func (m *InlineNarMutation) SetCompression(s string) {
+	m.compression = &s
+}
+
+// Compression returns the value of the "compression" field in the mutation.
+func (m *InlineNarMutation) Compression() (r string, exists bool) {
+	v := m.compression
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// OldCompression returns the old "compression" field's value of the InlineNar entity.
+// If the InlineNar object wasn't provided to the builder, the object is fetched from the database.
+// An error is returned if the mutation operation is not UpdateOne, or the database query fails.
+func (m *InlineNarMutation) OldCompression(ctx context.Context) (v string, err error) {
+	if !m.op.Is(OpUpdateOne) {
+		return v, errors.New("OldCompression is only allowed on UpdateOne operations")
+	}
+	if m.id == nil || m.oldValue == nil {
+		return v, errors.New("OldCompression requires an ID field in the mutation")
+	}
+	oldValue, err := m.oldValue(ctx)
+	if err != nil {
+		return v, fmt.Errorf("querying old value for OldCompression: %w", err)
+	}
+	return oldValue.Compression, nil
+}
+
+// ResetCompression resets all changes to the "compression" field.
+func (m *InlineNarMutation) ResetCompression() {
+	m.compression = nil
+}
+
+// SetData sets the "data" field.
+func (m *InlineNarMutation) SetData(b []byte) {
+	m.data = &b
+}
+
+// Data returns the value of the "data" field in the mutation.
+func (m *InlineNarMutation) Data() (r []byte, exists bool) {
+	v := m.data
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// OldData returns the old "data" field's value of the InlineNar entity.
+// If the InlineNar object wasn't provided to the builder, the object is fetched from the database.
+// An error is returned if the mutation operation is not UpdateOne, or the database query fails.
+func (m *InlineNarMutation) OldData(ctx context.Context) (v []byte, err error) {
+	if !m.op.Is(OpUpdateOne) {
+		return v, errors.New("OldData is only allowed on UpdateOne operations")
+	}
+	if m.id == nil || m.oldValue == nil {
+		return v, errors.New("OldData requires an ID field in the mutation")
+	}
+	oldValue, err := m.oldValue(ctx)
+	if err != nil {
+		return v, fmt.Errorf("querying old value for OldData: %w", err)
+	}
+	return oldValue.Data, nil
+}
+
+// ResetData resets all changes to the "data" field.
+func (m *InlineNarMutation) ResetData() {
+	m.data = nil
+}
+
+// Where appends a list predicates to the InlineNarMutation builder.
+func (m *InlineNarMutation) Where(ps ...predicate.InlineNar) {
+	m.predicates = append(m.predicates, ps...)
+}
+
+// WhereP appends storage-level predicates to the InlineNarMutation builder. Using this method,
+// users can use type-assertion to append predicates that do not depend on any generated package.
+func (m *InlineNarMutation) WhereP(ps ...func(*sql.Selector)) {
+	p := make([]predicate.InlineNar, len(ps))
+	for i := range ps {
+		p[i] = ps[i]
+	}
+	m.Where(p...)
+}
+
+// Op returns the operation name.
+func (m *InlineNarMutation) Op() Op {
+	return m.op
+}
+
+// SetOp allows setting the mutation operation.
+func (m *InlineNarMutation) SetOp(op Op) {
+	m.op = op
+}
+
+// Type returns the node type of this mutation (InlineNar).
+func (m *InlineNarMutation) Type() string {
+	return m.typ
+}
+
+// Fields returns all fields that were changed during this mutation. Note that in
+// order to get all numeric fields that were incremented/decremented, call
+// AddedFields().
+func (m *InlineNarMutation) Fields() []string {
+	fields := make([]string, 0, 5)
+	if m.created_at != nil {
+		fields = append(fields, inlinenar.FieldCreatedAt)
+	}
+	if m.updated_at != nil {
+		fields = append(fields, inlinenar.FieldUpdatedAt)
+	}
+	if m.hash != nil {
+		fields = append(fields, inlinenar.FieldHash)
+	}
+	if m.compression != nil {
+		fields = append(fields, inlinenar.FieldCompression)
+	}
+	if m.data != nil {
+		fields = append(fields, inlinenar.FieldData)
+	}
+	return fields
+}
+
+// Field returns the value of a field with the given name. The second boolean
+// return value indicates that this field was not set, or was not defined in the
+// schema.
+func (m *InlineNarMutation) Field(name string) (ent.Value, bool) {
+	switch name {
+	case inlinenar.FieldCreatedAt:
+		return m.CreatedAt()
+	case inlinenar.FieldUpdatedAt:
+		return m.UpdatedAt()
+	case inlinenar.FieldHash:
+		return m.Hash()
+	case inlinenar.FieldCompression:
+		return m.Compression()
+	case inlinenar.FieldData:
+		return m.Data()
+	}
+	return nil, false
+}
+
+// OldField returns the old value of the field from the database. An error is
+// returned if the mutation operation is not UpdateOne, or the query to the
+// database failed.
+func (m *InlineNarMutation) OldField(ctx context.Context, name string) (ent.Value, error) {
+	switch name {
+	case inlinenar.FieldCreatedAt:
+		return m.OldCreatedAt(ctx)
+	case inlinenar.FieldUpdatedAt:
+		return m.OldUpdatedAt(ctx)
+	case inlinenar.FieldHash:
+		return m.OldHash(ctx)
+	case inlinenar.FieldCompression:
+		return m.OldCompression(ctx)
+	case inlinenar.FieldData:
+		return m.OldData(ctx)
+	}
+	return nil, fmt.Errorf("unknown InlineNar field %s", name)
+}
+
+// SetField sets the value of a field with the given name. It returns an error if
+// the field is not defined in the schema, or if the type mismatched the field
+// type.
+func (m *InlineNarMutation) SetField(name string, value ent.Value) error {
+	switch name {
+	case inlinenar.FieldCreatedAt:
+		v, ok := value.(time.Time)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.SetCreatedAt(v)
+		return nil
+	case inlinenar.FieldUpdatedAt:
+		v, ok := value.(time.Time)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.SetUpdatedAt(v)
+		return nil
+	case inlinenar.FieldHash:
+		v, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.SetHash(v)
 		return nil
-	case configentry.FieldKey:
+	case inlinenar.FieldCompression:
 		v, ok := value.(string)
 		if !ok {
 			return fmt.Errorf("unexpected type %T for field %s", value, name)
 		}
-		m.SetKey(v)
+		m.SetCompression(v)
 		return nil
-	case configentry.FieldValue:
-		v, ok := value.(string)
+	case inlinenar.FieldData:
+		v, ok := value.([]byte)
 		if !ok {
 			return fmt.Errorf("unexpected type %T for field %s", value, name)
 		}
-		m.SetValue(v)
+		m.SetData(v)
 		return nil
 	}
-	return fmt.Errorf("unknown ConfigEntry field %s", name)
+	return fmt.Errorf("unknown InlineNar field %s", name)
 }
 
 // AddedFields returns all numeric fields that were incremented/decremented during
 // this mutation.
-func (m *ConfigEntryMutation) AddedFields() []string {
+func (m *InlineNarMutation) AddedFields() []string {
 	return nil
 }
 
 // AddedField returns the numeric value that was incremented/decremented on a field
 // with the given name. The second boolean return value indicates that this field
 // was not set, or was not defined in the schema.
-func (m *ConfigEntryMutation) AddedField(name string) (ent.Value, bool) {
+func (m *InlineNarMutation) AddedField(name string) (ent.Value, bool) {
 	return nil, false
 }
 
 // AddField adds the value to the field with the given name. It returns an error if
 // the field is not defined in the schema, or if the type mismatched the field
 // type.
-func (m *ConfigEntryMutation) AddField(name string, value ent.Value) error {
+func (m *InlineNarMutation) AddField(name string, value ent.Value) error {
 	switch name {
 	}
-	return fmt.Errorf("unknown ConfigEntry numeric field %s", name)
+	return fmt.Errorf("unknown InlineNar numeric field %s", name)
 }
 
 // ClearedFields returns all nullable fields that were cleared during this
 // mutation.
-func (m *ConfigEntryMutation) ClearedFields() []string {
+func (m *InlineNarMutation) ClearedFields() []string {
 	var fields []string
-	if m.FieldCleared(configentry.FieldUpdatedAt) {
-		fields = append(fields, configentry.FieldUpdatedAt)
+	if m.FieldCleared(inlinenar.FieldUpdatedAt) {
+		fields = append(fields, inlinenar.FieldUpdatedAt)
 	}
 	return fields
 }
 
 // FieldCleared returns a boolean indicating if a field with the given name was
 // cleared in this mutation.
-func (m *ConfigEntryMutation) FieldCleared(name string) bool {
+func (m *InlineNarMutation) FieldCleared(name string) bool {
 	_, ok := m.clearedFields[name]
 	return ok
 }
 
 // ClearField clears the value of the field with the given name. It returns an
 // error if the field is not defined in the schema.
-func (m *ConfigEntryMutation) ClearField(name string) error {
+func (m *InlineNarMutation) ClearField(name string) error {
 	switch name {
-	case configentry.FieldUpdatedAt:
+	case inlinenar.FieldUpdatedAt:
 		m.ClearUpdatedAt()
 		return nil
 	}
-	return fmt.Errorf("unknown ConfigEntry nullable field %s", name)
+	return fmt.Errorf("unknown InlineNar nullable field %s", name)
 }
 
 // ResetField resets all changes in the mutation for the field with the given name.
 // It returns an error if the field is not defined in the schema.
-func (m *ConfigEntryMutation) ResetField(name string) error {
+func (m *InlineNarMutation) ResetField(name string) error {
 	switch name {
-	case configentry.FieldCreatedAt:
+	case inlinenar.FieldCreatedAt:
 		m.ResetCreatedAt()
 		return nil
-	case configentry.FieldUpdatedAt:
+	case inlinenar.FieldUpdatedAt:
 		m.ResetUpdatedAt()
 		return nil
-	case configentry.FieldKey:
-		m.ResetKey()
+	case inlinenar.FieldHash:
+		m.ResetHash()
 		return nil
-	case configentry.FieldValue:
-		m.ResetValue()
+	case inlinenar.FieldCompression:
+		m.ResetCompression()
+		return nil
+	case inlinenar.FieldData:
+		m.ResetData()
 		return nil
 	}
-	return fmt.Errorf("unknown ConfigEntry field %s", name)
+	return fmt.Errorf("unknown InlineNar field %s", name)
 }
 
 // AddedEdges returns all edge names that were set/added in this mutation.
-func (m *ConfigEntryMutation) AddedEdges() []string {
+func (m *InlineNarMutation) AddedEdges() []string {
 	edges := make([]string, 0, 0)
 	return edges
 }
 
 // AddedIDs returns all IDs (to other nodes) that were added for the given edge
 // name in this mutation.
-func (m *ConfigEntryMutation) AddedIDs(name string) []ent.Value {
+func (m *InlineNarMutation) AddedIDs(name string) []ent.Value {
 	return nil
 }
 
 // RemovedEdges returns all edge names that were removed in this mutation.
-func (m *ConfigEntryMutation) RemovedEdges() []string {
+func (m *InlineNarMutation) RemovedEdges() []string {
 	edges := make([]string, 0, 0)
 	return edges
 }
 
 // RemovedIDs returns all IDs (to other nodes) that were removed for the edge with
 // the given name in this mutation.
-func (m *ConfigEntryMutation) RemovedIDs(name string) []ent.Value {
+func (m *InlineNarMutation) RemovedIDs(name string) []ent.Value {
 	return nil
 }
 
 // ClearedEdges returns all edge names that were cleared in this mutation.
-func (m *ConfigEntryMutation) ClearedEdges() []string {
+func (m *InlineNarMutation) ClearedEdges() []string {
 	edges := make([]string, 0, 0)
 	return edges
 }
 
 // EdgeCleared returns a boolean which indicates if the edge with the given name
 // was cleared in this mutation.
-func (m *ConfigEntryMutation) EdgeCleared(name string) bool {
+func (m *InlineNarMutation) EdgeCleared(name string) bool {
 	return false
 }
 
 // ClearEdge clears the value of the edge with the given name. It returns an error
 // if that edge is not defined in the schema.
-func (m *ConfigEntryMutation) ClearEdge(name string) error {
-	return fmt.Errorf("unknown ConfigEntry unique edge %s", name)
+func (m *InlineNarMutation) ClearEdge(name string) error {
+	return fmt.Errorf("unknown InlineNar unique edge %s", name)
 }
 
 // ResetEdge resets all changes to the edge with the given name in this mutation.
 // It returns an error if the edge is not defined in the schema.
-func (m *ConfigEntryMutation) ResetEdge(name string) error {
-	return fmt.Errorf("unknown ConfigEntry edge %s", name)
+func (m *InlineNarMutation) ResetEdge(name string) error {
+	return fmt.Errorf("unknown InlineNar edge %s", name)
 }
 
 // NarFileMutation represents an operation that mutates the NarFile nodes in the graph.
@@ -2506,6 +3373,13 @@ type NarFileMutation struct {
 	verified_at                *time.Time
 	bytes_stored_at            *time.Time
 	dechunk_residue_flagged_at *time.Time
+	chunk_min_size             *uint32
+	addchunk_min_size          *int32
+	chunk_avg_size             *uint32
+	addchunk_avg_size          *int32
+	chunk_max_size             *uint32
+	addchunk_max_size          *int32
+	stored_raw                 *bool
 	last_accessed_at           *time.Time
 	clearedFields              map[string]struct{}
 	nar_info_nar_files         map[int]struct{}
@@ -3118,6 +3992,252 @@ func (m *NarFileMutation) ResetDechunkResidueFlaggedAt() {
 	delete(m.clearedFields, narfile.FieldDechunkResidueFlaggedAt)
 }
 
+// SetChunkMinSize sets the "chunk_min_size" field.
+func (m *NarFileMutation) SetChunkMinSize(u uint32) {
+	m.chunk_min_size = &u
+	m.addchunk_min_size = nil
+}
+
+// ChunkMinSize returns the value of the "chunk_min_size" field in the mutation.
+func (m *NarFileMutation) ChunkMinSize() (r uint32, exists bool) {
+	v := m.chunk_min_size
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// OldChunkMinSize returns the old "chunk_min_size" field's value of the NarFile entity.
+// If the NarFile object wasn't provided to the builder, the object is fetched from the database.
+// An error is returned if the mutation operation is not UpdateOne, or the database query fails.
+func (m *NarFileMutation) OldChunkMinSize(ctx context.Context) (v *uint32, err error) {
+	if !m.op.Is(OpUpdateOne) {
+		return v, errors.New("OldChunkMinSize is only allowed on UpdateOne operations")
+	}
+	if m.id == nil || m.oldValue == nil {
+		return v, errors.New("OldChunkMinSize requires an ID field in the mutation")
+	}
+	oldValue, err := m.oldValue(ctx)
+	if err != nil {
+		return v, fmt.Errorf("querying old value for OldChunkMinSize: %w", err)
+	}
+	return oldValue.ChunkMinSize, nil
+}
+
+// AddChunkMinSize adds u to the "chunk_min_size" field.
+func (m *NarFileMutation) AddChunkMinSize(u int32) {
+	if m.addchunk_min_size != nil {
+		*m.addchunk_min_size += u
+	} else {
+		m.addchunk_min_size = &u
+	}
+}
+
+// AddedChunkMinSize returns the value that was added to the "chunk_min_size" field in this mutation.
+func (m *NarFileMutation) AddedChunkMinSize() (r int32, exists bool) {
+	v := m.addchunk_min_size
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// ClearChunkMinSize clears the value of the "chunk_min_size" field.
+func (m *NarFileMutation) ClearChunkMinSize() {
+	m.chunk_min_size = nil
+	m.addchunk_min_size = nil
+	m.clearedFields[narfile.FieldChunkMinSize] = struct{}{}
+}
+
+// ChunkMinSizeCleared returns if the "chunk_min_size" field was cleared in this mutation.
+func (m *NarFileMutation) ChunkMinSizeCleared() bool {
+	_, ok := m.clearedFields[narfile.FieldChunkMinSize]
+	return ok
+}
+
+// ResetChunkMinSize resets all changes to the "chunk_min_size" field.
+func (m *NarFileMutation) ResetChunkMinSize() {
+	m.chunk_min_size = nil
+	m.addchunk_min_size = nil
+	delete(m.clearedFields, narfile.FieldChunkMinSize)
+}
+
+// SetChunkAvgSize sets the "chunk_avg_size" field.
+func (m *NarFileMutation) SetChunkAvgSize(u uint32) {
+	m.chunk_avg_size = &u
+	m.addchunk_avg_size = nil
+}
+
+// ChunkAvgSize returns the value of the "chunk_avg_size" field in the mutation.
+func (m *NarFileMutation) ChunkAvgSize() (r uint32, exists bool) {
+	v := m.chunk_avg_size
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// OldChunkAvgSize returns the old "chunk_avg_size" field's value of the NarFile entity.
+// If the NarFile object wasn't provided to the builder, the object is fetched from the database.
+// An error is returned if the mutation operation is not UpdateOne, or the database query fails.
+func (m *NarFileMutation) OldChunkAvgSize(ctx context.Context) (v *uint32, err error) {
+	if !m.op.Is(OpUpdateOne) {
+		return v, errors.New("OldChunkAvgSize is only allowed on UpdateOne operations")
+	}
+	if m.id == nil || m.oldValue == nil {
+		return v, errors.New("OldChunkAvgSize requires an ID field in the mutation")
+	}
+	oldValue, err := m.oldValue(ctx)
+	if err != nil {
+		return v, fmt.Errorf("querying old value for OldChunkAvgSize: %w", err)
+	}
+	return oldValue.ChunkAvgSize, nil
+}
+
+// AddChunkAvgSize adds u to the "chunk_avg_size" field.
+func (m *NarFileMutation) AddChunkAvgSize(u int32) {
+	if m.addchunk_avg_size != nil {
+		*m.addchunk_avg_size += u
+	} else {
+		m.addchunk_avg_size = &u
+	}
+}
+
+// AddedChunkAvgSize returns the value that was added to the "chunk_avg_size" field in this mutation.
+func (m *NarFileMutation) AddedChunkAvgSize() (r int32, exists bool) {
+	v := m.addchunk_avg_size
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// ClearChunkAvgSize clears the value of the "chunk_avg_size" field.
+func (m *NarFileMutation) ClearChunkAvgSize() {
+	m.chunk_avg_size = nil
+	m.addchunk_avg_size = nil
+	m.clearedFields[narfile.FieldChunkAvgSize] = struct{}{}
+}
+
+// ChunkAvgSizeCleared returns if the "chunk_avg_size" field was cleared in this mutation.
+func (m *NarFileMutation) ChunkAvgSizeCleared() bool {
+	_, ok := m.clearedFields[narfile.FieldChunkAvgSize]
+	return ok
+}
+
+// ResetChunkAvgSize resets all changes to the "chunk_avg_size" field.
+func (m *NarFileMutation) ResetChunkAvgSize() {
+	m.chunk_avg_size = nil
+	m.addchunk_avg_size = nil
+	delete(m.clearedFields, narfile.FieldChunkAvgSize)
+}
+
+// SetChunkMaxSize sets the "chunk_max_size" field.
+func (m *NarFileMutation) SetChunkMaxSize(u uint32) {
+	m.chunk_max_size = &u
+	m.addchunk_max_size = nil
+}
+
+// ChunkMaxSize returns the value of the "chunk_max_size" field in the mutation.
+func (m *NarFileMutation) ChunkMaxSize() (r uint32, exists bool) {
+	v := m.chunk_max_size
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// OldChunkMaxSize returns the old "chunk_max_size" field's value of the NarFile entity.
+// If the NarFile object wasn't provided to the builder, the object is fetched from the database.
+// An error is returned if the mutation operation is not UpdateOne, or the database query fails.
+func (m *NarFileMutation) OldChunkMaxSize(ctx context.Context) (v *uint32, err error) {
+	if !m.op.Is(OpUpdateOne) {
+		return v, errors.New("OldChunkMaxSize is only allowed on UpdateOne operations")
+	}
+	if m.id == nil || m.oldValue == nil {
+		return v, errors.New("OldChunkMaxSize requires an ID field in the mutation")
+	}
+	oldValue, err := m.oldValue(ctx)
+	if err != nil {
+		return v, fmt.Errorf("querying old value for OldChunkMaxSize: %w", err)
+	}
+	return oldValue.ChunkMaxSize, nil
+}
+
+// AddChunkMaxSize adds u to the "chunk_max_size" field.
+func (m *NarFileMutation) AddChunkMaxSize(u int32) {
+	if m.addchunk_max_size != nil {
+		*m.addchunk_max_size += u
+	} else {
+		m.addchunk_max_size = &u
+	}
+}
+
+// AddedChunkMaxSize returns the value that was added to the "chunk_max_size" field in this mutation.
+func (m *NarFileMutation) AddedChunkMaxSize() (r int32, exists bool) {
+	v := m.addchunk_max_size
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// ClearChunkMaxSize clears the value of the "chunk_max_size" field.
+func (m *NarFileMutation) ClearChunkMaxSize() {
+	m.chunk_max_size = nil
+	m.addchunk_max_size = nil
+	m.clearedFields[narfile.FieldChunkMaxSize] = struct{}{}
+}
+
+// ChunkMaxSizeCleared returns if the "chunk_max_size" field was cleared in this mutation.
+func (m *NarFileMutation) ChunkMaxSizeCleared() bool {
+	_, ok := m.clearedFields[narfile.FieldChunkMaxSize]
+	return ok
+}
+
+// ResetChunkMaxSize resets all changes to the "chunk_max_size" field.
+func (m *NarFileMutation) ResetChunkMaxSize() {
+	m.chunk_max_size = nil
+	m.addchunk_max_size = nil
+	delete(m.clearedFields, narfile.FieldChunkMaxSize)
+}
+
+// SetStoredRaw sets the "stored_raw" field.
+func (m *NarFileMutation) SetStoredRaw(b bool) {
+	m.stored_raw = &b
+}
+
+// StoredRaw returns the value of the "stored_raw" field in the mutation.
+func (m *NarFileMutation) StoredRaw() (r bool, exists bool) {
+	v := m.stored_raw
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// OldStoredRaw returns the old "stored_raw" field's value of the NarFile entity.
+// If the NarFile object wasn't provided to the builder, the object is fetched from the database.
+// An error is returned if the mutation operation is not UpdateOne, or the database query fails.
+func (m *NarFileMutation) OldStoredRaw(ctx context.Context) (v bool, err error) {
+	if !m.op.Is(OpUpdateOne) {
+		return v, errors.New("OldStoredRaw is only allowed on UpdateOne operations")
+	}
+	if m.id == nil || m.oldValue == nil {
+		return v, errors.New("OldStoredRaw requires an ID field in the mutation")
+	}
+	oldValue, err := m.oldValue(ctx)
+	if err != nil {
+		return v, fmt.Errorf("querying old value for OldStoredRaw: %w", err)
+	}
+	return oldValue.StoredRaw, nil
+}
+
+// ResetStoredRaw resets all changes to the "stored_raw" field.
+func (m *NarFileMutation) ResetStoredRaw() {
+	m.stored_raw = nil
+}
+
 // SetLastAccessedAt sets the "last_accessed_at" field.
 func (m *NarFileMutation) SetLastAccessedAt(t time.Time) {
 	m.last_accessed_at = &t
@@ -3309,7 +4429,7 @@ func (m *NarFileMutation) Type() string {
 // order to get all numeric fields that were incremented/decremented, call
 // AddedFields().
 func (m *NarFileMutation) Fields() []string {
-	fields := make([]string, 0, 12)
+	fields := make([]string, 0, 16)
 	if m.created_at != nil {
 		fields = append(fields, narfile.FieldCreatedAt)
 	}
@@ -3343,6 +4463,18 @@ func (m *NarFileMutation) Fields() []string {
 	if m.dechunk_residue_flagged_at != nil {
 		fields = append(fields, narfile.FieldDechunkResidueFlaggedAt)
 	}
+	if m.chunk_min_size != nil {
+		fields = append(fields, narfile.FieldChunkMinSize)
+	}
+	if m.chunk_avg_size != nil {
+		fields = append(fields, narfile.FieldChunkAvgSize)
+	}
+	if m.chunk_max_size != nil {
+		fields = append(fields, narfile.FieldChunkMaxSize)
+	}
+	if m.stored_raw != nil {
+		fields = append(fields, narfile.FieldStoredRaw)
+	}
 	if m.last_accessed_at != nil {
 		fields = append(fields, narfile.FieldLastAccessedAt)
 	}
@@ -3376,6 +4508,14 @@ func (m *NarFileMutation) Field(name string) (ent.Value, bool) {
 		return m.BytesStoredAt()
 	case narfile.FieldDechunkResidueFlaggedAt:
 		return m.DechunkResidueFlaggedAt()
+	case narfile.FieldChunkMinSize:
+		return m.ChunkMinSize()
+	case narfile.FieldChunkAvgSize:
+		return m.ChunkAvgSize()
+	case narfile.FieldChunkMaxSize:
+		return m.ChunkMaxSize()
+	case narfile.FieldStoredRaw:
+		return m.StoredRaw()
 	case narfile.FieldLastAccessedAt:
 		return m.LastAccessedAt()
 	}
@@ -3409,6 +4549,14 @@ func (m *NarFileMutation) OldField(ctx context.Context, name string) (ent.Value,
 		return m.OldBytesStoredAt(ctx)
 	case narfile.FieldDechunkResidueFlaggedAt:
 		return m.OldDechunkResidueFlaggedAt(ctx)
+	case narfile.FieldChunkMinSize:
+		return m.OldChunkMinSize(ctx)
+	case narfile.FieldChunkAvgSize:
+		return m.OldChunkAvgSize(ctx)
+	case narfile.FieldChunkMaxSize:
+		return m.OldChunkMaxSize(ctx)
+	case narfile.FieldStoredRaw:
+		return m.OldStoredRaw(ctx)
 	case narfile.FieldLastAccessedAt:
 		return m.OldLastAccessedAt(ctx)
 	}
@@ -3497,6 +4645,34 @@ func (m *NarFileMutation) SetField(name string, value ent.Value) error {
 		}
 		m.SetDechunkResidueFlaggedAt(v)
 		return nil
+	case narfile.FieldChunkMinSize:
+		v, ok := value.(uint32)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.SetChunkMinSize(v)
+		return nil
+	case narfile.FieldChunkAvgSize:
+		v, ok := value.(uint32)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.SetChunkAvgSize(v)
+		return nil
+	case narfile.FieldChunkMaxSize:
+		v, ok := value.(uint32)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.SetChunkMaxSize(v)
+		return nil
+	case narfile.FieldStoredRaw:
+		v, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.SetStoredRaw(v)
+		return nil
 	case narfile.FieldLastAccessedAt:
 		v, ok := value.(time.Time)
 		if !ok {
@@ -3518,6 +4694,15 @@ func (m *NarFileMutation) AddedFields() []string {
 	if m.addtotal_chunks != nil {
 		fields = append(fields, narfile.FieldTotalChunks)
 	}
+	if m.addchunk_min_size != nil {
+		fields = append(fields, narfile.FieldChunkMinSize)
+	}
+	if m.addchunk_avg_size != nil {
+		fields = append(fields, narfile.FieldChunkAvgSize)
+	}
+	if m.addchunk_max_size != nil {
+		fields = append(fields, narfile.FieldChunkMaxSize)
+	}
 	return fields
 }
 
@@ -3530,6 +4715,12 @@ func (m *NarFileMutation) AddedField(name string) (ent.Value, bool) {
 		return m.AddedFileSize()
 	case narfile.FieldTotalChunks:
 		return m.AddedTotalChunks()
+	case narfile.FieldChunkMinSize:
+		return m.AddedChunkMinSize()
+	case narfile.FieldChunkAvgSize:
+		return m.AddedChunkAvgSize()
+	case narfile.FieldChunkMaxSize:
+		return m.AddedChunkMaxSize()
 	}
 	return nil, false
 }
@@ -3553,6 +4744,27 @@ func (m *NarFileMutation) AddField(name string, value ent.Value) error {
 		}
 		m.AddTotalChunks(v)
 		return nil
+	case narfile.FieldChunkMinSize:
+		v, ok := value.(int32)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.AddChunkMinSize(v)
+		return nil
+	case narfile.FieldChunkAvgSize:
+		v, ok := value.(int32)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.AddChunkAvgSize(v)
+		return nil
+	case narfile.FieldChunkMaxSize:
+		v, ok := value.(int32)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.AddChunkMaxSize(v)
+		return nil
 	}
 	return fmt.Errorf("unknown NarFile numeric field %s", name)
 }
@@ -3576,6 +4788,15 @@ func (m *NarFileMutation) ClearedFields() []string {
 	if m.FieldCleared(narfile.FieldDechunkResidueFlaggedAt) {
 		fields = append(fields, narfile.FieldDechunkResidueFlaggedAt)
 	}
+	if m.FieldCleared(narfile.FieldChunkMinSize) {
+		fields = append(fields, narfile.FieldChunkMinSize)
+	}
+	if m.FieldCleared(narfile.FieldChunkAvgSize) {
+		fields = append(fields, narfile.FieldChunkAvgSize)
+	}
+	if m.FieldCleared(narfile.FieldChunkMaxSize) {
+		fields = append(fields, narfile.FieldChunkMaxSize)
+	}
 	if m.FieldCleared(narfile.FieldLastAccessedAt) {
 		fields = append(fields, narfile.FieldLastAccessedAt)
 	}
@@ -3608,6 +4829,15 @@ func (m *NarFileMutation) ClearField(name string) error {
 	case narfile.FieldDechunkResidueFlaggedAt:
 		m.ClearDechunkResidueFlaggedAt()
 		return nil
+	case narfile.FieldChunkMinSize:
+		m.ClearChunkMinSize()
+		return nil
+	case narfile.FieldChunkAvgSize:
+		m.ClearChunkAvgSize()
+		return nil
+	case narfile.FieldChunkMaxSize:
+		m.ClearChunkMaxSize()
+		return nil
 	case narfile.FieldLastAccessedAt:
 		m.ClearLastAccessedAt()
 		return nil
@@ -3652,6 +4882,18 @@ func (m *NarFileMutation) ResetField(name string) error {
 	case narfile.FieldDechunkResidueFlaggedAt:
 		m.ResetDechunkResidueFlaggedAt()
 		return nil
+	case narfile.FieldChunkMinSize:
+		m.ResetChunkMinSize()
+		return nil
+	case narfile.FieldChunkAvgSize:
+		m.ResetChunkAvgSize()
+		return nil
+	case narfile.FieldChunkMaxSize:
+		m.ResetChunkMaxSize()
+		return nil
+	case narfile.FieldStoredRaw:
+		m.ResetStoredRaw()
+		return nil
 	case narfile.FieldLastAccessedAt:
 		m.ResetLastAccessedAt()
 		return nil
@@ -4362,6 +5604,7 @@ type NarInfoMutation struct {
 	system                    *string
 	ca                        *string
 	last_accessed_at          *time.Time
+	revalidated_at            *time.Time
 	clearedFields             map[string]struct{}
 	references                map[int]struct{}
 	removedreferences         map[int]struct{}
@@ -5226,6 +6469,55 @@ func (m *NarInfoMutation) ResetLastAccessedAt() {
 	delete(m.clearedFields, narinfo.FieldLastAccessedAt)
 }
 
+// SetRevalidatedAt sets the "revalidated_at" field.
+func (m *NarInfoMutation) SetRevalidatedAt(t time.Time) {
+	m.revalidated_at = &t
+}
+
+// RevalidatedAt returns the value of the "revalidated_at" field in the mutation.
+func (m *NarInfoMutation) RevalidatedAt() (r time.Time, exists bool) {
+	v := m.revalidated_at
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// OldRevalidatedAt returns the old "revalidated_at" field's value of the NarInfo entity.
+// If the NarInfo object wasn't provided to the builder, the object is fetched from the database.
+// An error is returned if the mutation operation is not UpdateOne, or the database query fails.
+func (m *NarInfoMutation) OldRevalidatedAt(ctx context.Context) (v *time.Time, err error) {
+	if !m.op.Is(OpUpdateOne) {
+		return v, errors.New("OldRevalidatedAt is only allowed on UpdateOne operations")
+	}
+	if m.id == nil || m.oldValue == nil {
+		return v, errors.New("OldRevalidatedAt requires an ID field in the mutation")
+	}
+	oldValue, err := m.oldValue(ctx)
+	if err != nil {
+		return v, fmt.Errorf("querying old value for OldRevalidatedAt: %w", err)
+	}
+	return oldValue.RevalidatedAt, nil
+}
+
+// ClearRevalidatedAt clears the value of the "revalidated_at" field.
+func (m *NarInfoMutation) ClearRevalidatedAt() {
+	m.revalidated_at = nil
+	m.clearedFields[narinfo.FieldRevalidatedAt] = struct{}{}
+}
+
+// RevalidatedAtCleared returns if the "revalidated_at" field was cleared in this mutation.
+func (m *NarInfoMutation) RevalidatedAtCleared() bool {
+	_, ok := m.clearedFields[narinfo.FieldRevalidatedAt]
+	return ok
+}
+
+// ResetRevalidatedAt resets all changes to the "revalidated_at" field.
+func (m *NarInfoMutation) ResetRevalidatedAt() {
+	m.revalidated_at = nil
+	delete(m.clearedFields, narinfo.FieldRevalidatedAt)
+}
+
 // AddReferenceIDs adds the "references" edge to the NarInfoReference entity by ids.
 func (m *NarInfoMutation) AddReferenceIDs(ids ...int) {
 	if m.references == nil {
@@ -5422,7 +6714,7 @@ func (m *NarInfoMutation) Type() string {
 // order to get all numeric fields that were incremented/decremented, call
 // AddedFields().
 func (m *NarInfoMutation) Fields() []string {
-	fields := make([]string, 0, 15)
+	fields := make([]string, 0, 16)
 	if m.created_at != nil {
 		fields = append(fields, narinfo.FieldCreatedAt)
 	}
@@ -5468,6 +6760,9 @@ func (m *NarInfoMutation) Fields() []string {
 	if m.last_accessed_at != nil {
 		fields = append(fields, narinfo.FieldLastAccessedAt)
 	}
+	if m.revalidated_at != nil {
+		fields = append(fields, narinfo.FieldRevalidatedAt)
+	}
 	return fields
 }
 
@@ -5506,6 +6801,8 @@ func (m *NarInfoMutation) Field(name string) (ent.Value, bool) {
 		return m.Ca()
 	case narinfo.FieldLastAccessedAt:
 		return m.LastAccessedAt()
+	case narinfo.FieldRevalidatedAt:
+		return m.RevalidatedAt()
 	}
 	return nil, false
 }
@@ -5545,6 +6842,8 @@ func (m *NarInfoMutation) OldField(ctx context.Context, name string) (ent.Value,
 		return m.OldCa(ctx)
 	case narinfo.FieldLastAccessedAt:
 		return m.OldLastAccessedAt(ctx)
+	case narinfo.FieldRevalidatedAt:
+		return m.OldRevalidatedAt(ctx)
 	}
 	return nil, fmt.Errorf("unknown NarInfo field %s", name)
 }
@@ -5659,6 +6958,13 @@ func (m *NarInfoMutation) SetField(name string, value ent.Value) error {
 		}
 		m.SetLastAccessedAt(v)
 		return nil
+	case narinfo.FieldRevalidatedAt:
+		v, ok := value.(time.Time)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.SetRevalidatedAt(v)
+		return nil
 	}
 	return fmt.Errorf("unknown NarInfo field %s", name)
 }
@@ -5755,6 +7061,9 @@ func (m *NarInfoMutation) ClearedFields() []string {
 	if m.FieldCleared(narinfo.FieldLastAccessedAt) {
 		fields = append(fields, narinfo.FieldLastAccessedAt)
 	}
+	if m.FieldCleared(narinfo.FieldRevalidatedAt) {
+		fields = append(fields, narinfo.FieldRevalidatedAt)
+	}
 	return fields
 }
 
@@ -5808,6 +7117,9 @@ func (m *NarInfoMutation) ClearField(name string) error {
 	case narinfo.FieldLastAccessedAt:
 		m.ClearLastAccessedAt()
 		return nil
+	case narinfo.FieldRevalidatedAt:
+		m.ClearRevalidatedAt()
+		return nil
 	}
 	return fmt.Errorf("unknown NarInfo nullable field %s", name)
 }
@@ -5861,6 +7173,9 @@ func (m *NarInfoMutation) ResetField(name string) error {
 	case narinfo.FieldLastAccessedAt:
 		m.ResetLastAccessedAt()
 		return nil
+	case narinfo.FieldRevalidatedAt:
+		m.ResetRevalidatedAt()
+		return nil
 	}
 	return fmt.Errorf("unknown NarInfo field %s", name)
 }
@@ -39,6 +39,14 @@ type NarFile struct {
 	BytesStoredAt *time.Time `json:"bytes_stored_at,omitempty"`
 	// DechunkResidueFlaggedAt holds the value of the "dechunk_residue_flagged_at" field.
 	DechunkResidueFlaggedAt *time.Time `json:"dechunk_residue_flagged_at,omitempty"`
+	// ChunkMinSize holds the value of the "chunk_min_size" field.
+	ChunkMinSize *uint32 `json:"chunk_min_size,omitempty"`
+	// ChunkAvgSize holds the value of the "chunk_avg_size" field.
+	ChunkAvgSize *uint32 `json:"chunk_avg_size,omitempty"`
+	// ChunkMaxSize holds the value of the "chunk_max_size" field.
+	ChunkMaxSize *uint32 `json:"chunk_max_size,omitempty"`
+	// StoredRaw holds the value of the "stored_raw" field.
+	StoredRaw bool `json:"stored_raw,omitempty"`
 	// LastAccessedAt holds the value of the "last_accessed_at" field.
 	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
 	// Edges holds the relations/edges for other nodes in the graph.
@@ -81,7 +89,9 @@ func (*NarFile) scanValues(columns []string) ([]any, error) {
 	values := make([]any, len(columns))
 	for i := range columns {
 		switch columns[i] {
-		case narfile.FieldID, narfile.FieldFileSize, narfile.FieldTotalChunks:
+		case narfile.FieldStoredRaw:
+			values[i] = new(sql.NullBool)
+		case narfile.FieldID, narfile.FieldFileSize, narfile.FieldTotalChunks, narfile.FieldChunkMinSize, narfile.FieldChunkAvgSize, narfile.FieldChunkMaxSize:
 			values[i] = new(sql.NullInt64)
 		case narfile.FieldHash, narfile.FieldCompression, narfile.FieldQuery:
 			values[i] = new(sql.NullString)
@@ -179,6 +189,33 @@ func (_m *NarFile) assignValues(columns []string, values []any) error {
 				_m.DechunkResidueFlaggedAt = new(time.Time)
 				*_m.DechunkResidueFlaggedAt = value.Time
 			}
+		case narfile.FieldChunkMinSize:
+			if value, ok := values[i].(*sql.NullInt64); !ok {
+				return fmt.Errorf("unexpected type %T for field chunk_min_size", values[i])
+			} else if value.Valid {
+				_m.ChunkMinSize = new(uint32)
+				*_m.ChunkMinSize = uint32(value.Int64)
+			}
+		case narfile.FieldChunkAvgSize:
+			if value, ok := values[i].(*sql.NullInt64); !ok {
+				return fmt.Errorf("unexpected type %T for field chunk_avg_size", values[i])
+			} else if value.Valid {
+				_m.ChunkAvgSize = new(uint32)
+				*_m.ChunkAvgSize = uint32(value.Int64)
+			}
+		case narfile.FieldChunkMaxSize:
+			if value, ok := values[i].(*sql.NullInt64); !ok {
+				return fmt.Errorf("unexpected type %T for field chunk_max_size", values[i])
+			} else if value.Valid {
+				_m.ChunkMaxSize = new(uint32)
+				*_m.ChunkMaxSize = uint32(value.Int64)
+			}
+		case narfile.FieldStoredRaw:
+			if value, ok := values[i].(*sql.NullBool); !ok {
+				return fmt.Errorf("unexpected type %T for field stored_raw", values[i])
+			} else if value.Valid {
+				_m.StoredRaw = value.Bool
+			}
 		case narfile.FieldLastAccessedAt:
 			if value, ok := values[i].(*sql.NullTime); !ok {
 				return fmt.Errorf("unexpected type %T for field last_accessed_at", values[i])
@@ -275,6 +312,24 @@ func (_m *NarFile) String() string {
 		builder.WriteString(v.Format(time.ANSIC))
 	}
 	builder.WriteString(", ")
+	if v := _m.ChunkMinSize; v != nil {
+		builder.WriteString("chunk_min_size=")
+		builder.WriteString(fmt.Sprintf("%v", *v))
+	}
+	builder.WriteString(", ")
+	if v := _m.ChunkAvgSize; v != nil {
+		builder.WriteString("chunk_avg_size=")
+		builder.WriteString(fmt.Sprintf("%v", *v))
+	}
+	builder.WriteString(", ")
+	if v := _m.ChunkMaxSize; v != nil {
+		builder.WriteString("chunk_max_size=")
+		builder.WriteString(fmt.Sprintf("%v", *v))
+	}
+	builder.WriteString(", ")
+	builder.WriteString("stored_raw=")
+	builder.WriteString(fmt.Sprintf("%v", _m.StoredRaw))
+	builder.WriteString(", ")
 	if v := _m.LastAccessedAt; v != nil {
 		builder.WriteString("last_accessed_at=")
 		builder.WriteString(v.Format(time.ANSIC))
@@ -36,6 +36,14 @@ const (
 	FieldBytesStoredAt = "bytes_stored_at"
 	// FieldDechunkResidueFlaggedAt holds the string denoting the dechunk_residue_flagged_at field in the database.
 	FieldDechunkResidueFlaggedAt = "dechunk_residue_flagged_at"
+	// FieldChunkMinSize holds the string denoting the chunk_min_size field in the database.
+	FieldChunkMinSize = "chunk_min_size"
+	// FieldChunkAvgSize holds the string denoting the chunk_avg_size field in the database.
+	FieldChunkAvgSize = "chunk_avg_size"
+	// FieldChunkMaxSize holds the string denoting the chunk_max_size field in the database.
+	FieldChunkMaxSize = "chunk_max_size"
+	// FieldStoredRaw holds the string denoting the stored_raw field in the database.
+	FieldStoredRaw = "stored_raw"
 	// FieldLastAccessedAt holds the string denoting the last_accessed_at field in the database.
 	FieldLastAccessedAt = "last_accessed_at"
 	// EdgeNarInfoNarFiles holds the string denoting the nar_info_nar_files edge name in mutations.
@@ -74,6 +82,10 @@ var Columns = []string{
 	FieldVerifiedAt,
 	FieldBytesStoredAt,
 	FieldDechunkResidueFlaggedAt,
+	FieldChunkMinSize,
+	FieldChunkAvgSize,
+	FieldChunkMaxSize,
+	FieldStoredRaw,
 	FieldLastAccessedAt,
 }
 
@@ -98,6 +110,8 @@ var (
 	DefaultQuery string
 	// DefaultTotalChunks holds the default value on creation for the "total_chunks" field.
 	DefaultTotalChunks int64
+	// DefaultStoredRaw holds the default value on creation for the "stored_raw" field.
+	DefaultStoredRaw bool
 	// DefaultLastAccessedAt holds the default value on creation for the "last_accessed_at" field.
 	DefaultLastAccessedAt func() time.Time
 )
@@ -165,6 +179,26 @@ func ByDechunkResidueFlaggedAt(opts ...sql.OrderTermOption) OrderOption {
 	return sql.OrderByField(FieldDechunkResidueFlaggedAt, opts...).ToFunc()
 }
 
+// ByChunkMinSize orders the results by the chunk_min_size field.
+func ByChunkMinSize(opts ...sql.OrderTermOption) OrderOption {
+	return sql.OrderByField(FieldChunkMinSize, opts...).ToFunc()
+}
+
+// ByChunkAvgSize orders the results by the chunk_avg_size field.
+func ByChunkAvgSize(opts ...sql.OrderTermOption) OrderOption {
+	return sql.OrderByField(FieldChunkAvgSize, opts...).ToFunc()
+}
+
+// ByChunkMaxSize orders the results by the chunk_max_size field.
+func ByChunkMaxSize(opts ...sql.OrderTermOption) OrderOption {
+	return sql.OrderByField(FieldChunkMaxSize, opts...).ToFunc()
+}
+
+// ByStoredRaw orders the results by the stored_raw field.
+func ByStoredRaw(opts ...sql.OrderTermOption) OrderOption {
+	return sql.OrderByField(FieldStoredRaw, opts...).ToFunc()
+}
+
 // ByLastAccessedAt orders the results by the last_accessed_at field.
 func ByLastAccessedAt(opts ...sql.OrderTermOption) OrderOption {
 	return sql.OrderByField(FieldLastAccessedAt, opts...).ToFunc()
@@ -110,6 +110,26 @@ func DechunkResidueFlaggedAt(v time.Time) predicate.NarFile {
 	return predicate.NarFile(sql.FieldEQ(FieldDechunkResidueFlaggedAt, v))
 }
 
+// ChunkMinSize applies equality check predicate on the "chunk_min_size" field. It's identical to ChunkMinSizeEQ.
+func ChunkMinSize(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldEQ(FieldChunkMinSize, v))
+}
+
+// ChunkAvgSize applies equality check predicate on the "chunk_avg_size" field. It's identical to ChunkAvgSizeEQ.
+func ChunkAvgSize(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldEQ(FieldChunkAvgSize, v))
+}
+
+// ChunkMaxSize applies equality check predicate on the "chunk_max_size" field. It's identical to ChunkMaxSizeEQ.
+func ChunkMaxSize(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldEQ(FieldChunkMaxSize, v))
+}
+
+// StoredRaw applies equality check predicate on the "stored_raw" field. It's identical to StoredRawEQ.
+func StoredRaw(v bool) predicate.NarFile {
+	return predicate.NarFile(sql.FieldEQ(FieldStoredRaw, v))
+}
+
 // LastAccessedAt applies equality check predicate on the "last_accessed_at" field. It's identical to LastAccessedAtEQ.
 func LastAccessedAt(v time.Time) predicate.NarFile {
 	return predicate.NarFile(sql.FieldEQ(FieldLastAccessedAt, v))
@@ -680,6 +700,166 @@ func DechunkResidueFlaggedAtNotNil() predicate.NarFile {
 	return predicate.NarFile(sql.FieldNotNull(FieldDechunkResidueFlaggedAt))
 }
 
+// ChunkMinSizeEQ applies the EQ predicate on the "chunk_min_size" field.
+func ChunkMinSizeEQ(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldEQ(FieldChunkMinSize, v))
+}
+
+// ChunkMinSizeNEQ applies the NEQ predicate on the "chunk_min_size" field.
+func ChunkMinSizeNEQ(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldNEQ(FieldChunkMinSize, v))
+}
+
+// ChunkMinSizeIn applies the In predicate on the "chunk_min_size" field.
+func ChunkMinSizeIn(vs ...uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldIn(FieldChunkMinSize, vs...))
+}
+
+// ChunkMinSizeNotIn applies the NotIn predicate on the "chunk_min_size" field.
+func ChunkMinSizeNotIn(vs ...uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldNotIn(FieldChunkMinSize, vs...))
+}
+
+// ChunkMinSizeGT applies the GT predicate on the "chunk_min_size" field.
+func ChunkMinSizeGT(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldGT(FieldChunkMinSize, v))
+}
+
+// ChunkMinSizeGTE applies the GTE predicate on the "chunk_min_size" field.
+func ChunkMinSizeGTE(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldGTE(FieldChunkMinSize, v))
+}
+
+// ChunkMinSizeLT applies the LT predicate on the "chunk_min_size" field.
+func ChunkMinSizeLT(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldLT(FieldChunkMinSize, v))
+}
+
+// ChunkMinSizeLTE applies the LTE predicate on the "chunk_min_size" field.
+func ChunkMinSizeLTE(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldLTE(FieldChunkMinSize, v))
+}
+
+// ChunkMinSizeIsNil applies the IsNil predicate on the "chunk_min_size" field.
+func ChunkMinSizeIsNil() predicate.NarFile {
+	return predicate.NarFile(sql.FieldIsNull(FieldChunkMinSize))
+}
+
+// ChunkMinSizeNotNil applies the NotNil predicate on the "chunk_min_size" field.
+func ChunkMinSizeNotNil() predicate.NarFile {
+	return predicate.NarFile(sql.FieldNotNull(FieldChunkMinSize))
+}
+
+// ChunkAvgSizeEQ applies the EQ predicate on the "chunk_avg_size" field.
+func ChunkAvgSizeEQ(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldEQ(FieldChunkAvgSize, v))
+}
+
+// ChunkAvgSizeNEQ applies the NEQ predicate on the "chunk_avg_size" field.
+func ChunkAvgSizeNEQ(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldNEQ(FieldChunkAvgSize, v))
+}
+
+// ChunkAvgSizeIn applies the In predicate on the "chunk_avg_size" field.
+func ChunkAvgSizeIn(vs ...uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldIn(FieldChunkAvgSize, vs...))
+}
+
+// ChunkAvgSizeNotIn applies the NotIn predicate on the "chunk_avg_size" field.
+func ChunkAvgSizeNotIn(vs ...uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldNotIn(FieldChunkAvgSize, vs...))
+}
+
+// ChunkAvgSizeGT applies the GT predicate on the "chunk_avg_size" field.
+func ChunkAvgSizeGT(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldGT(FieldChunkAvgSize, v))
+}
+
+// ChunkAvgSizeGTE applies the GTE predicate on the "chunk_avg_size" field.
+func ChunkAvgSizeGTE(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldGTE(FieldChunkAvgSize, v))
+}
+
+// ChunkAvgSizeLT applies the LT predicate on the "chunk_avg_size" field.
+func ChunkAvgSizeLT(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldLT(FieldChunkAvgSize, v))
+}
+
+// ChunkAvgSizeLTE applies the LTE predicate on the "chunk_avg_size" field.
+func ChunkAvgSizeLTE(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldLTE(FieldChunkAvgSize, v))
+}
+
+// ChunkAvgSizeIsNil applies the IsNil predicate on the "chunk_avg_size" field.
+func ChunkAvgSizeIsNil() predicate.NarFile {
+	return predicate.NarFile(sql.FieldIsNull(FieldChunkAvgSize))
+}
+
+// ChunkAvgSizeNotNil applies the NotNil predicate on the "chunk_avg_size" field.
+func ChunkAvgSizeNotNil() predicate.NarFile {
+	return predicate.NarFile(sql.FieldNotNull(FieldChunkAvgSize))
+}
+
+// ChunkMaxSizeEQ applies the EQ predicate on the "chunk_max_size" field.
+func ChunkMaxSizeEQ(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldEQ(FieldChunkMaxSize, v))
+}
+
+// ChunkMaxSizeNEQ applies the NEQ predicate on the "chunk_max_size" field.
+func ChunkMaxSizeNEQ(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldNEQ(FieldChunkMaxSize, v))
+}
+
+// ChunkMaxSizeIn applies the In predicate on the "chunk_max_size" field.
+func ChunkMaxSizeIn(vs ...uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldIn(FieldChunkMaxSize, vs...))
+}
+
+// ChunkMaxSizeNotIn applies the NotIn predicate on the "chunk_max_size" field.
+func ChunkMaxSizeNotIn(vs ...uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldNotIn(FieldChunkMaxSize, vs...))
+}
+
+// ChunkMaxSizeGT applies the GT predicate on the "chunk_max_size" field.
+func ChunkMaxSizeGT(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldGT(FieldChunkMaxSize, v))
+}
+
+// ChunkMaxSizeGTE applies the GTE predicate on the "chunk_max_size" field.
+func ChunkMaxSizeGTE(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldGTE(FieldChunkMaxSize, v))
+}
+
+// ChunkMaxSizeLT applies the LT predicate on the "chunk_max_size" field.
+func ChunkMaxSizeLT(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldLT(FieldChunkMaxSize, v))
+}
+
+// ChunkMaxSizeLTE applies the LTE predicate on the "chunk_max_size" field.
+func ChunkMaxSizeLTE(v uint32) predicate.NarFile {
+	return predicate.NarFile(sql.FieldLTE(FieldChunkMaxSize, v))
+}
+
+// ChunkMaxSizeIsNil applies the IsNil predicate on the "chunk_max_size" field.
+func ChunkMaxSizeIsNil() predicate.NarFile {
+	return predicate.NarFile(sql.FieldIsNull(FieldChunkMaxSize))
+}
+
+// ChunkMaxSizeNotNil applies the NotNil predicate on the "chunk_max_size" field.
+func ChunkMaxSizeNotNil() predicate.NarFile {
+	return predicate.NarFile(sql.FieldNotNull(FieldChunkMaxSize))
+}
+
+// StoredRawEQ applies the EQ predicate on the "stored_raw" field.
+func StoredRawEQ(v bool) predicate.NarFile {
+	return predicate.NarFile(sql.FieldEQ(FieldStoredRaw, v))
+}
+
+// StoredRawNEQ applies the NEQ predicate on the "stored_raw" field.
+func StoredRawNEQ(v bool) predicate.NarFile {
+	return predicate.NarFile(sql.FieldNEQ(FieldStoredRaw, v))
+}
+
 // LastAccessedAtEQ applies the EQ predicate on the "last_accessed_at" field.
 func LastAccessedAtEQ(v time.Time) predicate.NarFile {
 	return predicate.NarFile(sql.FieldEQ(FieldLastAccessedAt, v))
@@ -162,6 +162,62 @@ func (_c *NarFileCreate) SetNillableDechunkResidueFlaggedAt(v *time.Time) *NarFi
 	return _c
 }
 
+// SetChunkMinSize sets the "chunk_min_size" field.
+func (_c *NarFileCreate) SetChunkMinSize(v uint32) *NarFileCreate {
+	_c.mutation.SetChunkMinSize(v)
+	return _c
+}
+
+// SetNillableChunkMinSize sets the "chunk_min_size" field if the given value is not nil.
+func (_c *NarFileCreate) SetNillableChunkMinSize(v *uint32) *NarFileCreate {
+	if v != nil {
+		_c.SetChunkMinSize(*v)
+	}
+	return _c
+}
+
+// SetChunkAvgSize sets the "chunk_avg_size" field.
+func (_c *NarFileCreate) SetChunkAvgSize(v uint32) *NarFileCreate {
+	_c.mutation.SetChunkAvgSize(v)
+	return _c
+}
+
+// SetNillableChunkAvgSize sets the "chunk_avg_size" field if the given value is not nil.
+func (_c *NarFileCreate) SetNillableChunkAvgSize(v *uint32) *NarFileCreate {
+	if v != nil {
+		_c.SetChunkAvgSize(*v)
+	}
+	return _c
+}
+
+// SetChunkMaxSize sets the "chunk_max_size" field.
+func (_c *NarFileCreate) SetChunkMaxSize(v uint32) *NarFileCreate {
+	_c.mutation.SetChunkMaxSize(v)
+	return _c
+}
+
+// SetNillableChunkMaxSize sets the "chunk_max_size" field if the given value is not nil.
+func (_c *NarFileCreate) SetNillableChunkMaxSize(v *uint32) *NarFileCreate {
+	if v != nil {
+		_c.SetChunkMaxSize(*v)
+	}
+	return _c
+}
+
+// SetStoredRaw sets the "stored_raw" field.
+func (_c *NarFileCreate) SetStoredRaw(v bool) *NarFileCreate {
+	_c.mutation.SetStoredRaw(v)
+	return _c
+}
+
+// SetNillableStoredRaw sets the "stored_raw" field if the given value is not nil.
+func (_c *NarFileCreate) SetNillableStoredRaw(v *bool) *NarFileCreate {
+	if v != nil {
+		_c.SetStoredRaw(*v)
+	}
+	return _c
+}
+
 // SetLastAccessedAt sets the "last_accessed_at" field.
 func (_c *NarFileCreate) SetLastAccessedAt(v time.Time) *NarFileCreate {
 	_c.mutation.SetLastAccessedAt(v)
@@ -257,6 +313,10 @@ func (_c *NarFileCreate) defaults() {
 		v := narfile.DefaultTotalChunks
 		_c.mutation.SetTotalChunks(v)
 	}
+	if _, ok := _c.mutation.StoredRaw(); !ok {
+		v := narfile.DefaultStoredRaw
+		_c.mutation.SetStoredRaw(v)
+	}
 	if _, ok := _c.mutation.LastAccessedAt(); !ok {
 		v := narfile.DefaultLastAccessedAt()
 		_c.mutation.SetLastAccessedAt(v)
@@ -288,6 +348,9 @@ func (_c *NarFileCreate) check() error {
 	if _, ok := _c.mutation.TotalChunks(); !ok {
 		return &ValidationError{Name: "total_chunks", err: errors.New(`ent: missing required field "NarFile.total_chunks"`)}
 	}
+	if _, ok := _c.mutation.StoredRaw(); !ok {
+		return &ValidationError{Name: "stored_raw", err: errors.New(`ent: missing required field "NarFile.stored_raw"`)}
+	}
 	return nil
 }
 
@@ -359,6 +422,22 @@ func (_c *NarFileCreate) createSpec() (*NarFile, *sqlgraph.CreateSpec) {
 		_spec.SetField(narfile.FieldDechunkResidueFlaggedAt, field.TypeTime, value)
 		_node.DechunkResidueFlaggedAt = &value
 	}
+	if value, ok := _c.mutation.ChunkMinSize(); ok {
+		_spec.SetField(narfile.FieldChunkMinSize, field.TypeUint32, value)
+		_node.ChunkMinSize = &value
+	}
+	if value, ok := _c.mutation.ChunkAvgSize(); ok {
+		_spec.SetField(narfile.FieldChunkAvgSize, field.TypeUint32, value)
+		_node.ChunkAvgSize = &value
+	}
+	if value, ok := _c.mutation.ChunkMaxSize(); ok {
+		_spec.SetField(narfile.FieldChunkMaxSize, field.TypeUint32, value)
+		_node.ChunkMaxSize = &value
+	}
+	if value, ok := _c.mutation.StoredRaw(); ok {
+		_spec.SetField(narfile.FieldStoredRaw, field.TypeBool, value)
+		_node.StoredRaw = value
+	}
 	if value, ok := _c.mutation.LastAccessedAt(); ok {
 		_spec.SetField(narfile.FieldLastAccessedAt, field.TypeTime, value)
 		_node.LastAccessedAt = &value
@@ -609,6 +688,90 @@ func (u *NarFileUpsert) ClearDechunkResidueFlaggedAt() *NarFileUpsert {
 	return u
 }
 
+// SetChunkMinSize sets the "chunk_min_size" field.
+func (u *NarFileUpsert) SetChunkMinSize(v uint32) *NarFileUpsert {
+	u.Set(narfile.FieldChunkMinSize, v)
+	return u
+}
+
+// UpdateChunkMinSize sets the "chunk_min_size" field to the value that was provided on create.
+func (u *NarFileUpsert) UpdateChunkMinSize() *NarFileUpsert {
+	u.SetExcluded(narfile.FieldChunkMinSize)
+	return u
+}
+
+// AddChunkMinSize adds v to the "chunk_min_size" field.
+func (u *NarFileUpsert) AddChunkMinSize(v uint32) *NarFileUpsert {
+	u.Add(narfile.FieldChunkMinSize, v)
+	return u
+}
+
+// ClearChunkMinSize clears the value of the "chunk_min_size" field.
+func (u *NarFileUpsert) ClearChunkMinSize() *NarFileUpsert {
+	u.SetNull(narfile.FieldChunkMinSize)
+	return u
+}
+
+// SetChunkAvgSize sets the "chunk_avg_size" field.
+func (u *NarFileUpsert) SetChunkAvgSize(v uint32) *NarFileUpsert {
+	u.Set(narfile.FieldChunkAvgSize, v)
+	return u
+}
+
+// UpdateChunkAvgSize sets the "chunk_avg_size" field to the value that was provided on create.
+func (u *NarFileUpsert) UpdateChunkAvgSize() *NarFileUpsert {
+	u.SetExcluded(narfile.FieldChunkAvgSize)
+	return u
+}
+
+// AddChunkAvgSize adds v to the "chunk_avg_size" field.
+func (u *NarFileUpsert) AddChunkAvgSize(v uint32) *NarFileUpsert {
+	u.Add(narfile.FieldChunkAvgSize, v)
+	return u
+}
+
+// ClearChunkAvgSize clears the value of the "chunk_avg_size" field.
+func (u *NarFileUpsert) ClearChunkAvgSize() *NarFileUpsert {
+	u.SetNull(narfile.FieldChunkAvgSize)
+	return u
+}
+
+// SetChunkMaxSize sets the "chunk_max_size" field.
+func (u *NarFileUpsert) SetChunkMaxSize(v uint32) *NarFileUpsert {
+	u.Set(narfile.FieldChunkMaxSize, v)
+	return u
+}
+
+// UpdateChunkMaxSize sets the "chunk_max_size" field to the value that was provided on create.
+func (u *NarFileUpsert) UpdateChunkMaxSize() *NarFileUpsert {
+	u.SetExcluded(narfile.FieldChunkMaxSize)
+	return u
+}
+
+// AddChunkMaxSize adds v to the "chunk_max_size" field.
+func (u *NarFileUpsert) AddChunkMaxSize(v uint32) *NarFileUpsert {
+	u.Add(narfile.FieldChunkMaxSize, v)
+	return u
+}
+
+// ClearChunkMaxSize clears the value of the "chunk_max_size" field.
+func (u *NarFileUpsert) ClearChunkMaxSize() *NarFileUpsert {
+	u.SetNull(narfile.FieldChunkMaxSize)
+	return u
+}
+
+// SetStoredRaw sets the "stored_raw" field.
+func (u *NarFileUpsert) SetStoredRaw(v bool) *NarFileUpsert {
+	u.Set(narfile.FieldStoredRaw, v)
+	return u
+}
+
+// UpdateStoredRaw sets the "stored_raw" field to the value that was provided on create.
+func (u *NarFileUpsert) UpdateStoredRaw() *NarFileUpsert {
+	u.SetExcluded(narfile.FieldStoredRaw)
+	return u
+}
+
 // SetLastAccessedAt sets the "last_accessed_at" field.
 func (u *NarFileUpsert) SetLastAccessedAt(v time.Time) *NarFileUpsert {
 	u.Set(narfile.FieldLastAccessedAt, v)
@@ -861,6 +1024,104 @@ func (u *NarFileUpsertOne) ClearDechunkResidueFlaggedAt() *NarFileUpsertOne {
 	})
 }
 
+// SetChunkMinSize sets the "chunk_min_size" field.
+func (u *NarFileUpsertOne) SetChunkMinSize(v uint32) *NarFileUpsertOne {
+	return u.Update(func(s *NarFileUpsert) {
+		s.SetChunkMinSize(v)
+	})
+}
+
+// AddChunkMinSize adds v to the "chunk_min_size" field.
+func (u *NarFileUpsertOne) AddChunkMinSize(v uint32) *NarFileUpsertOne {
+	return u.Update(func(s *NarFileUpsert) {
+		s.AddChunkMinSize(v)
+	})
+}
+
+// UpdateChunkMinSize sets the "chunk_min_size" field to the value that was provided on create.
+func (u *NarFileUpsertOne) UpdateChunkMinSize() *NarFileUpsertOne {
+	return u.Update(func(s *NarFileUpsert) {
+		s.UpdateChunkMinSize()
+	})
+}
+
+// ClearChunkMinSize clears the value of the "chunk_min_size" field.
+func (u *NarFileUpsertOne) ClearChunkMinSize() *NarFileUpsertOne {
+	return u.Update(func(s *NarFileUpsert) {
+		s.ClearChunkMinSize()
+	})
+}
+
+// SetChunkAvgSize sets the "chunk_avg_size" field.
+func (u *NarFileUpsertOne) SetChunkAvgSize(v uint32) *NarFileUpsertOne {
+	return u.Update(func(s *NarFileUpsert) {
+		s.SetChunkAvgSize(v)
+	})
+}
+
+// AddChunkAvgSize adds v to the "chunk_avg_size" field.
+func (u *NarFileUpsertOne) AddChunkAvgSize(v uint32) *NarFileUpsertOne {
+	return u.Update(func(s *NarFileUpsert) {
+		s.AddChunkAvgSize(v)
+	})
+}
+
+// UpdateChunkAvgSize sets the "chunk_avg_size" field to the value that was provided on create.
+func (u *NarFileUpsertOne) UpdateChunkAvgSize() *NarFileUpsertOne {
+	return u.Update(func(s *NarFileUpsert) {
+		s.UpdateChunkAvgSize()
+	})
+}
+
+// ClearChunkAvgSize clears the value of the "chunk_avg_size" field.
+func (u *NarFileUpsertOne) ClearChunkAvgSize() *NarFileUpsertOne {
+	return u.Update(func(s *NarFileUpsert) {
+		s.ClearChunkAvgSize()
+	})
+}
+
+// SetChunkMaxSize sets the "chunk_max_size" field.
+func (u *NarFileUpsertOne) SetChunkMaxSize(v uint32) *NarFileUpsertOne {
+	return u.Update(func(s *NarFileUpsert) {
+		s.SetChunkMaxSize(v)
+	})
+}
+
+// AddChunkMaxSize adds v to the "chunk_max_size" field.
+func (u *NarFileUpsertOne) AddChunkMaxSize(v uint32) *NarFileUpsertOne {
+	return u.Update(func(s *NarFileUpsert) {
+		s.AddChunkMaxSize(v)
+	})
+}
+
+// UpdateChunkMaxSize sets the "chunk_max_size" field to the value that was provided on create.
+func (u *NarFileUpsertOne) UpdateChunkMaxSize() *NarFileUpsertOne {
+	return u.Update(func(s *NarFileUpsert) {
+		s.UpdateChunkMaxSize()
+	})
+}
+
+// ClearChunkMaxSize clears the value of the "chunk_max_size" field.
+func (u *NarFileUpsertOne) ClearChunkMaxSize() *NarFileUpsertOne {
+	return u.Update(func(s *NarFileUpsert) {
+		s.ClearChunkMaxSize()
+	})
+}
+
+// SetStoredRaw sets the "stored_raw" field.
+func (u *NarFileUpsertOne) SetStoredRaw(v bool) *NarFileUpsertOne {
+	return u.Update(func(s *NarFileUpsert) {
+		s.SetStoredRaw(v)
+	})
+}
+
+// UpdateStoredRaw sets the "stored_raw" field to the value that was provided on create.
+func (u *NarFileUpsertOne) UpdateStoredRaw() *NarFileUpsertOne {
+	return u.Update(func(s *NarFileUpsert) {
+		s.UpdateStoredRaw()
+	})
+}
+
 // SetLastAccessedAt sets the "last_accessed_at" field.
 func (u *NarFileUpsertOne) SetLastAccessedAt(v time.Time) *NarFileUpsertOne {
 	return u.Update(func(s *NarFileUpsert) {
@@ -1282,6 +1543,104 @@ func (u *NarFileUpsertBulk) ClearDechunkResidueFlaggedAt() *NarFileUpsertBulk {
 	})
 }
 
+// SetChunkMinSize sets the "chunk_min_size" field.
+func (u *NarFileUpsertBulk) SetChunkMinSize(v uint32) *NarFileUpsertBulk {
+	return u.Update(func(s *NarFileUpsert) {
+		s.SetChunkMinSize(v)
+	})
+}
+
+// AddChunkMinSize adds v to the "chunk_min_size" field.
+func (u *NarFileUpsertBulk) AddChunkMinSize(v uint32) *NarFileUpsertBulk {
+	return u.Update(func(s *NarFileUpsert) {
+		s.AddChunkMinSize(v)
+	})
+}
+
+// UpdateChunkMinSize sets the "chunk_min_size" field to the value that was provided on create.
+func (u *NarFileUpsertBulk) UpdateChunkMinSize() *NarFileUpsertBulk {
+	return u.Update(func(s *NarFileUpsert) {
+		s.UpdateChunkMinSize()
+	})
+}
+
+// ClearChunkMinSize clears the value of the "chunk_min_size" field.
+func (u *NarFileUpsertBulk) ClearChunkMinSize() *NarFileUpsertBulk {
+	return u.Update(func(s *NarFileUpsert) {
+		s.ClearChunkMinSize()
+	})
+}
+
+// SetChunkAvgSize sets the "chunk_avg_size" field.
+func (u *NarFileUpsertBulk) SetChunkAvgSize(v uint32) *NarFileUpsertBulk {
+	return u.Update(func(s *NarFileUpsert) {
+		s.SetChunkAvgSize(v)
+	})
+}
+
+// AddChunkAvgSize adds v to the "chunk_avg_size" field.
+func (u *NarFileUpsertBulk) AddChunkAvgSize(v uint32) *NarFileUpsertBulk {
+	return u.Update(func(s *NarFileUpsert) {
+		s.AddChunkAvgSize(v)
+	})
+}
+
+// UpdateChunkAvgSize sets the "chunk_avg_size" field to the value that was provided on create.
+func (u *NarFileUpsertBulk) UpdateChunkAvgSize() *NarFileUpsertBulk {
+	return u.Update(func(s *NarFileUpsert) {
+		s.UpdateChunkAvgSize()
+	})
+}
+
+// ClearChunkAvgSize clears the value of the "chunk_avg_size" field.
+func (u *NarFileUpsertBulk) ClearChunkAvgSize() *NarFileUpsertBulk {
+	return u.Update(func(s *NarFileUpsert) {
+		s.ClearChunkAvgSize()
+	})
+}
+
+// SetChunkMaxSize sets the "chunk_max_size" field.
+func (u *NarFileUpsertBulk) SetChunkMaxSize(v uint32) *NarFileUpsertBulk {
+	return u.Update(func(s *NarFileUpsert) {
+		s.SetChunkMaxSize(v)
+	})
+}
+
+// AddChunkMaxSize adds v to the "chunk_max_size" field.
+func (u *NarFileUpsertBulk) AddChunkMaxSize(v uint32) *NarFileUpsertBulk {
+	return u.Update(func(s *NarFileUpsert) {
+		s.AddChunkMaxSize(v)
+	})
+}
+
+// UpdateChunkMaxSize sets the "chunk_max_size" field to the value that was provided on create.
+func (u *NarFileUpsertBulk) UpdateChunkMaxSize() *NarFileUpsertBulk {
+	return u.Update(func(s *NarFileUpsert) {
+		s.UpdateChunkMaxSize()
+	})
+}
+
+// ClearChunkMaxSize clears the value of the "chunk_max_size" field.
+func (u *NarFileUpsertBulk) ClearChunkMaxSize() *NarFileUpsertBulk {
+	return u.Update(func(s *NarFileUpsert) {
+		s.ClearChunkMaxSize()
+	})
+}
+
+// SetStoredRaw sets the "stored_raw" field.
+func (u *NarFileUpsertBulk) SetStoredRaw(v bool) *NarFileUpsertBulk {
+	return u.Update(func(s *NarFileUpsert) {
+		s.SetStoredRaw(v)
+	})
+}
+
+// UpdateStoredRaw sets the "stored_raw" field to the value that was provided on create.
+func (u *NarFileUpsertBulk) UpdateStoredRaw() *NarFileUpsertBulk {
+	return u.Update(func(s *NarFileUpsert) {
+		s.UpdateStoredRaw()
+	})
+}
+
 // SetLastAccessedAt sets the "last_accessed_at" field.
 func (u *NarFileUpsertBulk) SetLastAccessedAt(v time.Time) *NarFileUpsertBulk {
 	return u.Update(func(s *NarFileUpsert) {
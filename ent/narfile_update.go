@@ -214,6 +214,101 @@ func (_u *NarFileUpdate) ClearDechunkResidueFlaggedAt() *NarFileUpdate {
 	return _u
 }
 
+// SetChunkMinSize sets the "chunk_min_size" field.
+func (_u *NarFileUpdate) SetChunkMinSize(v uint32) *NarFileUpdate {
+	_u.mutation.ResetChunkMinSize()
+	_u.mutation.SetChunkMinSize(v)
+	return _u
+}
+
+// SetNillableChunkMinSize sets the "chunk_min_size" field if the given value is not nil.
+func (_u *NarFileUpdate) SetNillableChunkMinSize(v *uint32) *NarFileUpdate {
+	if v != nil {
+		_u.SetChunkMinSize(*v)
+	}
+	return _u
+}
+
+// AddChunkMinSize adds value to the "chunk_min_size" field.
+func (_u *NarFileUpdate) AddChunkMinSize(v int32) *NarFileUpdate {
+	_u.mutation.AddChunkMinSize(v)
+	return _u
+}
+
+// ClearChunkMinSize clears the value of the "chunk_min_size" field.
+func (_u *NarFileUpdate) ClearChunkMinSize() *NarFileUpdate {
+	_u.mutation.ClearChunkMinSize()
+	return _u
+}
+
+// SetChunkAvgSize sets the "chunk_avg_size" field.
+func (_u *NarFileUpdate) SetChunkAvgSize(v uint32) *NarFileUpdate {
+	_u.mutation.ResetChunkAvgSize()
+	_u.mutation.SetChunkAvgSize(v)
+	return _u
+}
+
+// SetNillableChunkAvgSize sets the "chunk_avg_size" field if the given value is not nil.
+func (_u *NarFileUpdate) SetNillableChunkAvgSize(v *uint32) *NarFileUpdate {
+	if v != nil {
+		_u.SetChunkAvgSize(*v)
+	}
+	return _u
+}
+
+// AddChunkAvgSize adds value to the "chunk_avg_size" field.
+func (_u *NarFileUpdate) AddChunkAvgSize(v int32) *NarFileUpdate {
+	_u.mutation.AddChunkAvgSize(v)
+	return _u
+}
+
+// ClearChunkAvgSize clears the value of the "chunk_avg_size" field.
+func (_u *NarFileUpdate) ClearChunkAvgSize() *NarFileUpdate {
+	_u.mutation.ClearChunkAvgSize()
+	return _u
+}
+
+// SetChunkMaxSize sets the "chunk_max_size" field.
+func (_u *NarFileUpdate) SetChunkMaxSize(v uint32) *NarFileUpdate {
+	_u.mutation.ResetChunkMaxSize()
+	_u.mutation.SetChunkMaxSize(v)
+	return _u
+}
+
+// SetNillableChunkMaxSize sets the "chunk_max_size" field if the given value is not nil.
+func (_u *NarFileUpdate) SetNillableChunkMaxSize(v *uint32) *NarFileUpdate {
+	if v != nil {
+		_u.SetChunkMaxSize(*v)
+	}
+	return _u
+}
+
+// AddChunkMaxSize adds value to the "chunk_max_size" field.
+func (_u *NarFileUpdate) AddChunkMaxSize(v int32) *NarFileUpdate {
+	_u.mutation.AddChunkMaxSize(v)
+	return _u
+}
+
+// ClearChunkMaxSize clears the value of the "chunk_max_size" field.
+func (_u *NarFileUpdate) ClearChunkMaxSize() *NarFileUpdate {
+	_u.mutation.ClearChunkMaxSize()
+	return _u
+}
+
+// SetStoredRaw sets the "stored_raw" field.
+func (_u *NarFileUpdate) SetStoredRaw(v bool) *NarFileUpdate {
+	_u.mutation.SetStoredRaw(v)
+	return _u
+}
+
+// SetNillableStoredRaw sets the "stored_raw" field if the given value is not nil.
+func (_u *NarFileUpdate) SetNillableStoredRaw(v *bool) *NarFileUpdate {
+	if v != nil {
+		_u.SetStoredRaw(*v)
+	}
+	return _u
+}
+
 // SetLastAccessedAt sets the "last_accessed_at" field.
 func (_u *NarFileUpdate) SetLastAccessedAt(v time.Time) *NarFileUpdate {
 	_u.mutation.SetLastAccessedAt(v)
@@ -411,6 +506,36 @@ func (_u *NarFileUpdate) sqlSave(ctx context.Context) (_node int, err error) {
 	if _u.mutation.DechunkResidueFlaggedAtCleared() {
 		_spec.ClearField(narfile.FieldDechunkResidueFlaggedAt, field.TypeTime)
 	}
+	if value, ok := _u.mutation.ChunkMinSize(); ok {
+		_spec.SetField(narfile.FieldChunkMinSize, field.TypeUint32, value)
+	}
+	if value, ok := _u.mutation.AddedChunkMinSize(); ok {
+		_spec.AddField(narfile.FieldChunkMinSize, field.TypeUint32, value)
+	}
+	if _u.mutation.ChunkMinSizeCleared() {
+		_spec.ClearField(narfile.FieldChunkMinSize, field.TypeUint32)
+	}
+	if value, ok := _u.mutation.ChunkAvgSize(); ok {
+		_spec.SetField(narfile.FieldChunkAvgSize, field.TypeUint32, value)
+	}
+	if value, ok := _u.mutation.AddedChunkAvgSize(); ok {
+		_spec.AddField(narfile.FieldChunkAvgSize, field.TypeUint32, value)
+	}
+	if _u.mutation.ChunkAvgSizeCleared() {
+		_spec.ClearField(narfile.FieldChunkAvgSize, field.TypeUint32)
+	}
+	if value, ok := _u.mutation.ChunkMaxSize(); ok {
+		_spec.SetField(narfile.FieldChunkMaxSize, field.TypeUint32, value)
+	}
+	if value, ok := _u.mutation.AddedChunkMaxSize(); ok {
+		_spec.AddField(narfile.FieldChunkMaxSize, field.TypeUint32, value)
+	}
+	if _u.mutation.ChunkMaxSizeCleared() {
+		_spec.ClearField(narfile.FieldChunkMaxSize, field.TypeUint32)
+	}
+	if value, ok := _u.mutation.StoredRaw(); ok {
+		_spec.SetField(narfile.FieldStoredRaw, field.TypeBool, value)
+	}
 	if value, ok := _u.mutation.LastAccessedAt(); ok {
 		_spec.SetField(narfile.FieldLastAccessedAt, field.TypeTime, value)
 	}
@@ -711,6 +836,101 @@ func (_u *NarFileUpdateOne) ClearDechunkResidueFlaggedAt() *NarFileUpdateOne {
 	return _u
 }
 
+// SetChunkMinSize sets the "chunk_min_size" field.
+func (_u *NarFileUpdateOne) SetChunkMinSize(v uint32) *NarFileUpdateOne {
+	_u.mutation.ResetChunkMinSize()
+	_u.mutation.SetChunkMinSize(v)
+	return _u
+}
+
+// SetNillableChunkMinSize sets the "chunk_min_size" field if the given value is not nil.
+func (_u *NarFileUpdateOne) SetNillableChunkMinSize(v *uint32) *NarFileUpdateOne {
+	if v != nil {
+		_u.SetChunkMinSize(*v)
+	}
+	return _u
+}
+
+// AddChunkMinSize adds value to the "chunk_min_size" field.
+func (_u *NarFileUpdateOne) AddChunkMinSize(v int32) *NarFileUpdateOne {
+	_u.mutation.AddChunkMinSize(v)
+	return _u
+}
+
+// ClearChunkMinSize clears the value of the "chunk_min_size" field.
+func (_u *NarFileUpdateOne) ClearChunkMinSize() *NarFileUpdateOne {
+	_u.mutation.ClearChunkMinSize()
+	return _u
+}
+
+// SetChunkAvgSize sets the "chunk_avg_size" field.
+func (_u *NarFileUpdateOne) SetChunkAvgSize(v uint32) *NarFileUpdateOne {
+	_u.mutation.ResetChunkAvgSize()
+	_u.mutation.SetChunkAvgSize(v)
+	return _u
+}
+
+// SetNillableChunkAvgSize sets the "chunk_avg_size" field if the given value is not nil.
+func (_u *NarFileUpdateOne) SetNillableChunkAvgSize(v *uint32) *NarFileUpdateOne {
+	if v != nil {
+		_u.SetChunkAvgSize(*v)
+	}
+	return _u
+}
+
+// AddChunkAvgSize adds value to the "chunk_avg_size" field.
+func (_u *NarFileUpdateOne) AddChunkAvgSize(v int32) *NarFileUpdateOne {
+	_u.mutation.AddChunkAvgSize(v)
+	return _u
+}
+
+// ClearChunkAvgSize clears the value of the "chunk_avg_size" field.
+func (_u *NarFileUpdateOne) ClearChunkAvgSize() *NarFileUpdateOne {
+	_u.mutation.ClearChunkAvgSize()
+	return _u
+}
+
+// SetChunkMaxSize sets the "chunk_max_size" field.
+func (_u *NarFileUpdateOne) SetChunkMaxSize(v uint32) *NarFileUpdateOne {
+	_u.mutation.ResetChunkMaxSize()
+	_u.mutation.SetChunkMaxSize(v)
+	return _u
+}
+
+// SetNillableChunkMaxSize sets the "chunk_max_size" field if the given value is not nil.
+func (_u *NarFileUpdateOne) SetNillableChunkMaxSize(v *uint32) *NarFileUpdateOne {
+	if v != nil {
+		_u.SetChunkMaxSize(*v)
+	}
+	return _u
+}
+
+// AddChunkMaxSize adds value to the "chunk_max_size" field.
+func (_u *NarFileUpdateOne) AddChunkMaxSize(v int32) *NarFileUpdateOne {
+	_u.mutation.AddChunkMaxSize(v)
+	return _u
+}
+
+// ClearChunkMaxSize clears the value of the "chunk_max_size" field.
+func (_u *NarFileUpdateOne) ClearChunkMaxSize() *NarFileUpdateOne {
+	_u.mutation.ClearChunkMaxSize()
+	return _u
+}
+
+// SetStoredRaw sets the "stored_raw" field.
+func (_u *NarFileUpdateOne) SetStoredRaw(v bool) *NarFileUpdateOne {
+	_u.mutation.SetStoredRaw(v)
+	return _u
+}
+
+// SetNillableStoredRaw sets the "stored_raw" field if the given value is not nil.
+func (_u *NarFileUpdateOne) SetNillableStoredRaw(v *bool) *NarFileUpdateOne {
+	if v != nil {
+		_u.SetStoredRaw(*v)
+	}
+	return _u
+}
+
 // SetLastAccessedAt sets the "last_accessed_at" field.
 func (_u *NarFileUpdateOne) SetLastAccessedAt(v time.Time) *NarFileUpdateOne {
 	_u.mutation.SetLastAccessedAt(v)
@@ -938,6 +1158,36 @@ func (_u *NarFileUpdateOne) sqlSave(ctx context.Context) (_node *NarFile, err er
 	if _u.mutation.DechunkResidueFlaggedAtCleared() {
 		_spec.ClearField(narfile.FieldDechunkResidueFlaggedAt, field.TypeTime)
 	}
+	if value, ok := _u.mutation.ChunkMinSize(); ok {
+		_spec.SetField(narfile.FieldChunkMinSize, field.TypeUint32, value)
+	}
+	if value, ok := _u.mutation.AddedChunkMinSize(); ok {
+		_spec.AddField(narfile.FieldChunkMinSize, field.TypeUint32, value)
+	}
+	if _u.mutation.ChunkMinSizeCleared() {
+		_spec.ClearField(narfile.FieldChunkMinSize, field.TypeUint32)
+	}
+	if value, ok := _u.mutation.ChunkAvgSize(); ok {
+		_spec.SetField(narfile.FieldChunkAvgSize, field.TypeUint32, value)
+	}
+	if value, ok := _u.mutation.AddedChunkAvgSize(); ok {
+		_spec.AddField(narfile.FieldChunkAvgSize, field.TypeUint32, value)
+	}
+	if _u.mutation.ChunkAvgSizeCleared() {
+		_spec.ClearField(narfile.FieldChunkAvgSize, field.TypeUint32)
+	}
+	if value, ok := _u.mutation.ChunkMaxSize(); ok {
+		_spec.SetField(narfile.FieldChunkMaxSize, field.TypeUint32, value)
+	}
+	if value, ok := _u.mutation.AddedChunkMaxSize(); ok {
+		_spec.AddField(narfile.FieldChunkMaxSize, field.TypeUint32, value)
+	}
+	if _u.mutation.ChunkMaxSizeCleared() {
+		_spec.ClearField(narfile.FieldChunkMaxSize, field.TypeUint32)
+	}
+	if value, ok := _u.mutation.StoredRaw(); ok {
+		_spec.SetField(narfile.FieldStoredRaw, field.TypeBool, value)
+	}
 	if value, ok := _u.mutation.LastAccessedAt(); ok {
 		_spec.SetField(narfile.FieldLastAccessedAt, field.TypeTime, value)
 	}
@@ -47,6 +47,8 @@ type NarInfo struct {
 	Ca *string `json:"ca,omitempty"`
 	// LastAccessedAt holds the value of the "last_accessed_at" field.
 	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+	// RevalidatedAt holds the value of the "revalidated_at" field.
+	RevalidatedAt *time.Time `json:"revalidated_at,omitempty"`
 	// Edges holds the relations/edges for other nodes in the graph.
 	// The values are being populated by the NarInfoQuery when eager-loading is set.
 	Edges        NarInfoEdges `json:"edges"`
@@ -102,7 +104,7 @@ func (*NarInfo) scanValues(columns []string) ([]any, error) {
 			values[i] = new(sql.NullInt64)
 		case narinfo.FieldHash, narinfo.FieldStorePath, narinfo.FieldURL, narinfo.FieldUpstreamURL, narinfo.FieldCompression, narinfo.FieldFileHash, narinfo.FieldNarHash, narinfo.FieldDeriver, narinfo.FieldSystem, narinfo.FieldCa:
 			values[i] = new(sql.NullString)
-		case narinfo.FieldCreatedAt, narinfo.FieldUpdatedAt, narinfo.FieldLastAccessedAt:
+		case narinfo.FieldCreatedAt, narinfo.FieldUpdatedAt, narinfo.FieldLastAccessedAt, narinfo.FieldRevalidatedAt:
 			values[i] = new(sql.NullTime)
 		default:
 			values[i] = new(sql.UnknownType)
@@ -228,6 +230,13 @@ func (_m *NarInfo) assignValues(columns []string, values []any) error {
 				_m.LastAccessedAt = new(time.Time)
 				*_m.LastAccessedAt = value.Time
 			}
+		case narinfo.FieldRevalidatedAt:
+			if value, ok := values[i].(*sql.NullTime); !ok {
+				return fmt.Errorf("unexpected type %T for field revalidated_at", values[i])
+			} else if value.Valid {
+				_m.RevalidatedAt = new(time.Time)
+				*_m.RevalidatedAt = value.Time
+			}
 		default:
 			_m.selectValues.Set(columns[i], values[i])
 		}
@@ -349,6 +358,11 @@ func (_m *NarInfo) String() string {
 		builder.WriteString("last_accessed_at=")
 		builder.WriteString(v.Format(time.ANSIC))
 	}
+	builder.WriteString(", ")
+	if v := _m.RevalidatedAt; v != nil {
+		builder.WriteString("revalidated_at=")
+		builder.WriteString(v.Format(time.ANSIC))
+	}
 	builder.WriteByte(')')
 	return builder.String()
 }
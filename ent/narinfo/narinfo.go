@@ -44,6 +44,8 @@ const (
 	FieldCa = "ca"
 	// FieldLastAccessedAt holds the string denoting the last_accessed_at field in the database.
 	FieldLastAccessedAt = "last_accessed_at"
+	// FieldRevalidatedAt holds the string denoting the revalidated_at field in the database.
+	FieldRevalidatedAt = "revalidated_at"
 	// EdgeReferences holds the string denoting the references edge name in mutations.
 	EdgeReferences = "references"
 	// EdgeSignatures holds the string denoting the signatures edge name in mutations.
@@ -93,6 +95,7 @@ var Columns = []string{
 	FieldSystem,
 	FieldCa,
 	FieldLastAccessedAt,
+	FieldRevalidatedAt,
 }
 
 // ValidColumn reports if the column name is valid (part of the table columns).
@@ -197,6 +200,11 @@ func ByLastAccessedAt(opts ...sql.OrderTermOption) OrderOption {
 	return sql.OrderByField(FieldLastAccessedAt, opts...).ToFunc()
 }
 
+// ByRevalidatedAt orders the results by the revalidated_at field.
+func ByRevalidatedAt(opts ...sql.OrderTermOption) OrderOption {
+	return sql.OrderByField(FieldRevalidatedAt, opts...).ToFunc()
+}
+
 // ByReferencesCount orders the results by references count.
 func ByReferencesCount(opts ...sql.OrderTermOption) OrderOption {
 	return func(s *sql.Selector) {
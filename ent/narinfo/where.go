@@ -130,6 +130,11 @@ func LastAccessedAt(v time.Time) predicate.NarInfo {
 	return predicate.NarInfo(sql.FieldEQ(FieldLastAccessedAt, v))
 }
 
+// RevalidatedAt applies equality check predicate on the "revalidated_at" field. It's identical to RevalidatedAtEQ.
+func RevalidatedAt(v time.Time) predicate.NarInfo {
+	return predicate.NarInfo(sql.FieldEQ(FieldRevalidatedAt, v))
+}
+
 // CreatedAtEQ applies the EQ predicate on the "created_at" field.
 func CreatedAtEQ(v time.Time) predicate.NarInfo {
 	return predicate.NarInfo(sql.FieldEQ(FieldCreatedAt, v))
@@ -1110,6 +1115,56 @@ func LastAccessedAtNotNil() predicate.NarInfo {
 	return predicate.NarInfo(sql.FieldNotNull(FieldLastAccessedAt))
 }
 
+// RevalidatedAtEQ applies the EQ predicate on the "revalidated_at" field.
+func RevalidatedAtEQ(v time.Time) predicate.NarInfo {
+	return predicate.NarInfo(sql.FieldEQ(FieldRevalidatedAt, v))
+}
+
+// RevalidatedAtNEQ applies the NEQ predicate on the "revalidated_at" field.
+func RevalidatedAtNEQ(v time.Time) predicate.NarInfo {
+	return predicate.NarInfo(sql.FieldNEQ(FieldRevalidatedAt, v))
+}
+
+// RevalidatedAtIn applies the In predicate on the "revalidated_at" field.
+func RevalidatedAtIn(vs ...time.Time) predicate.NarInfo {
+	return predicate.NarInfo(sql.FieldIn(FieldRevalidatedAt, vs...))
+}
+
+// RevalidatedAtNotIn applies the NotIn predicate on the "revalidated_at" field.
+func RevalidatedAtNotIn(vs ...time.Time) predicate.NarInfo {
+	return predicate.NarInfo(sql.FieldNotIn(FieldRevalidatedAt, vs...))
+}
+
+// RevalidatedAtGT applies the GT predicate on the "revalidated_at" field.
+func RevalidatedAtGT(v time.Time) predicate.NarInfo {
+	return predicate.NarInfo(sql.FieldGT(FieldRevalidatedAt, v))
+}
+
+// RevalidatedAtGTE applies the GTE predicate on the "revalidated_at" field.
+func RevalidatedAtGTE(v time.Time) predicate.NarInfo {
+	return predicate.NarInfo(sql.FieldGTE(FieldRevalidatedAt, v))
+}
+
+// RevalidatedAtLT applies the LT predicate on the "revalidated_at" field.
+func RevalidatedAtLT(v time.Time) predicate.NarInfo {
+	return predicate.NarInfo(sql.FieldLT(FieldRevalidatedAt, v))
+}
+
+// RevalidatedAtLTE applies the LTE predicate on the "revalidated_at" field.
+func RevalidatedAtLTE(v time.Time) predicate.NarInfo {
+	return predicate.NarInfo(sql.FieldLTE(FieldRevalidatedAt, v))
+}
+
+// RevalidatedAtIsNil applies the IsNil predicate on the "revalidated_at" field.
+func RevalidatedAtIsNil() predicate.NarInfo {
+	return predicate.NarInfo(sql.FieldIsNull(FieldRevalidatedAt))
+}
+
+// RevalidatedAtNotNil applies the NotNil predicate on the "revalidated_at" field.
+func RevalidatedAtNotNil() predicate.NarInfo {
+	return predicate.NarInfo(sql.FieldNotNull(FieldRevalidatedAt))
+}
+
 // HasReferences applies the HasEdge predicate on the "references" edge.
 func HasReferences() predicate.NarInfo {
 	return predicate.NarInfo(func(s *sql.Selector) {
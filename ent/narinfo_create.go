@@ -227,6 +227,20 @@ func (_c *NarInfoCreate) SetNillableLastAccessedAt(v *time.Time) *NarInfoCreate
 	return _c
 }
 
+// SetRevalidatedAt sets the "revalidated_at" field.
+func (_c *NarInfoCreate) SetRevalidatedAt(v time.Time) *NarInfoCreate {
+	_c.mutation.SetRevalidatedAt(v)
+	return _c
+}
+
+// SetNillableRevalidatedAt sets the "revalidated_at" field if the given value is not nil.
+func (_c *NarInfoCreate) SetNillableRevalidatedAt(v *time.Time) *NarInfoCreate {
+	if v != nil {
+		_c.SetRevalidatedAt(*v)
+	}
+	return _c
+}
+
 // AddReferenceIDs adds the "references" edge to the NarInfoReference entity by IDs.
 func (_c *NarInfoCreate) AddReferenceIDs(ids ...int) *NarInfoCreate {
 	_c.mutation.AddReferenceIDs(ids...)
@@ -417,6 +431,10 @@ func (_c *NarInfoCreate) createSpec() (*NarInfo, *sqlgraph.CreateSpec) {
 		_spec.SetField(narinfo.FieldLastAccessedAt, field.TypeTime, value)
 		_node.LastAccessedAt = &value
 	}
+	if value, ok := _c.mutation.RevalidatedAt(); ok {
+		_spec.SetField(narinfo.FieldRevalidatedAt, field.TypeTime, value)
+		_node.RevalidatedAt = &value
+	}
 	if nodes := _c.mutation.ReferencesIDs(); len(nodes) > 0 {
 		edge := &sqlgraph.EdgeSpec{
 			Rel:     sqlgraph.O2M,
@@ -775,6 +793,24 @@ func (u *NarInfoUpsert) ClearLastAccessedAt() *NarInfoUpsert {
 	return u
 }
 
+// SetRevalidatedAt sets the "revalidated_at" field.
+func (u *NarInfoUpsert) SetRevalidatedAt(v time.Time) *NarInfoUpsert {
+	u.Set(narinfo.FieldRevalidatedAt, v)
+	return u
+}
+
+// UpdateRevalidatedAt sets the "revalidated_at" field to the value that was provided on create.
+func (u *NarInfoUpsert) UpdateRevalidatedAt() *NarInfoUpsert {
+	u.SetExcluded(narinfo.FieldRevalidatedAt)
+	return u
+}
+
+// ClearRevalidatedAt clears the value of the "revalidated_at" field.
+func (u *NarInfoUpsert) ClearRevalidatedAt() *NarInfoUpsert {
+	u.SetNull(narinfo.FieldRevalidatedAt)
+	return u
+}
+
 // UpdateNewValues updates the mutable fields using the new values that were set on create.
 // Using this option is equivalent to using:
 //
@@ -1121,6 +1157,27 @@ func (u *NarInfoUpsertOne) ClearLastAccessedAt() *NarInfoUpsertOne {
 	})
 }
 
+// SetRevalidatedAt sets the "revalidated_at" field.
+func (u *NarInfoUpsertOne) SetRevalidatedAt(v time.Time) *NarInfoUpsertOne {
+	return u.Update(func(s *NarInfoUpsert) {
+		s.SetRevalidatedAt(v)
+	})
+}
+
+// UpdateRevalidatedAt sets the "revalidated_at" field to the value that was provided on create.
+func (u *NarInfoUpsertOne) UpdateRevalidatedAt() *NarInfoUpsertOne {
+	return u.Update(func(s *NarInfoUpsert) {
+		s.UpdateRevalidatedAt()
+	})
+}
+
+// ClearRevalidatedAt clears the value of the "revalidated_at" field.
+func (u *NarInfoUpsertOne) ClearRevalidatedAt() *NarInfoUpsertOne {
+	return u.Update(func(s *NarInfoUpsert) {
+		s.ClearRevalidatedAt()
+	})
+}
+
 // Exec executes the query.
 func (u *NarInfoUpsertOne) Exec(ctx context.Context) error {
 	if len(u.create.conflict) == 0 {
@@ -1633,6 +1690,27 @@ func (u *NarInfoUpsertBulk) ClearLastAccessedAt() *NarInfoUpsertBulk {
 	})
 }
 
+// SetRevalidatedAt sets the "revalidated_at" field.
+func (u *NarInfoUpsertBulk) SetRevalidatedAt(v time.Time) *NarInfoUpsertBulk {
+	return u.Update(func(s *NarInfoUpsert) {
+		s.SetRevalidatedAt(v)
+	})
+}
+
+// UpdateRevalidatedAt sets the "revalidated_at" field to the value that was provided on create.
+func (u *NarInfoUpsertBulk) UpdateRevalidatedAt() *NarInfoUpsertBulk {
+	return u.Update(func(s *NarInfoUpsert) {
+		s.UpdateRevalidatedAt()
+	})
+}
+
+// ClearRevalidatedAt clears the value of the "revalidated_at" field.
+func (u *NarInfoUpsertBulk) ClearRevalidatedAt() *NarInfoUpsertBulk {
+	return u.Update(func(s *NarInfoUpsert) {
+		s.ClearRevalidatedAt()
+	})
+}
+
 // Exec executes the query.
 func (u *NarInfoUpsertBulk) Exec(ctx context.Context) error {
 	if u.create.err != nil {
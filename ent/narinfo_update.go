@@ -319,6 +319,26 @@ func (_u *NarInfoUpdate) ClearLastAccessedAt() *NarInfoUpdate {
 	return _u
 }
 
+// SetRevalidatedAt sets the "revalidated_at" field.
+func (_u *NarInfoUpdate) SetRevalidatedAt(v time.Time) *NarInfoUpdate {
+	_u.mutation.SetRevalidatedAt(v)
+	return _u
+}
+
+// SetNillableRevalidatedAt sets the "revalidated_at" field if the given value is not nil.
+func (_u *NarInfoUpdate) SetNillableRevalidatedAt(v *time.Time) *NarInfoUpdate {
+	if v != nil {
+		_u.SetRevalidatedAt(*v)
+	}
+	return _u
+}
+
+// ClearRevalidatedAt clears the value of the "revalidated_at" field.
+func (_u *NarInfoUpdate) ClearRevalidatedAt() *NarInfoUpdate {
+	_u.mutation.ClearRevalidatedAt()
+	return _u
+}
+
 // AddReferenceIDs adds the "references" edge to the NarInfoReference entity by IDs.
 func (_u *NarInfoUpdate) AddReferenceIDs(ids ...int) *NarInfoUpdate {
 	_u.mutation.AddReferenceIDs(ids...)
@@ -568,6 +588,12 @@ func (_u *NarInfoUpdate) sqlSave(ctx context.Context) (_node int, err error) {
 	if _u.mutation.LastAccessedAtCleared() {
 		_spec.ClearField(narinfo.FieldLastAccessedAt, field.TypeTime)
 	}
+	if value, ok := _u.mutation.RevalidatedAt(); ok {
+		_spec.SetField(narinfo.FieldRevalidatedAt, field.TypeTime, value)
+	}
+	if _u.mutation.RevalidatedAtCleared() {
+		_spec.ClearField(narinfo.FieldRevalidatedAt, field.TypeTime)
+	}
 	if _u.mutation.ReferencesCleared() {
 		edge := &sqlgraph.EdgeSpec{
 			Rel:     sqlgraph.O2M,
@@ -1011,6 +1037,26 @@ func (_u *NarInfoUpdateOne) ClearLastAccessedAt() *NarInfoUpdateOne {
 	return _u
 }
 
+// SetRevalidatedAt sets the "revalidated_at" field.
+func (_u *NarInfoUpdateOne) SetRevalidatedAt(v time.Time) *NarInfoUpdateOne {
+	_u.mutation.SetRevalidatedAt(v)
+	return _u
+}
+
+// SetNillableRevalidatedAt sets the "revalidated_at" field if the given value is not nil.
+func (_u *NarInfoUpdateOne) SetNillableRevalidatedAt(v *time.Time) *NarInfoUpdateOne {
+	if v != nil {
+		_u.SetRevalidatedAt(*v)
+	}
+	return _u
+}
+
+// ClearRevalidatedAt clears the value of the "revalidated_at" field.
+func (_u *NarInfoUpdateOne) ClearRevalidatedAt() *NarInfoUpdateOne {
+	_u.mutation.ClearRevalidatedAt()
+	return _u
+}
+
 // AddReferenceIDs adds the "references" edge to the NarInfoReference entity by IDs.
 func (_u *NarInfoUpdateOne) AddReferenceIDs(ids ...int) *NarInfoUpdateOne {
 	_u.mutation.AddReferenceIDs(ids...)
@@ -1290,6 +1336,12 @@ func (_u *NarInfoUpdateOne) sqlSave(ctx context.Context) (_node *NarInfo, err er
 	if _u.mutation.LastAccessedAtCleared() {
 		_spec.ClearField(narinfo.FieldLastAccessedAt, field.TypeTime)
 	}
+	if value, ok := _u.mutation.RevalidatedAt(); ok {
+		_spec.SetField(narinfo.FieldRevalidatedAt, field.TypeTime, value)
+	}
+	if _u.mutation.RevalidatedAtCleared() {
+		_spec.ClearField(narinfo.FieldRevalidatedAt, field.TypeTime)
+	}
 	if _u.mutation.ReferencesCleared() {
 		edge := &sqlgraph.EdgeSpec{
 			Rel:     sqlgraph.O2M,
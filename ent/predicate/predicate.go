@@ -18,6 +18,9 @@ type Chunk func(*sql.Selector)
 // ConfigEntry is the predicate function for configentry builders.
 type ConfigEntry func(*sql.Selector)
 
+// InlineNar is the predicate function for inlinenar builders.
+type InlineNar func(*sql.Selector)
+
 // NarFile is the predicate function for narfile builders.
 type NarFile func(*sql.Selector)
 
@@ -9,6 +9,7 @@ import (
 	"github.com/kalbasit/ncps/ent/buildtracesignature"
 	"github.com/kalbasit/ncps/ent/chunk"
 	"github.com/kalbasit/ncps/ent/configentry"
+	"github.com/kalbasit/ncps/ent/inlinenar"
 	"github.com/kalbasit/ncps/ent/narfile"
 	"github.com/kalbasit/ncps/ent/narinfo"
 	"github.com/kalbasit/ncps/ent/narinforeference"
@@ -74,6 +75,18 @@ func init() {
 	chunkDescCompressedSize := chunkFields[2].Descriptor()
 	// chunk.DefaultCompressedSize holds the default value on creation for the compressed_size field.
 	chunk.DefaultCompressedSize = chunkDescCompressedSize.Default.(uint32)
+	// chunkDescRefCount is the schema descriptor for ref_count field.
+	chunkDescRefCount := chunkFields[3].Descriptor()
+	// chunk.DefaultRefCount holds the default value on creation for the ref_count field.
+	chunk.DefaultRefCount = chunkDescRefCount.Default.(int64)
+	// chunkDescAccessCount is the schema descriptor for access_count field.
+	chunkDescAccessCount := chunkFields[4].Descriptor()
+	// chunk.DefaultAccessCount holds the default value on creation for the access_count field.
+	chunk.DefaultAccessCount = chunkDescAccessCount.Default.(int64)
+	// chunkDescCold is the schema descriptor for cold field.
+	chunkDescCold := chunkFields[6].Descriptor()
+	// chunk.DefaultCold holds the default value on creation for the cold field.
+	chunk.DefaultCold = chunkDescCold.Default.(bool)
 	configentryMixin := schema.ConfigEntry{}.Mixin()
 	configentryMixinFields0 := configentryMixin[0].Fields()
 	_ = configentryMixinFields0
@@ -91,6 +104,23 @@ func init() {
 	configentryDescValue := configentryFields[1].Descriptor()
 	// configentry.ValueValidator is a validator for the "value" field. It is called by the builders before save.
 	configentry.ValueValidator = configentryDescValue.Validators[0].(func(string) error)
+	inlinenarMixin := schema.InlineNar{}.Mixin()
+	inlinenarMixinFields0 := inlinenarMixin[0].Fields()
+	_ = inlinenarMixinFields0
+	inlinenarFields := schema.InlineNar{}.Fields()
+	_ = inlinenarFields
+	// inlinenarDescCreatedAt is the schema descriptor for created_at field.
+	inlinenarDescCreatedAt := inlinenarMixinFields0[0].Descriptor()
+	// inlinenar.DefaultCreatedAt holds the default value on creation for the created_at field.
+	inlinenar.DefaultCreatedAt = inlinenarDescCreatedAt.Default.(func() time.Time)
+	// inlinenarDescHash is the schema descriptor for hash field.
+	inlinenarDescHash := inlinenarFields[0].Descriptor()
+	// inlinenar.HashValidator is a validator for the "hash" field. It is called by the builders before save.
+	inlinenar.HashValidator = inlinenarDescHash.Validators[0].(func(string) error)
+	// inlinenarDescCompression is the schema descriptor for compression field.
+	inlinenarDescCompression := inlinenarFields[1].Descriptor()
+	// inlinenar.DefaultCompression holds the default value on creation for the compression field.
+	inlinenar.DefaultCompression = inlinenarDescCompression.Default.(string)
 	narfileMixin := schema.NarFile{}.Mixin()
 	narfileMixinFields0 := narfileMixin[0].Fields()
 	_ = narfileMixinFields0
@@ -116,8 +146,12 @@ func init() {
 	narfileDescTotalChunks := narfileFields[4].Descriptor()
 	// narfile.DefaultTotalChunks holds the default value on creation for the total_chunks field.
 	narfile.DefaultTotalChunks = narfileDescTotalChunks.Default.(int64)
+	// narfileDescStoredRaw is the schema descriptor for stored_raw field.
+	narfileDescStoredRaw := narfileFields[12].Descriptor()
+	// narfile.DefaultStoredRaw holds the default value on creation for the stored_raw field.
+	narfile.DefaultStoredRaw = narfileDescStoredRaw.Default.(bool)
 	// narfileDescLastAccessedAt is the schema descriptor for last_accessed_at field.
-	narfileDescLastAccessedAt := narfileFields[9].Descriptor()
+	narfileDescLastAccessedAt := narfileFields[13].Descriptor()
 	// narfile.DefaultLastAccessedAt holds the default value on creation for the last_accessed_at field.
 	narfile.DefaultLastAccessedAt = narfileDescLastAccessedAt.Default.(func() time.Time)
 	narinfoMixin := schema.NarInfo{}.Mixin()
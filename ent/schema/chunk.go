@@ -45,6 +45,18 @@ func (Chunk) Fields() []ent.Field {
 		field.String("hash").NotEmpty(),
 		field.Uint32("size"),
 		field.Uint32("compressed_size").Default(0),
+		// ref_count is the number of nar_file_chunks rows linking the chunk. It is
+		// maintained in the transaction that links or unlinks the chunk (see
+		// database.UnlinkNarFileChunks), so chunk GC deletes the rows with a
+		// ref_count of zero instead of scanning for chunks without links.
+		field.Int64("ref_count").Default(0),
+		// access_count is the decayed number of reads of the chunk, flushed by
+		// the chunk tiering job, which halves it at each run. last_accessed_at
+		// is the time of the last flush counting a read.
+		field.Int64("access_count").Default(0),
+		field.Time("last_accessed_at").Optional().Nillable(),
+		// cold tells whether the chunk was demoted to the cold chunk store.
+		field.Bool("cold").Default(false),
 	}
 }
 
@@ -64,5 +76,7 @@ func (Chunk) Edges() []ent.Edge {
 func (Chunk) Indexes() []ent.Index {
 	return []ent.Index{
 		index.Fields("hash").Unique(),
+		index.Fields("ref_count"),
+		index.Fields("cold", "access_count"),
 	}
 }
@@ -38,7 +38,9 @@ func (ConfigEntry) Mixin() []ent.Mixin {
 func (ConfigEntry) Fields() []ent.Field {
 	return []ent.Field{
 		field.String("key").NotEmpty(),
-		field.String("value").NotEmpty(),
+		// value is text: the ACME certificates cached in the config are
+		// larger than a MySQL varchar.
+		field.Text("value").NotEmpty(),
 	}
 }
 
@@ -0,0 +1,52 @@
+package schema
+
+import (
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+
+	"github.com/kalbasit/ncps/internal/entmixin"
+)
+
+// InlineNar holds the body of a small NAR directly in the database instead of
+// the NAR store (see pkg/storage/inline). Tiny NARs dominate request counts
+// but each costs a filesystem inode or an object-store round-trip; storing
+// them inline trades a few KiB of database row for both. Like the NAR store
+// itself, a row is keyed by the hash and compression of the NAR URL.
+type InlineNar struct {
+	ent.Schema
+}
+
+// Annotations declares the on-disk table name.
+func (InlineNar) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "inline_nars"},
+	}
+}
+
+// Mixin contributes created_at / updated_at.
+func (InlineNar) Mixin() []ent.Mixin {
+	return []ent.Mixin{entmixin.Timestamps{}}
+}
+
+// Fields of the InlineNar.
+func (InlineNar) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("hash").NotEmpty(),
+		field.String("compression").
+			Default(""),
+		// data is the NAR body exactly as it would be written to the NAR store.
+		// The column is a plain BLOB on every dialect (64 KiB on MySQL), which
+		// bounds the configurable inline threshold.
+		field.Bytes("data"),
+	}
+}
+
+// Indexes of the InlineNar. One row per NAR URL.
+func (InlineNar) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("hash", "compression").Unique(),
+	}
+}
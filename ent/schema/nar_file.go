@@ -70,6 +70,24 @@ func (NarFile) Fields() []ent.Field {
 		field.Time("dechunk_residue_flagged_at").
 			Optional().
 			Nillable(),
+		// chunk_min_size, chunk_avg_size and chunk_max_size record the CDC
+		// parameters the NAR was chunked with, so repairs re-chunk it the same
+		// way after the size classes change. NULL means the NAR predates size
+		// classes and was chunked with the global CDC parameters.
+		field.Uint32("chunk_min_size").
+			Optional().
+			Nillable(),
+		field.Uint32("chunk_avg_size").
+			Optional().
+			Nillable(),
+		field.Uint32("chunk_max_size").
+			Optional().
+			Nillable(),
+		// stored_raw records that the Compression:none NAR was stored as a plain
+		// .nar because a sample of it did not compress, so the serving paths read
+		// it as is instead of probing for a compressed variant to decompress.
+		field.Bool("stored_raw").
+			Default(false),
 		field.Time("last_accessed_at").
 			Optional().
 			Nillable().
@@ -75,6 +75,11 @@ func (NarInfo) Fields() []ent.Field {
 			// inspector strips, producing a perpetual phantom table rebuild —
 			// issue #1328). Mirrors the Timestamps mixin's created_at.
 			Annotations(entsql.Default("CURRENT_TIMESTAMP")),
+		// revalidated_at records when the narinfo was last checked against its
+		// upstream. NULL means never: its age is then counted from created_at.
+		field.Time("revalidated_at").
+			Optional().
+			Nillable(),
 	}
 }
 
@@ -20,6 +20,8 @@ type Tx struct {
 	Chunk *ChunkClient
 	// ConfigEntry is the client for interacting with the ConfigEntry builders.
 	ConfigEntry *ConfigEntryClient
+	// InlineNar is the client for interacting with the InlineNar builders.
+	InlineNar *InlineNarClient
 	// NarFile is the client for interacting with the NarFile builders.
 	NarFile *NarFileClient
 	// NarFileChunk is the client for interacting with the NarFileChunk builders.
@@ -171,6 +173,7 @@ func (tx *Tx) init() {
 	tx.BuildTraceSignature = NewBuildTraceSignatureClient(tx.config)
 	tx.Chunk = NewChunkClient(tx.config)
 	tx.ConfigEntry = NewConfigEntryClient(tx.config)
+	tx.InlineNar = NewInlineNarClient(tx.config)
 	tx.NarFile = NewNarFileClient(tx.config)
 	tx.NarFileChunk = NewNarFileChunkClient(tx.config)
 	tx.NarInfo = NewNarInfoClient(tx.config)
@@ -20,11 +20,10 @@ func realMain() int {
 		return 1
 	}
 
-	if err := c.Run(context.Background(), os.Args); err != nil {
+	err = c.Run(context.Background(), os.Args)
+	if err != nil {
 		log.Printf("error running the application: %s", err)
-
-		return 1
 	}
 
-	return 0
+	return ncps.ExitCode(err)
 }
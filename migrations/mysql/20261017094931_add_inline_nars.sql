@@ -0,0 +1,7 @@
+-- +goose Up
+-- create "inline_nars" table
+CREATE TABLE `inline_nars` (`id` bigint NOT NULL AUTO_INCREMENT, `created_at` timestamp NULL DEFAULT (current_timestamp()), `updated_at` timestamp NULL, `hash` varchar(255) NOT NULL, `compression` varchar(255) NOT NULL DEFAULT '', `data` blob NOT NULL, PRIMARY KEY (`id`), UNIQUE INDEX `inlinenar_hash_compression` (`hash`, `compression`)) CHARSET utf8mb4 COLLATE utf8mb4_bin;
+
+-- +goose Down
+-- reverse: create "inline_nars" table
+DROP TABLE `inline_nars`;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- modify "narinfos" table
+ALTER TABLE `narinfos` ADD COLUMN `revalidated_at` timestamp NULL;
+
+-- +goose Down
+-- reverse: modify "narinfos" table
+ALTER TABLE `narinfos` DROP COLUMN `revalidated_at`;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- modify "chunks" table
+ALTER TABLE `chunks` ADD COLUMN `ref_count` bigint NOT NULL DEFAULT 0, ADD INDEX `chunk_ref_count` (`ref_count`);
+-- backfill "ref_count" from the existing nar_file_chunks links
+UPDATE `chunks` SET `ref_count` = (SELECT COUNT(*) FROM `nar_file_chunks` WHERE `nar_file_chunks`.`chunk_id` = `chunks`.`id`);
+
+-- +goose Down
+-- reverse: modify "chunks" table
+ALTER TABLE `chunks` DROP INDEX `chunk_ref_count`, DROP COLUMN `ref_count`;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- modify "chunks" table
+ALTER TABLE `chunks` ADD COLUMN `access_count` bigint NOT NULL DEFAULT 0, ADD COLUMN `last_accessed_at` timestamp NULL, ADD COLUMN `cold` bool NOT NULL DEFAULT 0, ADD INDEX `chunk_cold_access_count` (`cold`, `access_count`);
+
+-- +goose Down
+-- reverse: modify "chunks" table
+ALTER TABLE `chunks` DROP INDEX `chunk_cold_access_count`, DROP COLUMN `cold`, DROP COLUMN `last_accessed_at`, DROP COLUMN `access_count`;
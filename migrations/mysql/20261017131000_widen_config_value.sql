@@ -0,0 +1,7 @@
+-- +goose Up
+-- modify "config" table
+ALTER TABLE `config` MODIFY COLUMN `value` longtext NOT NULL;
+
+-- +goose Down
+-- reverse: modify "config" table
+ALTER TABLE `config` MODIFY COLUMN `value` varchar(255) NOT NULL;
@@ -0,0 +1,11 @@
+-- +goose Up
+-- create "inline_nars" table
+CREATE TABLE "inline_nars" ("id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY, "created_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP, "updated_at" timestamptz NULL, "hash" character varying NOT NULL, "compression" character varying NOT NULL DEFAULT '', "data" bytea NOT NULL, PRIMARY KEY ("id"));
+-- create index "inlinenar_hash_compression" to table: "inline_nars"
+CREATE UNIQUE INDEX "inlinenar_hash_compression" ON "inline_nars" ("hash", "compression");
+
+-- +goose Down
+-- reverse: create index "inlinenar_hash_compression" to table: "inline_nars"
+DROP INDEX "inlinenar_hash_compression";
+-- reverse: create "inline_nars" table
+DROP TABLE "inline_nars";
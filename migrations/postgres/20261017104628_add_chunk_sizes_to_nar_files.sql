@@ -0,0 +1,7 @@
+-- +goose Up
+-- modify "nar_files" table
+ALTER TABLE "nar_files" ADD COLUMN "chunk_min_size" bigint NULL, ADD COLUMN "chunk_avg_size" bigint NULL, ADD COLUMN "chunk_max_size" bigint NULL;
+
+-- +goose Down
+-- reverse: modify "nar_files" table
+ALTER TABLE "nar_files" DROP COLUMN "chunk_max_size", DROP COLUMN "chunk_avg_size", DROP COLUMN "chunk_min_size";
@@ -0,0 +1,13 @@
+-- +goose Up
+-- modify "chunks" table
+ALTER TABLE "chunks" ADD COLUMN "ref_count" bigint NOT NULL DEFAULT 0;
+-- create index "chunk_ref_count" to table: "chunks"
+CREATE INDEX "chunk_ref_count" ON "chunks" ("ref_count");
+-- backfill "ref_count" from the existing nar_file_chunks links
+UPDATE "chunks" SET "ref_count" = (SELECT COUNT(*) FROM "nar_file_chunks" WHERE "nar_file_chunks"."chunk_id" = "chunks"."id");
+
+-- +goose Down
+-- reverse: create index "chunk_ref_count" to table: "chunks"
+DROP INDEX "chunk_ref_count";
+-- reverse: modify "chunks" table
+ALTER TABLE "chunks" DROP COLUMN "ref_count";
@@ -0,0 +1,11 @@
+-- +goose Up
+-- modify "chunks" table
+ALTER TABLE "chunks" ADD COLUMN "access_count" bigint NOT NULL DEFAULT 0, ADD COLUMN "last_accessed_at" timestamptz NULL, ADD COLUMN "cold" boolean NOT NULL DEFAULT false;
+-- create index "chunk_cold_access_count" to table: "chunks"
+CREATE INDEX "chunk_cold_access_count" ON "chunks" ("cold", "access_count");
+
+-- +goose Down
+-- reverse: create index "chunk_cold_access_count" to table: "chunks"
+DROP INDEX "chunk_cold_access_count";
+-- reverse: modify "chunks" table
+ALTER TABLE "chunks" DROP COLUMN "cold", DROP COLUMN "last_accessed_at", DROP COLUMN "access_count";
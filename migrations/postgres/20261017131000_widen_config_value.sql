@@ -0,0 +1,7 @@
+-- +goose Up
+-- modify "config" table
+ALTER TABLE "config" ALTER COLUMN "value" TYPE text;
+
+-- +goose Down
+-- reverse: modify "config" table
+ALTER TABLE "config" ALTER COLUMN "value" TYPE character varying;
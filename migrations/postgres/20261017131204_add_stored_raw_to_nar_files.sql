@@ -0,0 +1,7 @@
+-- +goose Up
+-- modify "nar_files" table
+ALTER TABLE "nar_files" ADD COLUMN "stored_raw" boolean NOT NULL DEFAULT false;
+
+-- +goose Down
+-- reverse: modify "nar_files" table
+ALTER TABLE "nar_files" DROP COLUMN "stored_raw";
@@ -0,0 +1,11 @@
+-- +goose Up
+-- create "inline_nars" table
+CREATE TABLE `inline_nars` (`id` integer NOT NULL PRIMARY KEY AUTOINCREMENT, `created_at` datetime NOT NULL DEFAULT (CURRENT_TIMESTAMP), `updated_at` datetime NULL, `hash` text NOT NULL, `compression` text NOT NULL DEFAULT (''), `data` blob NOT NULL);
+-- create index "inlinenar_hash_compression" to table: "inline_nars"
+CREATE UNIQUE INDEX `inlinenar_hash_compression` ON `inline_nars` (`hash`, `compression`);
+
+-- +goose Down
+-- reverse: create index "inlinenar_hash_compression" to table: "inline_nars"
+DROP INDEX `inlinenar_hash_compression`;
+-- reverse: create "inline_nars" table
+DROP TABLE `inline_nars`;
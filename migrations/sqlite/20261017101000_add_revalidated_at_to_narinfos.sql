@@ -0,0 +1,7 @@
+-- +goose Up
+-- add column "revalidated_at" to table: "narinfos"
+ALTER TABLE `narinfos` ADD COLUMN `revalidated_at` datetime NULL;
+
+-- +goose Down
+-- reverse: add column "revalidated_at" to table: "narinfos"
+ALTER TABLE `narinfos` DROP COLUMN `revalidated_at`;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- add column "chunk_min_size" to table: "nar_files"
+ALTER TABLE `nar_files` ADD COLUMN `chunk_min_size` integer NULL;
+-- add column "chunk_avg_size" to table: "nar_files"
+ALTER TABLE `nar_files` ADD COLUMN `chunk_avg_size` integer NULL;
+-- add column "chunk_max_size" to table: "nar_files"
+ALTER TABLE `nar_files` ADD COLUMN `chunk_max_size` integer NULL;
+
+-- +goose Down
+-- reverse: add column "chunk_max_size" to table: "nar_files"
+ALTER TABLE `nar_files` DROP COLUMN `chunk_max_size`;
+-- reverse: add column "chunk_avg_size" to table: "nar_files"
+ALTER TABLE `nar_files` DROP COLUMN `chunk_avg_size`;
+-- reverse: add column "chunk_min_size" to table: "nar_files"
+ALTER TABLE `nar_files` DROP COLUMN `chunk_min_size`;
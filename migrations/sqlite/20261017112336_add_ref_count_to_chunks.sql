@@ -0,0 +1,27 @@
+-- +goose Up
+-- disable the enforcement of foreign-keys constraints
+PRAGMA foreign_keys = off;
+-- create "new_chunks" table
+CREATE TABLE `new_chunks` (`id` integer NOT NULL PRIMARY KEY AUTOINCREMENT, `created_at` datetime NOT NULL DEFAULT (CURRENT_TIMESTAMP), `updated_at` datetime NULL, `hash` text NOT NULL, `size` integer NOT NULL, `compressed_size` integer NOT NULL DEFAULT (0), `ref_count` integer NOT NULL DEFAULT (0), CONSTRAINT `chunks_compressed_size_nonneg` CHECK (compressed_size >= 0), CONSTRAINT `chunks_size_nonneg` CHECK (size >= 0));
+-- copy rows from old table "chunks" to new temporary table "new_chunks"
+INSERT INTO `new_chunks` (`id`, `created_at`, `updated_at`, `hash`, `size`, `compressed_size`) SELECT `id`, `created_at`, `updated_at`, `hash`, `size`, `compressed_size` FROM `chunks`;
+-- drop "chunks" table after copying rows
+DROP TABLE `chunks`;
+-- rename temporary table "new_chunks" to "chunks"
+ALTER TABLE `new_chunks` RENAME TO `chunks`;
+-- create index "chunk_hash" to table: "chunks"
+CREATE UNIQUE INDEX `chunk_hash` ON `chunks` (`hash`);
+-- create index "chunk_ref_count" to table: "chunks"
+CREATE INDEX `chunk_ref_count` ON `chunks` (`ref_count`);
+-- backfill "ref_count" from the existing nar_file_chunks links
+UPDATE `chunks` SET `ref_count` = (SELECT COUNT(*) FROM `nar_file_chunks` WHERE `nar_file_chunks`.`chunk_id` = `chunks`.`id`);
+-- enable back the enforcement of foreign-keys constraints
+PRAGMA foreign_keys = on;
+
+-- +goose Down
+-- reverse: create index "chunk_ref_count" to table: "chunks"
+DROP INDEX `chunk_ref_count`;
+-- reverse: create index "chunk_hash" to table: "chunks"
+DROP INDEX `chunk_hash`;
+-- reverse: create "new_chunks" table
+DROP TABLE `new_chunks`;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- add column "access_count" to table: "chunks"
+ALTER TABLE `chunks` ADD COLUMN `access_count` integer NOT NULL DEFAULT (0);
+-- add column "last_accessed_at" to table: "chunks"
+ALTER TABLE `chunks` ADD COLUMN `last_accessed_at` datetime NULL;
+-- add column "cold" to table: "chunks"
+ALTER TABLE `chunks` ADD COLUMN `cold` bool NOT NULL DEFAULT (false);
+-- create index "chunk_cold_access_count" to table: "chunks"
+CREATE INDEX `chunk_cold_access_count` ON `chunks` (`cold`, `access_count`);
+
+-- +goose Down
+-- reverse: create index "chunk_cold_access_count" to table: "chunks"
+DROP INDEX `chunk_cold_access_count`;
+-- reverse: add column "cold" to table: "chunks"
+ALTER TABLE `chunks` DROP COLUMN `cold`;
+-- reverse: add column "last_accessed_at" to table: "chunks"
+ALTER TABLE `chunks` DROP COLUMN `last_accessed_at`;
+-- reverse: add column "access_count" to table: "chunks"
+ALTER TABLE `chunks` DROP COLUMN `access_count`;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- add column "stored_raw" to table: "nar_files"
+ALTER TABLE `nar_files` ADD COLUMN `stored_raw` bool NOT NULL DEFAULT (false);
+
+-- +goose Down
+-- reverse: add column "stored_raw" to table: "nar_files"
+ALTER TABLE `nar_files` DROP COLUMN `stored_raw`;
@@ -0,0 +1,276 @@
+// Package accesslog exports a record of every narinfo and NAR request served
+// by ncps to an external analytics sink, such as ClickHouse or BigQuery, in
+// batches, to learn which store paths are actually fetched across an
+// organization.
+package accesslog
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	otelPackageName = "github.com/kalbasit/ncps/pkg/accesslog"
+
+	// DefaultBatchSize is the number of records exported at once when
+	// Options.BatchSize is zero.
+	DefaultBatchSize = 1000
+
+	// DefaultFlushInterval is the longest a record waits to be exported when
+	// Options.FlushInterval is zero.
+	DefaultFlushInterval = 10 * time.Second
+
+	// DefaultQueueSize is the number of records waiting to be exported when
+	// Options.QueueSize is zero.
+	DefaultQueueSize = 10000
+)
+
+// Outcomes recorded by ncps_access_log_records_total.
+const (
+	outcomeExported = "exported"
+	outcomeDropped  = "dropped"
+	outcomeFailed   = "failed"
+)
+
+// Kinds of the objects requested.
+const (
+	KindNarInfo = "narinfo"
+	KindNar     = "nar"
+)
+
+//nolint:gochecknoglobals
+var accessLogRecordsTotal metric.Int64Counter
+
+//nolint:gochecknoinits
+func init() {
+	var err error
+
+	accessLogRecordsTotal, err = otel.Meter(otelPackageName).Int64Counter(
+		"ncps_access_log_records_total",
+		metric.WithDescription("Access log records by outcome: exported, dropped when the queue was full, "+
+			"or failed when the sink rejected their batch."),
+		metric.WithUnit("{record}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// PrimeMetrics records a zero-valued measurement on every counter instrument in
+// this package so the corresponding time series are exported from startup
+// rather than only appearing after the first real event.
+func PrimeMetrics(ctx context.Context) {
+	for _, outcome := range []string{outcomeExported, outcomeDropped, outcomeFailed} {
+		recordOutcome(ctx, outcome, 0)
+	}
+}
+
+// Record is the access record of a narinfo or NAR request.
+type Record struct {
+	// Time is when the request was received.
+	Time time.Time `json:"time"`
+
+	// Kind is KindNarInfo or KindNar.
+	Kind string `json:"kind"`
+
+	// Hash is the narinfo hash of a narinfo request, or the NAR hash of a NAR
+	// request.
+	Hash string `json:"hash"`
+
+	// StorePath is the name of the store path of a narinfo request, without
+	// its store directory and hash, such as hello-2.12.1.
+	StorePath string `json:"store_path"`
+
+	// Client is the address of the client.
+	Client string `json:"client"`
+
+	// Method is GET or HEAD.
+	Method string `json:"method"`
+
+	// Status is the HTTP status of the response.
+	Status int `json:"status"`
+
+	// Bytes is the size of the response body.
+	Bytes int64 `json:"bytes"`
+
+	// Result is how the object was served: HIT, MISS or PASS, or empty if it
+	// was not served.
+	Result string `json:"result"`
+
+	// DurationMS is the time taken to serve the request, in milliseconds.
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// Sink receives the batches of records.
+type Sink interface {
+	// Export writes records to the sink. A failed batch is dropped.
+	Export(ctx context.Context, records []Record) error
+}
+
+// Options configures an Exporter.
+type Options struct {
+	// BatchSize is the number of records exported at once. If zero,
+	// DefaultBatchSize is used.
+	BatchSize int
+
+	// FlushInterval is the longest a record waits to be exported. If zero,
+	// DefaultFlushInterval is used.
+	FlushInterval time.Duration
+
+	// QueueSize bounds the records waiting to be exported; the records
+	// arriving while it is full are dropped. If zero, DefaultQueueSize is
+	// used.
+	QueueSize int
+}
+
+// Exporter batches the records and exports them to its sink in the
+// background, so serving a request never waits on the sink.
+type Exporter struct {
+	sink          Sink
+	batchSize     int
+	flushInterval time.Duration
+
+	queue chan Record
+
+	closeOnce sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// NewExporter returns an Exporter to sink. It exports once Run is called.
+func NewExporter(sink Sink, opts Options) *Exporter {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+
+	return &Exporter{
+		sink:          sink,
+		batchSize:     opts.BatchSize,
+		flushInterval: opts.FlushInterval,
+		queue:         make(chan Record, opts.QueueSize),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+}
+
+// Record queues r for export. It never blocks: r is dropped if the queue is
+// full.
+func (e *Exporter) Record(ctx context.Context, r Record) {
+	select {
+	case e.queue <- r:
+	default:
+		recordOutcome(ctx, outcomeDropped, 1)
+	}
+}
+
+// Run exports the queued records in batches of the batch size, or once the
+// flush interval is elapsed, until Close is called or ctx is done. The records
+// still queued are exported before it returns.
+func (e *Exporter) Run(ctx context.Context) {
+	defer close(e.stopped)
+
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Record, 0, e.batchSize)
+
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+
+		e.export(ctx, batch)
+
+		batch = make([]Record, 0, e.batchSize)
+	}
+
+	for {
+		select {
+		case r := <-e.queue:
+			batch = append(batch, r)
+			if len(batch) >= e.batchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-e.done:
+			e.drain(context.WithoutCancel(ctx), &batch)
+			flush(context.WithoutCancel(ctx))
+
+			return
+		case <-ctx.Done():
+			e.drain(context.WithoutCancel(ctx), &batch)
+			flush(context.WithoutCancel(ctx))
+
+			return
+		}
+	}
+}
+
+// Close stops Run once the queued records are exported, waiting until ctx is
+// done at most.
+func (e *Exporter) Close(ctx context.Context) error {
+	e.closeOnce.Do(func() { close(e.done) })
+
+	select {
+	case <-e.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain moves the queued records to batch, exporting the full batches.
+func (e *Exporter) drain(ctx context.Context, batch *[]Record) {
+	for {
+		select {
+		case r := <-e.queue:
+			*batch = append(*batch, r)
+			if len(*batch) >= e.batchSize {
+				e.export(ctx, *batch)
+
+				*batch = make([]Record, 0, e.batchSize)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (e *Exporter) export(ctx context.Context, batch []Record) {
+	if err := e.sink.Export(ctx, batch); err != nil {
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(err).
+			Int("count", len(batch)).
+			Msg("error exporting the access log records")
+
+		recordOutcome(ctx, outcomeFailed, len(batch))
+
+		return
+	}
+
+	recordOutcome(ctx, outcomeExported, len(batch))
+}
+
+func recordOutcome(ctx context.Context, outcome string, count int) {
+	if accessLogRecordsTotal == nil {
+		return
+	}
+
+	accessLogRecordsTotal.Add(ctx, int64(count), metric.WithAttributes(attribute.String("outcome", outcome)))
+}
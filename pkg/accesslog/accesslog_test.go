@@ -0,0 +1,233 @@
+package accesslog_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/accesslog"
+)
+
+var errSink = errors.New("sink is down")
+
+type batchSink struct {
+	mu      sync.Mutex
+	batches [][]accesslog.Record
+	err     error
+}
+
+func (s *batchSink) Export(_ context.Context, records []accesslog.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.batches = append(s.batches, records)
+
+	return s.err
+}
+
+func (s *batchSink) sizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sizes := make([]int, 0, len(s.batches))
+	for _, b := range s.batches {
+		sizes = append(sizes, len(b))
+	}
+
+	return sizes
+}
+
+func TestExporter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("records are exported in batches", func(t *testing.T) {
+		t.Parallel()
+
+		sink := &batchSink{}
+		e := accesslog.NewExporter(sink, accesslog.Options{BatchSize: 2, FlushInterval: time.Hour})
+
+		go e.Run(context.Background())
+
+		for range 5 {
+			e.Record(context.Background(), accesslog.Record{Hash: "h"})
+		}
+
+		require.EventuallyWithT(t, func(ct *assert.CollectT) {
+			assert.Equal(ct, []int{2, 2}, sink.sizes())
+		}, 5*time.Second, 10*time.Millisecond)
+
+		require.NoError(t, e.Close(context.Background()))
+		assert.Equal(t, []int{2, 2, 1}, sink.sizes(), "Close exports the records left")
+		require.NoError(t, e.Close(context.Background()), "Close is idempotent")
+	})
+
+	t.Run("records are exported once the flush interval is elapsed", func(t *testing.T) {
+		t.Parallel()
+
+		sink := &batchSink{}
+		e := accesslog.NewExporter(sink, accesslog.Options{FlushInterval: 10 * time.Millisecond})
+
+		go e.Run(context.Background())
+
+		e.Record(context.Background(), accesslog.Record{Hash: "h"})
+
+		require.EventuallyWithT(t, func(ct *assert.CollectT) {
+			assert.Equal(ct, []int{1}, sink.sizes())
+		}, 5*time.Second, 10*time.Millisecond)
+
+		require.NoError(t, e.Close(context.Background()))
+	})
+
+	t.Run("records are dropped once the queue is full", func(t *testing.T) {
+		t.Parallel()
+
+		sink := &batchSink{}
+		e := accesslog.NewExporter(sink, accesslog.Options{QueueSize: 2, FlushInterval: time.Hour})
+
+		for range 5 {
+			e.Record(context.Background(), accesslog.Record{Hash: "h"})
+		}
+
+		go e.Run(context.Background())
+
+		require.NoError(t, e.Close(context.Background()))
+		assert.Equal(t, []int{2}, sink.sizes())
+	})
+
+	t.Run("failed batches are dropped", func(t *testing.T) {
+		t.Parallel()
+
+		sink := &batchSink{err: errSink}
+		e := accesslog.NewExporter(sink, accesslog.Options{BatchSize: 1, FlushInterval: time.Hour})
+
+		go e.Run(context.Background())
+
+		e.Record(context.Background(), accesslog.Record{Hash: "a"})
+		e.Record(context.Background(), accesslog.Record{Hash: "b"})
+
+		require.NoError(t, e.Close(context.Background()))
+		assert.Equal(t, []int{1, 1}, sink.sizes())
+	})
+}
+
+func TestNewSink(t *testing.T) {
+	t.Parallel()
+
+	_, err := accesslog.NewSink(accesslog.SinkConfig{Name: "kafka"})
+	require.ErrorIs(t, err, accesslog.ErrUnknownSink)
+
+	_, err = accesslog.NewSink(accesslog.SinkConfig{Name: accesslog.SinkHTTP})
+	require.ErrorIs(t, err, accesslog.ErrURLRequired)
+
+	_, err = accesslog.NewSink(accesslog.SinkConfig{Name: accesslog.SinkClickHouse, URL: "http://clickhouse:8123"})
+	require.ErrorIs(t, err, accesslog.ErrTableRequired)
+
+	_, err = accesslog.NewSink(accesslog.SinkConfig{Name: accesslog.SinkBigQuery, Table: "dataset.table"})
+	require.ErrorIs(t, err, accesslog.ErrTableRequired)
+}
+
+func TestClickHouseSink(t *testing.T) {
+	t.Parallel()
+
+	var got []accesslog.Record
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "INSERT INTO ncps.access_log FORMAT JSONEachRow", r.URL.Query().Get("query"))
+		assert.Equal(t, "secret", r.Header.Get("X-ClickHouse-Key"))
+
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			var rec accesslog.Record
+			if assert.NoError(t, json.Unmarshal(sc.Bytes(), &rec)) {
+				got = append(got, rec)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	sink, err := accesslog.NewSink(accesslog.SinkConfig{
+		Name:   accesslog.SinkClickHouse,
+		URL:    srv.URL,
+		Table:  "ncps.access_log",
+		Header: http.Header{"X-Clickhouse-Key": {"secret"}},
+	})
+	require.NoError(t, err)
+
+	records := []accesslog.Record{
+		{Kind: accesslog.KindNarInfo, Hash: "a", StorePath: "hello-2.12.1", Status: http.StatusOK, Result: "HIT"},
+		{Kind: accesslog.KindNar, Hash: "b", Bytes: 42, Status: http.StatusOK, Result: "MISS"},
+	}
+
+	require.NoError(t, sink.Export(context.Background(), records))
+	assert.Equal(t, records, got)
+}
+
+func TestHTTPSink_Error(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "table is read-only", http.StatusForbidden)
+	}))
+	t.Cleanup(srv.Close)
+
+	sink, err := accesslog.NewSink(accesslog.SinkConfig{Name: accesslog.SinkHTTP, URL: srv.URL})
+	require.NoError(t, err)
+
+	err = sink.Export(context.Background(), []accesslog.Record{{Hash: "a"}})
+	require.ErrorIs(t, err, accesslog.ErrExport)
+	assert.Contains(t, err.Error(), "table is read-only")
+}
+
+func TestBigQuerySink(t *testing.T) {
+	t.Parallel()
+
+	var rows int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/projects/my-project/datasets/ncps/tables/access_log/insertAll", r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		var in struct {
+			Rows []struct {
+				JSON accesslog.Record `json:"json"`
+			} `json:"rows"`
+		}
+
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+
+		rows = len(in.Rows)
+
+		if in.Rows[0].JSON.Hash == "rejected" {
+			_, _ = w.Write([]byte(`{"insertErrors":[{"index":0}]}`))
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	sink, err := accesslog.NewSink(accesslog.SinkConfig{
+		Name:   accesslog.SinkBigQuery,
+		URL:    srv.URL,
+		Table:  "my-project.ncps.access_log",
+		Header: http.Header{"Authorization": {"Bearer token"}},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Export(context.Background(), []accesslog.Record{{Hash: "a"}, {Hash: "b"}}))
+	assert.Equal(t, 2, rows)
+
+	err = sink.Export(context.Background(), []accesslog.Record{{Hash: "rejected"}})
+	require.ErrorIs(t, err, accesslog.ErrExport)
+}
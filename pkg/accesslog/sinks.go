@@ -0,0 +1,329 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Names of the sinks.
+const (
+	SinkHTTP       = "http"
+	SinkClickHouse = "clickhouse"
+	SinkBigQuery   = "bigquery"
+)
+
+const (
+	// defaultBigQueryURL is the base URL of the BigQuery API.
+	defaultBigQueryURL = "https://bigquery.googleapis.com/bigquery/v2"
+
+	// metadataTokenURL returns the access token of the service account of the
+	// GCE instance or the GKE workload.
+	metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+	// tokenExpiryMargin renews a metadata token this long before it expires.
+	tokenExpiryMargin = time.Minute
+
+	// maxErrorBody bounds the response body quoted in the errors.
+	maxErrorBody = 512
+)
+
+var (
+	// ErrUnknownSink is returned by NewSink for an unknown sink name.
+	ErrUnknownSink = errors.New("unknown access log sink")
+
+	// ErrURLRequired is returned by NewSink if the URL of the sink is needed
+	// but empty.
+	ErrURLRequired = errors.New("the URL of the access log sink is required")
+
+	// ErrTableRequired is returned by NewSink if the table of the sink is
+	// needed but empty or malformed.
+	ErrTableRequired = errors.New("the table of the access log sink is required")
+
+	// ErrExport is returned when the sink rejects a batch.
+	ErrExport = errors.New("error exporting the access log records")
+)
+
+// SinkConfig configures the sink built by NewSink.
+type SinkConfig struct {
+	// Name is the kind of the sink: SinkHTTP, SinkClickHouse or SinkBigQuery.
+	Name string
+
+	// URL is where the records are sent: the endpoint of SinkHTTP, or the
+	// HTTP interface of ClickHouse, such as http://clickhouse:8123. It
+	// defaults to the BigQuery API for SinkBigQuery.
+	URL string
+
+	// Table is the table the records are inserted into: database.table for
+	// SinkClickHouse, project.dataset.table for SinkBigQuery.
+	Table string
+
+	// Header is sent with every request, such as the credentials of the sink.
+	// For SinkBigQuery, the token of the service account of the GCE instance
+	// or GKE workload is used unless it has an Authorization header.
+	Header http.Header
+
+	// HTTPClient sends the requests. If nil, an instrumented client with a
+	// 30 seconds timeout is used.
+	HTTPClient *http.Client
+}
+
+// NewSink returns the sink described by cfg.
+func NewSink(cfg SinkConfig) (Sink, error) {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   30 * time.Second,
+		}
+	}
+
+	switch cfg.Name {
+	case SinkHTTP:
+		if cfg.URL == "" {
+			return nil, ErrURLRequired
+		}
+
+		return &httpSink{client: client, url: cfg.URL, header: cfg.Header}, nil
+	case SinkClickHouse:
+		if cfg.URL == "" {
+			return nil, ErrURLRequired
+		}
+
+		if cfg.Table == "" {
+			return nil, ErrTableRequired
+		}
+
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the ClickHouse URL: %w", err)
+		}
+
+		q := u.Query()
+		q.Set("query", "INSERT INTO "+cfg.Table+" FORMAT JSONEachRow")
+		q.Set("date_time_input_format", "best_effort")
+		u.RawQuery = q.Encode()
+
+		return &httpSink{client: client, url: u.String(), header: cfg.Header}, nil
+	case SinkBigQuery:
+		return newBigQuerySink(client, cfg)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSink, cfg.Name)
+	}
+}
+
+// httpSink POSTs the records as newline-delimited JSON, the format of the
+// JSONEachRow inserts of ClickHouse.
+type httpSink struct {
+	client *http.Client
+	url    string
+	header http.Header
+}
+
+func (s *httpSink) Export(ctx context.Context, records []Record) error {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return fmt.Errorf("error encoding the access log record: %w", err)
+		}
+	}
+
+	return post(ctx, s.client, s.url, "application/x-ndjson", s.header, &buf)
+}
+
+// bigQuerySink streams the records with the insertAll API of BigQuery.
+type bigQuerySink struct {
+	client *http.Client
+	url    string
+	header http.Header
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func newBigQuerySink(client *http.Client, cfg SinkConfig) (*bigQuerySink, error) {
+	parts := strings.Split(cfg.Table, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("%w: %q is not project.dataset.table", ErrTableRequired, cfg.Table)
+	}
+
+	base := cfg.URL
+	if base == "" {
+		base = defaultBigQueryURL
+	}
+
+	return &bigQuerySink{
+		client: client,
+		url: fmt.Sprintf("%s/projects/%s/datasets/%s/tables/%s/insertAll",
+			strings.TrimSuffix(base, "/"),
+			url.PathEscape(parts[0]),
+			url.PathEscape(parts[1]),
+			url.PathEscape(parts[2]),
+		),
+		header: cfg.Header,
+	}, nil
+}
+
+type bigQueryRow struct {
+	JSON Record `json:"json"`
+}
+
+type bigQueryInsertAll struct {
+	Rows []bigQueryRow `json:"rows"`
+}
+
+type bigQueryInsertAllResponse struct {
+	InsertErrors []json.RawMessage `json:"insertErrors"`
+}
+
+func (s *bigQuerySink) Export(ctx context.Context, records []Record) error {
+	in := bigQueryInsertAll{Rows: make([]bigQueryRow, 0, len(records))}
+	for _, r := range records {
+		in.Rows = append(in.Rows, bigQueryRow{JSON: r})
+	}
+
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("error encoding the access log records: %w", err)
+	}
+
+	header := s.header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+
+	if header.Get("Authorization") == "" {
+		token, err := s.metadataToken(ctx)
+		if err != nil {
+			return err
+		}
+
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating the request: %w", err)
+	}
+
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending the access log records: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	var out bigQueryInsertAllResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("error decoding the insertAll response: %w", err)
+	}
+
+	if len(out.InsertErrors) > 0 {
+		return fmt.Errorf("%w: %d rows were rejected", ErrExport, len(out.InsertErrors))
+	}
+
+	return nil
+}
+
+// metadataToken returns the access token of the service account from the
+// metadata server, caching it until shortly before it expires.
+func (s *bigQuerySink) metadataToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataTokenURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating the request: %w", err)
+	}
+
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error getting the token from the metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("error decoding the token of the metadata server: %w", err)
+	}
+
+	s.token = token.AccessToken
+	s.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpiryMargin)
+
+	return s.token, nil
+}
+
+func post(
+	ctx context.Context,
+	client *http.Client,
+	u, contentType string,
+	header http.Header,
+	body io.Reader,
+) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, body)
+	if err != nil {
+		return fmt.Errorf("error creating the request: %w", err)
+	}
+
+	for k, vs := range header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending the access log records: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp)
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return nil
+}
+
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	return fmt.Errorf("%w: %s: %s", ErrExport, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,146 @@
+// Package bloom implements the bloom filter the caches exchange to tell which
+// narinfos they probably hold, so a peer is only asked for the narinfos its
+// filter does not rule out.
+//
+// A filter never misses a key it was given, but it may report a key it was
+// not given, at the false positive rate it was sized for.
+package bloom
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// formatVersion is the version of the binary encoding of a filter.
+	formatVersion = 1
+
+	// headerSize is the size of the encoding before the bits: the version, the
+	// number of hash functions and the number of bits.
+	headerSize = 1 + 1 + 8
+
+	// minBits is the size of the filter of an empty set.
+	minBits = 64
+
+	// maxHashes bounds the number of hash functions of a filter.
+	maxHashes = 32
+)
+
+// ErrInvalidFilter is returned by UnmarshalBinary for data that is not the
+// encoding of a filter.
+var ErrInvalidFilter = errors.New("invalid bloom filter")
+
+// Filter is a bloom filter of strings.
+type Filter struct {
+	words  []uint64
+	bits   uint64
+	hashes uint8
+}
+
+// New returns an empty filter sized for n keys at the false positive rate
+// fpRate, between 0 and 1 exclusive.
+func New(n int, fpRate float64) *Filter {
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.01
+	}
+
+	n = max(n, 1)
+
+	bits := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	bits = max(bits, minBits)
+
+	hashes := uint8(min(max(math.Round(float64(bits)/float64(n)*math.Ln2), 1), maxHashes))
+
+	return &Filter{
+		words:  make([]uint64, (bits+63)/64),
+		bits:   bits,
+		hashes: hashes,
+	}
+}
+
+// Add adds key to the filter.
+func (f *Filter) Add(key string) {
+	h1, h2 := hash(key)
+
+	for i := range uint64(f.hashes) {
+		bit := (h1 + i*h2) % f.bits
+		f.words[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// Test reports whether key may have been added to the filter; false means it
+// was not.
+func (f *Filter) Test(key string) bool {
+	h1, h2 := hash(key)
+
+	for i := range uint64(f.hashes) {
+		bit := (h1 + i*h2) % f.bits
+		if f.words[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Bits returns the size of the filter, in bits.
+func (f *Filter) Bits() uint64 { return f.bits }
+
+// MarshalBinary encodes the filter.
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	data := make([]byte, headerSize, headerSize+8*len(f.words))
+
+	data[0] = formatVersion
+	data[1] = f.hashes
+	binary.BigEndian.PutUint64(data[2:], f.bits)
+
+	for _, w := range f.words {
+		data = binary.BigEndian.AppendUint64(data, w)
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary decodes a filter encoded by MarshalBinary.
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	if len(data) < headerSize {
+		return fmt.Errorf("%w: %d bytes", ErrInvalidFilter, len(data))
+	}
+
+	if data[0] != formatVersion {
+		return fmt.Errorf("%w: unknown version %d", ErrInvalidFilter, data[0])
+	}
+
+	hashes := data[1]
+	bits := binary.BigEndian.Uint64(data[2:])
+
+	if hashes == 0 || hashes > maxHashes || bits == 0 {
+		return fmt.Errorf("%w: %d hashes over %d bits", ErrInvalidFilter, hashes, bits)
+	}
+
+	words := data[headerSize:]
+	if uint64(len(words)) != (bits+63)/64*8 {
+		return fmt.Errorf("%w: %d bytes for %d bits", ErrInvalidFilter, len(words), bits)
+	}
+
+	f.words = make([]uint64, len(words)/8)
+	for i := range f.words {
+		f.words[i] = binary.BigEndian.Uint64(words[8*i:])
+	}
+
+	f.bits = bits
+	f.hashes = hashes
+
+	return nil
+}
+
+// hash returns the two hashes of key the bits of the filter are derived from,
+// the second one odd.
+func hash(key string) (uint64, uint64) {
+	sum := sha256.Sum256([]byte(key))
+
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:16]) | 1
+}
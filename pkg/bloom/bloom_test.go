@@ -0,0 +1,58 @@
+package bloom_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/bloom"
+)
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	const n = 10000
+
+	f := bloom.New(n, 0.01)
+
+	for i := range n {
+		f.Add("in-" + strconv.Itoa(i))
+	}
+
+	for i := range n {
+		require.True(t, f.Test("in-"+strconv.Itoa(i)), "a key added is never missed")
+	}
+
+	falsePositives := 0
+
+	for i := range n {
+		if f.Test("out-" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+
+	assert.Less(t, falsePositives, n*2/100, "the false positive rate is about the one sized for")
+}
+
+func TestMarshalBinary(t *testing.T) {
+	t.Parallel()
+
+	f := bloom.New(100, 0.01)
+	f.Add("0a5rhbyz3lrgvvjg3spsx6vbsh6kvxb3")
+
+	data, err := f.MarshalBinary()
+	require.NoError(t, err)
+
+	var got bloom.Filter
+	require.NoError(t, got.UnmarshalBinary(data))
+
+	assert.Equal(t, f.Bits(), got.Bits())
+	assert.True(t, got.Test("0a5rhbyz3lrgvvjg3spsx6vbsh6kvxb3"))
+	assert.False(t, got.Test("1b6sicz04msgwwkh4tqty7wcti7lwyc4"))
+
+	for _, bad := range [][]byte{nil, data[:5], data[:len(data)-1], append([]byte{2}, data[1:]...)} {
+		require.ErrorIs(t, got.UnmarshalBinary(bad), bloom.ErrInvalidFilter)
+	}
+}
@@ -232,7 +232,7 @@ func (c *Cache) signBuildTrace(ctx context.Context, entry *buildTraceEntryJSON)
 		return fmt.Errorf("fingerprint: %w", err)
 	}
 
-	sig, err := c.secretKey.Sign(nil, fp)
+	sig, err := c.signer.Sign(ctx, fp)
 	if err != nil {
 		return fmt.Errorf("sign: %w", err)
 	}
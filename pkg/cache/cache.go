@@ -16,6 +16,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nix-community/go-nix/pkg/narinfo"
@@ -39,15 +40,18 @@ import (
 	entpinnedclosure "github.com/kalbasit/ncps/ent/pinnedclosure"
 
 	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/ent/predicate"
 	"github.com/kalbasit/ncps/pkg/analytics"
 	"github.com/kalbasit/ncps/pkg/cache/healthcheck"
 	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/cache/upstream/ipfs"
 	"github.com/kalbasit/ncps/pkg/chunker"
 	"github.com/kalbasit/ncps/pkg/config"
 	"github.com/kalbasit/ncps/pkg/database"
 	"github.com/kalbasit/ncps/pkg/helper"
 	"github.com/kalbasit/ncps/pkg/lock"
 	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/signer"
 	"github.com/kalbasit/ncps/pkg/storage"
 	"github.com/kalbasit/ncps/pkg/storage/chunk"
 	"github.com/kalbasit/ncps/pkg/zstd"
@@ -76,6 +80,17 @@ const (
 	// almost every install.
 	cdcCleanupHashBatchSize = 500
 
+	// defaultLRUDeleteConcurrency is the number of files the LRU deletes from
+	// the stores at once, unless SetLRUDeleteConcurrency changes it.
+	defaultLRUDeleteConcurrency = 32
+
+	// lruDeleteBatchSize is the number of files the LRU deletes between two
+	// progress logs.
+	lruDeleteBatchSize = 1000
+
+	// lruBatchSize is the number of narinfos the LRU evicts per transaction.
+	lruBatchSize = 100
+
 	// Migration operation constants for metrics.
 	migrationOperationMigrate = "migrate"
 	migrationOperationDelete  = "delete"
@@ -104,8 +119,27 @@ const (
 // narInfoJobKey returns the key used for tracking narinfo download jobs.
 func narInfoJobKey(hash string) string { return "download:narinfo:" + hash }
 
-// narJobKey returns the key used for tracking NAR download jobs.
-func narJobKey(hash string) string { return "download:nar:" + hash }
+// narJobKeyPrefix prefixes the keys used for tracking NAR download jobs.
+const narJobKeyPrefix = "download:nar:"
+
+// narJobKey returns the key used for tracking the download jobs of the NAR at
+// narURL. Every representation of a NAR, its compression and canonical query,
+// is stored apart and gets its own job. With CDC every compression of a NAR is
+// stored as the same chunks, so they share the job of the uncompressed NAR and
+// a download serves the clients asking for either.
+func (c *Cache) narJobKey(narURL nar.URL) string {
+	compression := narURL.Compression
+	if c.isCDCEnabled() {
+		compression = nar.CompressionTypeNone
+	}
+
+	key := narJobKeyPrefix + narURL.Hash + ":" + compression.String()
+	if q := narURL.CanonicalQuery(); q != "" {
+		key += "?" + q
+	}
+
+	return key
+}
 
 // narInfoLockKey returns the lock key used for narinfo operations.
 func narInfoLockKey(hash string) string { return "narinfo:" + hash }
@@ -163,14 +197,19 @@ var (
 	ErrNoNarHashToVerify = errors.New("no narinfo NarHash to verify reconstructed nar against")
 
 	// ErrNarHashMismatch is returned by MigrateChunksToNar when the bytes
-	// reconstructed from chunks do not match the recorded NarHash or size.
-	ErrNarHashMismatch = errors.New("reconstructed nar does not match recorded hash or size")
+	// reconstructed from chunks do not match the recorded NarHash or size. It
+	// wraps ErrIntegrity.
+	ErrNarHashMismatch = fmt.Errorf("%w: reconstructed nar does not match recorded hash or size", ErrIntegrity)
 
 	// ErrMissingChunk is returned by MigrateChunksToNar when one or more chunks
 	// referenced by the nar_file are absent from the chunk store or the DB. The
 	// NAR cannot be reconstructed and should be purged so it can be re-fetched.
 	ErrMissingChunk = errors.New("one or more chunks missing from store")
 
+	// ErrNarPresignUnsupported is returned by PresignNarURL when the configured
+	// nar store cannot hand out presigned URLs (e.g. local storage).
+	ErrNarPresignUnsupported = errors.New("nar store does not support presigned URLs")
+
 	errMissingChunkEdge = errors.New("nar_file_chunk is missing eager-loaded chunk edge")
 
 	errChunkIDFetchMismatch = errors.New("chunk count mismatch after bulk insert")
@@ -187,6 +226,30 @@ var (
 	//nolint:gochecknoglobals
 	narInfoServedCount metric.Int64Counter
 
+	//nolint:gochecknoglobals
+	referencePrefetchTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	narInfoRevalidationTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	narInfoPurgesTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	narInfoMetadataCacheTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	narInfoInvalidationsTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	chunkRepairTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	chunkIngestTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	chunkIngestBytesTotal metric.Int64Counter
+
 	//nolint:gochecknoglobals
 	totalSizeMetric metric.Int64ObservableGauge
 
@@ -223,6 +286,13 @@ var (
 	//nolint:gochecknoglobals
 	cacheMaxSizeBytes metric.Int64ObservableGauge
 
+	// Chunk store size metrics
+	//nolint:gochecknoglobals
+	chunkStoreSizeBytes metric.Int64ObservableGauge
+
+	//nolint:gochecknoglobals
+	chunkStoreMaxSizeBytes metric.Int64ObservableGauge
+
 	// Upstream fetch duration metrics
 	//nolint:gochecknoglobals
 	upstreamNarInfoFetchDuration metric.Float64Histogram
@@ -240,6 +310,61 @@ var (
 	// Download coordination metrics
 	//nolint:gochecknoglobals // package-level OTel metric instrument, initialized once in init() and reused.
 	downloadCoordinationFallbackTotal metric.Int64Counter
+
+	// Latency and concurrency metrics
+	//nolint:gochecknoglobals
+	upstreamNarTTFB metric.Float64Histogram
+
+	//nolint:gochecknoglobals
+	narInfoHedgesTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	narInfoConflictsTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	narUploadDedupTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	narInfoAliasesTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	shadowComparisonsTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	shadowNarInfoDuration metric.Float64Histogram
+
+	//nolint:gochecknoglobals
+	missingReferencesTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	referencePullsTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	referenceWaitTimeoutsTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	narSizeMismatchesTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	narStoreDecisionsTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	narInfoFixupsTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	upstreamIPFSNarFetchesTotal metric.Int64Counter
+
+	//nolint:gochecknoglobals
+	narServeTTFB metric.Float64Histogram
+
+	//nolint:gochecknoglobals
+	narStreamDuration metric.Float64Histogram
+
+	//nolint:gochecknoglobals
+	narDownloadsInFlight metric.Int64UpDownCounter
+
+	//nolint:gochecknoglobals
+	narUploadsInFlight metric.Int64UpDownCounter
 )
 
 //nolint:gochecknoinits
@@ -267,6 +392,78 @@ func init() {
 		panic(err)
 	}
 
+	referencePrefetchTotal, err = meter.Int64Counter(
+		"ncps_narinfo_reference_prefetch_total",
+		metric.WithDescription("Counts the narinfos speculatively prefetched from the references of served narinfos."),
+		metric.WithUnit("{file}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narInfoRevalidationTotal, err = meter.Int64Counter(
+		"ncps_narinfo_revalidation_total",
+		metric.WithDescription("Counts the cached narinfos revalidated against their upstream."),
+		metric.WithUnit("{file}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narInfoPurgesTotal, err = meter.Int64Counter(
+		"ncps_narinfo_purges_total",
+		metric.WithDescription("Counts the narinfo purges, by result: purged, quarantined or error."),
+		metric.WithUnit("{file}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narInfoMetadataCacheTotal, err = meter.Int64Counter(
+		"ncps_narinfo_metadata_cache_requests_total",
+		metric.WithDescription("Counts the narinfo lookups of the shared metadata cache, by result: hit, miss or error."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narInfoInvalidationsTotal, err = meter.Int64Counter(
+		"ncps_narinfo_invalidations_total",
+		metric.WithDescription("Counts the narinfo invalidations exchanged between the replicas, by direction: sent or received."),
+		metric.WithUnit("{narinfo}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	chunkRepairTotal, err = meter.Int64Counter(
+		"ncps_chunk_repair_total",
+		metric.WithDescription("Counts the repairs of chunked NARs re-fetched from their upstream."),
+		metric.WithUnit("{file}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	chunkIngestTotal, err = meter.Int64Counter(
+		"ncps_chunk_ingest_total",
+		metric.WithDescription("Counts the chunks produced by CDC, by whether they were new or already stored."),
+		metric.WithUnit("{chunk}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	chunkIngestBytesTotal, err = meter.Int64Counter(
+		"ncps_chunk_ingest_bytes_total",
+		metric.WithDescription("Counts the uncompressed bytes of the chunks produced by CDC, by whether they were new."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
 	totalSizeMetric, err = meter.Int64ObservableGauge(
 		"ncps_store_total_size_bytes",
 		metric.WithDescription("The total size of all NAR files in the store."),
@@ -369,6 +566,24 @@ func init() {
 		panic(err)
 	}
 
+	chunkStoreSizeBytes, err = meter.Int64ObservableGauge(
+		"ncps_chunk_store_size_bytes",
+		metric.WithDescription("Bytes taken by the unique chunks in the chunk store."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	chunkStoreMaxSizeBytes, err = meter.Int64ObservableGauge(
+		"ncps_chunk_store_max_size_bytes",
+		metric.WithDescription("Configured maximum size of the chunk store in bytes."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
 	// Initialize upstream fetch duration metrics
 	upstreamNarInfoFetchDuration, err = meter.Float64Histogram(
 		"ncps_upstream_narinfo_fetch_duration_seconds",
@@ -417,6 +632,178 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+
+	upstreamNarTTFB, err = meter.Float64Histogram(
+		"ncps_upstream_nar_ttfb_seconds",
+		metric.WithDescription("Time from requesting a NAR from an upstream cache until its response headers arrive."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narInfoHedgesTotal, err = meter.Int64Counter(
+		"ncps_upstream_narinfo_hedges_total",
+		metric.WithDescription("Counts the hedged narinfo probes, by whether they found the narinfo first."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narInfoConflictsTotal, err = meter.Int64Counter(
+		"ncps_upstream_narinfo_conflicts_total",
+		metric.WithDescription("Counts the upstream narinfos conflicting with the one picked by the conflict policy, "+
+			"by policy and kind: content, representation or signatures."),
+		metric.WithUnit("{narinfo}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narUploadDedupTotal, err = meter.Int64Counter(
+		"ncps_nar_upload_dedup_total",
+		metric.WithDescription("Counts the NAR uploads of a NAR already stored, by result: deduplicated "+
+			"(the write was skipped) or mismatch (the upload did not match the FileHash of the stored NAR and was written)."),
+		metric.WithUnit("{upload}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narInfoAliasesTotal, err = meter.Int64Counter(
+		"ncps_narinfo_aliases_total",
+		metric.WithDescription("Counts the narinfos pulled from upstream aliased to the stored NAR of the same content."),
+		metric.WithUnit("{narinfo}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	shadowComparisonsTotal, err = meter.Int64Counter(
+		"ncps_upstream_shadow_comparisons_total",
+		metric.WithDescription("Counts the narinfo answers of the shadow upstreams compared with the served ones, "+
+			"by outcome: agree, disagree or error."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	shadowNarInfoDuration, err = meter.Float64Histogram(
+		"ncps_upstream_shadow_narinfo_duration_seconds",
+		metric.WithDescription("Time to fetch a narinfo from a shadow upstream, and from the upstream it is "+
+			"compared with, by role: shadow or primary."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	missingReferencesTotal, err = meter.Int64Counter(
+		"ncps_narinfo_missing_references_total",
+		metric.WithDescription("Counts the references, missing from the cache, of the narinfos served, "+
+			"by missing references policy."),
+		metric.WithUnit("{reference}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	referencePullsTotal, err = meter.Int64Counter(
+		"ncps_narinfo_reference_pulls_total",
+		metric.WithDescription("Counts the missing references pulled into the cache, "+
+			"by result: cached, not_found, error or dropped."),
+		metric.WithUnit("{reference}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	referenceWaitTimeoutsTotal, err = meter.Int64Counter(
+		"ncps_narinfo_reference_wait_timeouts_total",
+		metric.WithDescription("Counts the narinfos served by the strict missing references policy before "+
+			"all their references were cached."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narSizeMismatchesTotal, err = meter.Int64Counter(
+		"ncps_upstream_nar_size_mismatches_total",
+		metric.WithDescription("Counts the NARs downloaded from upstream whose size does not match their narinfo, "+
+			"by action: warned or rejected."),
+		metric.WithUnit("{nar}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narStoreDecisionsTotal, err = meter.Int64Counter(
+		"ncps_nar_store_decisions_total",
+		metric.WithDescription("Counts the uncompressed NARs stored, by decision: compressed or raw."),
+		metric.WithUnit("{nar}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narInfoFixupsTotal, err = meter.Int64Counter(
+		"ncps_narinfo_fixups_total",
+		metric.WithDescription("Counts the automatic fixes of narinfo fields, by trigger (inline or background), "+
+			"field and reason."),
+		metric.WithUnit("{fix}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	upstreamIPFSNarFetchesTotal, err = meter.Int64Counter(
+		"ncps_upstream_ipfs_nar_fetches_total",
+		metric.WithDescription("Counts the NARs no upstream serves looked up on the IPFS gateways, by result "+
+			"(hit, miss or error)."),
+		metric.WithUnit("{fetch}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narServeTTFB, err = meter.Float64Histogram(
+		"ncps_nar_serve_ttfb_seconds",
+		metric.WithDescription("Time from a NAR request until its first byte is handed to the client."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narStreamDuration, err = meter.Float64Histogram(
+		"ncps_nar_stream_duration_seconds",
+		metric.WithDescription("Duration of NAR streams served to or uploaded by clients."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narDownloadsInFlight, err = meter.Int64UpDownCounter(
+		"ncps_nar_downloads_in_flight",
+		metric.WithDescription("Number of NAR downloads from upstream caches currently in progress."),
+		metric.WithUnit("{download}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narUploadsInFlight, err = meter.Int64UpDownCounter(
+		"ncps_nar_uploads_in_flight",
+		metric.WithDescription("Number of NAR uploads from clients currently in progress."),
+		metric.WithUnit("{upload}"),
+	)
+	if err != nil {
+		panic(err)
+	}
 }
 
 // PrimeMetrics records a zero-valued measurement on every counter instrument in
@@ -433,6 +820,14 @@ func PrimeMetrics(ctx context.Context) {
 	counters := []metric.Int64Counter{
 		narServedCount,
 		narInfoServedCount,
+		referencePrefetchTotal,
+		narInfoRevalidationTotal,
+		narInfoPurgesTotal,
+		narInfoMetadataCacheTotal,
+		narInfoInvalidationsTotal,
+		chunkRepairTotal,
+		chunkIngestTotal,
+		chunkIngestBytesTotal,
 		lruCleanupRunsTotal,
 		lruNarInfosEvictedTotal,
 		lruNarFilesEvictedTotal,
@@ -440,6 +835,18 @@ func PrimeMetrics(ctx context.Context) {
 		lruBytesFreedTotal,
 		backgroundMigrationObjectsTotal,
 		downloadCoordinationFallbackTotal,
+		narInfoHedgesTotal,
+		narInfoConflictsTotal,
+		narUploadDedupTotal,
+		narInfoAliasesTotal,
+		shadowComparisonsTotal,
+		missingReferencesTotal,
+		referencePullsTotal,
+		referenceWaitTimeoutsTotal,
+		narSizeMismatchesTotal,
+		narStoreDecisionsTotal,
+		narInfoFixupsTotal,
+		upstreamIPFSNarFetchesTotal,
 	}
 
 	for _, c := range counters {
@@ -449,15 +856,36 @@ func PrimeMetrics(ctx context.Context) {
 
 		c.Add(ctx, 0)
 	}
+
+	for _, g := range []metric.Int64UpDownCounter{narDownloadsInFlight, narUploadsInFlight} {
+		if g == nil {
+			continue
+		}
+
+		g.Add(ctx, 0)
+	}
 }
 
 // Cache represents the main cache service.
 type Cache struct {
 	hostName      string
 	secretKey     signature.SecretKey
+	signer        signer.Signer
 	healthChecker *healthcheck.HealthChecker
 	maxSize       uint64
 
+	// chunkStoreMaxSize bounds the bytes of the unique chunks, enforced by the
+	// LRU. See SetChunkStoreMaxSize.
+	chunkStoreMaxSize uint64
+
+	// maintenanceWindows restricts the heavy cron jobs; see
+	// SetMaintenanceWindows.
+	maintenanceWindows maintenanceWindows
+
+	// evictionExclusions are the store path patterns the LRU never evicts;
+	// see SetEvictionExclusions.
+	evictionExclusions []evictionExclusion
+
 	dbClient *database.Client
 
 	// tempDir is used to store nar files temporarily.
@@ -474,6 +902,18 @@ type Cache struct {
 	cdcMu      sync.RWMutex
 	cdcEnabled bool
 	chunker    chunker.Chunker
+	cdcSizes   chunkSizes
+
+	// cdcSizeClasses are sorted by maxNarSize; see SetCDCSizeClasses.
+	cdcSizeClasses []cdcSizeClass
+
+	// cdcJobs counts the chunking jobs admitted by beginCDCJob that are still
+	// running; DisableCDC waits for it to drop to zero.
+	cdcJobs int
+
+	// chunkStoreFactory builds the chunk store when CDC is enabled at runtime
+	// without one. See SetChunkStoreFactory.
+	chunkStoreFactory ChunkStoreFactory
 
 	// Lazy chunking configuration
 	cdcLazyChunkingEnabled bool
@@ -490,9 +930,21 @@ type Cache struct {
 	inflightStagingPartSize  int64
 	lockerIsDistributed      bool
 
+	// interruptedDownloads holds, by job key, the expiry of the locks of the
+	// downloads interrupted by a restart. See RecoverInterruptedDownloads.
+	interruptedDownloadsMu sync.Mutex
+	interruptedDownloads   map[string]time.Time
+
 	// Should the cache sign the narinfos?
 	shouldSignNarinfo bool
 
+	// storeDirRewrite, when set, maps the store dir of the stored narinfos to
+	// the one of the served narinfos. See SetStoreDirRewrite.
+	storeDirRewrite *storeDirRewrite
+
+	// narInfoFields are set on the served narinfos. See SetNarInfoFields.
+	narInfoFields []NarInfoField
+
 	// requireTrustedSignature, when true, makes PutNarInfo reject any narinfo
 	// that does not carry at least one signature validating against the
 	// configured trusted upload keys. Default false preserves prior behavior.
@@ -512,6 +964,17 @@ type Cache struct {
 	// is locked` errors
 	recordAgeIgnoreTouch time.Duration
 
+	// touchMode selects how the records served are touched. See SetTouchMode.
+	touchMode TouchMode
+
+	// ipfsGateways are the IPFS gateways of the last-resort tier, nil unless
+	// set. See SetIPFSGateways.
+	ipfsGateways *ipfs.Gateways
+
+	// touchesInFlight holds the keys of the records being touched in the
+	// background, so a record is not touched twice at once.
+	touchesInFlight sync.Map
+
 	// Lock abstraction (can be local or distributed)
 	downloadLocker      lock.Locker
 	cacheLocker         lock.RWLocker
@@ -530,11 +993,130 @@ type Cache struct {
 	// for jobs. Protected by upstreamJobsMu for local synchronization.
 	upstreamJobsMu sync.Mutex
 	upstreamJobs   map[string]*downloadState
+	// chunkingJobs tracks the progress of the chunking jobs in flight by NAR
+	// hash. See Jobs.
+	chunkingJobsMu sync.Mutex
+	chunkingJobs   map[string]*chunkingProgress
 	cron           *cron.Cron
+	// cronJobs tracks the jobs registered with cron. See CronJobs.
+	cronJobs cronJobs
+	// backgroundJobs tracks the outcome of the other background jobs. See
+	// HealthReport.
+	backgroundJobs backgroundJobs
 	// upstreamCachesMu protects upstreamCaches
 	upstreamCachesMu sync.RWMutex
 	upstreamCaches   []*upstream.Cache
 
+	// upstreamDiscovery, when set, manages the upstream caches returned by a
+	// discovery source. See SetUpstreamDiscovery.
+	upstreamDiscovery *upstreamDiscovery
+
+	// prewarm, when set, configures the closures pre-warmed by Prewarm. See
+	// SetPrewarm.
+	prewarm *prewarmConfig
+
+	// channelPrefetch, when set, configures the channels pre-fetched by
+	// PrefetchChannels. See SetChannelPrefetch.
+	channelPrefetch *channelPrefetchConfig
+
+	// referencePrefetch, when set, holds the narinfos prefetched from the
+	// references of served narinfos. See SetReferencePrefetch.
+	referencePrefetch *referencePrefetch
+
+	// narSizeCheck configures the check of the size of the NARs downloaded
+	// from the upstreams. See SetUpstreamNarSizeCheck.
+	narSizeCheck narSizeCheck
+
+	// offline, when set, keeps the cache from contacting its upstreams. See
+	// SetOffline.
+	offline atomic.Bool
+
+	// missingReferences, when set, configures the handling of the narinfos
+	// served with references missing from the cache. See
+	// SetMissingReferencesPolicy.
+	missingReferences *missingReferences
+
+	// narInfoRevalidation, when set, configures the revalidation of cached
+	// narinfos against their upstream. See SetNarInfoRevalidation.
+	narInfoRevalidation *narInfoRevalidation
+
+	// narInfoPurgeGuard, when set, limits the rate of the narinfo purges. See
+	// SetNarInfoPurgeLimit.
+	narInfoPurgeGuard *narInfoPurgeGuard
+
+	// chunkRepair, when set, enables the background repair of chunked NARs
+	// found damaged while serving them. See SetChunkRepair.
+	chunkRepair *chunkRepair
+
+	// upstreamFetchStrategy selects how NARs are fetched from the upstreams. The
+	// zero value is UpstreamFetchStrategySelect. See SetUpstreamFetchStrategy.
+	upstreamFetchStrategy UpstreamFetchStrategy
+
+	// narInfoMetadataCache, when set, serves the narinfos before the database.
+	// See SetNarInfoMetadataCache.
+	narInfoMetadataCache NarInfoMetadataCache
+
+	// narInfoWarmUp, when set, holds the hot narinfos preloaded by WarmUp.
+	// See SetNarInfoWarmUp.
+	narInfoWarmUp *narInfoWarmUp
+
+	// warmingUp is set until the warm-up configured by SetNarInfoWarmUp is
+	// over. See IsReady.
+	warmingUp atomic.Bool
+
+	// narInfoInvalidation, when set, notifies the other replicas of the
+	// narinfos stored or deleted. See SetNarInfoInvalidation.
+	narInfoInvalidation *narInfoInvalidation
+
+	// cdcUploadLookahead, when positive, chunks the uploaded NARs straight from
+	// the request body. See SetCDCUploadStreaming.
+	cdcUploadLookahead int
+
+	// cdcIngestWorkers is the number of chunks of a NAR stored at once while
+	// chunking it. See SetCDCIngestWorkers.
+	cdcIngestWorkers int
+
+	// narInfoCompression selects the compression advertised by the rewritten
+	// narinfos. The zero value is NarInfoCompressionNone. See
+	// SetNarInfoCompression.
+	narInfoCompression NarInfoCompression
+
+	// narInfoConflictPolicy selects the narinfo served when several upstreams
+	// have one, narInfoPreferredKeys being the keys of prefer-signed-by. The
+	// zero value is NarInfoConflictPolicyFastest. See SetNarInfoConflictPolicy.
+	narInfoConflictPolicy NarInfoConflictPolicy
+	narInfoPreferredKeys  []signature.PublicKey
+
+	// narInfoAliases links the narinfos pulled from upstream to the stored NAR
+	// of the same content. See SetNarInfoAliases.
+	narInfoAliases bool
+
+	// lruDeleteConcurrency bounds the files the LRU deletes from the stores at
+	// once. See SetLRUDeleteConcurrency.
+	lruDeleteConcurrency int
+
+	// narSampleSize and narMinRatio decide whether an uncompressed NAR is
+	// stored raw. See SetNarCompressionSampling.
+	narSampleSize int64
+	narMinRatio   float64
+
+	// narInfoHedging, when set, hedges the narinfo HEAD probes instead of
+	// sending them to every healthy upstream at once. See SetNarInfoHedging.
+	narInfoHedging *narInfoHedging
+
+	// shadowUpstreams are queried alongside the upstream serving each narinfo,
+	// without serving their answers. See AddShadowUpstreamCaches.
+	shadowUpstreamsMu sync.RWMutex
+	shadowUpstreams   []*shadowUpstream
+
+	// chunkTiering, when set, counts the chunk reads for the chunk tiering. See
+	// SetChunkTiering.
+	chunkTiering *chunkTiering
+
+	// narInfoIndex is the narinfo index last built. See BuildNarInfoIndex.
+	narInfoIndexMu sync.RWMutex
+	narInfoIndex   *NarInfoIndex
+
 	// Wait group to track background operations
 	backgroundWG sync.WaitGroup
 
@@ -556,11 +1138,23 @@ type downloadState struct {
 	cdcWg               sync.WaitGroup // Tracks CDC background goroutine; zero by default (non-CDC)
 	closed              bool           // Indicates whether new readers are allowed (protected by mu)
 	assetPath           string
-	compressedAssetPath string // If non-empty, we are decompressing from here to assetPath
 	bytesWritten        int64
 	finalSize           int64
+	expectedSize        int64               // Expected size of the temp file, zero while unknown
+	startedAt           time.Time           // Reported by Jobs
 	tempFileCompression nar.CompressionType // Actual compression of bytes written to the temp file
 
+	// compressedAssetPath, if non-empty, holds the compressed upstream bytes
+	// being decompressed to assetPath, so a client requesting the upstream's
+	// compression is served by the same download. Its progress is tracked by
+	// compressedBytesWritten and compressedFinalSize (protected by mu), and
+	// compressedError is set if the file could not be completed.
+	compressedAssetPath    string
+	compressedCompression  nar.CompressionType
+	compressedBytesWritten int64
+	compressedFinalSize    int64
+	compressedError        error
+
 	// Store any download errors in this field
 	downloadError error
 
@@ -585,9 +1179,10 @@ type downloadState struct {
 
 func newDownloadState() *downloadState {
 	ds := &downloadState{
-		done:   make(chan struct{}),
-		start:  make(chan struct{}),
-		stored: make(chan struct{}),
+		done:      make(chan struct{}),
+		start:     make(chan struct{}),
+		stored:    make(chan struct{}),
+		startedAt: time.Now(),
 	}
 
 	ds.cond = sync.NewCond(&ds.mu)
@@ -599,17 +1194,37 @@ func newDownloadState() *downloadState {
 // blocking (via ds.cond.Wait) when the download is still in progress. Returns io.EOF
 // once all expected bytes (ds.finalSize) have been consumed. Used to drive streaming
 // decompression from a temp file while a download is still writing to it.
+//
+// With compressed set, it reads the compressed temp file (ds.compressedAssetPath)
+// instead.
 type fileAvailableReader struct {
-	f      *os.File
-	ds     *downloadState
-	offset int64
-	ctx    context.Context
+	f          *os.File
+	ds         *downloadState
+	offset     int64
+	ctx        context.Context
+	compressed bool
+}
+
+// progress returns the bytes written to the file read and its final size, zero
+// until complete, and the error preventing its completion. ds.mu must be held.
+func (r *fileAvailableReader) progress() (int64, int64, error) {
+	if r.compressed {
+		if r.ds.downloadError != nil {
+			return r.ds.compressedBytesWritten, r.ds.compressedFinalSize, r.ds.downloadError
+		}
+
+		return r.ds.compressedBytesWritten, r.ds.compressedFinalSize, r.ds.compressedError
+	}
+
+	return r.ds.bytesWritten, r.ds.finalSize, r.ds.downloadError
 }
 
 func (r *fileAvailableReader) Read(p []byte) (int, error) {
 	r.ds.mu.Lock()
 
-	for r.offset >= r.ds.bytesWritten && r.ds.finalSize == 0 && r.ds.downloadError == nil {
+	bytesWritten, finalSize, err := r.progress()
+
+	for r.offset >= bytesWritten && finalSize == 0 && err == nil {
 		// Check before Wait so a broadcast that already fired is not missed.
 		// sync.Cond has no memory: a Broadcast that arrives before Wait() is lost.
 		if r.ctx != nil && r.ctx.Err() != nil {
@@ -619,21 +1234,23 @@ func (r *fileAvailableReader) Read(p []byte) (int, error) {
 		}
 
 		r.ds.cond.Wait()
+
+		bytesWritten, finalSize, err = r.progress()
 	}
 
-	if r.ds.downloadError != nil {
+	if err != nil {
 		r.ds.mu.Unlock()
 
-		return 0, r.ds.downloadError
+		return 0, err
 	}
 
-	if r.ds.finalSize != 0 && r.offset >= r.ds.finalSize {
+	if finalSize != 0 && r.offset >= finalSize {
 		r.ds.mu.Unlock()
 
 		return 0, io.EOF
 	}
 
-	available := r.ds.bytesWritten - r.offset
+	available := bytesWritten - r.offset
 
 	r.ds.mu.Unlock()
 
@@ -648,6 +1265,56 @@ func (r *fileAvailableReader) Read(p []byte) (int, error) {
 	return n, readErr
 }
 
+// compressedTempFileReader reads the compressed temp file of a download as it
+// is written. Closing it ends the read of the download.
+type compressedTempFileReader struct {
+	fileAvailableReader
+
+	closeOnce sync.Once
+}
+
+// openCompressedTempFile returns a reader of the compressed temp file of ds,
+// whose reader count (ds.wg) the caller incremented; the reader decrements it
+// when closed.
+func openCompressedTempFile(ctx context.Context, ds *downloadState) (io.ReadCloser, error) {
+	f, err := os.Open(ds.compressedAssetPath)
+	if err != nil {
+		ds.wg.Done()
+
+		return nil, fmt.Errorf("error opening the compressed temp file: %w", err)
+	}
+
+	return &compressedTempFileReader{
+		fileAvailableReader: fileAvailableReader{f: f, ds: ds, ctx: ctx, compressed: true},
+	}, nil
+}
+
+func (r *compressedTempFileReader) Close() error {
+	err := r.f.Close()
+
+	r.closeOnce.Do(r.ds.wg.Done)
+
+	return err
+}
+
+// compressedTempFileWriter writes the compressed upstream bytes of a download
+// to its compressed temp file, signaling the progress to its readers.
+type compressedTempFileWriter struct {
+	f  *os.File
+	ds *downloadState
+}
+
+func (w *compressedTempFileWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+
+	w.ds.mu.Lock()
+	w.ds.compressedBytesWritten += int64(n)
+	w.ds.mu.Unlock()
+	w.ds.cond.Broadcast()
+
+	return n, err
+}
+
 // setError safely sets the download error with mutex protection.
 func (ds *downloadState) setError(err error) {
 	ds.mu.Lock()
@@ -745,6 +1412,7 @@ func New(
 		cacheLockTTL:         cacheLockTTL,
 		chunkWaitTimeout:     defaultChunkWaitTimeout,
 		upstreamJobs:         make(map[string]*downloadState),
+		chunkingJobs:         make(map[string]*chunkingProgress),
 		upstreamCaches:       make([]*upstream.Cache, 0),
 		recordAgeIgnoreTouch: recordAgeIgnoreTouch,
 		shutdownCh:           make(chan struct{}),
@@ -760,6 +1428,8 @@ func New(
 		return c, fmt.Errorf("error setting up the secret key: %w", err)
 	}
 
+	c.signer = signer.NewLocal(c.secretKey)
+
 	// Configure metric callbacks
 	if err := c.setupMetricCallbacks(); err != nil {
 		return c, fmt.Errorf("error registering metric callback: %w", err)
@@ -795,6 +1465,8 @@ func (c *Cache) SetCDCConfiguration(enabled bool, minSize, avgSize, maxSize uint
 		if err != nil {
 			return fmt.Errorf("failed to create CDC chunker: %w", err)
 		}
+
+		c.cdcSizes = chunkSizes{min: minSize, avg: avgSize, max: maxSize}
 	}
 
 	return nil
@@ -965,8 +1637,31 @@ func (c *Cache) setupMetricCallbacks() error {
 			o.ObserveFloat64(cacheUtilizationRatio, 0.0)
 		}
 
+		if c.isCDCEnabled() {
+			chunkStoreSize, err := totalChunkStoreSize(ctx, c.dbClient.Ent().Chunk)
+			if err != nil {
+				zerolog.Ctx(ctx).
+					Warn().
+					Err(err).
+					Msg("failed to get chunk store size for metrics")
+			} else {
+				o.ObserveInt64(chunkStoreSizeBytes, chunkStoreSize)
+			}
+
+			//nolint:gosec // G115: the chunk store max size is configured and unlikely to exceed int64 max
+			o.ObserveInt64(chunkStoreMaxSizeBytes, int64(c.chunkStoreMaxSize))
+		}
+
 		return nil
-	}, totalSizeMetric, narInfoCountMetric, narFileCountMetric, cacheMaxSizeBytes, cacheUtilizationRatio)
+	},
+		totalSizeMetric,
+		narInfoCountMetric,
+		narFileCountMetric,
+		cacheMaxSizeBytes,
+		cacheUtilizationRatio,
+		chunkStoreSizeBytes,
+		chunkStoreMaxSizeBytes,
+	)
 	if err != nil {
 		return err
 	}
@@ -1040,6 +1735,15 @@ func (c *Cache) RegisterUpstreamMetrics(m metric.Meter) error {
 	return err
 }
 
+// GetUpstreamCount returns the number of configured upstream caches, healthy
+// or not.
+func (c *Cache) GetUpstreamCount() int {
+	c.upstreamCachesMu.RLock()
+	defer c.upstreamCachesMu.RUnlock()
+
+	return len(c.upstreamCaches)
+}
+
 // GetHealthyUpstreamCount returns the number of healthy upstream caches.
 func (c *Cache) GetHealthyUpstreamCount() int {
 	c.upstreamCachesMu.RLock()
@@ -1062,6 +1766,24 @@ func (c *Cache) GetHealthyUpstreamCountLocked() int {
 	return count
 }
 
+// IsUpstreamUnreachable reports whether upstream caches are configured but
+// none of them is healthy, a miss then not telling whether the object exists.
+// It is false in offline mode, where the upstreams are not asked and a miss is
+// a plain miss; their health, no longer checked, is stale.
+func (c *Cache) IsUpstreamUnreachable() bool {
+	return !c.IsOffline() && c.GetUpstreamCount() > 0 && c.GetHealthyUpstreamCount() == 0
+}
+
+// missErrorStatus returns the status label of a failed miss: the misses failing
+// while no upstream is healthy are told apart from the other errors.
+func (c *Cache) missErrorStatus() string {
+	if c.IsUpstreamUnreachable() {
+		return "upstream_unreachable"
+	}
+
+	return "error"
+}
+
 // GetHealthChecker returns the instance of haelth checker used by the cache.
 // It's useful for testing the behavior of ncps.
 func (c *Cache) GetHealthChecker() *healthcheck.HealthChecker {
@@ -1074,6 +1796,10 @@ func (c *Cache) GetConfig() *config.Config {
 	return c.config
 }
 
+// SetSigner makes the cache sign narinfos and build traces with s, e.g. a key
+// held by an external key management service, instead of its secret key.
+func (c *Cache) SetSigner(s signer.Signer) { c.signer = s }
+
 // SetCacheSignNarinfo configure ncps to sign or not sign narinfos.
 func (c *Cache) SetCacheSignNarinfo(shouldSignNarinfo bool) { c.shouldSignNarinfo = shouldSignNarinfo }
 
@@ -1094,6 +1820,10 @@ func (c *Cache) SetCacheTrustedUploadKeys(keys []signature.PublicKey) {
 // cronjob to automatically clean-up the store.
 func (c *Cache) SetMaxSize(maxSize uint64) { c.maxSize = maxSize }
 
+// SetLRUDeleteConcurrency sets the number of files the LRU deletes from the
+// stores at once; 0 restores the default of 32.
+func (c *Cache) SetLRUDeleteConcurrency(n int) { c.lruDeleteConcurrency = n }
+
 // verifyNarInfoTrusted returns nil when requireTrustedSignature is disabled,
 // or when the narinfo carries at least one signature that validates against
 // the configured trusted upload keys. When the gate is enabled it fails closed:
@@ -1111,7 +1841,7 @@ func (c *Cache) verifyNarInfoTrusted(narInfo *narinfo.NarInfo) error {
 		return ErrUntrustedNarInfo
 	}
 
-	if !signature.VerifyFirst(narInfo.Fingerprint(), narInfo.Signatures, keys) {
+	if !signature.VerifyFirst(narInfoFingerprint(narInfo), narInfo.Signatures, keys) {
 		return ErrUntrustedNarInfo
 	}
 
@@ -1184,7 +1914,7 @@ func (c *Cache) AddLRUCronJob(ctx context.Context, schedule cron.Schedule) {
 		Time("next-run", schedule.Next(time.Now())).
 		Msg("adding a cronjob for LRU")
 
-	c.cron.Schedule(schedule, cron.FuncJob(c.runLRU(ctx)))
+	c.scheduleCronJob(ctx, CronJobLRU, schedule, c.runLRU)
 }
 
 // AddCDCDeletedCleanupCronJob adds a periodic job to delete old compressed NAR files
@@ -1195,7 +1925,7 @@ func (c *Cache) AddCDCDeletedCleanupCronJob(ctx context.Context, schedule cron.S
 		Time("next-run", schedule.Next(time.Now())).
 		Msg("adding a cronjob for CDC delayed cleanup")
 
-	c.cron.Schedule(schedule, cron.FuncJob(c.runCDCDeletedCleanup(ctx)))
+	c.scheduleCronJob(ctx, CronJobCDCDeletedCleanup, schedule, c.runCDCDeletedCleanup)
 }
 
 // AddCDCLazyRecoveryCronJob adds a periodic job to recover CDC rows that failed
@@ -1211,7 +1941,9 @@ func (c *Cache) AddCDCLazyRecoveryCronJob(
 		Int("batch_size", batchSize).
 		Msg("adding a cronjob for CDC recovery")
 
-	c.cron.Schedule(schedule, cron.FuncJob(c.runCDCLazyRecovery(ctx, schedule, batchSize)))
+	c.scheduleCronJob(ctx, CronJobCDCLazyRecovery, schedule, func(ctx context.Context) func() {
+		return c.runCDCLazyRecovery(ctx, schedule, batchSize)
+	})
 }
 
 // StartCron starts the cron scheduler in its own go-routine, or no-op if already started.
@@ -1220,6 +1952,8 @@ func (c *Cache) StartCron(ctx context.Context) {
 		Info().
 		Msg("starting the cron scheduler")
 
+	c.scheduleMaintenanceWindowResumer(ctx)
+
 	c.cron.Start()
 }
 
@@ -1242,7 +1976,30 @@ func (c *Cache) Close() {
 		<-c.cron.Stop().Done()
 	}
 
+	// Likewise wait for the cron jobs triggered manually via TriggerCronJob.
+	c.stopCronJobs()
+
 	c.backgroundWG.Wait()
+
+	_ = c.CloseChunkStore(context.Background())
+}
+
+// CloseChunkStore closes the chunk store if it needs closing, as a mirrored
+// chunk store does to flush its replication queue, waiting until ctx is done
+// at most.
+func (c *Cache) CloseChunkStore(ctx context.Context) error {
+	closer, ok := c.getChunkStore().(interface {
+		Close(ctx context.Context) error
+	})
+	if !ok {
+		return nil
+	}
+
+	if err := closer.Close(ctx); err != nil && !errors.Is(err, chunk.ErrMirrorClosed) {
+		return fmt.Errorf("error closing the chunk store: %w", err)
+	}
+
+	return nil
 }
 
 // SetRecordAgeIgnoreTouch changes the duration at which a record is considered
@@ -1253,14 +2010,21 @@ func (c *Cache) SetRecordAgeIgnoreTouch(d time.Duration) { c.recordAgeIgnoreTouc
 func (c *Cache) GetHostname() string { return c.hostName }
 
 // PublicKey returns the public key of the server.
-func (c *Cache) PublicKey() signature.PublicKey { return c.secretKey.ToPublicKey() }
+func (c *Cache) PublicKey() signature.PublicKey { return c.signer.PublicKey() }
 
 // GetNar returns the nar given a hash and compression from the store. If the
 // nar is not found in the store, it's pulled from an upstream, stored in the
 // stored and finally returned. The returned narURL reflects any mutations made
 // during serving (e.g. TransparentZstd cleared when zstd stream not available).
+// Its errors are classified by the error taxonomy, see ErrNotFound.
 // NOTE: It's the caller responsibility to close the body.
 func (c *Cache) GetNar(ctx context.Context, narURL nar.URL) (nar.URL, int64, io.ReadCloser, error) {
+	narURL, size, r, err := c.getNar(ctx, narURL)
+
+	return narURL, size, r, c.classifyError(ctx, err)
+}
+
+func (c *Cache) getNar(ctx context.Context, narURL nar.URL) (nar.URL, int64, io.ReadCloser, error) {
 	ctx, span := tracer.Start(
 		ctx,
 		"cache.GetNar",
@@ -1271,6 +2035,8 @@ func (c *Cache) GetNar(ctx context.Context, narURL nar.URL) (nar.URL, int64, io.
 	)
 	defer span.End()
 
+	startTime := time.Now()
+
 	var metricAttrs []attribute.KeyValue
 
 	defer func() {
@@ -1282,7 +2048,7 @@ func (c *Cache) GetNar(ctx context.Context, narURL nar.URL) (nar.URL, int64, io.
 		reader io.ReadCloser
 	)
 
-	err := c.withReadLock(ctx, "GetNar", narJobKey(narURL.Hash), func() error {
+	err := c.withReadLock(ctx, "GetNar", c.narJobKey(narURL), func() error {
 		ctx = narURL.
 			NewLogger(*zerolog.Ctx(ctx)).
 			WithContext(ctx)
@@ -1298,7 +2064,7 @@ func (c *Cache) GetNar(ctx context.Context, narURL nar.URL) (nar.URL, int64, io.
 		hasNarInStore := c.HasNarInStore(ctx, narURL)
 
 		c.upstreamJobsMu.Lock()
-		_, hasActiveLocalJob := c.upstreamJobs[narJobKey(narURL.Hash)]
+		_, hasActiveLocalJob := c.upstreamJobs[c.narJobKey(narURL)]
 		c.upstreamJobsMu.Unlock()
 
 		// hasNar decides whether we can serve immediately (whole-file in store, fully
@@ -1345,6 +2111,8 @@ func (c *Cache) GetNar(ctx context.Context, narURL nar.URL) (nar.URL, int64, io.
 				c.maybeBackgroundMigrateNarToChunks(ctx, narURL)
 			}
 
+			recordServe(ctx, ServeStatusHit, "", narServeStore(hasNarInStore))
+
 			size, reader, err = c.serveNarFromStorageViaPipe(ctx, &narURL, hasNarInStore)
 			if err != nil {
 				metricAttrs = append(metricAttrs, attribute.String("status", "error"))
@@ -1373,6 +2141,15 @@ func (c *Cache) GetNar(ctx context.Context, narURL nar.URL) (nar.URL, int64, io.
 		// the original (prefixed) hash (e.g., nix-serve style upstreams).
 		narURL = c.lookupOriginalNarURL(ctx, narURL)
 
+		// The download of the NAR was interrupted by a restart and its lock may
+		// still be held by the process that died: fail fast for the client to
+		// retry once it expires rather than wait on a download no one makes.
+		if err := c.interruptedDownloadError(c.narJobKey(narURL)); err != nil {
+			metricAttrs = append(metricAttrs, attribute.String("status", "error"))
+
+			return err
+		}
+
 		// For CDC mode, narURL still has CompressionTypeNone after lookupOriginalNarURL
 		// because nar_files records don't exist yet for first pulls.
 		// To avoid downloading uncompressed NARs from upstream (slow TTFB due to
@@ -1396,6 +2173,8 @@ func (c *Cache) GetNar(ctx context.Context, narURL nar.URL) (nar.URL, int64, io.
 				attribute.String("status", "success"),
 			)
 
+			recordServe(ctx, ServeStatusMiss, "", ServeStoreStaging)
+
 			var serveErr error
 
 			size, reader, serveErr = c.serveNarFromStaging(
@@ -1438,6 +2217,8 @@ func (c *Cache) GetNar(ctx context.Context, narURL nar.URL) (nar.URL, int64, io.
 				attribute.String("status", "success"),
 			)
 
+			recordServe(ctx, ServeStatusHit, ds.getUpstreamHostname(), narServeStore(hasNarInStore))
+
 			var err error
 
 			size, reader, err = c.serveNarFromStorageViaPipe(ctx, &narURL, hasNarInStore)
@@ -1470,7 +2251,7 @@ func (c *Cache) GetNar(ctx context.Context, narURL nar.URL) (nar.URL, int64, io.
 
 		err = ds.getError()
 		if err != nil {
-			metricAttrs = append(metricAttrs, attribute.String("status", "error"))
+			metricAttrs = append(metricAttrs, attribute.String("status", c.missErrorStatus()))
 
 			// Add upstream hostname to metrics even on error
 			if upstreamHostname := ds.getUpstreamHostname(); upstreamHostname != "" {
@@ -1495,12 +2276,22 @@ func (c *Cache) GetNar(ctx context.Context, narURL nar.URL) (nar.URL, int64, io.
 		// once chunked, a zstd request is served by recompression and a none request
 		// by reassembly. Checked only after ds.getError so a genuine upstream miss
 		// still surfaces upstream.ErrNotFound rather than this fallback's not-found.
+		// A download decompressing the upstream's NAR keeps its compressed bytes
+		// too (ds.compressedAssetPath): a client requesting that compression is
+		// served them instead, so concurrent requests of both variants share one
+		// upstream fetch.
+		servesCompressedTemp := false
+
 		if compressedRequestNeedsUpstreamFallback(requestedCompression, ds.tempFileCompression) {
-			metricAttrs = append(metricAttrs, attribute.String("status", "error"))
+			if ds.compressedAssetPath == "" || requestedCompression != ds.compressedCompression {
+				metricAttrs = append(metricAttrs, attribute.String("status", "error"))
 
-			ds.wg.Done()
+				ds.wg.Done()
 
-			return storage.ErrNotFound
+				return storage.ErrNotFound
+			}
+
+			servesCompressedTemp = true
 		}
 
 		// Add upstream hostname to metrics on success
@@ -1509,6 +2300,17 @@ func (c *Cache) GetNar(ctx context.Context, narURL nar.URL) (nar.URL, int64, io.
 				attribute.String("upstream_hostname", upstreamHostname))
 		}
 
+		recordServe(ctx, ServeStatusMiss, ds.getUpstreamHostname(), "")
+
+		if servesCompressedTemp {
+			narURL.Compression = requestedCompression
+			size = -1
+
+			reader, err = openCompressedTempFile(ctx, ds)
+
+			return err
+		}
+
 		// create a pipe to stream file down to the http client
 		r, writer := io.Pipe()
 
@@ -1691,7 +2493,7 @@ func (c *Cache) GetNar(ctx context.Context, narURL nar.URL) (nar.URL, int64, io.
 		return narURL, 0, nil, err
 	}
 
-	return narURL, size, reader, nil
+	return narURL, size, newMeteredNarStream(ctx, reader, narURL.Compression, startTime), nil
 }
 
 // GetNarFileSize returns the size of the NAR file from the database if it exists.
@@ -1746,7 +2548,7 @@ func (c *Cache) lookupOriginalNarURL(ctx context.Context, normalizedNarURL nar.U
 			entnarinfonarfile.HasNarFileWith(
 				entnarfile.HashEQ(normalizedNarURL.Hash),
 				entnarfile.CompressionEQ(normalizedNarURL.Compression.String()),
-				entnarfile.QueryEQ(normalizedNarURL.Query.Encode()),
+				entnarfile.QueryEQ(normalizedNarURL.CanonicalQuery()),
 			),
 		)).
 		First(ctx)
@@ -1785,7 +2587,7 @@ func (c *Cache) lookupOriginalNarURL(ctx context.Context, normalizedNarURL nar.U
 // Returns nil, nil if CDC is not enabled, there is an active local download, or the
 // original URL cannot be found.
 func (c *Cache) lookupPreferredUpstreamURL(ctx context.Context, narURL nar.URL) (*nar.URL, *narinfo.NarInfo) {
-	if !c.isCDCEnabled() || narURL.Compression != nar.CompressionTypeNone || c.hasUpstreamJob(narURL.Hash) {
+	if !c.isCDCEnabled() || narURL.Compression != nar.CompressionTypeNone || c.hasUpstreamJob(narURL) {
 		return nil, nil
 	}
 
@@ -1829,7 +2631,16 @@ func (c *Cache) lookupPreferredUpstreamURL(ctx context.Context, narURL nar.URL)
 
 // ensureNarFileRecord ensures a NarFile record exists with the correct size.
 // It creates the record if it doesn't exist, or updates the size if it's incorrect.
-func (c *Cache) ensureNarFileRecord(ctx context.Context, narURL nar.URL, written int64, txName string) error {
+//
+// storedRaw records whether a Compression:none NAR is stored as a plain .nar
+// rather than recompressed, see isNarStoredRaw.
+func (c *Cache) ensureNarFileRecord(
+	ctx context.Context,
+	narURL nar.URL,
+	written int64,
+	storedRaw bool,
+	txName string,
+) error {
 	// Normalize so the nar_file row is keyed the same way storeInDatabase keys it.
 	// Without this a nix-serve-style prefixed URL would create a duplicate prefixed
 	// row carrying bytes_stored_at, while the narinfo stays linked to the normalized
@@ -1864,9 +2675,10 @@ func (c *Cache) ensureNarFileRecord(ctx context.Context, narURL nar.URL, written
 		id, err := tx.NarFile.Create().
 			SetHash(narURL.Hash).
 			SetCompression(narURL.Compression.String()).
-			SetQuery(narURL.Query.Encode()).
+			SetQuery(narURL.CanonicalQuery()).
 			SetFileSize(fileSize).
 			SetBytesStoredAt(now).
+			SetStoredRaw(storedRaw).
 			OnConflictColumns(
 				entnarfile.FieldHash,
 				entnarfile.FieldCompression,
@@ -1875,6 +2687,7 @@ func (c *Cache) ensureNarFileRecord(ctx context.Context, narURL nar.URL, written
 			Update(func(u *ent.NarFileUpsert) {
 				u.SetFileSize(fileSize)
 				u.SetBytesStoredAt(now)
+				u.SetStoredRaw(storedRaw)
 				u.SetUpdatedAt(now)
 			}).
 			ID(ctx)
@@ -1898,8 +2711,13 @@ func (c *Cache) ensureNarFileRecord(ctx context.Context, narURL nar.URL, written
 	})
 }
 
-// PutNar records the NAR (given as an io.Reader) into the store.
+// PutNar records the NAR (given as an io.Reader) into the store. Its errors
+// are classified by the error taxonomy, see ErrNotFound.
 func (c *Cache) PutNar(ctx context.Context, narURL nar.URL, r io.ReadCloser) error {
+	return c.classifyError(ctx, c.putNar(ctx, narURL, r))
+}
+
+func (c *Cache) putNar(ctx context.Context, narURL nar.URL, r io.ReadCloser) error {
 	ctx, span := tracer.Start(
 		ctx,
 		"cache.PutNar",
@@ -1910,7 +2728,14 @@ func (c *Cache) PutNar(ctx context.Context, narURL nar.URL, r io.ReadCloser) err
 	)
 	defer span.End()
 
-	return c.withReadLock(ctx, "PutNar", narJobKey(narURL.Hash), func() error {
+	compressionAttr := attribute.String("compression", narURL.Compression.String())
+	startTime := time.Now()
+
+	narUploadsInFlight.Add(ctx, 1, metric.WithAttributes(compressionAttr))
+
+	defer narUploadsInFlight.Add(context.WithoutCancel(ctx), -1, metric.WithAttributes(compressionAttr))
+
+	err := c.withReadLock(ctx, "PutNar", c.narJobKey(narURL), func() error {
 		// TODO: The context already has these keys from the server (caller), should this be removed?
 		ctx = narURL.
 			NewLogger(*zerolog.Ctx(ctx)).
@@ -1922,46 +2747,79 @@ func (c *Cache) PutNar(ctx context.Context, narURL nar.URL, r io.ReadCloser) err
 			r.Close()
 		}()
 
-		if c.isCDCEnabled() {
-			return c.putNarWithCDC(ctx, narURL, r)
+		if nf, fileHash := c.storedNarFile(ctx, narURL); nf != nil {
+			return c.putStoredNar(ctx, narURL, nf, fileHash, r)
 		}
 
-		written, err := c.narStore.PutNar(ctx, narURL, r, -1)
-		if err != nil {
-			if errors.Is(err, storage.ErrAlreadyExists) {
-				zerolog.Ctx(ctx).Debug().Msg("nar already exists in storage, getting size to ensure db record")
-
-				// We still need the size to ensure the DB record is correct.
-				var getErr error
+		return c.writeUploadedNar(ctx, narURL, r)
+	})
 
-				var reader io.ReadCloser
+	narStreamDuration.Record(ctx, time.Since(startTime).Seconds(), metric.WithAttributes(
+		attribute.String("direction", streamDirectionUpload),
+		compressionAttr,
+		attribute.String("result", streamResultFromError(err)),
+	))
 
-				written, reader, getErr = c.narStore.GetNar(ctx, narURL)
-				if getErr != nil {
-					return fmt.Errorf("nar exists in storage but failed to get its metadata: %w", getErr)
-				}
+	return err
+}
 
-				reader.Close()
-			} else {
-				return err
-			}
+// writeUploadedNar stores the NAR of an upload read from r, as chunks when CDC
+// is enabled and as a whole file otherwise.
+func (c *Cache) writeUploadedNar(ctx context.Context, narURL nar.URL, r io.Reader) error {
+	if c.isCDCEnabled() {
+		if c.cdcUploadLookahead > 0 {
+			return c.putNarWithCDCStreaming(ctx, narURL, r)
 		}
 
-		// Ensure we have a NarFile record for it.
-		// fileSize is 'written'.
-		err = c.ensureNarFileRecord(ctx, narURL, written, "PutNar.ensureNarFile")
-		if err != nil {
-			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to ensure nar file record in PutNar")
+		return c.putNarWithCDC(ctx, narURL, r)
+	}
+
+	return c.putNarWholeFile(ctx, narURL, r)
+}
+
+// putNarWholeFile stores the NAR of an upload read from r as a whole file.
+func (c *Cache) putNarWholeFile(ctx context.Context, narURL nar.URL, r io.Reader) error {
+	written, err := c.narStore.PutNar(ctx, narURL, r, -1)
+	if err != nil {
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			zerolog.Ctx(ctx).Debug().Msg("nar already exists in storage, getting size to ensure db record")
+
+			// We still need the size to ensure the DB record is correct.
+			var getErr error
 
+			var reader io.ReadCloser
+
+			written, reader, getErr = c.narStore.GetNar(ctx, narURL)
+			if getErr != nil {
+				return fmt.Errorf("nar exists in storage but failed to get its metadata: %w", getErr)
+			}
+
+			reader.Close()
+		} else {
 			return err
 		}
+	}
+
+	// Ensure we have a NarFile record for it.
+	// fileSize is 'written'.
+	err = c.ensureNarFileRecord(
+		ctx,
+		narURL,
+		written,
+		narURL.Compression == nar.CompressionTypeNone,
+		"PutNar.ensureNarFile",
+	)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to ensure nar file record in PutNar")
+
+		return err
+	}
 
-		if err := c.checkAndFixNarInfosForNar(context.WithoutCancel(ctx), narURL); err != nil {
-			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to fix narinfos after PutNar")
-		}
+	if err := c.checkAndFixNarInfosForNar(context.WithoutCancel(ctx), narURL); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to fix narinfos after PutNar")
+	}
 
-		return nil
-	})
+	return nil
 }
 
 func (c *Cache) putNarWithCDC(ctx context.Context, narURL nar.URL, r io.Reader) error {
@@ -1981,6 +2839,19 @@ func (c *Cache) putNarWithCDC(ctx context.Context, narURL nar.URL, r io.Reader)
 	}
 
 	err = c.storeNarWithCDC(ctx, tempPath, &narURL, nil)
+	if errors.Is(err, errCDCJobRefused) {
+		zerolog.Ctx(ctx).Info().Msg("CDC was disabled during the upload, storing the nar as a whole file")
+
+		f, err := os.Open(tempPath)
+		if err != nil {
+			return fmt.Errorf("failed to open the temp file: %w", err)
+		}
+
+		defer f.Close()
+
+		return c.putNarWholeFile(ctx, narURL, f)
+	}
+
 	if err != nil {
 		if errors.Is(err, storage.ErrAlreadyExists) {
 			zerolog.Ctx(ctx).Debug().Msg("nar already exists in chunk storage, skipping")
@@ -2008,7 +2879,7 @@ func (c *Cache) DeleteNar(ctx context.Context, narURL nar.URL) error {
 	)
 	defer span.End()
 
-	return c.withReadLock(ctx, "DeleteNar", narJobKey(narURL.Hash), func() error {
+	return c.withReadLock(ctx, "DeleteNar", c.narJobKey(narURL), func() error {
 		ctx = narURL.
 			NewLogger(*zerolog.Ctx(ctx)).
 			WithContext(ctx)
@@ -2064,7 +2935,7 @@ func (c *Cache) DeleteNar(ctx context.Context, narURL nar.URL) error {
 			Where(
 				entnarfile.HashEQ(clearURL.Hash),
 				entnarfile.CompressionEQ(clearURL.Compression.String()),
-				entnarfile.QueryEQ(clearURL.Query.Encode()),
+				entnarfile.QueryEQ(clearURL.CanonicalQuery()),
 			).
 			ClearBytesStoredAt().
 			Save(ctx); err != nil {
@@ -2112,6 +2983,12 @@ func (c *Cache) createTempFile(ctx context.Context, hash string, compression nar
 // streamResponseToFile streams the HTTP response body to a file in chunks,
 // updating download state and broadcasting progress to waiting clients.
 func (c *Cache) streamResponseToFile(ctx context.Context, resp *http.Response, f *os.File, ds *downloadState) error {
+	if resp.ContentLength > 0 {
+		ds.mu.Lock()
+		ds.expectedSize = resp.ContentLength
+		ds.mu.Unlock()
+	}
+
 	return c.streamReaderToFile(ctx, resp.Body, f, ds)
 }
 
@@ -2194,14 +3071,33 @@ func (c *Cache) storeNarFromTempFile(ctx context.Context, tempPath string, narUR
 
 	// For Compression:none NARs the temp file holds raw bytes (the upstream package
 	// transparently decompresses any content-encoding). We re-compress them as zstd
-	// before storing so all "uncompressed" NARs are uniformly stored as .nar.zst.
-	// Other compression types (zstd, xz, etc.) are stored as-is under their original
-	// extension.
+	// before storing so all "uncompressed" NARs are uniformly stored as .nar.zst, in
+	// the seekable format so Range requests can be served without decompressing
+	// from the start (see GetNarSeeker), unless a sample of them does not
+	// compress (see SetNarCompressionSampling): they are then stored as-is as a
+	// plain .nar. Other compression types (zstd, xz, etc.) are stored as-is under
+	// their original extension.
 	storeURL := *narURL
 
 	var putSize int64
 
+	var storedRaw bool
+
 	if narURL.Compression == nar.CompressionTypeNone {
+		storedRaw, err = c.shouldStoreNarRaw(ctx, f)
+		if err != nil {
+			return err
+		}
+
+		recordNarStoreDecision(ctx, storedRaw)
+	}
+
+	switch {
+	case storedRaw:
+		zerolog.Ctx(ctx).Debug().Msg("storing the uncompressed NAR raw, it does not compress")
+
+		putSize = fileSize
+	case narURL.Compression == nar.CompressionTypeNone:
 		zerolog.Ctx(ctx).Debug().Msg("re-compressing uncompressed NAR as zstd before storing")
 
 		// When re-compressing, we don't know the final compressed size,
@@ -2211,7 +3107,7 @@ func (c *Cache) storeNarFromTempFile(ctx context.Context, tempPath string, narUR
 		pr, pw := io.Pipe()
 
 		analytics.SafeGo(ctx, func() {
-			zw := zstd.NewPooledWriter(pw)
+			zw := zstd.NewNarSeekableWriter(pw)
 
 			_, copyErr := io.Copy(zw, f)
 
@@ -2226,7 +3122,7 @@ func (c *Cache) storeNarFromTempFile(ctx context.Context, tempPath string, narUR
 
 		reader = pr
 		storeURL.Compression = nar.CompressionTypeZstd
-	} else {
+	default:
 		// For pre-compressed NARs, we know the file size
 		putSize = fileSize
 	}
@@ -2263,7 +3159,7 @@ func (c *Cache) storeNarFromTempFile(ctx context.Context, tempPath string, narUR
 	zerolog.Ctx(ctx).Debug().Int64("written", written).Msg("nar stored successfully")
 
 	// Ensure we have a NarFile record for it, and that it reflects the truth.
-	if err = c.ensureNarFileRecord(ctx, *narURL, written, "storeNarFromTempFile.ensureNarFile"); err != nil {
+	if err = c.ensureNarFileRecord(ctx, *narURL, written, storedRaw, "storeNarFromTempFile.ensureNarFile"); err != nil {
 		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to ensure nar file record in storeNarFromTempFile")
 
 		return err
@@ -2306,10 +3202,22 @@ func (c *Cache) storeNarWithCDCUnlocked(
 	}
 	defer f.Close()
 
-	// Pass fileSize=0: the compressed file size on disk does not equal the uncompressed
-	// NarSize, so we skip the size validation (which requires the narinfo's NarSize).
-	// The NarSize is only known at download time (pullNarIntoStore), not here.
-	return c.storeNarWithCDCFromReader(ctx, f, 0, narURL, onNarFileReady)
+	// Pass fileSize=0 for a compressed NAR: the compressed file size on disk does not
+	// equal the uncompressed NarSize, so we skip the size validation (which requires
+	// the narinfo's NarSize). An uncompressed NAR's file size is its NarSize, which
+	// also lets it pick its CDC size class.
+	var fileSize uint64
+
+	if narURL.Compression == nar.CompressionTypeNone {
+		info, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("error stating temp file: %w", err)
+		}
+
+		fileSize = uint64(info.Size()) //nolint:gosec // G115: file sizes are non-negative
+	}
+
+	return c.storeNarWithCDCFromReader(ctx, f, fileSize, narURL, onNarFileReady)
 }
 
 func (c *Cache) storeNarWithCDCFromReaderWithMigrationLock(
@@ -2392,6 +3300,17 @@ func (c *Cache) reportBackgroundCDCError(
 	ds.setError(cdcErr)
 }
 
+// recordBackgroundCDCChunking records the outcome of a background CDC chunking
+// attempt that started at start for the health report. A peer holding the
+// migration lock is not an outcome: see reportBackgroundCDCError.
+func (c *Cache) recordBackgroundCDCChunking(start time.Time, cdcErr error) {
+	if errors.Is(cdcErr, ErrMigrationInProgress) {
+		return
+	}
+
+	c.recordBackgroundJob(backgroundJobCDCChunking, start, cdcErr)
+}
+
 // maybeDecompressReader wraps r in a decompression reader if compression is not none.
 // Returns the (possibly decompressed) reader, a cleanup function, and an error.
 // If decompression setup fails, it logs a warning and returns the original reader so
@@ -2454,6 +3373,19 @@ func (c *Cache) storeNarWithCDCFromReader(
 		Uint64("file_size", fileSize).
 		Msg("storeNarWithCDCFromReader: starting")
 
+	// Admit the job before recording anything so that a job refused while CDC
+	// is being disabled leaves no partial nar_file behind, and an admitted job
+	// runs to completion even if CDC is disabled meanwhile.
+	endCDCJob, ok := c.beginCDCJob()
+	if !ok {
+		return errCDCJobRefused
+	}
+
+	defer endCDCJob()
+
+	progress, untrackChunkingJob := c.trackChunkingJob(narURL.Hash, fileSize)
+	defer untrackChunkingJob()
+
 	// For CDC, always store raw uncompressed data in chunks.
 	// Save original compression before normalizing narURL.
 	originalCompression := narURL.Compression
@@ -2510,8 +3442,10 @@ func (c *Cache) storeNarWithCDCFromReader(
 	}
 
 	// 2. Start chunking
-	cdcEnabled, chunkStore, cdcChunker := c.getCDCInfo()
-	if !cdcEnabled || chunkStore == nil || cdcChunker == nil {
+	chunkStore := c.getChunkStore()
+	cdcChunker, cdcSizes := c.chunkerForNarSize(fileSize)
+
+	if chunkStore == nil || cdcChunker == nil {
 		return ErrCDCDisabled
 	}
 
@@ -2530,13 +3464,58 @@ func (c *Cache) storeNarWithCDCFromReader(
 
 	defer decompCleanup()
 
-	chunksChan, errChan := cdcChunker.Chunk(ctx, reader)
+	// Stop the chunker and the ingest workers when returning early.
+	chunkCtx, stopChunking := context.WithCancel(ctx)
+	defer stopChunking()
+
+	chunksChan, errChan := cdcChunker.Chunk(chunkCtx, reader)
 
 	var (
 		totalSize  int64
 		chunkCount int64
 	)
 
+	dedup := newChunkDedup(c.dbClient.Ent().Chunk)
+
+	// Store in chunkStore if new, on the ingest workers. A chunk already
+	// recorded in the DB, by another NAR or earlier in this one, is already in
+	// the chunk store: it is neither compressed nor written again.
+	//
+	// NOTE (known limitation): The physical chunk file is written here before
+	// recordChunkBatch writes the DB record. If the process crashes between these
+	// two operations, the chunk file will be an unreferenced orphan on disk with no
+	// corresponding DB record. The GC (RunLRU/GetOrphanedChunks) cannot find it
+	// because it operates on DB records, not the filesystem. However, if the same NAR
+	// is re-requested, the stale chunking_started_at lock will trigger cleanup and
+	// a fresh chunking attempt that reuses existing chunk files via PutChunk.
+	// For truly abandoned NARs (never re-requested after a crash), the orphaned
+	// chunk files will persist until a filesystem-level cleanup is performed.
+	ingestedChan, ingestErrChan := ingestChunks(chunkCtx, chunksChan, c.cdcIngestWorkers,
+		func(ctx context.Context, chunkMetadata *chunker.Chunk) error {
+			defer chunkMetadata.Free()
+
+			compressedSize, duplicate, err := dedup.lookup(ctx, chunkMetadata.Hash, chunkMetadata.Size)
+			if err != nil {
+				return err
+			}
+
+			if !duplicate {
+				_, size, err := chunkStore.PutChunk(ctx, chunkMetadata.Hash, chunkMetadata.Data)
+				if err != nil {
+					return fmt.Errorf("error storing chunk: %w", err)
+				}
+
+				//nolint:gosec // G115: Chunk size is small enough to fit in uint32
+				compressedSize = uint32(size)
+
+				dedup.stored(chunkMetadata.Hash, chunkMetadata.Size, compressedSize)
+			}
+
+			chunkMetadata.CompressedSize = compressedSize
+
+			return nil
+		})
+
 	var batch []*chunker.Chunk
 
 	flushTimer := time.NewTimer(cdcFirstBatchDelay)
@@ -2550,6 +3529,8 @@ func (c *Cache) storeNarWithCDCFromReader(
 			if err != nil {
 				return fmt.Errorf("chunking error: %w", err)
 			}
+		case err := <-ingestErrChan:
+			return err
 		case <-flushTimer.C:
 			// Timer fired — flush if we have accumulated chunks
 			if len(batch) > 0 {
@@ -2562,8 +3543,15 @@ func (c *Cache) storeNarWithCDCFromReader(
 			}
 
 			flushTimer.Reset(cdcSubsequentBatchDelay)
-		case chunkMetadata, ok := <-chunksChan:
+		case chunkMetadata, ok := <-ingestedChan:
 			if !ok { //nolint:nestif // TODO: Improve this later.
+				// The ingest error is sent before ingestedChan is closed.
+				select {
+				case err := <-ingestErrChan:
+					return err
+				default:
+				}
+
 				// Process remaining batch
 				if err := c.recordChunkBatch(ctx, narFileID, chunkCount, batch); err != nil {
 					return err
@@ -2596,6 +3584,9 @@ func (c *Cache) storeNarWithCDCFromReader(
 						SetTotalChunks(chunkCount).
 						//nolint:gosec // G115: totalSize is non-negative
 						SetFileSize(uint64(totalSize)).
+						SetChunkMinSize(cdcSizes.min).
+						SetChunkAvgSize(cdcSizes.avg).
+						SetChunkMaxSize(cdcSizes.max).
 						SetUpdatedAt(time.Now()).
 						ClearChunkingStartedAt().
 						Save(ctx)
@@ -2608,6 +3599,16 @@ func (c *Cache) storeNarWithCDCFromReader(
 
 				success = true
 
+				dedup.record(ctx)
+
+				zerolog.Ctx(ctx).Debug().
+					Int64("total_chunks", chunkCount).
+					Int64("new_chunks", dedup.newChunks).
+					Int64("new_bytes", dedup.newBytes).
+					Int64("duplicate_chunks", dedup.duplicateChunks).
+					Int64("duplicate_bytes", dedup.duplicateBytes).
+					Msg("chunked nar")
+
 				// If compression was normalized (e.g., xz → none), atomically clean up the old
 				// NarFile record and re-link narinfos to the new one in a single transaction.
 				//
@@ -2626,12 +3627,18 @@ func (c *Cache) storeNarWithCDCFromReader(
 					}
 
 					if err := c.withEntTransaction(ctx, "storeNarWithCDC.RelinkAndCleanup", func(tx *ent.Tx) error {
+						oldNarFile := []predicate.NarFile{
+							entnarfile.HashEQ(narURL.Hash),
+							entnarfile.CompressionEQ(originalCompression.String()),
+							entnarfile.QueryEQ(narURL.CanonicalQuery()),
+						}
+
+						if _, err := database.UnlinkNarFilesChunks(ctx, tx, oldNarFile...); err != nil {
+							return err
+						}
+
 						if _, err := tx.NarFile.Delete().
-							Where(
-								entnarfile.HashEQ(narURL.Hash),
-								entnarfile.CompressionEQ(originalCompression.String()),
-								entnarfile.QueryEQ(narURL.Query.Encode()),
-							).
+							Where(oldNarFile...).
 							Exec(ctx); err != nil {
 							return fmt.Errorf("failed to delete old nar_file record: %w", err)
 						}
@@ -2648,29 +3655,8 @@ func (c *Cache) storeNarWithCDCFromReader(
 				return nil
 			}
 
-			// Store in chunkStore if new.
-			//
-			// NOTE (known limitation): The physical chunk file is written here before
-			// recordChunkBatch writes the DB record. If the process crashes between these
-			// two operations, the chunk file will be an unreferenced orphan on disk with no
-			// corresponding DB record. The GC (RunLRU/GetOrphanedChunks) cannot find it
-			// because it operates on DB records, not the filesystem. However, if the same NAR
-			// is re-requested, the stale chunking_started_at lock will trigger cleanup and
-			// a fresh chunking attempt that reuses existing chunk files via PutChunk.
-			// For truly abandoned NARs (never re-requested after a crash), the orphaned
-			// chunk files will persist until a filesystem-level cleanup is performed.
-			_, compressedSize, err := chunkStore.PutChunk(ctx, chunkMetadata.Hash, chunkMetadata.Data)
-			if err != nil {
-				chunkMetadata.Free()
-
-				return fmt.Errorf("error storing chunk: %w", err)
-			}
-
-			chunkMetadata.Free()
-			//nolint:gosec // G115: Chunk size is small enough to fit in uint32
-			chunkMetadata.CompressedSize = uint32(compressedSize)
-
 			totalSize += int64(chunkMetadata.Size)
+			progress.add(chunkMetadata.Size)
 
 			batch = append(batch, chunkMetadata)
 
@@ -2723,7 +3709,7 @@ func (c *Cache) findOrCreateNarFileForCDC(
 		nrID, err := tx.NarFile.Create().
 			SetHash(narURL.Hash).
 			SetCompression(narURL.Compression.String()).
-			SetQuery(narURL.Query.Encode()).
+			SetQuery(narURL.CanonicalQuery()).
 			SetFileSize(fileSize).
 			OnConflictColumns(
 				entnarfile.FieldHash,
@@ -2851,9 +3837,7 @@ func (c *Cache) clearStaleCDCChunkingLockWithEntTx(
 		return nil, false, fmt.Errorf("failed to get chunks for stale nar_file %d: %w", nr.ID, err)
 	}
 
-	if _, err := tx.NarFileChunk.Delete().
-		Where(entnarfilechunk.NarFileID(nr.ID)).
-		Exec(ctx); err != nil {
+	if _, err := database.UnlinkNarFileChunks(ctx, tx, entnarfilechunk.NarFileID(nr.ID)); err != nil {
 		return nil, false, fmt.Errorf("failed to delete partial chunks for nar_file %d: %w", nr.ID, err)
 	}
 
@@ -2896,9 +3880,8 @@ func (c *Cache) cleanupStaleLockChunks(ctx context.Context, cs chunk.Store, stal
 	// Restrict the orphan-check query to the candidate IDs we already
 	// know about, so we don't pull (and filter in memory) every orphan
 	// in the database. Run this outside the previous transaction so we
-	// see the committed deletion. Ent equivalent of the legacy LEFT
-	// JOIN nar_file_chunks WHERE chunk_id IS NULL: chunks that have no
-	// NarFileLinks edge.
+	// see the committed deletion: a chunk is orphaned once its ref_count
+	// drops to zero.
 	//
 	// IDIn is batched (cdcCleanupHashBatchSize) to stay below driver
 	// parameter limits: a very large NAR file split into small CDC
@@ -2916,7 +3899,7 @@ func (c *Cache) cleanupStaleLockChunks(ctx context.Context, cs chunk.Store, stal
 		batch, err := c.dbClient.Ent().Chunk.Query().
 			Where(
 				entchunk.IDIn(ids[start:end]...),
-				entchunk.Not(entchunk.HasNarFileLinks()),
+				entchunk.RefCountEQ(0),
 			).
 			All(ctx)
 		if err != nil {
@@ -2937,10 +3920,10 @@ func (c *Cache) cleanupStaleLockChunks(ctx context.Context, cs chunk.Store, stal
 		// Delete the DB record, but only when no nar_file has re-linked this chunk
 		// since we took the orphan snapshot. A concurrent GetNar re-fetch can recreate
 		// a nar_file and reuse chunks with matching content hashes before we reach
-		// this point; the HasNarFileLinks predicate makes the delete a no-op in that
+		// this point; the ref_count predicate makes the delete a no-op in that
 		// case so we never remove a live chunk.
 		deleted, err := c.dbClient.Ent().Chunk.Delete().
-			Where(entchunk.IDEQ(oc.ID), entchunk.Not(entchunk.HasNarFileLinks())).
+			Where(entchunk.IDEQ(oc.ID), entchunk.RefCountEQ(0)).
 			Exec(ctx)
 		if err != nil {
 			chunkLog.Warn().Err(err).Msg("failed to delete orphaned chunk record during stale lock cleanup")
@@ -3031,104 +4014,140 @@ func (c *Cache) recordChunkBatch(ctx context.Context, narFileID int64, startInde
 	}
 
 	return c.withEntTransaction(ctx, "recordChunkBatch", func(tx *ent.Tx) error {
-		// Collect unique hashes from this batch.
-		uniqueHashes := make([]string, 0, len(batch))
-
-		seenInBatch := make(map[string]struct{}, len(batch))
-		for _, cm := range batch {
-			if _, ok := seenInBatch[cm.Hash]; !ok {
-				uniqueHashes = append(uniqueHashes, cm.Hash)
-				seenInBatch[cm.Hash] = struct{}{}
-			}
-		}
+		return recordChunkBatchWithEntTx(ctx, tx, narFileID, startIndex, batch)
+	})
+}
 
-		// Bulk-fetch all chunks that already exist in one SELECT. This
-		// avoids generating new auto-increment PKs (and hitting the
-		// sequence-desync bug) for chunks whose hash is already in the
-		// table.
-		existing, err := chunksByHashes(ctx, tx.Chunk, uniqueHashes)
-		if err != nil {
-			return fmt.Errorf("error fetching existing chunks: %w", err)
-		}
+// recordChunkBatchWithEntTx is recordChunkBatch within the transaction tx.
+func recordChunkBatchWithEntTx(
+	ctx context.Context,
+	tx *ent.Tx,
+	narFileID int64,
+	startIndex int64,
+	batch []*chunker.Chunk,
+) error {
+	// Collect unique hashes from this batch.
+	uniqueHashes := make([]string, 0, len(batch))
 
-		idByHash := make(map[string]int, len(uniqueHashes))
-		for _, ch := range existing {
-			idByHash[ch.Hash] = ch.ID
+	seenInBatch := make(map[string]struct{}, len(batch))
+	for _, cm := range batch {
+		if _, ok := seenInBatch[cm.Hash]; !ok {
+			uniqueHashes = append(uniqueHashes, cm.Hash)
+			seenInBatch[cm.Hash] = struct{}{}
 		}
+	}
 
-		// Build CREATE calls only for hashes genuinely absent from the DB.
-		var creates []*ent.ChunkCreate
+	// Bulk-fetch all chunks that already exist in one SELECT. This
+	// avoids generating new auto-increment PKs (and hitting the
+	// sequence-desync bug) for chunks whose hash is already in the
+	// table.
+	existing, err := chunksByHashes(ctx, tx.Chunk, uniqueHashes)
+	if err != nil {
+		return fmt.Errorf("error fetching existing chunks: %w", err)
+	}
 
-		newHashSet := make(map[string]struct{})
+	idByHash := make(map[string]int, len(uniqueHashes))
+	for _, ch := range existing {
+		idByHash[ch.Hash] = ch.ID
+	}
 
-		for _, cm := range batch {
-			if _, exists := idByHash[cm.Hash]; exists {
-				continue
-			}
+	// Build CREATE calls only for hashes genuinely absent from the DB.
+	var creates []*ent.ChunkCreate
 
-			if _, queued := newHashSet[cm.Hash]; queued {
-				continue // duplicate within batch — only INSERT once
-			}
+	newHashSet := make(map[string]struct{})
 
-			newHashSet[cm.Hash] = struct{}{}
-			creates = append(creates, tx.Chunk.Create().
-				SetHash(cm.Hash).
-				SetSize(cm.Size).
-				SetCompressedSize(cm.CompressedSize))
+	for _, cm := range batch {
+		if _, exists := idByHash[cm.Hash]; exists {
+			continue
 		}
 
-		if len(creates) > 0 {
-			// Bulk-insert new chunks. ON CONFLICT (hash) DO NOTHING handles
-			// the narrow race where another goroutine inserted the same hash
-			// between our SELECT and this INSERT.
-			if err := tx.Chunk.CreateBulk(creates...).
-				OnConflictColumns(entchunk.FieldHash).
-				Ignore().
-				Exec(ctx); err != nil {
-				return fmt.Errorf("error creating new chunk records: %w", err)
-			}
+		if _, queued := newHashSet[cm.Hash]; queued {
+			continue // duplicate within batch — only INSERT once
+		}
 
-			// Re-fetch newly inserted (or race-won-by-another) chunks to
-			// populate idByHash with their PKs.
-			newHashes := make([]string, 0, len(newHashSet))
-			for h := range newHashSet {
-				newHashes = append(newHashes, h)
-			}
+		newHashSet[cm.Hash] = struct{}{}
+		creates = append(creates, tx.Chunk.Create().
+			SetHash(cm.Hash).
+			SetSize(cm.Size).
+			SetCompressedSize(cm.CompressedSize))
+	}
 
-			freshChunks, err := chunksByHashes(ctx, tx.Chunk, newHashes)
-			if err != nil {
-				return fmt.Errorf("error fetching new chunk IDs: %w", err)
-			}
+	if len(creates) > 0 {
+		// Bulk-insert new chunks. ON CONFLICT (hash) DO NOTHING handles
+		// the narrow race where another goroutine inserted the same hash
+		// between our SELECT and this INSERT.
+		if err := tx.Chunk.CreateBulk(creates...).
+			OnConflictColumns(entchunk.FieldHash).
+			Ignore().
+			Exec(ctx); err != nil {
+			return fmt.Errorf("error creating new chunk records: %w", err)
+		}
 
-			if len(freshChunks) != len(newHashes) {
-				return fmt.Errorf("error fetching new chunk IDs: expected %d got %d: %w",
-					len(newHashes), len(freshChunks), errChunkIDFetchMismatch)
-			}
+		// Re-fetch newly inserted (or race-won-by-another) chunks to
+		// populate idByHash with their PKs.
+		newHashes := make([]string, 0, len(newHashSet))
+		for h := range newHashSet {
+			newHashes = append(newHashes, h)
+		}
 
-			for _, ch := range freshChunks {
-				idByHash[ch.Hash] = ch.ID
-			}
+		freshChunks, err := chunksByHashes(ctx, tx.Chunk, newHashes)
+		if err != nil {
+			return fmt.Errorf("error fetching new chunk IDs: %w", err)
 		}
 
-		// Link every batch entry to the NAR file in bulk; ON CONFLICT
-		// (nar_file_id, chunk_index) DO NOTHING is idempotent on retry.
-		bulk := make([]*ent.NarFileChunkCreate, len(batch))
-		for i, cm := range batch {
-			bulk[i] = tx.NarFileChunk.Create().
-				SetNarFileID(int(narFileID)).
-				SetChunkID(idByHash[cm.Hash]).
-				SetChunkIndex(int(startIndex) + i)
+		if len(freshChunks) != len(newHashes) {
+			return fmt.Errorf("error fetching new chunk IDs: expected %d got %d: %w",
+				len(newHashes), len(freshChunks), errChunkIDFetchMismatch)
 		}
 
-		if err := tx.NarFileChunk.CreateBulk(bulk...).
-			OnConflictColumns(entnarfilechunk.FieldNarFileID, entnarfilechunk.FieldChunkIndex).
-			Ignore().
-			Exec(ctx); err != nil {
-			return fmt.Errorf("error linking chunks in bulk: %w", err)
+		for _, ch := range freshChunks {
+			idByHash[ch.Hash] = ch.ID
 		}
+	}
 
-		return nil
-	})
+	// A retried batch finds some of its links already recorded; only the
+	// links created here add a reference to their chunk.
+	linkedIndexes, err := tx.NarFileChunk.Query().
+		Where(
+			entnarfilechunk.NarFileIDEQ(int(narFileID)),
+			entnarfilechunk.ChunkIndexGTE(int(startIndex)),
+			entnarfilechunk.ChunkIndexLT(int(startIndex)+len(batch)),
+		).
+		Select(entnarfilechunk.FieldChunkIndex).
+		Ints(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching existing chunk links: %w", err)
+	}
+
+	linked := make(map[int]struct{}, len(linkedIndexes))
+	for _, index := range linkedIndexes {
+		linked[index] = struct{}{}
+	}
+
+	// Link every batch entry to the NAR file in bulk; ON CONFLICT
+	// (nar_file_id, chunk_index) DO NOTHING is idempotent on retry.
+	bulk := make([]*ent.NarFileChunkCreate, len(batch))
+	newRefs := make([]int, 0, len(batch))
+
+	for i, cm := range batch {
+		bulk[i] = tx.NarFileChunk.Create().
+			SetNarFileID(int(narFileID)).
+			SetChunkID(idByHash[cm.Hash]).
+			SetChunkIndex(int(startIndex) + i)
+
+		if _, ok := linked[int(startIndex)+i]; !ok {
+			newRefs = append(newRefs, idByHash[cm.Hash])
+		}
+	}
+
+	if err := tx.NarFileChunk.CreateBulk(bulk...).
+		OnConflictColumns(entnarfilechunk.FieldNarFileID, entnarfilechunk.FieldChunkIndex).
+		Ignore().
+		Exec(ctx); err != nil {
+		return fmt.Errorf("error linking chunks in bulk: %w", err)
+	}
+
+	return database.AddChunkRefs(ctx, tx, newRefs)
 }
 
 func (c *Cache) pullNarIntoStore(
@@ -3138,11 +4157,20 @@ func (c *Cache) pullNarIntoStore(
 	uc *upstream.Cache,
 	ds *downloadState,
 	narInfo *narinfo.NarInfo, // Added
+	expectedSize int64,
+	expectedDigest []byte,
 ) {
 	// Track download completion for cleanup synchronization
 	ds.cleanupWg.Add(1)
 	defer ds.cleanupWg.Done()
 
+	jobKey := c.narJobKey(*narURL)
+
+	inFlightAttrs := metric.WithAttributes(attribute.String("compression", narURL.Compression.String()))
+
+	narDownloadsInFlight.Add(ctx, 1, inFlightAttrs)
+	defer narDownloadsInFlight.Add(context.WithoutCancel(ctx), -1, inFlightAttrs)
+
 	// keepJobAlive prevents the deferred cleanup below from removing the job from
 	// upstreamJobs and closing ds.done immediately. For CDC, we keep the job alive
 	// so concurrent GetNar calls can find the ds and stream from the temp file while
@@ -3181,7 +4209,7 @@ func (c *Cache) pullNarIntoStore(
 
 		// Clean up local job tracking
 		c.upstreamJobsMu.Lock()
-		delete(c.upstreamJobs, narJobKey(narURL.Hash))
+		delete(c.upstreamJobs, jobKey)
 		c.upstreamJobsMu.Unlock()
 
 		ds.startOnce.Do(func() { close(ds.start) })
@@ -3224,6 +4252,14 @@ func (c *Cache) pullNarIntoStore(
 		Msg("downloading the nar from upstream")
 
 	resp, err := c.getNarFromUpstream(ctx, downloadURL, uc)
+	if err != nil {
+		// The IPFS gateways are the last resort, their errors are not the ones
+		// reported.
+		if ipfsResp, ipfsErr := c.getNarFromIPFS(ctx, downloadURL, expectedDigest, expectedSize); ipfsErr == nil {
+			resp, err = ipfsResp, nil
+		}
+	}
+
 	if err != nil {
 		if !errors.Is(err, storage.ErrNotFound) {
 			zerolog.Ctx(ctx).
@@ -3242,6 +4278,8 @@ func (c *Cache) pullNarIntoStore(
 		return
 	}
 
+	resp.Body = c.checkUpstreamNarSize(ctx, resp.Body, expectedSize)
+
 	// bodyOwned is set to true when a background goroutine takes ownership of
 	// resp.Body (CDC path). In that case the goroutine is responsible for
 	// draining and closing the body; the defer below must not touch it.
@@ -3322,6 +4360,23 @@ func (c *Cache) pullNarIntoStore(
 
 		ds.tempFileCompression = nar.CompressionTypeNone
 
+		// Keep the compressed upstream bytes too, so the clients requesting the
+		// upstream's compression are served by this download instead of fetching
+		// the NAR again.
+		compressedFile, err := c.createTempFile(ctx, narURL.Hash, downloadURL.Compression)
+		if err != nil {
+			ds.setError(err)
+
+			return
+		}
+
+		defer compressedFile.Close()
+
+		ds.compressedAssetPath = compressedFile.Name()
+		ds.compressedCompression = downloadURL.Compression
+
+		c.journalDownload(ctx, jobKey, narURL.String(), ds)
+
 		// Signal concurrent clients that the temp file path is ready.
 		// ds.tempFileCompression must be set before closing ds.start.
 		ds.startOnce.Do(func() { close(ds.start) })
@@ -3342,7 +4397,7 @@ func (c *Cache) pullNarIntoStore(
 			// LIFO: wg.Done 1st, cdcWg.Done 2nd, cleanup func 3rd.
 			defer func() {
 				c.upstreamJobsMu.Lock()
-				delete(c.upstreamJobs, narJobKey(narURL.Hash))
+				delete(c.upstreamJobs, jobKey)
 				c.upstreamJobsMu.Unlock()
 
 				ds.doneOnce.Do(func() { close(ds.done) })
@@ -3371,6 +4426,7 @@ func (c *Cache) pullNarIntoStore(
 				ds.storedOnce.Do(func() { close(ds.stored) })
 			}
 
+			cdcStart := time.Now()
 			cdcErr := c.storeNarWithCDCFromReaderWithMigrationLock(
 				ctx,
 				cdcReader,
@@ -3378,6 +4434,21 @@ func (c *Cache) pullNarIntoStore(
 				&narURLForCDC,
 				onNarFileReady,
 			)
+			if errors.Is(cdcErr, errCDCJobRefused) {
+				// Wait for the download to write the whole NAR to the temp file.
+				if _, err := io.Copy(io.Discard, cdcReader); err != nil {
+					ds.setError(err)
+
+					return
+				}
+
+				c.storePulledNarWholeFile(ctx, ds, &narURLForCDC)
+
+				return
+			}
+
+			c.recordBackgroundCDCChunking(cdcStart, cdcErr)
+
 			if cdcErr != nil {
 				c.reportBackgroundCDCError(
 					ctx,
@@ -3401,7 +4472,14 @@ func (c *Cache) pullNarIntoStore(
 		// Decompress HTTP response and write to the temp file.
 		// Updates ds.bytesWritten and ds.finalSize so concurrent GetNar clients
 		// and the CDC goroutine can read progressively via fileAvailableReader.
-		decompReader, err := nar.DecompressReader(ctx, resp.Body, downloadURL.Compression)
+		// The compressed bytes are written to compressedFile as they are read.
+		body := io.TeeReader(resp.Body, &compressedTempFileWriter{f: compressedFile, ds: ds})
+
+		ds.mu.Lock()
+		ds.expectedSize = int64(narInfo.NarSize) //nolint:gosec // G115: NAR sizes fit in int64
+		ds.mu.Unlock()
+
+		decompReader, err := nar.DecompressReader(ctx, body, downloadURL.Compression)
 		if err != nil {
 			ds.setError(err)
 
@@ -3416,6 +4494,20 @@ func (c *Cache) pullNarIntoStore(
 			return
 		}
 
+		// The decompressor may stop before the end of the compressed stream.
+		_, drainErr := io.Copy(io.Discard, body)
+
+		ds.mu.Lock()
+
+		if drainErr != nil {
+			ds.compressedError = drainErr
+		} else {
+			ds.compressedFinalSize = ds.compressedBytesWritten
+		}
+
+		ds.mu.Unlock()
+		ds.cond.Broadcast()
+
 		zerolog.Ctx(ctx).
 			Info().
 			Dur("elapsed", time.Since(now)).
@@ -3434,6 +4526,8 @@ func (c *Cache) pullNarIntoStore(
 
 	defer f.Close()
 
+	c.journalDownload(ctx, jobKey, narURL.String(), ds)
+
 	// Record the actual compression type of the bytes written to the temp file
 	ds.tempFileCompression = downloadURL.Compression
 
@@ -3462,7 +4556,7 @@ func (c *Cache) pullNarIntoStore(
 
 			defer func() {
 				c.upstreamJobsMu.Lock()
-				delete(c.upstreamJobs, narJobKey(narURL.Hash))
+				delete(c.upstreamJobs, jobKey)
 				c.upstreamJobsMu.Unlock()
 
 				ds.doneOnce.Do(func() { close(ds.done) })
@@ -3475,7 +4569,16 @@ func (c *Cache) pullNarIntoStore(
 				ds.storedOnce.Do(func() { close(ds.stored) })
 			}
 
+			cdcStart := time.Now()
 			cdcErr := c.storeNarWithCDC(context.WithoutCancel(ctx), ds.assetPath, narURL, onNarFileReady)
+			if errors.Is(cdcErr, errCDCJobRefused) {
+				c.storePulledNarWholeFile(context.WithoutCancel(ctx), ds, narURL)
+
+				return
+			}
+
+			c.recordBackgroundCDCChunking(cdcStart, cdcErr)
+
 			if cdcErr != nil {
 				c.reportBackgroundCDCError(
 					ctx,
@@ -3533,6 +4636,28 @@ func (c *Cache) pullNarIntoStore(
 		Msg("download of nar complete")
 }
 
+// storePulledNarWholeFile stores as a whole file the NAR of a pull, fully
+// written to the temp file of ds, whose chunking job was refused because CDC
+// was disabled during the download.
+func (c *Cache) storePulledNarWholeFile(ctx context.Context, ds *downloadState, narURL *nar.URL) {
+	zerolog.Ctx(ctx).Info().Msg("CDC was disabled during the download, storing the nar as a whole file")
+
+	if err := c.storeNarFromTempFile(ctx, ds.assetPath, narURL); err != nil {
+		ds.setError(err)
+
+		return
+	}
+
+	ds.storedOnce.Do(func() { close(ds.stored) })
+
+	if err := c.checkAndFixNarInfosForNar(context.WithoutCancel(ctx), *narURL); err != nil {
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(err).
+			Msg("failed to fix narinfo file size after pullNarIntoStore")
+	}
+}
+
 // serveNarFromStorageViaPipe wraps storage reading with a pipe pattern to decouple
 // it from the HTTP request context. This prevents partial transfers when the request
 // context is cancelled (e.g., timeout, client disconnect) while data is still being
@@ -3721,7 +4846,7 @@ func (c *Cache) serveZstdFromChunks(ctx context.Context, narURL *nar.URL) (int64
 	pipeReader, pipeWriter := io.Pipe()
 
 	analytics.SafeGo(ctx, func() {
-		zw := zstd.NewPooledWriter(pipeWriter)
+		zw := zstd.NewNarWriter(pipeWriter)
 
 		var copyErr error
 
@@ -3786,7 +4911,7 @@ func (c *Cache) getNarFromStore(
 	// stored compressed whole file to satisfy a Compression:none request.
 	storedComp := narURL.Compression
 
-	if narURL.Compression == nar.CompressionTypeNone {
+	if narURL.Compression == nar.CompressionTypeNone && !c.isNarStoredRaw(ctx, *narURL) {
 		for _, comp := range wholeFileServeCompressions() {
 			candURL := *narURL
 			candURL.Compression = comp
@@ -3858,7 +4983,7 @@ func (c *Cache) getNarFromStore(
 			Where(
 				entnarfile.HashEQ(narURL.Hash),
 				entnarfile.CompressionEQ(touchComp.String()),
-				entnarfile.QueryEQ(narURL.Query.Encode()),
+				entnarfile.QueryEQ(narURL.CanonicalQuery()),
 			).
 			Only(ctx)
 		if database.IsNotFoundError(err) && storedComp != narURL.Compression {
@@ -3868,7 +4993,7 @@ func (c *Cache) getNarFromStore(
 				Where(
 					entnarfile.HashEQ(narURL.Hash),
 					entnarfile.CompressionEQ(touchComp.String()),
-					entnarfile.QueryEQ(narURL.Query.Encode()),
+					entnarfile.QueryEQ(narURL.CanonicalQuery()),
 				).
 				Only(ctx)
 		}
@@ -3889,12 +5014,14 @@ func (c *Cache) getNarFromStore(
 
 		// Touch the row matching the served representation (gated on that row's own
 		// last_accessed_at) so LRU tracking reflects the bytes we actually streamed.
-		if nr.LastAccessedAt == nil || time.Since(*nr.LastAccessedAt) > c.recordAgeIgnoreTouch {
+		if c.touchMode == TouchModeAsync && c.touchDue(nr.LastAccessedAt) {
+			c.touchNarFileAsync(ctx, nr.ID)
+		} else if c.touchDue(nr.LastAccessedAt) {
 			if _, err := tx.NarFile.Update().
 				Where(
 					entnarfile.HashEQ(narURL.Hash),
 					entnarfile.CompressionEQ(touchComp.String()),
-					entnarfile.QueryEQ(narURL.Query.Encode()),
+					entnarfile.QueryEQ(narURL.CanonicalQuery()),
 				).
 				SetLastAccessedAt(time.Now()).
 				SetUpdatedAt(time.Now()).
@@ -3913,7 +5040,13 @@ func (c *Cache) getNarFromStore(
 
 	// Heal the orphan: create the missing DB record so LRU tracking works.
 	if needsDBRecord {
-		if healErr := c.ensureNarFileRecord(ctx, *narURL, storedFileSize, "getNarFromStore.healOrphan"); healErr != nil {
+		if healErr := c.ensureNarFileRecord(
+			ctx,
+			*narURL,
+			storedFileSize,
+			storedComp == nar.CompressionTypeNone,
+			"getNarFromStore.healOrphan",
+		); healErr != nil {
 			zerolog.Ctx(ctx).Warn().Err(healErr).
 				Str("nar_url", narURL.String()).
 				Msg("failed to create missing DB record for orphan NAR in getNarFromStore")
@@ -3950,6 +5083,23 @@ func (c *Cache) getNarFromUpstream(
 		NewLogger(*zerolog.Ctx(ctx)).
 		WithContext(ctx)
 
+	if c.IsOffline() {
+		return nil, storage.ErrNotFound
+	}
+
+	if candidates := c.raceCandidates(narURL, uc); candidates != nil {
+		resp, err := c.raceNarFromUpstreams(ctx, narURL, candidates)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			level := errorLogLevelForContextErrors(err)
+			zerolog.Ctx(ctx).
+				WithLevel(level).
+				Err(err).
+				Msg("error racing the upstreams for the nar")
+		}
+
+		return resp, err
+	}
+
 	var ucs []*upstream.Cache
 	if uc != nil {
 		ucs = []*upstream.Cache{uc}
@@ -3971,7 +5121,16 @@ func (c *Cache) getNarFromUpstream(
 		return nil, storage.ErrNotFound
 	}
 
+	ttfbStart := time.Now()
+
 	resp, err := uc.GetNar(ctx, *narURL)
+
+	upstreamNarTTFB.Record(ctx, time.Since(ttfbStart).Seconds(), metric.WithAttributes(
+		attribute.String("upstream_hostname", uc.GetHostname()),
+		attribute.String("compression", narURL.Compression.String()),
+		attribute.String("result", streamResultFromError(err)),
+	))
+
 	if err != nil {
 		if !errors.Is(err, upstream.ErrNotFound) {
 			level := errorLogLevelForContextErrors(err)
@@ -3990,8 +5149,15 @@ func (c *Cache) getNarFromUpstream(
 
 // GetNarInfo returns the narInfo given a hash from the store. If the narInfo
 // is not found in the store, it's pulled from an upstream, stored in the
-// stored and finally returned.
+// stored and finally returned. Its errors are classified by the error
+// taxonomy, see ErrNotFound.
 func (c *Cache) GetNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo, error) {
+	narInfo, err := c.getNarInfo(ctx, hash)
+
+	return narInfo, c.classifyError(ctx, err)
+}
+
+func (c *Cache) getNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo, error) {
 	ctx, span := tracer.Start(
 		ctx,
 		"cache.GetNarInfo",
@@ -4019,13 +5185,37 @@ func (c *Cache) GetNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo,
 		Logger().
 		WithContext(ctx)
 
-	narInfo, err = c.getNarInfoFromDatabase(ctx, hash)
+	// A narinfo preloaded by WarmUp, or one of the shared metadata cache, was
+	// read from the database, and revalidated then, within their TTL.
+	source := "warm_up"
+
+	narInfo = c.getNarInfoFromWarmUp(ctx, hash)
+	if narInfo == nil {
+		source = "metadata_cache"
+
+		narInfo = c.getNarInfoFromMetadataCache(ctx, hash)
+	}
+
+	if narInfo == nil {
+		source = "database"
+
+		narInfo, err = c.getNarInfoFromDatabase(ctx, hash)
+		if err == nil && c.maybeRevalidateNarInfo(ctx, hash) {
+			// The upstream serves a different NAR now: pull the new narinfo.
+			narInfo, err = nil, storage.ErrNotFound
+		}
+
+		if err == nil {
+			c.cacheNarInfoMetadata(ctx, hash, narInfo)
+		}
+	}
+
 	if err == nil {
 		metricAttrs = append(
 			metricAttrs,
 			attribute.String("result", "hit"),
 			attribute.String("status", "success"),
-			attribute.String("source", "database"),
+			attribute.String("source", source),
 		)
 
 		if narURL, err := nar.ParseURL(narInfo.URL); err == nil {
@@ -4043,14 +5233,22 @@ func (c *Cache) GetNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo,
 				c.maybeCDCNormalizeNarInfoURL(ctx, narURL, narInfo)
 			}
 
+			c.maybeZstdNarInfoURL(ctx, narInfo)
+
 			zerolog.Ctx(ctx).
 				Debug().
 				Str("narinfo", narInfo.String()).
+				Str("source", source).
 				Msg("fetched this narinfo from the database")
 		}
 
 		metricAttrs = append(metricAttrs, attribute.String("status", "success"))
 
+		recordServe(ctx, ServeStatusHit, "", "")
+
+		c.maybePrefetchReferences(ctx, hash, narInfo)
+		c.handleMissingReferences(ctx, hash, narInfo)
+
 		return narInfo, nil
 	}
 
@@ -4082,6 +5280,8 @@ func (c *Cache) GetNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo,
 
 			metricAttrs = append(metricAttrs, attribute.String("status", "success"))
 
+			recordServe(ctx, ServeStatusHit, "", "")
+
 			return narInfo, nil
 		}
 
@@ -4093,6 +5293,8 @@ func (c *Cache) GetNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo,
 
 			metricAttrs = append(metricAttrs, attribute.String("status", "success"))
 
+			recordServe(ctx, ServeStatusHit, "", "")
+
 			return narInfo, nil
 		}
 	}
@@ -4114,13 +5316,13 @@ func (c *Cache) GetNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo,
 
 	select {
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		return nil, requestBudgetError(ctx, ctx.Err())
 	case <-ds.done:
 	}
 
 	err = ds.getError()
 	if err != nil {
-		metricAttrs = append(metricAttrs, attribute.String("status", "error"))
+		metricAttrs = append(metricAttrs, attribute.String("status", c.missErrorStatus()))
 
 		// Add upstream hostname to metrics even on error
 		if upstreamHostname := ds.getUpstreamHostname(); upstreamHostname != "" {
@@ -4148,6 +5350,8 @@ func (c *Cache) GetNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo,
 		// 404 and Nix falls back to its next substituter, rather than dead-ending
 		// in a retry-then-fail loop.
 		if errors.Is(err, ErrNarInfoPurged) {
+			recordNarInfoPurged(ctx)
+
 			err = storage.ErrNotFound
 		}
 
@@ -4163,6 +5367,8 @@ func (c *Cache) GetNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo,
 		return nil, err
 	}
 
+	c.maybeZstdNarInfoURL(ctx, narInfo)
+
 	if zerolog.Ctx(ctx).GetLevel() <= zerolog.DebugLevel {
 		zerolog.Ctx(ctx).
 			Debug().
@@ -4172,6 +5378,11 @@ func (c *Cache) GetNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo,
 
 	metricAttrs = append(metricAttrs, attribute.String("status", "success"))
 
+	recordServe(ctx, ServeStatusMiss, ds.getUpstreamHostname(), "")
+
+	c.maybePrefetchReferences(ctx, hash, narInfo)
+	c.handleMissingReferences(ctx, hash, narInfo)
+
 	return narInfo, nil
 }
 
@@ -4181,9 +5392,12 @@ func (c *Cache) pullNarInfo(
 	ds *downloadState,
 ) {
 	done := func() {
-		// Clean up local job tracking
+		// Clean up local job tracking, unless the job was already detached by
+		// an invalidation and replaced.
 		c.upstreamJobsMu.Lock()
-		delete(c.upstreamJobs, narInfoJobKey(hash))
+		if c.upstreamJobs[narInfoJobKey(hash)] == ds {
+			delete(c.upstreamJobs, narInfoJobKey(hash))
+		}
 		c.upstreamJobsMu.Unlock()
 
 		// Ensure ds.start is closed to unblock waiters
@@ -4237,7 +5451,23 @@ func (c *Cache) pullNarInfo(
 		return
 	}
 
-	uc, narInfo, err := c.getNarInfoFromUpstream(ctx, hash)
+	uc, narInfo, prefetched := c.takePrefetchedNarInfo(ctx, hash)
+
+	var err error
+
+	if !prefetched {
+		// The fetch is bounded by the budget of the request that started the
+		// pull, if any; the NAR download that follows is not.
+		fetchCtx, cancel := withRequestBudgetDeadline(ctx)
+
+		uc, narInfo, err = c.getNarInfoFromUpstream(fetchCtx, hash)
+		if err != nil && fetchCtx.Err() != nil && ctx.Err() == nil {
+			err = fmt.Errorf("%w: %w", ErrRequestBudgetExhausted, err)
+		}
+
+		cancel()
+	}
+
 	if err != nil {
 		if !errors.Is(err, storage.ErrNotFound) {
 			zerolog.Ctx(ctx).
@@ -4315,10 +5545,17 @@ func (c *Cache) pullNarInfo(
 		narURLForBG = nar.URL{Hash: narURLForBG.Hash, Compression: narURLForBG.Compression}
 	}
 
+	// A narinfo aliased to the stored NAR of the same content points at that
+	// NAR, which is not downloaded again under its own URL.
+	aliasUpstreamURL, aliased := c.aliasNarInfo(ctx, hash, narInfo)
+	if aliased {
+		upstreamNarPath = aliasUpstreamURL
+	}
+
 	// narPrefetchDisabled is a test-only seam: it lets tests deterministically
 	// reproduce an orphan narinfo (backing NAR never lands in storage) without
 	// racing a background NAR download.
-	if !narPrefetchDisabled(ctx) {
+	if !narPrefetchDisabled(ctx) && !aliased {
 		c.prePullNar(ctx, detachedCtx, &narURLForBG, preferredDownloadURL, uc, narInfo)
 	}
 
@@ -4339,6 +5576,8 @@ func (c *Cache) pullNarInfo(
 	// storage; serve-time maybeCDCNormalizeNarInfoURL presents url=none only once
 	// the NAR is genuinely chunked (HasNarInChunks), so the happy path is unchanged.
 	switch {
+	case aliased:
+		// The URL of the stored NAR is already in its stored form.
 	case narInfo.Compression == nar.CompressionTypeNone.String():
 		// Preserve any query on a conventional hash-named URL. Only an OPAQUE
 		// upstream URL (e.g. snix-castore's ?narsize=N) has its query dropped from
@@ -4363,7 +5602,7 @@ func (c *Cache) pullNarInfo(
 		// still be re-fetched from upstream after the local copy is evicted.
 		rewrittenURL := nar.URL{Hash: narURL.Hash, Compression: narURL.Compression}
 		narInfo.URL = rewrittenURL.String()
-	case c.isEagerCDC():
+	case c.rewritesNarInfoPredictively():
 		// Eager CDC: advertise Compression: none predictively so clients always
 		// request the uncompressed nar/<hash>.nar. The durable form under eager CDC
 		// is uncompressed chunks, ncps has no NAR compressor, and a re-compressed
@@ -4438,7 +5677,12 @@ func (c *Cache) pullNarInfo(
 }
 
 // PutNarInfo records the narInfo (given as an io.Reader) into the store and signs it.
+// Its errors are classified by the error taxonomy, see ErrNotFound.
 func (c *Cache) PutNarInfo(ctx context.Context, hash string, r io.ReadCloser) error {
+	return c.classifyError(ctx, c.putNarInfo(ctx, hash, r))
+}
+
+func (c *Cache) putNarInfo(ctx context.Context, hash string, r io.ReadCloser) error {
 	ctx, span := tracer.Start(
 		ctx,
 		"cache.PutNarInfo",
@@ -4473,6 +5717,12 @@ func (c *Cache) PutNarInfo(ctx context.Context, hash string, r io.ReadCloser) er
 			return fmt.Errorf("rejecting untrusted narinfo: %w", err)
 		}
 
+		// The NAR of an alias URL (e.g. .nar.zstd) is stored under the canonical
+		// one; the URL is not part of the signed fingerprint.
+		narInfo.URL = nar.NormalizeURL(narInfo.URL)
+
+		c.ingestStorePath(narInfo)
+
 		// For CDC mode, normalize all NARs to Compression: none.
 		// CDC chunks are stored uncompressed and re-compressed individually.
 		// For Compression:none upstreams, NARs are stored as zstd and served
@@ -4622,10 +5872,20 @@ func (c *Cache) prePullNar(
 	// ctx is the detached context for the download itself
 	// We need both: coordCtx to respond to caller cancellation, ctx for background download
 
+	// The narinfo is rewritten for serving once this returns, so the size the
+	// upstream advertises is read now.
+	downloadURL := narURL
+	if preferredUpstreamURL != nil {
+		downloadURL = preferredUpstreamURL
+	}
+
+	expectedSize := expectedUpstreamNarSize(downloadURL, narInfo)
+	expectedDigest := c.expectedUpstreamNarDigest(downloadURL, narInfo)
+
 	return c.coordinateDownload(
 		coordCtx,
 		ctx,
-		narJobKey(narURL.Hash),
+		c.narJobKey(*narURL),
 		narURL.Hash,
 		false,
 		true, // NAR downloads may serve cross-pod waiters from in-flight staging
@@ -4651,7 +5911,7 @@ func (c *Cache) prePullNar(
 			return servable, finished
 		},
 		func(ds *downloadState) {
-			c.pullNarIntoStore(ctx, narURL, preferredUpstreamURL, uc, ds, narInfo)
+			c.pullNarIntoStore(ctx, narURL, preferredUpstreamURL, uc, ds, narInfo, expectedSize, expectedDigest)
 		},
 	)
 }
@@ -4677,7 +5937,7 @@ func (c *Cache) getNarFileFromDB(
 		Where(
 			entnarfile.HashEQ(narURL.Hash),
 			entnarfile.CompressionEQ(first.String()),
-			entnarfile.QueryEQ(narURL.Query.Encode()),
+			entnarfile.QueryEQ(narURL.CanonicalQuery()),
 		).
 		Only(ctx)
 	if err == nil {
@@ -4689,7 +5949,7 @@ func (c *Cache) getNarFileFromDB(
 			Where(
 				entnarfile.HashEQ(narURL.Hash),
 				entnarfile.CompressionEQ(second.String()),
-				entnarfile.QueryEQ(narURL.Query.Encode()),
+				entnarfile.QueryEQ(narURL.CanonicalQuery()),
 			).
 			Only(ctx)
 	}
@@ -4850,7 +6110,7 @@ func (c *Cache) narFileBytesStored(ctx context.Context, narURL nar.URL) bool {
 	ok, err := c.dbClient.Ent().NarFile.Query().
 		Where(
 			entnarfile.HashEQ(narURL.Hash),
-			entnarfile.QueryEQ(narURL.Query.Encode()),
+			entnarfile.QueryEQ(narURL.CanonicalQuery()),
 			entnarfile.BytesStoredAtNotNil(),
 		).
 		Exist(ctx)
@@ -4898,7 +6158,8 @@ func (c *Cache) statNarInStore(ctx context.Context, narURL nar.URL) (bool, error
 	// (canonically .nar.zst; also .nar.xz under the narinfo<->nar_file compression
 	// desync). Check each servable compression before the plain .nar so a
 	// locally-present NAR is reported present instead of triggering a re-download.
-	if narURL.Compression == nar.CompressionTypeNone {
+	// A NAR recorded as stored raw is only a plain .nar.
+	if narURL.Compression == nar.CompressionTypeNone && !c.isNarStoredRaw(ctx, narURL) {
 		for _, comp := range wholeFileServeCompressions() {
 			candURL := narURL
 			candURL.Compression = comp
@@ -4940,7 +6201,7 @@ func (c *Cache) signNarInfo(ctx context.Context, hash string, narInfo *narinfo.N
 		}
 	}
 
-	sig, err := c.secretKey.Sign(nil, narInfo.Fingerprint())
+	sig, err := c.signer.Sign(ctx, narInfo.Fingerprint())
 	if err != nil {
 		return fmt.Errorf("error signing the fingerprint: %w", err)
 	}
@@ -4977,7 +6238,7 @@ func (c *Cache) getNarInfoFromStore(ctx context.Context, hash string) (*narinfo.
 			Msg("error parsing the nar-url")
 
 		// narinfo is invalid, remove it
-		if err := c.purgeNarInfo(ctx, hash, &narURL); err != nil {
+		if err := c.purgeNarInfo(ctx, hash, &narURL); err != nil && !errors.Is(err, ErrNarInfoQuarantined) {
 			zerolog.Ctx(ctx).
 				Error().
 				Err(err).
@@ -5025,7 +6286,7 @@ func (c *Cache) getNarInfoFromStore(ctx context.Context, hash string) (*narinfo.
 		hasNar = true
 	}
 
-	if !hasNar && !c.hasUpstreamJob(narURL.Hash) {
+	if !hasNar && !c.hasUpstreamJob(narURL) {
 		// Missing-NAR cache miss on the store-sourced path. Non-destructive: do NOT
 		// purge. GetNarInfo turns the sentinel into an upstream re-fetch (substituter)
 		// or a 404 (upload-only); the record is healed in place, never deleted out
@@ -5058,7 +6319,9 @@ func (c *Cache) getNarInfoFromStore(ctx context.Context, hash string) (*narinfo.
 			c.BackgroundMigrateNarToChunks(ctx, narURL)
 		}
 
-		if nir.LastAccessedAt == nil || time.Since(*nir.LastAccessedAt) > c.recordAgeIgnoreTouch {
+		if c.touchMode == TouchModeAsync && c.touchDue(nir.LastAccessedAt) {
+			c.touchNarInfoAsync(ctx, hash)
+		} else if c.touchDue(nir.LastAccessedAt) {
 			if _, err := tx.NarInfo.Update().
 				Where(entnarinfo.HashEQ(hash)).
 				SetLastAccessedAt(time.Now()).
@@ -5230,11 +6493,11 @@ func (c *Cache) getNarInfoFromDatabase(ctx context.Context, hash string) (*narin
 		hasNar = true
 	}
 
-	isBeingDownloadedLocally := c.hasUpstreamJob(narURL.Hash)
+	isBeingDownloadedLocally := c.hasUpstreamJob(*narURL)
 	isBeingDownloadedRemotely := false
 
 	if !hasNar && !isBeingDownloadedLocally {
-		isBeingDownloadedRemotely = c.isRemoteDownloadInProgress(ctx, narURL.Hash)
+		isBeingDownloadedRemotely = c.isRemoteDownloadInProgress(ctx, *narURL)
 	}
 
 	// Check if this narinfo should be purged
@@ -5378,7 +6641,9 @@ func (c *Cache) populateNarInfoFromDatabase(
 
 	// Touch the record if needed.
 	if touch {
-		if nir.LastAccessedAt == nil || time.Since(*nir.LastAccessedAt) > c.recordAgeIgnoreTouch {
+		if c.touchMode == TouchModeAsync && c.touchDue(nir.LastAccessedAt) {
+			c.touchNarInfoAsync(ctx, hash)
+		} else if c.touchDue(nir.LastAccessedAt) {
 			if _, err := tx.NarInfo.Update().
 				Where(entnarinfo.HashEQ(hash)).
 				SetLastAccessedAt(time.Now()).
@@ -5413,6 +6678,29 @@ func (c *Cache) getNarInfoFromUpstream(
 		upstreamNarInfoFetchDuration.Record(ctx, duration)
 	}()
 
+	if c.IsOffline() {
+		return nil, nil, storage.ErrNotFound
+	}
+
+	// The shadow upstreams answer the same request, to be compared with the
+	// answer served; the comparison is skipped unless the answer is known.
+	var primary *shadowAnswer
+
+	if reportPrimary := c.shadowNarInfo(ctx, hash); reportPrimary != nil {
+		defer func() { reportPrimary(primary) }()
+	}
+
+	if c.resolvesNarInfoConflicts() {
+		uc, narInfo := c.getNarInfoFromEveryUpstream(ctx, hash)
+		primary = &shadowAnswer{narInfo: narInfo, latency: time.Since(startTime)}
+
+		if uc == nil {
+			return nil, nil, storage.ErrNotFound
+		}
+
+		return uc, narInfo, nil
+	}
+
 	uc, err := c.selectNarInfoUpstream(ctx, hash)
 	if err != nil {
 		zerolog.Ctx(ctx).
@@ -5424,10 +6712,16 @@ func (c *Cache) getNarInfoFromUpstream(
 	}
 
 	if uc == nil {
+		primary = &shadowAnswer{latency: time.Since(startTime)}
+
 		return nil, nil, storage.ErrNotFound
 	}
 
 	narInfo, err := uc.GetNarInfo(ctx, hash)
+	if err == nil || errors.Is(err, upstream.ErrNotFound) {
+		primary = &shadowAnswer{narInfo: narInfo, latency: time.Since(startTime)}
+	}
+
 	if err != nil {
 		if !errors.Is(err, upstream.ErrNotFound) {
 			level := errorLogLevelForContextErrors(err)
@@ -5466,6 +6760,9 @@ func (c *Cache) deleteNarBytes(ctx context.Context, narURL nar.URL) error {
 	return c.narStore.DeleteNar(ctx, narURL)
 }
 
+// purgeNarInfo deletes the narinfo of hash and the NARs no other narinfo links.
+// It returns ErrNarInfoQuarantined, keeping the narinfo, when the purge rate is
+// abnormal. See SetNarInfoPurgeLimit.
 func (c *Cache) purgeNarInfo(
 	ctx context.Context,
 	hash string,
@@ -5482,6 +6779,26 @@ func (c *Cache) purgeNarInfo(
 	)
 	defer span.End()
 
+	if err := c.admitNarInfoPurge(ctx, hash); err != nil {
+		return err
+	}
+
+	if err := c.deleteNarInfoRecords(ctx, hash); err != nil {
+		recordNarInfoPurge(ctx, narInfoPurgeResultError)
+
+		return err
+	}
+
+	recordNarInfoPurge(ctx, narInfoPurgeResultPurged)
+
+	return nil
+}
+
+// deleteNarInfoRecords deletes the narinfo of hash, its record and the NARs
+// no other narinfo links. See purgeNarInfo.
+func (c *Cache) deleteNarInfoRecords(ctx context.Context, hash string) error {
+	defer c.invalidateNarInfos(ctx, hash)
+
 	var orphanedNarURLs []nar.URL
 
 	err := c.withEntTransaction(ctx, "purgeNarInfo", func(tx *ent.Tx) error {
@@ -5539,6 +6856,10 @@ func (c *Cache) purgeNarInfo(
 				continue
 			}
 
+			if _, err := database.UnlinkNarFileChunks(ctx, tx, entnarfilechunk.NarFileIDEQ(nf.ID)); err != nil {
+				return err
+			}
+
 			if err := tx.NarFile.DeleteOne(nf).Exec(ctx); err != nil {
 				return fmt.Errorf("error deleting the nar record: %w", err)
 			}
@@ -5606,6 +6927,8 @@ func (c *Cache) storeInDatabase(
 		Info().
 		Msg("storing narinfo and nar_file record in the database")
 
+	defer c.invalidateNarInfos(ctx, hash)
+
 	return c.withEntTransaction(ctx, "storeInDatabase", func(tx *ent.Tx) error {
 		nir, err := upsertNarInfoFromParsed(ctx, tx, hash, narInfo)
 		if err != nil {
@@ -5914,7 +7237,7 @@ func createOrUpdateNarFileEnt(
 	id, err := tx.NarFile.Create().
 		SetHash(narURL.Hash).
 		SetCompression(narURL.Compression.String()).
-		SetQuery(narURL.Query.Encode()).
+		SetQuery(narURL.CanonicalQuery()).
 		SetFileSize(fileSize).
 		OnConflictColumns(
 			entnarfile.FieldHash,
@@ -5984,9 +7307,15 @@ func (c *Cache) getNarActualSize(ctx context.Context, nu nar.URL) (int64, error)
 	return int64(narFileRow.FileSize), nil
 }
 
-// checkAndFixNarInfo checks if a NarInfo exists for the given hash, and if so,
+// CheckAndFixNarInfo checks if a NarInfo exists for the given hash, and if so,
 // ensures its FileSize matches the actual NAR size.
 func (c *Cache) CheckAndFixNarInfo(ctx context.Context, hash string) error {
+	return c.checkAndFixNarInfo(ctx, hash, narInfoFixupTriggerInline)
+}
+
+// checkAndFixNarInfo implements CheckAndFixNarInfo, recording the fixes it
+// makes under trigger.
+func (c *Cache) checkAndFixNarInfo(ctx context.Context, hash, trigger string) error {
 	// First check if we have the NarInfo in DB using direct DB access
 	// to avoid higher-level cache logic (like purging or storage checks)
 	niRow, err := narInfoByHash(ctx, c.dbClient.Ent().NarInfo, hash)
@@ -6011,7 +7340,7 @@ func (c *Cache) CheckAndFixNarInfo(ctx context.Context, hash string) error {
 	// FileSize must be null/0 for compression=none narinfos — this is correct by spec.
 	// Nix ignores FileSize/FileHash for uncompressed NARs; do not overwrite with actual size.
 	if nu.Compression == nar.CompressionTypeNone {
-		return c.checkAndFixNarInfoNoCompression(ctx, hash, niRow)
+		return c.checkAndFixNarInfoNoCompression(ctx, hash, trigger, niRow)
 	}
 
 	// Determine the actual NAR size without triggering a streaming pipeline.
@@ -6051,6 +7380,8 @@ func (c *Cache) CheckAndFixNarInfo(ctx context.Context, hash string) error {
 		if err := c.fixNarInfoFileSize(ctx, hash, size); err != nil {
 			return err
 		}
+
+		recordNarInfoFixup(ctx, hash, trigger, narInfoFixupFieldFileSize, narInfoFixupReasonMismatch)
 	}
 
 	// Issue #1314: some upstreams (niks3, nix-serve) omit the optional FileHash on
@@ -6071,7 +7402,11 @@ func (c *Cache) CheckAndFixNarInfo(ctx context.Context, hash string) error {
 				Str("file_hash", fileHash).
 				Msg("missing FileHash detected, backfilling narinfo file hash")
 
-			return c.fixNarInfoFileHash(ctx, hash, fileHash)
+			if err := c.fixNarInfoFileHash(ctx, hash, fileHash); err != nil {
+				return err
+			}
+
+			recordNarInfoFixup(ctx, hash, trigger, narInfoFixupFieldFileHash, narInfoFixupReasonMissing)
 		}
 	}
 
@@ -6142,7 +7477,11 @@ func (c *Cache) fixNarInfoFileHash(
 	})
 }
 
-func (c *Cache) checkAndFixNarInfoNoCompression(ctx context.Context, hash string, niRow *ent.NarInfo) error {
+func (c *Cache) checkAndFixNarInfoNoCompression(
+	ctx context.Context,
+	hash, trigger string,
+	niRow *ent.NarInfo,
+) error {
 	// For compression=none, FileSize must be 0/NULL. If it's not, fix it.
 	if niRow.FileSize != nil && *niRow.FileSize != 0 {
 		zerolog.Ctx(ctx).
@@ -6160,6 +7499,8 @@ func (c *Cache) checkAndFixNarInfoNoCompression(ctx context.Context, hash string
 		}); err != nil {
 			return fmt.Errorf("failed to fix narinfo file size to NULL: %w", err)
 		}
+
+		recordNarInfoFixup(ctx, hash, trigger, narInfoFixupFieldFileSize, narInfoFixupReasonUncompressed)
 	}
 
 	// For compression=none, FileHash must be NULL. If it's not, fix it.
@@ -6179,6 +7520,8 @@ func (c *Cache) checkAndFixNarInfoNoCompression(ctx context.Context, hash string
 		}); err != nil {
 			return fmt.Errorf("failed to fix narinfo file hash to NULL: %w", err)
 		}
+
+		recordNarInfoFixup(ctx, hash, trigger, narInfoFixupFieldFileHash, narInfoFixupReasonUncompressed)
 	}
 
 	return nil
@@ -6517,6 +7860,8 @@ func (c *Cache) deleteNarInfoFromStore(ctx context.Context, hash string) error {
 			return fmt.Errorf("error deleting narinfo from the database: %w", err)
 		}
 
+		c.invalidateNarInfos(ctx, hash)
+
 		zerolog.Ctx(ctx).Debug().Msg("narinfo deleted from database")
 	}
 
@@ -6640,19 +7985,21 @@ func (c *Cache) setupSecretKeyFromFile(ctx context.Context, secretKeyPath string
 	return nil
 }
 
-func (c *Cache) hasUpstreamJob(hash string) bool {
+func (c *Cache) hasUpstreamJob(narURL nar.URL) bool {
 	c.upstreamJobsMu.Lock()
 	defer c.upstreamJobsMu.Unlock()
 
-	_, narJobExists := c.upstreamJobs[narJobKey(hash)]
+	_, narJobExists := c.upstreamJobs[c.narJobKey(narURL)]
 
 	return narJobExists
 }
 
-func (c *Cache) isRemoteDownloadInProgress(ctx context.Context, hash string) bool {
+func (c *Cache) isRemoteDownloadInProgress(ctx context.Context, narURL nar.URL) bool {
+	lockKey := c.narJobKey(narURL)
+
 	// Try to acquire the lock. If it fails, someone else has it.
 	// We use a very short TTL since we'll release it immediately if we get it.
-	locked, err := c.downloadLocker.TryLock(ctx, narJobKey(hash), 10*time.Second)
+	locked, err := c.downloadLocker.TryLock(ctx, lockKey, 10*time.Second)
 	if err != nil {
 		return false
 	}
@@ -6663,11 +8010,11 @@ func (c *Cache) isRemoteDownloadInProgress(ctx context.Context, hash string) boo
 
 	// We got the lock! No one else was downloading it remotely.
 	// We must release it immediately.
-	if err := c.downloadLocker.Unlock(context.WithoutCancel(ctx), narJobKey(hash)); err != nil {
+	if err := c.downloadLocker.Unlock(context.WithoutCancel(ctx), lockKey); err != nil {
 		zerolog.Ctx(ctx).
 			Warn().
 			Err(err).
-			Str("hash", hash).
+			Str("hash", narURL.Hash).
 			Msg("failed to unlock after TryLock check in isRemoteDownloadInProgress")
 	}
 
@@ -7258,7 +8605,7 @@ func (c *Cache) withTryLock(ctx context.Context, operation string, lockKey strin
 // calculateCleanupSize validates the total NAR size and calculates how much needs to be cleaned up.
 // Returns 0 if no cleanup is needed.
 func (c *Cache) calculateCleanupSize(ctx context.Context, tx *ent.Tx, log zerolog.Logger) (uint64, error) {
-	narTotalSize, err := totalNarFileSize(ctx, tx.NarFile)
+	narTotalSize, err := c.lruStoreSize(ctx, tx)
 	if err != nil {
 		log.Error().Err(err).Msg("error fetching the total nar size")
 
@@ -7273,6 +8620,14 @@ func (c *Cache) calculateCleanupSize(ctx context.Context, tx *ent.Tx, log zerolo
 
 	log = log.With().Int64("nar_total_size", narTotalSize).Logger()
 
+	// Without a max-size, an LRU bounding the chunk store leaves the NARs
+	// alone rather than reclaim all of them.
+	if c.maxSize == 0 && c.chunkStoreMaxSize > 0 {
+		log.Info().Msg("no max-size, only the chunk store is bounded")
+
+		return 0, nil
+	}
+
 	//nolint:gosec // G115: SUM over nar_files.file_size (a uint64 column) is non-negative
 	if uint64(narTotalSize) <= c.maxSize {
 		log.Info().Msg("store size is less than max-size, not removing any nars")
@@ -7289,15 +8644,19 @@ func (c *Cache) calculateCleanupSize(ctx context.Context, tx *ent.Tx, log zerolo
 	return cleanupSize, nil
 }
 
-// deleteLRURecordsFromDB identifies the least used NarInfos, deletes them,
-// and then cleans up any NarFiles that became orphaned as a result.
-func (c *Cache) deleteLRURecordsFromDB(
+// evictLeastUsedNarInfos evicts the least used NarInfos until cleanupSize
+// bytes are freed, with the NarFiles and chunks they leave orphaned. The
+// candidates are a snapshot taken up front; they are deleted in transactions
+// of lruBatchSize narinfos, each deleting its files from the stores once
+// committed, so the LRU never holds the database locks of the whole eviction.
+// A candidate read or evicted since the snapshot is skipped, so a run
+// interrupted between two batches is safely run again.
+func (c *Cache) evictLeastUsedNarInfos(
 	ctx context.Context,
-	tx *ent.Tx,
 	log zerolog.Logger,
 	cleanupSize uint64,
 	pinnedHashes map[string]struct{},
-) ([]string, []nar.URL, []string, error) {
+) error {
 	// 1. METADATA PHASE
 	// Find the NarInfos that constitute the oldest `cleanupSize` worth of
 	// data. We fetch in LRU order (last_accessed_at ASC, id ASC) with the
@@ -7310,7 +8669,9 @@ func (c *Cache) deleteLRURecordsFromDB(
 	// out of candidates) — never over-evicting beyond the budget.
 	const maxFetchRows = 10000 // hard cap so we never load the whole table
 
-	candidates, err := tx.NarInfo.Query().
+	snapshotAt := time.Now()
+
+	candidates, err := c.dbClient.Ent().NarInfo.Query().
 		Order(
 			ent.Asc(entnarinfo.FieldLastAccessedAt),
 			ent.Asc(entnarinfo.FieldID),
@@ -7323,13 +8684,13 @@ func (c *Cache) deleteLRURecordsFromDB(
 	if err != nil {
 		log.Error().Err(err).Msg("error getting least used narinfos")
 
-		return nil, nil, nil, err
+		return err
 	}
 
 	if len(candidates) == 0 {
 		log.Warn().Msg("cleanup required but no reclaimable narinfos found")
 
-		return nil, nil, nil, nil
+		return nil
 	}
 
 	if len(candidates) == maxFetchRows {
@@ -7339,13 +8700,22 @@ func (c *Cache) deleteLRURecordsFromDB(
 		)
 	}
 
+	// With CDC, a chunked NAR frees the chunks no other NAR links, only known
+	// once it is evicted. See lruBytesFreed.
+	cdcEnabled := c.isCDCEnabled()
+
 	// Apply the legacy cumulative-byte filter: keep the LRU prefix whose
 	// cumulative file_size is <= max(2*cleanupSize, smallest-single-row).
-	// For cleanupSize == 0 ("delete all") keep every candidate.
+	// For cleanupSize == 0 ("delete all") keep every candidate. The chunked
+	// NARs do not count towards the budget, their freed bytes being unknown.
 	narInfoFileSize := func(info *ent.NarInfo) uint64 {
 		for _, link := range info.Edges.NarInfoNarFiles {
-			if link.Edges.NarFile != nil {
-				return link.Edges.NarFile.FileSize
+			if nf := link.Edges.NarFile; nf != nil {
+				if cdcEnabled && nf.TotalChunks > 0 {
+					return 0
+				}
+
+				return nf.FileSize
 			}
 		}
 
@@ -7385,53 +8755,117 @@ func (c *Cache) deleteLRURecordsFromDB(
 	if len(narInfosToDelete) == 0 {
 		log.Warn().Msg("cleanup required but no reclaimable narinfos found")
 
-		return nil, nil, nil, nil
+		return nil
 	}
 
 	log.Info().Int("count", len(narInfosToDelete)).Msg("found narinfos to expire")
 
-	// Track hashes to remove from the in-memory/disk store later
-	narInfoHashesToRemove := make([]string, 0, len(narInfosToDelete))
-
-	var totalSize uint64
+	var (
+		evicted   int
+		totalSize uint64
+	)
 
-	// Delete the NarInfos from the database.
+	// 2. DELETION PHASE
+	// Delete the NarInfos from the database, a batch per transaction.
 	// This breaks the link between the Metadata and the Storage.
-	// Skip any narinfos that are in the pinned closure.
-	for _, info := range narInfosToDelete {
-		// Skip if this narinfo is in the pinned closure
-		if _, isPinned := pinnedHashes[info.Hash]; isPinned {
-			log.Debug().Str("hash", info.Hash).Msg("skipping pinned narinfo during eviction")
+	// Skip any narinfos that are in the pinned closure or excluded by pattern.
+	for batch := range slices.Chunk(narInfosToDelete, lruBatchSize) {
+		var done bool
 
-			continue
-		}
+		n, freed, err := c.evictLRUBatch(ctx, log, "runLRU.evict", func(tx *ent.Tx) ([]string, uint64, error) {
+			var (
+				hashes []string
+				freed  uint64
+				// unlinked counts, by chunk ID, the links dropped by the
+				// chunked nar files orphaned so far in this batch.
+				unlinked = make(map[int]int64)
+			)
 
-		fileSize := narInfoFileSize(info)
+			done = false
 
-		narInfoHashesToRemove = append(narInfoHashesToRemove, info.Hash)
-		totalSize += fileSize
+			for _, info := range batch {
+				// Skip if this narinfo is in the pinned closure
+				if _, isPinned := pinnedHashes[info.Hash]; isPinned {
+					log.Debug().Str("hash", info.Hash).Msg("skipping pinned narinfo during eviction")
 
-		if err := tx.NarInfo.DeleteOneID(info.ID).Exec(ctx); err != nil {
-			log.Error().
-				Err(err).
-				Str("hash", info.Hash).
-				Msg("error deleting narinfo record")
-
-			return nil, nil, nil, err
-		}
-
-		// Stop if we've collected enough to meet cleanupSize
-		// Note: cleanupSize = 0 means "delete all", so we don't break early in that case
-		// Also, if totalSize >= cleanupSize AND this is the last narinfo in the list,
-		// we should continue to ensure all are deleted (handles edge case where
-		// cleanupSize equals total unique size)
-		if cleanupSize > 0 && totalSize >= cleanupSize {
-			// Only break if this is not the last narinfo we're processing
-			// (i.e., there are more narinfos to process after this one)
-			idx := len(narInfoHashesToRemove)
-			if idx < len(narInfosToDelete)-1 {
-				break
+					continue
+				}
+
+				if c.isEvictionExcluded(info.StorePath) {
+					log.Debug().
+						Str("hash", info.Hash).
+						Str("store_path", *info.StorePath).
+						Msg("skipping excluded narinfo during eviction")
+
+					continue
+				}
+
+				deleted, err := tx.NarInfo.Delete().
+					Where(
+						entnarinfo.ID(info.ID),
+						entnarinfo.Or(
+							entnarinfo.LastAccessedAtIsNil(),
+							entnarinfo.LastAccessedAtLT(snapshotAt),
+						),
+					).
+					Exec(ctx)
+				if err != nil {
+					log.Error().
+						Err(err).
+						Str("hash", info.Hash).
+						Msg("error deleting narinfo record")
+
+					return nil, 0, err
+				}
+
+				if deleted == 0 {
+					log.Debug().Str("hash", info.Hash).Msg("narinfo read or evicted since the LRU snapshot, skipping")
+
+					continue
+				}
+
+				hashes = append(hashes, info.Hash)
+
+				fileSize, err := lruBytesFreed(ctx, tx, info, cdcEnabled, unlinked)
+				if err != nil {
+					log.Error().
+						Err(err).
+						Str("hash", info.Hash).
+						Msg("error computing the bytes freed by a narinfo")
+
+					return nil, 0, err
+				}
+
+				freed += fileSize
+
+				// Stop if we've collected enough to meet cleanupSize
+				// Note: cleanupSize = 0 means "delete all", so we don't break early in that case
+				// Also, if totalSize >= cleanupSize AND this is the last narinfo in the list,
+				// we should continue to ensure all are deleted (handles edge case where
+				// cleanupSize equals total unique size)
+				if cleanupSize > 0 && totalSize+freed >= cleanupSize {
+					// Only break if this is not the last narinfo we're processing
+					// (i.e., there are more narinfos to process after this one)
+					idx := evicted + len(hashes)
+					if idx < len(narInfosToDelete)-1 {
+						done = true
+
+						break
+					}
+				}
 			}
+
+			return hashes, freed, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		evicted += n
+		totalSize += freed
+
+		if done {
+			break
 		}
 	}
 
@@ -7440,15 +8874,80 @@ func (c *Cache) deleteLRURecordsFromDB(
 		log.Warn().
 			Uint64("collected", totalSize).
 			Uint64("requested", cleanupSize).
-			Msg("could not collect enough narinfos for cleanup, all may be pinned or database exhausted")
+			Msg("could not collect enough narinfos for cleanup, all may be pinned, excluded or database exhausted")
 	}
 
 	log.Info().
-		Int("count", len(narInfoHashesToRemove)).
+		Int("count", evicted).
 		Uint64("total_size", totalSize).
-		Msg("narinfos to be deleted")
+		Msg("narinfos deleted")
+
+	return nil
+}
+
+// evictLRUBatch runs evict, which deletes a batch of narinfos in tx and
+// returns their hashes and the bytes they free, then deletes the records they
+// leave orphaned in the same transaction. Once it is committed, the files are
+// deleted from the stores. It returns the number of narinfos evicted and the
+// bytes freed.
+func (c *Cache) evictLRUBatch(
+	ctx context.Context,
+	log zerolog.Logger,
+	operation string,
+	evict func(tx *ent.Tx) ([]string, uint64, error),
+) (int, uint64, error) {
+	var (
+		narInfoHashesToRemove []string
+		narURLsToRemove       []nar.URL
+		chunkHashesToRemove   []string
+		freed                 uint64
+	)
+
+	err := c.withEntTransaction(ctx, operation, func(tx *ent.Tx) error {
+		var err error
+
+		narInfoHashesToRemove, freed, err = evict(tx)
+		if err != nil {
+			return err
+		}
+
+		// 3. STORAGE AND CHUNK PHASES
+		narURLsToRemove, chunkHashesToRemove, err = c.deleteOrphanedRecordsFromDB(ctx, tx, log)
+
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
 
-	// 2. STORAGE PHASE
+	if len(narInfoHashesToRemove) == 0 &&
+		len(narURLsToRemove) == 0 &&
+		len(chunkHashesToRemove) == 0 {
+		return 0, 0, nil
+	}
+
+	// Track eviction counts
+	lruNarInfosEvictedTotal.Add(ctx, int64(len(narInfoHashesToRemove)))
+	lruNarFilesEvictedTotal.Add(ctx, int64(len(narURLsToRemove)))
+	lruChunksEvictedTotal.Add(ctx, int64(len(chunkHashesToRemove)))
+
+	//nolint:gosec // G115: the bytes freed by a batch fit in an int64
+	lruBytesFreedTotal.Add(ctx, int64(freed))
+
+	// Remove the files of the batch from the store as fast as possible
+	c.parallelDeleteFromStores(ctx, log, narInfoHashesToRemove, narURLsToRemove, chunkHashesToRemove)
+
+	return len(narInfoHashesToRemove), freed, nil
+}
+
+// deleteOrphanedRecordsFromDB deletes the nar files no narinfo links anymore
+// and, with CDC, the chunks no nar file links anymore. It returns the NARs and
+// chunks to delete from the stores.
+func (c *Cache) deleteOrphanedRecordsFromDB(
+	ctx context.Context,
+	tx *ent.Tx,
+	log zerolog.Logger,
+) ([]nar.URL, []string, error) {
 	// Now that metadata is gone, some files might have zero references.
 	// We find those truly orphaned files.
 	orphanedNarFiles, err := tx.NarFile.Query().
@@ -7457,7 +8956,7 @@ func (c *Cache) deleteLRURecordsFromDB(
 	if err != nil {
 		log.Error().Err(err).Msg("error identifying orphaned nar files")
 
-		return nil, nil, nil, err
+		return nil, nil, err
 	}
 
 	narURLsToRemove := make([]nar.URL, 0, len(orphanedNarFiles))
@@ -7473,6 +8972,15 @@ func (c *Cache) deleteLRURecordsFromDB(
 			})
 		}
 
+		// Drop the references the orphaned nar files hold on their chunks.
+		if _, err := database.UnlinkNarFilesChunks(ctx, tx, entnarfile.Not(entnarfile.HasNarInfoNarFiles())); err != nil {
+			log.Error().
+				Err(err).
+				Msg("error unlinking the chunks of orphaned nar files")
+
+			return nil, nil, err
+		}
+
 		// Batch delete all orphaned nar files in one query
 		if _, err := tx.NarFile.Delete().
 			Where(entnarfile.Not(entnarfile.HasNarInfoNarFiles())).
@@ -7481,31 +8989,32 @@ func (c *Cache) deleteLRURecordsFromDB(
 				Err(err).
 				Msg("error deleting orphaned nar file records")
 
-			return nil, nil, nil, err
+			return nil, nil, err
 		}
 	} else {
 		log.Info().Msg("no orphaned nar files found (files may be shared with active narinfos)")
 	}
 
-	// 3. CHUNK PHASE
-	// Now that files are gone, some chunks might have zero references.
+	// Now that files are gone, some chunks might have zero references. The
+	// ref_count index makes this an indexed lookup rather than a scan of the
+	// chunks without links.
 	if !c.isCDCEnabled() {
-		return narInfoHashesToRemove, narURLsToRemove, nil, nil
+		return narURLsToRemove, nil, nil
 	}
 
 	orphanedChunks, err := tx.Chunk.Query().
-		Where(entchunk.Not(entchunk.HasNarFileLinks())).
+		Where(entchunk.RefCountEQ(0)).
 		All(ctx)
 	if err != nil {
 		log.Error().Err(err).Msg("error identifying orphaned chunks")
 
-		return nil, nil, nil, err
+		return nil, nil, err
 	}
 
 	if len(orphanedChunks) == 0 {
 		log.Debug().Msg("no orphaned chunks found")
 
-		return narInfoHashesToRemove, narURLsToRemove, nil, nil
+		return narURLsToRemove, nil, nil
 	}
 
 	log.Info().Int("count", len(orphanedChunks)).Msg("found orphaned chunks to delete")
@@ -7517,19 +9026,22 @@ func (c *Cache) deleteLRURecordsFromDB(
 
 	// Batch delete all orphaned chunks in one query
 	if _, err := tx.Chunk.Delete().
-		Where(entchunk.Not(entchunk.HasNarFileLinks())).
+		Where(entchunk.RefCountEQ(0)).
 		Exec(ctx); err != nil {
 		log.Error().
 			Err(err).
 			Msg("error deleting orphaned chunk records")
 
-		return nil, nil, nil, err
+		return nil, nil, err
 	}
 
-	return narInfoHashesToRemove, narURLsToRemove, chunkHashesToRemove, nil
+	return narURLsToRemove, chunkHashesToRemove, nil
 }
 
-// parallelDeleteFromStores deletes narinfos and nars from stores in parallel.
+// parallelDeleteFromStores deletes narinfos, nars and chunks from the stores
+// with at most lruDeleteConcurrency deletions in flight. It logs its progress
+// every lruDeleteBatchSize deletions and stops when ctx is canceled: the files
+// left behind have no database record anymore and are reclaimed by fsck.
 func (c *Cache) parallelDeleteFromStores(
 	ctx context.Context,
 	log zerolog.Logger,
@@ -7537,14 +9049,12 @@ func (c *Cache) parallelDeleteFromStores(
 	narURLsToRemove []nar.URL,
 	chunkHashesToRemove []string,
 ) {
-	var wg sync.WaitGroup
-
-	for _, hash := range narInfoHashesToRemove {
-		wg.Add(1)
+	c.invalidateNarInfos(ctx, narInfoHashesToRemove...)
 
-		analytics.SafeGo(ctx, func() {
-			defer wg.Done()
+	deletions := make([]func(), 0, len(narInfoHashesToRemove)+len(narURLsToRemove)+len(chunkHashesToRemove))
 
+	for _, hash := range narInfoHashesToRemove {
+		deletions = append(deletions, func() {
 			log := log.With().Str("narinfo_hash", hash).Logger()
 
 			log.Info().Msg("deleting narinfo from store")
@@ -7558,11 +9068,7 @@ func (c *Cache) parallelDeleteFromStores(
 	}
 
 	for _, narURL := range narURLsToRemove {
-		wg.Add(1)
-
-		analytics.SafeGo(ctx, func() {
-			defer wg.Done()
-
+		deletions = append(deletions, func() {
 			log := log.With().Str("nar_url", narURL.String()).Logger()
 
 			log.Info().Msg("deleting nar from store")
@@ -7575,30 +9081,88 @@ func (c *Cache) parallelDeleteFromStores(
 		})
 	}
 
-	for _, hash := range chunkHashesToRemove {
-		wg.Add(1)
+	if chunkStore := c.getChunkStore(); chunkStore != nil {
+		for _, hash := range chunkHashesToRemove {
+			deletions = append(deletions, func() {
+				log := log.With().Str("chunk_hash", hash).Logger()
 
-		analytics.SafeGo(ctx, func() {
-			defer wg.Done()
+				log.Info().Msg("deleting chunk from store")
 
-			chunkStore := c.getChunkStore()
-			if chunkStore == nil {
-				return
-			}
+				if err := chunkStore.DeleteChunk(ctx, hash); err != nil {
+					log.Error().
+						Err(err).
+						Msg("error removing the chunk from the store")
+				}
+			})
+		}
+	}
 
-			log := log.With().Str("chunk_hash", hash).Logger()
+	concurrency := c.lruDeleteConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultLRUDeleteConcurrency
+	}
 
-			log.Info().Msg("deleting chunk from store")
+	startTime := time.Now()
 
-			if err := chunkStore.DeleteChunk(ctx, hash); err != nil {
-				log.Error().
-					Err(err).
-					Msg("error removing the chunk from the store")
-			}
+	for start := 0; start < len(deletions); start += lruDeleteBatchSize {
+		batch := deletions[start:min(start+lruDeleteBatchSize, len(deletions))]
+
+		deleted := start + runDeletions(ctx, batch, concurrency)
+
+		if deleted < start+len(batch) {
+			log.Warn().
+				Err(ctx.Err()).
+				Int("deleted", deleted).
+				Int("remaining", len(deletions)-deleted).
+				Msg("LRU deletion canceled, the files left are reclaimed by fsck")
+
+			return
+		}
+
+		log.Info().
+			Int("deleted", deleted).
+			Int("total", len(deletions)).
+			Dur("elapsed", time.Since(startTime)).
+			Msg("LRU deletion progress")
+	}
+}
+
+// runDeletions runs the deletions with at most concurrency of them in flight,
+// and returns how many ran: all of them unless ctx is canceled first.
+func runDeletions(ctx context.Context, deletions []func(), concurrency int) int {
+	sem := make(chan struct{}, concurrency)
+
+	var (
+		wg      sync.WaitGroup
+		started int
+	)
+
+loop:
+	for _, del := range deletions {
+		select {
+		case <-ctx.Done():
+			break loop
+		case sem <- struct{}{}:
+		}
+
+		started++
+
+		wg.Add(1)
+
+		analytics.SafeGo(ctx, func() {
+			defer func() {
+				<-sem
+
+				wg.Done()
+			}()
+
+			del()
 		})
 	}
 
 	wg.Wait()
+
+	return started
 }
 
 func (c *Cache) runLRU(ctx context.Context) func() {
@@ -7629,28 +9193,12 @@ func (c *Cache) runLRU(ctx context.Context) func() {
 				return err
 			}
 
-			var (
-				narInfoHashesToRemove []string
-				narURLsToRemove       []nar.URL
-				chunkHashesToRemove   []string
-				cleanupSize           uint64
-			)
+			var cleanupSize uint64
 
-			err = c.withEntTransaction(ctx, "runLRU", func(tx *ent.Tx) error {
+			err = c.withEntTransaction(ctx, "runLRU.cleanupSize", func(tx *ent.Tx) error {
 				var txErr error
 
 				cleanupSize, txErr = c.calculateCleanupSize(ctx, tx, log)
-				if txErr != nil || cleanupSize == 0 {
-					return txErr
-				}
-
-				narInfoHashesToRemove, narURLsToRemove, chunkHashesToRemove, txErr = c.deleteLRURecordsFromDB(
-					ctx,
-					tx,
-					log,
-					cleanupSize,
-					pinnedHashes,
-				)
 
 				return txErr
 			})
@@ -7658,24 +9206,15 @@ func (c *Cache) runLRU(ctx context.Context) func() {
 				return err
 			}
 
-			if len(narInfoHashesToRemove) == 0 &&
-				len(narURLsToRemove) == 0 &&
-				len(chunkHashesToRemove) == 0 {
-				return nil
+			if cleanupSize > 0 {
+				if err := c.evictLeastUsedNarInfos(ctx, log, cleanupSize, pinnedHashes); err != nil {
+					return err
+				}
 			}
 
-			// Track eviction counts
-			lruNarInfosEvictedTotal.Add(ctx, int64(len(narInfoHashesToRemove)))
-			lruNarFilesEvictedTotal.Add(ctx, int64(len(narURLsToRemove)))
-			lruChunksEvictedTotal.Add(ctx, int64(len(chunkHashesToRemove)))
-
-			// Track bytes freed (approximate as cleanupSize)
-			lruBytesFreedTotal.Add(ctx, int64(cleanupSize))
-
-			// Remove all the files from the store as fast as possible
-			c.parallelDeleteFromStores(ctx, log, narInfoHashesToRemove, narURLsToRemove, chunkHashesToRemove)
-
-			return nil
+			// The chunk store is bounded on its own: the NARs evicted above
+			// may share most of their chunks with the NARs kept.
+			return c.evictLeastUsedChunkedNarInfos(ctx, log, pinnedHashes)
 		})
 
 		// Record cleanup duration
@@ -7683,6 +9222,8 @@ func (c *Cache) runLRU(ctx context.Context) func() {
 		lruCleanupDuration.Record(ctx, duration)
 
 		if err != nil {
+			recordCronJobError(ctx, err)
+
 			return
 		}
 
@@ -7786,7 +9327,16 @@ func (c *Cache) runCDCDeletedCleanup(ctx context.Context) func() {
 			log.Info().Int("count", len(oldFiles)).Msg("found old compressed NAR files for cleanup")
 
 			// Delete each old compressed file
-			for _, oldFile := range oldFiles {
+			for i, oldFile := range oldFiles {
+				if c.maintenanceWindowClosed(ctx) {
+					log.Info().
+						Int("deleted", i).
+						Int("remaining", len(oldFiles)-i).
+						Msg("CDC delayed cleanup paused")
+
+					return nil
+				}
+
 				narURL := nar.URL{
 					Hash:        oldFile.Hash,
 					Compression: nar.CompressionType(oldFile.Compression),
@@ -7830,6 +9380,8 @@ func (c *Cache) runCDCDeletedCleanup(ctx context.Context) func() {
 		})
 		if err != nil {
 			zerolog.Ctx(ctx).Error().Err(err).Msg("error running CDC delayed cleanup")
+
+			recordCronJobError(ctx, err)
 		} else if !acquired {
 			zerolog.Ctx(ctx).Debug().Msg("another instance is running CDC delayed cleanup, skipping")
 		}
@@ -7948,7 +9500,19 @@ func (c *Cache) runCDCLazyRecovery(ctx context.Context, schedule cron.Schedule,
 				lazyChunkingDisabledSkipCount int
 			)
 
-			for _, stuckFile := range recoveryFiles {
+			for i, stuckFile := range recoveryFiles {
+				if c.maintenanceWindowClosed(ctx) {
+					// Resume with the first candidate left unexamined.
+					c.saveRecoveryCursor(ctx, stuckFile.ID-1)
+
+					log.Info().
+						Int("examined", i).
+						Int("remaining", len(recoveryFiles)-i).
+						Msg("CDC recovery paused")
+
+					return nil
+				}
+
 				if stuckFile.ChunkingStartedAt != nil {
 					recovered, chunkCount, err := c.recoverStaleCDCChunkingLock(ctx, stuckFile, &log)
 					if err != nil {
@@ -8036,6 +9600,8 @@ func (c *Cache) runCDCLazyRecovery(ctx context.Context, schedule cron.Schedule,
 		})
 		if err != nil {
 			zerolog.Ctx(ctx).Error().Err(err).Msg("error running CDC lazy recovery")
+
+			recordCronJobError(ctx, err)
 		} else if !acquired {
 			zerolog.Ctx(ctx).Debug().Msg("another instance is running CDC lazy recovery, skipping")
 		}
@@ -8186,7 +9752,13 @@ func (c *Cache) gcOrSkipBackingLessNarFile(ctx context.Context, narFileID int, n
 	// weight that would be re-scanned forever; garbage-collect it outright. A later
 	// request re-creates it on demand.
 	if len(nis) == 0 {
-		if err := c.dbClient.Ent().NarFile.DeleteOneID(narFileID).Exec(ctx); err != nil {
+		if err := c.withEntTransaction(ctx, "gcOrphanedPlaceholderNarFile", func(tx *ent.Tx) error {
+			if _, err := database.UnlinkNarFileChunks(ctx, tx, entnarfilechunk.NarFileIDEQ(narFileID)); err != nil {
+				return err
+			}
+
+			return tx.NarFile.DeleteOneID(narFileID).Exec(ctx)
+		}); err != nil {
 			log.Warn().
 				Err(err).
 				Str("hash", narURL.Hash).
@@ -8307,6 +9879,10 @@ func (c *Cache) gcDeleteAbsentNarInfosAndMaybeNarFile(
 			return nil
 		}
 
+		if _, err := database.UnlinkNarFileChunks(ctx, tx, entnarfilechunk.NarFileIDEQ(narFileID)); err != nil {
+			return fmt.Errorf("unlink the chunks of backing-less nar_file(%d): %w", narFileID, err)
+		}
+
 		if err := tx.NarFile.DeleteOneID(narFileID).Exec(ctx); err != nil {
 			return fmt.Errorf("delete backing-less nar_file(%d): %w", narFileID, err)
 		}
@@ -8355,6 +9931,10 @@ type upstreamSelectionFn func(
 )
 
 func (c *Cache) getHealthyUpstreams() []*upstream.Cache {
+	if c.IsOffline() {
+		return nil
+	}
+
 	c.upstreamCachesMu.RLock()
 	defer c.upstreamCachesMu.RUnlock()
 
@@ -8378,6 +9958,10 @@ func (c *Cache) selectNarInfoUpstream(
 	ctx context.Context,
 	hash string,
 ) (*upstream.Cache, error) {
+	if c.narInfoHedging != nil {
+		return c.hedgeNarInfoUpstream(ctx, hash, c.getHealthyUpstreams())
+	}
+
 	return c.selectUpstream(ctx, c.getHealthyUpstreams(), func(
 		ctx context.Context,
 		uc *upstream.Cache,
@@ -8561,6 +10145,7 @@ func derefInt64Ptr(p *int64) int64 {
 //   - Lazy CDC / drain (chunk store present but not eager): normalize ONLY once the
 //     NAR is genuinely chunked (HasNarInChunks), because the whole upstream-
 //     compressed file is still servable as .nar.xz until migration completes.
+//     Eager CDC is gated the same way with NarInfoCompressionKeepUpstream.
 func (c *Cache) maybeCDCNormalizeNarInfoURL(ctx context.Context, narURL nar.URL, narInfo *narinfo.NarInfo) {
 	if !c.isChunkStoreAvailable() {
 		return
@@ -8573,7 +10158,7 @@ func (c *Cache) maybeCDCNormalizeNarInfoURL(ctx context.Context, narURL nar.URL,
 		return
 	}
 
-	if !c.isEagerCDC() {
+	if !c.rewritesNarInfoPredictively() {
 		hasChunks, err := c.HasNarInChunks(ctx, normalizedURL)
 		if err != nil || !hasChunks {
 			return
@@ -8641,9 +10226,10 @@ func (c *Cache) getNarFromChunks(ctx context.Context, narURL *nar.URL) (int64, i
 
 	// Query initial state
 	var (
-		narFileID   int64
-		totalSize   int64
-		totalChunks int64
+		narFileID       int64
+		totalSize       int64
+		totalChunks     int64
+		linksIncomplete bool
 	)
 
 	err := c.withEntTransaction(ctx, "getNarFromChunks.init", func(tx *ent.Tx) error {
@@ -8660,7 +10246,9 @@ func (c *Cache) getNarFromChunks(ctx context.Context, narURL *nar.URL) (int64, i
 		// Touch the NAR file by the fetched row's ID. getNarFileFromDB may
 		// return a compression=none fallback row whose key differs from
 		// narURL, so filtering on narURL fields can silently miss it.
-		if nr.LastAccessedAt == nil || time.Since(*nr.LastAccessedAt) > c.recordAgeIgnoreTouch {
+		if c.touchMode == TouchModeAsync && c.touchDue(nr.LastAccessedAt) {
+			c.touchNarFileAsync(ctx, nr.ID)
+		} else if c.touchDue(nr.LastAccessedAt) {
 			now := time.Now()
 			if _, err := tx.NarFile.UpdateOneID(nr.ID).
 				SetLastAccessedAt(now).
@@ -8690,6 +10278,8 @@ func (c *Cache) getNarFromChunks(ctx context.Context, narURL *nar.URL) (int64, i
 			}
 
 			if int64(links) != nr.TotalChunks {
+				linksIncomplete = true
+
 				return fmt.Errorf("nar %s has %d of %d chunk links: %w",
 					narURL.Hash, links, nr.TotalChunks, storage.ErrNotFound)
 			}
@@ -8698,6 +10288,10 @@ func (c *Cache) getNarFromChunks(ctx context.Context, narURL *nar.URL) (int64, i
 		return nil
 	})
 	if err != nil {
+		if linksIncomplete {
+			c.maybeRepairChunkedNar(ctx, narFileID)
+		}
+
 		return 0, nil, err
 	}
 
@@ -8794,7 +10388,12 @@ func (c *Cache) streamCompleteChunks(
 	}
 
 	// Use prefetch pipeline to overlap I/O operations
-	return c.streamChunksWithPrefetch(ctx, w, chunkHashes, raw)
+	err = c.streamChunksWithPrefetch(ctx, w, chunkHashes, raw)
+	if errors.Is(err, chunk.ErrNotFound) {
+		c.maybeRepairChunkedNar(ctx, narFileID)
+	}
+
+	return err
 }
 
 // prefetchedChunk holds a chunk reader and any error from fetching it.
@@ -8833,16 +10432,7 @@ func (c *Cache) streamChunksWithPrefetch(ctx context.Context, w io.Writer, chunk
 			}
 
 			// Fetch chunk
-			var (
-				rc  io.ReadCloser
-				err error
-			)
-
-			if raw {
-				rc, err = c.getChunkStore().GetRawChunk(ctx, hash)
-			} else {
-				rc, err = c.getChunkStore().GetChunk(ctx, hash)
-			}
+			rc, err := c.fetchChunk(ctx, hash, raw)
 
 			// Send chunk or error to consumer
 			select {
@@ -8973,16 +10563,7 @@ func (c *Cache) streamProgressiveChunks(ctx context.Context, w io.Writer, narFil
 
 					ch := link.Edges.Chunk
 
-					var (
-						rc       io.ReadCloser
-						fetchErr error
-					)
-
-					if raw {
-						rc, fetchErr = c.getChunkStore().GetRawChunk(ctx, ch.Hash)
-					} else {
-						rc, fetchErr = c.getChunkStore().GetChunk(ctx, ch.Hash)
-					}
+					rc, fetchErr := c.fetchChunk(ctx, ch.Hash, raw)
 
 					select {
 					case chunkChan <- &prefetchedChunk{reader: rc, hash: ch.Hash, err: fetchErr}:
@@ -9089,16 +10670,7 @@ func (c *Cache) streamProgressiveChunks(ctx context.Context, w io.Writer, narFil
 
 						ch := link.Edges.Chunk
 
-						var (
-							rc       io.ReadCloser
-							fetchErr error
-						)
-
-						if raw {
-							rc, fetchErr = c.getChunkStore().GetRawChunk(ctx, ch.Hash)
-						} else {
-							rc, fetchErr = c.getChunkStore().GetChunk(ctx, ch.Hash)
-						}
+						rc, fetchErr := c.fetchChunk(ctx, ch.Hash, raw)
 
 						select {
 						case chunkChan <- &prefetchedChunk{reader: rc, hash: ch.Hash, err: fetchErr}:
@@ -9349,15 +10921,14 @@ func (c *Cache) MigrateChunksToNar(ctx context.Context, narURL *nar.URL, forceRe
 	// whole file written above. This happens after the durable PutNar so an
 	// interrupted run is recoverable by re-running.
 	if err := c.withEntTransaction(ctx, "MigrateChunksToNar.flip", func(tx *ent.Tx) error {
-		if _, err := tx.NarFileChunk.Delete().
-			Where(entnarfilechunk.NarFileID(nr.ID)).
-			Exec(ctx); err != nil {
+		if _, err := database.UnlinkNarFileChunks(ctx, tx, entnarfilechunk.NarFileID(nr.ID)); err != nil {
 			return fmt.Errorf("error deleting chunk links: %w", err)
 		}
 
 		if _, err := tx.NarFile.UpdateOneID(nr.ID).
 			SetTotalChunks(0).
 			ClearChunkingStartedAt().
+			SetStoredRaw(true).
 			SetUpdatedAt(time.Now()).
 			Save(ctx); err != nil {
 			return fmt.Errorf("error flipping nar_file to whole-file: %w", err)
@@ -9470,9 +11041,7 @@ func (c *Cache) PurgeChunkedNar(ctx context.Context, narURL *nar.URL) error {
 
 	// In a single transaction: remove chunk links then delete the nar_file record.
 	if err := c.withEntTransaction(ctx, "PurgeChunkedNar", func(tx *ent.Tx) error {
-		if _, err := tx.NarFileChunk.Delete().
-			Where(entnarfilechunk.NarFileID(nr.ID)).
-			Exec(ctx); err != nil {
+		if _, err := database.UnlinkNarFileChunks(ctx, tx, entnarfilechunk.NarFileID(nr.ID)); err != nil {
 			return fmt.Errorf("error deleting chunk links: %w", err)
 		}
 
@@ -9665,7 +11234,7 @@ func normalizedURL(rawURL string) (nar.URL, bool) {
 }
 
 // narInfoIDsByNormalizedURL returns the IDs of narinfos whose URL normalizes to
-// the same (hash, query) as target. The candidate set is narrowed in SQL by
+// the same (hash, canonical query) as target. The candidate set is narrowed in SQL by
 // URLContains(target.Hash) — the 52/64-char hash is highly selective — and
 // confirmed in Go via normalizedURL, so it matches unlinked nix-serve-style
 // prefixed URLs that a raw URLHasPrefix match would miss. The query is matched too
@@ -9681,7 +11250,7 @@ func narInfoIDsByNormalizedURL(ctx context.Context, q *ent.NarInfoClient, target
 		return nil, fmt.Errorf("error querying candidate narinfos for hash %q: %w", target.Hash, err)
 	}
 
-	wantQuery := target.Query.Encode()
+	wantQuery := target.CanonicalQuery()
 
 	ids := make([]int, 0, len(cands))
 
@@ -9690,7 +11259,7 @@ func narInfoIDsByNormalizedURL(ctx context.Context, q *ent.NarInfoClient, target
 			continue
 		}
 
-		if n, ok := normalizedURL(*ni.URL); ok && n.Hash == target.Hash && n.Query.Encode() == wantQuery {
+		if n, ok := normalizedURL(*ni.URL); ok && n.Hash == target.Hash && n.CanonicalQuery() == wantQuery {
 			ids = append(ids, ni.ID)
 		}
 	}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -26,6 +27,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	entconfigentry "github.com/kalbasit/ncps/ent/configentry"
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
 	entpinnedclosure "github.com/kalbasit/ncps/ent/pinnedclosure"
 	locklocal "github.com/kalbasit/ncps/pkg/lock/local"
 
@@ -555,6 +557,77 @@ Sig: cache.nixos.org-1:eGSj5WPpZRjwzx7eWpCyZdNsFHjhtGTZF8T4FccYXjHNkTOZoGPfplgFP
 	assert.Equal(t, []byte(narBody), got2)
 }
 
+// TestGetNarInfoAliasExtensionURL verifies that an upstream narinfo whose URL
+// uses an alias extension (.nar.zstd) is served with the canonical .nar.zst URL
+// while its NAR is still fetched from the upstream's alias path.
+func TestGetNarInfoAliasExtensionURL(t *testing.T) {
+	t.Parallel()
+
+	const (
+		narInfoHash = "1123456789abcdfghijklmnpqrsvwxyz"
+		narHash     = "188g68hrjilbsjifcj70k8729zqhm9sl1q336vg5wxwzw0qp0sk4"
+		fileHash    = "1xqqdh1yn5sz3d6wcz3qz3azm5mbypwq6mv8g2dal1v042h0sprf"
+		fileSize    = 50308
+		aliasURL    = "nar/" + narHash + ".nar.zstd"
+	)
+
+	narInfoText := fmt.Sprintf(`StorePath: /nix/store/%s-alias-1.0
+URL: %s
+Compression: zstd
+FileHash: sha256:%s
+FileSize: %d
+NarHash: sha256:%s
+NarSize: 226560
+References: %s-alias-1.0
+`, narInfoHash, aliasURL, fileHash, fileSize, narHash, narInfoHash)
+
+	narBody := testhelper.MustRandString(fileSize)
+
+	ts := testdata.NewTestServer(t, 40)
+	t.Cleanup(ts.Close)
+
+	ts.AddMaybeHandler(func(w http.ResponseWriter, r *http.Request) bool {
+		switch r.URL.Path {
+		case "/" + narInfoHash + ".narinfo":
+			_, _ = w.Write([]byte(narInfoText))
+
+			return true
+		case "/" + aliasURL:
+			_, _ = w.Write([]byte(narBody))
+
+			return true
+		}
+
+		return false
+	})
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), &upstream.Options{})
+	require.NoError(t, err)
+
+	c.AddUpstreamCaches(newContext(), uc)
+
+	<-c.GetHealthChecker().Trigger()
+
+	ni, err := c.GetNarInfo(context.Background(), narInfoHash)
+	require.NoError(t, err)
+	assert.Equal(t, "nar/"+narHash+".nar.zst", ni.URL)
+
+	narURL, err := nar.ParseURL(ni.URL)
+	require.NoError(t, err)
+
+	_, _, rc, err := c.GetNar(context.Background(), narURL)
+	require.NoError(t, err)
+
+	defer rc.Close()
+
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, narBody, string(got))
+}
+
 // TestGetNarInfoSnixCastoreOpaqueURL exercises the snix-castore variant of an
 // opaque upstream narinfo: the URL: field has NO ".nar" token and carries a
 // required ?narsize=N query (e.g. cache.snix.dev's
@@ -684,6 +757,101 @@ Sig: cache.nixos.org-1:eGSj5WPpZRjwzx7eWpCyZdNsFHjhtGTZF8T4FccYXjHNkTOZoGPfplgFP
 	assert.True(t, sawNarsizeQuery.Load(), "re-fetch GET must carry the restored ?narsize query")
 }
 
+// TestGetNarInfoCAQueryURL covers a hash-named NAR URL carrying the ?ca=
+// content address of a content-addressed path: the query must survive on the
+// served narinfo URL, on the upstream GET and on the stored nar_file.
+func TestGetNarInfoCAQueryURL(t *testing.T) {
+	t.Parallel()
+
+	const (
+		narInfoHash = "0123456789abcdfghijklmnpqrsvwxyz"
+		narHash     = "188g68hrjilbsjifcj70k8729zqhm9sl1q336vg5wxwzw0qp0sk4"
+		fileHash    = "1xqqdh1yn5sz3d6wcz3qz3azm5mbypwq6mv8g2dal1v042h0sprf"
+		fileSize    = 50308
+		ca          = "fixed:r:sha256:" + narHash
+		narURLText  = "nar/" + narHash + ".nar.zst?ca=" + ca
+		narPath     = "/nar/" + narHash + ".nar.zst"
+	)
+
+	narInfoText := fmt.Sprintf(`StorePath: /nix/store/%s-ca-1.0
+URL: %s
+Compression: zstd
+FileHash: sha256:%s
+FileSize: %d
+NarHash: sha256:%s
+NarSize: 226560
+References: %s-ca-1.0
+CA: %s
+Sig: cache.nixos.org-1:eGSj5WPpZRjwzx7eWpCyZdNsFHjhtGTZF8T4FccYXjHNkTOZoGPfplgFP1w5bEST0/FtfV7f3AmQUVEv1NAEDg==
+`, narInfoHash, narURLText, fileHash, fileSize, narHash, narInfoHash, ca)
+
+	narBody := testhelper.MustRandString(fileSize)
+
+	var sawCAQuery atomic.Bool
+
+	ts := testdata.NewTestServer(t, 40)
+	t.Cleanup(ts.Close)
+
+	ts.AddMaybeHandler(func(w http.ResponseWriter, r *http.Request) bool {
+		switch r.URL.Path {
+		case "/" + narInfoHash + ".narinfo":
+			_, _ = w.Write([]byte(narInfoText))
+
+			return true
+		case narPath:
+			if r.URL.Query().Get("ca") == ca {
+				sawCAQuery.Store(true)
+			}
+
+			w.Header().Set("Content-Length", strconv.Itoa(len(narBody)))
+			_, _ = w.Write([]byte(narBody))
+
+			return true
+		}
+
+		return false
+	})
+
+	c, dbClient, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), &upstream.Options{})
+	require.NoError(t, err)
+
+	c.AddUpstreamCaches(newContext(), uc)
+	c.SetRecordAgeIgnoreTouch(0)
+
+	<-c.GetHealthChecker().Trigger()
+
+	ni, err := c.GetNarInfo(context.Background(), narInfoHash)
+	require.NoError(t, err)
+
+	narURL, err := nar.ParseURL(ni.URL)
+	require.NoError(t, err)
+	assert.Equal(t, ca, narURL.Query.Get("ca"), "served narinfo URL must keep the ?ca= query")
+
+	require.Eventually(t, func() bool {
+		return c.HasNarInStore(context.Background(), narURL)
+	}, downloadPollTimeout, 10*time.Millisecond, "prefetched NAR should land in the store")
+
+	assert.True(t, sawCAQuery.Load(), "upstream GET must carry the ?ca= query")
+
+	nf, err := dbClient.Ent().NarFile.Query().
+		Where(entnarfile.HashEQ(narHash), entnarfile.QueryEQ(narURL.CanonicalQuery())).
+		Only(context.Background())
+	require.NoError(t, err, "the nar_file must be keyed by the ?ca= query")
+	assert.Equal(t, "ca="+url.QueryEscape(ca), nf.Query)
+
+	_, _, rc, err := c.GetNar(context.Background(), narURL)
+	require.NoError(t, err)
+
+	defer rc.Close()
+
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(narBody), got)
+}
+
 func testGetNarInfo(factory cacheFactory) func(*testing.T) {
 	return func(t *testing.T) {
 		ts := testdata.NewTestServer(t, 40)
@@ -0,0 +1,286 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+
+	"github.com/kalbasit/ncps/pkg/chunker"
+	"github.com/kalbasit/ncps/pkg/config"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+)
+
+// cdcDrainPollInterval is how often DisableCDC checks whether the chunking
+// jobs in flight are done.
+const cdcDrainPollInterval = 100 * time.Millisecond
+
+var (
+	// ErrChunkStoreRequired is returned by EnableCDC when no chunk store is
+	// configured and none can be built.
+	ErrChunkStoreRequired = errors.New("a chunk store is required to enable CDC")
+
+	// ErrCDCSizesRequired is returned by EnableCDC when the chunk sizes are
+	// neither given, configured nor stored in the database.
+	ErrCDCSizesRequired = errors.New("the CDC chunk sizes are required to enable CDC for the first time")
+
+	// ErrChunkedNarsRemain is returned by DisableCDC when NARs are stored only as
+	// chunks and draining them was not requested.
+	ErrChunkedNarsRemain = errors.New("NARs are stored only as chunks")
+
+	// errCDCJobRefused is returned when CDC was disabled before the chunking job
+	// of a NAR was admitted, before anything was read or recorded: the NAR can
+	// still be stored as a whole file.
+	errCDCJobRefused = fmt.Errorf("%w: the chunking job was refused", ErrCDCDisabled)
+)
+
+// ChunkStoreFactory builds the chunk store of the cache.
+type ChunkStoreFactory func(ctx context.Context) (chunk.Store, error)
+
+// CDCStatus is the state of content-defined chunking.
+type CDCStatus struct {
+	// Enabled tells whether new NARs are chunked.
+	Enabled bool
+
+	// ChunkStore tells whether a chunk store is configured. With CDC disabled,
+	// it serves the NARs already chunked until they are migrated back.
+	ChunkStore bool
+
+	// LazyChunking tells whether NARs are chunked in the background.
+	LazyChunking bool
+
+	// MinSize, AvgSize and MaxSize are the chunk sizes, zero until CDC was
+	// enabled.
+	MinSize uint32
+	AvgSize uint32
+	MaxSize uint32
+
+	// InFlightJobs is the number of chunking jobs running.
+	InFlightJobs int
+
+	// ChunkedNars is the number of NARs stored as chunks.
+	ChunkedNars int
+}
+
+// SetChunkStoreFactory sets the factory building the chunk store when CDC is
+// enabled at runtime by EnableCDC while none is configured.
+func (c *Cache) SetChunkStoreFactory(f ChunkStoreFactory) {
+	c.cdcMu.Lock()
+	defer c.cdcMu.Unlock()
+
+	c.chunkStoreFactory = f
+}
+
+// CDCStatus returns the state of content-defined chunking.
+func (c *Cache) CDCStatus(ctx context.Context) (CDCStatus, error) {
+	chunkedNars, err := c.countChunkedNars(ctx)
+	if err != nil {
+		return CDCStatus{}, err
+	}
+
+	c.cdcMu.RLock()
+	defer c.cdcMu.RUnlock()
+
+	return CDCStatus{
+		Enabled:      c.cdcEnabled && c.chunkStore != nil,
+		ChunkStore:   c.chunkStore != nil,
+		LazyChunking: c.cdcLazyChunkingEnabled,
+		MinSize:      c.cdcSizes.min,
+		AvgSize:      c.cdcSizes.avg,
+		MaxSize:      c.cdcSizes.max,
+		InFlightJobs: c.cdcJobs,
+		ChunkedNars:  chunkedNars,
+	}, nil
+}
+
+// EnableCDC starts chunking the new NARs without a restart. The chunk sizes
+// default to the ones configured, then to the ones stored in the database;
+// they must match the stored ones, as with the --cache-cdc-* flags. A chunk
+// store is built by the factory of SetChunkStoreFactory if none is configured.
+//
+// The change is not persisted: a restart applies the flags again.
+func (c *Cache) EnableCDC(ctx context.Context, minSize, avgSize, maxSize uint32) error {
+	sizes, err := c.enableCDCSizes(ctx, chunkSizes{min: minSize, avg: avgSize, max: maxSize})
+	if err != nil {
+		return err
+	}
+
+	cdcChunker, err := chunker.NewCDCChunker(sizes.min, sizes.avg, sizes.max)
+	if err != nil {
+		return fmt.Errorf("%w: %w", config.ErrCDCInvalidChunkSizes, err)
+	}
+
+	if err := c.config.ValidateOrStoreCDCConfig(ctx, true, sizes.min, sizes.avg, sizes.max); err != nil {
+		return err
+	}
+
+	cs := c.getChunkStore()
+	if cs == nil {
+		c.cdcMu.RLock()
+		factory := c.chunkStoreFactory
+		c.cdcMu.RUnlock()
+
+		if factory == nil {
+			return ErrChunkStoreRequired
+		}
+
+		// The chunk store outlives the request enabling CDC.
+		if cs, err = factory(context.WithoutCancel(ctx)); err != nil {
+			return fmt.Errorf("error creating the chunk store: %w", err)
+		}
+	}
+
+	c.cdcMu.Lock()
+	defer c.cdcMu.Unlock()
+
+	if c.chunkStore == nil {
+		c.chunkStore = cs
+	}
+
+	c.cdcEnabled = true
+	c.chunker = cdcChunker
+	c.cdcSizes = sizes
+
+	zerolog.Ctx(ctx).
+		Info().
+		Uint32("cdc-min", sizes.min).
+		Uint32("cdc-avg", sizes.avg).
+		Uint32("cdc-max", sizes.max).
+		Msg("CDC enabled")
+
+	return nil
+}
+
+// DisableCDC stops chunking the new NARs without a restart, then waits until
+// the chunking jobs in flight are done, or ctx is. The NARs already chunked
+// keep being served from the chunk store until they are migrated back with
+// migrate-chunks-to-nar, which drain must acknowledge if there are any;
+// otherwise DisableCDC returns ErrChunkedNarsRemain and leaves CDC enabled.
+//
+// The change is not persisted: a restart applies the flags again.
+func (c *Cache) DisableCDC(ctx context.Context, drain bool) error {
+	chunkedNars, err := c.countChunkedNars(ctx)
+	if err != nil {
+		return err
+	}
+
+	if chunkedNars > 0 && !drain {
+		return fmt.Errorf("%w: %d NARs would be served from the chunk store until migrated",
+			ErrChunkedNarsRemain, chunkedNars)
+	}
+
+	c.cdcMu.Lock()
+	wasEnabled := c.cdcEnabled
+	c.cdcEnabled = false
+	c.cdcMu.Unlock()
+
+	if wasEnabled {
+		zerolog.Ctx(ctx).
+			Info().
+			Int("chunked_nars", chunkedNars).
+			Msg("CDC disabled; draining the chunking jobs in flight")
+	}
+
+	return c.waitCDCJobs(ctx)
+}
+
+// beginCDCJob admits a chunking job unless CDC is disabled. The returned
+// function ends the job.
+func (c *Cache) beginCDCJob() (func(), bool) {
+	c.cdcMu.Lock()
+	defer c.cdcMu.Unlock()
+
+	if !c.cdcEnabled {
+		return nil, false
+	}
+
+	c.cdcJobs++
+
+	return func() {
+		c.cdcMu.Lock()
+		c.cdcJobs--
+		c.cdcMu.Unlock()
+	}, true
+}
+
+// waitCDCJobs waits until no chunking job is in flight, or ctx is done.
+func (c *Cache) waitCDCJobs(ctx context.Context) error {
+	ticker := time.NewTicker(cdcDrainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		c.cdcMu.RLock()
+		jobs := c.cdcJobs
+		c.cdcMu.RUnlock()
+
+		if jobs == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("error waiting for %d chunking jobs: %w", jobs, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// enableCDCSizes returns sizes, or the configured chunk sizes if sizes is
+// zero, or else the ones stored in the database.
+func (c *Cache) enableCDCSizes(ctx context.Context, sizes chunkSizes) (chunkSizes, error) {
+	if sizes != (chunkSizes{}) {
+		return sizes, nil
+	}
+
+	c.cdcMu.RLock()
+	sizes = c.cdcSizes
+	c.cdcMu.RUnlock()
+
+	if sizes != (chunkSizes{}) {
+		return sizes, nil
+	}
+
+	for _, s := range []struct {
+		get func(context.Context) (string, error)
+		v   *uint32
+	}{
+		{c.config.GetCDCMin, &sizes.min},
+		{c.config.GetCDCAvg, &sizes.avg},
+		{c.config.GetCDCMax, &sizes.max},
+	} {
+		raw, err := s.get(ctx)
+		if errors.Is(err, config.ErrConfigNotFound) {
+			return chunkSizes{}, ErrCDCSizesRequired
+		}
+
+		if err != nil {
+			return chunkSizes{}, fmt.Errorf("error reading the stored CDC chunk sizes: %w", err)
+		}
+
+		v, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return chunkSizes{}, fmt.Errorf("error parsing the stored CDC chunk size %q: %w", raw, err)
+		}
+
+		*s.v = uint32(v)
+	}
+
+	return sizes, nil
+}
+
+// countChunkedNars returns the number of NARs stored as chunks.
+func (c *Cache) countChunkedNars(ctx context.Context) (int, error) {
+	count, err := c.dbClient.Ent().NarFile.Query().
+		Where(entnarfile.TotalChunksGT(0)).
+		Count(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error counting the chunked NARs: %w", err)
+	}
+
+	return count, nil
+}
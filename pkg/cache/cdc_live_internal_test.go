@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestLiveCDCToggle(t *testing.T) {
+	t.Parallel()
+
+	ctx := newContext()
+
+	c, dbClient, _, dir, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	require.ErrorIs(t, c.EnableCDC(ctx, 0, 0, 0), ErrCDCSizesRequired)
+	require.ErrorIs(t, c.EnableCDC(ctx, 1024, 4096, 8192), ErrChunkStoreRequired)
+
+	c.SetChunkStoreFactory(func(_ context.Context) (chunk.Store, error) {
+		return chunk.NewLocalStore(filepath.Join(dir, "chunks-store"))
+	})
+
+	require.NoError(t, c.EnableCDC(ctx, 1024, 4096, 8192))
+
+	status, err := c.CDCStatus(ctx)
+	require.NoError(t, err)
+	assert.True(t, status.Enabled)
+	assert.Equal(t, uint32(4096), status.AvgSize)
+
+	// A NAR is chunked while CDC is enabled.
+	chunked := testhelper.MustRandString(5000)
+	chunkedURL := nar.URL{Hash: strings.Repeat("1", 52), Compression: nar.CompressionTypeNone}
+
+	require.NoError(t, c.PutNar(ctx, chunkedURL, io.NopCloser(strings.NewReader(chunked))))
+
+	require.ErrorIs(t, c.DisableCDC(ctx, false), ErrChunkedNarsRemain)
+	assert.True(t, c.isCDCEnabled(), "CDC is left enabled without the drain acknowledgement")
+
+	// Disabling waits for the chunking job in flight.
+	endJob, ok := c.beginCDCJob()
+	require.True(t, ok)
+
+	disabled := make(chan error, 1)
+
+	go func() { disabled <- c.DisableCDC(ctx, true) }()
+
+	require.Eventually(t, func() bool { return !c.isCDCEnabled() }, 5*time.Second, 10*time.Millisecond)
+
+	_, ok = c.beginCDCJob()
+	assert.False(t, ok, "no chunking job is admitted once CDC is disabled")
+
+	select {
+	case err := <-disabled:
+		t.Fatalf("DisableCDC returned %v before the job in flight ended", err)
+	case <-time.After(3 * cdcDrainPollInterval):
+	}
+
+	endJob()
+
+	require.NoError(t, <-disabled)
+
+	status, err = c.CDCStatus(ctx)
+	require.NoError(t, err)
+	assert.False(t, status.Enabled)
+	assert.True(t, status.ChunkStore)
+	assert.Equal(t, 1, status.ChunkedNars)
+	assert.Zero(t, status.InFlightJobs)
+
+	// The chunked NAR is still served, and new NARs are stored whole.
+	_, _, r, err := c.GetNar(ctx, chunkedURL)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(r)
+	require.NoError(t, r.Close())
+	require.NoError(t, err)
+	assert.Equal(t, chunked, string(body))
+
+	wholeURL := nar.URL{Hash: strings.Repeat("2", 52), Compression: nar.CompressionTypeNone}
+
+	require.NoError(t, c.PutNar(ctx, wholeURL, io.NopCloser(strings.NewReader(testhelper.MustRandString(5000)))))
+
+	nf, err := fetchNarFile(ctx, dbClient, wholeURL.Hash, nar.CompressionTypeNone.String(), "")
+	require.NoError(t, err)
+	assert.Zero(t, nf.TotalChunks)
+
+	// The sizes are remembered when CDC is enabled again.
+	require.NoError(t, c.EnableCDC(ctx, 0, 0, 0))
+	assert.True(t, c.isCDCEnabled())
+}
+
+// disablingReader disables CDC on the first read, after the upload found it
+// enabled and before its chunking job is admitted.
+type disablingReader struct {
+	io.Reader
+
+	t    *testing.T
+	c    *Cache
+	once sync.Once
+}
+
+func (r *disablingReader) Read(p []byte) (int, error) {
+	r.once.Do(func() { require.NoError(r.t, r.c.DisableCDC(newContext(), true)) })
+
+	return r.Reader.Read(p)
+}
+
+func TestPutNar_CDCDisabledDuringUpload(t *testing.T) {
+	t.Parallel()
+
+	ctx := newContext()
+
+	c, dbClient, _, dir, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	cs, err := chunk.NewLocalStore(filepath.Join(dir, "chunks-store"))
+	require.NoError(t, err)
+
+	c.SetChunkStore(cs)
+	require.NoError(t, c.SetCDCConfiguration(true, 1024, 4096, 8192))
+
+	content := testhelper.MustRandString(5000)
+	nu := nar.URL{Hash: strings.Repeat("3", 52), Compression: nar.CompressionTypeNone}
+
+	body := &disablingReader{Reader: strings.NewReader(content), t: t, c: c}
+
+	require.NoError(t, c.PutNar(ctx, nu, io.NopCloser(body)))
+	assert.False(t, c.isCDCEnabled())
+
+	nf, err := fetchNarFile(ctx, dbClient, nu.Hash, nar.CompressionTypeNone.String(), "")
+	require.NoError(t, err)
+	assert.Zero(t, nf.TotalChunks, "the NAR is stored as a whole file")
+
+	_, _, r, err := c.GetNar(ctx, nu)
+	require.NoError(t, err)
+
+	got, err := io.ReadAll(r)
+	require.NoError(t, r.Close())
+	require.NoError(t, err)
+	assert.Equal(t, content, string(got))
+}
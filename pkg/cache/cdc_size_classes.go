@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/pkg/chunker"
+	"github.com/kalbasit/ncps/pkg/helper"
+)
+
+// ErrInvalidChunkSizeClass is returned when a CDC size class cannot be parsed.
+var ErrInvalidChunkSizeClass = errors.New("invalid CDC size class")
+
+// ChunkSizeClass selects the CDC parameters of the NARs whose uncompressed size
+// is at most MaxNarSize. NARs larger than every class, or whose size is not
+// known before chunking, use the global CDC parameters.
+type ChunkSizeClass struct {
+	MaxNarSize uint64
+	Min        uint32
+	Avg        uint32
+	Max        uint32
+}
+
+// ParseChunkSizeClass parses a size class written as
+// "<max-nar-size>:<min>:<avg>:<max>", for example "1M:4K:16K:64K".
+func ParseChunkSizeClass(s string) (ChunkSizeClass, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 4 {
+		return ChunkSizeClass{}, fmt.Errorf(
+			"%w %q: expected <max-nar-size>:<min>:<avg>:<max>", ErrInvalidChunkSizeClass, s)
+	}
+
+	sizes := make([]uint64, len(parts))
+
+	for i, part := range parts {
+		size, err := helper.ParseSize(strings.TrimSpace(part))
+		if err != nil {
+			return ChunkSizeClass{}, fmt.Errorf("%w %q: %w", ErrInvalidChunkSizeClass, s, err)
+		}
+
+		if size == 0 || (i > 0 && size > math.MaxUint32) {
+			return ChunkSizeClass{}, fmt.Errorf("%w %q: %q is out of range", ErrInvalidChunkSizeClass, s, part)
+		}
+
+		sizes[i] = size
+	}
+
+	return ChunkSizeClass{
+		MaxNarSize: sizes[0],
+		Min:        uint32(sizes[1]), //nolint:gosec // G115: bounded above
+		Avg:        uint32(sizes[2]), //nolint:gosec // G115: bounded above
+		Max:        uint32(sizes[3]), //nolint:gosec // G115: bounded above
+	}, nil
+}
+
+// String returns the class in the form accepted by ParseChunkSizeClass.
+func (sc ChunkSizeClass) String() string {
+	return fmt.Sprintf("%dB:%dB:%dB:%dB", sc.MaxNarSize, sc.Min, sc.Avg, sc.Max)
+}
+
+// chunkSizes are the CDC parameters a chunker was created with.
+type chunkSizes struct {
+	min, avg, max uint32
+}
+
+type cdcSizeClass struct {
+	maxNarSize uint64
+	sizes      chunkSizes
+	chunker    chunker.Chunker
+}
+
+// SetCDCSizeClasses configures the CDC size classes. A NAR is chunked with the
+// parameters of the smallest class it fits in. Passing no classes chunks every
+// NAR with the global CDC parameters.
+func (c *Cache) SetCDCSizeClasses(classes []ChunkSizeClass) error {
+	sizeClasses := make([]cdcSizeClass, 0, len(classes))
+
+	for _, class := range classes {
+		ch, err := chunker.NewCDCChunker(class.Min, class.Avg, class.Max)
+		if err != nil {
+			return fmt.Errorf("%w %s: %w", ErrInvalidChunkSizeClass, class, err)
+		}
+
+		sizeClasses = append(sizeClasses, cdcSizeClass{
+			maxNarSize: class.MaxNarSize,
+			sizes:      chunkSizes{min: class.Min, avg: class.Avg, max: class.Max},
+			chunker:    ch,
+		})
+	}
+
+	slices.SortFunc(sizeClasses, func(a, b cdcSizeClass) int {
+		switch {
+		case a.maxNarSize < b.maxNarSize:
+			return -1
+		case a.maxNarSize > b.maxNarSize:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	c.cdcMu.Lock()
+	defer c.cdcMu.Unlock()
+
+	c.cdcSizeClasses = sizeClasses
+
+	return nil
+}
+
+// chunkerForNarSize returns the chunker for a NAR of narSize uncompressed bytes
+// and the parameters it was created with. A narSize of zero means the size is
+// unknown and selects the global chunker.
+func (c *Cache) chunkerForNarSize(narSize uint64) (chunker.Chunker, chunkSizes) {
+	c.cdcMu.RLock()
+	defer c.cdcMu.RUnlock()
+
+	if narSize > 0 {
+		for _, class := range c.cdcSizeClasses {
+			if narSize <= class.maxNarSize {
+				return class.chunker, class.sizes
+			}
+		}
+	}
+
+	return c.chunker, c.cdcSizes
+}
+
+// chunkerForNarFile returns a chunker that re-creates the chunks of nf: one
+// with the CDC parameters recorded on it, or the global chunker for a NAR
+// chunked before they were recorded.
+func (c *Cache) chunkerForNarFile(nf *ent.NarFile) (chunker.Chunker, chunkSizes, error) {
+	if nf.ChunkMinSize == nil || nf.ChunkAvgSize == nil || nf.ChunkMaxSize == nil {
+		ch, sizes := c.chunkerForNarSize(0)
+
+		return ch, sizes, nil
+	}
+
+	sizes := chunkSizes{min: *nf.ChunkMinSize, avg: *nf.ChunkAvgSize, max: *nf.ChunkMaxSize}
+
+	ch, err := chunker.NewCDCChunker(sizes.min, sizes.avg, sizes.max)
+	if err != nil {
+		return nil, chunkSizes{}, fmt.Errorf("failed to create CDC chunker: %w", err)
+	}
+
+	return ch, sizes, nil
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestParseChunkSizeClass(t *testing.T) {
+	t.Parallel()
+
+	class, err := ParseChunkSizeClass("1M:4K:16K:64K")
+	require.NoError(t, err)
+	assert.Equal(t, ChunkSizeClass{MaxNarSize: 1 << 20, Min: 4 << 10, Avg: 16 << 10, Max: 64 << 10}, class)
+
+	roundTrip, err := ParseChunkSizeClass(class.String())
+	require.NoError(t, err)
+	assert.Equal(t, class, roundTrip)
+
+	for _, s := range []string{"", "1M:4K:16K", "1M:4K:16K:64K:1M", "1M:four:16K:64K", "0B:4K:16K:64K", "1M:4K:16K:8G"} {
+		_, err := ParseChunkSizeClass(s)
+		require.ErrorIs(t, err, ErrInvalidChunkSizeClass, s)
+	}
+}
+
+func TestCDCSizeClasses(t *testing.T) {
+	t.Parallel()
+
+	ctx := newContext()
+
+	c, dbClient, _, dir, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	cs, err := chunk.NewLocalStore(filepath.Join(dir, "chunks-store"))
+	require.NoError(t, err)
+
+	c.SetChunkStore(cs)
+	require.NoError(t, c.SetCDCConfiguration(true, 1024, 4096, 8192))
+
+	// Classes are matched smallest first, whatever their order.
+	require.NoError(t, c.SetCDCSizeClasses([]ChunkSizeClass{
+		{MaxNarSize: 1 << 20, Min: 8192, Avg: 16384, Max: 32768},
+		{MaxNarSize: 10000, Min: 2048, Avg: 8192, Max: 16384},
+	}))
+
+	_, sizes := c.chunkerForNarSize(5000)
+	assert.Equal(t, chunkSizes{min: 2048, avg: 8192, max: 16384}, sizes)
+
+	_, sizes = c.chunkerForNarSize(50000)
+	assert.Equal(t, chunkSizes{min: 8192, avg: 16384, max: 32768}, sizes)
+
+	_, sizes = c.chunkerForNarSize(2 << 20)
+	assert.Equal(t, chunkSizes{min: 1024, avg: 4096, max: 8192}, sizes, "larger than every class")
+
+	_, sizes = c.chunkerForNarSize(0)
+	assert.Equal(t, chunkSizes{min: 1024, avg: 4096, max: 8192}, sizes, "unknown size")
+
+	require.Error(t, c.SetCDCSizeClasses([]ChunkSizeClass{{MaxNarSize: 10000, Min: 8192, Avg: 16, Max: 32}}))
+
+	// The parameters a NAR was chunked with are recorded on its nar_file.
+	content := testhelper.MustRandString(5000)
+	narURL := nar.URL{Hash: strings.Repeat("1", 52), Compression: nar.CompressionTypeNone}
+
+	require.NoError(t, c.PutNar(ctx, narURL, io.NopCloser(strings.NewReader(content))))
+
+	nf, err := fetchNarFile(ctx, dbClient, narURL.Hash, nar.CompressionTypeNone.String(), "")
+	require.NoError(t, err)
+
+	require.NotNil(t, nf.ChunkMinSize)
+	require.NotNil(t, nf.ChunkAvgSize)
+	require.NotNil(t, nf.ChunkMaxSize)
+	assert.Equal(t, uint32(2048), *nf.ChunkMinSize)
+	assert.Equal(t, uint32(8192), *nf.ChunkAvgSize)
+	assert.Equal(t, uint32(16384), *nf.ChunkMaxSize)
+
+	// Changing the classes leaves the recorded parameters usable for repairs.
+	require.NoError(t, c.SetCDCSizeClasses(nil))
+
+	_, sizes, err = c.chunkerForNarFile(nf)
+	require.NoError(t, err)
+	assert.Equal(t, chunkSizes{min: 2048, avg: 8192, max: 16384}, sizes)
+
+	_, _, rc, err := c.GetNar(ctx, narURL)
+	require.NoError(t, err)
+
+	defer rc.Close()
+
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(got))
+}
@@ -1419,6 +1419,7 @@ func testCDCMigrateNarToChunksRecoversFromPartialChunking(factory cacheFactory)
 			SetChunkIndex(0).
 			Save(ctx)
 		require.NoError(t, err)
+		require.NoError(t, dbClient.Ent().Chunk.UpdateOneID(fakeChunk.ID).AddRefCount(1).Exec(ctx))
 
 		// Mark chunking as started (sets chunking_started_at = CURRENT_TIMESTAMP).
 		_, err = dbClient.Ent().NarFile.UpdateOneID(narFile.ID).SetChunkingStartedAt(time.Now()).Save(ctx)
@@ -1711,6 +1712,7 @@ func testCDCStaleLockCleansUpChunkFiles(factory cacheFactory) func(*testing.T) {
 			SetChunkIndex(0).
 			Save(ctx)
 		require.NoError(t, err)
+		require.NoError(t, dbClient.Ent().Chunk.UpdateOneID(fakeChunk.ID).AddRefCount(1).Exec(ctx))
 
 		// Mark chunking as started and move the timestamp 2 hours into the past.
 		_, err = dbClient.Ent().NarFile.UpdateOneID(narFile.ID).SetChunkingStartedAt(time.Now()).Save(ctx)
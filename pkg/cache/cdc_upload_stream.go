@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/rs/zerolog"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+// SetCDCUploadStreaming chunks the uploaded NARs straight from the request
+// body instead of writing them to a temp file first, halving the disk IO of
+// large uploads. The first lookahead bytes read are kept in memory so that an
+// upload failing on a retryable error before reading past them is replayed
+// through a temp file. A non-positive lookahead disables the streaming.
+func (c *Cache) SetCDCUploadStreaming(lookahead int) {
+	c.cdcUploadLookahead = lookahead
+}
+
+// putNarWithCDCStreaming chunks the NAR of an upload straight from r. See
+// SetCDCUploadStreaming.
+func (c *Cache) putNarWithCDCStreaming(ctx context.Context, narURL nar.URL, r io.Reader) error {
+	rr := &replayReader{r: r, limit: c.cdcUploadLookahead}
+
+	// storeNarWithCDCFromReader normalizes the compression of the URL it is
+	// given; narURL is kept for the fallback. The size of a streamed NAR is not
+	// known, so it is chunked with the global CDC parameters.
+	streamURL := narURL
+
+	err := c.storeNarWithCDCFromReaderWithMigrationLock(ctx, rr, 0, &streamURL, nil)
+	if errors.Is(err, errCDCJobRefused) {
+		// Nothing was read from the request yet.
+		zerolog.Ctx(ctx).Info().Msg("CDC was disabled during the upload, storing the nar as a whole file")
+
+		return c.putNarWholeFile(ctx, narURL, rr)
+	}
+
+	if err != nil && !errors.Is(err, storage.ErrAlreadyExists) {
+		body, ok := rr.replay()
+		if !ok || !isRetryableUploadStreamError(err) {
+			return err
+		}
+
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(err).
+			Msg("error chunking the uploaded nar from the request, retrying through a temp file")
+
+		return c.putNarWithCDC(ctx, narURL, body)
+	}
+
+	if err := c.checkAndFixNarInfosForNar(context.WithoutCancel(ctx), narURL); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to fix narinfos after PutNar")
+	}
+
+	return nil
+}
+
+// isRetryableUploadStreamError reports whether an upload chunked from the
+// request may succeed through a temp file: it is not when another instance
+// chunks the NAR, CDC was disabled or the request is gone.
+func isRetryableUploadStreamError(err error) bool {
+	return !errors.Is(err, ErrMigrationInProgress) &&
+		!errors.Is(err, ErrCDCDisabled) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
+
+// replayReader reads from r, keeping the first limit bytes read so that they
+// can be read again with replay.
+type replayReader struct {
+	r     io.Reader
+	limit int
+
+	buf bytes.Buffer
+	// overflow is set once more than limit bytes were read.
+	overflow bool
+	// err is the first error of r other than io.EOF.
+	err error
+}
+
+func (rr *replayReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+
+	if !rr.overflow {
+		if rr.buf.Len()+n > rr.limit {
+			rr.overflow = true
+			rr.buf = bytes.Buffer{}
+		} else {
+			rr.buf.Write(p[:n])
+		}
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) && rr.err == nil {
+		rr.err = err
+	}
+
+	return n, err
+}
+
+// replay returns a reader of everything read from r and the rest of it. It
+// returns false when more than limit bytes were read or r failed.
+func (rr *replayReader) replay() (io.Reader, bool) {
+	if rr.overflow || rr.err != nil {
+		return nil, false
+	}
+
+	return io.MultiReader(bytes.NewReader(rr.buf.Bytes()), rr.r), true
+}
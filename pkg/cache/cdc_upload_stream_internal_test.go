@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+var errChunkStoreUnavailable = errors.New("the chunk store is unavailable")
+
+// failingChunkStore fails the first failures chunk writes.
+type failingChunkStore struct {
+	chunk.Store
+
+	failures atomic.Int32
+}
+
+func (s *failingChunkStore) PutChunk(ctx context.Context, hash string, data []byte) (bool, int64, error) {
+	if s.failures.Add(-1) >= 0 {
+		return false, 0, errChunkStoreUnavailable
+	}
+
+	return s.Store.PutChunk(ctx, hash, data)
+}
+
+func TestPutNar_CDCUploadStreaming(t *testing.T) {
+	t.Parallel()
+
+	newCache := func(t *testing.T, lookahead int, failures int32) *Cache {
+		t.Helper()
+
+		c, _, _, dir, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		cs, err := chunk.NewLocalStore(filepath.Join(dir, "chunks-store"))
+		require.NoError(t, err)
+
+		store := &failingChunkStore{Store: cs}
+		store.failures.Store(failures)
+
+		c.SetChunkStore(store)
+		require.NoError(t, c.SetCDCConfiguration(true, 1024, 4096, 8192))
+		c.SetCDCUploadStreaming(lookahead)
+
+		return c
+	}
+
+	readNar := func(t *testing.T, c *Cache, nu nar.URL) string {
+		t.Helper()
+
+		_, _, r, err := c.GetNar(newContext(), nu)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		body, err := io.ReadAll(r)
+		require.NoError(t, err)
+
+		return string(body)
+	}
+
+	t.Run("chunks the upload from the request", func(t *testing.T) {
+		t.Parallel()
+
+		c := newCache(t, 1<<20, 0)
+
+		content := testhelper.MustRandString(50000)
+		nu := nar.URL{Hash: strings.Repeat("4", 52), Compression: nar.CompressionTypeNone}
+
+		require.NoError(t, c.PutNar(newContext(), nu, io.NopCloser(strings.NewReader(content))))
+
+		hasChunks, err := c.HasNarInChunks(newContext(), nu)
+		require.NoError(t, err)
+		assert.True(t, hasChunks)
+
+		assert.Equal(t, content, readNar(t, c, nu))
+	})
+
+	t.Run("replays a failed upload through a temp file", func(t *testing.T) {
+		t.Parallel()
+
+		c := newCache(t, 1<<20, 1)
+
+		content := testhelper.MustRandString(50000)
+		nu := nar.URL{Hash: strings.Repeat("5", 52), Compression: nar.CompressionTypeNone}
+
+		require.NoError(t, c.PutNar(newContext(), nu, io.NopCloser(strings.NewReader(content))))
+
+		assert.Equal(t, content, readNar(t, c, nu))
+	})
+
+	t.Run("fails an upload read past the lookahead", func(t *testing.T) {
+		t.Parallel()
+
+		c := newCache(t, 16, 1)
+
+		content := testhelper.MustRandString(50000)
+		nu := nar.URL{Hash: strings.Repeat("6", 52), Compression: nar.CompressionTypeNone}
+
+		require.ErrorIs(t, c.PutNar(newContext(), nu, io.NopCloser(strings.NewReader(content))), errChunkStoreUnavailable)
+	})
+}
+
+func TestReplayReader(t *testing.T) {
+	t.Parallel()
+
+	t.Run("replays what it read and the rest", func(t *testing.T) {
+		t.Parallel()
+
+		rr := &replayReader{r: strings.NewReader("hello world"), limit: 8}
+
+		p := make([]byte, 5)
+		_, err := io.ReadFull(rr, p)
+		require.NoError(t, err)
+
+		body, ok := rr.replay()
+		require.True(t, ok)
+
+		got, err := io.ReadAll(body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(got))
+	})
+
+	t.Run("does not replay past the limit", func(t *testing.T) {
+		t.Parallel()
+
+		rr := &replayReader{r: strings.NewReader("hello world"), limit: 8}
+
+		_, err := io.ReadAll(rr)
+		require.NoError(t, err)
+
+		_, ok := rr.replay()
+		assert.False(t, ok)
+	})
+}
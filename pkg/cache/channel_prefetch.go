@@ -0,0 +1,216 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/kalbasit/ncps/pkg/cache/prewarm"
+	"github.com/kalbasit/ncps/pkg/narinfo"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+type channelPrefetchConfig struct {
+	// running is held for the duration of a run so overlapping schedules skip
+	// instead of stacking up.
+	running     sync.Mutex
+	channels    []*prewarm.Channel
+	maxNarSize  uint64
+	concurrency int
+
+	// releases maps each channel to the last release fully pre-fetched. It is
+	// only accessed while running is held.
+	releases map[string]string
+}
+
+// SetChannelPrefetch configures the channels whose releases are pre-fetched by
+// PrefetchChannels. Paths whose compressed NAR is larger than maxNarSize are
+// skipped; zero disables the limit. A narinfo is only ever cached along with
+// its NAR, so the limit bounds which paths are pre-fetched at all.
+func (c *Cache) SetChannelPrefetch(channels []*prewarm.Channel, maxNarSize uint64, concurrency int) {
+	if concurrency <= 0 {
+		concurrency = defaultPrewarmConcurrency
+	}
+
+	c.channelPrefetch = &channelPrefetchConfig{
+		channels:    channels,
+		maxNarSize:  maxNarSize,
+		concurrency: concurrency,
+		releases:    make(map[string]string, len(channels)),
+	}
+}
+
+// PrefetchChannels pre-fetches the store paths of every configured channel
+// whose release changed since its last complete pre-fetch. A release is only
+// marked as done when none of its paths failed, so transient failures are
+// retried on the next run.
+func (c *Cache) PrefetchChannels(ctx context.Context) error {
+	cp := c.channelPrefetch
+	if cp == nil || len(cp.channels) == 0 {
+		return nil
+	}
+
+	if c.IsOffline() {
+		zerolog.Ctx(ctx).Info().Msg("the cache is offline; skipping the channel pre-fetch")
+
+		return nil
+	}
+
+	if !cp.running.TryLock() {
+		zerolog.Ctx(ctx).Info().Msg("a channel pre-fetch is already running; skipping")
+
+		return nil
+	}
+	defer cp.running.Unlock()
+
+	var errs []error
+
+	for _, ch := range cp.channels {
+		log := zerolog.Ctx(ctx).With().Str("channel", ch.String()).Logger()
+
+		release, err := ch.Release(ctx)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if cp.releases[ch.String()] == release {
+			log.Debug().Str("release", release).Msg("channel release already pre-fetched")
+
+			continue
+		}
+
+		paths, err := ch.StorePaths(ctx, release)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		startedAt := time.Now()
+
+		result := c.prefetchChannelPaths(log.WithContext(ctx), cp, paths)
+
+		log.Info().
+			Str("release", release).
+			Int("paths", result.Roots).
+			Int("cached", result.Cached).
+			Int("fetched", result.Fetched).
+			Int("skipped", result.Skipped).
+			Int("missing", result.Missing).
+			Int("failed", result.Failed).
+			Dur("elapsed", time.Since(startedAt)).
+			Msg("channel release pre-fetched")
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if result.Failed == 0 {
+			cp.releases[ch.String()] = release
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// AddChannelPrefetchCronJob adds a periodic job pre-fetching the releases of
+// the channels configured with SetChannelPrefetch.
+func (c *Cache) AddChannelPrefetchCronJob(ctx context.Context, schedule cron.Schedule) {
+	zerolog.Ctx(ctx).
+		Info().
+		Time("next-run", schedule.Next(time.Now())).
+		Msg("adding a cronjob for channel pre-fetch")
+
+	c.scheduleCronJob(ctx, CronJobChannelPrefetch, schedule, func(ctx context.Context) func() {
+		return func() {
+			if err := c.PrefetchChannels(ctx); err != nil {
+				zerolog.Ctx(ctx).
+					Error().
+					Err(err).
+					Msg("error pre-fetching the channels")
+
+				recordCronJobError(ctx, err)
+			}
+		}
+	})
+}
+
+func (c *Cache) prefetchChannelPaths(
+	ctx context.Context,
+	cp *channelPrefetchConfig,
+	paths []string,
+) PrewarmResult {
+	var (
+		mu     sync.Mutex
+		result = PrewarmResult{Roots: len(paths)}
+	)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(cp.concurrency)
+
+	for _, p := range paths {
+		hash, err := narinfo.HashFromStorePath(p)
+		if err != nil {
+			continue
+		}
+
+		g.Go(func() error {
+			outcome := c.prefetchChannelPath(gctx, cp, hash)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch outcome {
+			case prewarmOutcomeCached:
+				result.Cached++
+			case prewarmOutcomeFetched:
+				result.Fetched++
+			case prewarmOutcomeSkipped:
+				result.Skipped++
+			case prewarmOutcomeMissing:
+				result.Missing++
+			case prewarmOutcomeFailed:
+				result.Failed++
+			}
+
+			return nil
+		})
+	}
+
+	_ = g.Wait() // prefetchChannelPath never returns an error to the group.
+
+	return result
+}
+
+func (c *Cache) prefetchChannelPath(ctx context.Context, cp *channelPrefetchConfig, hash string) prewarmOutcome {
+	if _, err := c.getNarInfoFromDatabase(ctx, hash); err == nil {
+		return prewarmOutcomeCached
+	}
+
+	if cp.maxNarSize > 0 {
+		// Look at the upstream narinfo first so a large NAR is never pulled.
+		_, ni, err := c.getNarInfoFromUpstream(ctx, hash)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return prewarmOutcomeMissing
+			}
+
+			return prewarmOutcomeFailed
+		}
+
+		if ni.FileSize > cp.maxNarSize {
+			return prewarmOutcomeSkipped
+		}
+	}
+
+	_, outcome := c.prewarmOne(ctx, hash)
+
+	return outcome
+}
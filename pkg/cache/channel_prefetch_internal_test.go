@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+
+	"github.com/kalbasit/ncps/pkg/cache/prewarm"
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/storage"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestPrefetchChannels(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	ts := testdata.NewTestServer(t, 40)
+	t.Cleanup(ts.Close)
+
+	uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), nil)
+	require.NoError(t, err)
+
+	c.AddUpstreamCaches(newContext(), uc)
+
+	<-c.GetHealthChecker().Trigger()
+
+	var listing bytes.Buffer
+
+	xw, err := xz.NewWriter(&listing)
+	require.NoError(t, err)
+
+	_, err = xw.Write([]byte(
+		"/nix/store/" + testdata.Nar1.NarInfoHash + "-hello-2.12.1\n" +
+			"/nix/store/" + testdata.Nar2.NarInfoHash + "-hello-2.12.1\n" +
+			"/nix/store/qdcbgcj27x2kpxj2sf9yfvva7qsgg64g-glibc-2.38-77\n"))
+	require.NoError(t, err)
+	require.NoError(t, xw.Close())
+
+	var listingFetches atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nixos-unstable", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/releases/nixos-25.11pre1", http.StatusFound)
+	})
+	mux.HandleFunc("/releases/nixos-25.11pre1", func(http.ResponseWriter, *http.Request) {})
+	mux.HandleFunc("/releases/nixos-25.11pre1/store-paths.xz", func(w http.ResponseWriter, _ *http.Request) {
+		listingFetches.Add(1)
+
+		_, _ = w.Write(listing.Bytes())
+	})
+
+	channelServer := httptest.NewServer(mux)
+	t.Cleanup(channelServer.Close)
+
+	// Nar1 (50160 bytes) fits, Nar2 (50308 bytes) does not.
+	c.SetChannelPrefetch(
+		[]*prewarm.Channel{prewarm.NewChannel(channelServer.URL+"/nixos-unstable", channelServer.Client())},
+		50200,
+		2,
+	)
+
+	require.NoError(t, c.PrefetchChannels(newContext()))
+
+	_, err = c.getNarInfoFromDatabase(newContext(), testdata.Nar1.NarInfoHash)
+	require.NoError(t, err, "the small NAR must be pre-fetched")
+
+	_, err = c.getNarInfoFromDatabase(newContext(), testdata.Nar2.NarInfoHash)
+	require.ErrorIs(t, err, storage.ErrNotFound, "the large NAR must be skipped")
+
+	assert.Equal(t,
+		channelServer.URL+"/releases/nixos-25.11pre1",
+		c.channelPrefetch.releases[channelServer.URL+"/nixos-unstable"])
+
+	// An unchanged release is not listed again.
+	require.NoError(t, c.PrefetchChannels(newContext()))
+	assert.Equal(t, int32(1), listingFetches.Load())
+}
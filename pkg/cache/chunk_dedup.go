@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	entchunk "github.com/kalbasit/ncps/ent/chunk"
+
+	"github.com/kalbasit/ncps/ent"
+)
+
+const (
+	chunkIngestResultNew       = "new"
+	chunkIngestResultDuplicate = "duplicate"
+)
+
+// chunkDedup tracks the chunks produced while chunking one NAR. A chunk whose
+// hash is already recorded, by another NAR or earlier in this one, is neither
+// compressed nor written again. It is safe for concurrent use.
+type chunkDedup struct {
+	chunks *ent.ChunkClient
+
+	mu sync.Mutex
+	// seen maps the hashes of the chunks of this NAR to their compressed size.
+	seen map[string]uint32
+
+	newChunks       int64
+	newBytes        int64
+	duplicateChunks int64
+	duplicateBytes  int64
+}
+
+func newChunkDedup(chunks *ent.ChunkClient) *chunkDedup {
+	return &chunkDedup{
+		chunks: chunks,
+		seen:   make(map[string]uint32),
+	}
+}
+
+// lookup returns the compressed size of the chunk and true if it is already
+// stored, in which case it is counted as a duplicate.
+func (d *chunkDedup) lookup(ctx context.Context, hash string, size uint32) (uint32, bool, error) {
+	d.mu.Lock()
+	compressedSize, ok := d.seen[hash]
+	d.mu.Unlock()
+
+	if !ok {
+		ch, err := d.chunks.Query().
+			Where(entchunk.HashEQ(hash)).
+			Only(ctx)
+
+		switch {
+		case ent.IsNotFound(err):
+			return 0, false, nil
+		case err != nil:
+			return 0, false, fmt.Errorf("error looking up chunk %s: %w", hash, err)
+		}
+
+		compressedSize = ch.CompressedSize
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.seen[hash] = compressedSize
+	d.duplicateChunks++
+	d.duplicateBytes += int64(size)
+
+	return compressedSize, true, nil
+}
+
+// stored records a chunk written to the chunk store. A chunk already stored
+// concurrently by another worker is counted as a duplicate.
+func (d *chunkDedup) stored(hash string, size, compressedSize uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.seen[hash]; ok {
+		d.duplicateChunks++
+		d.duplicateBytes += int64(size)
+
+		return
+	}
+
+	d.seen[hash] = compressedSize
+	d.newChunks++
+	d.newBytes += int64(size)
+}
+
+// record adds the chunks of the NAR to the ingest metrics.
+func (d *chunkDedup) record(ctx context.Context) {
+	if chunkIngestTotal == nil || chunkIngestBytesTotal == nil {
+		return
+	}
+
+	for result, counts := range map[string][2]int64{
+		chunkIngestResultNew:       {d.newChunks, d.newBytes},
+		chunkIngestResultDuplicate: {d.duplicateChunks, d.duplicateBytes},
+	} {
+		attrs := metric.WithAttributes(attribute.String("result", result))
+
+		chunkIngestTotal.Add(ctx, counts[0], attrs)
+		chunkIngestBytesTotal.Add(ctx, counts[1], attrs)
+	}
+}
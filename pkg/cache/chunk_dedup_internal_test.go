@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+// countingChunkStore counts the chunks written to the wrapped store.
+type countingChunkStore struct {
+	chunk.Store
+
+	puts atomic.Int64
+}
+
+func (s *countingChunkStore) PutChunk(ctx context.Context, hash string, data []byte) (bool, int64, error) {
+	s.puts.Add(1)
+
+	return s.Store.PutChunk(ctx, hash, data)
+}
+
+func TestChunkDedupSkipsStoredChunks(t *testing.T) {
+	t.Parallel()
+
+	ctx := newContext()
+
+	c, dbClient, _, dir, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	local, err := chunk.NewLocalStore(filepath.Join(dir, "chunks-store"))
+	require.NoError(t, err)
+
+	cs := &countingChunkStore{Store: local}
+
+	c.SetChunkStore(cs)
+	require.NoError(t, c.SetCDCConfiguration(true, 1024, 4096, 8192))
+
+	content := testhelper.MustRandString(50000)
+
+	put := func(hash string) *nar.URL {
+		t.Helper()
+
+		narURL := nar.URL{Hash: hash, Compression: nar.CompressionTypeNone}
+		require.NoError(t, c.PutNar(ctx, narURL, io.NopCloser(strings.NewReader(content))))
+
+		return &narURL
+	}
+
+	first := put(strings.Repeat("1", 52))
+
+	nf, err := fetchNarFile(ctx, dbClient, first.Hash, nar.CompressionTypeNone.String(), "")
+	require.NoError(t, err)
+	require.Greater(t, nf.TotalChunks, int64(1))
+
+	written := cs.puts.Load()
+	assert.Positive(t, written)
+
+	// A second NAR with the same content writes no chunk at all.
+	second := put(strings.Repeat("2", 52))
+
+	assert.Equal(t, written, cs.puts.Load())
+
+	// The reference counts maintained while linking match the links.
+	recounted, err := dbClient.RecountChunkRefs(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, recounted)
+
+	for _, narURL := range []*nar.URL{first, second} {
+		_, _, rc, err := c.GetNar(ctx, *narURL)
+		require.NoError(t, err)
+
+		got, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+
+		assert.Equal(t, content, string(got))
+	}
+}
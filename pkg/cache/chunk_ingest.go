@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kalbasit/ncps/pkg/chunker"
+)
+
+// SetCDCIngestWorkers sets the number of chunks of a NAR stored, that is
+// compressed and written to the chunk store, at once while chunking it. The
+// chunks are still linked to the NAR in order. A value below 1 stores them one
+// at a time.
+func (c *Cache) SetCDCIngestWorkers(workers int) {
+	c.cdcIngestWorkers = workers
+}
+
+// ingestChunks runs store on the chunks received from in on workers goroutines
+// and sends them, once stored and in the order received, on the returned
+// channel. The channel is closed once in is closed or on the first error of
+// store, sent beforehand on the returned error channel. The chunks received
+// after an error are freed without being stored.
+func ingestChunks(
+	ctx context.Context,
+	in <-chan *chunker.Chunk,
+	workers int,
+	store func(context.Context, *chunker.Chunk) error,
+) (<-chan *chunker.Chunk, <-chan error) {
+	workers = max(workers, 1)
+
+	type job struct {
+		chunk *chunker.Chunk
+		done  chan error
+	}
+
+	out := make(chan *chunker.Chunk)
+	errChan := make(chan error, 1)
+
+	jobs := make(chan job)
+	// pending holds the jobs in the order received; its capacity bounds the
+	// chunks held in memory ahead of the one being waited for.
+	pending := make(chan job, 2*workers)
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Go(func() {
+			for j := range jobs {
+				j.done <- store(ctx, j.chunk)
+			}
+		})
+	}
+
+	// Dispatch the chunks to the workers, recording their order.
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+
+		for ch := range in {
+			j := job{chunk: ch, done: make(chan error, 1)}
+
+			select {
+			case pending <- j:
+			case <-ctx.Done():
+				ch.Free()
+
+				continue
+			}
+
+			jobs <- j
+		}
+	}()
+
+	// Collect the stored chunks in order.
+	go func() {
+		defer cancel()
+		defer close(out)
+
+		var err error
+
+		for j := range pending {
+			jobErr := <-j.done
+
+			if err == nil && jobErr != nil {
+				err = jobErr
+				errChan <- err
+
+				cancel()
+			}
+
+			if err != nil {
+				j.chunk.Free()
+
+				continue
+			}
+
+			select {
+			case out <- j.chunk:
+			case <-ctx.Done():
+				err = ctx.Err()
+				errChan <- err
+
+				j.chunk.Free()
+			}
+		}
+
+		wg.Wait()
+	}()
+
+	return out, errChan
+}
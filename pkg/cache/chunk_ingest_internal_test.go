@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/chunker"
+)
+
+var errChunkIngest = errors.New("chunk ingest failed")
+
+func produceChunks(n int) <-chan *chunker.Chunk {
+	in := make(chan *chunker.Chunk)
+
+	go func() {
+		defer close(in)
+
+		for i := range n {
+			in <- &chunker.Chunk{Hash: strconv.Itoa(i), Offset: int64(i)}
+		}
+	}()
+
+	return in
+}
+
+func TestIngestChunks(t *testing.T) {
+	t.Parallel()
+
+	t.Run("keeps the order of the chunks", func(t *testing.T) {
+		t.Parallel()
+
+		out, errChan := ingestChunks(context.Background(), produceChunks(100), 8,
+			func(_ context.Context, ch *chunker.Chunk) error {
+				time.Sleep(time.Duration(rand.IntN(1000)) * time.Microsecond) //nolint:gosec // test jitter
+
+				ch.CompressedSize = uint32(ch.Offset) //nolint:gosec // G115: small test offsets
+
+				return nil
+			})
+
+		var offset int64
+
+		for ch := range out {
+			assert.Equal(t, offset, ch.Offset)
+			assert.Equal(t, uint32(offset), ch.CompressedSize) //nolint:gosec // G115: small test offsets
+
+			offset++
+		}
+
+		assert.EqualValues(t, 100, offset)
+
+		select {
+		case err := <-errChan:
+			require.NoError(t, err)
+		default:
+		}
+	})
+
+	t.Run("stops on the first error", func(t *testing.T) {
+		t.Parallel()
+
+		out, errChan := ingestChunks(context.Background(), produceChunks(100), 4,
+			func(_ context.Context, ch *chunker.Chunk) error {
+				if ch.Offset == 10 {
+					return errChunkIngest
+				}
+
+				return nil
+			})
+
+		var received int64
+
+		for ch := range out {
+			assert.Equal(t, received, ch.Offset)
+
+			received++
+		}
+
+		assert.EqualValues(t, 10, received)
+		require.ErrorIs(t, <-errChan, errChunkIngest)
+	})
+}
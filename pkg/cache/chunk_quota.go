@@ -0,0 +1,299 @@
+package cache
+
+import (
+	"context"
+	"slices"
+
+	"github.com/rs/zerolog"
+
+	entchunk "github.com/kalbasit/ncps/ent/chunk"
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+	entnarfilechunk "github.com/kalbasit/ncps/ent/narfilechunk"
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+	entnarinfonarfile "github.com/kalbasit/ncps/ent/narinfonarfile"
+
+	"github.com/kalbasit/ncps/ent"
+)
+
+const (
+	// chunkLRUBatchSize is the number of least used chunked narinfos fetched
+	// at once by the chunk store eviction.
+	chunkLRUBatchSize = 100
+
+	// chunkLRUQueryBatchSize bounds the chunk IDs bound to a single query.
+	chunkLRUQueryBatchSize = 1000
+)
+
+// SetChunkStoreMaxSize sets the maximum size of the chunk store, the bytes
+// taken by the unique chunks, enforced by the LRU independently of the
+// maximum size of the NARs (see SetMaxSize). Zero disables it.
+func (c *Cache) SetChunkStoreMaxSize(maxSize uint64) { c.chunkStoreMaxSize = maxSize }
+
+// evictLeastUsedChunkedNarInfos evicts the least recently used narinfos backed
+// by chunked NARs, and the nar files and chunks they leave orphaned, until the
+// chunk store fits within its maximum size. The chunks of a NAR may be shared
+// with the NARs kept: only the chunks no NAR kept links count as freed. Each
+// batch of chunkLRUBatchSize narinfos is evicted in its own transaction, see
+// evictLRUBatch.
+func (c *Cache) evictLeastUsedChunkedNarInfos(
+	ctx context.Context,
+	log zerolog.Logger,
+	pinnedHashes map[string]struct{},
+) error {
+	if c.chunkStoreMaxSize == 0 || !c.isCDCEnabled() {
+		return nil
+	}
+
+	log = log.With().Uint64("chunk_store_max_size", c.chunkStoreMaxSize).Logger()
+
+	size, err := totalChunkStoreSize(ctx, c.dbClient.Ent().Chunk)
+	if err != nil {
+		log.Error().Err(err).Msg("error fetching the chunk store size")
+
+		return err
+	}
+
+	log = log.With().Int64("chunk_store_size", size).Logger()
+
+	//nolint:gosec // G115: sums of chunk sizes are non-negative
+	if uint64(size) <= c.chunkStoreMaxSize {
+		log.Info().Msg("chunk store size is less than its max-size, not removing any chunks")
+
+		return nil
+	}
+
+	//nolint:gosec // G115: checked above that size exceeds the max size
+	cleanupSize := uint64(size) - c.chunkStoreMaxSize
+
+	log.Info().Uint64("cleanup_size", cleanupSize).Msg("going to remove chunked nars")
+
+	var (
+		evicted int
+		freed   uint64
+		// skipped counts the pinned and excluded narinfos, which stay at the
+		// head of the LRU order.
+		skipped int
+	)
+
+	for freed < cleanupSize {
+		var (
+			batchSkipped int
+			exhausted    bool
+		)
+
+		n, batchFreed, err := c.evictLRUBatch(ctx, log, "runLRU.evictChunks", func(tx *ent.Tx) ([]string, uint64, error) {
+			var (
+				hashes []string
+				freedN uint64
+				// unlinked counts, by chunk ID, the links dropped by the nar
+				// files orphaned so far in this batch.
+				unlinked = make(map[int]int64)
+			)
+
+			batchSkipped = 0
+
+			candidates, err := tx.NarInfo.Query().
+				Where(entnarinfo.HasNarInfoNarFilesWith(
+					entnarinfonarfile.HasNarFileWith(entnarfile.HasChunkLinks()),
+				)).
+				Order(
+					ent.Asc(entnarinfo.FieldLastAccessedAt),
+					ent.Asc(entnarinfo.FieldID),
+				).
+				WithNarInfoNarFiles().
+				Offset(skipped).
+				Limit(chunkLRUBatchSize).
+				All(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("error getting the least used chunked narinfos")
+
+				return nil, 0, err
+			}
+
+			exhausted = len(candidates) == 0
+
+			for _, info := range candidates {
+				if freed+freedN >= cleanupSize {
+					break
+				}
+
+				if _, isPinned := pinnedHashes[info.Hash]; isPinned || c.isEvictionExcluded(info.StorePath) {
+					log.Debug().Str("hash", info.Hash).Msg("skipping pinned or excluded narinfo during chunk eviction")
+
+					batchSkipped++
+
+					continue
+				}
+
+				if err := tx.NarInfo.DeleteOneID(info.ID).Exec(ctx); err != nil {
+					log.Error().
+						Err(err).
+						Str("hash", info.Hash).
+						Msg("error deleting narinfo record")
+
+					return nil, 0, err
+				}
+
+				hashes = append(hashes, info.Hash)
+
+				narFileIDs := make([]int, 0, len(info.Edges.NarInfoNarFiles))
+				for _, link := range info.Edges.NarInfoNarFiles {
+					narFileIDs = append(narFileIDs, link.NarFileID)
+				}
+
+				n, err := chunkStoreBytesFreed(ctx, tx, narFileIDs, unlinked)
+				if err != nil {
+					log.Error().
+						Err(err).
+						Str("hash", info.Hash).
+						Msg("error computing the chunk bytes freed by a narinfo")
+
+					return nil, 0, err
+				}
+
+				freedN += n
+			}
+
+			return hashes, freedN, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if exhausted {
+			break
+		}
+
+		evicted += n
+		freed += batchFreed
+		skipped += batchSkipped
+	}
+
+	if freed < cleanupSize {
+		log.Warn().
+			Uint64("collected", freed).
+			Uint64("requested", cleanupSize).
+			Msg("could not collect enough chunks for cleanup, all may be pinned, excluded or shared")
+	}
+
+	log.Info().
+		Int("count", evicted).
+		Uint64("freed", freed).
+		Msg("chunked narinfos deleted")
+
+	return nil
+}
+
+// lruStoreSize returns the size bounded by the maximum size of the cache: the
+// sum of the NAR sizes or, with CDC, the sum of the sizes of the NARs stored
+// whole and of the chunk store, where a chunk shared by several NARs counts
+// once.
+func (c *Cache) lruStoreSize(ctx context.Context, tx *ent.Tx) (int64, error) {
+	if !c.isCDCEnabled() {
+		return totalNarFileSize(ctx, tx.NarFile)
+	}
+
+	wholeSize, err := totalWholeNarFileSize(ctx, tx.NarFile)
+	if err != nil {
+		return 0, err
+	}
+
+	chunkSize, err := totalChunkStoreSize(ctx, tx.Chunk)
+	if err != nil {
+		return 0, err
+	}
+
+	return wholeSize + chunkSize, nil
+}
+
+// lruBytesFreed returns the bytes freed by the eviction of info, already
+// deleted in tx: the size of its NAR or, for a chunked NAR, the size of the
+// chunks no other NAR links. unlinked is updated as by chunkStoreBytesFreed.
+func lruBytesFreed(
+	ctx context.Context,
+	tx *ent.Tx,
+	info *ent.NarInfo,
+	cdcEnabled bool,
+	unlinked map[int]int64,
+) (uint64, error) {
+	for _, link := range info.Edges.NarInfoNarFiles {
+		nf := link.Edges.NarFile
+		if nf == nil {
+			continue
+		}
+
+		if !cdcEnabled || nf.TotalChunks == 0 {
+			return nf.FileSize, nil
+		}
+
+		return chunkStoreBytesFreed(ctx, tx, []int{nf.ID}, unlinked)
+	}
+
+	return 0, nil
+}
+
+// chunkStoreBytesFreed returns the bytes of the chunks freed once the nar
+// files narFileIDs no narinfo links anymore are unlinked, given the links
+// already dropped by the nar files orphaned before, counted by unlinked, which
+// it updates.
+func chunkStoreBytesFreed(ctx context.Context, tx *ent.Tx, narFileIDs []int, unlinked map[int]int64) (uint64, error) {
+	orphaned, err := tx.NarFile.Query().
+		Where(
+			entnarfile.IDIn(narFileIDs...),
+			entnarfile.Not(entnarfile.HasNarInfoNarFiles()),
+		).
+		IDs(ctx)
+	if err != nil || len(orphaned) == 0 {
+		return 0, err
+	}
+
+	var rows []struct {
+		ChunkID int   `sql:"chunk_id"`
+		Count   int64 `sql:"count"`
+	}
+
+	if err := tx.NarFileChunk.Query().
+		Where(entnarfilechunk.NarFileIDIn(orphaned...)).
+		GroupBy(entnarfilechunk.FieldChunkID).
+		Aggregate(ent.Count()).
+		Scan(ctx, &rows); err != nil {
+		return 0, err
+	}
+
+	links := make(map[int]int64, len(rows))
+	chunkIDs := make([]int, 0, len(rows))
+
+	for _, row := range rows {
+		links[row.ChunkID] = row.Count
+		chunkIDs = append(chunkIDs, row.ChunkID)
+	}
+
+	var freed uint64
+
+	for batch := range slices.Chunk(chunkIDs, chunkLRUQueryBatchSize) {
+		chunks, err := tx.Chunk.Query().Where(entchunk.IDIn(batch...)).All(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, ch := range chunks {
+			before := unlinked[ch.ID]
+			unlinked[ch.ID] = before + links[ch.ID]
+
+			if before < ch.RefCount && unlinked[ch.ID] >= ch.RefCount {
+				freed += uint64(chunkStoreSize(ch))
+			}
+		}
+	}
+
+	return freed, nil
+}
+
+// chunkStoreSize returns the bytes ch takes in the chunk store.
+func chunkStoreSize(ch *ent.Chunk) uint32 {
+	if ch.CompressedSize > 0 {
+		return ch.CompressedSize
+	}
+
+	return ch.Size
+}
@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+)
+
+// setupSharedChunkedNars enables CDC on c and stores four chunked NARs of
+// 1000 bytes, least recently used first, each made of a chunk shared by all of
+// them and a chunk of its own of 100 bytes: the chunk store takes 500 bytes.
+func setupSharedChunkedNars(t *testing.T, c *Cache, dir string) {
+	t.Helper()
+
+	ctx := newContext()
+	db := c.dbClient.Ent()
+
+	chunkDir := filepath.Join(dir, "chunks")
+	require.NoError(t, os.MkdirAll(chunkDir, 0o700))
+
+	cs, err := chunk.NewLocalStore(chunkDir)
+	require.NoError(t, err)
+
+	c.SetChunkStore(cs)
+	require.NoError(t, c.SetCDCConfiguration(true, 1024, 4096, 8192))
+
+	shared := db.Chunk.Create().SetHash("chunk-shared").SetSize(200).SetCompressedSize(100).SaveX(ctx)
+
+	baseTime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, name := range []string{"a", "b", "c", "d"} {
+		nf := db.NarFile.Create().
+			SetHash("nar-file-" + name).
+			SetCompression("none").
+			SetFileSize(1000).
+			SetTotalChunks(2).
+			SaveX(ctx)
+
+		own := db.Chunk.Create().SetHash("chunk-" + name).SetSize(100).SaveX(ctx)
+
+		for idx, ch := range []int{shared.ID, own.ID} {
+			db.NarFileChunk.Create().SetNarFileID(nf.ID).SetChunkID(ch).SetChunkIndex(idx).SaveX(ctx)
+			db.Chunk.UpdateOneID(ch).AddRefCount(1).ExecX(ctx)
+		}
+
+		ni := db.NarInfo.Create().
+			SetHash("nar-info-" + name).
+			SetLastAccessedAt(baseTime.Add(time.Duration(i) * time.Hour)).
+			SaveX(ctx)
+
+		db.NarInfoNarFile.Create().SetNarinfoID(ni.ID).SetNarFileID(nf.ID).SaveX(ctx)
+	}
+
+	size, err := totalChunkStoreSize(ctx, db.Chunk)
+	require.NoError(t, err)
+	require.Equal(t, int64(500), size)
+}
+
+func TestRunLRUBoundsTheChunkStore(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, dir, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	setupSharedChunkedNars(t, c, dir)
+
+	ctx := newContext()
+	db := c.dbClient.Ent()
+
+	// Evicting the oldest NAR frees its own chunk only, so the two oldest go.
+	c.SetChunkStoreMaxSize(350)
+	c.runLRU(ctx)()
+
+	hashes, err := db.NarInfo.Query().Select(entnarinfo.FieldHash).Strings(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"nar-info-c", "nar-info-d"}, hashes)
+
+	size, err := totalChunkStoreSize(ctx, db.Chunk)
+	require.NoError(t, err)
+	assert.Equal(t, int64(300), size)
+
+	// Without a max-size for the NARs, the NARs within the chunk quota are kept.
+	c.runLRU(ctx)()
+
+	hashes, err = db.NarInfo.Query().Select(entnarinfo.FieldHash).Strings(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"nar-info-c", "nar-info-d"}, hashes)
+}
+
+func TestRunLRUCountsOnlyTheOrphanedChunksAsFreed(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, dir, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	setupSharedChunkedNars(t, c, dir)
+
+	ctx := newContext()
+	db := c.dbClient.Ent()
+
+	// The cache takes the 500 bytes of its chunks, not the 4000 bytes of its
+	// NARs. Evicting the oldest NAR frees 100 bytes, not its 1000 bytes, so
+	// the two oldest go to fit within 350 bytes.
+	c.SetMaxSize(350)
+	c.runLRU(ctx)()
+
+	hashes, err := db.NarInfo.Query().Select(entnarinfo.FieldHash).Strings(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"nar-info-c", "nar-info-d"}, hashes)
+
+	chunks, err := db.Chunk.Query().Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, chunks, "the shared chunk is kept")
+}
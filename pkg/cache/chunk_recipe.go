@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+	entnarfilechunk "github.com/kalbasit/ncps/ent/narfilechunk"
+
+	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+)
+
+// ChunkRecipe lists the chunks a chunked NAR is assembled from, so that a peer
+// cache or a client holding some of them fetches only the chunks it misses.
+type ChunkRecipe struct {
+	// NarSize is the size of the uncompressed NAR, the sum of the sizes of its
+	// chunks.
+	NarSize uint64
+
+	// Chunks are the chunks of the NAR, in order. A chunk repeated in the NAR
+	// is listed every time.
+	Chunks []RecipeChunk
+}
+
+// RecipeChunk is a chunk of a ChunkRecipe.
+type RecipeChunk struct {
+	// Hash is the BLAKE3 hash of the content of the chunk, in hex.
+	Hash string
+
+	// Size is the size of the content of the chunk.
+	Size uint32
+}
+
+// GetChunkRecipe returns the recipe of the chunked NAR of narURL. It returns
+// ErrNarNotChunked if no NAR of its hash is stored as chunks, and an error
+// wrapping storage.ErrNotFound if the chunk links of the NAR are incomplete, in
+// which case the NAR is repaired in the background if SetChunkRepair enabled
+// it.
+func (c *Cache) GetChunkRecipe(ctx context.Context, narURL nar.URL) (ChunkRecipe, error) {
+	if !c.isChunkStoreAvailable() {
+		return ChunkRecipe{}, ErrNarNotChunked
+	}
+
+	nf, err := c.dbClient.Ent().NarFile.Query().
+		Where(
+			entnarfile.HashEQ(narURL.Hash),
+			entnarfile.CompressionEQ(nar.CompressionTypeNone.String()),
+			entnarfile.QueryEQ(narURL.CanonicalQuery()),
+			entnarfile.TotalChunksGT(0),
+		).
+		Only(ctx)
+	if err != nil {
+		if ent.IsNotFound(err) {
+			return ChunkRecipe{}, ErrNarNotChunked
+		}
+
+		return ChunkRecipe{}, fmt.Errorf("error loading the nar_file: %w", err)
+	}
+
+	links, err := c.dbClient.Ent().NarFileChunk.Query().
+		Where(entnarfilechunk.NarFileIDEQ(nf.ID)).
+		Order(entnarfilechunk.ByChunkIndex()).
+		WithChunk().
+		All(ctx)
+	if err != nil {
+		return ChunkRecipe{}, fmt.Errorf("error loading the chunks of the nar: %w", err)
+	}
+
+	recipe := ChunkRecipe{Chunks: make([]RecipeChunk, 0, len(links))}
+
+	complete := int64(len(links)) == nf.TotalChunks
+
+	for i, link := range links {
+		if link.ChunkIndex != i || link.Edges.Chunk == nil {
+			complete = false
+
+			break
+		}
+
+		recipe.NarSize += uint64(link.Edges.Chunk.Size)
+		recipe.Chunks = append(recipe.Chunks, RecipeChunk{
+			Hash: link.Edges.Chunk.Hash,
+			Size: link.Edges.Chunk.Size,
+		})
+	}
+
+	if !complete {
+		c.maybeRepairChunkedNar(ctx, int64(nf.ID))
+
+		return ChunkRecipe{}, fmt.Errorf("nar %s has %d of %d chunk links: %w",
+			narURL.Hash, len(links), nf.TotalChunks, storage.ErrNotFound)
+	}
+
+	return recipe, nil
+}
+
+// GetChunk returns the content of the chunk of hash, listed by a
+// ChunkRecipe. It returns an error wrapping storage.ErrNotFound if the chunk is
+// not stored.
+func (c *Cache) GetChunk(ctx context.Context, hash string) (io.ReadCloser, error) {
+	if !c.isChunkStoreAvailable() {
+		return nil, fmt.Errorf("chunk store not initialized: %w", storage.ErrNotFound)
+	}
+
+	rc, err := c.fetchChunk(ctx, hash, false)
+	if err != nil {
+		if errors.Is(err, chunk.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %w", storage.ErrNotFound, err)
+		}
+
+		return nil, fmt.Errorf("error reading the chunk %s: %w", hash, err)
+	}
+
+	return rc, nil
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestGetChunkRecipe(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	ctx := newContext()
+	entry := testdata.Nar7
+
+	narURL := nar.URL{Hash: entry.NarHash, Compression: entry.NarCompression}
+	require.NoError(t, c.PutNar(ctx, narURL, io.NopCloser(strings.NewReader(entry.NarText))))
+	require.NoError(t, c.PutNarInfo(ctx, entry.NarInfoHash, io.NopCloser(strings.NewReader(entry.NarInfoText))))
+
+	cs, err := chunk.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+
+	c.SetChunkStore(cs)
+	require.NoError(t, c.SetCDCConfiguration(true, 1024, 4096, 8192))
+
+	_, err = c.GetChunkRecipe(ctx, narURL)
+	require.ErrorIs(t, err, ErrNarNotChunked, "the NAR is stored whole")
+
+	require.NoError(t, c.MigrateNarToChunks(ctx, &narURL))
+
+	recipe, err := c.GetChunkRecipe(ctx, narURL)
+	require.NoError(t, err)
+	require.Greater(t, len(recipe.Chunks), 1)
+	assert.Equal(t, uint64(len(entry.NarText)), recipe.NarSize)
+
+	var assembled bytes.Buffer
+
+	for _, ch := range recipe.Chunks {
+		rc, err := c.GetChunk(ctx, ch.Hash)
+		require.NoError(t, err)
+
+		n, err := io.Copy(&assembled, rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+
+		assert.Equal(t, int64(ch.Size), n)
+	}
+
+	assert.Equal(t, entry.NarText, assembled.String())
+
+	_, err = c.GetChunk(ctx, strings.Repeat("0", 64))
+	require.ErrorIs(t, err, storage.ErrNotFound)
+}
@@ -0,0 +1,500 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/rs/zerolog"
+	"github.com/zeebo/blake3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+	entnarfilechunk "github.com/kalbasit/ncps/ent/narfilechunk"
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+	entnarinfonarfile "github.com/kalbasit/ncps/ent/narinfonarfile"
+
+	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/pkg/analytics"
+	"github.com/kalbasit/ncps/pkg/chunker"
+	"github.com/kalbasit/ncps/pkg/database"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+)
+
+// Results recorded by ncps_chunk_repair_total.
+const (
+	chunkRepairResultRepaired = "repaired"
+	chunkRepairResultIntact   = "intact"
+	chunkRepairResultError    = "error"
+)
+
+var (
+	// ErrNarNotChunked is returned when repairing the chunks of a NAR that is
+	// not stored as chunks.
+	ErrNarNotChunked = errors.New("nar is not stored as chunks")
+
+	// ErrUpstreamNarChanged is returned when the upstream now serves a
+	// different NAR than the one whose chunks are being repaired.
+	ErrUpstreamNarChanged = errors.New("the upstream serves a different nar")
+)
+
+// ChunkRepairResult describes a repair of the chunks of a NAR.
+type ChunkRepairResult struct {
+	// Repaired reports whether the NAR was re-fetched and its chunks replaced.
+	// It is false when the NAR needed no repair.
+	Repaired bool
+
+	// TotalChunks is the number of chunks of the NAR after the repair.
+	TotalChunks int64
+
+	// ReplacedChunks is the number of missing or corrupt chunks written back to
+	// the chunk store.
+	ReplacedChunks int
+}
+
+// chunkRepair tracks the NARs being repaired in the background so a NAR
+// failing on every request is repaired once. See SetChunkRepair.
+type chunkRepair struct {
+	mu       sync.Mutex
+	inflight map[int64]struct{}
+}
+
+// SetChunkRepair enables the background repair of chunked NARs found damaged
+// while serving them: a NAR with a missing chunk or chunk link is re-fetched
+// from its upstream and its chunks rewritten with RepairChunkedNar. The
+// request that found the damage still fails; the next one is served from the
+// repaired chunks.
+func (c *Cache) SetChunkRepair(enabled bool) {
+	if !enabled {
+		c.chunkRepair = nil
+
+		return
+	}
+
+	c.chunkRepair = &chunkRepair{inflight: make(map[int64]struct{})}
+}
+
+// RepairChunkedNar repairs the chunks of the chunked NAR at narURL. When a
+// chunk is missing from the chunk store, does not hash to its key or the chunk
+// links of the NAR have a gap, the NAR is re-fetched from its upstream and
+// chunked again; the missing and corrupt chunks are written back and the chunk
+// links are replaced in a single transaction, so the NAR stays servable
+// throughout. The re-fetched NAR must match the NarHash of its narinfo. The
+// chunks no longer linked are left to the orphaned chunks cleanup.
+func (c *Cache) RepairChunkedNar(ctx context.Context, narURL nar.URL) (ChunkRepairResult, error) {
+	ctx, span := tracer.Start(
+		ctx,
+		"cache.RepairChunkedNar",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("nar_url", narURL.String()),
+		),
+	)
+	defer span.End()
+
+	ctx = narURL.
+		NewLogger(*zerolog.Ctx(ctx)).
+		WithContext(ctx)
+
+	cdcEnabled, cs, cdcChunker := c.getCDCInfo()
+	if !cdcEnabled || cs == nil || cdcChunker == nil {
+		return ChunkRepairResult{}, ErrCDCDisabled
+	}
+
+	var result ChunkRepairResult
+
+	err := c.withNarMigrationLock(ctx, narURL.Hash, "RepairChunkedNar", func() error {
+		var err error
+
+		result, err = c.repairChunkedNar(ctx, narURL, cs)
+
+		return err
+	})
+
+	switch {
+	case err != nil:
+		recordChunkRepair(ctx, chunkRepairResultError)
+	case result.Repaired:
+		recordChunkRepair(ctx, chunkRepairResultRepaired)
+	default:
+		recordChunkRepair(ctx, chunkRepairResultIntact)
+	}
+
+	return result, err
+}
+
+func (c *Cache) repairChunkedNar(
+	ctx context.Context,
+	narURL nar.URL,
+	cs chunk.Store,
+) (ChunkRepairResult, error) {
+	nf, err := c.dbClient.Ent().NarFile.Query().
+		Where(
+			entnarfile.HashEQ(narURL.Hash),
+			entnarfile.CompressionEQ(nar.CompressionTypeNone.String()),
+			entnarfile.QueryEQ(narURL.CanonicalQuery()),
+			entnarfile.TotalChunksGT(0),
+		).
+		Only(ctx)
+	if err != nil {
+		if ent.IsNotFound(err) {
+			return ChunkRepairResult{}, ErrNarNotChunked
+		}
+
+		return ChunkRepairResult{}, fmt.Errorf("error loading the nar_file: %w", err)
+	}
+
+	damaged, complete, err := c.damagedChunks(ctx, cs, nf)
+	if err != nil {
+		return ChunkRepairResult{}, err
+	}
+
+	if len(damaged) == 0 && complete {
+		return ChunkRepairResult{TotalChunks: nf.TotalChunks}, nil
+	}
+
+	ni, err := c.dbClient.Ent().NarInfo.Query().
+		Where(entnarinfo.HasNarInfoNarFilesWith(entnarinfonarfile.NarFileIDEQ(nf.ID))).
+		First(ctx)
+	if err != nil {
+		if ent.IsNotFound(err) {
+			return ChunkRepairResult{}, fmt.Errorf("no narinfo links to the nar: %w", storage.ErrNotFound)
+		}
+
+		return ChunkRepairResult{}, fmt.Errorf("error loading the narinfo of the nar: %w", err)
+	}
+
+	if ni.NarHash == nil || *ni.NarHash == "" {
+		return ChunkRepairResult{}, ErrNoNarHashToVerify
+	}
+
+	expectedHash, err := nixhash.ParseAny(*ni.NarHash, nil)
+	if err != nil {
+		return ChunkRepairResult{}, fmt.Errorf("error parsing the NarHash %q: %w", *ni.NarHash, err)
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Int("damaged_chunks", len(damaged)).
+		Bool("links_complete", complete).
+		Msg("repairing the chunks of the nar from upstream")
+
+	_, upstreamNarInfo, err := c.getNarInfoFromUpstream(ctx, ni.Hash)
+	if err != nil {
+		return ChunkRepairResult{}, fmt.Errorf("error fetching the narinfo from upstream: %w", err)
+	}
+
+	if upstreamNarInfo.NarHash == nil || upstreamNarInfo.NarHash.String() != expectedHash.String() {
+		return ChunkRepairResult{}, ErrUpstreamNarChanged
+	}
+
+	upstreamNarURL, err := nar.ParseURL(upstreamNarInfo.URL)
+	if err != nil {
+		return ChunkRepairResult{}, fmt.Errorf("error parsing the upstream nar URL: %w", err)
+	}
+
+	resp, err := c.getNarFromUpstream(ctx, &upstreamNarURL, nil)
+	if err != nil {
+		return ChunkRepairResult{}, fmt.Errorf("error fetching the nar from upstream: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	r, decompCleanup, err := maybeDecompressReader(ctx, resp.Body, upstreamNarURL.Compression)
+	if err != nil {
+		return ChunkRepairResult{}, err
+	}
+
+	defer decompCleanup()
+
+	cdcChunker, cdcSizes, err := c.chunkerForNarFile(nf)
+	if err != nil {
+		return ChunkRepairResult{}, err
+	}
+
+	h := sha256.New()
+
+	chunks, replaced, narSize, err := rechunk(ctx, io.TeeReader(r, h), cs, cdcChunker, damaged)
+	if err != nil {
+		return ChunkRepairResult{}, err
+	}
+
+	if !bytes.Equal(h.Sum(nil), expectedHash.Digest()) ||
+		(ni.NarSize != nil && *ni.NarSize > 0 && uint64(*ni.NarSize) != narSize) {
+		return ChunkRepairResult{}, ErrNarHashMismatch
+	}
+
+	// Swap the chunk links at once: readers see either the old or the new
+	// chunk set, never a partial one.
+	err = c.withEntTransaction(ctx, "RepairChunkedNar.Swap", func(tx *ent.Tx) error {
+		if _, err := database.UnlinkNarFileChunks(ctx, tx, entnarfilechunk.NarFileIDEQ(nf.ID)); err != nil {
+			return fmt.Errorf("error deleting the chunk links: %w", err)
+		}
+
+		for start := 0; start < len(chunks); start += cdcMaxBatchSize {
+			end := min(start+cdcMaxBatchSize, len(chunks))
+
+			if err := recordChunkBatchWithEntTx(ctx, tx, int64(nf.ID), int64(start), chunks[start:end]); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+
+		return tx.NarFile.UpdateOneID(nf.ID).
+			SetTotalChunks(int64(len(chunks))).
+			SetFileSize(narSize).
+			SetChunkMinSize(cdcSizes.min).
+			SetChunkAvgSize(cdcSizes.avg).
+			SetChunkMaxSize(cdcSizes.max).
+			SetVerifiedAt(now).
+			SetUpdatedAt(now).
+			Exec(ctx)
+	})
+	if err != nil {
+		return ChunkRepairResult{}, fmt.Errorf("error replacing the chunks of the nar: %w", err)
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Int("total_chunks", len(chunks)).
+		Int("replaced_chunks", replaced).
+		Msg("repaired the chunks of the nar")
+
+	return ChunkRepairResult{
+		Repaired:       true,
+		TotalChunks:    int64(len(chunks)),
+		ReplacedChunks: replaced,
+	}, nil
+}
+
+// damagedChunks returns the chunks of nf that are missing from the chunk
+// store (false) or whose content does not hash to their key (true), and
+// whether the chunk links of nf are exactly 0..total_chunks-1.
+func (c *Cache) damagedChunks(
+	ctx context.Context,
+	cs chunk.Store,
+	nf *ent.NarFile,
+) (map[string]bool, bool, error) {
+	links, err := c.dbClient.Ent().NarFileChunk.Query().
+		Where(entnarfilechunk.NarFileIDEQ(nf.ID)).
+		Order(entnarfilechunk.ByChunkIndex()).
+		WithChunk().
+		All(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("error loading the chunks of the nar: %w", err)
+	}
+
+	complete := int64(len(links)) == nf.TotalChunks
+	damaged := make(map[string]bool)
+
+	for i, link := range links {
+		if link.ChunkIndex != i {
+			complete = false
+		}
+
+		if link.Edges.Chunk == nil {
+			return nil, false, fmt.Errorf("nar_file_chunk %d: %w", link.ID, errMissingChunkEdge)
+		}
+
+		hash := link.Edges.Chunk.Hash
+		if _, seen := damaged[hash]; seen {
+			continue
+		}
+
+		corrupt, err := chunkCorrupt(ctx, cs, hash)
+		switch {
+		case errors.Is(err, chunk.ErrNotFound):
+			damaged[hash] = false
+		case err != nil:
+			return nil, false, err
+		case corrupt:
+			damaged[hash] = true
+		}
+	}
+
+	return damaged, complete, nil
+}
+
+// chunkCorrupt reports whether the content of the chunk hash does not hash to
+// its key. A chunk that cannot be decompressed is corrupt.
+func chunkCorrupt(ctx context.Context, cs chunk.Store, hash string) (bool, error) {
+	rc, err := cs.GetChunk(ctx, hash)
+	if err != nil {
+		return false, fmt.Errorf("error reading chunk %s: %w", hash, err)
+	}
+
+	defer rc.Close()
+
+	h := blake3.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return true, nil //nolint:nilerr // an unreadable chunk is a corrupt one
+	}
+
+	return hex.EncodeToString(h.Sum(nil)) != hash, nil
+}
+
+// rechunk chunks r into cs. Chunks in damaged are written back, deleting the
+// corrupt ones first since PutChunk keeps an existing chunk. It returns the
+// chunks, without their data, the number of damaged chunks written back and
+// the size of r.
+func rechunk(
+	ctx context.Context,
+	r io.Reader,
+	cs chunk.Store,
+	cdcChunker chunker.Chunker,
+	damaged map[string]bool,
+) ([]*chunker.Chunk, int, uint64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunksChan, errChan := cdcChunker.Chunk(ctx, r)
+
+	// On an early return, stop the chunker and release the chunks it already
+	// produced.
+	drain := func() {
+		cancel()
+
+		for ch := range chunksChan {
+			ch.Free()
+		}
+	}
+
+	var (
+		chunks   []*chunker.Chunk
+		replaced int
+		size     uint64
+	)
+
+	for ch := range chunksChan {
+		corrupt, isDamaged := damaged[ch.Hash]
+		if corrupt {
+			if err := cs.DeleteChunk(ctx, ch.Hash); err != nil && !errors.Is(err, chunk.ErrNotFound) {
+				ch.Free()
+				drain()
+
+				return nil, 0, 0, fmt.Errorf("error deleting corrupt chunk %s: %w", ch.Hash, err)
+			}
+		}
+
+		_, compressedSize, err := cs.PutChunk(ctx, ch.Hash, ch.Data)
+
+		ch.Free()
+
+		if err != nil {
+			drain()
+
+			return nil, 0, 0, fmt.Errorf("error storing chunk %s: %w", ch.Hash, err)
+		}
+
+		if isDamaged {
+			// A chunk repeated within the NAR is written back once.
+			delete(damaged, ch.Hash)
+
+			replaced++
+		}
+
+		//nolint:gosec // G115: Chunk size is small enough to fit in uint32
+		ch.CompressedSize = uint32(compressedSize)
+		size += uint64(ch.Size)
+
+		chunks = append(chunks, ch)
+	}
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("chunking error: %w", err)
+		}
+	default:
+	}
+
+	return chunks, replaced, size, nil
+}
+
+// maybeRepairChunkedNar repairs the chunked NAR of narFileID in the background
+// when SetChunkRepair enabled it. A NAR already being repaired is skipped.
+func (c *Cache) maybeRepairChunkedNar(ctx context.Context, narFileID int64) {
+	cr := c.chunkRepair
+	if cr == nil || !cr.claim(narFileID) {
+		return
+	}
+
+	// The repair outlives the request that found the damage.
+	detachedCtx := context.WithoutCancel(ctx)
+
+	c.backgroundWG.Add(1)
+
+	analytics.SafeGo(detachedCtx, func() {
+		defer c.backgroundWG.Done()
+		defer cr.release(narFileID)
+
+		start := time.Now()
+		log := zerolog.Ctx(detachedCtx).With().Int64("nar_file_id", narFileID).Logger()
+
+		//nolint:gosec // G115: nar_file IDs are non-negative
+		nf, err := c.dbClient.Ent().NarFile.Get(detachedCtx, int(narFileID))
+		if err != nil {
+			log.Warn().Err(err).Msg("error loading the nar_file to repair")
+			c.recordBackgroundJob(backgroundJobChunkRepair, start, err)
+
+			return
+		}
+
+		query, err := url.ParseQuery(nf.Query)
+		if err != nil {
+			log.Warn().Err(err).Msg("error parsing the query of the nar_file to repair")
+			c.recordBackgroundJob(backgroundJobChunkRepair, start, err)
+
+			return
+		}
+
+		narURL := nar.URL{Hash: nf.Hash, Compression: nar.CompressionTypeNone, Query: query}
+
+		_, err = c.RepairChunkedNar(detachedCtx, narURL)
+		if err != nil {
+			log.Error().Err(err).Msg("error repairing the chunks of the nar")
+		}
+
+		c.recordBackgroundJob(backgroundJobChunkRepair, start, err)
+	})
+}
+
+// claim marks narFileID as being repaired. It returns false when it already is.
+func (cr *chunkRepair) claim(narFileID int64) bool {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if _, ok := cr.inflight[narFileID]; ok {
+		return false
+	}
+
+	cr.inflight[narFileID] = struct{}{}
+
+	return true
+}
+
+func (cr *chunkRepair) release(narFileID int64) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	delete(cr.inflight, narFileID)
+}
+
+func recordChunkRepair(ctx context.Context, result string) {
+	if chunkRepairTotal == nil {
+		return
+	}
+
+	chunkRepairTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+}
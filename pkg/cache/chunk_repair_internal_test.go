@@ -0,0 +1,175 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/nixbase32"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	entnarfilechunk "github.com/kalbasit/ncps/ent/narfilechunk"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestRepairChunkedNar(t *testing.T) {
+	t.Parallel()
+
+	ctx := newContext()
+
+	c, dbClient, _, dir, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	cs, err := chunk.NewLocalStore(filepath.Join(dir, "chunks-store"))
+	require.NoError(t, err)
+
+	c.SetChunkStore(cs)
+	require.NoError(t, c.SetCDCConfiguration(true, 1024, 4096, 8192))
+
+	content := testhelper.MustRandString(50000)
+	sum := sha256.Sum256([]byte(content))
+	narHash := "sha256:" + nixbase32.EncodeToString(sum[:])
+
+	narInfoHash := strings.Repeat("2", 32)
+	narURL := nar.URL{Hash: strings.Repeat("1", 52), Compression: nar.CompressionTypeNone}
+	narInfoText := fmt.Sprintf(
+		"StorePath: /nix/store/%s-chunk-repair\nURL: %s\nCompression: none\n"+
+			"FileHash: %s\nFileSize: %d\nNarHash: %s\nNarSize: %d\n",
+		narInfoHash, narURL.String(), narHash, len(content), narHash, len(content),
+	)
+
+	ts := testdata.NewTestServer(t, 40)
+	t.Cleanup(ts.Close)
+
+	ts.AddMaybeHandler(func(w http.ResponseWriter, r *http.Request) bool {
+		var body string
+
+		switch r.URL.Path {
+		case "/" + narInfoHash + ".narinfo":
+			body = narInfoText
+		case "/" + narURL.String():
+			body = content
+		default:
+			return false
+		}
+
+		if r.Method == http.MethodGet {
+			_, err := w.Write([]byte(body))
+			assert.NoError(t, err)
+		}
+
+		return true
+	})
+
+	uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), nil)
+	require.NoError(t, err)
+
+	c.AddUpstreamCaches(newContext(), uc)
+
+	<-c.GetHealthChecker().Trigger()
+
+	require.NoError(t, c.PutNar(ctx, narURL, io.NopCloser(strings.NewReader(content))))
+
+	nf, err := fetchNarFile(ctx, dbClient, narURL.Hash, nar.CompressionTypeNone.String(), "")
+	require.NoError(t, err)
+	require.Greater(t, nf.TotalChunks, int64(2))
+
+	ni, err := dbClient.Ent().NarInfo.Create().
+		SetHash(narInfoHash).
+		SetURL(narURL.String()).
+		SetNarHash(narHash).
+		SetNarSize(int64(len(content))).
+		Save(ctx)
+	require.NoError(t, err)
+
+	_, err = dbClient.Ent().NarInfoNarFile.Create().
+		SetNarinfoID(ni.ID).
+		SetNarFileID(nf.ID).
+		Save(ctx)
+	require.NoError(t, err)
+
+	chunkHash := func(index int) string {
+		t.Helper()
+
+		ch, err := dbClient.Ent().NarFileChunk.Query().
+			Where(
+				entnarfilechunk.NarFileIDEQ(nf.ID),
+				entnarfilechunk.ChunkIndexEQ(index),
+			).
+			QueryChunk().
+			Only(ctx)
+		require.NoError(t, err)
+
+		return ch.Hash
+	}
+
+	assertServed := func() {
+		t.Helper()
+
+		_, _, rc, err := c.GetNar(ctx, narURL)
+		require.NoError(t, err)
+
+		defer rc.Close()
+
+		got, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		assert.Equal(t, content, string(got))
+	}
+
+	// An intact NAR is left alone.
+	result, err := c.RepairChunkedNar(ctx, narURL)
+	require.NoError(t, err)
+	assert.False(t, result.Repaired)
+	assert.Equal(t, nf.TotalChunks, result.TotalChunks)
+
+	// A missing and a corrupt chunk are written back from upstream.
+	missing, corrupt := chunkHash(0), chunkHash(1)
+
+	require.NoError(t, cs.DeleteChunk(ctx, missing))
+	require.NoError(t, cs.DeleteChunk(ctx, corrupt))
+
+	_, _, err = cs.PutChunk(ctx, corrupt, []byte("not the content of the chunk"))
+	require.NoError(t, err)
+
+	result, err = c.RepairChunkedNar(ctx, narURL)
+	require.NoError(t, err)
+	assert.True(t, result.Repaired)
+	assert.Equal(t, 2, result.ReplacedChunks)
+	assert.Equal(t, nf.TotalChunks, result.TotalChunks)
+
+	assertServed()
+
+	// A chunk found missing while serving is repaired in the background.
+	c.SetChunkRepair(true)
+
+	require.NoError(t, cs.DeleteChunk(ctx, chunkHash(2)))
+
+	_, _, rc, err := c.GetNar(ctx, narURL)
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(rc)
+	require.ErrorIs(t, err, chunk.ErrNotFound)
+	require.NoError(t, rc.Close())
+
+	c.backgroundWG.Wait()
+
+	exists, err := cs.HasChunk(ctx, chunkHash(2))
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	assertServed()
+
+	// A NAR stored whole cannot be repaired as chunks.
+	_, err = c.RepairChunkedNar(ctx, nar.URL{Hash: strings.Repeat("3", 52), Compression: nar.CompressionTypeNone})
+	require.ErrorIs(t, err, ErrNarNotChunked)
+}
@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog"
+
+	entchunk "github.com/kalbasit/ncps/ent/chunk"
+
+	"github.com/kalbasit/ncps/ent"
+)
+
+const (
+	// chunkTieringBatchSize is the number of demotion candidates listed per
+	// query.
+	chunkTieringBatchSize = 1000
+
+	// chunkAccessMaxTracked bounds the chunks whose reads are counted between
+	// two runs of the tiering job; the reads of the others are not counted.
+	chunkAccessMaxTracked = 1 << 20
+)
+
+// ErrColdChunkStoreRequired is returned by RunChunkTiering when the chunk
+// store has no cold store to demote the chunks to.
+var ErrColdChunkStoreRequired = errors.New("a cold chunk store is required to demote chunks")
+
+// chunkDemoter is a chunk store able to move a chunk to a cold store, such as
+// chunk.TieredStore.
+type chunkDemoter interface {
+	Demote(ctx context.Context, hash string) (int64, error)
+}
+
+type chunkTiering struct {
+	coldAfter   time.Duration
+	minAccesses int64
+
+	mu       sync.Mutex
+	accesses map[string]int64
+}
+
+// ChunkTieringResult reports a run of RunChunkTiering.
+type ChunkTieringResult struct {
+	// Accessed is the number of chunks whose reads were flushed to the
+	// database.
+	Accessed int
+
+	// Demoted is the number of chunks moved to the cold store, and
+	// DemotedBytes their uncompressed size.
+	Demoted      int
+	DemotedBytes int64
+
+	// Failed is the number of chunks that could not be demoted.
+	Failed int
+}
+
+// SetChunkTiering enables counting the chunk reads for RunChunkTiering, which
+// demotes to the cold store the chunks read fewer than minAccesses times, the
+// count being halved at each run, and not read for coldAfter.
+func (c *Cache) SetChunkTiering(coldAfter time.Duration, minAccesses int64) {
+	c.chunkTiering = &chunkTiering{
+		coldAfter:   coldAfter,
+		minAccesses: minAccesses,
+		accesses:    make(map[string]int64),
+	}
+}
+
+// fetchChunk returns the chunk of hash from the chunk store, compressed if raw
+// is set, and counts the read for the chunk tiering.
+func (c *Cache) fetchChunk(ctx context.Context, hash string, raw bool) (io.ReadCloser, error) {
+	if ct := c.chunkTiering; ct != nil {
+		ct.mu.Lock()
+
+		if _, ok := ct.accesses[hash]; ok || len(ct.accesses) < chunkAccessMaxTracked {
+			ct.accesses[hash]++
+		}
+
+		ct.mu.Unlock()
+	}
+
+	if raw {
+		return c.getChunkStore().GetRawChunk(ctx, hash)
+	}
+
+	return c.getChunkStore().GetChunk(ctx, hash)
+}
+
+// RunChunkTiering flushes the chunk reads counted since its last run to the
+// database, demotes the cold chunks to the cold store, then halves the read
+// counts. A chunk read from the cold store was promoted back by the read.
+func (c *Cache) RunChunkTiering(ctx context.Context) (ChunkTieringResult, error) {
+	var result ChunkTieringResult
+
+	ct := c.chunkTiering
+	if ct == nil {
+		return result, nil
+	}
+
+	ct.mu.Lock()
+	accesses := ct.accesses
+	ct.accesses = make(map[string]int64)
+	ct.mu.Unlock()
+
+	if err := c.dbClient.AddChunkAccesses(ctx, accesses, time.Now()); err != nil {
+		return result, err
+	}
+
+	result.Accessed = len(accesses)
+
+	demoter, ok := c.getChunkStore().(chunkDemoter)
+	if !ok {
+		return result, ErrColdChunkStoreRequired
+	}
+
+	if err := c.demoteColdChunks(ctx, ct, demoter, &result); err != nil {
+		return result, err
+	}
+
+	if err := c.dbClient.DecayChunkAccessCounts(ctx); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (c *Cache) demoteColdChunks(
+	ctx context.Context,
+	ct *chunkTiering,
+	demoter chunkDemoter,
+	result *ChunkTieringResult,
+) error {
+	cutoff := time.Now().Add(-ct.coldAfter)
+	lastID := 0
+
+	for {
+		chunks, err := c.dbClient.Ent().Chunk.Query().
+			Where(
+				entchunk.IDGT(lastID),
+				entchunk.Cold(false),
+				entchunk.RefCountGT(0),
+				entchunk.AccessCountLT(ct.minAccesses),
+				entchunk.CreatedAtLT(cutoff),
+				entchunk.Or(entchunk.LastAccessedAtIsNil(), entchunk.LastAccessedAtLT(cutoff)),
+			).
+			Order(ent.Asc(entchunk.FieldID)).
+			Limit(chunkTieringBatchSize).
+			All(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing the cold chunks: %w", err)
+		}
+
+		demoted := make([]int, 0, len(chunks))
+
+		for _, ch := range chunks {
+			size, err := demoter.Demote(ctx, ch.Hash)
+			if err != nil {
+				zerolog.Ctx(ctx).
+					Warn().
+					Err(err).
+					Str("chunk_hash", ch.Hash).
+					Msg("error demoting a chunk to the cold store")
+
+				result.Failed++
+
+				continue
+			}
+
+			demoted = append(demoted, ch.ID)
+			result.DemotedBytes += size
+		}
+
+		if len(demoted) > 0 {
+			if err := c.dbClient.Ent().Chunk.Update().
+				Where(entchunk.IDIn(demoted...)).
+				SetCold(true).
+				Exec(ctx); err != nil {
+				return fmt.Errorf("error flagging the demoted chunks: %w", err)
+			}
+
+			result.Demoted += len(demoted)
+		}
+
+		if len(chunks) < chunkTieringBatchSize {
+			return nil
+		}
+
+		lastID = chunks[len(chunks)-1].ID
+	}
+}
+
+// AddChunkTieringCronJob adds a periodic job running RunChunkTiering.
+func (c *Cache) AddChunkTieringCronJob(ctx context.Context, schedule cron.Schedule) {
+	zerolog.Ctx(ctx).
+		Info().
+		Time("next-run", schedule.Next(time.Now())).
+		Msg("adding a cronjob for the chunk tiering")
+
+	c.scheduleCronJob(ctx, CronJobChunkTiering, schedule, func(ctx context.Context) func() {
+		return func() {
+			result, err := c.RunChunkTiering(ctx)
+			if err != nil {
+				zerolog.Ctx(ctx).
+					Error().
+					Err(err).
+					Msg("error running the chunk tiering")
+
+				recordCronJobError(ctx, err)
+
+				return
+			}
+
+			zerolog.Ctx(ctx).
+				Info().
+				Int("accessed", result.Accessed).
+				Int("demoted", result.Demoted).
+				Int64("demoted_bytes", result.DemotedBytes).
+				Int("failed", result.Failed).
+				Msg("chunk tiering completed")
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	entchunk "github.com/kalbasit/ncps/ent/chunk"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestRunChunkTiering(t *testing.T) {
+	t.Parallel()
+
+	ctx := newContext()
+
+	c, dbClient, _, dir, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	hot, err := chunk.NewLocalStore(filepath.Join(dir, "chunks-hot"))
+	require.NoError(t, err)
+
+	cold, err := chunk.NewLocalStore(filepath.Join(dir, "chunks-cold"))
+	require.NoError(t, err)
+
+	c.SetChunkStore(chunk.NewTieredStore(hot, cold))
+	require.NoError(t, c.SetCDCConfiguration(true, 1024, 4096, 8192))
+
+	c.SetChunkTiering(0, 1)
+
+	content := testhelper.MustRandString(20000)
+	nu := nar.URL{Hash: strings.Repeat("3", 52), Compression: nar.CompressionTypeNone}
+
+	require.NoError(t, c.PutNar(ctx, nu, io.NopCloser(strings.NewReader(content))))
+
+	chunks := dbClient.Ent().Chunk.Query().AllX(ctx)
+	require.NotEmpty(t, chunks)
+
+	// The chunks never read are demoted.
+	result, err := c.RunChunkTiering(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, result.Accessed)
+	assert.Equal(t, len(chunks), result.Demoted)
+	assert.Equal(t, int64(len(content)), result.DemotedBytes)
+	assert.Zero(t, result.Failed)
+
+	for _, ch := range chunks {
+		ok, err := hot.HasChunk(ctx, ch.Hash)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	}
+
+	assert.Equal(t, len(chunks), dbClient.Ent().Chunk.Query().Where(entchunk.Cold(true)).CountX(ctx))
+
+	// Reading the NAR promotes its chunks.
+	_, _, r, err := c.GetNar(ctx, nu)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(r)
+	require.NoError(t, r.Close())
+	require.NoError(t, err)
+	assert.Equal(t, content, string(body))
+
+	for _, ch := range chunks {
+		ok, err := hot.HasChunk(ctx, ch.Hash)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	// The chunks read are flagged back as hot and not demoted again.
+	result, err = c.RunChunkTiering(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(chunks), result.Accessed)
+	assert.Zero(t, result.Demoted)
+
+	assert.Zero(t, dbClient.Ent().Chunk.Query().Where(entchunk.Cold(true)).CountX(ctx))
+}
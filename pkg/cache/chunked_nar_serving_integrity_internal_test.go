@@ -52,6 +52,7 @@ func TestGetNarFromChunks_MidChunkingPartialLinksNotFalse404(t *testing.T) {
 		SetChunkIndex(0).
 		Save(ctx)
 	require.NoError(t, err)
+	require.NoError(t, c.dbClient.Ent().Chunk.UpdateOneID(ch.ID).AddRefCount(1).Exec(ctx))
 
 	narURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: nar.CompressionTypeNone}
 
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/kalbasit/ncps/pkg/nar"
 )
 
 // downloadLockFree reports whether the per-hash NAR download lock is currently
@@ -52,7 +54,7 @@ func TestCoordinateDownload_HoldsNARLockThroughChunking(t *testing.T) {
 
 	const hash = "0123456789abcdef0123456789abcdef"
 
-	key := narJobKey(hash)
+	key := c.narJobKey(nar.URL{Hash: hash, Compression: nar.CompressionTypeXz})
 
 	// The asset is not present, so coordinateDownload starts the (stubbed) job
 	// rather than short-circuiting to a served-from-storage state.
@@ -141,7 +141,7 @@ func TestCoordinateDownloadTakesOverNAR(t *testing.T) {
 
 	c, locker := setupTakeoverCache(t)
 
-	narKey := narJobKey(testdata.Nar1.NarHash)
+	narKey := c.narJobKey(nar.URL{Hash: testdata.Nar1.NarHash, Compression: nar.CompressionTypeXz})
 
 	// Simulate another replica holding the NAR download lock from the start, so
 	// neither the narinfo-triggered background pre-pull nor the explicit GetNar
@@ -237,7 +237,7 @@ func TestCoordinateDownloadNARGiveUpReturnsNotFound(t *testing.T) {
 
 	c, locker := setupTakeoverCache(t)
 
-	narKey := narJobKey(testdata.Nar1.NarHash)
+	narKey := c.narJobKey(nar.URL{Hash: testdata.Nar1.NarHash, Compression: nar.CompressionTypeXz})
 
 	// Hold the NAR download lock for the entire test: the waiter can neither
 	// observe the asset (it is never produced) nor re-acquire the lock, so it
@@ -0,0 +1,372 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog"
+)
+
+// Names of the cron jobs registered by the Add*CronJob methods.
+const (
+	CronJobLRU                     = "lru"
+	CronJobCDCDeletedCleanup       = "cdc-deleted-cleanup"
+	CronJobCDCLazyRecovery         = "cdc-lazy-recovery"
+	CronJobStagingGC               = "staging-gc"
+	CronJobPrewarm                 = "prewarm"
+	CronJobChannelPrefetch         = "channel-prefetch"
+	CronJobUpstreamDiscovery       = "upstream-discovery"
+	CronJobSQLiteMaintenance       = "sqlite-maintenance"
+	CronJobNarInfoIndex            = "narinfo-index"
+	CronJobChunkTiering            = "chunk-tiering"
+	CronJobNarInfoFileHashBackfill = "narinfo-file-hash-backfill"
+	CronJobUpstreamBloom           = "upstream-bloom"
+)
+
+// CronJobNames returns the names of the cron jobs the Add*CronJob methods
+// register.
+func CronJobNames() []string {
+	return []string{
+		CronJobLRU,
+		CronJobCDCDeletedCleanup,
+		CronJobCDCLazyRecovery,
+		CronJobStagingGC,
+		CronJobPrewarm,
+		CronJobChannelPrefetch,
+		CronJobUpstreamDiscovery,
+		CronJobSQLiteMaintenance,
+		CronJobNarInfoIndex,
+		CronJobChunkTiering,
+		CronJobNarInfoFileHashBackfill,
+		CronJobUpstreamBloom,
+	}
+}
+
+var (
+	// ErrCronJobNotFound is returned if no cron job is registered under the
+	// given name.
+	ErrCronJobNotFound = errors.New("cron job not found")
+
+	// ErrCronJobRunning is returned when triggering a cron job that is already
+	// running.
+	ErrCronJobRunning = errors.New("cron job is already running")
+
+	// ErrCronStopped is returned when triggering a cron job after the cache was
+	// closed.
+	ErrCronStopped = errors.New("cron scheduler is stopped")
+)
+
+const cronJobKey contextKey = "cron_job"
+
+// CronJobStatus describes a registered cron job.
+type CronJobStatus struct {
+	Name string
+
+	// Paused is true when the scheduled runs of the job are skipped. A paused
+	// job can still be triggered manually.
+	Paused bool
+
+	// Deferred is true when a scheduled run of the job waits for a maintenance
+	// window to open; see SetMaintenanceWindows.
+	Deferred bool
+
+	// Running is true while a run of the job is in progress.
+	Running bool
+
+	// NextRun is the next scheduled run; it is zero until the cron is started.
+	NextRun time.Time
+
+	// LastRun is when the last run started; it is zero if the job never ran.
+	LastRun time.Time
+
+	// LastDuration is how long the last completed run took.
+	LastDuration time.Duration
+
+	// LastError is the error reported by the last completed run, if any.
+	LastError string
+
+	// Runs and Failures count the completed runs and the failed ones.
+	Runs     int64
+	Failures int64
+}
+
+// cronJob tracks the state of a job registered with the cron scheduler.
+type cronJob struct {
+	name    string
+	entryID cron.EntryID
+	run     func()
+
+	mu           sync.Mutex
+	paused       bool
+	deferred     bool
+	running      bool
+	triggered    bool
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastErr      error
+	runErr       error
+	runs         int64
+	failures     int64
+}
+
+// cronJobs is the registry of the cron jobs of a cache.
+type cronJobs struct {
+	mu      sync.Mutex
+	jobs    map[string]*cronJob
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// scheduleCronJob registers the job returned by newRun under name with the
+// cron scheduler. newRun receives a context letting the job report its errors
+// with recordCronJobError.
+func (c *Cache) scheduleCronJob(
+	ctx context.Context,
+	name string,
+	schedule cron.Schedule,
+	newRun func(ctx context.Context) func(),
+) {
+	job := &cronJob{name: name}
+	job.run = newRun(context.WithValue(ctx, cronJobKey, job))
+
+	job.entryID = c.cron.Schedule(schedule, cron.FuncJob(func() {
+		job.mu.Lock()
+		paused := job.paused
+		job.mu.Unlock()
+
+		if paused {
+			zerolog.Ctx(ctx).
+				Debug().
+				Str("cron_job", name).
+				Msg("cron job is paused, skipping the scheduled run")
+
+			return
+		}
+
+		if c.outsideMaintenanceWindow(name, time.Now()) {
+			job.mu.Lock()
+			job.deferred = true
+			job.mu.Unlock()
+
+			zerolog.Ctx(ctx).
+				Info().
+				Str("cron_job", name).
+				Msg("outside of the maintenance windows, deferring the scheduled run")
+
+			return
+		}
+
+		if err := job.execute(false); err != nil {
+			zerolog.Ctx(ctx).
+				Info().
+				Str("cron_job", name).
+				Msg("cron job is still running, skipping the scheduled run")
+		}
+	}))
+
+	c.cronJobs.mu.Lock()
+	defer c.cronJobs.mu.Unlock()
+
+	if c.cronJobs.jobs == nil {
+		c.cronJobs.jobs = make(map[string]*cronJob)
+	}
+
+	c.cronJobs.jobs[name] = job
+}
+
+// execute runs the job unless a run is already in progress, in which case it
+// returns ErrCronJobRunning. A triggered run ignores the maintenance windows.
+func (j *cronJob) execute(triggered bool) error {
+	j.mu.Lock()
+
+	if j.running {
+		j.mu.Unlock()
+
+		return ErrCronJobRunning
+	}
+
+	j.running = true
+	j.triggered = triggered
+	j.deferred = false
+	j.runErr = nil
+	j.lastRun = time.Now()
+
+	j.mu.Unlock()
+
+	defer func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+
+		j.running = false
+		j.lastDuration = time.Since(j.lastRun)
+		j.lastErr = j.runErr
+		j.runs++
+
+		if j.runErr != nil {
+			j.failures++
+		}
+	}()
+
+	j.run()
+
+	return nil
+}
+
+// recordCronJobError records err as the outcome of the current run of the cron
+// job whose context is ctx. It is a no-op outside of a cron job.
+func recordCronJobError(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+
+	if j, ok := ctx.Value(cronJobKey).(*cronJob); ok {
+		j.mu.Lock()
+		j.runErr = err
+		j.mu.Unlock()
+	}
+}
+
+// CronJobs returns the status of the registered cron jobs sorted by name.
+func (c *Cache) CronJobs() []CronJobStatus {
+	c.cronJobs.mu.Lock()
+	jobs := make([]*cronJob, 0, len(c.cronJobs.jobs))
+
+	for _, j := range c.cronJobs.jobs {
+		jobs = append(jobs, j)
+	}
+	c.cronJobs.mu.Unlock()
+
+	slices.SortFunc(jobs, func(a, b *cronJob) int { return strings.Compare(a.name, b.name) })
+
+	statuses := make([]CronJobStatus, 0, len(jobs))
+	for _, j := range jobs {
+		statuses = append(statuses, c.cronJobStatus(j))
+	}
+
+	return statuses
+}
+
+// CronJob returns the status of the cron job registered under name.
+func (c *Cache) CronJob(name string) (CronJobStatus, error) {
+	j, err := c.lookupCronJob(name)
+	if err != nil {
+		return CronJobStatus{}, err
+	}
+
+	return c.cronJobStatus(j), nil
+}
+
+// TriggerCronJob starts a run of the cron job registered under name in the
+// background, whether or not the job is paused. It returns ErrCronJobRunning
+// if a run is already in progress.
+func (c *Cache) TriggerCronJob(ctx context.Context, name string) error {
+	j, err := c.lookupCronJob(name)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	running := j.running
+	j.mu.Unlock()
+
+	if running {
+		return ErrCronJobRunning
+	}
+
+	c.cronJobs.mu.Lock()
+	defer c.cronJobs.mu.Unlock()
+
+	if c.cronJobs.stopped {
+		return ErrCronStopped
+	}
+
+	zerolog.Ctx(ctx).
+		Info().
+		Str("cron_job", name).
+		Msg("triggering the cron job")
+
+	c.cronJobs.wg.Go(func() {
+		if err := j.execute(true); err != nil {
+			zerolog.Ctx(ctx).
+				Info().
+				Str("cron_job", name).
+				Msg("cron job started running before the trigger, skipping")
+		}
+	})
+
+	return nil
+}
+
+// PauseCronJob stops the scheduled runs of the cron job registered under name
+// until ResumeCronJob is called. A run in progress is not interrupted.
+func (c *Cache) PauseCronJob(name string) error { return c.setCronJobPaused(name, true) }
+
+// ResumeCronJob resumes the scheduled runs of a cron job paused with
+// PauseCronJob.
+func (c *Cache) ResumeCronJob(name string) error { return c.setCronJobPaused(name, false) }
+
+func (c *Cache) setCronJobPaused(name string, paused bool) error {
+	j, err := c.lookupCronJob(name)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.paused = paused
+	j.mu.Unlock()
+
+	return nil
+}
+
+func (c *Cache) lookupCronJob(name string) (*cronJob, error) {
+	c.cronJobs.mu.Lock()
+	defer c.cronJobs.mu.Unlock()
+
+	j, ok := c.cronJobs.jobs[name]
+	if !ok {
+		return nil, ErrCronJobNotFound
+	}
+
+	return j, nil
+}
+
+func (c *Cache) cronJobStatus(j *cronJob) CronJobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := CronJobStatus{
+		Name:         j.name,
+		Paused:       j.paused,
+		Deferred:     j.deferred,
+		Running:      j.running,
+		LastRun:      j.lastRun,
+		LastDuration: j.lastDuration,
+		Runs:         j.runs,
+		Failures:     j.failures,
+	}
+
+	if j.lastErr != nil {
+		status.LastError = j.lastErr.Error()
+	}
+
+	if c.cron != nil {
+		status.NextRun = c.cron.Entry(j.entryID).Next
+	}
+
+	return status
+}
+
+// stopCronJobs refuses further triggers and waits for the triggered runs to
+// return.
+func (c *Cache) stopCronJobs() {
+	c.cronJobs.mu.Lock()
+	c.cronJobs.stopped = true
+	c.cronJobs.mu.Unlock()
+
+	c.cronJobs.wg.Wait()
+}
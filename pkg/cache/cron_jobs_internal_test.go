@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errCronJobTest = errors.New("cron job failed")
+
+func TestCronJobs(t *testing.T) {
+	t.Parallel()
+
+	// The subtests share the job state and run in order.
+	c := &Cache{cron: cron.New()}
+
+	var (
+		runs    atomic.Int64
+		fail    atomic.Bool
+		release = make(chan struct{})
+		started = make(chan struct{}, 1)
+	)
+
+	c.scheduleCronJob(newContext(), "test", cron.Every(time.Hour), func(ctx context.Context) func() {
+		return func() {
+			runs.Add(1)
+			started <- struct{}{}
+			<-release
+
+			if fail.Load() {
+				recordCronJobError(ctx, errCronJobTest)
+			}
+		}
+	})
+
+	waitIdle := func(t *testing.T) CronJobStatus {
+		t.Helper()
+
+		var status CronJobStatus
+
+		require.Eventually(t, func() bool {
+			var err error
+
+			status, err = c.CronJob("test")
+			require.NoError(t, err)
+
+			return !status.Running
+		}, 5*time.Second, 10*time.Millisecond)
+
+		return status
+	}
+
+	t.Run("unknown job", func(t *testing.T) { //nolint:paralleltest // sequential by design: shares the job state
+		_, err := c.CronJob("nope")
+		require.ErrorIs(t, err, ErrCronJobNotFound)
+		require.ErrorIs(t, c.TriggerCronJob(newContext(), "nope"), ErrCronJobNotFound)
+		require.ErrorIs(t, c.PauseCronJob("nope"), ErrCronJobNotFound)
+	})
+
+	t.Run("trigger records success", func(t *testing.T) { //nolint:paralleltest // sequential by design: shares the job state
+		require.NoError(t, c.TriggerCronJob(newContext(), "test"))
+		<-started
+
+		status, err := c.CronJob("test")
+		require.NoError(t, err)
+		assert.True(t, status.Running)
+
+		require.ErrorIs(t, c.TriggerCronJob(newContext(), "test"), ErrCronJobRunning)
+
+		release <- struct{}{}
+
+		status = waitIdle(t)
+		assert.Equal(t, int64(1), status.Runs)
+		assert.Equal(t, int64(0), status.Failures)
+		assert.Empty(t, status.LastError)
+		assert.False(t, status.LastRun.IsZero())
+	})
+
+	t.Run("trigger records failure", func(t *testing.T) { //nolint:paralleltest // sequential by design: shares the job state
+		fail.Store(true)
+
+		require.NoError(t, c.TriggerCronJob(newContext(), "test"))
+		<-started
+		release <- struct{}{}
+
+		status := waitIdle(t)
+		assert.Equal(t, int64(2), status.Runs)
+		assert.Equal(t, int64(1), status.Failures)
+		assert.Equal(t, errCronJobTest.Error(), status.LastError)
+	})
+
+	t.Run("paused job skips scheduled runs", func(t *testing.T) { //nolint:paralleltest // sequential by design: shares the job state
+		require.NoError(t, c.PauseCronJob("test"))
+
+		c.cron.Entry(c.cronJobs.jobs["test"].entryID).Job.Run()
+		assert.Equal(t, int64(2), runs.Load())
+
+		jobs := c.CronJobs()
+		require.Len(t, jobs, 1)
+		assert.True(t, jobs[0].Paused)
+
+		require.NoError(t, c.ResumeCronJob("test"))
+
+		go c.cron.Entry(c.cronJobs.jobs["test"].entryID).Job.Run()
+		<-started
+		release <- struct{}{}
+
+		waitIdle(t)
+		assert.Equal(t, int64(3), runs.Load())
+	})
+
+	t.Run("trigger after stop", func(t *testing.T) { //nolint:paralleltest // sequential by design: shares the job state
+		c.stopCronJobs()
+
+		require.ErrorIs(t, c.TriggerCronJob(newContext(), "test"), ErrCronStopped)
+	})
+}
@@ -0,0 +1,278 @@
+package cache
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/kalbasit/ncps/pkg/analytics"
+)
+
+const (
+	// downloadJournalDir is the directory, in the temporary directory, holding
+	// a journal entry for each NAR download writing to a temporary file.
+	downloadJournalDir = "ncps-journal"
+
+	// downloadJournalCheckpointInterval is how often the progress of a
+	// download is written to its journal entry.
+	downloadJournalCheckpointInterval = time.Second
+)
+
+// ErrDownloadInterrupted is returned by GetNar for a NAR whose download was
+// interrupted by a restart while its download lock may still be held by the
+// process that died. See DownloadInterruptedError.
+var ErrDownloadInterrupted = errors.New("the download of the nar was interrupted")
+
+// DownloadInterruptedError is the ErrDownloadInterrupted of a NAR, carrying
+// how long until the lock of the interrupted download expires and the NAR can
+// be downloaded again.
+type DownloadInterruptedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *DownloadInterruptedError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrDownloadInterrupted, e.RetryAfter)
+}
+
+func (e *DownloadInterruptedError) Unwrap() error { return ErrDownloadInterrupted }
+
+// downloadJournalEntry is the checkpoint of a NAR download, kept on disk while
+// the download writes its temporary files.
+type downloadJournalEntry struct {
+	Key          string    `json:"key"`
+	NarURL       string    `json:"nar_url"`
+	TempPaths    []string  `json:"temp_paths"`
+	BytesWritten int64     `json:"bytes_written"`
+	StartedAt    time.Time `json:"started_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// tempDirOrDefault returns the temporary directory, which is the default one
+// of the system while none is set, as it is for os.CreateTemp.
+func (c *Cache) tempDirOrDefault() string {
+	return filepath.Clean(cmp.Or(c.tempDir, os.TempDir()))
+}
+
+func (c *Cache) downloadJournalPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return filepath.Join(c.tempDirOrDefault(), downloadJournalDir, hex.EncodeToString(sum[:])+".json")
+}
+
+// writeDownloadJournalEntry writes the entry, replacing the previous one of
+// its download in one rename so a crash never leaves it half written.
+func (c *Cache) writeDownloadJournalEntry(entry *downloadJournalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding the download journal entry: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(c.tempDirOrDefault(), downloadJournalDir), 0o700); err != nil {
+		return fmt.Errorf("error creating the download journal directory: %w", err)
+	}
+
+	path := c.downloadJournalPath(entry.Key)
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("error writing the download journal entry: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error renaming the download journal entry: %w", err)
+	}
+
+	return nil
+}
+
+// journalDownload records the download of ds, whose job is key, in the
+// download journal, checkpoints its progress until it is done, and then
+// removes its entry. An entry left behind means the process stopped during
+// the download, see RecoverInterruptedDownloads. The journal is best effort:
+// a download is not failed because it cannot be journaled.
+func (c *Cache) journalDownload(ctx context.Context, key string, narURL string, ds *downloadState) {
+	ds.mu.Lock()
+
+	entry := downloadJournalEntry{
+		Key:          key,
+		NarURL:       narURL,
+		TempPaths:    []string{ds.assetPath},
+		BytesWritten: ds.bytesWritten,
+		StartedAt:    ds.startedAt,
+		UpdatedAt:    time.Now(),
+	}
+
+	if ds.compressedAssetPath != "" {
+		entry.TempPaths = append(entry.TempPaths, ds.compressedAssetPath)
+	}
+
+	ds.mu.Unlock()
+
+	if err := c.writeDownloadJournalEntry(&entry); err != nil {
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(err).
+			Msg("error journaling the nar download")
+
+		return
+	}
+
+	c.backgroundWG.Add(1)
+
+	analytics.SafeGo(ctx, func() {
+		defer c.backgroundWG.Done()
+
+		ticker := time.NewTicker(downloadJournalCheckpointInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ds.done:
+				if err := os.Remove(c.downloadJournalPath(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+					zerolog.Ctx(ctx).
+						Warn().
+						Err(err).
+						Msg("error removing the nar download journal entry")
+				}
+
+				return
+			case <-ticker.C:
+				ds.mu.Lock()
+				bytesWritten := ds.bytesWritten
+				ds.mu.Unlock()
+
+				if bytesWritten == entry.BytesWritten {
+					continue
+				}
+
+				entry.BytesWritten = bytesWritten
+				entry.UpdatedAt = time.Now()
+
+				if err := c.writeDownloadJournalEntry(&entry); err != nil {
+					zerolog.Ctx(ctx).
+						Warn().
+						Err(err).
+						Msg("error checkpointing the nar download")
+				}
+			}
+		}
+	})
+}
+
+// RecoverInterruptedDownloads reconciles the downloads journaled by a previous
+// run of the process that did not finish: their temporary files are removed
+// along with their journal entries. With a distributed locker, the lock of
+// such a download outlives the process until its TTL expires, so until then
+// GetNar answers the NAR with a DownloadInterruptedError, for the client to
+// retry, instead of waiting for a download no one is making. It must be called
+// once at startup, after SetTempDir and before serving.
+func (c *Cache) RecoverInterruptedDownloads(ctx context.Context) error {
+	dir := filepath.Join(c.tempDirOrDefault(), downloadJournalDir)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("error reading the download journal: %w", err)
+	}
+
+	now := time.Now()
+
+	for _, de := range entries {
+		path := filepath.Join(dir, de.Name())
+
+		if !strings.HasSuffix(de.Name(), ".json") {
+			// A checkpoint interrupted before its rename.
+			_ = os.Remove(path)
+
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading the download journal entry %q: %w", path, err)
+		}
+
+		var entry downloadJournalEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			zerolog.Ctx(ctx).
+				Warn().
+				Err(err).
+				Str("path", path).
+				Msg("removing the unreadable download journal entry")
+
+			_ = os.Remove(path)
+
+			continue
+		}
+
+		for _, tempPath := range entry.TempPaths {
+			// Only the files of the temporary directory are ever journaled.
+			if filepath.Dir(tempPath) != c.tempDirOrDefault() {
+				continue
+			}
+
+			if err := os.Remove(tempPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("error removing the temporary file %q: %w", tempPath, err)
+			}
+		}
+
+		if expiresAt := entry.UpdatedAt.Add(c.downloadLockTTL); c.lockerIsDistributed && expiresAt.After(now) {
+			c.interruptedDownloadsMu.Lock()
+			if c.interruptedDownloads == nil {
+				c.interruptedDownloads = make(map[string]time.Time)
+			}
+
+			c.interruptedDownloads[entry.Key] = expiresAt
+			c.interruptedDownloadsMu.Unlock()
+		}
+
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("error removing the download journal entry %q: %w", path, err)
+		}
+
+		zerolog.Ctx(ctx).
+			Warn().
+			Str("nar_url", entry.NarURL).
+			Int64("bytes_written", entry.BytesWritten).
+			Time("started_at", entry.StartedAt).
+			Time("updated_at", entry.UpdatedAt).
+			Msg("recovered a nar download interrupted by a restart")
+	}
+
+	return nil
+}
+
+// interruptedDownloadError returns the DownloadInterruptedError of the job
+// key while the lock of its interrupted download may still be held, and nil
+// otherwise.
+func (c *Cache) interruptedDownloadError(key string) error {
+	c.interruptedDownloadsMu.Lock()
+	defer c.interruptedDownloadsMu.Unlock()
+
+	expiresAt, ok := c.interruptedDownloads[key]
+	if !ok {
+		return nil
+	}
+
+	retryAfter := time.Until(expiresAt)
+	if retryAfter <= 0 {
+		delete(c.interruptedDownloads, key)
+
+		return nil
+	}
+
+	return &DownloadInterruptedError{RetryAfter: retryAfter}
+}
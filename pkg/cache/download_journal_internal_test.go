@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+)
+
+func TestJournalDownload(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	require.NoError(t, c.SetTempDir(t.TempDir()))
+
+	narURL := nar.URL{Hash: "1s8p1kgdms8rmxkq24q51wc7zpn0aqcwgzvc473v9cii7z2qyxq0", Compression: nar.CompressionTypeXz}
+	key := c.narJobKey(narURL)
+
+	ds := newDownloadState()
+	ds.assetPath = filepath.Join(c.tempDir, "download.nar.xz")
+
+	c.journalDownload(newContext(), key, narURL.String(), ds)
+
+	readEntry := func() downloadJournalEntry {
+		data, err := os.ReadFile(c.downloadJournalPath(key))
+		require.NoError(t, err)
+
+		var entry downloadJournalEntry
+		require.NoError(t, json.Unmarshal(data, &entry))
+
+		return entry
+	}
+
+	entry := readEntry()
+	assert.Equal(t, key, entry.Key)
+	assert.Equal(t, narURL.String(), entry.NarURL)
+	assert.Equal(t, []string{ds.assetPath}, entry.TempPaths)
+	assert.Zero(t, entry.BytesWritten)
+
+	ds.mu.Lock()
+	ds.bytesWritten = 1024
+	ds.mu.Unlock()
+
+	assert.Eventually(t, func() bool {
+		return readEntry().BytesWritten == 1024
+	}, 5*downloadJournalCheckpointInterval, 100*time.Millisecond, "the progress should be checkpointed")
+
+	ds.doneOnce.Do(func() { close(ds.done) })
+	c.backgroundWG.Wait()
+
+	assert.NoFileExists(t, c.downloadJournalPath(key), "the entry should be removed once the download is done")
+}
+
+func TestRecoverInterruptedDownloads(t *testing.T) {
+	t.Parallel()
+
+	narURL := nar.URL{Hash: "1s8p1kgdms8rmxkq24q51wc7zpn0aqcwgzvc473v9cii7z2qyxq0", Compression: nar.CompressionTypeXz}
+
+	// journalInterruptedDownload leaves the journal entry and the temporary
+	// file of a download as a process dying during it does.
+	journalInterruptedDownload := func(t *testing.T, c *Cache, updatedAt time.Time) string {
+		t.Helper()
+
+		tempPath := filepath.Join(c.tempDir, "download.nar.xz")
+		require.NoError(t, os.WriteFile(tempPath, []byte("partial"), 0o600))
+
+		require.NoError(t, c.writeDownloadJournalEntry(&downloadJournalEntry{
+			Key:          c.narJobKey(narURL),
+			NarURL:       narURL.String(),
+			TempPaths:    []string{tempPath},
+			BytesWritten: 7,
+			StartedAt:    updatedAt,
+			UpdatedAt:    updatedAt,
+		}))
+
+		return tempPath
+	}
+
+	t.Run("distributed locker answers until the lock expires", func(t *testing.T) {
+		t.Parallel()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		require.NoError(t, c.SetTempDir(t.TempDir()))
+		c.SetInflightStaging(false, 0, 0, true)
+
+		tempPath := journalInterruptedDownload(t, c, time.Now())
+
+		require.NoError(t, c.RecoverInterruptedDownloads(newContext()))
+
+		assert.NoFileExists(t, tempPath, "the temporary file should be removed")
+		assert.NoFileExists(t, c.downloadJournalPath(c.narJobKey(narURL)), "the entry should be removed")
+
+		_, _, _, err := c.GetNar(newContext(), narURL)
+		require.ErrorIs(t, err, ErrDownloadInterrupted)
+
+		var interrupted *DownloadInterruptedError
+		require.ErrorAs(t, err, &interrupted)
+		assert.Positive(t, interrupted.RetryAfter)
+		assert.LessOrEqual(t, interrupted.RetryAfter, c.downloadLockTTL)
+
+		// Once the lock expired, the NAR is downloaded again.
+		c.interruptedDownloadsMu.Lock()
+		c.interruptedDownloads[c.narJobKey(narURL)] = time.Now().Add(-time.Second)
+		c.interruptedDownloadsMu.Unlock()
+
+		require.NoError(t, c.interruptedDownloadError(c.narJobKey(narURL)))
+	})
+
+	t.Run("expired lock is not waited for", func(t *testing.T) {
+		t.Parallel()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		require.NoError(t, c.SetTempDir(t.TempDir()))
+		c.SetInflightStaging(false, 0, 0, true)
+
+		tempPath := journalInterruptedDownload(t, c, time.Now().Add(-2*c.downloadLockTTL))
+
+		require.NoError(t, c.RecoverInterruptedDownloads(newContext()))
+
+		assert.NoFileExists(t, tempPath)
+		require.NoError(t, c.interruptedDownloadError(c.narJobKey(narURL)))
+	})
+
+	t.Run("local locker does not outlive the process", func(t *testing.T) {
+		t.Parallel()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		require.NoError(t, c.SetTempDir(t.TempDir()))
+
+		tempPath := journalInterruptedDownload(t, c, time.Now())
+
+		require.NoError(t, c.RecoverInterruptedDownloads(newContext()))
+
+		assert.NoFileExists(t, tempPath)
+		require.NoError(t, c.interruptedDownloadError(c.narJobKey(narURL)))
+	})
+
+	t.Run("no journal", func(t *testing.T) {
+		t.Parallel()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		require.NoError(t, c.SetTempDir(t.TempDir()))
+
+		require.NoError(t, c.RecoverInterruptedDownloads(newContext()))
+	})
+}
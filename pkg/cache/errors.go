@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+// The classes of the errors returned by GetNar, GetNarInfo, PutNar and
+// PutNarInfo. Such an error wraps one of them at most, ErrUpstreamUnavailable
+// wrapping ErrNotFound too, tested with errors.Is so that a caller, e.g. the
+// HTTP server, tells the failures apart without knowing the errors they come
+// from; an error wrapping none of them is an internal error. The errors returned for a request whose context is done
+// wrap the error of the context instead.
+var (
+	// ErrNotFound is returned when the object is neither cached nor found on
+	// an upstream. It is storage.ErrNotFound, which the cache always returned.
+	ErrNotFound = storage.ErrNotFound
+
+	// ErrUpstreamUnavailable is returned when the object is not cached while
+	// every configured upstream is unhealthy: it may exist upstream, and the
+	// request may succeed once an upstream recovers. The error wraps
+	// ErrNotFound too.
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+
+	// ErrStorageFull is returned when the object could not be stored because
+	// the storage is out of space or quota.
+	ErrStorageFull = errors.New("storage full")
+
+	// ErrIntegrity is returned when the content of a NAR does not match its
+	// hash or size, be it uploaded, downloaded from an upstream or read back
+	// from the chunks.
+	ErrIntegrity = errors.New("integrity error")
+)
+
+// classifyError wraps err, returned by GetNar, GetNarInfo, PutNar or
+// PutNarInfo, in its class of the error taxonomy when it does not wrap one
+// already.
+func (c *Cache) classifyError(ctx context.Context, err error) error {
+	switch {
+	case err == nil,
+		errors.Is(err, ErrUpstreamUnavailable),
+		errors.Is(err, ErrStorageFull),
+		errors.Is(err, ErrIntegrity):
+		return err
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return fmt.Errorf("%w: %w", ErrStorageFull, err)
+	case errors.Is(err, ErrNotFound), errors.Is(err, upstream.ErrNotFound):
+		if !errors.Is(err, ErrNotFound) {
+			err = fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+
+		// The uploads only look for the objects stored, regardless of the
+		// upstreams.
+		if !IsUploadOnly(ctx) && c.IsUpstreamUnreachable() {
+			return fmt.Errorf("%w: %w", ErrUpstreamUnavailable, err)
+		}
+
+		return err
+	default:
+		return err
+	}
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestClassifyError(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	t.Run("nil stays nil", func(t *testing.T) {
+		t.Parallel()
+
+		require.NoError(t, c.classifyError(newContext(), nil))
+	})
+
+	t.Run("a full disk is a full storage", func(t *testing.T) {
+		t.Parallel()
+
+		err := c.classifyError(newContext(), fmt.Errorf("error writing the nar: %w", syscall.ENOSPC))
+		require.ErrorIs(t, err, ErrStorageFull)
+		require.ErrorIs(t, err, syscall.ENOSPC)
+	})
+
+	t.Run("an upstream miss is not found", func(t *testing.T) {
+		t.Parallel()
+
+		err := c.classifyError(newContext(), upstream.ErrNotFound)
+		require.ErrorIs(t, err, ErrNotFound)
+		assert.NotErrorIs(t, err, ErrUpstreamUnavailable, "no upstream is configured")
+	})
+
+	t.Run("the mismatches are integrity errors", func(t *testing.T) {
+		t.Parallel()
+
+		for _, sentinel := range []error{ErrNarSizeMismatch, ErrNarHashMismatch} {
+			require.ErrorIs(t, c.classifyError(newContext(), sentinel), ErrIntegrity)
+		}
+	})
+
+	t.Run("other errors are left alone", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, io.ErrUnexpectedEOF, c.classifyError(newContext(), io.ErrUnexpectedEOF))
+	})
+}
+
+func TestClassifyErrorUpstreamUnavailable(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	ts := testdata.NewTestServer(t, 40)
+	ts.Close()
+
+	uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), nil)
+	require.NoError(t, err)
+
+	c.AddUpstreamCaches(newContext(), uc)
+
+	<-c.GetHealthChecker().Trigger()
+
+	require.True(t, c.IsUpstreamUnreachable())
+
+	_, err = c.GetNarInfo(newContext(), testdata.Nar1.NarInfoHash)
+	require.ErrorIs(t, err, ErrUpstreamUnavailable)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	_, err = c.GetNarInfo(WithUploadOnly(newContext()), testdata.Nar1.NarInfoHash)
+	require.ErrorIs(t, err, ErrNotFound)
+	assert.NotErrorIs(t, err, ErrUpstreamUnavailable, "the uploads ignore the upstreams")
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// evictionExclusionRegexPrefix marks an eviction exclusion as a regular
+// expression rather than a glob.
+const evictionExclusionRegexPrefix = "regex:"
+
+// ErrInvalidEvictionExclusion is returned by SetEvictionExclusions for a
+// pattern that does not compile.
+var ErrInvalidEvictionExclusion = errors.New("invalid eviction exclusion pattern")
+
+// evictionExclusion is a compiled eviction exclusion pattern: a glob matched
+// against the name of the store path, or a regular expression matched against
+// the whole store path.
+type evictionExclusion struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+func (e evictionExclusion) match(storePath string) bool {
+	if e.re != nil {
+		return e.re.MatchString(storePath)
+	}
+
+	// The pattern was validated by SetEvictionExclusions.
+	matched, _ := path.Match(e.glob, path.Base(storePath))
+
+	return matched
+}
+
+// SetEvictionExclusions configures the store path patterns the LRU never
+// evicts, complementing the pinned closures for bulk rules. A pattern is a
+// glob matched against the name of the store path, without /nix/store/ (e.g.
+// *-nixos-system-*), or, prefixed with "regex:", a regular expression matched
+// against the whole store path. Unlike a pinned closure, an excluded narinfo
+// does not protect its references.
+func (c *Cache) SetEvictionExclusions(patterns []string) error {
+	exclusions := make([]evictionExclusion, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		if expr, ok := strings.CutPrefix(pattern, evictionExclusionRegexPrefix); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("%w %q: %w", ErrInvalidEvictionExclusion, pattern, err)
+			}
+
+			exclusions = append(exclusions, evictionExclusion{re: re})
+
+			continue
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w %q: %w", ErrInvalidEvictionExclusion, pattern, err)
+		}
+
+		exclusions = append(exclusions, evictionExclusion{glob: pattern})
+	}
+
+	c.evictionExclusions = exclusions
+
+	return nil
+}
+
+// isEvictionExcluded returns whether the narinfo of storePath matches one of
+// the eviction exclusions. A narinfo without a store path matches none.
+func (c *Cache) isEvictionExcluded(storePath *string) bool {
+	if storePath == nil || *storePath == "" {
+		return false
+	}
+
+	for _, e := range c.evictionExclusions {
+		if e.match(*storePath) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+)
+
+func TestSetEvictionExclusions(t *testing.T) {
+	t.Parallel()
+
+	t.Run("invalid patterns are rejected", func(t *testing.T) {
+		t.Parallel()
+
+		var c Cache
+
+		require.ErrorIs(t, c.SetEvictionExclusions([]string{"[-"}), ErrInvalidEvictionExclusion)
+		require.ErrorIs(t, c.SetEvictionExclusions([]string{"regex:("}), ErrInvalidEvictionExclusion)
+	})
+
+	t.Run("globs match the name and regexes the store path", func(t *testing.T) {
+		t.Parallel()
+
+		var c Cache
+
+		require.NoError(t, c.SetEvictionExclusions([]string{
+			"*-nixos-system-*",
+			"regex:^/nix/store/[a-z0-9]+-gcc-[0-9.]+$",
+		}))
+
+		for storePath, excluded := range map[string]bool{
+			"/nix/store/1s8p1kgdms8rmxkq24q51wc7zpn0aqcw-nixos-system-host-24.11": true,
+			"/nix/store/1s8p1kgdms8rmxkq24q51wc7zpn0aqcw-gcc-13.2.0":              true,
+			"/nix/store/1s8p1kgdms8rmxkq24q51wc7zpn0aqcw-gcc-13.2.0-lib":          false,
+			"/nix/store/1s8p1kgdms8rmxkq24q51wc7zpn0aqcw-hello-2.12.1":            false,
+		} {
+			assert.Equal(t, excluded, c.isEvictionExcluded(&storePath), storePath)
+		}
+
+		assert.False(t, c.isEvictionExcluded(nil))
+	})
+}
+
+func TestRunLRUSkipsEvictionExclusions(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	ctx := newContext()
+	db := c.dbClient.Ent()
+
+	for _, n := range []struct{ hash, storePath string }{
+		{"nar-info-system", "/nix/store/1s8p1kgdms8rmxkq24q51wc7zpn0aqcw-nixos-system-host-24.11"},
+		{"nar-info-hello", "/nix/store/0mw6qwsrz35cck0wnjgmfnjzwnjbspsy-hello-2.12.1"},
+	} {
+		nf, err := db.NarFile.Create().
+			SetHash("nar-file-" + n.hash).
+			SetCompression("xz").
+			SetFileSize(100).
+			Save(ctx)
+		require.NoError(t, err)
+
+		ni, err := db.NarInfo.Create().SetHash(n.hash).SetStorePath(n.storePath).Save(ctx)
+		require.NoError(t, err)
+
+		_, err = db.NarInfoNarFile.Create().SetNarinfoID(ni.ID).SetNarFileID(nf.ID).Save(ctx)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, c.SetEvictionExclusions([]string{"*-nixos-system-*"}))
+
+	// Reclaim everything that can be reclaimed.
+	c.SetMaxSize(0)
+	c.runLRU(ctx)()
+
+	hashes, err := db.NarInfo.Query().Select(entnarinfo.FieldHash).Strings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nar-info-system"}, hashes)
+}
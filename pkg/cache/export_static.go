@@ -0,0 +1,217 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/rs/zerolog"
+
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+
+	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+const (
+	// exportStaticBatchSize is the number of narinfo hashes listed per query.
+	exportStaticBatchSize = 500
+
+	// exportStaticNixCacheInfo is the nix-cache-info of an exported cache. It
+	// matches the one served by ncps.
+	exportStaticNixCacheInfo = "StoreDir: /nix/store\nWantMassQuery: 1\nPriority: 10\n"
+)
+
+// ExportStaticStats summarizes an ExportStatic run.
+type ExportStaticStats struct {
+	// NarInfos is the number of narinfos exported.
+	NarInfos int
+
+	// Nars is the number of NAR files written; a NAR already in the export is
+	// not written again.
+	Nars int
+
+	// Skipped is the number of narinfos not exported because their NAR is not
+	// stored.
+	Skipped int
+}
+
+// ExportStatic writes the narinfos of the database and their NARs to dir as a
+// static binary cache: a nix-cache-info, a <hash>.narinfo per narinfo and its
+// NAR at the path of its URL, nar/<hash>.nar[.<compression>], as read by Nix
+// from any static file host. The export is deterministic: the same cache
+// content always produces the same files. Chunked NARs are exported
+// uncompressed, as they are served. NARs already present in dir are kept, so
+// re-running the export into the same directory only writes what changed.
+// Nothing is fetched from the upstreams.
+func (c *Cache) ExportStatic(ctx context.Context, dir string) (ExportStaticStats, error) {
+	var stats ExportStaticStats
+
+	if err := os.MkdirAll(filepath.Join(dir, "nar"), 0o755); err != nil {
+		return stats, fmt.Errorf("error creating the export directory: %w", err)
+	}
+
+	if err := writeExportFile(dir, "nix-cache-info", strings.NewReader(exportStaticNixCacheInfo)); err != nil {
+		return stats, err
+	}
+
+	// Never pull a missing NAR from an upstream.
+	ctx = WithUploadOnly(ctx)
+
+	lastHash := ""
+
+	for {
+		hashes, err := c.dbClient.Ent().NarInfo.Query().
+			Where(entnarinfo.HashGT(lastHash), entnarinfo.URLNotNil()).
+			Order(ent.Asc(entnarinfo.FieldHash)).
+			Limit(exportStaticBatchSize).
+			Select(entnarinfo.FieldHash).
+			Strings(ctx)
+		if err != nil {
+			return stats, fmt.Errorf("error listing the narinfos: %w", err)
+		}
+
+		for _, hash := range hashes {
+			if err := c.exportStaticNarInfo(ctx, dir, hash, &stats); err != nil {
+				return stats, fmt.Errorf("error exporting the narinfo %s: %w", hash, err)
+			}
+		}
+
+		if len(hashes) < exportStaticBatchSize {
+			return stats, nil
+		}
+
+		lastHash = hashes[len(hashes)-1]
+	}
+}
+
+func (c *Cache) exportStaticNarInfo(ctx context.Context, dir, hash string, stats *ExportStaticStats) error {
+	var (
+		ni     *narinfo.NarInfo
+		narURL *nar.URL
+	)
+
+	err := c.withEntTransaction(ctx, "exportStaticNarInfo", func(tx *ent.Tx) error {
+		var populateErr error
+
+		ni, narURL, populateErr = c.populateNarInfoFromDatabase(ctx, tx, hash, false)
+
+		return populateErr
+	})
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if narURL.Compression != nar.CompressionTypeNone {
+		c.maybeCDCNormalizeNarInfoURL(ctx, *narURL, ni)
+	}
+
+	// The NAR is served as described by the narinfo, possibly normalized to
+	// the uncompressed chunks above.
+	servedURL, err := nar.ParseURL(ni.URL)
+	if err != nil {
+		return fmt.Errorf("error parsing the nar URL %q: %w", ni.URL, err)
+	}
+
+	normalizedURL, err := servedURL.Normalize()
+	if err != nil {
+		return fmt.Errorf("error normalizing the nar URL %q: %w", ni.URL, err)
+	}
+
+	// A static file host serves no query and no opaque upstream path.
+	staticURL := nar.URL{Hash: normalizedURL.Hash, Compression: normalizedURL.Compression}
+
+	written, err := c.exportStaticNar(ctx, dir, servedURL, staticURL)
+	if errors.Is(err, storage.ErrNotFound) {
+		zerolog.Ctx(ctx).Warn().
+			Str("narinfo_hash", hash).
+			Str("nar_url", servedURL.String()).
+			Msg("skipping a narinfo whose NAR is not stored")
+
+		stats.Skipped++
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if written {
+		stats.Nars++
+	}
+
+	ni.URL = staticURL.String()
+
+	if err := writeExportFile(dir, hash+".narinfo", strings.NewReader(ni.String())); err != nil {
+		return err
+	}
+
+	stats.NarInfos++
+
+	return nil
+}
+
+// exportStaticNar writes the NAR of narURL to its static path in dir, unless
+// it is already there. It returns whether it was written.
+func (c *Cache) exportStaticNar(ctx context.Context, dir string, narURL, staticURL nar.URL) (bool, error) {
+	path := filepath.Join(dir, filepath.FromSlash(staticURL.String()))
+
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	}
+
+	_, _, r, err := c.GetNar(ctx, narURL)
+	if err != nil {
+		return false, err
+	}
+
+	defer r.Close()
+
+	if err := writeExportFile(filepath.Dir(path), filepath.Base(path), r); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// writeExportFile writes the content of r to the file name of dir through a
+// temporary file, so a reader of the export never sees a partial file.
+func writeExportFile(dir, name string, r io.Reader) error {
+	f, err := os.CreateTemp(dir, ".export-*")
+	if err != nil {
+		return fmt.Errorf("error creating a temporary file: %w", err)
+	}
+
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error closing %s: %w", name, err)
+	}
+
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return fmt.Errorf("error setting the mode of %s: %w", name, err)
+	}
+
+	if err := os.Rename(f.Name(), filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("error moving %s in place: %w", name, err)
+	}
+
+	return nil
+}
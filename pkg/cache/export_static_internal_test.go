@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestExportStatic(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, dir, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	ctx := newContext()
+
+	// Nar1 is fully stored; Nar2 has a narinfo but no NAR.
+	narURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: testdata.Nar1.NarCompression}
+	require.NoError(t, c.PutNar(ctx, narURL, io.NopCloser(strings.NewReader(testdata.Nar1.NarText))))
+	require.NoError(t, c.PutNarInfo(ctx, testdata.Nar1.NarInfoHash,
+		io.NopCloser(strings.NewReader(testdata.Nar1.NarInfoText))))
+	require.NoError(t, c.PutNarInfo(ctx, testdata.Nar2.NarInfoHash,
+		io.NopCloser(strings.NewReader(testdata.Nar2.NarInfoText))))
+
+	out := filepath.Join(dir, "export")
+
+	stats, err := c.ExportStatic(ctx, out)
+	require.NoError(t, err)
+	assert.Equal(t, ExportStaticStats{NarInfos: 1, Nars: 1, Skipped: 1}, stats)
+
+	cacheInfo, err := os.ReadFile(filepath.Join(out, "nix-cache-info"))
+	require.NoError(t, err)
+	assert.Contains(t, string(cacheInfo), "StoreDir: /nix/store")
+
+	narInfoText, err := os.ReadFile(filepath.Join(out, testdata.Nar1.NarInfoHash+".narinfo"))
+	require.NoError(t, err)
+
+	ni, err := narinfo.Parse(strings.NewReader(string(narInfoText)))
+	require.NoError(t, err)
+	assert.Equal(t, narURL.String(), ni.URL)
+	assert.NotEmpty(t, ni.Signatures)
+
+	narText, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(ni.URL)))
+	require.NoError(t, err)
+	assert.Equal(t, testdata.Nar1.NarText, string(narText))
+
+	assert.NoFileExists(t, filepath.Join(out, testdata.Nar2.NarInfoHash+".narinfo"))
+
+	// Exporting again produces the same files without writing the NAR again.
+	stats, err = c.ExportStatic(ctx, out)
+	require.NoError(t, err)
+	assert.Equal(t, ExportStaticStats{NarInfos: 1, Skipped: 1}, stats)
+
+	again, err := os.ReadFile(filepath.Join(out, testdata.Nar1.NarInfoHash+".narinfo"))
+	require.NoError(t, err)
+	assert.Equal(t, string(narInfoText), string(again))
+}
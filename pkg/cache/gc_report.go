@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kalbasit/ncps/ent"
+
+	entchunk "github.com/kalbasit/ncps/ent/chunk"
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+	entnarfilechunk "github.com/kalbasit/ncps/ent/narfilechunk"
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+)
+
+// gcReportBatchSize bounds the chunked nar_files whose chunk links are counted
+// at once by GCReport, keeping the IN predicate below the parameter limits of
+// the database engines.
+const gcReportBatchSize = 1000
+
+// GCReport is a dry run of a garbage collection of the database: the rows a
+// clean up, such as fsck --repair, would delete or repair.
+type GCReport struct {
+	// OrphanedNarInfos are the narinfos linked to no NAR file, by hash.
+	OrphanedNarInfos GCFinding
+
+	// OrphanedNarFiles are the NAR files linked to no narinfo, by hash.
+	OrphanedNarFiles GCFinding
+
+	// OrphanedChunks are the chunks linked to no NAR file, by hash.
+	OrphanedChunks GCFinding
+
+	// BrokenChunkLinks are the chunked NAR files whose chunk links do not
+	// number their chunks, by hash: a NAR that cannot be reassembled.
+	BrokenChunkLinks GCFinding
+}
+
+// GCFinding counts the rows of one kind of drift, with a sample of them.
+type GCFinding struct {
+	Count   int
+	Samples []string
+}
+
+// GCReport counts the orphaned and broken rows of the database, keeping up to
+// samples of each kind, without deleting anything. It only reads the
+// database: the objects of the storage not backed by a row are left to fsck.
+func (c *Cache) GCReport(ctx context.Context, samples int) (GCReport, error) {
+	db := c.dbClient.Ent()
+
+	var (
+		report GCReport
+		err    error
+	)
+
+	narInfos := db.NarInfo.Query().
+		Where(entnarinfo.Not(entnarinfo.HasNarInfoNarFiles()))
+
+	if report.OrphanedNarInfos.Count, err = narInfos.Clone().Count(ctx); err != nil {
+		return GCReport{}, fmt.Errorf("error counting the orphaned narinfos: %w", err)
+	}
+
+	if report.OrphanedNarInfos.Samples, err = narInfos.
+		Order(ent.Asc(entnarinfo.FieldID)).
+		Limit(samples).
+		Select(entnarinfo.FieldHash).
+		Strings(ctx); err != nil {
+		return GCReport{}, fmt.Errorf("error sampling the orphaned narinfos: %w", err)
+	}
+
+	narFiles := db.NarFile.Query().
+		Where(entnarfile.Not(entnarfile.HasNarInfoNarFiles()))
+
+	if report.OrphanedNarFiles.Count, err = narFiles.Clone().Count(ctx); err != nil {
+		return GCReport{}, fmt.Errorf("error counting the orphaned nar files: %w", err)
+	}
+
+	if report.OrphanedNarFiles.Samples, err = narFiles.
+		Order(ent.Asc(entnarfile.FieldID)).
+		Limit(samples).
+		Select(entnarfile.FieldHash).
+		Strings(ctx); err != nil {
+		return GCReport{}, fmt.Errorf("error sampling the orphaned nar files: %w", err)
+	}
+
+	chunks := db.Chunk.Query().
+		Where(entchunk.Not(entchunk.HasNarFileLinks()))
+
+	if report.OrphanedChunks.Count, err = chunks.Clone().Count(ctx); err != nil {
+		return GCReport{}, fmt.Errorf("error counting the orphaned chunks: %w", err)
+	}
+
+	if report.OrphanedChunks.Samples, err = chunks.
+		Order(ent.Asc(entchunk.FieldID)).
+		Limit(samples).
+		Select(entchunk.FieldHash).
+		Strings(ctx); err != nil {
+		return GCReport{}, fmt.Errorf("error sampling the orphaned chunks: %w", err)
+	}
+
+	if report.BrokenChunkLinks, err = c.brokenChunkLinks(ctx, samples); err != nil {
+		return GCReport{}, err
+	}
+
+	return report, nil
+}
+
+// brokenChunkLinks finds the chunked nar_files whose chunk links do not number
+// their total_chunks, walking them in batches of gcReportBatchSize.
+func (c *Cache) brokenChunkLinks(ctx context.Context, samples int) (GCFinding, error) {
+	db := c.dbClient.Ent()
+
+	finding := GCFinding{Samples: []string{}}
+
+	var lastID int
+
+	for {
+		page, err := db.NarFile.Query().
+			Where(
+				entnarfile.TotalChunksGT(0),
+				entnarfile.IDGT(lastID),
+			).
+			Order(ent.Asc(entnarfile.FieldID)).
+			Limit(gcReportBatchSize).
+			Select(entnarfile.FieldID, entnarfile.FieldHash, entnarfile.FieldTotalChunks).
+			All(ctx)
+		if err != nil {
+			return GCFinding{}, fmt.Errorf("error listing the chunked nar files: %w", err)
+		}
+
+		if len(page) == 0 {
+			return finding, nil
+		}
+
+		ids := make([]int, len(page))
+		for i, nf := range page {
+			ids[i] = nf.ID
+		}
+
+		var rows []struct {
+			NarFileID int   `sql:"nar_file_id"`
+			Count     int64 `sql:"count"`
+		}
+
+		if err := db.NarFileChunk.Query().
+			Where(entnarfilechunk.NarFileIDIn(ids...)).
+			GroupBy(entnarfilechunk.FieldNarFileID).
+			Aggregate(ent.Count()).
+			Scan(ctx, &rows); err != nil {
+			return GCFinding{}, fmt.Errorf("error counting the chunk links: %w", err)
+		}
+
+		links := make(map[int]int64, len(rows))
+		for _, row := range rows {
+			links[row.NarFileID] = row.Count
+		}
+
+		for _, nf := range page {
+			if links[nf.ID] == nf.TotalChunks {
+				continue
+			}
+
+			finding.Count++
+
+			if len(finding.Samples) < samples {
+				finding.Samples = append(finding.Samples, nf.Hash)
+			}
+		}
+
+		if len(page) < gcReportBatchSize {
+			return finding, nil
+		}
+
+		lastID = page[len(page)-1].ID
+	}
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGCReport(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	ctx := newContext()
+	db := c.dbClient.Ent()
+
+	// A healthy chunked NAR: a narinfo, its nar_file and its only chunk.
+	healthyNarFile, err := db.NarFile.Create().
+		SetHash("nar-file-healthy").
+		SetCompression("none").
+		SetFileSize(512).
+		SetTotalChunks(1).
+		Save(ctx)
+	require.NoError(t, err)
+
+	healthyNarInfo, err := db.NarInfo.Create().SetHash("nar-info-healthy").Save(ctx)
+	require.NoError(t, err)
+
+	_, err = db.NarInfoNarFile.Create().
+		SetNarinfoID(healthyNarInfo.ID).
+		SetNarFileID(healthyNarFile.ID).
+		Save(ctx)
+	require.NoError(t, err)
+
+	linkedChunk, err := db.Chunk.Create().SetHash("chunk-linked").SetSize(512).SetCompressedSize(256).Save(ctx)
+	require.NoError(t, err)
+
+	_, err = db.NarFileChunk.Create().
+		SetNarFileID(healthyNarFile.ID).
+		SetChunkID(linkedChunk.ID).
+		SetChunkIndex(0).
+		Save(ctx)
+	require.NoError(t, err)
+
+	// A chunked NAR missing one of its two chunk links.
+	brokenNarFile, err := db.NarFile.Create().
+		SetHash("nar-file-broken").
+		SetCompression("none").
+		SetFileSize(1024).
+		SetTotalChunks(2).
+		Save(ctx)
+	require.NoError(t, err)
+
+	brokenNarInfo, err := db.NarInfo.Create().SetHash("nar-info-broken").Save(ctx)
+	require.NoError(t, err)
+
+	_, err = db.NarInfoNarFile.Create().
+		SetNarinfoID(brokenNarInfo.ID).
+		SetNarFileID(brokenNarFile.ID).
+		Save(ctx)
+	require.NoError(t, err)
+
+	_, err = db.NarFileChunk.Create().
+		SetNarFileID(brokenNarFile.ID).
+		SetChunkID(linkedChunk.ID).
+		SetChunkIndex(0).
+		Save(ctx)
+	require.NoError(t, err)
+
+	// The orphans.
+	for _, hash := range []string{"nar-info-orphan-1", "nar-info-orphan-2"} {
+		_, err = db.NarInfo.Create().SetHash(hash).Save(ctx)
+		require.NoError(t, err)
+	}
+
+	_, err = db.NarFile.Create().SetHash("nar-file-orphan").SetCompression("xz").SetFileSize(50).Save(ctx)
+	require.NoError(t, err)
+
+	_, err = db.Chunk.Create().SetHash("chunk-orphan").SetSize(512).SetCompressedSize(256).Save(ctx)
+	require.NoError(t, err)
+
+	report, err := c.GCReport(ctx, 1)
+	require.NoError(t, err)
+
+	assert.Equal(t, GCFinding{Count: 2, Samples: []string{"nar-info-orphan-1"}}, report.OrphanedNarInfos)
+	assert.Equal(t, GCFinding{Count: 1, Samples: []string{"nar-file-orphan"}}, report.OrphanedNarFiles)
+	assert.Equal(t, GCFinding{Count: 1, Samples: []string{"chunk-orphan"}}, report.OrphanedChunks)
+	assert.Equal(t, GCFinding{Count: 1, Samples: []string{"nar-file-broken"}}, report.BrokenChunkLinks)
+
+	// Nothing was deleted.
+	narInfos, err := db.NarInfo.Query().Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, narInfos)
+}
@@ -0,0 +1,290 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kalbasit/ncps/pkg/lock"
+	"github.com/kalbasit/ncps/pkg/nar"
+)
+
+const (
+	// healthProbeTimeout bounds each probe of a health report.
+	healthProbeTimeout = 5 * time.Second
+
+	// healthProbeHash names the nar and the chunk the storage probes look up. It
+	// is never stored: the probes only tell a missing object from an error.
+	healthProbeHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+	backgroundJobCDCChunking    = "cdc-chunking"
+	backgroundJobChunkRepair    = "chunk-repair"
+	backgroundJobLegacyMigrator = "legacy-layout-migration"
+)
+
+// HealthStatus is the status of a component, or of the cache as a whole.
+type HealthStatus string
+
+const (
+	// HealthOK is the status of a working component.
+	HealthOK HealthStatus = "ok"
+
+	// HealthDegraded is the status of a component that works with failures,
+	// e.g. a cron job whose last run failed or one of several upstreams down.
+	HealthDegraded HealthStatus = "degraded"
+
+	// HealthDown is the status of a component that does not work.
+	HealthDown HealthStatus = "down"
+)
+
+//nolint:gochecknoglobals
+var healthSeverity = map[HealthStatus]int{HealthOK: 0, HealthDegraded: 1, HealthDown: 2}
+
+// ComponentKind is the kind of a component of a health report.
+type ComponentKind string
+
+// The kinds of the components of a health report.
+const (
+	ComponentDatabase      ComponentKind = "database"
+	ComponentStorage       ComponentKind = "storage"
+	ComponentUpstream      ComponentKind = "upstream"
+	ComponentLock          ComponentKind = "lock"
+	ComponentCronJob       ComponentKind = "cron"
+	ComponentBackgroundJob ComponentKind = "background"
+)
+
+// ComponentHealth is the health of a component of the cache.
+type ComponentHealth struct {
+	// Kind and Name identify the component, e.g. the upstream named after its
+	// hostname.
+	Kind ComponentKind
+	Name string
+
+	Status HealthStatus
+
+	// CheckedAt is when the component was last checked or ran, and Latency how
+	// long it took. They are zero for a component that never ran.
+	CheckedAt time.Time
+	Latency   time.Duration
+
+	// LastError is the last error of the component, and LastErrorAt its time.
+	// The error of an upstream, a cron job or a background job is kept once it
+	// recovers.
+	LastError   string
+	LastErrorAt time.Time
+}
+
+// HealthReport is the health of every component of the cache.
+type HealthReport struct {
+	// Status is the worst status of the components, except that the upstreams
+	// being down only degrade the cache until they all are.
+	Status HealthStatus
+
+	Components []ComponentHealth
+}
+
+// backgroundJobs tracks the outcome of the jobs the cache runs in the
+// background outside of the cron.
+type backgroundJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*ComponentHealth
+}
+
+// recordBackgroundJob records the outcome of a run of the background job
+// named name that started at start.
+func (c *Cache) recordBackgroundJob(name string, start time.Time, err error) {
+	c.backgroundJobs.mu.Lock()
+	defer c.backgroundJobs.mu.Unlock()
+
+	if c.backgroundJobs.jobs == nil {
+		c.backgroundJobs.jobs = make(map[string]*ComponentHealth)
+	}
+
+	job, ok := c.backgroundJobs.jobs[name]
+	if !ok {
+		job = &ComponentHealth{Kind: ComponentBackgroundJob, Name: name}
+		c.backgroundJobs.jobs[name] = job
+	}
+
+	job.CheckedAt = start
+	job.Latency = time.Since(start)
+	job.Status = HealthOK
+
+	if err != nil {
+		job.Status = HealthDegraded
+		job.LastError = err.Error()
+		job.LastErrorAt = start
+	}
+}
+
+// HealthReport checks the database, the storage backends and the lock
+// backend, and reports them along with the health of the upstreams, the cron
+// jobs and the background jobs.
+func (c *Cache) HealthReport(ctx context.Context) HealthReport {
+	var components []ComponentHealth
+
+	components = append(components, probeHealth(ctx, ComponentDatabase, "database", func(ctx context.Context) error {
+		return c.dbClient.DB().PingContext(ctx)
+	}))
+
+	components = append(components, probeHealth(ctx, ComponentStorage, "nar", func(ctx context.Context) error {
+		_, err := c.narStore.StatNar(ctx, nar.URL{Hash: healthProbeHash, Compression: nar.CompressionTypeNone})
+
+		return err
+	}))
+
+	if cs := c.getChunkStore(); cs != nil {
+		components = append(components, probeHealth(ctx, ComponentStorage, "chunk", func(ctx context.Context) error {
+			_, err := cs.HasChunk(ctx, healthProbeHash)
+
+			return err
+		}))
+	}
+
+	components = append(components, c.lockHealth(ctx))
+	components = append(components, c.upstreamsHealth()...)
+
+	for _, job := range c.CronJobs() {
+		components = append(components, cronJobHealth(job))
+	}
+
+	components = append(components, c.backgroundJobsHealth()...)
+
+	return HealthReport{Status: overallHealth(components), Components: components}
+}
+
+// probeHealth runs check as the health check of a component, which is down
+// when check fails.
+func probeHealth(
+	ctx context.Context,
+	kind ComponentKind,
+	name string,
+	check func(ctx context.Context) error,
+) ComponentHealth {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+
+	h := ComponentHealth{Kind: kind, Name: name, Status: HealthOK, CheckedAt: time.Now()}
+
+	err := check(ctx)
+
+	h.Latency = time.Since(h.CheckedAt)
+
+	if err != nil {
+		h.Status = HealthDown
+		h.LastError = err.Error()
+		h.LastErrorAt = h.CheckedAt
+	}
+
+	return h
+}
+
+// lockHealth pings the lock backends that can be pinged. The local locks are
+// always healthy.
+func (c *Cache) lockHealth(ctx context.Context) ComponentHealth {
+	return probeHealth(ctx, ComponentLock, "lock", func(ctx context.Context) error {
+		var errs []error
+
+		for _, l := range []any{c.downloadLocker, c.cacheLocker} {
+			if p, ok := l.(lock.Pinger); ok {
+				if err := p.Ping(ctx); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+
+		return errors.Join(errs...)
+	})
+}
+
+func (c *Cache) upstreamsHealth() []ComponentHealth {
+	c.upstreamCachesMu.RLock()
+	defer c.upstreamCachesMu.RUnlock()
+
+	components := make([]ComponentHealth, 0, len(c.upstreamCaches))
+
+	for _, u := range c.upstreamCaches {
+		h := ComponentHealth{Kind: ComponentUpstream, Name: u.GetHostname(), Status: HealthOK}
+
+		if !u.IsHealthy() {
+			h.Status = HealthDown
+		}
+
+		if c.healthChecker != nil {
+			if status, ok := c.healthChecker.Status(u); ok {
+				h.CheckedAt = status.CheckedAt
+				h.Latency = status.Latency
+				h.LastError = status.LastError
+				h.LastErrorAt = status.LastErrorAt
+			}
+		}
+
+		components = append(components, h)
+	}
+
+	return components
+}
+
+func (c *Cache) backgroundJobsHealth() []ComponentHealth {
+	c.backgroundJobs.mu.Lock()
+	defer c.backgroundJobs.mu.Unlock()
+
+	components := make([]ComponentHealth, 0, len(c.backgroundJobs.jobs))
+	for _, job := range c.backgroundJobs.jobs {
+		components = append(components, *job)
+	}
+
+	slices.SortFunc(components, func(a, b ComponentHealth) int { return strings.Compare(a.Name, b.Name) })
+
+	return components
+}
+
+func cronJobHealth(job CronJobStatus) ComponentHealth {
+	h := ComponentHealth{
+		Kind:      ComponentCronJob,
+		Name:      job.Name,
+		Status:    HealthOK,
+		CheckedAt: job.LastRun,
+		Latency:   job.LastDuration,
+	}
+
+	if job.LastError != "" {
+		h.Status = HealthDegraded
+		h.LastError = job.LastError
+		h.LastErrorAt = job.LastRun
+	}
+
+	return h
+}
+
+func overallHealth(components []ComponentHealth) HealthStatus {
+	status := HealthOK
+
+	var upstreams, upstreamsDown int
+
+	for _, h := range components {
+		s := h.Status
+
+		if h.Kind == ComponentUpstream {
+			upstreams++
+
+			if s == HealthDown {
+				upstreamsDown++
+				s = HealthDegraded
+			}
+		}
+
+		if healthSeverity[s] > healthSeverity[status] {
+			status = s
+		}
+	}
+
+	if upstreams > 0 && upstreamsDown == upstreams {
+		return HealthDown
+	}
+
+	return status
+}
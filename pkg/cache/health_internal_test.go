@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverallHealth(t *testing.T) {
+	t.Parallel()
+
+	ok := func(kind ComponentKind) ComponentHealth { return ComponentHealth{Kind: kind, Status: HealthOK} }
+	down := func(kind ComponentKind) ComponentHealth { return ComponentHealth{Kind: kind, Status: HealthDown} }
+
+	tests := []struct {
+		name       string
+		components []ComponentHealth
+		want       HealthStatus
+	}{
+		{"all ok", []ComponentHealth{ok(ComponentDatabase), ok(ComponentUpstream)}, HealthOK},
+		{"database down", []ComponentHealth{down(ComponentDatabase), ok(ComponentUpstream)}, HealthDown},
+		{
+			"a failed cron job",
+			[]ComponentHealth{ok(ComponentDatabase), {Kind: ComponentCronJob, Status: HealthDegraded}},
+			HealthDegraded,
+		},
+		{
+			"one of two upstreams down",
+			[]ComponentHealth{ok(ComponentDatabase), down(ComponentUpstream), ok(ComponentUpstream)},
+			HealthDegraded,
+		},
+		{
+			"every upstream down",
+			[]ComponentHealth{ok(ComponentDatabase), down(ComponentUpstream), down(ComponentUpstream)},
+			HealthDown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, overallHealth(tt.components))
+		})
+	}
+}
+
+func TestRecordBackgroundJob(t *testing.T) {
+	t.Parallel()
+
+	c := &Cache{}
+
+	start := time.Now()
+
+	c.recordBackgroundJob(backgroundJobChunkRepair, start, errTest)
+	c.recordBackgroundJob(backgroundJobCDCChunking, start, nil)
+
+	jobs := c.backgroundJobsHealth()
+	require.Len(t, jobs, 2)
+
+	assert.Equal(t, backgroundJobCDCChunking, jobs[0].Name)
+	assert.Equal(t, HealthOK, jobs[0].Status)
+
+	assert.Equal(t, backgroundJobChunkRepair, jobs[1].Name)
+	assert.Equal(t, HealthDegraded, jobs[1].Status)
+	assert.Equal(t, errTest.Error(), jobs[1].LastError)
+
+	// A successful run recovers the job and keeps its last error.
+	c.recordBackgroundJob(backgroundJobChunkRepair, time.Now(), nil)
+
+	jobs = c.backgroundJobsHealth()
+	assert.Equal(t, HealthOK, jobs[1].Status)
+	assert.Equal(t, errTest.Error(), jobs[1].LastError)
+	assert.Equal(t, start, jobs[1].LastErrorAt)
+}
@@ -2,6 +2,7 @@ package healthcheck
 
 import (
 	"context"
+	"slices"
 	"sync"
 	"time"
 
@@ -11,6 +12,9 @@ import (
 	"github.com/kalbasit/ncps/pkg/cache/upstream"
 )
 
+// Interval is the period of the health checks of the upstream caches.
+const Interval = time.Minute
+
 // HealthChecker is responsible for checking the health of upstream caches.
 type HealthChecker struct {
 	ticker  *time.Ticker
@@ -18,7 +22,23 @@ type HealthChecker struct {
 
 	mu                   sync.RWMutex
 	upstreams            []*upstream.Cache
+	statuses             map[*upstream.Cache]UpstreamStatus
 	healthChangeNotifier chan<- HealthStatusChange
+	paused               bool
+}
+
+// UpstreamStatus is the outcome of the health checks of an upstream cache.
+type UpstreamStatus struct {
+	// CheckedAt is the time of the last health check.
+	CheckedAt time.Time
+
+	// Latency is the duration of the last health check.
+	Latency time.Duration
+
+	// LastError is the error of the last failed health check, and LastErrorAt
+	// its time. They are kept once the upstream recovers.
+	LastError   string
+	LastErrorAt time.Time
 }
 
 // HealthStatusChange represents a change in upstream health status.
@@ -31,7 +51,8 @@ type HealthStatusChange struct {
 func New() *HealthChecker {
 	return &HealthChecker{
 		upstreams: []*upstream.Cache{},
-		ticker:    time.NewTicker(1 * time.Minute),
+		statuses:  make(map[*upstream.Cache]UpstreamStatus),
+		ticker:    time.NewTicker(Interval),
 		trigger:   make(chan chan struct{}),
 	}
 }
@@ -52,6 +73,15 @@ func (hc *HealthChecker) SetHealthChangeNotifier(ch chan<- HealthStatusChange) {
 	hc.healthChangeNotifier = ch
 }
 
+// SetPaused pauses or resumes the health checks. While paused, the upstreams
+// are not contacted and keep the health of their last check.
+func (hc *HealthChecker) SetPaused(paused bool) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	hc.paused = paused
+}
+
 // AddUpstream adds a new upstream cache to monitor.
 func (hc *HealthChecker) AddUpstreams(upstreams []*upstream.Cache) {
 	hc.mu.Lock()
@@ -60,20 +90,33 @@ func (hc *HealthChecker) AddUpstreams(upstreams []*upstream.Cache) {
 	hc.upstreams = append(hc.upstreams, upstreams...)
 }
 
-// RemoveUpstream removes an upstream cache from monitoring.
+// RemoveUpstream removes an upstream cache from monitoring. Upstreams are
+// matched by identity because several of them may share a hostname.
 func (hc *HealthChecker) RemoveUpstream(upstream *upstream.Cache) {
 	hc.mu.Lock()
 	defer hc.mu.Unlock()
 
 	for i, u := range hc.upstreams {
-		if u.GetHostname() == upstream.GetHostname() {
+		if u == upstream {
 			hc.upstreams = append(hc.upstreams[:i], hc.upstreams[i+1:]...)
+			delete(hc.statuses, u)
 
 			break
 		}
 	}
 }
 
+// Status returns the outcome of the health checks of u. It returns false until
+// u is checked.
+func (hc *HealthChecker) Status(u *upstream.Cache) (UpstreamStatus, bool) {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	status, ok := hc.statuses[u]
+
+	return status, ok
+}
+
 // Start starts the health checker.
 func (hc *HealthChecker) Start(ctx context.Context) {
 	analytics.SafeGo(ctx, func() {
@@ -101,12 +144,20 @@ func (hc *HealthChecker) check(ctx context.Context) {
 	upstreams := make([]*upstream.Cache, len(hc.upstreams))
 	copy(upstreams, hc.upstreams)
 	notifier := hc.healthChangeNotifier
+	paused := hc.paused
 	hc.mu.RUnlock()
 
+	if paused {
+		return
+	}
+
 	for _, u := range upstreams {
 		previouslyHealthy := u.IsHealthy()
 
+		start := time.Now()
 		priority, err := u.ParsePriority(ctx)
+		hc.recordStatus(u, start, err)
+
 		if err != nil {
 			u.SetHealthy(false)
 			zerolog.Ctx(ctx).Error().Err(err).Str("upstream", u.GetHostname()).Msg("upstream is not healthy")
@@ -135,3 +186,24 @@ func (hc *HealthChecker) check(ctx context.Context) {
 		}
 	}
 }
+
+func (hc *HealthChecker) recordStatus(u *upstream.Cache, start time.Time, err error) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	// u was removed while it was checked.
+	if !slices.Contains(hc.upstreams, u) {
+		return
+	}
+
+	status := hc.statuses[u]
+	status.CheckedAt = start
+	status.Latency = time.Since(start)
+
+	if err != nil {
+		status.LastError = err.Error()
+		status.LastErrorAt = start
+	}
+
+	hc.statuses[u] = status
+}
@@ -182,23 +182,25 @@ func (c *Cache) stagingOrphanAge() time.Duration {
 }
 
 // AddInflightStagingGCCronJob registers the periodic staging GC sweep. It binds
-// only the logger from ctx, not ctx itself: each sweep derives a fresh
-// shutdown-bound context, so a request/startup-scoped registration ctx being
-// cancelled can never silently disable later sweeps.
+// only the values of ctx (logger, cron job), not its cancellation: each sweep
+// derives a fresh shutdown-bound context, so a request/startup-scoped
+// registration ctx being cancelled can never silently disable later sweeps.
 func (c *Cache) AddInflightStagingGCCronJob(ctx context.Context, schedule cron.Schedule) {
-	log := zerolog.Ctx(ctx)
-
-	log.Info().
+	zerolog.Ctx(ctx).
+		Info().
 		Time("next-run", schedule.Next(time.Now())).
 		Msg("adding a cronjob for in-flight staging GC")
 
-	c.cron.Schedule(schedule, cron.FuncJob(c.runStagingGC(log)))
+	c.scheduleCronJob(ctx, CronJobStagingGC, schedule, c.runStagingGC)
 }
 
 // runStagingGC returns the cron job body for the periodic staging sweep. It
 // creates a fresh shutdown-bound context per run rather than closing over a
-// caller context that may later be cancelled.
-func (c *Cache) runStagingGC(log *zerolog.Logger) func() {
+// caller context that may later be cancelled; jobCtx is only used for its
+// logger and to report the outcome of the run.
+func (c *Cache) runStagingGC(jobCtx context.Context) func() {
+	log := zerolog.Ctx(jobCtx)
+
 	return func() {
 		if !c.InflightStagingEnabled() {
 			return
@@ -215,6 +217,8 @@ func (c *Cache) runStagingGC(log *zerolog.Logger) func() {
 
 			log.Warn().Err(err).Msg("in-flight staging GC sweep failed")
 
+			recordCronJobError(jobCtx, err)
+
 			return
 		}
 
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream/ipfs"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+const (
+	// Results recorded by ncps_upstream_ipfs_nar_fetches_total.
+	ipfsNarFetchHit   = "hit"
+	ipfsNarFetchMiss  = "miss"
+	ipfsNarFetchError = "error"
+)
+
+// SetIPFSGateways sets the IPFS gateways the NARs no upstream serves are
+// fetched from by their content hash, as a last resort. Nil disables it.
+func (c *Cache) SetIPFSGateways(gateways *ipfs.Gateways) { c.ipfsGateways = gateways }
+
+// expectedUpstreamNarDigest returns the sha256 digest of the NAR downloaded
+// from downloadURL as advertised by narInfo, or nil when it is not known or no
+// IPFS gateway is set. It must be called before narInfo is rewritten for
+// serving.
+func (c *Cache) expectedUpstreamNarDigest(downloadURL *nar.URL, narInfo *narinfo.NarInfo) []byte {
+	if c.ipfsGateways == nil || narInfo == nil {
+		return nil
+	}
+
+	var fileHash, narHash string
+
+	if narInfo.FileHash != nil {
+		fileHash = narInfo.FileHash.String()
+	}
+
+	if narInfo.NarHash != nil {
+		narHash = narInfo.NarHash.String()
+	}
+
+	return narContentDigest(downloadURL, narInfo.Compression, fileHash, narHash)
+}
+
+// narContentDigest returns the sha256 digest of the bytes of downloadURL
+// described by a narinfo: its NarHash for an uncompressed NAR, its FileHash
+// for one in the compression of the narinfo. It returns nil when the narinfo
+// does not describe them.
+func narContentDigest(downloadURL *nar.URL, compression, fileHash, narHash string) []byte {
+	contentHash := fileHash
+
+	switch {
+	case downloadURL.Compression == nar.CompressionTypeNone:
+		contentHash = narHash
+	case compression != downloadURL.Compression.String():
+		return nil
+	}
+
+	if contentHash == "" {
+		return nil
+	}
+
+	h, err := nixhash.ParseAny(contentHash, nil)
+	if err != nil || h.Algo() != nixhash.SHA256 {
+		return nil
+	}
+
+	return h.Digest()
+}
+
+// getNarFromIPFS fetches the NAR of downloadURL from the IPFS gateways by its
+// content hash and size, digest and size, or the ones of its narinfo in the
+// database when digest is nil. A non-positive size is not known.
+func (c *Cache) getNarFromIPFS(
+	ctx context.Context,
+	downloadURL *nar.URL,
+	digest []byte,
+	size int64,
+) (*http.Response, error) {
+	if c.ipfsGateways == nil || c.IsOffline() {
+		return nil, storage.ErrNotFound
+	}
+
+	if digest == nil {
+		ni, err := c.dbClient.Ent().NarInfo.Query().
+			Where(entnarinfo.URL(downloadURL.String())).
+			First(ctx)
+		if err != nil {
+			return nil, storage.ErrNotFound
+		}
+
+		digest = narContentDigest(downloadURL,
+			derefStringPtr(ni.Compression), derefStringPtr(ni.FileHash), derefStringPtr(ni.NarHash))
+		if digest == nil {
+			return nil, storage.ErrNotFound
+		}
+
+		size = derefInt64Ptr(ni.FileSize)
+		if downloadURL.Compression == nar.CompressionTypeNone {
+			size = derefInt64Ptr(ni.NarSize)
+		}
+	}
+
+	resp, err := c.ipfsGateways.GetNar(ctx, digest, size)
+
+	upstreamIPFSNarFetchesTotal.Add(ctx, 1,
+		metric.WithAttributes(attribute.String("result", ipfsNarFetchResult(err))))
+
+	if err != nil {
+		zerolog.Ctx(ctx).
+			Debug().
+			Err(err).
+			Msg("the nar is not served by the IPFS gateways either")
+
+		return nil, err
+	}
+
+	zerolog.Ctx(ctx).
+		Info().
+		Str("gateway", resp.Request.URL.Host).
+		Msg("fetching the nar no upstream serves from an IPFS gateway")
+
+	return resp, nil
+}
+
+// ipfsNarFetchResult returns the result recorded for a lookup on the IPFS
+// gateways returning err: a miss only when every gateway answered that it
+// does not have the NAR or it is too large to be looked up.
+func ipfsNarFetchResult(err error) string {
+	switch {
+	case err == nil:
+		return ipfsNarFetchHit
+	case errors.Is(err, ipfs.ErrNotFound), errors.Is(err, ipfs.ErrTooLarge):
+		return ipfsNarFetchMiss
+	default:
+		return ipfsNarFetchError
+	}
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/nixbase32"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream/ipfs"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+func TestNarContentDigest(t *testing.T) {
+	t.Parallel()
+
+	fileDigest := sha256.Sum256([]byte("compressed"))
+	narDigest := sha256.Sum256([]byte("uncompressed"))
+
+	fileHash := "sha256:" + nixbase32.EncodeToString(fileDigest[:])
+	narHash := "sha256:" + hex.EncodeToString(narDigest[:])
+
+	assert.Equal(t, fileDigest[:],
+		narContentDigest(&nar.URL{Compression: nar.CompressionTypeXz}, "xz", fileHash, narHash))
+	assert.Equal(t, narDigest[:],
+		narContentDigest(&nar.URL{Compression: nar.CompressionTypeNone}, "xz", fileHash, narHash),
+		"an uncompressed NAR is addressed by the NarHash")
+	assert.Nil(t, narContentDigest(&nar.URL{Compression: nar.CompressionTypeZstd}, "xz", fileHash, narHash),
+		"a NAR in another compression than the narinfo's is not addressed")
+	assert.Nil(t, narContentDigest(&nar.URL{Compression: nar.CompressionTypeXz}, "xz", "", narHash))
+	assert.Nil(t, narContentDigest(&nar.URL{Compression: nar.CompressionTypeXz}, "xz",
+		"sha1:"+hex.EncodeToString(fileDigest[:20]), narHash))
+}
+
+func TestGetNarFromIPFS(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("the content of a nar")
+	digest := sha256.Sum256(content)
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(content)
+	}))
+	t.Cleanup(gateway.Close)
+
+	gateways, err := ipfs.New([]string{gateway.URL})
+	require.NoError(t, err)
+
+	u := &nar.URL{Hash: "abc", Compression: nar.CompressionTypeXz}
+
+	t.Run("without gateways", func(t *testing.T) {
+		t.Parallel()
+
+		c := &Cache{}
+
+		_, err := c.getNarFromIPFS(newContext(), u, digest[:], int64(len(content)))
+		require.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("offline", func(t *testing.T) {
+		t.Parallel()
+
+		c := &Cache{}
+		c.SetIPFSGateways(gateways)
+		c.offline.Store(true)
+
+		_, err := c.getNarFromIPFS(newContext(), u, digest[:], int64(len(content)))
+		require.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("served by a gateway", func(t *testing.T) {
+		t.Parallel()
+
+		c := &Cache{}
+		c.SetIPFSGateways(gateways)
+
+		resp, err := c.getNarFromIPFS(newContext(), u, digest[:], int64(len(content)))
+		require.NoError(t, err)
+
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, content, body)
+		assert.Equal(t, ipfsNarFetchHit, ipfsNarFetchResult(err))
+	})
+
+	t.Run("not served by any gateway", func(t *testing.T) {
+		t.Parallel()
+
+		missing := httptest.NewServer(http.NotFoundHandler())
+		t.Cleanup(missing.Close)
+
+		missingGateways, err := ipfs.New([]string{missing.URL})
+		require.NoError(t, err)
+
+		c := &Cache{}
+		c.SetIPFSGateways(missingGateways)
+
+		_, err = c.getNarFromIPFS(newContext(), u, digest[:], int64(len(content)))
+		require.Error(t, err)
+		assert.Equal(t, ipfsNarFetchMiss, ipfsNarFetchResult(err))
+	})
+
+	t.Run("gateway error", func(t *testing.T) {
+		t.Parallel()
+
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		t.Cleanup(failing.Close)
+
+		failingGateways, err := ipfs.New([]string{failing.URL})
+		require.NoError(t, err)
+
+		c := &Cache{}
+		c.SetIPFSGateways(failingGateways)
+
+		_, err = c.getNarFromIPFS(newContext(), u, digest[:], int64(len(content)))
+		require.Error(t, err)
+		assert.Equal(t, ipfsNarFetchError, ipfsNarFetchResult(err), "a gateway 500 is counted as an error")
+	})
+}
@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// JobKind is the kind of a job reported by Jobs.
+type JobKind string
+
+const (
+	// JobKindDownload is the pull of a NAR from an upstream.
+	JobKindDownload JobKind = "download"
+
+	// JobKindChunking is the chunking of a NAR into the chunk store, whether
+	// pulled, uploaded or migrated.
+	JobKindChunking JobKind = "chunking"
+)
+
+// ErrJobNotFound is returned by Job for an unknown or finished job.
+var ErrJobNotFound = errors.New("job not found")
+
+// JobProgress is the progress of a job in flight.
+type JobProgress struct {
+	// ID identifies the job as <kind>-<nar hash>.
+	ID        string
+	Kind      JobKind
+	Hash      string
+	StartedAt time.Time
+
+	// BytesDone is the number of bytes downloaded, or chunked.
+	BytesDone int64
+	// BytesTotal is the number of bytes of the NAR, or zero while unknown.
+	BytesTotal int64
+	// ChunksDone is the number of chunks stored by a chunking job. The number
+	// of chunks of a NAR is only known once it is chunked.
+	ChunksDone int64
+}
+
+func jobID(kind JobKind, hash string) string { return string(kind) + "-" + hash }
+
+// chunkingProgress tracks the progress of a chunking job.
+type chunkingProgress struct {
+	startedAt  time.Time
+	bytesTotal int64
+
+	bytesDone  atomic.Int64
+	chunksDone atomic.Int64
+}
+
+// add records a chunk stored.
+func (p *chunkingProgress) add(size uint32) {
+	p.bytesDone.Add(int64(size))
+	p.chunksDone.Add(1)
+}
+
+// trackChunkingJob records a chunking job of the NAR with the given hash,
+// reported by Jobs until the returned function is called.
+func (c *Cache) trackChunkingJob(hash string, fileSize uint64) (*chunkingProgress, func()) {
+	p := &chunkingProgress{
+		startedAt:  time.Now(),
+		bytesTotal: int64(fileSize), //nolint:gosec // G115: NAR sizes fit in int64
+	}
+
+	c.chunkingJobsMu.Lock()
+	c.chunkingJobs[hash] = p
+	c.chunkingJobsMu.Unlock()
+
+	return p, func() {
+		c.chunkingJobsMu.Lock()
+		defer c.chunkingJobsMu.Unlock()
+
+		if c.chunkingJobs[hash] == p {
+			delete(c.chunkingJobs, hash)
+		}
+	}
+}
+
+// Jobs returns the progress of the NAR downloads and chunking jobs in flight
+// on this instance, ordered by start time.
+func (c *Cache) Jobs() []JobProgress {
+	var jobs []JobProgress
+
+	c.upstreamJobsMu.Lock()
+
+	for key, ds := range c.upstreamJobs {
+		rest, ok := strings.CutPrefix(key, narJobKeyPrefix)
+		if !ok {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(rest, ":")
+
+		jobs = append(jobs, ds.progress(hash))
+	}
+
+	c.upstreamJobsMu.Unlock()
+
+	c.chunkingJobsMu.Lock()
+
+	for hash, p := range c.chunkingJobs {
+		jobs = append(jobs, JobProgress{
+			ID:         jobID(JobKindChunking, hash),
+			Kind:       JobKindChunking,
+			Hash:       hash,
+			StartedAt:  p.startedAt,
+			BytesDone:  p.bytesDone.Load(),
+			BytesTotal: p.bytesTotal,
+			ChunksDone: p.chunksDone.Load(),
+		})
+	}
+
+	c.chunkingJobsMu.Unlock()
+
+	slices.SortFunc(jobs, func(a, b JobProgress) int {
+		if n := a.StartedAt.Compare(b.StartedAt); n != 0 {
+			return n
+		}
+
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	return jobs
+}
+
+// Job returns the progress of the job in flight with the given ID.
+func (c *Cache) Job(id string) (JobProgress, error) {
+	for _, job := range c.Jobs() {
+		if job.ID == id {
+			return job, nil
+		}
+	}
+
+	return JobProgress{}, fmt.Errorf("%w: %q", ErrJobNotFound, id)
+}
+
+// progress returns the progress of the download of the NAR with the given
+// hash.
+func (ds *downloadState) progress(hash string) JobProgress {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	total := ds.finalSize
+	if total == 0 {
+		total = ds.expectedSize
+	}
+
+	return JobProgress{
+		ID:         jobID(JobKindDownload, hash),
+		Kind:       JobKindDownload,
+		Hash:       hash,
+		StartedAt:  ds.startedAt,
+		BytesDone:  ds.bytesWritten,
+		BytesTotal: total,
+	}
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+)
+
+func TestJobs(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	assert.Empty(t, c.Jobs())
+
+	const (
+		downloadHash = "1s8p1kgdms8rmxkq24q51wc7zpn0aqcwgzvc473v9cii7z2qyxq0"
+		chunkingHash = "0amzzlz5w7ihknr59cn0q56pvp17bqqz0amzzlz5w7ihknr59cn0"
+	)
+
+	ds := newDownloadState()
+	ds.startedAt = time.Now().Add(-time.Minute)
+	ds.bytesWritten = 100
+	ds.expectedSize = 1000
+
+	c.upstreamJobsMu.Lock()
+	c.upstreamJobs[c.narJobKey(nar.URL{Hash: downloadHash, Compression: nar.CompressionTypeXz})] = ds
+	c.upstreamJobs[narInfoJobKey(downloadHash)] = newDownloadState()
+	c.upstreamJobsMu.Unlock()
+
+	progress, untrack := c.trackChunkingJob(chunkingHash, 4096)
+	progress.add(1024)
+	progress.add(2048)
+
+	jobs := c.Jobs()
+	require.Len(t, jobs, 2, "the narinfo downloads are not reported")
+
+	assert.Equal(t, JobProgress{
+		ID:         "download-" + downloadHash,
+		Kind:       JobKindDownload,
+		Hash:       downloadHash,
+		StartedAt:  ds.startedAt,
+		BytesDone:  100,
+		BytesTotal: 1000,
+	}, jobs[0])
+
+	job, err := c.Job("chunking-" + chunkingHash)
+	require.NoError(t, err)
+	assert.Equal(t, JobKindChunking, job.Kind)
+	assert.EqualValues(t, 3072, job.BytesDone)
+	assert.EqualValues(t, 4096, job.BytesTotal)
+	assert.EqualValues(t, 2, job.ChunksDone)
+
+	untrack()
+
+	_, err = c.Job("chunking-" + chunkingHash)
+	require.ErrorIs(t, err, ErrJobNotFound)
+}
@@ -0,0 +1,205 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+	entnarfilechunk "github.com/kalbasit/ncps/ent/narfilechunk"
+	entnarinfonarfile "github.com/kalbasit/ncps/ent/narinfonarfile"
+
+	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/pkg/database"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+// legacyNarFileBatchSize is the number of legacy nar_files rows listed per
+// query.
+const legacyNarFileBatchSize = 500
+
+// LegacyLayoutStats summarizes a MigrateLegacyLayout run.
+type LegacyLayoutStats struct {
+	// NarsMoved is the number of nar files moved to their current path.
+	NarsMoved int
+
+	// NarsRemoved is the number of legacy nar files removed because the nar
+	// was already stored at its current path.
+	NarsRemoved int
+
+	// NarFilesRenamed is the number of nar_files rows re-keyed by their
+	// normalized hash.
+	NarFilesRenamed int
+
+	// NarFilesMerged is the number of nar_files rows merged into the row of
+	// their normalized hash.
+	NarFilesMerged int
+}
+
+// Migrated returns whether anything was migrated.
+func (s LegacyLayoutStats) Migrated() bool { return s != LegacyLayoutStats{} }
+
+// MigrateLegacyLayout brings what older versions of ncps stored to the
+// current layout, without downloading anything again: the nar files stored
+// under a nix-serve-style prefixed hash (<narinfo-hash>-<hash>) or outside of
+// their shard are moved to their current path, and the nar_files rows keyed by
+// a prefixed hash are re-keyed by their normalized hash, or merged into the
+// row already keyed by it. It is safe to run while the cache is serving.
+func (c *Cache) MigrateLegacyLayout(ctx context.Context) (LegacyLayoutStats, error) {
+	start := time.Now()
+
+	stats, err := c.migrateLegacyLayout(ctx)
+
+	c.recordBackgroundJob(backgroundJobLegacyMigrator, start, err)
+
+	return stats, err
+}
+
+func (c *Cache) migrateLegacyLayout(ctx context.Context) (LegacyLayoutStats, error) {
+	var stats LegacyLayoutStats
+
+	if migrator, ok := c.narStore.(storage.LegacyNarMigrator); ok {
+		moved, removed, err := migrator.MigrateLegacyNars(ctx)
+
+		stats.NarsMoved, stats.NarsRemoved = moved, removed
+
+		if err != nil {
+			return stats, fmt.Errorf("error migrating the legacy nar files: %w", err)
+		}
+	}
+
+	lastID := 0
+
+	for {
+		nfs, err := c.dbClient.Ent().NarFile.Query().
+			Where(
+				entnarfile.IDGT(lastID),
+				entnarfile.Or(entnarfile.HashContains("-"), entnarfile.HashContains("_")),
+			).
+			Order(ent.Asc(entnarfile.FieldID)).
+			Limit(legacyNarFileBatchSize).
+			All(ctx)
+		if err != nil {
+			return stats, fmt.Errorf("error listing the legacy nar_files rows: %w", err)
+		}
+
+		for _, nf := range nfs {
+			if err := c.migrateLegacyNarFile(ctx, nf, &stats); err != nil {
+				return stats, fmt.Errorf("error migrating the nar_files row %d: %w", nf.ID, err)
+			}
+		}
+
+		if len(nfs) < legacyNarFileBatchSize {
+			return stats, nil
+		}
+
+		lastID = nfs[len(nfs)-1].ID
+	}
+}
+
+// migrateLegacyNarFile re-keys the nar_files row nf by its normalized hash.
+// When a row is already keyed by it, the row holding the nar's bytes is kept,
+// the narinfos of the other one are linked to it and the other one is deleted.
+func (c *Cache) migrateLegacyNarFile(ctx context.Context, nf *ent.NarFile, stats *LegacyLayoutStats) error {
+	if !strings.ContainsAny(nf.Hash, "-_") {
+		return nil
+	}
+
+	normalizedURL, err := nar.URL{Hash: nf.Hash}.Normalize()
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("hash", nf.Hash).Msg("skipping a nar_files row of an invalid hash")
+
+		return nil
+	}
+
+	hash := normalizedURL.Hash
+
+	var merged bool
+
+	err = c.withEntTransaction(ctx, "migrateLegacyNarFile", func(tx *ent.Tx) error {
+		merged = false
+
+		current, err := tx.NarFile.Query().
+			Where(
+				entnarfile.HashEQ(hash),
+				entnarfile.CompressionEQ(nf.Compression),
+				entnarfile.QueryEQ(nf.Query),
+			).
+			Only(ctx)
+		if ent.IsNotFound(err) {
+			if err := tx.NarFile.UpdateOneID(nf.ID).SetHash(hash).Exec(ctx); err != nil {
+				return fmt.Errorf("error re-keying the nar_files row: %w", err)
+			}
+
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("error querying the nar_files row of %s: %w", hash, err)
+		}
+
+		keep, drop := current, nf
+		if !narFileHasContent(current) && narFileHasContent(nf) {
+			keep, drop = nf, current
+		}
+
+		linked, err := tx.NarInfoNarFile.Query().
+			Where(entnarinfonarfile.NarFileIDEQ(keep.ID)).
+			Select(entnarinfonarfile.FieldNarinfoID).
+			Ints(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing the narinfos of the nar_files row: %w", err)
+		}
+
+		// The links of drop to narinfos already linked to keep are deleted with
+		// drop.
+		if _, err := tx.NarInfoNarFile.Update().
+			Where(
+				entnarinfonarfile.NarFileIDEQ(drop.ID),
+				entnarinfonarfile.NarinfoIDNotIn(linked...),
+			).
+			SetNarFileID(keep.ID).
+			Save(ctx); err != nil {
+			return fmt.Errorf("error relinking the narinfos of the nar_files row: %w", err)
+		}
+
+		if _, err := database.UnlinkNarFileChunks(ctx, tx, entnarfilechunk.NarFileIDEQ(drop.ID)); err != nil {
+			return fmt.Errorf("error unlinking the chunks of the merged nar_files row: %w", err)
+		}
+
+		if err := tx.NarFile.DeleteOneID(drop.ID).Exec(ctx); err != nil {
+			return fmt.Errorf("error deleting the merged nar_files row: %w", err)
+		}
+
+		if keep.Hash != hash {
+			if err := tx.NarFile.UpdateOneID(keep.ID).SetHash(hash).Exec(ctx); err != nil {
+				return fmt.Errorf("error re-keying the nar_files row: %w", err)
+			}
+		}
+
+		merged = true
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if merged {
+		stats.NarFilesMerged++
+	} else {
+		stats.NarFilesRenamed++
+	}
+
+	return nil
+}
+
+// narFileHasContent returns whether the bytes of the nar of nf are stored,
+// whole or chunked.
+func narFileHasContent(nf *ent.NarFile) bool {
+	return nf.BytesStoredAt != nil || nf.TotalChunks > 0
+}
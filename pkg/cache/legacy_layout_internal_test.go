@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+	entnarinfonarfile "github.com/kalbasit/ncps/ent/narinfonarfile"
+)
+
+func TestMigrateLegacyLayout(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	ctx := newContext()
+	db := c.dbClient.Ent()
+
+	const (
+		renamedHash = "1s8p1kgdms8rmxkq24q51wc7zpn0aqcwgzvc473v9cii7z2qyxq0"
+		mergedHash  = "0mw6qwsrz35cck0wnjgmfnjzwnjbspsyihnfkng38kxghdc9k9zd"
+		prefix      = "c12lxpykv921zk2ya1iaqp16n1cvqr0a-"
+	)
+
+	// A legacy row without a current one, re-keyed in place.
+	renamed, err := db.NarFile.Create().
+		SetHash(prefix + renamedHash).
+		SetCompression("xz").
+		SetFileSize(10).
+		Save(ctx)
+	require.NoError(t, err)
+
+	// A legacy row holding the bytes of a nar whose current row holds none:
+	// the legacy row is kept and takes the narinfos of both.
+	legacy, err := db.NarFile.Create().
+		SetHash(prefix + mergedHash).
+		SetCompression("xz").
+		SetFileSize(20).
+		SetBytesStoredAt(time.Now()).
+		Save(ctx)
+	require.NoError(t, err)
+
+	current, err := db.NarFile.Create().
+		SetHash(mergedHash).
+		SetCompression("xz").
+		SetFileSize(20).
+		Save(ctx)
+	require.NoError(t, err)
+
+	ni1, err := db.NarInfo.Create().SetHash("nar-info-1").Save(ctx)
+	require.NoError(t, err)
+	ni2, err := db.NarInfo.Create().SetHash("nar-info-2").Save(ctx)
+	require.NoError(t, err)
+
+	for _, link := range []struct{ narInfoID, narFileID int }{
+		{ni1.ID, legacy.ID},
+		{ni1.ID, current.ID},
+		{ni2.ID, current.ID},
+	} {
+		_, err := db.NarInfoNarFile.Create().
+			SetNarinfoID(link.narInfoID).
+			SetNarFileID(link.narFileID).
+			Save(ctx)
+		require.NoError(t, err)
+	}
+
+	stats, err := c.MigrateLegacyLayout(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, LegacyLayoutStats{NarFilesRenamed: 1, NarFilesMerged: 1}, stats)
+
+	nf, err := db.NarFile.Get(ctx, renamed.ID)
+	require.NoError(t, err)
+	assert.Equal(t, renamedHash, nf.Hash)
+
+	nf, err = db.NarFile.Query().Where(entnarfile.HashEQ(mergedHash)).Only(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, legacy.ID, nf.ID, "the row holding the bytes is kept")
+
+	narInfoIDs, err := db.NarInfoNarFile.Query().
+		Where(entnarinfonarfile.NarFileIDEQ(legacy.ID)).
+		Select(entnarinfonarfile.FieldNarinfoID).
+		Ints(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{ni1.ID, ni2.ID}, narInfoIDs)
+
+	count, err := db.NarFile.Query().Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// Once migrated, nothing is left to migrate.
+	stats, err = c.MigrateLegacyLayout(ctx)
+	require.NoError(t, err)
+	assert.False(t, stats.Migrated())
+}
+
+func TestMigrateLegacyLayout_UnlinksMergedChunks(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	ctx := newContext()
+	db := c.dbClient.Ent()
+
+	const hash = "0mw6qwsrz35cck0wnjgmfnjzwnjbspsyihnfkng38kxghdc9k9zd"
+
+	// Both rows hold the nar: the current row is kept and the chunked legacy
+	// row is dropped.
+	current := db.NarFile.Create().
+		SetHash(hash).
+		SetCompression("xz").
+		SetFileSize(20).
+		SetBytesStoredAt(time.Now()).
+		SaveX(ctx)
+
+	legacy := db.NarFile.Create().
+		SetHash("c12lxpykv921zk2ya1iaqp16n1cvqr0a-" + hash).
+		SetCompression("xz").
+		SetFileSize(20).
+		SetTotalChunks(1).
+		SaveX(ctx)
+
+	ch := db.Chunk.Create().SetHash("chunk-legacy").SetSize(20).SaveX(ctx)
+	db.NarFileChunk.Create().SetNarFileID(legacy.ID).SetChunkID(ch.ID).SetChunkIndex(0).SaveX(ctx)
+	db.Chunk.UpdateOneID(ch.ID).AddRefCount(1).ExecX(ctx)
+
+	stats, err := c.MigrateLegacyLayout(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, LegacyLayoutStats{NarFilesMerged: 1}, stats)
+
+	nf, err := db.NarFile.Query().Where(entnarfile.HashEQ(hash)).Only(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, current.ID, nf.ID)
+
+	ch, err = db.Chunk.Get(ctx, ch.ID)
+	require.NoError(t, err)
+	assert.Zero(t, ch.RefCount, "the chunks of the dropped row are no longer referenced")
+}
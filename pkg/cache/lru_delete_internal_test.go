@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunDeletions(t *testing.T) {
+	t.Parallel()
+
+	t.Run("bounds the deletions in flight", func(t *testing.T) {
+		t.Parallel()
+
+		var inFlight, maxInFlight, ran atomic.Int64
+
+		deletions := make([]func(), 100)
+		for i := range deletions {
+			deletions[i] = func() {
+				n := inFlight.Add(1)
+
+				for {
+					m := maxInFlight.Load()
+					if n <= m || maxInFlight.CompareAndSwap(m, n) {
+						break
+					}
+				}
+
+				time.Sleep(time.Millisecond)
+
+				inFlight.Add(-1)
+				ran.Add(1)
+			}
+		}
+
+		assert.Equal(t, 100, runDeletions(context.Background(), deletions, 4))
+		assert.Equal(t, int64(100), ran.Load())
+		assert.LessOrEqual(t, maxInFlight.Load(), int64(4))
+	})
+
+	t.Run("stops when canceled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var ran atomic.Int64
+
+		deletions := make([]func(), 100)
+		for i := range deletions {
+			deletions[i] = func() {
+				if ran.Add(1) == 10 {
+					cancel()
+				}
+			}
+		}
+
+		started := runDeletions(ctx, deletions, 1)
+
+		assert.Less(t, started, 100)
+		assert.Equal(t, int64(started), ran.Load())
+	})
+}
+
+func TestRunLRUEvictsAcrossBatches(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	ctx := newContext()
+	db := c.dbClient.Ent()
+
+	// More narinfos than fit in a single eviction transaction.
+	const count = 2*lruBatchSize + 50
+
+	for i := range count {
+		nf, err := db.NarFile.Create().
+			SetHash(fmt.Sprintf("nar-file-%d", i)).
+			SetCompression("xz").
+			SetFileSize(100).
+			Save(ctx)
+		require.NoError(t, err)
+
+		ni, err := db.NarInfo.Create().SetHash(fmt.Sprintf("nar-info-%d", i)).Save(ctx)
+		require.NoError(t, err)
+
+		_, err = db.NarInfoNarFile.Create().SetNarinfoID(ni.ID).SetNarFileID(nf.ID).Save(ctx)
+		require.NoError(t, err)
+	}
+
+	c.SetMaxSize(0)
+	c.runLRU(ctx)()
+
+	narInfos, err := db.NarInfo.Query().Count(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, narInfos)
+
+	narFiles, err := db.NarFile.Query().Count(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, narFiles)
+}
@@ -0,0 +1,284 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog"
+)
+
+// maintenanceWindowCheckInterval is how often the deferred cron jobs are
+// checked for an open maintenance window.
+const maintenanceWindowCheckInterval = time.Minute
+
+// ErrInvalidMaintenanceWindow is returned by ParseMaintenanceWindow for a
+// malformed window.
+var ErrInvalidMaintenanceWindow = errors.New("invalid maintenance window")
+
+//nolint:gochecknoglobals
+var weekdaysByName = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// MaintenanceWindow is a recurring period of the week during which the heavy
+// cron jobs may run.
+type MaintenanceWindow struct {
+	// Days are the weekdays the window opens on; every day when empty.
+	Days []time.Weekday
+
+	// Start and End are the times of day the window opens and closes at, as
+	// offsets from midnight. A window whose End is not after its Start closes
+	// on the next day.
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseMaintenanceWindow parses a window of the form "[DAYS ]HH:MM-HH:MM",
+// where DAYS is a comma-separated list of weekdays or ranges of weekdays, e.g.
+// "01:00-05:00", "Sat,Sun 00:00-24:00" or "Mon-Fri 22:00-06:00".
+func ParseMaintenanceWindow(s string) (MaintenanceWindow, error) {
+	var w MaintenanceWindow
+
+	fields := strings.Fields(s)
+
+	switch len(fields) {
+	case 1:
+	case 2:
+		days, err := parseWeekdays(fields[0])
+		if err != nil {
+			return MaintenanceWindow{}, fmt.Errorf("error parsing the maintenance window %q: %w", s, err)
+		}
+
+		w.Days = days
+	default:
+		return MaintenanceWindow{}, fmt.Errorf("%w %q: expected [DAYS ]HH:MM-HH:MM", ErrInvalidMaintenanceWindow, s)
+	}
+
+	startStr, endStr, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return MaintenanceWindow{}, fmt.Errorf("%w %q: expected HH:MM-HH:MM", ErrInvalidMaintenanceWindow, s)
+	}
+
+	var err error
+
+	if w.Start, err = parseTimeOfDay(startStr); err != nil {
+		return MaintenanceWindow{}, fmt.Errorf("error parsing the maintenance window %q: %w", s, err)
+	}
+
+	if w.End, err = parseTimeOfDay(endStr); err != nil {
+		return MaintenanceWindow{}, fmt.Errorf("error parsing the maintenance window %q: %w", s, err)
+	}
+
+	if w.Start == w.End {
+		return MaintenanceWindow{}, fmt.Errorf("%w %q: the window is empty", ErrInvalidMaintenanceWindow, s)
+	}
+
+	return w, nil
+}
+
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	var days []time.Weekday
+
+	for _, part := range strings.Split(s, ",") {
+		firstStr, lastStr, isRange := strings.Cut(part, "-")
+
+		first, ok := weekdaysByName[strings.ToLower(firstStr)]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown weekday %q", ErrInvalidMaintenanceWindow, firstStr)
+		}
+
+		last := first
+
+		if isRange {
+			if last, ok = weekdaysByName[strings.ToLower(lastStr)]; !ok {
+				return nil, fmt.Errorf("%w: unknown weekday %q", ErrInvalidMaintenanceWindow, lastStr)
+			}
+		}
+
+		// A range may wrap around the end of the week, e.g. Fri-Mon.
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+
+			if d == last {
+				break
+			}
+		}
+	}
+
+	return days, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	// time.Parse rejects 24:00, the end of a window closing at midnight.
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid time of day %q, expected HH:MM", ErrInvalidMaintenanceWindow, s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains returns whether t, in its own location, is within the window.
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	sinceMidnight := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+
+	if w.Start < w.End {
+		return w.opensOn(t.Weekday()) && sinceMidnight >= w.Start && sinceMidnight < w.End
+	}
+
+	// The window closes on the day after it opens.
+	return (w.opensOn(t.Weekday()) && sinceMidnight >= w.Start) ||
+		(w.opensOn((t.Weekday()+6)%7) && sinceMidnight < w.End)
+}
+
+func (w MaintenanceWindow) opensOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maintenanceWindows restricts cron jobs to maintenance windows.
+type maintenanceWindows struct {
+	windows []MaintenanceWindow
+	jobs    map[string]struct{}
+
+	resumerOnce sync.Once
+}
+
+// SetMaintenanceWindows restricts the cron jobs named by jobs to windows,
+// evaluated in the timezone of the cron. A scheduled run that falls outside
+// of every window is deferred to the next window instead of skipped, and a run
+// the windows close on pauses at its next checkpoint and resumes when a window
+// opens again. Runs triggered with TriggerCronJob ignore the windows. It must
+// be called before StartCron.
+func (c *Cache) SetMaintenanceWindows(windows []MaintenanceWindow, jobs []string) {
+	c.maintenanceWindows.windows = windows
+	c.maintenanceWindows.jobs = make(map[string]struct{}, len(jobs))
+
+	for _, job := range jobs {
+		c.maintenanceWindows.jobs[job] = struct{}{}
+	}
+}
+
+// outsideMaintenanceWindow returns whether the cron job named name must not
+// run at now.
+func (c *Cache) outsideMaintenanceWindow(name string, now time.Time) bool {
+	if len(c.maintenanceWindows.windows) == 0 {
+		return false
+	}
+
+	if _, ok := c.maintenanceWindows.jobs[name]; !ok {
+		return false
+	}
+
+	if c.cron != nil {
+		now = now.In(c.cron.Location())
+	}
+
+	for _, w := range c.maintenanceWindows.windows {
+		if w.Contains(now) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// maintenanceWindowClosed is a checkpoint of the heavy cron jobs: it returns
+// whether the scheduled run of the cron job of ctx must pause because its
+// maintenance windows closed. The job then resumes once a window opens again.
+// It always returns false outside of a cron job and during a triggered run.
+func (c *Cache) maintenanceWindowClosed(ctx context.Context) bool {
+	j, ok := ctx.Value(cronJobKey).(*cronJob)
+	if !ok || !c.outsideMaintenanceWindow(j.name, time.Now()) {
+		return false
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.triggered {
+		return false
+	}
+
+	j.deferred = true
+
+	zerolog.Ctx(ctx).
+		Info().
+		Str("cron_job", j.name).
+		Msg("the maintenance window closed, pausing the cron job until the next one")
+
+	return true
+}
+
+// scheduleMaintenanceWindowResumer registers the job running the deferred cron
+// jobs once a maintenance window opens.
+func (c *Cache) scheduleMaintenanceWindowResumer(ctx context.Context) {
+	if len(c.maintenanceWindows.windows) == 0 {
+		return
+	}
+
+	c.maintenanceWindows.resumerOnce.Do(func() {
+		c.cron.Schedule(cron.Every(maintenanceWindowCheckInterval), cron.FuncJob(func() {
+			c.resumeDeferredCronJobs(ctx)
+		}))
+	})
+}
+
+// resumeDeferredCronJobs starts the runs of the deferred cron jobs whose
+// maintenance window is open.
+func (c *Cache) resumeDeferredCronJobs(ctx context.Context) {
+	c.cronJobs.mu.Lock()
+	defer c.cronJobs.mu.Unlock()
+
+	if c.cronJobs.stopped {
+		return
+	}
+
+	now := time.Now()
+
+	for name, j := range c.cronJobs.jobs {
+		j.mu.Lock()
+		resume := j.deferred && !j.paused && !j.running
+		j.mu.Unlock()
+
+		if !resume || c.outsideMaintenanceWindow(name, now) {
+			continue
+		}
+
+		zerolog.Ctx(ctx).
+			Info().
+			Str("cron_job", name).
+			Msg("the maintenance window opened, resuming the deferred cron job")
+
+		c.cronJobs.wg.Go(func() {
+			// Lost to a run that started meanwhile, which clears deferred.
+			_ = j.execute(false)
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	t.Parallel()
+
+	for s, want := range map[string]MaintenanceWindow{
+		"01:00-05:00": {Start: time.Hour, End: 5 * time.Hour},
+		"Sat,Sun 00:00-24:00": {
+			Days:  []time.Weekday{time.Saturday, time.Sunday},
+			Start: 0,
+			End:   24 * time.Hour,
+		},
+		"mon-wed 22:30-06:00": {
+			Days:  []time.Weekday{time.Monday, time.Tuesday, time.Wednesday},
+			Start: 22*time.Hour + 30*time.Minute,
+			End:   6 * time.Hour,
+		},
+		"Fri-Mon,Wed 01:00-02:00": {
+			Days:  []time.Weekday{time.Friday, time.Saturday, time.Sunday, time.Monday, time.Wednesday},
+			Start: time.Hour,
+			End:   2 * time.Hour,
+		},
+	} {
+		w, err := ParseMaintenanceWindow(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, w, s)
+	}
+
+	for _, s := range []string{
+		"",
+		"01:00",
+		"25:00-26:00",
+		"01:00-01:00",
+		"Funday 01:00-02:00",
+		"Mon 01:00-02:00 extra",
+	} {
+		_, err := ParseMaintenanceWindow(s)
+		require.ErrorIs(t, err, ErrInvalidMaintenanceWindow, s)
+	}
+}
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	t.Parallel()
+
+	// 2026-01-05 is a Monday.
+	at := func(day int, hour, minute int) time.Time {
+		return time.Date(2026, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	w, err := ParseMaintenanceWindow("Mon-Fri 22:00-06:00")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{at(5, 21, 59), false},
+		{at(5, 22, 0), true},
+		{at(6, 5, 59), true},
+		{at(6, 6, 0), false},
+		// Opened on Friday, still open Saturday morning.
+		{at(10, 3, 0), true},
+		// Not opened on Saturday.
+		{at(10, 23, 0), false},
+		{at(11, 3, 0), false},
+		// Sunday opens no window, so Monday morning is closed.
+		{at(12, 3, 0), false},
+	} {
+		assert.Equal(t, tc.want, w.Contains(tc.t), tc.t.String())
+	}
+}
+
+func TestMaintenanceWindowCronJobs(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	open := []MaintenanceWindow{{Start: 0, End: 24 * time.Hour}}
+	// A window three days away is neither open today nor carried over from
+	// yesterday.
+	closed := []MaintenanceWindow{{Days: []time.Weekday{(now.Weekday() + 3) % 7}, Start: 0, End: 24 * time.Hour}}
+
+	c := &Cache{cron: cron.New()}
+
+	var (
+		runs   atomic.Int64
+		paused atomic.Bool
+	)
+
+	c.scheduleCronJob(newContext(), CronJobLRU, cron.Every(time.Hour), func(ctx context.Context) func() {
+		return func() {
+			runs.Add(1)
+			paused.Store(c.maintenanceWindowClosed(ctx))
+		}
+	})
+
+	scheduledRun := func() {
+		j, err := c.lookupCronJob(CronJobLRU)
+		require.NoError(t, err)
+
+		c.cron.Entry(j.entryID).Job.Run()
+	}
+
+	waitRuns := func(t *testing.T, n int64) CronJobStatus {
+		t.Helper()
+
+		var status CronJobStatus
+
+		require.Eventually(t, func() bool {
+			var err error
+
+			status, err = c.CronJob(CronJobLRU)
+			require.NoError(t, err)
+
+			return runs.Load() == n && !status.Running
+		}, 5*time.Second, 10*time.Millisecond)
+
+		return status
+	}
+
+	// Outside of the windows, the scheduled run is deferred.
+	c.SetMaintenanceWindows(closed, []string{CronJobLRU})
+	scheduledRun()
+
+	status := waitRuns(t, 0)
+	assert.True(t, status.Deferred)
+
+	// The deferred run waits for a window to open.
+	c.resumeDeferredCronJobs(newContext())
+	status = waitRuns(t, 0)
+	assert.True(t, status.Deferred)
+
+	// Once one opens, it runs.
+	c.SetMaintenanceWindows(open, []string{CronJobLRU})
+	c.resumeDeferredCronJobs(newContext())
+
+	status = waitRuns(t, 1)
+	assert.False(t, status.Deferred)
+	assert.False(t, paused.Load())
+
+	// A run the window closed on pauses at its checkpoint and is deferred.
+	c.SetMaintenanceWindows(closed, []string{CronJobLRU})
+	require.NoError(t, c.cronJobs.jobs[CronJobLRU].execute(false))
+
+	status = waitRuns(t, 2)
+	assert.True(t, paused.Load())
+	assert.True(t, status.Deferred)
+
+	// A triggered run ignores the windows.
+	require.NoError(t, c.TriggerCronJob(newContext(), CronJobLRU))
+
+	status = waitRuns(t, 3)
+	assert.False(t, paused.Load())
+	assert.False(t, status.Deferred)
+
+	// Jobs that are not restricted always run.
+	c.SetMaintenanceWindows(closed, []string{CronJobCDCLazyRecovery})
+	scheduledRun()
+
+	status = waitRuns(t, 4)
+	assert.False(t, status.Deferred)
+}
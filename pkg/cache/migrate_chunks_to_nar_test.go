@@ -344,7 +344,7 @@ func TestMigrateChunksToNar_DoesNotRewriteDifferentQueryVariant(t *testing.T) {
 	// A separate, unlinked narinfo for the SAME nar hash but a DIFFERENT query.
 	const otherNarInfoHash = "0123456789abcdfghijklmnpqrsvwxyz"
 
-	otherURL := "nar/" + noneURL.Hash + ".nar.xz?foo=bar"
+	otherURL := "nar/" + noneURL.Hash + ".nar.xz?hash=0123456789abcdfghijklmnpqrsvwxyz"
 
 	sum := sha256.Sum256([]byte("other-variant"))
 	otherNarHash := nixhash.MustNewHashWithEncoding(nixhash.SHA256, sum[:], nixhash.NixBase32, true).String()
@@ -616,6 +616,8 @@ func TestPurgeChunkedNar_RetainsSharedChunk(t *testing.T) {
 			SetChunkIndex(l.ChunkIndex).
 			Save(ctx)
 		require.NoError(t, err)
+
+		require.NoError(t, dbClient.Ent().Chunk.UpdateOneID(l.ChunkID).AddRefCount(1).Exec(ctx))
 	}
 
 	chunksBefore, err := dbClient.Ent().Chunk.Query().Count(ctx)
@@ -702,6 +704,8 @@ func TestMigrateChunksToNar_ForceReclaimRetainsSharedChunks(t *testing.T) {
 			SetChunkIndex(l.ChunkIndex).
 			Save(ctx)
 		require.NoError(t, err)
+
+		require.NoError(t, dbClient.Ent().Chunk.UpdateOneID(l.ChunkID).AddRefCount(1).Exec(ctx))
 	}
 
 	before, err := dbClient.Ent().Chunk.Query().Count(ctx)
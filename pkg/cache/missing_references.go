@@ -0,0 +1,320 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+
+	"github.com/kalbasit/ncps/pkg/analytics"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+// MissingReferencesPolicy selects how a narinfo is served when the narinfos
+// of some of its References are not cached.
+type MissingReferencesPolicy string
+
+const (
+	// MissingReferencesIgnore serves the narinfo as is. It is the default.
+	MissingReferencesIgnore MissingReferencesPolicy = "ignore"
+
+	// MissingReferencesAnnotate serves the narinfo as is and counts its
+	// missing references in ncps_narinfo_missing_references_total.
+	MissingReferencesAnnotate MissingReferencesPolicy = "annotate"
+
+	// MissingReferencesPrefetch also pulls the missing references, and their
+	// NARs, into the cache in the background, so the closure ends up cached.
+	MissingReferencesPrefetch MissingReferencesPolicy = "prefetch"
+
+	// MissingReferencesStrict pulls the missing references too but delays the
+	// response until their narinfos are cached, or the wait timeout is
+	// elapsed.
+	MissingReferencesStrict MissingReferencesPolicy = "strict"
+)
+
+const (
+	// defaultMissingReferencesConcurrency bounds the background pulls of the
+	// missing references when SetMissingReferencesPolicy is given a
+	// non-positive concurrency.
+	defaultMissingReferencesConcurrency = 8
+
+	// defaultMissingReferencesWait bounds the wait of the strict policy when
+	// SetMissingReferencesPolicy is given a non-positive wait.
+	defaultMissingReferencesWait = 30 * time.Second
+
+	// Results recorded by ncps_narinfo_reference_pulls_total.
+	referencePullResultCached   = "cached"
+	referencePullResultNotFound = "not_found"
+	referencePullResultError    = "error"
+	referencePullResultDropped  = "dropped"
+)
+
+// ErrUnknownMissingReferencesPolicy is returned by
+// ParseMissingReferencesPolicy for an unknown policy.
+var ErrUnknownMissingReferencesPolicy = errors.New(
+	"unknown missing references policy (allowed: ignore, annotate, prefetch, strict)",
+)
+
+// ParseMissingReferencesPolicy parses the name of a MissingReferencesPolicy.
+// The empty string is the default policy.
+func ParseMissingReferencesPolicy(s string) (MissingReferencesPolicy, error) {
+	switch MissingReferencesPolicy(s) {
+	case "", MissingReferencesIgnore:
+		return MissingReferencesIgnore, nil
+	case MissingReferencesAnnotate:
+		return MissingReferencesAnnotate, nil
+	case MissingReferencesPrefetch:
+		return MissingReferencesPrefetch, nil
+	case MissingReferencesStrict:
+		return MissingReferencesStrict, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownMissingReferencesPolicy, s)
+	}
+}
+
+type referencePullKey struct{}
+
+// missingReferences configures the handling of the narinfos served with
+// missing references. See SetMissingReferencesPolicy.
+type missingReferences struct {
+	policy MissingReferencesPolicy
+	wait   time.Duration
+
+	// slots bounds the background pulls running at once. A background pull
+	// that finds no free slot is dropped rather than queued; the strict
+	// policy pulls regardless.
+	slots chan struct{}
+
+	mu       sync.Mutex
+	inflight map[string]chan struct{}
+}
+
+// SetMissingReferencesPolicy sets how a narinfo is served when the narinfos
+// of some of its References are not cached. The prefetch and strict policies
+// pull the missing references from the upstreams, up to concurrency at once
+// in the background; the strict policy waits at most wait for them before
+// responding. The references pulled apply the policy to their own
+// references, without waiting, so the whole closure ends up cached.
+func (c *Cache) SetMissingReferencesPolicy(policy MissingReferencesPolicy, concurrency int, wait time.Duration) {
+	if policy == "" || policy == MissingReferencesIgnore {
+		c.missingReferences = nil
+
+		return
+	}
+
+	if concurrency <= 0 {
+		concurrency = defaultMissingReferencesConcurrency
+	}
+
+	if wait <= 0 {
+		wait = defaultMissingReferencesWait
+	}
+
+	c.missingReferences = &missingReferences{
+		policy:   policy,
+		wait:     wait,
+		slots:    make(chan struct{}, concurrency),
+		inflight: make(map[string]chan struct{}),
+	}
+}
+
+// handleMissingReferences applies the missing references policy to the
+// narinfo of hash about to be served.
+func (c *Cache) handleMissingReferences(ctx context.Context, hash string, narInfo *narinfo.NarInfo) {
+	mr := c.missingReferences
+	if mr == nil || narInfo == nil || IsUploadOnly(ctx) {
+		return
+	}
+
+	missing, err := c.findMissingReferences(ctx, hash, narInfo)
+	if err != nil {
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(err).
+			Msg("error looking up the references of the narinfo")
+
+		return
+	}
+
+	if len(missing) == 0 {
+		return
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("missing_references", len(missing)))
+
+	if missingReferencesTotal != nil {
+		missingReferencesTotal.Add(ctx, int64(len(missing)),
+			metric.WithAttributes(attribute.String("policy", string(mr.policy))))
+	}
+
+	zerolog.Ctx(ctx).
+		Debug().
+		Int("missing_references", len(missing)).
+		Str("policy", string(mr.policy)).
+		Msg("serving a narinfo with references missing from the cache")
+
+	switch mr.policy {
+	case MissingReferencesPrefetch:
+		for _, ref := range missing {
+			c.pullReference(ctx, mr, ref, false)
+		}
+	case MissingReferencesStrict:
+		// A reference pulled for a strict narinfo does not wait on its own
+		// references, or the wait would span the whole closure.
+		if ctx.Value(referencePullKey{}) != nil {
+			for _, ref := range missing {
+				c.pullReference(ctx, mr, ref, false)
+			}
+
+			return
+		}
+
+		c.waitForReferences(ctx, mr, missing)
+	case MissingReferencesIgnore, MissingReferencesAnnotate:
+	}
+}
+
+// findMissingReferences returns the hashes of the references of narInfo, but
+// hash itself, whose narinfos are not cached.
+func (c *Cache) findMissingReferences(ctx context.Context, hash string, narInfo *narinfo.NarInfo) ([]string, error) {
+	refs := slices.DeleteFunc(referenceHashes(narInfo.References), func(ref string) bool { return ref == hash })
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	cached, err := c.dbClient.Ent().NarInfo.Query().
+		Where(entnarinfo.HashIn(refs...)).
+		Select(entnarinfo.FieldHash).
+		Strings(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error querying the cached references: %w", err)
+	}
+
+	return slices.DeleteFunc(refs, func(ref string) bool { return slices.Contains(cached, ref) }), nil
+}
+
+// waitForReferences pulls the missing references and waits until they are
+// cached, the wait of the policy is elapsed or ctx is done.
+func (c *Cache) waitForReferences(ctx context.Context, mr *missingReferences, missing []string) {
+	dones := make([]<-chan struct{}, 0, len(missing))
+	for _, ref := range missing {
+		dones = append(dones, c.pullReference(ctx, mr, ref, true))
+	}
+
+	timer := time.NewTimer(mr.wait)
+	defer timer.Stop()
+
+	for _, done := range dones {
+		select {
+		case <-done:
+		case <-timer.C:
+			zerolog.Ctx(ctx).
+				Warn().
+				Dur("wait", mr.wait).
+				Msg("serving the narinfo before all its references are cached")
+
+			if referenceWaitTimeoutsTotal != nil {
+				referenceWaitTimeoutsTotal.Add(ctx, 1)
+			}
+
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pullReference pulls the narinfo of ref, and its NAR, into the cache in the
+// background. It returns a channel closed once the pull is done, or nil if
+// the pull was dropped. A background pull is dropped when no slot is free
+// unless force is set.
+func (c *Cache) pullReference(ctx context.Context, mr *missingReferences, ref string, force bool) <-chan struct{} {
+	mr.mu.Lock()
+	if done, ok := mr.inflight[ref]; ok {
+		mr.mu.Unlock()
+
+		return done
+	}
+
+	acquired := false
+
+	select {
+	case mr.slots <- struct{}{}:
+		acquired = true
+	default:
+		if !force {
+			mr.mu.Unlock()
+			recordReferencePull(ctx, referencePullResultDropped)
+
+			return nil
+		}
+	}
+
+	done := make(chan struct{})
+	mr.inflight[ref] = done
+	mr.mu.Unlock()
+
+	// The pull outlives the request that triggered it.
+	detachedCtx := context.WithValue(context.WithoutCancel(ctx), referencePullKey{}, struct{}{})
+
+	c.backgroundWG.Add(1)
+
+	analytics.SafeGo(detachedCtx, func() {
+		defer c.backgroundWG.Done()
+
+		defer func() {
+			if acquired {
+				<-mr.slots
+			}
+
+			mr.mu.Lock()
+			delete(mr.inflight, ref)
+			mr.mu.Unlock()
+
+			close(done)
+		}()
+
+		select {
+		case <-c.shutdownCh:
+			return
+		default:
+		}
+
+		_, err := c.GetNarInfo(detachedCtx, ref)
+
+		switch {
+		case err == nil:
+			recordReferencePull(detachedCtx, referencePullResultCached)
+		case errors.Is(err, storage.ErrNotFound):
+			recordReferencePull(detachedCtx, referencePullResultNotFound)
+		default:
+			zerolog.Ctx(detachedCtx).
+				Debug().
+				Err(err).
+				Str("reference_hash", ref).
+				Msg("error pulling a missing reference")
+
+			recordReferencePull(detachedCtx, referencePullResultError)
+		}
+	})
+
+	return done
+}
+
+func recordReferencePull(ctx context.Context, result string) {
+	if referencePullsTotal == nil {
+		return
+	}
+
+	referencePullsTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+}
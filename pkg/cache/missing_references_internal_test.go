@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestParseMissingReferencesPolicy(t *testing.T) {
+	t.Parallel()
+
+	for s, want := range map[string]MissingReferencesPolicy{
+		"":         MissingReferencesIgnore,
+		"ignore":   MissingReferencesIgnore,
+		"annotate": MissingReferencesAnnotate,
+		"prefetch": MissingReferencesPrefetch,
+		"strict":   MissingReferencesStrict,
+	} {
+		got, err := ParseMissingReferencesPolicy(s)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := ParseMissingReferencesPolicy("block")
+	require.ErrorIs(t, err, ErrUnknownMissingReferencesPolicy)
+}
+
+func TestMissingReferences(t *testing.T) {
+	t.Parallel()
+
+	served := &narinfo.NarInfo{
+		References: []string{
+			testdata.Nar1.NarInfoHash + "-hello-2.12.1",
+			testdata.Nar2.NarInfoHash + "-hello-2.12.1",
+			"33333333333333333333333333333333-missing",
+		},
+	}
+
+	setup := func(t *testing.T, policy MissingReferencesPolicy) *Cache {
+		t.Helper()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		ts := testdata.NewTestServer(t, 40)
+		t.Cleanup(ts.Close)
+
+		uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), nil)
+		require.NoError(t, err)
+
+		c.AddUpstreamCaches(newContext(), uc)
+
+		<-c.GetHealthChecker().Trigger()
+
+		c.SetMissingReferencesPolicy(policy, 2, time.Minute)
+
+		return c
+	}
+
+	t.Run("annotate does not pull the references", func(t *testing.T) {
+		t.Parallel()
+
+		c := setup(t, MissingReferencesAnnotate)
+
+		missing, err := c.findMissingReferences(newContext(), testdata.Nar1.NarInfoHash, served)
+		require.NoError(t, err)
+		assert.Equal(t, []string{testdata.Nar2.NarInfoHash, "33333333333333333333333333333333"}, missing,
+			"a narinfo is not its own missing reference")
+
+		c.handleMissingReferences(newContext(), testdata.Nar1.NarInfoHash, served)
+		c.backgroundWG.Wait()
+
+		_, err = c.getNarInfoFromDatabase(newContext(), testdata.Nar2.NarInfoHash)
+		require.Error(t, err)
+	})
+
+	t.Run("prefetch pulls the references in the background", func(t *testing.T) {
+		t.Parallel()
+
+		c := setup(t, MissingReferencesPrefetch)
+
+		c.handleMissingReferences(newContext(), testdata.Nar1.NarInfoHash, served)
+		c.backgroundWG.Wait()
+
+		_, err := c.getNarInfoFromDatabase(newContext(), testdata.Nar2.NarInfoHash)
+		require.NoError(t, err)
+
+		missing, err := c.findMissingReferences(newContext(), testdata.Nar1.NarInfoHash, served)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"33333333333333333333333333333333"}, missing)
+	})
+
+	t.Run("strict waits for the references", func(t *testing.T) {
+		t.Parallel()
+
+		c := setup(t, MissingReferencesStrict)
+
+		c.handleMissingReferences(newContext(), testdata.Nar1.NarInfoHash, served)
+
+		// The reference is cached by the time the narinfo is served.
+		_, err := c.getNarInfoFromDatabase(newContext(), testdata.Nar2.NarInfoHash)
+		require.NoError(t, err)
+
+		c.backgroundWG.Wait()
+	})
+
+	t.Run("upload-only requests are left alone", func(t *testing.T) {
+		t.Parallel()
+
+		c := setup(t, MissingReferencesStrict)
+
+		c.handleMissingReferences(WithUploadOnly(newContext()), testdata.Nar1.NarInfoHash, served)
+		c.backgroundWG.Wait()
+
+		_, err := c.getNarInfoFromDatabase(newContext(), testdata.Nar2.NarInfoHash)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/zstd"
+)
+
+const (
+	narStoreDecisionCompressed = "compressed"
+	narStoreDecisionRaw        = "raw"
+)
+
+// ErrInvalidNarCompressionSampling is returned by SetNarCompressionSampling
+// for a negative sample size or a minimum ratio below 1.
+var ErrInvalidNarCompressionSampling = errors.New(
+	"the nar compression sample size must not be negative and the minimum ratio must be at least 1",
+)
+
+// SetNarCompressionSampling makes the uncompressed NARs pulled from upstream be
+// stored as a plain .nar, instead of recompressed as seekable zstd, when their
+// first sampleSize bytes do not compress to at least minRatio times smaller.
+// Such NARs hold already compressed artifacts, so recompressing them costs the
+// CPU of every store and serve for no space. A sampleSize of 0 disables the
+// sampling: every uncompressed NAR is recompressed.
+func (c *Cache) SetNarCompressionSampling(sampleSize int64, minRatio float64) error {
+	if sampleSize < 0 || (sampleSize > 0 && minRatio < 1) {
+		return fmt.Errorf("%w: sample size %d, minimum ratio %g",
+			ErrInvalidNarCompressionSampling, sampleSize, minRatio)
+	}
+
+	c.narSampleSize = sampleSize
+	c.narMinRatio = minRatio
+
+	return nil
+}
+
+// shouldStoreNarRaw compresses the first bytes of the uncompressed NAR of f
+// with the whole-file NAR encoder and reports whether their ratio is under the
+// minimum set by SetNarCompressionSampling. It rewinds f before returning.
+func (c *Cache) shouldStoreNarRaw(ctx context.Context, f io.ReadSeeker) (bool, error) {
+	if c.narSampleSize <= 0 {
+		return false, nil
+	}
+
+	var compressed countingWriter
+
+	zw := zstd.NewNarWriter(&compressed)
+
+	sampled, err := io.Copy(zw, io.LimitReader(f, c.narSampleSize))
+
+	closeErr := zw.Close()
+
+	if _, seekErr := f.Seek(0, io.SeekStart); seekErr != nil {
+		return false, fmt.Errorf("error rewinding the nar temp file: %w", seekErr)
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("error sampling the nar: %w", err)
+	}
+
+	if closeErr != nil {
+		return false, fmt.Errorf("error sampling the nar: %w", closeErr)
+	}
+
+	if sampled == 0 || compressed.n == 0 {
+		return false, nil
+	}
+
+	ratio := float64(sampled) / float64(compressed.n)
+	raw := ratio < c.narMinRatio
+
+	zerolog.Ctx(ctx).Debug().
+		Int64("sampled", sampled).
+		Int64("compressed", compressed.n).
+		Float64("ratio", ratio).
+		Bool("raw", raw).
+		Msg("sampled the compression ratio of the nar")
+
+	return raw, nil
+}
+
+// recordNarStoreDecision counts whether an uncompressed NAR was recompressed or
+// stored raw.
+func recordNarStoreDecision(ctx context.Context, raw bool) {
+	if narStoreDecisionsTotal == nil {
+		return
+	}
+
+	decision := narStoreDecisionCompressed
+	if raw {
+		decision = narStoreDecisionRaw
+	}
+
+	narStoreDecisionsTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("decision", decision)))
+}
+
+// isNarStoredRaw reports whether the Compression:none NAR of narURL was
+// recorded as stored as a plain .nar, so the serving paths read it as is
+// without probing for a compressed variant. An error reading the record is
+// logged and reported as false, falling back to the probing.
+func (c *Cache) isNarStoredRaw(ctx context.Context, narURL nar.URL) bool {
+	if narURL.Compression != nar.CompressionTypeNone {
+		return false
+	}
+
+	if normalized, err := narURL.Normalize(); err == nil {
+		narURL = normalized
+	}
+
+	raw, err := c.dbClient.Ent().NarFile.Query().
+		Where(
+			entnarfile.HashEQ(narURL.Hash),
+			entnarfile.CompressionEQ(nar.CompressionTypeNone.String()),
+			entnarfile.QueryEQ(narURL.CanonicalQuery()),
+			entnarfile.StoredRaw(true),
+		).
+		Exist(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("error checking whether the nar is stored raw")
+
+		return false
+	}
+
+	return raw
+}
+
+// countingWriter discards what is written to it, counting its bytes.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+
+	return len(p), nil
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestSetNarCompressionSampling(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	require.NoError(t, c.SetNarCompressionSampling(0, 0))
+	require.NoError(t, c.SetNarCompressionSampling(65536, 1.1))
+	require.ErrorIs(t, c.SetNarCompressionSampling(-1, 1.1), ErrInvalidNarCompressionSampling)
+	require.ErrorIs(t, c.SetNarCompressionSampling(65536, 0.5), ErrInvalidNarCompressionSampling)
+}
+
+func TestStoreNarFromTempFileSamplesCompression(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		hash    string
+		text    string
+		wantRaw bool
+	}{
+		{
+			// The text of Nar7 is random bytes, it does not compress.
+			name:    "incompressible NAR is stored raw",
+			hash:    testdata.Nar7.NarHash,
+			text:    testdata.Nar7.NarText,
+			wantRaw: true,
+		},
+		{
+			name:    "compressible NAR is recompressed",
+			hash:    "1111111111111111111111111111111111111111111111111111",
+			text:    strings.Repeat("compressible ", 10000),
+			wantRaw: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+			t.Cleanup(cleanup)
+
+			require.NoError(t, c.SetNarCompressionSampling(65536, 1.1))
+
+			ctx := newContext()
+
+			tempPath := filepath.Join(t.TempDir(), "nar")
+			require.NoError(t, os.WriteFile(tempPath, []byte(tt.text), 0o600))
+
+			narURL := nar.URL{Hash: tt.hash, Compression: nar.CompressionTypeNone}
+			require.NoError(t, c.storeNarFromTempFile(ctx, tempPath, &narURL))
+
+			zstdURL := narURL
+			zstdURL.Compression = nar.CompressionTypeZstd
+
+			assert.Equal(t, tt.wantRaw, c.narStore.HasNar(ctx, narURL), "plain .nar stored")
+			assert.Equal(t, !tt.wantRaw, c.narStore.HasNar(ctx, zstdURL), ".nar.zst stored")
+			assert.Equal(t, tt.wantRaw, c.isNarStoredRaw(ctx, narURL))
+
+			getURL := narURL
+
+			_, r, err := c.getNarFromStore(ctx, &getURL)
+			require.NoError(t, err)
+
+			body, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+
+			assert.Equal(t, tt.text, string(body))
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+)
+
+func TestNarJobKey(t *testing.T) {
+	t.Parallel()
+
+	const hash = "1s8p1kgdms8rmxkq24q51wc7zpn0aqcwgzvc473v9cii7z2qyxq0"
+
+	xzURL := nar.URL{Hash: hash, Compression: nar.CompressionTypeXz}
+	noneURL := nar.URL{Hash: hash, Compression: nar.CompressionTypeNone}
+	hashQueryURL := nar.URL{Hash: hash, Compression: nar.CompressionTypeXz, Query: url.Values{"hash": {"abc"}}}
+	noiseQueryURL := nar.URL{Hash: hash, Compression: nar.CompressionTypeXz, Query: url.Values{"utm": {"x"}}}
+
+	t.Run("every representation has its own job", func(t *testing.T) {
+		t.Parallel()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		assert.NotEqual(t, c.narJobKey(xzURL), c.narJobKey(noneURL))
+		assert.NotEqual(t, c.narJobKey(xzURL), c.narJobKey(hashQueryURL))
+		assert.Equal(t, c.narJobKey(xzURL), c.narJobKey(noiseQueryURL),
+			"the query parameters not identifying a representation are ignored")
+		assert.NotEqual(t, c.narJobKey(xzURL), narInfoJobKey(hash),
+			"the narinfo and NAR jobs of a hash are apart")
+	})
+
+	t.Run("with CDC every compression shares a job", func(t *testing.T) {
+		t.Parallel()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		cs, err := chunk.NewLocalStore(filepath.Join(t.TempDir(), "chunks"))
+		require.NoError(t, err)
+
+		c.SetChunkStore(cs)
+		require.NoError(t, c.SetCDCConfiguration(true, 1024, 4096, 8192))
+
+		assert.Equal(t, c.narJobKey(xzURL), c.narJobKey(noneURL))
+		assert.NotEqual(t, c.narJobKey(xzURL), c.narJobKey(hashQueryURL))
+	})
+}
+
+// TestCoordinateDownloadMixedRepresentations starts concurrent downloads of
+// several representations of the same NAR, and of its narinfo, and checks
+// each representation gets one job that is neither shared with nor blocked by
+// the others, and joined by the later requests of the same representation.
+func TestCoordinateDownloadMixedRepresentations(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	ctx := context.Background()
+
+	const hash = "1s8p1kgdms8rmxkq24q51wc7zpn0aqcwgzvc473v9cii7z2qyxq0"
+
+	keys := []string{
+		c.narJobKey(nar.URL{Hash: hash, Compression: nar.CompressionTypeXz}),
+		c.narJobKey(nar.URL{Hash: hash, Compression: nar.CompressionTypeNone}),
+		c.narJobKey(nar.URL{Hash: hash, Compression: nar.CompressionTypeXz, Query: url.Values{"hash": {"abc"}}}),
+		narInfoJobKey(hash),
+	}
+
+	var (
+		started sync.Map
+		jobs    atomic.Int32
+	)
+
+	// The jobs start but do not finish until the end of the test, so a
+	// representation sharing the job of another would not start its own.
+	release := make(chan struct{})
+
+	startJob := func(key string) func(*downloadState) {
+		return func(ds *downloadState) {
+			jobs.Add(1)
+
+			_, dup := started.LoadOrStore(key, struct{}{})
+			assert.False(t, dup, "the job of %q started twice", key)
+
+			ds.startOnce.Do(func() { close(ds.start) })
+
+			<-release
+
+			c.upstreamJobsMu.Lock()
+			delete(c.upstreamJobs, key)
+			c.upstreamJobsMu.Unlock()
+
+			ds.storedOnce.Do(func() { close(ds.stored) })
+			ds.doneOnce.Do(func() { close(ds.done) })
+		}
+	}
+
+	coordinate := func(key string) *downloadState {
+		return c.coordinateDownload(
+			ctx, ctx, key, hash,
+			false, // waitForStorage
+			false, // allowStaging
+			func(context.Context) (bool, bool) { return false, false },
+			startJob(key),
+		)
+	}
+
+	// Every representation at once.
+	dss := make([]*downloadState, len(keys))
+
+	var wg sync.WaitGroup
+
+	for i, key := range keys {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			dss[i] = coordinate(key)
+		}()
+	}
+
+	wg.Wait()
+
+	// A second request of a representation joins its job.
+	for i, key := range keys {
+		assert.Same(t, dss[i], coordinate(key), "the requests of %q should share a job", key)
+	}
+
+	assert.Equal(t, int32(len(keys)), jobs.Load(), "each representation should have its own job")
+
+	close(release)
+	c.backgroundWG.Wait()
+}
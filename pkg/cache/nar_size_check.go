@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+)
+
+// NarSizeCheck selects what is done with a NAR downloaded from an upstream
+// whose size does not match the one advertised by its narinfo.
+type NarSizeCheck string
+
+const (
+	// NarSizeCheckOff does not check the size of the NARs downloaded.
+	NarSizeCheckOff NarSizeCheck = "off"
+
+	// NarSizeCheckWarn logs and counts the mismatching NARs, but stores them.
+	// It is the default, also used while no check is set.
+	NarSizeCheckWarn NarSizeCheck = "warn"
+
+	// NarSizeCheckReject aborts the download of a mismatching NAR, as soon as
+	// it is too long, so it is never stored.
+	NarSizeCheckReject NarSizeCheck = "reject"
+)
+
+const (
+	// Actions recorded by ncps_upstream_nar_size_mismatches_total.
+	narSizeMismatchWarned   = "warned"
+	narSizeMismatchRejected = "rejected"
+)
+
+var (
+	// ErrUnknownNarSizeCheck is returned by ParseNarSizeCheck for an unknown
+	// check.
+	ErrUnknownNarSizeCheck = errors.New("unknown nar size check (allowed: off, warn, reject)")
+
+	// ErrNarSizeMismatch is returned when the download of a NAR is rejected
+	// because its size does not match the one advertised by its narinfo. It
+	// wraps ErrIntegrity.
+	ErrNarSizeMismatch = fmt.Errorf("%w: the nar size does not match its narinfo", ErrIntegrity)
+)
+
+// ParseNarSizeCheck parses the name of a NarSizeCheck. The empty string is the
+// default check.
+func ParseNarSizeCheck(s string) (NarSizeCheck, error) {
+	switch NarSizeCheck(s) {
+	case "", NarSizeCheckWarn:
+		return NarSizeCheckWarn, nil
+	case NarSizeCheckOff:
+		return NarSizeCheckOff, nil
+	case NarSizeCheckReject:
+		return NarSizeCheckReject, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownNarSizeCheck, s)
+	}
+}
+
+// narSizeCheck configures the check of the size of the NARs downloaded. See
+// SetUpstreamNarSizeCheck.
+type narSizeCheck struct {
+	mode      NarSizeCheck
+	tolerance float64
+}
+
+// SetUpstreamNarSizeCheck sets the check of the NARs downloaded from the
+// upstreams against the size advertised by their narinfo: the FileSize of a
+// compressed NAR, the NarSize of an uncompressed one. A NAR whose size differs
+// from it by more than tolerance, a fraction of the advertised size, is a
+// mismatch. NARs whose size is not advertised are not checked.
+func (c *Cache) SetUpstreamNarSizeCheck(mode NarSizeCheck, tolerance float64) {
+	c.narSizeCheck = narSizeCheck{mode: mode, tolerance: max(tolerance, 0)}
+}
+
+// expectedUpstreamNarSize returns the size, in bytes, of the NAR downloaded
+// from downloadURL as advertised by narInfo, or zero when it is not known. It
+// must be called before narInfo is rewritten for serving.
+func expectedUpstreamNarSize(downloadURL *nar.URL, narInfo *narinfo.NarInfo) int64 {
+	if narInfo == nil {
+		return 0
+	}
+
+	// The upstream client decodes any transfer encoding, so an uncompressed
+	// NAR always arrives as the raw NAR.
+	if downloadURL.Compression == nar.CompressionTypeNone {
+		return int64(narInfo.NarSize) //nolint:gosec // G115: NAR sizes fit in int64
+	}
+
+	if narInfo.Compression != downloadURL.Compression.String() {
+		return 0
+	}
+
+	return int64(narInfo.FileSize) //nolint:gosec // G115: NAR sizes fit in int64
+}
+
+// checkUpstreamNarSize wraps the body of a NAR downloaded from an upstream to
+// check its size against expected. A non-positive expected size is not
+// checked.
+func (c *Cache) checkUpstreamNarSize(ctx context.Context, body io.ReadCloser, expected int64) io.ReadCloser {
+	check := c.narSizeCheck
+	if check.mode == NarSizeCheckOff || expected <= 0 {
+		return body
+	}
+
+	slack := int64(math.Floor(float64(expected) * check.tolerance))
+
+	return &narSizeCheckReader{
+		ReadCloser: body,
+		ctx:        ctx,
+		reject:     check.mode == NarSizeCheckReject,
+		expected:   expected,
+		minSize:    expected - slack,
+		maxSize:    expected + slack,
+	}
+}
+
+// narSizeCheckReader counts the bytes of a NAR body and checks them against
+// the expected size once the body is read, or as soon as they exceed it.
+type narSizeCheckReader struct {
+	io.ReadCloser
+
+	ctx      context.Context //nolint:containedctx // the context of the download the reader belongs to
+	reject   bool
+	expected int64
+	minSize  int64
+	maxSize  int64
+
+	read     int64
+	reported bool
+}
+
+func (r *narSizeCheckReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+
+	if r.read > r.maxSize && r.reject {
+		return n, r.mismatch(false)
+	}
+
+	if errors.Is(err, io.EOF) && (r.read < r.minSize || r.read > r.maxSize) {
+		if mismatchErr := r.mismatch(true); r.reject {
+			return n, mismatchErr
+		}
+	}
+
+	return n, err
+}
+
+// mismatch reports the mismatch, once, and returns the error rejecting it.
+// complete tells whether the whole body was read.
+func (r *narSizeCheckReader) mismatch(complete bool) error {
+	err := fmt.Errorf("%w: read %d bytes, expected %d", ErrNarSizeMismatch, r.read, r.expected)
+	if !complete {
+		err = fmt.Errorf("%w: read more than %d bytes, expected %d", ErrNarSizeMismatch, r.maxSize, r.expected)
+	}
+
+	if r.reported {
+		return err
+	}
+
+	r.reported = true
+
+	action := narSizeMismatchWarned
+	if r.reject {
+		action = narSizeMismatchRejected
+	}
+
+	zerolog.Ctx(r.ctx).
+		Warn().
+		Int64("bytes_read", r.read).
+		Int64("expected_size", r.expected).
+		Str("action", action).
+		Msg("the nar downloaded from upstream does not match the size of its narinfo")
+
+	narSizeMismatchesTotal.Add(r.ctx, 1, metric.WithAttributes(attribute.String("action", action)))
+
+	return err
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+)
+
+func TestParseNarSizeCheck(t *testing.T) {
+	t.Parallel()
+
+	for s, want := range map[string]NarSizeCheck{
+		"":       NarSizeCheckWarn,
+		"off":    NarSizeCheckOff,
+		"warn":   NarSizeCheckWarn,
+		"reject": NarSizeCheckReject,
+	} {
+		got, err := ParseNarSizeCheck(s)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := ParseNarSizeCheck("strict")
+	require.ErrorIs(t, err, ErrUnknownNarSizeCheck)
+}
+
+func TestExpectedUpstreamNarSize(t *testing.T) {
+	t.Parallel()
+
+	ni := &narinfo.NarInfo{Compression: "xz", FileSize: 100, NarSize: 400}
+
+	assert.Equal(t, int64(100),
+		expectedUpstreamNarSize(&nar.URL{Compression: nar.CompressionTypeXz}, ni))
+	assert.Equal(t, int64(400),
+		expectedUpstreamNarSize(&nar.URL{Compression: nar.CompressionTypeNone}, ni),
+		"an uncompressed NAR is checked against the NarSize")
+	assert.Zero(t, expectedUpstreamNarSize(&nar.URL{Compression: nar.CompressionTypeZstd}, ni),
+		"a NAR in another compression than the narinfo's is not checked")
+	assert.Zero(t, expectedUpstreamNarSize(&nar.URL{Compression: nar.CompressionTypeXz}, nil))
+}
+
+func TestCheckUpstreamNarSize(t *testing.T) {
+	t.Parallel()
+
+	read := func(c *Cache, body string, expected int64) (string, error) {
+		r := c.checkUpstreamNarSize(newContext(), io.NopCloser(strings.NewReader(body)), expected)
+		defer r.Close()
+
+		b, err := io.ReadAll(r)
+
+		return string(b), err
+	}
+
+	t.Run("warn stores the mismatching NARs", func(t *testing.T) {
+		t.Parallel()
+
+		c := &Cache{}
+
+		got, err := read(c, "truncated", 100)
+		require.NoError(t, err)
+		assert.Equal(t, "truncated", got)
+	})
+
+	t.Run("reject aborts a short NAR", func(t *testing.T) {
+		t.Parallel()
+
+		c := &Cache{}
+		c.SetUpstreamNarSizeCheck(NarSizeCheckReject, 0)
+
+		_, err := read(c, "truncated", 100)
+		require.ErrorIs(t, err, ErrNarSizeMismatch)
+	})
+
+	t.Run("reject aborts a long NAR", func(t *testing.T) {
+		t.Parallel()
+
+		c := &Cache{}
+		c.SetUpstreamNarSizeCheck(NarSizeCheckReject, 0)
+
+		_, err := read(c, strings.Repeat("x", 200), 100)
+		require.ErrorIs(t, err, ErrNarSizeMismatch)
+	})
+
+	t.Run("reject accepts a NAR within the tolerance", func(t *testing.T) {
+		t.Parallel()
+
+		c := &Cache{}
+		c.SetUpstreamNarSizeCheck(NarSizeCheckReject, 0.1)
+
+		_, err := read(c, strings.Repeat("x", 95), 100)
+		require.NoError(t, err)
+
+		_, err = read(c, strings.Repeat("x", 89), 100)
+		require.ErrorIs(t, err, ErrNarSizeMismatch)
+	})
+
+	t.Run("an unknown size is not checked", func(t *testing.T) {
+		t.Parallel()
+
+		c := &Cache{}
+		c.SetUpstreamNarSizeCheck(NarSizeCheckReject, 0)
+
+		_, err := read(c, "anything", 0)
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,136 @@
+package cache_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/chunker"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+// TestGetNar_CompressionVariantsShareOneUpstreamFetch requests a NAR as .nar
+// and .nar.xz while its eager-CDC download is in flight: both are served from
+// that download, the .nar.xz with the upstream's xz bytes, and the NAR is
+// fetched upstream once.
+func TestGetNar_CompressionVariantsShareOneUpstreamFetch(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	ts := testdata.NewTestServer(t, 40)
+	t.Cleanup(ts.Close)
+
+	c, _, _, dir, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	chunkStore, err := chunk.NewLocalStore(filepath.Join(dir, "chunks-store"))
+	require.NoError(t, err)
+
+	c.SetChunkStore(chunkStore)
+	require.NoError(t, c.SetCDCConfiguration(true, 1024, 4096, 8192))
+
+	// Keep the download in flight while the variants are requested.
+	realChunker, err := chunker.NewCDCChunker(1024, 4096, 8192)
+	require.NoError(t, err)
+
+	c.SetChunker(&slowChunker{real: realChunker, delay: 5 * time.Second})
+
+	originalContent := testhelper.MustRandString(50160)
+	xzContent := compressXz(t, originalContent)
+
+	var fetches atomic.Int32
+
+	narServing := make(chan struct{})
+
+	var narServingOnce sync.Once
+
+	nar2NARPath := "/nar/" + testdata.Nar2.NarHash + ".nar.xz"
+	idx := ts.AddMaybeHandler(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != nar2NARPath {
+			return false
+		}
+
+		if r.Method == http.MethodGet {
+			fetches.Add(1)
+		}
+
+		narServingOnce.Do(func() { close(narServing) })
+
+		_, _ = io.WriteString(w, xzContent)
+
+		return true
+	})
+
+	t.Cleanup(func() { ts.RemoveMaybeHandler(idx) })
+
+	uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), &upstream.Options{
+		PublicKeys: testdata.PublicKeys(),
+	})
+	require.NoError(t, err)
+
+	c.AddUpstreamCaches(newContext(), uc)
+	<-c.GetHealthChecker().Trigger()
+
+	// Pulling the narinfo starts the eager-CDC download of the NAR.
+	_, err = c.GetNarInfo(ctx, testdata.Nar2.NarInfoHash)
+	require.NoError(t, err)
+
+	select {
+	case <-narServing:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the NAR download to start")
+	}
+
+	readNar := func(compression nar.CompressionType) (nar.URL, []byte) {
+		nu, _, rc, err := c.GetNar(ctx, nar.URL{Hash: testdata.Nar2.NarHash, Compression: compression})
+		require.NoError(t, err)
+
+		defer rc.Close()
+
+		body, err := io.ReadAll(rc)
+		require.NoError(t, err)
+
+		return nu, body
+	}
+
+	var (
+		wg               sync.WaitGroup
+		noneURL, xzURL   nar.URL
+		noneBody, xzBody []byte
+	)
+
+	wg.Go(func() { noneURL, noneBody = readNar(nar.CompressionTypeNone) })
+	wg.Go(func() { xzURL, xzBody = readNar(nar.CompressionTypeXz) })
+	wg.Wait()
+
+	assert.Equal(t, nar.CompressionTypeNone, noneURL.Compression)
+	assert.Equal(t, originalContent, string(noneBody))
+
+	assert.Equal(t, nar.CompressionTypeXz, xzURL.Compression)
+	assert.Equal(t, xzContent, string(xzBody), "the .nar.xz is served the upstream's bytes")
+
+	dr, err := nar.DecompressReader(ctx, bytes.NewReader(xzBody), nar.CompressionTypeXz)
+	require.NoError(t, err)
+
+	defer dr.Close()
+
+	got, err := io.ReadAll(dr)
+	require.NoError(t, err)
+	assert.Equal(t, originalContent, string(got))
+
+	assert.Equal(t, int32(1), fetches.Load(), "the NAR is fetched upstream once")
+}
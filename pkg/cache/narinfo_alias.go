@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/rs/zerolog"
+
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+	entnarinfonarfile "github.com/kalbasit/ncps/ent/narinfonarfile"
+
+	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/pkg/database"
+)
+
+// SetNarInfoAliases makes the narinfos pulled from upstream aliases of the
+// stored NAR of the same content: a narinfo whose NarHash and NarSize are those
+// of a NAR already stored, whole or as chunks, under another narinfo is linked
+// to that NAR and served with its URL, instead of downloading the same NAR
+// again under its own URL. This saves the space of the NARs shared by several
+// store paths without CDC.
+func (c *Cache) SetNarInfoAliases(enabled bool) {
+	c.narInfoAliases = enabled
+}
+
+// aliasNarInfo points narInfo, the narinfo of hash pulled from upstream, at the
+// stored NAR of the same content when aliases are enabled. It returns the
+// upstream URL of the aliased NAR to persist with narInfo, and whether narInfo
+// was aliased; its NAR must not be downloaded then.
+func (c *Cache) aliasNarInfo(ctx context.Context, hash string, narInfo *narinfo.NarInfo) (string, bool) {
+	if !c.narInfoAliases || narInfo.NarHash == nil {
+		return "", false
+	}
+
+	// The NarHash is matched in the encoding of the narinfo and in the nix32
+	// one most narinfos use.
+	narHashes := []string{narInfo.NarHash.String(), narInfo.NarHash.Format(nixhash.NixBase32, true)}
+
+	target, err := c.dbClient.Ent().NarInfo.Query().
+		Where(
+			entnarinfo.HashNEQ(hash),
+			entnarinfo.NarHashIn(narHashes...),
+			//nolint:gosec // G115: NarSize is non-negative by spec
+			entnarinfo.NarSizeEQ(int64(narInfo.NarSize)),
+			entnarinfo.URLNotNil(),
+			entnarinfo.HasNarInfoNarFilesWith(
+				entnarinfonarfile.HasNarFileWith(
+					entnarfile.Or(
+						entnarfile.BytesStoredAtNotNil(),
+						entnarfile.TotalChunksGT(0),
+					),
+				),
+			),
+		).
+		First(ctx)
+	if err != nil {
+		if !database.IsNotFoundError(err) {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to look up the stored nar of the same content")
+		}
+
+		return "", false
+	}
+
+	if err := applyNarInfoAlias(narInfo, target); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("alias_of", target.Hash).Msg("failed to alias the narinfo")
+
+		return "", false
+	}
+
+	narInfoAliasesTotal.Add(ctx, 1)
+
+	zerolog.Ctx(ctx).Info().
+		Str("alias_of", target.Hash).
+		Str("nar_url", narInfo.URL).
+		Msg("narinfo aliased to the stored nar of the same content")
+
+	var upstreamURL string
+	if target.UpstreamURL != nil {
+		upstreamURL = *target.UpstreamURL
+	}
+
+	return upstreamURL, true
+}
+
+// applyNarInfoAlias sets the fields of narInfo describing its NAR file to those
+// of target. The fingerprint of narInfo, hence its signatures, is unchanged.
+func applyNarInfoAlias(narInfo *narinfo.NarInfo, target *ent.NarInfo) error {
+	var fileHash *nixhash.HashWithEncoding
+
+	if target.FileHash != nil {
+		var err error
+
+		fileHash, err = nixhash.ParseAny(*target.FileHash, nil)
+		if err != nil {
+			return fmt.Errorf("error parsing the FileHash %q: %w", *target.FileHash, err)
+		}
+	}
+
+	var fileSize uint64
+	if target.FileSize != nil {
+		//nolint:gosec // G115: FileSize is non-negative by spec
+		fileSize = uint64(*target.FileSize)
+	}
+
+	narInfo.URL = *target.URL
+	narInfo.Compression = ""
+	narInfo.FileHash = fileHash
+	narInfo.FileSize = fileSize
+
+	if target.Compression != nil {
+		narInfo.Compression = *target.Compression
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestNarInfoAliases(t *testing.T) {
+	t.Parallel()
+
+	// The alias is another store path of the same NAR content as testdata.Nar1,
+	// served by the upstream under another URL.
+	const (
+		aliasHash   = "a5glp21rsz314qssw9fbvfswgy3kc68f"
+		aliasNarURL = "nar/0000000000000000000000000000000000000000000000000000.nar.xz"
+	)
+
+	aliasText := strings.NewReplacer(
+		"/nix/store/"+testdata.Nar1.NarInfoHash, "/nix/store/"+aliasHash,
+		"URL: nar/"+testdata.Nar1.NarHash+".nar.xz", "URL: "+aliasNarURL,
+	).Replace(testdata.Nar1.NarInfoText)
+
+	setup := func(t *testing.T, aliases bool) (*Cache, *atomic.Int64) {
+		t.Helper()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		c.SetNarInfoAliases(aliases)
+
+		ts := testdata.NewTestServer(t, 40)
+		t.Cleanup(ts.Close)
+
+		var narRequests atomic.Int64
+
+		ts.AddMaybeHandler(func(w http.ResponseWriter, r *http.Request) bool {
+			switch r.URL.Path {
+			case "/" + aliasHash + ".narinfo":
+				if r.Method == http.MethodGet {
+					_, _ = w.Write([]byte(aliasText))
+				}
+
+				return true
+			case "/" + aliasNarURL:
+				narRequests.Add(1)
+
+				_, _ = w.Write([]byte(testdata.Nar1.NarText))
+
+				return true
+			}
+
+			return false
+		})
+
+		uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), nil)
+		require.NoError(t, err)
+
+		c.AddUpstreamCaches(newContext(), uc)
+
+		<-c.GetHealthChecker().Trigger()
+
+		narURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: testdata.Nar1.NarCompression}
+		require.NoError(t, c.PutNar(newContext(), narURL, io.NopCloser(strings.NewReader(testdata.Nar1.NarText))))
+		require.NoError(t, c.PutNarInfo(newContext(), testdata.Nar1.NarInfoHash,
+			io.NopCloser(strings.NewReader(testdata.Nar1.NarInfoText))))
+
+		return c, &narRequests
+	}
+
+	t.Run("the alias is served with the stored nar", func(t *testing.T) {
+		t.Parallel()
+
+		c, narRequests := setup(t, true)
+
+		ni, err := c.GetNarInfo(newContext(), aliasHash)
+		require.NoError(t, err)
+		assert.Equal(t, "/nix/store/"+aliasHash+"-hello-2.12.1", ni.StorePath)
+		assert.Equal(t, "nar/"+testdata.Nar1.NarHash+".nar.xz", ni.URL)
+
+		_, _, r, err := c.GetNar(newContext(), nar.URL{Hash: testdata.Nar1.NarHash, Compression: nar.CompressionTypeXz})
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+
+		c.backgroundWG.Wait()
+
+		assert.Zero(t, narRequests.Load(), "the nar of the alias is not downloaded")
+	})
+
+	t.Run("the alias is not linked when disabled", func(t *testing.T) {
+		t.Parallel()
+
+		c, _ := setup(t, false)
+
+		ni, err := c.GetNarInfo(newContext(), aliasHash)
+		require.NoError(t, err)
+		assert.Equal(t, aliasNarURL, ni.URL)
+	})
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+)
+
+// NarInfoCompression selects the compression advertised by the narinfos ncps
+// rewrites: the ones of CDC-chunked NARs and of uncompressed upstream NARs,
+// stored as zstd.
+type NarInfoCompression string
+
+const (
+	// NarInfoCompressionNone advertises the rewritten narinfos uncompressed;
+	// the NAR is then encoded with zstd on the fly for the clients accepting
+	// it. It is the default.
+	NarInfoCompressionNone NarInfoCompression = "none"
+
+	// NarInfoCompressionZstd advertises the rewritten narinfos as zstd once
+	// their NAR is chunked or stored as zstd, serving the stored zstd file as
+	// is and the chunks recompressed to zstd.
+	NarInfoCompressionZstd NarInfoCompression = "zstd"
+
+	// NarInfoCompressionKeepUpstream advertises the upstream's compression
+	// until the NAR is only available uncompressed: under eager CDC, the
+	// narinfos are not rewritten predictively and only advertise none once
+	// their NAR is chunked.
+	NarInfoCompressionKeepUpstream NarInfoCompression = "keep-upstream"
+)
+
+// ErrUnknownNarInfoCompression is returned by ParseNarInfoCompression for an
+// unknown compression.
+var ErrUnknownNarInfoCompression = errors.New(
+	"unknown narinfo compression (allowed: none, zstd, keep-upstream)",
+)
+
+// ParseNarInfoCompression parses the name of a NarInfoCompression. The empty
+// string is the default compression.
+func ParseNarInfoCompression(s string) (NarInfoCompression, error) {
+	switch NarInfoCompression(s) {
+	case "", NarInfoCompressionNone:
+		return NarInfoCompressionNone, nil
+	case NarInfoCompressionZstd:
+		return NarInfoCompressionZstd, nil
+	case NarInfoCompressionKeepUpstream:
+		return NarInfoCompressionKeepUpstream, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownNarInfoCompression, s)
+	}
+}
+
+// SetNarInfoCompression sets the compression advertised by the narinfos ncps
+// rewrites.
+func (c *Cache) SetNarInfoCompression(compression NarInfoCompression) {
+	c.narInfoCompression = compression
+}
+
+// rewritesNarInfoPredictively reports whether the narinfos pulled under eager
+// CDC are advertised uncompressed before their NAR is chunked.
+func (c *Cache) rewritesNarInfoPredictively() bool {
+	return c.isEagerCDC() && c.narInfoCompression != NarInfoCompressionKeepUpstream
+}
+
+// maybeZstdNarInfoURL advertises as zstd, in-memory, a narinfo ncps serves
+// uncompressed when NarInfoCompressionZstd is set and its NAR can be served as
+// zstd from the store or the chunks. It leaves it untouched otherwise, e.g.
+// while the NAR is being chunked.
+func (c *Cache) maybeZstdNarInfoURL(ctx context.Context, narInfo *narinfo.NarInfo) {
+	if c.narInfoCompression != NarInfoCompressionZstd ||
+		narInfo.Compression != nar.CompressionTypeNone.String() {
+		return
+	}
+
+	narURL, err := nar.ParseURL(narInfo.URL)
+	if err != nil || narURL.Compression != nar.CompressionTypeNone {
+		return
+	}
+
+	zstdURL := nar.URL{Hash: narURL.Hash, Compression: nar.CompressionTypeZstd, Query: narURL.Query}
+
+	if !c.narStore.HasNar(ctx, zstdURL) {
+		hasChunks, err := c.HasNarInChunks(ctx, narURL)
+		if err != nil || !hasChunks {
+			return
+		}
+	}
+
+	narInfo.URL = zstdURL.String()
+	narInfo.Compression = nar.CompressionTypeZstd.String()
+	narInfo.FileHash = nil
+	narInfo.FileSize = 0
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestParseNarInfoCompression(t *testing.T) {
+	t.Parallel()
+
+	for s, want := range map[string]NarInfoCompression{
+		"":              NarInfoCompressionNone,
+		"none":          NarInfoCompressionNone,
+		"zstd":          NarInfoCompressionZstd,
+		"keep-upstream": NarInfoCompressionKeepUpstream,
+	} {
+		got, err := ParseNarInfoCompression(s)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := ParseNarInfoCompression("xz")
+	require.ErrorIs(t, err, ErrUnknownNarInfoCompression)
+}
+
+// TestPullNarInfo_EagerCDC_KeepUpstreamRetainsXzURL checks that keep-upstream
+// turns off the predictive normalization of eager CDC: the pulled narinfo keeps
+// the upstream's xz URL, at rest and as served, while its NAR is not chunked.
+func TestPullNarInfo_EagerCDC_KeepUpstreamRetainsXzURL(t *testing.T) {
+	t.Parallel()
+
+	c, dbClient := setupCDCPullCache(t, false)
+	c.SetNarInfoCompression(NarInfoCompressionKeepUpstream)
+
+	reqCtx := withNarPrefetchDisabled(newContext())
+
+	ni, err := c.GetNarInfo(reqCtx, testdata.Nar1.NarInfoHash)
+	require.NoError(t, err)
+	assert.Equal(t, nar.CompressionTypeXz.String(), ni.Compression)
+
+	row, err := dbClient.Ent().NarInfo.Query().
+		Where(entnarinfo.HashEQ(testdata.Nar1.NarInfoHash)).
+		Only(newContext())
+	require.NoError(t, err)
+
+	require.NotNil(t, row.URL)
+	assert.True(t, strings.HasSuffix(*row.URL, ".nar.xz"), "got %q", *row.URL)
+
+	ni, err = c.GetNarInfo(reqCtx, testdata.Nar1.NarInfoHash)
+	require.NoError(t, err)
+	assert.Equal(t, nar.CompressionTypeXz.String(), ni.Compression,
+		"a not-yet-chunked narinfo is served with the upstream's compression")
+}
+
+// TestGetNarInfo_ZstdAdvertisesChunkedNarAsZstd checks that the zstd narinfo
+// compression advertises a chunked NAR as zstd, and that the NAR is then
+// served as zstd from its chunks.
+func TestGetNarInfo_ZstdAdvertisesChunkedNarAsZstd(t *testing.T) {
+	t.Parallel()
+
+	ctx := newContext()
+
+	c, _ := setupCDCPullCache(t, false)
+	c.SetNarInfoCompression(NarInfoCompressionZstd)
+
+	content := "this is test content for a narinfo advertised as zstd"
+	hash := "1s8p1kgdms8rmxkq24q51wc7zpn0aqcwgzvc473v9cii7z2qyxq0"
+
+	require.NoError(t, c.PutNar(ctx, nar.URL{Hash: hash, Compression: nar.CompressionTypeNone},
+		io.NopCloser(strings.NewReader(content))))
+
+	niText := `StorePath: /nix/store/0amzzlz5w7ihknr59cn0q56pvp17bqqz-test-path
+URL: nar/` + hash + `.nar
+Compression: none
+NarHash: sha256:` + hash + `
+NarSize: 53
+`
+	require.NoError(t, c.PutNarInfo(ctx, "0amzzlz5w7ihknr59cn0q56pvp17bqqz", io.NopCloser(strings.NewReader(niText))))
+
+	ni, err := c.GetNarInfo(ctx, "0amzzlz5w7ihknr59cn0q56pvp17bqqz")
+	require.NoError(t, err)
+	assert.Equal(t, nar.CompressionTypeZstd.String(), ni.Compression)
+	assert.Equal(t, "nar/"+hash+".nar.zst", ni.URL)
+	assert.Nil(t, ni.FileHash)
+
+	narURL, err := nar.ParseURL(ni.URL)
+	require.NoError(t, err)
+
+	_, _, rc, err := c.GetNar(ctx, narURL)
+	require.NoError(t, err)
+
+	defer rc.Close()
+
+	body, err := io.ReadAll(rc)
+	require.NoError(t, err)
+
+	dr, err := nar.DecompressReader(ctx, bytes.NewReader(body), nar.CompressionTypeZstd)
+	require.NoError(t, err)
+
+	defer dr.Close()
+
+	got, err := io.ReadAll(dr)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(got))
+}
@@ -0,0 +1,280 @@
+package cache
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/kalbasit/ncps/pkg/analytics"
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+)
+
+// NarInfoConflictPolicy selects the narinfo served when several upstreams have
+// one for the same hash.
+type NarInfoConflictPolicy string
+
+const (
+	// NarInfoConflictPolicyFastest serves the narinfo of the first upstream
+	// found to have it, as probed by SetNarInfoHedging. It is the default, also
+	// used while no policy is set.
+	NarInfoConflictPolicyFastest NarInfoConflictPolicy = "fastest"
+
+	// NarInfoConflictPolicyFirstHealthy serves the narinfo of the healthy
+	// upstream of the highest priority having it.
+	NarInfoConflictPolicyFirstHealthy NarInfoConflictPolicy = "first-healthy"
+
+	// NarInfoConflictPolicyPreferSignedBy serves the narinfo signed by the
+	// first of the preferred keys, and the first-healthy one when none is.
+	NarInfoConflictPolicyPreferSignedBy NarInfoConflictPolicy = "prefer-signed-by"
+
+	// NarInfoConflictPolicyPreferSmallest serves the narinfo of the smallest
+	// download, the first-healthy one among those of the same size.
+	NarInfoConflictPolicyPreferSmallest NarInfoConflictPolicy = "prefer-smallest"
+)
+
+const (
+	// Kinds of conflicts recorded by ncps_upstream_narinfo_conflicts_total, the
+	// most severe difference between the narinfos.
+	narInfoConflictContent        = "content"
+	narInfoConflictRepresentation = "representation"
+	narInfoConflictSignatures     = "signatures"
+)
+
+var (
+	// ErrUnknownNarInfoConflictPolicy is returned by ParseNarInfoConflictPolicy
+	// for an unknown policy.
+	ErrUnknownNarInfoConflictPolicy = errors.New(
+		"unknown narinfo conflict policy (allowed: fastest, first-healthy, prefer-signed-by, prefer-smallest)")
+
+	// ErrNarInfoConflictKeysRequired is returned by SetNarInfoConflictPolicy for
+	// the prefer-signed-by policy without a preferred key.
+	ErrNarInfoConflictKeysRequired = errors.New("the prefer-signed-by narinfo conflict policy requires a preferred key")
+)
+
+// ParseNarInfoConflictPolicy parses the name of a NarInfoConflictPolicy. The
+// empty string is the default policy.
+func ParseNarInfoConflictPolicy(s string) (NarInfoConflictPolicy, error) {
+	switch NarInfoConflictPolicy(s) {
+	case "", NarInfoConflictPolicyFastest:
+		return NarInfoConflictPolicyFastest, nil
+	case NarInfoConflictPolicyFirstHealthy:
+		return NarInfoConflictPolicyFirstHealthy, nil
+	case NarInfoConflictPolicyPreferSignedBy:
+		return NarInfoConflictPolicyPreferSignedBy, nil
+	case NarInfoConflictPolicyPreferSmallest:
+		return NarInfoConflictPolicyPreferSmallest, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownNarInfoConflictPolicy, s)
+	}
+}
+
+// SetNarInfoConflictPolicy sets the narinfo served when several upstreams have
+// one for the same hash. Every policy but fastest fetches the narinfo from
+// every healthy upstream having it and picks one deterministically; the
+// narinfos differing from the one picked are logged and counted as conflicts.
+// preferredKeys, in order of preference, are the public keys of the
+// prefer-signed-by policy, which only counts the signatures they verify.
+func (c *Cache) SetNarInfoConflictPolicy(policy NarInfoConflictPolicy, preferredKeys []signature.PublicKey) error {
+	if policy == NarInfoConflictPolicyPreferSignedBy && len(preferredKeys) == 0 {
+		return ErrNarInfoConflictKeysRequired
+	}
+
+	c.narInfoConflictPolicy = policy
+	c.narInfoPreferredKeys = preferredKeys
+
+	return nil
+}
+
+// resolvesNarInfoConflicts reports whether the narinfos are fetched from every
+// upstream to pick one by the conflict policy.
+func (c *Cache) resolvesNarInfoConflicts() bool {
+	return c.narInfoConflictPolicy != "" && c.narInfoConflictPolicy != NarInfoConflictPolicyFastest
+}
+
+// upstreamNarInfo is the narinfo of a hash on an upstream.
+type upstreamNarInfo struct {
+	uc      *upstream.Cache
+	narInfo *narinfo.NarInfo
+}
+
+// getNarInfoFromEveryUpstream fetches the narinfo of hash from every healthy
+// upstream and returns the one picked by the conflict policy. It returns nil
+// when no upstream has it.
+func (c *Cache) getNarInfoFromEveryUpstream(ctx context.Context, hash string) (*upstream.Cache, *narinfo.NarInfo) {
+	ucs := c.getHealthyUpstreams()
+
+	// The answers are kept in priority order, for the policies to be
+	// deterministic whichever upstream answers first.
+	answers := make([]*narinfo.NarInfo, len(ucs))
+
+	var wg sync.WaitGroup
+
+	for i, uc := range ucs {
+		wg.Add(1)
+
+		analytics.SafeGo(ctx, func() {
+			defer wg.Done()
+
+			narInfo, err := uc.GetNarInfo(ctx, hash)
+			if err != nil {
+				if !errors.Is(err, upstream.ErrNotFound) {
+					zerolog.Ctx(ctx).
+						WithLevel(errorLogLevelForContextErrors(err)).
+						Err(err).
+						Str("hostname", uc.GetHostname()).
+						Msg("error fetching the narInfo from upstream")
+				}
+
+				return
+			}
+
+			answers[i] = narInfo
+		})
+	}
+
+	wg.Wait()
+
+	candidates := make([]upstreamNarInfo, 0, len(ucs))
+
+	for i, narInfo := range answers {
+		if narInfo != nil {
+			candidates = append(candidates, upstreamNarInfo{uc: ucs[i], narInfo: narInfo})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	picked := c.pickNarInfo(candidates)
+
+	c.recordNarInfoConflicts(ctx, hash, picked, candidates)
+
+	return picked.uc, picked.narInfo
+}
+
+// pickNarInfo returns the candidate picked by the conflict policy. The
+// candidates are in priority order.
+func (c *Cache) pickNarInfo(candidates []upstreamNarInfo) upstreamNarInfo {
+	switch c.narInfoConflictPolicy {
+	case NarInfoConflictPolicyPreferSignedBy:
+		for _, pk := range c.narInfoPreferredKeys {
+			for _, candidate := range candidates {
+				fingerprint := candidate.narInfo.Fingerprint()
+
+				if slices.ContainsFunc(candidate.narInfo.Signatures, func(sig signature.Signature) bool {
+					return pk.Verify(fingerprint, sig)
+				}) {
+					return candidate
+				}
+			}
+		}
+	case NarInfoConflictPolicyPreferSmallest:
+		// SortStableFunc keeps the priority order of the candidates of the same
+		// size.
+		sorted := slices.Clone(candidates)
+		slices.SortStableFunc(sorted, func(a, b upstreamNarInfo) int {
+			return cmp.Compare(narInfoDownloadSize(a.narInfo), narInfoDownloadSize(b.narInfo))
+		})
+
+		return sorted[0]
+	case NarInfoConflictPolicyFastest, NarInfoConflictPolicyFirstHealthy:
+	}
+
+	return candidates[0]
+}
+
+// narInfoDownloadSize returns the size of the NAR download of narInfo, its
+// uncompressed size when the compressed one is not advertised.
+func narInfoDownloadSize(narInfo *narinfo.NarInfo) uint64 {
+	if narInfo.FileSize != 0 {
+		return narInfo.FileSize
+	}
+
+	return narInfo.NarSize
+}
+
+// recordNarInfoConflicts logs and counts the candidates conflicting with the
+// picked one.
+func (c *Cache) recordNarInfoConflicts(
+	ctx context.Context,
+	hash string,
+	picked upstreamNarInfo,
+	candidates []upstreamNarInfo,
+) {
+	for _, candidate := range candidates {
+		if candidate.uc == picked.uc {
+			continue
+		}
+
+		kind := narInfoConflictKind(picked.narInfo, candidate.narInfo)
+		if kind == "" {
+			continue
+		}
+
+		narInfoConflictsTotal.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("policy", string(c.narInfoConflictPolicy)),
+			attribute.String("kind", kind),
+		))
+
+		zerolog.Ctx(ctx).
+			Warn().
+			Str("narinfo_hash", hash).
+			Str("policy", string(c.narInfoConflictPolicy)).
+			Str("kind", kind).
+			Str("picked_hostname", picked.uc.GetHostname()).
+			Str("conflicting_hostname", candidate.uc.GetHostname()).
+			Msg("the upstreams have conflicting narinfos")
+	}
+}
+
+// narInfoConflictKind returns the most severe difference between the narinfos
+// a and b, or the empty string when they do not conflict: the content, when
+// they describe different NARs; the representation, when the same NAR is
+// served differently; the signatures, when the same NAR is signed by other
+// keys.
+func narInfoConflictKind(a, b *narinfo.NarInfo) string {
+	switch {
+	case a.StorePath != b.StorePath || a.NarSize != b.NarSize || !nixHashEqual(a.NarHash, b.NarHash):
+		return narInfoConflictContent
+	case a.Compression != b.Compression || a.FileSize != b.FileSize || !nixHashEqual(a.FileHash, b.FileHash):
+		return narInfoConflictRepresentation
+	case !slices.Equal(signatureStrings(a.Signatures), signatureStrings(b.Signatures)):
+		return narInfoConflictSignatures
+	default:
+		return ""
+	}
+}
+
+// nixHashEqual reports whether a and b are the same hash, whatever their
+// encoding.
+func nixHashEqual(a, b *nixhash.HashWithEncoding) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Format(nixhash.NixBase32, true) == b.Format(nixhash.NixBase32, true)
+}
+
+// signatureStrings returns the signatures formatted and sorted, to be compared
+// whatever their order.
+func signatureStrings(sigs []signature.Signature) []string {
+	s := make([]string, 0, len(sigs))
+	for _, sig := range sigs {
+		s = append(s, sig.String())
+	}
+
+	slices.Sort(s)
+
+	return s
+}
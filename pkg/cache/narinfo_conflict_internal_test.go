@@ -0,0 +1,199 @@
+package cache
+
+import (
+	"crypto/rand"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestParseNarInfoConflictPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, err := ParseNarInfoConflictPolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, NarInfoConflictPolicyFastest, policy)
+
+	for _, p := range []NarInfoConflictPolicy{
+		NarInfoConflictPolicyFastest,
+		NarInfoConflictPolicyFirstHealthy,
+		NarInfoConflictPolicyPreferSignedBy,
+		NarInfoConflictPolicyPreferSmallest,
+	} {
+		policy, err := ParseNarInfoConflictPolicy(string(p))
+		require.NoError(t, err)
+		assert.Equal(t, p, policy)
+	}
+
+	_, err = ParseNarInfoConflictPolicy("random")
+	require.ErrorIs(t, err, ErrUnknownNarInfoConflictPolicy)
+}
+
+func TestNarInfoConflictKind(t *testing.T) {
+	t.Parallel()
+
+	parse := func(t *testing.T) *narinfo.NarInfo {
+		t.Helper()
+
+		ni, err := narinfo.Parse(strings.NewReader(testdata.Nar1.NarInfoText))
+		require.NoError(t, err)
+
+		return ni
+	}
+
+	a := parse(t)
+
+	assert.Empty(t, narInfoConflictKind(a, parse(t)))
+
+	b := parse(t)
+	b.Signatures = nil
+	assert.Equal(t, narInfoConflictSignatures, narInfoConflictKind(a, b))
+
+	b = parse(t)
+	b.Compression = "zstd"
+	assert.Equal(t, narInfoConflictRepresentation, narInfoConflictKind(a, b))
+
+	b = parse(t)
+	b.NarSize++
+	assert.Equal(t, narInfoConflictContent, narInfoConflictKind(a, b))
+}
+
+func TestGetNarInfoFromEveryUpstream(t *testing.T) {
+	t.Parallel()
+
+	narInfoPath := "/" + testdata.Nar1.NarInfoHash + ".narinfo"
+
+	sk, pk, err := signature.GenerateKeypair("other-cache-1", rand.Reader)
+	require.NoError(t, err)
+
+	// The other upstream serves the NAR smaller and signed by its own key.
+	other, err := narinfo.Parse(strings.NewReader(testdata.Nar1.NarInfoText))
+	require.NoError(t, err)
+
+	other.FileSize--
+
+	sig, err := sk.Sign(rand.Reader, other.Fingerprint())
+	require.NoError(t, err)
+
+	other.Signatures = []signature.Signature{sig}
+
+	// setup returns a cache with the upstream serving the narinfo of testdata
+	// and, with a lower priority, the other one.
+	setup := func(t *testing.T) (*Cache, []*upstream.Cache) {
+		t.Helper()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		primary := testdata.NewTestServer(t, 10)
+		t.Cleanup(primary.Close)
+
+		secondary := testdata.NewTestServer(t, 20)
+		t.Cleanup(secondary.Close)
+
+		secondary.AddMaybeHandler(func(w http.ResponseWriter, r *http.Request) bool {
+			if r.URL.Path != narInfoPath {
+				return false
+			}
+
+			if r.Method == http.MethodGet {
+				_, _ = w.Write([]byte(other.String()))
+			}
+
+			return true
+		})
+
+		ucs := make([]*upstream.Cache, 0, 2)
+
+		for _, ts := range []*testdata.Server{primary, secondary} {
+			uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), nil)
+			require.NoError(t, err)
+
+			c.AddUpstreamCaches(newContext(), uc)
+
+			ucs = append(ucs, uc)
+		}
+
+		<-c.GetHealthChecker().Trigger()
+
+		return c, ucs
+	}
+
+	tests := []struct {
+		name   string
+		policy NarInfoConflictPolicy
+		keys   []signature.PublicKey
+		want   int
+	}{
+		{name: "first-healthy picks the highest priority", policy: NarInfoConflictPolicyFirstHealthy, want: 0},
+		{
+			name:   "prefer-signed-by picks the narinfo signed by the key",
+			policy: NarInfoConflictPolicyPreferSignedBy,
+			keys:   []signature.PublicKey{pk},
+			want:   1,
+		},
+		{name: "prefer-smallest picks the smallest download", policy: NarInfoConflictPolicyPreferSmallest, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c, ucs := setup(t)
+
+			require.NoError(t, c.SetNarInfoConflictPolicy(tt.policy, tt.keys))
+			require.True(t, c.resolvesNarInfoConflicts())
+
+			for range 3 {
+				uc, ni := c.getNarInfoFromEveryUpstream(newContext(), testdata.Nar1.NarInfoHash)
+				require.NotNil(t, ni)
+				assert.Same(t, ucs[tt.want], uc, "the pick should not depend on which upstream answers first")
+			}
+		})
+	}
+
+	t.Run("prefer-signed-by falls back to first-healthy", func(t *testing.T) {
+		t.Parallel()
+
+		c, ucs := setup(t)
+
+		_, unknown, err := signature.GenerateKeypair("unknown-1", rand.Reader)
+		require.NoError(t, err)
+
+		require.NoError(t, c.SetNarInfoConflictPolicy(NarInfoConflictPolicyPreferSignedBy, []signature.PublicKey{unknown}))
+
+		uc, _ := c.getNarInfoFromEveryUpstream(newContext(), testdata.Nar1.NarInfoHash)
+		assert.Same(t, ucs[0], uc)
+	})
+
+	t.Run("prefer-signed-by requires a key", func(t *testing.T) {
+		t.Parallel()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		require.ErrorIs(t, c.SetNarInfoConflictPolicy(NarInfoConflictPolicyPreferSignedBy, nil),
+			ErrNarInfoConflictKeysRequired)
+	})
+
+	t.Run("no upstream has the narinfo", func(t *testing.T) {
+		t.Parallel()
+
+		c, _ := setup(t)
+
+		require.NoError(t, c.SetNarInfoConflictPolicy(NarInfoConflictPolicyFirstHealthy, nil))
+
+		uc, ni := c.getNarInfoFromEveryUpstream(newContext(), "00000000000000000000000000000000")
+		assert.Nil(t, uc)
+		assert.Nil(t, ni)
+	})
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+)
+
+var (
+	// ErrInvalidNarInfoField is returned by ParseNarInfoField for a rule that is
+	// not of the form NAME=VALUE, with a field name of letters, digits and
+	// dashes and a value on a single line.
+	ErrInvalidNarInfoField = errors.New("the narinfo field must be of the form NAME=VALUE")
+
+	// ErrProtectedNarInfoField is returned by ParseNarInfoField for a field
+	// identifying the store path or its NAR, which is never overridden.
+	ErrProtectedNarInfoField = errors.New("the narinfo field cannot be overridden")
+)
+
+//nolint:gochecknoglobals
+var narInfoFieldNameRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+
+//nolint:gochecknoglobals // The fields covered by the NAR and its signatures.
+var protectedNarInfoFields = map[string]struct{}{
+	"StorePath":   {},
+	"URL":         {},
+	"Compression": {},
+	"FileHash":    {},
+	"FileSize":    {},
+	"NarHash":     {},
+	"NarSize":     {},
+	"References":  {},
+	"Sig":         {},
+}
+
+// NarInfoField is a field set on every narinfo served. The Deriver, System and
+// CA fields of the narinfos are overridden by it, or removed by an empty
+// value; any other field is added after them, for the clients of the cache to
+// read. The fields of the narinfos without a NarInfoField are served as
+// stored, the CA of the content-addressed paths included.
+type NarInfoField struct {
+	Name  string
+	Value string
+}
+
+// ParseNarInfoField parses the NAME=VALUE of a NarInfoField.
+func ParseNarInfoField(raw string) (NarInfoField, error) {
+	name, value, ok := strings.Cut(raw, "=")
+
+	name = strings.TrimSpace(name)
+	value = strings.TrimSpace(value)
+
+	if !ok || !narInfoFieldNameRegexp.MatchString(name) || strings.ContainsAny(value, "\r\n") {
+		return NarInfoField{}, fmt.Errorf("%w: %q", ErrInvalidNarInfoField, raw)
+	}
+
+	if _, ok := protectedNarInfoFields[name]; ok {
+		return NarInfoField{}, fmt.Errorf("%w: %q", ErrProtectedNarInfoField, name)
+	}
+
+	return NarInfoField{Name: name, Value: value}, nil
+}
+
+// SetNarInfoFields configures the fields set on every narinfo served, the last
+// of a name winning. See NarInfoField.
+func (c *Cache) SetNarInfoFields(fields []NarInfoField) {
+	c.narInfoFields = nil
+
+	seen := make(map[string]int, len(fields))
+
+	for _, field := range fields {
+		if i, ok := seen[field.Name]; ok {
+			c.narInfoFields[i] = field
+
+			continue
+		}
+
+		seen[field.Name] = len(c.narInfoFields)
+		c.narInfoFields = append(c.narInfoFields, field)
+	}
+}
+
+// narInfoFieldValue returns the field of narInfo overridden by the
+// NarInfoField of the name, or nil for an additional field.
+func narInfoFieldValue(narInfo *narinfo.NarInfo, name string) *string {
+	switch name {
+	case "Deriver":
+		return &narInfo.Deriver
+	case "System":
+		return &narInfo.System
+	case "CA":
+		return &narInfo.CA
+	default:
+		return nil
+	}
+}
+
+// applyNarInfoFields returns narInfo with its fields overridden by the
+// NarInfoFields, none of which is covered by the signatures. narInfo itself is
+// returned, and it is never modified, while no field overrides it.
+func (c *Cache) applyNarInfoFields(narInfo *narinfo.NarInfo) *narinfo.NarInfo {
+	served := narInfo
+
+	for _, field := range c.narInfoFields {
+		dst := narInfoFieldValue(served, field.Name)
+		if dst == nil || *dst == field.Value {
+			continue
+		}
+
+		if served == narInfo {
+			cp := *narInfo
+			served = &cp
+			dst = narInfoFieldValue(served, field.Name)
+		}
+
+		*dst = field.Value
+	}
+
+	return served
+}
+
+// FormatNarInfo returns the narinfo served to the clients, narInfo as returned
+// by ServedNarInfo followed by the additional NarInfoFields.
+func (c *Cache) FormatNarInfo(narInfo *narinfo.NarInfo) string {
+	s := narInfo.String()
+
+	var sb strings.Builder
+
+	for _, field := range c.narInfoFields {
+		if field.Value == "" || narInfoFieldValue(narInfo, field.Name) != nil {
+			continue
+		}
+
+		sb.WriteString(field.Name)
+		sb.WriteString(": ")
+		sb.WriteString(field.Value)
+		sb.WriteByte('\n')
+	}
+
+	if sb.Len() == 0 {
+		return s
+	}
+
+	return s + sb.String()
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestParseNarInfoField(t *testing.T) {
+	t.Parallel()
+
+	field, err := ParseNarInfoField("Priority=20")
+	require.NoError(t, err)
+	assert.Equal(t, NarInfoField{Name: "Priority", Value: "20"}, field)
+
+	field, err = ParseNarInfoField("CA=")
+	require.NoError(t, err)
+	assert.Equal(t, NarInfoField{Name: "CA"}, field, "an empty value removes the field")
+
+	for _, raw := range []string{"Priority", "=20", "Pri ority=20", "X-Note=a\nb"} {
+		_, err := ParseNarInfoField(raw)
+		require.ErrorIs(t, err, ErrInvalidNarInfoField, raw)
+	}
+
+	for _, raw := range []string{"StorePath=/nix/store/x", "NarHash=sha256:x", "Sig=", "References="} {
+		_, err := ParseNarInfoField(raw)
+		require.ErrorIs(t, err, ErrProtectedNarInfoField, raw)
+	}
+}
+
+func TestNarInfoFields(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	ni, err := narinfo.Parse(strings.NewReader(testdata.Nar1.NarInfoText))
+	require.NoError(t, err)
+
+	ni.CA = "fixed:r:sha256:1s8p1kgdms8rmxkq24q51wc7zpn0aqcwgzvc473v9cii7z2qyxq0"
+
+	t.Run("no field serves the narinfo as stored", func(t *testing.T) {
+		t.Parallel()
+
+		served, err := c.ServedNarInfo(newContext(), testdata.Nar1.NarInfoHash, ni)
+		require.NoError(t, err)
+
+		assert.Same(t, ni, served)
+		assert.Equal(t, ni.String(), c.FormatNarInfo(served), "the CA is passed through verbatim")
+	})
+
+	t.Run("fields override and extend the narinfo", func(t *testing.T) {
+		t.Parallel()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		c.SetNarInfoFields([]NarInfoField{
+			{Name: "System", Value: "aarch64-linux"},
+			{Name: "Deriver"},
+			{Name: "Priority", Value: "30"},
+			{Name: "Priority", Value: "20"},
+			{Name: "X-Empty"},
+		})
+
+		served, err := c.ServedNarInfo(newContext(), testdata.Nar1.NarInfoHash, ni)
+		require.NoError(t, err)
+
+		assert.NotSame(t, ni, served)
+		assert.NotEmpty(t, ni.Deriver, "the stored narinfo is not modified")
+
+		body := c.FormatNarInfo(served)
+		assert.Contains(t, body, "System: aarch64-linux\n")
+		assert.NotContains(t, body, "Deriver:")
+		assert.Contains(t, body, "CA: "+ni.CA+"\n")
+		assert.True(t, strings.HasSuffix(body, "\nPriority: 20\n"), "the last field of a name wins")
+		assert.NotContains(t, body, "X-Empty")
+		assert.Equal(t, ni.Signatures, served.Signatures, "the signatures do not cover the overridden fields")
+	})
+}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog"
+
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+)
+
+// narInfoBackfillBatchSize is the number of narinfos loaded at once by
+// BackfillNarInfoFileHashes.
+const narInfoBackfillBatchSize = 1000
+
+// NarInfoBackfillResult is the outcome of a BackfillNarInfoFileHashes run.
+type NarInfoBackfillResult struct {
+	// Checked is the number of narinfos of a compressed NAR missing their
+	// FileHash or their FileSize.
+	Checked int
+
+	// Backfilled is the number of them whose FileHash and FileSize were
+	// computed from the stored NAR.
+	Backfilled int
+
+	// Failed is the number of them whose backfill failed. A narinfo whose NAR
+	// is not stored is neither backfilled nor failed.
+	Failed int
+}
+
+// BackfillNarInfoFileHashes computes the FileHash and the FileSize missing
+// from the narinfos of the compressed NARs, older records or upstreams
+// omitting them, from the stored NARs. The narinfos of the uncompressed and
+// the chunked NARs carry neither, by spec, and are left alone.
+func (c *Cache) BackfillNarInfoFileHashes(ctx context.Context) (NarInfoBackfillResult, error) {
+	var (
+		result NarInfoBackfillResult
+		lastID int
+	)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		nis, err := c.dbClient.Ent().NarInfo.Query().
+			Where(
+				entnarinfo.IDGT(lastID),
+				entnarinfo.URLNotNil(),
+				entnarinfo.CompressionNotNil(),
+				entnarinfo.CompressionNEQ(nar.CompressionTypeNone.String()),
+				entnarinfo.Or(
+					entnarinfo.FileHashIsNil(),
+					entnarinfo.FileHashEQ(""),
+					entnarinfo.FileSizeIsNil(),
+					entnarinfo.FileSizeEQ(0),
+				),
+			).
+			Order(entnarinfo.ByID()).
+			Limit(narInfoBackfillBatchSize).
+			Select(entnarinfo.FieldID, entnarinfo.FieldHash).
+			All(ctx)
+		if err != nil {
+			return result, fmt.Errorf("error listing the narinfos to backfill: %w", err)
+		}
+
+		for _, ni := range nis {
+			result.Checked++
+
+			if err := c.checkAndFixNarInfo(ctx, ni.Hash, narInfoFixupTriggerBackground); err != nil {
+				zerolog.Ctx(ctx).
+					Warn().
+					Err(err).
+					Str("narinfo_hash", ni.Hash).
+					Msg("error backfilling the narinfo file hash")
+
+				result.Failed++
+
+				continue
+			}
+
+			backfilled, err := c.dbClient.Ent().NarInfo.Query().
+				Where(
+					entnarinfo.ID(ni.ID),
+					entnarinfo.FileHashNotNil(),
+					entnarinfo.FileHashNEQ(""),
+					entnarinfo.FileSizeNotNil(),
+					entnarinfo.FileSizeNEQ(0),
+				).
+				Exist(ctx)
+			if err != nil {
+				return result, fmt.Errorf("error checking the backfilled narinfo %s: %w", ni.Hash, err)
+			}
+
+			if backfilled {
+				result.Backfilled++
+			}
+		}
+
+		if len(nis) < narInfoBackfillBatchSize {
+			return result, nil
+		}
+
+		lastID = nis[len(nis)-1].ID
+	}
+}
+
+// AddNarInfoFileHashBackfillCronJob adds a periodic job running
+// BackfillNarInfoFileHashes.
+func (c *Cache) AddNarInfoFileHashBackfillCronJob(ctx context.Context, schedule cron.Schedule) {
+	zerolog.Ctx(ctx).
+		Info().
+		Time("next-run", schedule.Next(time.Now())).
+		Msg("adding a cronjob for the narinfo file hash backfill")
+
+	c.scheduleCronJob(ctx, CronJobNarInfoFileHashBackfill, schedule, func(ctx context.Context) func() {
+		return func() {
+			result, err := c.BackfillNarInfoFileHashes(ctx)
+			if err != nil {
+				zerolog.Ctx(ctx).
+					Error().
+					Err(err).
+					Msg("error backfilling the narinfo file hashes")
+
+				recordCronJobError(ctx, err)
+
+				return
+			}
+
+			zerolog.Ctx(ctx).
+				Info().
+				Int("checked", result.Checked).
+				Int("backfilled", result.Backfilled).
+				Int("failed", result.Failed).
+				Msg("narinfo file hash backfill completed")
+		}
+	})
+}
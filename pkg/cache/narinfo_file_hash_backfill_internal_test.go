@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestBackfillNarInfoFileHashes(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	ctx := newContext()
+	db := c.dbClient.Ent()
+
+	// A compressed NAR stored whole, its narinfo missing its FileHash and its
+	// FileSize.
+	stored := testdata.Nar1
+
+	narURL := nar.URL{Hash: stored.NarHash, Compression: stored.NarCompression}
+	require.NoError(t, c.PutNar(ctx, narURL, io.NopCloser(strings.NewReader(stored.NarText))))
+	require.NoError(t, c.PutNarInfo(ctx, stored.NarInfoHash, io.NopCloser(strings.NewReader(stored.NarInfoText))))
+
+	_, err := db.NarInfo.Update().
+		Where(entnarinfo.HashEQ(stored.NarInfoHash)).
+		ClearFileHash().
+		ClearFileSize().
+		Save(ctx)
+	require.NoError(t, err)
+
+	// A narinfo of a compressed NAR that is not stored.
+	_, err = db.NarInfo.Create().
+		SetHash("narinfo-without-nar").
+		SetURL("nar/1111111111111111111111111111111111111111111111111111.nar.xz").
+		SetCompression(nar.CompressionTypeXz.String()).
+		Save(ctx)
+	require.NoError(t, err)
+
+	result, err := c.BackfillNarInfoFileHashes(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, NarInfoBackfillResult{Checked: 2, Backfilled: 1}, result)
+
+	ni, err := db.NarInfo.Query().Where(entnarinfo.HashEQ(stored.NarInfoHash)).Only(ctx)
+	require.NoError(t, err)
+
+	require.NotNil(t, ni.FileHash)
+
+	sum := sha256.Sum256([]byte(stored.NarText))
+	assert.Equal(t,
+		nixhash.MustNewHashWithEncoding(nixhash.SHA256, sum[:], nixhash.NixBase32, true).String(),
+		*ni.FileHash)
+
+	require.NotNil(t, ni.FileSize)
+	assert.Equal(t, int64(len(stored.NarText)), *ni.FileSize)
+
+	// The backfilled narinfo is not checked again.
+	result, err = c.BackfillNarInfoFileHashes(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, NarInfoBackfillResult{Checked: 1}, result)
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// The triggers of a narinfo fix-up: inline when a NAR or a narinfo is stored,
+// background when the narinfos are swept on a schedule.
+const (
+	narInfoFixupTriggerInline     = "inline"
+	narInfoFixupTriggerBackground = "background"
+)
+
+// The narinfo fields fixed up.
+const (
+	narInfoFixupFieldFileSize = "file_size"
+	narInfoFixupFieldFileHash = "file_hash"
+)
+
+// The reasons of a narinfo fix-up.
+const (
+	// narInfoFixupReasonMismatch is a FileSize differing from the size of the
+	// stored NAR, corrected.
+	narInfoFixupReasonMismatch = "mismatch"
+
+	// narInfoFixupReasonMissing is a FileHash missing from the narinfo of a
+	// compressed NAR, computed from the stored NAR.
+	narInfoFixupReasonMissing = "missing"
+
+	// narInfoFixupReasonUncompressed is a FileSize or a FileHash set on the
+	// narinfo of an uncompressed NAR, which carries neither by spec, cleared.
+	narInfoFixupReasonUncompressed = "uncompressed"
+)
+
+// recordNarInfoFixup counts an automatic fix of a narinfo field and logs it,
+// after the fix was written.
+func recordNarInfoFixup(ctx context.Context, hash, trigger, field, reason string) {
+	zerolog.Ctx(ctx).
+		Info().
+		Str("narinfo_hash", hash).
+		Str("trigger", trigger).
+		Str("field", field).
+		Str("reason", reason).
+		Msg("narinfo fixed up")
+
+	if narInfoFixupsTotal == nil {
+		return
+	}
+
+	narInfoFixupsTotal.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("trigger", trigger),
+		attribute.String("field", field),
+		attribute.String("reason", reason),
+	))
+}
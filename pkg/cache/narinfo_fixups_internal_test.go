@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestCheckAndFixNarInfoRecordsFixups(t *testing.T) {
+	t.Parallel()
+
+	c, dbClient, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	// Nar4 is uncompressed, its narinfo must carry neither FileSize nor FileHash.
+	r := io.NopCloser(strings.NewReader(testdata.Nar4.NarInfoText))
+	require.NoError(t, c.PutNarInfo(newContext(), testdata.Nar4.NarInfoHash, r))
+
+	_, err := dbClient.Ent().NarInfo.Update().
+		Where(entnarinfo.HashEQ(testdata.Nar4.NarInfoHash)).
+		SetFileSize(226488).
+		SetFileHash("sha256:" + testdata.Nar4.NarHash).
+		Save(context.Background())
+	require.NoError(t, err)
+
+	var logBuf bytes.Buffer
+
+	ctx := zerolog.New(&logBuf).WithContext(context.Background())
+
+	require.NoError(t, c.checkAndFixNarInfo(ctx, testdata.Nar4.NarInfoHash, narInfoFixupTriggerBackground))
+
+	logs := logBuf.String()
+	assert.Equal(t, 2, strings.Count(logs, `"message":"narinfo fixed up"`))
+	assert.Contains(t, logs, `"trigger":"background","field":"file_size","reason":"uncompressed"`)
+	assert.Contains(t, logs, `"trigger":"background","field":"file_hash","reason":"uncompressed"`)
+
+	// Nothing is left to fix.
+	logBuf.Reset()
+
+	require.NoError(t, c.checkAndFixNarInfo(ctx, testdata.Nar4.NarInfoHash, narInfoFixupTriggerBackground))
+	assert.NotContains(t, logBuf.String(), "narinfo fixed up")
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/kalbasit/ncps/pkg/analytics"
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+)
+
+const (
+	narInfoHedgeResultWin  = "win"
+	narInfoHedgeResultLoss = "loss"
+)
+
+// narInfoHedging configures the hedging of narinfo HEAD probes. See
+// SetNarInfoHedging.
+type narInfoHedging struct {
+	// delay is used as the hedge delay of an upstream whose p95 latency is not
+	// known yet.
+	delay time.Duration
+}
+
+// SetNarInfoHedging makes the narinfo HEAD probes hedged instead of sent to
+// every healthy upstream at once: the upstreams are probed in priority order,
+// and the next one is probed when the current one lacks the narinfo, fails, or
+// has not answered within its p95 probe latency (delay until that latency is
+// known). The first upstream to report the narinfo wins and the other probes
+// are canceled. A non-positive delay disables hedging.
+func (c *Cache) SetNarInfoHedging(delay time.Duration) {
+	if delay <= 0 {
+		c.narInfoHedging = nil
+
+		return
+	}
+
+	c.narInfoHedging = &narInfoHedging{delay: delay}
+}
+
+// hedgeDelay returns how long to wait on uc before hedging its probe.
+func (h *narInfoHedging) hedgeDelay(uc *upstream.Cache) time.Duration {
+	if p95, ok := uc.NarInfoProbeP95(); ok {
+		return p95
+	}
+
+	return h.delay
+}
+
+type narInfoProbe struct {
+	idx    int
+	exists bool
+	err    error
+}
+
+// hedgeNarInfoUpstream returns the first of ucs, in priority order, found to
+// have the narinfo of hash, hedging the probes as described by
+// SetNarInfoHedging. It returns nil when no upstream has it.
+func (c *Cache) hedgeNarInfoUpstream(
+	ctx context.Context,
+	hash string,
+	ucs []*upstream.Cache,
+) (*upstream.Cache, error) {
+	if len(ucs) == 0 {
+		//nolint:nilnil
+		return nil, nil
+	}
+
+	if len(ucs) == 1 {
+		return ucs[0], nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan narInfoProbe, len(ucs))
+
+	var (
+		next    int
+		pending int
+		hedged  = make(map[int]bool)
+		winner  = -1
+	)
+
+	defer func() { recordNarInfoHedges(ctx, hedged, winner) }()
+
+	probeNext := func() time.Duration {
+		idx := next
+		uc := ucs[idx]
+
+		next++
+		pending++
+
+		analytics.SafeGo(ctx, func() {
+			exists, err := uc.HasNarInfo(ctx, hash)
+			results <- narInfoProbe{idx: idx, exists: exists, err: err}
+		})
+
+		return c.narInfoHedging.hedgeDelay(uc)
+	}
+
+	timer := time.NewTimer(probeNext())
+	defer timer.Stop()
+
+	var errs error
+
+	for pending > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, errors.Join(ctx.Err(), errs)
+		case <-timer.C:
+			// The probe is slow: hedge it with the next upstream.
+			if next < len(ucs) {
+				hedged[next] = true
+
+				timer.Reset(probeNext())
+			}
+		case probe := <-results:
+			pending--
+
+			if probe.err == nil && probe.exists {
+				winner = probe.idx
+
+				return ucs[probe.idx], errs
+			}
+
+			if probe.err != nil && !errors.Is(probe.err, context.Canceled) {
+				errs = errors.Join(errs, probe.err)
+			}
+
+			// The upstream lacks the narinfo: move on without waiting.
+			if next < len(ucs) {
+				timer.Reset(probeNext())
+			}
+		}
+	}
+
+	return nil, errs
+}
+
+// recordNarInfoHedges counts the hedged probes, by whether they found the
+// narinfo first.
+func recordNarInfoHedges(ctx context.Context, hedged map[int]bool, winner int) {
+	if narInfoHedgesTotal == nil {
+		return
+	}
+
+	for idx := range hedged {
+		result := narInfoHedgeResultLoss
+		if idx == winner {
+			result = narInfoHedgeResultWin
+		}
+
+		narInfoHedgesTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+	}
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestHedgeNarInfoUpstream(t *testing.T) {
+	t.Parallel()
+
+	narInfoPath := "/" + testdata.Nar1.NarInfoHash + ".narinfo"
+
+	newUpstreams := func(t *testing.T, c *Cache, servers ...*testdata.Server) []*upstream.Cache {
+		t.Helper()
+
+		ucs := make([]*upstream.Cache, 0, len(servers))
+
+		for _, ts := range servers {
+			uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), nil)
+			require.NoError(t, err)
+
+			c.AddUpstreamCaches(newContext(), uc)
+
+			ucs = append(ucs, uc)
+		}
+
+		<-c.GetHealthChecker().Trigger()
+
+		return ucs
+	}
+
+	t.Run("a slow upstream is hedged", func(t *testing.T) {
+		t.Parallel()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		c.SetNarInfoHedging(20 * time.Millisecond)
+
+		// The slow upstream has the higher priority but holds the narinfo back
+		// until its probe is canceled.
+		slow := testdata.NewTestServer(t, 10)
+		t.Cleanup(slow.Close)
+
+		var cancelOnce sync.Once
+
+		slowCanceled := make(chan struct{})
+
+		slow.AddMaybeHandler(func(w http.ResponseWriter, r *http.Request) bool {
+			if r.Method != http.MethodHead || r.URL.Path != narInfoPath {
+				return false
+			}
+
+			select {
+			case <-r.Context().Done():
+				cancelOnce.Do(func() { close(slowCanceled) })
+			case <-time.After(10 * time.Second):
+				w.WriteHeader(http.StatusGatewayTimeout)
+			}
+
+			return true
+		})
+
+		fast := testdata.NewTestServer(t, 20)
+		t.Cleanup(fast.Close)
+
+		ucs := newUpstreams(t, c, slow, fast)
+
+		start := time.Now()
+
+		uc, err := c.selectNarInfoUpstream(newContext(), testdata.Nar1.NarInfoHash)
+		require.NoError(t, err)
+		assert.Same(t, ucs[1], uc, "the hedged upstream should win")
+		assert.Less(t, time.Since(start), 5*time.Second)
+
+		select {
+		case <-slowCanceled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the slow probe should be canceled once the hedged one won")
+		}
+	})
+
+	t.Run("a missing narinfo moves on without hedging", func(t *testing.T) {
+		t.Parallel()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		// A delay long enough that no probe is ever hedged.
+		c.SetNarInfoHedging(time.Minute)
+
+		missing := testdata.NewTestServer(t, 10)
+		t.Cleanup(missing.Close)
+
+		missing.AddMaybeHandler(func(w http.ResponseWriter, r *http.Request) bool {
+			if r.URL.Path != narInfoPath {
+				return false
+			}
+
+			w.WriteHeader(http.StatusNotFound)
+
+			return true
+		})
+
+		found := testdata.NewTestServer(t, 20)
+		t.Cleanup(found.Close)
+
+		// The lowest-priority upstream is never probed.
+		var lastProbes atomic.Int64
+
+		last := testdata.NewTestServer(t, 30)
+		t.Cleanup(last.Close)
+
+		last.AddMaybeHandler(func(_ http.ResponseWriter, r *http.Request) bool {
+			if r.URL.Path == narInfoPath {
+				lastProbes.Add(1)
+			}
+
+			return false
+		})
+
+		ucs := newUpstreams(t, c, missing, found, last)
+
+		uc, err := c.selectNarInfoUpstream(newContext(), testdata.Nar1.NarInfoHash)
+		require.NoError(t, err)
+		assert.Same(t, ucs[1], uc)
+		assert.Zero(t, lastProbes.Load())
+
+		// No upstream has an unknown narinfo.
+		uc, err = c.selectNarInfoUpstream(newContext(), "00000000000000000000000000000000")
+		require.NoError(t, err)
+		assert.Nil(t, uc)
+	})
+}
@@ -0,0 +1,205 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog"
+
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+
+	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/pkg/bloom"
+	"github.com/kalbasit/ncps/pkg/zstd"
+)
+
+const (
+	// narInfoIndexBatchSize is the number of narinfo hashes listed per query
+	// while building the narinfo index.
+	narInfoIndexBatchSize = 5000
+
+	// narInfoBloomFalsePositiveRate is the rate of the hashes not cached that
+	// the bloom filter of the narinfo index reports as cached.
+	narInfoBloomFalsePositiveRate = 0.01
+)
+
+// NarInfoIndex is a listing of the hashes of the cached narinfos, letting a
+// client diff its store paths against the cache in one request.
+type NarInfoIndex struct {
+	// Data is the listing, one narinfo hash per line in ascending order,
+	// compressed with zstd.
+	Data []byte
+
+	// ETag is the strong entity tag of Data, quoted.
+	ETag string
+
+	// LastModified is when the listing last changed; rebuilding an unchanged
+	// listing keeps it.
+	LastModified time.Time
+
+	// Count is the number of narinfo hashes listed.
+	Count int
+
+	// Bloom is the bloom filter of the hashes listed, compressed with zstd,
+	// letting a peer skip asking the cache for the narinfos it does not hold.
+	// See the bloom package for its encoding.
+	Bloom []byte
+
+	// BloomETag is the strong entity tag of Bloom, quoted.
+	BloomETag string
+}
+
+// NarInfoIndex returns the narinfo index last built by BuildNarInfoIndex, or
+// nil if it was never built.
+func (c *Cache) NarInfoIndex() *NarInfoIndex {
+	c.narInfoIndexMu.RLock()
+	defer c.narInfoIndexMu.RUnlock()
+
+	return c.narInfoIndex
+}
+
+// BuildNarInfoIndex lists the hashes of the narinfos of the database, with a
+// NAR, into a new narinfo index returned by NarInfoIndex.
+func (c *Cache) BuildNarInfoIndex(ctx context.Context) (*NarInfoIndex, error) {
+	// The filter is sized for the narinfos counted before listing them; the
+	// ones cached in between only raise its false positive rate a little.
+	total, err := c.dbClient.Ent().NarInfo.Query().
+		Where(entnarinfo.URLNotNil()).
+		Count(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error counting the narinfos: %w", err)
+	}
+
+	filter := bloom.New(total, narInfoBloomFalsePositiveRate)
+
+	var buf bytes.Buffer
+
+	zw := zstd.NewPooledWriter(&buf)
+	defer zw.Close()
+
+	count := 0
+	lastHash := ""
+
+	for {
+		hashes, err := c.dbClient.Ent().NarInfo.Query().
+			Where(entnarinfo.HashGT(lastHash), entnarinfo.URLNotNil()).
+			Order(ent.Asc(entnarinfo.FieldHash)).
+			Limit(narInfoIndexBatchSize).
+			Select(entnarinfo.FieldHash).
+			Strings(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing the narinfos: %w", err)
+		}
+
+		for _, hash := range hashes {
+			if _, err := zw.Write([]byte(hash + "\n")); err != nil {
+				return nil, fmt.Errorf("error compressing the narinfo index: %w", err)
+			}
+
+			filter.Add(hash)
+		}
+
+		count += len(hashes)
+
+		if len(hashes) < narInfoIndexBatchSize {
+			break
+		}
+
+		lastHash = hashes[len(hashes)-1]
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("error compressing the narinfo index: %w", err)
+	}
+
+	bloomData, err := encodeNarInfoBloom(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &NarInfoIndex{
+		Data:         buf.Bytes(),
+		ETag:         strongETag(buf.Bytes()),
+		LastModified: time.Now().UTC().Truncate(time.Second),
+		Count:        count,
+		Bloom:        bloomData,
+		BloomETag:    strongETag(bloomData),
+	}
+
+	c.narInfoIndexMu.Lock()
+	defer c.narInfoIndexMu.Unlock()
+
+	if prev := c.narInfoIndex; prev != nil && prev.ETag == idx.ETag {
+		idx.LastModified = prev.LastModified
+	}
+
+	c.narInfoIndex = idx
+
+	return idx, nil
+}
+
+// encodeNarInfoBloom encodes filter compressed with zstd.
+func encodeNarInfoBloom(filter *bloom.Filter) ([]byte, error) {
+	data, err := filter.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("error encoding the narinfo bloom filter: %w", err)
+	}
+
+	var buf bytes.Buffer
+
+	zw := zstd.NewPooledWriter(&buf)
+	defer zw.Close()
+
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("error compressing the narinfo bloom filter: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("error compressing the narinfo bloom filter: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// strongETag returns the quoted strong entity tag of data.
+func strongETag(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// AddNarInfoIndexCronJob adds a periodic job rebuilding the narinfo index.
+func (c *Cache) AddNarInfoIndexCronJob(ctx context.Context, schedule cron.Schedule) {
+	zerolog.Ctx(ctx).
+		Info().
+		Time("next-run", schedule.Next(time.Now())).
+		Msg("adding a cronjob for the narinfo index")
+
+	c.scheduleCronJob(ctx, CronJobNarInfoIndex, schedule, func(ctx context.Context) func() {
+		return func() {
+			idx, err := c.BuildNarInfoIndex(ctx)
+			if err != nil {
+				zerolog.Ctx(ctx).
+					Error().
+					Err(err).
+					Msg("error building the narinfo index")
+
+				recordCronJobError(ctx, err)
+
+				return
+			}
+
+			zerolog.Ctx(ctx).
+				Info().
+				Int("narinfos", idx.Count).
+				Int("size", len(idx.Data)).
+				Int("bloom_size", len(idx.Bloom)).
+				Msg("built the narinfo index")
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/bloom"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/zstd"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestBuildNarInfoIndex(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	assert.Nil(t, c.NarInfoIndex(), "the index is not built yet")
+
+	empty, err := c.BuildNarInfoIndex(newContext())
+	require.NoError(t, err)
+	assert.Equal(t, 0, empty.Count)
+
+	for _, entry := range []testdata.Entry{testdata.Nar2, testdata.Nar1} {
+		narURL := nar.URL{Hash: entry.NarHash, Compression: entry.NarCompression}
+		require.NoError(t, c.PutNar(newContext(), narURL, io.NopCloser(strings.NewReader(entry.NarText))))
+		require.NoError(t, c.PutNarInfo(newContext(), entry.NarInfoHash,
+			io.NopCloser(strings.NewReader(entry.NarInfoText))))
+	}
+
+	idx, err := c.BuildNarInfoIndex(newContext())
+	require.NoError(t, err)
+	assert.Same(t, idx, c.NarInfoIndex())
+	assert.Equal(t, 2, idx.Count)
+	assert.NotEqual(t, empty.ETag, idx.ETag)
+
+	zr, err := zstd.NewPooledReader(strings.NewReader(string(idx.Data)))
+	require.NoError(t, err)
+
+	defer zr.Close()
+
+	listing, err := io.ReadAll(zr)
+	require.NoError(t, err)
+
+	want := []string{testdata.Nar1.NarInfoHash, testdata.Nar2.NarInfoHash}
+	if want[0] > want[1] {
+		want[0], want[1] = want[1], want[0]
+	}
+
+	assert.Equal(t, strings.Join(want, "\n")+"\n", string(listing))
+
+	br, err := zstd.NewPooledReader(strings.NewReader(string(idx.Bloom)))
+	require.NoError(t, err)
+
+	defer br.Close()
+
+	encoded, err := io.ReadAll(br)
+	require.NoError(t, err)
+
+	var filter bloom.Filter
+	require.NoError(t, filter.UnmarshalBinary(encoded))
+
+	for _, hash := range want {
+		assert.True(t, filter.Test(hash), "the bloom filter holds the narinfos listed")
+	}
+
+	// Rebuilding an unchanged listing keeps its Last-Modified.
+	lastModified := idx.LastModified.Add(-time.Hour)
+
+	c.narInfoIndexMu.Lock()
+	c.narInfoIndex.LastModified = lastModified
+	c.narInfoIndexMu.Unlock()
+
+	rebuilt, err := c.BuildNarInfoIndex(newContext())
+	require.NoError(t, err)
+	assert.Equal(t, idx.ETag, rebuilt.ETag)
+	assert.Equal(t, lastModified, rebuilt.LastModified)
+}
@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/kalbasit/ncps/pkg/analytics"
+	"github.com/kalbasit/ncps/pkg/database"
+)
+
+const (
+	// narInfoInvalidationChannel is the PostgreSQL notification channel of
+	// the narinfo invalidations.
+	narInfoInvalidationChannel = "ncps_narinfo_invalidation"
+
+	// narInfoInvalidationBatchSize bounds the hashes of a notification, whose
+	// payload PostgreSQL limits to 8000 bytes.
+	narInfoInvalidationBatchSize = 100
+
+	// narInfoInvalidationRetryDelay is the delay before listening again after
+	// the listening connection broke.
+	narInfoInvalidationRetryDelay = 5 * time.Second
+)
+
+// Directions recorded by ncps_narinfo_invalidations_total.
+const (
+	narInfoInvalidationSent     = "sent"
+	narInfoInvalidationReceived = "received"
+)
+
+// narInfoInvalidation notifies the other replicas of the narinfos stored or
+// deleted, with PostgreSQL LISTEN/NOTIFY.
+type narInfoInvalidation struct {
+	// origin identifies this instance so that it ignores its own
+	// notifications.
+	origin string
+}
+
+// narInfoInvalidationMessage is the payload of a notification.
+type narInfoInvalidationMessage struct {
+	Origin string   `json:"origin"`
+	Hashes []string `json:"hashes"`
+}
+
+// SetNarInfoInvalidation enables the invalidation of the narinfos between the
+// replicas sharing the database: a narinfo stored or deleted by a replica is
+// dropped from the in-memory state of the others, the prefetched narinfos and
+// the narinfo pulls in flight, so that they do not serve it stale. The
+// notifications are received once ListenNarInfoInvalidations is called. It
+// returns database.ErrNotPostgreSQL for the other database types.
+func (c *Cache) SetNarInfoInvalidation(enabled bool) error {
+	if !enabled {
+		c.narInfoInvalidation = nil
+
+		return nil
+	}
+
+	if c.dbClient.Type() != database.TypePostgreSQL {
+		return fmt.Errorf("narinfo invalidation requires LISTEN/NOTIFY: %w", database.ErrNotPostgreSQL)
+	}
+
+	c.narInfoInvalidation = &narInfoInvalidation{origin: rand.Text()}
+
+	return nil
+}
+
+// ListenNarInfoInvalidations receives the narinfo invalidations of the other
+// replicas in the background until ctx is done or the cache is closed. It is a
+// no-op unless enabled by SetNarInfoInvalidation.
+func (c *Cache) ListenNarInfoInvalidations(ctx context.Context) {
+	ni := c.narInfoInvalidation
+	if ni == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	c.backgroundWG.Add(1)
+
+	analytics.SafeGo(ctx, func() {
+		defer c.backgroundWG.Done()
+		defer cancel()
+
+		go func() {
+			select {
+			case <-c.shutdownCh:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		for {
+			err := c.dbClient.Listen(ctx, narInfoInvalidationChannel, func(payload string) {
+				c.handleNarInfoInvalidation(ctx, ni, payload)
+			})
+			if ctx.Err() != nil {
+				return
+			}
+
+			zerolog.Ctx(ctx).
+				Warn().
+				Err(err).
+				Dur("retry_in", narInfoInvalidationRetryDelay).
+				Msg("stopped listening to the narinfo invalidations")
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(narInfoInvalidationRetryDelay):
+			}
+		}
+	})
+}
+
+// publishNarInfoInvalidation notifies the other replicas that the narinfos of
+// hashes were stored or deleted.
+func (c *Cache) publishNarInfoInvalidation(ctx context.Context, hashes ...string) {
+	ni := c.narInfoInvalidation
+	if ni == nil || len(hashes) == 0 {
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+
+	for batch := range slices.Chunk(hashes, narInfoInvalidationBatchSize) {
+		payload, err := json.Marshal(narInfoInvalidationMessage{Origin: ni.origin, Hashes: batch})
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("error encoding the narinfo invalidation")
+
+			return
+		}
+
+		if err := c.dbClient.Notify(ctx, narInfoInvalidationChannel, string(payload)); err != nil {
+			zerolog.Ctx(ctx).
+				Warn().
+				Err(err).
+				Int("count", len(batch)).
+				Msg("error notifying the narinfo invalidation")
+
+			continue
+		}
+
+		recordNarInfoInvalidations(ctx, narInfoInvalidationSent, len(batch))
+	}
+}
+
+// handleNarInfoInvalidation drops the narinfos invalidated by another replica
+// from the in-memory state of this one.
+func (c *Cache) handleNarInfoInvalidation(ctx context.Context, ni *narInfoInvalidation, payload string) {
+	var msg narInfoInvalidationMessage
+
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("error decoding the narinfo invalidation")
+
+		return
+	}
+
+	if msg.Origin == ni.origin {
+		return
+	}
+
+	c.dropLocalNarInfos(msg.Hashes...)
+
+	recordNarInfoInvalidations(ctx, narInfoInvalidationReceived, len(msg.Hashes))
+
+	zerolog.Ctx(ctx).
+		Debug().
+		Strs("hashes", msg.Hashes).
+		Msg("narinfos invalidated by another replica")
+}
+
+// dropLocalNarInfos forgets the prefetched and preloaded narinfos of hashes
+// and detaches the pulls of these narinfos in flight, so that the next
+// requests do not join a pull whose result is stale.
+func (c *Cache) dropLocalNarInfos(hashes ...string) {
+	c.dropWarmNarInfos(hashes...)
+
+	if rp := c.referencePrefetch; rp != nil {
+		rp.mu.Lock()
+
+		for _, hash := range hashes {
+			delete(rp.entries, hash)
+		}
+
+		rp.mu.Unlock()
+	}
+
+	c.upstreamJobsMu.Lock()
+
+	for _, hash := range hashes {
+		delete(c.upstreamJobs, narInfoJobKey(hash))
+	}
+
+	c.upstreamJobsMu.Unlock()
+}
+
+func recordNarInfoInvalidations(ctx context.Context, direction string, count int) {
+	if narInfoInvalidationsTotal == nil {
+		return
+	}
+
+	narInfoInvalidationsTotal.Add(ctx, int64(count), metric.WithAttributes(attribute.String("direction", direction)))
+}
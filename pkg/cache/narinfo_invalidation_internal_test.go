@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/database"
+)
+
+func TestSetNarInfoInvalidation_RequiresPostgreSQL(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	require.ErrorIs(t, c.SetNarInfoInvalidation(true), database.ErrNotPostgreSQL)
+	require.NoError(t, c.SetNarInfoInvalidation(false))
+}
+
+func TestHandleNarInfoInvalidation(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	c.SetReferencePrefetch(1, time.Minute)
+
+	ni := &narInfoInvalidation{origin: "self"}
+
+	const hash = "11111111111111111111111111111111"
+
+	seed := func() {
+		c.referencePrefetch.mu.Lock()
+		c.referencePrefetch.entries[hash] = prefetchedNarInfo{expiresAt: time.Now().Add(time.Minute)}
+		c.referencePrefetch.mu.Unlock()
+
+		c.upstreamJobsMu.Lock()
+		c.upstreamJobs[narInfoJobKey(hash)] = newDownloadState()
+		c.upstreamJobsMu.Unlock()
+	}
+
+	has := func() (bool, bool) {
+		c.referencePrefetch.mu.Lock()
+		_, prefetched := c.referencePrefetch.entries[hash]
+		c.referencePrefetch.mu.Unlock()
+
+		c.upstreamJobsMu.Lock()
+		_, pulling := c.upstreamJobs[narInfoJobKey(hash)]
+		c.upstreamJobsMu.Unlock()
+
+		return prefetched, pulling
+	}
+
+	payload := func(origin string) string {
+		b, err := json.Marshal(narInfoInvalidationMessage{Origin: origin, Hashes: []string{hash}})
+		require.NoError(t, err)
+
+		return string(b)
+	}
+
+	seed()
+
+	t.Run("own notifications are ignored", func(t *testing.T) {
+		c.handleNarInfoInvalidation(newContext(), ni, payload("self"))
+
+		prefetched, pulling := has()
+		assert.True(t, prefetched)
+		assert.True(t, pulling)
+	})
+
+	t.Run("invalid payloads are ignored", func(t *testing.T) {
+		c.handleNarInfoInvalidation(newContext(), ni, "not json")
+
+		prefetched, pulling := has()
+		assert.True(t, prefetched)
+		assert.True(t, pulling)
+	})
+
+	t.Run("other replicas' notifications drop the narinfos", func(t *testing.T) {
+		c.handleNarInfoInvalidation(newContext(), ni, payload("other"))
+
+		prefetched, pulling := has()
+		assert.False(t, prefetched)
+		assert.False(t, pulling)
+	})
+}
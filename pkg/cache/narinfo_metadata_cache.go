@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Results recorded by ncps_narinfo_metadata_cache_requests_total.
+const (
+	narInfoMetadataCacheResultHit   = "hit"
+	narInfoMetadataCacheResultMiss  = "miss"
+	narInfoMetadataCacheResultError = "error"
+)
+
+// NarInfoMetadataCache caches the narinfos read from the database, shared by
+// the replicas of a deployment so that they do not query the database for
+// every narinfo request. The entries are expected to expire on their own; the
+// narinfos stored or deleted are invalidated on every replica.
+type NarInfoMetadataCache interface {
+	// Get returns the narinfo of hash, or false if it is not cached.
+	Get(ctx context.Context, hash string) (string, bool, error)
+
+	// Set caches the narinfo of hash.
+	Set(ctx context.Context, hash, narInfo string) error
+
+	// Delete drops the narinfos of hashes.
+	Delete(ctx context.Context, hashes ...string) error
+}
+
+// SetNarInfoMetadataCache serves the narinfos from mc before the database. A
+// nil mc disables it.
+func (c *Cache) SetNarInfoMetadataCache(mc NarInfoMetadataCache) {
+	c.narInfoMetadataCache = mc
+}
+
+// getNarInfoFromMetadataCache returns the narinfo of hash from the metadata
+// cache, or nil if it is not cached or cannot be read.
+func (c *Cache) getNarInfoFromMetadataCache(ctx context.Context, hash string) *narinfo.NarInfo {
+	if c.narInfoMetadataCache == nil {
+		return nil
+	}
+
+	text, ok, err := c.narInfoMetadataCache.Get(ctx, hash)
+	if err != nil {
+		recordNarInfoMetadataCache(ctx, narInfoMetadataCacheResultError)
+
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(err).
+			Msg("error reading the narinfo from the metadata cache")
+
+		return nil
+	}
+
+	if !ok {
+		recordNarInfoMetadataCache(ctx, narInfoMetadataCacheResultMiss)
+
+		return nil
+	}
+
+	narInfo, err := narinfo.Parse(strings.NewReader(text))
+	if err != nil {
+		recordNarInfoMetadataCache(ctx, narInfoMetadataCacheResultError)
+
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(err).
+			Msg("error parsing the narinfo of the metadata cache")
+
+		c.invalidateNarInfoMetadata(ctx, hash)
+
+		return nil
+	}
+
+	recordNarInfoMetadataCache(ctx, narInfoMetadataCacheResultHit)
+
+	return narInfo
+}
+
+// cacheNarInfoMetadata caches the narinfo of hash, as read from the database,
+// in the metadata cache.
+func (c *Cache) cacheNarInfoMetadata(ctx context.Context, hash string, narInfo *narinfo.NarInfo) {
+	if c.narInfoMetadataCache == nil {
+		return
+	}
+
+	if err := c.narInfoMetadataCache.Set(ctx, hash, narInfo.String()); err != nil {
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(err).
+			Msg("error caching the narinfo in the metadata cache")
+	}
+}
+
+// invalidateNarInfoMetadata drops the narinfos of hashes, stored or deleted,
+// from the narinfos preloaded by WarmUp and from the metadata cache.
+func (c *Cache) invalidateNarInfoMetadata(ctx context.Context, hashes ...string) {
+	c.dropWarmNarInfos(hashes...)
+
+	if c.narInfoMetadataCache == nil || len(hashes) == 0 {
+		return
+	}
+
+	if err := c.narInfoMetadataCache.Delete(context.WithoutCancel(ctx), hashes...); err != nil {
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(err).
+			Int("count", len(hashes)).
+			Msg("error invalidating the narinfos of the metadata cache")
+	}
+}
+
+// invalidateNarInfos drops the narinfos of hashes, stored or deleted, from the
+// metadata cache and from the in-memory state of the other replicas.
+func (c *Cache) invalidateNarInfos(ctx context.Context, hashes ...string) {
+	c.invalidateNarInfoMetadata(ctx, hashes...)
+	c.publishNarInfoInvalidation(ctx, hashes...)
+}
+
+func recordNarInfoMetadataCache(ctx context.Context, result string) {
+	if narInfoMetadataCacheTotal == nil {
+		return
+	}
+
+	narInfoMetadataCacheTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+}
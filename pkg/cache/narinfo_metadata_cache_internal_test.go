@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+)
+
+// memoryNarInfoMetadataCache is an in-memory NarInfoMetadataCache.
+type memoryNarInfoMetadataCache struct {
+	mu       sync.Mutex
+	narInfos map[string]string
+}
+
+func (m *memoryNarInfoMetadataCache) Get(_ context.Context, hash string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	narInfo, ok := m.narInfos[hash]
+
+	return narInfo, ok, nil
+}
+
+func (m *memoryNarInfoMetadataCache) Set(_ context.Context, hash, narInfo string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.narInfos[hash] = narInfo
+
+	return nil
+}
+
+func (m *memoryNarInfoMetadataCache) Delete(_ context.Context, hashes ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, hash := range hashes {
+		delete(m.narInfos, hash)
+	}
+
+	return nil
+}
+
+func (m *memoryNarInfoMetadataCache) has(hash string) bool {
+	_, ok, _ := m.Get(context.Background(), hash)
+
+	return ok
+}
+
+func TestGetNarInfo_MetadataCache(t *testing.T) {
+	t.Parallel()
+
+	ctx := newContext()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	mc := &memoryNarInfoMetadataCache{narInfos: make(map[string]string)}
+	c.SetNarInfoMetadataCache(mc)
+
+	const (
+		narInfoHash = "0amzzlz5w7ihknr59cn0q56pvp17bqqz"
+		narHash     = "1s8p1kgdms8rmxkq24q51wc7zpn0aqcwgzvc473v9cii7z2qyxq0"
+		content     = "this is test content for a narinfo in the metadata cache"
+	)
+
+	require.NoError(t, c.PutNar(ctx, nar.URL{Hash: narHash, Compression: nar.CompressionTypeNone},
+		io.NopCloser(strings.NewReader(content))))
+
+	niText := `StorePath: /nix/store/0amzzlz5w7ihknr59cn0q56pvp17bqqz-test-path
+URL: nar/` + narHash + `.nar
+Compression: none
+NarHash: sha256:` + narHash + `
+NarSize: 56
+`
+
+	mc.narInfos[narInfoHash] = "stale"
+
+	require.NoError(t, c.PutNarInfo(ctx, narInfoHash, io.NopCloser(strings.NewReader(niText))))
+	assert.False(t, mc.has(narInfoHash), "storing a narinfo invalidates it")
+
+	ni, err := c.GetNarInfo(ctx, narInfoHash)
+	require.NoError(t, err)
+	assert.True(t, mc.has(narInfoHash), "a narinfo read from the database is cached")
+
+	// Serve a marker from the metadata cache to tell it from the database.
+	mc.narInfos[narInfoHash] = strings.Replace(ni.String(), "NarSize: 56", "NarSize: 57", 1)
+
+	ni, err = c.GetNarInfo(ctx, narInfoHash)
+	require.NoError(t, err)
+	assert.EqualValues(t, 57, ni.NarSize, "the narinfo is served from the metadata cache")
+
+	require.NoError(t, c.DeleteNarInfo(ctx, narInfoHash))
+	assert.False(t, mc.has(narInfoHash), "deleting a narinfo invalidates it")
+}
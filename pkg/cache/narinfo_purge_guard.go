@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// narInfoPurgeWindow is the window over which the narinfo purges are limited.
+const narInfoPurgeWindow = time.Minute
+
+// Results recorded by ncps_narinfo_purges_total.
+const (
+	narInfoPurgeResultPurged      = "purged"
+	narInfoPurgeResultQuarantined = "quarantined"
+	narInfoPurgeResultError       = "error"
+)
+
+// ErrNarInfoQuarantined is returned by purgeNarInfo when the purge rate is
+// abnormal and the narinfo is kept instead of being purged.
+var ErrNarInfoQuarantined = errors.New("the narinfo purge was quarantined")
+
+// narInfoPurgeGuard limits the narinfo purges to max per narInfoPurgeWindow. A
+// purge over the limit waits for the window to allow it or, with quarantine
+// set, is skipped and the narinfo kept.
+type narInfoPurgeGuard struct {
+	max        int
+	quarantine bool
+
+	mu sync.Mutex
+	// purges are the times of the purges within the window, oldest first.
+	purges []time.Time
+}
+
+// SetNarInfoPurgeLimit limits the narinfo purges to maxPerMinute, guarding
+// against a misfiring storage check purging the cache wholesale. A purge over
+// the limit waits until the rate allows it or, with quarantine set, keeps the
+// narinfo and is logged instead. A non-positive maxPerMinute removes the limit.
+func (c *Cache) SetNarInfoPurgeLimit(maxPerMinute int, quarantine bool) {
+	if maxPerMinute <= 0 {
+		c.narInfoPurgeGuard = nil
+
+		return
+	}
+
+	c.narInfoPurgeGuard = &narInfoPurgeGuard{max: maxPerMinute, quarantine: quarantine}
+}
+
+// admitNarInfoPurge admits a narinfo purge under the purge limit. It returns
+// ErrNarInfoQuarantined when the purge must be skipped, or the error of ctx
+// while waiting for the rate to allow it.
+func (c *Cache) admitNarInfoPurge(ctx context.Context, hash string) error {
+	g := c.narInfoPurgeGuard
+	if g == nil {
+		return nil
+	}
+
+	for {
+		wait := g.reserve(time.Now())
+		if wait <= 0 {
+			return nil
+		}
+
+		if g.quarantine {
+			zerolog.Ctx(ctx).
+				Warn().
+				Str("narinfo_hash", hash).
+				Int("max_per_minute", g.max).
+				Msg("the narinfo purge rate is abnormal; quarantining the narinfo instead of purging it")
+
+			recordNarInfoPurge(ctx, narInfoPurgeResultQuarantined)
+
+			return ErrNarInfoQuarantined
+		}
+
+		t := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			t.Stop()
+
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// reserve records a purge at now if the limit allows it, returning zero, or
+// else returns how long until it does.
+func (g *narInfoPurgeGuard) reserve(now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	cutoff := now.Add(-narInfoPurgeWindow)
+
+	expired := 0
+	for expired < len(g.purges) && !g.purges[expired].After(cutoff) {
+		expired++
+	}
+
+	g.purges = g.purges[expired:]
+
+	if len(g.purges) >= g.max {
+		return g.purges[0].Sub(cutoff)
+	}
+
+	g.purges = append(g.purges, now)
+
+	return 0
+}
+
+func recordNarInfoPurge(ctx context.Context, result string) {
+	if narInfoPurgesTotal == nil {
+		return
+	}
+
+	narInfoPurgesTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+}
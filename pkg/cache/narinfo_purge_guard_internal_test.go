@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNarInfoPurgeGuardReserve(t *testing.T) {
+	t.Parallel()
+
+	g := &narInfoPurgeGuard{max: 2}
+	now := time.Now()
+
+	assert.Zero(t, g.reserve(now))
+	assert.Zero(t, g.reserve(now.Add(10*time.Second)))
+	assert.Equal(t, 30*time.Second, g.reserve(now.Add(30*time.Second)), "the third purge waits for the first to expire")
+
+	assert.Zero(t, g.reserve(now.Add(narInfoPurgeWindow+time.Second)))
+	assert.Len(t, g.purges, 2)
+}
+
+func TestAdmitNarInfoPurge(t *testing.T) {
+	t.Parallel()
+
+	t.Run("unlimited", func(t *testing.T) {
+		t.Parallel()
+
+		c := &Cache{}
+		c.SetNarInfoPurgeLimit(0, true)
+
+		for range 10 {
+			require.NoError(t, c.admitNarInfoPurge(newContext(), "hash"))
+		}
+	})
+
+	t.Run("quarantine over the limit", func(t *testing.T) {
+		t.Parallel()
+
+		c := &Cache{}
+		c.SetNarInfoPurgeLimit(1, true)
+
+		require.NoError(t, c.admitNarInfoPurge(newContext(), "hash"))
+		require.ErrorIs(t, c.admitNarInfoPurge(newContext(), "hash"), ErrNarInfoQuarantined)
+	})
+
+	t.Run("wait over the limit", func(t *testing.T) {
+		t.Parallel()
+
+		c := &Cache{}
+		c.SetNarInfoPurgeLimit(1, false)
+
+		require.NoError(t, c.admitNarInfoPurge(newContext(), "hash"))
+
+		ctx, cancel := context.WithTimeout(newContext(), 50*time.Millisecond)
+		defer cancel()
+
+		require.ErrorIs(t, c.admitNarInfoPurge(ctx, "hash"), context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,232 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+
+	"github.com/kalbasit/ncps/pkg/analytics"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+// Results recorded by ncps_narinfo_revalidation_total.
+const (
+	narInfoRevalidationResultUnchanged = "unchanged"
+	narInfoRevalidationResultChanged   = "changed"
+	narInfoRevalidationResultNotFound  = "not_found"
+	narInfoRevalidationResultError     = "error"
+)
+
+// narInfoRevalidation configures the revalidation of cached narinfos against
+// their upstream, with HTTP stale-while-revalidate semantics: a narinfo older
+// than maxAge is revalidated; within staleWindow past maxAge it is served
+// immediately and revalidated in the background, beyond it the request waits
+// for the revalidation.
+type narInfoRevalidation struct {
+	maxAge      time.Duration
+	staleWindow time.Duration
+
+	mu       sync.Mutex
+	inflight map[string]struct{}
+}
+
+// SetNarInfoRevalidation enables the revalidation of cached narinfos older
+// than maxAge against their upstream. A narinfo whose upstream now serves a
+// different NAR is purged and pulled again. For staleWhileRevalidate past
+// maxAge, the cached narinfo is served at once and revalidated in the
+// background; past that window, the request waits for the revalidation. A
+// non-positive maxAge disables the revalidation.
+func (c *Cache) SetNarInfoRevalidation(maxAge, staleWhileRevalidate time.Duration) {
+	if maxAge <= 0 {
+		c.narInfoRevalidation = nil
+
+		return
+	}
+
+	c.narInfoRevalidation = &narInfoRevalidation{
+		maxAge:      maxAge,
+		staleWindow: max(staleWhileRevalidate, 0),
+		inflight:    make(map[string]struct{}),
+	}
+}
+
+// maybeRevalidateNarInfo revalidates the cached narinfo of hash if it is due.
+// It returns true when the cached narinfo was purged because the upstream now
+// serves a different NAR, in which case the caller must pull it again. A
+// narinfo already being revalidated is served as is.
+func (c *Cache) maybeRevalidateNarInfo(ctx context.Context, hash string) bool {
+	rv := c.narInfoRevalidation
+	if rv == nil || IsUploadOnly(ctx) || c.IsOffline() {
+		return false
+	}
+
+	ni, err := c.dbClient.Ent().NarInfo.Query().
+		Where(entnarinfo.HashEQ(hash)).
+		Select(entnarinfo.FieldCreatedAt, entnarinfo.FieldRevalidatedAt).
+		Only(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(err).
+			Msg("error loading the narinfo revalidation time")
+
+		return false
+	}
+
+	validatedAt := ni.CreatedAt
+	if ni.RevalidatedAt != nil {
+		validatedAt = *ni.RevalidatedAt
+	}
+
+	age := time.Since(validatedAt)
+	if age < rv.maxAge || !rv.claim(hash) {
+		return false
+	}
+
+	if age < rv.maxAge+rv.staleWindow {
+		// The revalidation outlives the request that served the stale narinfo.
+		detachedCtx := context.WithoutCancel(ctx)
+
+		c.backgroundWG.Add(1)
+
+		analytics.SafeGo(detachedCtx, func() {
+			defer c.backgroundWG.Done()
+			defer rv.release(hash)
+
+			c.revalidateNarInfo(detachedCtx, hash)
+		})
+
+		return false
+	}
+
+	defer rv.release(hash)
+
+	return c.revalidateNarInfo(ctx, hash)
+}
+
+// revalidateNarInfo compares the cached narinfo of hash with the one served
+// by the upstream. It returns true when they describe different NARs and the
+// cached narinfo was purged. An upstream that no longer has the narinfo, or
+// fails, leaves the cached narinfo in place.
+func (c *Cache) revalidateNarInfo(ctx context.Context, hash string) bool {
+	ctx, span := tracer.Start(
+		ctx,
+		"cache.revalidateNarInfo",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("narinfo_hash", hash),
+		),
+	)
+	defer span.End()
+
+	log := zerolog.Ctx(ctx).With().Str("op", "narinfo-revalidation").Logger()
+	ctx = log.WithContext(ctx)
+
+	_, upstreamNarInfo, err := c.getNarInfoFromUpstream(ctx, hash)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Warn().Err(err).Msg("error revalidating the narinfo")
+		recordNarInfoRevalidation(ctx, narInfoRevalidationResultError)
+
+		return false
+	}
+
+	result := narInfoRevalidationResultNotFound
+
+	if err == nil {
+		ni, err := c.dbClient.Ent().NarInfo.Query().
+			Where(entnarinfo.HashEQ(hash)).
+			Select(entnarinfo.FieldURL, entnarinfo.FieldNarHash).
+			Only(ctx)
+		if err != nil {
+			log.Warn().Err(err).Msg("error loading the narinfo to revalidate")
+			recordNarInfoRevalidation(ctx, narInfoRevalidationResultError)
+
+			return false
+		}
+
+		result = narInfoRevalidationResultUnchanged
+
+		if upstreamNarInfo.NarHash != nil && ni.NarHash != nil && upstreamNarInfo.NarHash.String() != *ni.NarHash {
+			log.Info().
+				Str("cached_nar_hash", *ni.NarHash).
+				Str("upstream_nar_hash", upstreamNarInfo.NarHash.String()).
+				Msg("the upstream serves a different nar, purging the cached narinfo")
+
+			var narURL nar.URL
+			if ni.URL != nil {
+				narURL, _ = nar.ParseURL(*ni.URL)
+			}
+
+			err := c.withWriteLock(ctx, "revalidateNarInfo", narInfoLockKey(hash), func() error {
+				return c.purgeNarInfo(ctx, hash, &narURL)
+			})
+			if errors.Is(err, ErrNarInfoQuarantined) {
+				recordNarInfoRevalidation(ctx, narInfoRevalidationResultError)
+
+				return false
+			}
+
+			if err != nil {
+				log.Error().Err(err).Msg("error purging the changed narinfo")
+				recordNarInfoRevalidation(ctx, narInfoRevalidationResultError)
+
+				return false
+			}
+
+			recordNarInfoRevalidation(ctx, narInfoRevalidationResultChanged)
+
+			return true
+		}
+	}
+
+	// Both an unchanged narinfo and one the upstream no longer has stay valid
+	// for another maxAge: the cached copy is still consistent with its NAR.
+	if err := c.dbClient.Ent().NarInfo.Update().
+		Where(entnarinfo.HashEQ(hash)).
+		SetRevalidatedAt(time.Now()).
+		Exec(ctx); err != nil {
+		log.Warn().Err(err).Msg("error recording the narinfo revalidation")
+	}
+
+	recordNarInfoRevalidation(ctx, result)
+
+	return false
+}
+
+// claim marks hash as being revalidated. It returns false when it already is.
+func (rv *narInfoRevalidation) claim(hash string) bool {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+
+	if _, ok := rv.inflight[hash]; ok {
+		return false
+	}
+
+	rv.inflight[hash] = struct{}{}
+
+	return true
+}
+
+func (rv *narInfoRevalidation) release(hash string) {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+
+	delete(rv.inflight, hash)
+}
+
+func recordNarInfoRevalidation(ctx context.Context, result string) {
+	if narInfoRevalidationTotal == nil {
+		return
+	}
+
+	narInfoRevalidationTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+}
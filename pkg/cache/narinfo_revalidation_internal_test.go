@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestNarInfoRevalidation(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	ts := testdata.NewTestServer(t, 40)
+	t.Cleanup(ts.Close)
+
+	var (
+		narInfoGets atomic.Int32
+		changed     atomic.Bool
+	)
+
+	narInfoPath := "/" + testdata.Nar1.NarInfoHash + ".narinfo"
+
+	ts.AddMaybeHandler(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodGet || r.URL.Path != narInfoPath {
+			return false
+		}
+
+		narInfoGets.Add(1)
+
+		if !changed.Load() {
+			return false
+		}
+
+		_, err := w.Write([]byte(strings.Replace(
+			testdata.Nar1.NarInfoText,
+			"NarHash: sha256:07kc6swib31psygpmwi8952lvywlpqn474059yxl7grwsvr6k0fj",
+			"NarHash: sha256:1lid9xrpirkzcpqsxfq02qwiq0yd70chfl860wzsqd1739ih0nri",
+			1,
+		)))
+		assert.NoError(t, err)
+
+		return true
+	})
+
+	uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), nil)
+	require.NoError(t, err)
+
+	c.AddUpstreamCaches(newContext(), uc)
+
+	<-c.GetHealthChecker().Trigger()
+
+	_, err = c.GetNarInfo(newContext(), testdata.Nar1.NarInfoHash)
+	require.NoError(t, err)
+
+	c.backgroundWG.Wait()
+	require.Equal(t, int32(1), narInfoGets.Load())
+
+	c.SetNarInfoRevalidation(time.Hour, time.Hour)
+
+	validatedAgo := func(d time.Duration) {
+		t.Helper()
+
+		require.NoError(t, c.dbClient.Ent().NarInfo.Update().
+			Where(entnarinfo.HashEQ(testdata.Nar1.NarInfoHash)).
+			SetRevalidatedAt(time.Now().Add(-d)).
+			Exec(newContext()))
+	}
+
+	revalidatedAt := func() time.Time {
+		t.Helper()
+
+		ni, err := c.dbClient.Ent().NarInfo.Query().
+			Where(entnarinfo.HashEQ(testdata.Nar1.NarInfoHash)).
+			Only(newContext())
+		require.NoError(t, err)
+		require.NotNil(t, ni.RevalidatedAt)
+
+		return *ni.RevalidatedAt
+	}
+
+	// A fresh narinfo is served without asking the upstream.
+	_, err = c.GetNarInfo(newContext(), testdata.Nar1.NarInfoHash)
+	require.NoError(t, err)
+
+	c.backgroundWG.Wait()
+	assert.Equal(t, int32(1), narInfoGets.Load())
+
+	// A stale narinfo within the window is revalidated in the background.
+	validatedAgo(90 * time.Minute)
+
+	_, err = c.GetNarInfo(newContext(), testdata.Nar1.NarInfoHash)
+	require.NoError(t, err)
+
+	c.backgroundWG.Wait()
+	assert.Equal(t, int32(2), narInfoGets.Load())
+	assert.WithinDuration(t, time.Now(), revalidatedAt(), time.Minute)
+
+	// Past the window the request waits for the revalidation.
+	validatedAgo(3 * time.Hour)
+
+	_, err = c.GetNarInfo(newContext(), testdata.Nar1.NarInfoHash)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), narInfoGets.Load())
+	assert.WithinDuration(t, time.Now(), revalidatedAt(), time.Minute)
+
+	// An upstream serving a different NAR purges the cached narinfo.
+	changed.Store(true)
+
+	assert.True(t, c.revalidateNarInfo(newContext(), testdata.Nar1.NarInfoHash))
+
+	exists, err := c.dbClient.Ent().NarInfo.Query().
+		Where(entnarinfo.HashEQ(testdata.Nar1.NarInfoHash)).
+		Exist(newContext())
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestSetNarInfoRevalidationDisabled(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	c.SetNarInfoRevalidation(time.Hour, -time.Hour)
+	require.NotNil(t, c.narInfoRevalidation)
+	assert.Zero(t, c.narInfoRevalidation.staleWindow)
+
+	c.SetNarInfoRevalidation(0, time.Hour)
+	assert.Nil(t, c.narInfoRevalidation)
+	assert.False(t, c.maybeRevalidateNarInfo(newContext(), testdata.Nar1.NarInfoHash))
+}
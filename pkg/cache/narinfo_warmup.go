@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
+
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+
+	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+// narInfoWarmUpConcurrency is the number of narinfos read from the database in
+// parallel by WarmUp.
+const narInfoWarmUpConcurrency = 4
+
+// narInfoWarmUp holds the hot narinfos preloaded by WarmUp.
+type narInfoWarmUp struct {
+	count int
+	ttl   time.Duration
+
+	mu      sync.RWMutex
+	entries map[string]warmNarInfo
+}
+
+// warmNarInfo is a narinfo preloaded by WarmUp, kept as text since the
+// narinfos served are modified in place.
+type warmNarInfo struct {
+	text     string
+	loadedAt time.Time
+}
+
+// SetNarInfoWarmUp makes WarmUp preload the count most recently accessed
+// narinfos in memory, where they are served from for ttl before the metadata
+// cache and the database. The cache is not ready, see IsReady, until WarmUp
+// completes, so that a replica joining a load balancer does not take its share
+// of the traffic with every narinfo to read from the database. A non-positive
+// count disables the warm-up.
+func (c *Cache) SetNarInfoWarmUp(count int, ttl time.Duration) {
+	if count <= 0 {
+		c.narInfoWarmUp = nil
+		c.warmingUp.Store(false)
+
+		return
+	}
+
+	c.narInfoWarmUp = &narInfoWarmUp{
+		count:   count,
+		ttl:     ttl,
+		entries: make(map[string]warmNarInfo),
+	}
+	c.warmingUp.Store(true)
+}
+
+// IsReady reports whether the cache is ready to take its share of the traffic,
+// that is whether the warm-up configured by SetNarInfoWarmUp is over. The cache
+// serves every request while it is not ready.
+func (c *Cache) IsReady() bool {
+	return !c.warmingUp.Load()
+}
+
+// WarmUp preloads the most recently accessed narinfos configured by
+// SetNarInfoWarmUp and returns how many were loaded. The cache is ready once it
+// returns, even on error: the narinfos not preloaded are read from the
+// database as usual.
+func (c *Cache) WarmUp(ctx context.Context) (int, error) {
+	wu := c.narInfoWarmUp
+	if wu == nil {
+		return 0, nil
+	}
+
+	defer c.warmingUp.Store(false)
+
+	start := time.Now()
+
+	hashes, err := c.dbClient.Ent().NarInfo.Query().
+		Where(entnarinfo.URLNotNil(), entnarinfo.LastAccessedAtNotNil()).
+		Order(ent.Desc(entnarinfo.FieldLastAccessedAt)).
+		Limit(wu.count).
+		Select(entnarinfo.FieldHash).
+		Strings(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error listing the most recently accessed narinfos: %w", err)
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(narInfoWarmUpConcurrency)
+
+	for _, hash := range hashes {
+		g.Go(func() error {
+			var ni *narinfo.NarInfo
+
+			err := c.withEntTransaction(gctx, "warmUpNarInfo", func(tx *ent.Tx) error {
+				var populateErr error
+
+				ni, _, populateErr = c.populateNarInfoFromDatabase(gctx, tx, hash, false)
+
+				return populateErr
+			})
+			if errors.Is(err, storage.ErrNotFound) {
+				return nil
+			}
+
+			if err != nil {
+				return fmt.Errorf("error reading the narinfo %s: %w", hash, err)
+			}
+
+			wu.mu.Lock()
+			wu.entries[hash] = warmNarInfo{text: ni.String(), loadedAt: time.Now()}
+			wu.mu.Unlock()
+
+			return nil
+		})
+	}
+
+	err = g.Wait()
+
+	wu.mu.RLock()
+	loaded := len(wu.entries)
+	wu.mu.RUnlock()
+
+	if err != nil {
+		return loaded, err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Int("narinfos", loaded).
+		Dur("elapsed", time.Since(start)).
+		Msg("narinfo warm-up complete")
+
+	return loaded, nil
+}
+
+// getNarInfoFromWarmUp returns the narinfo of hash preloaded by WarmUp, or nil
+// if it was not preloaded or has expired.
+func (c *Cache) getNarInfoFromWarmUp(ctx context.Context, hash string) *narinfo.NarInfo {
+	wu := c.narInfoWarmUp
+	if wu == nil {
+		return nil
+	}
+
+	wu.mu.RLock()
+	entry, ok := wu.entries[hash]
+	wu.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	if wu.ttl > 0 && time.Since(entry.loadedAt) > wu.ttl {
+		c.dropWarmNarInfos(hash)
+
+		return nil
+	}
+
+	narInfo, err := narinfo.Parse(strings.NewReader(entry.text))
+	if err != nil {
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(err).
+			Msg("error parsing the preloaded narinfo")
+
+		c.dropWarmNarInfos(hash)
+
+		return nil
+	}
+
+	return narInfo
+}
+
+// dropWarmNarInfos forgets the preloaded narinfos of hashes.
+func (c *Cache) dropWarmNarInfos(hashes ...string) {
+	wu := c.narInfoWarmUp
+	if wu == nil {
+		return
+	}
+
+	wu.mu.Lock()
+
+	for _, hash := range hashes {
+		delete(wu.entries, hash)
+	}
+
+	wu.mu.Unlock()
+}
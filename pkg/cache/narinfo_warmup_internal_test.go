@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestNarInfoWarmUp(t *testing.T) {
+	t.Parallel()
+
+	setup := func(t *testing.T, ttl time.Duration) *Cache {
+		t.Helper()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		narURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: testdata.Nar1.NarCompression}
+		require.NoError(t, c.PutNar(newContext(), narURL, io.NopCloser(strings.NewReader(testdata.Nar1.NarText))))
+		require.NoError(t, c.PutNarInfo(newContext(), testdata.Nar1.NarInfoHash,
+			io.NopCloser(strings.NewReader(testdata.Nar1.NarInfoText))))
+
+		c.SetNarInfoWarmUp(10, ttl)
+
+		return c
+	}
+
+	t.Run("the cache is not ready until the narinfos are preloaded", func(t *testing.T) {
+		t.Parallel()
+
+		c := setup(t, time.Hour)
+		assert.False(t, c.IsReady())
+
+		loaded, err := c.WarmUp(newContext())
+		require.NoError(t, err)
+		assert.Equal(t, 1, loaded)
+		assert.True(t, c.IsReady())
+
+		ni := c.getNarInfoFromWarmUp(newContext(), testdata.Nar1.NarInfoHash)
+		require.NotNil(t, ni)
+		assert.Equal(t, "nar/"+testdata.Nar1.NarHash+".nar.xz", ni.URL)
+
+		served, err := c.GetNarInfo(newContext(), testdata.Nar1.NarInfoHash)
+		require.NoError(t, err)
+		assert.Equal(t, ni.StorePath, served.StorePath)
+	})
+
+	t.Run("an invalidated narinfo is dropped", func(t *testing.T) {
+		t.Parallel()
+
+		c := setup(t, time.Hour)
+
+		_, err := c.WarmUp(newContext())
+		require.NoError(t, err)
+
+		c.invalidateNarInfos(newContext(), testdata.Nar1.NarInfoHash)
+
+		assert.Nil(t, c.getNarInfoFromWarmUp(newContext(), testdata.Nar1.NarInfoHash))
+	})
+
+	t.Run("an expired narinfo is dropped", func(t *testing.T) {
+		t.Parallel()
+
+		c := setup(t, time.Nanosecond)
+
+		_, err := c.WarmUp(newContext())
+		require.NoError(t, err)
+
+		assert.Nil(t, c.getNarInfoFromWarmUp(newContext(), testdata.Nar1.NarInfoHash))
+	})
+
+	t.Run("the cache is ready without a warm-up", func(t *testing.T) {
+		t.Parallel()
+
+		c := setup(t, time.Hour)
+		c.SetNarInfoWarmUp(0, 0)
+
+		assert.True(t, c.IsReady())
+	})
+}
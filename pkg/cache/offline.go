@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+// SetOffline switches the offline mode on or off. While offline, the cache
+// serves only what it has: its upstreams, shadow ones included, are never
+// contacted, so a narinfo or NAR that is not cached is not found at once
+// rather than after the upstream timeouts. The health checks are paused too,
+// and the upstreams keep the health of their last check until the next one.
+func (c *Cache) SetOffline(ctx context.Context, offline bool) {
+	if c.offline.Swap(offline) == offline {
+		return
+	}
+
+	zerolog.Ctx(ctx).
+		Info().
+		Bool("offline", offline).
+		Msg("switched the offline mode")
+
+	if c.healthChecker != nil {
+		c.healthChecker.SetPaused(offline)
+	}
+}
+
+// IsOffline reports whether the offline mode is on. See SetOffline.
+func (c *Cache) IsOffline() bool {
+	return c.offline.Load()
+}
@@ -191,5 +191,6 @@ func seedChunkLinks(ctx context.Context, t *testing.T, c *Cache, narFileID int,
 			SetChunkIndex(i).
 			Save(ctx)
 		require.NoError(t, err)
+		require.NoError(t, c.dbClient.Ent().Chunk.UpdateOneID(ch.ID).AddRefCount(1).Exec(ctx))
 	}
 }
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+// PresignNarURL returns a time-limited URL through which the client can fetch
+// the nar directly from the storage backend, bypassing ncps. It only succeeds
+// when the exact representation requested is stored as a whole file: a nar that
+// is chunked, stored under a different compression, or still downloading returns
+// storage.ErrNotFound so the caller falls back to proxying it through GetNar. A
+// store that cannot presign returns ErrNarPresignUnsupported.
+//
+// The nar_file record is touched like a regular serve so redirected nars are not
+// evicted by the LRU as if they were never accessed.
+func (c *Cache) PresignNarURL(ctx context.Context, narURL nar.URL, expiry time.Duration) (*url.URL, error) {
+	ctx, span := tracer.Start(
+		ctx,
+		"cache.PresignNarURL",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("nar_url", narURL.String()),
+		),
+	)
+	defer span.End()
+
+	presigner, ok := c.narStore.(storage.NarPresigner)
+	if !ok {
+		return nil, ErrNarPresignUnsupported
+	}
+
+	present, err := c.narStore.StatNar(ctx, narURL)
+	if err != nil {
+		return nil, fmt.Errorf("error checking the nar in the store: %w", err)
+	}
+
+	if !present {
+		return nil, storage.ErrNotFound
+	}
+
+	u, err := presigner.PresignNarURL(ctx, narURL, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.touchNarFile(ctx, narURL); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// touchNarFile refreshes the last access time of the nar_file record of narURL,
+// unless it was refreshed within recordAgeIgnoreTouch, for the serve paths that
+// do not go through getNarFromStore. It follows the touch mode.
+func (c *Cache) touchNarFile(ctx context.Context, narURL nar.URL) error {
+	switch c.touchMode {
+	case TouchModeOff:
+		return nil
+	case TouchModeAsync:
+		c.touchAsync(ctx, "nar_file:"+narURL.String(), func(ctx context.Context) error {
+			return c.updateNarFileLastAccessedAt(ctx, narURL)
+		})
+
+		return nil
+	default:
+		return c.updateNarFileLastAccessedAt(ctx, narURL)
+	}
+}
+
+func (c *Cache) updateNarFileLastAccessedAt(ctx context.Context, narURL nar.URL) error {
+	now := time.Now()
+
+	if _, err := c.dbClient.Ent().NarFile.Update().
+		Where(
+			entnarfile.HashEQ(narURL.Hash),
+			entnarfile.CompressionEQ(narURL.Compression.String()),
+			entnarfile.QueryEQ(narURL.CanonicalQuery()),
+			entnarfile.Or(
+				entnarfile.LastAccessedAtIsNil(),
+				entnarfile.LastAccessedAtLT(now.Add(-c.recordAgeIgnoreTouch)),
+			),
+		).
+		SetLastAccessedAt(now).
+		SetUpdatedAt(now).
+		Save(ctx); err != nil {
+		return fmt.Errorf("error touching the nar record: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,331 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/kalbasit/ncps/pkg/cache/prewarm"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/narinfo"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+// defaultPrewarmConcurrency is the number of store paths pre-warmed in parallel
+// when SetPrewarm is given a non-positive concurrency.
+const defaultPrewarmConcurrency = 4
+
+// PrewarmResult summarizes a pre-warm run.
+type PrewarmResult struct {
+	// Roots is the number of root store paths returned by the sources.
+	Roots int
+
+	// Cached is the number of closure members that were already cached.
+	Cached int
+
+	// Fetched is the number of closure members pulled from upstream.
+	Fetched int
+
+	// Missing is the number of closure members no upstream has.
+	Missing int
+
+	// Skipped is the number of store paths left out because their NAR is larger
+	// than the configured limit. Only channel pre-fetches skip paths.
+	Skipped int
+
+	// Failed is the number of closure members that could not be pulled.
+	Failed int
+}
+
+type prewarmConfig struct {
+	// running is held for the duration of a run so overlapping schedules skip
+	// instead of stacking up.
+	running     sync.Mutex
+	sources     []prewarm.Source
+	concurrency int
+}
+
+// SetPrewarm configures the sources of store paths whose closures are
+// pre-warmed by Prewarm, and how many paths are pulled in parallel.
+func (c *Cache) SetPrewarm(sources []prewarm.Source, concurrency int) {
+	if concurrency <= 0 {
+		concurrency = defaultPrewarmConcurrency
+	}
+
+	c.prewarm = &prewarmConfig{sources: sources, concurrency: concurrency}
+}
+
+// Prewarm collects the root store paths from the configured sources, walks
+// their closures through the narinfo references and pulls every member that
+// is not cached yet, NAR included. A failing source is logged and skipped; an
+// error is returned only when every source failed.
+func (c *Cache) Prewarm(ctx context.Context) (PrewarmResult, error) {
+	var result PrewarmResult
+
+	pc := c.prewarm
+	if pc == nil || len(pc.sources) == 0 {
+		return result, nil
+	}
+
+	if !pc.running.TryLock() {
+		zerolog.Ctx(ctx).Info().Msg("a pre-warm is already running; skipping")
+
+		return result, nil
+	}
+	defer pc.running.Unlock()
+
+	roots, err := c.collectPrewarmRoots(ctx, pc.sources)
+	if err != nil {
+		return result, err
+	}
+
+	return c.prewarmClosures(ctx, roots, pc.concurrency)
+}
+
+// PrefetchClosures walks the closures of the given narinfo hashes through the
+// narinfo references and pulls every member that is not cached yet, NAR
+// included, like a pre-warm run of these roots. It returns once done.
+func (c *Cache) PrefetchClosures(ctx context.Context, hashes []string) (PrewarmResult, error) {
+	concurrency := defaultPrewarmConcurrency
+	if c.prewarm != nil {
+		concurrency = c.prewarm.concurrency
+	}
+
+	return c.prewarmClosures(ctx, hashes, concurrency)
+}
+
+// prewarmClosures pulls the closures of roots, concurrency members at a time.
+func (c *Cache) prewarmClosures(ctx context.Context, roots []string, concurrency int) (PrewarmResult, error) {
+	result := PrewarmResult{Roots: len(roots)}
+
+	var (
+		mu      sync.Mutex
+		visited = make(map[string]struct{}, len(roots))
+		level   = make([]string, 0, len(roots))
+	)
+
+	for _, hash := range roots {
+		if _, ok := visited[hash]; !ok {
+			visited[hash] = struct{}{}
+			level = append(level, hash)
+		}
+	}
+
+	for len(level) > 0 {
+		var next []string
+
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(concurrency)
+
+		for _, hash := range level {
+			g.Go(func() error {
+				refs, outcome := c.prewarmOne(gctx, hash)
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				switch outcome {
+				case prewarmOutcomeCached:
+					result.Cached++
+				case prewarmOutcomeFetched:
+					result.Fetched++
+				case prewarmOutcomeMissing:
+					result.Missing++
+				case prewarmOutcomeFailed:
+					result.Failed++
+				}
+
+				for _, ref := range refs {
+					if _, ok := visited[ref]; !ok {
+						visited[ref] = struct{}{}
+						next = append(next, ref)
+					}
+				}
+
+				return nil
+			})
+		}
+
+		_ = g.Wait() // prewarmOne never returns an error to the group.
+
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		level = next
+	}
+
+	return result, nil
+}
+
+// AddPrewarmCronJob adds a periodic job pre-warming the closures returned by
+// the sources configured with SetPrewarm.
+func (c *Cache) AddPrewarmCronJob(ctx context.Context, schedule cron.Schedule) {
+	zerolog.Ctx(ctx).
+		Info().
+		Time("next-run", schedule.Next(time.Now())).
+		Msg("adding a cronjob for pre-warm")
+
+	c.scheduleCronJob(ctx, CronJobPrewarm, schedule, c.runPrewarm)
+}
+
+func (c *Cache) runPrewarm(ctx context.Context) func() {
+	return func() {
+		log := zerolog.Ctx(ctx).With().Str("op", "prewarm").Logger()
+
+		log.Info().Msg("running the pre-warm")
+
+		startedAt := time.Now()
+
+		result, err := c.Prewarm(log.WithContext(ctx))
+		if err != nil {
+			log.Error().Err(err).Msg("error running the pre-warm")
+
+			recordCronJobError(ctx, err)
+
+			return
+		}
+
+		log.Info().
+			Int("roots", result.Roots).
+			Int("cached", result.Cached).
+			Int("fetched", result.Fetched).
+			Int("missing", result.Missing).
+			Int("failed", result.Failed).
+			Dur("elapsed", time.Since(startedAt)).
+			Msg("pre-warm complete")
+	}
+}
+
+type prewarmOutcome int
+
+const (
+	prewarmOutcomeCached prewarmOutcome = iota
+	prewarmOutcomeFetched
+	prewarmOutcomeMissing
+	prewarmOutcomeFailed
+	prewarmOutcomeSkipped
+)
+
+// collectPrewarmRoots returns the narinfo hashes of the root store paths of
+// all sources.
+func (c *Cache) collectPrewarmRoots(ctx context.Context, sources []prewarm.Source) ([]string, error) {
+	var (
+		roots   []string
+		errs    []error
+		healthy int
+	)
+
+	for _, src := range sources {
+		paths, err := src.StorePaths(ctx)
+		if err != nil {
+			zerolog.Ctx(ctx).
+				Error().
+				Err(err).
+				Str("source", src.String()).
+				Msg("error collecting the store paths to pre-warm")
+
+			errs = append(errs, err)
+
+			continue
+		}
+
+		healthy++
+
+		for _, p := range paths {
+			hash, err := narinfo.HashFromStorePath(p)
+			if err != nil {
+				zerolog.Ctx(ctx).
+					Warn().
+					Err(err).
+					Str("source", src.String()).
+					Str("store_path", p).
+					Msg("ignoring an invalid store path")
+
+				continue
+			}
+
+			roots = append(roots, hash)
+		}
+	}
+
+	if healthy == 0 {
+		return nil, fmt.Errorf("error collecting the store paths to pre-warm: %w", errors.Join(errs...))
+	}
+
+	return roots, nil
+}
+
+// prewarmOne makes sure the narinfo and NAR of hash are cached and returns the
+// hashes of its references.
+func (c *Cache) prewarmOne(ctx context.Context, hash string) ([]string, prewarmOutcome) {
+	log := zerolog.Ctx(ctx).With().Str("narinfo_hash", hash).Logger()
+
+	ni, err := c.getNarInfoFromDatabase(ctx, hash)
+	if err == nil {
+		return referenceHashes(ni.References), prewarmOutcomeCached
+	}
+
+	ni, err = c.GetNarInfo(ctx, hash)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Debug().Msg("no upstream has this store path")
+
+			return nil, prewarmOutcomeMissing
+		}
+
+		log.Warn().Err(err).Msg("error pre-warming the narinfo")
+
+		return nil, prewarmOutcomeFailed
+	}
+
+	refs := referenceHashes(ni.References)
+
+	narURL, err := nar.ParseURL(ni.URL)
+	if err != nil {
+		log.Warn().Err(err).Str("nar_url", ni.URL).Msg("error parsing the nar URL")
+
+		return refs, prewarmOutcomeFailed
+	}
+
+	// Reading the NAR through waits for the download started by GetNarInfo, so
+	// the concurrency limit bounds the NAR downloads too.
+	_, _, rc, err := c.GetNar(ctx, narURL)
+	if err != nil {
+		log.Warn().Err(err).Msg("error pre-warming the nar")
+
+		return refs, prewarmOutcomeFailed
+	}
+
+	_, err = io.Copy(io.Discard, rc)
+	rc.Close()
+
+	if err != nil {
+		log.Warn().Err(err).Msg("error pre-warming the nar")
+
+		return refs, prewarmOutcomeFailed
+	}
+
+	return refs, prewarmOutcomeFetched
+}
+
+// referenceHashes returns the narinfo hashes of the references, skipping the
+// invalid ones.
+func referenceHashes(references []string) []string {
+	hashes := make([]string, 0, len(references))
+
+	for _, ref := range references {
+		if hash, err := narinfo.HashFromStorePath(ref); err == nil {
+			hashes = append(hashes, hash)
+		}
+	}
+
+	return hashes
+}
@@ -0,0 +1,87 @@
+package prewarm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kalbasit/ncps/pkg/xz"
+)
+
+// Channel tracks a nix channel (e.g. https://channels.nixos.org/nixos-unstable)
+// which redirects to the release it currently points to. Each release
+// publishes the list of its store paths in store-paths.xz.
+type Channel struct {
+	url    string
+	client *http.Client
+}
+
+// NewChannel returns a Channel for channelURL. A nil client uses
+// http.DefaultClient.
+func NewChannel(channelURL string, client *http.Client) *Channel {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Channel{url: strings.TrimSuffix(channelURL, "/"), client: client}
+}
+
+func (ch *Channel) String() string { return ch.url }
+
+// Release returns the URL of the release the channel currently points to, by
+// following its redirects.
+func (ch *Channel) Release(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, ch.url, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating the channel request: %w", err)
+	}
+
+	resp, err := ch.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error resolving the channel %q: %w", ch.url, err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %d from %q", ErrUnexpectedHTTPStatusCode, resp.StatusCode, ch.url)
+	}
+
+	return strings.TrimSuffix(resp.Request.URL.String(), "/"), nil
+}
+
+// StorePaths returns the store paths listed in the store-paths.xz of release.
+func (ch *Channel) StorePaths(ctx context.Context, release string) ([]string, error) {
+	endpoint := release + "/store-paths.xz"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the store paths request: %w", err)
+	}
+
+	resp, err := ch.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %q: %w", endpoint, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %d from %q", ErrUnexpectedHTTPStatusCode, resp.StatusCode, endpoint)
+	}
+
+	r, err := xz.Decompress(ctx, resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing %q: %w", endpoint, err)
+	}
+
+	defer r.Close()
+
+	paths, err := parsePathList(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the store paths from %q: %w", endpoint, err)
+	}
+
+	return paths, nil
+}
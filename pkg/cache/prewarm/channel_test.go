@@ -0,0 +1,58 @@
+package prewarm_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+
+	"github.com/kalbasit/ncps/pkg/cache/prewarm"
+)
+
+func TestChannel(t *testing.T) {
+	t.Parallel()
+
+	var listing bytes.Buffer
+
+	xw, err := xz.NewWriter(&listing)
+	require.NoError(t, err)
+
+	_, err = xw.Write([]byte("/nix/store/n5glp21rsz314qssw9fbvfswgy3kc68f-hello-2.12.1\n" +
+		"/nix/store/qdcbgcj27x2kpxj2sf9yfvva7qsgg64g-glibc-2.38-77\n"))
+	require.NoError(t, err)
+	require.NoError(t, xw.Close())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nixos-unstable", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/releases/nixos-25.11pre1/", http.StatusFound)
+	})
+	mux.HandleFunc("/releases/nixos-25.11pre1/", func(http.ResponseWriter, *http.Request) {})
+	mux.HandleFunc("/releases/nixos-25.11pre1/store-paths.xz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(listing.Bytes())
+	})
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	ch := prewarm.NewChannel(ts.URL+"/nixos-unstable/", ts.Client())
+	assert.Equal(t, ts.URL+"/nixos-unstable", ch.String())
+
+	release, err := ch.Release(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, ts.URL+"/releases/nixos-25.11pre1", release)
+
+	paths, err := ch.StorePaths(context.Background(), release)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"/nix/store/n5glp21rsz314qssw9fbvfswgy3kc68f-hello-2.12.1",
+		"/nix/store/qdcbgcj27x2kpxj2sf9yfvva7qsgg64g-glibc-2.38-77",
+	}, paths)
+
+	_, err = prewarm.NewChannel(ts.URL+"/missing", ts.Client()).Release(context.Background())
+	require.ErrorIs(t, err, prewarm.ErrUnexpectedHTTPStatusCode)
+}
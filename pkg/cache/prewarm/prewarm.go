@@ -0,0 +1,149 @@
+// Package prewarm provides the sources of store paths that ncps pre-warms on a
+// schedule, so the closures developers are about to need are already cached
+// when they arrive.
+package prewarm
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// maxPathListSize caps the size of a path list response.
+const maxPathListSize = 64 << 20
+
+// ErrUnexpectedHTTPStatusCode is returned when the path list endpoint does not
+// answer with 200 OK.
+var ErrUnexpectedHTTPStatusCode = errors.New("unexpected HTTP status code")
+
+// Source returns the root store paths to pre-warm. The closure of each root is
+// walked by the cache through the narinfo references.
+type Source interface {
+	// StorePaths returns the root store paths.
+	StorePaths(ctx context.Context) ([]string, error)
+
+	// String describes the source for logging.
+	String() string
+}
+
+// CommandRunner runs a command and returns its standard output.
+type CommandRunner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+// ExecCommand is the CommandRunner running commands with os/exec.
+func ExecCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stderr strings.Builder
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running %s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+type flake struct {
+	ref    string
+	nixBin string
+	run    CommandRunner
+}
+
+// NewFlake returns a Source evaluating the output path of the flake
+// installable ref (e.g. github:org/repo#packages.x86_64-linux.default) with
+// `nix eval --raw`. The installable must evaluate to a derivation or a store
+// path; nothing is built. A nil run uses ExecCommand.
+func NewFlake(ref, nixBin string, run CommandRunner) Source {
+	if nixBin == "" {
+		nixBin = "nix"
+	}
+
+	if run == nil {
+		run = ExecCommand
+	}
+
+	return &flake{ref: ref, nixBin: nixBin, run: run}
+}
+
+func (f *flake) String() string { return f.ref }
+
+func (f *flake) StorePaths(ctx context.Context) ([]string, error) {
+	out, err := f.run(ctx, f.nixBin,
+		"--extra-experimental-features", "nix-command flakes",
+		"eval", "--raw", f.ref,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating the flake %q: %w", f.ref, err)
+	}
+
+	return parsePathList(strings.NewReader(string(out)))
+}
+
+type pathList struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewPathList returns a Source fetching a pre-computed list of store paths
+// from endpoint, one path per line; blank lines and lines starting with # are
+// ignored. A nil client uses http.DefaultClient.
+func NewPathList(endpoint string, client *http.Client) Source {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &pathList{endpoint: endpoint, client: client}
+}
+
+func (p *pathList) String() string { return p.endpoint }
+
+func (p *pathList) StorePaths(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the path list request: %w", err)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %q: %w", p.endpoint, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %d from %q", ErrUnexpectedHTTPStatusCode, resp.StatusCode, p.endpoint)
+	}
+
+	paths, err := parsePathList(io.LimitReader(resp.Body, maxPathListSize))
+	if err != nil {
+		return nil, fmt.Errorf("error reading the path list from %q: %w", p.endpoint, err)
+	}
+
+	return paths, nil
+}
+
+func parsePathList(r io.Reader) ([]string, error) {
+	var paths []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		paths = append(paths, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,88 @@
+package prewarm_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/prewarm"
+)
+
+var errEval = errors.New("evaluation failed")
+
+func TestFlake(t *testing.T) {
+	t.Parallel()
+
+	t.Run("evaluates the installable", func(t *testing.T) {
+		t.Parallel()
+
+		var gotName string
+
+		var gotArgs []string
+
+		src := prewarm.NewFlake("github:org/repo#default", "", func(_ context.Context, name string, args ...string) ([]byte, error) {
+			gotName, gotArgs = name, args
+
+			return []byte("/nix/store/n5glp21rsz314qssw9fbvfswgy3kc68f-hello-2.12.1"), nil
+		})
+
+		paths, err := src.StorePaths(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/nix/store/n5glp21rsz314qssw9fbvfswgy3kc68f-hello-2.12.1"}, paths)
+		assert.Equal(t, "nix", gotName)
+		assert.Equal(t, []string{
+			"--extra-experimental-features", "nix-command flakes",
+			"eval", "--raw", "github:org/repo#default",
+		}, gotArgs)
+	})
+
+	t.Run("propagates evaluation errors", func(t *testing.T) {
+		t.Parallel()
+
+		src := prewarm.NewFlake(".#default", "/run/current-system/sw/bin/nix",
+			func(context.Context, string, ...string) ([]byte, error) { return nil, errEval })
+
+		_, err := src.StorePaths(context.Background())
+		require.ErrorIs(t, err, errEval)
+	})
+}
+
+func TestPathList(t *testing.T) {
+	t.Parallel()
+
+	t.Run("parses one path per line", func(t *testing.T) {
+		t.Parallel()
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("# nightly closure\n" +
+				"/nix/store/n5glp21rsz314qssw9fbvfswgy3kc68f-hello-2.12.1\n" +
+				"\n" +
+				"  /nix/store/qdcbgcj27x2kpxj2sf9yfvva7qsgg64g-glibc-2.38-77  \n"))
+		}))
+		t.Cleanup(ts.Close)
+
+		paths, err := prewarm.NewPathList(ts.URL, ts.Client()).StorePaths(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"/nix/store/n5glp21rsz314qssw9fbvfswgy3kc68f-hello-2.12.1",
+			"/nix/store/qdcbgcj27x2kpxj2sf9yfvva7qsgg64g-glibc-2.38-77",
+		}, paths)
+	})
+
+	t.Run("non-200 is an error", func(t *testing.T) {
+		t.Parallel()
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		t.Cleanup(ts.Close)
+
+		_, err := prewarm.NewPathList(ts.URL, ts.Client()).StorePaths(context.Background())
+		require.ErrorIs(t, err, prewarm.ErrUnexpectedHTTPStatusCode)
+	})
+}
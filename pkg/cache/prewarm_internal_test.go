@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/prewarm"
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+var errPrewarmSourceDown = errors.New("source down")
+
+// staticPrewarmSource returns a fixed list of store paths, or an error.
+type staticPrewarmSource struct {
+	paths []string
+	err   error
+}
+
+func (s staticPrewarmSource) String() string { return "static" }
+
+func (s staticPrewarmSource) StorePaths(context.Context) ([]string, error) { return s.paths, s.err }
+
+func TestPrewarm(t *testing.T) {
+	t.Parallel()
+
+	t.Run("pulls the missing closure members once", func(t *testing.T) {
+		t.Parallel()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		ts := testdata.NewTestServer(t, 40)
+		t.Cleanup(ts.Close)
+
+		uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), nil)
+		require.NoError(t, err)
+
+		c.AddUpstreamCaches(newContext(), uc)
+
+		<-c.GetHealthChecker().Trigger()
+
+		c.SetPrewarm([]prewarm.Source{
+			staticPrewarmSource{paths: []string{
+				"/nix/store/" + testdata.Nar1.NarInfoHash + "-hello-2.12.1",
+				"not-a-store-path",
+			}},
+			staticPrewarmSource{err: errPrewarmSourceDown},
+		}, 2)
+
+		// Nar1 references itself and a glibc that the test upstream does not have.
+		result, err := c.Prewarm(newContext())
+		require.NoError(t, err)
+		assert.Equal(t, PrewarmResult{Roots: 1, Fetched: 1, Missing: 1}, result)
+
+		assert.True(t, c.HasNarInStore(newContext(),
+			nar.URL{Hash: testdata.Nar1.NarHash, Compression: testdata.Nar1.NarCompression}))
+
+		// The second run only diffs against the cache.
+		result, err = c.Prewarm(newContext())
+		require.NoError(t, err)
+		assert.Equal(t, PrewarmResult{Roots: 1, Cached: 1, Missing: 1}, result)
+	})
+
+	t.Run("prefetches the closures of given hashes", func(t *testing.T) {
+		t.Parallel()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		ts := testdata.NewTestServer(t, 40)
+		t.Cleanup(ts.Close)
+
+		uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), nil)
+		require.NoError(t, err)
+
+		c.AddUpstreamCaches(newContext(), uc)
+
+		<-c.GetHealthChecker().Trigger()
+
+		// No pre-warm is configured: the default concurrency applies.
+		result, err := c.PrefetchClosures(newContext(), []string{testdata.Nar1.NarInfoHash})
+		require.NoError(t, err)
+		assert.Equal(t, PrewarmResult{Roots: 1, Fetched: 1, Missing: 1}, result)
+
+		stats, err := c.Stats(newContext())
+		require.NoError(t, err)
+		assert.Equal(t, 1, stats.NarInfos)
+		assert.Equal(t, 1, stats.NarFiles)
+		assert.Positive(t, stats.TotalSize)
+		assert.Equal(t, 1, stats.Upstreams)
+		assert.Equal(t, 1, stats.HealthyUpstreams)
+	})
+
+	t.Run("fails when every source fails", func(t *testing.T) {
+		t.Parallel()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		c.SetPrewarm([]prewarm.Source{staticPrewarmSource{err: errPrewarmSourceDown}}, 0)
+
+		_, err := c.Prewarm(newContext())
+		require.ErrorIs(t, err, errPrewarmSourceDown)
+	})
+}
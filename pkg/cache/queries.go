@@ -9,6 +9,7 @@ import (
 	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
 
 	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/ent/predicate"
 )
 
 // narInfoByHash returns the single narinfo row with the given hash. q may
@@ -53,3 +54,56 @@ func totalNarFileSize(ctx context.Context, q *ent.NarFileClient) (int64, error)
 
 	return 0, nil
 }
+
+// totalWholeNarFileSize returns the sum of file_size across the nar_files
+// rows stored whole, not chunked. It performs no logging.
+func totalWholeNarFileSize(ctx context.Context, q *ent.NarFileClient) (int64, error) {
+	var rows []struct {
+		Sum sql.NullInt64 `sql:"sum"`
+	}
+
+	if err := q.Query().
+		Where(entnarfile.TotalChunksEQ(0)).
+		Aggregate(ent.Sum(entnarfile.FieldFileSize)).
+		Scan(ctx, &rows); err != nil {
+		return 0, err
+	}
+
+	if len(rows) > 0 && rows[0].Sum.Valid {
+		return rows[0].Sum.Int64, nil
+	}
+
+	return 0, nil
+}
+
+// totalChunkStoreSize returns the bytes the chunks take in the chunk store:
+// the sum of their compressed_size, or of their size for the chunks stored
+// before compressed_size was recorded. It performs no logging.
+func totalChunkStoreSize(ctx context.Context, q *ent.ChunkClient) (int64, error) {
+	var total int64
+
+	for _, agg := range []struct {
+		where predicate.Chunk
+		field string
+	}{
+		{entchunk.CompressedSizeGT(0), entchunk.FieldCompressedSize},
+		{entchunk.CompressedSizeEQ(0), entchunk.FieldSize},
+	} {
+		var rows []struct {
+			Sum sql.NullInt64 `sql:"sum"`
+		}
+
+		if err := q.Query().
+			Where(agg.where).
+			Aggregate(ent.Sum(agg.field)).
+			Scan(ctx, &rows); err != nil {
+			return 0, err
+		}
+
+		if len(rows) > 0 && rows[0].Sum.Valid {
+			total += rows[0].Sum.Int64
+		}
+	}
+
+	return total, nil
+}
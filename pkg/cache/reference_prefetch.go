@@ -0,0 +1,255 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+
+	"github.com/kalbasit/ncps/pkg/analytics"
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+const (
+	// defaultReferencePrefetchTTL is how long a prefetched narinfo is kept for
+	// the client's follow-up request when SetReferencePrefetch is given a
+	// non-positive TTL.
+	defaultReferencePrefetchTTL = time.Minute
+
+	// referencePrefetchMaxEntries bounds the prefetched narinfos held in memory.
+	// Prefetches beyond it are dropped until entries are used or expire.
+	referencePrefetchMaxEntries = 10_000
+
+	// Results recorded by ncps_narinfo_reference_prefetch_total.
+	referencePrefetchResultFetched  = "fetched"
+	referencePrefetchResultUsed     = "used"
+	referencePrefetchResultNotFound = "not_found"
+	referencePrefetchResultError    = "error"
+	referencePrefetchResultDropped  = "dropped"
+)
+
+// referencePrefetch holds the narinfos fetched from an upstream ahead of the
+// client asking for them. They are kept in memory and never written to the
+// database: a narinfo is only ever cached along with its NAR, so the client's
+// follow-up request goes through the regular pull, which consumes the
+// prefetched narinfo instead of asking the upstream again and fetches the NAR.
+type referencePrefetch struct {
+	// slots bounds the prefetches running at once. A prefetch that finds no
+	// free slot is dropped rather than queued.
+	slots chan struct{}
+	ttl   time.Duration
+
+	mu       sync.Mutex
+	entries  map[string]prefetchedNarInfo
+	inflight map[string]struct{}
+}
+
+type prefetchedNarInfo struct {
+	uc        *upstream.Cache
+	narInfo   *narinfo.NarInfo
+	expiresAt time.Time
+}
+
+// SetReferencePrefetch enables the speculative prefetch of the narinfos
+// referenced by every narinfo served: up to concurrency narinfos are fetched
+// in the background and kept for ttl so the client's follow-up requests do not
+// wait on the upstream. Only metadata is prefetched, never NARs. A
+// non-positive concurrency disables the prefetch.
+func (c *Cache) SetReferencePrefetch(concurrency int, ttl time.Duration) {
+	if concurrency <= 0 {
+		c.referencePrefetch = nil
+
+		return
+	}
+
+	if ttl <= 0 {
+		ttl = defaultReferencePrefetchTTL
+	}
+
+	c.referencePrefetch = &referencePrefetch{
+		slots:    make(chan struct{}, concurrency),
+		ttl:      ttl,
+		entries:  make(map[string]prefetchedNarInfo),
+		inflight: make(map[string]struct{}),
+	}
+}
+
+// maybePrefetchReferences starts the prefetch of the references of narInfo
+// that are neither cached nor already prefetched. It never blocks the caller.
+func (c *Cache) maybePrefetchReferences(ctx context.Context, hash string, narInfo *narinfo.NarInfo) {
+	rp := c.referencePrefetch
+	if rp == nil || narInfo == nil || IsUploadOnly(ctx) {
+		return
+	}
+
+	for _, ref := range referenceHashes(narInfo.References) {
+		if ref == hash || !rp.claim(ref) {
+			continue
+		}
+
+		select {
+		case rp.slots <- struct{}{}:
+		default:
+			rp.release(ref)
+			recordReferencePrefetch(ctx, referencePrefetchResultDropped)
+
+			continue
+		}
+
+		// The prefetch outlives the request that triggered it.
+		detachedCtx := context.WithoutCancel(ctx)
+
+		c.backgroundWG.Add(1)
+
+		analytics.SafeGo(detachedCtx, func() {
+			defer c.backgroundWG.Done()
+			defer func() { <-rp.slots }()
+
+			c.prefetchNarInfo(detachedCtx, rp, ref)
+		})
+	}
+}
+
+func (c *Cache) prefetchNarInfo(ctx context.Context, rp *referencePrefetch, hash string) {
+	log := zerolog.Ctx(ctx).With().Str("op", "reference-prefetch").Str("narinfo_hash", hash).Logger()
+	ctx = log.WithContext(ctx)
+
+	// Either outcome of the fetch releases the in-flight claim; a successful one
+	// also stores the narinfo before releasing it.
+	var entry *prefetchedNarInfo
+
+	defer func() { rp.finish(hash, entry) }()
+
+	select {
+	case <-c.shutdownCh:
+		return
+	default:
+	}
+
+	exists, err := c.dbClient.Ent().NarInfo.Query().Where(entnarinfo.HashEQ(hash)).Exist(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("error checking for the narinfo before prefetching it")
+		recordReferencePrefetch(ctx, referencePrefetchResultError)
+
+		return
+	}
+
+	if exists {
+		return
+	}
+
+	uc, narInfo, err := c.getNarInfoFromUpstream(ctx, hash)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			recordReferencePrefetch(ctx, referencePrefetchResultNotFound)
+		} else {
+			log.Debug().Err(err).Msg("error prefetching the narinfo")
+			recordReferencePrefetch(ctx, referencePrefetchResultError)
+		}
+
+		return
+	}
+
+	entry = &prefetchedNarInfo{uc: uc, narInfo: narInfo, expiresAt: time.Now().Add(rp.ttl)}
+
+	recordReferencePrefetch(ctx, referencePrefetchResultFetched)
+}
+
+// takePrefetchedNarInfo returns, and forgets, the narinfo prefetched for hash.
+// The caller owns the returned narinfo and may modify it.
+func (c *Cache) takePrefetchedNarInfo(ctx context.Context, hash string) (*upstream.Cache, *narinfo.NarInfo, bool) {
+	rp := c.referencePrefetch
+	if rp == nil {
+		return nil, nil, false
+	}
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	entry, ok := rp.entries[hash]
+	if !ok {
+		return nil, nil, false
+	}
+
+	delete(rp.entries, hash)
+
+	if time.Now().After(entry.expiresAt) {
+		return nil, nil, false
+	}
+
+	recordReferencePrefetch(ctx, referencePrefetchResultUsed)
+
+	return entry.uc, entry.narInfo, true
+}
+
+// claim marks hash as being prefetched. It returns false when hash is already
+// prefetched (and not expired) or being prefetched.
+func (rp *referencePrefetch) claim(hash string) bool {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if _, ok := rp.inflight[hash]; ok {
+		return false
+	}
+
+	if entry, ok := rp.entries[hash]; ok && time.Now().Before(entry.expiresAt) {
+		return false
+	}
+
+	rp.inflight[hash] = struct{}{}
+
+	return true
+}
+
+func (rp *referencePrefetch) release(hash string) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	delete(rp.inflight, hash)
+}
+
+// finish releases the claim on hash and stores entry, if any, evicting the
+// expired entries when the table is full. The entry is dropped when the table
+// is still full afterwards.
+func (rp *referencePrefetch) finish(hash string, entry *prefetchedNarInfo) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	delete(rp.inflight, hash)
+
+	if entry == nil {
+		return
+	}
+
+	if len(rp.entries) >= referencePrefetchMaxEntries {
+		now := time.Now()
+
+		for h, e := range rp.entries {
+			if now.After(e.expiresAt) {
+				delete(rp.entries, h)
+			}
+		}
+
+		if len(rp.entries) >= referencePrefetchMaxEntries {
+			return
+		}
+	}
+
+	rp.entries[hash] = *entry
+}
+
+func recordReferencePrefetch(ctx context.Context, result string) {
+	if referencePrefetchTotal == nil {
+		return
+	}
+
+	referencePrefetchTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+}
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestReferencePrefetch(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	ts := testdata.NewTestServer(t, 40)
+	t.Cleanup(ts.Close)
+
+	var narInfoGets atomic.Int32
+
+	ts.AddMaybeHandler(func(_ http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodGet && r.URL.Path == "/"+testdata.Nar2.NarInfoHash+".narinfo" {
+			narInfoGets.Add(1)
+		}
+
+		return false
+	})
+
+	uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), nil)
+	require.NoError(t, err)
+
+	c.AddUpstreamCaches(newContext(), uc)
+
+	<-c.GetHealthChecker().Trigger()
+
+	c.SetReferencePrefetch(2, time.Minute)
+
+	served := &narinfo.NarInfo{
+		References: []string{
+			testdata.Nar1.NarInfoHash + "-hello-2.12.1",
+			testdata.Nar2.NarInfoHash + "-hello-2.12.1",
+			"33333333333333333333333333333333-missing",
+		},
+	}
+
+	c.maybePrefetchReferences(newContext(), testdata.Nar1.NarInfoHash, served)
+	c.backgroundWG.Wait()
+
+	c.referencePrefetch.mu.Lock()
+	_, selfPrefetched := c.referencePrefetch.entries[testdata.Nar1.NarInfoHash]
+	_, refPrefetched := c.referencePrefetch.entries[testdata.Nar2.NarInfoHash]
+	entries := len(c.referencePrefetch.entries)
+	c.referencePrefetch.mu.Unlock()
+
+	assert.False(t, selfPrefetched, "a narinfo must not prefetch itself")
+	assert.True(t, refPrefetched)
+	assert.Equal(t, 1, entries, "references missing upstream are not kept")
+	assert.Equal(t, int32(1), narInfoGets.Load())
+
+	// Only metadata is prefetched: nothing is cached until the client asks.
+	_, err = c.getNarInfoFromDatabase(newContext(), testdata.Nar2.NarInfoHash)
+	require.Error(t, err)
+
+	// A second serve does not prefetch the same reference again.
+	c.maybePrefetchReferences(newContext(), testdata.Nar1.NarInfoHash, served)
+	c.backgroundWG.Wait()
+	assert.Equal(t, int32(1), narInfoGets.Load())
+
+	// The follow-up request uses the prefetched narinfo.
+	ni, err := c.GetNarInfo(newContext(), testdata.Nar2.NarInfoHash)
+	require.NoError(t, err)
+	assert.Contains(t, ni.StorePath, testdata.Nar2.NarInfoHash)
+	assert.Equal(t, int32(1), narInfoGets.Load(), "the upstream must not be asked again")
+
+	c.referencePrefetch.mu.Lock()
+	assert.Empty(t, c.referencePrefetch.entries, "a prefetched narinfo is used once")
+	c.referencePrefetch.mu.Unlock()
+}
+
+func TestSetReferencePrefetchDisabled(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	c.SetReferencePrefetch(2, 0)
+	require.NotNil(t, c.referencePrefetch)
+	assert.Equal(t, defaultReferencePrefetchTTL, c.referencePrefetch.ttl)
+
+	c.SetReferencePrefetch(0, time.Minute)
+	assert.Nil(t, c.referencePrefetch)
+
+	// Serving a narinfo with prefetch disabled is a no-op.
+	c.maybePrefetchReferences(newContext(), testdata.Nar1.NarInfoHash, &narinfo.NarInfo{
+		References: []string{testdata.Nar2.NarInfoHash + "-hello-2.12.1"},
+	})
+}
@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+)
+
+// ErrRequestBudgetExhausted is returned when a request with a time budget
+// could not be answered within it. See WithRequestBudget.
+var ErrRequestBudgetExhausted = errors.New("the request budget is exhausted")
+
+type requestBudgetKey struct{}
+
+// WithRequestBudget gives the request served with ctx a time budget: the
+// upstream probes and fetch attempts made for it get what is left of the
+// budget, shared among the attempts left, instead of unbounded waits, and the
+// request fails with ErrRequestBudgetExhausted once it is spent. The budget
+// also bounds the upstream narinfo fetch the request starts, which the
+// concurrent requests for the same narinfo join. A non-positive budget leaves
+// the request unbounded.
+func WithRequestBudget(ctx context.Context, budget time.Duration) (context.Context, context.CancelFunc) {
+	if budget <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	deadline := time.Now().Add(budget)
+
+	ctx = context.WithValue(ctx, requestBudgetKey{}, deadline)
+
+	return context.WithDeadline(upstream.WithRequestBudget(ctx), deadline)
+}
+
+// withRequestBudgetDeadline bounds ctx, detached from a request with a budget,
+// by the deadline of that budget.
+func withRequestBudgetDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Value(requestBudgetKey{}).(time.Time)
+	if !ok {
+		return ctx, func() {}
+	}
+
+	return context.WithDeadline(ctx, deadline)
+}
+
+// requestBudgetError returns ErrRequestBudgetExhausted, wrapping err, if err
+// is the deadline of the budget of ctx being exceeded, and err otherwise.
+func requestBudgetError(ctx context.Context, err error) error {
+	if _, ok := ctx.Value(requestBudgetKey{}).(time.Time); !ok || !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	return fmt.Errorf("%w: %w", ErrRequestBudgetExhausted, err)
+}
@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+	"github.com/kalbasit/ncps/pkg/zstd"
+)
+
+var (
+	// ErrRangeNotSupported is returned by GetNarSeeker when the nar cannot be
+	// read from an arbitrary offset: it is not stored as a whole file, the store
+	// cannot read at an offset, or it is a .nar.zst that is not in the seekable
+	// format. The caller should serve the nar in full instead.
+	ErrRangeNotSupported = errors.New("the nar cannot be read from an offset")
+
+	// ErrNarAlreadySeekable is returned by MigrateNarToSeekableZstd when the
+	// .nar.zst is already in the seekable format.
+	ErrNarAlreadySeekable = errors.New("nar is already in the seekable zstd format")
+)
+
+// GetNarSeeker returns the nar as an io.ReadSeekCloser so a Range request can
+// be served without streaming it from the start. A Compression:none nar is read
+// from its seekable .nar.zst, decompressing only the frames covering the
+// requested range; any other nar is read from its stored bytes. It returns
+// ErrRangeNotSupported when the nar cannot be seeked.
+//
+// Unlike GetNar it never pulls the nar from an upstream.
+func (c *Cache) GetNarSeeker(ctx context.Context, narURL nar.URL) (io.ReadSeekCloser, error) {
+	ctx, span := tracer.Start(
+		ctx,
+		"cache.GetNarSeeker",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("nar_url", narURL.String()),
+		),
+	)
+	defer span.End()
+
+	storeURL := narURL
+
+	if narURL.Compression == nar.CompressionTypeNone && !c.isNarStoredRaw(ctx, narURL) {
+		zstdURL := narURL
+		zstdURL.Compression = nar.CompressionTypeZstd
+
+		present, err := c.narStore.StatNar(ctx, zstdURL)
+		if err != nil {
+			return nil, fmt.Errorf("error checking the nar in the store: %w", err)
+		}
+
+		if present {
+			storeURL = zstdURL
+		}
+	}
+
+	size, r, err := c.narStore.GetNar(ctx, storeURL)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("%w: not stored as a whole file", ErrRangeNotSupported)
+		}
+
+		return nil, fmt.Errorf("error fetching the nar from the store: %w", err)
+	}
+
+	ra, ok := r.(io.ReaderAt)
+	if !ok {
+		r.Close()
+
+		return nil, fmt.Errorf("%w: the store cannot read at an offset", ErrRangeNotSupported)
+	}
+
+	var rs io.ReadSeekCloser
+
+	if storeURL.Compression == narURL.Compression {
+		rs = sectionReadCloser{SectionReader: io.NewSectionReader(ra, 0, size), Closer: r}
+	} else {
+		sr, err := zstd.NewSeekableReader(ra, size)
+		if err != nil {
+			r.Close()
+
+			if errors.Is(err, zstd.ErrNotSeekable) {
+				return nil, fmt.Errorf("%w: %w", ErrRangeNotSupported, err)
+			}
+
+			return nil, fmt.Errorf("error reading the seek table: %w", err)
+		}
+
+		rs = sr
+	}
+
+	if err := c.touchNarFile(ctx, narURL); err != nil {
+		rs.Close()
+
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// MigrateNarToSeekableZstd rewrites the .nar.zst of a Compression:none nar in
+// the seekable zstd format so GetNarSeeker can serve it. Only the .nar.zst ncps
+// produced by recompressing uncompressed nars is rewritten: a nar stored with
+// the compression of its upstream keeps the upstream's bytes. It returns
+// ErrNarAlreadySeekable when there is nothing to do and storage.ErrNotFound
+// when the nar has no .nar.zst.
+func (c *Cache) MigrateNarToSeekableZstd(ctx context.Context, narURL *nar.URL) error {
+	ctx, span := tracer.Start(
+		ctx,
+		"cache.MigrateNarToSeekableZstd",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("nar_url", narURL.String()),
+		),
+	)
+	defer span.End()
+
+	return c.withNarMigrationLock(ctx, narURL.Hash, "MigrateNarToSeekableZstd", func() error {
+		zstdURL := nar.URL{Hash: narURL.Hash, Compression: nar.CompressionTypeZstd, Query: narURL.Query}
+
+		size, r, err := c.narStore.GetNar(ctx, zstdURL)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		if ra, ok := r.(io.ReaderAt); ok {
+			if _, err := zstd.ReadSeekTable(ra, size); err == nil {
+				return ErrNarAlreadySeekable
+			} else if !errors.Is(err, zstd.ErrNotSeekable) {
+				return fmt.Errorf("error reading the seek table: %w", err)
+			}
+		}
+
+		f, err := os.CreateTemp(c.tempDir, fmt.Sprintf("%s-*.nar.zst", filepath.Base(narURL.Hash)))
+		if err != nil {
+			return fmt.Errorf("error creating the temporary file: %w", err)
+		}
+
+		defer func() {
+			f.Close()
+			os.Remove(f.Name())
+		}()
+
+		decompressed, err := reencodeSeekable(f, r)
+		if err != nil {
+			return err
+		}
+
+		fi, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("error stating the temporary file: %w", err)
+		}
+
+		st, err := zstd.ReadSeekTable(f, fi.Size())
+		if err != nil {
+			return fmt.Errorf("error verifying the seekable nar: %w", err)
+		}
+
+		if st.DecompressedSize() != decompressed {
+			return fmt.Errorf("%w: seekable nar holds %d bytes, expected %d", zstd.ErrCorruptSeekTable,
+				st.DecompressedSize(), decompressed)
+		}
+
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("error rewinding the temporary file: %w", err)
+		}
+
+		// The store has no atomic replace: a reader racing the swap misses the
+		// whole file and falls back to the upstream like for any missing nar.
+		if err := c.narStore.DeleteNar(ctx, zstdURL); err != nil {
+			return fmt.Errorf("error deleting the non-seekable nar: %w", err)
+		}
+
+		written, err := c.narStore.PutNar(ctx, zstdURL, f, fi.Size())
+		if err != nil {
+			zerolog.Ctx(ctx).
+				Error().
+				Err(err).
+				Str("nar_url", zstdURL.String()).
+				Msg("error storing the seekable nar; the nar will be pulled again from an upstream")
+
+			return fmt.Errorf("error storing the seekable nar: %w", err)
+		}
+
+		noneURL := nar.URL{Hash: narURL.Hash, Compression: nar.CompressionTypeNone, Query: narURL.Query}
+
+		return c.ensureNarFileRecord(ctx, noneURL, written, false, "MigrateNarToSeekableZstd")
+	})
+}
+
+// reencodeSeekable decompresses the zstd stream r into w in the seekable
+// format and returns the number of decompressed bytes.
+func reencodeSeekable(w io.Writer, r io.Reader) (int64, error) {
+	pr, err := zstd.NewPooledReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("error creating the zstd reader: %w", err)
+	}
+	defer pr.Close()
+
+	sw := zstd.NewNarSeekableWriter(w)
+
+	n, err := io.Copy(sw, pr)
+	if err != nil {
+		return 0, fmt.Errorf("error re-encoding the nar: %w", err)
+	}
+
+	if err := sw.Close(); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
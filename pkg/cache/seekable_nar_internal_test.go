@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+	"github.com/kalbasit/ncps/pkg/storage/local"
+	"github.com/kalbasit/ncps/pkg/zstd"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestMigrateNarToSeekableZstd(t *testing.T) {
+	t.Parallel()
+
+	c := newServableTestCache(t, func(s *local.Store) storage.NarStore { return s })
+
+	// Several frames worth of data, stored the way ncps stored uncompressed
+	// nars before the seekable format: a single regular zstd frame.
+	data := make([]byte, 3*zstd.DefaultSeekableFrameSize+12345)
+	for i := range data {
+		data[i] = byte(rand.IntN(8)) //nolint:gosec // test data
+	}
+
+	enc := zstd.GetWriter()
+	compressed := enc.EncodeAll(data, nil)
+	zstd.PutWriter(enc)
+
+	noneURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: nar.CompressionTypeNone}
+	zstdURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: nar.CompressionTypeZstd}
+
+	_, err := c.narStore.PutNar(newContext(), zstdURL, bytes.NewReader(compressed), int64(len(compressed)))
+	require.NoError(t, err)
+
+	_, err = c.GetNarSeeker(newContext(), noneURL)
+	require.ErrorIs(t, err, ErrRangeNotSupported, "a non-seekable .nar.zst cannot serve ranges")
+
+	require.NoError(t, c.MigrateNarToSeekableZstd(newContext(), &noneURL))
+	require.ErrorIs(t, c.MigrateNarToSeekableZstd(newContext(), &noneURL), ErrNarAlreadySeekable)
+
+	rs, err := c.GetNarSeeker(newContext(), noneURL)
+	require.NoError(t, err)
+
+	defer rs.Close()
+
+	end, err := rs.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), end)
+
+	off := int64(2*zstd.DefaultSeekableFrameSize - 50)
+	_, err = rs.Seek(off, io.SeekStart)
+	require.NoError(t, err)
+
+	got := make([]byte, 100)
+	_, err = io.ReadFull(rs, got)
+	require.NoError(t, err)
+	assert.Equal(t, data[off:off+100], got)
+
+	// The rewritten file is still a regular zstd stream for every other reader.
+	_, r, err := c.narStore.GetNar(newContext(), zstdURL)
+	require.NoError(t, err)
+
+	defer r.Close()
+
+	pr, err := zstd.NewPooledReader(r)
+	require.NoError(t, err)
+
+	defer pr.Close()
+
+	all, err := io.ReadAll(pr)
+	require.NoError(t, err)
+	assert.Equal(t, data, all)
+}
+
+func TestMigrateNarToSeekableZstd_MissingNar(t *testing.T) {
+	t.Parallel()
+
+	c := newServableTestCache(t, func(s *local.Store) storage.NarStore { return s })
+
+	noneURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: nar.CompressionTypeNone}
+
+	require.ErrorIs(t, c.MigrateNarToSeekableZstd(newContext(), &noneURL), storage.ErrNotFound)
+
+	_, err := c.GetNarSeeker(newContext(), noneURL)
+	require.ErrorIs(t, err, ErrRangeNotSupported)
+}
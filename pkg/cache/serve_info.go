@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"context"
+	"sync"
+)
+
+// ServeStatus describes how the cache answered a request.
+type ServeStatus string
+
+const (
+	// ServeStatusHit means the object was served from the cache.
+	ServeStatusHit ServeStatus = "HIT"
+
+	// ServeStatusMiss means the object was pulled from an upstream cache.
+	ServeStatusMiss ServeStatus = "MISS"
+
+	// ServeStatusPass means the client was handed over to the storage backend
+	// (e.g. a presigned redirect) instead of being served by ncps.
+	ServeStatusPass ServeStatus = "PASS"
+)
+
+// ServeStore describes where the served NAR bytes came from.
+type ServeStore string
+
+const (
+	// ServeStoreFile means the NAR was served from a whole file.
+	ServeStoreFile ServeStore = "file"
+
+	// ServeStoreChunks means the NAR was reassembled from CDC chunks.
+	ServeStoreChunks ServeStore = "chunks"
+
+	// ServeStoreStaging means the NAR was served from the in-flight staging of
+	// a download running on another instance.
+	ServeStoreStaging ServeStore = "staging"
+)
+
+// ServeInfo records how a GetNarInfo or GetNar call was served. Attach one to
+// the context with WithServeInfo before the call and read it afterwards.
+type ServeInfo struct {
+	mu       sync.Mutex
+	status   ServeStatus
+	upstream string
+	store    ServeStore
+	purged   bool
+}
+
+const serveInfoKey contextKey = "serve_info"
+
+// WithServeInfo returns a context recording how the cache serves the request
+// into the returned ServeInfo.
+func WithServeInfo(ctx context.Context) (context.Context, *ServeInfo) {
+	si := &ServeInfo{}
+
+	return context.WithValue(ctx, serveInfoKey, si), si
+}
+
+// Set records how the request was served. An empty upstream or store leaves
+// the previous value untouched.
+func (si *ServeInfo) Set(status ServeStatus, upstream string, store ServeStore) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	si.status = status
+
+	if upstream != "" {
+		si.upstream = upstream
+	}
+
+	if store != "" {
+		si.store = store
+	}
+}
+
+// Status returns how the request was served, or "" if it was not recorded.
+func (si *ServeInfo) Status() ServeStatus {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	return si.status
+}
+
+// Upstream returns the hostname of the upstream the object was pulled from.
+func (si *ServeInfo) Upstream() string {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	return si.upstream
+}
+
+// Store returns where the NAR bytes came from.
+func (si *ServeInfo) Store() ServeStore {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	return si.store
+}
+
+// NarInfoPurged reports whether the narinfo was purged while being served
+// because its NAR was missing, which GetNarInfo reports as not found.
+func (si *ServeInfo) NarInfoPurged() bool {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	return si.purged
+}
+
+// recordNarInfoPurged records that the narinfo was purged if the context
+// carries a ServeInfo.
+func recordNarInfoPurged(ctx context.Context) {
+	if si, ok := ctx.Value(serveInfoKey).(*ServeInfo); ok {
+		si.mu.Lock()
+		si.purged = true
+		si.mu.Unlock()
+	}
+}
+
+// recordServe records how the request was served if the context carries a
+// ServeInfo.
+func recordServe(ctx context.Context, status ServeStatus, upstream string, store ServeStore) {
+	if si, ok := ctx.Value(serveInfoKey).(*ServeInfo); ok {
+		si.Set(status, upstream, store)
+	}
+}
+
+// narServeStore returns the ServeStore of a NAR served from storage.
+func narServeStore(hasNarInStore bool) ServeStore {
+	if hasNarInStore {
+		return ServeStoreFile
+	}
+
+	return ServeStoreChunks
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog"
+)
+
+// sqliteMaintenanceLockKey keeps the instances sharing a SQLite database from
+// running its maintenance together.
+const sqliteMaintenanceLockKey = "sqlite-maintenance"
+
+// AddSQLiteMaintenanceCronJob adds a job checkpointing the WAL of the SQLite
+// database, returning up to vacuumPages of its free pages to the filesystem
+// (all of them when vacuumPages <= 0) and refreshing its statistics. See
+// database.Client.RunSQLiteMaintenance.
+func (c *Cache) AddSQLiteMaintenanceCronJob(ctx context.Context, schedule cron.Schedule, vacuumPages int) {
+	zerolog.Ctx(ctx).
+		Info().
+		Time("next-run", schedule.Next(time.Now())).
+		Int("vacuum_pages", vacuumPages).
+		Msg("adding a cronjob for SQLite maintenance")
+
+	c.scheduleCronJob(ctx, CronJobSQLiteMaintenance, schedule, func(ctx context.Context) func() {
+		return c.runSQLiteMaintenance(ctx, vacuumPages)
+	})
+}
+
+func (c *Cache) runSQLiteMaintenance(ctx context.Context, vacuumPages int) func() {
+	return func() {
+		startTime := time.Now()
+
+		log := zerolog.Ctx(ctx).With().Str("op", "sqlite-maintenance").Logger()
+
+		acquired, err := c.withTryLock(ctx, "runSQLiteMaintenance", sqliteMaintenanceLockKey, func() error {
+			log.Info().Msg("running SQLite maintenance")
+
+			stats, err := c.dbClient.RunSQLiteMaintenance(ctx, vacuumPages)
+			if err != nil {
+				return err
+			}
+
+			if stats.CheckpointBusy {
+				log.Warn().Msg("the WAL checkpoint was blocked by a concurrent transaction, the WAL was not truncated")
+			}
+
+			log.Info().
+				Int64("checkpointed_frames", stats.CheckpointedFrames).
+				Bool("converted_to_incremental_vacuum", stats.ConvertedToIncrementalVacuum).
+				Int64("freed_pages", stats.FreedPages).
+				Dur("elapsed", time.Since(startTime)).
+				Msg("SQLite maintenance completed")
+
+			return nil
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("error running SQLite maintenance")
+
+			recordCronJobError(ctx, err)
+		} else if !acquired {
+			log.Debug().Msg("another instance is running SQLite maintenance, skipping")
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+)
+
+// Stats is a snapshot of what the cache holds.
+type Stats struct {
+	// NarInfos is the number of narinfos.
+	NarInfos int
+
+	// NarFiles is the number of NAR files, whole or chunked.
+	NarFiles int
+
+	// TotalSize is the sum of the file sizes of the NAR files.
+	TotalSize int64
+
+	// MaxSize is the configured maximum size of the store, zero if unlimited.
+	MaxSize uint64
+
+	// Chunks is the number of CDC chunks.
+	Chunks int
+
+	// PinnedClosures is the number of pinned closures.
+	PinnedClosures int
+
+	// Upstreams and HealthyUpstreams are the number of upstream caches and how
+	// many of them are healthy.
+	Upstreams        int
+	HealthyUpstreams int
+}
+
+// Stats returns a snapshot of what the cache holds, counted from the database.
+func (c *Cache) Stats(ctx context.Context) (Stats, error) {
+	db := c.dbClient.Ent()
+
+	stats := Stats{
+		MaxSize:          c.maxSize,
+		Upstreams:        c.GetUpstreamCount(),
+		HealthyUpstreams: c.GetHealthyUpstreamCount(),
+	}
+
+	var err error
+
+	if stats.NarInfos, err = db.NarInfo.Query().Count(ctx); err != nil {
+		return Stats{}, fmt.Errorf("error counting the narinfos: %w", err)
+	}
+
+	if stats.NarFiles, err = db.NarFile.Query().Count(ctx); err != nil {
+		return Stats{}, fmt.Errorf("error counting the nar files: %w", err)
+	}
+
+	if stats.TotalSize, err = totalNarFileSize(ctx, db.NarFile); err != nil {
+		return Stats{}, fmt.Errorf("error summing the nar file sizes: %w", err)
+	}
+
+	if stats.Chunks, err = db.Chunk.Query().Count(ctx); err != nil {
+		return Stats{}, fmt.Errorf("error counting the chunks: %w", err)
+	}
+
+	if stats.PinnedClosures, err = db.PinnedClosure.Query().Count(ctx); err != nil {
+		return Stats{}, fmt.Errorf("error counting the pinned closures: %w", err)
+	}
+
+	return stats, nil
+}
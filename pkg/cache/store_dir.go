@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+)
+
+// DefaultStoreDir is the store dir of the narinfos ncps stores.
+const DefaultStoreDir = "/nix/store"
+
+// ErrInvalidStoreDir is returned by SetStoreDirRewrite for a store dir that is
+// not a clean absolute path.
+var ErrInvalidStoreDir = errors.New("the store dir must be a clean absolute path")
+
+// storeDirRewrite maps the store dir of the stored narinfos, from, to the one
+// of the served narinfos, to.
+type storeDirRewrite struct {
+	from string
+	to   string
+}
+
+// SetStoreDirRewrite configures the narinfos stored with their store path under
+// from to be served under to, as advertised by nix-cache-info, and the
+// narinfos uploaded under to to be stored under from. The served narinfos are
+// re-signed, the signatures of the stored ones not covering the rewritten
+// store path. Equal store dirs disable the rewrite.
+func (c *Cache) SetStoreDirRewrite(from, to string) error {
+	for _, dir := range []string{from, to} {
+		if !path.IsAbs(dir) || path.Clean(dir) != dir || dir == "/" {
+			return fmt.Errorf("%w: %q", ErrInvalidStoreDir, dir)
+		}
+	}
+
+	if from == to {
+		c.storeDirRewrite = nil
+
+		return nil
+	}
+
+	c.storeDirRewrite = &storeDirRewrite{from: from, to: to}
+
+	return nil
+}
+
+// StoreDir returns the store dir of the narinfos served to the clients.
+func (c *Cache) StoreDir() string {
+	if c.storeDirRewrite == nil {
+		return DefaultStoreDir
+	}
+
+	return c.storeDirRewrite.to
+}
+
+// ServedNarInfo returns narInfo as it is served to the clients: with the
+// fields set by SetNarInfoFields, and its store path moved to the served store
+// dir and signed by this cache alone, since the other signatures do not cover
+// the rewritten store path. Without a field set or a store dir rewrite, or for
+// a narinfo outside of the stored store dir, narInfo itself is returned; it is
+// never modified.
+func (c *Cache) ServedNarInfo(ctx context.Context, hash string, narInfo *narinfo.NarInfo) (*narinfo.NarInfo, error) {
+	narInfo = c.applyNarInfoFields(narInfo)
+
+	rw := c.storeDirRewrite
+	if rw == nil {
+		return narInfo, nil
+	}
+
+	storePath, ok := moveStorePath(narInfo.StorePath, rw.from, rw.to)
+	if !ok {
+		return narInfo, nil
+	}
+
+	served := *narInfo
+	served.StorePath = storePath
+	served.Signatures = nil
+
+	if c.shouldSignNarinfo {
+		sig, err := c.signer.Sign(ctx, narInfoFingerprint(&served))
+		if err != nil {
+			return nil, fmt.Errorf("error signing the rewritten narinfo %s: %w", hash, err)
+		}
+
+		served.Signatures = append(served.Signatures, sig)
+	}
+
+	return &served, nil
+}
+
+// ingestStorePath moves the store path of a narinfo uploaded under the served
+// store dir to the stored one. The signatures of the upload, which cover the
+// served store path, are dropped.
+func (c *Cache) ingestStorePath(narInfo *narinfo.NarInfo) {
+	rw := c.storeDirRewrite
+	if rw == nil {
+		return
+	}
+
+	if storePath, ok := moveStorePath(narInfo.StorePath, rw.to, rw.from); ok {
+		narInfo.StorePath = storePath
+		narInfo.Signatures = nil
+	}
+}
+
+// moveStorePath returns storePath moved from the store dir from to the store
+// dir to, and whether it was under from.
+func moveStorePath(storePath, from, to string) (string, bool) {
+	base, ok := strings.CutPrefix(storePath, from+"/")
+	if !ok || base == "" || strings.Contains(base, "/") {
+		return storePath, false
+	}
+
+	return to + "/" + base, true
+}
+
+// narInfoFingerprint returns the fingerprint signed for narInfo. Unlike
+// narinfo.NarInfo.Fingerprint, the references are made absolute in the store
+// dir of the store path rather than in /nix/store.
+func narInfoFingerprint(narInfo *narinfo.NarInfo) string {
+	var narHash string
+	if narInfo.NarHash != nil {
+		narHash = narInfo.NarHash.Format(nixhash.NixBase32, true)
+	}
+
+	storeDir := path.Dir(narInfo.StorePath)
+
+	refs := make([]string, 0, len(narInfo.References))
+	for _, ref := range narInfo.References {
+		refs = append(refs, storeDir+"/"+ref)
+	}
+
+	return "1;" + narInfo.StorePath + ";" + narHash + ";" +
+		strconv.FormatUint(narInfo.NarSize, 10) + ";" + strings.Join(refs, ",")
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestNarInfoFingerprintMatchesNix(t *testing.T) {
+	t.Parallel()
+
+	ni, err := narinfo.Parse(strings.NewReader(testdata.Nar1.NarInfoText))
+	require.NoError(t, err)
+
+	assert.Equal(t, ni.Fingerprint(), narInfoFingerprint(ni))
+
+	ni.References = nil
+	assert.Equal(t, ni.Fingerprint(), narInfoFingerprint(ni))
+}
+
+func TestMoveStorePath(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		storePath string
+		want      string
+		moved     bool
+	}{
+		{"/nix/store/abc-hello", "/opt/store/abc-hello", true},
+		{"/nix/storefront/abc-hello", "/nix/storefront/abc-hello", false},
+		{"/nix/store/abc-hello/bin", "/nix/store/abc-hello/bin", false},
+		{"/nix/store/", "/nix/store/", false},
+		{"/gnu/store/abc-hello", "/gnu/store/abc-hello", false},
+	}
+
+	for _, tt := range tests {
+		got, moved := moveStorePath(tt.storePath, "/nix/store", "/opt/store")
+		assert.Equal(t, tt.want, got, tt.storePath)
+		assert.Equal(t, tt.moved, moved, tt.storePath)
+	}
+}
+
+func TestStoreDirRewrite(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	assert.Equal(t, DefaultStoreDir, c.StoreDir())
+
+	for _, dir := range []string{"opt/store", "/opt/store/", "/", ""} {
+		require.ErrorIs(t, c.SetStoreDirRewrite(DefaultStoreDir, dir), ErrInvalidStoreDir, dir)
+	}
+
+	require.NoError(t, c.SetStoreDirRewrite(DefaultStoreDir, "/opt/nix/store"))
+	assert.Equal(t, "/opt/nix/store", c.StoreDir())
+
+	narURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: testdata.Nar1.NarCompression}
+	require.NoError(t, c.PutNar(newContext(), narURL, io.NopCloser(strings.NewReader(testdata.Nar1.NarText))))
+
+	// A client of the rewritten store uploads under its own store dir.
+	uploaded := strings.ReplaceAll(testdata.Nar1.NarInfoText, "/nix/store/", "/opt/nix/store/")
+	require.NoError(t, c.PutNarInfo(newContext(), testdata.Nar1.NarInfoHash, io.NopCloser(strings.NewReader(uploaded))))
+
+	stored, err := c.GetNarInfo(newContext(), testdata.Nar1.NarInfoHash)
+	require.NoError(t, err)
+	assert.Equal(t, "/nix/store/n5glp21rsz314qssw9fbvfswgy3kc68f-hello-2.12.1", stored.StorePath)
+	require.Len(t, stored.Signatures, 1, "the signature of the upload is replaced")
+	assert.True(t, signature.VerifyFirst(stored.Fingerprint(), stored.Signatures,
+		[]signature.PublicKey{c.PublicKey()}))
+
+	served, err := c.ServedNarInfo(newContext(), testdata.Nar1.NarInfoHash, stored)
+	require.NoError(t, err)
+	assert.Equal(t, "/opt/nix/store/n5glp21rsz314qssw9fbvfswgy3kc68f-hello-2.12.1", served.StorePath)
+	require.Len(t, served.Signatures, 1)
+	assert.True(t, signature.VerifyFirst(narInfoFingerprint(served), served.Signatures,
+		[]signature.PublicKey{c.PublicKey()}))
+	assert.Equal(t, "/nix/store/n5glp21rsz314qssw9fbvfswgy3kc68f-hello-2.12.1", stored.StorePath,
+		"the stored narinfo is not modified")
+
+	require.NoError(t, c.SetStoreDirRewrite(DefaultStoreDir, DefaultStoreDir))
+
+	served, err = c.ServedNarInfo(newContext(), testdata.Nar1.NarInfoHash, stored)
+	require.NoError(t, err)
+	assert.Same(t, stored, served, "equal store dirs disable the rewrite")
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+// Values of the "direction" attribute on ncps_nar_stream_duration_seconds.
+const (
+	streamDirectionServe  = "serve"
+	streamDirectionUpload = "upload"
+)
+
+// Values of the "result" attribute on the latency and stream metrics.
+const (
+	streamResultSuccess  = "success"
+	streamResultNotFound = "not_found"
+	streamResultAborted  = "aborted"
+	streamResultError    = "error"
+)
+
+// streamResultFromError maps the outcome of an operation to the "result"
+// attribute recorded on the latency metrics.
+func streamResultFromError(err error) string {
+	switch {
+	case err == nil:
+		return streamResultSuccess
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, upstream.ErrNotFound):
+		return streamResultNotFound
+	case errors.Is(err, context.Canceled):
+		return streamResultAborted
+	default:
+		return streamResultError
+	}
+}
+
+// meteredNarStream wraps a NAR served to a client and records the time to its
+// first byte and the duration of the whole stream, both measured from the start
+// of GetNar. The stream counts as a
+// success when it was read to EOF, as aborted when the client closed it early
+// and as an error when reading it failed.
+type meteredNarStream struct {
+	io.ReadCloser
+
+	ctx         context.Context
+	compression string
+	start       time.Time
+
+	sawFirstByte bool
+	sawEOF       bool
+	readErr      error
+
+	closeOnce sync.Once
+}
+
+func newMeteredNarStream(
+	ctx context.Context,
+	rc io.ReadCloser,
+	compression nar.CompressionType,
+	start time.Time,
+) io.ReadCloser {
+	return &meteredNarStream{
+		ReadCloser:  rc,
+		ctx:         context.WithoutCancel(ctx),
+		compression: compression.String(),
+		start:       start,
+	}
+}
+
+func (s *meteredNarStream) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+
+	if n > 0 && !s.sawFirstByte {
+		s.sawFirstByte = true
+
+		narServeTTFB.Record(s.ctx, time.Since(s.start).Seconds(), metric.WithAttributes(
+			attribute.String("compression", s.compression),
+			attribute.String("result", streamResultSuccess),
+		))
+	}
+
+	switch {
+	case errors.Is(err, io.EOF):
+		s.sawEOF = true
+	case err != nil && s.readErr == nil:
+		s.readErr = err
+	}
+
+	return n, err
+}
+
+func (s *meteredNarStream) Close() error {
+	err := s.ReadCloser.Close()
+
+	s.closeOnce.Do(func() {
+		result := streamResultAborted
+
+		switch {
+		case s.readErr != nil:
+			result = streamResultError
+		case s.sawEOF:
+			result = streamResultSuccess
+		}
+
+		// A stream that never produced a byte still has a time to first byte:
+		// record it under the failure result so failed requests stay visible.
+		if !s.sawFirstByte {
+			narServeTTFB.Record(s.ctx, time.Since(s.start).Seconds(), metric.WithAttributes(
+				attribute.String("compression", s.compression),
+				attribute.String("result", result),
+			))
+		}
+
+		narStreamDuration.Record(s.ctx, time.Since(s.start).Seconds(), metric.WithAttributes(
+			attribute.String("direction", streamDirectionServe),
+			attribute.String("compression", s.compression),
+			attribute.String("result", result),
+		))
+	})
+
+	return err
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+var errStreamBroken = errors.New("stream broken")
+
+func TestStreamResultFromError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil is success", nil, streamResultSuccess},
+		{"storage not found", storage.ErrNotFound, streamResultNotFound},
+		{"wrapped upstream not found", fmt.Errorf("fetching: %w", upstream.ErrNotFound), streamResultNotFound},
+		{"canceled is aborted", context.Canceled, streamResultAborted},
+		{"anything else is an error", errStreamBroken, streamResultError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, streamResultFromError(tt.err))
+		})
+	}
+}
+
+type failingReadCloser struct{ closed bool }
+
+func (f *failingReadCloser) Read([]byte) (int, error) { return 0, errStreamBroken }
+
+func (f *failingReadCloser) Close() error {
+	f.closed = true
+
+	return nil
+}
+
+func TestMeteredNarStream(t *testing.T) {
+	t.Parallel()
+
+	t.Run("passes bytes through and tracks EOF", func(t *testing.T) {
+		t.Parallel()
+
+		rc := newMeteredNarStream(
+			context.Background(), io.NopCloser(strings.NewReader("nar bytes")), nar.CompressionTypeXz, time.Now(),
+		)
+
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		assert.Equal(t, "nar bytes", string(b))
+
+		ms, ok := rc.(*meteredNarStream)
+		require.True(t, ok)
+		assert.True(t, ms.sawFirstByte)
+		assert.True(t, ms.sawEOF)
+		require.NoError(t, ms.readErr)
+
+		require.NoError(t, rc.Close())
+		require.NoError(t, rc.Close(), "closing twice must be safe")
+	})
+
+	t.Run("tracks read errors and closes the underlying reader", func(t *testing.T) {
+		t.Parallel()
+
+		frc := &failingReadCloser{}
+		rc := newMeteredNarStream(context.Background(), frc, nar.CompressionTypeNone, time.Now())
+
+		_, err := io.ReadAll(rc)
+		require.ErrorIs(t, err, errStreamBroken)
+
+		ms, ok := rc.(*meteredNarStream)
+		require.True(t, ok)
+		assert.False(t, ms.sawFirstByte)
+		require.ErrorIs(t, ms.readErr, errStreamBroken)
+
+		require.NoError(t, rc.Close())
+		assert.True(t, frc.closed)
+	})
+}
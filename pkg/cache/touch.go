@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+)
+
+// TouchMode selects how the last access time of the narinfo and nar_file
+// records served is refreshed, which drives the LRU eviction.
+type TouchMode string
+
+const (
+	// TouchModeSync refreshes the last access time in the transaction serving
+	// the record. It is the default, also used while no mode is set.
+	TouchModeSync TouchMode = "sync"
+
+	// TouchModeAsync refreshes the last access time in the background, after
+	// the record is served, keeping the write out of the serving transaction.
+	TouchModeAsync TouchMode = "async"
+
+	// TouchModeOff never refreshes the last access time, for read-only
+	// replicas. The LRU eviction then only knows when a record was stored.
+	TouchModeOff TouchMode = "off"
+)
+
+// ErrUnknownTouchMode is returned by ParseTouchMode for an unknown mode.
+var ErrUnknownTouchMode = errors.New("unknown touch mode (allowed: sync, async, off)")
+
+// ParseTouchMode parses the name of a TouchMode. The empty string is the
+// default mode.
+func ParseTouchMode(s string) (TouchMode, error) {
+	switch TouchMode(s) {
+	case "", TouchModeSync:
+		return TouchModeSync, nil
+	case TouchModeAsync:
+		return TouchModeAsync, nil
+	case TouchModeOff:
+		return TouchModeOff, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownTouchMode, s)
+	}
+}
+
+// SetTouchMode sets how the last access time of the records served is
+// refreshed. Whatever the mode, a record last accessed within the duration set
+// by SetRecordAgeIgnoreTouch is not touched.
+func (c *Cache) SetTouchMode(mode TouchMode) { c.touchMode = mode }
+
+// touchDue reports whether a record last accessed at lastAccessedAt is to be
+// touched.
+func (c *Cache) touchDue(lastAccessedAt *time.Time) bool {
+	if c.touchMode == TouchModeOff {
+		return false
+	}
+
+	return lastAccessedAt == nil || time.Since(*lastAccessedAt) > c.recordAgeIgnoreTouch
+}
+
+// touchAsync runs the touch of the record identified by key in the background,
+// unless one is already running.
+func (c *Cache) touchAsync(ctx context.Context, key string, touch func(ctx context.Context) error) {
+	if _, running := c.touchesInFlight.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+
+	c.backgroundWG.Add(1)
+
+	go func() {
+		defer c.backgroundWG.Done()
+		defer c.touchesInFlight.Delete(key)
+
+		if err := touch(ctx); err != nil {
+			zerolog.Ctx(ctx).
+				Warn().
+				Err(err).
+				Str("record", key).
+				Msg("error touching the record in the background")
+		}
+	}()
+}
+
+// touchNarInfoAsync refreshes the last access time of the narinfo record of
+// hash in the background.
+func (c *Cache) touchNarInfoAsync(ctx context.Context, hash string) {
+	c.touchAsync(ctx, "narinfo:"+hash, func(ctx context.Context) error {
+		now := time.Now()
+
+		if _, err := c.dbClient.Ent().NarInfo.Update().
+			Where(
+				entnarinfo.HashEQ(hash),
+				entnarinfo.Or(
+					entnarinfo.LastAccessedAtIsNil(),
+					entnarinfo.LastAccessedAtLT(now.Add(-c.recordAgeIgnoreTouch)),
+				),
+			).
+			SetLastAccessedAt(now).
+			Save(ctx); err != nil {
+			return fmt.Errorf("error touching the narinfo record: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// touchNarFileAsync refreshes the last access time of the nar_file record id
+// in the background.
+func (c *Cache) touchNarFileAsync(ctx context.Context, id int) {
+	c.touchAsync(ctx, fmt.Sprintf("nar_file:%d", id), func(ctx context.Context) error {
+		now := time.Now()
+
+		if _, err := c.dbClient.Ent().NarFile.Update().
+			Where(
+				entnarfile.ID(id),
+				entnarfile.Or(
+					entnarfile.LastAccessedAtIsNil(),
+					entnarfile.LastAccessedAtLT(now.Add(-c.recordAgeIgnoreTouch)),
+				),
+			).
+			SetLastAccessedAt(now).
+			SetUpdatedAt(now).
+			Save(ctx); err != nil {
+			return fmt.Errorf("error touching the nar record: %w", err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestParseTouchMode(t *testing.T) {
+	t.Parallel()
+
+	for s, want := range map[string]TouchMode{
+		"":      TouchModeSync,
+		"sync":  TouchModeSync,
+		"async": TouchModeAsync,
+		"off":   TouchModeOff,
+	} {
+		got, err := ParseTouchMode(s)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := ParseTouchMode("lazy")
+	require.ErrorIs(t, err, ErrUnknownTouchMode)
+}
+
+func TestTouchMode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		mode        TouchMode
+		wantTouched bool
+	}{
+		{mode: TouchModeSync, wantTouched: true},
+		{mode: TouchModeAsync, wantTouched: true},
+		{mode: TouchModeOff, wantTouched: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			t.Parallel()
+
+			c, dbClient, _, _, _, cleanup := setupSQLiteFactory(t)
+			t.Cleanup(cleanup)
+
+			ctx := newContext()
+
+			narURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: testdata.Nar1.NarCompression}
+			require.NoError(t, c.PutNar(ctx, narURL, io.NopCloser(strings.NewReader(testdata.Nar1.NarText))))
+			require.NoError(t, c.PutNarInfo(ctx, testdata.Nar1.NarInfoHash,
+				io.NopCloser(strings.NewReader(testdata.Nar1.NarInfoText))))
+
+			stale := time.Now().Add(-time.Hour)
+
+			_, err := dbClient.Ent().NarInfo.Update().
+				Where(entnarinfo.HashEQ(testdata.Nar1.NarInfoHash)).
+				SetLastAccessedAt(stale).
+				Save(context.Background())
+			require.NoError(t, err)
+
+			c.SetTouchMode(tt.mode)
+
+			_, err = c.GetNarInfo(ctx, testdata.Nar1.NarInfoHash)
+			require.NoError(t, err)
+
+			// Wait for the touches in the background.
+			c.backgroundWG.Wait()
+
+			ni, err := dbClient.Ent().NarInfo.Query().
+				Where(entnarinfo.HashEQ(testdata.Nar1.NarInfoHash)).
+				Only(context.Background())
+			require.NoError(t, err)
+			require.NotNil(t, ni.LastAccessedAt)
+
+			assert.Equal(t, tt.wantTouched, ni.LastAccessedAt.After(stale.Add(time.Minute)))
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/kalbasit/ncps/ent"
+
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+	entnarinfonarfile "github.com/kalbasit/ncps/ent/narinfonarfile"
+
+	"github.com/kalbasit/ncps/pkg/database"
+	"github.com/kalbasit/ncps/pkg/nar"
+)
+
+const (
+	// Results of the uploads recorded by ncps_nar_upload_dedup_total.
+	uploadDedupDeduplicated = "deduplicated"
+	uploadDedupMismatch     = "mismatch"
+)
+
+// storedNarFile returns the stored nar_file recorded with the FileHash of the
+// upload of narURL, and that FileHash. The FileHash of the upload is the one
+// its narinfos record; the nar_file is looked up by the FileHash of the
+// narinfos linked to it. It returns nil when there is none, the upload then
+// being written as usual.
+func (c *Cache) storedNarFile(ctx context.Context, narURL nar.URL) (*ent.NarFile, *nixhash.HashWithEncoding) {
+	ni, err := c.dbClient.Ent().NarInfo.Query().
+		Where(
+			entnarinfo.URL(narURL.String()),
+			entnarinfo.FileHashNotNil(),
+		).
+		First(ctx)
+	if err != nil {
+		if !database.IsNotFoundError(err) {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to look up the FileHash of the uploaded nar")
+		}
+
+		return nil, nil
+	}
+
+	fileHash, err := nixhash.ParseAny(*ni.FileHash, nil)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("file_hash", *ni.FileHash).Msg("failed to parse the FileHash of the uploaded nar")
+
+		return nil, nil
+	}
+
+	if normalized, err := narURL.Normalize(); err == nil {
+		narURL = normalized
+	}
+
+	// The stored bytes are keyed by the NAR URL, so only a nar_file of the
+	// upload's hash and query can stand for it. Its compression is not matched,
+	// as in narFileBytesStored: a CDC-stored NAR is recorded uncompressed while
+	// the FileHash of its narinfos is that of the compressed upload.
+	nf, err := c.dbClient.Ent().NarFile.Query().
+		Where(
+			entnarfile.HashEQ(narURL.Hash),
+			entnarfile.QueryEQ(narURL.CanonicalQuery()),
+			entnarfile.Or(
+				entnarfile.BytesStoredAtNotNil(),
+				entnarfile.TotalChunksGT(0),
+			),
+			entnarfile.HasNarInfoNarFilesWith(
+				entnarinfonarfile.HasNarinfoWith(
+					entnarinfo.FileHashEQ(*ni.FileHash),
+					entnarinfo.CompressionEQ(narURL.Compression.String()),
+				),
+			),
+		).
+		First(ctx)
+	if err != nil {
+		if !database.IsNotFoundError(err) {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to look up the stored nar by FileHash")
+		}
+
+		return nil, nil
+	}
+
+	return nf, fileHash
+}
+
+// putStoredNar handles the upload of a NAR already stored as nf. When the
+// stored bytes are present, the upload is hashed, and spooled to a temp file,
+// instead of being written and, when it matches fileHash, only the narinfos of
+// the upload are linked to nf. An upload not matching fileHash, or of a NAR
+// whose bytes are gone, is written as usual.
+func (c *Cache) putStoredNar(
+	ctx context.Context,
+	narURL nar.URL,
+	nf *ent.NarFile,
+	fileHash *nixhash.HashWithEncoding,
+	r io.Reader,
+) error {
+	servable, err := c.IsNarServable(ctx, narURL)
+	if err != nil || !servable {
+		zerolog.Ctx(ctx).Debug().Err(err).Msg("the stored nar is not servable, writing the upload")
+
+		return c.writeUploadedNar(ctx, narURL, r)
+	}
+
+	f, err := os.CreateTemp(c.tempDir, fmt.Sprintf("%s-*.nar", filepath.Base(narURL.Hash)))
+	if err != nil {
+		return fmt.Errorf("failed to create temp file for the uploaded nar: %w", err)
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := fileHash.Algo().Func().New()
+
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return fmt.Errorf("error hashing the uploaded nar: %w", err)
+	}
+
+	if !bytes.Equal(h.Sum(nil), fileHash.Digest()) {
+		narUploadDedupTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("result", uploadDedupMismatch)))
+
+		zerolog.Ctx(ctx).
+			Warn().
+			Str("file_hash", fileHash.Format(nixhash.NixBase32, true)).
+			Msg("the uploaded nar does not match the FileHash of the stored nar, writing it")
+
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("error rewinding the uploaded nar: %w", err)
+		}
+
+		return c.writeUploadedNar(ctx, narURL, f)
+	}
+
+	narUploadDedupTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("result", uploadDedupDeduplicated)))
+
+	zerolog.Ctx(ctx).Debug().Int64("size", size).Msg("nar already stored, skipped writing the upload")
+
+	if err := c.linkNarInfosToNarFile(ctx, narURL, nf); err != nil {
+		return err
+	}
+
+	if err := c.checkAndFixNarInfosForNar(context.WithoutCancel(ctx), narURL); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to fix narinfos after PutNar")
+	}
+
+	return nil
+}
+
+// linkNarInfosToNarFile links the narinfos of narURL to nf.
+func (c *Cache) linkNarInfosToNarFile(ctx context.Context, narURL nar.URL, nf *ent.NarFile) error {
+	nis, err := c.dbClient.Ent().NarInfo.Query().
+		Where(entnarinfo.URL(narURL.String())).
+		All(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get the narinfos of the uploaded nar: %w", err)
+	}
+
+	var errs []error
+
+	for _, ni := range nis {
+		if err := c.dbClient.Ent().NarInfoNarFile.Create().
+			SetNarinfoID(ni.ID).
+			SetNarFileID(nf.ID).
+			OnConflictColumns(entnarinfonarfile.FieldNarinfoID, entnarinfonarfile.FieldNarFileID).
+			Ignore().
+			Exec(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("link narinfo %s to the stored nar: %w", ni.Hash, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
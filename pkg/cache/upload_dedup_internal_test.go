@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	entnarinfonarfile "github.com/kalbasit/ncps/ent/narinfonarfile"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestPutStoredNar(t *testing.T) {
+	t.Parallel()
+
+	c, dbClient, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	narURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: testdata.Nar1.NarCompression}
+
+	require.NoError(t, c.PutNar(newContext(), narURL, io.NopCloser(strings.NewReader(testdata.Nar1.NarText))))
+
+	nf, _ := c.storedNarFile(newContext(), narURL)
+	assert.Nil(t, nf, "no narinfo records the FileHash yet")
+
+	// The FileHash of the narinfo of testdata is not the hash of its NAR text.
+	digest := sha256.Sum256([]byte(testdata.Nar1.NarText))
+	wantFileHash := nixhash.MustNewHashWithEncoding(nixhash.SHA256, digest[:], nixhash.NixBase32, true).String()
+	narInfoText := strings.Replace(testdata.Nar1.NarInfoText,
+		"FileHash: sha256:"+testdata.Nar1.NarHash, "FileHash: "+wantFileHash, 1)
+
+	require.NoError(t, c.PutNarInfo(newContext(), testdata.Nar1.NarInfoHash,
+		io.NopCloser(strings.NewReader(narInfoText))))
+
+	nf, fileHash := c.storedNarFile(newContext(), narURL)
+	require.NotNil(t, nf)
+	assert.Equal(t, wantFileHash, fileHash.String())
+
+	readNar := func(t *testing.T) string {
+		t.Helper()
+
+		_, _, r, err := c.GetNar(newContext(), narURL)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		body, err := io.ReadAll(r)
+		require.NoError(t, err)
+
+		return string(body)
+	}
+
+	t.Run("the same nar is deduplicated", func(t *testing.T) {
+		require.NoError(t, c.PutNar(newContext(), narURL, io.NopCloser(strings.NewReader(testdata.Nar1.NarText))))
+
+		linked, err := dbClient.Ent().NarInfoNarFile.Query().
+			Where(entnarinfonarfile.NarFileID(nf.ID)).
+			Exist(newContext())
+		require.NoError(t, err)
+		assert.True(t, linked, "the narinfo is linked to the stored nar")
+	})
+
+	t.Run("a different nar is written as usual", func(t *testing.T) {
+		require.NoError(t, c.PutNar(newContext(), narURL, io.NopCloser(strings.NewReader("not the nar"))))
+
+		assert.Equal(t, testdata.Nar1.NarText, readNar(t), "the stored nar is kept")
+	})
+
+	t.Run("a nar whose bytes are gone is written", func(t *testing.T) {
+		require.NoError(t, c.narStore.DeleteNar(newContext(), narURL))
+		assert.False(t, c.HasNarInStore(newContext(), narURL))
+
+		require.NoError(t, c.PutNar(newContext(), narURL, io.NopCloser(strings.NewReader(testdata.Nar1.NarText))))
+
+		assert.Equal(t, testdata.Nar1.NarText, readNar(t))
+	})
+}
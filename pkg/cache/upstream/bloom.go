@@ -0,0 +1,144 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/kalbasit/ncps/pkg/bloom"
+	"github.com/kalbasit/ncps/pkg/zstd"
+)
+
+const (
+	// narInfoBloomPath is where a peer ncps serves the bloom filter of the
+	// narinfos it holds.
+	narInfoBloomPath = "/narinfo-bloom.zst"
+
+	// maxNarInfoBloomSize bounds the decompressed bloom filter read from an
+	// upstream, about 100 million narinfos at a 1% false positive rate.
+	maxNarInfoBloomSize = 128 << 20
+
+	// Results of the narinfo lookups recorded by
+	// ncps_upstream_narinfo_bloom_checks_total.
+	narInfoBloomSkipped = "skipped"
+	narInfoBloomPassed  = "passed"
+)
+
+//nolint:gochecknoglobals
+var narInfoBloomChecksTotal metric.Int64Counter
+
+//nolint:gochecknoinits
+func init() {
+	var err error
+
+	narInfoBloomChecksTotal, err = otel.Meter(otelPackageName).Int64Counter(
+		"ncps_upstream_narinfo_bloom_checks_total",
+		metric.WithDescription("Counts the narinfo lookups checked against the bloom filter of an upstream "+
+			"by result: skipped (ruled out, not sent) or passed (sent)."),
+		metric.WithUnit("{lookup}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// narInfoBloom is the bloom filter of the narinfos an upstream holds, as last
+// fetched.
+type narInfoBloom struct {
+	filter *bloom.Filter
+	etag   string
+}
+
+// RefreshNarInfoBloom fetches the bloom filter of the narinfos the upstream
+// holds, served by a peer ncps with a narinfo index. The narinfos it rules out
+// are no longer asked for. An upstream not serving one is asked for every
+// narinfo; on error the filter last fetched is kept. It reports whether the
+// upstream serves a filter.
+func (c *Cache) RefreshNarInfoBloom(ctx context.Context) (bool, error) {
+	current := c.narInfoBloom.Load()
+
+	resp, err := c.doRequest(ctx, http.MethodGet, c.url.JoinPath(narInfoBloomPath).String(),
+		func(r *http.Request) {
+			if current != nil {
+				r.Header.Set("If-None-Match", current.etag)
+			}
+		})
+	if err != nil {
+		return current != nil, err
+	}
+
+	defer func() {
+		//nolint:errcheck
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return current != nil, nil
+	case http.StatusOK:
+	case http.StatusNotFound:
+		c.narInfoBloom.Store(nil)
+
+		return false, nil
+	default:
+		return current != nil, fmt.Errorf("%w: %d", ErrUnexpectedHTTPStatusCode, resp.StatusCode)
+	}
+
+	zr, err := zstd.NewPooledReader(resp.Body)
+	if err != nil {
+		return current != nil, fmt.Errorf("error decompressing the narinfo bloom filter: %w", err)
+	}
+
+	defer zr.Close()
+
+	data, err := io.ReadAll(io.LimitReader(zr, maxNarInfoBloomSize+1))
+	if err != nil {
+		return current != nil, fmt.Errorf("error reading the narinfo bloom filter: %w", err)
+	}
+
+	if len(data) > maxNarInfoBloomSize {
+		return current != nil, fmt.Errorf("%w: larger than %d bytes", bloom.ErrInvalidFilter, maxNarInfoBloomSize)
+	}
+
+	filter := &bloom.Filter{}
+	if err := filter.UnmarshalBinary(data); err != nil {
+		return current != nil, err
+	}
+
+	c.narInfoBloom.Store(&narInfoBloom{filter: filter, etag: resp.Header.Get("ETag")})
+
+	zerolog.Ctx(ctx).
+		Debug().
+		Str("upstream_url", c.url.String()).
+		Uint64("bits", filter.Bits()).
+		Msg("fetched the narinfo bloom filter of the upstream")
+
+	return true, nil
+}
+
+// narInfoRuledOut reports whether the bloom filter of the upstream rules the
+// narinfo of hash out, so it is not asked for. The filter is as old as the
+// last index build of the upstream: a narinfo cached since is ruled out too.
+func (c *Cache) narInfoRuledOut(ctx context.Context, hash string) bool {
+	b := c.narInfoBloom.Load()
+	if b == nil {
+		return false
+	}
+
+	if b.filter.Test(hash) {
+		narInfoBloomChecksTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("result", narInfoBloomPassed)))
+
+		return false
+	}
+
+	narInfoBloomChecksTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("result", narInfoBloomSkipped)))
+
+	return true
+}
@@ -0,0 +1,106 @@
+package upstream_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/bloom"
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/zstd"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestRefreshNarInfoBloom(t *testing.T) {
+	t.Parallel()
+
+	filter := bloom.New(1, 0.01)
+	filter.Add(testdata.Nar1.NarInfoHash)
+
+	encoded, err := filter.MarshalBinary()
+	require.NoError(t, err)
+
+	var compressed bytes.Buffer
+
+	zw := zstd.NewPooledWriter(&compressed)
+	_, err = zw.Write(encoded)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	var (
+		serveBloom      atomic.Bool
+		narInfoRequests atomic.Int32
+		ifNoneMatchHit  atomic.Bool
+	)
+
+	serveBloom.Store(true)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/narinfo-bloom.zst" && serveBloom.Load():
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				ifNoneMatchHit.Store(true)
+				w.WriteHeader(http.StatusNotModified)
+
+				return
+			}
+
+			w.Header().Set("ETag", `"v1"`)
+			_, _ = w.Write(compressed.Bytes())
+		case strings.HasSuffix(r.URL.Path, ".narinfo"):
+			narInfoRequests.Add(1)
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := upstream.New(context.Background(), testhelper.MustParseURL(t, ts.URL), nil)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	_, err = c.HasNarInfo(ctx, testdata.Nar2.NarInfoHash)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), narInfoRequests.Load(), "every narinfo is asked for without a filter")
+
+	served, err := c.RefreshNarInfoBloom(ctx)
+	require.NoError(t, err)
+	assert.True(t, served)
+
+	ok, err := c.HasNarInfo(ctx, testdata.Nar2.NarInfoHash)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = c.GetNarInfo(ctx, testdata.Nar2.NarInfoHash)
+	require.ErrorIs(t, err, upstream.ErrNotFound)
+	assert.Equal(t, int32(1), narInfoRequests.Load(), "the narinfos ruled out are not asked for")
+
+	_, err = c.HasNarInfo(ctx, testdata.Nar1.NarInfoHash)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), narInfoRequests.Load(), "the narinfos the filter holds are asked for")
+
+	served, err = c.RefreshNarInfoBloom(ctx)
+	require.NoError(t, err)
+	assert.True(t, served)
+	assert.True(t, ifNoneMatchHit.Load(), "an unchanged filter is not fetched again")
+
+	serveBloom.Store(false)
+
+	served, err = c.RefreshNarInfoBloom(ctx)
+	require.NoError(t, err)
+	assert.False(t, served)
+
+	_, err = c.HasNarInfo(ctx, testdata.Nar2.NarInfoHash)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), narInfoRequests.Load(), "the filter is dropped once the upstream stops serving it")
+}
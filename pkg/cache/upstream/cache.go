@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nix-community/go-nix/pkg/narinfo"
@@ -21,6 +22,8 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 
+	"github.com/kalbasit/ncps/pkg/cache/upstream/recorder"
+	"github.com/kalbasit/ncps/pkg/faultinject"
 	"github.com/kalbasit/ncps/pkg/helper"
 	"github.com/kalbasit/ncps/pkg/nar"
 	"github.com/kalbasit/ncps/pkg/nixcacheinfo"
@@ -39,6 +42,9 @@ const (
 	// hammering an upstream that is brown-out failing.
 	defaultRetryBackoff    = 100 * time.Millisecond
 	defaultRetryBackoffCap = 2 * time.Second
+
+	// maxPublicKeySize bounds the response read by FetchPublicKey.
+	maxPublicKeySize = 4 << 10
 )
 
 var (
@@ -83,14 +89,34 @@ type Cache struct {
 	publicKeys []signature.PublicKey
 	netrcAuth  *NetrcCredentials
 
+	userAgent       string
+	header          http.Header
+	forwardClientIP bool
+
 	mu        sync.RWMutex
 	isHealthy bool
 
 	dialerTimeout         time.Duration
 	responseHeaderTimeout time.Duration
+	dnsCache              *DNSCache
+
+	retryBackoff       time.Duration
+	retryBackoffCap    time.Duration
+	retryAttempts      int
+	retryPerTryTimeout time.Duration
+	retryBudget        *retryBudget
 
-	retryBackoff    time.Duration
-	retryBackoffCap time.Duration
+	// narInfoProbeLatency tracks the latency of the narinfo HEAD probes, used
+	// to hedge them. See NarInfoProbeP95.
+	narInfoProbeLatency latencyWindow
+
+	// narInfoURLPolicy is applied to the narinfos whose URL is not relative
+	// to the upstream.
+	narInfoURLPolicy NarInfoURLPolicy
+
+	// narInfoBloom is the bloom filter of the narinfos the upstream holds. See
+	// RefreshNarInfoBloom.
+	narInfoBloom atomic.Pointer[narInfoBloom]
 }
 
 // NetrcCredentials holds authentication credentials.
@@ -109,6 +135,20 @@ type Options struct {
 	// If nil, no authentication will be used.
 	NetrcCredentials *NetrcCredentials
 
+	// UserAgent is the User-Agent of the requests to the upstream cache.
+	// If empty, the default User-Agent of Go is used.
+	UserAgent string
+
+	// Header holds the headers set on every request to the upstream cache,
+	// such as the headers required by an egress proxy. They take precedence
+	// over NetrcCredentials.
+	Header http.Header
+
+	// ForwardClientIP sends the address of the client on whose behalf a
+	// request is made, carried by the context (see WithClientIP), as
+	// X-Forwarded-For.
+	ForwardClientIP bool
+
 	// DialerTimeout is the timeout for establishing a TCP connection.
 	// If zero, defaults to defaultHTTPTimeout (3s).
 	DialerTimeout time.Duration
@@ -117,14 +157,50 @@ type Options struct {
 	// If zero, defaults to defaultHTTPTimeout (3s).
 	ResponseHeaderTimeout time.Duration
 
+	// DNSCache, if set, caches the addresses of the upstream host for the
+	// connections of the default transport. It is ignored with Transport.
+	DNSCache *DNSCache
+
 	// Transport is the HTTP transport to use.
 	// If nil, a default transport will be created.
 	Transport http.RoundTripper
 
 	// RetryBackoff is the base delay before the first transient-error retry on
-	// idempotent requests; it doubles per attempt up to an internal cap. If zero,
+	// idempotent requests; it doubles per attempt up to RetryMaxBackoff. If zero,
 	// defaults to defaultRetryBackoff. Set a small value in tests to keep them fast.
 	RetryBackoff time.Duration
+
+	// RetryMaxBackoff caps the retry backoff.
+	// If zero, defaults to defaultRetryBackoffCap (2s).
+	RetryMaxBackoff time.Duration
+
+	// RetryAttempts is the maximum number of attempts of an idempotent request,
+	// the first one included; 1 disables retries.
+	// If zero, defaults to defaultHTTPRetries (3).
+	RetryAttempts int
+
+	// RetryPerTryTimeout bounds each attempt until its response headers arrive;
+	// an attempt that exceeds it is retried. If zero, attempts are only bounded
+	// by the dialer and response header timeouts.
+	RetryPerTryTimeout time.Duration
+
+	// RetryBudget caps the retries to this fraction of the requests (e.g. 0.2
+	// for one retry per five requests) after an initial burst, so retries do
+	// not multiply the load on a failing upstream. If zero, retries are not
+	// budgeted.
+	RetryBudget float64
+
+	// Recorder, if set, records the requests to the upstream and their
+	// responses.
+	Recorder *recorder.Recorder
+
+	// Faults, if set, injects faults into the requests to the upstream. The
+	// failed requests are recorded by Recorder.
+	Faults *faultinject.Injector
+
+	// NarInfoURLPolicy is applied to the narinfos whose URL is not relative to
+	// the upstream. If empty, defaults to NarInfoURLPolicyNormalize.
+	NarInfoURLPolicy NarInfoURLPolicy
 }
 
 // New creates a new upstream cache with the given URL and options.
@@ -155,12 +231,30 @@ func New(ctx context.Context, u *url.URL, opts *Options) (*Cache, error) {
 		retryBackoff = opts.RetryBackoff
 	}
 
+	retryBackoffCap := defaultRetryBackoffCap
+	if opts.RetryMaxBackoff > 0 {
+		retryBackoffCap = opts.RetryMaxBackoff
+	}
+
+	retryAttempts := defaultHTTPRetries
+	if opts.RetryAttempts > 0 {
+		retryAttempts = opts.RetryAttempts
+	}
+
 	c := &Cache{
 		url:                   u,
 		dialerTimeout:         dialerTimeout,
 		responseHeaderTimeout: responseHeaderTimeout,
+		dnsCache:              opts.DNSCache,
 		retryBackoff:          retryBackoff,
-		retryBackoffCap:       defaultRetryBackoffCap,
+		retryBackoffCap:       retryBackoffCap,
+		retryAttempts:         retryAttempts,
+		retryPerTryTimeout:    opts.RetryPerTryTimeout,
+		retryBudget:           newRetryBudget(opts.RetryBudget),
+		userAgent:             opts.UserAgent,
+		header:                opts.Header.Clone(),
+		forwardClientIP:       opts.ForwardClientIP,
+		narInfoURLPolicy:      opts.NarInfoURLPolicy,
 		httpClient: &http.Client{
 			Transport: opts.Transport,
 		},
@@ -176,10 +270,23 @@ func New(ctx context.Context, u *url.URL, opts *Options) (*Cache, error) {
 			Msg("loaded netrc authentication credentials")
 	}
 
+	// A local directory is read through a transport serving its files.
+	if IsFileURL(u) {
+		c.httpClient.Transport = newFileTransport(u)
+	}
+
 	if err := c.setupHTTPClient(); err != nil {
 		return nil, err
 	}
 
+	if opts.Faults != nil {
+		c.httpClient.Transport = opts.Faults.Transport(c.httpClient.Transport)
+	}
+
+	if opts.Recorder != nil {
+		c.httpClient.Transport = opts.Recorder.Wrap(c.httpClient.Transport)
+	}
+
 	zerolog.Ctx(ctx).
 		Debug().
 		Str("upstream_url", c.url.String()).
@@ -236,6 +343,9 @@ func (c *Cache) setupHTTPClient() error {
 
 	// configure dialer with tighter timeout
 	dt.DialContext = dialer.DialContext
+	if c.dnsCache != nil {
+		dt.DialContext = c.dnsCache.DialContext(dialer)
+	}
 
 	// Set timeout to first byte
 	dt.ResponseHeaderTimeout = c.responseHeaderTimeout
@@ -272,13 +382,76 @@ func (c *Cache) PublicKeys() []signature.PublicKey {
 	return c.publicKeys
 }
 
+// AddPublicKey trusts pk for the signatures of the narinfos of the upstream.
+// It must be called before the upstream serves any request.
+func (c *Cache) AddPublicKey(pk signature.PublicKey) {
+	c.publicKeys = append(c.publicKeys, pk)
+}
+
+// FetchPublicKey downloads the public key the upstream signs its narinfos
+// with from keyURL or, when keyURL is empty, from /pubkey next to its
+// nix-cache-info, as served by ncps. The upstream credentials are only sent
+// to the upstream host.
+func (c *Cache) FetchPublicKey(ctx context.Context, keyURL string) (signature.PublicKey, error) {
+	u := c.url.JoinPath("/pubkey")
+
+	if keyURL != "" {
+		var err error
+
+		u, err = url.Parse(keyURL)
+		if err != nil {
+			return signature.PublicKey{}, fmt.Errorf("error parsing the public key URL %q: %w", keyURL, err)
+		}
+	}
+
+	resp, err := c.doRequest(ctx, http.MethodGet, u.String(), func(r *http.Request) {
+		if r.URL.Host != c.url.Host {
+			r.Header.Del("Authorization")
+		}
+	})
+	if err != nil {
+		return signature.PublicKey{}, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		//nolint:errcheck
+		io.Copy(io.Discard, resp.Body)
+
+		return signature.PublicKey{}, fmt.Errorf("%w: %d fetching %s", ErrUnexpectedHTTPStatusCode, resp.StatusCode, u)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPublicKeySize))
+	if err != nil {
+		return signature.PublicKey{}, fmt.Errorf("error reading the public key from %s: %w", u, err)
+	}
+
+	pk, err := signature.ParsePublicKey(strings.TrimSpace(string(body)))
+	if err != nil {
+		return signature.PublicKey{}, fmt.Errorf("error parsing the public key from %s: %w", u, err)
+	}
+
+	return pk, nil
+}
+
 // ParsePriority parses the priority from the upstream.
 func (c *Cache) ParsePriority(ctx context.Context) (uint64, error) {
 	return c.parsePriority(ctx)
 }
 
-// GetHostname returns the hostname.
-func (c *Cache) GetHostname() string { return c.url.Hostname() }
+// GetHostname returns the hostname, or the path of the directory of a file://
+// upstream.
+func (c *Cache) GetHostname() string {
+	if IsFileURL(c.url) {
+		return c.url.Path
+	}
+
+	return c.url.Hostname()
+}
+
+// GetURL returns the URL of the upstream cache.
+func (c *Cache) GetURL() string { return c.url.String() }
 
 // isRetriableTransportError reports whether err is a transient transport failure
 // that should be retried for idempotent (GET/HEAD) requests. These are
@@ -308,27 +481,16 @@ func isRetriableTransportError(err error) bool {
 	return false
 }
 
-// waitRetryBackoff sleeps for the capped exponential backoff for the given
-// zero-based attempt before the next retry, returning early with the context
-// error if the context is cancelled during the wait. A non-positive base
-// disables the delay but still honours cancellation.
+// waitRetryBackoff sleeps for the capped, jittered exponential backoff for
+// the given zero-based attempt before the next retry, returning early with the
+// context error if the context is cancelled during the wait. A non-positive
+// base disables the delay but still honours cancellation.
 func (c *Cache) waitRetryBackoff(ctx context.Context, attempt int) error {
-	base := c.retryBackoff
-	if base <= 0 {
+	if c.retryBackoff <= 0 {
 		return ctx.Err()
 	}
 
-	// base * 2^attempt, capped (and guarded against shift overflow).
-	delay := base
-	for n := 0; n < attempt && delay < c.retryBackoffCap; n++ {
-		delay *= 2
-	}
-
-	if delay > c.retryBackoffCap {
-		delay = c.retryBackoffCap
-	}
-
-	timer := time.NewTimer(delay)
+	timer := time.NewTimer(c.retryDelay(attempt))
 	defer timer.Stop()
 
 	select {
@@ -340,6 +502,9 @@ func (c *Cache) waitRetryBackoff(ctx context.Context, attempt int) error {
 }
 
 // doRequest creates and executes an HTTP request with authentication.
+// Idempotent requests that fail with a retriable error or status are retried
+// with backoff, up to the configured attempts and within the retry budget; the
+// response of the last attempt is returned as is.
 // The caller is responsible for closing the response body.
 func (c *Cache) doRequest(
 	ctx context.Context,
@@ -351,7 +516,11 @@ func (c *Cache) doRequest(
 		err  error
 	)
 
-	for i := range defaultHTTPRetries {
+	idempotent := method == http.MethodGet || method == http.MethodHead
+
+	c.retryBudget.earn()
+
+	for i := range c.retryAttempts {
 		var r *http.Request
 
 		r, err = http.NewRequestWithContext(ctx, method, url, nil)
@@ -360,41 +529,73 @@ func (c *Cache) doRequest(
 		}
 
 		c.addAuthToRequest(r)
+		c.addHeadersToRequest(r)
 
 		for _, mutator := range mutators {
 			mutator(r)
 		}
 
-		resp, err = c.httpClient.Do(r)
-		if err == nil {
-			return resp, nil
+		attemptsLeft := c.retryAttempts - i
+		if !idempotent {
+			attemptsLeft = 1
+		}
+
+		resp, err = c.send(r, c.attemptTimeout(ctx, attemptsLeft))
+
+		// Only idempotent requests that failed with a transient error or status are
+		// retried; everything else, 4xx included, is returned immediately.
+		var retriable bool
+		if err != nil {
+			retriable = isRetriableError(err)
+		} else {
+			retriable = isRetriableStatus(resp.StatusCode)
 		}
 
-		// Only idempotent requests that failed with a transient transport error are
-		// retried; everything else fails immediately.
-		retriable := (method == http.MethodGet || method == http.MethodHead) &&
-			isRetriableTransportError(err)
-		if !retriable {
-			return nil, fmt.Errorf("error performing %s request to %s: %w", method, url, err)
+		// No retry follows the final attempt, so it is returned without a backoff
+		// that would only add latency.
+		if !idempotent || !retriable || i == c.retryAttempts-1 {
+			break
 		}
 
-		zerolog.Ctx(ctx).Warn().
-			Err(err).
-			Int("attempt", i+1).
-			Int("max_retries", defaultHTTPRetries).
-			Msg("transient transport error from upstream, retrying request")
+		if !c.retryBudget.spend() {
+			zerolog.Ctx(ctx).Warn().
+				Err(err).
+				Int("attempt", i+1).
+				Msg("upstream retry budget exhausted, not retrying request")
+
+			break
+		}
+
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().
+				Err(err).
+				Int("attempt", i+1).
+				Int("max_retries", c.retryAttempts).
+				Msg("transient transport error from upstream, retrying request")
+		} else {
+			zerolog.Ctx(ctx).Warn().
+				Int("status_code", resp.StatusCode).
+				Int("attempt", i+1).
+				Int("max_retries", c.retryAttempts).
+				Msg("transient status from upstream, retrying request")
+
+			//nolint:errcheck
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
 
 		// Back off before retrying so a brown-out upstream is not hammered, aborting
-		// promptly if the context is cancelled. Skip the wait on the final attempt —
-		// no retry follows it, so a backoff would only add latency.
-		if i < defaultHTTPRetries-1 {
-			if waitErr := c.waitRetryBackoff(ctx, i); waitErr != nil {
-				return nil, waitErr
-			}
+		// promptly if the context is cancelled.
+		if waitErr := c.waitRetryBackoff(ctx, i); waitErr != nil {
+			return nil, waitErr
 		}
 	}
 
-	return nil, fmt.Errorf("error performing %s request to %s: %w", method, url, err)
+	if err != nil {
+		return nil, fmt.Errorf("error performing %s request to %s: %w", method, url, err)
+	}
+
+	return resp, nil
 }
 
 // GetNarInfo returns a parsed NarInfo from the cache server.
@@ -421,6 +622,14 @@ func (c *Cache) GetNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo,
 		Logger().
 		WithContext(ctx)
 
+	if c.narInfoRuledOut(ctx, hash) {
+		zerolog.Ctx(ctx).
+			Debug().
+			Msg("the bloom filter of the upstream rules the narinfo out")
+
+		return nil, ErrNotFound
+	}
+
 	zerolog.Ctx(ctx).
 		Info().
 		Msg("download the narinfo from upstream")
@@ -471,6 +680,12 @@ func (c *Cache) GetNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo,
 		}
 	}
 
+	// The URL is not signed: a narinfo pointing its NAR to another host is
+	// brought back to the upstream, or rejected.
+	if ni.URL, err = c.checkNarInfoURL(ctx, ni.URL); err != nil {
+		return nil, err
+	}
+
 	// Some upstreams (niks3, nix-serve) omit the optional FileHash/FileSize on
 	// compressed NARs (issue #1314). For Compression:none we can safely fall
 	// back to NarSize. For compressed NARs we leave FileSize/FileHash unset
@@ -508,10 +723,20 @@ func (c *Cache) HasNarInfo(ctx context.Context, hash string) (bool, error) {
 		Logger().
 		WithContext(ctx)
 
+	if c.narInfoRuledOut(ctx, hash) {
+		zerolog.Ctx(ctx).
+			Debug().
+			Msg("the bloom filter of the upstream rules the narinfo out")
+
+		return false, nil
+	}
+
 	zerolog.Ctx(ctx).
 		Info().
 		Msg("heading the narinfo from upstream")
 
+	start := time.Now()
+
 	resp, err := c.doRequest(ctx, http.MethodHead, u)
 	if err != nil {
 		if isTimeout(err) {
@@ -521,6 +746,8 @@ func (c *Cache) HasNarInfo(ctx context.Context, hash string) (bool, error) {
 		return false, err
 	}
 
+	c.narInfoProbeLatency.record(time.Since(start))
+
 	defer func() {
 		//nolint:errcheck
 		io.Copy(io.Discard, resp.Body)
@@ -739,6 +966,7 @@ func (c *Cache) parsePriority(ctx context.Context) (uint64, error) {
 	}
 
 	c.addAuthToRequest(r)
+	c.addHeadersToRequest(r)
 
 	resp, err := c.httpClient.Do(r)
 	if err != nil {
@@ -781,6 +1009,10 @@ func (c *Cache) validateURL(u *url.URL) error {
 		return ErrURLMustContainScheme
 	}
 
+	if IsFileURL(u) {
+		return validateFileURL(u)
+	}
+
 	return nil
 }
 
@@ -788,5 +1020,7 @@ func (c *Cache) validateURL(u *url.URL) error {
 func isTimeout(err error) bool {
 	var netErr net.Error
 
-	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, errAttemptTimeout) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
 }
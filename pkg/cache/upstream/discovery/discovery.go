@@ -0,0 +1,221 @@
+// Package discovery resolves the set of upstream caches at runtime, from DNS
+// SRV records or from an HTTP endpoint serving JSON, so a fleet can rotate its
+// upstreams without redeploying ncps.
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SchemeDNSSRV is the URL scheme selecting DNS SRV discovery, e.g.
+// dns+srv://_nix-cache._tcp.example.com?scheme=https.
+const SchemeDNSSRV = "dns+srv"
+
+// maxResponseSize caps the size of an HTTP discovery response.
+const maxResponseSize = 1 << 20
+
+var (
+	// ErrUnsupportedScheme is returned by NewSource for a discovery URL whose
+	// scheme is neither dns+srv, http nor https.
+	ErrUnsupportedScheme = errors.New("unsupported upstream discovery scheme")
+
+	// ErrUnexpectedHTTPStatusCode is returned when the discovery endpoint does not
+	// answer with 200 OK.
+	ErrUnexpectedHTTPStatusCode = errors.New("unexpected HTTP status code")
+)
+
+// Upstream is an upstream cache returned by a Source.
+type Upstream struct {
+	// URL is the URL of the upstream cache.
+	URL *url.URL
+
+	// PublicKeys are the nix-format public keys trusted for this upstream, if
+	// the source provides any.
+	PublicKeys []string
+}
+
+// Source discovers the current set of upstream caches.
+type Source interface {
+	// Discover returns the upstream caches currently advertised by the source.
+	// An error means the set could not be determined; callers must keep the
+	// previously discovered set instead of treating it as empty.
+	Discover(ctx context.Context) ([]Upstream, error)
+
+	// String describes the source for logging.
+	String() string
+}
+
+// NewSource returns the Source described by raw:
+//   - dns+srv://<name>[?scheme=http|https] looks up the SRV records of name and
+//     builds one upstream per target (the scheme defaults to https).
+//   - http(s)://... fetches a JSON document of the form
+//     {"upstreams": [{"url": "https://...", "public_keys": ["name-1:base64"]}]}.
+func NewSource(raw string) (Source, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the upstream discovery URL %q: %w", raw, err)
+	}
+
+	switch u.Scheme {
+	case SchemeDNSSRV:
+		scheme := u.Query().Get("scheme")
+		if scheme == "" {
+			scheme = "https"
+		}
+
+		if scheme != "http" && scheme != "https" {
+			return nil, fmt.Errorf("%w: upstream scheme %q", ErrUnsupportedScheme, scheme)
+		}
+
+		return NewDNSSRV(u.Host, scheme), nil
+	case "http", "https":
+		return NewHTTPJSON(raw, nil), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedScheme, u.Scheme)
+	}
+}
+
+// srvResolver is the subset of *net.Resolver used by DNS SRV discovery.
+type srvResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+type dnsSRV struct {
+	name     string
+	scheme   string
+	resolver srvResolver
+}
+
+// NewDNSSRV returns a Source resolving the SRV records of name (e.g.
+// _nix-cache._tcp.example.com) into upstream URLs using scheme.
+func NewDNSSRV(name, scheme string) Source {
+	return &dnsSRV{name: name, scheme: scheme, resolver: net.DefaultResolver}
+}
+
+func (d *dnsSRV) String() string { return SchemeDNSSRV + "://" + d.name }
+
+func (d *dnsSRV) Discover(ctx context.Context) ([]Upstream, error) {
+	_, records, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up the SRV records of %q: %w", d.name, err)
+	}
+
+	ups := make([]Upstream, 0, len(records))
+
+	for _, r := range records {
+		host := strings.TrimSuffix(r.Target, ".")
+		if host == "" {
+			continue
+		}
+
+		ups = append(ups, Upstream{
+			URL: &url.URL{
+				Scheme: d.scheme,
+				Host:   net.JoinHostPort(host, strconv.Itoa(int(r.Port))),
+			},
+		})
+	}
+
+	return ups, nil
+}
+
+type httpJSON struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewHTTPJSON returns a Source fetching the upstream list from endpoint. A nil
+// client uses http.DefaultClient.
+func NewHTTPJSON(endpoint string, client *http.Client) Source {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &httpJSON{endpoint: endpoint, client: client}
+}
+
+func (h *httpJSON) String() string { return h.endpoint }
+
+type httpJSONDocument struct {
+	Upstreams []struct {
+		URL        string   `json:"url"`
+		PublicKeys []string `json:"public_keys"`
+	} `json:"upstreams"`
+}
+
+func (h *httpJSON) Discover(ctx context.Context) ([]Upstream, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the discovery request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %q: %w", h.endpoint, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %d from %q", ErrUnexpectedHTTPStatusCode, resp.StatusCode, h.endpoint)
+	}
+
+	var doc httpJSONDocument
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("error decoding the discovery document from %q: %w", h.endpoint, err)
+	}
+
+	ups := make([]Upstream, 0, len(doc.Upstreams))
+
+	for _, du := range doc.Upstreams {
+		u, err := url.Parse(du.URL)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the discovered upstream URL %q: %w", du.URL, err)
+		}
+
+		ups = append(ups, Upstream{URL: u, PublicKeys: du.PublicKeys})
+	}
+
+	return ups, nil
+}
+
+type multi []Source
+
+// Multi returns a Source concatenating the upstreams of all sources. It fails
+// if any of them fails so a transient error never shrinks the discovered set.
+func Multi(sources ...Source) Source { return multi(sources) }
+
+func (m multi) String() string {
+	names := make([]string, 0, len(m))
+	for _, s := range m {
+		names = append(names, s.String())
+	}
+
+	return strings.Join(names, ",")
+}
+
+func (m multi) Discover(ctx context.Context) ([]Upstream, error) {
+	var ups []Upstream
+
+	for _, s := range m {
+		sups, err := s.Discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		ups = append(ups, sups...)
+	}
+
+	return ups, nil
+}
@@ -0,0 +1,157 @@
+package discovery_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream/discovery"
+)
+
+var errLookup = errors.New("lookup failed")
+
+func urls(ups []discovery.Upstream) []string {
+	out := make([]string, 0, len(ups))
+	for _, u := range ups {
+		out = append(out, u.URL.String())
+	}
+
+	return out
+}
+
+func TestNewSource(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr error
+	}{
+		{name: "dns srv", raw: "dns+srv://_nix-cache._tcp.example.com", want: "dns+srv://_nix-cache._tcp.example.com"},
+		{name: "dns srv with scheme", raw: "dns+srv://_nix-cache._tcp.example.com?scheme=http", want: "dns+srv://_nix-cache._tcp.example.com"},
+		{name: "http endpoint", raw: "https://config.example.com/upstreams.json", want: "https://config.example.com/upstreams.json"},
+		{name: "unknown scheme", raw: "ftp://example.com", wantErr: discovery.ErrUnsupportedScheme},
+		{name: "bad srv scheme", raw: "dns+srv://_nix-cache._tcp.example.com?scheme=ftp", wantErr: discovery.ErrUnsupportedScheme},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := discovery.NewSource(tt.raw)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, s.String())
+		})
+	}
+}
+
+func TestDNSSRV(t *testing.T) {
+	t.Parallel()
+
+	t.Run("builds one upstream per target", func(t *testing.T) {
+		t.Parallel()
+
+		s := discovery.NewDNSSRVWithResolver(
+			"_nix-cache._tcp.example.com",
+			"http",
+			func(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+				assert.Empty(t, service)
+				assert.Empty(t, proto)
+				assert.Equal(t, "_nix-cache._tcp.example.com", name)
+
+				return name, []*net.SRV{
+					{Target: "cache-a.example.com.", Port: 8501},
+					{Target: ".", Port: 0},
+					{Target: "cache-b.example.com.", Port: 80},
+				}, nil
+			},
+		)
+
+		ups, err := s.Discover(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"http://cache-a.example.com:8501", "http://cache-b.example.com:80"}, urls(ups))
+	})
+
+	t.Run("propagates lookup errors", func(t *testing.T) {
+		t.Parallel()
+
+		s := discovery.NewDNSSRVWithResolver(
+			"_nix-cache._tcp.example.com",
+			"https",
+			func(context.Context, string, string, string) (string, []*net.SRV, error) {
+				return "", nil, errLookup
+			},
+		)
+
+		_, err := s.Discover(context.Background())
+		require.ErrorIs(t, err, errLookup)
+	})
+}
+
+func TestHTTPJSON(t *testing.T) {
+	t.Parallel()
+
+	t.Run("decodes upstreams and keys", func(t *testing.T) {
+		t.Parallel()
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(`{"upstreams":[
+				{"url":"https://cache.nixos.org","public_keys":["cache.nixos.org-1:6NCHdD59X431o0gWypbMrAURkbJ16ZPMQFGspcDShjY="]},
+				{"url":"http://cache.example.com:8501?priority=10"}
+			]}`))
+		}))
+		t.Cleanup(ts.Close)
+
+		ups, err := discovery.NewHTTPJSON(ts.URL, ts.Client()).Discover(context.Background())
+		require.NoError(t, err)
+		require.Len(t, ups, 2)
+		assert.Equal(t, []string{"https://cache.nixos.org", "http://cache.example.com:8501?priority=10"}, urls(ups))
+		assert.Len(t, ups[0].PublicKeys, 1)
+		assert.Empty(t, ups[1].PublicKeys)
+	})
+
+	t.Run("non-200 is an error", func(t *testing.T) {
+		t.Parallel()
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		t.Cleanup(ts.Close)
+
+		_, err := discovery.NewHTTPJSON(ts.URL, ts.Client()).Discover(context.Background())
+		require.ErrorIs(t, err, discovery.ErrUnexpectedHTTPStatusCode)
+	})
+}
+
+func TestMulti(t *testing.T) {
+	t.Parallel()
+
+	ok := discovery.NewDNSSRVWithResolver("a", "https",
+		func(context.Context, string, string, string) (string, []*net.SRV, error) {
+			return "", []*net.SRV{{Target: "a.example.com.", Port: 443}}, nil
+		})
+	failing := discovery.NewDNSSRVWithResolver("b", "https",
+		func(context.Context, string, string, string) (string, []*net.SRV, error) {
+			return "", nil, errLookup
+		})
+
+	ups, err := discovery.Multi(ok, ok).Discover(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, ups, 2)
+
+	_, err = discovery.Multi(ok, failing).Discover(context.Background())
+	require.ErrorIs(t, err, errLookup)
+}
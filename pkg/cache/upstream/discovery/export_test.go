@@ -0,0 +1,19 @@
+package discovery
+
+import (
+	"context"
+	"net"
+)
+
+// SRVLookupFunc is a test-only adapter turning a function into a resolver.
+type SRVLookupFunc func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+
+// LookupSRV implements the resolver interface used by DNS SRV discovery.
+func (f SRVLookupFunc) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return f(ctx, service, proto, name)
+}
+
+// NewDNSSRVWithResolver is a test-only constructor injecting the SRV resolver.
+func NewDNSSRVWithResolver(name, scheme string, lookup SRVLookupFunc) Source {
+	return &dnsSRV{name: name, scheme: scheme, resolver: lookup}
+}
@@ -0,0 +1,246 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// Results of the lookups recorded by ncps_upstream_dns_lookups_total.
+	dnsLookupHit     = "hit"
+	dnsLookupMiss    = "miss"
+	dnsLookupStale   = "stale"
+	dnsLookupFailure = "failure"
+)
+
+var (
+	// ErrNoAddresses is returned by DNSCache.LookupIPAddr when the resolver
+	// returns no address for a host.
+	ErrNoAddresses = errors.New("no addresses found")
+
+	//nolint:gochecknoglobals
+	dnsLookupsTotal metric.Int64Counter
+)
+
+//nolint:gochecknoinits
+func init() {
+	var err error
+
+	dnsLookupsTotal, err = otel.Meter(otelPackageName).Int64Counter(
+		"ncps_upstream_dns_lookups_total",
+		metric.WithDescription("Counts the DNS lookups of the upstream hosts by result: "+
+			"hit, miss, stale (served after a failure) or failure."),
+		metric.WithUnit("{lookup}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// PrimeMetrics records a zero-valued measurement on every counter instrument in
+// this package so the corresponding time series are exported from startup
+// rather than only appearing after the first real event.
+func PrimeMetrics(ctx context.Context) {
+	for _, result := range []string{dnsLookupHit, dnsLookupMiss, dnsLookupStale, dnsLookupFailure} {
+		dnsLookupsTotal.Add(ctx, 0, metric.WithAttributes(attribute.String("result", result)))
+	}
+
+	for _, result := range []string{narInfoBloomSkipped, narInfoBloomPassed} {
+		narInfoBloomChecksTotal.Add(ctx, 0, metric.WithAttributes(attribute.String("result", result)))
+	}
+
+	primeNarInfoURLMetrics(ctx)
+}
+
+// Resolver resolves the addresses of a host. *net.Resolver implements it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// DNSCacheOptions configures a DNSCache.
+type DNSCacheOptions struct {
+	// TTL is how long the addresses of a host are used before being looked up
+	// again, overriding the TTL of the DNS records.
+	TTL time.Duration
+
+	// MaxStale is how long past their TTL the addresses of a host are still
+	// used when looking them up again fails, to survive short resolver
+	// outages. If zero, expired addresses are never used.
+	MaxStale time.Duration
+
+	// Resolver looks the hosts up. If nil, net.DefaultResolver is used.
+	Resolver Resolver
+}
+
+// DNSCache caches the addresses of the upstream hosts, so the health checks and
+// fetches do not wait on the resolver for every new connection. A DNSCache is
+// shared by the upstream caches through Options.DNSCache.
+type DNSCache struct {
+	ttl      time.Duration
+	maxStale time.Duration
+	resolver Resolver
+
+	mu      sync.Mutex
+	entries map[string]dnsCacheEntry
+	lookups map[string]*dnsLookup
+}
+
+// dnsCacheEntry holds the addresses of a host.
+type dnsCacheEntry struct {
+	addrs     []net.IPAddr
+	expiresAt time.Time
+}
+
+// dnsLookup is a lookup in progress, joined by the concurrent lookups of the
+// same host.
+type dnsLookup struct {
+	done  chan struct{}
+	addrs []net.IPAddr
+	err   error
+}
+
+// NewDNSCache returns a DNSCache configured by opts.
+func NewDNSCache(opts DNSCacheOptions) *DNSCache {
+	resolver := opts.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	return &DNSCache{
+		ttl:      opts.TTL,
+		maxStale: opts.MaxStale,
+		resolver: resolver,
+		entries:  make(map[string]dnsCacheEntry),
+		lookups:  make(map[string]*dnsLookup),
+	}
+}
+
+// LookupIPAddr returns the addresses of host, from the cache while they are
+// fresh. When looking them up fails, the expired addresses are returned while
+// they are within MaxStale.
+func (d *DNSCache) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	now := time.Now()
+
+	d.mu.Lock()
+
+	entry, cached := d.entries[host]
+	if cached && now.Before(entry.expiresAt) {
+		d.mu.Unlock()
+
+		recordDNSLookup(ctx, dnsLookupHit)
+
+		return entry.addrs, nil
+	}
+
+	lookup, joined := d.lookups[host]
+	if !joined {
+		lookup = &dnsLookup{done: make(chan struct{})}
+		d.lookups[host] = lookup
+	}
+
+	d.mu.Unlock()
+
+	if !joined {
+		d.lookup(ctx, host, lookup)
+	}
+
+	select {
+	case <-lookup.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if lookup.err == nil {
+		if !joined {
+			recordDNSLookup(ctx, dnsLookupMiss)
+		}
+
+		return lookup.addrs, nil
+	}
+
+	if cached && d.maxStale > 0 && time.Now().Before(entry.expiresAt.Add(d.maxStale)) {
+		recordDNSLookup(ctx, dnsLookupStale)
+
+		return entry.addrs, nil
+	}
+
+	recordDNSLookup(ctx, dnsLookupFailure)
+
+	return nil, lookup.err
+}
+
+// lookup resolves host into lookup, caching the addresses found.
+func (d *DNSCache) lookup(ctx context.Context, host string, lookup *dnsLookup) {
+	// The lookup is shared by the concurrent callers, so it is not canceled
+	// with the caller starting it.
+	addrs, err := d.resolver.LookupIPAddr(context.WithoutCancel(ctx), host)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("%w: %s", ErrNoAddresses, host)
+	}
+
+	lookup.addrs, lookup.err = addrs, err
+
+	d.mu.Lock()
+
+	if err == nil {
+		d.entries[host] = dnsCacheEntry{addrs: addrs, expiresAt: time.Now().Add(d.ttl)}
+	}
+
+	delete(d.lookups, host)
+
+	d.mu.Unlock()
+
+	close(lookup.done)
+}
+
+// DialContext returns the function dialing with dialer the addresses of the
+// host of addr found by the cache, one after the other until a connection is
+// established.
+func (d *DNSCache) DialContext(
+	dialer *net.Dialer,
+) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		addrs, err := d.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("error looking up %s: %w", host, err)
+		}
+
+		var errs []error
+
+		for _, ip := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+
+			errs = append(errs, err)
+
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		return nil, errors.Join(errs...)
+	}
+}
+
+func recordDNSLookup(ctx context.Context, result string) {
+	dnsLookupsTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+}
@@ -0,0 +1,166 @@
+package upstream_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+var errResolverDown = errors.New("lookup cache.example: server misbehaving")
+
+// fakeResolver resolves every host to its addrs, or fails with err.
+type fakeResolver struct {
+	mu    sync.Mutex
+	addrs []net.IPAddr
+	err   error
+	calls int
+}
+
+func (r *fakeResolver) LookupIPAddr(context.Context, string) ([]net.IPAddr, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls++
+
+	return r.addrs, r.err
+}
+
+func (r *fakeResolver) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.err = err
+}
+
+func (r *fakeResolver) callCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.calls
+}
+
+func TestDNSCache(t *testing.T) {
+	t.Parallel()
+
+	localhost := []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}
+
+	t.Run("fresh addresses are served from the cache", func(t *testing.T) {
+		t.Parallel()
+
+		r := &fakeResolver{addrs: localhost}
+		d := upstream.NewDNSCache(upstream.DNSCacheOptions{TTL: time.Hour, Resolver: r})
+
+		for range 3 {
+			addrs, err := d.LookupIPAddr(context.Background(), "cache.example")
+			require.NoError(t, err)
+			assert.Equal(t, localhost, addrs)
+		}
+
+		assert.Equal(t, 1, r.callCount())
+	})
+
+	t.Run("expired addresses are looked up again", func(t *testing.T) {
+		t.Parallel()
+
+		r := &fakeResolver{addrs: localhost}
+		d := upstream.NewDNSCache(upstream.DNSCacheOptions{TTL: time.Nanosecond, Resolver: r})
+
+		for range 2 {
+			_, err := d.LookupIPAddr(context.Background(), "cache.example")
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, 2, r.callCount())
+	})
+
+	t.Run("stale addresses survive a resolver outage", func(t *testing.T) {
+		t.Parallel()
+
+		r := &fakeResolver{addrs: localhost}
+		d := upstream.NewDNSCache(upstream.DNSCacheOptions{TTL: time.Nanosecond, MaxStale: time.Hour, Resolver: r})
+
+		_, err := d.LookupIPAddr(context.Background(), "cache.example")
+		require.NoError(t, err)
+
+		r.fail(errResolverDown)
+
+		addrs, err := d.LookupIPAddr(context.Background(), "cache.example")
+		require.NoError(t, err)
+		assert.Equal(t, localhost, addrs)
+	})
+
+	t.Run("the failure is returned without stale addresses", func(t *testing.T) {
+		t.Parallel()
+
+		r := &fakeResolver{addrs: localhost}
+		d := upstream.NewDNSCache(upstream.DNSCacheOptions{TTL: time.Nanosecond, Resolver: r})
+
+		_, err := d.LookupIPAddr(context.Background(), "cache.example")
+		require.NoError(t, err)
+
+		r.fail(errResolverDown)
+
+		_, err = d.LookupIPAddr(context.Background(), "other.example")
+		require.ErrorIs(t, err, errResolverDown)
+
+		_, err = d.LookupIPAddr(context.Background(), "cache.example")
+		require.ErrorIs(t, err, errResolverDown)
+	})
+
+	t.Run("no address is an error", func(t *testing.T) {
+		t.Parallel()
+
+		d := upstream.NewDNSCache(upstream.DNSCacheOptions{TTL: time.Hour, Resolver: &fakeResolver{}})
+
+		_, err := d.LookupIPAddr(context.Background(), "cache.example")
+		require.ErrorIs(t, err, upstream.ErrNoAddresses)
+	})
+}
+
+func TestDNSCacheDialsTheUpstream(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/nix-cache-info" {
+			_, _ = w.Write([]byte("StoreDir: /nix/store\nWantMassQuery: 1\nPriority: 40\n"))
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(ts.Close)
+
+	_, port, err := net.SplitHostPort(ts.Listener.Addr().String())
+	require.NoError(t, err)
+
+	// cache.example only resolves through the DNS cache.
+	r := &fakeResolver{addrs: []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}}
+	d := upstream.NewDNSCache(upstream.DNSCacheOptions{TTL: time.Hour, Resolver: r})
+
+	uc, err := upstream.New(
+		context.Background(),
+		testhelper.MustParseURL(t, "http://cache.example:"+port),
+		&upstream.Options{DNSCache: d},
+	)
+	require.NoError(t, err)
+
+	for range 2 {
+		priority, err := uc.ParsePriority(context.Background())
+		require.NoError(t, err)
+		assert.EqualValues(t, 40, priority)
+	}
+
+	assert.Equal(t, 1, r.callCount())
+}
@@ -0,0 +1,88 @@
+package upstream
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// fileScheme is the scheme of the upstream caches read from a local
+// directory, such as a mounted backup of a binary cache written by
+// `nix copy --to file://...`.
+const fileScheme = "file"
+
+// ErrInvalidFileURL is returned by New for a file:// URL that is not the
+// absolute path of a local directory.
+var ErrInvalidFileURL = errors.New("the file URL must be the absolute path of a local directory")
+
+// ParseURL parses the URL of an upstream cache. An absolute path is the
+// file:// URL of the directory.
+func ParseURL(s string) (*url.URL, error) {
+	if filepath.IsAbs(s) {
+		return &url.URL{Scheme: fileScheme, Path: filepath.ToSlash(filepath.Clean(s))}, nil
+	}
+
+	return url.Parse(s)
+}
+
+// IsFileURL reports whether u is the URL of an upstream cache read from a
+// local directory.
+func IsFileURL(u *url.URL) bool { return u.Scheme == fileScheme }
+
+func validateFileURL(u *url.URL) error {
+	if (u.Host != "" && u.Host != "localhost") || !path.IsAbs(u.Path) {
+		return ErrInvalidFileURL
+	}
+
+	return nil
+}
+
+// fileTransport serves the requests to a file:// upstream from its directory,
+// as an HTTP binary cache serving it would: a missing file is a 404 and HEAD
+// requests carry no body.
+type fileTransport struct {
+	// dir is the path of the directory in the request URLs.
+	dir   string
+	files http.RoundTripper
+}
+
+func newFileTransport(u *url.URL) *fileTransport {
+	dir := path.Clean(u.Path)
+
+	return &fileTransport{
+		dir:   dir,
+		files: http.NewFileTransport(http.Dir(filepath.FromSlash(dir))),
+	}
+}
+
+// RoundTrip implements http.RoundTripper. The paths outside of the directory
+// are not found.
+func (t *fileTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rel, ok := strings.CutPrefix(path.Clean(r.URL.Path), t.dir)
+	if !ok || (rel != "" && !strings.HasPrefix(rel, "/") && t.dir != "/") {
+		rel = ""
+	}
+
+	if rel == "" {
+		// The directory itself is never requested, and would be listed.
+		return &http.Response{
+			Status:     http.StatusText(http.StatusNotFound),
+			StatusCode: http.StatusNotFound,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     make(http.Header),
+			Body:       http.NoBody,
+			Request:    r,
+		}, nil
+	}
+
+	r = r.Clone(r.Context())
+	r.URL.Path = rel
+	r.URL.RawPath = ""
+
+	return t.files.RoundTrip(r)
+}
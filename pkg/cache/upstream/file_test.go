@@ -0,0 +1,112 @@
+package upstream_test
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestParseURL(t *testing.T) {
+	t.Parallel()
+
+	u, err := upstream.ParseURL("/mnt/backup/cache/")
+	require.NoError(t, err)
+	assert.Equal(t, "file:///mnt/backup/cache", u.String())
+	assert.True(t, upstream.IsFileURL(u))
+
+	u, err = upstream.ParseURL("https://cache.nixos.org")
+	require.NoError(t, err)
+	assert.False(t, upstream.IsFileURL(u))
+}
+
+func TestFileUpstream(t *testing.T) {
+	t.Parallel()
+
+	// A binary cache as written by `nix copy --to file://`.
+	dir := t.TempDir()
+	entry := testdata.Nar1
+	narURL := nar.URL{Hash: entry.NarHash, Compression: entry.NarCompression}
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nix-cache-info"),
+		[]byte("StoreDir: /nix/store\nWantMassQuery: 1\nPriority: 30\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, entry.NarInfoHash+".narinfo"),
+		[]byte(entry.NarInfoText), 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "nar"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, narURL.String()), []byte(entry.NarText), 0o600))
+
+	// A file outside of the cache directory is never served.
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(dir), "outside.narinfo"),
+		[]byte(entry.NarInfoText), 0o600))
+
+	c, err := upstream.New(newContext(), &url.URL{Scheme: "file", Path: dir, RawQuery: "priority=20"}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, dir, c.GetHostname())
+	assert.EqualValues(t, 20, c.GetPriority())
+
+	t.Run("nix-cache-info", func(t *testing.T) {
+		t.Parallel()
+
+		priority, err := c.ParsePriority(context.Background())
+		require.NoError(t, err)
+		assert.EqualValues(t, 30, priority)
+	})
+
+	t.Run("narinfo", func(t *testing.T) {
+		t.Parallel()
+
+		ni, err := c.GetNarInfo(context.Background(), entry.NarInfoHash)
+		require.NoError(t, err)
+		assert.Equal(t, "nar/"+entry.NarHash+".nar.xz", ni.URL)
+
+		exists, err := c.HasNarInfo(context.Background(), entry.NarInfoHash)
+		require.NoError(t, err)
+		assert.True(t, exists)
+
+		_, err = c.GetNarInfo(context.Background(), testdata.Nar2.NarInfoHash)
+		require.ErrorIs(t, err, upstream.ErrNotFound)
+
+		_, err = c.GetNarInfo(context.Background(), "../outside")
+		require.ErrorIs(t, err, upstream.ErrNotFound)
+	})
+
+	t.Run("nar", func(t *testing.T) {
+		t.Parallel()
+
+		exists, err := c.HasNar(context.Background(), narURL)
+		require.NoError(t, err)
+		assert.True(t, exists)
+
+		resp, err := c.GetNar(context.Background(), narURL)
+		require.NoError(t, err)
+
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, entry.NarText, string(body))
+
+		_, err = c.GetNar(context.Background(), nar.URL{Hash: testdata.Nar2.NarHash, Compression: nar.CompressionTypeXz})
+		require.ErrorIs(t, err, upstream.ErrNotFound)
+	})
+}
+
+func TestFileUpstreamInvalidURL(t *testing.T) {
+	t.Parallel()
+
+	_, err := upstream.New(newContext(), &url.URL{Scheme: "file", Host: "remote", Path: "/cache"}, nil)
+	require.ErrorIs(t, err, upstream.ErrInvalidFileURL)
+
+	_, err = upstream.New(newContext(), &url.URL{Scheme: "file", Opaque: "cache"}, nil)
+	require.ErrorIs(t, err, upstream.ErrInvalidFileURL)
+}
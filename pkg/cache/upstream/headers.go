@@ -0,0 +1,44 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+)
+
+// clientIPKey is the context key of the address of the client on whose behalf
+// an upstream request is made.
+type clientIPKey struct{}
+
+// WithClientIP returns a copy of ctx carrying ip, the address of the client on
+// whose behalf the upstream requests made with it are made. The upstreams
+// created with Options.ForwardClientIP send it as X-Forwarded-For.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+func clientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+
+	return ip
+}
+
+// addHeadersToRequest sets the User-Agent and the headers configured for the
+// upstream on req, and X-Forwarded-For if the client IP is forwarded. The
+// configured headers take precedence over the netrc credentials.
+func (c *Cache) addHeadersToRequest(req *http.Request) {
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
+
+	if !c.forwardClientIP {
+		return
+	}
+
+	if ip := clientIPFromContext(req.Context()); ip != "" {
+		req.Header.Set("X-Forwarded-For", ip)
+	}
+}
@@ -0,0 +1,69 @@
+package upstream_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestRequestHeaders(t *testing.T) {
+	t.Parallel()
+
+	headers := make(chan http.Header, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+
+		_, _ = w.Write([]byte("StoreDir: /nix/store\nWantMassQuery: 1\nPriority: 30\n"))
+	}))
+	t.Cleanup(ts.Close)
+
+	newCache := func(t *testing.T, opts *upstream.Options) *upstream.Cache {
+		t.Helper()
+
+		c, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), opts)
+		require.NoError(t, err)
+
+		return c
+	}
+
+	t.Run("user agent and configured headers", func(t *testing.T) {
+		c := newCache(t, &upstream.Options{
+			UserAgent: "ncps/test",
+			Header:    http.Header{"X-Proxy-Token": {"secret"}, "Authorization": {"Bearer token"}},
+			NetrcCredentials: &upstream.NetrcCredentials{
+				Username: "user",
+				Password: "pass",
+			},
+		})
+
+		_, err := c.ParsePriority(upstream.WithClientIP(newContext(), "10.0.0.1"))
+		require.NoError(t, err)
+
+		h := <-headers
+		assert.Equal(t, "ncps/test", h.Get("User-Agent"))
+		assert.Equal(t, "secret", h.Get("X-Proxy-Token"))
+		assert.Equal(t, "Bearer token", h.Get("Authorization"), "the configured headers override netrc")
+		assert.Empty(t, h.Get("X-Forwarded-For"), "the client IP is not forwarded by default")
+	})
+
+	t.Run("client IP forwarded", func(t *testing.T) {
+		c := newCache(t, &upstream.Options{ForwardClientIP: true})
+
+		_, err := c.ParsePriority(upstream.WithClientIP(newContext(), "10.0.0.1"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "10.0.0.1", (<-headers).Get("X-Forwarded-For"))
+
+		_, err = c.ParsePriority(newContext())
+		require.NoError(t, err)
+
+		assert.Empty(t, (<-headers).Get("X-Forwarded-For"), "no client, no X-Forwarded-For")
+	})
+}
@@ -0,0 +1,231 @@
+// Package ipfs fetches the NARs by their content hash from IPFS gateways, the
+// last-resort tier of the upstream caches for the public content no upstream
+// serves anymore.
+//
+// The content hash of a NAR, the sha256 of the file as described by its
+// narinfo, is addressed as the CIDv1 of the raw codec with the sha2-256
+// multihash. It is requested as a raw block from each gateway in turn, and
+// the bytes served are checked against the hash.
+//
+// That CID only resolves for a NAR stored as a single raw block, e.g. with
+// ipfs block put or ipfs add --raw-leaves of a file no larger than the chunk
+// size. A larger NAR added through the UnixFS import is chunked into a DAG
+// whose root CID is not derived from the hash of the file, so the NARs larger
+// than MaxBlockSize are never looked up.
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// codecRaw is the multicodec of raw binary content.
+	codecRaw = 0x55
+
+	// multihashSHA256 is the multihash code of sha2-256.
+	multihashSHA256 = 0x12
+
+	// mediaTypeRaw requests the raw block of a CID from a trustless gateway.
+	mediaTypeRaw = "application/vnd.ipld.raw"
+
+	// MaxBlockSize is the size of the largest NAR looked up, the largest raw
+	// block the gateways exchange.
+	MaxBlockSize = 1 << 20
+
+	// responseHeaderTimeout bounds the wait for a gateway to start answering,
+	// so a stalled gateway does not hold the tier.
+	responseHeaderTimeout = 30 * time.Second
+)
+
+var (
+	// ErrNotFound is returned by GetNar when every gateway answered that it
+	// does not have the NAR.
+	ErrNotFound = errors.New("not found on the IPFS gateways")
+
+	// ErrTooLarge is returned by GetNar for a NAR larger than MaxBlockSize,
+	// which cannot be a single raw block.
+	ErrTooLarge = errors.New("the NAR is too large to be a raw IPFS block")
+
+	// ErrInvalidGatewayURL is returned by New for a gateway URL that is not an
+	// absolute HTTP(S) URL.
+	ErrInvalidGatewayURL = errors.New("the IPFS gateway URL must be an absolute http or https URL")
+
+	// ErrInvalidDigest is returned for a content hash that is not a sha256
+	// digest.
+	ErrInvalidDigest = errors.New("the content hash must be a sha256 digest")
+
+	// ErrHashMismatch is returned by the body of a NAR whose content does not
+	// match its hash once read.
+	ErrHashMismatch = errors.New("the content served by the IPFS gateway does not match its hash")
+
+	// errNotServed is returned for a gateway answering that it does not have
+	// a NAR.
+	errNotServed = errors.New("not served")
+
+	// errUnexpectedStatus is returned for a gateway failing to serve a NAR.
+	errUnexpectedStatus = errors.New("unexpected status")
+
+	//nolint:gochecknoglobals
+	base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+)
+
+// Gateways fetches the NARs from a list of IPFS gateways, tried in order.
+type Gateways struct {
+	urls   []*url.URL
+	client *http.Client
+}
+
+// New returns the Gateways of the URLs, e.g. https://ipfs.io.
+func New(rawURLs []string) (*Gateways, error) {
+	g := &Gateways{urls: make([]*url.URL, 0, len(rawURLs))}
+
+	for _, raw := range rawURLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidGatewayURL, raw)
+		}
+
+		g.urls = append(g.urls, u)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert // the default is *http.Transport
+	transport.ResponseHeaderTimeout = responseHeaderTimeout
+
+	g.client = &http.Client{Transport: transport}
+
+	return g, nil
+}
+
+// URLs returns the URLs of the gateways.
+func (g *Gateways) URLs() []string {
+	urls := make([]string, 0, len(g.urls))
+	for _, u := range g.urls {
+		urls = append(urls, u.String())
+	}
+
+	return urls
+}
+
+// CID returns the CIDv1 of the raw content of sha256 digest, in base32.
+func CID(digest []byte) (string, error) {
+	if len(digest) != sha256.Size {
+		return "", fmt.Errorf("%w: %d bytes", ErrInvalidDigest, len(digest))
+	}
+
+	cid := append([]byte{0x01, codecRaw, multihashSHA256, sha256.Size}, digest...)
+
+	return "b" + base32Lower.EncodeToString(cid), nil
+}
+
+// GetNar fetches the NAR of sha256 digest and size bytes from the first
+// gateway serving it. The body of the response returns ErrHashMismatch once
+// read if the content does not match digest. It returns ErrTooLarge for a NAR
+// larger than MaxBlockSize, and ErrNotFound only if every gateway answered
+// that it does not have it.
+func (g *Gateways) GetNar(ctx context.Context, digest []byte, size int64) (*http.Response, error) {
+	cid, err := CID(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > MaxBlockSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, size)
+	}
+
+	errs := make([]error, 0, len(g.urls))
+	notServed := true
+
+	for _, u := range g.urls {
+		resp, err := g.get(ctx, u.JoinPath("ipfs", cid).String())
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			notServed = notServed && errors.Is(err, errNotServed)
+			errs = append(errs, fmt.Errorf("%s: %w", u.Host, err))
+
+			continue
+		}
+
+		resp.Body = &verifyingReader{
+			ReadCloser: resp.Body,
+			hash:       sha256.New(),
+			digest:     digest,
+		}
+
+		return resp, nil
+	}
+
+	if notServed {
+		errs = append([]error{ErrNotFound}, errs...)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+func (g *Gateways) get(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", mediaTypeRaw)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK || resp.ContentLength > MaxBlockSize {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		_ = resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, resp.ContentLength)
+		case http.StatusNotFound, http.StatusGone:
+			return nil, fmt.Errorf("%w: %s", errNotServed, resp.Status)
+		default:
+			return nil, fmt.Errorf("%w: %s", errUnexpectedStatus, resp.Status)
+		}
+	}
+
+	return resp, nil
+}
+
+// verifyingReader hashes the content it reads and checks it against digest
+// at EOF. It returns ErrTooLarge past MaxBlockSize bytes.
+type verifyingReader struct {
+	io.ReadCloser
+
+	hash   hash.Hash
+	digest []byte
+	read   int64
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+
+	r.read += int64(n)
+	if r.read > MaxBlockSize {
+		return n, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, MaxBlockSize)
+	}
+
+	if errors.Is(err, io.EOF) && !bytes.Equal(r.hash.Sum(nil), r.digest) {
+		return n, ErrHashMismatch
+	}
+
+	return n, err
+}
@@ -0,0 +1,136 @@
+package ipfs_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream/ipfs"
+)
+
+func TestCID(t *testing.T) {
+	t.Parallel()
+
+	digest := sha256.Sum256(nil)
+
+	cid, err := ipfs.CID(digest[:])
+	require.NoError(t, err)
+	assert.Equal(t, "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku", cid)
+
+	_, err = ipfs.CID(digest[:20])
+	require.ErrorIs(t, err, ipfs.ErrInvalidDigest)
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	g, err := ipfs.New([]string{"https://ipfs.io", "http://localhost:8080"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://ipfs.io", "http://localhost:8080"}, g.URLs())
+
+	for _, raw := range []string{"ipfs.io", "ftp://ipfs.io", "https://", "://"} {
+		_, err := ipfs.New([]string{raw})
+		require.ErrorIs(t, err, ipfs.ErrInvalidGatewayURL, raw)
+	}
+}
+
+func TestGetNar(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("the content of a nar")
+	digest := sha256.Sum256(content)
+
+	cid, err := ipfs.CID(digest[:])
+	require.NoError(t, err)
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(missing.Close)
+
+	serving := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ipfs/"+cid || r.Header.Get("Accept") != "application/vnd.ipld.raw" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		_, _ = w.Write(content)
+	}))
+	t.Cleanup(serving.Close)
+
+	corrupt := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("not the content of the nar"))
+	}))
+	t.Cleanup(corrupt.Close)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(failing.Close)
+
+	t.Run("served by a later gateway", func(t *testing.T) {
+		t.Parallel()
+
+		g, err := ipfs.New([]string{missing.URL, serving.URL})
+		require.NoError(t, err)
+
+		resp, err := g.GetNar(context.Background(), digest[:], int64(len(content)))
+		require.NoError(t, err)
+
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, content, body)
+	})
+
+	t.Run("hash mismatch", func(t *testing.T) {
+		t.Parallel()
+
+		g, err := ipfs.New([]string{corrupt.URL})
+		require.NoError(t, err)
+
+		resp, err := g.GetNar(context.Background(), digest[:], int64(len(content)))
+		require.NoError(t, err)
+
+		defer resp.Body.Close()
+
+		_, err = io.ReadAll(resp.Body)
+		require.ErrorIs(t, err, ipfs.ErrHashMismatch)
+	})
+
+	t.Run("not served", func(t *testing.T) {
+		t.Parallel()
+
+		g, err := ipfs.New([]string{missing.URL})
+		require.NoError(t, err)
+
+		_, err = g.GetNar(context.Background(), digest[:], int64(len(content)))
+		require.ErrorIs(t, err, ipfs.ErrNotFound)
+	})
+	t.Run("gateway error", func(t *testing.T) {
+		t.Parallel()
+
+		g, err := ipfs.New([]string{missing.URL, failing.URL})
+		require.NoError(t, err)
+
+		_, err = g.GetNar(context.Background(), digest[:], int64(len(content)))
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ipfs.ErrNotFound, "a gateway failing is not a NAR not found")
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		t.Parallel()
+
+		g, err := ipfs.New([]string{serving.URL})
+		require.NoError(t, err)
+
+		_, err = g.GetNar(context.Background(), digest[:], ipfs.MaxBlockSize+1)
+		require.ErrorIs(t, err, ipfs.ErrTooLarge)
+	})
+}
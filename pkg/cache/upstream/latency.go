@@ -0,0 +1,63 @@
+package upstream
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// latencyWindowSize is the number of recent narinfo probes whose latency is
+	// kept per upstream.
+	latencyWindowSize = 128
+
+	// latencyWindowMinSamples is the number of probes needed before a
+	// percentile is reported.
+	latencyWindowMinSamples = 20
+)
+
+// latencyWindow keeps the latencies of the most recent requests of a kind.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+// record adds a latency to the window, evicting the oldest one when full.
+func (w *latencyWindow) record(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, d)
+
+		return
+	}
+
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencyWindowSize
+}
+
+// percentile returns the p-th percentile (0 < p <= 1) of the window, or false
+// when it holds fewer than latencyWindowMinSamples latencies.
+func (w *latencyWindow) percentile(p float64) (time.Duration, bool) {
+	w.mu.Lock()
+	sorted := slices.Clone(w.samples)
+	w.mu.Unlock()
+
+	if len(sorted) < latencyWindowMinSamples {
+		return 0, false
+	}
+
+	slices.Sort(sorted)
+
+	idx := int(p*float64(len(sorted))+0.5) - 1
+
+	return sorted[max(0, min(idx, len(sorted)-1))], true
+}
+
+// NarInfoProbeP95 returns the 95th percentile latency of the recent narinfo
+// HEAD probes of the upstream, or false until enough probes were made.
+func (c *Cache) NarInfoProbeP95() (time.Duration, bool) {
+	return c.narInfoProbeLatency.percentile(0.95)
+}
@@ -0,0 +1,51 @@
+package upstream_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+// delayRoundTripper answers every request with a 200 after its delay.
+type delayRoundTripper struct {
+	delay time.Duration
+}
+
+func (d delayRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	time.Sleep(d.delay)
+
+	return (&statusRoundTripper{status: http.StatusOK}).RoundTrip(req)
+}
+
+func TestNarInfoProbeP95(t *testing.T) {
+	t.Parallel()
+
+	const delay = 5 * time.Millisecond
+
+	c, err := upstream.New(
+		context.Background(),
+		testhelper.MustParseURL(t, "https://cache.nixos.org"),
+		&upstream.Options{Transport: delayRoundTripper{delay: delay}},
+	)
+	require.NoError(t, err)
+
+	_, ok := c.NarInfoProbeP95()
+	assert.False(t, ok, "no latency is known before any probe")
+
+	for range 20 {
+		exists, err := c.HasNarInfo(context.Background(), "hash")
+		require.NoError(t, err)
+		require.True(t, exists)
+	}
+
+	p95, ok := c.NarInfoProbeP95()
+	require.True(t, ok)
+	assert.GreaterOrEqual(t, p95, delay)
+}
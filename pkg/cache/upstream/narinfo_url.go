@@ -0,0 +1,170 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// NarInfoURLPolicy selects what is done with a narinfo whose URL is not a path
+// relative to the upstream serving it: an absolute URL pointing to another
+// host, or a path escaping the root of the upstream. Unless it is allowed, the
+// NAR of a narinfo is always fetched from the upstream serving it, never from
+// the host its URL names.
+type NarInfoURLPolicy string
+
+const (
+	// NarInfoURLPolicyNormalize rewrites the URL into the path of the NAR file
+	// on the upstream, nar/<file name>. It is the default.
+	NarInfoURLPolicyNormalize NarInfoURLPolicy = "normalize"
+
+	// NarInfoURLPolicyReject rejects the narinfo, as if it were invalid.
+	NarInfoURLPolicyReject NarInfoURLPolicy = "reject"
+
+	// NarInfoURLPolicyAllow passes the URL through unchanged.
+	NarInfoURLPolicyAllow NarInfoURLPolicy = "allow"
+)
+
+const (
+	// Actions recorded by ncps_upstream_narinfo_url_rewrites_total.
+	narInfoURLNormalized = "normalized"
+	narInfoURLRejected   = "rejected"
+	narInfoURLAllowed    = "allowed"
+)
+
+var (
+	// ErrUnknownNarInfoURLPolicy is returned by ParseNarInfoURLPolicy for an
+	// unknown policy.
+	ErrUnknownNarInfoURLPolicy = errors.New("unknown narinfo URL policy (allowed: normalize, reject, allow)")
+
+	// ErrNarInfoURLNotRelative is returned by GetNarInfo for a narinfo whose URL
+	// is not relative to the upstream, with NarInfoURLPolicyReject. It wraps
+	// ErrInvalidNarInfo.
+	ErrNarInfoURLNotRelative = fmt.Errorf("%w: the URL is not relative to the upstream", ErrInvalidNarInfo)
+
+	//nolint:gochecknoglobals
+	narInfoURLRewritesTotal metric.Int64Counter
+)
+
+//nolint:gochecknoinits
+func init() {
+	var err error
+
+	narInfoURLRewritesTotal, err = otel.Meter(otelPackageName).Int64Counter(
+		"ncps_upstream_narinfo_url_rewrites_total",
+		metric.WithDescription("Counts the upstream narinfos whose URL is not relative to the upstream, "+
+			"by action: normalized, rejected or allowed."),
+		metric.WithUnit("{narinfo}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// primeNarInfoURLMetrics records zero for every action of the narinfo URL
+// check.
+func primeNarInfoURLMetrics(ctx context.Context) {
+	for _, action := range []string{narInfoURLNormalized, narInfoURLRejected, narInfoURLAllowed} {
+		narInfoURLRewritesTotal.Add(ctx, 0, metric.WithAttributes(attribute.String("action", action)))
+	}
+}
+
+// ParseNarInfoURLPolicy parses the name of a NarInfoURLPolicy. The empty
+// string is the default policy.
+func ParseNarInfoURLPolicy(s string) (NarInfoURLPolicy, error) {
+	switch NarInfoURLPolicy(s) {
+	case "", NarInfoURLPolicyNormalize:
+		return NarInfoURLPolicyNormalize, nil
+	case NarInfoURLPolicyReject:
+		return NarInfoURLPolicyReject, nil
+	case NarInfoURLPolicyAllow:
+		return NarInfoURLPolicyAllow, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownNarInfoURLPolicy, s)
+	}
+}
+
+// checkNarInfoURL returns the narinfo URL raw as a path relative to the
+// upstream. An absolute URL to the upstream itself is made relative; any other
+// URL not relative to the upstream is normalized, rejected or passed through
+// unchanged per the policy.
+func (c *Cache) checkNarInfoURL(ctx context.Context, raw string) (string, error) {
+	rel, ok := relativeNarInfoURL(c.url, raw)
+	if ok {
+		return rel, nil
+	}
+
+	switch c.narInfoURLPolicy {
+	case NarInfoURLPolicyAllow:
+		narInfoURLRewritesTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("action", narInfoURLAllowed)))
+
+		zerolog.Ctx(ctx).
+			Debug().
+			Str("url", raw).
+			Msg("allowing the narinfo URL not relative to the upstream")
+
+		return raw, nil
+	case NarInfoURLPolicyReject:
+		narInfoURLRewritesTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("action", narInfoURLRejected)))
+
+		zerolog.Ctx(ctx).
+			Warn().
+			Str("url", raw).
+			Msg("rejecting the narinfo whose URL is not relative to the upstream")
+
+		return "", fmt.Errorf("%w: %q", ErrNarInfoURLNotRelative, raw)
+	case NarInfoURLPolicyNormalize:
+		// Normalized below, as with the empty default policy.
+	}
+
+	narInfoURLRewritesTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("action", narInfoURLNormalized)))
+
+	zerolog.Ctx(ctx).
+		Warn().
+		Str("url", raw).
+		Str("normalized_url", rel).
+		Msg("normalized the narinfo URL not relative to the upstream")
+
+	return rel, nil
+}
+
+// relativeNarInfoURL returns the narinfo URL raw relative to the upstream at
+// base, and whether it is one: a relative path not escaping the root of the
+// upstream, or an absolute URL under base. Otherwise it returns the path of
+// the NAR file on the upstream, nar/<file name>.
+func relativeNarInfoURL(base *url.URL, raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// Left to the parsing of the nar URL, which rejects it.
+		return raw, true
+	}
+
+	query := ""
+	if u.RawQuery != "" {
+		query = "?" + u.RawQuery
+	}
+
+	if u.Scheme == "" && u.Host == "" {
+		cleaned := path.Clean(strings.TrimPrefix(u.Path, "/"))
+		if cleaned != ".." && !strings.HasPrefix(cleaned, "../") {
+			return raw, true
+		}
+	} else if u.Scheme == base.Scheme && u.Host == base.Host {
+		prefix := strings.TrimSuffix(base.Path, "/") + "/"
+
+		cleaned := path.Clean(u.Path)
+		if strings.HasPrefix(cleaned, prefix) {
+			return strings.TrimPrefix(cleaned, prefix) + query, true
+		}
+	}
+
+	return "nar/" + path.Base(u.Path) + query, false
+}
@@ -0,0 +1,111 @@
+package upstream_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestParseNarInfoURLPolicy(t *testing.T) {
+	t.Parallel()
+
+	for s, want := range map[string]upstream.NarInfoURLPolicy{
+		"":          upstream.NarInfoURLPolicyNormalize,
+		"normalize": upstream.NarInfoURLPolicyNormalize,
+		"reject":    upstream.NarInfoURLPolicyReject,
+		"allow":     upstream.NarInfoURLPolicyAllow,
+	} {
+		got, err := upstream.ParseNarInfoURLPolicy(s)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := upstream.ParseNarInfoURLPolicy("follow")
+	require.ErrorIs(t, err, upstream.ErrUnknownNarInfoURLPolicy)
+}
+
+func TestGetNarInfo_URLNotRelative(t *testing.T) {
+	t.Parallel()
+
+	const relURL = "nar/1lid9xrpirkzcpqsxfq02qwiq0yd70chfl860wzsqd1739ih0nri.nar.xz"
+
+	var narInfoURL atomic.Value
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		u, _ := narInfoURL.Load().(string)
+		_, _ = w.Write([]byte(strings.Replace(testdata.Nar1.NarInfoText, "URL: "+relURL, "URL: "+u, 1)))
+	}))
+	t.Cleanup(ts.Close)
+
+	require.Contains(t, testdata.Nar1.NarInfoText, "URL: "+relURL)
+
+	tests := []struct {
+		name       string
+		url        string
+		normalized string
+		relative   bool
+	}{
+		{name: "relative", url: relURL, normalized: relURL, relative: true},
+		{name: "absolute to the upstream", url: ts.URL + "/cache/" + relURL, normalized: relURL, relative: true},
+		{name: "absolute to another host", url: "https://evil.example/" + relURL, normalized: relURL},
+		{name: "protocol-relative", url: "//evil.example/x/" + relURL + "?a=1", normalized: relURL + "?a=1"},
+		{name: "escaping the upstream", url: "../other/" + relURL, normalized: relURL},
+		{name: "another path on the upstream host", url: ts.URL + "/other/" + relURL, normalized: relURL},
+	}
+
+	get := func(t *testing.T, policy upstream.NarInfoURLPolicy, url string) (string, error) {
+		t.Helper()
+
+		narInfoURL.Store(url)
+
+		c, err := upstream.New(context.Background(), testhelper.MustParseURL(t, ts.URL+"/cache"),
+			&upstream.Options{NarInfoURLPolicy: policy})
+		require.NoError(t, err)
+
+		ni, err := c.GetNarInfo(context.Background(), testdata.Nar1.NarInfoHash)
+		if err != nil {
+			return "", err
+		}
+
+		return ni.URL, nil
+	}
+
+	//nolint:paralleltest // the subtests share the narinfo served
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := get(t, upstream.NarInfoURLPolicyNormalize, tc.url)
+			require.NoError(t, err)
+			assert.Equal(t, tc.normalized, got)
+
+			got, err = get(t, upstream.NarInfoURLPolicyAllow, tc.url)
+			require.NoError(t, err)
+
+			if tc.relative {
+				assert.Equal(t, tc.normalized, got)
+			} else {
+				assert.Equal(t, tc.url, got, "the URL is passed through unchanged")
+			}
+
+			got, err = get(t, upstream.NarInfoURLPolicyReject, tc.url)
+			if tc.relative {
+				require.NoError(t, err)
+				assert.Equal(t, tc.normalized, got)
+
+				return
+			}
+
+			require.ErrorIs(t, err, upstream.ErrNarInfoURLNotRelative)
+			require.ErrorIs(t, err, upstream.ErrInvalidNarInfo)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package upstream_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+const testPublicKey = "cache.nixos.org-1:6NCHdD59X431o0gWypbMrAURkbJ16ZPMQFGspcDShjY="
+
+func TestFetchPublicKey(t *testing.T) {
+	t.Parallel()
+
+	newKeyServer := func(t *testing.T, path string, auth *string) *httptest.Server {
+		t.Helper()
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if auth != nil {
+				*auth = r.Header.Get("Authorization")
+			}
+
+			if r.URL.Path != path {
+				w.WriteHeader(http.StatusNotFound)
+
+				return
+			}
+
+			_, _ = w.Write([]byte(testPublicKey + "\n"))
+		}))
+		t.Cleanup(ts.Close)
+
+		return ts
+	}
+
+	t.Run("from the pubkey of the upstream", func(t *testing.T) {
+		t.Parallel()
+
+		var auth string
+
+		ts := newKeyServer(t, "/pubkey", &auth)
+
+		c, err := upstream.New(context.Background(), testhelper.MustParseURL(t, ts.URL), &upstream.Options{
+			NetrcCredentials: &upstream.NetrcCredentials{Username: "user", Password: "pass"},
+		})
+		require.NoError(t, err)
+
+		pk, err := c.FetchPublicKey(context.Background(), "")
+		require.NoError(t, err)
+		assert.Equal(t, testPublicKey, pk.String())
+		assert.NotEmpty(t, auth, "the credentials of the upstream are sent to it")
+	})
+
+	t.Run("from a configured URL on another host", func(t *testing.T) {
+		t.Parallel()
+
+		var auth string
+
+		keys := newKeyServer(t, "/keys/cache.pub", &auth)
+
+		c, err := upstream.New(
+			context.Background(),
+			testhelper.MustParseURL(t, "https://cache.nixos.org"),
+			&upstream.Options{
+				NetrcCredentials: &upstream.NetrcCredentials{Username: "user", Password: "pass"},
+			},
+		)
+		require.NoError(t, err)
+
+		pk, err := c.FetchPublicKey(context.Background(), keys.URL+"/keys/cache.pub")
+		require.NoError(t, err)
+		assert.Equal(t, testPublicKey, pk.String())
+		assert.Empty(t, auth, "the credentials of the upstream are not sent to another host")
+	})
+
+	t.Run("missing public key", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newKeyServer(t, "/elsewhere", nil)
+
+		c, err := upstream.New(context.Background(), testhelper.MustParseURL(t, ts.URL), nil)
+		require.NoError(t, err)
+
+		_, err = c.FetchPublicKey(context.Background(), "")
+		require.ErrorIs(t, err, upstream.ErrUnexpectedHTTPStatusCode)
+	})
+}
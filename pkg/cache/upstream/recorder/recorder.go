@@ -0,0 +1,368 @@
+// Package recorder records the requests ncps makes to its upstream caches and
+// their responses, optionally with their bodies, to debug them offline. A
+// recording is replayed by serving it as a fake upstream with NewReplayHandler.
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// segmentLayout names the segment of an hour; a recording is split into
+	// hourly segments so the ones out of the window can be removed.
+	segmentLayout = "20060102T15"
+	segmentPrefix = "upstream-"
+	segmentSuffix = ".jsonl"
+
+	redacted = "REDACTED"
+)
+
+// ErrDirRequired is returned if the directory of the recording is empty.
+var ErrDirRequired = errors.New("the recording directory is required")
+
+// redactedHeaders are the request headers whose value is not recorded.
+//
+//nolint:gochecknoglobals
+var redactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// Exchange is a recorded upstream request and its response.
+type Exchange struct {
+	Time          time.Time   `json:"time"`
+	Method        string      `json:"method"`
+	URL           string      `json:"url"`
+	RequestHeader http.Header `json:"requestHeader,omitempty"`
+
+	// Status and ResponseHeader are zero when the request failed with Error.
+	Status         int         `json:"status,omitempty"`
+	ResponseHeader http.Header `json:"responseHeader,omitempty"`
+	Error          string      `json:"error,omitempty"`
+
+	// Duration is the time until the response body was closed, or until the
+	// request failed.
+	Duration time.Duration `json:"duration"`
+
+	// BodySize is the number of bytes of the response body that were read.
+	// Body holds up to the maximum body size of them, and BodyTruncated
+	// tells whether it holds less than BodySize.
+	BodySize      int64  `json:"bodySize"`
+	Body          []byte `json:"body,omitempty"`
+	BodyTruncated bool   `json:"bodyTruncated,omitempty"`
+}
+
+// Options configures a Recorder.
+type Options struct {
+	// Dir is the directory the recording is written to.
+	Dir string
+
+	// Window is how long the exchanges are kept. If zero, they are kept
+	// forever.
+	Window time.Duration
+
+	// MaxBodySize is the number of bytes of each response body that are
+	// recorded. If zero, the bodies are not recorded.
+	MaxBodySize int64
+}
+
+// Recorder records the exchanges of the transports it wraps to hourly segments
+// of JSON lines in its directory.
+type Recorder struct {
+	dir         string
+	window      time.Duration
+	maxBodySize int64
+
+	mu      sync.Mutex
+	segment string
+	f       *os.File
+	now     func() time.Time
+}
+
+// New returns a Recorder writing to opts.Dir, which is created if needed.
+func New(opts Options) (*Recorder, error) {
+	if opts.Dir == "" {
+		return nil, ErrDirRequired
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("error creating the recording directory: %w", err)
+	}
+
+	return &Recorder{
+		dir:         opts.Dir,
+		window:      opts.Window,
+		maxBodySize: opts.MaxBodySize,
+		now:         time.Now,
+	}, nil
+}
+
+// Wrap returns a transport recording the exchanges of next. An exchange is
+// recorded once its response body is closed.
+func (r *Recorder) Wrap(next http.RoundTripper) http.RoundTripper {
+	return &transport{recorder: r, next: next}
+}
+
+// Close closes the current segment.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return nil
+	}
+
+	err := r.f.Close()
+	r.f = nil
+
+	return err
+}
+
+func (r *Recorder) write(e *Exchange) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error encoding the exchange: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.rotate(); err != nil {
+		return err
+	}
+
+	if _, err := r.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing the exchange: %w", err)
+	}
+
+	return nil
+}
+
+// rotate opens the segment of the current hour, and removes the segments out
+// of the window when it changes.
+func (r *Recorder) rotate() error {
+	segment := segmentPrefix + r.now().UTC().Format(segmentLayout) + segmentSuffix
+	if r.f != nil && segment == r.segment {
+		return nil
+	}
+
+	if r.f != nil {
+		if err := r.f.Close(); err != nil {
+			return fmt.Errorf("error closing the segment %s: %w", r.segment, err)
+		}
+
+		r.f = nil
+	}
+
+	f, err := os.OpenFile(filepath.Join(r.dir, segment), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening the segment %s: %w", segment, err)
+	}
+
+	r.f, r.segment = f, segment
+
+	return r.prune()
+}
+
+// prune removes the segments whose last exchange is out of the window.
+func (r *Recorder) prune() error {
+	if r.window <= 0 {
+		return nil
+	}
+
+	segments, err := listSegments(r.dir)
+	if err != nil {
+		return err
+	}
+
+	cutoff := r.now().Add(-r.window)
+
+	for _, s := range segments {
+		if !s.start.Add(time.Hour).Before(cutoff) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(r.dir, s.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing the segment %s: %w", s.name, err)
+		}
+	}
+
+	return nil
+}
+
+type segmentFile struct {
+	name  string
+	start time.Time
+}
+
+// listSegments returns the segments of dir, oldest first.
+func listSegments(dir string) ([]segmentFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing the recording directory: %w", err)
+	}
+
+	var segments []segmentFile
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix)
+
+		start, err := time.Parse(segmentLayout, stamp)
+		if err != nil {
+			continue
+		}
+
+		segments = append(segments, segmentFile{name: name, start: start})
+	}
+
+	slices.SortFunc(segments, func(a, b segmentFile) int { return a.start.Compare(b.start) })
+
+	return segments, nil
+}
+
+// Read returns the exchanges recorded in dir, in the order they started.
+func Read(dir string) ([]Exchange, error) {
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var exchanges []Exchange
+
+	for _, s := range segments {
+		data, err := os.ReadFile(filepath.Join(dir, s.name))
+		if err != nil {
+			return nil, fmt.Errorf("error reading the segment %s: %w", s.name, err)
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, len(data)+1)
+
+		for line := 1; scanner.Scan(); line++ {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+
+			var e Exchange
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				return nil, fmt.Errorf("error decoding the line %d of the segment %s: %w", line, s.name, err)
+			}
+
+			exchanges = append(exchanges, e)
+		}
+	}
+
+	// An exchange is written once its body is closed, so they are sorted
+	// back by start time.
+	slices.SortStableFunc(exchanges, func(a, b Exchange) int { return a.Time.Compare(b.Time) })
+
+	return exchanges, nil
+}
+
+type transport struct {
+	recorder *Recorder
+	next     http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	e := &Exchange{
+		Time:          t.recorder.now(),
+		Method:        req.Method,
+		URL:           req.URL.String(),
+		RequestHeader: redactHeader(req.Header),
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		e.Error = err.Error()
+		e.Duration = t.recorder.now().Sub(e.Time)
+
+		t.recordExchange(req, e)
+
+		return nil, err
+	}
+
+	e.Status = resp.StatusCode
+	e.ResponseHeader = resp.Header.Clone()
+
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		exchange:   e,
+		max:        t.recorder.maxBodySize,
+		done: func() {
+			e.Duration = t.recorder.now().Sub(e.Time)
+
+			t.recordExchange(req, e)
+		},
+	}
+
+	return resp, nil
+}
+
+func (t *transport) recordExchange(req *http.Request, e *Exchange) {
+	if err := t.recorder.write(e); err != nil {
+		zerolog.Ctx(req.Context()).Warn().Err(err).Str("url", e.URL).Msg("error recording an upstream exchange")
+	}
+}
+
+// recordingBody records the bytes read of a response body, up to max of them,
+// and calls done once it is closed.
+type recordingBody struct {
+	io.ReadCloser
+
+	exchange *Exchange
+	max      int64
+	done     func()
+	once     sync.Once
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	e := b.exchange
+	e.BodySize += int64(n)
+
+	if room := b.max - int64(len(e.Body)); room > 0 {
+		e.Body = append(e.Body, p[:min(int64(n), room)]...)
+	}
+
+	e.BodyTruncated = int64(len(e.Body)) < e.BodySize
+
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+
+	b.once.Do(b.done)
+
+	return err
+}
+
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+
+	for _, name := range redactedHeaders {
+		if h.Get(name) != "" {
+			h.Set(name, redacted)
+		}
+	}
+
+	return h
+}
@@ -0,0 +1,46 @@
+package recorder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorderPrunesSegmentsOutOfTheWindow(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	rec, err := New(Options{Dir: dir, Window: 2 * time.Hour})
+	require.NoError(t, err)
+
+	now := time.Date(2026, 1, 1, 10, 30, 0, 0, time.UTC)
+	rec.now = func() time.Time { return now }
+
+	for _, hour := range []int{6, 7, 8, 9} {
+		name := segmentPrefix + time.Date(2026, 1, 1, hour, 0, 0, 0, time.UTC).Format(segmentLayout) + segmentSuffix
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o600))
+	}
+
+	require.NoError(t, rec.write(&Exchange{Time: now, Method: "GET", URL: "https://cache.example.com/"}))
+	require.NoError(t, rec.Close())
+
+	segments, err := listSegments(dir)
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(segments))
+	for _, s := range segments {
+		names = append(names, s.name)
+	}
+
+	// The segment of 08:00 ends at 09:00, within two hours of 10:30.
+	assert.Equal(t, []string{
+		"upstream-20260101T08.jsonl",
+		"upstream-20260101T09.jsonl",
+		"upstream-20260101T10.jsonl",
+	}, names)
+}
@@ -0,0 +1,146 @@
+package recorder_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream/recorder"
+)
+
+func get(t *testing.T, client *http.Client, u string, header http.Header) (*http.Response, []byte, error) {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, u, nil)
+	require.NoError(t, err)
+
+	req.Header = header
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, body, nil
+}
+
+func TestRecorder(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.narinfo" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-nix-nar")
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	t.Cleanup(upstream.Close)
+
+	dir := t.TempDir()
+
+	rec, err := recorder.New(recorder.Options{Dir: dir, MaxBodySize: 4})
+	require.NoError(t, err)
+
+	client := &http.Client{Transport: rec.Wrap(http.DefaultTransport)}
+
+	_, body, err := get(t, client, upstream.URL+"/nar/abc.nar", http.Header{"Authorization": {"Bearer secret"}})
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(body), "the recording does not alter the body")
+
+	_, _, err = get(t, client, upstream.URL+"/missing.narinfo", nil)
+	require.NoError(t, err)
+
+	_, _, err = get(t, client, "http://127.0.0.1:1/unreachable", nil)
+	require.Error(t, err)
+
+	require.NoError(t, rec.Close())
+
+	exchanges, err := recorder.Read(dir)
+	require.NoError(t, err)
+	require.Len(t, exchanges, 3)
+
+	nar := exchanges[0]
+	assert.Equal(t, http.MethodGet, nar.Method)
+	assert.Equal(t, upstream.URL+"/nar/abc.nar", nar.URL)
+	assert.Equal(t, "REDACTED", nar.RequestHeader.Get("Authorization"))
+	assert.Equal(t, http.StatusOK, nar.Status)
+	assert.Equal(t, "application/x-nix-nar", nar.ResponseHeader.Get("Content-Type"))
+	assert.Equal(t, int64(10), nar.BodySize)
+	assert.Equal(t, "0123", string(nar.Body))
+	assert.True(t, nar.BodyTruncated)
+
+	assert.Equal(t, http.StatusNotFound, exchanges[1].Status)
+
+	assert.Zero(t, exchanges[2].Status)
+	assert.NotEmpty(t, exchanges[2].Error)
+
+	t.Run("replay", func(t *testing.T) {
+		t.Parallel()
+
+		replay := httptest.NewServer(recorder.NewReplayHandler(exchanges))
+		t.Cleanup(replay.Close)
+
+		resp, body, err := get(t, replay.Client(), replay.URL+"/nar/abc.nar", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/x-nix-nar", resp.Header.Get("Content-Type"))
+		assert.Equal(t, "truncated", resp.Header.Get(recorder.ReplayBodyHeader))
+		assert.Equal(t, "0123", string(body))
+
+		resp, _, err = get(t, replay.Client(), replay.URL+"/missing.narinfo", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		_, _, err = get(t, replay.Client(), replay.URL+"/unreachable", nil)
+		require.Error(t, err, "a failed request is replayed by closing the connection")
+
+		resp, _, err = get(t, replay.Client(), replay.URL+"/never-recorded", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestReplayHandlerServesResponsesInTurn(t *testing.T) {
+	t.Parallel()
+
+	replay := httptest.NewServer(recorder.NewReplayHandler([]recorder.Exchange{
+		{Method: http.MethodGet, URL: "https://cache.example.com/abc.narinfo", Status: http.StatusServiceUnavailable},
+		{
+			Method:   http.MethodGet,
+			URL:      "https://cache.example.com/abc.narinfo",
+			Status:   http.StatusOK,
+			Body:     []byte("StorePath: /nix/store/abc"),
+			BodySize: 25,
+		},
+	}))
+	t.Cleanup(replay.Close)
+
+	for _, want := range []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusOK} {
+		resp, _, err := get(t, replay.Client(), replay.URL+"/abc.narinfo", nil)
+		require.NoError(t, err)
+		assert.Equal(t, want, resp.StatusCode)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodHead, replay.URL+"/abc.narinfo", nil)
+	require.NoError(t, err)
+
+	resp, err := replay.Client().Do(req)
+	require.NoError(t, err)
+
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "a HEAD is answered with the headers of a GET")
+}
@@ -0,0 +1,132 @@
+package recorder
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+const (
+	// ReplayBodyHeader tells that the body of a replayed response is
+	// incomplete: "missing" when it was not recorded and "truncated" when it
+	// was recorded up to the maximum body size.
+	ReplayBodyHeader = "X-Ncps-Replay-Body"
+
+	replayBodyMissing   = "missing"
+	replayBodyTruncated = "truncated"
+)
+
+// replayHandler serves recorded responses.
+type replayHandler struct {
+	mu sync.Mutex
+
+	// responses are the exchanges recorded for each request, by method and
+	// request URI, and served the index of the next one to serve.
+	responses map[string][]Exchange
+	served    map[string]int
+}
+
+// NewReplayHandler returns a handler serving the responses recorded in
+// exchanges, to reproduce a sequence of upstream exchanges locally. The
+// requests of a method and request URI are answered with the responses
+// recorded for them in turn, the last one being repeated; a HEAD request with
+// no recorded response is answered with the headers of a GET. Other requests
+// are answered with 404, and a request that failed is answered by closing the
+// connection.
+func NewReplayHandler(exchanges []Exchange) http.Handler {
+	h := &replayHandler{
+		responses: make(map[string][]Exchange),
+		served:    make(map[string]int),
+	}
+
+	for _, e := range exchanges {
+		u, err := url.Parse(e.URL)
+		if err != nil {
+			continue
+		}
+
+		key := replayKey(e.Method, u.RequestURI())
+		h.responses[key] = append(h.responses[key], e)
+	}
+
+	return h
+}
+
+func replayKey(method, requestURI string) string { return method + " " + requestURI }
+
+func (h *replayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e, ok := h.next(r.Method, r.URL.RequestURI())
+	if !ok && r.Method == http.MethodHead {
+		e, ok = h.next(http.MethodGet, r.URL.RequestURI())
+	}
+
+	if !ok {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	if e.Error != "" {
+		closeConnection(w)
+
+		return
+	}
+
+	for k, v := range e.ResponseHeader {
+		w.Header()[k] = v
+	}
+
+	switch {
+	case e.BodySize > 0 && len(e.Body) == 0:
+		w.Header().Del("Content-Length")
+		w.Header().Set(ReplayBodyHeader, replayBodyMissing)
+	case e.BodyTruncated:
+		w.Header().Del("Content-Length")
+		w.Header().Set(ReplayBodyHeader, replayBodyTruncated)
+	}
+
+	w.WriteHeader(e.Status)
+
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(e.Body)
+	}
+}
+
+// next returns the response to serve to the request of method and
+// requestURI.
+func (h *replayHandler) next(method, requestURI string) (Exchange, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := replayKey(method, requestURI)
+
+	responses := h.responses[key]
+	if len(responses) == 0 {
+		return Exchange{}, false
+	}
+
+	i := min(h.served[key], len(responses)-1)
+	h.served[key] = i + 1
+
+	return responses[i], true
+}
+
+// closeConnection closes the connection of w without a response, as a failed
+// upstream request would.
+func closeConnection(w http.ResponseWriter) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(http.StatusBadGateway)
+
+		return
+	}
+
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+
+		return
+	}
+
+	_ = conn.Close()
+}
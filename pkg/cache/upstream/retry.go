@@ -0,0 +1,211 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	mathrand "math/rand"
+)
+
+// retryBudgetBurst is the number of retries a budgeted upstream may make
+// before requests have earned any.
+const retryBudgetBurst = 10
+
+// retryJitterFactor bounds the random delay added to a retry backoff, as a
+// fraction of the backoff, so clients that failed together do not retry
+// together.
+const retryJitterFactor = 0.25
+
+type requestBudgetKey struct{}
+
+// errAttemptTimeout is the cause of an attempt canceled by the per-try timeout.
+var errAttemptTimeout = errors.New("upstream attempt timed out")
+
+// isRetriableStatus reports whether an upstream answered with a status that
+// signals a transient overload or outage. Every other status, 4xx included, is
+// a definitive answer and is never retried.
+func isRetriableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRetriableError reports whether a failed attempt may be retried: a
+// transient transport failure, a refused connection, a response cut short or
+// an attempt that exceeded the per-try timeout. Transport timeouts are not
+// retried: they already waited the full dialer or response header timeout.
+func isRetriableError(err error) bool {
+	return isRetriableTransportError(err) ||
+		errors.Is(err, errAttemptTimeout) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryBudget caps the retries of an upstream to a fraction of its requests:
+// every request earns ratio of a retry and every retry spends one, with a
+// burst of retryBudgetBurst. It keeps retries from multiplying the load on an
+// upstream that fails every request.
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+// newRetryBudget returns a budget earning ratio retries per request, or nil
+// (an unlimited budget) if ratio is not positive.
+func newRetryBudget(ratio float64) *retryBudget {
+	if ratio <= 0 {
+		return nil
+	}
+
+	return &retryBudget{ratio: ratio, tokens: retryBudgetBurst}
+}
+
+// earn credits the budget for a request.
+func (b *retryBudget) earn() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = min(b.tokens+b.ratio, retryBudgetBurst)
+}
+
+// spend reports whether a retry is within the budget and, if so, charges it.
+func (b *retryBudget) spend() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// retryDelay returns the capped exponential backoff for the given zero-based
+// attempt, plus up to retryJitterFactor of it at random.
+func (c *Cache) retryDelay(attempt int) time.Duration {
+	// base * 2^attempt, capped (and guarded against shift overflow).
+	delay := c.retryBackoff
+	for n := 0; n < attempt && delay < c.retryBackoffCap; n++ {
+		delay *= 2
+	}
+
+	if delay > c.retryBackoffCap {
+		delay = c.retryBackoffCap
+	}
+
+	//nolint:gosec // G404: math/rand is acceptable for jitter, doesn't need crypto-grade randomness
+	return delay + time.Duration(mathrand.Float64()*retryJitterFactor*float64(delay))
+}
+
+// WithRequestBudget marks the deadline of ctx as the time budget of the client
+// request being served. The attempts of a request within a budget share what
+// is left of it: each one is given the time left divided by the attempts left,
+// so a hung attempt leaves time for a retry rather than exhausting the budget.
+func WithRequestBudget(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestBudgetKey{}, struct{}{})
+}
+
+// attemptTimeout returns the timeout of an attempt with attemptsLeft attempts
+// left, itself included: the per-try timeout, shrunk to the share of the
+// request budget of the attempt. Zero leaves the attempt unbounded.
+func (c *Cache) attemptTimeout(ctx context.Context, attemptsLeft int) time.Duration {
+	timeout := c.retryPerTryTimeout
+
+	if ctx.Value(requestBudgetKey{}) == nil {
+		return timeout
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return timeout
+	}
+
+	share := time.Until(deadline) / time.Duration(max(attemptsLeft, 1))
+	if share <= 0 {
+		// The request fails on its own deadline.
+		return timeout
+	}
+
+	if timeout <= 0 || share < timeout {
+		return share
+	}
+
+	return timeout
+}
+
+// send performs a single attempt of the request. With a timeout, the attempt
+// is canceled if its response headers do not arrive in time; the body is then
+// read under the request's own context.
+func (c *Cache) send(r *http.Request, timeout time.Duration) (*http.Response, error) {
+	if timeout <= 0 {
+		return c.httpClient.Do(r)
+	}
+
+	ctx, cancel := context.WithCancelCause(r.Context())
+	timer := time.AfterFunc(timeout, func() { cancel(errAttemptTimeout) })
+
+	resp, err := c.httpClient.Do(r.WithContext(ctx))
+
+	// The timer fired after the headers arrived: the body is already canceled.
+	if !timer.Stop() && err == nil {
+		resp.Body.Close()
+		cancel(nil)
+
+		return nil, fmt.Errorf("%w after %s", errAttemptTimeout, timeout)
+	}
+
+	if err != nil {
+		timedOut := errors.Is(context.Cause(ctx), errAttemptTimeout)
+
+		cancel(nil)
+
+		if timedOut {
+			return nil, fmt.Errorf("%w after %s: %w", errAttemptTimeout, timeout, err)
+		}
+
+		return nil, err
+	}
+
+	resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+
+	return resp, nil
+}
+
+// cancelOnCloseBody releases the context of an attempt once its body is
+// closed.
+type cancelOnCloseBody struct {
+	io.ReadCloser
+
+	cancel context.CancelCauseFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+
+	b.cancel(nil)
+
+	return err
+}
@@ -0,0 +1,187 @@
+package upstream_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+// statusThenOKRoundTripper answers the first request with the configured
+// status, then serves the Nar1 narinfo fixture.
+type statusThenOKRoundTripper struct {
+	status int
+	count  int
+}
+
+func (s *statusThenOKRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.count++
+
+	status, body := http.StatusOK, testdata.Nar1.NarInfoText
+	if s.count == 1 {
+		status, body = s.status, ""
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestDoRequest_RetriableStatuses(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		status    int
+		wantCount int
+	}{
+		{status: http.StatusTooManyRequests, wantCount: 2},
+		{status: http.StatusBadGateway, wantCount: 2},
+		{status: http.StatusServiceUnavailable, wantCount: 2},
+		{status: http.StatusGatewayTimeout, wantCount: 2},
+		{status: http.StatusBadRequest, wantCount: 1},
+		{status: http.StatusForbidden, wantCount: 1},
+		{status: http.StatusInternalServerError, wantCount: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			t.Parallel()
+
+			rt := &statusThenOKRoundTripper{status: tc.status}
+			c, err := upstream.New(
+				context.Background(),
+				testhelper.MustParseURL(t, "https://cache.nixos.org"),
+				&upstream.Options{Transport: rt, RetryBackoff: time.Millisecond},
+			)
+			require.NoError(t, err)
+
+			_, err = c.GetNarInfo(context.Background(), "hash")
+			if tc.wantCount > 1 {
+				require.NoError(t, err, "a transient status should be retried and then succeed")
+			} else {
+				require.ErrorIs(t, err, upstream.ErrUnexpectedHTTPStatusCode)
+			}
+
+			assert.Equal(t, tc.wantCount, rt.count)
+		})
+	}
+}
+
+func TestDoRequest_RetryAttempts(t *testing.T) {
+	t.Parallel()
+
+	for _, attempts := range []int{1, 5} {
+		rt := &statusRoundTripper{status: http.StatusServiceUnavailable}
+		c, err := upstream.New(
+			context.Background(),
+			testhelper.MustParseURL(t, "https://cache.nixos.org"),
+			&upstream.Options{Transport: rt, RetryBackoff: time.Millisecond, RetryAttempts: attempts},
+		)
+		require.NoError(t, err)
+
+		_, err = c.GetNarInfo(context.Background(), "hash")
+		require.ErrorIs(t, err, upstream.ErrUnexpectedHTTPStatusCode,
+			"the status of the final attempt is returned")
+		assert.Equal(t, attempts, rt.count)
+	}
+}
+
+// hangOnceRoundTripper blocks its first request until the request is canceled,
+// then serves the Nar1 narinfo fixture.
+type hangOnceRoundTripper struct {
+	count int
+}
+
+func (h *hangOnceRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	h.count++
+	if h.count == 1 {
+		<-req.Context().Done()
+
+		return nil, req.Context().Err()
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(testdata.Nar1.NarInfoText)),
+		Request:    req,
+	}, nil
+}
+
+func TestDoRequest_PerTryTimeout(t *testing.T) {
+	t.Parallel()
+
+	rt := &hangOnceRoundTripper{}
+	c, err := upstream.New(
+		context.Background(),
+		testhelper.MustParseURL(t, "https://cache.nixos.org"),
+		&upstream.Options{
+			Transport:          rt,
+			RetryBackoff:       time.Millisecond,
+			RetryPerTryTimeout: 50 * time.Millisecond,
+		},
+	)
+	require.NoError(t, err)
+
+	_, err = c.GetNarInfo(context.Background(), "hash")
+	require.NoError(t, err, "an attempt exceeding the per-try timeout should be retried")
+	assert.Equal(t, 2, rt.count)
+}
+
+func TestDoRequest_RequestBudget(t *testing.T) {
+	t.Parallel()
+
+	rt := &hangOnceRoundTripper{}
+	c, err := upstream.New(
+		context.Background(),
+		testhelper.MustParseURL(t, "https://cache.nixos.org"),
+		&upstream.Options{Transport: rt, RetryBackoff: time.Millisecond},
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(upstream.WithRequestBudget(context.Background()), time.Second)
+	defer cancel()
+
+	start := time.Now()
+
+	_, err = c.GetNarInfo(ctx, "hash")
+	require.NoError(t, err, "the hung attempt should leave budget for a retry")
+	assert.Equal(t, 2, rt.count)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestDoRequest_RetryBudget(t *testing.T) {
+	t.Parallel()
+
+	rt := &alwaysTransientRoundTripper{}
+	c, err := upstream.New(
+		context.Background(),
+		testhelper.MustParseURL(t, "https://cache.nixos.org"),
+		&upstream.Options{Transport: rt, RetryBackoff: time.Millisecond, RetryBudget: 0.01},
+	)
+	require.NoError(t, err)
+
+	// The burst of 10 retries is spent by the first five requests.
+	for range 5 {
+		_, err = c.GetNarInfo(context.Background(), "hash")
+		require.Error(t, err)
+	}
+
+	assert.Equal(t, 15, rt.count)
+
+	_, err = c.GetNarInfo(context.Background(), "hash")
+	require.Error(t, err)
+	assert.Equal(t, 16, rt.count, "a request over budget is not retried")
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog"
+)
+
+// RefreshUpstreamNarInfoBlooms fetches the bloom filter of the narinfos held by
+// every upstream serving one, the peer ncps instances with a narinfo index, so
+// they are only asked for the narinfos their filter does not rule out. It
+// returns the number of upstreams serving a filter.
+func (c *Cache) RefreshUpstreamNarInfoBlooms(ctx context.Context) int {
+	c.upstreamCachesMu.RLock()
+	ucs := slices.Clone(c.upstreamCaches)
+	c.upstreamCachesMu.RUnlock()
+
+	var peers int
+
+	for _, uc := range ucs {
+		served, err := uc.RefreshNarInfoBloom(ctx)
+		if err != nil {
+			zerolog.Ctx(ctx).
+				Warn().
+				Err(err).
+				Str("hostname", uc.GetHostname()).
+				Msg("error fetching the narinfo bloom filter of the upstream; keeping the last one")
+		}
+
+		if served {
+			peers++
+		}
+	}
+
+	return peers
+}
+
+// AddUpstreamNarInfoBloomCronJob adds a periodic job refreshing the bloom
+// filters of the upstream caches.
+func (c *Cache) AddUpstreamNarInfoBloomCronJob(ctx context.Context, schedule cron.Schedule) {
+	zerolog.Ctx(ctx).
+		Info().
+		Time("next-run", schedule.Next(time.Now())).
+		Msg("adding a cronjob for the upstream narinfo bloom filters")
+
+	c.scheduleCronJob(ctx, CronJobUpstreamBloom, schedule, func(ctx context.Context) func() {
+		return func() {
+			peers := c.RefreshUpstreamNarInfoBlooms(ctx)
+
+			zerolog.Ctx(ctx).
+				Debug().
+				Int("peers", peers).
+				Msg("refreshed the upstream narinfo bloom filters")
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/cache/upstream/discovery"
+)
+
+// UpstreamFactory builds an upstream cache for an upstream returned by a
+// discovery source.
+type UpstreamFactory func(ctx context.Context, du discovery.Upstream) (*upstream.Cache, error)
+
+// upstreamDiscovery tracks the upstream caches managed by a discovery source so
+// that a refresh only ever adds or removes those, never the static ones.
+type upstreamDiscovery struct {
+	// mu serializes refreshes and protects discovered.
+	mu         sync.Mutex
+	source     discovery.Source
+	factory    UpstreamFactory
+	discovered map[string]*upstream.Cache
+}
+
+// SetUpstreamDiscovery configures the source used to discover upstream caches
+// at runtime and the factory used to build them. It must be called before
+// RefreshDiscoveredUpstreams or AddUpstreamDiscoveryCronJob.
+func (c *Cache) SetUpstreamDiscovery(source discovery.Source, factory UpstreamFactory) {
+	c.upstreamDiscovery = &upstreamDiscovery{
+		source:     source,
+		factory:    factory,
+		discovered: make(map[string]*upstream.Cache),
+	}
+}
+
+// RemoveUpstreamCaches removes one or more upstream caches and stops monitoring
+// their health.
+func (c *Cache) RemoveUpstreamCaches(ctx context.Context, ucs ...*upstream.Cache) {
+	hostnames := make([]string, 0, len(ucs))
+
+	for _, uc := range ucs {
+		hostnames = append(hostnames, uc.GetHostname())
+	}
+
+	zerolog.Ctx(ctx).
+		Debug().
+		Strs("hostnames", hostnames).
+		Msg("removing upstream caches")
+
+	c.upstreamCachesMu.Lock()
+	c.upstreamCaches = slices.DeleteFunc(c.upstreamCaches, func(u *upstream.Cache) bool {
+		return slices.Contains(ucs, u)
+	})
+	c.upstreamCachesMu.Unlock()
+
+	for _, uc := range ucs {
+		c.healthChecker.RemoveUpstream(uc)
+	}
+}
+
+// RefreshDiscoveredUpstreams queries the discovery source and reconciles the
+// upstream caches it manages: new upstreams are added and upstreams no longer
+// advertised are removed. Upstreams configured statically are never touched.
+// On error the current set is kept as is. It reports whether the set changed;
+// newly added upstreams stay unhealthy until the next health check.
+func (c *Cache) RefreshDiscoveredUpstreams(ctx context.Context) (bool, error) {
+	ud := c.upstreamDiscovery
+	if ud == nil {
+		return false, nil
+	}
+
+	ud.mu.Lock()
+	defer ud.mu.Unlock()
+
+	dus, err := ud.source.Discover(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	wanted := make(map[string]discovery.Upstream, len(dus))
+	for _, du := range dus {
+		wanted[discoveredUpstreamKey(du)] = du
+	}
+
+	var removed []*upstream.Cache
+
+	for key, uc := range ud.discovered {
+		if _, ok := wanted[key]; !ok {
+			removed = append(removed, uc)
+
+			delete(ud.discovered, key)
+		}
+	}
+
+	var added []*upstream.Cache
+
+	for key, du := range wanted {
+		if _, ok := ud.discovered[key]; ok {
+			continue
+		}
+
+		uc, err := ud.factory(ctx, du)
+		if err != nil {
+			zerolog.Ctx(ctx).
+				Error().
+				Err(err).
+				Str("upstream_url", du.URL.String()).
+				Msg("error creating a discovered upstream cache")
+
+			continue
+		}
+
+		ud.discovered[key] = uc
+		added = append(added, uc)
+	}
+
+	if len(removed) > 0 {
+		c.RemoveUpstreamCaches(ctx, removed...)
+	}
+
+	if len(added) > 0 {
+		c.AddUpstreamCaches(ctx, added...)
+	}
+
+	return len(added) > 0 || len(removed) > 0, nil
+}
+
+// AddUpstreamDiscoveryCronJob adds a periodic job refreshing the upstream caches
+// from the discovery source configured with SetUpstreamDiscovery.
+func (c *Cache) AddUpstreamDiscoveryCronJob(ctx context.Context, schedule cron.Schedule) {
+	zerolog.Ctx(ctx).
+		Info().
+		Time("next-run", schedule.Next(time.Now())).
+		Msg("adding a cronjob for upstream discovery")
+
+	c.scheduleCronJob(ctx, CronJobUpstreamDiscovery, schedule, c.runUpstreamDiscovery)
+}
+
+func (c *Cache) runUpstreamDiscovery(ctx context.Context) func() {
+	return func() {
+		changed, err := c.RefreshDiscoveredUpstreams(ctx)
+		if err != nil {
+			zerolog.Ctx(ctx).
+				Warn().
+				Err(err).
+				Msg("error refreshing the discovered upstream caches; keeping the current set")
+
+			recordCronJobError(ctx, err)
+
+			return
+		}
+
+		if changed {
+			// Probe the new upstreams now instead of waiting for the next tick.
+			<-c.healthChecker.Trigger()
+		}
+	}
+}
+
+// discoveredUpstreamKey identifies a discovered upstream by its URL and keys so
+// a key rotation replaces the upstream cache.
+func discoveredUpstreamKey(du discovery.Upstream) string {
+	keys := slices.Clone(du.PublicKeys)
+	slices.Sort(keys)
+
+	return du.URL.String() + "|" + strings.Join(keys, ",")
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/cache/upstream/discovery"
+)
+
+var errDiscoveryUnavailable = errors.New("discovery unavailable")
+
+// fakeDiscoverySource returns whatever upstream URLs (or error) were last set.
+type fakeDiscoverySource struct {
+	mu   sync.Mutex
+	urls []string
+	err  error
+}
+
+func (f *fakeDiscoverySource) set(err error, urls ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.urls, f.err = urls, err
+}
+
+func (f *fakeDiscoverySource) String() string { return "fake" }
+
+func (f *fakeDiscoverySource) Discover(context.Context) ([]discovery.Upstream, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	ups := make([]discovery.Upstream, 0, len(f.urls))
+
+	for _, raw := range f.urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		ups = append(ups, discovery.Upstream{URL: u})
+	}
+
+	return ups, nil
+}
+
+func upstreamURLs(c *Cache) []string {
+	c.upstreamCachesMu.RLock()
+	defer c.upstreamCachesMu.RUnlock()
+
+	out := make([]string, 0, len(c.upstreamCaches))
+	for _, uc := range c.upstreamCaches {
+		out = append(out, uc.GetURL())
+	}
+
+	return out
+}
+
+func TestRefreshDiscoveredUpstreams(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	ctx := context.Background()
+
+	staticURL, err := url.Parse("https://static.example.com")
+	require.NoError(t, err)
+
+	static, err := upstream.New(ctx, staticURL, nil)
+	require.NoError(t, err)
+
+	c.AddUpstreamCaches(ctx, static)
+
+	src := &fakeDiscoverySource{}
+	c.SetUpstreamDiscovery(src, func(ctx context.Context, du discovery.Upstream) (*upstream.Cache, error) {
+		return upstream.New(ctx, du.URL, &upstream.Options{PublicKeys: du.PublicKeys})
+	})
+
+	// Initial discovery adds both upstreams alongside the static one.
+	src.set(nil, "https://a.example.com", "https://b.example.com")
+
+	changed, err := c.RefreshDiscoveredUpstreams(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.ElementsMatch(t,
+		[]string{"https://static.example.com", "https://a.example.com", "https://b.example.com"},
+		upstreamURLs(c))
+
+	// The same set is a no-op.
+	changed, err = c.RefreshDiscoveredUpstreams(ctx)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// A failing source keeps the current set.
+	src.set(errDiscoveryUnavailable)
+
+	_, err = c.RefreshDiscoveredUpstreams(ctx)
+	require.ErrorIs(t, err, errDiscoveryUnavailable)
+	assert.Len(t, upstreamURLs(c), 3)
+
+	// Rotation removes b and adds c but never touches the static upstream.
+	src.set(nil, "https://a.example.com", "https://c.example.com")
+
+	changed, err = c.RefreshDiscoveredUpstreams(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.ElementsMatch(t,
+		[]string{"https://static.example.com", "https://a.example.com", "https://c.example.com"},
+		upstreamURLs(c))
+
+	// An empty answer removes every discovered upstream.
+	src.set(nil)
+
+	_, err = c.RefreshDiscoveredUpstreams(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://static.example.com"}, upstreamURLs(c))
+}
@@ -0,0 +1,248 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/kalbasit/ncps/pkg/analytics"
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/helper"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+// UpstreamFetchStrategy selects how a NAR is fetched when several upstreams
+// may serve it.
+type UpstreamFetchStrategy string
+
+const (
+	// UpstreamFetchStrategySelect asks every healthy upstream whether it has the
+	// NAR and downloads it from the first one to answer. It is the default.
+	UpstreamFetchStrategySelect UpstreamFetchStrategy = "select"
+
+	// UpstreamFetchStrategyRace downloads the NAR from the top raceUpstreamCount
+	// healthy upstreams at once and keeps the first one to deliver a byte,
+	// canceling the others. It trades upstream bandwidth for latency when
+	// upstreams are flaky.
+	UpstreamFetchStrategyRace UpstreamFetchStrategy = "race"
+
+	// raceUpstreamCount is the number of upstreams raced by
+	// UpstreamFetchStrategyRace.
+	raceUpstreamCount = 2
+)
+
+// ErrUnknownUpstreamFetchStrategy is returned by ParseUpstreamFetchStrategy for
+// an unknown strategy.
+var ErrUnknownUpstreamFetchStrategy = errors.New("unknown upstream fetch strategy (allowed: select, race)")
+
+// ParseUpstreamFetchStrategy parses the name of an UpstreamFetchStrategy. The
+// empty string is the default strategy.
+func ParseUpstreamFetchStrategy(s string) (UpstreamFetchStrategy, error) {
+	switch UpstreamFetchStrategy(s) {
+	case "", UpstreamFetchStrategySelect:
+		return UpstreamFetchStrategySelect, nil
+	case UpstreamFetchStrategyRace:
+		return UpstreamFetchStrategyRace, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownUpstreamFetchStrategy, s)
+	}
+}
+
+// SetUpstreamFetchStrategy sets how NARs are fetched from the upstreams.
+func (c *Cache) SetUpstreamFetchStrategy(strategy UpstreamFetchStrategy) {
+	c.upstreamFetchStrategy = strategy
+}
+
+// raceCandidates returns the upstreams to race for a NAR, or nil when racing
+// does not apply. preferred, the upstream that served the narinfo, is always
+// raced when set.
+func (c *Cache) raceCandidates(narURL *nar.URL, preferred *upstream.Cache) []*upstream.Cache {
+	// An opaque URL (e.g. a cachix UUID NAR) only exists on its own upstream.
+	if c.upstreamFetchStrategy != UpstreamFetchStrategyRace || narURL.IsOpaque() {
+		return nil
+	}
+
+	candidates := make([]*upstream.Cache, 0, raceUpstreamCount)
+	if preferred != nil {
+		candidates = append(candidates, preferred)
+	}
+
+	for _, uc := range c.getHealthyUpstreams() {
+		if len(candidates) == raceUpstreamCount {
+			break
+		}
+
+		if uc != preferred {
+			candidates = append(candidates, uc)
+		}
+	}
+
+	if len(candidates) < 2 {
+		return nil
+	}
+
+	return candidates
+}
+
+type raceResult struct {
+	idx   int
+	resp  *http.Response
+	first []byte
+	err   error
+}
+
+// raceNarFromUpstreams requests the NAR from every upstream in ucs at once and
+// returns the response of the first one to deliver its first byte. The other
+// requests are canceled and their bodies closed. storage.ErrNotFound is
+// returned when no upstream has the NAR.
+func (c *Cache) raceNarFromUpstreams(
+	ctx context.Context,
+	narURL *nar.URL,
+	ucs []*upstream.Cache,
+) (*http.Response, error) {
+	span := trace.SpanFromContext(ctx)
+
+	cancels := make([]context.CancelFunc, len(ucs))
+	results := make(chan raceResult, len(ucs))
+
+	for i, uc := range ucs {
+		rctx, cancel := context.WithCancel(ctx)
+		cancels[i] = cancel
+
+		analytics.SafeGo(rctx, func() {
+			results <- fetchFirstByte(rctx, i, uc, narURL)
+		})
+	}
+
+	var (
+		winner  *raceResult
+		errs    []error
+		pending = len(ucs)
+	)
+
+	for winner == nil && pending > 0 {
+		r := <-results
+		pending--
+
+		if r.err != nil {
+			cancels[r.idx]()
+
+			errs = append(errs, r.err)
+
+			continue
+		}
+
+		winner = &r
+	}
+
+	if winner == nil {
+		return nil, raceError(errs)
+	}
+
+	for i, cancel := range cancels {
+		if i != winner.idx {
+			cancel()
+		}
+	}
+
+	// Release the losers that got a response before being canceled.
+	if pending > 0 {
+		analytics.SafeGo(ctx, func() {
+			for range pending {
+				if r := <-results; r.resp != nil {
+					r.resp.Body.Close()
+				}
+			}
+		})
+	}
+
+	uc := ucs[winner.idx]
+
+	span.SetAttributes(attribute.String("race_winner", uc.GetHostname()))
+
+	zerolog.Ctx(ctx).
+		Debug().
+		Str("hostname", uc.GetHostname()).
+		Msg("won the race for the nar")
+
+	resp := winner.resp
+	body := resp.Body
+	resp.Body = helper.NewMultiReadCloser(
+		io.MultiReader(bytes.NewReader(winner.first), body),
+		body,
+		cancelCloser(cancels[winner.idx]),
+	)
+
+	return resp, nil
+}
+
+// fetchFirstByte requests the NAR from uc and reads its first byte, which is
+// what the race is decided on.
+func fetchFirstByte(ctx context.Context, idx int, uc *upstream.Cache, narURL *nar.URL) raceResult {
+	ttfbStart := time.Now()
+
+	resp, err := uc.GetNar(ctx, *narURL)
+
+	var first []byte
+
+	if err == nil {
+		buf := make([]byte, 1)
+
+		n, rerr := io.ReadFull(resp.Body, buf)
+		if rerr != nil && !errors.Is(rerr, io.EOF) {
+			resp.Body.Close()
+
+			resp, err = nil, fmt.Errorf("error reading the first byte of the nar: %w", rerr)
+		}
+
+		first = buf[:n]
+	}
+
+	// A loser canceled by the winner is not an upstream failure.
+	if !errors.Is(err, context.Canceled) {
+		upstreamNarTTFB.Record(ctx, time.Since(ttfbStart).Seconds(), metric.WithAttributes(
+			attribute.String("upstream_hostname", uc.GetHostname()),
+			attribute.String("compression", narURL.Compression.String()),
+			attribute.String("result", streamResultFromError(err)),
+		))
+	}
+
+	return raceResult{idx: idx, resp: resp, first: first, err: err}
+}
+
+// raceError returns the error of a race every upstream lost: the upstream
+// failures if any, storage.ErrNotFound when no upstream has the NAR.
+func raceError(errs []error) error {
+	var failures []error
+
+	for _, err := range errs {
+		if !errors.Is(err, upstream.ErrNotFound) {
+			failures = append(failures, err)
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.Join(failures...)
+	}
+
+	return storage.ErrNotFound
+}
+
+// cancelCloser cancels a context when closed.
+type cancelCloser context.CancelFunc
+
+func (cc cancelCloser) Close() error {
+	cc()
+
+	return nil
+}
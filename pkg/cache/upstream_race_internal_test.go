@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestParseUpstreamFetchStrategy(t *testing.T) {
+	t.Parallel()
+
+	for in, want := range map[string]UpstreamFetchStrategy{
+		"":       UpstreamFetchStrategySelect,
+		"select": UpstreamFetchStrategySelect,
+		"race":   UpstreamFetchStrategyRace,
+	} {
+		got, err := ParseUpstreamFetchStrategy(in)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := ParseUpstreamFetchStrategy("fastest")
+	require.ErrorIs(t, err, ErrUnknownUpstreamFetchStrategy)
+}
+
+func TestRaceNarFromUpstreams(t *testing.T) {
+	t.Parallel()
+
+	c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+	t.Cleanup(cleanup)
+
+	c.SetUpstreamFetchStrategy(UpstreamFetchStrategyRace)
+
+	// The slow upstream has the higher priority but holds the nar back until
+	// its request is canceled.
+	slow := testdata.NewTestServer(t, 10)
+	t.Cleanup(slow.Close)
+
+	var cancelOnce sync.Once
+
+	slowCanceled := make(chan struct{})
+
+	slow.AddMaybeHandler(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, "/nar/"+testdata.Nar1.NarHash) {
+			return false
+		}
+
+		select {
+		case <-r.Context().Done():
+			cancelOnce.Do(func() { close(slowCanceled) })
+		case <-time.After(10 * time.Second):
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+
+		return true
+	})
+
+	fast := testdata.NewTestServer(t, 20)
+	t.Cleanup(fast.Close)
+
+	for _, ts := range []*testdata.Server{slow, fast} {
+		uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, ts.URL), nil)
+		require.NoError(t, err)
+
+		c.AddUpstreamCaches(newContext(), uc)
+	}
+
+	<-c.GetHealthChecker().Trigger()
+
+	narURL := &nar.URL{Hash: testdata.Nar1.NarHash, Compression: testdata.Nar1.NarCompression}
+
+	t.Run("the first upstream to deliver a byte wins", func(t *testing.T) {
+		t.Parallel()
+
+		candidates := c.raceCandidates(narURL, nil)
+		require.Len(t, candidates, 2)
+
+		resp, err := c.raceNarFromUpstreams(newContext(), narURL, candidates)
+		require.NoError(t, err)
+
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, testdata.Nar1.NarText, string(body))
+
+		select {
+		case <-slowCanceled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the slow upstream was not canceled")
+		}
+	})
+
+	t.Run("a nar missing everywhere is not found", func(t *testing.T) {
+		t.Parallel()
+
+		missing := &nar.URL{Hash: strings.Repeat("1", 52), Compression: nar.CompressionTypeXz}
+
+		_, err := c.raceNarFromUpstreams(newContext(), missing, c.raceCandidates(missing, nil))
+		require.ErrorIs(t, err, storage.ErrNotFound)
+	})
+}
@@ -0,0 +1,385 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/kalbasit/ncps/pkg/analytics"
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+)
+
+const (
+	shadowOutcomeAgree    = "agree"
+	shadowOutcomeDisagree = "disagree"
+	shadowOutcomeError    = "error"
+
+	shadowRoleShadow  = "shadow"
+	shadowRolePrimary = "primary"
+
+	// shadowFetchTimeout bounds the narinfo fetch of a shadow upstream, which
+	// outlives the request it shadows.
+	shadowFetchTimeout = 30 * time.Second
+)
+
+// ErrShadowUpstreamNotFound is returned by PromoteShadowUpstream for a
+// hostname that is not a shadow upstream.
+var ErrShadowUpstreamNotFound = errors.New("shadow upstream not found")
+
+// ShadowTrial configures when a shadow upstream is promoted to selection.
+type ShadowTrial struct {
+	// Period is how long the shadow upstream is only compared before it may be
+	// promoted. Zero never promotes it automatically; see
+	// PromoteShadowUpstream.
+	Period time.Duration
+
+	// MinComparisons is the number of answers compared before it may be
+	// promoted.
+	MinComparisons int64
+
+	// MinAgreement is the ratio, between 0 and 1, of its answers agreeing with
+	// the served ones required for it to be promoted.
+	MinAgreement float64
+}
+
+// ShadowUpstreamStats are the statistics of a shadow upstream.
+type ShadowUpstreamStats struct {
+	Hostname string
+	URL      string
+
+	// Since is when the shadow upstream was added, and TrialEndsAt when it may
+	// be promoted, or zero if it is never promoted automatically.
+	Since       time.Time
+	TrialEndsAt time.Time
+
+	Comparisons   int64
+	Agreements    int64
+	Disagreements int64
+	Errors        int64
+
+	// ShadowLatency and PrimaryLatency are the mean times to fetch the
+	// narinfos compared from the shadow upstream and from the upstreams
+	// serving them.
+	ShadowLatency  time.Duration
+	PrimaryLatency time.Duration
+}
+
+// Agreement returns the ratio of the comparisons in agreement, or zero before
+// the first one.
+func (s ShadowUpstreamStats) Agreement() float64 {
+	if s.Comparisons == 0 {
+		return 0
+	}
+
+	return float64(s.Agreements) / float64(s.Comparisons)
+}
+
+// shadowUpstream is an upstream queried alongside the selected one, whose
+// answers are compared but never served.
+type shadowUpstream struct {
+	uc    *upstream.Cache
+	since time.Time
+	trial ShadowTrial
+
+	mu             sync.Mutex
+	promoted       bool
+	comparisons    int64
+	agreements     int64
+	disagreements  int64
+	errors         int64
+	shadowLatency  time.Duration
+	primaryLatency time.Duration
+}
+
+// shadowAnswer is the narinfo of a hash fetched from an upstream. A nil
+// narInfo with a nil err means the upstream does not have it.
+type shadowAnswer struct {
+	narInfo *narinfo.NarInfo
+	err     error
+	latency time.Duration
+}
+
+// AddShadowUpstreamCaches adds upstream caches in shadow mode: every narinfo
+// fetched from the upstreams is also fetched from them, in parallel, and their
+// answers are compared with the one served, recording the agreement and the
+// latency, but never served. Once the trial period is elapsed and the trial
+// requirements are met, a shadow upstream is promoted to selection as a
+// regular upstream cache.
+func (c *Cache) AddShadowUpstreamCaches(ctx context.Context, trial ShadowTrial, ucs ...*upstream.Cache) {
+	now := time.Now()
+
+	c.shadowUpstreamsMu.Lock()
+	defer c.shadowUpstreamsMu.Unlock()
+
+	for _, uc := range ucs {
+		zerolog.Ctx(ctx).
+			Info().
+			Str("hostname", uc.GetHostname()).
+			Dur("trial_period", trial.Period).
+			Msg("adding a shadow upstream cache")
+
+		c.shadowUpstreams = append(c.shadowUpstreams, &shadowUpstream{uc: uc, since: now, trial: trial})
+	}
+}
+
+// ShadowUpstreamStats returns the statistics of the shadow upstreams.
+func (c *Cache) ShadowUpstreamStats() []ShadowUpstreamStats {
+	c.shadowUpstreamsMu.RLock()
+	defer c.shadowUpstreamsMu.RUnlock()
+
+	stats := make([]ShadowUpstreamStats, 0, len(c.shadowUpstreams))
+	for _, su := range c.shadowUpstreams {
+		stats = append(stats, su.stats())
+	}
+
+	return stats
+}
+
+// PromoteShadowUpstream promotes the shadow upstream at hostname to selection,
+// regardless of its trial.
+func (c *Cache) PromoteShadowUpstream(ctx context.Context, hostname string) error {
+	c.shadowUpstreamsMu.RLock()
+	idx := slices.IndexFunc(c.shadowUpstreams, func(su *shadowUpstream) bool {
+		return su.uc.GetHostname() == hostname
+	})
+
+	var su *shadowUpstream
+	if idx >= 0 {
+		su = c.shadowUpstreams[idx]
+	}
+	c.shadowUpstreamsMu.RUnlock()
+
+	if su == nil {
+		return fmt.Errorf("%w: %s", ErrShadowUpstreamNotFound, hostname)
+	}
+
+	c.promoteShadowUpstream(ctx, su)
+
+	return nil
+}
+
+// shadowNarInfo fetches the narinfo of hash from every shadow upstream, in
+// the background. It returns the function reporting the answer served, to
+// which the shadow answers are compared, or nil without shadow upstreams.
+// The function must be called exactly once; a nil answer skips the
+// comparison.
+func (c *Cache) shadowNarInfo(ctx context.Context, hash string) func(primary *shadowAnswer) {
+	c.shadowUpstreamsMu.RLock()
+	shadows := slices.Clone(c.shadowUpstreams)
+	c.shadowUpstreamsMu.RUnlock()
+
+	if len(shadows) == 0 {
+		return nil
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	primaryCh := make(chan *shadowAnswer, 1)
+
+	var once sync.Once
+
+	var primary *shadowAnswer
+
+	// waitPrimary returns the answer served, which every shadow waits for.
+	waitPrimary := func() *shadowAnswer {
+		once.Do(func() { primary = <-primaryCh })
+
+		return primary
+	}
+
+	for _, su := range shadows {
+		c.backgroundWG.Add(1)
+
+		analytics.SafeGo(ctx, func() {
+			defer c.backgroundWG.Done()
+
+			fetchCtx, cancel := context.WithTimeout(ctx, shadowFetchTimeout)
+			answer := fetchShadowAnswer(fetchCtx, su.uc, hash)
+
+			cancel()
+
+			p := waitPrimary()
+			if p == nil {
+				return
+			}
+
+			c.recordShadowComparison(ctx, su, hash, p, answer)
+		})
+	}
+
+	return func(primary *shadowAnswer) { primaryCh <- primary }
+}
+
+func fetchShadowAnswer(ctx context.Context, uc *upstream.Cache, hash string) *shadowAnswer {
+	start := time.Now()
+
+	narInfo, err := uc.GetNarInfo(ctx, hash)
+	if errors.Is(err, upstream.ErrNotFound) {
+		err = nil
+	}
+
+	return &shadowAnswer{narInfo: narInfo, err: err, latency: time.Since(start)}
+}
+
+// recordShadowComparison compares the answer of the shadow su with the one
+// served and promotes su once its trial is passed.
+func (c *Cache) recordShadowComparison(
+	ctx context.Context,
+	su *shadowUpstream,
+	hash string,
+	primary, shadow *shadowAnswer,
+) {
+	outcome := compareShadowAnswers(primary, shadow)
+
+	hostname := attribute.String("upstream", su.uc.GetHostname())
+
+	if shadowComparisonsTotal != nil {
+		shadowComparisonsTotal.Add(ctx, 1, metric.WithAttributes(hostname, attribute.String("outcome", outcome)))
+	}
+
+	if shadowNarInfoDuration != nil && outcome != shadowOutcomeError {
+		shadowNarInfoDuration.Record(ctx, shadow.latency.Seconds(),
+			metric.WithAttributes(hostname, attribute.String("role", shadowRoleShadow)))
+		shadowNarInfoDuration.Record(ctx, primary.latency.Seconds(),
+			metric.WithAttributes(hostname, attribute.String("role", shadowRolePrimary)))
+	}
+
+	if outcome == shadowOutcomeDisagree {
+		zerolog.Ctx(ctx).
+			Debug().
+			Str("hostname", su.uc.GetHostname()).
+			Str("narinfo_hash", hash).
+			Msg("the shadow upstream disagrees with the narinfo served")
+	}
+
+	if su.record(outcome, primary.latency, shadow.latency) {
+		c.promoteShadowUpstream(ctx, su)
+	}
+}
+
+// compareShadowAnswers returns whether shadow agrees with primary: both lack
+// the narinfo, or both have it for the same NAR.
+func compareShadowAnswers(primary, shadow *shadowAnswer) string {
+	if shadow.err != nil {
+		return shadowOutcomeError
+	}
+
+	switch {
+	case primary.narInfo == nil && shadow.narInfo == nil:
+		return shadowOutcomeAgree
+	case primary.narInfo == nil || shadow.narInfo == nil:
+		return shadowOutcomeDisagree
+	case primary.narInfo.StorePath == shadow.narInfo.StorePath &&
+		sameNarHash(primary.narInfo, shadow.narInfo) &&
+		primary.narInfo.NarSize == shadow.narInfo.NarSize:
+		return shadowOutcomeAgree
+	default:
+		return shadowOutcomeDisagree
+	}
+}
+
+func sameNarHash(a, b *narinfo.NarInfo) bool {
+	if a.NarHash == nil || b.NarHash == nil {
+		return a.NarHash == b.NarHash
+	}
+
+	// The upstreams may encode the same hash differently.
+	return a.NarHash.Algo() == b.NarHash.Algo() && bytes.Equal(a.NarHash.Digest(), b.NarHash.Digest())
+}
+
+// promoteShadowUpstream moves su from the shadow upstreams to the upstream
+// caches. It is a no-op if su was already promoted.
+func (c *Cache) promoteShadowUpstream(ctx context.Context, su *shadowUpstream) {
+	su.mu.Lock()
+	promoted := su.promoted
+	su.promoted = true
+	stats := su.statsLocked()
+	su.mu.Unlock()
+
+	if promoted {
+		return
+	}
+
+	c.shadowUpstreamsMu.Lock()
+	c.shadowUpstreams = slices.DeleteFunc(c.shadowUpstreams, func(s *shadowUpstream) bool { return s == su })
+	c.shadowUpstreamsMu.Unlock()
+
+	zerolog.Ctx(ctx).
+		Info().
+		Str("hostname", su.uc.GetHostname()).
+		Int64("comparisons", stats.Comparisons).
+		Float64("agreement", stats.Agreement()).
+		Msg("promoting the shadow upstream cache to selection")
+
+	c.AddUpstreamCaches(ctx, su.uc)
+	c.healthChecker.Trigger()
+}
+
+// record counts a comparison and reports whether su just passed its trial.
+func (su *shadowUpstream) record(outcome string, primaryLatency, shadowLatency time.Duration) bool {
+	su.mu.Lock()
+	defer su.mu.Unlock()
+
+	switch outcome {
+	case shadowOutcomeAgree:
+		su.agreements++
+	case shadowOutcomeDisagree:
+		su.disagreements++
+	default:
+		su.errors++
+
+		return false
+	}
+
+	su.comparisons++
+	su.primaryLatency += primaryLatency
+	su.shadowLatency += shadowLatency
+
+	if su.promoted || su.trial.Period <= 0 || time.Since(su.since) < su.trial.Period {
+		return false
+	}
+
+	if su.comparisons < max(su.trial.MinComparisons, 1) {
+		return false
+	}
+
+	return float64(su.agreements)/float64(su.comparisons) >= su.trial.MinAgreement
+}
+
+func (su *shadowUpstream) stats() ShadowUpstreamStats {
+	su.mu.Lock()
+	defer su.mu.Unlock()
+
+	return su.statsLocked()
+}
+
+func (su *shadowUpstream) statsLocked() ShadowUpstreamStats {
+	stats := ShadowUpstreamStats{
+		Hostname:      su.uc.GetHostname(),
+		URL:           su.uc.GetURL(),
+		Since:         su.since,
+		Comparisons:   su.comparisons,
+		Agreements:    su.agreements,
+		Disagreements: su.disagreements,
+		Errors:        su.errors,
+	}
+
+	if su.trial.Period > 0 {
+		stats.TrialEndsAt = su.since.Add(su.trial.Period)
+	}
+
+	if su.comparisons > 0 {
+		stats.ShadowLatency = su.shadowLatency / time.Duration(su.comparisons)
+		stats.PrimaryLatency = su.primaryLatency / time.Duration(su.comparisons)
+	}
+
+	return stats
+}
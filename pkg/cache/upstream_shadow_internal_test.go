@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestShadowUpstreams(t *testing.T) {
+	t.Parallel()
+
+	missingHash := "00000000000000000000000000000000"
+
+	// setup returns a cache with a primary upstream and the shadow upstream
+	// served by shadow.
+	setup := func(t *testing.T, shadow *testdata.Server, trial ShadowTrial) *Cache {
+		t.Helper()
+
+		c, _, _, _, _, cleanup := setupSQLiteFactory(t)
+		t.Cleanup(cleanup)
+
+		primary := testdata.NewTestServer(t, 40)
+		t.Cleanup(primary.Close)
+
+		uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, primary.URL), nil)
+		require.NoError(t, err)
+
+		c.AddUpstreamCaches(newContext(), uc)
+
+		<-c.GetHealthChecker().Trigger()
+
+		suc, err := upstream.New(newContext(), testhelper.MustParseURL(t, shadow.URL), nil)
+		require.NoError(t, err)
+
+		c.AddShadowUpstreamCaches(newContext(), trial, suc)
+
+		return c
+	}
+
+	waitComparisons := func(t *testing.T, c *Cache, n int64) ShadowUpstreamStats {
+		t.Helper()
+
+		var stats ShadowUpstreamStats
+
+		require.EventuallyWithT(t, func(ct *assert.CollectT) {
+			all := c.ShadowUpstreamStats()
+			if assert.Len(ct, all, 1) {
+				stats = all[0]
+				assert.Equal(ct, n, stats.Comparisons+stats.Errors)
+			}
+		}, 5*time.Second, 10*time.Millisecond)
+
+		return stats
+	}
+
+	t.Run("agreeing answers are recorded but not served", func(t *testing.T) {
+		t.Parallel()
+
+		shadow := testdata.NewTestServer(t, 40)
+		t.Cleanup(shadow.Close)
+
+		c := setup(t, shadow, ShadowTrial{})
+
+		_, ni, err := c.getNarInfoFromUpstream(newContext(), testdata.Nar1.NarInfoHash)
+		require.NoError(t, err)
+		assert.NotNil(t, ni)
+
+		_, _, err = c.getNarInfoFromUpstream(newContext(), missingHash)
+		require.Error(t, err)
+
+		stats := waitComparisons(t, c, 2)
+		assert.Equal(t, int64(2), stats.Agreements)
+		assert.Zero(t, stats.Disagreements)
+		assert.InDelta(t, 1.0, stats.Agreement(), 0)
+		assert.True(t, stats.TrialEndsAt.IsZero())
+		assert.Positive(t, stats.ShadowLatency)
+
+		assert.Equal(t, 1, c.GetUpstreamCount(), "a shadow upstream is never selected")
+
+		require.ErrorIs(t, c.PromoteShadowUpstream(newContext(), "unknown.example.com"), ErrShadowUpstreamNotFound)
+
+		require.NoError(t, c.PromoteShadowUpstream(newContext(), stats.Hostname))
+		assert.Equal(t, 2, c.GetUpstreamCount())
+		assert.Empty(t, c.ShadowUpstreamStats())
+	})
+
+	t.Run("a disagreeing shadow is not promoted", func(t *testing.T) {
+		t.Parallel()
+
+		shadow := testdata.NewTestServer(t, 40)
+		t.Cleanup(shadow.Close)
+
+		shadow.AddMaybeHandler(func(w http.ResponseWriter, _ *http.Request) bool {
+			w.WriteHeader(http.StatusNotFound)
+
+			return true
+		})
+
+		c := setup(t, shadow, ShadowTrial{Period: time.Nanosecond, MinComparisons: 1, MinAgreement: 0.5})
+
+		_, _, err := c.getNarInfoFromUpstream(newContext(), testdata.Nar1.NarInfoHash)
+		require.NoError(t, err)
+
+		stats := waitComparisons(t, c, 1)
+		assert.Equal(t, int64(1), stats.Disagreements)
+		assert.Equal(t, 1, c.GetUpstreamCount())
+	})
+
+	t.Run("a shadow passing its trial is promoted", func(t *testing.T) {
+		t.Parallel()
+
+		shadow := testdata.NewTestServer(t, 40)
+		t.Cleanup(shadow.Close)
+
+		c := setup(t, shadow, ShadowTrial{Period: time.Nanosecond, MinComparisons: 2, MinAgreement: 1})
+
+		_, _, err := c.getNarInfoFromUpstream(newContext(), testdata.Nar1.NarInfoHash)
+		require.NoError(t, err)
+
+		waitComparisons(t, c, 1)
+		assert.Equal(t, 1, c.GetUpstreamCount(), "the trial requires two comparisons")
+
+		_, _, err = c.getNarInfoFromUpstream(newContext(), testdata.Nar1.NarInfoHash)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			return c.GetUpstreamCount() == 2
+		}, 5*time.Second, 10*time.Millisecond)
+
+		assert.Empty(t, c.ShadowUpstreamStats())
+	})
+}
+
+func TestCompareShadowAnswers(t *testing.T) {
+	t.Parallel()
+
+	parse := func(t *testing.T) *shadowAnswer {
+		t.Helper()
+
+		ni, err := narinfo.Parse(strings.NewReader(testdata.Nar1.NarInfoText))
+		require.NoError(t, err)
+
+		return &shadowAnswer{narInfo: ni}
+	}
+
+	assert.Equal(t, shadowOutcomeAgree, compareShadowAnswers(parse(t), parse(t)))
+	assert.Equal(t, shadowOutcomeAgree, compareShadowAnswers(&shadowAnswer{}, &shadowAnswer{}))
+	assert.Equal(t, shadowOutcomeDisagree, compareShadowAnswers(parse(t), &shadowAnswer{}))
+	assert.Equal(t, shadowOutcomeDisagree, compareShadowAnswers(&shadowAnswer{}, parse(t)))
+	assert.Equal(t, shadowOutcomeError,
+		compareShadowAnswers(parse(t), &shadowAnswer{err: errTest}))
+
+	other := parse(t)
+	other.narInfo.NarSize++
+	assert.Equal(t, shadowOutcomeDisagree, compareShadowAnswers(parse(t), other))
+}
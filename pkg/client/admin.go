@@ -0,0 +1,277 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// adminAPI is the prefix of the admin API routes.
+const adminAPI = "api/v1/"
+
+// CronJob is the status of a cron job of the cache.
+type CronJob struct {
+	Name         string     `json:"name"`
+	Paused       bool       `json:"paused"`
+	Deferred     bool       `json:"deferred"`
+	Running      bool       `json:"running"`
+	NextRun      *time.Time `json:"nextRun,omitempty"`
+	LastRun      *time.Time `json:"lastRun,omitempty"`
+	LastDuration string     `json:"lastDuration,omitempty"`
+	LastSuccess  *bool      `json:"lastSuccess,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+	Runs         int64      `json:"runs"`
+	Failures     int64      `json:"failures"`
+}
+
+// NarRepair is the result of the repair of a chunked NAR.
+type NarRepair struct {
+	Hash           string `json:"hash"`
+	Repaired       bool   `json:"repaired"`
+	TotalChunks    int64  `json:"totalChunks"`
+	ReplacedChunks int    `json:"replacedChunks"`
+}
+
+// HealthReport is the health of the components of the cache. Status is one of
+// ok, degraded or down.
+type HealthReport struct {
+	Status     string            `json:"status"`
+	Components []ComponentHealth `json:"components"`
+}
+
+// ComponentHealth is the health of a component of the cache.
+type ComponentHealth struct {
+	Kind        string     `json:"kind"`
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	CheckedAt   *time.Time `json:"checkedAt,omitempty"`
+	Latency     string     `json:"latency,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// Stats are the statistics of the cache.
+type Stats struct {
+	NarInfos         int    `json:"narInfos"`
+	NarFiles         int    `json:"narFiles"`
+	TotalSize        int64  `json:"totalSize"`
+	MaxSize          uint64 `json:"maxSize"`
+	Chunks           int    `json:"chunks"`
+	PinnedClosures   int    `json:"pinnedClosures"`
+	Upstreams        int    `json:"upstreams"`
+	HealthyUpstreams int    `json:"healthyUpstreams"`
+}
+
+// PrefetchResult counts the store paths of the closures prefetched.
+type PrefetchResult struct {
+	Roots   int `json:"roots"`
+	Cached  int `json:"cached"`
+	Fetched int `json:"fetched"`
+	Missing int `json:"missing"`
+	Failed  int `json:"failed"`
+}
+
+// CDCStatus is the status of content-defined chunking on the cache.
+type CDCStatus struct {
+	Enabled      bool   `json:"enabled"`
+	ChunkStore   bool   `json:"chunkStore"`
+	LazyChunking bool   `json:"lazyChunking"`
+	MinSize      uint32 `json:"minSize,omitempty"`
+	AvgSize      uint32 `json:"avgSize,omitempty"`
+	MaxSize      uint32 `json:"maxSize,omitempty"`
+	InFlightJobs int    `json:"inFlightJobs"`
+	ChunkedNars  int    `json:"chunkedNars"`
+}
+
+// OfflineStatus is the status of the offline mode of the cache.
+type OfflineStatus struct {
+	Offline bool `json:"offline"`
+}
+
+// Job is the progress of a NAR download or chunking job in flight.
+type Job struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	Hash       string    `json:"hash"`
+	StartedAt  time.Time `json:"startedAt"`
+	BytesDone  int64     `json:"bytesDone"`
+	BytesTotal int64     `json:"bytesTotal,omitempty"`
+	ChunksDone int64     `json:"chunksDone,omitempty"`
+}
+
+// ShadowUpstream is the statistics of an upstream in shadow mode.
+type ShadowUpstream struct {
+	Hostname       string     `json:"hostname"`
+	URL            string     `json:"url"`
+	Since          time.Time  `json:"since"`
+	TrialEndsAt    *time.Time `json:"trialEndsAt,omitempty"`
+	Comparisons    int64      `json:"comparisons"`
+	Agreements     int64      `json:"agreements"`
+	Disagreements  int64      `json:"disagreements"`
+	Errors         int64      `json:"errors"`
+	Agreement      float64    `json:"agreement"`
+	ShadowLatency  string     `json:"shadowLatency,omitempty"`
+	PrimaryLatency string     `json:"primaryLatency,omitempty"`
+}
+
+// CronJobs returns the status of the cron jobs.
+func (c *Client) CronJobs(ctx context.Context) ([]CronJob, error) {
+	return adminCall[[]CronJob](ctx, c, http.MethodGet, "cron/jobs", nil)
+}
+
+// CronJob returns the status of the cron job name.
+func (c *Client) CronJob(ctx context.Context, name string) (CronJob, error) {
+	return adminCall[CronJob](ctx, c, http.MethodGet, "cron/jobs/"+url.PathEscape(name), nil)
+}
+
+// TriggerCronJob runs the cron job name now and returns its status.
+func (c *Client) TriggerCronJob(ctx context.Context, name string) (CronJob, error) {
+	return c.cronJobAction(ctx, name, "trigger")
+}
+
+// PauseCronJob pauses the cron job name and returns its status.
+func (c *Client) PauseCronJob(ctx context.Context, name string) (CronJob, error) {
+	return c.cronJobAction(ctx, name, "pause")
+}
+
+// ResumeCronJob resumes the cron job name and returns its status.
+func (c *Client) ResumeCronJob(ctx context.Context, name string) (CronJob, error) {
+	return c.cronJobAction(ctx, name, "resume")
+}
+
+func (c *Client) cronJobAction(ctx context.Context, name, action string) (CronJob, error) {
+	return adminCall[CronJob](ctx, c, http.MethodPost, "cron/jobs/"+url.PathEscape(name)+"/"+action, nil)
+}
+
+// RepairNar repairs the chunks of the chunked NAR of hash from its upstream.
+func (c *Client) RepairNar(ctx context.Context, hash string) (NarRepair, error) {
+	return adminCall[NarRepair](ctx, c, http.MethodPost, "nars/"+url.PathEscape(hash)+"/repair", nil)
+}
+
+// HealthDetail returns the health of the components of the cache, also when
+// the cache is down.
+func (c *Client) HealthDetail(ctx context.Context) (HealthReport, error) {
+	var report HealthReport
+
+	err := c.doJSON(ctx, request{
+		method:     http.MethodGet,
+		path:       adminAPI + "health/detail",
+		okStatuses: []int{http.StatusServiceUnavailable},
+	}, nil, &report)
+
+	return report, err
+}
+
+// Stats returns the statistics of the cache.
+func (c *Client) Stats(ctx context.Context) (Stats, error) {
+	return adminCall[Stats](ctx, c, http.MethodGet, "stats", nil)
+}
+
+// EvictNarInfo deletes the narinfo of hash, regardless of
+// --cache-allow-delete-verb. Its NAR is left to the LRU.
+func (c *Client) EvictNarInfo(ctx context.Context, hash string) error {
+	return c.adminJSON(ctx, http.MethodDelete, "narinfos/"+url.PathEscape(hash), nil, nil)
+}
+
+// Pins returns the hashes of the pinned closures.
+func (c *Client) Pins(ctx context.Context) ([]string, error) {
+	return adminCall[[]string](ctx, c, http.MethodGet, "pins", nil)
+}
+
+// Pin pins the closure of the narinfo of hash, protecting it from the LRU.
+func (c *Client) Pin(ctx context.Context, hash string) error {
+	return c.adminJSON(ctx, http.MethodPost, "pins/"+url.PathEscape(hash), nil, nil)
+}
+
+// Unpin unpins the closure of the narinfo of hash.
+func (c *Client) Unpin(ctx context.Context, hash string) error {
+	return c.adminJSON(ctx, http.MethodDelete, "pins/"+url.PathEscape(hash), nil, nil)
+}
+
+// Prefetch pulls the closures of storePaths, store paths or their hashes, from
+// the upstreams. It returns once they are cached.
+func (c *Client) Prefetch(ctx context.Context, storePaths ...string) (PrefetchResult, error) {
+	in := struct {
+		StorePaths []string `json:"storePaths"`
+	}{StorePaths: storePaths}
+
+	return adminCall[PrefetchResult](ctx, c, http.MethodPost, "prefetch", in)
+}
+
+// CDC returns the status of content-defined chunking.
+func (c *Client) CDC(ctx context.Context) (CDCStatus, error) {
+	return adminCall[CDCStatus](ctx, c, http.MethodGet, "cdc", nil)
+}
+
+// EnableCDC enables content-defined chunking. The chunk sizes are required the
+// first time only and may be zero otherwise.
+func (c *Client) EnableCDC(ctx context.Context, minSize, avgSize, maxSize uint32) (CDCStatus, error) {
+	in := struct {
+		MinSize uint32 `json:"minSize"`
+		AvgSize uint32 `json:"avgSize"`
+		MaxSize uint32 `json:"maxSize"`
+	}{MinSize: minSize, AvgSize: avgSize, MaxSize: maxSize}
+
+	return adminCall[CDCStatus](ctx, c, http.MethodPost, "cdc/enable", in)
+}
+
+// DisableCDC disables content-defined chunking. drain acknowledges that the
+// NARs already chunked are served from the chunk store until migrated back.
+func (c *Client) DisableCDC(ctx context.Context, drain bool) (CDCStatus, error) {
+	in := struct {
+		Drain bool `json:"drain"`
+	}{Drain: drain}
+
+	return adminCall[CDCStatus](ctx, c, http.MethodPost, "cdc/disable", in)
+}
+
+// Jobs returns the progress of the jobs in flight.
+func (c *Client) Jobs(ctx context.Context) ([]Job, error) {
+	return adminCall[[]Job](ctx, c, http.MethodGet, "jobs", nil)
+}
+
+// Job returns the progress of the job in flight id.
+func (c *Client) Job(ctx context.Context, id string) (Job, error) {
+	return adminCall[Job](ctx, c, http.MethodGet, "jobs/"+url.PathEscape(id), nil)
+}
+
+// ShadowUpstreams returns the statistics of the upstreams in shadow mode.
+func (c *Client) ShadowUpstreams(ctx context.Context) ([]ShadowUpstream, error) {
+	return adminCall[[]ShadowUpstream](ctx, c, http.MethodGet, "upstreams/shadow", nil)
+}
+
+// PromoteShadowUpstream promotes the shadow upstream at hostname to selection,
+// regardless of its trial.
+func (c *Client) PromoteShadowUpstream(ctx context.Context, hostname string) error {
+	return c.adminJSON(ctx, http.MethodPost, "upstreams/shadow/"+url.PathEscape(hostname)+"/promote", nil, nil)
+}
+
+// Offline returns the status of the offline mode.
+func (c *Client) Offline(ctx context.Context) (OfflineStatus, error) {
+	return adminCall[OfflineStatus](ctx, c, http.MethodGet, "offline", nil)
+}
+
+// SetOffline switches the offline mode on or off.
+func (c *Client) SetOffline(ctx context.Context, offline bool) (OfflineStatus, error) {
+	path := "offline/disable"
+	if offline {
+		path = "offline/enable"
+	}
+
+	return adminCall[OfflineStatus](ctx, c, http.MethodPost, path, nil)
+}
+
+// adminJSON sends a request to the admin API path.
+func (c *Client) adminJSON(ctx context.Context, method, path string, in, out any) error {
+	return c.doJSON(ctx, request{method: method, path: adminAPI + path}, in, out)
+}
+
+// adminCall sends a request to the admin API path and returns its answer.
+func adminCall[T any](ctx context.Context, c *Client, method, path string, in any) (T, error) {
+	var out T
+
+	err := c.adminJSON(ctx, method, path, in, &out)
+
+	return out, err
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+
+	"github.com/kalbasit/ncps/pkg/nixcacheinfo"
+)
+
+// NixCacheInfo returns the nix-cache-info of the cache.
+func (c *Client) NixCacheInfo(ctx context.Context) (nixcacheinfo.NixCacheInfo, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, path: "nix-cache-info"})
+	if err != nil {
+		return nixcacheinfo.NixCacheInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	nci, err := nixcacheinfo.Parse(resp.Body)
+	if err != nil {
+		return nixcacheinfo.NixCacheInfo{}, fmt.Errorf("error parsing the nix-cache-info: %w", err)
+	}
+
+	return nci, nil
+}
+
+// PublicKey returns the public key the cache signs its narinfos with.
+func (c *Client) PublicKey(ctx context.Context) (string, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, path: "pubkey"})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading the public key: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// GetNarInfo returns the narinfo of hash, the hash part of a store path. It
+// returns an error wrapping ErrNotFound if the cache has no such narinfo.
+func (c *Client) GetNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, path: hash + ".narinfo"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	ni, err := narinfo.Parse(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the narinfo %s: %w", hash, err)
+	}
+
+	return ni, nil
+}
+
+// HasNarInfo reports whether the cache has the narinfo of hash.
+func (c *Client) HasNarInfo(ctx context.Context, hash string) (bool, error) {
+	resp, err := c.do(ctx, request{method: http.MethodHead, path: hash + ".narinfo"})
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	_ = resp.Body.Close()
+
+	return true, nil
+}
+
+// GetNar returns the NAR at narURL, the URL of a narinfo such as
+// nar/<hash>.nar.xz, and its size, or -1 if unknown. The caller closes it. It
+// returns an error wrapping ErrNotFound if the cache has no such NAR.
+func (c *Client) GetNar(ctx context.Context, narURL string) (io.ReadCloser, int64, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, path: narURL})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return resp.Body, resp.ContentLength, nil
+}
+
+// DeleteNarInfo deletes the narinfo of hash through the binary cache route,
+// which the cache only allows with --cache-allow-delete-verb. See EvictNarInfo
+// for the admin API.
+func (c *Client) DeleteNarInfo(ctx context.Context, hash string) error {
+	resp, err := c.do(ctx, request{method: http.MethodDelete, path: hash + ".narinfo"})
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
@@ -0,0 +1,361 @@
+// Package client is a Go client of the HTTP API of ncps: the binary cache
+// routes nix uses and the admin API under /api/v1. Its requests are traced
+// with OpenTelemetry and the idempotent ones are retried on transient
+// failures.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	mathrand "math/rand"
+)
+
+const (
+	// DefaultMaxRetries is the number of retries of a failed idempotent
+	// request when Options.MaxRetries is zero.
+	DefaultMaxRetries = 3
+
+	// DefaultRetryBackoff is the delay before the first retry when
+	// Options.RetryBackoff is zero. It doubles on every retry.
+	DefaultRetryBackoff = 200 * time.Millisecond
+
+	// retryBackoffCap bounds the delay between two retries.
+	retryBackoffCap = 10 * time.Second
+
+	// retryJitterFactor bounds the random delay added to a retry backoff, as
+	// a fraction of the backoff.
+	retryJitterFactor = 0.25
+
+	contentTypeJSON = "application/json"
+)
+
+var (
+	// ErrURLRequired is returned by New if the URL of the cache is empty.
+	ErrURLRequired = errors.New("the URL of the cache is required")
+
+	// ErrNotFound is wrapped by the errors of the requests answered with 404
+	// Not Found.
+	ErrNotFound = errors.New("not found")
+
+	// ErrRequest is wrapped by the errors of the requests answered with any
+	// other error status.
+	ErrRequest = errors.New("the cache answered with an error")
+)
+
+// ResponseError is the error of a request answered with an error status. It
+// wraps ErrNotFound or ErrRequest.
+type ResponseError struct {
+	Method     string
+	Path       string
+	StatusCode int
+
+	// Code is the error code of the problem+json answers of the cache, such
+	// as narinfo_not_found or cron_job_running. It is empty for the answers in
+	// plain text.
+	Code string
+
+	// Detail describes the error.
+	Detail string
+}
+
+func (e *ResponseError) Error() string {
+	msg := fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+
+	switch {
+	case e.Detail != "" && e.Code != "":
+		msg += ": " + e.Detail + " (" + e.Code + ")"
+	case e.Detail != "":
+		msg += ": " + e.Detail
+	case e.Code != "":
+		msg += ": " + e.Code
+	}
+
+	return msg
+}
+
+func (e *ResponseError) Unwrap() error {
+	if e.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	return ErrRequest
+}
+
+// Options configures a Client.
+type Options struct {
+	// Token is sent as a Bearer token with every request: the admin token of
+	// the admin API, or the token of a cache requiring authentication.
+	Token string
+
+	// HTTPClient sends the requests. If nil, a client with Timeout is used.
+	// Its transport is wrapped to trace the requests.
+	HTTPClient *http.Client
+
+	// Timeout is the timeout of a request of the default HTTP client. If
+	// zero, the requests have no timeout but the one of their context.
+	Timeout time.Duration
+
+	// MaxRetries is the number of retries of a failed idempotent request. If
+	// zero, DefaultMaxRetries is used; if negative, the requests are not
+	// retried.
+	MaxRetries int
+
+	// RetryBackoff is the delay before the first retry. If zero,
+	// DefaultRetryBackoff is used.
+	RetryBackoff time.Duration
+}
+
+// Client is a client of a running ncps. It is safe for concurrent use.
+type Client struct {
+	httpClient   *http.Client
+	baseURL      *url.URL
+	token        string
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+// New returns a Client of the ncps at rawURL.
+func New(rawURL string, opts Options) (*Client, error) {
+	if rawURL == "" {
+		return nil, ErrURLRequired
+	}
+
+	baseURL, err := url.Parse(strings.TrimSuffix(rawURL, "/") + "/")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the URL of the cache: %w", err)
+	}
+
+	httpClient := &http.Client{Timeout: opts.Timeout}
+	if opts.HTTPClient != nil {
+		c := *opts.HTTPClient
+		httpClient = &c
+	}
+
+	transport := httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	httpClient.Transport = otelhttp.NewTransport(transport)
+
+	maxRetries := opts.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = DefaultMaxRetries
+	}
+
+	retryBackoff := opts.RetryBackoff
+	if retryBackoff == 0 {
+		retryBackoff = DefaultRetryBackoff
+	}
+
+	return &Client{
+		httpClient:   httpClient,
+		baseURL:      baseURL,
+		token:        opts.Token,
+		maxRetries:   max(maxRetries, 0),
+		retryBackoff: retryBackoff,
+	}, nil
+}
+
+// request is a request to the cache.
+type request struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+
+	// okStatuses are the statuses answered on success, besides 2xx.
+	okStatuses []int
+}
+
+// do sends req, retrying the idempotent requests that failed transiently, and
+// returns the response of a successful request. The caller closes its body.
+func (c *Client) do(ctx context.Context, req request) (*http.Response, error) {
+	retries := 0
+	if isIdempotent(req.method) {
+		retries = c.maxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req)
+
+		retriable := err != nil && isRetriableError(err) ||
+			err == nil && isRetriableStatus(resp.StatusCode) && !isOKStatus(req, resp.StatusCode)
+
+		if !retriable || attempt >= retries {
+			if err != nil {
+				return nil, err
+			}
+
+			if resp.StatusCode >= http.StatusMultipleChoices && !isOKStatus(req, resp.StatusCode) {
+				defer resp.Body.Close()
+
+				return nil, newResponseError(req, resp)
+			}
+
+			return resp, nil
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.retryDelay(attempt)):
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	u := c.baseURL.ResolveReference(&url.URL{Path: strings.TrimPrefix(req.path, "/")})
+
+	var body io.Reader
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, req.method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the request: %w", err)
+	}
+
+	for k, v := range req.header {
+		r.Header[k] = v
+	}
+
+	if c.token != "" {
+		r.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting %s %s: %w", req.method, req.path, err)
+	}
+
+	return resp, nil
+}
+
+// doJSON sends req with the JSON of in, if not nil, and decodes the JSON
+// answer into out, if not nil.
+func (c *Client) doJSON(ctx context.Context, req request, in, out any) error {
+	req.header = http.Header{"Accept": {contentTypeJSON}}
+
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("error encoding the request: %w", err)
+		}
+
+		req.body = data
+
+		req.header.Set("Content-Type", contentTypeJSON)
+	}
+
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding the answer of %s %s: %w", req.method, req.path, err)
+	}
+
+	return nil
+}
+
+// retryDelay returns the capped exponential backoff for the given zero-based
+// attempt, plus up to retryJitterFactor of it at random.
+func (c *Client) retryDelay(attempt int) time.Duration {
+	delay := c.retryBackoff
+	for n := 0; n < attempt && delay < retryBackoffCap; n++ {
+		delay *= 2
+	}
+
+	delay = min(delay, retryBackoffCap)
+
+	//nolint:gosec // G404: math/rand is acceptable for jitter, doesn't need crypto-grade randomness
+	return delay + time.Duration(mathrand.Float64()*retryJitterFactor*float64(delay))
+}
+
+// newResponseError returns the error of resp, answering req with an error
+// status, with the code and detail of its problem+json body, if any.
+func newResponseError(req request, resp *http.Response) *ResponseError {
+	e := &ResponseError{Method: req.method, Path: req.path, StatusCode: resp.StatusCode}
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var p struct {
+		Detail string `json:"detail"`
+		Code   string `json:"code"`
+	}
+
+	if json.Unmarshal(data, &p) == nil && p.Code != "" {
+		e.Code = p.Code
+		e.Detail = p.Detail
+
+		return e
+	}
+
+	e.Detail = strings.TrimSpace(string(data))
+
+	return e
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func isOKStatus(req request, code int) bool {
+	return slices.Contains(req.okStatuses, code)
+}
+
+// isRetriableStatus reports whether the cache answered with a status that
+// signals a transient overload or outage.
+func isRetriableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRetriableError reports whether a failed request may be retried: a refused
+// or reset connection, or a response cut short.
+func isRetriableError(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
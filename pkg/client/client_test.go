@@ -0,0 +1,237 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/client"
+)
+
+const (
+	testHash = "n5glp21rsz314qssw9fbvfswgy3kc68f"
+
+	testNarInfo = `StorePath: /nix/store/n5glp21rsz314qssw9fbvfswgy3kc68f-hello-2.12.1
+URL: nar/1lid9xrpirkzcpqsxfq02qwiq0yd70chfl860wzsqd1739ih0nri.nar.xz
+Compression: xz
+FileHash: sha256:1lid9xrpirkzcpqsxfq02qwiq0yd70chfl860wzsqd1739ih0nri
+FileSize: 50160
+NarHash: sha256:1fsqm8y8d8vaip2ycfzq2b3b6kxgz2yvv9xqw5wdm0c7crwmjp58
+NarSize: 226552
+References: n5glp21rsz314qssw9fbvfswgy3kc68f-hello-2.12.1
+`
+)
+
+func newTestClient(t *testing.T, h http.Handler, opts client.Options) *client.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	if opts.RetryBackoff == 0 {
+		opts.RetryBackoff = time.Millisecond
+	}
+
+	c, err := client.New(srv.URL, opts)
+	require.NoError(t, err)
+
+	return c
+}
+
+func TestNew_URLRequired(t *testing.T) {
+	t.Parallel()
+
+	_, err := client.New("", client.Options{})
+	require.ErrorIs(t, err, client.ErrURLRequired)
+}
+
+func TestGetNarInfo(t *testing.T) {
+	t.Parallel()
+
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+testHash+".narinfo" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		_, _ = io.WriteString(w, testNarInfo)
+	}), client.Options{})
+
+	ni, err := c.GetNarInfo(context.Background(), testHash)
+	require.NoError(t, err)
+	assert.Equal(t, "nar/1lid9xrpirkzcpqsxfq02qwiq0yd70chfl860wzsqd1739ih0nri.nar.xz", ni.URL)
+	assert.Equal(t, uint64(226552), ni.NarSize)
+
+	ok, err := c.HasNarInfo(context.Background(), testHash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = c.HasNarInfo(context.Background(), "00000000000000000000000000000000")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = c.GetNarInfo(context.Background(), "00000000000000000000000000000000")
+	require.ErrorIs(t, err, client.ErrNotFound)
+}
+
+func TestGetNar(t *testing.T) {
+	t.Parallel()
+
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/nar/abc.nar.xz", r.URL.Path)
+
+		_, _ = io.WriteString(w, "nar content")
+	}), client.Options{})
+
+	rc, size, err := c.GetNar(context.Background(), "nar/abc.nar.xz")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = rc.Close() })
+
+	body, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "nar content", string(body))
+	assert.Equal(t, int64(len(body)), size)
+}
+
+func TestRetries(t *testing.T) {
+	t.Parallel()
+
+	t.Run("idempotent requests are retried on transient statuses", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int32
+
+		c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if calls.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+
+				return
+			}
+
+			_, _ = io.WriteString(w, "StoreDir: /nix/store\nWantMassQuery: 1\nPriority: 40\n")
+		}), client.Options{})
+
+		nci, err := c.NixCacheInfo(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, uint64(40), nci.Priority)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("retries are bounded", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int32
+
+		c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadGateway)
+		}), client.Options{MaxRetries: 2})
+
+		_, err := c.PublicKey(context.Background())
+		require.ErrorIs(t, err, client.ErrRequest)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("other errors and POST requests are not retried", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int32
+
+		c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+
+			if r.Method == http.MethodPost {
+				w.WriteHeader(http.StatusServiceUnavailable)
+
+				return
+			}
+
+			w.WriteHeader(http.StatusInternalServerError)
+		}), client.Options{})
+
+		_, err := c.Stats(context.Background())
+		require.ErrorIs(t, err, client.ErrRequest)
+
+		_, err = c.TriggerCronJob(context.Background(), "lru")
+		require.ErrorIs(t, err, client.ErrRequest)
+
+		assert.Equal(t, int32(2), calls.Load())
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	t.Parallel()
+
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/v1/cron/jobs":
+			_, _ = io.WriteString(w, `[{"name":"lru","paused":true,"runs":2,"failures":1}]`)
+		case "POST /api/v1/cron/jobs/missing/trigger":
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"status":404,"code":"cron_job_not_found","detail":"cron job not found: missing"}`)
+		case "GET /api/v1/health/detail":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = io.WriteString(w, `{"status":"down","components":[{"kind":"database","name":"database","status":"down"}]}`)
+		case "POST /api/v1/prefetch":
+			var in struct {
+				StorePaths []string `json:"storePaths"`
+			}
+
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+			assert.Equal(t, []string{testHash}, in.StorePaths)
+
+			_, _ = io.WriteString(w, `{"roots":1,"fetched":1}`)
+		case "DELETE /api/v1/narinfos/" + testHash:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}), client.Options{Token: "secret"})
+
+	ctx := context.Background()
+
+	jobs, err := c.CronJobs(ctx)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "lru", jobs[0].Name)
+	assert.True(t, jobs[0].Paused)
+	assert.Equal(t, int64(2), jobs[0].Runs)
+
+	_, err = c.TriggerCronJob(ctx, "missing")
+	require.ErrorIs(t, err, client.ErrNotFound)
+
+	var respErr *client.ResponseError
+	require.ErrorAs(t, err, &respErr)
+	assert.Equal(t, "cron_job_not_found", respErr.Code)
+	assert.Equal(t, "cron job not found: missing", respErr.Detail)
+
+	report, err := c.HealthDetail(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "down", report.Status)
+	require.Len(t, report.Components, 1)
+
+	result, err := c.Prefetch(ctx, testHash)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Fetched)
+
+	require.NoError(t, c.EvictNarInfo(ctx, testHash))
+}
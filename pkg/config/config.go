@@ -29,6 +29,13 @@ const (
 	KeyCDCAvg = "cdc_avg"
 	// KeyCDCMax is the key for CDC maximum chunk size in the configuration database.
 	KeyCDCMax = "cdc_max"
+	// KeyUpstreamPublicKeyPrefix prefixes the key of the public key imported
+	// from an upstream, followed by the URL of the upstream.
+	KeyUpstreamPublicKeyPrefix = "upstream_public_key:"
+	// KeyACMEPrefix prefixes the key of the data cached by the ACME
+	// certificate manager, followed by the name of the data: the account key
+	// and the certificates of the domains.
+	KeyACMEPrefix = "acme:"
 
 	// lockKeyPrefix is the prefix used for locking configuration keys.
 	lockKeyPrefix = "config_"
@@ -126,6 +133,34 @@ func (c *Config) SetCDCMax(ctx context.Context, value string) error {
 	return c.setConfig(ctx, KeyCDCMax, value)
 }
 
+// GetUpstreamPublicKey returns the public key imported from the upstream at
+// upstreamURL.
+func (c *Config) GetUpstreamPublicKey(ctx context.Context, upstreamURL string) (string, error) {
+	return c.getConfig(ctx, KeyUpstreamPublicKeyPrefix+upstreamURL)
+}
+
+// SetUpstreamPublicKey records the public key imported from the upstream at
+// upstreamURL.
+func (c *Config) SetUpstreamPublicKey(ctx context.Context, upstreamURL, value string) error {
+	return c.setConfig(ctx, KeyUpstreamPublicKeyPrefix+upstreamURL, value)
+}
+
+// GetACME returns the data named name cached by the ACME certificate manager.
+func (c *Config) GetACME(ctx context.Context, name string) (string, error) {
+	return c.getConfig(ctx, KeyACMEPrefix+name)
+}
+
+// SetACME caches the data named name for the ACME certificate manager.
+func (c *Config) SetACME(ctx context.Context, name, value string) error {
+	return c.setConfig(ctx, KeyACMEPrefix+name, value)
+}
+
+// DeleteACME removes the data named name cached by the ACME certificate
+// manager. It returns nil if there is none.
+func (c *Config) DeleteACME(ctx context.Context, name string) error {
+	return c.deleteConfig(ctx, KeyACMEPrefix+name)
+}
+
 // getConfig retrieves a configuration value by key, acquiring a read lock.
 func (c *Config) getConfig(ctx context.Context, key string) (string, error) {
 	lockKey := getLockKey(key)
@@ -3,6 +3,7 @@ package config_test
 import (
 	"context"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -505,3 +506,53 @@ func testValidateCDCDisableAfterEnabled(factory databaseFactory) func(*testing.T
 		require.ErrorIs(t, err, config.ErrCDCConfigMismatch)
 	}
 }
+
+func TestUpstreamPublicKey(t *testing.T) {
+	t.Parallel()
+
+	db, cleanup := setupSQLiteDatabase(t)
+	t.Cleanup(cleanup)
+
+	c := config.New(db, local.NewRWLocker())
+
+	_, err := c.GetUpstreamPublicKey(context.Background(), "https://cache.nixos.org")
+	require.ErrorIs(t, err, config.ErrConfigNotFound)
+
+	require.NoError(t, c.SetUpstreamPublicKey(context.Background(), "https://cache.nixos.org", "key-1"))
+	require.NoError(t, c.SetUpstreamPublicKey(context.Background(), "https://example.com", "key-2"))
+
+	pk, err := c.GetUpstreamPublicKey(context.Background(), "https://cache.nixos.org")
+	require.NoError(t, err)
+	assert.Equal(t, "key-1", pk)
+
+	pk, err = c.GetUpstreamPublicKey(context.Background(), "https://example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "key-2", pk)
+}
+
+func TestACME(t *testing.T) {
+	t.Parallel()
+
+	db, cleanup := setupSQLiteDatabase(t)
+	t.Cleanup(cleanup)
+
+	c := config.New(db, local.NewRWLocker())
+
+	_, err := c.GetACME(context.Background(), "ncps.example.com")
+	require.ErrorIs(t, err, config.ErrConfigNotFound)
+
+	// A certificate chain is larger than a varchar.
+	cert := strings.Repeat("-----BEGIN CERTIFICATE-----\n", 200)
+
+	require.NoError(t, c.SetACME(context.Background(), "ncps.example.com", cert))
+
+	got, err := c.GetACME(context.Background(), "ncps.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, cert, got)
+
+	require.NoError(t, c.DeleteACME(context.Background(), "ncps.example.com"))
+	require.NoError(t, c.DeleteACME(context.Background(), "ncps.example.com"), "deleting nothing is no error")
+
+	_, err = c.GetACME(context.Background(), "ncps.example.com")
+	require.ErrorIs(t, err, config.ErrConfigNotFound)
+}
@@ -0,0 +1,219 @@
+// Package configfile loads the ncps configuration file.
+//
+// The file may be written in YAML, JSON or TOML. Its string values may
+// reference environment variables as ${VAR} or ${VAR:-default}; write $${ for
+// a literal ${.
+package configfile
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrUnsetVariable is returned when a value references an environment
+	// variable that is not set and has no default.
+	ErrUnsetVariable = errors.New("environment variable is not set")
+
+	// ErrInvalidReference is returned for a malformed ${...} reference.
+	ErrInvalidReference = errors.New("invalid environment variable reference")
+)
+
+// LookupFunc looks up the value of an environment variable.
+type LookupFunc func(name string) (string, bool)
+
+// Load reads the configuration file at path, decoding it according to its
+// extension (.toml, .json, YAML otherwise), and expands the environment
+// variable references of its string values.
+func Load(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the configuration file: %w", err)
+	}
+
+	um := yaml.Unmarshal
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		um = toml.Unmarshal
+	}
+
+	tree := make(map[string]any)
+	if err := Unmarshaler(um, os.LookupEnv)(data, &tree); err != nil {
+		return nil, fmt.Errorf("error parsing the configuration file %q: %w", path, err)
+	}
+
+	return tree, nil
+}
+
+// Unmarshaler wraps um so the string values it decodes have their environment
+// variable references expanded with lookup.
+func Unmarshaler(um func([]byte, any) error, lookup LookupFunc) func([]byte, any) error {
+	return func(data []byte, v any) error {
+		if err := um(data, v); err != nil {
+			return err
+		}
+
+		switch v := v.(type) {
+		case *map[any]any:
+			return expandMap(*v, "", lookup)
+		case *map[string]any:
+			return expandMap(*v, "", lookup)
+		default:
+			return nil
+		}
+	}
+}
+
+// Expand expands the ${VAR} and ${VAR:-default} references of s. $${ is
+// replaced with a literal ${.
+func Expand(s string, lookup LookupFunc) (string, error) {
+	var (
+		sb   strings.Builder
+		rest = s
+	)
+
+	for {
+		i := strings.Index(rest, "${")
+		if i < 0 {
+			sb.WriteString(rest)
+
+			return sb.String(), nil
+		}
+
+		if i > 0 && rest[i-1] == '$' {
+			sb.WriteString(rest[:i-1] + "${")
+			rest = rest[i+2:]
+
+			continue
+		}
+
+		sb.WriteString(rest[:i])
+
+		end := strings.IndexByte(rest[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("%w: unterminated reference in %q", ErrInvalidReference, s)
+		}
+
+		ref := rest[i+2 : i+end]
+		rest = rest[i+end+1:]
+
+		name, def, hasDefault := strings.Cut(ref, ":-")
+		if !isVariableName(name) {
+			return "", fmt.Errorf("%w: %q", ErrInvalidReference, "${"+ref+"}")
+		}
+
+		val, ok := lookup(name)
+		switch {
+		case ok && (val != "" || !hasDefault):
+			sb.WriteString(val)
+		case hasDefault:
+			sb.WriteString(def)
+		default:
+			return "", fmt.Errorf("%w: %s", ErrUnsetVariable, name)
+		}
+	}
+}
+
+// Keys returns the sorted dotted paths of the leaves of tree. Lists are
+// leaves; empty values (e.g. a section whose keys are all commented out) are
+// skipped.
+func Keys(tree map[string]any) []string {
+	var keys []string
+
+	var walk func(prefix string, node any)
+
+	walk = func(prefix string, node any) {
+		switch node := node.(type) {
+		case map[string]any:
+			for k, v := range node {
+				walk(joinKey(prefix, k), v)
+			}
+		case map[any]any:
+			for k, v := range node {
+				walk(joinKey(prefix, fmt.Sprint(k)), v)
+			}
+		case nil:
+		default:
+			keys = append(keys, prefix)
+		}
+	}
+
+	for k, v := range tree {
+		walk(k, v)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
+
+func expandMap[K comparable](m map[K]any, prefix string, lookup LookupFunc) error {
+	for _, k := range slices.Collect(maps.Keys(m)) {
+		v, err := expandValue(m[k], joinKey(prefix, fmt.Sprint(k)), lookup)
+		if err != nil {
+			return err
+		}
+
+		m[k] = v
+	}
+
+	return nil
+}
+
+func expandValue(v any, key string, lookup LookupFunc) (any, error) {
+	switch v := v.(type) {
+	case string:
+		s, err := Expand(v, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+
+		return s, nil
+	case map[string]any:
+		return v, expandMap(v, key, lookup)
+	case map[any]any:
+		return v, expandMap(v, key, lookup)
+	case []any:
+		for i := range v {
+			ev, err := expandValue(v[i], fmt.Sprintf("%s[%d]", key, i), lookup)
+			if err != nil {
+				return nil, err
+			}
+
+			v[i] = ev
+		}
+
+		return v, nil
+	default:
+		return v, nil
+	}
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+
+	return prefix + "." + key
+}
+
+func isVariableName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+
+	for _, r := range name {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,120 @@
+package configfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/configfile"
+)
+
+func lookupMap(env map[string]string) configfile.LookupFunc {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+
+		return v, ok
+	}
+}
+
+func TestExpand(t *testing.T) {
+	t.Parallel()
+
+	lookup := lookupMap(map[string]string{"HOST": "cache.example.com", "EMPTY": ""})
+
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{in: "plain", want: "plain"},
+		{in: "https://${HOST}/", want: "https://cache.example.com/"},
+		{in: "${HOST}-${HOST}", want: "cache.example.com-cache.example.com"},
+		{in: "${MISSING:-fallback}", want: "fallback"},
+		{in: "${EMPTY:-fallback}", want: "fallback"},
+		{in: "${EMPTY}", want: ""},
+		{in: "pa$$${HOST}", want: "pa$${HOST}"},
+		{in: "$${HOST}", want: "${HOST}"},
+		{in: "cost: $5", want: "cost: $5"},
+		{in: "${MISSING}", err: configfile.ErrUnsetVariable},
+		{in: "${HOST", err: configfile.ErrInvalidReference},
+		{in: "${1BAD}", err: configfile.ErrInvalidReference},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := configfile.Expand(tt.in, lookup)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+//nolint:paralleltest // uses t.Setenv
+func TestLoad(t *testing.T) {
+	t.Setenv("NCPS_TEST_HOSTNAME", "cache.example.com")
+
+	dir := t.TempDir()
+
+	t.Run("yaml", func(t *testing.T) {
+		path := filepath.Join(dir, "config.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(`
+cache:
+  # ${NOT_EXPANDED} in comments
+  hostname: ${NCPS_TEST_HOSTNAME}
+  upstream:
+    urls:
+      - https://${NCPS_TEST_HOSTNAME}
+  max-size: 10G
+server:
+  addr: ":8501"
+`), 0o600))
+
+		tree, err := configfile.Load(path)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"cache.hostname",
+			"cache.max-size",
+			"cache.upstream.urls",
+			"server.addr",
+		}, configfile.Keys(tree))
+
+		cacheTree, ok := tree["cache"].(map[string]any)
+		require.True(t, ok)
+		assert.Equal(t, "cache.example.com", cacheTree["hostname"])
+	})
+
+	t.Run("toml", func(t *testing.T) {
+		path := filepath.Join(dir, "config.toml")
+		require.NoError(t, os.WriteFile(path, []byte(`
+[cache]
+hostname = "${NCPS_TEST_HOSTNAME}"
+`), 0o600))
+
+		tree, err := configfile.Load(path)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"cache.hostname"}, configfile.Keys(tree))
+		assert.Equal(t, "cache.example.com", tree["cache"].(map[string]any)["hostname"])
+	})
+
+	t.Run("unset variable", func(t *testing.T) {
+		path := filepath.Join(dir, "unset.yaml")
+		require.NoError(t, os.WriteFile(path, []byte("cache:\n  hostname: ${NCPS_TEST_UNSET}\n"), 0o600))
+
+		_, err := configfile.Load(path)
+		require.ErrorIs(t, err, configfile.ErrUnsetVariable)
+		assert.ErrorContains(t, err, "cache.hostname")
+	})
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	entchunk "github.com/kalbasit/ncps/ent/chunk"
+)
+
+// decayChunkAccessCountsQuery halves the access_count of every chunk read
+// since the last decay. The remainder is subtracted before dividing so the
+// division is exact, MySQL rounding a decimal quotient instead of truncating
+// it.
+const decayChunkAccessCountsQuery = `UPDATE chunks SET access_count = (access_count - access_count % 2) / 2
+WHERE access_count > 0`
+
+// AddChunkAccesses adds the read counts of the chunks keyed by hash to their
+// access_count, sets their last_accessed_at to at and clears their cold flag,
+// a chunk read from the cold store being promoted back to the hot one.
+func (c *Client) AddChunkAccesses(ctx context.Context, counts map[string]int64, at time.Time) error {
+	hashesByCount := make(map[int64][]string)
+	for hash, count := range counts {
+		hashesByCount[count] = append(hashesByCount[count], hash)
+	}
+
+	for count, hashes := range hashesByCount {
+		slices.Sort(hashes)
+
+		for batch := range slices.Chunk(hashes, chunkRefsUpdateBatchSize) {
+			if err := c.ent.Chunk.Update().
+				Where(entchunk.HashIn(batch...)).
+				AddAccessCount(count).
+				SetLastAccessedAt(at).
+				SetCold(false).
+				Exec(ctx); err != nil {
+				return fmt.Errorf("error updating the chunk access counts: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// DecayChunkAccessCounts halves the access_count of every chunk, so it
+// weighs the recent reads more than the old ones.
+func (c *Client) DecayChunkAccessCounts(ctx context.Context) error {
+	if _, err := c.sdb.ExecContext(ctx, decayChunkAccessCountsQuery); err != nil {
+		return fmt.Errorf("error decaying the chunk access counts: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/database"
+)
+
+func TestChunkAccesses(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sdb, cleanup := freshSchemaSQLite(t)
+	t.Cleanup(cleanup)
+
+	c, err := database.NewClient(sdb, database.TypeSQLite)
+	require.NoError(t, err)
+
+	client := c.Ent()
+
+	hot := client.Chunk.Create().SetHash("hot").SetSize(1).SaveX(ctx)
+	cold := client.Chunk.Create().SetHash("cold").SetSize(1).SetCold(true).SaveX(ctx)
+	unread := client.Chunk.Create().SetHash("unread").SetSize(1).SaveX(ctx)
+
+	at := time.Now().UTC().Truncate(time.Second)
+
+	require.NoError(t, c.AddChunkAccesses(ctx, map[string]int64{"hot": 5, "cold": 1}, at))
+	require.NoError(t, c.AddChunkAccesses(ctx, map[string]int64{"hot": 2}, at))
+
+	got := client.Chunk.GetX(ctx, hot.ID)
+	assert.Equal(t, int64(7), got.AccessCount)
+	require.NotNil(t, got.LastAccessedAt)
+	assert.True(t, at.Equal(*got.LastAccessedAt))
+
+	got = client.Chunk.GetX(ctx, cold.ID)
+	assert.Equal(t, int64(1), got.AccessCount)
+	assert.False(t, got.Cold, "a chunk read is promoted")
+
+	assert.Nil(t, client.Chunk.GetX(ctx, unread.ID).LastAccessedAt)
+
+	require.NoError(t, c.DecayChunkAccessCounts(ctx))
+
+	assert.Equal(t, int64(3), client.Chunk.GetX(ctx, hot.ID).AccessCount)
+	assert.Zero(t, client.Chunk.GetX(ctx, cold.ID).AccessCount)
+	assert.Zero(t, client.Chunk.GetX(ctx, unread.ID).AccessCount)
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	entchunk "github.com/kalbasit/ncps/ent/chunk"
+	entnarfilechunk "github.com/kalbasit/ncps/ent/narfilechunk"
+
+	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/ent/predicate"
+)
+
+// chunkRefsUpdateBatchSize bounds the number of chunk IDs bound to a single
+// ref_count UPDATE.
+const chunkRefsUpdateBatchSize = 1000
+
+// recountChunkRefsQuery sets the ref_count of every chunk that drifted from
+// the number of nar_file_chunks rows linking it. It is valid in every
+// supported dialect.
+const recountChunkRefsQuery = `UPDATE chunks SET ref_count = (
+	SELECT COUNT(*) FROM nar_file_chunks WHERE nar_file_chunks.chunk_id = chunks.id
+) WHERE ref_count <> (
+	SELECT COUNT(*) FROM nar_file_chunks WHERE nar_file_chunks.chunk_id = chunks.id
+)`
+
+// AddChunkRefs increments the ref_count of the given chunks by one per
+// occurrence of their ID. It must run in the transaction that creates the
+// nar_file_chunks rows linking them.
+func AddChunkRefs(ctx context.Context, tx *ent.Tx, chunkIDs []int) error {
+	counts := make(map[int]int64, len(chunkIDs))
+	for _, id := range chunkIDs {
+		counts[id]++
+	}
+
+	return addChunkRefCounts(ctx, tx, counts, 1)
+}
+
+// UnlinkNarFileChunks deletes the nar_file_chunks rows matching preds and
+// decrements the ref_count of the chunks they link, returning the number of
+// rows deleted. The ON DELETE CASCADE of nar_file_chunks does not maintain
+// ref_count: a nar_file must have its chunks unlinked, in the transaction that
+// deletes it, before it is deleted.
+func UnlinkNarFileChunks(ctx context.Context, tx *ent.Tx, preds ...predicate.NarFileChunk) (int, error) {
+	var rows []struct {
+		ChunkID int   `sql:"chunk_id"`
+		Count   int64 `sql:"count"`
+	}
+
+	if err := tx.NarFileChunk.Query().
+		Where(preds...).
+		GroupBy(entnarfilechunk.FieldChunkID).
+		Aggregate(ent.Count()).
+		Scan(ctx, &rows); err != nil {
+		return 0, fmt.Errorf("error counting the chunk links: %w", err)
+	}
+
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	counts := make(map[int]int64, len(rows))
+	for _, row := range rows {
+		counts[row.ChunkID] = row.Count
+	}
+
+	if err := addChunkRefCounts(ctx, tx, counts, -1); err != nil {
+		return 0, err
+	}
+
+	deleted, err := tx.NarFileChunk.Delete().
+		Where(preds...).
+		Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting the chunk links: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// UnlinkNarFilesChunks unlinks the chunks of the nar_files matching preds.
+// See UnlinkNarFileChunks.
+func UnlinkNarFilesChunks(ctx context.Context, tx *ent.Tx, preds ...predicate.NarFile) (int, error) {
+	return UnlinkNarFileChunks(ctx, tx, entnarfilechunk.HasNarFileWith(preds...))
+}
+
+// RecountChunkRefs recomputes the ref_count of the chunks whose count does
+// not match their links and returns how many were corrected.
+func (c *Client) RecountChunkRefs(ctx context.Context) (int64, error) {
+	res, err := c.sdb.ExecContext(ctx, recountChunkRefsQuery)
+	if err != nil {
+		return 0, fmt.Errorf("error recounting the chunk references: %w", err)
+	}
+
+	corrected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error reading the recounted chunk references: %w", err)
+	}
+
+	return corrected, nil
+}
+
+// addChunkRefCounts adds sign*count to the ref_count of every chunk of
+// counts, with one UPDATE per distinct count and batch of IDs.
+func addChunkRefCounts(ctx context.Context, tx *ent.Tx, counts map[int]int64, sign int64) error {
+	idsByCount := make(map[int64][]int)
+	for id, count := range counts {
+		idsByCount[count] = append(idsByCount[count], id)
+	}
+
+	for count, ids := range idsByCount {
+		// Update the rows in a stable order to keep lock acquisition consistent
+		// across concurrent transactions.
+		slices.Sort(ids)
+
+		for batch := range slices.Chunk(ids, chunkRefsUpdateBatchSize) {
+			if err := tx.Chunk.Update().
+				Where(entchunk.IDIn(batch...)).
+				AddRefCount(sign * count).
+				Exec(ctx); err != nil {
+				return fmt.Errorf("error updating the chunk reference counts: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
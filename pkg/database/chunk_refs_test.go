@@ -0,0 +1,95 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+
+	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/pkg/database"
+)
+
+func TestChunkRefs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sdb, cleanup := freshSchemaSQLite(t)
+	t.Cleanup(cleanup)
+
+	c, err := database.NewClient(sdb, database.TypeSQLite)
+	require.NoError(t, err)
+
+	client := c.Ent()
+
+	shared := client.Chunk.Create().SetHash("shared").SetSize(1).SaveX(ctx)
+	own := client.Chunk.Create().SetHash("own").SetSize(1).SaveX(ctx)
+
+	nf1 := client.NarFile.Create().SetHash("nar1").SetFileSize(2).SetTotalChunks(3).SaveX(ctx)
+	nf2 := client.NarFile.Create().SetHash("nar2").SetFileSize(1).SetTotalChunks(1).SaveX(ctx)
+
+	refCount := func(id int) int64 {
+		t.Helper()
+
+		return client.Chunk.GetX(ctx, id).RefCount
+	}
+
+	// nar1 links the shared chunk twice; nar2 links it once.
+	links := []struct{ narFileID, chunkID, index int }{
+		{nf1.ID, shared.ID, 0},
+		{nf1.ID, own.ID, 1},
+		{nf1.ID, shared.ID, 2},
+		{nf2.ID, shared.ID, 0},
+	}
+
+	require.NoError(t, c.WithTransaction(ctx, "link", func(tx *ent.Tx) error {
+		chunkIDs := make([]int, 0, len(links))
+
+		for _, l := range links {
+			if err := tx.NarFileChunk.Create().
+				SetNarFileID(l.narFileID).
+				SetChunkID(l.chunkID).
+				SetChunkIndex(l.index).
+				Exec(ctx); err != nil {
+				return err
+			}
+
+			chunkIDs = append(chunkIDs, l.chunkID)
+		}
+
+		return database.AddChunkRefs(ctx, tx, chunkIDs)
+	}))
+
+	assert.Equal(t, int64(3), refCount(shared.ID))
+	assert.Equal(t, int64(1), refCount(own.ID))
+
+	// Unlinking nar1 drops its three references.
+	require.NoError(t, c.WithTransaction(ctx, "unlink", func(tx *ent.Tx) error {
+		deleted, err := database.UnlinkNarFilesChunks(ctx, tx, entnarfile.IDEQ(nf1.ID))
+		assert.Equal(t, 3, deleted)
+
+		return err
+	}))
+
+	assert.Equal(t, int64(1), refCount(shared.ID))
+	assert.Equal(t, int64(0), refCount(own.ID))
+
+	// A drifted count is recounted from the links.
+	client.Chunk.UpdateOneID(shared.ID).SetRefCount(7).ExecX(ctx)
+	client.Chunk.UpdateOneID(own.ID).SetRefCount(2).ExecX(ctx)
+
+	recounted, err := c.RecountChunkRefs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), recounted)
+
+	assert.Equal(t, int64(1), refCount(shared.ID))
+	assert.Equal(t, int64(0), refCount(own.ID))
+
+	recounted, err = c.RecountChunkRefs(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, recounted)
+}
@@ -80,6 +80,20 @@ func NewClient(sdb *sql.DB, t Type) (*Client, error) {
 	}, nil
 }
 
+// WrapDriver rebuilds the Ent client over the driver returned by wrap, e.g. to
+// inject faults into the queries. It must be called before the client is
+// shared; the Ent client it replaces is not closed as both share the *sql.DB.
+func (c *Client) WrapDriver(wrap func(dialect.Driver) dialect.Driver) error {
+	entDialect, err := EntDialectFor(c.dialect)
+	if err != nil {
+		return err
+	}
+
+	c.ent = ent.NewClient(ent.Driver(wrap(entsql.OpenDB(entDialect, c.sdb))))
+
+	return nil
+}
+
 // Ent returns the wrapped Ent client. Callers issue fluent queries
 // against this client (e.g. `c.Ent().NarInfo.Create()...`).
 func (c *Client) Ent() *ent.Client { return c.ent }
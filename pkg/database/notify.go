@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+var (
+	// ErrNotPostgreSQL is returned by the notifications of a client of another
+	// database type.
+	ErrNotPostgreSQL = errors.New("the database is not PostgreSQL")
+
+	// errNotPgxConn is returned by Listen when the pool hands out a connection
+	// of another driver.
+	errNotPgxConn = errors.New("the database connection is not a pgx connection")
+)
+
+// Notify sends payload to the listeners of channel with pg_notify. It returns
+// ErrNotPostgreSQL for the other database types.
+func (c *Client) Notify(ctx context.Context, channel, payload string) error {
+	if c.dialect != TypePostgreSQL {
+		return ErrNotPostgreSQL
+	}
+
+	if _, err := c.sdb.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, payload); err != nil {
+		return fmt.Errorf("error notifying %q: %w", channel, err)
+	}
+
+	return nil
+}
+
+// Listen calls handle with the payload of every notification sent to channel,
+// holding a connection of the pool meanwhile. It returns when ctx is done, or
+// with the error that broke the connection. It returns ErrNotPostgreSQL for the
+// other database types.
+func (c *Client) Listen(ctx context.Context, channel string, handle func(payload string)) error {
+	if c.dialect != TypePostgreSQL {
+		return ErrNotPostgreSQL
+	}
+
+	conn, err := c.sdb.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting a database connection: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(dc any) error {
+		// The connection may be wrapped by the otel instrumentation.
+		if r, ok := dc.(interface{ Raw() driver.Conn }); ok {
+			dc = r.Raw()
+		}
+
+		sc, ok := dc.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("%w: %T", errNotPgxConn, dc)
+		}
+
+		pc := sc.Conn()
+
+		if _, err := pc.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return fmt.Errorf("error listening to %q: %w", channel, err)
+		}
+
+		// The connection goes back to the pool: stop listening before, unless
+		// the connection is broken anyway.
+		defer func() {
+			_, _ = pc.Exec(context.WithoutCancel(ctx), "UNLISTEN "+pgx.Identifier{channel}.Sanitize())
+		}()
+
+		for {
+			n, err := pc.WaitForNotification(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+
+				return fmt.Errorf("error waiting for a notification of %q: %w", channel, err)
+			}
+
+			handle(n.Payload)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/database"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestNotify_NotPostgreSQL(t *testing.T) {
+	t.Parallel()
+
+	sdb, cleanup := freshSchemaSQLite(t)
+	t.Cleanup(cleanup)
+
+	c, err := database.NewClient(sdb, database.TypeSQLite)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, c.Notify(context.Background(), "ch", "payload"), database.ErrNotPostgreSQL)
+	require.ErrorIs(t, c.Listen(context.Background(), "ch", func(string) {}), database.ErrNotPostgreSQL)
+}
+
+func TestListenNotify_PostgreSQL(t *testing.T) {
+	t.Parallel()
+
+	c, _, cleanup := testhelper.SetupPostgres(t)
+	t.Cleanup(cleanup)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	payloads := make(chan string, 1)
+	listenErr := make(chan error, 1)
+
+	go func() {
+		listenErr <- c.Listen(ctx, "ncps_test", func(payload string) {
+			select {
+			case payloads <- payload:
+			default:
+			}
+		})
+	}()
+
+	// The listener may not be registered yet: notify until it is.
+	require.Eventually(t, func() bool {
+		assert.NoError(t, c.Notify(ctx, "ncps_test", "hello"))
+
+		select {
+		case payload := <-payloads:
+			assert.Equal(t, "hello", payload)
+
+			return true
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}, 10*time.Second, 10*time.Millisecond)
+
+	cancel()
+
+	require.NoError(t, <-listenErr)
+}
@@ -0,0 +1,364 @@
+// Package softlimit warns before the database outgrows its limits: it checks
+// the size, the rows and the bloat of the database against thresholds and
+// reports the thresholds crossed through the logs, the metrics and a webhook.
+package softlimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/kalbasit/ncps/pkg/database"
+)
+
+const (
+	otelPackageName = "github.com/kalbasit/ncps/pkg/database/softlimit"
+
+	// DefaultInterval is the default interval between two checks.
+	DefaultInterval = 5 * time.Minute
+
+	// webhookTimeout bounds the delivery of a webhook.
+	webhookTimeout = 10 * time.Second
+
+	// LimitSize is the name of the limit on the size of the database.
+	LimitSize = "size"
+
+	// LimitBloatRatio is the name of the limit on the bloat of the database.
+	LimitBloatRatio = "bloat_ratio"
+
+	// limitRowsPrefix prefixes the name of the limit on the rows of a table.
+	limitRowsPrefix = "rows:"
+
+	// StateExceeded is the state of a limit the database is over.
+	StateExceeded = "exceeded"
+
+	// StateResolved is the state of a limit the database went back under.
+	StateResolved = "resolved"
+)
+
+// ErrWebhookFailed is returned when the webhook responds with an error status.
+var ErrWebhookFailed = errors.New("the webhook failed")
+
+//nolint:gochecknoglobals
+var (
+	sizeMetric       metric.Int64ObservableGauge
+	rowsMetric       metric.Int64ObservableGauge
+	bloatRatioMetric metric.Float64ObservableGauge
+	exceededMetric   metric.Int64ObservableGauge
+)
+
+//nolint:gochecknoinits
+func init() {
+	meter := otel.Meter(otelPackageName)
+
+	var err error
+
+	sizeMetric, err = meter.Int64ObservableGauge(
+		"ncps_database_size_bytes",
+		metric.WithDescription("The size of the database."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	rowsMetric, err = meter.Int64ObservableGauge(
+		"ncps_database_rows",
+		metric.WithDescription("The number of rows of the tables with a soft limit."),
+		metric.WithUnit("{row}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	bloatRatioMetric, err = meter.Float64ObservableGauge(
+		"ncps_database_bloat_ratio",
+		metric.WithDescription("The share of the database that is reclaimable (0.0 to 1.0)."),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	exceededMetric, err = meter.Int64ObservableGauge(
+		"ncps_database_soft_limit_exceeded",
+		metric.WithDescription("Whether the database is over a soft limit (1) or not (0)."),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Limits are the soft limits of the database. A zero limit is disabled.
+type Limits struct {
+	// Size is the size of the database in bytes.
+	Size int64
+
+	// Rows is the number of rows by table.
+	Rows map[string]int64
+
+	// BloatRatio is the share of the database that is reclaimable, from 0 to 1.
+	BloatRatio float64
+}
+
+// Enabled returns whether any limit is set.
+func (l Limits) Enabled() bool {
+	return l.Size > 0 || l.BloatRatio > 0 || len(l.Rows) > 0
+}
+
+// Options configures a Monitor.
+type Options struct {
+	Limits Limits
+
+	// Interval is the interval between two checks. Defaults to DefaultInterval.
+	Interval time.Duration
+
+	// WebhookURL receives a POST of an Event, as JSON, when a limit is exceeded
+	// or resolved. No webhook is sent when empty.
+	WebhookURL string
+
+	// HTTPClient sends the webhooks. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Event is the payload of the webhook.
+type Event struct {
+	Limit     string    `json:"limit"`
+	State     string    `json:"state"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Time      time.Time `json:"time"`
+}
+
+// Monitor checks the database against its soft limits.
+type Monitor struct {
+	dbClient *database.Client
+	opts     Options
+
+	mu       sync.Mutex
+	usage    database.Usage
+	checked  bool
+	exceeded map[string]bool
+}
+
+// New returns a Monitor of the database of dbClient and registers its metrics.
+func New(dbClient *database.Client, opts Options) (*Monitor, error) {
+	for table := range opts.Limits.Rows {
+		if err := database.ValidateTable(table); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+
+	m := &Monitor{
+		dbClient: dbClient,
+		opts:     opts,
+		exceeded: make(map[string]bool),
+	}
+
+	_, err := otel.Meter(otelPackageName).RegisterCallback(
+		m.observe,
+		sizeMetric, rowsMetric, bloatRatioMetric, exceededMetric,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error registering the metrics callback: %w", err)
+	}
+
+	return m, nil
+}
+
+// Run checks the database every interval until ctx is done.
+func (m *Monitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Check(ctx); err != nil && ctx.Err() == nil {
+			zerolog.Ctx(ctx).
+				Warn().
+				Err(err).
+				Msg("error checking the database soft limits")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check reads the usage of the database and reports the limits it crossed in
+// either direction since the previous check.
+func (m *Monitor) Check(ctx context.Context) error {
+	usage, err := m.dbClient.Usage(ctx, slices.Sorted(maps.Keys(m.opts.Limits.Rows)))
+	if err != nil {
+		return fmt.Errorf("error reading the database usage: %w", err)
+	}
+
+	now := time.Now()
+
+	var events []Event
+
+	m.mu.Lock()
+
+	m.usage = usage
+	m.checked = true
+
+	for _, c := range m.comparisons(usage) {
+		exceeded := c.value > c.threshold
+		if exceeded == m.exceeded[c.limit] {
+			continue
+		}
+
+		m.exceeded[c.limit] = exceeded
+
+		state := StateResolved
+		if exceeded {
+			state = StateExceeded
+		}
+
+		events = append(events, Event{
+			Limit:     c.limit,
+			State:     state,
+			Value:     c.value,
+			Threshold: c.threshold,
+			Time:      now,
+		})
+	}
+
+	m.mu.Unlock()
+
+	for _, e := range events {
+		m.report(ctx, e)
+	}
+
+	return nil
+}
+
+type comparison struct {
+	limit            string
+	value, threshold float64
+}
+
+func (m *Monitor) comparisons(usage database.Usage) []comparison {
+	var cs []comparison
+
+	if m.opts.Limits.Size > 0 {
+		cs = append(cs, comparison{LimitSize, float64(usage.SizeBytes), float64(m.opts.Limits.Size)})
+	}
+
+	if m.opts.Limits.BloatRatio > 0 {
+		cs = append(cs, comparison{LimitBloatRatio, usage.BloatRatio, m.opts.Limits.BloatRatio})
+	}
+
+	for _, table := range slices.Sorted(maps.Keys(m.opts.Limits.Rows)) {
+		if threshold := m.opts.Limits.Rows[table]; threshold > 0 {
+			cs = append(cs, comparison{limitRowsPrefix + table, float64(usage.Rows[table]), float64(threshold)})
+		}
+	}
+
+	return cs
+}
+
+func (m *Monitor) report(ctx context.Context, e Event) {
+	log := zerolog.Ctx(ctx)
+
+	var ev *zerolog.Event
+	if e.State == StateExceeded {
+		ev = log.Warn()
+	} else {
+		ev = log.Info()
+	}
+
+	ev.
+		Str("limit", e.Limit).
+		Float64("value", e.Value).
+		Float64("threshold", e.Threshold).
+		Msgf("the database soft limit is %s", e.State)
+
+	if m.opts.WebhookURL == "" {
+		return
+	}
+
+	if err := m.sendWebhook(ctx, e); err != nil {
+		log.Warn().
+			Err(err).
+			Str("limit", e.Limit).
+			Msg("error sending the database soft limit webhook")
+	}
+}
+
+func (m *Monitor) sendWebhook(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error encoding the event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.opts.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating the request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.opts.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending the request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%w: %s", ErrWebhookFailed, resp.Status)
+	}
+
+	return nil
+}
+
+func (m *Monitor) observe(_ context.Context, o metric.Observer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.checked {
+		return nil
+	}
+
+	o.ObserveInt64(sizeMetric, m.usage.SizeBytes)
+	o.ObserveFloat64(bloatRatioMetric, m.usage.BloatRatio)
+
+	for table, rows := range m.usage.Rows {
+		o.ObserveInt64(rowsMetric, rows, metric.WithAttributes(attribute.String("table", table)))
+	}
+
+	for _, c := range m.comparisons(m.usage) {
+		var exceeded int64
+		if m.exceeded[c.limit] {
+			exceeded = 1
+		}
+
+		o.ObserveInt64(exceededMetric, exceeded, metric.WithAttributes(attribute.String("limit", c.limit)))
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package softlimit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/database"
+	"github.com/kalbasit/ncps/pkg/database/softlimit"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestMonitor(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dbClient, cleanup := testhelper.SetupSQLite(t)
+	t.Cleanup(cleanup)
+
+	var (
+		mu     sync.Mutex
+		events []softlimit.Event
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e softlimit.Event
+
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(ts.Close)
+
+	m, err := softlimit.New(dbClient, softlimit.Options{
+		Limits:     softlimit.Limits{Rows: map[string]int64{"chunks": 1}},
+		WebhookURL: ts.URL,
+	})
+	require.NoError(t, err)
+
+	states := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var s []string
+		for _, e := range events {
+			s = append(s, e.Limit+"="+e.State)
+		}
+
+		return s
+	}
+
+	dbClient.Ent().Chunk.Create().SetHash("a").SetSize(1).SaveX(ctx)
+
+	require.NoError(t, m.Check(ctx))
+	assert.Empty(t, states(), "under the limit")
+
+	c := dbClient.Ent().Chunk.Create().SetHash("b").SetSize(1).SaveX(ctx)
+
+	require.NoError(t, m.Check(ctx))
+	require.NoError(t, m.Check(ctx))
+	assert.Equal(t, []string{"rows:chunks=exceeded"}, states(), "reported once")
+
+	dbClient.Ent().Chunk.DeleteOne(c).ExecX(ctx)
+
+	require.NoError(t, m.Check(ctx))
+	assert.Equal(t, []string{"rows:chunks=exceeded", "rows:chunks=resolved"}, states())
+}
+
+func TestNewUnknownTable(t *testing.T) {
+	t.Parallel()
+
+	dbClient, cleanup := testhelper.SetupSQLite(t)
+	t.Cleanup(cleanup)
+
+	_, err := softlimit.New(dbClient, softlimit.Options{
+		Limits: softlimit.Limits{Rows: map[string]int64{"nope": 1}},
+	})
+	require.ErrorIs(t, err, database.ErrUnknownTable)
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// sqliteAutoVacuumIncremental is the value of PRAGMA auto_vacuum in the
+// incremental mode.
+const sqliteAutoVacuumIncremental = 2
+
+// ErrNotSQLite is returned by the SQLite maintenance of a client of another
+// database type.
+var ErrNotSQLite = errors.New("the database is not SQLite")
+
+// SQLiteConfig tunes the write-ahead log of a SQLite database.
+type SQLiteConfig struct {
+	// WALAutoCheckpoint is the number of pages the WAL grows to before it is
+	// checkpointed automatically. If <= 0, the SQLite default (1000) is kept.
+	WALAutoCheckpoint int
+
+	// JournalSizeLimit is the size in bytes the WAL is truncated to after a
+	// checkpoint. If <= 0, the WAL is never truncated, the SQLite default.
+	JournalSizeLimit int64
+}
+
+// ConfigureSQLite applies cfg to the database. It is a no-op for the other
+// database types.
+func (c *Client) ConfigureSQLite(ctx context.Context, cfg SQLiteConfig) error {
+	if c.dialect != TypeSQLite {
+		return nil
+	}
+
+	if cfg.WALAutoCheckpoint > 0 {
+		query := fmt.Sprintf("PRAGMA wal_autocheckpoint = %d", cfg.WALAutoCheckpoint)
+		if _, err := c.sdb.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("error setting the WAL auto-checkpoint: %w", err)
+		}
+	}
+
+	if cfg.JournalSizeLimit > 0 {
+		query := fmt.Sprintf("PRAGMA journal_size_limit = %d", cfg.JournalSizeLimit)
+		if _, err := c.sdb.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("error setting the journal size limit: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// SQLiteMaintenanceStats summarizes a RunSQLiteMaintenance run.
+type SQLiteMaintenanceStats struct {
+	// CheckpointBusy is true when the checkpoint could not complete because
+	// of a concurrent reader or writer; the WAL was then not truncated.
+	CheckpointBusy bool
+
+	// CheckpointedFrames is the number of WAL frames moved to the database.
+	CheckpointedFrames int64
+
+	// ConvertedToIncrementalVacuum is true when the database was rebuilt to
+	// enable the incremental vacuum.
+	ConvertedToIncrementalVacuum bool
+
+	// FreedPages is the number of free pages returned to the filesystem.
+	FreedPages int64
+}
+
+// RunSQLiteMaintenance checkpoints and truncates the WAL, returns up to
+// vacuumPages free pages to the filesystem (all of them when vacuumPages <= 0)
+// and refreshes the query planner statistics that are stale. The incremental
+// vacuum needs the incremental auto_vacuum mode: the first run on a database
+// created without it switches the mode with a full VACUUM, which rewrites the
+// database and blocks it meanwhile. It returns ErrNotSQLite for the other
+// database types.
+func (c *Client) RunSQLiteMaintenance(ctx context.Context, vacuumPages int) (SQLiteMaintenanceStats, error) {
+	var stats SQLiteMaintenanceStats
+
+	if c.dialect != TypeSQLite {
+		return stats, ErrNotSQLite
+	}
+
+	// Pin a connection: the pragmas of a maintenance run apply together.
+	conn, err := c.sdb.Conn(ctx)
+	if err != nil {
+		return stats, fmt.Errorf("error getting a connection: %w", err)
+	}
+	defer conn.Close()
+
+	var busy, logFrames int64
+
+	if err := conn.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").
+		Scan(&busy, &logFrames, &stats.CheckpointedFrames); err != nil {
+		return stats, fmt.Errorf("error checkpointing the WAL: %w", err)
+	}
+
+	stats.CheckpointBusy = busy != 0
+
+	var autoVacuum int
+
+	if err := conn.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&autoVacuum); err != nil {
+		return stats, fmt.Errorf("error reading the auto_vacuum mode: %w", err)
+	}
+
+	before, err := sqliteFreelistCount(ctx, conn)
+	if err != nil {
+		return stats, err
+	}
+
+	if autoVacuum != sqliteAutoVacuumIncremental {
+		if _, err := conn.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+			return stats, fmt.Errorf("error enabling the incremental vacuum: %w", err)
+		}
+
+		// Switching from NONE only takes effect after a VACUUM.
+		if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+			return stats, fmt.Errorf("error vacuuming the database: %w", err)
+		}
+
+		stats.ConvertedToIncrementalVacuum = true
+	} else if err := sqliteIncrementalVacuum(ctx, conn, vacuumPages); err != nil {
+		return stats, err
+	}
+
+	after, err := sqliteFreelistCount(ctx, conn)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.FreedPages = before - after
+
+	// Runs ANALYZE on the tables whose statistics are stale, bounded so it
+	// stays cheap on a large database.
+	if _, err := conn.ExecContext(ctx, "PRAGMA analysis_limit = 1000"); err != nil {
+		return stats, fmt.Errorf("error setting the analysis limit: %w", err)
+	}
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA optimize"); err != nil {
+		return stats, fmt.Errorf("error optimizing the database: %w", err)
+	}
+
+	return stats, nil
+}
+
+func sqliteFreelistCount(ctx context.Context, conn *sql.Conn) (int64, error) {
+	var count int64
+
+	if err := conn.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&count); err != nil {
+		return 0, fmt.Errorf("error reading the freelist count: %w", err)
+	}
+
+	return count, nil
+}
+
+func sqliteIncrementalVacuum(ctx context.Context, conn *sql.Conn, pages int) error {
+	// The pragma frees a page per step, so its rows must be drained.
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("PRAGMA incremental_vacuum(%d)", max(pages, 0)))
+	if err != nil {
+		return fmt.Errorf("error running the incremental vacuum: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() { //nolint:revive // draining the rows runs the vacuum
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error running the incremental vacuum: %w", err)
+	}
+
+	return nil
+}
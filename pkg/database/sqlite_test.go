@@ -0,0 +1,98 @@
+package database_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/database"
+)
+
+func TestRunSQLiteMaintenance(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	c, err := database.Open("sqlite:"+filepath.Join(t.TempDir(), "db.sqlite"), nil)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = c.Close() })
+
+	require.NoError(t, c.ConfigureSQLite(ctx, database.SQLiteConfig{
+		WALAutoCheckpoint: 100,
+		JournalSizeLimit:  1 << 20,
+	}))
+
+	var autoCheckpoint int
+
+	require.NoError(t, c.DB().QueryRowContext(ctx, "PRAGMA wal_autocheckpoint").Scan(&autoCheckpoint))
+	assert.Equal(t, 100, autoCheckpoint)
+
+	fillAndEmpty := func(t *testing.T) {
+		t.Helper()
+
+		_, err := c.DB().ExecContext(ctx, "CREATE TABLE IF NOT EXISTS blobs (b TEXT)")
+		require.NoError(t, err)
+
+		for range 200 {
+			_, err := c.DB().ExecContext(ctx, "INSERT INTO blobs (b) VALUES (?)", strings.Repeat("x", 4096))
+			require.NoError(t, err)
+		}
+
+		_, err = c.DB().ExecContext(ctx, "DELETE FROM blobs")
+		require.NoError(t, err)
+	}
+
+	// The first run enables the incremental vacuum.
+	fillAndEmpty(t)
+
+	stats, err := c.RunSQLiteMaintenance(ctx, 0)
+	require.NoError(t, err)
+	assert.True(t, stats.ConvertedToIncrementalVacuum)
+	assert.False(t, stats.CheckpointBusy)
+	assert.Positive(t, stats.FreedPages)
+
+	var autoVacuum int
+
+	require.NoError(t, c.DB().QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&autoVacuum))
+	assert.Equal(t, 2, autoVacuum)
+
+	// The next runs vacuum incrementally, at most vacuumPages at a time.
+	fillAndEmpty(t)
+
+	stats, err = c.RunSQLiteMaintenance(ctx, 10)
+	require.NoError(t, err)
+	assert.False(t, stats.ConvertedToIncrementalVacuum)
+	assert.Equal(t, int64(10), stats.FreedPages)
+
+	stats, err = c.RunSQLiteMaintenance(ctx, 0)
+	require.NoError(t, err)
+	assert.Positive(t, stats.FreedPages)
+
+	var freePages int64
+
+	require.NoError(t, c.DB().QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&freePages))
+	assert.Zero(t, freePages)
+}
+
+func TestRunSQLiteMaintenance_NotSQLite(t *testing.T) {
+	t.Parallel()
+
+	sdb, err := database.Open("sqlite:"+filepath.Join(t.TempDir(), "db.sqlite"), nil)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = sdb.Close() })
+
+	// The maintenance checks the type before touching the database.
+	c, err := database.NewClient(sdb.DB(), database.TypePostgreSQL)
+	require.NoError(t, err)
+
+	_, err = c.RunSQLiteMaintenance(context.Background(), 0)
+	require.ErrorIs(t, err, database.ErrNotSQLite)
+
+	require.NoError(t, c.ConfigureSQLite(context.Background(), database.SQLiteConfig{WALAutoCheckpoint: 1}))
+}
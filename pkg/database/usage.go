@@ -0,0 +1,189 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	entschema "entgo.io/ent/dialect/sql/schema"
+
+	entmigrate "github.com/kalbasit/ncps/ent/migrate"
+)
+
+// ErrUnknownTable is returned for a table that is not part of the schema.
+var ErrUnknownTable = errors.New("unknown table")
+
+// Usage is the space and the rows a database holds.
+type Usage struct {
+	// SizeBytes is the size of the database.
+	SizeBytes int64
+
+	// BloatRatio is the share of the database that is reclaimable: the free
+	// pages of SQLite, the free space of the MySQL tables or the dead tuples of
+	// PostgreSQL.
+	BloatRatio float64
+
+	// Rows is the number of rows of the requested tables. It is exact on
+	// SQLite and the statistics estimate on PostgreSQL and MySQL.
+	Rows map[string]int64
+}
+
+// ValidateTable returns ErrUnknownTable unless table is a table of the schema.
+func ValidateTable(table string) error {
+	if !slices.ContainsFunc(entmigrate.Tables, func(t *entschema.Table) bool { return t.Name == table }) {
+		return fmt.Errorf("%w: %q", ErrUnknownTable, table)
+	}
+
+	return nil
+}
+
+// Usage returns the usage of the database, with the rows of tables.
+func (c *Client) Usage(ctx context.Context, tables []string) (Usage, error) {
+	for _, table := range tables {
+		if err := ValidateTable(table); err != nil {
+			return Usage{}, err
+		}
+	}
+
+	usage := Usage{Rows: make(map[string]int64, len(tables))}
+
+	var err error
+
+	switch c.dialect {
+	case TypeSQLite:
+		err = c.sqliteUsage(ctx, tables, &usage)
+	case TypePostgreSQL:
+		err = c.postgresUsage(ctx, tables, &usage)
+	case TypeMySQL:
+		err = c.mysqlUsage(ctx, tables, &usage)
+	case TypeUnknown:
+		fallthrough
+	default:
+		err = fmt.Errorf("%w: %v", ErrUnknownDialect, c.dialect)
+	}
+
+	if err != nil {
+		return Usage{}, err
+	}
+
+	return usage, nil
+}
+
+func (c *Client) sqliteUsage(ctx context.Context, tables []string, usage *Usage) error {
+	var pageCount, pageSize, freePages int64
+
+	if err := c.sdb.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pageCount); err != nil {
+		return fmt.Errorf("error reading the page count: %w", err)
+	}
+
+	if err := c.sdb.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return fmt.Errorf("error reading the page size: %w", err)
+	}
+
+	if err := c.sdb.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&freePages); err != nil {
+		return fmt.Errorf("error reading the freelist count: %w", err)
+	}
+
+	usage.SizeBytes = pageCount * pageSize
+
+	if pageCount > 0 {
+		usage.BloatRatio = float64(freePages) / float64(pageCount)
+	}
+
+	for _, table := range tables {
+		var rows int64
+
+		//nolint:gosec // G202: table is one of the tables of the schema
+		if err := c.sdb.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&rows); err != nil {
+			return fmt.Errorf("error counting the rows of %s: %w", table, err)
+		}
+
+		usage.Rows[table] = rows
+	}
+
+	return nil
+}
+
+func (c *Client) postgresUsage(ctx context.Context, tables []string, usage *Usage) error {
+	if err := c.sdb.QueryRowContext(ctx, "SELECT pg_database_size(current_database())").
+		Scan(&usage.SizeBytes); err != nil {
+		return fmt.Errorf("error reading the database size: %w", err)
+	}
+
+	rows, err := c.sdb.QueryContext(ctx, "SELECT relname, n_live_tup, n_dead_tup FROM pg_stat_user_tables")
+	if err != nil {
+		return fmt.Errorf("error reading the table statistics: %w", err)
+	}
+	defer rows.Close()
+
+	var live, dead int64
+
+	for rows.Next() {
+		var (
+			table                string
+			tableLive, tableDead int64
+		)
+
+		if err := rows.Scan(&table, &tableLive, &tableDead); err != nil {
+			return fmt.Errorf("error reading the table statistics: %w", err)
+		}
+
+		live += tableLive
+		dead += tableDead
+
+		if slices.Contains(tables, table) {
+			usage.Rows[table] = tableLive
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading the table statistics: %w", err)
+	}
+
+	if live+dead > 0 {
+		usage.BloatRatio = float64(dead) / float64(live+dead)
+	}
+
+	return nil
+}
+
+func (c *Client) mysqlUsage(ctx context.Context, tables []string, usage *Usage) error {
+	rows, err := c.sdb.QueryContext(ctx, `SELECT table_name, COALESCE(table_rows, 0),
+		COALESCE(data_length, 0) + COALESCE(index_length, 0), COALESCE(data_free, 0)
+		FROM information_schema.tables WHERE table_schema = DATABASE()`)
+	if err != nil {
+		return fmt.Errorf("error reading the table statistics: %w", err)
+	}
+	defer rows.Close()
+
+	var free int64
+
+	for rows.Next() {
+		var (
+			table                  string
+			tableRows, size, tFree int64
+		)
+
+		if err := rows.Scan(&table, &tableRows, &size, &tFree); err != nil {
+			return fmt.Errorf("error reading the table statistics: %w", err)
+		}
+
+		usage.SizeBytes += size
+		free += tFree
+
+		if slices.Contains(tables, table) {
+			usage.Rows[table] = tableRows
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading the table statistics: %w", err)
+	}
+
+	if usage.SizeBytes+free > 0 {
+		usage.BloatRatio = float64(free) / float64(usage.SizeBytes+free)
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/database"
+)
+
+func TestUsage(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sdb, cleanup := freshSchemaSQLite(t)
+	t.Cleanup(cleanup)
+
+	c, err := database.NewClient(sdb, database.TypeSQLite)
+	require.NoError(t, err)
+
+	c.Ent().Chunk.Create().SetHash("a").SetSize(1).SaveX(ctx)
+	c.Ent().Chunk.Create().SetHash("b").SetSize(1).SaveX(ctx)
+
+	usage, err := c.Usage(ctx, []string{"chunks", "narinfos"})
+	require.NoError(t, err)
+
+	assert.Positive(t, usage.SizeBytes)
+	assert.GreaterOrEqual(t, usage.BloatRatio, 0.0)
+	assert.Equal(t, map[string]int64{"chunks": 2, "narinfos": 0}, usage.Rows)
+
+	_, err = c.Usage(ctx, []string{"chunks; DROP TABLE chunks"})
+	require.ErrorIs(t, err, database.ErrUnknownTable)
+}
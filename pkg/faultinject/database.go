@@ -0,0 +1,110 @@
+package faultinject
+
+import (
+	"context"
+	"database/sql"
+
+	"entgo.io/ent/dialect"
+)
+
+// Driver returns next with the faults of the database target injected, or next
+// itself if i is nil. The operations are exec, query, begin and commit; like a
+// real failed commit, a failed commit rolls the transaction back. Rollbacks are
+// never failed.
+func (i *Injector) Driver(next dialect.Driver) dialect.Driver {
+	if i == nil || next == nil {
+		return next
+	}
+
+	return &driver{Driver: next, inj: i}
+}
+
+type driver struct {
+	dialect.Driver
+
+	inj *Injector
+}
+
+func (d *driver) Exec(ctx context.Context, query string, args, v any) error {
+	if err := d.inj.Inject(ctx, TargetDatabase, "exec"); err != nil {
+		return err
+	}
+
+	return d.Driver.Exec(ctx, query, args, v)
+}
+
+func (d *driver) Query(ctx context.Context, query string, args, v any) error {
+	if err := d.inj.Inject(ctx, TargetDatabase, "query"); err != nil {
+		return err
+	}
+
+	return d.Driver.Query(ctx, query, args, v)
+}
+
+func (d *driver) Tx(ctx context.Context) (dialect.Tx, error) {
+	if err := d.inj.Inject(ctx, TargetDatabase, "begin"); err != nil {
+		return nil, err
+	}
+
+	tx, err := d.Driver.Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &faultTx{Tx: tx, ctx: ctx, inj: d.inj}, nil
+}
+
+// BeginTx starts a transaction with opts, if the wrapped driver supports it.
+func (d *driver) BeginTx(ctx context.Context, opts *sql.TxOptions) (dialect.Tx, error) {
+	beginner, ok := d.Driver.(interface {
+		BeginTx(ctx context.Context, opts *sql.TxOptions) (dialect.Tx, error)
+	})
+	if !ok {
+		return d.Tx(ctx)
+	}
+
+	if err := d.inj.Inject(ctx, TargetDatabase, "begin"); err != nil {
+		return nil, err
+	}
+
+	tx, err := beginner.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &faultTx{Tx: tx, ctx: ctx, inj: d.inj}, nil
+}
+
+type faultTx struct {
+	dialect.Tx
+
+	// ctx is the context the transaction was started with; Commit has none.
+	ctx context.Context
+	inj *Injector
+}
+
+func (t *faultTx) Exec(ctx context.Context, query string, args, v any) error {
+	if err := t.inj.Inject(ctx, TargetDatabase, "exec"); err != nil {
+		return err
+	}
+
+	return t.Tx.Exec(ctx, query, args, v)
+}
+
+func (t *faultTx) Query(ctx context.Context, query string, args, v any) error {
+	if err := t.inj.Inject(ctx, TargetDatabase, "query"); err != nil {
+		return err
+	}
+
+	return t.Tx.Query(ctx, query, args, v)
+}
+
+func (t *faultTx) Commit() error {
+	if err := t.inj.Inject(t.ctx, TargetDatabase, "commit"); err != nil {
+		_ = t.Tx.Rollback()
+
+		return err
+	}
+
+	return t.Tx.Commit()
+}
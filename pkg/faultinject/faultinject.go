@@ -0,0 +1,314 @@
+// Package faultinject injects delays and errors into the storage, the chunk
+// store, the database and the upstream caches at configurable probabilities,
+// so operators and CI can test how ncps behaves under partial failures. It is
+// enabled by the NCPS_FAULT_INJECTION environment variable only, and must never
+// be enabled in production.
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// EnvVar is the environment variable holding the fault injection spec.
+	EnvVar = "NCPS_FAULT_INJECTION"
+
+	// The targets faults are injected into.
+	TargetStorage  = "storage"
+	TargetChunk    = "chunk"
+	TargetDatabase = "database"
+	TargetUpstream = "upstream"
+
+	otelPackageName = "github.com/kalbasit/ncps/pkg/faultinject"
+)
+
+var (
+	// ErrInjected is returned by the operations failed by an injected fault.
+	ErrInjected = errors.New("injected fault")
+
+	// ErrInvalidSpec is returned by Parse for a malformed spec.
+	ErrInvalidSpec = errors.New("invalid fault injection spec")
+
+	//nolint:gochecknoglobals
+	faultsInjectedTotal metric.Int64Counter
+)
+
+//nolint:gochecknoinits
+func init() {
+	var err error
+
+	faultsInjectedTotal, err = otel.Meter(otelPackageName).Int64Counter(
+		"ncps_faults_injected_total",
+		metric.WithDescription("Counts the faults injected by NCPS_FAULT_INJECTION."),
+		metric.WithUnit("{fault}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Fault is what is injected into the operations of a rule.
+type Fault struct {
+	// ErrorRate is the probability, between 0 and 1, an operation fails with
+	// ErrInjected.
+	ErrorRate float64
+
+	// Delay is added to an operation, with probability DelayRate, before it
+	// runs or fails.
+	Delay     time.Duration
+	DelayRate float64
+}
+
+// Injector injects the faults of its rules. A nil Injector injects nothing.
+type Injector struct {
+	// rules maps a target, or a target and an operation as target.op, to its
+	// fault.
+	rules map[string]Fault
+
+	// float64 returns a pseudo-random number in [0, 1); tests replace it.
+	float64 func() float64
+}
+
+// Parse parses a spec of rules separated by semicolons. A rule is a target
+// (storage, chunk, database or upstream), optionally narrowed to one operation
+// as target.op, followed by a colon and comma-separated settings:
+//
+//   - error=P fails the operations with probability P.
+//   - delay=D delays the operations by the duration D.
+//   - delay-rate=P delays the operations with probability P (default 1).
+//
+// For example, "storage:error=0.05;database.commit:error=0.1,delay=2s". The
+// rule of an operation takes precedence over the rule of its target. The
+// operations are matched case-insensitively.
+func Parse(spec string) (*Injector, error) {
+	inj := &Injector{rules: make(map[string]Fault), float64: rand.Float64}
+
+	for rule := range strings.SplitSeq(spec, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		name, settings, ok := strings.Cut(rule, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: rule %q has no settings", ErrInvalidSpec, rule)
+		}
+
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		target, _, _ := strings.Cut(name, ".")
+		switch target {
+		case TargetStorage, TargetChunk, TargetDatabase, TargetUpstream:
+		default:
+			return nil, fmt.Errorf("%w: unknown target %q", ErrInvalidSpec, target)
+		}
+
+		if _, ok := inj.rules[name]; ok {
+			return nil, fmt.Errorf("%w: duplicate rule for %q", ErrInvalidSpec, name)
+		}
+
+		fault, err := parseFault(settings)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", name, err)
+		}
+
+		inj.rules[name] = fault
+	}
+
+	if len(inj.rules) == 0 {
+		return nil, fmt.Errorf("%w: no rules", ErrInvalidSpec)
+	}
+
+	return inj, nil
+}
+
+// FromEnv parses the spec of NCPS_FAULT_INJECTION. It returns nil when the
+// variable is unset or empty.
+func FromEnv() (*Injector, error) {
+	spec := os.Getenv(EnvVar)
+	if spec == "" {
+		return nil, nil //nolint:nilnil // fault injection is disabled
+	}
+
+	inj, err := Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", EnvVar, err)
+	}
+
+	return inj, nil
+}
+
+// String returns the rules of the injector, sorted, in the syntax of Parse.
+func (i *Injector) String() string {
+	if i == nil {
+		return ""
+	}
+
+	rules := make([]string, 0, len(i.rules))
+
+	for name, fault := range i.rules {
+		var settings []string
+
+		if fault.ErrorRate > 0 {
+			settings = append(settings, "error="+strconv.FormatFloat(fault.ErrorRate, 'g', -1, 64))
+		}
+
+		if fault.Delay > 0 {
+			settings = append(settings,
+				"delay="+fault.Delay.String(),
+				"delay-rate="+strconv.FormatFloat(fault.DelayRate, 'g', -1, 64),
+			)
+		}
+
+		rules = append(rules, name+":"+strings.Join(settings, ","))
+	}
+
+	sort.Strings(rules)
+
+	return strings.Join(rules, ";")
+}
+
+// Inject applies the fault of the operation op of target: it sleeps for the
+// delay, if drawn, then returns ErrInjected if the error is drawn. It returns
+// the error of ctx if ctx is done while sleeping.
+func (i *Injector) Inject(ctx context.Context, target, op string) error {
+	if i == nil {
+		return nil
+	}
+
+	name := target + "." + strings.ToLower(op)
+
+	fault, ok := i.rules[name]
+	if !ok {
+		if fault, ok = i.rules[target]; !ok {
+			return nil
+		}
+	}
+
+	if fault.Delay > 0 && i.float64() < fault.DelayRate {
+		i.record(ctx, target, op, "delay")
+
+		t := time.NewTimer(fault.Delay)
+		defer t.Stop()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+
+	if fault.ErrorRate > 0 && i.float64() < fault.ErrorRate {
+		i.record(ctx, target, op, "error")
+
+		return fmt.Errorf("%w: %s", ErrInjected, name)
+	}
+
+	return nil
+}
+
+func (i *Injector) record(ctx context.Context, target, op, fault string) {
+	zerolog.Ctx(ctx).
+		Debug().
+		Str("target", target).
+		Str("op", op).
+		Str("fault", fault).
+		Msg("injecting a fault")
+
+	faultsInjectedTotal.Add(ctx, 1,
+		metric.WithAttributes(
+			attribute.String("target", target),
+			attribute.String("op", op),
+			attribute.String("fault", fault),
+		),
+	)
+}
+
+func parseFault(settings string) (Fault, error) {
+	fault := Fault{DelayRate: 1}
+
+	for setting := range strings.SplitSeq(settings, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(setting), "=")
+		if !ok {
+			return Fault{}, fmt.Errorf("%w: setting %q is not key=value", ErrInvalidSpec, setting)
+		}
+
+		var err error
+
+		switch key {
+		case "error":
+			fault.ErrorRate, err = parseRate(value)
+		case "delay":
+			fault.Delay, err = parseDelay(value)
+		case "delay-rate":
+			fault.DelayRate, err = parseRate(value)
+		default:
+			err = fmt.Errorf("%w: unknown setting %q", ErrInvalidSpec, key)
+		}
+
+		if err != nil {
+			return Fault{}, err
+		}
+	}
+
+	if fault.ErrorRate == 0 && fault.Delay == 0 {
+		return Fault{}, fmt.Errorf("%w: neither an error nor a delay is set", ErrInvalidSpec)
+	}
+
+	return fault, nil
+}
+
+func parseDelay(s string) (time.Duration, error) {
+	delay, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: error parsing the delay %q: %w", ErrInvalidSpec, s, err)
+	}
+
+	if delay < 0 {
+		return 0, fmt.Errorf("%w: delay %q is negative", ErrInvalidSpec, s)
+	}
+
+	return delay, nil
+}
+
+func parseRate(s string) (float64, error) {
+	rate, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: error parsing the probability %q: %w", ErrInvalidSpec, s, err)
+	}
+
+	if rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("%w: probability %q is not between 0 and 1", ErrInvalidSpec, s)
+	}
+
+	return rate, nil
+}
+
+//nolint:gochecknoglobals
+var ctxKey = &struct{}{}
+
+// WithContext returns a copy of ctx carrying the injector, for the code
+// building the stores and the upstream caches to find with Ctx.
+func (i *Injector) WithContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKey, i)
+}
+
+// Ctx returns the injector carried by ctx, or nil if it carries none.
+func Ctx(ctx context.Context) *Injector {
+	i, _ := ctx.Value(ctxKey).(*Injector)
+
+	return i
+}
@@ -0,0 +1,236 @@
+package faultinject_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/pkg/database"
+	"github.com/kalbasit/ncps/pkg/faultinject"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage/local"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		spec string
+		want string
+	}{
+		{"target", "storage:error=0.5", "storage:error=0.5"},
+		{"operation", " database.Commit : error=1 ", "database.commit:error=1"},
+		{"delay", "upstream:delay=2s", "upstream:delay=2s,delay-rate=1"},
+		{
+			"several rules",
+			"chunk:delay=100ms,delay-rate=0.25,error=0.1;storage.PutNar:error=1;",
+			"chunk:error=0.1,delay=100ms,delay-rate=0.25;storage.putnar:error=1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			inj, err := faultinject.Parse(tt.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, inj.String())
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, spec := range []string{
+		"",
+		";",
+		"storage",
+		"disk:error=1",
+		"storage:error=1;storage:delay=1s",
+		"storage:error=2",
+		"storage:error=-1",
+		"storage:error=often",
+		"storage:delay=-1s",
+		"storage:delay=soon",
+		"storage:delay-rate=1",
+		"storage:errors=1",
+		"storage:error",
+	} {
+		t.Run(spec, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := faultinject.Parse(spec)
+			require.ErrorIs(t, err, faultinject.ErrInvalidSpec)
+		})
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(faultinject.EnvVar, "")
+
+	inj, err := faultinject.FromEnv()
+	require.NoError(t, err)
+	assert.Nil(t, inj)
+
+	t.Setenv(faultinject.EnvVar, "storage:error=1")
+
+	inj, err = faultinject.FromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "storage:error=1", inj.String())
+
+	t.Setenv(faultinject.EnvVar, "storage")
+
+	_, err = faultinject.FromEnv()
+	require.ErrorIs(t, err, faultinject.ErrInvalidSpec)
+}
+
+func TestInject(t *testing.T) {
+	t.Parallel()
+
+	t.Run("nil injector", func(t *testing.T) {
+		t.Parallel()
+
+		var inj *faultinject.Injector
+
+		require.NoError(t, inj.Inject(context.Background(), faultinject.TargetStorage, "GetNar"))
+		assert.Nil(t, faultinject.Ctx(context.Background()))
+	})
+
+	t.Run("operation rule takes precedence", func(t *testing.T) {
+		t.Parallel()
+
+		inj, err := faultinject.Parse("storage:error=1;storage.GetNar:error=0,delay=1ms")
+		require.NoError(t, err)
+
+		ctx := context.Background()
+
+		require.ErrorIs(t, inj.Inject(ctx, faultinject.TargetStorage, "PutNar"), faultinject.ErrInjected)
+		require.NoError(t, inj.Inject(ctx, faultinject.TargetStorage, "GetNar"))
+		require.NoError(t, inj.Inject(ctx, faultinject.TargetDatabase, "query"))
+	})
+
+	t.Run("delay is cut short by the context", func(t *testing.T) {
+		t.Parallel()
+
+		inj, err := faultinject.Parse("upstream:delay=1h")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		require.ErrorIs(t, inj.Inject(ctx, faultinject.TargetUpstream, http.MethodGet), context.DeadlineExceeded)
+	})
+
+	t.Run("carried by the context", func(t *testing.T) {
+		t.Parallel()
+
+		inj, err := faultinject.Parse("storage:error=1")
+		require.NoError(t, err)
+
+		assert.Same(t, inj, faultinject.Ctx(inj.WithContext(context.Background())))
+	})
+}
+
+func TestNarStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	store, err := local.New(ctx, t.TempDir())
+	require.NoError(t, err)
+
+	inj, err := faultinject.Parse("storage.PutNar:error=1")
+	require.NoError(t, err)
+
+	narStore := inj.NarStore(store)
+	narURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: testdata.Nar1.NarCompression}
+
+	_, err = narStore.PutNar(ctx, narURL, strings.NewReader(testdata.Nar1.NarText), 0)
+	require.ErrorIs(t, err, faultinject.ErrInjected)
+	assert.False(t, store.HasNar(ctx, narURL), "the failed write reached the store")
+
+	_, err = store.PutNar(ctx, narURL, strings.NewReader(testdata.Nar1.NarText), 0)
+	require.NoError(t, err)
+
+	size, r, err := narStore.GetNar(ctx, narURL)
+	require.NoError(t, err, "reads are not failed")
+
+	defer r.Close()
+
+	assert.Equal(t, int64(len(testdata.Nar1.NarText)), size)
+
+	var nilInj *faultinject.Injector
+
+	assert.Same(t, store, nilInj.NarStore(store))
+}
+
+func TestDriver(t *testing.T) {
+	t.Parallel()
+
+	dbFile := filepath.Join(t.TempDir(), "db.sqlite")
+	testhelper.CreateMigrateDatabase(t, dbFile)
+
+	dbClient, err := database.Open("sqlite:"+dbFile, nil)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { dbClient.Close() })
+
+	inj, err := faultinject.Parse("database.commit:error=1")
+	require.NoError(t, err)
+
+	require.NoError(t, dbClient.WrapDriver(inj.Driver))
+
+	ctx := context.Background()
+
+	err = dbClient.WithTransaction(ctx, "test", func(tx *ent.Tx) error {
+		return tx.ConfigEntry.Create().SetKey("fault").SetValue("injected").Exec(ctx)
+	})
+	require.ErrorIs(t, err, faultinject.ErrInjected)
+
+	count, err := dbClient.Ent().ConfigEntry.Query().Count(ctx)
+	require.NoError(t, err, "queries are not failed")
+	assert.Zero(t, count, "the failed commit was not rolled back")
+}
+
+func TestTransport(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	t.Cleanup(ts.Close)
+
+	inj, err := faultinject.Parse("upstream.HEAD:error=1")
+	require.NoError(t, err)
+
+	client := &http.Client{Transport: inj.Transport(nil)}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodHead, ts.URL, nil)
+	require.NoError(t, err)
+
+	_, err = client.Do(req)
+	require.ErrorIs(t, err, faultinject.ErrInjected)
+
+	req, err = http.NewRequestWithContext(context.Background(), http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,291 @@
+package faultinject
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+)
+
+// ConfigStore returns next with the faults of the storage target injected, or
+// next itself if i is nil. The operations are named after the methods.
+//
+//nolint:staticcheck // deprecated: migration support
+func (i *Injector) ConfigStore(next storage.ConfigStore) storage.ConfigStore {
+	if i == nil || next == nil {
+		return next
+	}
+
+	return &configStore{ConfigStore: next, inj: i}
+}
+
+// NarInfoStore returns next with the faults of the storage target injected, or
+// next itself if i is nil. The operations are named after the methods.
+func (i *Injector) NarInfoStore(next storage.NarInfoStore) storage.NarInfoStore {
+	if i == nil || next == nil {
+		return next
+	}
+
+	return &narInfoStore{next: next, inj: i}
+}
+
+// NarStore returns next with the faults of the storage target injected, or
+// next itself if i is nil. The operations are named after the methods. The
+// returned store implements storage.NarPresigner if, and only if, next does.
+func (i *Injector) NarStore(next storage.NarStore) storage.NarStore {
+	if i == nil || next == nil {
+		return next
+	}
+
+	s := &narStore{next: next, inj: i}
+
+	if presigner, ok := next.(storage.NarPresigner); ok {
+		return &presigningNarStore{narStore: s, presigner: presigner}
+	}
+
+	return s
+}
+
+// ChunkStore returns next with the faults of the chunk target injected, or
+// next itself if i is nil. The operations are named after the methods.
+func (i *Injector) ChunkStore(next chunk.Store) chunk.Store {
+	if i == nil || next == nil {
+		return next
+	}
+
+	return &chunkStore{next: next, inj: i}
+}
+
+//nolint:staticcheck // deprecated: migration support
+type configStore struct {
+	storage.ConfigStore
+
+	inj *Injector
+}
+
+func (s *configStore) GetSecretKey(ctx context.Context) (signature.SecretKey, error) {
+	if err := s.inj.Inject(ctx, TargetStorage, "GetSecretKey"); err != nil {
+		return signature.SecretKey{}, err
+	}
+
+	return s.ConfigStore.GetSecretKey(ctx)
+}
+
+type narInfoStore struct {
+	next storage.NarInfoStore
+	inj  *Injector
+}
+
+func (s *narInfoStore) HasNarInfo(ctx context.Context, hash string) bool {
+	if err := s.inj.Inject(ctx, TargetStorage, "HasNarInfo"); err != nil {
+		return false
+	}
+
+	return s.next.HasNarInfo(ctx, hash)
+}
+
+func (s *narInfoStore) GetNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo, error) {
+	if err := s.inj.Inject(ctx, TargetStorage, "GetNarInfo"); err != nil {
+		return nil, err
+	}
+
+	return s.next.GetNarInfo(ctx, hash)
+}
+
+func (s *narInfoStore) PutNarInfo(ctx context.Context, hash string, narInfo *narinfo.NarInfo) error {
+	if err := s.inj.Inject(ctx, TargetStorage, "PutNarInfo"); err != nil {
+		return err
+	}
+
+	return s.next.PutNarInfo(ctx, hash, narInfo)
+}
+
+func (s *narInfoStore) DeleteNarInfo(ctx context.Context, hash string) error {
+	if err := s.inj.Inject(ctx, TargetStorage, "DeleteNarInfo"); err != nil {
+		return err
+	}
+
+	return s.next.DeleteNarInfo(ctx, hash)
+}
+
+func (s *narInfoStore) WalkNarInfos(ctx context.Context, fn func(hash string) error) error {
+	if err := s.inj.Inject(ctx, TargetStorage, "WalkNarInfos"); err != nil {
+		return err
+	}
+
+	return s.next.WalkNarInfos(ctx, fn)
+}
+
+type narStore struct {
+	next storage.NarStore
+	inj  *Injector
+}
+
+// presigningNarStore is returned by NarStore when the wrapped store can
+// presign URLs.
+type presigningNarStore struct {
+	*narStore
+
+	presigner storage.NarPresigner
+}
+
+func (s *narStore) HasNar(ctx context.Context, narURL nar.URL) bool {
+	if err := s.inj.Inject(ctx, TargetStorage, "HasNar"); err != nil {
+		return false
+	}
+
+	return s.next.HasNar(ctx, narURL)
+}
+
+func (s *narStore) StatNar(ctx context.Context, narURL nar.URL) (bool, error) {
+	if err := s.inj.Inject(ctx, TargetStorage, "StatNar"); err != nil {
+		return false, err
+	}
+
+	return s.next.StatNar(ctx, narURL)
+}
+
+func (s *narStore) GetNar(ctx context.Context, narURL nar.URL) (int64, io.ReadCloser, error) {
+	if err := s.inj.Inject(ctx, TargetStorage, "GetNar"); err != nil {
+		return 0, nil, err
+	}
+
+	return s.next.GetNar(ctx, narURL)
+}
+
+func (s *narStore) PutNar(ctx context.Context, narURL nar.URL, body io.Reader, size int64) (int64, error) {
+	if err := s.inj.Inject(ctx, TargetStorage, "PutNar"); err != nil {
+		return 0, err
+	}
+
+	return s.next.PutNar(ctx, narURL, body, size)
+}
+
+func (s *narStore) DeleteNar(ctx context.Context, narURL nar.URL) error {
+	if err := s.inj.Inject(ctx, TargetStorage, "DeleteNar"); err != nil {
+		return err
+	}
+
+	return s.next.DeleteNar(ctx, narURL)
+}
+
+func (s *narStore) WalkNars(ctx context.Context, fn func(narURL nar.URL) error) error {
+	if err := s.inj.Inject(ctx, TargetStorage, "WalkNars"); err != nil {
+		return err
+	}
+
+	return s.next.WalkNars(ctx, fn)
+}
+
+func (s *narStore) PutStagingPart(
+	ctx context.Context,
+	hash string,
+	index int64,
+	body io.Reader,
+	size int64,
+) (int64, error) {
+	if err := s.inj.Inject(ctx, TargetStorage, "PutStagingPart"); err != nil {
+		return 0, err
+	}
+
+	return s.next.PutStagingPart(ctx, hash, index, body, size)
+}
+
+func (s *narStore) GetStagingPart(ctx context.Context, hash string, index int64) (io.ReadCloser, error) {
+	if err := s.inj.Inject(ctx, TargetStorage, "GetStagingPart"); err != nil {
+		return nil, err
+	}
+
+	return s.next.GetStagingPart(ctx, hash, index)
+}
+
+func (s *narStore) DeleteStagingParts(ctx context.Context, hash string) error {
+	if err := s.inj.Inject(ctx, TargetStorage, "DeleteStagingParts"); err != nil {
+		return err
+	}
+
+	return s.next.DeleteStagingParts(ctx, hash)
+}
+
+// MigrateLegacyNars migrates the legacy nars of the wrapped store, if it can
+// hold any. No fault is injected: it only runs at startup.
+func (s *narStore) MigrateLegacyNars(ctx context.Context) (int, int, error) {
+	migrator, ok := s.next.(storage.LegacyNarMigrator)
+	if !ok {
+		return 0, 0, nil
+	}
+
+	return migrator.MigrateLegacyNars(ctx)
+}
+
+func (s *presigningNarStore) PresignNarURL(
+	ctx context.Context,
+	narURL nar.URL,
+	expiry time.Duration,
+) (*url.URL, error) {
+	if err := s.inj.Inject(ctx, TargetStorage, "PresignNarURL"); err != nil {
+		return nil, err
+	}
+
+	return s.presigner.PresignNarURL(ctx, narURL, expiry)
+}
+
+type chunkStore struct {
+	next chunk.Store
+	inj  *Injector
+}
+
+func (s *chunkStore) HasChunk(ctx context.Context, hash string) (bool, error) {
+	if err := s.inj.Inject(ctx, TargetChunk, "HasChunk"); err != nil {
+		return false, err
+	}
+
+	return s.next.HasChunk(ctx, hash)
+}
+
+func (s *chunkStore) GetChunk(ctx context.Context, hash string) (io.ReadCloser, error) {
+	if err := s.inj.Inject(ctx, TargetChunk, "GetChunk"); err != nil {
+		return nil, err
+	}
+
+	return s.next.GetChunk(ctx, hash)
+}
+
+func (s *chunkStore) GetRawChunk(ctx context.Context, hash string) (io.ReadCloser, error) {
+	if err := s.inj.Inject(ctx, TargetChunk, "GetRawChunk"); err != nil {
+		return nil, err
+	}
+
+	return s.next.GetRawChunk(ctx, hash)
+}
+
+func (s *chunkStore) PutChunk(ctx context.Context, hash string, data []byte) (bool, int64, error) {
+	if err := s.inj.Inject(ctx, TargetChunk, "PutChunk"); err != nil {
+		return false, 0, err
+	}
+
+	return s.next.PutChunk(ctx, hash, data)
+}
+
+func (s *chunkStore) DeleteChunk(ctx context.Context, hash string) error {
+	if err := s.inj.Inject(ctx, TargetChunk, "DeleteChunk"); err != nil {
+		return err
+	}
+
+	return s.next.DeleteChunk(ctx, hash)
+}
+
+func (s *chunkStore) WalkChunks(ctx context.Context, fn func(hash string) error) error {
+	if err := s.inj.Inject(ctx, TargetChunk, "WalkChunks"); err != nil {
+		return err
+	}
+
+	return s.next.WalkChunks(ctx, fn)
+}
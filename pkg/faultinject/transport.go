@@ -0,0 +1,37 @@
+package faultinject
+
+import (
+	"net/http"
+)
+
+// Transport returns next with the faults of the upstream target injected, or
+// next itself if i is nil. The operations are the HTTP methods of the requests.
+// A nil next is http.DefaultTransport.
+func (i *Injector) Transport(next http.RoundTripper) http.RoundTripper {
+	if i == nil {
+		return next
+	}
+
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &transport{next: next, inj: i}
+}
+
+type transport struct {
+	next http.RoundTripper
+	inj  *Injector
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.inj.Inject(req.Context(), TargetUpstream, req.Method); err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+
+		return nil, err
+	}
+
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,390 @@
+// Package file implements distributed locks as lock files in a directory shared
+// by the instances, such as an NFS or CIFS mount, for deployments pointing
+// several instances at one storage without Redis.
+//
+// A lock is a lease: a file holding its owner, its token and its expiry,
+// created atomically with link(2), which is atomic on NFS where O_EXCL may not
+// be. The lease file of a live lock is never removed or moved: it is extended
+// by renaming a new lease over it, which is atomic, and only released or
+// extended while it is further than a safety margin from its expiry. An
+// expired lease is taken over by the next instance locking its key, which first
+// claims the takeover with link(2) so only one instance replaces it. Each lease
+// carries a token greater than that of the lease it replaces, and Extend and
+// Unlock check the lease still carries the token of the acquisition: an
+// instance whose lease expired and was taken over gets ErrLockLost instead of
+// releasing or extending the lock of another.
+//
+// The tokens guard the locks only, not the storage: an instance paused past
+// the expiry of its lease may still write after the lease was taken over. The
+// lock TTLs must be well above the pauses of the instances, and their clocks
+// synchronized within a fraction of the lock TTLs.
+package file
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	dirMode  = 0o700
+	fileMode = 0o600
+
+	// pollInterval is how often a blocked RLock or write Lock checks again.
+	pollInterval = 10 * time.Millisecond
+
+	// safetyMargin is the time left before the expiry of a lease under which
+	// it is no longer released or extended, as it may be taken over
+	// meanwhile. It is capped to a quarter of the TTL of the lease.
+	safetyMargin = time.Second
+
+	// takeoverWindow is how long the claim of an instance on an expired lease
+	// stands. Once it passes, another instance may claim it, in case the
+	// first one died before replacing the lease.
+	takeoverWindow = 10 * time.Second
+)
+
+var (
+	// ErrLockHeld is returned by Lock when the lock is still held by another
+	// owner after the retries.
+	ErrLockHeld = errors.New("lock held by another owner")
+
+	// ErrLockLost is returned by Extend and Unlock when the lease of the lock
+	// expired and was taken over by another instance.
+	ErrLockLost = errors.New("lock lease lost to another owner")
+
+	// ErrWriteLockTimeout is returned by RLock when the write lock is not
+	// released within the TTL.
+	ErrWriteLockTimeout = errors.New("timeout waiting for write lock to clear")
+
+	// ErrReadersTimeout is returned by the write Lock when the readers do not
+	// release the lock within the TTL.
+	ErrReadersTimeout = errors.New("timeout waiting for readers to finish")
+)
+
+// lease is the content of a lock file.
+type lease struct {
+	Key       string        `json:"key"`
+	Owner     string        `json:"owner"`
+	Token     int64         `json:"token"`
+	ExpiresAt time.Time     `json:"expires_at"`
+	TTL       time.Duration `json:"ttl"`
+}
+
+func (l lease) expired(now time.Time) bool { return !now.Before(l.ExpiresAt) }
+
+// safe reports whether l is far enough from its expiry at now to be released
+// or extended without racing its takeover.
+func (l lease) safe(now time.Time) bool {
+	margin := safetyMargin
+	if l.TTL > 0 {
+		margin = min(margin, l.TTL/4)
+	}
+
+	return l.ExpiresAt.Sub(now) > margin
+}
+
+func (l lease) same(o lease) bool { return l.Owner == o.Owner && l.Token == o.Token }
+
+// leases manages the lease files of a directory.
+type leases struct {
+	dir string
+
+	// owner identifies this instance in the leases it writes.
+	owner string
+
+	// replaceHook, if set, is called by extend and takeOver right before
+	// they replace a lease. It is set by the tests only.
+	replaceHook func()
+}
+
+func newLeases(dir string) (*leases, error) {
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return nil, fmt.Errorf("error creating the lock directory %q: %w", dir, err)
+	}
+
+	return &leases{dir: dir, owner: randomID()}, nil
+}
+
+// path returns the path of the lease file of key, named after its sha256 so
+// any key makes a valid file name.
+func (ls *leases) path(key, suffix string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return filepath.Join(ls.dir, hex.EncodeToString(sum[:])+suffix)
+}
+
+// acquire creates the lease at path unless an unexpired one exists, taking an
+// expired one over. It returns the acquired lease, or false when the lock is
+// held.
+func (ls *leases) acquire(key, path string, ttl time.Duration) (lease, bool, error) {
+	// A second round when the lease was released meanwhile.
+	for range 2 {
+		cur, err := readLease(path)
+
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			l := ls.newLease(key, 0, ttl)
+
+			linked, err := ls.link(path, l)
+			if err != nil {
+				return lease{}, false, err
+			}
+
+			if linked {
+				return l, true, nil
+			}
+		case err != nil:
+			return lease{}, false, err
+		case !cur.expired(time.Now()):
+			return lease{}, false, nil
+		default:
+			l := ls.newLease(key, cur.Token, ttl)
+
+			taken, err := ls.takeOver(path, cur, l)
+			if err != nil || !taken {
+				return lease{}, false, err
+			}
+
+			return l, true, nil
+		}
+	}
+
+	return lease{}, false, nil
+}
+
+func (ls *leases) newLease(key string, prevToken int64, ttl time.Duration) lease {
+	return lease{
+		Key:       key,
+		Owner:     ls.owner,
+		Token:     max(prevToken+1, time.Now().UnixNano()),
+		ExpiresAt: time.Now().Add(ttl),
+		TTL:       ttl,
+	}
+}
+
+// link atomically creates the lease file at path holding l. It returns false
+// if the file exists.
+func (ls *leases) link(path string, l lease) (bool, error) {
+	tmp, err := ls.writeTemp(l)
+	if err != nil {
+		return false, err
+	}
+
+	defer os.Remove(tmp)
+
+	err = os.Link(tmp, path)
+	if errors.Is(err, fs.ErrExist) {
+		return false, nil
+	}
+
+	// Over NFS link may report an error for a link it made; the lease file
+	// tells.
+	if cur, readErr := readLease(path); readErr == nil && cur.same(l) {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("error creating the lock file %q: %w", path, err)
+	}
+
+	return false, nil
+}
+
+// takeOver replaces the expired lease exp at path with l. The takeover is
+// claimed first with a claim file named after exp and the current takeover
+// window, created with link(2): only the instance holding the claim replaces
+// exp, and only if the window has time left. It returns false if another
+// instance holds the claim or the lease is no longer exp.
+func (ls *leases) takeOver(path string, exp, l lease) (bool, error) {
+	window := int64(time.Since(exp.ExpiresAt) / takeoverWindow)
+	windowEnd := exp.ExpiresAt.Add(time.Duration(window+1) * takeoverWindow)
+
+	claim := fmt.Sprintf("%s.takeover-%d-%d-%d", path, exp.Token, exp.ExpiresAt.UnixNano(), window)
+
+	claimed, err := ls.link(claim, l)
+	if err != nil || !claimed {
+		return false, err
+	}
+
+	cur, err := readLease(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+
+	// The lease must not have been extended, or replaced by the claim of an
+	// earlier window, and the window must have time left: once it passes,
+	// another instance may claim the lease.
+	if err == nil && cur.same(exp) && cur.ExpiresAt.Equal(exp.ExpiresAt) && time.Until(windowEnd) > safetyMargin {
+		replaced, err := ls.replace(path, l)
+		if replaced {
+			// The claims left on the leases replaced are stale.
+			removeClaims(path)
+		}
+
+		return replaced, err
+	}
+
+	// The claims of the other instances are theirs to remove.
+	os.Remove(claim)
+
+	return false, err
+}
+
+// removeClaims removes the takeover claims of the leases at path.
+func removeClaims(path string) {
+	claims, _ := filepath.Glob(path + ".takeover-*")
+	for _, claim := range claims {
+		os.Remove(claim)
+	}
+}
+
+// replace atomically replaces the lease file at path with l, so the path is
+// never missing a lease.
+func (ls *leases) replace(path string, l lease) (bool, error) {
+	tmp, err := ls.writeTemp(l)
+	if err != nil {
+		return false, err
+	}
+
+	if ls.replaceHook != nil {
+		ls.replaceHook()
+	}
+
+	err = os.Rename(tmp, path)
+	if err == nil {
+		return true, nil
+	}
+
+	os.Remove(tmp)
+
+	// Over NFS rename may report an error for a rename it made; the lease
+	// file tells.
+	if cur, readErr := readLease(path); readErr == nil && cur.same(l) && cur.ExpiresAt.Equal(l.ExpiresAt) {
+		return true, nil
+	}
+
+	return false, fmt.Errorf("error replacing the lock file %q: %w", path, err)
+}
+
+// release removes the lease l at path, or returns ErrLockLost if it is no
+// longer there. A lease within the safety margin of its expiry is left to
+// expire instead, as it may be taken over meanwhile.
+func (ls *leases) release(path string, l lease) error {
+	cur, err := readLease(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrLockLost
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !cur.same(l) {
+		return ErrLockLost
+	}
+
+	if !cur.safe(time.Now()) {
+		return nil
+	}
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrLockLost
+		}
+
+		return fmt.Errorf("error removing the lock file %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// extend replaces the lease l at path with one expiring after ttl, or returns
+// ErrLockLost if it is no longer there or within the safety margin of its
+// expiry, as it may be taken over meanwhile.
+func (ls *leases) extend(path string, l lease, ttl time.Duration) (lease, error) {
+	cur, err := readLease(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return lease{}, ErrLockLost
+	}
+
+	if err != nil {
+		return lease{}, err
+	}
+
+	if !cur.same(l) || !cur.safe(time.Now()) {
+		return lease{}, ErrLockLost
+	}
+
+	l.ExpiresAt = time.Now().Add(ttl)
+	l.TTL = ttl
+
+	if _, err := ls.replace(path, l); err != nil {
+		return lease{}, fmt.Errorf("error extending the lock file %q: %w", path, err)
+	}
+
+	return l, nil
+}
+
+func (ls *leases) writeTemp(l lease) (string, error) {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return "", fmt.Errorf("error encoding the lease: %w", err)
+	}
+
+	f, err := os.CreateTemp(ls.dir, ".lease-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("error creating the lease file: %w", err)
+	}
+
+	_, err = f.Write(b)
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Chmod(f.Name(), fileMode)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+
+		return "", fmt.Errorf("error writing the lease file: %w", err)
+	}
+
+	return f.Name(), nil
+}
+
+func readLease(path string) (lease, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return lease{}, err
+	}
+
+	var l lease
+	if err := json.Unmarshal(b, &l); err != nil {
+		// A torn or foreign file holds no lock.
+		return lease{}, nil
+	}
+
+	return l, nil
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b) // crypto/rand.Read always returns err == nil
+
+	return hex.EncodeToString(b)
+}
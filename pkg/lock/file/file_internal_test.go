@@ -0,0 +1,129 @@
+package file
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLeases returns n leases sharing a directory, as n instances sharing a
+// mount.
+func newTestLeases(t *testing.T, n int) []*leases {
+	t.Helper()
+
+	dir := t.TempDir()
+	all := make([]*leases, 0, n)
+
+	for range n {
+		ls, err := newLeases(dir)
+		require.NoError(t, err)
+
+		all = append(all, ls)
+	}
+
+	return all
+}
+
+func TestLeasesExtend(t *testing.T) {
+	t.Parallel()
+
+	t.Run("the lock is held while its lease is replaced", func(t *testing.T) {
+		t.Parallel()
+
+		all := newTestLeases(t, 2)
+		a, b := all[0], all[1]
+		path := a.path("key", ".lock")
+
+		held, ok, err := a.acquire("key", path, time.Minute)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		// Another instance locks the key while the lease is being extended.
+		var hookCalled bool
+
+		a.replaceHook = func() {
+			hookCalled = true
+
+			_, acquired, err := b.acquire("key", path, time.Minute)
+			assert.NoError(t, err)
+			assert.False(t, acquired, "the lock is held throughout the extension")
+		}
+
+		extended, err := a.extend(path, held, time.Hour)
+		require.NoError(t, err)
+		assert.True(t, hookCalled)
+		assert.Equal(t, held.Token, extended.Token)
+
+		cur, err := readLease(path)
+		require.NoError(t, err)
+		assert.True(t, cur.same(held))
+		assert.True(t, cur.ExpiresAt.After(held.ExpiresAt))
+
+		require.NoError(t, a.release(path, extended))
+	})
+
+	t.Run("a lease close to its expiry is not extended", func(t *testing.T) {
+		t.Parallel()
+
+		ls := newTestLeases(t, 1)[0]
+		path := ls.path("key", ".lock")
+
+		held, ok, err := ls.acquire("key", path, 20*time.Millisecond)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		time.Sleep(20 * time.Millisecond)
+
+		_, err = ls.extend(path, held, time.Minute)
+		require.ErrorIs(t, err, ErrLockLost)
+	})
+}
+
+func TestLeasesTakeOver(t *testing.T) {
+	t.Parallel()
+
+	all := newTestLeases(t, 3)
+	a, b, c := all[0], all[1], all[2]
+	path := a.path("key", ".lock")
+
+	expired, ok, err := a.acquire("key", path, 10*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	time.Sleep(20 * time.Millisecond)
+
+	// A third instance takes the lock over while the second one replaces the
+	// expired lease.
+	var hookCalled bool
+
+	b.replaceHook = func() {
+		hookCalled = true
+
+		_, acquired, err := c.acquire("key", path, time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, acquired, "only one instance takes an expired lease over")
+	}
+
+	taken, ok, err := b.acquire("key", path, time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.True(t, hookCalled)
+	assert.Greater(t, taken.Token, expired.Token)
+
+	_, err = a.extend(path, expired, time.Minute)
+	require.ErrorIs(t, err, ErrLockLost)
+	require.ErrorIs(t, a.release(path, expired), ErrLockLost)
+
+	cur, err := readLease(path)
+	require.NoError(t, err)
+	assert.True(t, cur.same(taken), "the lease taken over is left to its new owner")
+
+	claims, err := filepath.Glob(path + ".takeover-*")
+	require.NoError(t, err)
+	assert.Empty(t, claims)
+
+	require.NoError(t, b.release(path, taken))
+}
@@ -0,0 +1,149 @@
+package file_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/lock"
+	"github.com/kalbasit/ncps/pkg/lock/file"
+)
+
+func retryConfig() lock.RetryConfig {
+	return lock.RetryConfig{MaxAttempts: 2, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}
+}
+
+// newLockers returns two lockers sharing a directory, as two instances
+// sharing a mount.
+func newLockers(t *testing.T) (*file.Locker, *file.Locker) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	a, err := file.NewLocker(dir, retryConfig())
+	require.NoError(t, err)
+
+	b, err := file.NewLocker(dir, retryConfig())
+	require.NoError(t, err)
+
+	return a, b
+}
+
+func TestLocker(t *testing.T) {
+	t.Parallel()
+
+	t.Run("a held lock excludes the other instances", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		a, b := newLockers(t)
+
+		require.NoError(t, a.Lock(ctx, "key", time.Minute))
+
+		acquired, err := b.TryLock(ctx, "key", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, acquired)
+
+		require.ErrorIs(t, b.Lock(ctx, "key", time.Minute), file.ErrLockHeld)
+
+		acquired, err = b.TryLock(ctx, "other-key", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, acquired)
+
+		require.NoError(t, a.Extend(ctx, "key"))
+		require.NoError(t, a.Unlock(ctx, "key"))
+
+		acquired, err = b.TryLock(ctx, "key", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, acquired)
+	})
+
+	t.Run("an expired lease is taken over and its owner locked out", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		a, b := newLockers(t)
+
+		require.NoError(t, a.Lock(ctx, "key", 20*time.Millisecond))
+
+		time.Sleep(50 * time.Millisecond)
+
+		acquired, err := b.TryLock(ctx, "key", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, acquired)
+
+		require.ErrorIs(t, a.Extend(ctx, "key"), file.ErrLockLost)
+		require.ErrorIs(t, a.Unlock(ctx, "key"), file.ErrLockLost)
+
+		// The lease taken over survives the release of the former owner.
+		acquired, err = a.TryLock(ctx, "key", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, acquired)
+
+		require.NoError(t, b.Unlock(ctx, "key"))
+	})
+}
+
+func TestRWLocker(t *testing.T) {
+	t.Parallel()
+
+	t.Run("readers and writers exclude each other", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		dir := t.TempDir()
+
+		a, err := file.NewRWLocker(dir, retryConfig())
+		require.NoError(t, err)
+
+		b, err := file.NewRWLocker(dir, retryConfig())
+		require.NoError(t, err)
+
+		require.NoError(t, a.RLock(ctx, "key", time.Minute))
+		require.NoError(t, b.RLock(ctx, "key", time.Minute))
+
+		acquired, err := b.TryLock(ctx, "key", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, acquired, "readers hold the lock")
+
+		require.NoError(t, a.RUnlock(ctx, "key"))
+		require.NoError(t, b.RUnlock(ctx, "key"))
+
+		acquired, err = b.TryLock(ctx, "key", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, acquired)
+
+		require.ErrorIs(t, a.RLock(ctx, "key", 20*time.Millisecond), file.ErrWriteLockTimeout)
+
+		require.NoError(t, b.Unlock(ctx, "key"))
+		require.NoError(t, a.RLock(ctx, "key", time.Minute))
+		require.NoError(t, a.RUnlock(ctx, "key"))
+	})
+
+	t.Run("the write lock waits for the readers", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		dir := t.TempDir()
+
+		a, err := file.NewRWLocker(dir, retryConfig())
+		require.NoError(t, err)
+
+		b, err := file.NewRWLocker(dir, retryConfig())
+		require.NoError(t, err)
+
+		require.NoError(t, a.RLock(ctx, "key", time.Minute))
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+
+			_ = a.RUnlock(ctx, "key")
+		}()
+
+		require.NoError(t, b.Lock(ctx, "key", time.Minute))
+		require.NoError(t, b.Unlock(ctx, "key"))
+	})
+}
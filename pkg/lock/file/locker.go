@@ -0,0 +1,169 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/kalbasit/ncps/pkg/lock"
+)
+
+// heldLease is a lease acquired by this instance.
+type heldLease struct {
+	lease
+
+	ttl        time.Duration
+	acquiredAt time.Time
+}
+
+// Locker implements lock.Locker with a lock file per key.
+type Locker struct {
+	leases      *leases
+	retryConfig lock.RetryConfig
+
+	mu   sync.Mutex
+	held map[string]heldLease
+}
+
+// NewLocker returns a locker keeping its lock files in dir, created if needed.
+func NewLocker(dir string, retryCfg lock.RetryConfig) (*Locker, error) {
+	ls, err := newLeases(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Locker{
+		leases:      ls,
+		retryConfig: retryCfg,
+		held:        make(map[string]heldLease),
+	}, nil
+}
+
+// Lock acquires an exclusive lock, retrying with exponential backoff while it
+// is held by another.
+func (l *Locker) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	for attempt := 0; attempt < l.retryConfig.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			lock.RecordLockRetryAttempt(ctx, lock.LockTypeExclusive)
+
+			select {
+			case <-ctx.Done():
+				lock.RecordLockFailure(ctx, lock.LockTypeExclusive, lock.LockModeDistributed, lock.LockFailureContextCanceled)
+
+				return ctx.Err()
+			case <-time.After(lock.CalculateBackoff(l.retryConfig, attempt)):
+			}
+		}
+
+		acquired, err := l.tryLock(key, ttl)
+		if err != nil {
+			lock.RecordLockFailure(ctx, lock.LockTypeExclusive, lock.LockModeDistributed, lock.LockFailureFileError)
+
+			return fmt.Errorf("failed to acquire lock %s: %w", key, err)
+		}
+
+		if acquired {
+			lock.RecordLockAcquisition(ctx, lock.LockTypeExclusive, lock.LockModeDistributed, lock.LockResultSuccess)
+
+			return nil
+		}
+	}
+
+	lock.RecordLockFailure(ctx, lock.LockTypeExclusive, lock.LockModeDistributed, lock.LockFailureMaxRetries)
+
+	return fmt.Errorf("failed to acquire lock %s after %d attempts: %w",
+		key, l.retryConfig.MaxAttempts, ErrLockHeld)
+}
+
+// TryLock attempts to acquire an exclusive lock without retries.
+func (l *Locker) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	acquired, err := l.tryLock(key, ttl)
+	if err != nil {
+		lock.RecordLockFailure(ctx, lock.LockTypeExclusive, lock.LockModeDistributed, lock.LockFailureFileError)
+
+		return false, fmt.Errorf("error trying lock %s: %w", key, err)
+	}
+
+	if !acquired {
+		lock.RecordLockAcquisition(ctx, lock.LockTypeExclusive, lock.LockModeDistributed, lock.LockResultContention)
+
+		return false, nil
+	}
+
+	lock.RecordLockAcquisition(ctx, lock.LockTypeExclusive, lock.LockModeDistributed, lock.LockResultSuccess)
+
+	return true, nil
+}
+
+func (l *Locker) tryLock(key string, ttl time.Duration) (bool, error) {
+	acquired, ok, err := l.leases.acquire(key, l.leases.path(key, ".lock"), ttl)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	l.mu.Lock()
+	l.held[key] = heldLease{lease: acquired, ttl: ttl, acquiredAt: time.Now()}
+	l.mu.Unlock()
+
+	return true, nil
+}
+
+// Unlock releases an exclusive lock. It returns ErrLockLost if its lease
+// expired and was taken over.
+func (l *Locker) Unlock(ctx context.Context, key string) error {
+	l.mu.Lock()
+	h, ok := l.held[key]
+	delete(l.held, key)
+	l.mu.Unlock()
+
+	if !ok {
+		// This can happen if Lock failed but Unlock is still called
+		return nil
+	}
+
+	lock.RecordLockDuration(ctx, lock.LockTypeExclusive, lock.LockModeDistributed, time.Since(h.acquiredAt).Seconds())
+
+	if err := l.leases.release(l.leases.path(key, ".lock"), h.lease); err != nil {
+		if errors.Is(err, ErrLockLost) {
+			zerolog.Ctx(ctx).Warn().
+				Str("key", key).
+				Int64("token", h.Token).
+				Msg("the lock lease expired and was taken over before its release")
+		}
+
+		return fmt.Errorf("failed to release lock %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Extend refreshes the TTL of an acquired lock. It returns ErrLockLost if its
+// lease expired and was taken over.
+func (l *Locker) Extend(_ context.Context, key string) error {
+	l.mu.Lock()
+	h, ok := l.held[key]
+	l.mu.Unlock()
+
+	if !ok {
+		// Lock not found — already released or never acquired
+		return nil
+	}
+
+	extended, err := l.leases.extend(l.leases.path(key, ".lock"), h.lease, h.ttl)
+	if err != nil {
+		return fmt.Errorf("failed to extend lock %s: %w", key, err)
+	}
+
+	l.mu.Lock()
+	if cur, ok := l.held[key]; ok && cur.Token == h.Token {
+		cur.lease = extended
+		l.held[key] = cur
+	}
+	l.mu.Unlock()
+
+	return nil
+}
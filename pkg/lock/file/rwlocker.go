@@ -0,0 +1,399 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kalbasit/ncps/pkg/lock"
+)
+
+// RWLocker implements lock.RWLocker with a writer lock file per key and a
+// directory of reader lease files per key.
+type RWLocker struct {
+	leases      *leases
+	retryConfig lock.RetryConfig
+
+	mu      sync.Mutex
+	writers map[string]heldLease
+
+	// readers are the paths of the reader leases held per key.
+	readers map[string][]string
+}
+
+// NewRWLocker returns a read-write locker keeping its lock files in dir,
+// created if needed.
+func NewRWLocker(dir string, retryCfg lock.RetryConfig) (*RWLocker, error) {
+	ls, err := newLeases(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RWLocker{
+		leases:      ls,
+		retryConfig: retryCfg,
+		writers:     make(map[string]heldLease),
+		readers:     make(map[string][]string),
+	}, nil
+}
+
+func (rw *RWLocker) writerPath(key string) string  { return rw.leases.path(key, ".writer") }
+func (rw *RWLocker) readersPath(key string) string { return rw.leases.path(key, ".readers") }
+
+// Lock acquires an exclusive write lock with retry and exponential backoff,
+// then waits for the readers to finish within the TTL.
+func (rw *RWLocker) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	lastErr := ErrLockHeld
+
+	for attempt := 0; attempt < rw.retryConfig.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			lock.RecordLockRetryAttempt(ctx, lock.LockTypeWrite)
+
+			select {
+			case <-ctx.Done():
+				lock.RecordLockFailure(ctx, lock.LockTypeWrite, lock.LockModeDistributed, lock.LockFailureContextCanceled)
+
+				return ctx.Err()
+			case <-time.After(lock.CalculateBackoff(rw.retryConfig, attempt)):
+			}
+		}
+
+		acquired, ok, err := rw.leases.acquire(key, rw.writerPath(key), ttl)
+		if err != nil {
+			lock.RecordLockFailure(ctx, lock.LockTypeWrite, lock.LockModeDistributed, lock.LockFailureFileError)
+
+			return fmt.Errorf("failed to acquire write lock %s: %w", key, err)
+		}
+
+		if !ok {
+			lastErr = ErrLockHeld
+
+			continue
+		}
+
+		// Wait for the readers to finish.
+		deadline := time.Now().Add(ttl)
+
+		for {
+			active, err := rw.activeReaders(key)
+			if err != nil {
+				_ = rw.leases.release(rw.writerPath(key), acquired)
+
+				lock.RecordLockFailure(ctx, lock.LockTypeWrite, lock.LockModeDistributed, lock.LockFailureFileError)
+
+				return fmt.Errorf("error checking readers: %w", err)
+			}
+
+			if active == 0 {
+				rw.mu.Lock()
+				rw.writers[key] = heldLease{lease: acquired, ttl: ttl, acquiredAt: time.Now()}
+				rw.mu.Unlock()
+
+				lock.RecordLockAcquisition(ctx, lock.LockTypeWrite, lock.LockModeDistributed, lock.LockResultSuccess)
+
+				return nil
+			}
+
+			if time.Now().After(deadline) {
+				_ = rw.leases.release(rw.writerPath(key), acquired)
+
+				lastErr = ErrReadersTimeout
+
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				_ = rw.leases.release(rw.writerPath(key), acquired)
+
+				lock.RecordLockFailure(ctx, lock.LockTypeWrite, lock.LockModeDistributed, lock.LockFailureContextCanceled)
+
+				return ctx.Err()
+			case <-time.After(pollInterval):
+			}
+		}
+	}
+
+	lock.RecordLockFailure(ctx, lock.LockTypeWrite, lock.LockModeDistributed, lock.LockFailureMaxRetries)
+
+	return fmt.Errorf("failed to acquire write lock after %d attempts: %w",
+		rw.retryConfig.MaxAttempts, lastErr)
+}
+
+// TryLock attempts to acquire an exclusive write lock without blocking.
+func (rw *RWLocker) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	acquired, ok, err := rw.leases.acquire(key, rw.writerPath(key), ttl)
+	if err != nil {
+		lock.RecordLockFailure(ctx, lock.LockTypeWrite, lock.LockModeDistributed, lock.LockFailureFileError)
+
+		return false, fmt.Errorf("error trying write lock: %w", err)
+	}
+
+	if !ok {
+		lock.RecordLockAcquisition(ctx, lock.LockTypeWrite, lock.LockModeDistributed, lock.LockResultContention)
+
+		return false, nil
+	}
+
+	active, err := rw.activeReaders(key)
+	if err != nil || active > 0 {
+		_ = rw.leases.release(rw.writerPath(key), acquired)
+
+		if err != nil {
+			lock.RecordLockFailure(ctx, lock.LockTypeWrite, lock.LockModeDistributed, lock.LockFailureFileError)
+
+			return false, fmt.Errorf("error checking readers: %w", err)
+		}
+
+		lock.RecordLockAcquisition(ctx, lock.LockTypeWrite, lock.LockModeDistributed, lock.LockResultContention)
+
+		return false, nil
+	}
+
+	rw.mu.Lock()
+	rw.writers[key] = heldLease{lease: acquired, ttl: ttl, acquiredAt: time.Now()}
+	rw.mu.Unlock()
+
+	lock.RecordLockAcquisition(ctx, lock.LockTypeWrite, lock.LockModeDistributed, lock.LockResultSuccess)
+
+	return true, nil
+}
+
+// Unlock releases an exclusive write lock. It returns ErrLockLost if its
+// lease expired and was taken over.
+func (rw *RWLocker) Unlock(ctx context.Context, key string) error {
+	rw.mu.Lock()
+	h, ok := rw.writers[key]
+	delete(rw.writers, key)
+	rw.mu.Unlock()
+
+	if !ok {
+		// This can happen if Lock failed but Unlock is still called
+		return nil
+	}
+
+	lock.RecordLockDuration(ctx, lock.LockTypeWrite, lock.LockModeDistributed, time.Since(h.acquiredAt).Seconds())
+
+	if err := rw.leases.release(rw.writerPath(key), h.lease); err != nil {
+		return fmt.Errorf("failed to release write lock %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Extend refreshes the TTL of an acquired write lock. It returns ErrLockLost
+// if its lease expired and was taken over.
+func (rw *RWLocker) Extend(_ context.Context, key string) error {
+	rw.mu.Lock()
+	h, ok := rw.writers[key]
+	rw.mu.Unlock()
+
+	if !ok {
+		// Lock not found — already released or never acquired.
+		return nil
+	}
+
+	extended, err := rw.leases.extend(rw.writerPath(key), h.lease, h.ttl)
+	if err != nil {
+		return fmt.Errorf("failed to extend write lock %s: %w", key, err)
+	}
+
+	rw.mu.Lock()
+	if cur, ok := rw.writers[key]; ok && cur.Token == h.Token {
+		cur.lease = extended
+		rw.writers[key] = cur
+	}
+	rw.mu.Unlock()
+
+	return nil
+}
+
+// RLock acquires a shared read lock, waiting for the write lock to clear
+// within the TTL. The reader lease expires after the TTL.
+func (rw *RWLocker) RLock(ctx context.Context, key string, ttl time.Duration) error {
+	deadline := time.Now().Add(ttl)
+
+	for {
+		held, err := rw.writerHeld(key)
+		if err != nil {
+			lock.RecordLockFailure(ctx, lock.LockTypeRead, lock.LockModeDistributed, lock.LockFailureFileError)
+
+			return fmt.Errorf("error checking writer lock: %w", err)
+		}
+
+		if !held {
+			path, err := rw.addReader(key, ttl)
+			if err != nil {
+				lock.RecordLockFailure(ctx, lock.LockTypeRead, lock.LockModeDistributed, lock.LockFailureFileError)
+
+				return fmt.Errorf("error acquiring read lock: %w", err)
+			}
+
+			// A writer may have locked in between, before seeing this reader.
+			held, err = rw.writerHeld(key)
+			if err == nil && !held {
+				rw.mu.Lock()
+				rw.readers[key] = append(rw.readers[key], path)
+				rw.mu.Unlock()
+
+				lock.RecordLockAcquisition(ctx, lock.LockTypeRead, lock.LockModeDistributed, lock.LockResultSuccess)
+
+				return nil
+			}
+
+			os.Remove(path)
+
+			if err != nil {
+				lock.RecordLockFailure(ctx, lock.LockTypeRead, lock.LockModeDistributed, lock.LockFailureFileError)
+
+				return fmt.Errorf("error checking writer lock: %w", err)
+			}
+		}
+
+		if time.Now().After(deadline) {
+			lock.RecordLockFailure(ctx, lock.LockTypeRead, lock.LockModeDistributed, lock.LockFailureTimeout)
+
+			return ErrWriteLockTimeout
+		}
+
+		select {
+		case <-ctx.Done():
+			lock.RecordLockFailure(ctx, lock.LockTypeRead, lock.LockModeDistributed, lock.LockFailureContextCanceled)
+
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// RUnlock releases a shared read lock.
+func (rw *RWLocker) RUnlock(_ context.Context, key string) error {
+	rw.mu.Lock()
+
+	paths := rw.readers[key]
+	if len(paths) == 0 {
+		rw.mu.Unlock()
+
+		return nil
+	}
+
+	path := paths[len(paths)-1]
+
+	if len(paths) == 1 {
+		delete(rw.readers, key)
+	} else {
+		rw.readers[key] = paths[:len(paths)-1]
+	}
+
+	rw.mu.Unlock()
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error releasing read lock %s: %w", key, err)
+	}
+
+	// Fails while other readers hold the lock.
+	_ = os.Remove(filepath.Dir(path))
+
+	return nil
+}
+
+// writerHeld reports whether an unexpired write lease exists for key.
+func (rw *RWLocker) writerHeld(key string) (bool, error) {
+	cur, err := readLease(rw.writerPath(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return !cur.expired(time.Now()), nil
+}
+
+// addReader creates a reader lease for key expiring after ttl and returns its
+// path.
+func (rw *RWLocker) addReader(key string, ttl time.Duration) (string, error) {
+	dir := rw.readersPath(key)
+
+	l := lease{
+		Key:       key,
+		Owner:     rw.leases.owner,
+		Token:     time.Now().UnixNano(),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	tmp, err := rw.leases.writeTemp(l)
+	if err != nil {
+		return "", err
+	}
+
+	defer os.Remove(tmp)
+
+	path := filepath.Join(dir, rw.leases.owner+"-"+randomID())
+
+	// The directory is removed by the last reader to leave, possibly between
+	// its creation and the rename.
+	for range 3 {
+		if err = os.MkdirAll(dir, dirMode); err != nil {
+			return "", fmt.Errorf("error creating the readers directory %q: %w", dir, err)
+		}
+
+		if err = os.Rename(tmp, path); !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("error creating the reader lease %q: %w", path, err)
+	}
+
+	return path, nil
+}
+
+// activeReaders returns the number of unexpired reader leases of key,
+// removing the expired ones.
+func (rw *RWLocker) activeReaders(key string) (int, error) {
+	dir := rw.readersPath(key)
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("error listing the readers of %s: %w", key, err)
+	}
+
+	now := time.Now()
+	active := 0
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+
+		l, err := readLease(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Released meanwhile.
+			continue
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		if l.expired(now) {
+			os.Remove(path)
+
+			continue
+		}
+
+		active++
+	}
+
+	return active, nil
+}
@@ -88,3 +88,11 @@ type RWLocker interface {
 	// behaves like sync.RWMutex.RUnlock().
 	RUnlock(ctx context.Context, key string) error
 }
+
+// Pinger is implemented by the lockers backed by a remote service, such as
+// Redis, to report whether the service is reachable.
+type Pinger interface {
+	// Ping returns an error when the service backing the locker cannot be
+	// reached, including while the locker runs in degraded mode.
+	Ping(ctx context.Context) error
+}
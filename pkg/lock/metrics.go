@@ -32,6 +32,7 @@ const (
 	LockFailureCircuitBreaker  = "circuit_breaker"
 	LockFailureMaxRetries      = "max_retries"
 	LockFailureDatabaseError   = "database_error"
+	LockFailureFileError       = "file_error"
 )
 
 var (
@@ -381,3 +381,20 @@ func (l *Locker) TryLock(ctx context.Context, key string, ttl time.Duration) (bo
 
 	return true, nil
 }
+
+// Ping implements lock.Pinger: it pings every connected Redis node.
+func (l *Locker) Ping(ctx context.Context) error {
+	if l.circuitBreaker.IsOpen() {
+		return ErrCircuitBreakerOpen
+	}
+
+	errs := make([]error, 0, len(l.clients))
+
+	for _, client := range l.clients {
+		if err := client.Ping(ctx).Err(); err != nil {
+			errs = append(errs, fmt.Errorf("error pinging %s: %w", client.Options().Addr, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
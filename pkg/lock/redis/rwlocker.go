@@ -611,3 +611,16 @@ func isLockAlreadyTakenError(err error) bool {
 	return strings.Contains(errStr, "lock already taken") ||
 		strings.Contains(errStr, "already taken")
 }
+
+// Ping implements lock.Pinger.
+func (rw *RWLocker) Ping(ctx context.Context) error {
+	if rw.circuitBreaker.IsOpen() {
+		return ErrCircuitBreakerOpen
+	}
+
+	if err := rw.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("error pinging Redis: %w", err)
+	}
+
+	return nil
+}
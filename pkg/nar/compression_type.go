@@ -22,8 +22,32 @@ const (
 	CompressionTypeXz    CompressionType = "xz"
 )
 
-// CompressionTypeFromExtension returns the compression type given an extension.
+// extensionAliases maps the alternative extensions some tooling produces to
+// the canonical extension of their compression type.
+//
+//nolint:gochecknoglobals
+var extensionAliases = map[string]string{
+	"zstd":   "zst",
+	"bzip2":  "bz2",
+	"lz":     "lzip",
+	"brotli": "br",
+}
+
+// IsExtensionAlias reports whether ext is an alternative extension of a
+// compression type, such as "zstd" for "zst".
+func IsExtensionAlias(ext string) bool {
+	_, ok := extensionAliases[ext]
+
+	return ok
+}
+
+// CompressionTypeFromExtension returns the compression type given an
+// extension, canonical or an alias (see IsExtensionAlias).
 func CompressionTypeFromExtension(ext string) (CompressionType, error) {
+	if canonical, ok := extensionAliases[ext]; ok {
+		ext = canonical
+	}
+
 	switch ext {
 	case "":
 		fallthrough
@@ -0,0 +1,65 @@
+package nar
+
+import (
+	"net/url"
+	"slices"
+)
+
+// knownQueryKeys lists the nar URL query parameters that identify a distinct
+// representation of a NAR. "hash" is the store path hash appended by
+// nix-serve style caches (e.g. "nar/<hash>.nar?hash=<store-path-hash>") and
+// "ca" is the content address of a content-addressed path (e.g.
+// "nar/<hash>.nar?ca=fixed:r:sha256:<hash>").
+// Any other parameter is noise (cache busters, tracking parameters) and is
+// dropped from the storage key.
+//
+//nolint:gochecknoglobals // read-only lookup table
+var knownQueryKeys = map[string]struct{}{
+	"ca":   {},
+	"hash": {},
+}
+
+// CanonicalizeQuery returns the canonical form of a nar URL query: unknown
+// keys and empty values are dropped and the values of each key are sorted and
+// de-duplicated. Keys are sorted by url.Values.Encode, so two semantically
+// identical queries canonicalize to the same encoded string. It returns nil
+// when nothing is left.
+func CanonicalizeQuery(q url.Values) url.Values {
+	var canonical url.Values
+
+	for key, values := range q {
+		if _, ok := knownQueryKeys[key]; !ok {
+			continue
+		}
+
+		kept := make([]string, 0, len(values))
+
+		for _, v := range values {
+			if v != "" {
+				kept = append(kept, v)
+			}
+		}
+
+		if len(kept) == 0 {
+			continue
+		}
+
+		slices.Sort(kept)
+
+		if canonical == nil {
+			canonical = make(url.Values)
+		}
+
+		canonical[key] = slices.Compact(kept)
+	}
+
+	return canonical
+}
+
+// CanonicalQuery returns the encoded canonical query of the URL. It is the
+// query component of the (hash, compression, query) key of the nar_files
+// table and must be used whenever a nar_file is stored or looked up. The URL
+// itself keeps its original query for the upstream GET.
+func (u URL) CanonicalQuery() string {
+	return CanonicalizeQuery(u.Query).Encode()
+}
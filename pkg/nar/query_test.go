@@ -0,0 +1,99 @@
+package nar_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+)
+
+func TestCanonicalizeQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query url.Values
+		want  url.Values
+	}{
+		{
+			name:  "nil",
+			query: nil,
+			want:  nil,
+		},
+		{
+			name:  "unknown keys are dropped",
+			query: url.Values{"utm_source": {"x"}, "foo": {"bar"}},
+			want:  nil,
+		},
+		{
+			name:  "empty values are dropped",
+			query: url.Values{"hash": {""}},
+			want:  nil,
+		},
+		{
+			name:  "known keys are kept",
+			query: url.Values{"hash": {"123"}, "foo": {"bar"}},
+			want:  url.Values{"hash": {"123"}},
+		},
+		{
+			name:  "content address is kept",
+			query: url.Values{"ca": {"fixed:r:sha256:abc"}},
+			want:  url.Values{"ca": {"fixed:r:sha256:abc"}},
+		},
+		{
+			name:  "values are sorted and de-duplicated",
+			query: url.Values{"hash": {"b", "a", "b", ""}},
+			want:  url.Values{"hash": {"a", "b"}},
+		},
+	}
+
+	t.Parallel()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, test.want, nar.CanonicalizeQuery(test.query))
+		})
+	}
+}
+
+func TestURLCanonicalQuery(t *testing.T) {
+	t.Parallel()
+
+	const hash = "1mb5fxh7nzbx1b2q40bgzwjnjh8xqfap9mfnfqxlvvgvdyv8xwps"
+
+	a, err := nar.ParseURL("nar/" + hash + ".nar.xz?hash=123&utm_source=x")
+	require.NoError(t, err)
+
+	b, err := nar.ParseURL("nar/" + hash + ".nar.xz?cb=42&hash=123")
+	require.NoError(t, err)
+
+	assert.Equal(t, "hash=123", a.CanonicalQuery())
+	assert.Equal(t, a.CanonicalQuery(), b.CanonicalQuery())
+
+	// The URL keeps its original query for the upstream GET.
+	assert.Equal(t, "nar/"+hash+".nar.xz?hash=123&utm_source=x", a.String())
+}
+
+func TestURLCAQueryRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	const (
+		hash = "1mb5fxh7nzbx1b2q40bgzwjnjh8xqfap9mfnfqxlvvgvdyv8xwps"
+		ca   = "fixed:r:sha256:" + hash
+	)
+
+	u, err := nar.ParseURL("nar/" + hash + ".nar.xz?ca=" + ca)
+	require.NoError(t, err)
+	assert.Equal(t, ca, u.Query.Get("ca"))
+
+	roundTrip, err := nar.ParseURL(u.String())
+	require.NoError(t, err)
+	assert.Equal(t, u, roundTrip)
+
+	stored, err := url.ParseQuery(u.CanonicalQuery())
+	require.NoError(t, err)
+	assert.Equal(t, url.Values{"ca": {ca}}, stored, "the content address is part of the storage key")
+}
@@ -22,9 +22,10 @@ type URL struct {
 
 	// opaquePath holds the original upstream path (e.g. "nar/<uuid>.nar.zst")
 	// when the narinfo URL is not hash-named and therefore cannot be
-	// reconstructed from Hash. It is used exclusively for the upstream GET;
-	// the Hash field still drives ncps's local storage key. It is empty for
-	// conventional hash-named URLs. See ParseUpstreamURL.
+	// reconstructed from Hash, or carries an alias extension. It is used
+	// exclusively for the upstream GET; the Hash field still drives ncps's
+	// local storage key. It is empty for conventional hash-named URLs. See
+	// ParseUpstreamURL.
 	opaquePath string
 }
 
@@ -89,12 +90,20 @@ func ParseUpstreamURL(u, fallbackHash string) (URL, error) {
 	}
 
 	// Fast path: a conventional hash-named URL behaves exactly like ParseURL.
+	// An alias extension (e.g. ".nar.zstd") is served and stored under the
+	// canonical one, so the original path is kept for the upstream GET.
 	if ValidateHash(hash) == nil {
-		return URL{
+		u := URL{
 			Hash:        hash,
 			Compression: ct,
 			Query:       query,
-		}, nil
+		}
+
+		if hasExtensionAlias(pathPart) {
+			u.opaquePath = pathPart
+		}
+
+		return u, nil
 	}
 
 	// Opaque URL: the storage key must come from the narinfo's NarHash.
@@ -162,6 +171,31 @@ func parseURLParts(u string) (pathPart, hash string, ct CompressionType, query u
 	return pathPart, hash, ct, query, nil
 }
 
+// NormalizeURL returns the nar URL u with an alias extension (see
+// IsExtensionAlias) replaced by the canonical one, e.g. "nar/<hash>.nar.zstd"
+// becomes "nar/<hash>.nar.zst". Any other URL is returned unchanged.
+func NormalizeURL(u string) string {
+	pathPart, _, _ := strings.Cut(u, "?")
+	if !hasExtensionAlias(pathPart) {
+		return u
+	}
+
+	nu, err := ParseURL(u)
+	if err != nil {
+		return u
+	}
+
+	return nu.String()
+}
+
+// hasExtensionAlias reports whether the nar path pathPart ends in an alias
+// extension.
+func hasExtensionAlias(pathPart string) bool {
+	_, afterNar, found := strings.Cut(filepath.Base(pathPart), ".nar.")
+
+	return found && IsExtensionAlias(afterNar)
+}
+
 // parseOpaqueNoNarURL recognises an opaque upstream NAR URL that has no ".nar"
 // token at all (e.g. snix-castore's "nar/snix-castore/<blob>?narsize=N"). It
 // returns the path (query stripped), the parsed query, and ok=true only for a
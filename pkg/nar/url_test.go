@@ -52,6 +52,15 @@ func TestParseURL(t *testing.T) {
 			},
 			err: nil,
 		},
+		{
+			url: "nar/1mb5fxh7nzbx1b2q40bgzwjnjh8xqfap9mfnfqxlvvgvdyv8xwps.nar.zstd",
+			narURL: nar.URL{
+				Hash:        "1mb5fxh7nzbx1b2q40bgzwjnjh8xqfap9mfnfqxlvvgvdyv8xwps",
+				Compression: nar.CompressionTypeZstd,
+				Query:       url.Values{},
+			},
+			err: nil,
+		},
 		{
 			url: "nar/1mb5fxh7nzbx1b2q40bgzwjnjh8xqfap9mfnfqxlvvgvdyv8xwps.nar.lzip",
 			narURL: nar.URL{
@@ -275,6 +284,46 @@ func TestParseUpstreamURL(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, "https://example.com/"+u, restored.JoinURL(base).String())
 	})
+
+	t.Run("alias extension is fetched as is and keyed canonically", func(t *testing.T) {
+		t.Parallel()
+
+		const u = "nar/1bn7c3bf5z32cdgylhbp9nzhh6ydib5ngsm6mdhsvf233g0nh1ac.nar.zstd"
+
+		got, err := nar.ParseUpstreamURL(u, fallback)
+		require.NoError(t, err)
+
+		assert.Equal(t, "1bn7c3bf5z32cdgylhbp9nzhh6ydib5ngsm6mdhsvf233g0nh1ac", got.Hash)
+		assert.Equal(t, nar.CompressionTypeZstd, got.Compression)
+		assert.Equal(t, u, got.OpaquePath())
+	})
+}
+
+func TestNormalizeURL(t *testing.T) {
+	t.Parallel()
+
+	const hash = "1bn7c3bf5z32cdgylhbp9nzhh6ydib5ngsm6mdhsvf233g0nh1ac"
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "nar/" + hash + ".nar.zstd", want: "nar/" + hash + ".nar.zst"},
+		{url: "nar/" + hash + ".nar.bzip2?a=b", want: "nar/" + hash + ".nar.bz2?a=b"},
+		{url: "nar/" + hash + ".nar.lz", want: "nar/" + hash + ".nar.lzip"},
+		{url: "nar/" + hash + ".nar.brotli", want: "nar/" + hash + ".nar.br"},
+		{url: "nar/" + hash + ".nar.zst", want: "nar/" + hash + ".nar.zst"},
+		{url: "nar/" + hash + ".nar", want: "nar/" + hash + ".nar"},
+		{url: "nar/0123.nar.zstd", want: "nar/0123.nar.zstd"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, test.want, nar.NormalizeURL(test.url))
+		})
+	}
 }
 
 func TestWithOpaquePath(t *testing.T) {
@@ -2,6 +2,7 @@ package narinfo
 
 import (
 	"errors"
+	"path"
 	"regexp"
 )
 
@@ -30,3 +31,23 @@ func ValidateHash(hash string) error {
 
 	return nil
 }
+
+// HashFromStorePath returns the narinfo hash of a store path, given either as a
+// full path (/nix/store/<hash>-<name>) or as its basename (<hash>-<name>).
+func HashFromStorePath(storePath string) (string, error) {
+	base := path.Base(storePath)
+	if len(base) < 32 {
+		return "", ErrInvalidHash
+	}
+
+	hash := base[:32]
+	if err := ValidateHash(hash); err != nil {
+		return "", err
+	}
+
+	if len(base) > 32 && base[32] != '-' {
+		return "", ErrInvalidHash
+	}
+
+	return hash, nil
+}
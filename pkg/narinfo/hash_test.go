@@ -129,3 +129,37 @@ func TestValidateHash(t *testing.T) {
 		})
 	}
 }
+
+func TestHashFromStorePath(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		path      string
+		want      string
+		shouldErr bool
+	}{
+		{name: "full store path", path: "/nix/store/n5glp21rsz314qssw9fbvfswgy3kc68f-hello-2.12.1", want: "n5glp21rsz314qssw9fbvfswgy3kc68f"},
+		{name: "basename", path: "n5glp21rsz314qssw9fbvfswgy3kc68f-hello-2.12.1", want: "n5glp21rsz314qssw9fbvfswgy3kc68f"},
+		{name: "bare hash", path: "n5glp21rsz314qssw9fbvfswgy3kc68f", want: "n5glp21rsz314qssw9fbvfswgy3kc68f"},
+		{name: "too short", path: "/nix/store/n5glp21rsz", shouldErr: true},
+		{name: "invalid characters", path: "/nix/store/n5glp21rsz314qssw9fbvfswgy3kc68e-hello", shouldErr: true},
+		{name: "missing separator", path: "/nix/store/n5glp21rsz314qssw9fbvfswgy3kc68fxhello", shouldErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := narinfo.HashFromStorePath(tt.path)
+			if tt.shouldErr {
+				assert.ErrorIs(t, err, narinfo.ErrInvalidHash)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// Package narinfocache provides the shared narinfo metadata caches of the
+// replicated deployments.
+package narinfocache
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultKeyPrefix is the prefix of the Redis keys of the narinfos when
+// RedisConfig.KeyPrefix is not set.
+const DefaultKeyPrefix = "ncps:narinfo:"
+
+// ErrNoRedisAddr is returned by NewRedis without a Redis address.
+var ErrNoRedisAddr = errors.New("a Redis address is required")
+
+// RedisConfig configures a Redis narinfo metadata cache.
+type RedisConfig struct {
+	// Addr is the address of the Redis server.
+	Addr string
+
+	// Username for authentication (optional, required for Redis ACL).
+	Username string
+
+	// Password for authentication (optional).
+	Password string
+
+	// DB is the Redis database number.
+	DB int
+
+	// UseTLS enables TLS connection.
+	UseTLS bool
+
+	// PoolSize is the maximum number of socket connections.
+	PoolSize int
+
+	// KeyPrefix is the prefix of the keys of the narinfos. It defaults to
+	// DefaultKeyPrefix.
+	KeyPrefix string
+
+	// TTL is how long a narinfo is cached.
+	TTL time.Duration
+}
+
+// Redis caches the narinfos in Redis, each under its own key expiring after
+// the TTL. It implements cache.NarInfoMetadataCache.
+type Redis struct {
+	client    *redis.Client
+	keyPrefix string
+	ttl       time.Duration
+}
+
+// NewRedis connects to the Redis server of cfg.
+func NewRedis(ctx context.Context, cfg RedisConfig) (*Redis, error) {
+	if cfg.Addr == "" {
+		return nil, ErrNoRedisAddr
+	}
+
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = DefaultKeyPrefix
+	}
+
+	opts := &redis.Options{
+		Addr:     cfg.Addr,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+		PoolSize: cfg.PoolSize,
+	}
+
+	if cfg.UseTLS {
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	client := redis.NewClient(opts)
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+
+		return nil, fmt.Errorf("error connecting to Redis at %s: %w", cfg.Addr, err)
+	}
+
+	return &Redis{client: client, keyPrefix: cfg.KeyPrefix, ttl: cfg.TTL}, nil
+}
+
+// Get returns the narinfo of hash, or false if it is not cached.
+func (r *Redis) Get(ctx context.Context, hash string) (string, bool, error) {
+	narInfo, err := r.client.Get(ctx, r.key(hash)).Result()
+
+	switch {
+	case errors.Is(err, redis.Nil):
+		return "", false, nil
+	case err != nil:
+		return "", false, fmt.Errorf("error getting the narinfo %s: %w", hash, err)
+	}
+
+	return narInfo, true, nil
+}
+
+// Set caches the narinfo of hash for the TTL.
+func (r *Redis) Set(ctx context.Context, hash, narInfo string) error {
+	if err := r.client.Set(ctx, r.key(hash), narInfo, r.ttl).Err(); err != nil {
+		return fmt.Errorf("error setting the narinfo %s: %w", hash, err)
+	}
+
+	return nil
+}
+
+// Delete drops the narinfos of hashes.
+func (r *Redis) Delete(ctx context.Context, hashes ...string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		keys = append(keys, r.key(hash))
+	}
+
+	if err := r.client.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("error deleting %d narinfos: %w", len(hashes), err)
+	}
+
+	return nil
+}
+
+// Close closes the connections to Redis.
+func (r *Redis) Close() error { return r.client.Close() }
+
+func (r *Redis) key(hash string) string { return r.keyPrefix + hash }
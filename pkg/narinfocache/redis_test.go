@@ -0,0 +1,85 @@
+package narinfocache_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/narinfocache"
+)
+
+func newTestRedis(t *testing.T, ttl time.Duration) *narinfocache.Redis {
+	t.Helper()
+
+	if os.Getenv("NCPS_ENABLE_REDIS_TESTS") != "1" {
+		t.Skip("Redis tests disabled (set NCPS_ENABLE_REDIS_TESTS=1 to enable)")
+	}
+
+	addr := "localhost:6379"
+	if envAddrs := os.Getenv("NCPS_TEST_REDIS_ADDRS"); envAddrs != "" {
+		addr = strings.Split(envAddrs, ",")[0]
+	}
+
+	r, err := narinfocache.NewRedis(context.Background(), narinfocache.RedisConfig{
+		Addr:      addr,
+		KeyPrefix: "test:ncps:narinfo:" + t.Name() + ":",
+		TTL:       ttl,
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { r.Close() })
+
+	return r
+}
+
+func TestNewRedis_RequiresAddr(t *testing.T) {
+	t.Parallel()
+
+	_, err := narinfocache.NewRedis(context.Background(), narinfocache.RedisConfig{})
+	require.ErrorIs(t, err, narinfocache.ErrNoRedisAddr)
+}
+
+func TestRedis(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRedis(t, time.Minute)
+	ctx := context.Background()
+
+	_, ok, err := r.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, r.Set(ctx, "a", "StorePath: /nix/store/a"))
+	require.NoError(t, r.Set(ctx, "b", "StorePath: /nix/store/b"))
+
+	narInfo, ok, err := r.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "StorePath: /nix/store/a", narInfo)
+
+	require.NoError(t, r.Delete(ctx, "a", "b"))
+
+	_, ok, err = r.Get(ctx, "b")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestRedis_Expires(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRedis(t, 100*time.Millisecond)
+	ctx := context.Background()
+
+	require.NoError(t, r.Set(ctx, "a", "StorePath: /nix/store/a"))
+
+	assert.Eventually(t, func() bool {
+		_, ok, err := r.Get(ctx, "a")
+
+		return err == nil && !ok
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,118 @@
+package ncps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/kalbasit/ncps/pkg/config"
+)
+
+const (
+	// acmeChallengeTLSALPN answers the ACME challenges on the TLS listener
+	// itself, which must be reachable on port 443.
+	acmeChallengeTLSALPN = "tls-alpn-01"
+
+	// acmeChallengeHTTP answers the ACME challenges on a plain HTTP listener,
+	// which must be reachable on port 80, also redirecting the other requests
+	// to HTTPS.
+	acmeChallengeHTTP = "http-01"
+)
+
+// ErrUnknownACMEChallenge is returned for an unknown --server-acme-challenge.
+var ErrUnknownACMEChallenge = errors.New("unknown ACME challenge (allowed: tls-alpn-01, http-01)")
+
+// validateACMEChallenge validates the name of an ACME challenge.
+func validateACMEChallenge(s string) error {
+	switch s {
+	case acmeChallengeTLSALPN, acmeChallengeHTTP:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownACMEChallenge, s)
+	}
+}
+
+// acmeCache caches the account key and the certificates of the ACME
+// certificate manager in the config store, so every replica shares them and
+// they survive restarts.
+type acmeCache struct {
+	cfg *config.Config
+}
+
+// Get implements autocert.Cache.
+func (a acmeCache) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := a.cfg.GetACME(ctx, name)
+	if err != nil {
+		if errors.Is(err, config.ErrConfigNotFound) {
+			return nil, autocert.ErrCacheMiss
+		}
+
+		return nil, err
+	}
+
+	return []byte(data), nil
+}
+
+// Put implements autocert.Cache.
+func (a acmeCache) Put(ctx context.Context, name string, data []byte) error {
+	return a.cfg.SetACME(ctx, name, string(data))
+}
+
+// Delete implements autocert.Cache.
+func (a acmeCache) Delete(ctx context.Context, name string) error {
+	return a.cfg.DeleteACME(ctx, name)
+}
+
+// newACMEManager returns the manager issuing and renewing the certificates of
+// the --server-acme-domain, or nil if there is none.
+func newACMEManager(cmd *cli.Command, cfg *config.Config) *autocert.Manager {
+	domains := nonEmpty(cmd.StringSlice("server-acme-domain"))
+	if len(domains) == 0 {
+		return nil
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      acmeCache{cfg: cfg},
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      cmd.String("server-acme-email"),
+	}
+
+	if directoryURL := cmd.String("server-acme-directory-url"); directoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: directoryURL}
+	}
+
+	return m
+}
+
+// serveACMEHTTPChallenge answers the http-01 challenges of m on addr, and
+// redirects the other requests to HTTPS. It returns the server, to be shut
+// down with the others.
+func serveACMEHTTPChallenge(ctx context.Context, m *autocert.Manager, addr string) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for the ACME HTTP challenges: %w", err)
+	}
+
+	server := &http.Server{
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+		Handler:           m.HTTPHandler(nil),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zerolog.Ctx(ctx).Error().Err(err).Str("addr", addr).Msg("ACME HTTP challenge server error")
+		}
+	}()
+
+	return server, nil
+}
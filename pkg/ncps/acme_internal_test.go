@@ -0,0 +1,46 @@
+package ncps
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/kalbasit/ncps/pkg/config"
+	"github.com/kalbasit/ncps/testhelper"
+
+	locklocal "github.com/kalbasit/ncps/pkg/lock/local"
+)
+
+func TestACMECache(t *testing.T) {
+	t.Parallel()
+
+	dbClient, cleanup := testhelper.SetupSQLite(t)
+	t.Cleanup(cleanup)
+
+	var cache autocert.Cache = acmeCache{cfg: config.New(dbClient, locklocal.NewRWLocker())}
+
+	_, err := cache.Get(context.Background(), "ncps.example.com")
+	require.ErrorIs(t, err, autocert.ErrCacheMiss)
+
+	require.NoError(t, cache.Put(context.Background(), "ncps.example.com", []byte("certificate")))
+
+	data, err := cache.Get(context.Background(), "ncps.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("certificate"), data)
+
+	require.NoError(t, cache.Delete(context.Background(), "ncps.example.com"))
+
+	_, err = cache.Get(context.Background(), "ncps.example.com")
+	require.ErrorIs(t, err, autocert.ErrCacheMiss)
+}
+
+func TestValidateACMEChallenge(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, validateACMEChallenge(acmeChallengeTLSALPN))
+	require.NoError(t, validateACMEChallenge(acmeChallengeHTTP))
+	require.ErrorIs(t, validateACMEChallenge("dns-01"), ErrUnknownACMEChallenge)
+}
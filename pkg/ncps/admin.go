@@ -0,0 +1,698 @@
+package ncps
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/kalbasit/ncps/pkg/narinfo"
+)
+
+const (
+	adminFormatTable = "table"
+	adminFormatJSON  = "json"
+
+	// defaultAdminSocket is where the NixOS module puts the control socket.
+	defaultAdminSocket = "/run/ncps/admin.sock"
+)
+
+var (
+	// ErrAdminUnknownFormat is returned for an unknown --format of ncps admin.
+	ErrAdminUnknownFormat = errors.New("unknown output format (allowed: table, json)")
+
+	// ErrAdminRequest is returned when the admin API answers with an error.
+	ErrAdminRequest = errors.New("the admin API answered with an error")
+
+	// ErrAdminArgsRequired is returned when a subcommand is given no argument.
+	ErrAdminArgsRequired = errors.New("at least one argument is required")
+)
+
+func adminCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "admin",
+		Usage: "Manage a running ncps through its admin API",
+		Description: `Calls the admin API of a running ncps, over its control socket
+(--server-admin-socket of serve) by default, or over HTTP with --url and the
+admin token (--server-admin-token of serve).`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "socket",
+				Usage:   "The control socket of the running ncps (ignored with --url)",
+				Sources: cli.EnvVars("ADMIN_SOCKET"),
+				Value:   defaultAdminSocket,
+			},
+			&cli.StringFlag{
+				Name:    "url",
+				Usage:   "The URL of the running ncps, to call the admin API over HTTP instead of the socket",
+				Sources: cli.EnvVars("ADMIN_URL"),
+			},
+			&cli.StringFlag{
+				Name:    "token",
+				Usage:   "The admin token sent with --url",
+				Sources: secretSources(cli.EnvVars("ADMIN_TOKEN")),
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "The timeout of a request; prefetch may need more",
+				Value: 5 * time.Minute,
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "The output format: table or json",
+				Value: adminFormatTable,
+				Validator: func(s string) error {
+					if s != adminFormatTable && s != adminFormatJSON {
+						return fmt.Errorf("%w: %q", ErrAdminUnknownFormat, s)
+					}
+
+					return nil
+				},
+			},
+		},
+		Commands: []*cli.Command{
+			{
+				Name:   "stats",
+				Usage:  "Show what the cache holds",
+				Action: adminAction(adminStats),
+			},
+			{
+				Name:   "health",
+				Usage:  "Show the health of every component",
+				Action: adminAction(adminHealth),
+			},
+			{
+				Name:  "gc-report",
+				Usage: "Report the orphaned and broken rows of the database without deleting anything",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "samples",
+						Usage: "The rows listed per kind",
+						Value: 10,
+					},
+				},
+				Action: adminCommandAction(adminGCReport),
+			},
+			{
+				Name:      "evict",
+				Usage:     "Delete narinfos from the cache; their NARs are left to the LRU",
+				ArgsUsage: "STORE-PATH-OR-HASH...",
+				Action:    adminAction(adminEvict),
+			},
+			{
+				Name:      "prefetch",
+				Usage:     "Pull the closures of store paths from the upstreams into the cache",
+				ArgsUsage: "STORE-PATH-OR-HASH...",
+				Action:    adminAction(adminPrefetch),
+			},
+			{
+				Name:  "pin",
+				Usage: "Manage the closures protected from the LRU",
+				Commands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "List the pinned closures",
+						Action: adminAction(adminPinList),
+					},
+					{
+						Name:      "add",
+						Usage:     "Pin the closures of cached store paths",
+						ArgsUsage: "STORE-PATH-OR-HASH...",
+						Action:    adminAction(adminPinAdd),
+					},
+					{
+						Name:      "remove",
+						Usage:     "Unpin closures",
+						ArgsUsage: "STORE-PATH-OR-HASH...",
+						Action:    adminAction(adminPinRemove),
+					},
+				},
+			},
+			{
+				Name:  "cdc",
+				Usage: "Manage content-defined chunking",
+				Commands: []*cli.Command{
+					{
+						Name:   "status",
+						Usage:  "Show whether new NARs are chunked",
+						Action: adminAction(adminCDCStatus),
+					},
+					{
+						Name:  "enable",
+						Usage: "Start chunking new NARs; sizes default to the configured or stored ones",
+						Flags: []cli.Flag{
+							&cli.Uint32Flag{Name: "min", Usage: "The minimum chunk size in bytes"},
+							&cli.Uint32Flag{Name: "avg", Usage: "The average chunk size in bytes"},
+							&cli.Uint32Flag{Name: "max", Usage: "The maximum chunk size in bytes"},
+						},
+						Action: adminCommandAction(adminCDCEnable),
+					},
+					{
+						Name:  "disable",
+						Usage: "Stop chunking new NARs once the chunking in flight is done",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "drain",
+								Usage: "Keep serving the chunked NARs from the chunk store until migrated back",
+							},
+						},
+						Action: adminCommandAction(adminCDCDisable),
+					},
+				},
+			},
+			{
+				Name:  "job",
+				Usage: "Manage the cron jobs",
+				Commands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "List the cron jobs",
+						Action: adminAction(adminJobList),
+					},
+					{
+						Name:      "trigger",
+						Usage:     "Start a run of a cron job now",
+						ArgsUsage: "NAME",
+						Action:    adminAction(adminJobAction("trigger")),
+					},
+					{
+						Name:      "pause",
+						Usage:     "Skip the scheduled runs of a cron job until resumed",
+						ArgsUsage: "NAME",
+						Action:    adminAction(adminJobAction("pause")),
+					},
+					{
+						Name:      "resume",
+						Usage:     "Resume the scheduled runs of a cron job",
+						ArgsUsage: "NAME",
+						Action:    adminAction(adminJobAction("resume")),
+					},
+				},
+			},
+		},
+	}
+}
+
+// adminClient calls the admin API and prints its answers in the requested
+// format.
+type adminClient struct {
+	*cacheClient
+
+	format string
+	out    io.Writer
+}
+
+func adminAction(fn func(context.Context, *adminClient, []string) error) cli.ActionFunc {
+	return func(ctx context.Context, cmd *cli.Command) error {
+		c, err := newAdminClient(cmd, cmd.Root().Writer)
+		if err != nil {
+			return err
+		}
+
+		return fn(ctx, c, cmd.Args().Slice())
+	}
+}
+
+// adminCommandAction is adminAction for the subcommands with flags of their
+// own.
+func adminCommandAction(fn func(context.Context, *adminClient, *cli.Command) error) cli.ActionFunc {
+	return func(ctx context.Context, cmd *cli.Command) error {
+		c, err := newAdminClient(cmd, cmd.Root().Writer)
+		if err != nil {
+			return err
+		}
+
+		return fn(ctx, c, cmd)
+	}
+}
+
+func newAdminClient(cmd *cli.Command, out io.Writer) (*adminClient, error) {
+	rawURL := cmd.String("url")
+	if rawURL == "" {
+		client := newSocketClient(cmd.String("socket"), cmd.Duration("timeout"))
+
+		return &adminClient{cacheClient: client, format: cmd.String("format"), out: out}, nil
+	}
+
+	token, err := secretValue(cmd, "token")
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newCacheClient(rawURL, token, cmd.Duration("timeout"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &adminClient{cacheClient: client, format: cmd.String("format"), out: out}, nil
+}
+
+// call sends a request with the JSON of in, if not nil, to the admin API path
+// and returns the body of the answer. An error answer is returned with its body
+// and an error wrapping ErrAdminRequest.
+func (c *adminClient) call(ctx context.Context, method, path string, in any) ([]byte, error) {
+	var body io.Reader
+
+	header := http.Header{"Accept": {"application/json"}}
+
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding the request: %w", err)
+		}
+
+		body = bytes.NewReader(data)
+
+		header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.do(ctx, method, c.resolve("api/v1/"+path), header, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the answer: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		var p struct {
+			Detail string `json:"detail"`
+			Code   string `json:"code"`
+		}
+
+		detail := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &p) == nil && p.Code != "" {
+			detail = p.Code
+			if p.Detail != "" {
+				detail = p.Detail + " (" + p.Code + ")"
+			}
+		}
+
+		return data, fmt.Errorf("%w: %s %s: %s: %s", ErrAdminRequest, method, path, resp.Status, detail)
+	}
+
+	return data, nil
+}
+
+// print prints data, the JSON answer of the admin API, as is in the json
+// format, or decoded into v and rendered by table otherwise.
+func (c *adminClient) print(data []byte, v any, table func(w io.Writer)) error {
+	if c.format == adminFormatJSON {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, bytes.TrimSpace(data), "", "  "); err != nil {
+			return fmt.Errorf("error formatting the answer: %w", err)
+		}
+
+		_, err := fmt.Fprintln(c.out, buf.String())
+
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("error decoding the answer: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+
+	table(tw)
+
+	return tw.Flush()
+}
+
+// printDone reports the completion of an action without an answer.
+func (c *adminClient) printDone(action, target string) error {
+	var err error
+
+	if c.format == adminFormatJSON {
+		_, err = fmt.Fprintf(c.out, "{\"%s\": %q}\n", action, target)
+	} else {
+		_, err = fmt.Fprintf(c.out, "%s %s\n", action, target)
+	}
+
+	return err
+}
+
+func adminStats(ctx context.Context, c *adminClient, _ []string) error {
+	data, err := c.call(ctx, http.MethodGet, "stats", nil)
+	if err != nil {
+		return err
+	}
+
+	var stats struct {
+		NarInfos         int    `json:"narInfos"`
+		NarFiles         int    `json:"narFiles"`
+		TotalSize        int64  `json:"totalSize"`
+		MaxSize          uint64 `json:"maxSize"`
+		Chunks           int    `json:"chunks"`
+		PinnedClosures   int    `json:"pinnedClosures"`
+		Upstreams        int    `json:"upstreams"`
+		HealthyUpstreams int    `json:"healthyUpstreams"`
+	}
+
+	return c.print(data, &stats, func(w io.Writer) {
+		maxSize := "unlimited"
+		if stats.MaxSize > 0 {
+			maxSize = strconv.FormatUint(stats.MaxSize, 10)
+		}
+
+		fmt.Fprintf(w, "narinfos\t%d\n", stats.NarInfos)
+		fmt.Fprintf(w, "nar files\t%d\n", stats.NarFiles)
+		fmt.Fprintf(w, "total size\t%d\n", stats.TotalSize)
+		fmt.Fprintf(w, "max size\t%s\n", maxSize)
+		fmt.Fprintf(w, "chunks\t%d\n", stats.Chunks)
+		fmt.Fprintf(w, "pinned closures\t%d\n", stats.PinnedClosures)
+		fmt.Fprintf(w, "upstreams\t%d healthy of %d\n", stats.HealthyUpstreams, stats.Upstreams)
+	})
+}
+
+func adminGCReport(ctx context.Context, c *adminClient, cmd *cli.Command) error {
+	data, err := c.call(ctx, http.MethodGet, "gc/report?samples="+strconv.Itoa(cmd.Int("samples")), nil)
+	if err != nil {
+		return err
+	}
+
+	type finding struct {
+		Count   int      `json:"count"`
+		Samples []string `json:"samples"`
+	}
+
+	var report struct {
+		OrphanedNarInfos finding `json:"orphanedNarInfos"`
+		OrphanedNarFiles finding `json:"orphanedNarFiles"`
+		OrphanedChunks   finding `json:"orphanedChunks"`
+		BrokenChunkLinks finding `json:"brokenChunkLinks"`
+	}
+
+	return c.print(data, &report, func(w io.Writer) {
+		fmt.Fprintf(w, "KIND\tCOUNT\tSAMPLES\n")
+
+		for _, row := range []struct {
+			kind string
+			finding
+		}{
+			{"orphaned narinfos", report.OrphanedNarInfos},
+			{"orphaned nar files", report.OrphanedNarFiles},
+			{"orphaned chunks", report.OrphanedChunks},
+			{"broken chunk links", report.BrokenChunkLinks},
+		} {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", row.kind, row.Count, strings.Join(row.Samples, " "))
+		}
+	})
+}
+
+func adminHealth(ctx context.Context, c *adminClient, _ []string) error {
+	data, callErr := c.call(ctx, http.MethodGet, "health/detail", nil)
+
+	var report struct {
+		Status     string `json:"status"`
+		Components []struct {
+			Kind      string `json:"kind"`
+			Name      string `json:"name"`
+			Status    string `json:"status"`
+			Latency   string `json:"latency"`
+			LastError string `json:"lastError"`
+		} `json:"components"`
+	}
+
+	// A down cache answers 503 with its report: print it, then fail.
+	if callErr != nil && (json.Unmarshal(data, &report) != nil || report.Status == "") {
+		return callErr
+	}
+
+	err := c.print(data, &report, func(w io.Writer) {
+		fmt.Fprintf(w, "KIND\tNAME\tSTATUS\tLATENCY\tLAST ERROR\n")
+
+		for _, h := range report.Components {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", h.Kind, h.Name, h.Status, h.Latency, h.LastError)
+		}
+
+		fmt.Fprintf(w, "overall\t\t%s\t\t\n", report.Status)
+	})
+
+	return errors.Join(err, callErr)
+}
+
+func adminEvict(ctx context.Context, c *adminClient, args []string) error {
+	return c.eachHash(args, func(hash string) error {
+		if _, err := c.call(ctx, http.MethodDelete, "narinfos/"+hash, nil); err != nil {
+			return err
+		}
+
+		return c.printDone("evicted", hash)
+	})
+}
+
+func adminPrefetch(ctx context.Context, c *adminClient, args []string) error {
+	if len(args) == 0 {
+		return ErrAdminArgsRequired
+	}
+
+	data, err := c.call(ctx, http.MethodPost, "prefetch", map[string][]string{"storePaths": args})
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Roots   int `json:"roots"`
+		Cached  int `json:"cached"`
+		Fetched int `json:"fetched"`
+		Missing int `json:"missing"`
+		Failed  int `json:"failed"`
+	}
+
+	return c.print(data, &result, func(w io.Writer) {
+		fmt.Fprintf(w, "ROOTS\tCACHED\tFETCHED\tMISSING\tFAILED\n")
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\n", result.Roots, result.Cached, result.Fetched, result.Missing, result.Failed)
+	})
+}
+
+func adminPinList(ctx context.Context, c *adminClient, _ []string) error {
+	data, err := c.call(ctx, http.MethodGet, "pins", nil)
+	if err != nil {
+		return err
+	}
+
+	var hashes []string
+
+	return c.print(data, &hashes, func(w io.Writer) {
+		for _, hash := range hashes {
+			fmt.Fprintln(w, hash)
+		}
+	})
+}
+
+func adminPinAdd(ctx context.Context, c *adminClient, args []string) error {
+	return c.eachHash(args, func(hash string) error {
+		if _, err := c.call(ctx, http.MethodPost, "pins/"+hash, nil); err != nil {
+			return err
+		}
+
+		return c.printDone("pinned", hash)
+	})
+}
+
+func adminPinRemove(ctx context.Context, c *adminClient, args []string) error {
+	return c.eachHash(args, func(hash string) error {
+		if _, err := c.call(ctx, http.MethodDelete, "pins/"+hash, nil); err != nil {
+			return err
+		}
+
+		return c.printDone("unpinned", hash)
+	})
+}
+
+// eachHash calls fn with the narinfo hash of every store path of args.
+func (c *adminClient) eachHash(args []string, fn func(hash string) error) error {
+	if len(args) == 0 {
+		return ErrAdminArgsRequired
+	}
+
+	for _, arg := range args {
+		hash, err := narinfo.HashFromStorePath(arg)
+		if err != nil {
+			return fmt.Errorf("invalid store path %q: %w", arg, err)
+		}
+
+		if err := fn(hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// adminCronJob is the JSON of a cron job of the admin API.
+type adminCronJob struct {
+	Name        string     `json:"name"`
+	Paused      bool       `json:"paused"`
+	Deferred    bool       `json:"deferred"`
+	Running     bool       `json:"running"`
+	NextRun     *time.Time `json:"nextRun"`
+	LastRun     *time.Time `json:"lastRun"`
+	LastSuccess *bool      `json:"lastSuccess"`
+	LastError   string     `json:"lastError"`
+	Runs        int64      `json:"runs"`
+	Failures    int64      `json:"failures"`
+}
+
+func (j adminCronJob) state() string {
+	switch {
+	case j.Running:
+		return "running"
+	case j.Paused:
+		return "paused"
+	case j.Deferred:
+		return "deferred"
+	default:
+		return "scheduled"
+	}
+}
+
+func (j adminCronJob) lastOutcome() string {
+	switch {
+	case j.LastSuccess == nil:
+		return "-"
+	case *j.LastSuccess:
+		return "ok"
+	default:
+		return "failed: " + j.LastError
+	}
+}
+
+func formatAdminTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.Local().Format(time.RFC3339)
+}
+
+func printCronJobs(w io.Writer, jobs []adminCronJob) {
+	fmt.Fprintf(w, "NAME\tSTATE\tNEXT RUN\tLAST RUN\tRUNS\tFAILURES\tLAST OUTCOME\n")
+
+	for _, j := range jobs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
+			j.Name, j.state(), formatAdminTime(j.NextRun), formatAdminTime(j.LastRun), j.Runs, j.Failures, j.lastOutcome())
+	}
+}
+
+func adminJobList(ctx context.Context, c *adminClient, _ []string) error {
+	data, err := c.call(ctx, http.MethodGet, "cron/jobs", nil)
+	if err != nil {
+		return err
+	}
+
+	var jobs []adminCronJob
+
+	return c.print(data, &jobs, func(w io.Writer) { printCronJobs(w, jobs) })
+}
+
+func adminJobAction(action string) func(context.Context, *adminClient, []string) error {
+	return func(ctx context.Context, c *adminClient, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("%w: the name of the cron job", ErrAdminArgsRequired)
+		}
+
+		data, err := c.call(ctx, http.MethodPost, "cron/jobs/"+url.PathEscape(args[0])+"/"+action, nil)
+		if err != nil {
+			return err
+		}
+
+		var job adminCronJob
+
+		return c.print(data, &job, func(w io.Writer) { printCronJobs(w, []adminCronJob{job}) })
+	}
+}
+
+// adminCDC is the JSON of the state of CDC of the admin API.
+type adminCDC struct {
+	Enabled      bool   `json:"enabled"`
+	ChunkStore   bool   `json:"chunkStore"`
+	LazyChunking bool   `json:"lazyChunking"`
+	MinSize      uint32 `json:"minSize"`
+	AvgSize      uint32 `json:"avgSize"`
+	MaxSize      uint32 `json:"maxSize"`
+	InFlightJobs int    `json:"inFlightJobs"`
+	ChunkedNars  int    `json:"chunkedNars"`
+}
+
+func (c *adminClient) printCDC(data []byte) error {
+	var status adminCDC
+
+	return c.print(data, &status, func(w io.Writer) {
+		fmt.Fprintf(w, "enabled\t%t\n", status.Enabled)
+		fmt.Fprintf(w, "chunk store\t%t\n", status.ChunkStore)
+		fmt.Fprintf(w, "lazy chunking\t%t\n", status.LazyChunking)
+
+		if status.AvgSize > 0 {
+			fmt.Fprintf(w, "chunk sizes\t%d / %d / %d\n", status.MinSize, status.AvgSize, status.MaxSize)
+		}
+
+		fmt.Fprintf(w, "jobs in flight\t%d\n", status.InFlightJobs)
+		fmt.Fprintf(w, "chunked nars\t%d\n", status.ChunkedNars)
+	})
+}
+
+func adminCDCStatus(ctx context.Context, c *adminClient, _ []string) error {
+	data, err := c.call(ctx, http.MethodGet, "cdc", nil)
+	if err != nil {
+		return err
+	}
+
+	return c.printCDC(data)
+}
+
+func adminCDCEnable(ctx context.Context, c *adminClient, cmd *cli.Command) error {
+	data, err := c.call(ctx, http.MethodPost, "cdc/enable", map[string]uint32{
+		"minSize": cmd.Uint32("min"),
+		"avgSize": cmd.Uint32("avg"),
+		"maxSize": cmd.Uint32("max"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.printCDC(data)
+}
+
+func adminCDCDisable(ctx context.Context, c *adminClient, cmd *cli.Command) error {
+	data, err := c.call(ctx, http.MethodPost, "cdc/disable", map[string]bool{"drain": cmd.Bool("drain")})
+	if err != nil {
+		return err
+	}
+
+	return c.printCDC(data)
+}
+
+// newSocketClient returns a cacheClient of the ncps listening on the Unix
+// socket at path.
+func newSocketClient(path string, timeout time.Duration) *cacheClient {
+	var d net.Dialer
+
+	return &cacheClient{
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return d.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+		// The host is not used to connect.
+		baseURL: &url.URL{Scheme: "http", Host: "ncps", Path: "/"},
+	}
+}
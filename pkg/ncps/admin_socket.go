@@ -0,0 +1,82 @@
+package ncps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// ErrAdminSocketInUse is returned if the path of the control socket is taken
+// by something else than a socket, which is not removed.
+var ErrAdminSocketInUse = errors.New("the control socket path exists and is not a socket")
+
+// parseSocketMode parses the octal permissions of the control socket.
+func parseSocketMode(s string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing the socket mode %q: %w", s, err)
+	}
+
+	return fs.FileMode(mode) & fs.ModePerm, nil
+}
+
+// listenAdminSocket listens on the Unix socket at path with the given
+// permissions, replacing the socket left over by a previous run.
+func listenAdminSocket(path string, mode fs.FileMode) (net.Listener, error) {
+	info, err := os.Lstat(path)
+
+	switch {
+	case err == nil && info.Mode().Type() != fs.ModeSocket:
+		return nil, fmt.Errorf("%w: %s", ErrAdminSocketInUse, path)
+	case err == nil:
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("error removing the stale control socket: %w", err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("error inspecting the control socket path: %w", err)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on the control socket: %w", err)
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		_ = l.Close()
+
+		return nil, fmt.Errorf("error setting the permissions of the control socket: %w", err)
+	}
+
+	return l, nil
+}
+
+// serveAdminSocket serves handler on the control socket at path and returns
+// the server, whose shutdown removes the socket.
+func serveAdminSocket(ctx context.Context, path string, mode fs.FileMode, handler http.Handler) (*http.Server, error) {
+	l, err := listenAdminSocket(path, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zerolog.Ctx(ctx).Error().Err(err).Str("path", path).Msg("control socket server error")
+		}
+	}()
+
+	return server, nil
+}
@@ -0,0 +1,94 @@
+package ncps
+
+import (
+	"context"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeAdminSocket(t *testing.T) {
+	t.Parallel()
+
+	// The path of a Unix socket is limited to about a hundred bytes, shorter
+	// than some test directories.
+	dir, err := os.MkdirTemp("", "ncps-socket")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "admin.sock")
+
+	// A socket left over by a previous run is replaced.
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	ul, ok := stale.(*net.UnixListener)
+	require.True(t, ok)
+
+	ul.SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	server, err := serveAdminSocket(context.Background(), path, 0o600, handler)
+	require.NoError(t, err)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0o600), info.Mode().Perm())
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://ncps/api/v1/cron/jobs", nil)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+
+	require.NoError(t, server.Shutdown(context.Background()))
+
+	_, err = os.Stat(path)
+	require.ErrorIs(t, err, fs.ErrNotExist, "the socket is removed on shutdown")
+}
+
+func TestListenAdminSocketKeepsOtherFiles(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0o600))
+
+	_, err := listenAdminSocket(path, 0o600)
+	require.ErrorIs(t, err, ErrAdminSocketInUse)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+}
+
+func TestParseSocketMode(t *testing.T) {
+	t.Parallel()
+
+	mode, err := parseSocketMode("0660")
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0o660), mode)
+
+	_, err = parseSocketMode("rw-rw----")
+	require.Error(t, err)
+}
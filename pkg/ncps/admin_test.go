@@ -0,0 +1,195 @@
+package ncps_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/ncps"
+)
+
+const adminTestHash = "00000000000000000000000000000000"
+
+// newAdminTestServer fakes the admin API, requiring the token, and records the
+// requests it got.
+func newAdminTestServer(t *testing.T, token string) (*httptest.Server, func() []string) {
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/stats", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, `{"narInfos": 3, "narFiles": 2, "totalSize": 1024, "maxSize": 0, `+
+			`"chunks": 0, "pinnedClosures": 1, "upstreams": 2, "healthyUpstreams": 1}`)
+	})
+	mux.HandleFunc("GET /api/v1/gc/report", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, `{"orphanedNarInfos": {"count": 2, "samples": ["`+adminTestHash+`"]}, `+
+			`"orphanedNarFiles": {"count": 0, "samples": []}, "orphanedChunks": {"count": 0, "samples": []}, `+
+			`"brokenChunkLinks": {"count": 0, "samples": []}}`)
+	})
+	mux.HandleFunc("GET /api/v1/cron/jobs", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, `[{"name": "lru", "paused": true, "runs": 2, "failures": 0, "lastSuccess": true}]`)
+	})
+	mux.HandleFunc("DELETE /api/v1/narinfos/{hash}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("hash") != adminTestHash {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"status": 404, "code": "narinfo_not_found", "detail": "not found"}`)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /api/v1/prefetch", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			StorePaths []string `json:"storePaths"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		_, _ = io.WriteString(w, `{"roots": 1, "cached": 0, "fetched": 2, "missing": 0, "failed": 0}`)
+	})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]string(nil), requests...)
+	}
+}
+
+func runAdmin(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	app, err := ncps.New()
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+
+	app.Writer = &out
+
+	err = app.Run(context.Background(), append([]string{"ncps", "admin"}, args...))
+
+	return out.String(), err
+}
+
+func TestAdmin(t *testing.T) {
+	t.Parallel()
+
+	ts, requests := newAdminTestServer(t, "secret")
+
+	t.Run("stats as a table", func(t *testing.T) {
+		t.Parallel()
+
+		out, err := runAdmin(t, "--url", ts.URL, "--token", "secret", "stats")
+		require.NoError(t, err)
+
+		assert.Regexp(t, `narinfos\s+3`, out)
+		assert.Regexp(t, `max size\s+unlimited`, out)
+		assert.Regexp(t, `upstreams\s+1 healthy of 2`, out)
+	})
+
+	t.Run("gc report as a table", func(t *testing.T) {
+		t.Parallel()
+
+		out, err := runAdmin(t, "--url", ts.URL, "--token", "secret", "gc-report", "--samples", "3")
+		require.NoError(t, err)
+
+		assert.Regexp(t, `orphaned narinfos\s+2\s+`+adminTestHash, out)
+		assert.Regexp(t, `broken chunk links\s+0`, out)
+	})
+
+	t.Run("job list as JSON", func(t *testing.T) {
+		t.Parallel()
+
+		out, err := runAdmin(t, "--url", ts.URL, "--token", "secret", "--format", "json", "job", "list")
+		require.NoError(t, err)
+
+		var jobs []map[string]any
+		require.NoError(t, json.Unmarshal([]byte(out), &jobs))
+		require.Len(t, jobs, 1)
+		assert.Equal(t, "lru", jobs[0]["name"])
+	})
+
+	t.Run("job list as a table", func(t *testing.T) {
+		t.Parallel()
+
+		out, err := runAdmin(t, "--url", ts.URL, "--token", "secret", "job", "list")
+		require.NoError(t, err)
+
+		assert.Regexp(t, `lru\s+paused\s+-\s+-\s+2\s+0\s+ok`, out)
+	})
+
+	t.Run("evict by store path", func(t *testing.T) {
+		t.Parallel()
+
+		out, err := runAdmin(t, "--url", ts.URL, "--token", "secret", "evict", "/nix/store/"+adminTestHash+"-hello")
+		require.NoError(t, err)
+
+		assert.Equal(t, "evicted "+adminTestHash+"\n", out)
+		assert.Contains(t, requests(), "DELETE /api/v1/narinfos/"+adminTestHash)
+	})
+
+	t.Run("evict reports the problem", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runAdmin(t, "--url", ts.URL, "--token", "secret", "evict", strings.Repeat("1", 32))
+		require.ErrorIs(t, err, ncps.ErrAdminRequest)
+		assert.Contains(t, err.Error(), "narinfo_not_found")
+	})
+
+	t.Run("prefetch", func(t *testing.T) {
+		t.Parallel()
+
+		out, err := runAdmin(t, "--url", ts.URL, "--token", "secret", "prefetch", "/nix/store/"+adminTestHash+"-hello")
+		require.NoError(t, err)
+
+		assert.Regexp(t, `1\s+0\s+2\s+0\s+0`, out)
+	})
+
+	t.Run("wrong token", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runAdmin(t, "--url", ts.URL, "--token", "wrong", "stats")
+		require.ErrorIs(t, err, ncps.ErrAdminRequest)
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runAdmin(t, "--url", ts.URL, "--format", "yaml", "stats")
+		require.ErrorContains(t, err, ncps.ErrAdminUnknownFormat.Error())
+	})
+}
@@ -0,0 +1,601 @@
+package ncps
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	mathrand "math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/nixbase32"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/sync/errgroup"
+
+	nixnar "github.com/nix-community/go-nix/pkg/nar"
+
+	"github.com/kalbasit/ncps/pkg/helper"
+)
+
+const (
+	benchOpUpload      = "upload"
+	benchOpNarInfoHit  = "narinfo-hit"
+	benchOpNarInfoMiss = "narinfo-miss"
+	benchOpNar         = "nar"
+
+	// benchStorePathHashSize is the size of the hash of a store path, which is
+	// also the hash of its narinfo.
+	benchStorePathHashSize = 20
+)
+
+var (
+	// ErrBenchUpload is returned when the synthetic store paths cannot be
+	// uploaded.
+	ErrBenchUpload = errors.New("error uploading the synthetic store paths (is --cache-allow-put-verb set?)")
+
+	// ErrBenchInvalidHitRatio is returned for a --hit-ratio outside of [0, 1].
+	ErrBenchInvalidHitRatio = errors.New("the hit ratio must be between 0 and 1")
+
+	errBenchUnexpectedStatus = errors.New("unexpected HTTP status")
+)
+
+// benchReport is the result of a bench run.
+type benchReport struct {
+	URL         string  `json:"url"`
+	Objects     int     `json:"objects"`
+	Concurrency int     `json:"concurrency"`
+	HitRatio    float64 `json:"hitRatio"`
+
+	// DurationSeconds is how long the traffic ran, the upload excluded.
+	DurationSeconds float64 `json:"durationSeconds"`
+
+	Operations []benchOpReport `json:"operations"`
+}
+
+// benchOpReport summarizes the requests of an operation. The latencies are in
+// milliseconds and only count the successful requests.
+type benchOpReport struct {
+	Name              string  `json:"name"`
+	Requests          int     `json:"requests"`
+	Errors            int     `json:"errors"`
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	BytesPerSecond    float64 `json:"bytesPerSecond"`
+	LatencyP50        float64 `json:"latencyP50Ms"`
+	LatencyP90        float64 `json:"latencyP90Ms"`
+	LatencyP99        float64 `json:"latencyP99Ms"`
+	LatencyMax        float64 `json:"latencyMaxMs"`
+}
+
+func benchCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "bench",
+		Usage: "Generate synthetic narinfo and nar traffic against a running ncps and report its performance",
+		Description: `Uploads --objects synthetic store paths of --nar-size to a running ncps, which
+must allow PUT (--cache-allow-put-verb), under --upload-url, then runs --concurrency clients for
+--duration, each looping over:
+
+  - a narinfo hit, with a probability of --hit-ratio, followed by the
+    download of its nar
+  - otherwise a narinfo miss, a narinfo no store path has, which ncps looks
+    up from its upstreams
+
+The report gives the latency percentiles and the throughput of the uploads,
+narinfo hits, narinfo misses and nar downloads, as text or, with
+--format=json, as JSON, to compare storage and database backends. Run it
+against a disposable instance: the synthetic store paths are left in the
+cache.`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "url",
+				Usage:    "The URL of the ncps to benchmark, e.g. http://localhost:8501",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "upload-url",
+				Usage: "The URL the synthetic store paths are uploaded to (default: --url followed by /upload)",
+			},
+			&cli.StringFlag{
+				Name:    "token",
+				Usage:   "The Bearer token sent to a cache requiring one (see --cache-get-token of serve)",
+				Sources: secretSources(cli.EnvVars("BENCH_TOKEN")),
+			},
+			&cli.IntFlag{
+				Name:  "objects",
+				Usage: "The number of synthetic store paths to upload",
+				Value: 100,
+			},
+			&cli.StringSliceFlag{
+				Name:  "nar-size",
+				Usage: "The size of the nars of the synthetic store paths, e.g. 64K (repeatable, used in turn)",
+				Value: []string{"64K"},
+				Validator: func(sizes []string) error {
+					for _, s := range sizes {
+						if _, err := helper.ParseSize(s); err != nil {
+							return err
+						}
+					}
+
+					return nil
+				},
+			},
+			&cli.Float64Flag{
+				Name:  "hit-ratio",
+				Usage: "The share of the narinfo requests for an uploaded store path, between 0 and 1",
+				Value: 0.9,
+				Validator: func(r float64) error {
+					if r < 0 || r > 1 {
+						return fmt.Errorf("%w: %v", ErrBenchInvalidHitRatio, r)
+					}
+
+					return nil
+				},
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "The number of concurrent clients",
+				Value: 16,
+			},
+			&cli.DurationFlag{
+				Name:  "duration",
+				Usage: "How long to run the traffic for",
+				Value: 30 * time.Second,
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "The timeout of each HTTP request",
+				Value: time.Minute,
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Report format: text or json",
+				Value: verifyFormatText,
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "Write the report to this file instead of stdout",
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := zerolog.Ctx(ctx).With().Str("cmd", "bench").Logger()
+			ctx = logger.WithContext(ctx)
+
+			return runBenchCommand(ctx, cmd)
+		},
+	}
+}
+
+func runBenchCommand(ctx context.Context, cmd *cli.Command) error {
+	format := cmd.String("format")
+	if format != verifyFormatText && format != verifyFormatJSON {
+		return fmt.Errorf("%w: %q", ErrVerifyUnknownFormat, format)
+	}
+
+	token, err := secretValue(cmd, "token")
+	if err != nil {
+		return err
+	}
+
+	client, err := newCacheClient(cmd.String("url"), token, cmd.Duration("timeout"))
+	if err != nil {
+		return err
+	}
+
+	uploadURL := cmd.String("upload-url")
+	if uploadURL == "" {
+		uploadURL = client.resolve("upload").String()
+	}
+
+	uploadClient, err := newCacheClient(uploadURL, token, cmd.Duration("timeout"))
+	if err != nil {
+		return err
+	}
+
+	sizes := make([]int, 0, len(cmd.StringSlice("nar-size")))
+
+	for _, s := range cmd.StringSlice("nar-size") {
+		size, err := helper.ParseSize(s)
+		if err != nil {
+			return fmt.Errorf("error parsing --nar-size: %w", err)
+		}
+
+		sizes = append(sizes, int(size)) //nolint:gosec // G115: a synthetic nar is held in memory
+	}
+
+	b := &bencher{
+		cacheClient:  client,
+		uploadClient: uploadClient,
+		concurrency:  max(1, int(cmd.Int("concurrency"))),
+		hitRatio:     cmd.Float64("hit-ratio"),
+		stats:        make(map[string]*benchOpStats),
+	}
+
+	objects := max(1, int(cmd.Int("objects")))
+
+	zerolog.Ctx(ctx).Info().Int("objects", objects).Msg("uploading the synthetic store paths")
+
+	if err := b.upload(ctx, objects, sizes); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().Dur("duration", cmd.Duration("duration")).Msg("running the traffic")
+
+	elapsed := b.run(ctx, cmd.Duration("duration"))
+
+	report := b.report(elapsed)
+
+	out := io.Writer(os.Stdout)
+
+	if path := cmd.String("output"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("error creating the report file: %w", err)
+		}
+
+		defer f.Close()
+
+		out = f
+	}
+
+	if err := writeBenchReport(out, format, report); err != nil {
+		return fmt.Errorf("error writing the report: %w", err)
+	}
+
+	return nil
+}
+
+// bencher generates the synthetic traffic and records the outcome of every
+// request in stats, by operation.
+type bencher struct {
+	*cacheClient
+
+	// uploadClient makes the PUT requests, which ncps serves under /upload.
+	uploadClient *cacheClient
+
+	concurrency int
+	hitRatio    float64
+
+	// hashes are the narinfo hashes of the uploaded store paths, and
+	// uploadTime how long their upload took.
+	hashes     []string
+	uploadTime time.Duration
+
+	mu    sync.Mutex
+	stats map[string]*benchOpStats
+}
+
+type benchOpStats struct {
+	latencies []time.Duration
+	errors    int
+	bytes     int64
+}
+
+func (b *bencher) record(op string, start time.Time, n int64, err error) {
+	latency := time.Since(start)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.stats[op]
+	if !ok {
+		s = &benchOpStats{}
+		b.stats[op] = s
+	}
+
+	if err != nil {
+		s.errors++
+
+		return
+	}
+
+	s.latencies = append(s.latencies, latency)
+	s.bytes += n
+}
+
+// upload uploads objects synthetic store paths, whose nars have the sizes in
+// turn.
+func (b *bencher) upload(ctx context.Context, objects int, sizes []int) error {
+	b.hashes = make([]string, objects)
+
+	defer func(start time.Time) { b.uploadTime = time.Since(start) }(time.Now())
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(b.concurrency)
+
+	for i := range objects {
+		g.Go(func() error {
+			hash, narInfo, narData, err := generateBenchStorePath(i, sizes[i%len(sizes)])
+			if err != nil {
+				return err
+			}
+
+			start := time.Now()
+
+			err = b.put(ctx, narInfo.URL, narData)
+			if err == nil {
+				err = b.put(ctx, hash+".narinfo", []byte(narInfo.String()))
+			}
+
+			b.record(benchOpUpload, start, int64(len(narData)), err)
+
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrBenchUpload, err)
+			}
+
+			b.hashes[i] = hash
+
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+func (b *bencher) put(ctx context.Context, ref string, body []byte) error {
+	u := b.uploadClient.resolve(ref)
+
+	resp, err := b.uploadClient.do(ctx, http.MethodPut, u, nil, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%w: PUT %s: %d", errBenchUnexpectedStatus, u, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// run runs the traffic for duration and returns how long it ran.
+func (b *bencher) run(ctx context.Context, duration time.Duration) time.Duration {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	start := time.Now()
+
+	var wg sync.WaitGroup
+
+	for range b.concurrency {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				//nolint:gosec // G404: picks synthetic traffic, not a secret
+				if mathrand.Float64() < b.hitRatio {
+					b.hit(ctx, b.hashes[mathrand.IntN(len(b.hashes))])
+				} else {
+					b.miss(ctx)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return time.Since(start)
+}
+
+// hit fetches the narinfo hash and then its nar, like Nix substituting a
+// store path.
+func (b *bencher) hit(ctx context.Context, hash string) {
+	start := time.Now()
+
+	body, err := b.fetch(ctx, hash+".narinfo", http.StatusOK)
+	if ctx.Err() != nil {
+		return
+	}
+
+	var ni *narinfo.NarInfo
+	if err == nil {
+		ni, err = narinfo.Parse(bytes.NewReader(body))
+	}
+
+	b.record(benchOpNarInfoHit, start, int64(len(body)), err)
+
+	if err != nil {
+		return
+	}
+
+	start = time.Now()
+
+	body, err = b.fetch(ctx, ni.URL, http.StatusOK)
+	if ctx.Err() != nil {
+		return
+	}
+
+	b.record(benchOpNar, start, int64(len(body)), err)
+}
+
+// miss fetches the narinfo of a random hash no store path has.
+func (b *bencher) miss(ctx context.Context) {
+	hash := make([]byte, benchStorePathHashSize)
+	_, _ = rand.Read(hash)
+
+	start := time.Now()
+
+	body, err := b.fetch(ctx, nixbase32.EncodeToString(hash)+".narinfo", http.StatusNotFound)
+	if ctx.Err() != nil {
+		return
+	}
+
+	b.record(benchOpNarInfoMiss, start, int64(len(body)), err)
+}
+
+// fetch returns the body of ref, or an error when it is not answered with
+// status.
+func (b *bencher) fetch(ctx context.Context, ref string, status int) ([]byte, error) {
+	u := b.resolve(ref)
+
+	resp, err := b.do(ctx, http.MethodGet, u, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", u, err)
+	}
+
+	if resp.StatusCode != status {
+		return nil, fmt.Errorf("%w: GET %s: %d", errBenchUnexpectedStatus, u, resp.StatusCode)
+	}
+
+	return body, nil
+}
+
+func (b *bencher) report(elapsed time.Duration) *benchReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	report := &benchReport{
+		URL:             b.baseURL.String(),
+		Objects:         len(b.hashes),
+		Concurrency:     b.concurrency,
+		HitRatio:        b.hitRatio,
+		DurationSeconds: elapsed.Seconds(),
+		Operations:      []benchOpReport{},
+	}
+
+	for _, op := range []string{benchOpUpload, benchOpNarInfoHit, benchOpNarInfoMiss, benchOpNar} {
+		s, ok := b.stats[op]
+		if !ok {
+			continue
+		}
+
+		slices.Sort(s.latencies)
+
+		// The uploads are rated over their own time, not the traffic's.
+		over := elapsed.Seconds()
+		if op == benchOpUpload {
+			over = b.uploadTime.Seconds()
+		}
+
+		r := benchOpReport{
+			Name:       op,
+			Requests:   len(s.latencies) + s.errors,
+			Errors:     s.errors,
+			LatencyP50: benchPercentile(s.latencies, 0.5),
+			LatencyP90: benchPercentile(s.latencies, 0.9),
+			LatencyP99: benchPercentile(s.latencies, 0.99),
+			LatencyMax: benchPercentile(s.latencies, 1),
+		}
+
+		if over > 0 {
+			r.RequestsPerSecond = float64(len(s.latencies)) / over
+			r.BytesPerSecond = float64(s.bytes) / over
+		}
+
+		report.Operations = append(report.Operations, r)
+	}
+
+	return report
+}
+
+// benchPercentile returns the q-quantile of the sorted latencies, in
+// milliseconds.
+func benchPercentile(latencies []time.Duration, q float64) float64 {
+	if len(latencies) == 0 {
+		return 0
+	}
+
+	i := max(0, int(math.Ceil(q*float64(len(latencies))))-1)
+
+	return float64(latencies[i].Microseconds()) / float64(time.Millisecond/time.Microsecond)
+}
+
+// generateBenchStorePath returns the narinfo hash, the narinfo and the nar of
+// the i-th synthetic store path, a single file of random content whose nar is
+// about size bytes.
+func generateBenchStorePath(i, size int) (string, *narinfo.NarInfo, []byte, error) {
+	content := make([]byte, size)
+	_, _ = rand.Read(content)
+
+	var buf bytes.Buffer
+
+	nw, err := nixnar.NewWriter(&buf)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("error creating the nar writer: %w", err)
+	}
+
+	if err := nw.WriteHeader(&nixnar.Header{Path: "/", Type: nixnar.TypeRegular, Size: int64(size)}); err != nil {
+		return "", nil, nil, fmt.Errorf("error writing the nar header: %w", err)
+	}
+
+	if _, err := nw.Write(content); err != nil {
+		return "", nil, nil, fmt.Errorf("error writing the nar: %w", err)
+	}
+
+	if err := nw.Close(); err != nil {
+		return "", nil, nil, fmt.Errorf("error closing the nar writer: %w", err)
+	}
+
+	narData := buf.Bytes()
+	digest := sha256.Sum256(narData)
+
+	narHash, err := nixhash.NewHashWithEncoding(nixhash.SHA256, digest[:], nixhash.NixBase32, true)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("error encoding the nar hash: %w", err)
+	}
+
+	pathHash := make([]byte, benchStorePathHashSize)
+	_, _ = rand.Read(pathHash)
+
+	hash := nixbase32.EncodeToString(pathHash)
+
+	return hash, &narinfo.NarInfo{
+		StorePath:   fmt.Sprintf("/nix/store/%s-ncps-bench-%d", hash, i),
+		URL:         "nar/" + nixbase32.EncodeToString(digest[:]) + ".nar",
+		Compression: "none",
+		FileHash:    narHash,
+		FileSize:    uint64(len(narData)),
+		NarHash:     narHash,
+		NarSize:     uint64(len(narData)),
+	}, narData, nil
+}
+
+func writeBenchReport(w io.Writer, format string, report *benchReport) error {
+	if format == verifyFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(report)
+	}
+
+	var err error
+
+	printf := func(format string, args ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	printf("Benchmarked %s for %.1fs with %d clients, %d store paths and a hit ratio of %.2f.\n",
+		report.URL, report.DurationSeconds, report.Concurrency, report.Objects, report.HitRatio)
+	printf("%-14s %9s %7s %10s %12s %9s %9s %9s %9s\n",
+		"operation", "requests", "errors", "req/s", "MiB/s", "p50 ms", "p90 ms", "p99 ms", "max ms")
+
+	for _, op := range report.Operations {
+		printf("%-14s %9d %7d %10.1f %12.2f %9.2f %9.2f %9.2f %9.2f\n",
+			op.Name, op.Requests, op.Errors, op.RequestsPerSecond, op.BytesPerSecond/(1<<20),
+			op.LatencyP50, op.LatencyP90, op.LatencyP99, op.LatencyMax)
+	}
+
+	return err
+}
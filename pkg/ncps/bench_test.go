@@ -0,0 +1,147 @@
+package ncps_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/ncps"
+)
+
+type benchTestReport struct {
+	Objects    int `json:"objects"`
+	Operations []struct {
+		Name     string  `json:"name"`
+		Requests int     `json:"requests"`
+		Errors   int     `json:"errors"`
+		P50      float64 `json:"latencyP50Ms"`
+	} `json:"operations"`
+}
+
+// newBenchTestServer serves what is PUT to it, and answers 404 to anything
+// else. With allowPut false, it rejects the PUTs.
+func newBenchTestServer(t *testing.T, allowPut bool) *httptest.Server {
+	t.Helper()
+
+	var (
+		mu      sync.Mutex
+		objects = make(map[string][]byte)
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case http.MethodPut:
+			if !allowPut {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+
+			objects[strings.TrimPrefix(r.URL.Path, "/upload")] = body
+
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+
+				return
+			}
+
+			_, _ = w.Write(body)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func TestBench(t *testing.T) {
+	t.Parallel()
+
+	t.Run("reports every operation", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newBenchTestServer(t, true)
+
+		app, err := ncps.New()
+		require.NoError(t, err)
+
+		out := filepath.Join(t.TempDir(), "report.json")
+
+		require.NoError(t, app.Run(context.Background(), []string{
+			"ncps", "bench",
+			"--url", ts.URL,
+			"--objects", "5",
+			"--nar-size", "1K",
+			"--nar-size", "4K",
+			"--hit-ratio", "0.5",
+			"--concurrency", "4",
+			"--duration", "200ms",
+			"--format", "json",
+			"--output", out,
+		}))
+
+		data, err := os.ReadFile(out)
+		require.NoError(t, err)
+
+		var report benchTestReport
+		require.NoError(t, json.Unmarshal(data, &report))
+
+		assert.Equal(t, 5, report.Objects)
+
+		ops := make(map[string]int)
+
+		for _, op := range report.Operations {
+			assert.Zero(t, op.Errors, op.Name)
+			assert.Positive(t, op.P50, op.Name)
+
+			ops[op.Name] = op.Requests
+		}
+
+		assert.Equal(t, 5, ops["upload"])
+		assert.Positive(t, ops["narinfo-hit"])
+		assert.Positive(t, ops["narinfo-miss"])
+		// The nar of the last hits may be cut off by the end of the traffic.
+		assert.Positive(t, ops["nar"])
+		assert.LessOrEqual(t, ops["nar"], ops["narinfo-hit"])
+	})
+
+	t.Run("fails when the cache rejects the upload", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newBenchTestServer(t, false)
+
+		app, err := ncps.New()
+		require.NoError(t, err)
+
+		err = app.Run(context.Background(), []string{
+			"ncps", "bench",
+			"--url", ts.URL,
+			"--objects", "2",
+			"--duration", "100ms",
+			"--output", filepath.Join(t.TempDir(), "report.txt"),
+		})
+		require.ErrorIs(t, err, ncps.ErrBenchUpload)
+	})
+}
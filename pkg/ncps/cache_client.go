@@ -0,0 +1,75 @@
+package ncps
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// cacheClient makes the HTTP requests of the commands talking to a running
+// binary cache.
+type cacheClient struct {
+	client  *http.Client
+	baseURL *url.URL
+	token   string
+}
+
+// newCacheClient returns a cacheClient of the binary cache at rawURL sending
+// token, when set, as a Bearer token.
+func newCacheClient(rawURL, token string, timeout time.Duration) (*cacheClient, error) {
+	baseURL, err := url.Parse(strings.TrimSuffix(rawURL, "/") + "/")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing --url: %w", err)
+	}
+
+	return &cacheClient{
+		client:  &http.Client{Timeout: timeout},
+		baseURL: baseURL,
+		token:   token,
+	}, nil
+}
+
+// resolve returns the URL of ref, relative to the cache. A nar URL may carry a
+// query.
+func (c *cacheClient) resolve(ref string) *url.URL {
+	ref = strings.TrimPrefix(ref, "/")
+
+	u, err := url.Parse(ref)
+	if err != nil {
+		u = &url.URL{Path: ref}
+	}
+
+	return c.baseURL.ResolveReference(u)
+}
+
+func (c *cacheClient) do(
+	ctx context.Context,
+	method string,
+	u *url.URL,
+	header http.Header,
+	body io.Reader,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the request: %w", err)
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting %s %s: %w", method, u, err)
+	}
+
+	return resp, nil
+}
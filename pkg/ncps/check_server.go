@@ -0,0 +1,657 @@
+package ncps
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v3"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/nixcacheinfo"
+)
+
+const (
+	// checkServerExitCodeError is the exit code of a check-server run that could
+	// not run its checks. A run with failed checks exits with 1.
+	checkServerExitCodeError = 2
+
+	// checkServerMissingHash is a narinfo hash no store path has, used to check
+	// how a missing narinfo is answered.
+	checkServerMissingHash = "00000000000000000000000000000000"
+
+	// checkServerRangeLength is the length of the range requested by the Range
+	// check.
+	checkServerRangeLength = 100
+
+	checkStatusPass = "pass"
+	checkStatusFail = "fail"
+	checkStatusSkip = "skip"
+)
+
+var (
+	// ErrCheckServerFailed is returned when a conformance check fails.
+	ErrCheckServerFailed = errors.New("conformance checks failed")
+
+	// ErrCheckServerUnreachable is returned when the server cannot be reached.
+	ErrCheckServerUnreachable = errors.New("the server cannot be reached")
+
+	errCheckUnexpectedStatus = errors.New("unexpected HTTP status")
+)
+
+// narMagics are the leading bytes of a nar file for each compression that has
+// any. A brotli stream has none.
+//
+//nolint:gochecknoglobals
+var narMagics = map[nar.CompressionType][]byte{
+	nar.CompressionTypeNone:  []byte("\x0d\x00\x00\x00\x00\x00\x00\x00nix-archive-1"),
+	nar.CompressionTypeXz:    []byte("\xfd7zXZ\x00"),
+	nar.CompressionTypeZstd:  []byte("\x28\xb5\x2f\xfd"),
+	nar.CompressionTypeBzip2: []byte("BZh"),
+	nar.CompressionTypeLzip:  []byte("LZIP"),
+	nar.CompressionTypeLz4:   []byte("\x04\x22\x4d\x18"),
+}
+
+// checkServerReport is the result of a check-server run.
+type checkServerReport struct {
+	URL    string        `json:"url"`
+	Checks []checkResult `json:"checks"`
+
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+}
+
+// checkResult is the outcome of a conformance check. Target is the narinfo
+// hash the check ran against, empty for the checks of the server itself.
+type checkResult struct {
+	Name   string `json:"name"`
+	Target string `json:"target,omitempty"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func (r *checkServerReport) add(name, target, status, detail string) {
+	r.Checks = append(r.Checks, checkResult{Name: name, Target: target, Status: status, Detail: detail})
+
+	switch status {
+	case checkStatusPass:
+		r.Passed++
+	case checkStatusFail:
+		r.Failed++
+	default:
+		r.Skipped++
+	}
+}
+
+func checkServerCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "check-server",
+		Usage: "Run HTTP conformance checks against a running binary cache",
+		Description: `Runs a battery of conformance checks of the Nix binary cache protocol
+against a running ncps, or any binary cache, without modifying anything:
+
+  - nix-cache-info: /nix-cache-info parses and names a store directory
+  - missing-narinfo: a missing narinfo is answered with 404
+  - narinfo, narinfo-head: each narinfo of --narinfo-hash is served to GET
+    and HEAD, is valid and encodes back to itself
+  - nar-compression: the nar of each narinfo starts with the magic bytes of
+    its Compression and matches its FileSize and FileHash
+  - nar-hash: the decompressed nar matches its NarSize and NarHash
+  - nar-range: a Range request of the nar is answered with 206 and the
+    requested bytes
+  - signature: each narinfo is signed by one of --public-key
+
+The narinfo checks run against the narinfos of --narinfo-hash, which should
+name store paths the cache holds. The report is printed as text or, with
+--format=json, as JSON for CI. The command exits with 0 when every check
+passes, 1 when a check fails and 2 when the checks could not run.`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "url",
+				Usage:    "The URL of the binary cache to check, e.g. http://localhost:8501",
+				Required: true,
+			},
+			&cli.StringSliceFlag{
+				Name:  "narinfo-hash",
+				Usage: "The hash of a narinfo the cache holds to run the narinfo checks against (repeatable)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "public-key",
+				Usage: "A public key the narinfos must be signed with; the signature check is skipped without one",
+			},
+			&cli.StringFlag{
+				Name:    "token",
+				Usage:   "The Bearer token sent to a cache requiring one (see --cache-get-token of serve)",
+				Sources: secretSources(cli.EnvVars("CHECK_SERVER_TOKEN")),
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "The timeout of each HTTP request",
+				Value: time.Minute,
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Report format: text or json",
+				Value: verifyFormatText,
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "Write the report to this file instead of stdout",
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := zerolog.Ctx(ctx).With().Str("cmd", "check-server").Logger()
+			ctx = logger.WithContext(ctx)
+
+			report, err := runCheckServerCommand(ctx, cmd)
+			if err != nil {
+				return &exitCodeError{err: err, code: checkServerExitCodeError}
+			}
+
+			if report.Failed > 0 {
+				return fmt.Errorf("%w: %d", ErrCheckServerFailed, report.Failed)
+			}
+
+			return nil
+		},
+	}
+}
+
+func runCheckServerCommand(ctx context.Context, cmd *cli.Command) (*checkServerReport, error) {
+	format := cmd.String("format")
+	if format != verifyFormatText && format != verifyFormatJSON {
+		return nil, fmt.Errorf("%w: %q", ErrVerifyUnknownFormat, format)
+	}
+
+	token, err := secretValue(cmd, "token")
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newCacheClient(cmd.String("url"), token, cmd.Duration("timeout"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]signature.PublicKey, 0, len(cmd.StringSlice("public-key")))
+
+	for _, k := range cmd.StringSlice("public-key") {
+		pk, err := signature.ParsePublicKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the public key %q: %w", k, err)
+		}
+
+		keys = append(keys, pk)
+	}
+
+	c := &serverChecker{
+		cacheClient: client,
+		keys:        keys,
+		report:      &checkServerReport{URL: client.baseURL.String(), Checks: []checkResult{}},
+	}
+
+	if err := c.run(ctx, cmd.StringSlice("narinfo-hash")); err != nil {
+		return nil, err
+	}
+
+	out := io.Writer(os.Stdout)
+
+	if path := cmd.String("output"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("error creating the report file: %w", err)
+		}
+
+		defer f.Close()
+
+		out = f
+	}
+
+	if err := writeCheckServerReport(out, format, c.report); err != nil {
+		return nil, fmt.Errorf("error writing the report: %w", err)
+	}
+
+	return c.report, nil
+}
+
+// serverChecker runs the conformance checks against the binary cache at
+// baseURL and records their outcome in report.
+type serverChecker struct {
+	*cacheClient
+
+	keys   []signature.PublicKey
+	report *checkServerReport
+}
+
+// run runs every check. It returns an error only when the server cannot be
+// reached at all; every other failure is recorded in the report.
+func (c *serverChecker) run(ctx context.Context, hashes []string) error {
+	if err := c.checkNixCacheInfo(ctx); err != nil {
+		return err
+	}
+
+	c.checkMissingNarInfo(ctx)
+
+	for _, hash := range hashes {
+		c.checkNarInfo(ctx, hash)
+	}
+
+	return nil
+}
+
+func (c *serverChecker) checkNixCacheInfo(ctx context.Context) error {
+	const name = "nix-cache-info"
+
+	body, err := c.get(ctx, "nix-cache-info")
+	if err != nil {
+		if !errors.Is(err, errCheckUnexpectedStatus) {
+			return fmt.Errorf("%w: %w", ErrCheckServerUnreachable, err)
+		}
+
+		c.report.add(name, "", checkStatusFail, err.Error())
+
+		return nil
+	}
+
+	nci, err := nixcacheinfo.ParseString(string(body))
+	if err != nil {
+		c.report.add(name, "", checkStatusFail, fmt.Sprintf("invalid nix-cache-info: %v", err))
+
+		return nil
+	}
+
+	if nci.StoreDir == "" {
+		c.report.add(name, "", checkStatusFail, "no StoreDir")
+
+		return nil
+	}
+
+	c.report.add(name, "", checkStatusPass, "StoreDir: "+nci.StoreDir)
+
+	return nil
+}
+
+func (c *serverChecker) checkMissingNarInfo(ctx context.Context) {
+	const name = "missing-narinfo"
+
+	resp, err := c.do(ctx, http.MethodGet, c.resolve(checkServerMissingHash+".narinfo"), nil, nil)
+	if err != nil {
+		c.report.add(name, "", checkStatusFail, err.Error())
+
+		return
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		c.report.add(name, "", checkStatusFail, fmt.Sprintf("expected 404, got %d", resp.StatusCode))
+
+		return
+	}
+
+	c.report.add(name, "", checkStatusPass, "")
+}
+
+// checkNarInfo runs the checks of the narinfo hash and of its nar. The nar
+// checks are skipped when the narinfo cannot be fetched or parsed.
+func (c *serverChecker) checkNarInfo(ctx context.Context, hash string) {
+	body, err := c.get(ctx, hash+".narinfo")
+	if err != nil {
+		c.report.add("narinfo", hash, checkStatusFail, err.Error())
+
+		return
+	}
+
+	ni, err := narinfo.Parse(bytes.NewReader(body))
+	if err != nil {
+		c.report.add("narinfo", hash, checkStatusFail, fmt.Sprintf("invalid narinfo: %v", err))
+
+		return
+	}
+
+	if detail := checkNarInfoFields(ni); detail != "" {
+		c.report.add("narinfo", hash, checkStatusFail, detail)
+	} else {
+		c.report.add("narinfo", hash, checkStatusPass, ni.StorePath)
+	}
+
+	c.checkNarInfoHead(ctx, hash)
+	c.checkSignature(ni, hash)
+
+	narURL := c.resolve(ni.URL)
+
+	prefix, ok := c.checkNar(ctx, ni, narURL, hash)
+	if !ok {
+		c.report.add("nar-range", hash, checkStatusSkip, "the nar could not be fetched")
+
+		return
+	}
+
+	c.checkRange(ctx, narURL, prefix, hash)
+}
+
+// checkNarInfoFields returns why ni is not a valid narinfo, or an empty string
+// when it is.
+func checkNarInfoFields(ni *narinfo.NarInfo) string {
+	switch {
+	case ni.StorePath == "":
+		return "no StorePath"
+	case ni.URL == "":
+		return "no URL"
+	case ni.Compression == "":
+		return "no Compression"
+	case ni.NarHash == nil:
+		return "no NarHash"
+	case ni.NarSize == 0:
+		return "no NarSize"
+	}
+
+	if err := ni.Check(); err != nil {
+		return err.Error()
+	}
+
+	encoded := ni.String()
+
+	again, err := narinfo.Parse(strings.NewReader(encoded))
+	if err != nil {
+		return fmt.Sprintf("the encoded narinfo does not parse: %v", err)
+	}
+
+	if again.String() != encoded {
+		return "the narinfo does not encode back to itself"
+	}
+
+	return ""
+}
+
+func (c *serverChecker) checkNarInfoHead(ctx context.Context, hash string) {
+	const name = "narinfo-head"
+
+	resp, err := c.do(ctx, http.MethodHead, c.resolve(hash+".narinfo"), nil, nil)
+	if err != nil {
+		c.report.add(name, hash, checkStatusFail, err.Error())
+
+		return
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		c.report.add(name, hash, checkStatusFail, fmt.Sprintf("expected 200, got %d", resp.StatusCode))
+
+		return
+	}
+
+	c.report.add(name, hash, checkStatusPass, "")
+}
+
+func (c *serverChecker) checkSignature(ni *narinfo.NarInfo, hash string) {
+	const name = "signature"
+
+	switch {
+	case len(c.keys) == 0:
+		c.report.add(name, hash, checkStatusSkip, "no --public-key")
+	case len(ni.Signatures) == 0:
+		c.report.add(name, hash, checkStatusFail, "the narinfo is not signed")
+	case !signature.VerifyFirst(ni.Fingerprint(), ni.Signatures, c.keys):
+		c.report.add(name, hash, checkStatusFail, "no signature is valid for the public keys")
+	default:
+		c.report.add(name, hash, checkStatusPass, "")
+	}
+}
+
+// checkNar fetches the nar at narURL once to run the nar-compression and
+// nar-hash checks. It returns the first bytes of the nar for the Range check,
+// and false when the nar cannot be fetched.
+func (c *serverChecker) checkNar(
+	ctx context.Context,
+	ni *narinfo.NarInfo,
+	narURL *url.URL,
+	hash string,
+) ([]byte, bool) {
+	resp, err := c.do(ctx, http.MethodGet, narURL, nil, nil)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+
+		err = fmt.Errorf("%w: GET %s: %d", errCheckUnexpectedStatus, narURL, resp.StatusCode)
+	}
+
+	if err != nil {
+		c.report.add("nar-compression", hash, checkStatusFail, err.Error())
+		c.report.add("nar-hash", hash, checkStatusSkip, "the nar could not be fetched")
+
+		return nil, false
+	}
+
+	defer resp.Body.Close()
+
+	tap := &narFileTap{}
+	if ni.FileHash != nil {
+		tap.hasher = ni.FileHash.Algo().Func().New()
+	}
+
+	body := io.TeeReader(resp.Body, tap)
+	compression := nar.CompressionTypeFromString(ni.Compression)
+	narHasher := ni.NarHash.Algo().Func().New()
+
+	var narSize int64
+
+	dr, err := nar.DecompressReader(ctx, body, compression)
+	if err == nil {
+		narSize, err = io.Copy(narHasher, dr)
+		dr.Close()
+	}
+
+	// Read what the decompressor left so the file checks see the whole file.
+	if _, derr := io.Copy(io.Discard, body); derr != nil {
+		c.report.add("nar-compression", hash, checkStatusFail, fmt.Sprintf("error reading the nar: %v", derr))
+		c.report.add("nar-hash", hash, checkStatusSkip, "the nar could not be read")
+
+		return nil, false
+	}
+
+	if detail := checkNarFile(ni, compression, tap); detail != "" {
+		c.report.add("nar-compression", hash, checkStatusFail, detail)
+	} else {
+		c.report.add("nar-compression", hash, checkStatusPass, string(compression))
+	}
+
+	switch {
+	case errors.Is(err, nar.ErrUnsupportedCompressionType):
+		c.report.add("nar-hash", hash, checkStatusSkip, fmt.Sprintf("cannot decompress %s", compression))
+	case err != nil:
+		c.report.add("nar-hash", hash, checkStatusFail, fmt.Sprintf("error decompressing the nar: %v", err))
+	case uint64(narSize) != ni.NarSize: //nolint:gosec // narSize is a byte count, never negative
+		c.report.add("nar-hash", hash, checkStatusFail,
+			fmt.Sprintf("NarSize is %d but the nar is %d bytes", ni.NarSize, narSize))
+	case !bytes.Equal(narHasher.Sum(nil), ni.NarHash.Digest()):
+		c.report.add("nar-hash", hash, checkStatusFail, "the nar does not match NarHash")
+	default:
+		c.report.add("nar-hash", hash, checkStatusPass, "")
+	}
+
+	return tap.head, true
+}
+
+// checkNarFile returns why the nar file read through tap does not match the
+// Compression, FileSize and FileHash of ni, or an empty string when it does.
+func checkNarFile(ni *narinfo.NarInfo, compression nar.CompressionType, tap *narFileTap) string {
+	if magic, ok := narMagics[compression]; ok && !bytes.HasPrefix(tap.head, magic) {
+		if actual := detectNarCompression(tap.head); actual != "" {
+			return fmt.Sprintf("labeled %s but compressed with %s", compression, actual)
+		}
+
+		return fmt.Sprintf("labeled %s but does not start with its magic bytes", compression)
+	}
+
+	if ni.FileSize != 0 && uint64(tap.size) != ni.FileSize { //nolint:gosec // a byte count, never negative
+		return fmt.Sprintf("FileSize is %d but the file is %d bytes", ni.FileSize, tap.size)
+	}
+
+	if tap.hasher != nil && !bytes.Equal(tap.hasher.Sum(nil), ni.FileHash.Digest()) {
+		return "the file does not match FileHash"
+	}
+
+	return ""
+}
+
+// detectNarCompression returns the compression whose magic bytes head starts
+// with, or an empty string when none matches.
+func detectNarCompression(head []byte) nar.CompressionType {
+	for compression, magic := range narMagics {
+		if bytes.HasPrefix(head, magic) {
+			return compression
+		}
+	}
+
+	return ""
+}
+
+func (c *serverChecker) checkRange(ctx context.Context, narURL *url.URL, prefix []byte, hash string) {
+	const name = "nar-range"
+
+	if len(prefix) < 2 {
+		c.report.add(name, hash, checkStatusSkip, "the nar is too small")
+
+		return
+	}
+
+	// The last byte is left out so a server answering with the whole file is
+	// told apart.
+	want := prefix[:len(prefix)-1]
+
+	resp, err := c.do(ctx, http.MethodGet, narURL, http.Header{
+		"Range": []string{fmt.Sprintf("bytes=0-%d", len(want)-1)},
+	}, nil)
+	if err != nil {
+		c.report.add(name, hash, checkStatusFail, err.Error())
+
+		return
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		c.report.add(name, hash, checkStatusFail, fmt.Sprintf("expected 206, got %d", resp.StatusCode))
+
+		return
+	}
+
+	if resp.Header.Get("Content-Range") == "" {
+		c.report.add(name, hash, checkStatusFail, "no Content-Range")
+
+		return
+	}
+
+	got, err := io.ReadAll(io.LimitReader(resp.Body, int64(len(want))+1))
+	if err != nil {
+		c.report.add(name, hash, checkStatusFail, fmt.Sprintf("error reading the range: %v", err))
+
+		return
+	}
+
+	if !bytes.Equal(got, want) {
+		c.report.add(name, hash, checkStatusFail, "the range does not match the bytes of the nar")
+
+		return
+	}
+
+	c.report.add(name, hash, checkStatusPass, resp.Header.Get("Content-Range"))
+}
+
+// get returns the body of the resource ref of the cache, or an error wrapping
+// errCheckUnexpectedStatus when it is not answered with 200.
+func (c *serverChecker) get(ctx context.Context, ref string) ([]byte, error) {
+	u := c.resolve(ref)
+
+	resp, err := c.do(ctx, http.MethodGet, u, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: GET %s: %d", errCheckUnexpectedStatus, u, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", u, err)
+	}
+
+	return body, nil
+}
+
+// narFileTap is written the bytes of a nar file as they are read: it counts
+// them, keeps the first ones and hashes them with hasher, when set.
+type narFileTap struct {
+	size   int64
+	head   []byte
+	hasher hash.Hash
+}
+
+func (t *narFileTap) Write(p []byte) (int, error) {
+	t.size += int64(len(p))
+
+	if missing := checkServerRangeLength - len(t.head); missing > 0 {
+		t.head = append(t.head, p[:min(missing, len(p))]...)
+	}
+
+	if t.hasher != nil {
+		// Writing to a hash never fails.
+		_, _ = t.hasher.Write(p)
+	}
+
+	return len(p), nil
+}
+
+func writeCheckServerReport(w io.Writer, format string, report *checkServerReport) error {
+	if format == verifyFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(report)
+	}
+
+	var err error
+
+	printf := func(format string, args ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	printf("Checked %s\n", report.URL)
+
+	for _, r := range report.Checks {
+		line := strings.ToUpper(r.Status) + " " + r.Name
+
+		if r.Target != "" {
+			line += " " + r.Target
+		}
+
+		if r.Detail != "" {
+			line += ": " + r.Detail
+		}
+
+		printf("%s\n", line)
+	}
+
+	printf("%d passed, %d failed, %d skipped.\n", report.Passed, report.Failed, report.Skipped)
+
+	return err
+}
@@ -0,0 +1,202 @@
+package ncps_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/ncps"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+type checkServerTestReport struct {
+	Checks []struct {
+		Name   string `json:"name"`
+		Target string `json:"target"`
+		Status string `json:"status"`
+		Detail string `json:"detail"`
+	} `json:"checks"`
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+}
+
+func (r checkServerTestReport) status(name string) string {
+	for _, c := range r.Checks {
+		if c.Name == name {
+			return c.Status
+		}
+	}
+
+	return ""
+}
+
+// newConformanceTestServer serves a signed narinfo and its uncompressed nar.
+// mutate rewrites the narinfo text and ignoreRange serves the nar in full to
+// Range requests.
+func newConformanceTestServer(
+	t *testing.T,
+	mutate func(string) string,
+	ignoreRange bool,
+) (*httptest.Server, testdata.Entry, string) {
+	t.Helper()
+
+	narData := append(
+		[]byte("\x0d\x00\x00\x00\x00\x00\x00\x00nix-archive-1\x00\x00\x00"),
+		bytes.Repeat([]byte("x"), 200)...,
+	)
+
+	entry, err := testdata.GenerateEntry(t, narData)
+	require.NoError(t, err)
+
+	sk, pk, err := signature.GenerateKeypair("check-server-test-1", rand.Reader)
+	require.NoError(t, err)
+
+	ni, err := narinfo.Parse(strings.NewReader(entry.NarInfoText))
+	require.NoError(t, err)
+
+	sig, err := sk.Sign(rand.Reader, ni.Fingerprint())
+	require.NoError(t, err)
+
+	narInfoText := entry.NarInfoText + "\nSig: " + sig.String() + "\n"
+	if mutate != nil {
+		narInfoText = mutate(narInfoText)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /nix-cache-info", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("StoreDir: /nix/store\nWantMassQuery: 1\nPriority: 40\n"))
+	})
+	mux.HandleFunc("GET /{hash}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("hash") != entry.NarInfoHash+".narinfo" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(narInfoText))
+	})
+	mux.HandleFunc("GET /nar/{file}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("file") != entry.NarHash+".nar" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		if ignoreRange {
+			_, _ = w.Write(narData)
+
+			return
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(narData))
+	})
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	return ts, entry, pk.String()
+}
+
+func runCheckServer(t *testing.T, args ...string) (checkServerTestReport, error) {
+	t.Helper()
+
+	app, err := ncps.New()
+	require.NoError(t, err)
+
+	out := filepath.Join(t.TempDir(), "report.json")
+
+	runErr := app.Run(context.Background(), append([]string{
+		"ncps", "check-server", "--format", "json", "--output", out,
+	}, args...))
+
+	var report checkServerTestReport
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &report))
+
+	return report, runErr
+}
+
+func TestCheckServer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("a conforming cache passes every check", func(t *testing.T) {
+		t.Parallel()
+
+		ts, entry, pk := newConformanceTestServer(t, nil, false)
+
+		report, err := runCheckServer(t, "--url", ts.URL, "--narinfo-hash", entry.NarInfoHash, "--public-key", pk)
+		require.NoError(t, err)
+
+		assert.Equal(t, 8, report.Passed, "%+v", report.Checks)
+		assert.Zero(t, report.Failed)
+		assert.Zero(t, report.Skipped)
+	})
+
+	t.Run("the signature check is skipped without a public key", func(t *testing.T) {
+		t.Parallel()
+
+		ts, entry, _ := newConformanceTestServer(t, nil, false)
+
+		report, err := runCheckServer(t, "--url", ts.URL, "--narinfo-hash", entry.NarInfoHash)
+		require.NoError(t, err)
+
+		assert.Equal(t, "skip", report.status("signature"))
+	})
+
+	t.Run("a mislabeled compression fails", func(t *testing.T) {
+		t.Parallel()
+
+		ts, entry, pk := newConformanceTestServer(t, func(s string) string {
+			return strings.Replace(s, "Compression: none", "Compression: xz", 1)
+		}, false)
+
+		report, err := runCheckServer(t, "--url", ts.URL, "--narinfo-hash", entry.NarInfoHash, "--public-key", pk)
+		require.ErrorIs(t, err, ncps.ErrCheckServerFailed)
+
+		assert.Equal(t, "fail", report.status("nar-compression"))
+		assert.Equal(t, "fail", report.status("nar-hash"))
+		assert.Equal(t, "pass", report.status("signature"))
+	})
+
+	t.Run("a cache ignoring Range fails", func(t *testing.T) {
+		t.Parallel()
+
+		ts, entry, pk := newConformanceTestServer(t, nil, true)
+
+		report, err := runCheckServer(t, "--url", ts.URL, "--narinfo-hash", entry.NarInfoHash, "--public-key", pk)
+		require.ErrorIs(t, err, ncps.ErrCheckServerFailed)
+
+		assert.Equal(t, "fail", report.status("nar-range"))
+		assert.Equal(t, 1, report.Failed)
+	})
+
+	t.Run("a narinfo signed by another key fails", func(t *testing.T) {
+		t.Parallel()
+
+		ts, entry, _ := newConformanceTestServer(t, nil, false)
+		_, other, err := signature.GenerateKeypair("other-1", rand.Reader)
+		require.NoError(t, err)
+
+		report, err := runCheckServer(t,
+			"--url", ts.URL, "--narinfo-hash", entry.NarInfoHash, "--public-key", other.String())
+		require.ErrorIs(t, err, ncps.ErrCheckServerFailed)
+
+		assert.Equal(t, "fail", report.status("signature"))
+	})
+}
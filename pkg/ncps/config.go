@@ -0,0 +1,527 @@
+package ncps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v3"
+	"gopkg.in/yaml.v3"
+
+	"github.com/kalbasit/ncps/pkg/configfile"
+)
+
+var (
+	// ErrUnknownConfigKeys is returned when the configuration file contains
+	// keys that no flag reads.
+	ErrUnknownConfigKeys = errors.New("unknown configuration keys")
+
+	// ErrInvalidConfigValue is returned when a configuration value cannot be
+	// parsed as the type of its flag or is rejected by its validator.
+	ErrInvalidConfigValue = errors.New("invalid configuration value")
+
+	// ErrConfigFileNotFound is returned by `ncps config validate` when the
+	// configuration file does not exist.
+	ErrConfigFileNotFound = errors.New("configuration file not found")
+
+	// ErrUnknownCommand is returned by `ncps config validate` when --command
+	// does not name a sub-command.
+	ErrUnknownCommand = errors.New("unknown command")
+)
+
+const redactedValue = "<redacted>"
+
+// configSchema records the configuration file key of every flag, as declared
+// through flagSources.
+type configSchema struct {
+	// keyBySource maps the first source of the chain returned by flagSources
+	// to its configuration file key.
+	keyBySource map[cli.ValueSource]string
+	keys        map[string]struct{}
+}
+
+func newConfigSchema() *configSchema {
+	return &configSchema{
+		keyBySource: make(map[cli.ValueSource]string),
+		keys:        make(map[string]struct{}),
+	}
+}
+
+func (cs *configSchema) record(configFileKey string, sources cli.ValueSourceChain) {
+	cs.keys[configFileKey] = struct{}{}
+
+	if len(sources.Chain) > 0 {
+		cs.keyBySource[sources.Chain[0]] = configFileKey
+	}
+}
+
+// keyOf returns the configuration file key of f, if it has one.
+func (cs *configSchema) keyOf(f cli.Flag) (string, bool) {
+	sources := sourcesOf(f)
+	if len(sources.Chain) == 0 {
+		return "", false
+	}
+
+	key, ok := cs.keyBySource[sources.Chain[0]]
+
+	return key, ok
+}
+
+// sourcesOf returns the value sources of f.
+func sourcesOf(f cli.Flag) cli.ValueSourceChain {
+	switch f := f.(type) {
+	case *cli.BoolFlag:
+		return f.Sources
+	case *cli.IntFlag:
+		return f.Sources
+	case *cli.Int64Flag:
+		return f.Sources
+	case *cli.Uint32Flag:
+		return f.Sources
+	case *cli.FloatFlag:
+		return f.Sources
+	case *cli.DurationFlag:
+		return f.Sources
+	case *cli.StringSliceFlag:
+		return f.Sources
+	case *cli.StringFlag:
+		return f.Sources
+	default:
+		return cli.ValueSourceChain{}
+	}
+}
+
+// unknownKeys returns the keys of tree no flag reads.
+func (cs *configSchema) unknownKeys(tree map[string]any) []string {
+	var unknown []string
+
+	for _, key := range configfile.Keys(tree) {
+		if _, ok := cs.keys[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+
+	return unknown
+}
+
+// loadConfigFile loads the configuration file at path and rejects the keys no
+// flag reads. It returns a nil tree when path does not exist.
+func (cs *configSchema) loadConfigFile(path string) (map[string]any, error) {
+	if path == "" {
+		return nil, nil //nolint:nilnil // no configuration file is not an error
+	}
+
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil //nolint:nilnil // no configuration file is not an error
+	}
+
+	tree, err := configfile.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if unknown := cs.unknownKeys(tree); len(unknown) > 0 {
+		return nil, fmt.Errorf("%w in %s: %s", ErrUnknownConfigKeys, path, strings.Join(unknown, ", "))
+	}
+
+	return tree, nil
+}
+
+func configCommand(schema *configSchema) *cli.Command {
+	return &cli.Command{
+		Name:  "config",
+		Usage: "Inspect the configuration",
+		Commands: []*cli.Command{
+			{
+				Name:  "validate",
+				Usage: "Validate the configuration file and print the effective configuration",
+				Description: "Rejects unknown keys, unset environment variables and values that do not " +
+					"parse, then prints the configuration resolved from the configuration file, the " +
+					"environment and the defaults as YAML. Secrets are redacted.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "command",
+						Usage: "The sub-command whose configuration to resolve",
+						Value: "serve",
+					},
+				},
+				Action: func(_ context.Context, cmd *cli.Command) error {
+					return validateConfig(cmd.Root(), schema, cmd.String("config"), cmd.String("command"),
+						cmd.Root().Writer)
+				},
+			},
+		},
+	}
+}
+
+// validateConfig validates the configuration file at path against the flags of
+// root and of its sub-command named command, and writes the effective
+// configuration to w.
+func validateConfig(root *cli.Command, schema *configSchema, path, command string, w io.Writer) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("%w: %s", ErrConfigFileNotFound, path)
+	}
+
+	tree, err := schema.loadConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	sub := root.Command(command)
+	if sub == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownCommand, command)
+	}
+
+	var (
+		effective = make(map[string]effectiveValue)
+		errs      []error
+	)
+
+	for _, f := range slices.Concat(root.Flags, sub.Flags) {
+		key, ok := schema.keyOf(f)
+		if !ok {
+			continue
+		}
+
+		if _, seen := effective[key]; seen {
+			continue
+		}
+
+		ev, err := resolveFlag(f, key, tree)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		effective[key] = ev
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(effectiveConfigNode(effective)); err != nil {
+		return fmt.Errorf("error encoding the effective configuration: %w", err)
+	}
+
+	return enc.Close()
+}
+
+// effectiveValue is the resolved value of a flag and where it came from.
+type effectiveValue struct {
+	value  any
+	source string
+}
+
+// resolveFlag resolves the value of f the way the flag's sources do: the
+// configuration file, then the environment, then the default.
+func resolveFlag(f cli.Flag, key string, tree map[string]any) (effectiveValue, error) {
+	var (
+		raw    string
+		source string
+	)
+
+	if v, ok := lookupConfigValue(tree, key); ok {
+		raw, source = v, "config file"
+	} else {
+		for _, src := range sourcesOf(f).Chain {
+			es, ok := src.(cli.EnvValueSource)
+			if !ok || !es.IsFromEnv() {
+				continue
+			}
+
+			if v, ok := src.Lookup(); ok {
+				raw, source = v, "env "+es.Key()
+
+				break
+			}
+		}
+	}
+
+	var (
+		value any
+		err   error
+	)
+
+	if source == "" {
+		value = flagDefault(f)
+	} else if value, err = parseFlagValue(f, raw); err != nil {
+		return effectiveValue{}, fmt.Errorf("%w: %s (from %s): %w", ErrInvalidConfigValue, key, source, err)
+	}
+
+	return effectiveValue{value: redactFlagValue(f, value), source: source}, nil
+}
+
+// lookupConfigValue returns the value at the dotted key of tree, formatted the
+// way the configuration file sources hand it to the flags.
+func lookupConfigValue(tree map[string]any, key string) (string, bool) {
+	var node any = tree
+
+	for section := range strings.SplitSeq(key, ".") {
+		m, ok := node.(map[string]any)
+		if !ok {
+			return "", false
+		}
+
+		if node, ok = m[section]; !ok {
+			return "", false
+		}
+	}
+
+	if list, ok := node.([]any); ok {
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			items = append(items, fmt.Sprint(item))
+		}
+
+		return strings.Join(items, ","), true
+	}
+
+	return fmt.Sprint(node), true
+}
+
+// parseFlagValue parses raw as the type of f and runs its validator.
+func parseFlagValue(f cli.Flag, raw string) (any, error) {
+	switch f := f.(type) {
+	case *cli.BoolFlag:
+		return strconv.ParseBool(raw)
+	case *cli.IntFlag:
+		v, err := strconv.ParseInt(raw, 0, 64)
+		if err == nil && f.Validator != nil {
+			err = f.Validator(int(v))
+		}
+
+		return v, err
+	case *cli.Int64Flag:
+		v, err := strconv.ParseInt(raw, 0, 64)
+		if err == nil && f.Validator != nil {
+			err = f.Validator(v)
+		}
+
+		return v, err
+	case *cli.Uint32Flag:
+		v, err := strconv.ParseUint(raw, 0, 32)
+		if err == nil && f.Validator != nil {
+			err = f.Validator(uint32(v))
+		}
+
+		return v, err
+	case *cli.FloatFlag:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err == nil && f.Validator != nil {
+			err = f.Validator(v)
+		}
+
+		return v, err
+	case *cli.DurationFlag:
+		v, err := time.ParseDuration(raw)
+		if err == nil && f.Validator != nil {
+			err = f.Validator(v)
+		}
+
+		return v.String(), err
+	case *cli.StringSliceFlag:
+		var v []string
+		if raw != "" {
+			v = strings.Split(raw, ",")
+		}
+
+		if f.Validator != nil {
+			return v, f.Validator(v)
+		}
+
+		return v, nil
+	case *cli.StringFlag:
+		if f.Validator != nil {
+			return raw, f.Validator(raw)
+		}
+
+		return raw, nil
+	default:
+		return raw, nil
+	}
+}
+
+// flagDefault returns the default value of f.
+func flagDefault(f cli.Flag) any {
+	switch f := f.(type) {
+	case *cli.BoolFlag:
+		return f.Value
+	case *cli.IntFlag:
+		return f.Value
+	case *cli.Int64Flag:
+		return f.Value
+	case *cli.Uint32Flag:
+		return f.Value
+	case *cli.FloatFlag:
+		return f.Value
+	case *cli.DurationFlag:
+		return f.Value.String()
+	case *cli.StringSliceFlag:
+		return f.Value
+	case *cli.StringFlag:
+		return f.Value
+	default:
+		if gf, ok := f.(interface{ GetValue() string }); ok {
+			return gf.GetValue()
+		}
+
+		return nil
+	}
+}
+
+// headerFlags are the flags of "Name: value" headers, whose values may carry
+// credentials. The headers of the flags set to true are prefixed with "host=".
+//
+//nolint:gochecknoglobals
+var headerFlags = map[string]bool{
+	"cache-upstream-header":           false,
+	"cache-upstream-host-header":      true,
+	"server-access-log-export-header": false,
+}
+
+// redactFlagValue hides the value of the flags carrying secrets. Database URLs
+// keep everything but their password, and headers their name.
+func redactFlagValue(f cli.Flag, value any) any {
+	if headers, ok := value.([]string); ok {
+		if hostPrefixed, ok := headerFlags[f.Names()[0]]; ok {
+			return redactHeaders(headers, hostPrefixed)
+		}
+
+		return value
+	}
+
+	s, ok := value.(string)
+	if !ok || s == "" || strings.HasPrefix(s, secretFilePrefix) {
+		return value
+	}
+
+	for _, name := range f.Names() {
+		if strings.HasSuffix(name, "database-url") {
+			return redactURL(s)
+		}
+
+		for _, word := range []string{"password", "secret", "token", "auth-key"} {
+			if strings.Contains(name, word) {
+				return redactedValue
+			}
+		}
+	}
+
+	return value
+}
+
+// redactHeaders hides the values of the "Name: value" headers, prefixed with
+// "host=" if hostPrefixed. The file:// values are kept.
+func redactHeaders(headers []string, hostPrefixed bool) []string {
+	redacted := make([]string, 0, len(headers))
+
+	for _, h := range headers {
+		prefix := ""
+		if hostPrefixed {
+			if host, rest, ok := strings.Cut(h, "="); ok {
+				prefix, h = host+"=", rest
+			}
+		}
+
+		name, value, ok := strings.Cut(h, ":")
+		if strings.HasPrefix(h, secretFilePrefix) || strings.HasPrefix(strings.TrimSpace(value), secretFilePrefix) {
+			redacted = append(redacted, prefix+h)
+
+			continue
+		}
+
+		if !ok {
+			redacted = append(redacted, redactedValue)
+
+			continue
+		}
+
+		redacted = append(redacted, prefix+name+": "+redactedValue)
+	}
+
+	return redacted
+}
+
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return redactedValue
+	}
+
+	if q := u.Query(); len(q) > 0 {
+		for k := range q {
+			if strings.Contains(strings.ToLower(k), "password") {
+				q.Set(k, "xxxxx")
+			}
+		}
+
+		u.RawQuery = q.Encode()
+	}
+
+	return u.Redacted()
+}
+
+// effectiveConfigNode builds the YAML document of the effective configuration,
+// annotating every value that did not come from the defaults with its source.
+func effectiveConfigNode(effective map[string]effectiveValue) *yaml.Node {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+
+	keys := make([]string, 0, len(effective))
+	for key := range effective {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		sections := strings.Split(key, ".")
+		parent := root
+
+		for _, section := range sections[:len(sections)-1] {
+			parent = mappingChild(parent, section)
+		}
+
+		var value yaml.Node
+		if err := value.Encode(effective[key].value); err != nil {
+			value = yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(effective[key].value)}
+		}
+
+		if source := effective[key].source; source != "" {
+			value.LineComment = source
+		}
+
+		parent.Content = append(parent.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: sections[len(sections)-1]},
+			&value,
+		)
+	}
+
+	return root
+}
+
+// mappingChild returns the mapping stored under key in parent, adding it if
+// needed.
+func mappingChild(parent *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(parent.Content); i += 2 {
+		if parent.Content[i].Value == key && parent.Content[i+1].Kind == yaml.MappingNode {
+			return parent.Content[i+1]
+		}
+	}
+
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, child)
+
+	return child
+}
@@ -0,0 +1,151 @@
+package ncps
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v3"
+
+	locklocal "github.com/kalbasit/ncps/pkg/lock/local"
+)
+
+func exportStaticCommand(flagSources flagSourcesFn, registerShutdown registerShutdownFn) *cli.Command {
+	return &cli.Command{
+		Name:  "export-static",
+		Usage: "Export the cache as a static binary cache directory",
+		Description: `Writes every cached narinfo and its NAR to a plain binary cache directory,
+as produced by nix copy --to file://DIR, so the cache contents can be published
+by any static file host or CDN:
+
+  DIR/nix-cache-info
+  DIR/<hash>.narinfo
+  DIR/nar/<hash>.nar[.xz|.zst|...]
+
+The export is deterministic: the same cache contents always produce the same
+files. The narinfos keep their signatures, so the export is trusted with the
+public key of the cache. Chunked NARs are exported uncompressed, as ncps serves
+them. NARs already in DIR are not written again, so exporting into the same
+directory again only adds what changed. Narinfos whose NAR is not stored are
+skipped; nothing is fetched from the upstreams.`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:     "out",
+				Usage:    "The directory to export the cache to",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:    flagNameCacheTempPath,
+				Usage:   "The path to the temporary directory that is used by the cache to reconstruct NAR files",
+				Sources: flagSources("cache.temp-path", "CACHE_TEMP_PATH"),
+				Value:   os.TempDir(),
+			},
+
+			// Storage Flags
+			&cli.StringFlag{
+				Name:    flagNameStorageLocal,
+				Usage:   flagUsageStorageLocal,
+				Sources: flagSources("cache.storage.local", "CACHE_STORAGE_LOCAL"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameS3Bucket,
+				Usage:   flagUsageS3Bucket,
+				Sources: flagSources("cache.storage.s3.bucket", "CACHE_STORAGE_S3_BUCKET"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameS3Endpoint,
+				Usage:   flagUsageS3Endpoint,
+				Sources: flagSources("cache.storage.s3.endpoint", "CACHE_STORAGE_S3_ENDPOINT"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameS3Region,
+				Usage:   flagUsageS3Region,
+				Sources: flagSources("cache.storage.s3.region", "CACHE_STORAGE_S3_REGION"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameS3AccessKeyID,
+				Usage:   flagUsageS3AccessKeyID,
+				Sources: secretSources(flagSources("cache.storage.s3.access-key-id", "CACHE_STORAGE_S3_ACCESS_KEY_ID")),
+			},
+			&cli.StringFlag{
+				Name:    flagNameS3SecretKey,
+				Usage:   flagUsageS3SecretKey,
+				Sources: secretSources(flagSources("cache.storage.s3.secret-access-key", "CACHE_STORAGE_S3_SECRET_ACCESS_KEY")),
+			},
+			&cli.BoolFlag{
+				Name:    flagNameS3ForcePathStyle,
+				Usage:   flagUsageS3ForcePathStyle,
+				Sources: flagSources("cache.storage.s3.force-path-style", "CACHE_STORAGE_S3_FORCE_PATH_STYLE"),
+			},
+			&cli.IntFlag{
+				Name:    flagNameStorageInlineThreshold,
+				Usage:   flagUsageStorageInlineThreshold,
+				Sources: flagSources("cache.storage.inline-threshold", "CACHE_STORAGE_INLINE_THRESHOLD"),
+			},
+
+			// Database Flags
+			&cli.StringFlag{
+				Name:     flagNameDBURL,
+				Usage:    flagUsageDBURL,
+				Sources:  secretSources(flagSources("cache.database-url", "CACHE_DATABASE_URL")),
+				Required: true,
+			},
+			&cli.IntFlag{
+				Name:    flagNameDBMaxOpenConns,
+				Usage:   flagUsageDBMaxOpenConns,
+				Sources: flagSources("cache.database.pool.max-open-conns", "CACHE_DATABASE_POOL_MAX_OPEN_CONNS"),
+			},
+			&cli.IntFlag{
+				Name:    flagNameDBMaxIdleConns,
+				Usage:   flagUsageDBMaxIdleConns,
+				Sources: flagSources("cache.database.pool.max-idle-conns", "CACHE_DATABASE_POOL_MAX_IDLE_CONNS"),
+			},
+		}, storeFlags(flagSources)...),
+		Action: exportStaticAction(registerShutdown),
+	}
+}
+
+func exportStaticAction(registerShutdown registerShutdownFn) cli.ActionFunc {
+	return func(ctx context.Context, cmd *cli.Command) error {
+		logger := zerolog.Ctx(ctx).With().Str("cmd", "export-static").Logger()
+		ctx = logger.WithContext(ctx)
+
+		out := cmd.String("out")
+
+		dbClient, err := createDatabaseClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error creating database client: %w", err)
+		}
+
+		registerShutdown("database client", func(_ context.Context) error { return dbClient.Close() })
+
+		// The export only reads the cache, so it needs no distributed lock.
+		c, err := createCache(ctx, cmd, dbClient, locklocal.NewLocker(), locklocal.NewRWLocker(), nil)
+		if err != nil {
+			return fmt.Errorf("error creating cache: %w", err)
+		}
+		defer c.Close()
+
+		// Don't chunk the NARs read by the export.
+		c.SetCDCLazyChunking(false, 0)
+
+		startTime := time.Now()
+
+		stats, err := c.ExportStatic(ctx, out)
+		if err != nil {
+			return fmt.Errorf("error exporting the cache to %s: %w", out, err)
+		}
+
+		logger.Info().
+			Str("out", out).
+			Int("narinfos", stats.NarInfos).
+			Int("nars_written", stats.Nars).
+			Int("skipped", stats.Skipped).
+			Dur("duration", time.Since(startTime)).
+			Msg("exported the cache as a static binary cache")
+
+		return nil
+	}
+}
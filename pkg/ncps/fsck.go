@@ -30,6 +30,7 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
 
 	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/ent/predicate"
 	"github.com/kalbasit/ncps/pkg/config"
 	"github.com/kalbasit/ncps/pkg/database"
 	"github.com/kalbasit/ncps/pkg/nar"
@@ -227,7 +228,7 @@ Detects:
   - [CDC] Chunk files in storage that have no corresponding database record
 
 Use --repair to automatically fix detected issues, or --dry-run to preview what would be fixed.`,
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.BoolFlag{
 				Name:  "repair",
 				Usage: "Automatically fix detected issues (delete orphaned records and files)",
@@ -284,24 +285,29 @@ Use --repair to automatically fix detected issues, or --dry-run to preview what
 			&cli.StringFlag{
 				Name:    flagNameS3AccessKeyID,
 				Usage:   flagUsageS3AccessKeyID,
-				Sources: flagSources("cache.storage.s3.access-key-id", "CACHE_STORAGE_S3_ACCESS_KEY_ID"),
+				Sources: secretSources(flagSources("cache.storage.s3.access-key-id", "CACHE_STORAGE_S3_ACCESS_KEY_ID")),
 			},
 			&cli.StringFlag{
 				Name:    flagNameS3SecretKey,
 				Usage:   flagUsageS3SecretKey,
-				Sources: flagSources("cache.storage.s3.secret-access-key", "CACHE_STORAGE_S3_SECRET_ACCESS_KEY"),
+				Sources: secretSources(flagSources("cache.storage.s3.secret-access-key", "CACHE_STORAGE_S3_SECRET_ACCESS_KEY")),
 			},
 			&cli.BoolFlag{
 				Name:    flagNameS3ForcePathStyle,
 				Usage:   flagUsageS3ForcePathStyle,
 				Sources: flagSources("cache.storage.s3.force-path-style", "CACHE_STORAGE_S3_FORCE_PATH_STYLE"),
 			},
+			&cli.IntFlag{
+				Name:    flagNameStorageInlineThreshold,
+				Usage:   flagUsageStorageInlineThreshold,
+				Sources: flagSources("cache.storage.inline-threshold", "CACHE_STORAGE_INLINE_THRESHOLD"),
+			},
 
 			// Database Flags
 			&cli.StringFlag{
 				Name:     flagNameDBURL,
 				Usage:    flagUsageDBURL,
-				Sources:  flagSources("cache.database-url", "CACHE_DATABASE_URL"),
+				Sources:  secretSources(flagSources("cache.database-url", "CACHE_DATABASE_URL")),
 				Required: true,
 			},
 			&cli.IntFlag{
@@ -329,7 +335,7 @@ Use --repair to automatically fix detected issues, or --dry-run to preview what
 			&cli.StringFlag{
 				Name:    flagNameRedisPassword,
 				Usage:   flagUsageRedisPassword,
-				Sources: flagSources("cache.redis.password", "CACHE_REDIS_PASSWORD"),
+				Sources: secretSources(flagSources("cache.redis.password", "CACHE_REDIS_PASSWORD")),
 			},
 			&cli.IntFlag{
 				Name:    flagNameRedisDB,
@@ -347,6 +353,11 @@ Use --repair to automatically fix detected issues, or --dry-run to preview what
 				Sources: flagSources("cache.lock.backend", "CACHE_LOCK_BACKEND"),
 				Value:   lockBackendLocal,
 			},
+			&cli.StringFlag{
+				Name:    flagNameLockFileDir,
+				Usage:   flagUsageLockFileDir,
+				Sources: flagSources("cache.lock.file.dir", "CACHE_LOCK_FILE_DIR"),
+			},
 			&cli.StringFlag{
 				Name:    flagNameLockRedisKeyPrefix,
 				Usage:   "Prefix for all Redis lock keys",
@@ -400,7 +411,7 @@ Use --repair to automatically fix detected issues, or --dry-run to preview what
 				Sources: flagSources("cache.redis.pool-size", "CACHE_REDIS_POOL_SIZE"),
 				Value:   10,
 			},
-		},
+		}, storeFlags(flagSources)...),
 		Action: func(ctx context.Context, cmd *cli.Command) error {
 			logger := zerolog.Ctx(ctx).With().Str("cmd", "fsck").Logger()
 			ctx = logger.WithContext(ctx)
@@ -475,6 +486,11 @@ Use --repair to automatically fix detected issues, or --dry-run to preview what
 				return err
 			}
 
+			narStore, err = wrapInlineNarStore(ctx, cmd, dbClient, narStore)
+			if err != nil {
+				return err
+			}
+
 			// 5. Detect CDC mode
 			cdcMode := detectFsckCDCMode(ctx, dbClient, logger).enabled()
 
@@ -814,7 +830,7 @@ func collectFsckSuspects(
 				Where(
 					entnarfile.HashEQ(narURL.Hash),
 					entnarfile.CompressionEQ(narURL.Compression.String()),
-					entnarfile.QueryEQ(narURL.Query.Encode()),
+					entnarfile.QueryEQ(narURL.CanonicalQuery()),
 				).
 				Exist(ctx)
 			if dbErr != nil {
@@ -1163,7 +1179,7 @@ func reVerifyFsckSuspects(
 			Where(
 				entnarfile.HashEQ(narURL.Hash),
 				entnarfile.CompressionEQ(narURL.Compression.String()),
-				entnarfile.QueryEQ(narURL.Query.Encode()),
+				entnarfile.QueryEQ(narURL.CanonicalQuery()),
 			).
 			Exist(ctx)
 		if err != nil {
@@ -1452,7 +1468,7 @@ func relinkNarInfoToBackingNarFile(ctx context.Context, dbClient *database.Clien
 	nf, err := dbClient.Ent().NarFile.Query().
 		Where(
 			entnarfile.HashEQ(u.Hash),
-			entnarfile.QueryEQ(u.Query.Encode()),
+			entnarfile.QueryEQ(u.CanonicalQuery()),
 			entnarfile.CompressionEQ(u.Compression.String()),
 		).
 		First(ctx)
@@ -1462,7 +1478,7 @@ func relinkNarInfoToBackingNarFile(ctx context.Context, dbClient *database.Clien
 		}
 
 		nf, err = dbClient.Ent().NarFile.Query().
-			Where(entnarfile.HashEQ(u.Hash), entnarfile.QueryEQ(u.Query.Encode())).
+			Where(entnarfile.HashEQ(u.Hash), entnarfile.QueryEQ(u.CanonicalQuery())).
 			First(ctx)
 		if err != nil {
 			if database.IsNotFoundError(err) {
@@ -1601,13 +1617,11 @@ func repairFsckIssues(
 			continue
 		}
 
-		if _, err := dbClient.Ent().NarFile.Delete().
-			Where(
-				entnarfile.HashEQ(nf.Hash),
-				entnarfile.CompressionEQ(nf.Compression),
-				entnarfile.QueryEQ(nf.Query),
-			).
-			Exec(ctx); err != nil {
+		if err := deleteNarFileRows(ctx, dbClient,
+			entnarfile.HashEQ(nf.Hash),
+			entnarfile.CompressionEQ(nf.Compression),
+			entnarfile.QueryEQ(nf.Query),
+		); err != nil {
 			logger.Error().Err(err).Int("nar_file_id", nf.ID).Msg("failed to delete orphaned nar_file")
 		} else {
 			logger.Info().Int("nar_file_id", nf.ID).Str("hash", nf.Hash).Msg("deleted orphaned nar_file from DB")
@@ -1644,13 +1658,11 @@ func repairFsckIssues(
 			continue
 		}
 
-		if _, err := dbClient.Ent().NarFile.Delete().
-			Where(
-				entnarfile.HashEQ(nf.Hash),
-				entnarfile.CompressionEQ(nf.Compression),
-				entnarfile.QueryEQ(nf.Query),
-			).
-			Exec(ctx); err != nil {
+		if err := deleteNarFileRows(ctx, dbClient,
+			entnarfile.HashEQ(nf.Hash),
+			entnarfile.CompressionEQ(nf.Compression),
+			entnarfile.QueryEQ(nf.Query),
+		); err != nil {
 			logger.Error().Err(err).Int("nar_file_id", nf.ID).Msg("failed to delete nar_file missing from storage")
 		} else {
 			logger.Info().
@@ -1702,7 +1714,7 @@ func repairFsckIssues(
 			Where(
 				entnarfile.HashEQ(narURL.Hash),
 				entnarfile.CompressionEQ(narURL.Compression.String()),
-				entnarfile.QueryEQ(narURL.Query.Encode()),
+				entnarfile.QueryEQ(narURL.CanonicalQuery()),
 			).
 			Exist(ctx)
 		if err != nil {
@@ -1861,6 +1873,21 @@ func repairFsckIssues(
 			Msg("repaired narinfos advertising a non-producible compression")
 	}
 
+	// g. Recount the chunk ref_counts last, once every link above is settled, so
+	// chunk GC never reclaims a linked chunk nor keeps an unlinked one.
+	if results.cdcMode {
+		recounted, err := dbClient.RecountChunkRefs(ctx)
+		if err != nil {
+			return fmt.Errorf("recount chunk references: %w", err)
+		}
+
+		if recounted > 0 {
+			logger.Info().
+				Int64("count", recounted).
+				Msg("corrected drifted chunk reference counts")
+		}
+	}
+
 	return nil
 }
 
@@ -1888,12 +1915,18 @@ func reclaimStaleChunkLinks(
 	// not affected. A mid-chunking nar_file has chunking_started_at set with
 	// total_chunks still 0; its links are being written, not stale, so it is excluded
 	// via ChunkingStartedAtIsNil.
-	linksDeleted, err := dbClient.Ent().NarFileChunk.Delete().
-		Where(entnarfilechunk.HasNarFileWith(
+	var linksDeleted int
+
+	err := dbClient.WithTransaction(ctx, "fsck.deleteStaleChunkLinks", func(tx *ent.Tx) error {
+		var err error
+
+		linksDeleted, err = database.UnlinkNarFilesChunks(ctx, tx,
 			entnarfile.TotalChunksLTE(0),
 			entnarfile.ChunkingStartedAtIsNil(),
-		)).
-		Exec(ctx)
+		)
+
+		return err
+	})
 	if err != nil {
 		return 0, 0, fmt.Errorf("delete stale chunk links: %w", err)
 	}
@@ -2013,7 +2046,7 @@ func repairOneNarInfoCompressionDesync(ctx context.Context, dbClient *database.C
 		Where(
 			entnarfile.HashEQ(advertised.Hash),
 			entnarfile.CompressionEQ(nar.CompressionTypeXz.String()),
-			entnarfile.QueryEQ(advertised.Query.Encode()),
+			entnarfile.QueryEQ(advertised.CanonicalQuery()),
 		).
 		Exist(ctx)
 	if err != nil {
@@ -2035,7 +2068,7 @@ func repairOneNarInfoCompressionDesync(ctx context.Context, dbClient *database.C
 	hasBacking, err := dbClient.Ent().NarFile.Query().
 		Where(
 			entnarfile.HashEQ(advertised.Hash),
-			entnarfile.QueryEQ(advertised.Query.Encode()),
+			entnarfile.QueryEQ(advertised.CanonicalQuery()),
 			entnarfile.HasNarInfoNarFilesWith(entnarinfonarfile.NarinfoIDEQ(ni.ID)),
 		).
 		Exist(ctx)
@@ -2114,13 +2147,11 @@ func repairCDCNarFiles(
 			continue
 		}
 
-		if _, err := dbClient.Ent().NarFile.Delete().
-			Where(
-				entnarfile.HashEQ(nf.Hash),
-				entnarfile.CompressionEQ(nf.Compression),
-				entnarfile.QueryEQ(nf.Query),
-			).
-			Exec(ctx); err != nil {
+		if err := deleteNarFileRows(ctx, dbClient,
+			entnarfile.HashEQ(nf.Hash),
+			entnarfile.CompressionEQ(nf.Compression),
+			entnarfile.QueryEQ(nf.Query),
+		); err != nil {
 			logger.Error().Err(err).Int("nar_file_id", nf.ID).Msg("failed to delete broken CDC nar_file")
 		} else {
 			logger.Info().Int("nar_file_id", nf.ID).Str("hash", nf.Hash).Msg("deleted broken CDC nar_file")
@@ -2218,13 +2249,11 @@ func repairSizeMismatchCDCNarFiles(
 			continue
 		}
 
-		if _, err := dbClient.Ent().NarFile.Delete().
-			Where(
-				entnarfile.HashEQ(nf.Hash),
-				entnarfile.CompressionEQ(nf.Compression),
-				entnarfile.QueryEQ(nf.Query),
-			).
-			Exec(ctx); err != nil {
+		if err := deleteNarFileRows(ctx, dbClient,
+			entnarfile.HashEQ(nf.Hash),
+			entnarfile.CompressionEQ(nf.Compression),
+			entnarfile.QueryEQ(nf.Query),
+		); err != nil {
 			logger.Error().Err(err).Int("nar_file_id", nf.ID).Msg("failed to delete size-mismatched CDC nar_file")
 		} else {
 			logger.Info().Int("nar_file_id", nf.ID).Str("hash", nf.Hash).Msg("deleted size-mismatched CDC nar_file")
@@ -2636,6 +2665,22 @@ func isNarFileHashMismatched(
 	return !bytes.Equal(h.Sum(nil), expectedHash.Digest()), nil
 }
 
+// deleteNarFileRows deletes the nar_files matching preds, dropping the
+// references they hold on their chunks in the same transaction.
+func deleteNarFileRows(ctx context.Context, dbClient *database.Client, preds ...predicate.NarFile) error {
+	return dbClient.WithTransaction(ctx, "fsck.deleteNarFiles", func(tx *ent.Tx) error {
+		if _, err := database.UnlinkNarFilesChunks(ctx, tx, preds...); err != nil {
+			return err
+		}
+
+		_, err := tx.NarFile.Delete().
+			Where(preds...).
+			Exec(ctx)
+
+		return err
+	})
+}
+
 // narFileRowToURL converts nar_file fields into a nar.URL.
 func narFileRowToURL(hash, compression, query string) (nar.URL, error) {
 	parsedQuery, err := url.ParseQuery(query)
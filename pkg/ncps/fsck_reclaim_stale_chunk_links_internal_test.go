@@ -80,6 +80,7 @@ func TestReclaimStaleChunkLinks(t *testing.T) {
 		_, lerr := dbClient.Ent().NarFileChunk.Create().
 			SetNarFileID(nfID).SetChunkID(ch.ID).SetChunkIndex(idx).Save(ctx)
 		require.NoError(t, lerr)
+		require.NoError(t, dbClient.Ent().Chunk.UpdateOneID(ch.ID).AddRefCount(1).Exec(ctx))
 	}
 
 	link(dechunkedNF.ID, staleHash, 0)  // stale
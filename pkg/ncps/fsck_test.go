@@ -825,6 +825,7 @@ func setupFsckCDCNarFile(
 			SetChunkIndex(i).
 			Save(ctx)
 		require.NoError(t, err)
+		require.NoError(t, dbClient.Ent().Chunk.UpdateOneID(chunk.ID).AddRefCount(1).Exec(ctx))
 	}
 
 	return narFile
@@ -1066,6 +1067,7 @@ func testFsckCDCRepairIncompleteNar(setup fsckSetupFn) func(*testing.T) {
 			SetChunkIndex(0).
 			Save(ctx)
 		require.NoError(t, err)
+		require.NoError(t, dbClient.Ent().Chunk.UpdateOneID(sharedChunk.ID).AddRefCount(1).Exec(ctx))
 
 		// Delete chunk[1] of Nar1 from storage → Nar1 becomes broken.
 		brokenChunk := nar1Chunks[1]
@@ -1466,6 +1468,7 @@ func setupFsckCDCNarFileWithRealHashes(
 			SetChunkIndex(i).
 			Save(ctx)
 		require.NoError(t, err)
+		require.NoError(t, dbClient.Ent().Chunk.UpdateOneID(chunk.ID).AddRefCount(1).Exec(ctx))
 	}
 }
 
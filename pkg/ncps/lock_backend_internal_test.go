@@ -11,7 +11,7 @@ import (
 
 // TestDetermineEffectiveLockBackend pins the lock-backend resolution matrix that
 // drives in-flight staging: staging is only distributed (and therefore active)
-// when the effective backend resolves to Redis. This covers the
+// when the effective backend resolves to Redis or lock files. This covers the
 // backward-compatibility path where legacy --cache-redis-addrs implies Redis even
 // when --cache-lock-backend is left at its "local" default.
 func TestDetermineEffectiveLockBackend(t *testing.T) {
@@ -35,6 +35,12 @@ func TestDetermineEffectiveLockBackend(t *testing.T) {
 			wantBackend:     lockBackendRedis,
 			wantStagingDist: true,
 		},
+		{
+			name:            "explicit file backend, staging distributed",
+			args:            []string{"app", "--cache-lock-backend", lockBackendFile},
+			wantBackend:     lockBackendFile,
+			wantStagingDist: true,
+		},
 		{
 			name:            "legacy redis-addrs falls back to redis",
 			args:            []string{"app", "--cache-redis-addrs", "127.0.0.1:6379"},
@@ -66,7 +72,7 @@ func TestDetermineEffectiveLockBackend(t *testing.T) {
 				},
 				Action: func(_ context.Context, c *cli.Command) error {
 					gotBackend, _ = determineEffectiveLockBackend(c)
-					gotStagingDist = gotBackend == lockBackendRedis
+					gotStagingDist = gotBackend != lockBackendLocal
 
 					return nil
 				},
@@ -22,9 +22,10 @@ const (
 	MigrationOperationDelete  = "delete"
 
 	// Migration type constants for metrics.
-	MigrationTypeNarInfoToDB = "narinfo-to-db"
-	MigrationTypeNarToChunks = "nar-to-chunks"
-	MigrationTypeChunksToNar = "chunks-to-nar"
+	MigrationTypeNarInfoToDB       = "narinfo-to-db"
+	MigrationTypeNarToChunks       = "nar-to-chunks"
+	MigrationTypeChunksToNar       = "chunks-to-nar"
+	MigrationTypeNarToSeekableZstd = "nar-to-seekable-zstd"
 )
 
 var (
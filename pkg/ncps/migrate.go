@@ -49,7 +49,11 @@ func migrateUpCommand(flagSources flagSourcesFn) *cli.Command {
 			},
 		},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
-			dbURL := cmd.String("cache-database-url")
+			dbURL, err := secretValue(cmd, "cache-database-url")
+			if err != nil {
+				return fmt.Errorf("migrate up: %w", err)
+			}
+
 			if dbURL == "" {
 				//nolint:err113 // diagnostic
 				return errors.New("migrate up: --cache-database-url is required")
@@ -121,7 +125,7 @@ func cacheDatabaseURLFlag(flagSources flagSourcesFn) cli.Flag {
 	return &cli.StringFlag{
 		Name:     flagNameDBURL,
 		Usage:    "Database URL: sqlite:/path, postgresql://..., mysql://...",
-		Sources:  flagSources("cache.database.url", "CACHE_DATABASE_URL"),
+		Sources:  secretSources(flagSources("cache.database.url", "CACHE_DATABASE_URL")),
 		Required: true,
 	}
 }
@@ -41,7 +41,7 @@ For each chunked nar_file, the NAR is reassembled from its chunks, verified agai
 narinfo's recorded NarHash, written to the NAR store as a whole file, and the record is flipped to
 the whole-file representation. Chunks left unreferenced by any nar_file are then reclaimed.
 NARs whose narinfo has no recorded NarHash are left chunked (skipped) rather than de-chunked unverified.`,
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.BoolFlag{
 				Name:  flagNameDryRun,
 				Usage: "Report which NARs would be de-chunked without writing whole files, mutating records, or deleting chunks",
@@ -84,24 +84,29 @@ NARs whose narinfo has no recorded NarHash are left chunked (skipped) rather tha
 			&cli.StringFlag{
 				Name:    flagNameS3AccessKeyID,
 				Usage:   flagUsageS3AccessKeyID,
-				Sources: flagSources("cache.storage.s3.access-key-id", "CACHE_STORAGE_S3_ACCESS_KEY_ID"),
+				Sources: secretSources(flagSources("cache.storage.s3.access-key-id", "CACHE_STORAGE_S3_ACCESS_KEY_ID")),
 			},
 			&cli.StringFlag{
 				Name:    flagNameS3SecretKey,
 				Usage:   flagUsageS3SecretKey,
-				Sources: flagSources("cache.storage.s3.secret-access-key", "CACHE_STORAGE_S3_SECRET_ACCESS_KEY"),
+				Sources: secretSources(flagSources("cache.storage.s3.secret-access-key", "CACHE_STORAGE_S3_SECRET_ACCESS_KEY")),
 			},
 			&cli.BoolFlag{
 				Name:    flagNameS3ForcePathStyle,
 				Usage:   flagUsageS3ForcePathStyle,
 				Sources: flagSources("cache.storage.s3.force-path-style", "CACHE_STORAGE_S3_FORCE_PATH_STYLE"),
 			},
+			&cli.IntFlag{
+				Name:    flagNameStorageInlineThreshold,
+				Usage:   flagUsageStorageInlineThreshold,
+				Sources: flagSources("cache.storage.inline-threshold", "CACHE_STORAGE_INLINE_THRESHOLD"),
+			},
 
 			// Database Flags
 			&cli.StringFlag{
 				Name:     flagNameDBURL,
 				Usage:    flagUsageDBURL,
-				Sources:  flagSources("cache.database-url", "CACHE_DATABASE_URL"),
+				Sources:  secretSources(flagSources("cache.database-url", "CACHE_DATABASE_URL")),
 				Required: true,
 			},
 			&cli.IntFlag{
@@ -129,7 +134,7 @@ NARs whose narinfo has no recorded NarHash are left chunked (skipped) rather tha
 			&cli.StringFlag{
 				Name:    flagNameRedisPassword,
 				Usage:   flagUsageRedisPassword,
-				Sources: flagSources("cache.redis.password", "CACHE_REDIS_PASSWORD"),
+				Sources: secretSources(flagSources("cache.redis.password", "CACHE_REDIS_PASSWORD")),
 			},
 			&cli.IntFlag{
 				Name:    flagNameRedisDB,
@@ -147,6 +152,11 @@ NARs whose narinfo has no recorded NarHash are left chunked (skipped) rather tha
 				Sources: flagSources("cache.lock.backend", "CACHE_LOCK_BACKEND"),
 				Value:   lockBackendLocal,
 			},
+			&cli.StringFlag{
+				Name:    flagNameLockFileDir,
+				Usage:   flagUsageLockFileDir,
+				Sources: flagSources("cache.lock.file.dir", "CACHE_LOCK_FILE_DIR"),
+			},
 			&cli.StringFlag{
 				Name:    flagNameLockRedisKeyPrefix,
 				Usage:   flagUsageLockRedisKeyPrefix,
@@ -206,7 +216,7 @@ NARs whose narinfo has no recorded NarHash are left chunked (skipped) rather tha
 				Value:   10,
 				Sources: flagSources("concurrency", "CONCURRENCY"),
 			},
-		},
+		}, storeFlags(flagSources)...),
 		Action: migrateChunksToNarAction(registerShutdown),
 	}
 }
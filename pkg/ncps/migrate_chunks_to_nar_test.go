@@ -428,6 +428,7 @@ func seedBrokenChunkedNar(ctx context.Context, t *testing.T, dbClient *database.
 		SetChunkIndex(0).
 		Save(ctx)
 	require.NoError(t, err)
+	require.NoError(t, dbClient.Ent().Chunk.UpdateOneID(ch.ID).AddRefCount(1).Exec(ctx))
 }
 
 // TestMigrateChunksToNar_CLI_SkipsBrokenNarMigratesRestAndReports verifies the
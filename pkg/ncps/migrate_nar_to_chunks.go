@@ -38,7 +38,7 @@ func migrateNarToChunksCommand(
 		Description: `Migrates NAR files from traditional storage (filesystem/S3) to content-defined chunks.
 This requires CDC to be enabled and a chunk store configured.
 Once a NAR is successfully migrated to chunks and verified, it is deleted from the original storage.`,
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.BoolFlag{
 				Name:  flagNameDryRun,
 				Usage: "Simulate migration without writing to chunk store or deleting from storage",
@@ -75,24 +75,29 @@ Once a NAR is successfully migrated to chunks and verified, it is deleted from t
 			&cli.StringFlag{
 				Name:    flagNameS3AccessKeyID,
 				Usage:   flagUsageS3AccessKeyID,
-				Sources: flagSources("cache.storage.s3.access-key-id", "CACHE_STORAGE_S3_ACCESS_KEY_ID"),
+				Sources: secretSources(flagSources("cache.storage.s3.access-key-id", "CACHE_STORAGE_S3_ACCESS_KEY_ID")),
 			},
 			&cli.StringFlag{
 				Name:    flagNameS3SecretKey,
 				Usage:   flagUsageS3SecretKey,
-				Sources: flagSources("cache.storage.s3.secret-access-key", "CACHE_STORAGE_S3_SECRET_ACCESS_KEY"),
+				Sources: secretSources(flagSources("cache.storage.s3.secret-access-key", "CACHE_STORAGE_S3_SECRET_ACCESS_KEY")),
 			},
 			&cli.BoolFlag{
 				Name:    flagNameS3ForcePathStyle,
 				Usage:   flagUsageS3ForcePathStyle,
 				Sources: flagSources("cache.storage.s3.force-path-style", "CACHE_STORAGE_S3_FORCE_PATH_STYLE"),
 			},
+			&cli.IntFlag{
+				Name:    flagNameStorageInlineThreshold,
+				Usage:   flagUsageStorageInlineThreshold,
+				Sources: flagSources("cache.storage.inline-threshold", "CACHE_STORAGE_INLINE_THRESHOLD"),
+			},
 
 			// Database Flags
 			&cli.StringFlag{
 				Name:     flagNameDBURL,
 				Usage:    flagUsageDBURL,
-				Sources:  flagSources("cache.database-url", "CACHE_DATABASE_URL"),
+				Sources:  secretSources(flagSources("cache.database-url", "CACHE_DATABASE_URL")),
 				Required: true,
 			},
 			&cli.IntFlag{
@@ -120,7 +125,7 @@ Once a NAR is successfully migrated to chunks and verified, it is deleted from t
 			&cli.StringFlag{
 				Name:    flagNameRedisPassword,
 				Usage:   flagUsageRedisPassword,
-				Sources: flagSources("cache.redis.password", "CACHE_REDIS_PASSWORD"),
+				Sources: secretSources(flagSources("cache.redis.password", "CACHE_REDIS_PASSWORD")),
 			},
 			&cli.IntFlag{
 				Name:    flagNameRedisDB,
@@ -139,6 +144,11 @@ Once a NAR is successfully migrated to chunks and verified, it is deleted from t
 				Sources: flagSources("cache.lock.backend", "CACHE_LOCK_BACKEND"),
 				Value:   lockBackendLocal,
 			},
+			&cli.StringFlag{
+				Name:    flagNameLockFileDir,
+				Usage:   flagUsageLockFileDir,
+				Sources: flagSources("cache.lock.file.dir", "CACHE_LOCK_FILE_DIR"),
+			},
 			&cli.StringFlag{
 				Name:    flagNameLockRedisKeyPrefix,
 				Usage:   flagUsageLockRedisKeyPrefix,
@@ -198,7 +208,7 @@ Once a NAR is successfully migrated to chunks and verified, it is deleted from t
 				Value:   10,
 				Sources: flagSources("concurrency", "CONCURRENCY"),
 			},
-		},
+		}, storeFlags(flagSources)...),
 		Action: func(ctx context.Context, cmd *cli.Command) error {
 			logger := zerolog.Ctx(ctx).With().Str("cmd", "migrate-nar-to-chunks").Logger()
 			ctx = logger.WithContext(ctx)
@@ -287,6 +297,11 @@ Once a NAR is successfully migrated to chunks and verified, it is deleted from t
 				return fmt.Errorf("error creating storage backend: %w", err)
 			}
 
+			narStore, err = wrapInlineNarStore(ctx, cmd, dbClient, narStore)
+			if err != nil {
+				return err
+			}
+
 			// 6. Safety Check: Ensure all narinfos are migrated
 			var unmigratedHashesCount int32
 
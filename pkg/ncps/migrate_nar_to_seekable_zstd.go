@@ -0,0 +1,487 @@
+package ncps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/sync/errgroup"
+
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+
+	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/pkg/cache"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/otel"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+// ErrNarToSeekableZstdFailures is returned when one or more NARs failed to be
+// rewritten in the seekable zstd format.
+var ErrNarToSeekableZstdFailures = errors.New("one or more nars failed to migrate to the seekable zstd format")
+
+const migrateNarToSeekableZstdProgressInterval = 5 * time.Second
+
+func migrateNarToSeekableZstdCommand(
+	flagSources flagSourcesFn,
+	registerShutdown registerShutdownFn,
+) *cli.Command {
+	return &cli.Command{
+		Name:  "migrate-nar-to-seekable-zstd",
+		Usage: "Rewrite the .nar.zst of uncompressed NARs in the seekable zstd format",
+		Description: `Uncompressed NARs are stored as .nar.zst. New ones are written in the seekable zstd
+format, which lets Range requests decompress only the part of the NAR they cover. This command
+rewrites the .nar.zst files written before that in the seekable format. Each file is decompressed,
+re-encoded to a temporary file, verified and swapped in; files already in the seekable format are
+skipped. NARs stored with the compression of their upstream keep the upstream's bytes.`,
+		Flags: append([]cli.Flag{
+			&cli.BoolFlag{
+				Name:  flagNameDryRun,
+				Usage: "Report which NARs would be rewritten without rewriting them",
+			},
+
+			&cli.StringFlag{
+				Name:    flagNameCacheTempPath,
+				Usage:   "The path to the temporary directory that is used by the cache to re-encode NAR files",
+				Sources: flagSources("cache.temp-path", "CACHE_TEMP_PATH"),
+				Value:   os.TempDir(),
+			},
+
+			// Storage Flags
+			&cli.StringFlag{
+				Name:    flagNameStorageLocal,
+				Usage:   flagUsageStorageLocal,
+				Sources: flagSources("cache.storage.local", "CACHE_STORAGE_LOCAL"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameS3Bucket,
+				Usage:   flagUsageS3Bucket,
+				Sources: flagSources("cache.storage.s3.bucket", "CACHE_STORAGE_S3_BUCKET"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameS3Endpoint,
+				Usage:   flagUsageS3Endpoint,
+				Sources: flagSources("cache.storage.s3.endpoint", "CACHE_STORAGE_S3_ENDPOINT"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameS3Region,
+				Usage:   flagUsageS3Region,
+				Sources: flagSources("cache.storage.s3.region", "CACHE_STORAGE_S3_REGION"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameS3AccessKeyID,
+				Usage:   flagUsageS3AccessKeyID,
+				Sources: secretSources(flagSources("cache.storage.s3.access-key-id", "CACHE_STORAGE_S3_ACCESS_KEY_ID")),
+			},
+			&cli.StringFlag{
+				Name:    flagNameS3SecretKey,
+				Usage:   flagUsageS3SecretKey,
+				Sources: secretSources(flagSources("cache.storage.s3.secret-access-key", "CACHE_STORAGE_S3_SECRET_ACCESS_KEY")),
+			},
+			&cli.BoolFlag{
+				Name:    flagNameS3ForcePathStyle,
+				Usage:   flagUsageS3ForcePathStyle,
+				Sources: flagSources("cache.storage.s3.force-path-style", "CACHE_STORAGE_S3_FORCE_PATH_STYLE"),
+			},
+			&cli.IntFlag{
+				Name:    flagNameStorageInlineThreshold,
+				Usage:   flagUsageStorageInlineThreshold,
+				Sources: flagSources("cache.storage.inline-threshold", "CACHE_STORAGE_INLINE_THRESHOLD"),
+			},
+
+			// Database Flags
+			&cli.StringFlag{
+				Name:     flagNameDBURL,
+				Usage:    flagUsageDBURL,
+				Sources:  secretSources(flagSources("cache.database-url", "CACHE_DATABASE_URL")),
+				Required: true,
+			},
+			&cli.IntFlag{
+				Name:    flagNameDBMaxOpenConns,
+				Usage:   flagUsageDBMaxOpenConns,
+				Sources: flagSources("cache.database.pool.max-open-conns", "CACHE_DATABASE_POOL_MAX_OPEN_CONNS"),
+			},
+			&cli.IntFlag{
+				Name:    flagNameDBMaxIdleConns,
+				Usage:   flagUsageDBMaxIdleConns,
+				Sources: flagSources("cache.database.pool.max-idle-conns", "CACHE_DATABASE_POOL_MAX_IDLE_CONNS"),
+			},
+
+			// Lock Backend Flags (optional - for coordination with running instances)
+			&cli.StringSliceFlag{
+				Name:    flagNameRedisAddrs,
+				Usage:   flagUsageRedisAddrs,
+				Sources: flagSources("cache.redis.addrs", "CACHE_REDIS_ADDRS"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameRedisUsername,
+				Usage:   flagUsageRedisUsername,
+				Sources: flagSources("cache.redis.username", "CACHE_REDIS_USERNAME"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameRedisPassword,
+				Usage:   flagUsageRedisPassword,
+				Sources: secretSources(flagSources("cache.redis.password", "CACHE_REDIS_PASSWORD")),
+			},
+			&cli.IntFlag{
+				Name:    flagNameRedisDB,
+				Usage:   flagUsageRedisDB,
+				Sources: flagSources("cache.redis.db", "CACHE_REDIS_DB"),
+			},
+			&cli.BoolFlag{
+				Name:    flagNameRedisTLS,
+				Usage:   flagUsageRedisTLS,
+				Sources: flagSources("cache.redis.use-tls", "CACHE_REDIS_USE_TLS"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameLockBackend,
+				Usage:   flagUsageLockBackend,
+				Sources: flagSources("cache.lock.backend", "CACHE_LOCK_BACKEND"),
+				Value:   lockBackendLocal,
+			},
+			&cli.StringFlag{
+				Name:    flagNameLockFileDir,
+				Usage:   flagUsageLockFileDir,
+				Sources: flagSources("cache.lock.file.dir", "CACHE_LOCK_FILE_DIR"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameLockRedisKeyPrefix,
+				Usage:   flagUsageLockRedisKeyPrefix,
+				Sources: flagSources("cache.lock.redis.key-prefix", "CACHE_LOCK_REDIS_KEY_PREFIX"),
+				Value:   flagDefaultLockRedisKeyPrefix,
+			},
+			&cli.DurationFlag{
+				Name:    flagNameLockDownloadTTL,
+				Usage:   flagUsageLockDownloadTTL,
+				Sources: flagSources("cache.lock.download-lock-ttl", "CACHE_LOCK_DOWNLOAD_TTL"),
+				Value:   5 * time.Minute,
+			},
+			&cli.DurationFlag{
+				Name:    flagNameLockLRUTTL,
+				Usage:   flagUsageLockLRUTTL,
+				Sources: flagSources("cache.lock.lru-lock-ttl", "CACHE_LOCK_LRU_TTL"),
+				Value:   30 * time.Minute,
+			},
+			&cli.IntFlag{
+				Name:    flagNameLockMaxRetries,
+				Usage:   flagUsageLockMaxRetries,
+				Sources: flagSources("cache.lock.retry.max-attempts", "CACHE_LOCK_RETRY_MAX_ATTEMPTS"),
+				Value:   3,
+			},
+			&cli.DurationFlag{
+				Name:    flagNameLockInitialDelay,
+				Usage:   flagUsageLockInitialDelay,
+				Sources: flagSources("cache.lock.retry.initial-delay", "CACHE_LOCK_RETRY_INITIAL_DELAY"),
+				Value:   100 * time.Millisecond,
+			},
+			&cli.DurationFlag{
+				Name:    flagNameLockMaxDelay,
+				Usage:   flagUsageLockMaxDelay,
+				Sources: flagSources("cache.lock.retry.max-delay", "CACHE_LOCK_RETRY_MAX_DELAY"),
+				Value:   2 * time.Second,
+			},
+			&cli.BoolFlag{
+				Name:    flagNameLockJitter,
+				Usage:   flagUsageLockJitter,
+				Sources: flagSources("cache.lock.retry.jitter", "CACHE_LOCK_RETRY_JITTER"),
+				Value:   true,
+			},
+			&cli.BoolFlag{
+				Name:    flagNameLockAllowDegraded,
+				Usage:   flagUsageLockAllowDegraded,
+				Sources: flagSources("cache.lock.allow-degraded-mode", "CACHE_LOCK_ALLOW_DEGRADED_MODE"),
+			},
+			&cli.IntFlag{
+				Name:    flagNameRedisPoolSize,
+				Usage:   flagUsageRedisPoolSize,
+				Sources: flagSources("cache.redis.pool-size", "CACHE_REDIS_POOL_SIZE"),
+				Value:   10,
+			},
+			&cli.IntFlag{
+				Name:    flagNameConcurrency,
+				Usage:   flagUsageConcurrency,
+				Value:   10,
+				Sources: flagSources("concurrency", "CONCURRENCY"),
+			},
+		}, storeFlags(flagSources)...),
+		Action: migrateNarToSeekableZstdAction(registerShutdown),
+	}
+}
+
+//nolint:gocognit,cyclop // mirrors migrate-chunks-to-nar; the per-NAR state machine is inherently branchy.
+func migrateNarToSeekableZstdAction(registerShutdown registerShutdownFn) cli.ActionFunc {
+	return func(ctx context.Context, cmd *cli.Command) error {
+		logger := zerolog.Ctx(ctx).With().Str("cmd", "migrate-nar-to-seekable-zstd").Logger()
+		ctx = logger.WithContext(ctx)
+
+		dryRun := cmd.Bool("dry-run")
+
+		dbClient, err := createDatabaseClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error creating database client: %w", err)
+		}
+
+		registerShutdown("database client", func(_ context.Context) error { return dbClient.Close() })
+
+		locker, rwLocker, err := getLockers(ctx, cmd)
+		if err != nil {
+			return fmt.Errorf("error creating lockers: %w", err)
+		}
+
+		// Uncompressed NARs stored as whole files are the ones stored as .nar.zst.
+		wholeFileNone := entnarfile.And(
+			entnarfile.CompressionEQ(nar.CompressionTypeNone.String()),
+			entnarfile.TotalChunksEQ(0),
+		)
+
+		wholeFileCount, err := dbClient.Ent().NarFile.Query().
+			Where(wholeFileNone).
+			Count(ctx)
+		if err != nil {
+			return fmt.Errorf("error querying uncompressed NAR count: %w", err)
+		}
+
+		if wholeFileCount == 0 {
+			zerolog.Ctx(ctx).Info().Msg("migrate-nar-to-seekable-zstd: nothing to migrate; no uncompressed NARs found")
+
+			return nil
+		}
+
+		extraResourceAttrs, err := detectExtraResourceAttrs(ctx, cmd, dbClient, rwLocker)
+		if err != nil {
+			return fmt.Errorf("error detecting extra resource attributes: %w", err)
+		}
+
+		otelResource, err := otel.NewResource(ctx, cmd.Root().Name, Version, semconv.SchemaURL, extraResourceAttrs...)
+		if err != nil {
+			return fmt.Errorf("error creating otel resource: %w", err)
+		}
+
+		otelShutdown, err := otel.SetupOTelSDK(
+			ctx,
+			cmd.Root().Bool("otel-enabled"),
+			cmd.Root().String("otel-grpc-url"),
+			otelResource,
+		)
+		if err != nil {
+			return err
+		}
+
+		registerShutdown("open telemetry", otelShutdown)
+
+		c, err := createCache(ctx, cmd, dbClient, locker, rwLocker, nil)
+		if err != nil {
+			return fmt.Errorf("error creating cache: %w", err)
+		}
+		defer c.Close()
+
+		// Don't kick off lazy chunking of the NARs we are rewriting.
+		c.SetCDCLazyChunking(false, 0)
+
+		logger.Info().Bool("dry_run", dryRun).Msg("starting migration of uncompressed NARs to the seekable zstd format")
+
+		startTime := time.Now()
+
+		narFiles, err := dbClient.Ent().NarFile.Query().
+			Where(wholeFileNone).
+			Order(ent.Asc(entnarfile.FieldID)).
+			Select(
+				entnarfile.FieldID,
+				entnarfile.FieldHash,
+				entnarfile.FieldCompression,
+				entnarfile.FieldQuery,
+				entnarfile.FieldFileSize,
+			).
+			All(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to fetch uncompressed NAR files from database: %w", err)
+		}
+
+		total := int64(len(narFiles))
+
+		var (
+			totalProcessed int32
+			totalSucceeded int32
+			totalFailed    int32
+			totalSkipped   int32
+		)
+
+		// Clamp to >= 1: errgroup.SetLimit(0) makes the first g.Go block forever.
+		concurrency := cmd.Int("concurrency")
+		if concurrency < 1 {
+			logger.Warn().Int("concurrency", concurrency).Msg("concurrency must be >= 1; using 1")
+			concurrency = 1
+		}
+
+		g, ctx := errgroup.WithContext(ctx)
+		g.SetLimit(concurrency)
+
+		// Progress reporter
+		progressTicker := time.NewTicker(migrateNarToSeekableZstdProgressInterval)
+		defer progressTicker.Stop()
+
+		progressDone := make(chan struct{})
+
+		var progressWg sync.WaitGroup
+
+		progressWg.Add(1)
+
+		go func() {
+			defer progressWg.Done()
+
+			for {
+				select {
+				case <-progressTicker.C:
+					elapsed := time.Since(startTime)
+					processed := atomic.LoadInt32(&totalProcessed)
+					succeeded := atomic.LoadInt32(&totalSucceeded)
+					failed := atomic.LoadInt32(&totalFailed)
+					skipped := atomic.LoadInt32(&totalSkipped)
+
+					var rate float64
+					if durationInSeconds := elapsed.Seconds(); durationInSeconds > 0 {
+						rate = float64(processed) / durationInSeconds
+					}
+
+					var percent float64
+					if total > 0 {
+						percent = float64(processed) / float64(total) * 100
+					}
+
+					logger.Info().
+						Int64("total", total).
+						Int32("processed", processed).
+						Int32("succeeded", succeeded).
+						Int32("failed", failed).
+						Int32("skipped", skipped).
+						Str("percent", fmt.Sprintf("%.2f%%", percent)).
+						Str("elapsed", elapsed.Round(time.Second).String()).
+						Float64("rate", rate).
+						Msg("migration progress")
+				case <-progressDone:
+					return
+				}
+			}
+		}()
+
+		for _, row := range narFiles {
+			g.Go(func() error {
+				// If the group context is already cancelled (shutdown/interrupt),
+				// exit early instead of failing the migration and flooding logs.
+				if ctx.Err() != nil {
+					return nil //nolint:nilerr // cancellation is a graceful skip, not a per-NAR failure
+				}
+
+				log := logger.With().Str("nar_hash", row.Hash).Logger()
+
+				narURL := nar.URL{
+					Hash:        row.Hash,
+					Compression: nar.CompressionType(row.Compression),
+					Query:       make(map[string][]string),
+				}
+
+				// Count it as processed up front so a query-parse failure (counted
+				// below) still reconciles with the processed total in the summary.
+				atomic.AddInt32(&totalProcessed, 1)
+
+				if row.Query != "" {
+					q, err := url.ParseQuery(row.Query)
+					if err != nil {
+						log.Error().Err(err).Str("query", row.Query).Msg("failed to parse nar query")
+						atomic.AddInt32(&totalFailed, 1)
+						RecordMigrationObject(ctx, MigrationTypeNarToSeekableZstd, MigrationOperationMigrate, MigrationResultFailure)
+
+						return nil
+					}
+
+					narURL.Query = q
+				}
+
+				if dryRun {
+					log.Info().Msg("[DRY-RUN] would rewrite the nar in the seekable zstd format")
+					atomic.AddInt32(&totalSucceeded, 1)
+					RecordMigrationObject(ctx, MigrationTypeNarToSeekableZstd, MigrationOperationMigrate, MigrationResultSuccess)
+
+					return nil
+				}
+
+				opStartTime := time.Now()
+				err := c.MigrateNarToSeekableZstd(ctx, &narURL)
+				RecordMigrationDuration(
+					ctx,
+					MigrationTypeNarToSeekableZstd,
+					MigrationOperationMigrate,
+					time.Since(opStartTime).Seconds(),
+				)
+
+				switch {
+				case errors.Is(err, cache.ErrMigrationInProgress):
+					// Another instance is handling this hash; not our failure.
+					atomic.AddInt32(&totalSkipped, 1)
+					RecordMigrationObject(ctx, MigrationTypeNarToSeekableZstd, MigrationOperationMigrate, MigrationResultSkipped)
+				case errors.Is(err, cache.ErrNarAlreadySeekable), errors.Is(err, storage.ErrNotFound):
+					// Already seekable, or not stored as a .nar.zst: an uploaded
+					// plain .nar, or a nar chunked since the query above.
+					atomic.AddInt32(&totalSkipped, 1)
+					RecordMigrationObject(ctx, MigrationTypeNarToSeekableZstd, MigrationOperationMigrate, MigrationResultSkipped)
+				case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+					log.Warn().Err(err).Msg("migration interrupted; leaving nar as is")
+					atomic.AddInt32(&totalFailed, 1)
+					RecordMigrationObject(ctx, MigrationTypeNarToSeekableZstd, MigrationOperationMigrate, MigrationResultFailure)
+				case err != nil:
+					log.Error().Err(err).Msg("failed to rewrite the nar in the seekable zstd format")
+					atomic.AddInt32(&totalFailed, 1)
+					RecordMigrationObject(ctx, MigrationTypeNarToSeekableZstd, MigrationOperationMigrate, MigrationResultFailure)
+				default:
+					atomic.AddInt32(&totalSucceeded, 1)
+					RecordMigrationObject(ctx, MigrationTypeNarToSeekableZstd, MigrationOperationMigrate, MigrationResultSuccess)
+				}
+
+				return nil
+			})
+		}
+
+		workerErr := g.Wait()
+
+		// Stop the progress reporter before emitting the final summary or
+		// propagating any worker error, so progress lines never appear after
+		// "migration completed" in the log stream.
+		close(progressDone)
+		progressWg.Wait()
+
+		if workerErr != nil {
+			return workerErr
+		}
+
+		duration := time.Since(startTime)
+		processed := atomic.LoadInt32(&totalProcessed)
+		succeeded := atomic.LoadInt32(&totalSucceeded)
+		failed := atomic.LoadInt32(&totalFailed)
+		skipped := atomic.LoadInt32(&totalSkipped)
+
+		logger.Info().
+			Int64("total", total).
+			Int32("processed", processed).
+			Int32("succeeded", succeeded).
+			Int32("failed", failed).
+			Int32("skipped", skipped).
+			Str("duration", duration.Round(time.Millisecond).String()).
+			Msg("migration completed")
+
+		RecordMigrationBatchSize(ctx, MigrationTypeNarToSeekableZstd, total)
+
+		if failed > 0 {
+			return fmt.Errorf("%w (%d failed)", ErrNarToSeekableZstdFailures, failed)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,79 @@
+package ncps_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/ncps"
+	"github.com/kalbasit/ncps/pkg/zstd"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestMigrateNarToSeekableZstd_CLI(t *testing.T) {
+	t.Parallel()
+
+	ctx := zerolog.New(os.Stderr).WithContext(context.Background())
+	dbClient, store, dir, dbURL, cleanup := setupNarToChunksMigrationSQLite(t)
+	t.Cleanup(cleanup)
+
+	// An uncompressed nar stored as a regular, non-seekable .nar.zst.
+	enc := zstd.GetWriter()
+	compressed := enc.EncodeAll([]byte(testdata.Nar1.NarText), nil)
+	zstd.PutWriter(enc)
+
+	zstdURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: nar.CompressionTypeZstd}
+	_, err := store.PutNar(ctx, zstdURL, bytes.NewReader(compressed), int64(len(compressed)))
+	require.NoError(t, err)
+
+	_, err = dbClient.Ent().NarFile.Create().
+		SetHash(testdata.Nar1.NarHash).
+		SetCompression(nar.CompressionTypeNone.String()).
+		SetQuery("").
+		SetFileSize(uint64(len(compressed))).
+		Save(ctx)
+	require.NoError(t, err)
+
+	run := func() error {
+		app, err := ncps.New()
+		require.NoError(t, err)
+
+		return app.Run(ctx, []string{
+			"ncps", "migrate-nar-to-seekable-zstd",
+			"--cache-database-url", dbURL,
+			"--cache-storage-local", dir,
+		})
+	}
+
+	require.NoError(t, run())
+
+	size, r, err := store.GetNar(ctx, zstdURL)
+	require.NoError(t, err)
+
+	defer r.Close()
+
+	sr, err := zstd.NewSeekableReader(r.(io.ReaderAt), size)
+	require.NoError(t, err, "the .nar.zst must be seekable after the migration")
+
+	got, err := io.ReadAll(sr)
+	require.NoError(t, err)
+	assert.Equal(t, testdata.Nar1.NarText, string(got))
+
+	nf, err := dbClient.Ent().NarFile.Query().
+		Where(entnarfile.HashEQ(testdata.Nar1.NarHash)).
+		Only(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(size), nf.FileSize, "the recorded size must match the rewritten file") //nolint:gosec // test size
+
+	// A second run finds nothing left to rewrite.
+	require.NoError(t, run())
+}
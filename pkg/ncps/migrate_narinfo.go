@@ -70,12 +70,12 @@ requests. Without Redis, the command uses in-memory locking (no coordination wit
 			&cli.StringFlag{
 				Name:    flagNameS3AccessKeyID,
 				Usage:   flagUsageS3AccessKeyID,
-				Sources: flagSources("cache.storage.s3.access-key-id", "CACHE_STORAGE_S3_ACCESS_KEY_ID"),
+				Sources: secretSources(flagSources("cache.storage.s3.access-key-id", "CACHE_STORAGE_S3_ACCESS_KEY_ID")),
 			},
 			&cli.StringFlag{
 				Name:    flagNameS3SecretKey,
 				Usage:   flagUsageS3SecretKey,
-				Sources: flagSources("cache.storage.s3.secret-access-key", "CACHE_STORAGE_S3_SECRET_ACCESS_KEY"),
+				Sources: secretSources(flagSources("cache.storage.s3.secret-access-key", "CACHE_STORAGE_S3_SECRET_ACCESS_KEY")),
 			},
 			&cli.BoolFlag{
 				Name:    flagNameS3ForcePathStyle,
@@ -87,7 +87,7 @@ requests. Without Redis, the command uses in-memory locking (no coordination wit
 			&cli.StringFlag{
 				Name:     flagNameDBURL,
 				Usage:    flagUsageDBURL,
-				Sources:  flagSources("cache.database-url", "CACHE_DATABASE_URL"),
+				Sources:  secretSources(flagSources("cache.database-url", "CACHE_DATABASE_URL")),
 				Required: true,
 			},
 			&cli.IntFlag{
@@ -115,7 +115,7 @@ requests. Without Redis, the command uses in-memory locking (no coordination wit
 			&cli.StringFlag{
 				Name:    flagNameRedisPassword,
 				Usage:   flagUsageRedisPassword,
-				Sources: flagSources("cache.redis.password", "CACHE_REDIS_PASSWORD"),
+				Sources: secretSources(flagSources("cache.redis.password", "CACHE_REDIS_PASSWORD")),
 			},
 			&cli.IntFlag{
 				Name:    flagNameRedisDB,
@@ -141,6 +141,11 @@ requests. Without Redis, the command uses in-memory locking (no coordination wit
 				Sources: flagSources("cache.lock.backend", "CACHE_LOCK_BACKEND"),
 				Value:   lockBackendLocal,
 			},
+			&cli.StringFlag{
+				Name:    flagNameLockFileDir,
+				Usage:   flagUsageLockFileDir,
+				Sources: flagSources("cache.lock.file.dir", "CACHE_LOCK_FILE_DIR"),
+			},
 			&cli.StringFlag{
 				Name:    flagNameLockRedisKeyPrefix,
 				Usage:   flagUsageLockRedisKeyPrefix,
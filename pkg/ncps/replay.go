@@ -0,0 +1,144 @@
+package ncps
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v3"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream/recorder"
+)
+
+func replayCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "replay",
+		Usage: "Serve the upstream exchanges recorded by ncps serve as a fake upstream",
+		Description: `Reads the upstream exchanges recorded by ncps serve with
+--cache-upstream-record-dir and serves their responses as a fake upstream, to
+reproduce a failing sequence locally: point a local ncps at --addr with
+--cache-upstream-url and replay the client requests against it.
+
+The requests of a path are answered with the responses recorded for it in
+turn, the last one being repeated; a request that failed is answered by
+closing the connection. A response whose body was not recorded, or was
+truncated to --cache-upstream-record-max-body-size, is served with what was
+recorded and the X-Ncps-Replay-Body header.
+
+With --list, the selected exchanges are printed instead of served.`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "dir",
+				Usage:    "The directory of the recording (see --cache-upstream-record-dir of serve)",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "host",
+				Usage: "Only replay the exchanges with the upstream of this host (empty = every upstream)",
+			},
+			&cli.TimestampFlag{
+				Name:   "from",
+				Usage:  "Only replay the exchanges started at or after this time (RFC 3339)",
+				Config: cli.TimestampConfig{Layouts: []string{time.RFC3339}},
+			},
+			&cli.TimestampFlag{
+				Name:   "to",
+				Usage:  "Only replay the exchanges started before this time (RFC 3339)",
+				Config: cli.TimestampConfig{Layouts: []string{time.RFC3339}},
+			},
+			&cli.BoolFlag{
+				Name:  "list",
+				Usage: "Print the selected exchanges instead of serving them",
+			},
+			&cli.StringFlag{
+				Name:  "addr",
+				Usage: "The address to serve the recorded responses on",
+				Value: "127.0.0.1:8600",
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := zerolog.Ctx(ctx).With().Str("cmd", "replay").Logger()
+			ctx = logger.WithContext(ctx)
+
+			exchanges, err := recorder.Read(cmd.String("dir"))
+			if err != nil {
+				return fmt.Errorf("error reading the recording: %w", err)
+			}
+
+			exchanges = filterExchanges(exchanges, cmd.String("host"), cmd.Timestamp("from"), cmd.Timestamp("to"))
+
+			if cmd.Bool("list") {
+				return listExchanges(os.Stdout, exchanges)
+			}
+
+			server := &http.Server{
+				BaseContext:       func(net.Listener) context.Context { return ctx },
+				Addr:              cmd.String("addr"),
+				Handler:           recorder.NewReplayHandler(exchanges),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+
+			logger.Info().
+				Str("addr", cmd.String("addr")).
+				Int("exchanges", len(exchanges)).
+				Msg("replaying the recorded upstream exchanges")
+
+			if err := server.ListenAndServe(); err != nil {
+				return fmt.Errorf("error starting the HTTP listener: %w", err)
+			}
+
+			return nil
+		},
+	}
+}
+
+// filterExchanges returns the exchanges with the upstream of host, started in
+// [from, to). An empty host or a zero time does not filter.
+func filterExchanges(exchanges []recorder.Exchange, host string, from, to time.Time) []recorder.Exchange {
+	selected := make([]recorder.Exchange, 0, len(exchanges))
+
+	for _, e := range exchanges {
+		if host != "" {
+			u, err := url.Parse(e.URL)
+			if err != nil || u.Host != host {
+				continue
+			}
+		}
+
+		if !from.IsZero() && e.Time.Before(from) {
+			continue
+		}
+
+		if !to.IsZero() && !e.Time.Before(to) {
+			continue
+		}
+
+		selected = append(selected, e)
+	}
+
+	return selected
+}
+
+func listExchanges(w io.Writer, exchanges []recorder.Exchange) error {
+	for _, e := range exchanges {
+		outcome := fmt.Sprintf("%d %d bytes", e.Status, e.BodySize)
+		if e.Error != "" {
+			outcome = "error: " + e.Error
+		} else if e.BodyTruncated || (e.BodySize > 0 && len(e.Body) == 0) {
+			outcome += fmt.Sprintf(" (%d recorded)", len(e.Body))
+		}
+
+		if _, err := fmt.Fprintf(w, "%s %s %s %s in %s\n",
+			e.Time.Format(time.RFC3339Nano), e.Method, e.URL, outcome, e.Duration); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
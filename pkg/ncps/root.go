@@ -12,15 +12,15 @@ import (
 	"sync"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/rs/zerolog"
-	"github.com/urfave/cli-altsrc/v3/json"
-	"github.com/urfave/cli-altsrc/v3/toml"
-	"github.com/urfave/cli-altsrc/v3/yaml"
 	"github.com/urfave/cli/v3"
 	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
 
 	altsrc "github.com/urfave/cli-altsrc/v3"
 
+	"github.com/kalbasit/ncps/pkg/configfile"
 	"github.com/kalbasit/ncps/pkg/otelzerolog"
 	"github.com/kalbasit/ncps/pkg/xz"
 )
@@ -67,6 +67,7 @@ const (
 	flagNameRedisPoolSize         = "cache-redis-pool-size"
 	flagNameLockBackend           = "cache-lock-backend"
 	flagNameLockRedisKeyPrefix    = "cache-lock-redis-key-prefix"
+	flagNameLockFileDir           = "cache-lock-file-dir"
 	flagNameLockDownloadTTL       = "cache-lock-download-ttl"
 	flagNameLockLRUTTL            = "cache-lock-lru-ttl"
 	flagNameLockMaxRetries        = "cache-lock-retry-max-attempts"
@@ -75,6 +76,10 @@ const (
 	flagNameLockJitter            = "cache-lock-retry-jitter"
 	flagNameLockAllowDegraded     = "cache-lock-allow-degraded-mode"
 
+	flagNameStorageInlineThreshold  = "cache-storage-inline-threshold"
+	flagUsageStorageInlineThreshold = "Store NARs of at most this many bytes in the database instead of the " +
+		"storage backend (0 disables, at most 65536)"
+
 	// Flag usage strings.
 	flagUsageStorageLocal       = "The local data path used for configuration and cache storage (use this OR S3 storage)"
 	flagUsageCacheTempPath      = "The path to the temporary directory that is used by the cache to download NAR files"
@@ -91,8 +96,9 @@ const (
 	flagUsageRedisPassword      = "Redis password"
 	flagUsageRedisDB            = "Redis database number"
 	flagUsageRedisTLS           = "Use TLS for Redis connections"
-	flagUsageLockBackend        = "Lock backend to use: 'local' (single instance) or 'redis' (distributed)"
+	flagUsageLockBackend        = "Lock backend to use: 'local' (single instance), 'redis' or 'file' (distributed)"
 	flagUsageLockRedisKeyPrefix = "Prefix for all Redis lock keys (only used when Redis is configured)"
+	flagUsageLockFileDir        = "Directory shared by the instances holding the locks of the 'file' lock backend"
 	flagUsageLockDownloadTTL    = "TTL for download locks (per-hash locks)"
 	flagUsageLockLRUTTL         = "TTL for LRU lock (global exclusive lock)"
 	flagUsageLockAllowDegraded  = "Allow falling back to local locks if Redis is unavailable" +
@@ -117,17 +123,27 @@ type shutdownFn func(context.Context) error
 
 func New() (*cli.Command, error) {
 	var (
-		configPath  string
-		shutdownFns = make(map[string]shutdownFn)
+		configPath   string
+		shutdownFns  = make(map[string]shutdownFn)
+		configSchema = newConfigSchema()
 	)
 
+	// The configuration file may be written in TOML, YAML or JSON (read as
+	// YAML); the environment variable references of its values are expanded.
+	tomlUnmarshal := configfile.Unmarshaler(toml.Unmarshal, os.LookupEnv)
+	yamlUnmarshal := configfile.Unmarshaler(yaml.Unmarshal, os.LookupEnv)
+
 	flagSources := func(configFileKey, envVar string) cli.ValueSourceChain {
-		return cli.NewValueSourceChain(
-			toml.TOML(configFileKey, altsrc.NewStringPtrSourcer(&configPath)),
-			yaml.YAML(configFileKey, altsrc.NewStringPtrSourcer(&configPath)),
-			json.JSON(configFileKey, altsrc.NewStringPtrSourcer(&configPath)),
+		sources := cli.NewValueSourceChain(
+			altsrc.NewValueSource(tomlUnmarshal, "toml", configFileKey, altsrc.NewStringPtrSourcer(&configPath)),
+			altsrc.NewValueSource(yamlUnmarshal, "yaml", configFileKey, altsrc.NewStringPtrSourcer(&configPath)),
+			altsrc.NewValueSource(yamlUnmarshal, "json", configFileKey, altsrc.NewStringPtrSourcer(&configPath)),
 			cli.EnvVar(envVar),
 		)
+
+		configSchema.record(configFileKey, sources)
+
+		return sources
 	}
 
 	registerShutdown := func(name string, sfn shutdownFn) { shutdownFns[name] = sfn }
@@ -141,6 +157,9 @@ func New() (*cli.Command, error) {
 		Name:    "ncps",
 		Usage:   "Nix Binary Cache Proxy Service",
 		Version: Version,
+		// Never let cli exit the process on an error carrying an exit code: main
+		// maps the returned error to one with ExitCode.
+		ExitErrHandler: func(context.Context, *cli.Command, error) {},
 		After: func(ctx context.Context, _ *cli.Command) error {
 			var wg sync.WaitGroup
 
@@ -170,6 +189,13 @@ func New() (*cli.Command, error) {
 				return ctx, err
 			}
 
+			// Fail early on a configuration file the flags would silently
+			// ignore in part: unknown keys, unset environment variables or a
+			// syntax error.
+			if _, err := configSchema.loadConfigFile(configPath); err != nil {
+				return ctx, err
+			}
+
 			if cmd.Bool("use-xz-binary") {
 				p := cmd.String("xz-binary-path")
 				if p == "" {
@@ -191,6 +217,9 @@ func New() (*cli.Command, error) {
 				xz.UseInternal()
 			}
 
+			xz.SetThreads(cmd.Int("xz-threads"))
+			xz.SetWorkerBudget(cmd.Int("xz-worker-budget"))
+
 			return ctx, nil
 		},
 		Flags: []cli.Flag{
@@ -283,6 +312,19 @@ func New() (*cli.Command, error) {
 				Sources: flagSources("use-xz-binary", "USE_XZ_BINARY"),
 				Value:   true,
 			},
+			&cli.IntFlag{
+				Name: "xz-threads",
+				Usage: "Threads each decompression by the xz binary uses; only NARs compressed in several " +
+					"blocks are decompressed in parallel (0 = one per CPU)",
+				Sources: flagSources("xz-threads", "XZ_THREADS"),
+				Value:   1,
+			},
+			&cli.IntFlag{
+				Name: "xz-worker-budget",
+				Usage: "Threads shared by all the xz decompressions running at once, such as the NARs of a " +
+					"migration; a decompression waits for a free one (0 = unbounded)",
+				Sources: flagSources("xz-worker-budget", "XZ_WORKER_BUDGET"),
+			},
 		},
 		Commands: []*cli.Command{
 			serveCommand(userDirs, flagSources, registerShutdown),
@@ -290,13 +332,46 @@ func New() (*cli.Command, error) {
 			migrateNarInfoCommand(flagSources, registerShutdown),
 			migrateNarToChunksCommand(flagSources, registerShutdown),
 			migrateChunksToNarCommand(flagSources, registerShutdown),
+			migrateNarToSeekableZstdCommand(flagSources, registerShutdown),
 			fsckCommand(flagSources, registerShutdown),
+			verifyCommand(flagSources, registerShutdown),
+			checkServerCommand(),
+			benchCommand(),
+			replayCommand(),
+			adminCommand(),
+			exportStaticCommand(flagSources, registerShutdown),
+			configCommand(configSchema),
 		},
 	}
 
 	return c, nil
 }
 
+// ExitCode returns the process exit code for err, the error returned by
+// running the command: 0 when nil, the code carried by err if any, 1 otherwise.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var ec cli.ExitCoder
+	if errors.As(err, &ec) {
+		return ec.ExitCode()
+	}
+
+	return 1
+}
+
+// exitCodeError is an error asking for a specific process exit code.
+type exitCodeError struct {
+	err  error
+	code int
+}
+
+func (e *exitCodeError) Error() string { return e.err.Error() }
+func (e *exitCodeError) Unwrap() error { return e.err }
+func (e *exitCodeError) ExitCode() int { return e.code }
+
 func getZeroLogger(ctx context.Context, cmd *cli.Command) (context.Context, error) {
 	logLvl := cmd.String("log-level")
 
@@ -0,0 +1,117 @@
+package ncps
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// secretFilePrefix marks a secret flag value naming the file holding the
+// secret rather than the secret itself.
+const secretFilePrefix = "file://"
+
+// ErrSecretFileEmpty is returned when a secret file is empty.
+var ErrSecretFileEmpty = errors.New("secret file is empty")
+
+// secretSources extends the sources of a flag holding a secret so that, for
+// each of its environment variables, <VAR>_FILE may name the file holding the
+// secret. Read the flag with secretValue.
+func secretSources(sources cli.ValueSourceChain) cli.ValueSourceChain {
+	for _, envVar := range sources.EnvKeys() {
+		sources.Chain = append(sources.Chain, &fileEnvValueSource{key: envVar + "_FILE"})
+	}
+
+	return sources
+}
+
+// fileEnvValueSource is a cli.ValueSource for an environment variable holding
+// the path of a secret file; it yields a file:// value for secretValue.
+type fileEnvValueSource struct {
+	key string
+}
+
+func (s *fileEnvValueSource) Lookup() (string, bool) {
+	path, ok := os.LookupEnv(s.key)
+	if !ok || path == "" {
+		return "", false
+	}
+
+	return secretFilePrefix + path, true
+}
+
+func (s *fileEnvValueSource) IsFromEnv() bool { return true }
+
+func (s *fileEnvValueSource) Key() string { return s.key }
+
+func (s *fileEnvValueSource) String() string {
+	return fmt.Sprintf("file named by environment variable %q", s.key)
+}
+
+func (s *fileEnvValueSource) GoString() string {
+	return fmt.Sprintf("&fileEnvValueSource{key:%[1]q}", s.key)
+}
+
+// secretValue returns the value of the secret flag name. A value of the form
+// file:///path/to/secret is replaced with the content of that file, minus its
+// trailing newline, so secrets can be mounted as files instead of being passed
+// on the command line or in the environment.
+func secretValue(cmd *cli.Command, name string) (string, error) {
+	return resolveSecret(name, cmd.String(name))
+}
+
+func resolveSecret(name, value string) (string, error) {
+	path, ok := strings.CutPrefix(value, secretFilePrefix)
+	if !ok {
+		return value, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading the secret of --%s: %w", name, err)
+	}
+
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%w: --%s from %s", ErrSecretFileEmpty, name, path)
+	}
+
+	return secret, nil
+}
+
+// secretSliceValue returns the values of the repeatable flag name holding
+// credentials, such as "Name: value" headers. A value of the form
+// file:///path/to/values is replaced with the non-empty lines of that file.
+func secretSliceValue(cmd *cli.Command, name string) ([]string, error) {
+	var values []string
+
+	for _, value := range nonEmpty(cmd.StringSlice(name)) {
+		path, ok := strings.CutPrefix(value, secretFilePrefix)
+		if !ok {
+			values = append(values, value)
+
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the secret of --%s: %w", name, err)
+		}
+
+		n := len(values)
+
+		for line := range strings.Lines(string(data)) {
+			if line = strings.TrimSpace(line); line != "" {
+				values = append(values, line)
+			}
+		}
+
+		if len(values) == n {
+			return nil, fmt.Errorf("%w: --%s from %s", ErrSecretFileEmpty, name, path)
+		}
+	}
+
+	return values, nil
+}
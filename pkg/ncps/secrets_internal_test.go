@@ -0,0 +1,149 @@
+package ncps
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+)
+
+func TestResolveSecret(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	secretPath := filepath.Join(dir, "secret")
+	require.NoError(t, os.WriteFile(secretPath, []byte("hunter2\n"), 0o600))
+
+	emptyPath := filepath.Join(dir, "empty")
+	require.NoError(t, os.WriteFile(emptyPath, []byte("\n"), 0o600))
+
+	t.Run("literal values are returned as-is", func(t *testing.T) {
+		t.Parallel()
+
+		secret, err := resolveSecret("cache-get-token", "hunter2")
+		require.NoError(t, err)
+		assert.Equal(t, "hunter2", secret)
+	})
+
+	t.Run("file values are read without their trailing newline", func(t *testing.T) {
+		t.Parallel()
+
+		secret, err := resolveSecret("cache-get-token", secretFilePrefix+secretPath)
+		require.NoError(t, err)
+		assert.Equal(t, "hunter2", secret)
+	})
+
+	t.Run("missing files are an error", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := resolveSecret("cache-get-token", secretFilePrefix+filepath.Join(dir, "missing"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("empty files are an error", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := resolveSecret("cache-get-token", secretFilePrefix+emptyPath)
+		require.ErrorIs(t, err, ErrSecretFileEmpty)
+	})
+}
+
+func runSecretCommand(t *testing.T) string {
+	t.Helper()
+
+	var secret string
+
+	cmd := &cli.Command{
+		Name: "ncps",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "token",
+				Sources: secretSources(cli.EnvVars("NCPS_TEST_TOKEN")),
+			},
+		},
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			var err error
+
+			secret, err = secretValue(cmd, "token")
+
+			return err
+		},
+	}
+
+	require.NoError(t, cmd.Run(t.Context(), []string{"ncps"}))
+
+	return secret
+}
+
+//nolint:paralleltest // uses t.Setenv
+func TestSecretSources(t *testing.T) {
+	secretPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(secretPath, []byte("hunter2\n"), 0o600))
+
+	t.Setenv("NCPS_TEST_TOKEN_FILE", secretPath)
+
+	t.Run("the _FILE variable names the secret file", func(t *testing.T) {
+		assert.Equal(t, "hunter2", runSecretCommand(t))
+	})
+
+	t.Run("the variable takes precedence over its _FILE variant", func(t *testing.T) {
+		t.Setenv("NCPS_TEST_TOKEN", "literal")
+
+		assert.Equal(t, "literal", runSecretCommand(t))
+	})
+}
+
+//nolint:paralleltest // uses t.Setenv
+func TestSecretSliceValue(t *testing.T) {
+	headersPath := filepath.Join(t.TempDir(), "headers")
+	require.NoError(t, os.WriteFile(headersPath, []byte("Authorization: Bearer hunter2\n\nX-Team: ci\n"), 0o600))
+
+	run := func(t *testing.T, args ...string) ([]string, error) {
+		t.Helper()
+
+		var values []string
+
+		cmd := &cli.Command{
+			Name: "ncps",
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:    "header",
+					Sources: secretSources(cli.EnvVars("NCPS_TEST_HEADERS")),
+				},
+			},
+			Action: func(_ context.Context, cmd *cli.Command) error {
+				var err error
+
+				values, err = secretSliceValue(cmd, "header")
+
+				return err
+			},
+		}
+
+		return values, cmd.Run(t.Context(), append([]string{"ncps"}, args...))
+	}
+
+	t.Run("file values are replaced with the lines of the file", func(t *testing.T) {
+		values, err := run(t, "--header", "X-Other: 1", "--header", secretFilePrefix+headersPath)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"X-Other: 1", "Authorization: Bearer hunter2", "X-Team: ci"}, values)
+	})
+
+	t.Run("the _FILE variable names the file", func(t *testing.T) {
+		t.Setenv("NCPS_TEST_HEADERS_FILE", headersPath)
+
+		values, err := run(t)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Authorization: Bearer hunter2", "X-Team: ci"}, values)
+	})
+
+	t.Run("missing files are an error", func(t *testing.T) {
+		_, err := run(t, "--header", secretFilePrefix+headersPath+".missing")
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
@@ -6,15 +6,19 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/nix-community/go-nix/pkg/narinfo/signature"
 	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog"
@@ -25,32 +29,48 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+	filelock "github.com/kalbasit/ncps/pkg/lock/file"
 	s3config "github.com/kalbasit/ncps/pkg/s3"
 	localstorage "github.com/kalbasit/ncps/pkg/storage/local"
 	storageS3 "github.com/kalbasit/ncps/pkg/storage/s3"
 	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
 	netpprof "net/http/pprof"
 
+	"github.com/kalbasit/ncps/pkg/accesslog"
 	"github.com/kalbasit/ncps/pkg/analytics"
 	"github.com/kalbasit/ncps/pkg/cache"
+	"github.com/kalbasit/ncps/pkg/cache/prewarm"
 	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/cache/upstream/discovery"
+	"github.com/kalbasit/ncps/pkg/cache/upstream/ipfs"
+	"github.com/kalbasit/ncps/pkg/cache/upstream/recorder"
 	"github.com/kalbasit/ncps/pkg/config"
 	"github.com/kalbasit/ncps/pkg/database"
+	"github.com/kalbasit/ncps/pkg/database/softlimit"
+	"github.com/kalbasit/ncps/pkg/faultinject"
 	"github.com/kalbasit/ncps/pkg/helper"
 	"github.com/kalbasit/ncps/pkg/lock"
 	"github.com/kalbasit/ncps/pkg/lock/local"
 	"github.com/kalbasit/ncps/pkg/lock/redis"
 	"github.com/kalbasit/ncps/pkg/maxprocs"
+	"github.com/kalbasit/ncps/pkg/narinfocache"
 	"github.com/kalbasit/ncps/pkg/otel"
 	"github.com/kalbasit/ncps/pkg/prometheus"
+	"github.com/kalbasit/ncps/pkg/proxy"
 	"github.com/kalbasit/ncps/pkg/server"
+	"github.com/kalbasit/ncps/pkg/signer"
 	"github.com/kalbasit/ncps/pkg/storage"
 	"github.com/kalbasit/ncps/pkg/storage/chunk"
+	"github.com/kalbasit/ncps/pkg/storage/inline"
+	"github.com/kalbasit/ncps/pkg/zstd"
 )
 
 var (
-	// ErrCacheMaxSizeRequired is returned if --cache-lru-schedule was given but not --cache-max-size.
-	ErrCacheMaxSizeRequired = errors.New("--cache-max-size is required when --cache-lru-schedule is specified")
+	// ErrCacheMaxSizeRequired is returned if --cache-lru-schedule was given but neither --cache-max-size
+	// nor --cache-cdc-store-max-size.
+	ErrCacheMaxSizeRequired = errors.New(
+		"--cache-max-size or --cache-cdc-store-max-size is required when --cache-lru-schedule is specified",
+	)
 
 	// ErrStorageConfigRequired is returned if neither local nor S3 storage is configured.
 	ErrStorageConfigRequired = errors.New("either --cache-storage-local or --cache-storage-s3-bucket is required")
@@ -62,12 +82,75 @@ var (
 	// ErrStorageConflict is returned if both local and S3 storage are configured.
 	ErrStorageConflict = errors.New("cannot use both --cache-storage-local and --cache-storage-s3-bucket")
 
+	// ErrPrewarmPathListURLInvalid is returned if a --cache-prewarm-path-list-url
+	// is not an http(s) URL.
+	ErrPrewarmPathListURLInvalid = errors.New("the pre-warm path list URL must be an http(s) URL")
+
+	// ErrChannelPrefetchURLInvalid is returned if a --cache-channel-prefetch-url
+	// is not an http(s) URL.
+	ErrChannelPrefetchURLInvalid = errors.New("the channel pre-fetch URL must be an http(s) URL")
+
+	// ErrInvalidPublicKeyURL is returned if a --cache-upstream-public-key-url
+	// is not of the form host=URL.
+	ErrInvalidPublicKeyURL = errors.New("public key URL must be of the form host=URL")
+
+	// ErrInvalidUpstreamHeader is returned if a --cache-upstream-header is not
+	// of the form "Name: value" or a --cache-upstream-host-header is not of the
+	// form "host=Name: value".
+	ErrInvalidUpstreamHeader = errors.New(`the upstream header must be of the form "Name: value"`)
+
+	// ErrInvalidShadowMinAgreement is returned if
+	// --cache-upstream-shadow-min-agreement is not between 0 and 1.
+	ErrInvalidShadowMinAgreement = errors.New("the minimum agreement must be between 0 and 1")
+
+	// ErrInvalidAccessLogHeader is returned if a
+	// --server-access-log-export-header is not of the form "Name: value".
+	ErrInvalidAccessLogHeader = errors.New(`the access log header must be of the form "Name: value"`)
+
+	// ErrInvalidStoreDirRewrite is returned if --cache-store-dir-rewrite is not
+	// of the form FROM=TO.
+	ErrInvalidStoreDirRewrite = errors.New("the store dir rewrite must be of the form FROM=TO")
+
+	// ErrInvalidSigningBackend is returned if --cache-signing-backend is not a
+	// known backend.
+	ErrInvalidSigningBackend = errors.New("invalid signing backend")
+
+	// ErrSigningBackendConfig is returned if the flags of the signing backend
+	// are missing or conflicting.
+	ErrSigningBackendConfig = errors.New("invalid signing backend configuration")
+
+	// ErrInvalidLRUDeleteConcurrency is returned if
+	// --cache-lru-delete-concurrency is less than 1.
+	ErrInvalidLRUDeleteConcurrency = errors.New("the LRU delete concurrency must be at least 1")
+
+	// ErrInvalidTouchRecordAge is returned if --cache-touch-record-age is
+	// negative.
+	ErrInvalidTouchRecordAge = errors.New("the touch record age must not be negative")
+
+	// ErrInvalidRowLimit is returned if a --cache-database-soft-limit-rows is
+	// not of the form TABLE=ROWS.
+	ErrInvalidRowLimit = errors.New("invalid row limit")
+
 	// ErrUpstreamCacheRequired is returned if no upstream cache is configured.
-	ErrUpstreamCacheRequired = errors.New("at least one --cache-upstream-url is required")
+	ErrUpstreamCacheRequired = errors.New(
+		"at least one --cache-upstream-url or --cache-upstream-discovery is required",
+	)
 
 	// ErrRedisAddrsRequired is returned when Redis backend is selected but no addresses are provided.
 	ErrRedisAddrsRequired = errors.New("--cache-lock-backend=redis requires --cache-redis-addrs to be set")
 
+	// ErrNarInfoMetadataCacheRedisRequired is returned when the narinfo
+	// metadata cache is enabled without a Redis address.
+	ErrNarInfoMetadataCacheRedisRequired = errors.New(
+		"--cache-narinfo-metadata-cache-ttl requires --cache-redis-addrs to be set",
+	)
+
+	// ErrLockFileDirRequired is returned when the file backend is selected
+	// without a lock directory nor a local storage to default it to.
+	ErrLockFileDirRequired = errors.New(
+		"--cache-lock-backend=file requires --cache-lock-file-dir or --cache-storage-local to be set",
+	)
+
 	// ErrUnknownLockBackend is returned when an unknown lock backend is specified.
 	ErrUnknownLockBackend = errors.New("unknown lock backend")
 
@@ -80,6 +163,13 @@ var (
 		"--cache-cdc-lazy-recovery-schedule is required when CDC is enabled",
 	)
 
+	// ErrChunkTieringColdStoreRequired is returned when the chunk tiering is
+	// scheduled without a cold chunk storage.
+	ErrChunkTieringColdStoreRequired = errors.New(
+		"--cache-cdc-tiering-schedule requires --cache-storage-chunks-cold-local " +
+			"or --cache-storage-chunks-cold-s3-bucket",
+	)
+
 	// ErrStagingRetentionNonPositive is returned when in-flight staging is enabled
 	// with a non-positive retention grace period.
 	ErrStagingRetentionNonPositive = errors.New("--cache-inflight-staging-retention must be greater than 0")
@@ -87,11 +177,25 @@ var (
 	// ErrStagingPartSizeNonPositive is returned when in-flight staging is enabled
 	// with a non-positive part size.
 	ErrStagingPartSizeNonPositive = errors.New("--cache-inflight-staging-part-size must be greater than 0")
+
+	// ErrPresignedRedirectRequiresS3 is returned when presigned NAR redirects are
+	// configured without S3 storage for the nars.
+	ErrPresignedRedirectRequiresS3 = errors.New(
+		"--cache-storage-s3-presigned-redirect-network requires --cache-storage-s3-bucket " +
+			"or --cache-storage-nar-s3-bucket",
+	)
+
+	// ErrPresignedRedirectExpiryInvalid is returned when the presigned URL expiry
+	// is outside the range accepted by S3.
+	ErrPresignedRedirectExpiryInvalid = errors.New(
+		"--cache-storage-s3-presigned-redirect-expiry must be between 1s and 168h",
+	)
 )
 
 const (
 	lockBackendLocal = "local"
 	lockBackendRedis = "redis"
+	lockBackendFile  = "file"
 
 	storageTypeLocal = "local"
 	storageTypeS3    = "s3"
@@ -100,6 +204,10 @@ const (
 	storageModeWhole = "whole"
 
 	configValueTrue = "true"
+
+	signingBackendLocal  = "local"
+	signingBackendVault  = "vault"
+	signingBackendAWSKMS = "aws-kms"
 )
 
 // parseNetrcFile parses the netrc file and returns the parsed netrc object.
@@ -128,7 +236,7 @@ func serveCommand(
 		Aliases: []string{"s"},
 		Usage:   "serve the nix binary cache over http",
 		Action:  serveAction(registerShutdown),
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.BoolFlag{
 				Name:    "cache-allow-delete-verb",
 				Usage:   "Whether to allow the DELETE verb to delete narInfo and nar files",
@@ -139,12 +247,19 @@ func serveCommand(
 				Usage:   "Whether to allow the PUT verb to push narInfo and nar files directly",
 				Sources: flagSources("cache.allow-put-verb", "CACHE_ALLOW_PUT_VERB"),
 			},
+			&cli.BoolFlag{
+				Name: "cache-status-headers",
+				Usage: "Whether to add the X-Ncps-Cache, X-Ncps-Upstream and X-Ncps-Store headers " +
+					"telling clients how each narinfo and nar was served",
+				Sources: flagSources("cache.status-headers", "CACHE_STATUS_HEADERS"),
+				Value:   true,
+			},
 			&cli.StringFlag{
 				Name: "cache-get-token",
 				Usage: "Bearer token required to access GET and HEAD routes. When set, requests without a " +
 					"matching Authorization: Bearer <token> header are rejected with 401 Unauthorized. " +
 					"/healthz and /metrics are always exempt.",
-				Sources: flagSources("cache.get-token", "CACHE_GET_TOKEN"),
+				Sources: secretSources(flagSources("cache.get-token", "CACHE_GET_TOKEN")),
 			},
 			&cli.StringFlag{
 				Name:     "cache-hostname",
@@ -176,18 +291,56 @@ func serveCommand(
 			&cli.StringFlag{
 				Name:    flagNameS3AccessKeyID,
 				Usage:   flagUsageS3AccessKeyID,
-				Sources: flagSources("cache.storage.s3.access-key-id", "CACHE_STORAGE_S3_ACCESS_KEY_ID"),
+				Sources: secretSources(flagSources("cache.storage.s3.access-key-id", "CACHE_STORAGE_S3_ACCESS_KEY_ID")),
 			},
 			&cli.StringFlag{
 				Name:    flagNameS3SecretKey,
 				Usage:   flagUsageS3SecretKey,
-				Sources: flagSources("cache.storage.s3.secret-access-key", "CACHE_STORAGE_S3_SECRET_ACCESS_KEY"),
+				Sources: secretSources(flagSources("cache.storage.s3.secret-access-key", "CACHE_STORAGE_S3_SECRET_ACCESS_KEY")),
 			},
 			&cli.BoolFlag{
 				Name:    flagNameS3ForcePathStyle,
 				Usage:   "Force path-style S3 addressing (required for self-hosted S3 servers like Garage; optional for AWS S3)",
 				Sources: flagSources("cache.storage.s3.force-path-style", "CACHE_STORAGE_S3_FORCE_PATH_STYLE"),
 			},
+			&cli.IntFlag{
+				Name:    flagNameStorageInlineThreshold,
+				Usage:   flagUsageStorageInlineThreshold,
+				Sources: flagSources("cache.storage.inline-threshold", "CACHE_STORAGE_INLINE_THRESHOLD"),
+			},
+			&cli.StringSliceFlag{
+				Name: "cache-storage-s3-presigned-redirect-network",
+				Usage: "CIDR network (e.g., 10.0.0.0/8) whose clients are answered with a 302 redirect to a " +
+					"presigned S3 URL for NAR downloads instead of ncps proxying the bytes (repeatable)",
+				Sources: flagSources(
+					"cache.storage.s3.presigned-redirect.networks",
+					"CACHE_STORAGE_S3_PRESIGNED_REDIRECT_NETWORKS",
+				),
+			},
+			&cli.DurationFlag{
+				Name:  "cache-storage-s3-presigned-redirect-expiry",
+				Usage: "How long a presigned S3 NAR URL stays valid (at most 7 days)",
+				Sources: flagSources(
+					"cache.storage.s3.presigned-redirect.expiry",
+					"CACHE_STORAGE_S3_PRESIGNED_REDIRECT_EXPIRY",
+				),
+				Value: 5 * time.Minute,
+			},
+			&cli.BoolFlag{
+				Name: flagNameStorageLocalInventory,
+				Usage: "Answer whether a NAR or a chunk is stored locally from an in-memory inventory, saved to a " +
+					"manifest and rebuilt in the background, instead of a stat (for slow network filesystems)",
+				Sources: flagSources("cache.storage.local-inventory.enabled", "CACHE_STORAGE_LOCAL_INVENTORY_ENABLED"),
+			},
+			&cli.DurationFlag{
+				Name:  flagNameStorageLocalInventoryRebuildInterval,
+				Usage: "How often the local inventory is rebuilt by walking the store (0 rebuilds it on startup only)",
+				Sources: flagSources(
+					"cache.storage.local-inventory.rebuild-interval",
+					"CACHE_STORAGE_LOCAL_INVENTORY_REBUILD_INTERVAL",
+				),
+				Value: 24 * time.Hour,
+			},
 			// CDC Flags
 			&cli.BoolFlag{
 				Name:    "cache-cdc-enabled",
@@ -258,10 +411,47 @@ func serveCommand(
 					return err
 				},
 			},
+			&cli.IntFlag{
+				Name: "cache-zstd-chunk-window-log",
+				Usage: "Log2 of the zstd window compressing the CDC chunks, from 10 to 27 " +
+					"(0 = the encoder default of 8 MiB)",
+				Sources:   flagSources("cache.zstd.chunk.window-log", "CACHE_ZSTD_CHUNK_WINDOW_LOG"),
+				Validator: validateZstdWindowLog,
+			},
+			&cli.BoolFlag{
+				Name:    "cache-zstd-chunk-long",
+				Usage:   "Compress the CDC chunks with zstd long distance matching, at the better level",
+				Sources: flagSources("cache.zstd.chunk.long", "CACHE_ZSTD_CHUNK_LONG"),
+			},
+			&cli.IntFlag{
+				Name: "cache-zstd-nar-window-log",
+				Usage: "Log2 of the zstd window compressing whole NARs, from 10 to 27 " +
+					"(0 = the encoder default of 8 MiB, or 27 with long distance matching)",
+				Sources:   flagSources("cache.zstd.nar.window-log", "CACHE_ZSTD_NAR_WINDOW_LOG"),
+				Validator: validateZstdWindowLog,
+			},
+			&cli.BoolFlag{
+				Name: "cache-zstd-nar-long",
+				Usage: "Compress whole NARs with zstd long distance matching over a 128 MiB window, " +
+					"at the better level, trading memory for the ratio of large NARs",
+				Sources: flagSources("cache.zstd.nar.long", "CACHE_ZSTD_NAR_LONG"),
+			},
+			&cli.Int64Flag{
+				Name: "cache-zstd-nar-sample-size",
+				Usage: "Bytes of each uncompressed NAR compressed to sample its ratio before recompressing it, " +
+					"a NAR under the minimum ratio being stored raw (0 = disabled)",
+				Sources: flagSources("cache.zstd.nar.sample-size", "CACHE_ZSTD_NAR_SAMPLE_SIZE"),
+			},
+			&cli.FloatFlag{
+				Name:    "cache-zstd-nar-min-ratio",
+				Usage:   "Minimum compression ratio of the sample of an uncompressed NAR for it to be recompressed",
+				Sources: flagSources("cache.zstd.nar.min-ratio", "CACHE_ZSTD_NAR_MIN_RATIO"),
+				Value:   1.1,
+			},
 			&cli.StringFlag{
 				Name:     flagNameDBURL,
 				Usage:    flagUsageDBURL,
-				Sources:  flagSources("cache.database-url", "CACHE_DATABASE_URL"),
+				Sources:  secretSources(flagSources("cache.database-url", "CACHE_DATABASE_URL")),
 				Required: true,
 			},
 			&cli.IntFlag{
@@ -274,6 +464,97 @@ func serveCommand(
 				Usage:   "Maximum number of idle connections in the pool (0 = use database-specific defaults)",
 				Sources: flagSources("cache.database.pool.max-idle-conns", "CACHE_DATABASE_POOL_MAX_IDLE_CONNS"),
 			},
+			&cli.IntFlag{
+				Name: "cache-database-sqlite-wal-autocheckpoint",
+				Usage: "Number of pages the SQLite WAL grows to before it is checkpointed automatically " +
+					"(0 = the SQLite default of 1000)",
+				Sources: flagSources("cache.database.sqlite.wal-autocheckpoint", "CACHE_DATABASE_SQLITE_WAL_AUTOCHECKPOINT"),
+			},
+			&cli.StringFlag{
+				Name: "cache-database-sqlite-journal-size-limit",
+				Usage: "Size the SQLite WAL is truncated to after a checkpoint, e.g. 64M " +
+					"(empty = never truncated)",
+				Sources: flagSources("cache.database.sqlite.journal-size-limit", "CACHE_DATABASE_SQLITE_JOURNAL_SIZE_LIMIT"),
+				Validator: func(s string) error {
+					_, err := helper.ParseSize(s)
+
+					return err
+				},
+			},
+			&cli.StringFlag{
+				Name: "cache-database-sqlite-maintenance-schedule",
+				Usage: "The cron spec for checkpointing the SQLite WAL, vacuuming the free pages and refreshing " +
+					"the statistics; the first run on a database without the incremental vacuum rebuilds it",
+				Sources: flagSources(
+					"cache.database.sqlite.maintenance.schedule",
+					"CACHE_DATABASE_SQLITE_MAINTENANCE_SCHEDULE",
+				),
+				Validator: func(s string) error {
+					_, err := cron.ParseStandard(s)
+
+					return err
+				},
+			},
+			&cli.IntFlag{
+				Name:  "cache-database-sqlite-maintenance-vacuum-pages",
+				Usage: "Maximum number of free pages the SQLite maintenance vacuums per run (0 = all)",
+				Sources: flagSources(
+					"cache.database.sqlite.maintenance.vacuum-pages",
+					"CACHE_DATABASE_SQLITE_MAINTENANCE_VACUUM_PAGES",
+				),
+			},
+			&cli.StringFlag{
+				Name:  "cache-database-soft-limit-size",
+				Usage: "Warn when the database grows over this size, e.g. 10G (empty = disabled)",
+				Sources: flagSources(
+					"cache.database.soft-limits.size",
+					"CACHE_DATABASE_SOFT_LIMITS_SIZE",
+				),
+				Validator: func(s string) error {
+					_, err := helper.ParseSize(s)
+
+					return err
+				},
+			},
+			&cli.StringSliceFlag{
+				Name:  "cache-database-soft-limit-rows",
+				Usage: "Warn when a table holds more rows than its limit, as TABLE=ROWS, e.g. narinfos=10000000",
+				Sources: flagSources(
+					"cache.database.soft-limits.rows",
+					"CACHE_DATABASE_SOFT_LIMITS_ROWS",
+				),
+				Validator: func(ss []string) error {
+					_, err := parseRowLimits(ss)
+
+					return err
+				},
+			},
+			&cli.FloatFlag{
+				Name: "cache-database-soft-limit-bloat-ratio",
+				Usage: "Warn when the reclaimable share of the database, free pages or dead tuples, " +
+					"grows over this ratio, e.g. 0.3 (0 = disabled)",
+				Sources: flagSources(
+					"cache.database.soft-limits.bloat-ratio",
+					"CACHE_DATABASE_SOFT_LIMITS_BLOAT_RATIO",
+				),
+			},
+			&cli.DurationFlag{
+				Name:  "cache-database-soft-limit-check-interval",
+				Usage: "How often the database is checked against its soft limits",
+				Sources: flagSources(
+					"cache.database.soft-limits.check-interval",
+					"CACHE_DATABASE_SOFT_LIMITS_CHECK_INTERVAL",
+				),
+				Value: softlimit.DefaultInterval,
+			},
+			&cli.StringFlag{
+				Name:  "cache-database-soft-limit-webhook-url",
+				Usage: "URL receiving a JSON POST when a database soft limit is exceeded or resolved",
+				Sources: secretSources(flagSources(
+					"cache.database.soft-limits.webhook-url",
+					"CACHE_DATABASE_SOFT_LIMITS_WEBHOOK_URL",
+				)),
+			},
 			&cli.StringFlag{
 				Name: "cache-max-size",
 				//nolint:lll
@@ -296,24 +577,215 @@ func serveCommand(
 					return err
 				},
 			},
+			&cli.StringSliceFlag{
+				Name: "cache-lru-exclude",
+				Usage: "A store path pattern the LRU never evicts (repeatable): a glob matched against the " +
+					"store path name (e.g. *-nixos-system-*), or a regular expression matched against the " +
+					"whole store path when prefixed with regex:",
+				Sources: flagSources("cache.lru.exclude", "CACHE_LRU_EXCLUDE"),
+			},
+			&cli.IntFlag{
+				Name:    "cache-lru-delete-concurrency",
+				Usage:   "The number of files the LRU deletes from the stores at once",
+				Sources: flagSources("cache.lru.delete-concurrency", "CACHE_LRU_DELETE_CONCURRENCY"),
+				Value:   32,
+				Validator: func(n int) error {
+					if n < 1 {
+						return ErrInvalidLRUDeleteConcurrency
+					}
+
+					return nil
+				},
+			},
 			&cli.StringFlag{
 				Name:    "cache-lru-schedule-timezone",
 				Usage:   "The name of the timezone to use for the cron",
 				Sources: flagSources("cache.lru.timezone", "CACHE_LRU_SCHEDULE_TZ"),
 				Value:   "Local",
 			},
+			&cli.StringFlag{
+				Name: "cache-touch-mode",
+				Usage: "How the last access time of the records served, which drives the LRU, is refreshed: " +
+					"sync (in the serving transaction), async (in the background) or off (never, for read-only " +
+					"replicas)",
+				Sources: flagSources("cache.touch.mode", "CACHE_TOUCH_MODE"),
+				Value:   string(cache.TouchModeSync),
+				Validator: func(s string) error {
+					_, err := cache.ParseTouchMode(s)
+
+					return err
+				},
+			},
+			&cli.DurationFlag{
+				Name: "cache-touch-record-age",
+				Usage: "Do not refresh the last access time of a record accessed within this duration, sparing " +
+					"the database writes",
+				Sources: flagSources("cache.touch.record-age", "CACHE_TOUCH_RECORD_AGE"),
+				Value:   5 * time.Minute,
+				Validator: func(d time.Duration) error {
+					if d < 0 {
+						return ErrInvalidTouchRecordAge
+					}
+
+					return nil
+				},
+			},
+			&cli.StringSliceFlag{
+				Name: "cache-maintenance-window",
+				Usage: "A window, in the cron timezone, during which the maintenance jobs may run, as " +
+					"[DAYS ]HH:MM-HH:MM, e.g. \"Mon-Fri 01:00-05:00\" (repeatable). Without one they run at any time.",
+				Sources: flagSources("cache.maintenance.windows", "CACHE_MAINTENANCE_WINDOWS"),
+				Validator: func(windows []string) error {
+					for _, w := range windows {
+						if _, err := cache.ParseMaintenanceWindow(w); err != nil {
+							return err
+						}
+					}
+
+					return nil
+				},
+			},
+			&cli.StringSliceFlag{
+				Name:    "cache-maintenance-job",
+				Usage:   "A cron job restricted to the maintenance windows (repeatable)",
+				Sources: flagSources("cache.maintenance.jobs", "CACHE_MAINTENANCE_JOBS"),
+				Value: []string{
+					cache.CronJobLRU,
+					cache.CronJobCDCDeletedCleanup,
+					cache.CronJobCDCLazyRecovery,
+					cache.CronJobSQLiteMaintenance,
+					cache.CronJobChunkTiering,
+					cache.CronJobNarInfoFileHashBackfill,
+				},
+				Validator: func(jobs []string) error {
+					for _, job := range jobs {
+						if !slices.Contains(cache.CronJobNames(), job) {
+							return fmt.Errorf("%w: %q", cache.ErrCronJobNotFound, job)
+						}
+					}
+
+					return nil
+				},
+			},
 			&cli.StringFlag{
 				Name: "cache-secret-key-path",
 				Usage: "The path to the secret key used for signing cached paths. " +
 					"If set, it will be stored in the database if different.",
 				Sources: flagSources("cache.secret-key-path", "CACHE_SECRET_KEY_PATH"),
 			},
+			&cli.StringFlag{
+				Name: "cache-signing-backend",
+				Usage: "Where the signing key is held: local (the secret key), vault (a Vault transit key) " +
+					"or aws-kms (an AWS KMS key); with vault and aws-kms the private key never leaves the service",
+				Sources: flagSources("cache.signing.backend", "CACHE_SIGNING_BACKEND"),
+				Value:   signingBackendLocal,
+			},
+			&cli.StringFlag{
+				Name:    "cache-signing-vault-address",
+				Usage:   "The URL of the Vault server holding the signing key",
+				Sources: flagSources("cache.signing.vault.address", "CACHE_SIGNING_VAULT_ADDRESS"),
+			},
+			&cli.StringFlag{
+				Name:    "cache-signing-vault-token",
+				Usage:   "The token authenticating to Vault",
+				Sources: secretSources(flagSources("cache.signing.vault.token", "CACHE_SIGNING_VAULT_TOKEN")),
+			},
+			&cli.StringFlag{
+				Name:    "cache-signing-vault-mount",
+				Usage:   "The mount path of the Vault transit secrets engine",
+				Sources: flagSources("cache.signing.vault.mount", "CACHE_SIGNING_VAULT_MOUNT"),
+				Value:   "transit",
+			},
+			&cli.StringFlag{
+				Name:    "cache-signing-vault-key",
+				Usage:   "The name of the Ed25519 Vault transit key",
+				Sources: flagSources("cache.signing.vault.key", "CACHE_SIGNING_VAULT_KEY"),
+			},
+			&cli.StringFlag{
+				Name:    "cache-signing-aws-kms-key-id",
+				Usage:   "The ID, ARN or alias of the Ed25519 AWS KMS key",
+				Sources: flagSources("cache.signing.aws-kms.key-id", "CACHE_SIGNING_AWS_KMS_KEY_ID"),
+			},
+			&cli.StringFlag{
+				Name:    "cache-signing-aws-kms-region",
+				Usage:   "The AWS region of the KMS key",
+				Sources: flagSources("cache.signing.aws-kms.region", "CACHE_SIGNING_AWS_KMS_REGION"),
+			},
+			&cli.StringFlag{
+				Name:    "cache-signing-aws-kms-endpoint",
+				Usage:   "Override the AWS KMS endpoint of the region, e.g. for a VPC endpoint",
+				Sources: flagSources("cache.signing.aws-kms.endpoint", "CACHE_SIGNING_AWS_KMS_ENDPOINT"),
+			},
+			&cli.StringFlag{
+				Name: "cache-signing-aws-kms-access-key-id",
+				Usage: "The access key ID authenticating to AWS KMS; the AWS environment, " +
+					"shared credentials file and instance role are used when not set",
+				Sources: secretSources(flagSources("cache.signing.aws-kms.access-key-id", "CACHE_SIGNING_AWS_KMS_ACCESS_KEY_ID")),
+			},
+			&cli.StringFlag{
+				Name:  "cache-signing-aws-kms-secret-access-key",
+				Usage: "The secret access key authenticating to AWS KMS",
+				Sources: secretSources(
+					flagSources("cache.signing.aws-kms.secret-access-key", "CACHE_SIGNING_AWS_KMS_SECRET_ACCESS_KEY"),
+				),
+			},
 			&cli.BoolFlag{
 				Name:    "cache-sign-narinfo",
 				Usage:   "Whether to sign narInfo files or passthru as-is from upstream",
 				Sources: flagSources("cache.sign-narinfo", "CACHE_SIGN_NARINFO"),
 				Value:   true,
 			},
+			&cli.StringFlag{
+				Name: "cache-store-dir-rewrite",
+				Usage: "Serve the narinfos with their store path moved from one store dir to another, " +
+					"as FROM=TO (e.g. /nix/store=/opt/nix/store), for clients using a non-default store; " +
+					"uploads under TO are stored under FROM",
+				Sources: flagSources("cache.store-dir-rewrite", "CACHE_STORE_DIR_REWRITE"),
+				Validator: func(s string) error {
+					_, _, err := parseStoreDirRewrite(s)
+
+					return err
+				},
+			},
+			&cli.StringSliceFlag{
+				Name: "cache-narinfo-field",
+				Usage: "Set a field on every narinfo served, as NAME=VALUE (repeatable): Deriver, System and CA " +
+					"are overridden, or removed by an empty value, and other fields are added",
+				Sources: flagSources("cache.narinfo-fields", "CACHE_NARINFO_FIELDS"),
+				Validator: func(ss []string) error {
+					for _, s := range ss {
+						if _, err := cache.ParseNarInfoField(s); err != nil {
+							return err
+						}
+					}
+
+					return nil
+				},
+			},
+			&cli.IntFlag{
+				Name:    "cache-priority",
+				Usage:   "Priority of the cache advertised by nix-cache-info; Nix prefers the substituters of lower priority",
+				Sources: flagSources("cache.priority", "CACHE_PRIORITY"),
+				Value:   server.DefaultPriority,
+			},
+			&cli.BoolFlag{
+				Name: "cache-narinfo-aliases",
+				Usage: "Link a narinfo pulled from upstream whose NarHash is that of a stored NAR to that NAR, " +
+					"served under its URL, instead of downloading the same NAR again",
+				Sources: flagSources("cache.narinfo-aliases", "CACHE_NARINFO_ALIASES"),
+			},
+			&cli.IntFlag{
+				Name: "cache-narinfo-warm-up-count",
+				Usage: "Number of the most recently accessed narinfos preloaded in memory at startup; " +
+					"/readyz answers 503 until they are loaded (0 disables the warm-up)",
+				Sources: flagSources("cache.narinfo-warm-up.count", "CACHE_NARINFO_WARM_UP_COUNT"),
+			},
+			&cli.DurationFlag{
+				Name:    "cache-narinfo-warm-up-ttl",
+				Usage:   "How long a preloaded narinfo is served from memory before it is read from the database again",
+				Sources: flagSources("cache.narinfo-warm-up.ttl", "CACHE_NARINFO_WARM_UP_TTL"),
+				Value:   10 * time.Minute,
+			},
 			&cli.BoolFlag{
 				Name: "cache-require-trusted-signature",
 				Usage: "Reject narinfos uploaded via PUT that do not carry a signature trusted " +
@@ -335,9 +807,15 @@ func serveCommand(
 				Sources: flagSources("cache.temp-path", "CACHE_TEMP_PATH"),
 				Value:   os.TempDir(),
 			},
+			&cli.BoolFlag{
+				Name: "offline",
+				Usage: "Serve only the cached narinfos and NARs, never contacting the upstreams, e.g. when " +
+					"air-gapped or during an upstream outage (switchable at runtime through the admin API)",
+				Sources: flagSources("offline", "OFFLINE"),
+			},
 			&cli.StringSliceFlag{
 				Name:    "cache-upstream-url",
-				Usage:   "Set to URL (with scheme) for each upstream cache",
+				Usage:   "Set to URL (with scheme) for each upstream cache, or to the path of a local directory holding one",
 				Sources: flagSources("cache.upstream.urls", "CACHE_UPSTREAM_URLS"),
 				// TODO: Once --upstream-cache is removed, mark this as required and
 				// remove the custom validation block below.
@@ -348,856 +826,2823 @@ func serveCommand(
 				Usage:   "Set to host:public-key for each upstream cache",
 				Sources: flagSources("cache.upstream.public-keys", "CACHE_UPSTREAM_PUBLIC_KEYS"),
 			},
-			&cli.DurationFlag{
-				Name:    "cache-upstream-dialer-timeout",
-				Usage:   "Timeout for establishing TCP connections to upstream caches (e.g., 3s, 5s, 10s)",
-				Sources: flagSources("cache.upstream.dialer-timeout", "CACHE_UPSTREAM_DIALER_TIMEOUT"),
-				Value:   3 * time.Second,
+			&cli.BoolFlag{
+				Name: "cache-upstream-import-public-keys",
+				Usage: "Import the public key of every upstream without a configured one, from its /pubkey (as " +
+					"served by ncps) or --cache-upstream-public-key-url, and record it in the database; later " +
+					"starts trust the recorded key",
+				Sources: flagSources("cache.upstream.import-public-keys", "CACHE_UPSTREAM_IMPORT_PUBLIC_KEYS"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "cache-upstream-public-key-url",
+				Usage:   "Set to host=URL to import the public key of the upstream at host from URL; can be repeated",
+				Sources: flagSources("cache.upstream.public-key-urls", "CACHE_UPSTREAM_PUBLIC_KEY_URLS"),
+			},
+			&cli.StringSliceFlag{
+				Name: "cache-upstream-shadow-url",
+				Usage: "Set to the URL of a new upstream cache to query in shadow mode: its narinfo answers are " +
+					"compared with the ones served, but never served, until its trial is passed; can be repeated",
+				Sources: flagSources("cache.upstream.shadow.urls", "CACHE_UPSTREAM_SHADOW_URLS"),
 			},
 			&cli.DurationFlag{
-				Name:    "cache-upstream-response-header-timeout",
-				Usage:   "Timeout for waiting for upstream server's response headers (e.g., 3s, 5s, 10s)",
-				Sources: flagSources("cache.upstream.response-header-timeout", "CACHE_UPSTREAM_RESPONSE_HEADER_TIMEOUT"),
-				Value:   3 * time.Second,
+				Name: "cache-upstream-shadow-trial-period",
+				Usage: "How long a shadow upstream is only compared before it may be promoted to selection " +
+					"(0 never promotes it automatically)",
+				Sources: flagSources("cache.upstream.shadow.trial-period", "CACHE_UPSTREAM_SHADOW_TRIAL_PERIOD"),
+				Value:   7 * 24 * time.Hour,
+			},
+			&cli.IntFlag{
+				Name:    "cache-upstream-shadow-min-comparisons",
+				Usage:   "Narinfo answers of a shadow upstream compared before it may be promoted to selection",
+				Sources: flagSources("cache.upstream.shadow.min-comparisons", "CACHE_UPSTREAM_SHADOW_MIN_COMPARISONS"),
+				Value:   1000,
+			},
+			&cli.FloatFlag{
+				Name: "cache-upstream-shadow-min-agreement",
+				Usage: "Ratio, between 0 and 1, of the answers of a shadow upstream agreeing with the ones served " +
+					"required for it to be promoted to selection",
+				Sources: flagSources("cache.upstream.shadow.min-agreement", "CACHE_UPSTREAM_SHADOW_MIN_AGREEMENT"),
+				Value:   0.99,
 			},
 			&cli.StringFlag{
-				Name:    "netrc-file",
-				Usage:   "Path to netrc file for upstream authentication",
-				Sources: flagSources("cache.netrc-file", "NETRC_FILE"),
-				Value:   filepath.Join(userDirs.homeDir, ".netrc"),
+				Name:    "cache-upstream-user-agent",
+				Usage:   "The User-Agent of the requests to the upstream caches (default: ncps/<version>)",
+				Sources: flagSources("cache.upstream.user-agent", "CACHE_UPSTREAM_USER_AGENT"),
+			},
+			&cli.StringSliceFlag{
+				Name: "cache-upstream-header",
+				Usage: `Set to "Name: value" to send a header with the requests to every upstream; can be repeated. ` +
+					"A file:// value, or header value, names the file holding the headers, or the value",
+				Sources: secretSources(flagSources("cache.upstream.headers", "CACHE_UPSTREAM_HEADERS")),
+			},
+			&cli.StringSliceFlag{
+				Name: "cache-upstream-host-header",
+				Usage: `Set to "host=Name: value" to send a header with the requests to the upstream at host, ` +
+					"overriding a --cache-upstream-header of the same name; can be repeated. A file:// value, " +
+					"or header value, names the file holding the headers, or the value",
+				Sources: secretSources(flagSources("cache.upstream.host-headers", "CACHE_UPSTREAM_HOST_HEADERS")),
+			},
+			&cli.BoolFlag{
+				Name:    "cache-upstream-forward-client-ip",
+				Usage:   "Send the address of the client a NAR or narinfo is fetched for as X-Forwarded-For to the upstreams",
+				Sources: flagSources("cache.upstream.forward-client-ip", "CACHE_UPSTREAM_FORWARD_CLIENT_IP"),
+			},
+			&cli.StringSliceFlag{
+				Name: "cache-upstream-discovery",
+				Usage: "Discover upstream caches at runtime from a DNS SRV record " +
+					"(dns+srv://_nix-cache._tcp.example.com?scheme=https) or an HTTP(S) endpoint serving JSON " +
+					`({"upstreams":[{"url":"...","public_keys":["..."]}]}); can be repeated`,
+				Sources: flagSources("cache.upstream.discovery.sources", "CACHE_UPSTREAM_DISCOVERY"),
 			},
 			&cli.StringFlag{
-				Name:    "server-addr",
-				Usage:   "The address of the server",
-				Sources: flagSources("server.addr", "SERVER_ADDR"),
-				Value:   ":8501",
+				Name:    "cache-upstream-discovery-schedule",
+				Usage:   "The cron spec for refreshing the discovered upstream caches",
+				Sources: flagSources("cache.upstream.discovery.schedule", "CACHE_UPSTREAM_DISCOVERY_SCHEDULE"),
+				Value:   "@every 1m",
 			},
 			&cli.StringFlag{
-				Name:    "pprof-addr",
-				Usage:   "Address to listen on for pprof profiling endpoints (e.g. :6060). Empty disables pprof.",
-				Sources: flagSources("pprof.addr", "PPROF_ADDR"),
-				Value:   "",
+				Name: "cache-upstream-bloom-schedule",
+				Usage: "The cron spec for fetching the bloom filter of the narinfos held by the upstreams that " +
+					"serve one (peer ncps instances with a narinfo index), which are then only asked for the " +
+					"narinfos it does not rule out (empty disables it). A narinfo a peer cached since it built its filter " +
+					"is not asked for until the filter is built and fetched again",
+				Sources: flagSources("cache.upstream.bloom.schedule", "CACHE_UPSTREAM_BLOOM_SCHEDULE"),
 			},
-
-			// Redis Configuration (optional - for distributed locking in HA deployments)
 			&cli.StringSliceFlag{
-				Name:    flagNameRedisAddrs,
-				Usage:   "Redis server addresses for distributed locking (e.g., localhost:6379). If not set, local locks are used.",
-				Sources: flagSources("cache.redis.addrs", "CACHE_REDIS_ADDRS"),
+				Name: "cache-prewarm-flake",
+				Usage: "Flake installable whose closure is pre-warmed on --cache-prewarm-schedule " +
+					"(evaluated with nix eval --raw; can be repeated)",
+				Sources: flagSources("cache.prewarm.flakes", "CACHE_PREWARM_FLAKES"),
 			},
-			&cli.StringFlag{
-				Name:    flagNameRedisUsername,
-				Usage:   "Redis username for authentication (for Redis ACL)",
-				Sources: flagSources("cache.redis.username", "CACHE_REDIS_USERNAME"),
+			&cli.StringSliceFlag{
+				Name: "cache-prewarm-path-list-url",
+				Usage: "URL serving a pre-computed list of store paths, one per line, whose closures are " +
+					"pre-warmed on --cache-prewarm-schedule (can be repeated)",
+				Sources: flagSources("cache.prewarm.path-list-urls", "CACHE_PREWARM_PATH_LIST_URLS"),
 			},
 			&cli.StringFlag{
-				Name:    flagNameRedisPassword,
-				Usage:   "Redis password for authentication",
-				Sources: flagSources("cache.redis.password", "CACHE_REDIS_PASSWORD"),
+				Name:    "cache-prewarm-schedule",
+				Usage:   "The cron spec for pre-warming the configured closures (uses --cache-lru-schedule-timezone)",
+				Sources: flagSources("cache.prewarm.schedule", "CACHE_PREWARM_SCHEDULE"),
+				Value:   "0 5 * * *",
 			},
 			&cli.IntFlag{
-				Name:    flagNameRedisDB,
-				Usage:   "Redis database number (0-15)",
-				Sources: flagSources("cache.redis.db", "CACHE_REDIS_DB"),
-				Value:   0,
+				Name:    "cache-prewarm-concurrency",
+				Usage:   "Number of store paths pre-warmed or channel pre-fetched in parallel",
+				Sources: flagSources("cache.prewarm.concurrency", "CACHE_PREWARM_CONCURRENCY"),
+				Value:   4,
 			},
-			&cli.BoolFlag{
-				Name:    flagNameRedisTLS,
-				Usage:   "Use TLS for Redis connection",
-				Sources: flagSources("cache.redis.use-tls", "CACHE_REDIS_USE_TLS"),
+			&cli.StringFlag{
+				Name:    "cache-prewarm-nix-bin",
+				Usage:   "Path to the nix binary used to evaluate --cache-prewarm-flake",
+				Sources: flagSources("cache.prewarm.nix-bin", "CACHE_PREWARM_NIX_BIN"),
+				Value:   "nix",
 			},
-			&cli.IntFlag{
-				Name:    flagNameRedisPoolSize,
-				Usage:   flagUsageRedisPoolSize,
-				Sources: flagSources("cache.redis.pool-size", "CACHE_REDIS_POOL_SIZE"),
-				Value:   10,
+			&cli.StringSliceFlag{
+				Name: "cache-channel-prefetch-url",
+				Usage: "Nix channel URL (e.g. https://channels.nixos.org/nixos-unstable) whose releases are " +
+					"pre-fetched from their store-paths.xz when the channel moves (can be repeated)",
+				Sources: flagSources("cache.channel-prefetch.urls", "CACHE_CHANNEL_PREFETCH_URLS"),
 			},
-
 			&cli.StringFlag{
-				Name:    flagNameLockBackend,
-				Usage:   flagUsageLockBackend,
-				Sources: flagSources("cache.lock.backend", "CACHE_LOCK_BACKEND"),
-				Value:   "local",
+				Name:    "cache-channel-prefetch-schedule",
+				Usage:   "The cron spec for checking the channels for a new release",
+				Sources: flagSources("cache.channel-prefetch.schedule", "CACHE_CHANNEL_PREFETCH_SCHEDULE"),
+				Value:   "@every 15m",
 			},
-			// Lock Configuration
 			&cli.StringFlag{
-				Name:    flagNameLockRedisKeyPrefix,
-				Usage:   flagUsageLockRedisKeyPrefix,
-				Sources: flagSources("cache.lock.redis.key-prefix", "CACHE_LOCK_REDIS_KEY_PREFIX"),
-				Value:   "ncps:lock:",
+				Name: "cache-channel-prefetch-max-nar-size",
+				Usage: "Skip channel paths whose compressed NAR is larger than this size (e.g. 10M); " +
+					"empty pre-fetches every path",
+				Sources: flagSources("cache.channel-prefetch.max-nar-size", "CACHE_CHANNEL_PREFETCH_MAX_NAR_SIZE"),
+				Value:   "10M",
 			},
-			&cli.DurationFlag{
-				Name:    flagNameLockDownloadTTL,
-				Usage:   flagUsageLockDownloadTTL,
-				Sources: flagSources("cache.lock.download-lock-ttl", "CACHE_LOCK_DOWNLOAD_TTL"),
-				Value:   5 * time.Minute,
+			&cli.StringFlag{
+				Name: "cache-narinfo-index-schedule",
+				Usage: "The cron spec for rebuilding the narinfo index served at /narinfo-index.zst, the hashes " +
+					"of all cached narinfos (empty disables the index)",
+				Sources: flagSources("cache.narinfo-index.schedule", "CACHE_NARINFO_INDEX_SCHEDULE"),
 			},
-			&cli.DurationFlag{
-				Name:    flagNameLockLRUTTL,
-				Usage:   flagUsageLockLRUTTL,
-				Sources: flagSources("cache.lock.lru-lock-ttl", "CACHE_LOCK_LRU_TTL"),
-				Value:   30 * time.Minute,
+			&cli.StringFlag{
+				Name: "cache-narinfo-file-hash-backfill-schedule",
+				Usage: "The cron spec for computing the FileHash and FileSize missing from the narinfos " +
+					"of the compressed NARs from the stored NARs (empty disables the backfill)",
+				Sources: flagSources(
+					"cache.narinfo-file-hash-backfill.schedule",
+					"CACHE_NARINFO_FILE_HASH_BACKFILL_SCHEDULE",
+				),
+			},
+			&cli.IntFlag{
+				Name: "cache-reference-prefetch-concurrency",
+				Usage: "Number of narinfos referenced by served narinfos that are prefetched in parallel, " +
+					"metadata only, so the client's follow-up requests are warm (0 disables)",
+				Sources: flagSources("cache.reference-prefetch.concurrency", "CACHE_REFERENCE_PREFETCH_CONCURRENCY"),
 			},
 			&cli.DurationFlag{
-				Name:    "cache-download-poll-timeout",
-				Usage:   "Timeout for polling storage when waiting for download completion by another server",
-				Sources: flagSources("cache.download.poll-timeout", "CACHE_DOWNLOAD_POLL_TIMEOUT"),
-				Value:   30 * time.Second,
+				Name:    "cache-reference-prefetch-ttl",
+				Usage:   "How long a prefetched narinfo is kept waiting for the client to request it",
+				Sources: flagSources("cache.reference-prefetch.ttl", "CACHE_REFERENCE_PREFETCH_TTL"),
+				Value:   time.Minute,
+			},
+			&cli.StringFlag{
+				Name: "cache-missing-references-policy",
+				Usage: "How a narinfo whose references are not cached is served: ignore, annotate (count them " +
+					"in the metrics), prefetch (pull them and their NARs in the background) or strict (pull them " +
+					"and delay the response until their narinfos are cached)",
+				Sources: flagSources("cache.missing-references.policy", "CACHE_MISSING_REFERENCES_POLICY"),
+				Value:   string(cache.MissingReferencesIgnore),
+			},
+			&cli.IntFlag{
+				Name:    "cache-missing-references-concurrency",
+				Usage:   "Number of missing references pulled in parallel by the prefetch and strict policies",
+				Sources: flagSources("cache.missing-references.concurrency", "CACHE_MISSING_REFERENCES_CONCURRENCY"),
+				Value:   8,
 			},
 			&cli.DurationFlag{
-				Name: "cache-cdc-chunk-wait-timeout",
-				Usage: "Max time progressive CDC streaming waits for the next chunk before failing the " +
-					"transfer. Keep it below your reverse-proxy gateway timeout so a stalled chunk on " +
-					"high-latency storage surfaces as a retryable error instead of a gateway 504.",
-				Sources: flagSources("cache.cdc.chunk-wait-timeout", "CACHE_CDC_CHUNK_WAIT_TIMEOUT"),
+				Name:    "cache-missing-references-timeout",
+				Usage:   "How long the strict policy delays a narinfo waiting for its references to be cached",
+				Sources: flagSources("cache.missing-references.timeout", "CACHE_MISSING_REFERENCES_TIMEOUT"),
 				Value:   30 * time.Second,
 			},
-			// In-flight NAR staging flags (change serve-whole-nar-in-flight).
 			&cli.BoolFlag{
-				Name: "cache-inflight-staging-enabled",
-				Usage: "Serve a NAR cross-pod while it is still downloading by staging it to shared " +
-					"storage as part-objects once another replica waits for it. Off by default; only " +
-					"active with a distributed (Redis) lock. An HA-safe alternative to CDC.",
-				Sources: flagSources("cache.inflight-staging.enabled", "CACHE_INFLIGHT_STAGING_ENABLED"),
-				Value:   false,
+				Name: "cache-migrate-legacy-layout",
+				Usage: "Move the NARs stored by older versions of ncps under a legacy path, and re-key their " +
+					"database records, in the background at startup",
+				Sources: flagSources("cache.migrate-legacy-layout", "CACHE_MIGRATE_LEGACY_LAYOUT"),
+				Value:   true,
 			},
 			&cli.DurationFlag{
-				Name: "cache-inflight-staging-retention",
-				Usage: "Grace period to retain in-flight staging part-objects after the NAR's final " +
-					"representation is committed, so in-flight readers drain before reclamation.",
-				Sources: flagSources("cache.inflight-staging.retention", "CACHE_INFLIGHT_STAGING_RETENTION"),
-				Value:   5 * time.Minute,
+				Name: "cache-narinfo-revalidate-after",
+				Usage: "Revalidate a cached narinfo against its upstream once it is older than this " +
+					"(e.g. 24h). 0 disables the revalidation.",
+				Sources: flagSources("cache.narinfo-revalidation.after", "CACHE_NARINFO_REVALIDATE_AFTER"),
+			},
+			&cli.DurationFlag{
+				Name: "cache-narinfo-stale-while-revalidate",
+				Usage: "How long past --cache-narinfo-revalidate-after a narinfo is still served immediately " +
+					"while it is revalidated in the background. Older narinfos wait for the revalidation.",
+				Sources: flagSources(
+					"cache.narinfo-revalidation.stale-while-revalidate",
+					"CACHE_NARINFO_STALE_WHILE_REVALIDATE",
+				),
+			},
+			&cli.StringFlag{
+				Name: "cache-narinfo-compression",
+				Usage: "The compression advertised by the narinfos ncps rewrites (chunked NARs and uncompressed " +
+					"upstream NARs): none, zstd (once the NAR is chunked or stored as zstd) or keep-upstream " +
+					"(the upstream's compression until the NAR is chunked)",
+				Sources: flagSources("cache.narinfo-compression", "CACHE_NARINFO_COMPRESSION"),
+				Value:   string(cache.NarInfoCompressionNone),
 			},
 			&cli.IntFlag{
-				Name: "cache-inflight-staging-part-size",
-				Usage: "Size in bytes of each in-flight staging part-object (transport unit, distinct " +
-					"from CDC chunk sizes).",
-				Sources: flagSources("cache.inflight-staging.part-size", "CACHE_INFLIGHT_STAGING_PART_SIZE"),
-				Value:   8 << 20,
+				Name: "cache-narinfo-purge-max-per-minute",
+				Usage: "The maximum number of narinfos purged per minute, guarding against a misfiring " +
+					"storage check purging the cache. 0 disables the limit.",
+				Sources: flagSources("cache.narinfo-purge.max-per-minute", "CACHE_NARINFO_PURGE_MAX_PER_MINUTE"),
+			},
+			&cli.BoolFlag{
+				Name: "cache-narinfo-purge-quarantine",
+				Usage: "Keep and log the narinfos purged over --cache-narinfo-purge-max-per-minute instead " +
+					"of delaying their purge",
+				Sources: flagSources("cache.narinfo-purge.quarantine", "CACHE_NARINFO_PURGE_QUARANTINE"),
+			},
+			&cli.BoolFlag{
+				Name: "cache-narinfo-invalidation-enabled",
+				Usage: "Notify the other replicas of the narinfos stored or deleted with PostgreSQL LISTEN/NOTIFY, " +
+					"so that they drop them from their in-memory state (PostgreSQL only)",
+				Sources: flagSources("cache.narinfo-invalidation.enabled", "CACHE_NARINFO_INVALIDATION_ENABLED"),
+			},
+			&cli.DurationFlag{
+				Name: "cache-narinfo-metadata-cache-ttl",
+				Usage: "Cache the narinfos read from the database in the first of --cache-redis-addrs for this " +
+					"long, shared by the replicas and invalidated when a narinfo is stored or deleted (0 disables it)",
+				Sources: flagSources("cache.narinfo-metadata-cache.ttl", "CACHE_NARINFO_METADATA_CACHE_TTL"),
+			},
+			&cli.StringFlag{
+				Name:    "cache-narinfo-metadata-cache-key-prefix",
+				Usage:   "Prefix of the Redis keys of the narinfo metadata cache",
+				Sources: flagSources("cache.narinfo-metadata-cache.key-prefix", "CACHE_NARINFO_METADATA_CACHE_KEY_PREFIX"),
+				Value:   narinfocache.DefaultKeyPrefix,
+			},
+			&cli.DurationFlag{
+				Name:    "cache-upstream-dialer-timeout",
+				Usage:   "Timeout for establishing TCP connections to upstream caches (e.g., 3s, 5s, 10s)",
+				Sources: flagSources("cache.upstream.dialer-timeout", "CACHE_UPSTREAM_DIALER_TIMEOUT"),
+				Value:   3 * time.Second,
+			},
+			&cli.DurationFlag{
+				Name: "cache-upstream-dns-cache-ttl",
+				Usage: "Cache the addresses of the upstream hosts for this long, whatever the TTL of their DNS " +
+					"records, instead of resolving them for every new connection (0 disables the cache)",
+				Sources: flagSources("cache.upstream.dns-cache.ttl", "CACHE_UPSTREAM_DNS_CACHE_TTL"),
+			},
+			&cli.DurationFlag{
+				Name: "cache-upstream-dns-cache-max-stale",
+				Usage: "Keep using the cached addresses of an upstream host for this long past their TTL " +
+					"when resolving it fails, to survive short resolver outages",
+				Sources: flagSources("cache.upstream.dns-cache.max-stale", "CACHE_UPSTREAM_DNS_CACHE_MAX_STALE"),
+				Value:   5 * time.Minute,
+			},
+			&cli.DurationFlag{
+				Name:    "cache-upstream-response-header-timeout",
+				Usage:   "Timeout for waiting for upstream server's response headers (e.g., 3s, 5s, 10s)",
+				Sources: flagSources("cache.upstream.response-header-timeout", "CACHE_UPSTREAM_RESPONSE_HEADER_TIMEOUT"),
+				Value:   3 * time.Second,
 			},
 			&cli.IntFlag{
-				Name:    flagNameLockMaxRetries,
-				Usage:   flagUsageLockMaxRetries,
-				Sources: flagSources("cache.lock.retry.max-attempts", "CACHE_LOCK_RETRY_MAX_ATTEMPTS"),
+				Name:    "cache-upstream-retry-attempts",
+				Usage:   "Maximum attempts of an upstream narinfo or NAR request, the first included (1 disables retries)",
+				Sources: flagSources("cache.upstream.retry.attempts", "CACHE_UPSTREAM_RETRY_ATTEMPTS"),
 				Value:   3,
 			},
 			&cli.DurationFlag{
-				Name:    flagNameLockInitialDelay,
-				Usage:   flagUsageLockInitialDelay,
-				Sources: flagSources("cache.lock.retry.initial-delay", "CACHE_LOCK_RETRY_INITIAL_DELAY"),
+				Name: "cache-upstream-retry-per-try-timeout",
+				Usage: "Timeout of each upstream attempt until its response headers arrive; a slower attempt is " +
+					"retried (0 to only rely on the dialer and response header timeouts)",
+				Sources: flagSources("cache.upstream.retry.per-try-timeout", "CACHE_UPSTREAM_RETRY_PER_TRY_TIMEOUT"),
+			},
+			&cli.DurationFlag{
+				Name:    "cache-upstream-retry-backoff",
+				Usage:   "Delay before the first upstream retry; it doubles per retry, with jitter",
+				Sources: flagSources("cache.upstream.retry.backoff", "CACHE_UPSTREAM_RETRY_BACKOFF"),
 				Value:   100 * time.Millisecond,
 			},
 			&cli.DurationFlag{
-				Name:    flagNameLockMaxDelay,
-				Usage:   flagUsageLockMaxDelay,
-				Sources: flagSources("cache.lock.retry.max-delay", "CACHE_LOCK_RETRY_MAX_DELAY"),
+				Name:    "cache-upstream-retry-max-backoff",
+				Usage:   "Maximum delay between two upstream retries",
+				Sources: flagSources("cache.upstream.retry.max-backoff", "CACHE_UPSTREAM_RETRY_MAX_BACKOFF"),
 				Value:   2 * time.Second,
 			},
-			&cli.BoolFlag{
-				Name:    flagNameLockJitter,
-				Usage:   flagUsageLockJitter,
-				Sources: flagSources("cache.lock.retry.jitter", "CACHE_LOCK_RETRY_JITTER"),
-				Value:   true,
+			&cli.FloatFlag{
+				Name: "cache-upstream-retry-budget",
+				Usage: "Maximum upstream retries as a fraction of the requests to that upstream, after a burst " +
+					"of 10 (e.g. 0.2; 0 for unlimited)",
+				Sources: flagSources("cache.upstream.retry.budget", "CACHE_UPSTREAM_RETRY_BUDGET"),
 			},
-			&cli.BoolFlag{
-				Name:    flagNameLockAllowDegraded,
-				Usage:   flagUsageLockAllowDegraded,
-				Sources: flagSources("cache.lock.allow-degraded-mode", "CACHE_LOCK_ALLOW_DEGRADED_MODE"),
+			&cli.StringFlag{
+				Name: "cache-upstream-fetch-strategy",
+				Usage: "How a NAR is fetched from the upstreams: select (ask every upstream and download from the " +
+					"first to answer) or race (download from the top two healthy upstreams at once and keep the " +
+					"first to deliver a byte)",
+				Sources: flagSources("cache.upstream.fetch-strategy", "CACHE_UPSTREAM_FETCH_STRATEGY"),
+				Value:   string(cache.UpstreamFetchStrategySelect),
+			},
+			&cli.DurationFlag{
+				Name: "cache-upstream-narinfo-hedge-delay",
+				Usage: "Hedge narinfo probes: probe the upstreams in priority order and the next one when the " +
+					"current one has not answered within its p95 latency, or this delay until that latency is " +
+					"known (0 probes every upstream at once)",
+				Sources: flagSources("cache.upstream.narinfo-hedge-delay", "CACHE_UPSTREAM_NARINFO_HEDGE_DELAY"),
 			},
-
-			// DEPRECATED FLAGS BELOW
-
 			&cli.StringFlag{
-				Name:    "cache-data-path",
-				Usage:   "DEPRECATED: Use --cache-storage-local instead.",
-				Sources: flagSources("cache.data-path", "CACHE_DATA_PATH"),
+				Name: "cache-upstream-narinfo-conflict-policy",
+				Usage: "Narinfo served when several upstreams have one for the same hash: fastest (the first " +
+					"found), first-healthy (the upstream of the highest priority), prefer-signed-by (signed by a " +
+					"preferred key) or prefer-smallest (the smallest download); all but fastest fetch the narinfo " +
+					"from every upstream and log the conflicts",
+				Sources: flagSources("cache.upstream.narinfo-conflict.policy", "CACHE_UPSTREAM_NARINFO_CONFLICT_POLICY"),
+				Value:   string(cache.NarInfoConflictPolicyFastest),
+				Validator: func(s string) error {
+					_, err := cache.ParseNarInfoConflictPolicy(s)
+
+					return err
+				},
 			},
 			&cli.StringSliceFlag{
-				Name:    "upstream-cache",
-				Usage:   "DEPRECATED: Use --cache-upstream-url instead.",
-				Sources: flagSources("cache.upstream.caches", "UPSTREAM_CACHES"),
+				Name: "cache-upstream-narinfo-prefer-signed-by",
+				Usage: "Public key preferred by the prefer-signed-by narinfo conflict policy, as NAME:BASE64 " +
+					"(repeatable, in order of preference)",
+				Sources: flagSources(
+					"cache.upstream.narinfo-conflict.prefer-signed-by",
+					"CACHE_UPSTREAM_NARINFO_PREFER_SIGNED_BY",
+				),
+			},
+			&cli.StringFlag{
+				Name: "cache-upstream-nar-size-check",
+				Usage: "Check the NARs downloaded from the upstreams against the size advertised by their narinfo: " +
+					"off, warn (log and count the mismatches) or reject (abort the download, so the NAR is not stored)",
+				Sources: flagSources("cache.upstream.nar-size-check.mode", "CACHE_UPSTREAM_NAR_SIZE_CHECK"),
+				Value:   string(cache.NarSizeCheckWarn),
+			},
+			&cli.FloatFlag{
+				Name: "cache-upstream-nar-size-tolerance",
+				Usage: "Share of the advertised size a NAR may differ by before it is a mismatch, e.g. 0.01 " +
+					"(0 = exact size)",
+				Sources: flagSources(
+					"cache.upstream.nar-size-check.tolerance",
+					"CACHE_UPSTREAM_NAR_SIZE_TOLERANCE",
+				),
+			},
+			&cli.StringFlag{
+				Name: "cache-upstream-narinfo-url-policy",
+				Usage: "What is done with an upstream narinfo whose URL is not relative to the upstream (an absolute " +
+					"URL to another host, or a path escaping it): normalize (fetch nar/<file name> from the upstream), " +
+					"reject (skip the narinfo) or allow (pass the URL through unchanged)",
+				Sources: flagSources("cache.upstream.narinfo-url-policy", "CACHE_UPSTREAM_NARINFO_URL_POLICY"),
+				Value:   string(upstream.NarInfoURLPolicyNormalize),
 			},
 			&cli.StringSliceFlag{
-				Name:    "upstream-public-key",
-				Usage:   "DEPRECATED: Use --cache-upstream-public-key instead.",
-				Sources: cli.EnvVars("UPSTREAM_PUBLIC_KEYS"),
+				Name: "cache-upstream-ipfs-gateway",
+				Usage: "Set to the URL of an IPFS gateway, e.g. https://ipfs.io, to fetch the NARs no upstream " +
+					"serves by their content hash as a last resort, for the NARs of up to 1 MiB stored as a single raw " +
+					"block; can be repeated, tried in order",
+				Sources: flagSources("cache.upstream.ipfs.gateways", "CACHE_UPSTREAM_IPFS_GATEWAYS"),
 			},
-			&cli.DurationFlag{
-				Name:    "upstream-dialer-timeout",
-				Usage:   "DEPRECATED: Use --cache-upstream-dialer-timeout instead.",
-				Sources: cli.EnvVars("UPSTREAM_DIALER_TIMEOUT"),
-				Value:   3 * time.Second,
+			&cli.StringFlag{
+				Name: "cache-upstream-record-dir",
+				Usage: "Record the requests to the upstreams and their responses to this directory, for " +
+					"offline debugging with ncps replay (empty = disabled)",
+				Sources: flagSources("cache.upstream.record.dir", "CACHE_UPSTREAM_RECORD_DIR"),
 			},
 			&cli.DurationFlag{
-				Name:    "upstream-response-header-timeout",
-				Usage:   "DEPRECATED: Use --cache-upstream-response-header-timeout instead.",
-				Sources: cli.EnvVars("UPSTREAM_RESPONSE_HEADER_TIMEOUT"),
-				Value:   3 * time.Second,
+				Name:    "cache-upstream-record-window",
+				Usage:   "How long the recorded upstream exchanges are kept (0 keeps them forever)",
+				Sources: flagSources("cache.upstream.record.window", "CACHE_UPSTREAM_RECORD_WINDOW"),
+				Value:   24 * time.Hour,
 			},
-		},
-	}
-}
-
-func serveAction(registerShutdown registerShutdownFn) cli.ActionFunc {
-	return func(ctx context.Context, cmd *cli.Command) error {
-		logger := zerolog.Ctx(ctx).With().Str("cmd", "serve").Logger()
-
-		ctx = logger.WithContext(ctx)
-
-		ctx, cancel := context.WithCancel(ctx)
-
-		g, ctx := errgroup.WithContext(ctx)
-
-		defer func() {
-			if err := g.Wait(); err != nil {
-				logger.Error().Err(err).Msg("error returned from g.Wait()")
-			}
-		}()
-
-		// NOTE: Reminder that defer statements run last to first so the first
-		// thing that happens here is the context is canceled which triggers the
-		// errgroup 'g' to start exiting.
-		defer cancel()
+			&cli.StringFlag{
+				Name: "cache-upstream-record-max-body-size",
+				Usage: "Record up to this many bytes of each upstream response body, e.g. 64K " +
+					"(empty = bodies are not recorded)",
+				Sources: flagSources("cache.upstream.record.max-body-size", "CACHE_UPSTREAM_RECORD_MAX_BODY_SIZE"),
+				Validator: func(s string) error {
+					if s == "" {
+						return nil
+					}
 
-		g.Go(func() error {
-			return maxprocs.AutoMaxProcs(ctx, 30*time.Second, logger)
-		})
+					_, err := helper.ParseSize(s)
 
-		dbClient, err := createDatabaseClient(cmd)
-		if err != nil {
-			zerolog.Ctx(ctx).
-				Error().
-				Err(err).
-				Msg("error creating database client")
+					return err
+				},
+			},
+			&cli.StringFlag{
+				Name:    "netrc-file",
+				Usage:   "Path to netrc file for upstream authentication",
+				Sources: flagSources("cache.netrc-file", "NETRC_FILE"),
+				Value:   filepath.Join(userDirs.homeDir, ".netrc"),
+			},
+			&cli.StringFlag{
+				Name:    "server-addr",
+				Usage:   "The address of the server",
+				Sources: flagSources("server.addr", "SERVER_ADDR"),
+				Value:   ":8501",
+			},
+			&cli.StringFlag{
+				Name: "server-admin-token",
+				Usage: "Bearer token required to access the admin API under /api/v1 (e.g. to list, trigger " +
+					"or pause cron jobs). The admin API is disabled when empty.",
+				Sources: secretSources(flagSources("server.admin-token", "SERVER_ADMIN_TOKEN")),
+			},
+			&cli.StringFlag{
+				Name: "server-admin-socket",
+				Usage: "Path of a Unix control socket serving the admin API under /api/v1 without the admin " +
+					"token; access is restricted by the permissions of the socket (disabled when empty)",
+				Sources: flagSources("server.admin-socket.path", "SERVER_ADMIN_SOCKET"),
+			},
+			&cli.StringFlag{
+				Name:    "server-admin-socket-mode",
+				Usage:   "The octal permissions of the control socket",
+				Sources: flagSources("server.admin-socket.mode", "SERVER_ADMIN_SOCKET_MODE"),
+				Value:   "0660",
+				Validator: func(s string) error {
+					_, err := parseSocketMode(s)
 
-			return err
-		}
+					return err
+				},
+			},
+			&cli.StringSliceFlag{
+				Name: "server-acme-domain",
+				Usage: "Domain to serve over HTTPS with a certificate issued and renewed by ACME, e.g. Let's " +
+					"Encrypt (repeatable); the certificates are cached in the database (disabled when empty)",
+				Sources: flagSources("server.acme.domains", "SERVER_ACME_DOMAINS"),
+			},
+			&cli.StringFlag{
+				Name:    "server-acme-email",
+				Usage:   "Contact email of the ACME account, notified of the certificate problems",
+				Sources: flagSources("server.acme.email", "SERVER_ACME_EMAIL"),
+			},
+			&cli.StringFlag{
+				Name:    "server-acme-directory-url",
+				Usage:   "Directory URL of the ACME CA, e.g. the Let's Encrypt staging one (default: Let's Encrypt)",
+				Sources: flagSources("server.acme.directory-url", "SERVER_ACME_DIRECTORY_URL"),
+			},
+			&cli.StringFlag{
+				Name: "server-acme-challenge",
+				Usage: "ACME challenge answered: tls-alpn-01 (on --server-addr, which must be reachable on port 443) " +
+					"or http-01 (on --server-acme-http-addr, which must be reachable on port 80)",
+				Sources:   flagSources("server.acme.challenge", "SERVER_ACME_CHALLENGE"),
+				Value:     acmeChallengeTLSALPN,
+				Validator: validateACMEChallenge,
+			},
+			&cli.StringFlag{
+				Name:    "server-acme-http-addr",
+				Usage:   "Address answering the http-01 ACME challenges and redirecting the other requests to HTTPS",
+				Sources: flagSources("server.acme.http-addr", "SERVER_ACME_HTTP_ADDR"),
+				Value:   ":80",
+			},
+			&cli.StringFlag{
+				Name: "server-tsnet-hostname",
+				Usage: "Join the tailnet as this hostname with an embedded Tailscale node and serve on it too " +
+					"(requires a binary built with -tags tsnet; disabled when empty)",
+				Sources: flagSources("server.tsnet.hostname", "SERVER_TSNET_HOSTNAME"),
+			},
+			&cli.StringFlag{
+				Name:    "server-tsnet-auth-key",
+				Usage:   "Tailscale auth key joining the tailnet the first time (default: $TS_AUTHKEY, else log a login URL)",
+				Sources: secretSources(flagSources("server.tsnet.auth-key", "SERVER_TSNET_AUTH_KEY")),
+			},
+			&cli.StringFlag{
+				Name:    "server-tsnet-state-dir",
+				Usage:   "Directory keeping the identity of the Tailscale node across restarts",
+				Sources: flagSources("server.tsnet.state-dir", "SERVER_TSNET_STATE_DIR"),
+			},
+			&cli.BoolFlag{
+				Name:    "server-tsnet-https",
+				Usage:   "Serve the tailnet over HTTPS on port 443 with a Tailscale certificate, instead of HTTP on port 80",
+				Sources: flagSources("server.tsnet.https", "SERVER_TSNET_HTTPS"),
+				Value:   true,
+			},
+			&cli.BoolFlag{
+				Name:    "server-tsnet-ephemeral",
+				Usage:   "Remove the Tailscale node from the tailnet once it goes offline",
+				Sources: flagSources("server.tsnet.ephemeral", "SERVER_TSNET_EPHEMERAL"),
+			},
+			&cli.DurationFlag{
+				Name: "server-narinfo-budget",
+				Usage: "Time budget of a narinfo request, shared by the upstream probes and fetches made for it; " +
+					"a request exceeding it is answered with a 504 (0 disables)",
+				Sources: flagSources("server.narinfo-budget", "SERVER_NARINFO_BUDGET"),
+			},
+			&cli.IntFlag{
+				Name:    "server-limit-narinfo",
+				Usage:   "Maximum concurrent narinfo GET/HEAD requests; more are rejected with a 503 (0 for unlimited)",
+				Sources: flagSources("server.limits.narinfo", "SERVER_LIMIT_NARINFO"),
+			},
+			&cli.IntFlag{
+				Name:    "server-limit-nar",
+				Usage:   "Maximum concurrent NAR GET/HEAD requests; more are rejected with a 503 (0 for unlimited)",
+				Sources: flagSources("server.limits.nar", "SERVER_LIMIT_NAR"),
+			},
+			&cli.IntFlag{
+				Name:    "server-limit-upload",
+				Usage:   "Maximum concurrent PUT requests; more are rejected with a 503 (0 for unlimited)",
+				Sources: flagSources("server.limits.upload", "SERVER_LIMIT_UPLOAD"),
+			},
+			&cli.DurationFlag{
+				Name:    "server-limit-retry-after",
+				Usage:   "Retry-After advertised with the 503 sent when a request limit is reached",
+				Sources: flagSources("server.limits.retry-after", "SERVER_LIMIT_RETRY_AFTER"),
+				Value:   time.Second,
+			},
+			&cli.IntFlag{
+				Name: "server-client-abort-threshold",
+				Usage: "Log a warning for a client aborting at least this many NAR transfers within " +
+					"--server-client-abort-window (0 disables the flagging)",
+				Sources: flagSources("server.client-aborts.threshold", "SERVER_CLIENT_ABORT_THRESHOLD"),
+				Value:   10,
+			},
+			&cli.DurationFlag{
+				Name:    "server-client-abort-window",
+				Usage:   "Period over which the aborted NAR transfers of a client are counted",
+				Sources: flagSources("server.client-aborts.window", "SERVER_CLIENT_ABORT_WINDOW"),
+				Value:   10 * time.Minute,
+			},
+			&cli.DurationFlag{
+				Name: "server-cache-control-nar-max-age",
+				Usage: "max-age of the immutable Cache-Control header of the NAR responses, for fronting " +
+					"ncps with a CDN (0 sends no Cache-Control header)",
+				Sources: flagSources("server.cache-control.nar-max-age", "SERVER_CACHE_CONTROL_NAR_MAX_AGE"),
+			},
+			&cli.DurationFlag{
+				Name: "server-cache-control-narinfo-max-age",
+				Usage: "max-age of the Cache-Control header of the narinfo responses, for fronting ncps " +
+					"with a CDN (0 sends no Cache-Control header)",
+				Sources: flagSources("server.cache-control.narinfo-max-age", "SERVER_CACHE_CONTROL_NARINFO_MAX_AGE"),
+			},
+			&cli.StringFlag{
+				Name: "server-access-log-export-sink",
+				Usage: "Export a record of every narinfo and NAR request to an analytics sink: " +
+					"http, clickhouse or bigquery (empty disables the export)",
+				Sources: flagSources("server.access-log-export.sink", "SERVER_ACCESS_LOG_EXPORT_SINK"),
+			},
+			&cli.StringFlag{
+				Name: "server-access-log-export-url",
+				Usage: "URL of the access log sink: the endpoint of the http sink, the HTTP interface of " +
+					"ClickHouse, or the BigQuery API (defaults to https://bigquery.googleapis.com/bigquery/v2)",
+				Sources: flagSources("server.access-log-export.url", "SERVER_ACCESS_LOG_EXPORT_URL"),
+			},
+			&cli.StringFlag{
+				Name: "server-access-log-export-table",
+				Usage: "Table the access records are inserted into: database.table for clickhouse, " +
+					"project.dataset.table for bigquery",
+				Sources: flagSources("server.access-log-export.table", "SERVER_ACCESS_LOG_EXPORT_TABLE"),
+			},
+			&cli.StringSliceFlag{
+				Name: "server-access-log-export-header",
+				Usage: "Header sent to the access log sink, as \"Name: value\" (can be repeated). " +
+					"A file:// value, or header value, names the file holding the headers, or the value",
+				Sources: secretSources(flagSources("server.access-log-export.headers", "SERVER_ACCESS_LOG_EXPORT_HEADER")),
+			},
+			&cli.IntFlag{
+				Name:    "server-access-log-export-batch-size",
+				Usage:   "Number of access records exported at once",
+				Sources: flagSources("server.access-log-export.batch-size", "SERVER_ACCESS_LOG_EXPORT_BATCH_SIZE"),
+				Value:   accesslog.DefaultBatchSize,
+			},
+			&cli.DurationFlag{
+				Name:  "server-access-log-export-flush-interval",
+				Usage: "Longest an access record waits to be exported",
+				Sources: flagSources("server.access-log-export.flush-interval",
+					"SERVER_ACCESS_LOG_EXPORT_FLUSH_INTERVAL"),
+				Value: accesslog.DefaultFlushInterval,
+			},
+			&cli.IntFlag{
+				Name: "server-access-log-export-queue-size",
+				Usage: "Number of access records waiting to be exported; the records arriving while " +
+					"the queue is full are dropped",
+				Sources: flagSources("server.access-log-export.queue-size", "SERVER_ACCESS_LOG_EXPORT_QUEUE_SIZE"),
+				Value:   accesslog.DefaultQueueSize,
+			},
+			&cli.BoolFlag{
+				Name: "server-store-api",
+				Usage: "Serve the path infos and the NARs of the store paths over the experimental " +
+					"Nix store HTTP API under /store/v1",
+				Sources: flagSources("server.store-api", "SERVER_STORE_API"),
+			},
+			&cli.StringFlag{
+				Name:    "pprof-addr",
+				Usage:   "Address to listen on for pprof profiling endpoints (e.g. :6060). Empty disables pprof.",
+				Sources: flagSources("pprof.addr", "PPROF_ADDR"),
+				Value:   "",
+			},
 
-		registerShutdown("database client", func(_ context.Context) error { return dbClient.Close() })
+			// Redis Configuration (optional - for distributed locking in HA deployments)
+			&cli.StringSliceFlag{
+				Name:    flagNameRedisAddrs,
+				Usage:   "Redis server addresses for distributed locking (e.g., localhost:6379). If not set, local locks are used.",
+				Sources: flagSources("cache.redis.addrs", "CACHE_REDIS_ADDRS"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameRedisUsername,
+				Usage:   "Redis username for authentication (for Redis ACL)",
+				Sources: flagSources("cache.redis.username", "CACHE_REDIS_USERNAME"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameRedisPassword,
+				Usage:   "Redis password for authentication",
+				Sources: secretSources(flagSources("cache.redis.password", "CACHE_REDIS_PASSWORD")),
+			},
+			&cli.IntFlag{
+				Name:    flagNameRedisDB,
+				Usage:   "Redis database number (0-15)",
+				Sources: flagSources("cache.redis.db", "CACHE_REDIS_DB"),
+				Value:   0,
+			},
+			&cli.BoolFlag{
+				Name:    flagNameRedisTLS,
+				Usage:   "Use TLS for Redis connection",
+				Sources: flagSources("cache.redis.use-tls", "CACHE_REDIS_USE_TLS"),
+			},
+			&cli.IntFlag{
+				Name:    flagNameRedisPoolSize,
+				Usage:   flagUsageRedisPoolSize,
+				Sources: flagSources("cache.redis.pool-size", "CACHE_REDIS_POOL_SIZE"),
+				Value:   10,
+			},
 
-		locker, rwLocker, err := getLockers(ctx, cmd)
-		if err != nil {
-			zerolog.Ctx(ctx).
-				Error().
-				Err(err).
-				Msg("error creating the lockers")
+			&cli.StringFlag{
+				Name:    flagNameLockBackend,
+				Usage:   flagUsageLockBackend,
+				Sources: flagSources("cache.lock.backend", "CACHE_LOCK_BACKEND"),
+				Value:   "local",
+			},
+			// Lock Configuration
+			&cli.StringFlag{
+				Name:    flagNameLockFileDir,
+				Usage:   flagUsageLockFileDir,
+				Sources: flagSources("cache.lock.file.dir", "CACHE_LOCK_FILE_DIR"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameLockRedisKeyPrefix,
+				Usage:   flagUsageLockRedisKeyPrefix,
+				Sources: flagSources("cache.lock.redis.key-prefix", "CACHE_LOCK_REDIS_KEY_PREFIX"),
+				Value:   "ncps:lock:",
+			},
+			&cli.DurationFlag{
+				Name:    flagNameLockDownloadTTL,
+				Usage:   flagUsageLockDownloadTTL,
+				Sources: flagSources("cache.lock.download-lock-ttl", "CACHE_LOCK_DOWNLOAD_TTL"),
+				Value:   5 * time.Minute,
+			},
+			&cli.DurationFlag{
+				Name:    flagNameLockLRUTTL,
+				Usage:   flagUsageLockLRUTTL,
+				Sources: flagSources("cache.lock.lru-lock-ttl", "CACHE_LOCK_LRU_TTL"),
+				Value:   30 * time.Minute,
+			},
+			&cli.DurationFlag{
+				Name:    "cache-download-poll-timeout",
+				Usage:   "Timeout for polling storage when waiting for download completion by another server",
+				Sources: flagSources("cache.download.poll-timeout", "CACHE_DOWNLOAD_POLL_TIMEOUT"),
+				Value:   30 * time.Second,
+			},
+			&cli.DurationFlag{
+				Name: "cache-cdc-chunk-wait-timeout",
+				Usage: "Max time progressive CDC streaming waits for the next chunk before failing the " +
+					"transfer. Keep it below your reverse-proxy gateway timeout so a stalled chunk on " +
+					"high-latency storage surfaces as a retryable error instead of a gateway 504.",
+				Sources: flagSources("cache.cdc.chunk-wait-timeout", "CACHE_CDC_CHUNK_WAIT_TIMEOUT"),
+				Value:   30 * time.Second,
+			},
+			&cli.BoolFlag{
+				Name: "cache-cdc-repair-from-upstream",
+				Usage: "Repair a chunked NAR found with a missing chunk or chunk link while serving it by " +
+					"re-fetching it from upstream and rewriting its chunks in the background",
+				Sources: flagSources("cache.cdc.repair-from-upstream", "CACHE_CDC_REPAIR_FROM_UPSTREAM"),
+			},
+			&cli.IntFlag{
+				Name: "cache-cdc-upload-lookahead",
+				Usage: "Chunk the uploaded NARs straight from the request instead of writing them to a temp " +
+					"file first, keeping this many bytes in memory to retry an upload failing before reading " +
+					"past them through a temp file (0 disables the streaming)",
+				Sources: flagSources("cache.cdc.upload-lookahead", "CACHE_CDC_UPLOAD_LOOKAHEAD"),
+			},
+			&cli.IntFlag{
+				Name: "cache-cdc-ingest-workers",
+				Usage: "Number of chunks of a NAR compressed and written to the chunk store at once while " +
+					"chunking it (default: number of CPUs)",
+				Sources: flagSources("cache.cdc.ingest-workers", "CACHE_CDC_INGEST_WORKERS"),
+				Value:   runtime.NumCPU(),
+			},
+			&cli.StringSliceFlag{
+				Name: "cache-cdc-size-class",
+				Usage: "Chunk NARs of up to a given uncompressed size with their own CDC parameters, written " +
+					"as <max-nar-size>:<min>:<avg>:<max> (e.g. 1M:4K:16K:64K) (repeatable). Larger NARs, " +
+					"and NARs of unknown size, use the global CDC parameters.",
+				Sources: flagSources("cache.cdc.size-classes", "CACHE_CDC_SIZE_CLASSES"),
+			},
+			&cli.StringFlag{
+				Name: "cache-cdc-tiering-schedule",
+				Usage: "The cron spec for demoting the cold chunks to the cache.storage.chunks-cold storage " +
+					"(empty disables the chunk tiering)",
+				Sources: flagSources("cache.cdc.tiering.schedule", "CACHE_CDC_TIERING_SCHEDULE"),
+			},
+			&cli.DurationFlag{
+				Name:    "cache-cdc-tiering-cold-after",
+				Usage:   "How long a chunk must go unread before it is demoted to the cold storage",
+				Sources: flagSources("cache.cdc.tiering.cold-after", "CACHE_CDC_TIERING_COLD_AFTER"),
+				Value:   30 * 24 * time.Hour,
+			},
+			&cli.IntFlag{
+				Name: "cache-cdc-tiering-min-accesses",
+				Usage: "Demote only the chunks read fewer times than this, the read count being halved " +
+					"at each run of the chunk tiering",
+				Sources: flagSources("cache.cdc.tiering.min-accesses", "CACHE_CDC_TIERING_MIN_ACCESSES"),
+				Value:   1,
+			},
+			&cli.StringFlag{
+				Name: "cache-cdc-store-max-size",
+				Usage: "The maximum size of the chunk store, the unique chunks as stored, enforced by the LRU " +
+					"independently of --cache-max-size. It can be given with units such as 5K, 10G etc.",
+				Sources: flagSources("cache.cdc.store-max-size", "CACHE_CDC_STORE_MAX_SIZE"),
+				Validator: func(s string) error {
+					_, err := helper.ParseSize(s)
+
+					return err
+				},
+			},
+			// In-flight NAR staging flags (change serve-whole-nar-in-flight).
+			&cli.BoolFlag{
+				Name: "cache-inflight-staging-enabled",
+				Usage: "Serve a NAR cross-pod while it is still downloading by staging it to shared " +
+					"storage as part-objects once another replica waits for it. Off by default; only " +
+					"active with a distributed (Redis) lock. An HA-safe alternative to CDC.",
+				Sources: flagSources("cache.inflight-staging.enabled", "CACHE_INFLIGHT_STAGING_ENABLED"),
+				Value:   false,
+			},
+			&cli.DurationFlag{
+				Name: "cache-inflight-staging-retention",
+				Usage: "Grace period to retain in-flight staging part-objects after the NAR's final " +
+					"representation is committed, so in-flight readers drain before reclamation.",
+				Sources: flagSources("cache.inflight-staging.retention", "CACHE_INFLIGHT_STAGING_RETENTION"),
+				Value:   5 * time.Minute,
+			},
+			&cli.IntFlag{
+				Name: "cache-inflight-staging-part-size",
+				Usage: "Size in bytes of each in-flight staging part-object (transport unit, distinct " +
+					"from CDC chunk sizes).",
+				Sources: flagSources("cache.inflight-staging.part-size", "CACHE_INFLIGHT_STAGING_PART_SIZE"),
+				Value:   8 << 20,
+			},
+			&cli.IntFlag{
+				Name:    flagNameLockMaxRetries,
+				Usage:   flagUsageLockMaxRetries,
+				Sources: flagSources("cache.lock.retry.max-attempts", "CACHE_LOCK_RETRY_MAX_ATTEMPTS"),
+				Value:   3,
+			},
+			&cli.DurationFlag{
+				Name:    flagNameLockInitialDelay,
+				Usage:   flagUsageLockInitialDelay,
+				Sources: flagSources("cache.lock.retry.initial-delay", "CACHE_LOCK_RETRY_INITIAL_DELAY"),
+				Value:   100 * time.Millisecond,
+			},
+			&cli.DurationFlag{
+				Name:    flagNameLockMaxDelay,
+				Usage:   flagUsageLockMaxDelay,
+				Sources: flagSources("cache.lock.retry.max-delay", "CACHE_LOCK_RETRY_MAX_DELAY"),
+				Value:   2 * time.Second,
+			},
+			&cli.BoolFlag{
+				Name:    flagNameLockJitter,
+				Usage:   flagUsageLockJitter,
+				Sources: flagSources("cache.lock.retry.jitter", "CACHE_LOCK_RETRY_JITTER"),
+				Value:   true,
+			},
+			&cli.BoolFlag{
+				Name:    flagNameLockAllowDegraded,
+				Usage:   flagUsageLockAllowDegraded,
+				Sources: flagSources("cache.lock.allow-degraded-mode", "CACHE_LOCK_ALLOW_DEGRADED_MODE"),
+			},
+
+			// DEPRECATED FLAGS BELOW
+
+			&cli.StringFlag{
+				Name:    "cache-data-path",
+				Usage:   "DEPRECATED: Use --cache-storage-local instead.",
+				Sources: flagSources("cache.data-path", "CACHE_DATA_PATH"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "upstream-cache",
+				Usage:   "DEPRECATED: Use --cache-upstream-url instead.",
+				Sources: flagSources("cache.upstream.caches", "UPSTREAM_CACHES"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "upstream-public-key",
+				Usage:   "DEPRECATED: Use --cache-upstream-public-key instead.",
+				Sources: cli.EnvVars("UPSTREAM_PUBLIC_KEYS"),
+			},
+			&cli.DurationFlag{
+				Name:    "upstream-dialer-timeout",
+				Usage:   "DEPRECATED: Use --cache-upstream-dialer-timeout instead.",
+				Sources: cli.EnvVars("UPSTREAM_DIALER_TIMEOUT"),
+				Value:   3 * time.Second,
+			},
+			&cli.DurationFlag{
+				Name:    "upstream-response-header-timeout",
+				Usage:   "DEPRECATED: Use --cache-upstream-response-header-timeout instead.",
+				Sources: cli.EnvVars("UPSTREAM_RESPONSE_HEADER_TIMEOUT"),
+				Value:   3 * time.Second,
+			},
+		}, storeFlags(flagSources)...),
+	}
+}
+
+func serveAction(registerShutdown registerShutdownFn) cli.ActionFunc {
+	return func(ctx context.Context, cmd *cli.Command) error {
+		logger := zerolog.Ctx(ctx).With().Str("cmd", "serve").Logger()
+
+		ctx = logger.WithContext(ctx)
+
+		ctx, cancel := context.WithCancel(ctx)
+
+		g, ctx := errgroup.WithContext(ctx)
+
+		defer func() {
+			if err := g.Wait(); err != nil {
+				logger.Error().Err(err).Msg("error returned from g.Wait()")
+			}
+		}()
+
+		// NOTE: Reminder that defer statements run last to first so the first
+		// thing that happens here is the context is canceled which triggers the
+		// errgroup 'g' to start exiting.
+		defer cancel()
+
+		g.Go(func() error {
+			return maxprocs.AutoMaxProcs(ctx, 30*time.Second, logger)
+		})
+
+		dbClient, err := createDatabaseClient(cmd)
+		if err != nil {
+			zerolog.Ctx(ctx).
+				Error().
+				Err(err).
+				Msg("error creating database client")
+
+			return err
+		}
+
+		registerShutdown("database client", func(_ context.Context) error { return dbClient.Close() })
+
+		ctx, err = setupFaultInjection(ctx, dbClient)
+		if err != nil {
+			return err
+		}
+
+		locker, rwLocker, err := getLockers(ctx, cmd)
+		if err != nil {
+			zerolog.Ctx(ctx).
+				Error().
+				Err(err).
+				Msg("error creating the lockers")
+
+			return err
+		}
+
+		extraResourceAttrs, err := detectExtraResourceAttrs(ctx, cmd, dbClient, rwLocker)
+		if err != nil {
+			logger.
+				Error().
+				Err(err).
+				Msg("error detecting extra resource attributes")
+
+			return err
+		}
+
+		otelResource, err := otel.NewResource(
+			ctx,
+			cmd.Root().Name,
+			Version,
+			semconv.SchemaURL,
+			extraResourceAttrs...,
+		)
+		if err != nil {
+			logger.
+				Error().
+				Err(err).
+				Msg("error creating a new otel resource")
+
+			return err
+		}
+
+		otelShutdown, err := otel.SetupOTelSDK(
+			ctx,
+			cmd.Root().Bool("otel-enabled"),
+			cmd.Root().String("otel-grpc-url"),
+			otelResource,
+		)
+		if err != nil {
+			return err
+		}
+
+		registerShutdown("open telemetry", otelShutdown)
+
+		if cmd.Root().Bool("prometheus-enabled") {
+			gatherer, shutdown, err := prometheus.SetupPrometheusMetrics(otelResource)
+			if err != nil {
+				return fmt.Errorf("error setting up Prometheus metrics: %w", err)
+			}
+
+			registerShutdown("prometheus", shutdown)
+
+			server.SetPrometheusGatherer(gatherer)
+
+			logger.
+				Info().
+				Msg("Prometheus metrics enabled at /metrics")
+		}
+
+		// Prime all counter instruments to zero now that the global meter
+		// provider is installed, so documented counters (e.g.
+		// ncps_nar_served_total) are exposed at /metrics from startup instead of
+		// only appearing after the first event (GitHub issue #1337). When no
+		// metrics exporter is configured this is a harmless no-op.
+		if cmd.Root().Bool("otel-enabled") || cmd.Root().Bool("prometheus-enabled") {
+			cache.PrimeMetrics(ctx)
+			lock.PrimeMetrics(ctx)
+			accesslog.PrimeMetrics(ctx)
+			upstream.PrimeMetrics(ctx)
+			PrimeMetrics(ctx)
+		}
+
+		if pprofAddr := cmd.String("pprof-addr"); pprofAddr != "" {
+			pprofMux := http.NewServeMux()
+			pprofMux.HandleFunc("/debug/pprof/", netpprof.Index)
+			pprofMux.HandleFunc("/debug/pprof/cmdline", netpprof.Cmdline)
+			pprofMux.HandleFunc("/debug/pprof/profile", netpprof.Profile)
+			pprofMux.HandleFunc("/debug/pprof/symbol", netpprof.Symbol)
+			pprofMux.HandleFunc("/debug/pprof/trace", netpprof.Trace)
+
+			pprofServer := &http.Server{
+				Addr:              pprofAddr,
+				Handler:           pprofMux,
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+
+			go func() {
+				if err := pprofServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					logger.Error().Err(err).Msg("pprof server error")
+				}
+			}()
+
+			registerShutdown("pprof", pprofServer.Shutdown)
+
+			logger.Info().Str("pprof_addr", pprofAddr).Msg("pprof profiling enabled")
+		}
+
+		analyticsReporter := analytics.Ctx(ctx) // get the noop reporter
+		if cmd.Bool("analytics-reporting-enabled") || cmd.Bool("analytics-reporting-samples") {
+			analyticsResource, err := analytics.NewResource(
+				ctx,
+				cmd.Root().Name,
+				Version,
+				semconv.SchemaURL,
+				extraResourceAttrs...,
+			)
+			if err != nil {
+				logger.
+					Error().
+					Err(err).
+					Msg("error creating a new analytics resource")
+
+				return err
+			}
+
+			analyticsReporter, err = analytics.New(
+				ctx,
+				dbClient,
+				analyticsResource,
+				cmd.Bool("analytics-reporting-samples"),
+			)
+			if err != nil {
+				zerolog.Ctx(ctx).
+					Error().
+					Err(err).
+					Msg("error creating a new analytics reporter")
+
+				return err
+			}
+
+			registerShutdown("analytics", analyticsReporter.Shutdown)
+		}
+
+		// add the reporter to the context
+		ctx = analyticsReporter.WithContext(ctx)
+
+		netrcData, err := parseNetrcFile(cmd.String("netrc-file"))
+		if err != nil {
+			logger.Warn().Err(err).Msg("failed to parse netrc file, proceeding without netrc authentication")
+		}
+
+		upstreamRecorder, err := newUpstreamRecorder(cmd)
+		if err != nil {
+			return fmt.Errorf("error creating the upstream recorder: %w", err)
+		}
+
+		if upstreamRecorder != nil {
+			registerShutdown("upstream recorder", func(_ context.Context) error { return upstreamRecorder.Close() })
+
+			logger.Warn().
+				Str("dir", cmd.String("cache-upstream-record-dir")).
+				Msg("recording the upstream exchanges; disable it once done debugging")
+		}
+
+		ucs, newUpstream, err := getUpstreamCaches(
+			ctx, cmd, netrcData, config.New(dbClient, rwLocker), upstreamRecorder,
+		)
+		if err != nil {
+			return fmt.Errorf("error computing the upstream caches: %w", err)
+		}
+
+		cache, err := createCache(ctx, cmd, dbClient, locker, rwLocker, ucs)
+		if err != nil {
+			return err
+		}
+
+		registerShutdown("chunk store", cache.CloseChunkStore)
+
+		if err := cache.RecoverInterruptedDownloads(ctx); err != nil {
+			return fmt.Errorf("error recovering the interrupted downloads: %w", err)
+		}
+
+		if cmd.Bool("cache-migrate-legacy-layout") {
+			go migrateLegacyLayout(ctx, cache)
+		}
+
+		if err := setupDatabaseSoftLimits(ctx, cmd, dbClient, g); err != nil {
+			return err
+		}
+
+		if err := setupUpstreamDiscovery(ctx, cmd, cache, newUpstream); err != nil {
+			return err
+		}
+
+		if err := setupUpstreamNarInfoBloom(ctx, cmd, cache); err != nil {
+			return err
+		}
+
+		if err := setupShadowUpstreams(ctx, cmd, cache, newUpstream); err != nil {
+			return err
+		}
+
+		if err := setupPrewarm(ctx, cmd, cache); err != nil {
+			return err
+		}
+
+		if err := setupChannelPrefetch(ctx, cmd, cache); err != nil {
+			return err
+		}
+
+		if err := setupNarInfoIndex(ctx, cmd, cache); err != nil {
+			return err
+		}
+
+		cache.SetReferencePrefetch(
+			cmd.Int("cache-reference-prefetch-concurrency"),
+			cmd.Duration("cache-reference-prefetch-ttl"),
+		)
+
+		cache.SetNarInfoRevalidation(
+			cmd.Duration("cache-narinfo-revalidate-after"),
+			cmd.Duration("cache-narinfo-stale-while-revalidate"),
+		)
+
+		cache.SetNarInfoPurgeLimit(
+			cmd.Int("cache-narinfo-purge-max-per-minute"),
+			cmd.Bool("cache-narinfo-purge-quarantine"),
+		)
+
+		if err := cache.SetNarInfoInvalidation(cmd.Bool("cache-narinfo-invalidation-enabled")); err != nil {
+			zerolog.Ctx(ctx).
+				Error().
+				Err(err).
+				Msg("error enabling the narinfo invalidation")
+
+			return err
+		}
+
+		cache.ListenNarInfoInvalidations(ctx)
+
+		narInfoMetadataCache, err := getNarInfoMetadataCache(ctx, cmd)
+		if err != nil {
+			zerolog.Ctx(ctx).
+				Error().
+				Err(err).
+				Msg("error creating the narinfo metadata cache")
+
+			return err
+		}
+
+		if narInfoMetadataCache != nil {
+			defer narInfoMetadataCache.Close()
+
+			cache.SetNarInfoMetadataCache(narInfoMetadataCache)
+		}
+
+		startNarInfoWarmUp(ctx, cmd, cache)
+
+		// register the cache metrics
+		if err := cache.RegisterUpstreamMetrics(analyticsReporter.GetMeter()); err != nil {
+			zerolog.Ctx(ctx).
+				Error().
+				Err(err).
+				Msg("error registering the cache metrics in the analytics reporter")
+
+			return err
+		}
+
+		// report boot-up
+		record := log.Record{}
+		record.SetTimestamp(time.Now())
+		record.SetSeverity(log.SeverityInfo)
+		record.SetBody(log.StringValue("NCPS Started"))
+
+		analyticsReporter.GetLogger().Emit(ctx, record)
+
+		srv := server.New(cache)
+		srv.SetDeletePermitted(cmd.Bool("cache-allow-delete-verb"))
+		getToken, err := secretValue(cmd, "cache-get-token")
+		if err != nil {
+			return err
+		}
+
+		srv.SetGetToken(getToken)
+		srv.SetPutPermitted(cmd.Bool("cache-allow-put-verb"))
+		srv.SetCacheStatusHeaders(cmd.Bool("cache-status-headers"))
+		srv.SetStoreAPI(cmd.Bool("server-store-api"))
+		adminToken, err := secretValue(cmd, "server-admin-token")
+		if err != nil {
+			return err
+		}
+
+		srv.SetAdminToken(adminToken)
+
+		redirectExpiry, redirectNetworks, err := getPresignedRedirectConfig(cmd)
+		if err != nil {
+			return err
+		}
+
+		srv.SetNarRedirect(redirectExpiry, redirectNetworks)
+		srv.SetRequestLimits(server.RequestLimits{
+			NarInfo:    int64(cmd.Int("server-limit-narinfo")),
+			Nar:        int64(cmd.Int("server-limit-nar")),
+			Upload:     int64(cmd.Int("server-limit-upload")),
+			RetryAfter: cmd.Duration("server-limit-retry-after"),
+		})
+		srv.SetClientAbortTracking(server.ClientAbortTracking{
+			Threshold: cmd.Int("server-client-abort-threshold"),
+			Window:    cmd.Duration("server-client-abort-window"),
+		})
+		srv.SetNarInfoBudget(cmd.Duration("server-narinfo-budget"))
+		srv.SetPriority(cmd.Int("cache-priority"))
+		srv.SetCacheControl(server.CacheControl{
+			NarMaxAge:     cmd.Duration("server-cache-control-nar-max-age"),
+			NarInfoMaxAge: cmd.Duration("server-cache-control-narinfo-max-age"),
+		})
+
+		accessLog, err := newAccessLogExporter(cmd)
+		if err != nil {
+			return err
+		}
+
+		if accessLog != nil {
+			go accessLog.Run(ctx)
+
+			registerShutdown("access log", accessLog.Close)
+
+			srv.SetAccessLog(accessLog)
+
+			logger.Info().
+				Str("sink", cmd.String("server-access-log-export-sink")).
+				Msg("access log export enabled")
+		}
+
+		if socketPath := cmd.String("server-admin-socket"); socketPath != "" {
+			socketMode, err := parseSocketMode(cmd.String("server-admin-socket-mode"))
+			if err != nil {
+				return err
+			}
+
+			socketServer, err := serveAdminSocket(ctx, socketPath, socketMode, srv.AdminHandler())
+			if err != nil {
+				return err
+			}
+
+			registerShutdown("control socket", socketServer.Shutdown)
+
+			logger.Info().Str("path", socketPath).Msg("admin API served on the control socket")
+		}
+
+		server := &http.Server{
+			BaseContext:       func(net.Listener) context.Context { return ctx },
+			Addr:              cmd.String("server-addr"),
+			Handler:           srv,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		if err := serveTailnet(ctx, cmd, server, registerShutdown); err != nil {
+			return err
+		}
+
+		acmeManager := newACMEManager(cmd, config.New(dbClient, rwLocker))
+		if acmeManager == nil {
+			logger.Info().
+				Str("server_addr", cmd.String("server-addr")).
+				Msg("Server started")
+
+			if err := server.ListenAndServe(); err != nil {
+				return fmt.Errorf("error starting the HTTP listener: %w", err)
+			}
+
+			return nil
+		}
+
+		server.TLSConfig = acmeManager.TLSConfig()
+
+		if cmd.String("server-acme-challenge") == acmeChallengeHTTP {
+			challengeServer, err := serveACMEHTTPChallenge(ctx, acmeManager, cmd.String("server-acme-http-addr"))
+			if err != nil {
+				return err
+			}
+
+			registerShutdown("ACME HTTP challenge server", challengeServer.Shutdown)
+		}
+
+		logger.Info().
+			Str("server_addr", cmd.String("server-addr")).
+			Strs("acme_domains", nonEmpty(cmd.StringSlice("server-acme-domain"))).
+			Str("acme_challenge", cmd.String("server-acme-challenge")).
+			Msg("Server started with ACME certificates")
+
+		if err := server.ListenAndServeTLS("", ""); err != nil {
+			return fmt.Errorf("error starting the HTTPS listener: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// newAccessLogExporter returns the exporter of the access log, or nil if
+// --server-access-log-export-sink is empty.
+func newAccessLogExporter(cmd *cli.Command) (*accesslog.Exporter, error) {
+	name := cmd.String("server-access-log-export-sink")
+	if name == "" {
+		return nil, nil //nolint:nilnil // a nil exporter disables the access log
+	}
+
+	rawHeaders, err := secretSliceValue(cmd, "server-access-log-export-header")
+	if err != nil {
+		return nil, err
+	}
+
+	header := make(http.Header)
+
+	for _, r := range rawHeaders {
+		if err := addUpstreamHeader(header, "server-access-log-export-header", r); err != nil {
+			if errors.Is(err, ErrInvalidUpstreamHeader) {
+				err = ErrInvalidAccessLogHeader
+			}
+
+			return nil, fmt.Errorf("%w: --server-access-log-export-header=%q", err, r)
+		}
+	}
+
+	sink, err := accesslog.NewSink(accesslog.SinkConfig{
+		Name:   name,
+		URL:    cmd.String("server-access-log-export-url"),
+		Table:  cmd.String("server-access-log-export-table"),
+		Header: header,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating the access log sink: %w", err)
+	}
+
+	return accesslog.NewExporter(sink, accesslog.Options{
+		BatchSize:     cmd.Int("server-access-log-export-batch-size"),
+		FlushInterval: cmd.Duration("server-access-log-export-flush-interval"),
+		QueueSize:     cmd.Int("server-access-log-export-queue-size"),
+	}), nil
+}
+
+// parseStoreDirRewrite parses the FROM=TO of --cache-store-dir-rewrite.
+func parseStoreDirRewrite(raw string) (string, string, error) {
+	from, to, ok := strings.Cut(raw, "=")
+	if !ok || from == "" || to == "" {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidStoreDirRewrite, raw)
+	}
+
+	return from, to, nil
+}
+
+// getPresignedRedirectConfig returns the presigned URL expiry and the client
+// networks to redirect to S3 for NAR downloads. No networks means redirects are
+// disabled.
+func getPresignedRedirectConfig(cmd *cli.Command) (time.Duration, []netip.Prefix, error) {
+	var networks []netip.Prefix
+
+	for _, r := range cmd.StringSlice("cache-storage-s3-presigned-redirect-network") {
+		// An empty env var can surface as a [""] slice; it must be ignored.
+		if strings.TrimSpace(r) == "" {
+			continue
+		}
+
+		p, err := netip.ParsePrefix(strings.TrimSpace(r))
+		if err != nil {
+			return 0, nil, fmt.Errorf("error parsing presigned redirect network %q: %w", r, err)
+		}
+
+		networks = append(networks, p.Masked())
+	}
+
+	if len(networks) == 0 {
+		return 0, nil, nil
+	}
+
+	// The presigned URLs are those of the nar store.
+	narOnS3 := cmd.String(storeFlagName(storeNar, "s3-bucket")) != "" ||
+		(cmd.String(storeFlagName(storeNar, "local")) == "" && cmd.String(flagNameS3Bucket) != "")
+	if !narOnS3 {
+		return 0, nil, ErrPresignedRedirectRequiresS3
+	}
+
+	expiry := cmd.Duration("cache-storage-s3-presigned-redirect-expiry")
+	if expiry < time.Second || expiry > 7*24*time.Hour {
+		return 0, nil, ErrPresignedRedirectExpiryInvalid
+	}
+
+	return expiry, networks, nil
+}
+
+// parseTrustedUploadKeys parses operator-supplied nix-format `name:base64`
+// public keys into the signature.PublicKey form used to verify PUT uploads. It
+// returns an error on the first malformed entry so a typo fails startup rather
+// than silently producing a key set that rejects every upload.
+func parseTrustedUploadKeys(raw []string) ([]signature.PublicKey, error) {
+	keys := make([]signature.PublicKey, 0, len(raw))
+
+	for _, r := range raw {
+		// An empty CACHE_TRUSTED_UPLOAD_KEYS env var can yield a [""] slice;
+		// skip blanks so a templated/empty deployment does not fail startup.
+		if r == "" {
+			continue
+		}
+
+		pk, err := signature.ParsePublicKey(r)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing trusted upload key %q: %w", r, err)
+		}
+
+		keys = append(keys, pk)
+	}
+
+	return keys, nil
+}
+
+// newUpstreamRecorder returns the recorder of the upstream exchanges of
+// --cache-upstream-record-dir, or nil when it is not set.
+func newUpstreamRecorder(cmd *cli.Command) (*recorder.Recorder, error) {
+	dir := cmd.String("cache-upstream-record-dir")
+	if dir == "" {
+		return nil, nil //nolint:nilnil // recording is disabled
+	}
+
+	var maxBodySize int64
+
+	if s := cmd.String("cache-upstream-record-max-body-size"); s != "" {
+		size, err := helper.ParseSize(s)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing --cache-upstream-record-max-body-size: %w", err)
+		}
+
+		maxBodySize = int64(size) //nolint:gosec // G115: a body size cap is far below MaxInt64
+	}
+
+	return recorder.New(recorder.Options{
+		Dir:         dir,
+		Window:      cmd.Duration("cache-upstream-record-window"),
+		MaxBodySize: maxBodySize,
+	})
+}
+
+// getUpstreamCaches returns the statically configured upstream caches along
+// with a factory building upstream caches with the same options (timeouts,
+// public keys and netrc credentials), used for discovered upstreams.
+//
+// With --cache-upstream-import-public-keys, cfg records the public keys
+// imported from the upstreams. With rec set, the exchanges of every upstream
+// are recorded.
+func getUpstreamCaches(
+	ctx context.Context,
+	cmd *cli.Command,
+	netrcData *netrc.Netrc,
+	cfg *config.Config,
+	rec *recorder.Recorder,
+) ([]*upstream.Cache, cache.UpstreamFactory, error) {
+	// Handle backward compatibility for upstream flags (deprecated)
+	deprecatedUpstreamCache := cmd.StringSlice("upstream-cache")
+	upstreamURL := cmd.StringSlice("cache-upstream-url")
+	deprecatedPublicKey := cmd.StringSlice("upstream-public-key")
+	upstreamPublicKey := cmd.StringSlice("cache-upstream-public-key")
+	deprecatedDialerTimeout := cmd.Duration("upstream-dialer-timeout")
+	dialerTimeout := cmd.Duration("cache-upstream-dialer-timeout")
+	deprecatedResponseHeaderTimeout := cmd.Duration("upstream-response-header-timeout")
+	responseHeaderTimeout := cmd.Duration("cache-upstream-response-header-timeout")
+
+	// Show deprecation warning for upstream-cache
+	if len(deprecatedUpstreamCache) > 0 {
+		zerolog.Ctx(ctx).Warn().
+			Msg("--upstream-cache is deprecated, please use --cache-upstream-url instead")
+
+		if len(upstreamURL) > 0 {
+			zerolog.Ctx(ctx).Warn().
+				Msg("Both --upstream-cache and --cache-upstream-url are set; ignoring the deprecated --upstream-cache")
+		} else {
+			// Use deprecated value if new one is not set
+			upstreamURL = deprecatedUpstreamCache
+		}
+	}
+
+	// This block is a workaround the fact that --cache-upstream-url cannot be
+	// marked as required in order to support the deprecated flag
+	// --upstream-cache.
+	// TODO: Remove this block and the custom error once the --cache-upstream-url
+	// flag is marked as required above.
+	{
+		// Filter out empty upstream URLs before validation.
+		var validUpstreamURLs []string
+
+		for _, u := range upstreamURL {
+			if u != "" {
+				validUpstreamURLs = append(validUpstreamURLs, u)
+			}
+		}
+
+		upstreamURL = validUpstreamURLs
+
+		// Validate that at least one upstream cache is configured, either
+		// statically or through discovery.
+		if len(upstreamURL) == 0 && len(nonEmpty(cmd.StringSlice("cache-upstream-discovery"))) == 0 {
+			return nil, nil, ErrUpstreamCacheRequired
+		}
+	}
+
+	// Show deprecation warning for upstream-public-key
+	if len(deprecatedPublicKey) > 0 {
+		zerolog.Ctx(ctx).Warn().
+			Msg("--upstream-public-key is deprecated, please use --cache-upstream-public-key instead")
+
+		if len(upstreamPublicKey) > 0 {
+			zerolog.Ctx(ctx).Warn().
+				Msg("Both --upstream-public-key and --cache-upstream-public-key are set; " +
+					"ignoring the deprecated --upstream-public-key")
+		} else {
+			// Use deprecated value if new one is not set
+			upstreamPublicKey = deprecatedPublicKey
+		}
+	}
+
+	// Show deprecation warning for upstream-dialer-timeout
+	// Only warn if the value differs from the default (3s)
+	if deprecatedDialerTimeout != 3*time.Second {
+		zerolog.Ctx(ctx).Warn().
+			Msg("--upstream-dialer-timeout is deprecated, please use --cache-upstream-dialer-timeout instead")
+
+		if dialerTimeout != 3*time.Second {
+			zerolog.Ctx(ctx).Warn().
+				Msg("Both --upstream-dialer-timeout and --cache-upstream-dialer-timeout are set; " +
+					"ignoring the deprecated --upstream-dialer-timeout")
+		} else {
+			// Use deprecated value if new one is not set
+			dialerTimeout = deprecatedDialerTimeout
+		}
+	}
+
+	// Show deprecation warning for upstream-response-header-timeout
+	// Only warn if the value differs from the default (3s)
+	if deprecatedResponseHeaderTimeout != 3*time.Second {
+		zerolog.Ctx(ctx).Warn().
+			Msg("--upstream-response-header-timeout is deprecated, " +
+				"please use --cache-upstream-response-header-timeout instead")
+
+		if responseHeaderTimeout != 3*time.Second {
+			zerolog.Ctx(ctx).Warn().
+				Msg("Both --upstream-response-header-timeout and --cache-upstream-response-header-timeout are set; " +
+					"ignoring the deprecated --upstream-response-header-timeout")
+		} else {
+			// Use deprecated value if new one is not set
+			responseHeaderTimeout = deprecatedResponseHeaderTimeout
+		}
+	}
+
+	importPublicKeys := cmd.Bool("cache-upstream-import-public-keys")
+
+	publicKeyURLs := make(map[string]string)
+
+	for _, raw := range nonEmpty(cmd.StringSlice("cache-upstream-public-key-url")) {
+		host, keyURL, ok := strings.Cut(raw, "=")
+		if !ok || host == "" || keyURL == "" {
+			return nil, nil, fmt.Errorf("%w: --cache-upstream-public-key-url=%q", ErrInvalidPublicKeyURL, raw)
+		}
+
+		publicKeyURLs[host] = keyURL
+	}
+
+	rawHeaders, err := secretSliceValue(cmd, "cache-upstream-header")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rawHostHeaders, err := secretSliceValue(cmd, "cache-upstream-host-header")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header, hostHeaders, err := parseUpstreamHeaders(rawHeaders, rawHostHeaders)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	narInfoURLPolicy, err := upstream.ParseNarInfoURLPolicy(cmd.String("cache-upstream-narinfo-url-policy"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userAgent := cmd.String("cache-upstream-user-agent")
+	if userAgent == "" {
+		userAgent = "ncps/" + Version
+	}
+
+	// The DNS cache is shared by the upstreams, several of which may be served
+	// by the same hosts.
+	var dnsCache *upstream.DNSCache
+	if ttl := cmd.Duration("cache-upstream-dns-cache-ttl"); ttl > 0 {
+		dnsCache = upstream.NewDNSCache(upstream.DNSCacheOptions{
+			TTL:      ttl,
+			MaxStale: cmd.Duration("cache-upstream-dns-cache-max-stale"),
+		})
+	}
+
+	newUpstream := func(ctx context.Context, u *url.URL, publicKeys []string) (*upstream.Cache, error) {
+		h := header.Clone()
+		for name, values := range hostHeaders[u.Host] {
+			h[name] = values
+		}
+
+		// Build options for this upstream cache
+		opts := &upstream.Options{
+			UserAgent:             userAgent,
+			Header:                h,
+			ForwardClientIP:       cmd.Bool("cache-upstream-forward-client-ip"),
+			DialerTimeout:         dialerTimeout,
+			ResponseHeaderTimeout: responseHeaderTimeout,
+			DNSCache:              dnsCache,
+			PublicKeys:            publicKeys,
+			RetryAttempts:         cmd.Int("cache-upstream-retry-attempts"),
+			RetryPerTryTimeout:    cmd.Duration("cache-upstream-retry-per-try-timeout"),
+			RetryBackoff:          cmd.Duration("cache-upstream-retry-backoff"),
+			RetryMaxBackoff:       cmd.Duration("cache-upstream-retry-max-backoff"),
+			RetryBudget:           cmd.Float("cache-upstream-retry-budget"),
+			Recorder:              rec,
+			Faults:                faultinject.Ctx(ctx),
+			NarInfoURLPolicy:      narInfoURLPolicy,
+		}
+
+		// Find public keys for this upstream. A local directory, holding a copy
+		// of another cache, has no host to name its keys: it is verified
+		// against every upstream public key.
+		rx := regexp.MustCompile(fmt.Sprintf(`^%s-[0-9]+:[A-Za-z0-9+/=]+$`, regexp.QuoteMeta(u.Host)))
+		for _, pubKey := range upstreamPublicKey {
+			if (upstream.IsFileURL(u) || rx.MatchString(pubKey)) && !slices.Contains(opts.PublicKeys, pubKey) {
+				opts.PublicKeys = append(opts.PublicKeys, pubKey)
+			}
+		}
+
+		// Get credentials for this hostname
+		if netrcData != nil {
+			if machine := netrcData.FindMachine(u.Hostname()); machine != nil {
+				opts.NetrcCredentials = &upstream.NetrcCredentials{
+					Username: machine.Login,
+					Password: machine.Password,
+				}
+			}
+		}
+
+		uc, err := upstream.New(ctx, u, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error creating a new upstream cache: %w", err)
+		}
+
+		if importPublicKeys && len(opts.PublicKeys) == 0 {
+			if err := importUpstreamPublicKey(ctx, cfg, uc, u, publicKeyURLs[u.Host]); err != nil {
+				zerolog.Ctx(ctx).Warn().
+					Err(err).
+					Str("upstream_url", u.String()).
+					Msg("error importing the public key of the upstream; its signatures are not verified")
+			}
+		}
+
+		return uc, nil
+	}
+
+	ucs := make([]*upstream.Cache, 0, len(upstreamURL))
+
+	for _, us := range upstreamURL {
+		u, err := upstream.ParseURL(us)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing --cache-upstream-url=%q: %w", us, err)
+		}
+
+		uc, err := newUpstream(ctx, u, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ucs = append(ucs, uc)
+	}
+
+	factory := func(ctx context.Context, du discovery.Upstream) (*upstream.Cache, error) {
+		return newUpstream(ctx, du.URL, slices.Clone(du.PublicKeys))
+	}
+
+	return ucs, factory, nil
+}
+
+// parseUpstreamHeaders parses the "Name: value" headers sent to every
+// upstream and the "host=Name: value" headers sent to the upstream at host.
+func parseUpstreamHeaders(raw, rawHost []string) (http.Header, map[string]http.Header, error) {
+	header := make(http.Header)
+
+	for _, r := range raw {
+		if err := addUpstreamHeader(header, "cache-upstream-header", r); err != nil {
+			return nil, nil, fmt.Errorf("%w: --cache-upstream-header=%q", err, r)
+		}
+	}
+
+	hostHeaders := make(map[string]http.Header)
+
+	for _, r := range rawHost {
+		host, h, ok := strings.Cut(r, "=")
+		if !ok || host == "" || strings.ContainsAny(host, " \t") {
+			return nil, nil, fmt.Errorf("%w: --cache-upstream-host-header=%q", ErrInvalidUpstreamHeader, r)
+		}
+
+		if hostHeaders[host] == nil {
+			hostHeaders[host] = make(http.Header)
+		}
+
+		if err := addUpstreamHeader(hostHeaders[host], "cache-upstream-host-header", h); err != nil {
+			return nil, nil, fmt.Errorf("%w: --cache-upstream-host-header=%q", err, r)
+		}
+	}
+
+	return header, hostHeaders, nil
+}
+
+// addUpstreamHeader adds the "Name: value" header raw of the flag flagName to
+// header. A file:// value names the file holding the value.
+func addUpstreamHeader(header http.Header, flagName, raw string) error {
+	name, value, ok := strings.Cut(raw, ":")
+	name = strings.TrimSpace(name)
+
+	if !ok || name == "" || strings.ContainsAny(name, " \t=") {
+		return ErrInvalidUpstreamHeader
+	}
+
+	value, err := resolveSecret(flagName, strings.TrimSpace(value))
+	if err != nil {
+		return err
+	}
+
+	header.Add(name, value)
+
+	return nil
+}
+
+// importUpstreamPublicKey makes uc trust the public key recorded for it, or
+// fetches it from keyURL (its /pubkey when empty) and records it, so a key is
+// only ever trusted on first use.
+func importUpstreamPublicKey(
+	ctx context.Context,
+	cfg *config.Config,
+	uc *upstream.Cache,
+	u *url.URL,
+	keyURL string,
+) error {
+	// The priority query is not part of the identity of the upstream.
+	id := *u
+	id.RawQuery = ""
+
+	recorded, err := cfg.GetUpstreamPublicKey(ctx, id.String())
+
+	switch {
+	case err == nil:
+		pk, err := signature.ParsePublicKey(recorded)
+		if err != nil {
+			return fmt.Errorf("error parsing the recorded public key %q: %w", recorded, err)
+		}
+
+		uc.AddPublicKey(pk)
+
+		return nil
+	case !errors.Is(err, config.ErrConfigNotFound):
+		return fmt.Errorf("error reading the recorded public key: %w", err)
+	}
+
+	pk, err := uc.FetchPublicKey(ctx, keyURL)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.SetUpstreamPublicKey(ctx, id.String(), pk.String()); err != nil {
+		return fmt.Errorf("error recording the public key: %w", err)
+	}
+
+	uc.AddPublicKey(pk)
+
+	zerolog.Ctx(ctx).Info().
+		Str("upstream_url", id.String()).
+		Str("public_key", pk.String()).
+		Msg("imported the public key of the upstream")
+
+	return nil
+}
+
+// newSigner returns the signer of --cache-signing-backend, or nil when the
+// cache signs with its own secret key.
+func newSigner(ctx context.Context, cmd *cli.Command, hostName string) (signer.Signer, error) {
+	backend := cmd.String("cache-signing-backend")
+
+	if backend != "" && backend != signingBackendLocal && cmd.String("cache-secret-key-path") != "" {
+		return nil, fmt.Errorf("%w: --cache-secret-key-path cannot be used with --cache-signing-backend=%s",
+			ErrSigningBackendConfig, backend)
+	}
+
+	switch backend {
+	case "", signingBackendLocal:
+		//nolint:nilnil // no external signer
+		return nil, nil
+	case signingBackendVault:
+		token, err := secretValue(cmd, "cache-signing-vault-token")
+		if err != nil {
+			return nil, err
+		}
+
+		opts := signer.VaultOptions{
+			Address: cmd.String("cache-signing-vault-address"),
+			Token:   token,
+			Mount:   cmd.String("cache-signing-vault-mount"),
+			Key:     cmd.String("cache-signing-vault-key"),
+			Name:    hostName,
+		}
+
+		if opts.Address == "" || opts.Token == "" || opts.Key == "" {
+			return nil, fmt.Errorf("%w: --cache-signing-vault-address, --cache-signing-vault-token and "+
+				"--cache-signing-vault-key are required", ErrSigningBackendConfig)
+		}
+
+		return signer.NewVault(ctx, opts)
+	case signingBackendAWSKMS:
+		accessKeyID, err := secretValue(cmd, "cache-signing-aws-kms-access-key-id")
+		if err != nil {
+			return nil, err
+		}
+
+		secretAccessKey, err := secretValue(cmd, "cache-signing-aws-kms-secret-access-key")
+		if err != nil {
+			return nil, err
+		}
+
+		creds := credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		})
+		if accessKeyID != "" || secretAccessKey != "" {
+			creds = credentials.NewStaticV4(accessKeyID, secretAccessKey, "")
+		}
+
+		opts := signer.AWSKMSOptions{
+			Region:      cmd.String("cache-signing-aws-kms-region"),
+			KeyID:       cmd.String("cache-signing-aws-kms-key-id"),
+			Endpoint:    cmd.String("cache-signing-aws-kms-endpoint"),
+			Credentials: creds,
+			Name:        hostName,
+		}
+
+		if opts.Region == "" || opts.KeyID == "" {
+			return nil, fmt.Errorf("%w: --cache-signing-aws-kms-region and --cache-signing-aws-kms-key-id are required",
+				ErrSigningBackendConfig)
+		}
+
+		return signer.NewAWSKMS(ctx, opts)
+	default:
+		return nil, fmt.Errorf("%w: %q (must be %s, %s or %s)",
+			ErrInvalidSigningBackend, backend, signingBackendLocal, signingBackendVault, signingBackendAWSKMS)
+	}
+}
+
+// setupShadowUpstreams adds the --cache-upstream-shadow-url upstreams in
+// shadow mode.
+func setupShadowUpstreams(
+	ctx context.Context,
+	cmd *cli.Command,
+	c *cache.Cache,
+	factory cache.UpstreamFactory,
+) error {
+	rawURLs := nonEmpty(cmd.StringSlice("cache-upstream-shadow-url"))
+	if len(rawURLs) == 0 {
+		return nil
+	}
+
+	minAgreement := cmd.Float("cache-upstream-shadow-min-agreement")
+	if minAgreement < 0 || minAgreement > 1 {
+		return fmt.Errorf("%w: --cache-upstream-shadow-min-agreement=%v", ErrInvalidShadowMinAgreement, minAgreement)
+	}
+
+	ucs := make([]*upstream.Cache, 0, len(rawURLs))
+
+	for _, raw := range rawURLs {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("error parsing --cache-upstream-shadow-url=%q: %w", raw, err)
+		}
+
+		uc, err := factory(ctx, discovery.Upstream{URL: u})
+		if err != nil {
+			return err
+		}
+
+		ucs = append(ucs, uc)
+	}
+
+	c.AddShadowUpstreamCaches(ctx, cache.ShadowTrial{
+		Period:         cmd.Duration("cache-upstream-shadow-trial-period"),
+		MinComparisons: int64(cmd.Int("cache-upstream-shadow-min-comparisons")),
+		MinAgreement:   minAgreement,
+	}, ucs...)
+
+	return nil
+}
+
+// setupUpstreamDiscovery configures the upstream discovery sources, if any,
+// runs an initial discovery and schedules the periodic refresh.
+func setupUpstreamDiscovery(
+	ctx context.Context,
+	cmd *cli.Command,
+	c *cache.Cache,
+	factory cache.UpstreamFactory,
+) error {
+	rawSources := nonEmpty(cmd.StringSlice("cache-upstream-discovery"))
+	if len(rawSources) == 0 {
+		return nil
+	}
+
+	sources := make([]discovery.Source, 0, len(rawSources))
+
+	for _, raw := range rawSources {
+		src, err := discovery.NewSource(raw)
+		if err != nil {
+			return fmt.Errorf("error parsing --cache-upstream-discovery=%q: %w", raw, err)
+		}
+
+		sources = append(sources, src)
+	}
+
+	scheduleStr := cmd.String("cache-upstream-discovery-schedule")
+
+	schedule, err := cron.ParseStandard(scheduleStr)
+	if err != nil {
+		return fmt.Errorf("error parsing the upstream discovery cron spec %q: %w", scheduleStr, err)
+	}
+
+	source := discovery.Multi(sources...)
+	c.SetUpstreamDiscovery(source, factory)
+
+	// A failing initial discovery is not fatal: the cron job keeps retrying and
+	// the static upstreams, if any, keep serving in the meantime.
+	changed, err := c.RefreshDiscoveredUpstreams(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(err).
+			Str("source", source.String()).
+			Msg("error discovering the upstream caches; will retry on schedule")
+	} else if changed {
+		c.GetHealthChecker().Trigger()
+	}
+
+	c.AddUpstreamDiscoveryCronJob(ctx, schedule)
+
+	return nil
+}
+
+// setupSQLiteMaintenance schedules the maintenance of a SQLite database.
+func setupSQLiteMaintenance(ctx context.Context, cmd *cli.Command, dbClient *database.Client, c *cache.Cache) error {
+	scheduleStr := cmd.String("cache-database-sqlite-maintenance-schedule")
+	if scheduleStr == "" {
+		return nil
+	}
+
+	if dbClient.Type() != database.TypeSQLite {
+		zerolog.Ctx(ctx).
+			Warn().
+			Str("database_type", dbClient.Type().String()).
+			Msg("--cache-database-sqlite-maintenance-schedule is ignored, the database is not SQLite")
+
+		return nil
+	}
+
+	schedule, err := cron.ParseStandard(scheduleStr)
+	if err != nil {
+		return fmt.Errorf("error parsing the cron spec %q: %w", scheduleStr, err)
+	}
+
+	c.AddSQLiteMaintenanceCronJob(ctx, schedule, cmd.Int("cache-database-sqlite-maintenance-vacuum-pages"))
+
+	return nil
+}
+
+// setupChunkTiering counts the chunk reads and schedules the demotion of the
+// cold chunks, if enabled.
+func setupChunkTiering(ctx context.Context, cmd *cli.Command, c *cache.Cache) error {
+	scheduleStr := cmd.String("cache-cdc-tiering-schedule")
+	if scheduleStr == "" {
+		return nil
+	}
+
+	if !hasStoreConfig(cmd, storeChunksCold) {
+		return ErrChunkTieringColdStoreRequired
+	}
+
+	schedule, err := cron.ParseStandard(scheduleStr)
+	if err != nil {
+		return fmt.Errorf("error parsing the chunk tiering cron spec %q: %w", scheduleStr, err)
+	}
+
+	c.SetChunkTiering(cmd.Duration("cache-cdc-tiering-cold-after"), int64(cmd.Int("cache-cdc-tiering-min-accesses")))
+	c.AddChunkTieringCronJob(ctx, schedule)
+
+	return nil
+}
+
+// setupNarInfoFileHashBackfill schedules the backfill of the FileHash and the
+// FileSize missing from the narinfos, if enabled.
+func setupNarInfoFileHashBackfill(ctx context.Context, cmd *cli.Command, c *cache.Cache) error {
+	scheduleStr := cmd.String("cache-narinfo-file-hash-backfill-schedule")
+	if scheduleStr == "" {
+		return nil
+	}
+
+	schedule, err := cron.ParseStandard(scheduleStr)
+	if err != nil {
+		return fmt.Errorf("error parsing the narinfo file hash backfill cron spec %q: %w", scheduleStr, err)
+	}
+
+	c.AddNarInfoFileHashBackfillCronJob(ctx, schedule)
+
+	return nil
+}
+
+// setupMaintenanceWindows restricts the maintenance jobs to the configured
+// maintenance windows.
+func setupMaintenanceWindows(ctx context.Context, cmd *cli.Command, c *cache.Cache) error {
+	windowStrs := nonEmpty(cmd.StringSlice("cache-maintenance-window"))
+	if len(windowStrs) == 0 {
+		return nil
+	}
+
+	windows := make([]cache.MaintenanceWindow, 0, len(windowStrs))
+
+	for _, s := range windowStrs {
+		w, err := cache.ParseMaintenanceWindow(s)
+		if err != nil {
+			return err
+		}
+
+		windows = append(windows, w)
+	}
+
+	jobs := nonEmpty(cmd.StringSlice("cache-maintenance-job"))
+
+	zerolog.Ctx(ctx).
+		Info().
+		Strs("windows", windowStrs).
+		Strs("jobs", jobs).
+		Msg("restricting the maintenance jobs to the maintenance windows")
+
+	c.SetMaintenanceWindows(windows, jobs)
+
+	return nil
+}
+
+// setupDatabaseSoftLimits starts the monitor of the database soft limits, if
+// any is set.
+func setupDatabaseSoftLimits(
+	ctx context.Context,
+	cmd *cli.Command,
+	dbClient *database.Client,
+	g *errgroup.Group,
+) error {
+	rows, err := parseRowLimits(nonEmpty(cmd.StringSlice("cache-database-soft-limit-rows")))
+	if err != nil {
+		return err
+	}
+
+	limits := softlimit.Limits{
+		Rows:       rows,
+		BloatRatio: cmd.Float("cache-database-soft-limit-bloat-ratio"),
+	}
+
+	if sizeStr := cmd.String("cache-database-soft-limit-size"); sizeStr != "" {
+		size, err := helper.ParseSize(sizeStr)
+		if err != nil {
+			return fmt.Errorf("error parsing the database soft limit size: %w", err)
+		}
+
+		//nolint:gosec // G115: a database size is far below MaxInt64
+		limits.Size = int64(size)
+	}
+
+	if !limits.Enabled() {
+		return nil
+	}
+
+	webhookURL, err := secretValue(cmd, "cache-database-soft-limit-webhook-url")
+	if err != nil {
+		return err
+	}
+
+	monitor, err := softlimit.New(dbClient, softlimit.Options{
+		Limits:     limits,
+		Interval:   cmd.Duration("cache-database-soft-limit-check-interval"),
+		WebhookURL: webhookURL,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating the database soft limit monitor: %w", err)
+	}
+
+	g.Go(func() error { return monitor.Run(ctx) })
+
+	return nil
+}
+
+// parseRowLimits parses the TABLE=ROWS row limits.
+func parseRowLimits(ss []string) (map[string]int64, error) {
+	limits := make(map[string]int64, len(ss))
+
+	for _, s := range ss {
+		table, rowsStr, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q, expected TABLE=ROWS", ErrInvalidRowLimit, s)
+		}
+
+		if err := database.ValidateTable(table); err != nil {
+			return nil, err
+		}
+
+		rows, err := strconv.ParseInt(rowsStr, 10, 64)
+		if err != nil || rows <= 0 {
+			return nil, fmt.Errorf("%w: %q, expected a positive number of rows", ErrInvalidRowLimit, s)
+		}
+
+		limits[table] = rows
+	}
+
+	return limits, nil
+}
+
+// migrateLegacyLayout migrates what older versions of ncps stored to the
+// current layout, logging what it migrated.
+func migrateLegacyLayout(ctx context.Context, c *cache.Cache) {
+	stats, err := c.MigrateLegacyLayout(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("error migrating the legacy storage layout")
+
+		return
+	}
+
+	if stats.Migrated() {
+		zerolog.Ctx(ctx).Info().
+			Int("nars_moved", stats.NarsMoved).
+			Int("nars_removed", stats.NarsRemoved).
+			Int("nar_files_renamed", stats.NarFilesRenamed).
+			Int("nar_files_merged", stats.NarFilesMerged).
+			Msg("migrated the legacy storage layout")
+	}
+}
+
+// setupPrewarm configures the pre-warm sources, if any, and schedules the
+// pre-warm job.
+func setupPrewarm(ctx context.Context, cmd *cli.Command, c *cache.Cache) error {
+	flakes := nonEmpty(cmd.StringSlice("cache-prewarm-flake"))
+	pathListURLs := nonEmpty(cmd.StringSlice("cache-prewarm-path-list-url"))
+
+	if len(flakes) == 0 && len(pathListURLs) == 0 {
+		return nil
+	}
+
+	sources := make([]prewarm.Source, 0, len(flakes)+len(pathListURLs))
+
+	for _, ref := range flakes {
+		sources = append(sources, prewarm.NewFlake(ref, cmd.String("cache-prewarm-nix-bin"), nil))
+	}
+
+	for _, raw := range pathListURLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("%w: --cache-prewarm-path-list-url=%q", ErrPrewarmPathListURLInvalid, raw)
+		}
+
+		sources = append(sources, prewarm.NewPathList(raw, nil))
+	}
+
+	scheduleStr := cmd.String("cache-prewarm-schedule")
+
+	schedule, err := cron.ParseStandard(scheduleStr)
+	if err != nil {
+		return fmt.Errorf("error parsing the pre-warm cron spec %q: %w", scheduleStr, err)
+	}
+
+	c.SetPrewarm(sources, cmd.Int("cache-prewarm-concurrency"))
+	c.AddPrewarmCronJob(ctx, schedule)
+
+	return nil
+}
+
+// setupChannelPrefetch configures the channels to pre-fetch, if any, and
+// schedules the channel pre-fetch job.
+func setupChannelPrefetch(ctx context.Context, cmd *cli.Command, c *cache.Cache) error {
+	channelURLs := nonEmpty(cmd.StringSlice("cache-channel-prefetch-url"))
+	if len(channelURLs) == 0 {
+		return nil
+	}
+
+	channels := make([]*prewarm.Channel, 0, len(channelURLs))
+
+	for _, raw := range channelURLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("%w: --cache-channel-prefetch-url=%q", ErrChannelPrefetchURLInvalid, raw)
+		}
+
+		channels = append(channels, prewarm.NewChannel(raw, nil))
+	}
+
+	var maxNarSize uint64
+
+	if maxNarSizeStr := cmd.String("cache-channel-prefetch-max-nar-size"); maxNarSizeStr != "" {
+		var err error
+
+		maxNarSize, err = helper.ParseSize(maxNarSizeStr)
+		if err != nil {
+			return fmt.Errorf("error parsing --cache-channel-prefetch-max-nar-size=%q: %w", maxNarSizeStr, err)
+		}
+	}
+
+	scheduleStr := cmd.String("cache-channel-prefetch-schedule")
+
+	schedule, err := cron.ParseStandard(scheduleStr)
+	if err != nil {
+		return fmt.Errorf("error parsing the channel pre-fetch cron spec %q: %w", scheduleStr, err)
+	}
+
+	c.SetChannelPrefetch(channels, maxNarSize, cmd.Int("cache-prewarm-concurrency"))
+	c.AddChannelPrefetchCronJob(ctx, schedule)
+
+	return nil
+}
+
+// nonEmpty returns the non-empty values of ss.
+func nonEmpty(ss []string) []string {
+	var out []string
+
+	for _, s := range ss {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+
+	return out
+}
+
+func getStorageConfig(ctx context.Context, cmd *cli.Command) (string, *s3config.Config, error) {
+	deprecatedDataPath := cmd.String("cache-data-path")
+	localDataPath := cmd.String("cache-storage-local")
+	s3Bucket := cmd.String("cache-storage-s3-bucket")
+
+	// Show deprecation warning if old flag is used
+	if deprecatedDataPath != "" {
+		zerolog.Ctx(ctx).Warn().
+			Msg("--cache-data-path is deprecated, please use --cache-storage-local instead")
+
+		if localDataPath != "" {
+			zerolog.Ctx(ctx).Warn().
+				Msg("Both --cache-data-path and --cache-storage-local are set; ignoring the deprecated --cache-data-path")
+		} else {
+			// Use deprecated value if new one is not set
+			localDataPath = deprecatedDataPath
+		}
+	}
+
+	if localDataPath != "" && s3Bucket != "" {
+		return "", nil, ErrStorageConflict
+	}
+
+	if localDataPath == "" && s3Bucket == "" {
+		return "", nil, ErrStorageConfigRequired
+	}
+
+	if localDataPath != "" {
+		return localDataPath, nil, nil
+	}
+
+	accessKeyID, err := secretValue(cmd, "cache-storage-s3-access-key-id")
+	if err != nil {
+		return "", nil, err
+	}
+
+	secretAccessKey, err := secretValue(cmd, "cache-storage-s3-secret-access-key")
+	if err != nil {
+		return "", nil, err
+	}
+
+	s3Cfg := &s3config.Config{
+		Bucket:          s3Bucket,
+		Region:          cmd.String("cache-storage-s3-region"),
+		Endpoint:        cmd.String("cache-storage-s3-endpoint"),
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		ForcePathStyle:  cmd.Bool("cache-storage-s3-force-path-style"),
+	}
+
+	if err := s3config.ValidateConfig(*s3Cfg); err != nil {
+		return "", nil, err
+	}
 
-			return err
-		}
+	return "", s3Cfg, nil
+}
 
-		extraResourceAttrs, err := detectExtraResourceAttrs(ctx, cmd, dbClient, rwLocker)
-		if err != nil {
-			logger.
-				Error().
-				Err(err).
-				Msg("error detecting extra resource attributes")
+//nolint:staticcheck // deprecated: migration support
+func getStorageBackend(
+	ctx context.Context,
+	cmd *cli.Command,
+) (storage.ConfigStore, storage.NarInfoStore, storage.NarStore, error) {
+	localDataPath, s3Cfg, err := getStorageConfig(ctx, cmd)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
-			return err
-		}
+	var (
+		configStore  storage.ConfigStore
+		narInfoStore storage.NarInfoStore
+		narStore     storage.NarStore
+	)
 
-		otelResource, err := otel.NewResource(
-			ctx,
-			cmd.Root().Name,
-			Version,
-			semconv.SchemaURL,
-			extraResourceAttrs...,
-		)
-		if err != nil {
-			logger.
-				Error().
-				Err(err).
-				Msg("error creating a new otel resource")
+	switch {
+	case localDataPath != "":
+		configStore, narInfoStore, narStore, err = createLocalStorage(ctx, localDataPath)
 
-			return err
-		}
+	case s3Cfg != nil:
+		configStore, narInfoStore, narStore, err = createS3Storage(ctx, *s3Cfg)
 
-		otelShutdown, err := otel.SetupOTelSDK(
-			ctx,
-			cmd.Root().Bool("otel-enabled"),
-			cmd.Root().String("otel-grpc-url"),
-			otelResource,
-		)
+	default:
+		// This should never happen because getStorageConfig returns an error if neither is set
+		return nil, nil, nil, ErrStorageConfigRequired
+	}
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// The nars may be stored apart from the main storage.
+	if hasStoreConfig(cmd, storeNar) {
+		narStore, err = createNarStore(ctx, cmd)
 		if err != nil {
-			return err
+			return nil, nil, nil, err
 		}
+	}
 
-		registerShutdown("open telemetry", otelShutdown)
+	if localNarStore, ok := narStore.(*localstorage.Store); ok && cmd.Bool(flagNameStorageLocalNarDedup) {
+		localNarStore.SetNarDedup(true)
 
-		if cmd.Root().Bool("prometheus-enabled") {
-			gatherer, shutdown, err := prometheus.SetupPrometheusMetrics(otelResource)
-			if err != nil {
-				return fmt.Errorf("error setting up Prometheus metrics: %w", err)
-			}
+		zerolog.Ctx(ctx).Info().Msg("hard-linking the nars of identical content")
+	}
 
-			registerShutdown("prometheus", shutdown)
+	setStoreInventory(ctx, cmd, narStore)
 
-			server.SetPrometheusGatherer(gatherer)
+	return configStore, narInfoStore, narStore, nil
+}
 
-			logger.
-				Info().
-				Msg("Prometheus metrics enabled at /metrics")
-		}
+// wrapInlineNarStore wraps narStore so the nars of at most
+// --cache-storage-inline-threshold bytes are stored in the database. It returns
+// narStore untouched when the threshold is zero.
+func wrapInlineNarStore(
+	ctx context.Context,
+	cmd *cli.Command,
+	dbClient *database.Client,
+	narStore storage.NarStore,
+) (storage.NarStore, error) {
+	threshold := cmd.Int(flagNameStorageInlineThreshold)
+	if threshold == 0 {
+		return narStore, nil
+	}
 
-		// Prime all counter instruments to zero now that the global meter
-		// provider is installed, so documented counters (e.g.
-		// ncps_nar_served_total) are exposed at /metrics from startup instead of
-		// only appearing after the first event (GitHub issue #1337). When no
-		// metrics exporter is configured this is a harmless no-op.
-		if cmd.Root().Bool("otel-enabled") || cmd.Root().Bool("prometheus-enabled") {
-			cache.PrimeMetrics(ctx)
-			lock.PrimeMetrics(ctx)
-			PrimeMetrics(ctx)
-		}
+	inlineStore, err := inline.New(narStore, dbClient, int64(threshold))
+	if err != nil {
+		return nil, fmt.Errorf("error creating the inline nar store: %w", err)
+	}
 
-		if pprofAddr := cmd.String("pprof-addr"); pprofAddr != "" {
-			pprofMux := http.NewServeMux()
-			pprofMux.HandleFunc("/debug/pprof/", netpprof.Index)
-			pprofMux.HandleFunc("/debug/pprof/cmdline", netpprof.Cmdline)
-			pprofMux.HandleFunc("/debug/pprof/profile", netpprof.Profile)
-			pprofMux.HandleFunc("/debug/pprof/symbol", netpprof.Symbol)
-			pprofMux.HandleFunc("/debug/pprof/trace", netpprof.Trace)
+	zerolog.Ctx(ctx).Info().Int("threshold", threshold).Msg("storing small nars inline in the database")
 
-			pprofServer := &http.Server{
-				Addr:              pprofAddr,
-				Handler:           pprofMux,
-				ReadHeaderTimeout: 10 * time.Second,
-			}
+	return inlineStore, nil
+}
 
-			go func() {
-				if err := pprofServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-					logger.Error().Err(err).Msg("pprof server error")
-				}
-			}()
+//nolint:staticcheck // deprecated: migration support
+func createLocalStorage(
+	ctx context.Context,
+	dataPath string,
+) (storage.ConfigStore, storage.NarInfoStore, storage.NarStore, error) {
+	localStore, err := localstorage.New(ctx, dataPath)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error creating a new local store at %q: %w", dataPath, err)
+	}
 
-			registerShutdown("pprof", pprofServer.Shutdown)
+	zerolog.Ctx(ctx).Info().Str("path", dataPath).Msg("using local storage")
 
-			logger.Info().Str("pprof_addr", pprofAddr).Msg("pprof profiling enabled")
-		}
+	// Check if the narinfo directory exists
+	exist, err := localStore.HasNarinfoDir()
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to check for local narinfo directory")
+	} else if exist {
+		zerolog.Ctx(ctx).
+			Warn().
+			Str("component", "storage").
+			Str("action_required", "Migrate narinfo to database").
+			Str("instructions", "Run `ncps migrate-narinfo --help`").
+			Msg("Local 'narinfo' directory detected. File-based narinfo storage" +
+				" is deprecated and will be removed in the next release.")
+	}
 
-		analyticsReporter := analytics.Ctx(ctx) // get the noop reporter
-		if cmd.Bool("analytics-reporting-enabled") || cmd.Bool("analytics-reporting-samples") {
-			analyticsResource, err := analytics.NewResource(
-				ctx,
-				cmd.Root().Name,
-				Version,
-				semconv.SchemaURL,
-				extraResourceAttrs...,
-			)
-			if err != nil {
-				logger.
-					Error().
-					Err(err).
-					Msg("error creating a new analytics resource")
+	return localStore, localStore, localStore, nil
+}
 
-				return err
-			}
+//nolint:staticcheck // deprecated: migration support
+func createS3Storage(
+	ctx context.Context,
+	s3Cfg s3config.Config,
+) (storage.ConfigStore, storage.NarInfoStore, storage.NarStore, error) {
+	ctx = zerolog.Ctx(ctx).
+		With().
+		Str("bucket", s3Cfg.Bucket).
+		Str("endpoint", s3Cfg.Endpoint).
+		Bool("force_path_style", s3Cfg.ForcePathStyle).
+		Logger().
+		WithContext(ctx)
 
-			analyticsReporter, err = analytics.New(
-				ctx,
-				dbClient,
-				analyticsResource,
-				cmd.Bool("analytics-reporting-samples"),
-			)
-			if err != nil {
-				zerolog.Ctx(ctx).
-					Error().
-					Err(err).
-					Msg("error creating a new analytics reporter")
+	zerolog.Ctx(ctx).Debug().Msg("creating S3 storage")
 
-				return err
-			}
+	s3Store, err := storageS3.New(ctx, s3Cfg)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error creating a new S3 store: %w", err)
+	}
 
-			registerShutdown("analytics", analyticsReporter.Shutdown)
-		}
+	zerolog.Ctx(ctx).Info().Msg("using S3 storage")
 
-		// add the reporter to the context
-		ctx = analyticsReporter.WithContext(ctx)
+	// Check if the narinfo directory exists
+	exist, err := s3Store.HasNarinfoDir(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to check for S3 narinfo directory")
+	} else if exist {
+		zerolog.Ctx(ctx).
+			Warn().
+			Str("component", "storage").
+			Str("action_required", "Migrate narinfo to database").
+			Str("instructions", "Run `ncps migrate-narinfo --help`").
+			Msg("S3 'narinfo' directory detected. S3-based narinfo storage" +
+				" is deprecated and will be removed in the next release.")
+	}
 
-		netrcData, err := parseNetrcFile(cmd.String("netrc-file"))
-		if err != nil {
-			logger.Warn().Err(err).Msg("failed to parse netrc file, proceeding without netrc authentication")
-		}
+	return s3Store, s3Store, s3Store, nil
+}
 
-		ucs, err := getUpstreamCaches(ctx, cmd, netrcData)
-		if err != nil {
-			return fmt.Errorf("error computing the upstream caches: %w", err)
-		}
+func createDatabaseClient(cmd *cli.Command) (*database.Client, error) {
+	dbURL, err := secretValue(cmd, "cache-database-url")
+	if err != nil {
+		return nil, err
+	}
 
-		cache, err := createCache(ctx, cmd, dbClient, locker, rwLocker, ucs)
-		if err != nil {
-			return err
-		}
+	// Build pool configuration from flags
+	var poolCfg *database.PoolConfig
 
-		// register the cache metrics
-		if err := cache.RegisterUpstreamMetrics(analyticsReporter.GetMeter()); err != nil {
-			zerolog.Ctx(ctx).
-				Error().
-				Err(err).
-				Msg("error registering the cache metrics in the analytics reporter")
+	maxOpen := cmd.Int("cache-database-pool-max-open-conns")
 
-			return err
+	maxIdle := cmd.Int("cache-database-pool-max-idle-conns")
+	if maxOpen > 0 || maxIdle > 0 {
+		poolCfg = &database.PoolConfig{
+			MaxOpenConns: maxOpen,
+			MaxIdleConns: maxIdle,
 		}
+	}
 
-		// report boot-up
-		record := log.Record{}
-		record.SetTimestamp(time.Now())
-		record.SetSeverity(log.SeverityInfo)
-		record.SetBody(log.StringValue("NCPS Started"))
+	dbClient, err := database.Open(dbURL, poolCfg)
+	if err != nil {
+		// Avoid embedding dbURL — it may contain user:password credentials.
+		return nil, fmt.Errorf("error opening the database: %w", err)
+	}
 
-		analyticsReporter.GetLogger().Emit(ctx, record)
+	// The SQLite flags are only defined by serve; the other commands read zeros.
+	sqliteCfg := database.SQLiteConfig{
+		WALAutoCheckpoint: cmd.Int("cache-database-sqlite-wal-autocheckpoint"),
+	}
 
-		srv := server.New(cache)
-		srv.SetDeletePermitted(cmd.Bool("cache-allow-delete-verb"))
-		srv.SetGetToken(cmd.String("cache-get-token"))
-		srv.SetPutPermitted(cmd.Bool("cache-allow-put-verb"))
+	if limit := cmd.String("cache-database-sqlite-journal-size-limit"); limit != "" {
+		size, err := helper.ParseSize(limit)
+		if err != nil {
+			_ = dbClient.Close()
 
-		server := &http.Server{
-			BaseContext:       func(net.Listener) context.Context { return ctx },
-			Addr:              cmd.String("server-addr"),
-			Handler:           srv,
-			ReadHeaderTimeout: 10 * time.Second,
+			return nil, fmt.Errorf("error parsing the SQLite journal size limit: %w", err)
 		}
 
-		logger.Info().
-			Str("server_addr", cmd.String("server-addr")).
-			Msg("Server started")
+		//nolint:gosec // G115: a journal size limit is far below MaxInt64
+		sqliteCfg.JournalSizeLimit = int64(size)
+	}
 
-		if err := server.ListenAndServe(); err != nil {
-			return fmt.Errorf("error starting the HTTP listener: %w", err)
-		}
+	if err := dbClient.ConfigureSQLite(context.Background(), sqliteCfg); err != nil {
+		_ = dbClient.Close()
 
-		return nil
+		return nil, err
 	}
+
+	return dbClient, nil
 }
 
-// parseTrustedUploadKeys parses operator-supplied nix-format `name:base64`
-// public keys into the signature.PublicKey form used to verify PUT uploads. It
-// returns an error on the first malformed entry so a typo fails startup rather
-// than silently producing a key set that rejects every upload.
-func parseTrustedUploadKeys(raw []string) ([]signature.PublicKey, error) {
-	keys := make([]signature.PublicKey, 0, len(raw))
+// initCDCDrainMode handles drain mode startup: CDC is disabled, but chunked NARs
+// may remain from when it was enabled. It counts them and either auto-completes
+// the drain (clearing the stored config, if any, when none remain) or returns
+// the chunk store to serve them read-only until migrated back. The chunked NARs
+// are counted even when no CDC config is stored, so they stay servable whatever
+// happened to the config.
+func initCDCDrainMode(
+	ctx context.Context,
+	cfg *config.Config,
+	dbClient *database.Client,
+	storedWasEnabled bool,
+	newChunkStore cache.ChunkStoreFactory,
+) (chunk.Store, error) {
+	chunkedCount, err := dbClient.Ent().NarFile.Query().
+		Where(entnarfile.TotalChunksGT(0)).
+		Count(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error querying chunked NAR count for drain mode detection: %w", err)
+	}
 
-	for _, r := range raw {
-		// An empty CACHE_TRUSTED_UPLOAD_KEYS env var can yield a [""] slice;
-		// skip blanks so a templated/empty deployment does not fail startup.
-		if r == "" {
-			continue
+	if chunkedCount == 0 {
+		if !storedWasEnabled {
+			return nil, nil //nolint:nilnil // no chunked NAR to serve
 		}
 
-		pk, err := signature.ParsePublicKey(r)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing trusted upload key %q: %w", r, err)
+		if err := cfg.DeleteCDCConfig(ctx); err != nil {
+			return nil, fmt.Errorf("error clearing CDC config after drain completion: %w", err)
 		}
 
-		keys = append(keys, pk)
+		zerolog.Ctx(ctx).Info().Msg("CDC drain complete: all NARs migrated, stored config cleared")
+
+		return nil, nil //nolint:nilnil // no chunked NAR to serve
 	}
 
-	return keys, nil
+	chunkStore, err := newChunkStore(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error creating chunk storage backend for CDC drain mode: %w", err)
+	}
+
+	zerolog.Ctx(ctx).Warn().
+		Int("chunked_nar_count", chunkedCount).
+		Bool("cdc_config_stored", storedWasEnabled).
+		Msg("CDC disabled with chunked NARs remaining; drain mode active; run migrate-chunks-to-nar")
+
+	return chunkStore, nil
 }
 
-func getUpstreamCaches(ctx context.Context, cmd *cli.Command, netrcData *netrc.Netrc) ([]*upstream.Cache, error) {
-	// Handle backward compatibility for upstream flags (deprecated)
-	deprecatedUpstreamCache := cmd.StringSlice("upstream-cache")
-	upstreamURL := cmd.StringSlice("cache-upstream-url")
-	deprecatedPublicKey := cmd.StringSlice("upstream-public-key")
-	upstreamPublicKey := cmd.StringSlice("cache-upstream-public-key")
-	deprecatedDialerTimeout := cmd.Duration("upstream-dialer-timeout")
-	dialerTimeout := cmd.Duration("cache-upstream-dialer-timeout")
-	deprecatedResponseHeaderTimeout := cmd.Duration("upstream-response-header-timeout")
-	responseHeaderTimeout := cmd.Duration("cache-upstream-response-header-timeout")
+// setupFaultInjection enables the fault injection of NCPS_FAULT_INJECTION, if
+// set: it wraps the database driver and returns a context carrying the
+// injector, for the stores and the upstream caches to wrap.
+func setupFaultInjection(ctx context.Context, dbClient *database.Client) (context.Context, error) {
+	faults, err := faultinject.FromEnv()
+	if err != nil {
+		return ctx, err
+	}
 
-	// Show deprecation warning for upstream-cache
-	if len(deprecatedUpstreamCache) > 0 {
-		zerolog.Ctx(ctx).Warn().
-			Msg("--upstream-cache is deprecated, please use --cache-upstream-url instead")
+	if faults == nil {
+		return ctx, nil
+	}
 
-		if len(upstreamURL) > 0 {
-			zerolog.Ctx(ctx).Warn().
-				Msg("Both --upstream-cache and --cache-upstream-url are set; ignoring the deprecated --upstream-cache")
-		} else {
-			// Use deprecated value if new one is not set
-			upstreamURL = deprecatedUpstreamCache
-		}
+	if err := dbClient.WrapDriver(faults.Driver); err != nil {
+		return ctx, fmt.Errorf("error injecting faults into the database: %w", err)
 	}
 
-	// This block is a workaround the fact that --cache-upstream-url cannot be
-	// marked as required in order to support the deprecated flag
-	// --upstream-cache.
-	// TODO: Remove this block and the custom error once the --cache-upstream-url
-	// flag is marked as required above.
-	{
-		// Filter out empty upstream URLs before validation.
-		var validUpstreamURLs []string
+	zerolog.Ctx(ctx).
+		Warn().
+		Str("faults", faults.String()).
+		Msg("injecting faults into the storage, the database and the upstreams; never enable it in production")
 
-		for _, u := range upstreamURL {
-			if u != "" {
-				validUpstreamURLs = append(validUpstreamURLs, u)
-			}
-		}
+	return faults.WithContext(ctx), nil
+}
 
-		upstreamURL = validUpstreamURLs
+// validateZstdWindowLog validates the zstd window log flags.
+func validateZstdWindowLog(windowLog int) error {
+	return zstd.EncoderOptions{WindowLog: windowLog}.Validate()
+}
 
-		// Validate that at least one upstream cache is configured
-		if len(upstreamURL) == 0 {
-			return nil, ErrUpstreamCacheRequired
-		}
+// setZstdEncoderOptions sets the zstd encoder options of the chunk and
+// whole-file NAR compression paths.
+func setZstdEncoderOptions(cmd *cli.Command) error {
+	if err := zstd.SetChunkEncoderOptions(zstd.EncoderOptions{
+		WindowLog:            cmd.Int("cache-zstd-chunk-window-log"),
+		LongDistanceMatching: cmd.Bool("cache-zstd-chunk-long"),
+	}); err != nil {
+		return fmt.Errorf("error setting the zstd chunk encoder options: %w", err)
 	}
 
-	// Show deprecation warning for upstream-public-key
-	if len(deprecatedPublicKey) > 0 {
-		zerolog.Ctx(ctx).Warn().
-			Msg("--upstream-public-key is deprecated, please use --cache-upstream-public-key instead")
-
-		if len(upstreamPublicKey) > 0 {
-			zerolog.Ctx(ctx).Warn().
-				Msg("Both --upstream-public-key and --cache-upstream-public-key are set; " +
-					"ignoring the deprecated --upstream-public-key")
-		} else {
-			// Use deprecated value if new one is not set
-			upstreamPublicKey = deprecatedPublicKey
-		}
+	if err := zstd.SetNarEncoderOptions(zstd.EncoderOptions{
+		WindowLog:            cmd.Int("cache-zstd-nar-window-log"),
+		LongDistanceMatching: cmd.Bool("cache-zstd-nar-long"),
+	}); err != nil {
+		return fmt.Errorf("error setting the zstd nar encoder options: %w", err)
 	}
 
-	// Show deprecation warning for upstream-dialer-timeout
-	// Only warn if the value differs from the default (3s)
-	if deprecatedDialerTimeout != 3*time.Second {
-		zerolog.Ctx(ctx).Warn().
-			Msg("--upstream-dialer-timeout is deprecated, please use --cache-upstream-dialer-timeout instead")
+	return nil
+}
 
-		if dialerTimeout != 3*time.Second {
-			zerolog.Ctx(ctx).Warn().
-				Msg("Both --upstream-dialer-timeout and --cache-upstream-dialer-timeout are set; " +
-					"ignoring the deprecated --upstream-dialer-timeout")
-		} else {
-			// Use deprecated value if new one is not set
-			dialerTimeout = deprecatedDialerTimeout
-		}
+func createCache(
+	ctx context.Context,
+	cmd *cli.Command,
+	dbClient *database.Client,
+	locker lock.Locker,
+	rwLocker lock.RWLocker,
+	ucs []*upstream.Cache,
+) (*cache.Cache, error) {
+	if err := setZstdEncoderOptions(cmd); err != nil {
+		return nil, err
 	}
 
-	// Show deprecation warning for upstream-response-header-timeout
-	// Only warn if the value differs from the default (3s)
-	if deprecatedResponseHeaderTimeout != 3*time.Second {
-		zerolog.Ctx(ctx).Warn().
-			Msg("--upstream-response-header-timeout is deprecated, " +
-				"please use --cache-upstream-response-header-timeout instead")
-
-		if responseHeaderTimeout != 3*time.Second {
-			zerolog.Ctx(ctx).Warn().
-				Msg("Both --upstream-response-header-timeout and --cache-upstream-response-header-timeout are set; " +
-					"ignoring the deprecated --upstream-response-header-timeout")
-		} else {
-			// Use deprecated value if new one is not set
-			responseHeaderTimeout = deprecatedResponseHeaderTimeout
-		}
+	configStore, narInfoStore, narStore, err := getStorageBackend(ctx, cmd)
+	if err != nil {
+		return nil, err
 	}
 
-	ucs := make([]*upstream.Cache, 0, len(upstreamURL))
+	narStore, err = wrapInlineNarStore(ctx, cmd, dbClient, narStore)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, us := range upstreamURL {
-		u, err := url.Parse(us)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing --cache-upstream-url=%q: %w", us, err)
-		}
+	faults := faultinject.Ctx(ctx)
+	configStore = faults.ConfigStore(configStore)
+	narInfoStore = faults.NarInfoStore(narInfoStore)
+	narStore = faults.NarStore(narStore)
 
-		// Build options for this upstream cache
-		opts := &upstream.Options{
-			DialerTimeout:         dialerTimeout,
-			ResponseHeaderTimeout: responseHeaderTimeout,
-		}
+	hostName := cmd.String("cache-hostname")
+	if hostName == "" {
+		hostName = "localhost"
+	}
 
-		// Find public keys for this upstream
-		rx := regexp.MustCompile(fmt.Sprintf(`^%s-[0-9]+:[A-Za-z0-9+/=]+$`, regexp.QuoteMeta(u.Host)))
-		for _, pubKey := range upstreamPublicKey {
-			if rx.MatchString(pubKey) {
-				opts.PublicKeys = append(opts.PublicKeys, pubKey)
-			}
-		}
+	var loc *time.Location
 
-		// Get credentials for this hostname
-		if netrcData != nil {
-			if machine := netrcData.FindMachine(u.Hostname()); machine != nil {
-				opts.NetrcCredentials = &upstream.NetrcCredentials{
-					Username: machine.Login,
-					Password: machine.Password,
-				}
-			}
-		}
+	if cronTimezone := cmd.String("cache-lru-schedule-timezone"); cronTimezone != "" {
+		var err error
 
-		uc, err := upstream.New(ctx, u, opts)
+		loc, err = time.LoadLocation(cronTimezone)
 		if err != nil {
-			return nil, fmt.Errorf("error creating a new upstream cache: %w", err)
+			return nil, fmt.Errorf("error parsing the timezone %q: %w", cronTimezone, err)
 		}
-
-		ucs = append(ucs, uc)
 	}
 
-	return ucs, nil
-}
-
-func getStorageConfig(ctx context.Context, cmd *cli.Command) (string, *s3config.Config, error) {
-	deprecatedDataPath := cmd.String("cache-data-path")
-	localDataPath := cmd.String("cache-storage-local")
-	s3Bucket := cmd.String("cache-storage-s3-bucket")
+	zerolog.Ctx(ctx).
+		Info().
+		Str("time_zone", loc.String()).
+		Msg("setting up the cache timezone location")
 
-	// Show deprecation warning if old flag is used
-	if deprecatedDataPath != "" {
-		zerolog.Ctx(ctx).Warn().
-			Msg("--cache-data-path is deprecated, please use --cache-storage-local instead")
+	lruScheduleStr := cmd.String("cache-lru-schedule")
 
-		if localDataPath != "" {
-			zerolog.Ctx(ctx).Warn().
-				Msg("Both --cache-data-path and --cache-storage-local are set; ignoring the deprecated --cache-data-path")
-		} else {
-			// Use deprecated value if new one is not set
-			localDataPath = deprecatedDataPath
-		}
+	maxSize, chunkStoreMaxSize, err := getLRUMaxSizes(ctx, cmd, lruScheduleStr)
+	if err != nil {
+		return nil, err
 	}
 
-	if localDataPath != "" && s3Bucket != "" {
-		return "", nil, ErrStorageConflict
-	}
+	var features cacheFeatures
+
+	c, err := proxy.NewCache(ctx, proxy.CacheOptions{
+		Hostname:            hostName,
+		DBClient:            dbClient,
+		ConfigStore:         configStore,
+		NarInfoStore:        narInfoStore,
+		NarStore:            narStore,
+		SecretKeyPath:       cmd.String("cache-secret-key-path"),
+		TempDir:             cmd.String("cache-temp-path"),
+		Locker:              locker,
+		RWLocker:            rwLocker,
+		DownloadLockTTL:     cmd.Duration("cache-lock-download-ttl"),
+		DownloadPollTimeout: cmd.Duration("cache-download-poll-timeout"),
+		LRULockTTL:          cmd.Duration("cache-lock-lru-ttl"),
+		Upstreams:           ucs,
+		Configure: func(c *cache.Cache) error {
+			var err error
+
+			features, err = configureCache(ctx, cmd, dbClient, locker, rwLocker, hostName, c)
 
-	if localDataPath == "" && s3Bucket == "" {
-		return "", nil, ErrStorageConfigRequired
+			return err
+		},
+		Timezone:          loc,
+		MaxSize:           maxSize,
+		ChunkStoreMaxSize: chunkStoreMaxSize,
+		LRUSchedule:       lruScheduleStr,
+		Schedule: func(c *cache.Cache) error {
+			return addCacheCronJobs(ctx, cmd, dbClient, c, features)
+		},
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if localDataPath != "" {
-		return localDataPath, nil, nil
-	}
+	c.StartCron(ctx)
 
-	s3Cfg := &s3config.Config{
-		Bucket:          s3Bucket,
-		Region:          cmd.String("cache-storage-s3-region"),
-		Endpoint:        cmd.String("cache-storage-s3-endpoint"),
-		AccessKeyID:     cmd.String("cache-storage-s3-access-key-id"),
-		SecretAccessKey: cmd.String("cache-storage-s3-secret-access-key"),
-		ForcePathStyle:  cmd.Bool("cache-storage-s3-force-path-style"),
-	}
+	return c, nil
+}
 
-	if err := s3config.ValidateConfig(*s3Cfg); err != nil {
-		return "", nil, err
+// cacheFeatures are the features configureCache enabled that add cron jobs.
+type cacheFeatures struct {
+	cdcEnabled             bool
+	cdcLazyChunkingEnabled bool
+	inflightStagingActive  bool
+}
+
+// getLRUMaxSizes returns the maximum sizes of the NARs and of the chunks the
+// LRU enforces, both zero if the LRU is not scheduled.
+func getLRUMaxSizes(ctx context.Context, cmd *cli.Command, lruScheduleStr string) (uint64, uint64, error) {
+	if lruScheduleStr == "" {
+		return 0, 0, nil
 	}
 
-	return "", s3Cfg, nil
-}
+	maxSizeStr := cmd.String("cache-max-size")
+	chunkStoreMaxSizeStr := cmd.String("cache-cdc-store-max-size")
 
-//nolint:staticcheck // deprecated: migration support
-func getStorageBackend(
-	ctx context.Context,
-	cmd *cli.Command,
-) (storage.ConfigStore, storage.NarInfoStore, storage.NarStore, error) {
-	localDataPath, s3Cfg, err := getStorageConfig(ctx, cmd)
-	if err != nil {
-		return nil, nil, nil, err
+	if maxSizeStr == "" && chunkStoreMaxSizeStr == "" {
+		return 0, 0, ErrCacheMaxSizeRequired
 	}
 
-	switch {
-	case localDataPath != "":
-		return createLocalStorage(ctx, localDataPath)
+	var maxSize, chunkStoreMaxSize uint64
 
-	case s3Cfg != nil:
-		return createS3Storage(ctx, *s3Cfg)
+	if maxSizeStr != "" {
+		var err error
 
-	default:
-		// This should never happen because getStorageConfig returns an error if neither is set
-		return nil, nil, nil, ErrStorageConfigRequired
-	}
-}
+		maxSize, err = helper.ParseSize(maxSizeStr)
+		if err != nil {
+			return 0, 0, fmt.Errorf("error parsing the size: %w", err)
+		}
 
-//nolint:staticcheck // deprecated: migration support
-func createLocalStorage(
-	ctx context.Context,
-	dataPath string,
-) (storage.ConfigStore, storage.NarInfoStore, storage.NarStore, error) {
-	localStore, err := localstorage.New(ctx, dataPath)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("error creating a new local store at %q: %w", dataPath, err)
+		zerolog.Ctx(ctx).
+			Info().
+			Uint64("max-size", maxSize).
+			Msg("setting up the cache max-size")
 	}
 
-	zerolog.Ctx(ctx).Info().Str("path", dataPath).Msg("using local storage")
+	if chunkStoreMaxSizeStr != "" {
+		var err error
+
+		chunkStoreMaxSize, err = helper.ParseSize(chunkStoreMaxSizeStr)
+		if err != nil {
+			return 0, 0, fmt.Errorf("error parsing the chunk store size: %w", err)
+		}
 
-	// Check if the narinfo directory exists
-	exist, err := localStore.HasNarinfoDir()
-	if err != nil {
-		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to check for local narinfo directory")
-	} else if exist {
 		zerolog.Ctx(ctx).
-			Warn().
-			Str("component", "storage").
-			Str("action_required", "Migrate narinfo to database").
-			Str("instructions", "Run `ncps migrate-narinfo --help`").
-			Msg("Local 'narinfo' directory detected. File-based narinfo storage" +
-				" is deprecated and will be removed in the next release.")
+			Info().
+			Uint64("chunk-store-max-size", chunkStoreMaxSize).
+			Msg("setting up the chunk store max-size")
 	}
 
-	return localStore, localStore, localStore, nil
+	return maxSize, chunkStoreMaxSize, nil
 }
 
-//nolint:staticcheck // deprecated: migration support
-func createS3Storage(
+// configureCache applies the settings of the flags to c, before its upstreams
+// are added.
+func configureCache(
 	ctx context.Context,
-	s3Cfg s3config.Config,
-) (storage.ConfigStore, storage.NarInfoStore, storage.NarStore, error) {
-	ctx = zerolog.Ctx(ctx).
-		With().
-		Str("bucket", s3Cfg.Bucket).
-		Str("endpoint", s3Cfg.Endpoint).
-		Bool("force_path_style", s3Cfg.ForcePathStyle).
-		Logger().
-		WithContext(ctx)
+	cmd *cli.Command,
+	dbClient *database.Client,
+	locker lock.Locker,
+	rwLocker lock.RWLocker,
+	hostName string,
+	c *cache.Cache,
+) (cacheFeatures, error) {
+	c.SetCacheSignNarinfo(cmd.Bool("cache-sign-narinfo"))
 
-	zerolog.Ctx(ctx).Debug().Msg("creating S3 storage")
+	if raw := cmd.String("cache-store-dir-rewrite"); raw != "" {
+		from, to, err := parseStoreDirRewrite(raw)
+		if err != nil {
+			return cacheFeatures{}, err
+		}
 
-	s3Store, err := storageS3.New(ctx, s3Cfg)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("error creating a new S3 store: %w", err)
+		if err := c.SetStoreDirRewrite(from, to); err != nil {
+			return cacheFeatures{}, fmt.Errorf("error setting the store dir rewrite: %w", err)
+		}
 	}
 
-	zerolog.Ctx(ctx).Info().Msg("using S3 storage")
+	narInfoFields := make([]cache.NarInfoField, 0, len(cmd.StringSlice("cache-narinfo-field")))
 
-	// Check if the narinfo directory exists
-	exist, err := s3Store.HasNarinfoDir(ctx)
-	if err != nil {
-		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to check for S3 narinfo directory")
-	} else if exist {
-		zerolog.Ctx(ctx).
-			Warn().
-			Str("component", "storage").
-			Str("action_required", "Migrate narinfo to database").
-			Str("instructions", "Run `ncps migrate-narinfo --help`").
-			Msg("S3 'narinfo' directory detected. S3-based narinfo storage" +
-				" is deprecated and will be removed in the next release.")
+	for _, raw := range cmd.StringSlice("cache-narinfo-field") {
+		field, err := cache.ParseNarInfoField(raw)
+		if err != nil {
+			return cacheFeatures{}, err
+		}
+
+		narInfoFields = append(narInfoFields, field)
 	}
 
-	return s3Store, s3Store, s3Store, nil
-}
+	c.SetNarInfoFields(narInfoFields)
 
-func createDatabaseClient(cmd *cli.Command) (*database.Client, error) {
-	dbURL := cmd.String("cache-database-url")
+	c.SetNarInfoAliases(cmd.Bool("cache-narinfo-aliases"))
 
-	// Build pool configuration from flags
-	var poolCfg *database.PoolConfig
+	extSigner, err := newSigner(ctx, cmd, hostName)
+	if err != nil {
+		return cacheFeatures{}, err
+	}
 
-	maxOpen := cmd.Int("cache-database-pool-max-open-conns")
+	if extSigner != nil {
+		c.SetSigner(extSigner)
 
-	maxIdle := cmd.Int("cache-database-pool-max-idle-conns")
-	if maxOpen > 0 || maxIdle > 0 {
-		poolCfg = &database.PoolConfig{
-			MaxOpenConns: maxOpen,
-			MaxIdleConns: maxIdle,
-		}
+		zerolog.Ctx(ctx).Info().
+			Str("backend", cmd.String("cache-signing-backend")).
+			Str("public_key", extSigner.PublicKey().String()).
+			Msg("signing with an external key")
 	}
 
-	dbClient, err := database.Open(dbURL, poolCfg)
+	fetchStrategy, err := cache.ParseUpstreamFetchStrategy(cmd.String("cache-upstream-fetch-strategy"))
 	if err != nil {
-		// Avoid embedding dbURL — it may contain user:password credentials.
-		return nil, fmt.Errorf("error opening the database: %w", err)
+		return cacheFeatures{}, err
 	}
 
-	return dbClient, nil
-}
+	c.SetUpstreamFetchStrategy(fetchStrategy)
 
-func getChunkStorageBackend(ctx context.Context, cmd *cli.Command, locker lock.Locker) (chunk.Store, error) {
-	localDataPath, s3Cfg, err := getStorageConfig(ctx, cmd)
+	narInfoCompression, err := cache.ParseNarInfoCompression(cmd.String("cache-narinfo-compression"))
 	if err != nil {
-		return nil, err
+		return cacheFeatures{}, err
 	}
 
-	switch {
-	case localDataPath != "":
-		// Use {localDataPath}/store as base for chunks to match other stores
-		return chunk.NewLocalStore(filepath.Join(localDataPath, "store"))
-	case s3Cfg != nil:
-		return chunk.NewS3Store(ctx, *s3Cfg, locker)
-	default:
-		// This should never happen because getStorageConfig returns an error if neither is set
-		return nil, ErrStorageConfigRequired
+	c.SetNarInfoCompression(narInfoCompression)
+
+	missingReferencesPolicy, err := cache.ParseMissingReferencesPolicy(cmd.String("cache-missing-references-policy"))
+	if err != nil {
+		return cacheFeatures{}, err
 	}
-}
 
-// initCDCDrainMode handles drain mode startup: CDC was previously enabled but is now disabled.
-// It counts remaining chunked NARs and either auto-completes the drain (clearing the stored
-// config when none remain) or initializes the chunk store read-only for in-progress drain.
-func initCDCDrainMode(
-	ctx context.Context,
-	cmd *cli.Command,
-	locker lock.Locker,
-	c *cache.Cache,
-	cfg *config.Config,
-	dbClient *database.Client,
-) error {
-	chunkedCount, err := dbClient.Ent().NarFile.Query().
-		Where(entnarfile.TotalChunksGT(0)).
-		Count(ctx)
+	c.SetMissingReferencesPolicy(
+		missingReferencesPolicy,
+		cmd.Int("cache-missing-references-concurrency"),
+		cmd.Duration("cache-missing-references-timeout"),
+	)
+	c.SetNarInfoHedging(cmd.Duration("cache-upstream-narinfo-hedge-delay"))
+
+	conflictPolicy, err := cache.ParseNarInfoConflictPolicy(cmd.String("cache-upstream-narinfo-conflict-policy"))
 	if err != nil {
-		return fmt.Errorf("error querying chunked NAR count for drain mode detection: %w", err)
+		return cacheFeatures{}, err
 	}
 
-	if chunkedCount == 0 {
-		if err := cfg.DeleteCDCConfig(ctx); err != nil {
-			return fmt.Errorf("error clearing CDC config after drain completion: %w", err)
+	preferredKeys := make([]signature.PublicKey, 0, len(cmd.StringSlice("cache-upstream-narinfo-prefer-signed-by")))
+
+	for _, raw := range cmd.StringSlice("cache-upstream-narinfo-prefer-signed-by") {
+		pk, err := signature.ParsePublicKey(strings.TrimSpace(raw))
+		if err != nil {
+			return cacheFeatures{}, fmt.Errorf("error parsing the preferred narinfo key %q: %w", raw, err)
 		}
 
-		zerolog.Ctx(ctx).Info().Msg("CDC drain complete: all NARs migrated, stored config cleared")
+		preferredKeys = append(preferredKeys, pk)
+	}
 
-		return nil
+	if err := c.SetNarInfoConflictPolicy(conflictPolicy, preferredKeys); err != nil {
+		return cacheFeatures{}, err
 	}
 
-	chunkStore, err := getChunkStorageBackend(ctx, cmd, locker)
+	narSizeCheck, err := cache.ParseNarSizeCheck(cmd.String("cache-upstream-nar-size-check"))
 	if err != nil {
-		return fmt.Errorf("error creating chunk storage backend for CDC drain mode: %w", err)
+		return cacheFeatures{}, err
 	}
 
-	c.SetChunkStore(chunkStore)
-
-	zerolog.Ctx(ctx).Warn().
-		Int("chunked_nar_count", chunkedCount).
-		Msg("CDC disabled with chunked NARs remaining; drain mode active; run migrate-chunks-to-nar")
+	c.SetUpstreamNarSizeCheck(narSizeCheck, cmd.Float("cache-upstream-nar-size-tolerance"))
 
-	return nil
-}
+	if rawURLs := nonEmpty(cmd.StringSlice("cache-upstream-ipfs-gateway")); len(rawURLs) > 0 {
+		gateways, err := ipfs.New(rawURLs)
+		if err != nil {
+			return cacheFeatures{}, err
+		}
 
-func createCache(
-	ctx context.Context,
-	cmd *cli.Command,
-	dbClient *database.Client,
-	locker lock.Locker,
-	rwLocker lock.RWLocker,
-	ucs []*upstream.Cache,
-) (*cache.Cache, error) {
-	configStore, narInfoStore, narStore, err := getStorageBackend(ctx, cmd)
-	if err != nil {
-		return nil, err
-	}
+		c.SetIPFSGateways(gateways)
 
-	hostName := cmd.String("cache-hostname")
-	if hostName == "" {
-		hostName = "localhost"
+		zerolog.Ctx(ctx).Info().Strs("gateways", gateways.URLs()).Msg("falling back to the IPFS gateways for the nars")
 	}
 
-	c, err := cache.New(
-		ctx,
-		hostName,
-		dbClient,
-		configStore,
-		narInfoStore,
-		narStore,
-		cmd.String("cache-secret-key-path"),
-		locker,
-		rwLocker,
-		cmd.Duration("cache-lock-download-ttl"),
-		cmd.Duration("cache-download-poll-timeout"),
-		cmd.Duration("cache-lock-lru-ttl"),
-	)
+	touchMode, err := cache.ParseTouchMode(cmd.String("cache-touch-mode"))
 	if err != nil {
-		return nil, fmt.Errorf("error creating a new cache: %w", err)
+		return cacheFeatures{}, err
 	}
 
-	if err := c.SetTempDir(cmd.String("cache-temp-path")); err != nil {
-		return nil, fmt.Errorf("error setting cache temp dir: %w", err)
-	}
+	c.SetTouchMode(touchMode)
+	c.SetRecordAgeIgnoreTouch(cmd.Duration("cache-touch-record-age"))
 
-	c.SetCacheSignNarinfo(cmd.Bool("cache-sign-narinfo"))
+	if err := c.SetNarCompressionSampling(
+		cmd.Int64("cache-zstd-nar-sample-size"),
+		cmd.Float("cache-zstd-nar-min-ratio"),
+	); err != nil {
+		return cacheFeatures{}, err
+	}
 
 	cfg := config.New(dbClient, rwLocker)
 
@@ -1216,7 +3661,7 @@ func createCache(
 
 		cdcEnabled, cdcMin, cdcAvg, cdcMax, err = loadCDCConfigFromDB(ctx, cfg, cdcEnabled, cdcEnabledWasSet)
 		if err != nil {
-			return nil, err
+			return cacheFeatures{}, err
 		}
 	}
 
@@ -1227,11 +3672,11 @@ func createCache(
 	if storedEnabledErr == nil {
 		storedWasEnabled = storedEnabledStr == configValueTrue
 	} else if !errors.Is(storedEnabledErr, config.ErrConfigNotFound) {
-		return nil, fmt.Errorf("failed to read stored CDC enabled state: %w", storedEnabledErr)
+		return cacheFeatures{}, fmt.Errorf("failed to read stored CDC enabled state: %w", storedEnabledErr)
 	}
 
 	if err := cfg.ValidateOrStoreCDCConfig(ctx, cdcEnabled, cdcMin, cdcAvg, cdcMax); err != nil {
-		return nil, fmt.Errorf("CDC configuration validation failed: %w", err)
+		return cacheFeatures{}, fmt.Errorf("CDC configuration validation failed: %w", err)
 	}
 
 	zerolog.Ctx(ctx).
@@ -1243,10 +3688,28 @@ func createCache(
 		Msg("configuring Content-Defined-Chunking (CDC)")
 
 	if err := c.SetCDCConfiguration(cdcEnabled, cdcMin, cdcAvg, cdcMax); err != nil {
-		return nil, fmt.Errorf("error configuring CDC: %w", err)
+		return cacheFeatures{}, fmt.Errorf("error configuring CDC: %w", err)
+	}
+
+	cdcSizeClasses := make([]cache.ChunkSizeClass, 0, len(cmd.StringSlice("cache-cdc-size-class")))
+
+	for _, s := range cmd.StringSlice("cache-cdc-size-class") {
+		class, err := cache.ParseChunkSizeClass(s)
+		if err != nil {
+			return cacheFeatures{}, err
+		}
+
+		cdcSizeClasses = append(cdcSizeClasses, class)
+	}
+
+	if err := c.SetCDCSizeClasses(cdcSizeClasses); err != nil {
+		return cacheFeatures{}, fmt.Errorf("error configuring CDC size classes: %w", err)
 	}
 
 	c.SetChunkWaitTimeout(cmd.Duration("cache-cdc-chunk-wait-timeout"))
+	c.SetChunkRepair(cmd.Bool("cache-cdc-repair-from-upstream"))
+	c.SetCDCUploadStreaming(cmd.Int("cache-cdc-upload-lookahead"))
+	c.SetCDCIngestWorkers(cmd.Int("cache-cdc-ingest-workers"))
 
 	// Configure lazy chunking
 	cdcLazyChunkingEnabled := cmd.Bool("cache-cdc-lazy-chunking-enabled")
@@ -1265,7 +3728,7 @@ func createCache(
 	// is only meaningful with a distributed locker, since a single-instance
 	// deployment can never have a cross-pod waiter; the cache guards on this too.
 	stagingBackend, _ := determineEffectiveLockBackend(cmd)
-	stagingDistributed := stagingBackend == lockBackendRedis
+	stagingDistributed := stagingBackend != lockBackendLocal
 	inflightStagingEnabled := cmd.Bool("cache-inflight-staging-enabled")
 	stagingRetention := cmd.Duration("cache-inflight-staging-retention")
 	stagingPartSize := cmd.Int("cache-inflight-staging-part-size")
@@ -1275,11 +3738,11 @@ func createCache(
 	// disabled deployments are unaffected by the flag defaults.
 	if inflightStagingEnabled {
 		if stagingRetention <= 0 {
-			return nil, ErrStagingRetentionNonPositive
+			return cacheFeatures{}, ErrStagingRetentionNonPositive
 		}
 
 		if stagingPartSize <= 0 {
-			return nil, ErrStagingPartSizeNonPositive
+			return cacheFeatures{}, ErrStagingPartSizeNonPositive
 		}
 	}
 
@@ -1301,83 +3764,87 @@ func createCache(
 	// Configure Chunk Store.
 	//
 	// Full CDC mode: chunk store initialized with write gate on.
-	// Drain mode (storedWasEnabled && !cdcEnabled): CDC was previously active; chunked NARs may
-	// still exist. Initialize the chunk store for reads only (cdcEnabled=false keeps the write
-	// gate off). If no chunked NARs remain, clear the stored config and start fully disabled.
+	// Drain mode (!cdcEnabled with chunked NARs remaining): initialize the chunk store for
+	// reads only (cdcEnabled=false keeps the write gate off) so the chunked NARs are still
+	// assembled on the fly. If CDC was previously active and no chunked NARs remain, clear
+	// the stored config and start fully disabled.
+	newChunkStore := func(ctx context.Context) (chunk.Store, error) {
+		return getChunkStorageBackend(ctx, cmd, locker)
+	}
+
 	if cdcEnabled {
-		chunkStore, err := getChunkStorageBackend(ctx, cmd, locker)
+		chunkStore, err := newChunkStore(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("error creating chunk storage backend: %w", err)
+			return cacheFeatures{}, fmt.Errorf("error creating chunk storage backend: %w", err)
 		}
 
 		c.SetChunkStore(chunkStore)
-	} else if storedWasEnabled {
-		if err := initCDCDrainMode(ctx, cmd, locker, c, cfg, dbClient); err != nil {
-			return nil, err
+	} else {
+		chunkStore, err := initCDCDrainMode(ctx, cfg, dbClient, storedWasEnabled, newChunkStore)
+		if err != nil {
+			return cacheFeatures{}, err
+		}
+
+		if chunkStore != nil {
+			c.SetChunkStore(chunkStore)
 		}
 	}
 
-	c.AddUpstreamCaches(ctx, ucs...)
+	// CDC may be enabled at runtime through the admin API.
+	c.SetChunkStoreFactory(newChunkStore)
+
+	c.SetOffline(ctx, cmd.Bool("offline"))
 
 	uploadKeys, err := parseTrustedUploadKeys(cmd.StringSlice("cache-trusted-upload-key"))
 	if err != nil {
-		return nil, err
+		return cacheFeatures{}, err
 	}
 
 	c.SetCacheTrustedUploadKeys(uploadKeys)
 	c.SetCacheRequireTrustedSignature(cmd.Bool("cache-require-trusted-signature"))
 
-	// Trigger the health-checker to speed-up the boot but do not wait for the check to complete.
-	c.GetHealthChecker().Trigger()
-
-	var loc *time.Location
-
-	if cronTimezone := cmd.String("cache-lru-schedule-timezone"); cronTimezone != "" {
-		var err error
-
-		loc, err = time.LoadLocation(cronTimezone)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing the timezone %q: %w", cronTimezone, err)
-		}
+	if err := setupMaintenanceWindows(ctx, cmd, c); err != nil {
+		return cacheFeatures{}, err
 	}
 
-	zerolog.Ctx(ctx).
-		Info().
-		Str("time_zone", loc.String()).
-		Msg("setting up the cache timezone location")
-
-	c.SetupCron(ctx, loc)
-
-	lruScheduleStr := cmd.String("cache-lru-schedule")
-
-	if lruScheduleStr != "" {
-		maxSizeStr := cmd.String("cache-max-size")
-		if maxSizeStr == "" {
-			return nil, ErrCacheMaxSizeRequired
+	if cmd.String("cache-lru-schedule") != "" {
+		if err := c.SetEvictionExclusions(nonEmpty(cmd.StringSlice("cache-lru-exclude"))); err != nil {
+			return cacheFeatures{}, err
 		}
 
-		maxSize, err := helper.ParseSize(maxSizeStr)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing the size: %w", err)
-		}
+		c.SetLRUDeleteConcurrency(cmd.Int("cache-lru-delete-concurrency"))
+	}
 
-		zerolog.Ctx(ctx).
-			Info().
-			Uint64("max-size", maxSize).
-			Msg("setting up the cache max-size")
+	return cacheFeatures{
+		cdcEnabled:             cdcEnabled,
+		cdcLazyChunkingEnabled: cdcLazyChunkingEnabled,
+		inflightStagingActive:  inflightStagingEnabled && stagingDistributed,
+	}, nil
+}
 
-		c.SetMaxSize(maxSize)
+// addCacheCronJobs adds the cron jobs of the features of c once its cron is
+// set up.
+func addCacheCronJobs(
+	ctx context.Context,
+	cmd *cli.Command,
+	dbClient *database.Client,
+	c *cache.Cache,
+	features cacheFeatures,
+) error {
+	if err := setupSQLiteMaintenance(ctx, cmd, dbClient, c); err != nil {
+		return err
+	}
 
-		schedule, err := cron.ParseStandard(lruScheduleStr)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing the cron spec %q: %w", lruScheduleStr, err)
-		}
+	if err := setupChunkTiering(ctx, cmd, c); err != nil {
+		return err
+	}
 
-		c.AddLRUCronJob(ctx, schedule)
+	if err := setupNarInfoFileHashBackfill(ctx, cmd, c); err != nil {
+		return err
 	}
 
 	// Add CDC delayed cleanup cron job when lazy chunking is enabled
-	if cdcEnabled && cdcLazyChunkingEnabled {
+	if features.cdcEnabled && features.cdcLazyChunkingEnabled {
 		// Configure CDC delete delay for lazy chunking
 		cdcDeleteDelay := cmd.Duration("cache-cdc-delete-delay")
 		c.SetCDCDeleteDelay(cdcDeleteDelay)
@@ -1385,12 +3852,12 @@ func createCache(
 		cdcCleanupScheduleStr := cmd.String("cache-cdc-lazy-cleanup-schedule")
 
 		if cdcCleanupScheduleStr == "" {
-			return nil, ErrCDCCleanupScheduleRequired
+			return ErrCDCCleanupScheduleRequired
 		}
 
 		cdcCleanupSchedule, err := cron.ParseStandard(cdcCleanupScheduleStr)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing CDC cleanup cron spec: %w", err)
+			return fmt.Errorf("error parsing CDC cleanup cron spec: %w", err)
 		}
 
 		zerolog.Ctx(ctx).
@@ -1402,14 +3869,14 @@ func createCache(
 		c.AddCDCDeletedCleanupCronJob(ctx, cdcCleanupSchedule)
 	}
 
-	if err := addCDCRecoveryCronJob(ctx, cmd, c, cdcEnabled, cdcLazyChunkingEnabled); err != nil {
-		return nil, err
+	if err := addCDCRecoveryCronJob(ctx, cmd, c, features.cdcEnabled, features.cdcLazyChunkingEnabled); err != nil {
+		return err
 	}
 
 	// Periodic in-flight staging GC: reclaims completed staging past its retention
 	// grace and orphaned staging whose holder died. Only meaningful when staging is
 	// active (enabled + distributed locker).
-	if inflightStagingEnabled && stagingDistributed {
+	if features.inflightStagingActive {
 		zerolog.Ctx(ctx).
 			Info().
 			Msg("setting up in-flight staging GC cron job")
@@ -1417,9 +3884,72 @@ func createCache(
 		c.AddInflightStagingGCCronJob(ctx, cron.Every(time.Minute))
 	}
 
-	c.StartCron(ctx)
+	return nil
+}
 
-	return c, nil
+// setupNarInfoIndex schedules the rebuild of the narinfo index, if enabled,
+// and starts building it at once.
+func setupNarInfoIndex(ctx context.Context, cmd *cli.Command, c *cache.Cache) error {
+	scheduleStr := cmd.String("cache-narinfo-index-schedule")
+	if scheduleStr == "" {
+		return nil
+	}
+
+	schedule, err := cron.ParseStandard(scheduleStr)
+	if err != nil {
+		return fmt.Errorf("error parsing the narinfo index cron spec %q: %w", scheduleStr, err)
+	}
+
+	c.AddNarInfoIndexCronJob(ctx, schedule)
+
+	if err := c.TriggerCronJob(ctx, cache.CronJobNarInfoIndex); err != nil {
+		return fmt.Errorf("error building the narinfo index: %w", err)
+	}
+
+	return nil
+}
+
+// setupUpstreamNarInfoBloom schedules the refresh of the bloom filters of the
+// upstreams, if enabled, and fetches them at once.
+func setupUpstreamNarInfoBloom(ctx context.Context, cmd *cli.Command, c *cache.Cache) error {
+	scheduleStr := cmd.String("cache-upstream-bloom-schedule")
+	if scheduleStr == "" {
+		return nil
+	}
+
+	schedule, err := cron.ParseStandard(scheduleStr)
+	if err != nil {
+		return fmt.Errorf("error parsing the upstream bloom cron spec %q: %w", scheduleStr, err)
+	}
+
+	c.AddUpstreamNarInfoBloomCronJob(ctx, schedule)
+
+	if err := c.TriggerCronJob(ctx, cache.CronJobUpstreamBloom); err != nil {
+		return fmt.Errorf("error fetching the upstream bloom filters: %w", err)
+	}
+
+	return nil
+}
+
+// startNarInfoWarmUp preloads the most recently accessed narinfos in the
+// background when --cache-narinfo-warm-up-count is set, the server answering
+// /readyz with a 503 until they are loaded.
+func startNarInfoWarmUp(ctx context.Context, cmd *cli.Command, c *cache.Cache) {
+	count := cmd.Int("cache-narinfo-warm-up-count")
+	if count <= 0 {
+		return
+	}
+
+	c.SetNarInfoWarmUp(count, cmd.Duration("cache-narinfo-warm-up-ttl"))
+
+	analytics.SafeGo(ctx, func() {
+		if _, err := c.WarmUp(ctx); err != nil {
+			zerolog.Ctx(ctx).
+				Warn().
+				Err(err).
+				Msg("error warming up the narinfos; the instance is ready without them")
+		}
+	})
 }
 
 func addCDCRecoveryCronJob(
@@ -1535,7 +4065,10 @@ func detectExtraResourceAttrs(
 	var attrs []attribute.KeyValue
 
 	// 1. Identify Database Type
-	dbURL := cmd.String("cache-database-url")
+	dbURL, err := secretValue(cmd, "cache-database-url")
+	if err != nil {
+		return nil, err
+	}
 
 	dbType, err := database.DetectFromDatabaseURL(dbURL)
 	if err != nil {
@@ -1630,6 +4163,47 @@ func determineEffectiveLockBackend(cmd *cli.Command) (backend string, validRedis
 	return backend, validRedisAddrs
 }
 
+// getNarInfoMetadataCache connects to the Redis narinfo metadata cache shared
+// by the replicas when --cache-narinfo-metadata-cache-ttl is set. It returns
+// nil otherwise.
+func getNarInfoMetadataCache(ctx context.Context, cmd *cli.Command) (*narinfocache.Redis, error) {
+	ttl := cmd.Duration("cache-narinfo-metadata-cache-ttl")
+	if ttl <= 0 {
+		return nil, nil //nolint:nilnil // the metadata cache is optional
+	}
+
+	_, redisAddrs := determineEffectiveLockBackend(cmd)
+	if len(redisAddrs) == 0 {
+		return nil, ErrNarInfoMetadataCacheRedisRequired
+	}
+
+	redisPassword, err := secretValue(cmd, "cache-redis-password")
+	if err != nil {
+		return nil, err
+	}
+
+	mc, err := narinfocache.NewRedis(ctx, narinfocache.RedisConfig{
+		Addr:      redisAddrs[0],
+		Username:  cmd.String("cache-redis-username"),
+		Password:  redisPassword,
+		DB:        cmd.Int("cache-redis-db"),
+		UseTLS:    cmd.Bool("cache-redis-use-tls"),
+		PoolSize:  cmd.Int("cache-redis-pool-size"),
+		KeyPrefix: cmd.String("cache-narinfo-metadata-cache-key-prefix"),
+		TTL:       ttl,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating the Redis narinfo metadata cache: %w", err)
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("addr", redisAddrs[0]).
+		Dur("ttl", ttl).
+		Msg("narinfo metadata cache enabled with Redis")
+
+	return mc, nil
+}
+
 func getLockers(
 	ctx context.Context,
 	cmd *cli.Command,
@@ -1666,11 +4240,18 @@ func getLockers(
 			return nil, nil, ErrRedisAddrsRequired
 		}
 
+		var redisPassword string
+
+		redisPassword, err = secretValue(cmd, "cache-redis-password")
+		if err != nil {
+			return nil, nil, err
+		}
+
 		// Redis configured - use distributed locks
 		redisCfg := redis.Config{
 			Addrs:     validRedisAddrs,
 			Username:  cmd.String("cache-redis-username"),
-			Password:  cmd.String("cache-redis-password"),
+			Password:  redisPassword,
 			DB:        cmd.Int("cache-redis-db"),
 			UseTLS:    cmd.Bool("cache-redis-use-tls"),
 			PoolSize:  cmd.Int("cache-redis-pool-size"),
@@ -1691,6 +4272,31 @@ func getLockers(
 			Strs("addrs", redisCfg.Addrs).
 			Msg("distributed locking enabled with Redis")
 
+	case lockBackendFile:
+		dir := cmd.String(flagNameLockFileDir)
+		if dir == "" {
+			localDataPath := cmd.String(flagNameStorageLocal)
+			if localDataPath == "" {
+				return nil, nil, ErrLockFileDirRequired
+			}
+
+			dir = filepath.Join(localDataPath, "locks")
+		}
+
+		locker, err = filelock.NewLocker(dir, retryCfg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating file locker: %w", err)
+		}
+
+		rwLocker, err = filelock.NewRWLocker(dir, retryCfg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating file RW locker: %w", err)
+		}
+
+		zerolog.Ctx(ctx).Info().
+			Str("dir", dir).
+			Msg("distributed locking enabled with lock files")
+
 	case lockBackendLocal:
 		// No distributed backend - use local locks (single-instance mode)
 		locker = local.NewLocker()
@@ -1701,7 +4307,7 @@ func getLockers(
 			Msg("using local locks (single-instance mode)")
 
 	default:
-		return nil, nil, fmt.Errorf("%w: %s (must be 'local' or 'redis')",
+		return nil, nil, fmt.Errorf("%w: %s (must be 'local', 'redis' or 'file')",
 			ErrUnknownLockBackend, backend)
 	}
 
@@ -0,0 +1,85 @@
+package ncps
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/config"
+	locklocal "github.com/kalbasit/ncps/pkg/lock/local"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+)
+
+var errNoChunkStore = errors.New("no chunk store expected")
+
+func TestInitCDCDrainMode(t *testing.T) {
+	t.Parallel()
+
+	newChunkStore := func(t *testing.T) func(context.Context) (chunk.Store, error) {
+		return func(context.Context) (chunk.Store, error) {
+			return chunk.NewLocalStore(t.TempDir())
+		}
+	}
+
+	noChunkStore := func(context.Context) (chunk.Store, error) {
+		return nil, errNoChunkStore
+	}
+
+	t.Run("never enabled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		dbClient := newCDCModeTestDB(t)
+
+		cs, err := initCDCDrainMode(ctx, config.New(dbClient, locklocal.NewRWLocker()), dbClient, false, noChunkStore)
+		require.NoError(t, err)
+		assert.Nil(t, cs)
+	})
+
+	t.Run("drain complete", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		dbClient := newCDCModeTestDB(t)
+		cfg := config.New(dbClient, locklocal.NewRWLocker())
+
+		setCDCEnabled(ctx, t, dbClient)
+
+		cs, err := initCDCDrainMode(ctx, cfg, dbClient, true, noChunkStore)
+		require.NoError(t, err)
+		assert.Nil(t, cs)
+
+		_, err = cfg.GetCDCEnabled(ctx)
+		require.ErrorIs(t, err, config.ErrConfigNotFound, "the stored config is cleared")
+	})
+
+	t.Run("chunked NARs remain", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		dbClient := newCDCModeTestDB(t)
+
+		setCDCEnabled(ctx, t, dbClient)
+		addChunkedNarFile(ctx, t, dbClient, "chunkednarhashaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+
+		cs, err := initCDCDrainMode(ctx, config.New(dbClient, locklocal.NewRWLocker()), dbClient, true, newChunkStore(t))
+		require.NoError(t, err)
+		assert.NotNil(t, cs)
+	})
+
+	t.Run("chunked NARs remain without a stored config", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		dbClient := newCDCModeTestDB(t)
+
+		addChunkedNarFile(ctx, t, dbClient, "chunkednarhashbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+
+		cs, err := initCDCDrainMode(ctx, config.New(dbClient, locklocal.NewRWLocker()), dbClient, false, newChunkStore(t))
+		require.NoError(t, err)
+		assert.NotNil(t, cs, "the chunked NARs are served even though no CDC config is stored")
+	})
+}
@@ -0,0 +1,59 @@
+package ncps
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/config"
+	"github.com/kalbasit/ncps/testhelper"
+
+	locklocal "github.com/kalbasit/ncps/pkg/lock/local"
+)
+
+func TestImportUpstreamPublicKey(t *testing.T) {
+	t.Parallel()
+
+	const publicKey = "cache.nixos.org-1:6NCHdD59X431o0gWypbMrAURkbJ16ZPMQFGspcDShjY="
+
+	var fetches atomic.Int64
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pubkey" {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		fetches.Add(1)
+
+		_, _ = w.Write([]byte(publicKey))
+	}))
+	t.Cleanup(ts.Close)
+
+	dbClient, cleanup := testhelper.SetupSQLite(t)
+	t.Cleanup(cleanup)
+
+	cfg := config.New(dbClient, locklocal.NewRWLocker())
+
+	u := testhelper.MustParseURL(t, ts.URL+"?priority=10")
+
+	for range 2 {
+		uc, err := upstream.New(context.Background(), u, nil)
+		require.NoError(t, err)
+
+		require.NoError(t, importUpstreamPublicKey(context.Background(), cfg, uc, u, ""))
+	}
+
+	assert.Equal(t, int64(1), fetches.Load(), "the recorded key is trusted on later starts")
+
+	recorded, err := cfg.GetUpstreamPublicKey(context.Background(), ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, recorded)
+}
@@ -0,0 +1,131 @@
+package ncps
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+)
+
+func TestGetPresignedRedirectConfig(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		args         []string
+		wantExpiry   time.Duration
+		wantNetworks []netip.Prefix
+		wantErr      error
+		wantAnyErr   bool
+	}{
+		{
+			name: "no networks disables redirects",
+			args: []string{"app"},
+		},
+		{
+			name: "empty network is ignored",
+			args: []string{"app", "--cache-storage-s3-presigned-redirect-network", ""},
+		},
+		{
+			name: "networks are parsed and masked",
+			args: []string{
+				"app",
+				"--cache-storage-s3-bucket", "ncps",
+				"--cache-storage-s3-presigned-redirect-network", "10.1.2.3/8",
+				"--cache-storage-s3-presigned-redirect-network", "fd00::/8",
+			},
+			wantExpiry: 5 * time.Minute,
+			wantNetworks: []netip.Prefix{
+				netip.MustParsePrefix("10.0.0.0/8"),
+				netip.MustParsePrefix("fd00::/8"),
+			},
+		},
+		{
+			name:    "networks without S3 are rejected",
+			args:    []string{"app", "--cache-storage-s3-presigned-redirect-network", "10.0.0.0/8"},
+			wantErr: ErrPresignedRedirectRequiresS3,
+		},
+		{
+			name: "networks with the nars on S3 are accepted",
+			args: []string{
+				"app",
+				"--cache-storage-nar-s3-bucket", "nars",
+				"--cache-storage-s3-presigned-redirect-network", "10.0.0.0/8",
+			},
+			wantExpiry:   5 * time.Minute,
+			wantNetworks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		},
+		{
+			name: "networks with the nars stored locally are rejected",
+			args: []string{
+				"app",
+				"--cache-storage-s3-bucket", "ncps",
+				"--cache-storage-nar-local", "/mnt/nars",
+				"--cache-storage-s3-presigned-redirect-network", "10.0.0.0/8",
+			},
+			wantErr: ErrPresignedRedirectRequiresS3,
+		},
+		{
+			name: "expiry above the S3 limit is rejected",
+			args: []string{
+				"app",
+				"--cache-storage-s3-bucket", "ncps",
+				"--cache-storage-s3-presigned-redirect-network", "10.0.0.0/8",
+				"--cache-storage-s3-presigned-redirect-expiry", "200h",
+			},
+			wantErr: ErrPresignedRedirectExpiryInvalid,
+		},
+		{
+			name: "malformed network is rejected",
+			args: []string{
+				"app",
+				"--cache-storage-s3-bucket", "ncps",
+				"--cache-storage-s3-presigned-redirect-network", "10.0.0.0",
+			},
+			wantAnyErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				gotExpiry   time.Duration
+				gotNetworks []netip.Prefix
+				gotErr      error
+			)
+
+			cmd := &cli.Command{
+				Name: "app",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{Name: flagNameS3Bucket},
+					&cli.StringSliceFlag{Name: "cache-storage-s3-presigned-redirect-network"},
+					&cli.DurationFlag{Name: "cache-storage-s3-presigned-redirect-expiry", Value: 5 * time.Minute},
+				}, storeFlags(func(string, string) cli.ValueSourceChain { return cli.ValueSourceChain{} })...),
+				Action: func(_ context.Context, c *cli.Command) error {
+					gotExpiry, gotNetworks, gotErr = getPresignedRedirectConfig(c)
+
+					return nil
+				},
+			}
+
+			require.NoError(t, cmd.Run(context.Background(), tt.args))
+
+			switch {
+			case tt.wantErr != nil:
+				require.ErrorIs(t, gotErr, tt.wantErr)
+			case tt.wantAnyErr:
+				require.Error(t, gotErr)
+			default:
+				require.NoError(t, gotErr)
+				assert.Equal(t, tt.wantExpiry, gotExpiry)
+				assert.Equal(t, tt.wantNetworks, gotNetworks)
+			}
+		})
+	}
+}
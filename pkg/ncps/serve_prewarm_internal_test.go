@@ -0,0 +1,124 @@
+package ncps
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+)
+
+func TestSetupPrewarm_Validation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr error
+	}{
+		{name: "no source disables pre-warm", args: []string{"app"}},
+		{name: "empty sources are ignored", args: []string{"app", "--cache-prewarm-flake", ""}},
+		{
+			name:    "non-http path list is rejected",
+			args:    []string{"app", "--cache-prewarm-path-list-url", "file:///etc/paths"},
+			wantErr: ErrPrewarmPathListURLInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var gotErr error
+
+			cmd := &cli.Command{
+				Name: "app",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{Name: "cache-prewarm-flake"},
+					&cli.StringSliceFlag{Name: "cache-prewarm-path-list-url"},
+					&cli.StringFlag{Name: "cache-prewarm-schedule", Value: "0 5 * * *"},
+					&cli.IntFlag{Name: "cache-prewarm-concurrency", Value: 4},
+					&cli.StringFlag{Name: "cache-prewarm-nix-bin", Value: "nix"},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					// The cache is never reached on these paths.
+					gotErr = setupPrewarm(ctx, c, nil)
+
+					return nil
+				},
+			}
+
+			require.NoError(t, cmd.Run(context.Background(), tt.args))
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, gotErr, tt.wantErr)
+
+				return
+			}
+
+			require.NoError(t, gotErr)
+		})
+	}
+}
+
+func TestSetupChannelPrefetch_Validation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantErr    error
+		wantAnyErr bool
+	}{
+		{name: "no channel disables the pre-fetch", args: []string{"app"}},
+		{
+			name:    "non-http channel is rejected",
+			args:    []string{"app", "--cache-channel-prefetch-url", "channel:nixos-unstable"},
+			wantErr: ErrChannelPrefetchURLInvalid,
+		},
+		{
+			name: "invalid max NAR size is rejected",
+			args: []string{
+				"app",
+				"--cache-channel-prefetch-url", "https://channels.nixos.org/nixos-unstable",
+				"--cache-channel-prefetch-max-nar-size", "10X",
+			},
+			wantAnyErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var gotErr error
+
+			cmd := &cli.Command{
+				Name: "app",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{Name: "cache-channel-prefetch-url"},
+					&cli.StringFlag{Name: "cache-channel-prefetch-schedule", Value: "@every 15m"},
+					&cli.StringFlag{Name: "cache-channel-prefetch-max-nar-size", Value: "10M"},
+					&cli.IntFlag{Name: "cache-prewarm-concurrency", Value: 4},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					// The cache is never reached on these paths.
+					gotErr = setupChannelPrefetch(ctx, c, nil)
+
+					return nil
+				},
+			}
+
+			require.NoError(t, cmd.Run(context.Background(), tt.args))
+
+			switch {
+			case tt.wantErr != nil:
+				require.ErrorIs(t, gotErr, tt.wantErr)
+			case tt.wantAnyErr:
+				require.Error(t, gotErr)
+			default:
+				require.NoError(t, gotErr)
+			}
+		})
+	}
+}
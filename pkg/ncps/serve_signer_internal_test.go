@@ -0,0 +1,90 @@
+package ncps
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+
+	"github.com/kalbasit/ncps/pkg/signer"
+)
+
+func runNewSigner(t *testing.T, args ...string) (signer.Signer, error) {
+	t.Helper()
+
+	var (
+		s   signer.Signer
+		err error
+	)
+
+	cmd := &cli.Command{
+		Name: "app",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "cache-secret-key-path"},
+			&cli.StringFlag{Name: "cache-signing-backend", Value: signingBackendLocal},
+			&cli.StringFlag{Name: "cache-signing-vault-address"},
+			&cli.StringFlag{Name: "cache-signing-vault-token"},
+			&cli.StringFlag{Name: "cache-signing-vault-mount", Value: "transit"},
+			&cli.StringFlag{Name: "cache-signing-vault-key"},
+			&cli.StringFlag{Name: "cache-signing-aws-kms-key-id"},
+			&cli.StringFlag{Name: "cache-signing-aws-kms-region"},
+			&cli.StringFlag{Name: "cache-signing-aws-kms-endpoint"},
+			&cli.StringFlag{Name: "cache-signing-aws-kms-access-key-id"},
+			&cli.StringFlag{Name: "cache-signing-aws-kms-secret-access-key"},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			s, err = newSigner(ctx, c, "cache.example.com")
+
+			return nil
+		},
+	}
+
+	require.NoError(t, cmd.Run(context.Background(), append([]string{"app"}, args...)))
+
+	return s, err
+}
+
+func TestNewSigner(t *testing.T) {
+	t.Parallel()
+
+	t.Run("the local backend signs with the secret key", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := runNewSigner(t, "--cache-secret-key-path=/etc/ncps/secret-key")
+		require.NoError(t, err)
+		assert.Nil(t, s)
+	})
+
+	t.Run("unknown backend", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runNewSigner(t, "--cache-signing-backend=hsm")
+		require.ErrorIs(t, err, ErrInvalidSigningBackend)
+	})
+
+	t.Run("an external backend conflicts with a secret key", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runNewSigner(t,
+			"--cache-signing-backend=vault",
+			"--cache-secret-key-path=/etc/ncps/secret-key",
+		)
+		require.ErrorIs(t, err, ErrSigningBackendConfig)
+	})
+
+	t.Run("missing vault flags", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runNewSigner(t, "--cache-signing-backend=vault", "--cache-signing-vault-address=https://vault:8200")
+		require.ErrorIs(t, err, ErrSigningBackendConfig)
+	})
+
+	t.Run("missing aws-kms flags", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runNewSigner(t, "--cache-signing-backend=aws-kms", "--cache-signing-aws-kms-region=us-east-1")
+		require.ErrorIs(t, err, ErrSigningBackendConfig)
+	})
+}
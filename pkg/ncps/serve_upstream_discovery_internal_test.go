@@ -0,0 +1,95 @@
+package ncps
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+
+	"github.com/kalbasit/ncps/pkg/cache"
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/cache/upstream/discovery"
+)
+
+func runGetUpstreamCaches(t *testing.T, args ...string) ([]*upstream.Cache, cache.UpstreamFactory, error) {
+	t.Helper()
+
+	var (
+		ucs     []*upstream.Cache
+		factory cache.UpstreamFactory
+		err     error
+	)
+
+	cmd := &cli.Command{
+		Name: "app",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{Name: "upstream-cache"},
+			&cli.StringSliceFlag{Name: "cache-upstream-url"},
+			&cli.StringSliceFlag{Name: "upstream-public-key"},
+			&cli.StringSliceFlag{Name: "cache-upstream-public-key"},
+			&cli.DurationFlag{Name: "upstream-dialer-timeout", Value: 3 * time.Second},
+			&cli.DurationFlag{Name: "cache-upstream-dialer-timeout", Value: 3 * time.Second},
+			&cli.DurationFlag{Name: "upstream-response-header-timeout", Value: 3 * time.Second},
+			&cli.DurationFlag{Name: "cache-upstream-response-header-timeout", Value: 3 * time.Second},
+			&cli.StringSliceFlag{Name: "cache-upstream-discovery"},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			ucs, factory, err = getUpstreamCaches(ctx, c, nil, nil, nil)
+
+			return nil
+		},
+	}
+
+	require.NoError(t, cmd.Run(context.Background(), append([]string{"app"}, args...)))
+
+	return ucs, factory, err
+}
+
+func TestGetUpstreamCaches_Discovery(t *testing.T) {
+	t.Parallel()
+
+	t.Run("no upstream and no discovery is rejected", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := runGetUpstreamCaches(t)
+		require.ErrorIs(t, err, ErrUpstreamCacheRequired)
+	})
+
+	t.Run("discovery alone is enough", func(t *testing.T) {
+		t.Parallel()
+
+		ucs, factory, err := runGetUpstreamCaches(t,
+			"--cache-upstream-discovery", "dns+srv://_nix-cache._tcp.example.com",
+		)
+		require.NoError(t, err)
+		assert.Empty(t, ucs)
+		require.NotNil(t, factory)
+	})
+
+	t.Run("factory merges configured and discovered keys", func(t *testing.T) {
+		t.Parallel()
+
+		const (
+			configuredKey = "cache.nixos.org-1:6NCHdD59X431o0gWypbMrAURkbJ16ZPMQFGspcDShjY="
+			discoveredKey = "other.example.com-1:6NCHdD59X431o0gWypbMrAURkbJ16ZPMQFGspcDShjY="
+		)
+
+		_, factory, err := runGetUpstreamCaches(t,
+			"--cache-upstream-public-key", configuredKey,
+			"--cache-upstream-discovery", "https://config.example.com/upstreams.json",
+		)
+		require.NoError(t, err)
+
+		u, err := url.Parse("https://cache.nixos.org")
+		require.NoError(t, err)
+
+		uc, err := factory(context.Background(), discovery.Upstream{URL: u, PublicKeys: []string{discoveredKey}})
+		require.NoError(t, err)
+		assert.Equal(t, "https://cache.nixos.org", uc.GetURL())
+		assert.Len(t, uc.PublicKeys(), 2)
+	})
+}
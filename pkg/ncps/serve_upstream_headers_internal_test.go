@@ -0,0 +1,58 @@
+package ncps
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUpstreamHeaders(t *testing.T) {
+	t.Parallel()
+
+	header, hostHeaders, err := parseUpstreamHeaders(
+		[]string{"x-proxy-token: secret", "X-Team:ci"},
+		[]string{"cache.example.com=Authorization: Bearer a=b", "cache.example.com:8443=X-Team: release"},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.Header{"X-Proxy-Token": {"secret"}, "X-Team": {"ci"}}, header)
+	assert.Equal(t, map[string]http.Header{
+		"cache.example.com":      {"Authorization": {"Bearer a=b"}},
+		"cache.example.com:8443": {"X-Team": {"release"}},
+	}, hostHeaders)
+
+	for _, raw := range []string{"no-colon", ": value", "Bad Name: value"} {
+		_, _, err := parseUpstreamHeaders([]string{raw}, nil)
+		require.ErrorIs(t, err, ErrInvalidUpstreamHeader, raw)
+	}
+
+	for _, raw := range []string{"X-Team: ci", "=X-Team: ci", "X-Team: a=b"} {
+		_, _, err := parseUpstreamHeaders(nil, []string{raw})
+		require.ErrorIs(t, err, ErrInvalidUpstreamHeader, raw)
+	}
+}
+
+func TestParseUpstreamHeaders_SecretFiles(t *testing.T) {
+	t.Parallel()
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("Bearer hunter2\n"), 0o600))
+
+	header, hostHeaders, err := parseUpstreamHeaders(
+		[]string{"Proxy-Authorization: " + secretFilePrefix + tokenPath},
+		[]string{"cache.example.com=Authorization: " + secretFilePrefix + tokenPath},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.Header{"Proxy-Authorization": {"Bearer hunter2"}}, header)
+	assert.Equal(t, map[string]http.Header{
+		"cache.example.com": {"Authorization": {"Bearer hunter2"}},
+	}, hostHeaders)
+
+	_, _, err = parseUpstreamHeaders([]string{"Authorization: " + secretFilePrefix + tokenPath + ".missing"}, nil)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,372 @@
+package ncps
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v3"
+
+	s3config "github.com/kalbasit/ncps/pkg/s3"
+	localstorage "github.com/kalbasit/ncps/pkg/storage/local"
+	storageOCI "github.com/kalbasit/ncps/pkg/storage/oci"
+	storageS3 "github.com/kalbasit/ncps/pkg/storage/s3"
+
+	"github.com/kalbasit/ncps/pkg/faultinject"
+	"github.com/kalbasit/ncps/pkg/lock"
+	"github.com/kalbasit/ncps/pkg/storage"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+	"github.com/kalbasit/ncps/pkg/storage/inventory"
+)
+
+// Object types whose storage can be configured apart from the main storage.
+const (
+	storeNar    = "nar"
+	storeChunks = "chunks"
+
+	// storeChunksMirror is the store the chunks are mirrored to, if set.
+	storeChunksMirror = "chunks-mirror"
+
+	// storeChunksCold is the store the cold chunks are demoted to, if set.
+	storeChunksCold = "chunks-cold"
+)
+
+// ErrStoreStorageConflict is returned when a per-store section sets more than
+// one of a local path, an S3 bucket and an OCI repository.
+var ErrStoreStorageConflict = errors.New(
+	"a storage section cannot set more than one of a local path, an S3 bucket and an OCI repository")
+
+// storeFlagName returns the name of the flag of key in the section of store,
+// e.g. cache-storage-nar-s3-bucket.
+func storeFlagName(store, key string) string { return "cache-storage-" + store + "-" + key }
+
+// The flags of the connection to the registry of the OCI repositories of the
+// per-store sections.
+const (
+	flagNameStorageOCIUsername = "cache-storage-oci-username"
+	flagNameStorageOCIPassword = "cache-storage-oci-password" //nolint:gosec // G101: flag name
+	flagNameStorageOCIInsecure = "cache-storage-oci-insecure"
+)
+
+// flagNameStorageLocalNarDedup hard-links identical NARs of a local nar store.
+const flagNameStorageLocalNarDedup = "cache-storage-local-nar-dedup"
+
+// The flags of the inventory of the local nar and chunk stores, served only.
+const (
+	flagNameStorageLocalInventory                = "cache-storage-local-inventory"
+	flagNameStorageLocalInventoryRebuildInterval = "cache-storage-local-inventory-rebuild-interval"
+)
+
+// storeFlags returns the flags of the per-store storage sections, and of the
+// local nar dedup every command writing NARs honors. A store without a section
+// of its own uses the main storage.
+func storeFlags(flagSources flagSourcesFn) []cli.Flag {
+	flags := []cli.Flag{
+		&cli.BoolFlag{
+			Name: flagNameStorageLocalNarDedup,
+			Usage: "Hard-link the NARs of identical content (same FileHash) instead of storing them twice " +
+				"when the NARs are stored locally",
+			Sources: flagSources("cache.storage.local-nar-dedup", "CACHE_STORAGE_LOCAL_NAR_DEDUP"),
+		},
+		&cli.StringFlag{
+			Name:    flagNameStorageOCIUsername,
+			Usage:   "The username of the registry of the OCI repositories (experimental)",
+			Sources: secretSources(flagSources("cache.storage.oci.username", "CACHE_STORAGE_OCI_USERNAME")),
+		},
+		&cli.StringFlag{
+			Name:    flagNameStorageOCIPassword,
+			Usage:   "The password or token of the registry of the OCI repositories (experimental)",
+			Sources: secretSources(flagSources("cache.storage.oci.password", "CACHE_STORAGE_OCI_PASSWORD")),
+		},
+		&cli.BoolFlag{
+			Name:    flagNameStorageOCIInsecure,
+			Usage:   "Talk to the registry of the OCI repositories over plain HTTP (experimental)",
+			Sources: flagSources("cache.storage.oci.insecure", "CACHE_STORAGE_OCI_INSECURE"),
+		},
+	}
+
+	for _, section := range []struct{ store, role string }{
+		{storeNar, "storing the nar instead of the main storage"},
+		{storeChunks, "storing the chunks instead of the main storage"},
+		{storeChunksMirror, "mirroring the chunks, written in the background and read when missing from the chunks storage"},
+		{storeChunksCold, "the cold chunks are demoted to, read back and promoted when accessed"},
+	} {
+		store := section.store
+
+		source := func(key string) cli.ValueSourceChain {
+			return flagSources(
+				"cache.storage."+store+"."+key,
+				"CACHE_STORAGE_"+strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(store+"_"+key)),
+			)
+		}
+
+		flags = append(flags,
+			&cli.StringFlag{
+				Name:    storeFlagName(store, "local"),
+				Usage:   "The local path " + section.role,
+				Sources: source("local"),
+			},
+			&cli.StringFlag{
+				Name: storeFlagName(store, "s3-bucket"),
+				Usage: "The S3 bucket " + section.role + "; the S3 credentials and path style are " +
+					"those of the main S3 storage",
+				Sources: source("s3.bucket"),
+			},
+			&cli.StringFlag{
+				Name:    storeFlagName(store, "s3-endpoint"),
+				Usage:   "The S3 endpoint of --" + storeFlagName(store, "s3-bucket") + " (defaults to the main one)",
+				Sources: source("s3.endpoint"),
+			},
+			&cli.StringFlag{
+				Name:    storeFlagName(store, "s3-region"),
+				Usage:   "The S3 region of --" + storeFlagName(store, "s3-bucket") + " (defaults to the main one)",
+				Sources: source("s3.region"),
+			},
+			&cli.StringFlag{
+				Name: storeFlagName(store, "oci-repository"),
+				Usage: "The OCI repository of a container registry " + section.role +
+					", e.g. registry.example.com/ncps/nars (experimental)",
+				Sources: source("oci.repository"),
+			},
+		)
+	}
+
+	return flags
+}
+
+// getStoreConfig returns the storage configuration of store: its own section
+// when set, the main storage otherwise. It returns neither a local path nor an
+// S3 configuration for a section setting an OCI repository.
+func getStoreConfig(ctx context.Context, cmd *cli.Command, store string) (string, *s3config.Config, error) {
+	localPath := cmd.String(storeFlagName(store, "local"))
+	bucket := cmd.String(storeFlagName(store, "s3-bucket"))
+
+	var set []string
+
+	for _, key := range []string{"local", "s3-bucket", "oci-repository"} {
+		if cmd.String(storeFlagName(store, key)) != "" {
+			set = append(set, "--"+storeFlagName(store, key))
+		}
+	}
+
+	if len(set) > 1 {
+		return "", nil, fmt.Errorf("%w: %s", ErrStoreStorageConflict, strings.Join(set, " and "))
+	}
+
+	switch {
+	case localPath != "":
+		return localPath, nil, nil
+	case cmd.String(storeFlagName(store, "oci-repository")) != "":
+		// See getStoreOCIConfig.
+		return "", nil, nil
+	case bucket == "":
+		return getStorageConfig(ctx, cmd)
+	}
+
+	accessKeyID, err := secretValue(cmd, flagNameS3AccessKeyID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	secretAccessKey, err := secretValue(cmd, flagNameS3SecretKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	s3Cfg := &s3config.Config{
+		Bucket:          bucket,
+		Region:          cmp.Or(cmd.String(storeFlagName(store, "s3-region")), cmd.String(flagNameS3Region)),
+		Endpoint:        cmp.Or(cmd.String(storeFlagName(store, "s3-endpoint")), cmd.String(flagNameS3Endpoint)),
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		ForcePathStyle:  cmd.Bool(flagNameS3ForcePathStyle),
+	}
+
+	if err := s3config.ValidateConfig(*s3Cfg); err != nil {
+		return "", nil, fmt.Errorf("error validating the %s storage: %w", store, err)
+	}
+
+	return "", s3Cfg, nil
+}
+
+// getStoreOCIConfig returns the OCI configuration of the section of store, or
+// nil if the section sets no OCI repository.
+func getStoreOCIConfig(cmd *cli.Command, store string, locker lock.Locker) (*storageOCI.Config, error) {
+	repository := cmd.String(storeFlagName(store, "oci-repository"))
+	if repository == "" {
+		return nil, nil //nolint:nilnil // nil is the documented "no OCI repository"
+	}
+
+	if _, _, err := storageOCI.ParseRepository(repository); err != nil {
+		return nil, fmt.Errorf("error validating the %s storage: %w", store, err)
+	}
+
+	username, err := secretValue(cmd, flagNameStorageOCIUsername)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := secretValue(cmd, flagNameStorageOCIPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storageOCI.Config{
+		Repository: repository,
+		Username:   username,
+		Password:   password,
+		Insecure:   cmd.Bool(flagNameStorageOCIInsecure),
+		Locker:     locker,
+	}, nil
+}
+
+// hasStoreConfig returns whether store has a storage section of its own.
+func hasStoreConfig(cmd *cli.Command, store string) bool {
+	return cmd.String(storeFlagName(store, "local")) != "" ||
+		cmd.String(storeFlagName(store, "s3-bucket")) != "" ||
+		cmd.String(storeFlagName(store, "oci-repository")) != ""
+}
+
+// createNarStore creates the nar store of the nar storage section.
+func createNarStore(ctx context.Context, cmd *cli.Command) (storage.NarStore, error) {
+	localPath, s3Cfg, err := getStoreConfig(ctx, cmd, storeNar)
+	if err != nil {
+		return nil, err
+	}
+
+	ociCfg, err := getStoreOCIConfig(cmd, storeNar, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if ociCfg != nil {
+		narStore, err := storageOCI.New(ctx, *ociCfg)
+		if err != nil {
+			return nil, fmt.Errorf("error creating a new OCI nar store: %w", err)
+		}
+
+		zerolog.Ctx(ctx).Warn().
+			Str("repository", ociCfg.Repository).
+			Msg("using the experimental OCI storage for the nars")
+
+		return narStore, nil
+	}
+
+	if localPath != "" {
+		narStore, err := localstorage.New(ctx, localPath)
+		if err != nil {
+			return nil, fmt.Errorf("error creating a new local nar store at %q: %w", localPath, err)
+		}
+
+		zerolog.Ctx(ctx).Info().Str("path", localPath).Msg("using local storage for the nars")
+
+		return narStore, nil
+	}
+
+	narStore, err := storageS3.New(ctx, *s3Cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating a new S3 nar store: %w", err)
+	}
+
+	zerolog.Ctx(ctx).Info().Str("bucket", s3Cfg.Bucket).Msg("using S3 storage for the nars")
+
+	return narStore, nil
+}
+
+// getChunkStorageBackend creates the chunk store of the chunks storage section,
+// mirrored to the store of the chunks-mirror section if set. The faults of the
+// injector of ctx, if any, are injected into each store.
+func getChunkStorageBackend(ctx context.Context, cmd *cli.Command, locker lock.Locker) (chunk.Store, error) {
+	faults := faultinject.Ctx(ctx)
+
+	chunkStore, err := createChunkStore(ctx, cmd, locker, storeChunks)
+	if err != nil {
+		return nil, err
+	}
+
+	store := faults.ChunkStore(chunkStore)
+
+	if hasStoreConfig(cmd, storeChunksMirror) {
+		mirrorStore, err := createChunkStore(ctx, cmd, locker, storeChunksMirror)
+		if err != nil {
+			return nil, fmt.Errorf("error creating the chunk mirror: %w", err)
+		}
+
+		zerolog.Ctx(ctx).Info().Msg("mirroring the chunks")
+
+		store = chunk.NewMirrorStore(store, faults.ChunkStore(mirrorStore), chunk.MirrorOptions{})
+	}
+
+	if hasStoreConfig(cmd, storeChunksCold) {
+		coldStore, err := createChunkStore(ctx, cmd, locker, storeChunksCold)
+		if err != nil {
+			return nil, fmt.Errorf("error creating the cold chunk store: %w", err)
+		}
+
+		zerolog.Ctx(ctx).Info().Msg("tiering the chunks to a cold store")
+
+		// The cold store is not mirrored.
+		store = chunk.NewTieredStore(store, faults.ChunkStore(coldStore))
+	}
+
+	return store, nil
+}
+
+func createChunkStore(ctx context.Context, cmd *cli.Command, locker lock.Locker, store string) (chunk.Store, error) {
+	localDataPath, s3Cfg, err := getStoreConfig(ctx, cmd, store)
+	if err != nil {
+		return nil, err
+	}
+
+	ociCfg, err := getStoreOCIConfig(cmd, store, locker)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case ociCfg != nil:
+		chunkStore, err := storageOCI.New(ctx, *ociCfg)
+		if err != nil {
+			return nil, fmt.Errorf("error creating a new OCI %s store: %w", store, err)
+		}
+
+		zerolog.Ctx(ctx).Warn().
+			Str("repository", ociCfg.Repository).
+			Msgf("using the experimental OCI storage for the %s", store)
+
+		return chunkStore, nil
+	case localDataPath != "":
+		// Use {localDataPath}/store as base for chunks to match other stores
+		chunkStore, err := chunk.NewLocalStore(filepath.Join(localDataPath, "store"))
+		if err != nil {
+			return nil, err
+		}
+
+		setStoreInventory(ctx, cmd, chunkStore)
+
+		return chunkStore, nil
+	case s3Cfg != nil:
+		return chunk.NewS3Store(ctx, *s3Cfg, locker)
+	default:
+		// This should never happen because getStorageConfig returns an error if neither is set
+		return nil, ErrStorageConfigRequired
+	}
+}
+
+// setStoreInventory enables the inventory of store, if it is a local one, when
+// --cache-storage-local-inventory is set. Only serve defines the flag.
+func setStoreInventory(ctx context.Context, cmd *cli.Command, store any) {
+	if !cmd.Bool(flagNameStorageLocalInventory) {
+		return
+	}
+
+	if s, ok := store.(inventory.Enabler); ok {
+		s.SetInventory(ctx, cmd.Duration(flagNameStorageLocalInventoryRebuildInterval))
+
+		zerolog.Ctx(ctx).Info().Msg("keeping an inventory of the local store")
+	}
+}
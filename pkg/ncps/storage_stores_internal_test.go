@@ -0,0 +1,141 @@
+package ncps
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+
+	s3config "github.com/kalbasit/ncps/pkg/s3"
+)
+
+func TestGetStoreConfig(t *testing.T) {
+	t.Parallel()
+
+	mainS3 := []string{
+		"--cache-storage-s3-bucket", "main",
+		"--cache-storage-s3-endpoint", "https://s3.example.com",
+		"--cache-storage-s3-region", "us-east-1",
+		"--cache-storage-s3-access-key-id", "access",
+		"--cache-storage-s3-secret-access-key", "secret",
+	}
+
+	tests := []struct {
+		name      string
+		args      []string
+		wantLocal string
+		wantS3    *s3config.Config
+		wantErr   error
+	}{
+		{
+			name:      "a store without a section uses the main storage",
+			args:      []string{"--cache-storage-local", "/var/lib/ncps"},
+			wantLocal: "/var/lib/ncps",
+		},
+		{
+			name:      "a local section overrides the main S3 storage",
+			args:      append([]string{"--cache-storage-nar-local", "/mnt/nars"}, mainS3...),
+			wantLocal: "/mnt/nars",
+		},
+		{
+			name: "an S3 section inherits the main S3 connection",
+			args: append([]string{
+				"--cache-storage-nar-s3-bucket", "nars",
+				"--cache-storage-nar-s3-region", "eu-west-1",
+			}, mainS3...),
+			wantS3: &s3config.Config{
+				Bucket:          "nars",
+				Region:          "eu-west-1",
+				Endpoint:        "https://s3.example.com",
+				AccessKeyID:     "access",
+				SecretAccessKey: "secret",
+			},
+		},
+		{
+			name: "a section with both a local path and a bucket is rejected",
+			args: []string{
+				"--cache-storage-local", "/var/lib/ncps",
+				"--cache-storage-nar-local", "/mnt/nars",
+				"--cache-storage-nar-s3-bucket", "nars",
+			},
+			wantErr: ErrStoreStorageConflict,
+		},
+		{
+			name: "an OCI section needs no main storage",
+			args: []string{"--cache-storage-nar-oci-repository", "registry.example.com/ncps/nars"},
+		},
+		{
+			name: "a section with both a bucket and an OCI repository is rejected",
+			args: append([]string{
+				"--cache-storage-nar-s3-bucket", "nars",
+				"--cache-storage-nar-oci-repository", "registry.example.com/ncps/nars",
+			}, mainS3...),
+			wantErr: ErrStoreStorageConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				gotLocal string
+				gotS3    *s3config.Config
+				gotErr   error
+			)
+
+			cmd := &cli.Command{
+				Name: "app",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{Name: "cache-data-path"},
+					&cli.StringFlag{Name: flagNameStorageLocal},
+					&cli.StringFlag{Name: flagNameS3Bucket},
+					&cli.StringFlag{Name: flagNameS3Endpoint},
+					&cli.StringFlag{Name: flagNameS3Region},
+					&cli.StringFlag{Name: flagNameS3AccessKeyID},
+					&cli.StringFlag{Name: flagNameS3SecretKey},
+					&cli.BoolFlag{Name: flagNameS3ForcePathStyle},
+				}, storeFlags(func(string, string) cli.ValueSourceChain { return cli.ValueSourceChain{} })...),
+				Action: func(ctx context.Context, c *cli.Command) error {
+					gotLocal, gotS3, gotErr = getStoreConfig(ctx, c, storeNar)
+
+					return nil
+				},
+			}
+
+			require.NoError(t, cmd.Run(context.Background(), append([]string{"app"}, tt.args...)))
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, gotErr, tt.wantErr)
+
+				return
+			}
+
+			require.NoError(t, gotErr)
+			assert.Equal(t, tt.wantLocal, gotLocal)
+			assert.Equal(t, tt.wantS3, gotS3)
+		})
+	}
+}
+
+func TestStoreFlagsSources(t *testing.T) {
+	t.Parallel()
+
+	var sourceCalls [][2]string
+
+	storeFlags(func(configFileKey, envVar string) cli.ValueSourceChain {
+		sourceCalls = append(sourceCalls, [2]string{configFileKey, envVar})
+
+		return cli.ValueSourceChain{}
+	})
+
+	assert.Contains(t, sourceCalls, [2]string{"cache.storage.nar.local", "CACHE_STORAGE_NAR_LOCAL"})
+	assert.Contains(t, sourceCalls, [2]string{"cache.storage.chunks.s3.bucket", "CACHE_STORAGE_CHUNKS_S3_BUCKET"})
+	assert.Contains(t, sourceCalls,
+		[2]string{"cache.storage.chunks-mirror.s3.bucket", "CACHE_STORAGE_CHUNKS_MIRROR_S3_BUCKET"})
+	assert.Contains(t, sourceCalls, [2]string{"cache.storage.chunks-cold.local", "CACHE_STORAGE_CHUNKS_COLD_LOCAL"})
+	assert.Contains(t, sourceCalls,
+		[2]string{"cache.storage.chunks.oci.repository", "CACHE_STORAGE_CHUNKS_OCI_REPOSITORY"})
+}
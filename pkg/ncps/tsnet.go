@@ -0,0 +1,82 @@
+package ncps
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v3"
+)
+
+// ErrTSNetNotBuiltIn is returned for --server-tsnet-hostname by a binary built
+// without the tsnet build tag.
+var ErrTSNetNotBuiltIn = errors.New("ncps was built without tsnet support (build it with -tags tsnet)")
+
+// tailnetConfig configures the node joining the tailnet.
+type tailnetConfig struct {
+	// Hostname is the name of the node on the tailnet.
+	Hostname string
+
+	// AuthKey authenticates the node the first time it joins the tailnet.
+	AuthKey string
+
+	// StateDir keeps the identity of the node across restarts.
+	StateDir string
+
+	// Ephemeral removes the node from the tailnet once it goes offline.
+	Ephemeral bool
+
+	// HTTPS serves on port 443 with the certificate of the node issued by
+	// Tailscale, instead of plain HTTP on port 80.
+	HTTPS bool
+}
+
+// serveTailnet joins the tailnet as --server-tsnet-hostname and serves server
+// on it, next to --server-addr. It does nothing when the hostname is empty.
+func serveTailnet(
+	ctx context.Context,
+	cmd *cli.Command,
+	server *http.Server,
+	registerShutdown registerShutdownFn,
+) error {
+	hostname := cmd.String("server-tsnet-hostname")
+	if hostname == "" {
+		return nil
+	}
+
+	authKey, err := secretValue(cmd, "server-tsnet-auth-key")
+	if err != nil {
+		return err
+	}
+
+	cfg := tailnetConfig{
+		Hostname:  hostname,
+		AuthKey:   authKey,
+		StateDir:  cmd.String("server-tsnet-state-dir"),
+		Ephemeral: cmd.Bool("server-tsnet-ephemeral"),
+		HTTPS:     cmd.Bool("server-tsnet-https"),
+	}
+
+	l, closeFn, err := listenTailnet(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	registerShutdown("tailnet", closeFn)
+
+	go func() {
+		if err := server.Serve(l); err != nil &&
+			!errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+			zerolog.Ctx(ctx).Error().Err(err).Str("hostname", hostname).Msg("tailnet listener error")
+		}
+	}()
+
+	zerolog.Ctx(ctx).Info().
+		Str("hostname", hostname).
+		Bool("https", cfg.HTTPS).
+		Msg("serving on the tailnet")
+
+	return nil
+}
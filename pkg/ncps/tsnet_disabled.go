@@ -0,0 +1,14 @@
+//go:build !tsnet
+
+package ncps
+
+import (
+	"context"
+	"net"
+)
+
+// listenTailnet fails: the tsnet node is only built in with the tsnet build
+// tag, keeping its dependencies out of the default binary.
+func listenTailnet(context.Context, tailnetConfig) (net.Listener, shutdownFn, error) {
+	return nil, nil, ErrTSNetNotBuiltIn
+}
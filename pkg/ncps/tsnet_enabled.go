@@ -0,0 +1,56 @@
+//go:build tsnet
+
+package ncps
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/rs/zerolog"
+	"tailscale.com/tsnet"
+)
+
+// listenTailnet joins the tailnet as cfg.Hostname and listens on it. It
+// returns the function leaving the tailnet.
+func listenTailnet(ctx context.Context, cfg tailnetConfig) (net.Listener, shutdownFn, error) {
+	logger := zerolog.Ctx(ctx).With().Str("hostname", cfg.Hostname).Logger()
+
+	s := &tsnet.Server{
+		Hostname:  cfg.Hostname,
+		AuthKey:   cfg.AuthKey,
+		Dir:       cfg.StateDir,
+		Ephemeral: cfg.Ephemeral,
+		// The backend logs of tsnet are verbose; only the messages meant for
+		// the user, such as the login URL, are logged.
+		Logf:     func(string, ...any) {},
+		UserLogf: func(format string, args ...any) { logger.Info().Msgf(format, args...) },
+	}
+
+	closeFn := func(context.Context) error { return s.Close() }
+
+	if _, err := s.Up(ctx); err != nil {
+		_ = s.Close()
+
+		return nil, nil, fmt.Errorf("error joining the tailnet: %w", err)
+	}
+
+	var (
+		l   net.Listener
+		err error
+	)
+
+	if cfg.HTTPS {
+		l, err = s.ListenTLS("tcp", ":443")
+	} else {
+		l, err = s.Listen("tcp", ":80")
+	}
+
+	if err != nil {
+		_ = s.Close()
+
+		return nil, nil, fmt.Errorf("error listening on the tailnet: %w", err)
+	}
+
+	return l, closeFn, nil
+}
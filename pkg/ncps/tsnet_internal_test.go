@@ -0,0 +1,17 @@
+//go:build !tsnet
+
+package ncps
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenTailnetNotBuiltIn(t *testing.T) {
+	t.Parallel()
+
+	_, _, err := listenTailnet(context.Background(), tailnetConfig{Hostname: "ncps"})
+	require.ErrorIs(t, err, ErrTSNetNotBuiltIn)
+}
@@ -0,0 +1,618 @@
+package ncps
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v3"
+	"github.com/zeebo/blake3"
+
+	entchunk "github.com/kalbasit/ncps/ent/chunk"
+	entnarfile "github.com/kalbasit/ncps/ent/narfile"
+	entnarfilechunk "github.com/kalbasit/ncps/ent/narfilechunk"
+	entnarinfo "github.com/kalbasit/ncps/ent/narinfo"
+	locklocal "github.com/kalbasit/ncps/pkg/lock/local"
+
+	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/pkg/database"
+	"github.com/kalbasit/ncps/pkg/storage"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+)
+
+const (
+	// verifyExitCodeError is the exit code of a verify run that could not
+	// complete. A run that completed and found issues exits with 1.
+	verifyExitCodeError = 2
+
+	verifyFormatText = "text"
+	verifyFormatJSON = "json"
+)
+
+var (
+	// ErrVerifyIssuesFound is returned when verify finds integrity issues.
+	ErrVerifyIssuesFound = errors.New("integrity issues found")
+
+	// ErrVerifyUnknownFormat is returned for an unknown --format.
+	ErrVerifyUnknownFormat = errors.New("unknown report format (allowed: text, json)")
+)
+
+// verifyReport is the result of a verify run. Every issue list is ordered by
+// database ID so two runs over the same data produce the same report.
+type verifyReport struct {
+	// ChunksChecked is the number of chunks whose content was hashed; zero when
+	// no chunk is stored.
+	ChunksChecked int `json:"chunksChecked"`
+
+	// NarFilesChecked is the number of chunked nar_files whose chunk links were
+	// checked.
+	NarFilesChecked int `json:"narFilesChecked"`
+
+	// CorruptChunks are chunks whose content does not hash to their key.
+	CorruptChunks []verifyChunkIssue `json:"corruptChunks"`
+
+	// MissingChunks are chunks recorded in the database but absent from the
+	// chunk store.
+	MissingChunks []verifyChunkIssue `json:"missingChunks"`
+
+	// IncompleteNarFiles are chunked nar_files whose chunk indexes are not
+	// exactly 0..totalChunks-1.
+	IncompleteNarFiles []verifyNarFileIssue `json:"incompleteNarFiles"`
+
+	// NarInfosWithoutNarFile are narinfos linked to no nar_file.
+	NarInfosWithoutNarFile []verifyNarInfoIssue `json:"narInfosWithoutNarFile"`
+
+	// NarFilesWithoutNarInfo are nar_files linked to no narinfo.
+	NarFilesWithoutNarInfo []verifyNarFileIssue `json:"narFilesWithoutNarInfo"`
+
+	// NarFilesToRepair are the nar_files with a corrupt or missing chunk or
+	// incomplete chunk links. Each can be repaired from upstream through the
+	// admin API of a running ncps. They are not counted in Issues.
+	NarFilesToRepair []verifyNarFileIssue `json:"narFilesToRepair"`
+
+	// Issues is the total number of issues found.
+	Issues int `json:"issues"`
+}
+
+type verifyChunkIssue struct {
+	Hash   string `json:"hash"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type verifyNarFileIssue struct {
+	Hash        string `json:"hash"`
+	Compression string `json:"compression"`
+	Detail      string `json:"detail,omitempty"`
+}
+
+type verifyNarInfoIssue struct {
+	Hash string `json:"hash"`
+}
+
+func (r *verifyReport) countIssues() {
+	r.Issues = len(r.CorruptChunks) +
+		len(r.MissingChunks) +
+		len(r.IncompleteNarFiles) +
+		len(r.NarInfosWithoutNarFile) +
+		len(r.NarFilesWithoutNarInfo)
+}
+
+func verifyCommand(flagSources flagSourcesFn, registerShutdown registerShutdownFn) *cli.Command {
+	return &cli.Command{
+		Name:  "verify",
+		Usage: "Verify the integrity of the cached data, including CDC chunks",
+		Description: `Verifies the cached data without modifying anything:
+
+  - Chunk integrity: the content of every chunk hashes (BLAKE3) to its key
+    and the chunk is present in the chunk store
+  - Chunk links: the chunks of every chunked nar_file are indexed exactly
+    0..total_chunks-1, without gaps
+  - Narinfo links: every narinfo is linked to a nar_file and every nar_file
+    to a narinfo
+
+The report is printed as text or, with --format=json, as JSON for CI. The
+command exits with 0 when no issue is found, 1 when issues are found and 2
+when the verification could not complete. Use fsck to repair issues.`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Report format: text or json",
+				Value: verifyFormatText,
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "Write the report to this file instead of stdout",
+			},
+			&cli.BoolFlag{
+				Name: "skip-chunk-content",
+				Usage: "Only check that chunks are present in the chunk store, without reading and " +
+					"hashing their content",
+			},
+
+			// Storage Flags
+			&cli.StringFlag{
+				Name:    flagNameStorageLocal,
+				Usage:   flagUsageStorageLocal,
+				Sources: flagSources("cache.storage.local", "CACHE_STORAGE_LOCAL"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameS3Bucket,
+				Usage:   flagUsageS3Bucket,
+				Sources: flagSources("cache.storage.s3.bucket", "CACHE_STORAGE_S3_BUCKET"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameS3Endpoint,
+				Usage:   flagUsageS3Endpoint,
+				Sources: flagSources("cache.storage.s3.endpoint", "CACHE_STORAGE_S3_ENDPOINT"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameS3Region,
+				Usage:   flagUsageS3Region,
+				Sources: flagSources("cache.storage.s3.region", "CACHE_STORAGE_S3_REGION"),
+			},
+			&cli.StringFlag{
+				Name:    flagNameS3AccessKeyID,
+				Usage:   flagUsageS3AccessKeyID,
+				Sources: secretSources(flagSources("cache.storage.s3.access-key-id", "CACHE_STORAGE_S3_ACCESS_KEY_ID")),
+			},
+			&cli.StringFlag{
+				Name:    flagNameS3SecretKey,
+				Usage:   flagUsageS3SecretKey,
+				Sources: secretSources(flagSources("cache.storage.s3.secret-access-key", "CACHE_STORAGE_S3_SECRET_ACCESS_KEY")),
+			},
+			&cli.BoolFlag{
+				Name:    flagNameS3ForcePathStyle,
+				Usage:   flagUsageS3ForcePathStyle,
+				Sources: flagSources("cache.storage.s3.force-path-style", "CACHE_STORAGE_S3_FORCE_PATH_STYLE"),
+			},
+
+			// Database Flags
+			&cli.StringFlag{
+				Name:     flagNameDBURL,
+				Usage:    flagUsageDBURL,
+				Sources:  secretSources(flagSources("cache.database-url", "CACHE_DATABASE_URL")),
+				Required: true,
+			},
+			&cli.IntFlag{
+				Name:    flagNameDBMaxOpenConns,
+				Usage:   flagUsageDBMaxOpenConns,
+				Sources: flagSources("cache.database.pool.max-open-conns", "CACHE_DATABASE_POOL_MAX_OPEN_CONNS"),
+			},
+			&cli.IntFlag{
+				Name:    flagNameDBMaxIdleConns,
+				Usage:   flagUsageDBMaxIdleConns,
+				Sources: flagSources("cache.database.pool.max-idle-conns", "CACHE_DATABASE_POOL_MAX_IDLE_CONNS"),
+			},
+		}, storeFlags(flagSources)...),
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := zerolog.Ctx(ctx).With().Str("cmd", "verify").Logger()
+			ctx = logger.WithContext(ctx)
+
+			report, err := runVerifyCommand(ctx, cmd, registerShutdown)
+			if err != nil {
+				return &exitCodeError{err: err, code: verifyExitCodeError}
+			}
+
+			if report.Issues > 0 {
+				return fmt.Errorf("%w: %d", ErrVerifyIssuesFound, report.Issues)
+			}
+
+			return nil
+		},
+	}
+}
+
+func runVerifyCommand(
+	ctx context.Context,
+	cmd *cli.Command,
+	registerShutdown registerShutdownFn,
+) (*verifyReport, error) {
+	format := cmd.String("format")
+	if format != verifyFormatText && format != verifyFormatJSON {
+		return nil, fmt.Errorf("%w: %q", ErrVerifyUnknownFormat, format)
+	}
+
+	dbClient, err := createDatabaseClient(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("error creating database client: %w", err)
+	}
+
+	registerShutdown("database client", func(_ context.Context) error { return dbClient.Close() })
+
+	// verify never writes chunks, so the chunk store needs no distributed lock.
+	chunkStore, err := getChunkStorageBackend(ctx, cmd, locklocal.NewLocker())
+	if err != nil {
+		return nil, fmt.Errorf("error creating chunk storage backend: %w", err)
+	}
+
+	report, err := verifyData(ctx, dbClient, chunkStore, !cmd.Bool("skip-chunk-content"))
+	if err != nil {
+		return nil, err
+	}
+
+	out := io.Writer(os.Stdout)
+
+	if path := cmd.String("output"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("error creating the report file: %w", err)
+		}
+
+		defer f.Close()
+
+		out = f
+	}
+
+	if err := writeVerifyReport(out, format, report); err != nil {
+		return nil, fmt.Errorf("error writing the report: %w", err)
+	}
+
+	return report, nil
+}
+
+// verifyData runs every verify check and returns the report. With
+// verifyContent false, chunks are only checked for presence.
+func verifyData(
+	ctx context.Context,
+	dbClient *database.Client,
+	cs chunk.Store,
+	verifyContent bool,
+) (*verifyReport, error) {
+	report := &verifyReport{
+		CorruptChunks:          []verifyChunkIssue{},
+		MissingChunks:          []verifyChunkIssue{},
+		IncompleteNarFiles:     []verifyNarFileIssue{},
+		NarInfosWithoutNarFile: []verifyNarInfoIssue{},
+		NarFilesWithoutNarInfo: []verifyNarFileIssue{},
+		NarFilesToRepair:       []verifyNarFileIssue{},
+	}
+
+	for _, check := range []func(context.Context, *database.Client, chunk.Store, bool, *verifyReport) error{
+		verifyChunks,
+		verifyChunkLinks,
+		verifyNarInfoLinks,
+	} {
+		if err := check(ctx, dbClient, cs, verifyContent, report); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := collectNarFilesToRepair(ctx, dbClient, report); err != nil {
+		return nil, err
+	}
+
+	report.countIssues()
+
+	return report, nil
+}
+
+// collectNarFilesToRepair lists in report the nar_files linked to a damaged
+// chunk or with incomplete chunk links.
+func collectNarFilesToRepair(ctx context.Context, dbClient *database.Client, report *verifyReport) error {
+	damaged := make([]string, 0, len(report.CorruptChunks)+len(report.MissingChunks))
+	for _, i := range report.CorruptChunks {
+		damaged = append(damaged, i.Hash)
+	}
+
+	for _, i := range report.MissingChunks {
+		damaged = append(damaged, i.Hash)
+	}
+
+	seen := make(map[string]struct{})
+
+	add := func(i verifyNarFileIssue) {
+		key := i.Hash + "/" + i.Compression
+		if _, ok := seen[key]; ok {
+			return
+		}
+
+		seen[key] = struct{}{}
+
+		report.NarFilesToRepair = append(report.NarFilesToRepair, verifyNarFileIssue{
+			Hash:        i.Hash,
+			Compression: i.Compression,
+		})
+	}
+
+	for _, i := range report.IncompleteNarFiles {
+		add(i)
+	}
+
+	for start := 0; start < len(damaged); start += fsckEagerLoadBatchSize {
+		end := min(start+fsckEagerLoadBatchSize, len(damaged))
+
+		narFiles, err := dbClient.Ent().NarFile.Query().
+			Where(entnarfile.HasChunkLinksWith(
+				entnarfilechunk.HasChunkWith(entchunk.HashIn(damaged[start:end]...)),
+			)).
+			Order(ent.Asc(entnarfile.FieldID)).
+			Select(entnarfile.FieldHash, entnarfile.FieldCompression).
+			All(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing the nar_files of the damaged chunks: %w", err)
+		}
+
+		for _, nf := range narFiles {
+			add(verifyNarFileIssue{Hash: nf.Hash, Compression: nf.Compression})
+		}
+	}
+
+	return nil
+}
+
+// verifyChunks checks that every chunk is in the chunk store and, with
+// verifyContent, that its content hashes to its key.
+func verifyChunks(
+	ctx context.Context,
+	dbClient *database.Client,
+	cs chunk.Store,
+	verifyContent bool,
+	report *verifyReport,
+) error {
+	lastID := 0
+
+	for {
+		chunks, err := dbClient.Ent().Chunk.Query().
+			Where(entchunk.IDGT(lastID)).
+			Order(ent.Asc(entchunk.FieldID)).
+			Limit(fsckEagerLoadBatchSize).
+			All(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing the chunks: %w", err)
+		}
+
+		for _, c := range chunks {
+			report.ChunksChecked++
+
+			issue, err := verifyChunk(ctx, cs, c.Hash, verifyContent)
+			if err != nil {
+				return err
+			}
+
+			switch issue {
+			case chunkIssueMissing:
+				report.MissingChunks = append(report.MissingChunks, verifyChunkIssue{Hash: c.Hash})
+			case chunkIssueCorrupt:
+				report.CorruptChunks = append(report.CorruptChunks, verifyChunkIssue{
+					Hash:   c.Hash,
+					Detail: "content does not hash to the chunk key",
+				})
+			}
+		}
+
+		if len(chunks) < fsckEagerLoadBatchSize {
+			return nil
+		}
+
+		lastID = chunks[len(chunks)-1].ID
+	}
+}
+
+type chunkIssue int
+
+const (
+	chunkIssueNone chunkIssue = iota
+	chunkIssueMissing
+	chunkIssueCorrupt
+)
+
+func verifyChunk(ctx context.Context, cs chunk.Store, hash string, verifyContent bool) (chunkIssue, error) {
+	if !verifyContent {
+		exists, err := cs.HasChunk(ctx, hash)
+		if err != nil {
+			return chunkIssueNone, fmt.Errorf("error checking for chunk %s: %w", hash, err)
+		}
+
+		if !exists {
+			return chunkIssueMissing, nil
+		}
+
+		return chunkIssueNone, nil
+	}
+
+	r, err := cs.GetChunk(ctx, hash)
+	if err != nil {
+		if errors.Is(err, chunk.ErrNotFound) || errors.Is(err, storage.ErrNotFound) {
+			return chunkIssueMissing, nil
+		}
+
+		return chunkIssueNone, fmt.Errorf("error reading chunk %s: %w", hash, err)
+	}
+
+	defer r.Close()
+
+	h := blake3.New()
+	if _, err := io.Copy(h, r); err != nil {
+		// A chunk that cannot be decompressed is as corrupt as one that hashes
+		// wrong.
+		return chunkIssueCorrupt, nil //nolint:nilerr // the read error is the finding
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != hash {
+		return chunkIssueCorrupt, nil
+	}
+
+	return chunkIssueNone, nil
+}
+
+// verifyChunkLinks checks that the chunk indexes of every chunked nar_file
+// are exactly 0..total_chunks-1.
+func verifyChunkLinks(
+	ctx context.Context,
+	dbClient *database.Client,
+	_ chunk.Store,
+	_ bool,
+	report *verifyReport,
+) error {
+	lastID := 0
+
+	for {
+		narFiles, err := dbClient.Ent().NarFile.Query().
+			Where(entnarfile.IDGT(lastID), entnarfile.TotalChunksGT(0)).
+			Order(ent.Asc(entnarfile.FieldID)).
+			Limit(fsckEagerLoadBatchSize).
+			All(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing the chunked nar_files: %w", err)
+		}
+
+		for _, nf := range narFiles {
+			report.NarFilesChecked++
+
+			detail, err := chunkIndexGap(ctx, dbClient, nf)
+			if err != nil {
+				return err
+			}
+
+			if detail != "" {
+				report.IncompleteNarFiles = append(report.IncompleteNarFiles, verifyNarFileIssue{
+					Hash:        nf.Hash,
+					Compression: nf.Compression,
+					Detail:      detail,
+				})
+			}
+		}
+
+		if len(narFiles) < fsckEagerLoadBatchSize {
+			return nil
+		}
+
+		lastID = narFiles[len(narFiles)-1].ID
+	}
+}
+
+// chunkIndexGap describes the first discontinuity in the chunk indexes of nf,
+// or returns the empty string when they are exactly 0..total_chunks-1. The
+// (nar_file_id, chunk_index) unique index rules out duplicates.
+func chunkIndexGap(ctx context.Context, dbClient *database.Client, nf *ent.NarFile) (string, error) {
+	expected := 0
+
+	for {
+		links, err := dbClient.Ent().NarFileChunk.Query().
+			Where(
+				entnarfilechunk.NarFileIDEQ(nf.ID),
+				entnarfilechunk.ChunkIndexGTE(expected),
+			).
+			Order(ent.Asc(entnarfilechunk.FieldChunkIndex)).
+			Select(entnarfilechunk.FieldChunkIndex).
+			Limit(fsckEagerLoadBatchSize).
+			All(ctx)
+		if err != nil {
+			return "", fmt.Errorf("error listing the chunks of nar_file %d: %w", nf.ID, err)
+		}
+
+		for _, link := range links {
+			if link.ChunkIndex != expected {
+				return fmt.Sprintf("chunk index %d is missing", expected), nil
+			}
+
+			expected++
+		}
+
+		if len(links) < fsckEagerLoadBatchSize {
+			break
+		}
+	}
+
+	switch {
+	case int64(expected) < nf.TotalChunks:
+		return fmt.Sprintf("has %d of %d chunks", expected, nf.TotalChunks), nil
+	case int64(expected) > nf.TotalChunks:
+		return fmt.Sprintf("has %d chunks but expects %d", expected, nf.TotalChunks), nil
+	default:
+		return "", nil
+	}
+}
+
+// verifyNarInfoLinks checks that every narinfo is linked to a nar_file and
+// every nar_file to a narinfo.
+func verifyNarInfoLinks(
+	ctx context.Context,
+	dbClient *database.Client,
+	_ chunk.Store,
+	_ bool,
+	report *verifyReport,
+) error {
+	narInfos, err := dbClient.Ent().NarInfo.Query().
+		Where(entnarinfo.Not(entnarinfo.HasNarInfoNarFiles())).
+		Order(ent.Asc(entnarinfo.FieldID)).
+		Select(entnarinfo.FieldHash).
+		All(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing the unlinked narinfos: %w", err)
+	}
+
+	for _, ni := range narInfos {
+		report.NarInfosWithoutNarFile = append(report.NarInfosWithoutNarFile, verifyNarInfoIssue{Hash: ni.Hash})
+	}
+
+	narFiles, err := dbClient.Ent().NarFile.Query().
+		Where(entnarfile.Not(entnarfile.HasNarInfoNarFiles())).
+		Order(ent.Asc(entnarfile.FieldID)).
+		Select(entnarfile.FieldHash, entnarfile.FieldCompression).
+		All(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing the unlinked nar_files: %w", err)
+	}
+
+	for _, nf := range narFiles {
+		report.NarFilesWithoutNarInfo = append(report.NarFilesWithoutNarInfo, verifyNarFileIssue{
+			Hash:        nf.Hash,
+			Compression: nf.Compression,
+		})
+	}
+
+	return nil
+}
+
+func writeVerifyReport(w io.Writer, format string, report *verifyReport) error {
+	if format == verifyFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(report)
+	}
+
+	var err error
+
+	printf := func(format string, args ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	printf("Checked %d chunks and %d chunked nar_files.\n", report.ChunksChecked, report.NarFilesChecked)
+
+	for _, i := range report.CorruptChunks {
+		printf("corrupt chunk %s: %s\n", i.Hash, i.Detail)
+	}
+
+	for _, i := range report.MissingChunks {
+		printf("missing chunk %s\n", i.Hash)
+	}
+
+	for _, i := range report.IncompleteNarFiles {
+		printf("incomplete nar_file %s (%s): %s\n", i.Hash, i.Compression, i.Detail)
+	}
+
+	for _, i := range report.NarInfosWithoutNarFile {
+		printf("narinfo %s is linked to no nar_file\n", i.Hash)
+	}
+
+	for _, i := range report.NarFilesWithoutNarInfo {
+		printf("nar_file %s (%s) is linked to no narinfo\n", i.Hash, i.Compression)
+	}
+
+	for _, i := range report.NarFilesToRepair {
+		printf("nar_file %s (%s) needs repair\n", i.Hash, i.Compression)
+	}
+
+	printf("%d issues found.\n", report.Issues)
+
+	return err
+}
@@ -0,0 +1,181 @@
+package ncps_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	entnarfilechunk "github.com/kalbasit/ncps/ent/narfilechunk"
+	chunkstore "github.com/kalbasit/ncps/pkg/storage/chunk"
+
+	"github.com/kalbasit/ncps/pkg/ncps"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+type verifyTestReport struct {
+	ChunksChecked   int `json:"chunksChecked"`
+	NarFilesChecked int `json:"narFilesChecked"`
+	CorruptChunks   []struct {
+		Hash string `json:"hash"`
+	} `json:"corruptChunks"`
+	MissingChunks      []json.RawMessage `json:"missingChunks"`
+	IncompleteNarFiles []struct {
+		Hash   string `json:"hash"`
+		Detail string `json:"detail"`
+	} `json:"incompleteNarFiles"`
+	NarInfosWithoutNarFile []struct {
+		Hash string `json:"hash"`
+	} `json:"narInfosWithoutNarFile"`
+	NarFilesWithoutNarInfo []json.RawMessage `json:"narFilesWithoutNarInfo"`
+	NarFilesToRepair       []struct {
+		Hash string `json:"hash"`
+	} `json:"narFilesToRepair"`
+	Issues int `json:"issues"`
+}
+
+func runVerify(ctx context.Context, t *testing.T, dbURL, dir string) (verifyTestReport, error) {
+	t.Helper()
+
+	app, err := ncps.New()
+	require.NoError(t, err)
+
+	out := filepath.Join(t.TempDir(), "report.json")
+
+	runErr := app.Run(ctx, []string{
+		"ncps", "verify",
+		"--cache-database-url", dbURL,
+		"--cache-storage-local", dir,
+		"--format", "json",
+		"--output", out,
+	})
+
+	var report verifyTestReport
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &report))
+
+	return report, runErr
+}
+
+func TestVerifyClean(t *testing.T) {
+	t.Parallel()
+
+	ctx := zerolog.New(os.Stderr).WithContext(context.Background())
+
+	dbClient, _, dir, dbURL, cleanup := setupFsckSQLite(t)
+	t.Cleanup(cleanup)
+
+	configureFsckCDCInDatabase(ctx, t, dbClient)
+
+	cs, err := chunkstore.NewLocalStore(filepath.Join(dir, "store"))
+	require.NoError(t, err)
+
+	setupFsckCDCNarFileWithRealHashes(ctx, t, dbClient, cs, testdata.Nar1.NarInfoHash, [][]byte{
+		[]byte("first chunk of the verify test"),
+		[]byte("second chunk of the verify test"),
+	})
+
+	report, err := runVerify(ctx, t, dbURL, dir)
+	require.NoError(t, err)
+	assert.Equal(t, 0, ncps.ExitCode(err))
+
+	assert.Equal(t, 2, report.ChunksChecked)
+	assert.Equal(t, 1, report.NarFilesChecked)
+	assert.Zero(t, report.Issues)
+}
+
+func TestVerifyIssues(t *testing.T) {
+	t.Parallel()
+
+	ctx := zerolog.New(os.Stderr).WithContext(context.Background())
+
+	dbClient, _, dir, dbURL, cleanup := setupFsckSQLite(t)
+	t.Cleanup(cleanup)
+
+	configureFsckCDCInDatabase(ctx, t, dbClient)
+
+	cs, err := chunkstore.NewLocalStore(filepath.Join(dir, "store"))
+	require.NoError(t, err)
+
+	// The chunk keys of setupFsckCDCNarFile are not the hashes of their
+	// content, so every chunk reads as corrupt.
+	narFile := setupFsckCDCNarFile(ctx, t, dbClient, cs,
+		testdata.Nar1.NarInfoHash, testdata.Nar1.NarInfoText,
+		testdata.Nar1.NarHash, testdata.Nar1.NarCompression, 3)
+
+	// Drop the middle chunk link.
+	_, err = dbClient.Ent().NarFileChunk.Delete().
+		Where(
+			entnarfilechunk.NarFileIDEQ(narFile.ID),
+			entnarfilechunk.ChunkIndexEQ(1),
+		).
+		Exec(ctx)
+	require.NoError(t, err)
+
+	// Lose the last chunk.
+	lastChunk, err := dbClient.Ent().NarFileChunk.Query().
+		Where(
+			entnarfilechunk.NarFileIDEQ(narFile.ID),
+			entnarfilechunk.ChunkIndexEQ(2),
+		).
+		QueryChunk().
+		Only(ctx)
+	require.NoError(t, err)
+	require.NoError(t, cs.DeleteChunk(ctx, lastChunk.Hash))
+
+	// A narinfo linked to no nar_file.
+	_, err = createNarInfoFromParsed(ctx, dbClient, testdata.Nar2.NarInfoHash,
+		parseFsckNarInfoText(t, testdata.Nar2.NarInfoText))
+	require.NoError(t, err)
+
+	report, err := runVerify(ctx, t, dbURL, dir)
+	require.ErrorIs(t, err, ncps.ErrVerifyIssuesFound)
+	assert.Equal(t, 1, ncps.ExitCode(err))
+
+	assert.Len(t, report.CorruptChunks, 2)
+	assert.Len(t, report.MissingChunks, 1)
+
+	if assert.Len(t, report.IncompleteNarFiles, 1) {
+		assert.Equal(t, testdata.Nar1.NarHash, report.IncompleteNarFiles[0].Hash)
+		assert.Equal(t, "chunk index 1 is missing", report.IncompleteNarFiles[0].Detail)
+	}
+
+	if assert.Len(t, report.NarInfosWithoutNarFile, 1) {
+		assert.Equal(t, testdata.Nar2.NarInfoHash, report.NarInfosWithoutNarFile[0].Hash)
+	}
+
+	assert.Empty(t, report.NarFilesWithoutNarInfo)
+	assert.Equal(t, 5, report.Issues)
+
+	if assert.Len(t, report.NarFilesToRepair, 1) {
+		assert.Equal(t, testdata.Nar1.NarHash, report.NarFilesToRepair[0].Hash)
+	}
+}
+
+func TestVerifyFailureExitCode(t *testing.T) {
+	t.Parallel()
+
+	ctx := zerolog.New(os.Stderr).WithContext(context.Background())
+
+	_, _, dir, dbURL, cleanup := setupFsckSQLite(t)
+	t.Cleanup(cleanup)
+
+	app, err := ncps.New()
+	require.NoError(t, err)
+
+	err = app.Run(ctx, []string{
+		"ncps", "verify",
+		"--cache-database-url", dbURL,
+		"--cache-storage-local", dir,
+		"--format", "yaml",
+	})
+	require.ErrorIs(t, err, ncps.ErrVerifyUnknownFormat)
+	assert.Equal(t, 2, ncps.ExitCode(err))
+}
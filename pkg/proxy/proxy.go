@@ -0,0 +1,517 @@
+// Package proxy embeds the whole ncps binary cache proxy into another Go
+// binary. New sets up the database, the storage, the locks, the upstreams and
+// the cache from a single Options struct; the Proxy it returns serves the
+// binary cache, and its admin API, as an http.Handler, and Run drives its
+// background jobs.
+//
+// NewCache is the setup of the cache New and ncps serve share, from the
+// database, the storage and the upstreams the caller built.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog"
+
+	s3config "github.com/kalbasit/ncps/pkg/s3"
+	localstorage "github.com/kalbasit/ncps/pkg/storage/local"
+	storageS3 "github.com/kalbasit/ncps/pkg/storage/s3"
+
+	"github.com/kalbasit/ncps/migrations"
+	"github.com/kalbasit/ncps/pkg/cache"
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/database"
+	"github.com/kalbasit/ncps/pkg/database/migrate"
+	"github.com/kalbasit/ncps/pkg/lock"
+	"github.com/kalbasit/ncps/pkg/lock/local"
+	"github.com/kalbasit/ncps/pkg/server"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+const (
+	defaultHostname            = "localhost"
+	defaultLRUSchedule         = "@hourly"
+	defaultDownloadLockTTL     = 5 * time.Minute
+	defaultDownloadPollTimeout = 30 * time.Second
+	defaultLRULockTTL          = 30 * time.Minute
+)
+
+var (
+	// ErrDatabaseURLRequired is returned if Options.DatabaseURL is empty.
+	ErrDatabaseURLRequired = errors.New("the database URL is required")
+
+	// ErrStorageRequired is returned if neither Options.StoragePath nor
+	// Options.S3 is set.
+	ErrStorageRequired = errors.New("either the storage path or the S3 configuration is required")
+
+	// ErrStorageConflict is returned if both Options.StoragePath and
+	// Options.S3 are set.
+	ErrStorageConflict = errors.New("the storage path and the S3 configuration are mutually exclusive")
+
+	// ErrUpstreamRequired is returned if Options.Upstreams is empty.
+	ErrUpstreamRequired = errors.New("at least one upstream is required")
+)
+
+// Upstream is an upstream binary cache.
+type Upstream struct {
+	// URL is the URL of the upstream, such as https://cache.nixos.org.
+	URL string
+
+	// PublicKeys are the keys the narinfos of the upstream are verified with.
+	// If empty, their signatures are not verified.
+	PublicKeys []string
+}
+
+// Options configures a Proxy.
+type Options struct {
+	// Hostname is the name of the cache, used as the name of its signing key.
+	// If empty, localhost is used.
+	Hostname string
+
+	// DatabaseURL is the URL of the database: sqlite:, postgresql: or mysql:.
+	DatabaseURL string
+
+	// MigrateDatabase applies the pending database migrations, as ncps
+	// migrate up does, before setting up the cache.
+	MigrateDatabase bool
+
+	// StoragePath is the directory of the local storage. Exclusive with S3.
+	StoragePath string
+
+	// S3 is the configuration of the S3 storage. Exclusive with StoragePath.
+	S3 *s3config.Config
+
+	// Upstreams are the upstream caches, in order of preference.
+	Upstreams []Upstream
+
+	// SecretKeyPath is the path of the key the narinfos are signed with. If
+	// empty, a key is generated and stored in the database.
+	SecretKeyPath string
+
+	// TempDir is the directory of the temporary files. If empty, the default
+	// directory for temporary files is used.
+	TempDir string
+
+	// MaxSize is the maximum size of the NARs, enforced by the LRU on
+	// LRUSchedule. If zero, the cache is not bounded.
+	MaxSize uint64
+
+	// LRUSchedule is the cron spec of the LRU, in the Timezone. If empty, the
+	// LRU runs hourly.
+	LRUSchedule string
+
+	// Timezone is the time zone of the cron schedules. If nil, the local time
+	// zone is used.
+	Timezone *time.Location
+
+	// AllowPut accepts the uploads of narinfos and NARs under /upload.
+	AllowPut bool
+
+	// AllowDelete accepts the DELETE of narinfos and NARs.
+	AllowDelete bool
+
+	// AdminToken enables the admin API under /api/v1, guarded by this Bearer
+	// token. If empty, the admin API is disabled.
+	AdminToken string
+
+	// Locker and RWLocker coordinate the replicas sharing the database and the
+	// storage. If nil, in-memory locks fit for a single instance are used.
+	Locker   lock.Locker
+	RWLocker lock.RWLocker
+
+	// DownloadLockTTL, DownloadPollTimeout and LRULockTTL tune the locks. If
+	// zero, the defaults of ncps serve are used.
+	DownloadLockTTL     time.Duration
+	DownloadPollTimeout time.Duration
+	LRULockTTL          time.Duration
+
+	// Configure applies the settings of the cache Options does not cover. It
+	// is called before the upstreams are added. Optional.
+	Configure func(c *cache.Cache) error
+}
+
+// CacheOptions configures the cache set up by NewCache.
+type CacheOptions struct {
+	// Hostname is the name of the cache, used as the name of its signing key.
+	// If empty, localhost is used.
+	Hostname string
+
+	// DBClient is the database of the cache.
+	DBClient *database.Client
+
+	// ConfigStore, NarInfoStore and NarStore are the storage of the cache.
+	ConfigStore  storage.ConfigStore
+	NarInfoStore storage.NarInfoStore
+	NarStore     storage.NarStore
+
+	// SecretKeyPath is the path of the key the narinfos are signed with. If
+	// empty, a key is generated and stored in the database.
+	SecretKeyPath string
+
+	// TempDir is the directory of the temporary files. If empty, the default
+	// directory for temporary files is used.
+	TempDir string
+
+	// Locker and RWLocker coordinate the replicas sharing the database and the
+	// storage. If nil, in-memory locks fit for a single instance are used.
+	Locker   lock.Locker
+	RWLocker lock.RWLocker
+
+	// DownloadLockTTL, DownloadPollTimeout and LRULockTTL tune the locks. If
+	// zero, the defaults of ncps serve are used.
+	DownloadLockTTL     time.Duration
+	DownloadPollTimeout time.Duration
+	LRULockTTL          time.Duration
+
+	// Upstreams are the upstream caches, in order of preference.
+	Upstreams []*upstream.Cache
+
+	// Configure applies the settings of the cache CacheOptions does not cover.
+	// It is called before the upstreams are added. Optional.
+	Configure func(c *cache.Cache) error
+
+	// Timezone is the time zone of the cron schedules. If nil, the local time
+	// zone is used.
+	Timezone *time.Location
+
+	// MaxSize and ChunkStoreMaxSize are the maximum sizes of the NARs and of
+	// the chunks, enforced by the LRU on LRUSchedule. If both are zero, the
+	// cache is not bounded.
+	MaxSize           uint64
+	ChunkStoreMaxSize uint64
+
+	// LRUSchedule is the cron spec of the LRU, in the Timezone. If empty, the
+	// LRU runs hourly.
+	LRUSchedule string
+
+	// Schedule adds the cron jobs of the cache CacheOptions does not cover,
+	// once its cron is set up. Optional.
+	Schedule func(c *cache.Cache) error
+}
+
+// Proxy is an embedded ncps.
+type Proxy struct {
+	cache    *cache.Cache
+	server   *server.Server
+	dbClient *database.Client
+
+	closeOnce sync.Once
+}
+
+// New sets up a Proxy with opts. ctx bounds the upstream health checks; the
+// cron jobs start once Run is called. The caller calls Close once done, unless
+// Run returned.
+func New(ctx context.Context, opts Options) (*Proxy, error) {
+	if err := validateOptions(opts); err != nil {
+		return nil, err
+	}
+
+	dbClient, err := openDatabase(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := newProxy(ctx, opts, dbClient)
+	if err != nil {
+		_ = dbClient.Close()
+
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func newProxy(ctx context.Context, opts Options, dbClient *database.Client) (*Proxy, error) {
+	configStore, narInfoStore, narStore, err := newStorage(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ucs := make([]*upstream.Cache, 0, len(opts.Upstreams))
+
+	for _, us := range opts.Upstreams {
+		u, err := url.Parse(us.URL)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the upstream URL %q: %w", us.URL, err)
+		}
+
+		uc, err := upstream.New(ctx, u, &upstream.Options{PublicKeys: us.PublicKeys})
+		if err != nil {
+			return nil, fmt.Errorf("error creating the upstream %q: %w", us.URL, err)
+		}
+
+		ucs = append(ucs, uc)
+	}
+
+	c, err := NewCache(ctx, CacheOptions{
+		Hostname:            opts.Hostname,
+		DBClient:            dbClient,
+		ConfigStore:         configStore,
+		NarInfoStore:        narInfoStore,
+		NarStore:            narStore,
+		SecretKeyPath:       opts.SecretKeyPath,
+		TempDir:             opts.TempDir,
+		Locker:              opts.Locker,
+		RWLocker:            opts.RWLocker,
+		DownloadLockTTL:     opts.DownloadLockTTL,
+		DownloadPollTimeout: opts.DownloadPollTimeout,
+		LRULockTTL:          opts.LRULockTTL,
+		Upstreams:           ucs,
+		Configure:           opts.Configure,
+		Timezone:            opts.Timezone,
+		MaxSize:             opts.MaxSize,
+		LRUSchedule:         opts.LRUSchedule,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	srv := server.New(c)
+	srv.SetPutPermitted(opts.AllowPut)
+	srv.SetDeletePermitted(opts.AllowDelete)
+	srv.SetAdminToken(opts.AdminToken)
+
+	return &Proxy{cache: c, server: srv, dbClient: dbClient}, nil
+}
+
+// NewCache sets up a cache with opts: it creates it, applies opts.Configure,
+// adds the upstreams and checks their health, then sets up its cron with the
+// LRU and opts.Schedule. The caller starts the cron.
+func NewCache(ctx context.Context, opts CacheOptions) (*cache.Cache, error) {
+	locker, rwLocker := opts.Locker, opts.RWLocker
+	if locker == nil {
+		locker = local.NewLocker()
+	}
+
+	if rwLocker == nil {
+		rwLocker = local.NewRWLocker()
+	}
+
+	c, err := cache.New(
+		ctx,
+		withDefault(opts.Hostname, defaultHostname),
+		opts.DBClient,
+		opts.ConfigStore,
+		opts.NarInfoStore,
+		opts.NarStore,
+		opts.SecretKeyPath,
+		locker,
+		rwLocker,
+		withDefault(opts.DownloadLockTTL, defaultDownloadLockTTL),
+		withDefault(opts.DownloadPollTimeout, defaultDownloadPollTimeout),
+		withDefault(opts.LRULockTTL, defaultLRULockTTL),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the cache: %w", err)
+	}
+
+	if opts.TempDir != "" {
+		if err := c.SetTempDir(opts.TempDir); err != nil {
+			return nil, fmt.Errorf("error setting the temporary directory: %w", err)
+		}
+	}
+
+	if opts.Configure != nil {
+		if err := opts.Configure(c); err != nil {
+			return nil, err
+		}
+	}
+
+	c.AddUpstreamCaches(ctx, opts.Upstreams...)
+
+	// Check the upstreams now to speed up the boot, without waiting for it.
+	checkUpstreams(ctx, c)
+
+	c.SetupCron(ctx, opts.Timezone)
+
+	if opts.MaxSize > 0 || opts.ChunkStoreMaxSize > 0 {
+		schedule, err := cron.ParseStandard(withDefault(opts.LRUSchedule, defaultLRUSchedule))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the LRU schedule %q: %w", opts.LRUSchedule, err)
+		}
+
+		if opts.MaxSize > 0 {
+			c.SetMaxSize(opts.MaxSize)
+		}
+
+		if opts.ChunkStoreMaxSize > 0 {
+			c.SetChunkStoreMaxSize(opts.ChunkStoreMaxSize)
+		}
+
+		c.AddLRUCronJob(ctx, schedule)
+	}
+
+	if opts.Schedule != nil {
+		if err := opts.Schedule(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// checkUpstreams triggers the health check of the upstreams of c and logs its
+// outcome once done.
+func checkUpstreams(ctx context.Context, c *cache.Cache) {
+	if c.GetUpstreamCount() == 0 {
+		return
+	}
+
+	done := c.GetHealthChecker().Trigger()
+
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return
+		}
+
+		healthy, total := c.GetHealthyUpstreamCount(), c.GetUpstreamCount()
+
+		ev := zerolog.Ctx(ctx).Info()
+		if healthy < total {
+			ev = zerolog.Ctx(ctx).Warn()
+		}
+
+		ev.
+			Int("healthy_upstreams", healthy).
+			Int("upstreams", total).
+			Msg("checked the health of the upstreams")
+	}()
+}
+
+// Handler returns the handler serving the binary cache, and the admin API if
+// Options.AdminToken is set.
+func (p *Proxy) Handler() http.Handler { return p.server }
+
+// Cache returns the cache of the proxy, for the settings Options does not
+// cover.
+func (p *Proxy) Cache() *cache.Cache { return p.cache }
+
+// Run starts the cron jobs and blocks until ctx is done, then waits for the
+// background jobs and closes the proxy.
+func (p *Proxy) Run(ctx context.Context) error {
+	p.cache.StartCron(ctx)
+
+	<-ctx.Done()
+
+	return p.Close()
+}
+
+// Close waits for the background jobs and closes the database. It is safe to
+// call more than once.
+func (p *Proxy) Close() error {
+	var err error
+
+	p.closeOnce.Do(func() {
+		p.cache.Close()
+
+		err = p.dbClient.Close()
+	})
+
+	return err
+}
+
+func validateOptions(opts Options) error {
+	if opts.DatabaseURL == "" {
+		return ErrDatabaseURLRequired
+	}
+
+	switch {
+	case opts.StoragePath == "" && opts.S3 == nil:
+		return ErrStorageRequired
+	case opts.StoragePath != "" && opts.S3 != nil:
+		return ErrStorageConflict
+	}
+
+	if len(opts.Upstreams) == 0 {
+		return ErrUpstreamRequired
+	}
+
+	return nil
+}
+
+// openDatabase opens the database, migrating it first if asked to.
+func openDatabase(ctx context.Context, opts Options) (*database.Client, error) {
+	dbClient, err := database.Open(opts.DatabaseURL, nil)
+	if err != nil {
+		// Avoid embedding the URL — it may contain user:password credentials.
+		return nil, fmt.Errorf("error opening the database: %w", err)
+	}
+
+	if !opts.MigrateDatabase {
+		return dbClient, nil
+	}
+
+	sub, err := fs.Sub(migrations.FS, migrationsDir(dbClient.Type()))
+	if err != nil {
+		_ = dbClient.Close()
+
+		return nil, fmt.Errorf("error opening the migrations: %w", err)
+	}
+
+	if err := migrate.Up(ctx, migrate.Options{
+		DB:           dbClient.DB(),
+		Dialect:      dbClient.Type(),
+		MigrationsFS: sub,
+	}); err != nil {
+		_ = dbClient.Close()
+
+		return nil, fmt.Errorf("error migrating the database: %w", err)
+	}
+
+	return dbClient, nil
+}
+
+//nolint:staticcheck // deprecated: migration support
+func newStorage(
+	ctx context.Context,
+	opts Options,
+) (storage.ConfigStore, storage.NarInfoStore, storage.NarStore, error) {
+	if opts.S3 != nil {
+		s3Store, err := storageS3.New(ctx, *opts.S3)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error creating the S3 store: %w", err)
+		}
+
+		return s3Store, s3Store, s3Store, nil
+	}
+
+	localStore, err := localstorage.New(ctx, opts.StoragePath)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error creating the local store at %q: %w", opts.StoragePath, err)
+	}
+
+	return localStore, localStore, localStore, nil
+}
+
+// migrationsDir returns the directory of the migrations of the database type.
+func migrationsDir(t database.Type) string {
+	switch t {
+	case database.TypePostgreSQL:
+		return "postgres"
+	case database.TypeMySQL:
+		return "mysql"
+	case database.TypeSQLite, database.TypeUnknown:
+		return "sqlite"
+	default:
+		return "sqlite"
+	}
+}
+
+func withDefault[T comparable](v, def T) T {
+	var zero T
+	if v == zero {
+		return def
+	}
+
+	return v
+}
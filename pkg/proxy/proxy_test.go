@@ -0,0 +1,138 @@
+package proxy_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache"
+	"github.com/kalbasit/ncps/pkg/client"
+	"github.com/kalbasit/ncps/pkg/proxy"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+var errConfigure = errors.New("configure failed")
+
+func TestNew_Validation(t *testing.T) {
+	t.Parallel()
+
+	upstreams := []proxy.Upstream{{URL: "https://cache.nixos.org"}}
+
+	tests := []struct {
+		name string
+		opts proxy.Options
+		err  error
+	}{
+		{
+			name: "database URL required",
+			opts: proxy.Options{StoragePath: t.TempDir(), Upstreams: upstreams},
+			err:  proxy.ErrDatabaseURLRequired,
+		},
+		{
+			name: "storage required",
+			opts: proxy.Options{DatabaseURL: "sqlite:" + t.TempDir() + "/db.sqlite", Upstreams: upstreams},
+			err:  proxy.ErrStorageRequired,
+		},
+		{
+			name: "upstream required",
+			opts: proxy.Options{DatabaseURL: "sqlite:" + t.TempDir() + "/db.sqlite", StoragePath: t.TempDir()},
+			err:  proxy.ErrUpstreamRequired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := proxy.New(context.Background(), tt.opts)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestProxy(t *testing.T) {
+	t.Parallel()
+
+	hts := testdata.NewTestServer(t, 40)
+	t.Cleanup(hts.Close)
+
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "storage"), 0o700))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	p, err := proxy.New(ctx, proxy.Options{
+		DatabaseURL:     "sqlite:" + filepath.Join(dir, "db.sqlite"),
+		MigrateDatabase: true,
+		StoragePath:     filepath.Join(dir, "storage"),
+		TempDir:         dir,
+		Upstreams:       []proxy.Upstream{{URL: hts.URL, PublicKeys: testdata.PublicKeys()}},
+		MaxSize:         1 << 30,
+		AdminToken:      "secret",
+	})
+	require.NoError(t, err)
+
+	runErr := make(chan error, 1)
+
+	go func() { runErr <- p.Run(ctx) }()
+
+	srv := httptest.NewServer(p.Handler())
+	t.Cleanup(srv.Close)
+
+	c, err := client.New(srv.URL, client.Options{Token: "secret", RetryBackoff: time.Millisecond})
+	require.NoError(t, err)
+
+	// The upstream is usable once its first health check is done.
+	require.EventuallyWithT(t, func(ct *assert.CollectT) {
+		ni, err := c.GetNarInfo(ctx, testdata.Nar1.NarInfoHash)
+		if assert.NoError(ct, err) {
+			assert.Contains(ct, ni.StorePath, testdata.Nar1.NarInfoHash)
+		}
+	}, 10*time.Second, 50*time.Millisecond)
+
+	stats, err := c.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.NarInfos)
+	assert.Equal(t, uint64(1<<30), stats.MaxSize)
+
+	jobs, err := c.CronJobs(ctx)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "lru", jobs[0].Name)
+
+	cancel()
+
+	require.NoError(t, <-runErr)
+	require.NoError(t, p.Close(), "Close is idempotent")
+}
+
+func TestNew_Configure(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "storage"), 0o700))
+
+	var configured *cache.Cache
+
+	_, err := proxy.New(context.Background(), proxy.Options{
+		DatabaseURL:     "sqlite:" + filepath.Join(dir, "db.sqlite"),
+		MigrateDatabase: true,
+		StoragePath:     filepath.Join(dir, "storage"),
+		Upstreams:       []proxy.Upstream{{URL: "https://cache.nixos.org"}},
+		Configure: func(c *cache.Cache) error {
+			configured = c
+
+			return errConfigure
+		},
+	})
+	require.ErrorIs(t, err, errConfigure)
+	assert.NotNil(t, configured)
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+)
+
+//nolint:gochecknoglobals
+var (
+	narTransfersAborted     metric.Int64Counter
+	narTransferAbortedBytes metric.Int64Counter
+	abortingClientsFlagged  metric.Int64Counter
+)
+
+//nolint:gochecknoinits
+func init() {
+	meter := otel.Meter(otelPackageName)
+
+	var err error
+
+	narTransfersAborted, err = meter.Int64Counter(
+		"ncps_server_nar_transfers_aborted_total",
+		metric.WithDescription("NAR transfers aborted by the client before the whole NAR was sent."),
+		metric.WithUnit("{transfer}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narTransferAbortedBytes, err = meter.Int64Counter(
+		"ncps_server_nar_transfer_aborted_bytes_total",
+		metric.WithDescription("Bytes sent to clients in NAR transfers they aborted."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	abortingClientsFlagged, err = meter.Int64Counter(
+		"ncps_server_aborting_clients_flagged_total",
+		metric.WithDescription("Clients flagged for aborting too many NAR transfers within the abort window."),
+		metric.WithUnit("{client}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// ClientAbortTracking flags the clients aborting many NAR transfers, which
+// usually points at a network issue or a client timeout too short for the
+// NARs served.
+type ClientAbortTracking struct {
+	// Threshold flags a client aborting at least this many NAR transfers
+	// within Window. Zero disables the flagging.
+	Threshold int
+
+	// Window is the period over which the aborts of a client are counted.
+	Window time.Duration
+}
+
+// SetClientAbortTracking configures the flagging of the clients aborting many
+// NAR transfers. A flagged client is logged with a warning, at most once per
+// window, and counted by ncps_server_aborting_clients_flagged_total. The
+// aborted transfers are counted whether or not the flagging is enabled.
+func (s *Server) SetClientAbortTracking(tracking ClientAbortTracking) {
+	if tracking.Threshold <= 0 || tracking.Window <= 0 {
+		s.abortTracker = nil
+
+		return
+	}
+
+	s.abortTracker = &abortTracker{
+		threshold: tracking.Threshold,
+		window:    tracking.Window,
+		clients:   make(map[string]*clientAborts),
+	}
+}
+
+// abortTracker counts the aborted NAR transfers of each client over a sliding
+// window.
+type abortTracker struct {
+	threshold int
+	window    time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*clientAborts
+}
+
+type clientAborts struct {
+	aborts    []time.Time
+	flaggedAt time.Time
+}
+
+// record records an abort by client at now. It returns the number of aborts of
+// the client within the window and true if the client is to be flagged.
+func (t *abortTracker) record(client string, now time.Time) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := now.Add(-t.window)
+
+	// Forget the clients that did not abort within the window.
+	for c, ca := range t.clients {
+		if ca.aborts[len(ca.aborts)-1].Before(cutoff) && ca.flaggedAt.Before(cutoff) {
+			delete(t.clients, c)
+		}
+	}
+
+	ca, ok := t.clients[client]
+	if !ok {
+		ca = &clientAborts{}
+		t.clients[client] = ca
+	}
+
+	i := 0
+	for i < len(ca.aborts) && ca.aborts[i].Before(cutoff) {
+		i++
+	}
+
+	ca.aborts = append(ca.aborts[i:], now)
+
+	if len(ca.aborts) < t.threshold || !ca.flaggedAt.Before(cutoff) {
+		return len(ca.aborts), false
+	}
+
+	ca.flaggedAt = now
+
+	return len(ca.aborts), true
+}
+
+// countingWriter counts the bytes written to w and keeps the first write
+// error, which means the client went away.
+type countingWriter struct {
+	w io.Writer
+
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+
+	if err != nil && cw.err == nil {
+		cw.err = err
+	}
+
+	return n, err
+}
+
+// clientAddr returns the address of the client of r.
+func clientAddr(r *http.Request) string {
+	if ip := middleware.GetClientIP(r.Context()); ip != "" {
+		return ip
+	}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr
+}
+
+// recordNarAbort records a transfer of the NAR at nu aborted by the client of
+// r after sent bytes were sent.
+func (s *Server) recordNarAbort(r *http.Request, nu nar.URL, sent int64) {
+	ctx := r.Context()
+	client := clientAddr(r)
+
+	attrs := metric.WithAttributes(attribute.String("compression", nu.Compression.String()))
+
+	narTransfersAborted.Add(ctx, 1, attrs)
+	narTransferAbortedBytes.Add(ctx, sent, attrs)
+
+	zerolog.Ctx(ctx).
+		Debug().
+		Str("client", client).
+		Int64("sent_bytes", sent).
+		Msg("nar transfer aborted by the client")
+
+	if s.abortTracker == nil {
+		return
+	}
+
+	aborts, flagged := s.abortTracker.record(client, time.Now())
+	if !flagged {
+		return
+	}
+
+	abortingClientsFlagged.Add(ctx, 1)
+
+	zerolog.Ctx(ctx).
+		Warn().
+		Str("client", client).
+		Int("aborts", aborts).
+		Dur("window", s.abortTracker.window).
+		Msg("client aborts many nar transfers; check its network and timeouts")
+}
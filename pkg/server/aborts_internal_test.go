@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errBrokenPipe = errors.New("broken pipe")
+
+func TestAbortTracker(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{}
+	s.SetClientAbortTracking(ClientAbortTracking{Threshold: 3, Window: time.Minute})
+	require.NotNil(t, s.abortTracker)
+
+	tracker := s.abortTracker
+	now := time.Now()
+
+	for i := range 2 {
+		aborts, flagged := tracker.record("10.0.0.1", now.Add(time.Duration(i)*time.Second))
+		assert.Equal(t, i+1, aborts)
+		assert.False(t, flagged)
+	}
+
+	aborts, flagged := tracker.record("10.0.0.1", now.Add(2*time.Second))
+	assert.Equal(t, 3, aborts)
+	assert.True(t, flagged, "the third abort within the window flags the client")
+
+	_, flagged = tracker.record("10.0.0.1", now.Add(3*time.Second))
+	assert.False(t, flagged, "a client is flagged at most once per window")
+
+	_, flagged = tracker.record("10.0.0.2", now.Add(3*time.Second))
+	assert.False(t, flagged, "the aborts are counted per client")
+
+	aborts, flagged = tracker.record("10.0.0.1", now.Add(2*time.Minute))
+	assert.Equal(t, 1, aborts, "the aborts out of the window are forgotten")
+	assert.False(t, flagged)
+
+	tracker.mu.Lock()
+	assert.NotContains(t, tracker.clients, "10.0.0.2", "the clients without a recent abort are forgotten")
+	tracker.mu.Unlock()
+
+	s.SetClientAbortTracking(ClientAbortTracking{})
+	assert.Nil(t, s.abortTracker)
+}
+
+type failingWriter struct{ limit int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		return w.limit, errBrokenPipe
+	}
+
+	w.limit -= len(p)
+
+	return len(p), nil
+}
+
+func TestCountingWriter(t *testing.T) {
+	t.Parallel()
+
+	cw := &countingWriter{w: &failingWriter{limit: 5}}
+
+	_, err := cw.Write([]byte("abc"))
+	require.NoError(t, err)
+
+	_, err = cw.Write([]byte("defg"))
+	require.ErrorIs(t, err, errBrokenPipe)
+
+	assert.EqualValues(t, 5, cw.n)
+	require.ErrorIs(t, cw.err, errBrokenPipe)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/kalbasit/ncps/pkg/accesslog"
+	"github.com/kalbasit/ncps/pkg/cache"
+)
+
+type accessEntryKey struct{}
+
+// accessEntry collects what the handler learns about the request it serves
+// for its access record.
+type accessEntry struct {
+	serveInfo *cache.ServeInfo
+	storePath string
+}
+
+// SetAccessLog configures the server to record every narinfo and NAR GET and
+// HEAD request to exporter. A nil exporter disables the access log.
+func (s *Server) SetAccessLog(exporter *accesslog.Exporter) { s.accessLog = exporter }
+
+// withAccessLog records the requests served by h, of kind, to the access log.
+func (s *Server) withAccessLog(kind string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.accessLog == nil {
+			h(w, r)
+
+			return
+		}
+
+		start := time.Now()
+		entry := &accessEntry{}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		h(ww, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry)))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		record := accesslog.Record{
+			Time:       start.UTC(),
+			Kind:       kind,
+			Hash:       chi.URLParam(r, "hash"),
+			StorePath:  entry.storePath,
+			Client:     clientAddr(r),
+			Method:     r.Method,
+			Status:     status,
+			Bytes:      int64(ww.BytesWritten()),
+			DurationMS: time.Since(start).Milliseconds(),
+		}
+
+		if entry.serveInfo != nil {
+			record.Result = string(entry.serveInfo.Status())
+		}
+
+		s.accessLog.Record(r.Context(), record)
+	}
+}
+
+// setAccessStorePath records the store path of the narinfo served to r in its
+// access record, as its name without the store directory and hash.
+func setAccessStorePath(r *http.Request, storePath string) {
+	entry, ok := r.Context().Value(accessEntryKey{}).(*accessEntry)
+	if !ok {
+		return
+	}
+
+	name := path.Base(storePath)
+	if _, after, found := strings.Cut(name, "-"); found {
+		name = after
+	}
+
+	entry.storePath = name
+}
@@ -0,0 +1,120 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/accesslog"
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/database"
+	"github.com/kalbasit/ncps/pkg/server"
+	"github.com/kalbasit/ncps/pkg/storage/local"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	records []accesslog.Record
+}
+
+func (s *recordingSink) Export(_ context.Context, records []accesslog.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records = append(s.records, records...)
+
+	return nil
+}
+
+func TestAccessLog(t *testing.T) {
+	t.Parallel()
+
+	hts := testdata.NewTestServer(t, 40)
+	t.Cleanup(hts.Close)
+
+	uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, hts.URL), &upstream.Options{
+		PublicKeys: testdata.PublicKeys(),
+	})
+	require.NoError(t, err)
+
+	dir, err := os.MkdirTemp("", "access-log-")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	dbFile := filepath.Join(dir, "var", "ncps", "db", "db.sqlite")
+	testhelper.CreateMigrateDatabase(t, dbFile)
+
+	dbClient, err := database.Open("sqlite:"+dbFile, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = dbClient.Close() })
+
+	localStore, err := local.New(newContext(), dir)
+	require.NoError(t, err)
+
+	c, err := newTestCache(newContext(), dbClient, localStore, localStore, localStore)
+	require.NoError(t, err)
+	t.Cleanup(c.Close)
+
+	c.AddUpstreamCaches(newContext(), uc)
+
+	<-c.GetHealthChecker().Trigger()
+
+	sink := &recordingSink{}
+	exporter := accesslog.NewExporter(sink, accesslog.Options{FlushInterval: time.Hour})
+
+	go exporter.Run(newContext())
+
+	s := server.New(c)
+	s.SetAccessLog(exporter)
+
+	get := func(method, path string) {
+		req := httptest.NewRequestWithContext(t.Context(), method, path, nil)
+		req.RemoteAddr = "10.1.2.3:4567"
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+	}
+
+	get(http.MethodGet, "/"+testdata.Nar1.NarInfoHash+".narinfo")
+	get(http.MethodHead, "/"+testdata.Nar1.NarInfoHash+".narinfo")
+	get(http.MethodGet, "/00000000000000000000000000000000.narinfo")
+	get(http.MethodGet, "/nix-cache-info")
+
+	require.NoError(t, exporter.Close(t.Context()))
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	require.Len(t, sink.records, 3, "only the narinfo and NAR requests are recorded")
+
+	got := sink.records[0]
+	assert.Equal(t, accesslog.KindNarInfo, got.Kind)
+	assert.Equal(t, testdata.Nar1.NarInfoHash, got.Hash)
+	assert.NotEmpty(t, got.StorePath)
+	assert.NotContains(t, got.StorePath, testdata.Nar1.NarInfoHash)
+	assert.Equal(t, "10.1.2.3", got.Client)
+	assert.Equal(t, http.MethodGet, got.Method)
+	assert.Equal(t, http.StatusOK, got.Status)
+	assert.Positive(t, got.Bytes)
+	assert.Equal(t, "MISS", got.Result)
+
+	got = sink.records[1]
+	assert.Equal(t, http.MethodHead, got.Method)
+	assert.Equal(t, "HIT", got.Result)
+	assert.Zero(t, got.Bytes)
+
+	got = sink.records[2]
+	assert.Equal(t, http.StatusNotFound, got.Status)
+	assert.Empty(t, got.StorePath)
+}
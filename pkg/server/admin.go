@@ -0,0 +1,814 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+
+	"github.com/kalbasit/ncps/pkg/cache"
+	"github.com/kalbasit/ncps/pkg/config"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/narinfo"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+const (
+	routeCronJobs        = "/cron/jobs"
+	routeCronJob         = "/cron/jobs/{name}"
+	routeCronJobTrigger  = "/cron/jobs/{name}/trigger"
+	routeCronJobPause    = "/cron/jobs/{name}/pause"
+	routeCronJobResume   = "/cron/jobs/{name}/resume"
+	routeNarRepair       = "/nars/{hash}/repair"
+	routeHealthDetail    = "/health/detail"
+	routeStats           = "/stats"
+	routeGCReport        = "/gc/report"
+	routeAdminNarInfo    = "/narinfos/{hash:" + narinfo.HashPattern + "}"
+	routeAdminPins       = "/pins"
+	routeAdminPin        = "/pins/{hash:" + narinfo.HashPattern + "}"
+	routePrefetch        = "/prefetch"
+	routeCDC             = "/cdc"
+	routeCDCEnable       = "/cdc/enable"
+	routeCDCDisable      = "/cdc/disable"
+	routeJobs            = "/jobs"
+	routeJob             = "/jobs/{id}"
+	routeShadowUpstreams = "/upstreams/shadow"
+	routeShadowPromote   = "/upstreams/shadow/{hostname}/promote"
+	routeOffline         = "/offline"
+	routeOfflineEnable   = "/offline/enable"
+	routeOfflineDisable  = "/offline/disable"
+
+	errorCodeCronJobNotFound    = "cron_job_not_found"
+	errorCodeCronJobRunning     = "cron_job_running"
+	errorCodeCDCDisabled        = "cdc_disabled"
+	errorCodeNarBusy            = "nar_busy"
+	errorCodeNarNotChunked      = "nar_not_chunked"
+	errorCodeUpstreamNarChanged = "upstream_nar_changed"
+	errorCodeChunkedNarsRemain  = "chunked_nars_remain"
+	errorCodeChunkStoreRequired = "chunk_store_required"
+	errorCodeCDCConfigMismatch  = "cdc_config_mismatch"
+	errorCodeJobNotFound        = "job_not_found"
+	errorCodeShadowNotFound     = "shadow_upstream_not_found"
+
+	// defaultGCReportSamples and maxGCReportSamples bound the rows sampled
+	// per kind by the GC report.
+	defaultGCReportSamples = 10
+	maxGCReportSamples     = 1000
+)
+
+// cronJobResponse is the JSON representation of a cache.CronJobStatus.
+type cronJobResponse struct {
+	Name         string     `json:"name"`
+	Paused       bool       `json:"paused"`
+	Deferred     bool       `json:"deferred"`
+	Running      bool       `json:"running"`
+	NextRun      *time.Time `json:"nextRun,omitempty"`
+	LastRun      *time.Time `json:"lastRun,omitempty"`
+	LastDuration string     `json:"lastDuration,omitempty"`
+	LastSuccess  *bool      `json:"lastSuccess,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+	Runs         int64      `json:"runs"`
+	Failures     int64      `json:"failures"`
+}
+
+func newCronJobResponse(status cache.CronJobStatus) cronJobResponse {
+	resp := cronJobResponse{
+		Name:      status.Name,
+		Paused:    status.Paused,
+		Deferred:  status.Deferred,
+		Running:   status.Running,
+		LastError: status.LastError,
+		Runs:      status.Runs,
+		Failures:  status.Failures,
+	}
+
+	if !status.NextRun.IsZero() {
+		resp.NextRun = &status.NextRun
+	}
+
+	if !status.LastRun.IsZero() {
+		resp.LastRun = &status.LastRun
+	}
+
+	if status.Runs > 0 {
+		success := status.LastError == ""
+		resp.LastSuccess = &success
+		resp.LastDuration = status.LastDuration.String()
+	}
+
+	return resp
+}
+
+func (s *Server) registerAdminRoutes(r chi.Router) {
+	r.Use(s.requireAdminToken)
+
+	s.registerAdminAPIRoutes(r)
+}
+
+// AdminHandler returns a handler serving the admin API under /api/v1 without
+// requiring the admin token, for a listener whose access is already restricted
+// such as the local control socket.
+func (s *Server) AdminHandler() http.Handler {
+	r := chi.NewRouter()
+	r.NotFound(notFound)
+	r.MethodNotAllowed(methodNotAllowed)
+
+	r.Use(recoverer)
+
+	r.Route(routeAdminAPI, s.registerAdminAPIRoutes)
+
+	return r
+}
+
+func (s *Server) registerAdminAPIRoutes(r chi.Router) {
+	r.Get(routeCronJobs, s.listCronJobs)
+	r.Get(routeCronJob, s.getCronJob)
+	r.Post(routeCronJobTrigger, s.triggerCronJob)
+	r.Post(routeCronJobPause, s.pauseCronJob)
+	r.Post(routeCronJobResume, s.resumeCronJob)
+
+	r.Post(routeNarRepair, s.repairNar)
+
+	r.Get(routeHealthDetail, s.getHealthDetail)
+
+	r.Get(routeStats, s.getStats)
+	r.Get(routeGCReport, s.getGCReport)
+	r.Delete(routeAdminNarInfo, s.evictNarInfo)
+
+	r.Get(routeAdminPins, s.listPins)
+	r.Post(routeAdminPin, s.pinClosure)
+	r.Delete(routeAdminPin, s.unpinClosure)
+
+	r.Post(routePrefetch, s.prefetchClosures)
+
+	r.Get(routeCDC, s.getCDC)
+	r.Post(routeCDCEnable, s.enableCDC)
+	r.Post(routeCDCDisable, s.disableCDC)
+
+	r.Get(routeJobs, s.listJobs)
+	r.Get(routeJob, s.getJob)
+
+	r.Get(routeShadowUpstreams, s.listShadowUpstreams)
+	r.Post(routeShadowPromote, s.promoteShadowUpstream)
+
+	r.Get(routeOffline, s.getOffline)
+	r.Post(routeOfflineEnable, s.enableOffline)
+	r.Post(routeOfflineDisable, s.disableOffline)
+}
+
+// requireAdminToken is a middleware that hides the admin API unless an admin
+// token is configured, and enforces Bearer token authentication otherwise.
+func (s *Server) requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			notFound(w, r)
+
+			return
+		}
+
+		if !hasBearerToken(r, s.adminToken) {
+			unauthorized(w, r)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) listCronJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := s.cache.CronJobs()
+
+	resp := make([]cronJobResponse, 0, len(jobs))
+	for _, job := range jobs {
+		resp = append(resp, newCronJobResponse(job))
+	}
+
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+func (s *Server) getCronJob(w http.ResponseWriter, r *http.Request) {
+	s.respondCronJob(w, r, http.StatusOK, nil)
+}
+
+func (s *Server) triggerCronJob(w http.ResponseWriter, r *http.Request) {
+	s.respondCronJob(w, r, http.StatusAccepted, func(name string) error {
+		return s.cache.TriggerCronJob(r.Context(), name)
+	})
+}
+
+func (s *Server) pauseCronJob(w http.ResponseWriter, r *http.Request) {
+	s.respondCronJob(w, r, http.StatusOK, s.cache.PauseCronJob)
+}
+
+func (s *Server) resumeCronJob(w http.ResponseWriter, r *http.Request) {
+	s.respondCronJob(w, r, http.StatusOK, s.cache.ResumeCronJob)
+}
+
+// respondCronJob applies action, if any, to the cron job named in the URL and
+// answers with its status.
+func (s *Server) respondCronJob(
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	action func(name string) error,
+) {
+	name := chi.URLParam(r, "name")
+
+	if action != nil {
+		if err := action(name); err != nil {
+			writeCronJobError(w, r, err)
+
+			return
+		}
+
+		zerolog.Ctx(r.Context()).
+			Info().
+			Str("cron_job", name).
+			Str("path", r.URL.Path).
+			Msg("cron job updated via the admin API")
+	}
+
+	job, err := s.cache.CronJob(name)
+	if err != nil {
+		writeCronJobError(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, r, status, newCronJobResponse(job))
+}
+
+func writeCronJobError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, cache.ErrCronJobNotFound):
+		writeError(w, r, http.StatusNotFound, errorCodeCronJobNotFound, err.Error())
+	case errors.Is(err, cache.ErrCronJobRunning):
+		writeError(w, r, http.StatusConflict, errorCodeCronJobRunning, err.Error())
+	case errors.Is(err, cache.ErrCronStopped):
+		writeError(w, r, http.StatusServiceUnavailable, errorCodeInternal, err.Error())
+	default:
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
+	}
+}
+
+// narRepairResponse is the JSON representation of a cache.ChunkRepairResult.
+type narRepairResponse struct {
+	Hash           string `json:"hash"`
+	Repaired       bool   `json:"repaired"`
+	TotalChunks    int64  `json:"totalChunks"`
+	ReplacedChunks int    `json:"replacedChunks"`
+}
+
+// repairNar repairs the chunks of the chunked NAR named in the URL from its
+// upstream. It answers once the repair is done.
+func (s *Server) repairNar(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+
+	narURL, err := nar.ParseURL("nar/" + hash + ".nar")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errorCodeBadRequest, err.Error())
+
+		return
+	}
+
+	result, err := s.cache.RepairChunkedNar(r.Context(), narURL)
+	if err != nil {
+		switch {
+		case errors.Is(err, cache.ErrNarNotChunked):
+			writeError(w, r, http.StatusNotFound, errorCodeNarNotChunked, err.Error())
+		case errors.Is(err, storage.ErrNotFound):
+			writeError(w, r, http.StatusNotFound, errorCodeNotFound, err.Error())
+		case errors.Is(err, cache.ErrCDCDisabled):
+			writeError(w, r, http.StatusConflict, errorCodeCDCDisabled, err.Error())
+		case errors.Is(err, cache.ErrMigrationInProgress):
+			writeError(w, r, http.StatusConflict, errorCodeNarBusy, err.Error())
+		case errors.Is(err, cache.ErrUpstreamNarChanged), errors.Is(err, cache.ErrNarHashMismatch):
+			writeError(w, r, http.StatusConflict, errorCodeUpstreamNarChanged, err.Error())
+		default:
+			writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
+		}
+
+		return
+	}
+
+	zerolog.Ctx(r.Context()).
+		Info().
+		Str("nar_hash", hash).
+		Bool("repaired", result.Repaired).
+		Int("replaced_chunks", result.ReplacedChunks).
+		Msg("nar repaired via the admin API")
+
+	writeJSON(w, r, http.StatusOK, narRepairResponse{
+		Hash:           hash,
+		Repaired:       result.Repaired,
+		TotalChunks:    result.TotalChunks,
+		ReplacedChunks: result.ReplacedChunks,
+	})
+}
+
+// healthReportResponse is the JSON representation of a cache.HealthReport.
+type healthReportResponse struct {
+	Status     cache.HealthStatus        `json:"status"`
+	Components []componentHealthResponse `json:"components"`
+}
+
+// componentHealthResponse is the JSON representation of a
+// cache.ComponentHealth.
+type componentHealthResponse struct {
+	Kind        cache.ComponentKind `json:"kind"`
+	Name        string              `json:"name"`
+	Status      cache.HealthStatus  `json:"status"`
+	CheckedAt   *time.Time          `json:"checkedAt,omitempty"`
+	Latency     string              `json:"latency,omitempty"`
+	LastError   string              `json:"lastError,omitempty"`
+	LastErrorAt *time.Time          `json:"lastErrorAt,omitempty"`
+}
+
+func newComponentHealthResponse(h cache.ComponentHealth) componentHealthResponse {
+	resp := componentHealthResponse{
+		Kind:      h.Kind,
+		Name:      h.Name,
+		Status:    h.Status,
+		LastError: h.LastError,
+	}
+
+	if !h.CheckedAt.IsZero() {
+		resp.CheckedAt = &h.CheckedAt
+		resp.Latency = h.Latency.String()
+	}
+
+	if !h.LastErrorAt.IsZero() {
+		resp.LastErrorAt = &h.LastErrorAt
+	}
+
+	return resp
+}
+
+// getHealthDetail reports the health of every component of the cache. It
+// answers 503 Service Unavailable while the cache is down.
+func (s *Server) getHealthDetail(w http.ResponseWriter, r *http.Request) {
+	report := s.cache.HealthReport(r.Context())
+
+	resp := healthReportResponse{
+		Status:     report.Status,
+		Components: make([]componentHealthResponse, 0, len(report.Components)),
+	}
+
+	for _, h := range report.Components {
+		resp.Components = append(resp.Components, newComponentHealthResponse(h))
+	}
+
+	status := http.StatusOK
+	if report.Status == cache.HealthDown {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, r, status, resp)
+}
+
+// statsResponse is the JSON representation of a cache.Stats.
+type statsResponse struct {
+	NarInfos         int    `json:"narInfos"`
+	NarFiles         int    `json:"narFiles"`
+	TotalSize        int64  `json:"totalSize"`
+	MaxSize          uint64 `json:"maxSize"`
+	Chunks           int    `json:"chunks"`
+	PinnedClosures   int    `json:"pinnedClosures"`
+	Upstreams        int    `json:"upstreams"`
+	HealthyUpstreams int    `json:"healthyUpstreams"`
+}
+
+func (s *Server) getStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.cache.Stats(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
+
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, statsResponse{
+		NarInfos:         stats.NarInfos,
+		NarFiles:         stats.NarFiles,
+		TotalSize:        stats.TotalSize,
+		MaxSize:          stats.MaxSize,
+		Chunks:           stats.Chunks,
+		PinnedClosures:   stats.PinnedClosures,
+		Upstreams:        stats.Upstreams,
+		HealthyUpstreams: stats.HealthyUpstreams,
+	})
+}
+
+// gcReportResponse is the JSON representation of a cache.GCReport.
+type gcReportResponse struct {
+	OrphanedNarInfos gcFindingResponse `json:"orphanedNarInfos"`
+	OrphanedNarFiles gcFindingResponse `json:"orphanedNarFiles"`
+	OrphanedChunks   gcFindingResponse `json:"orphanedChunks"`
+	BrokenChunkLinks gcFindingResponse `json:"brokenChunkLinks"`
+}
+
+// gcFindingResponse is the JSON representation of a cache.GCFinding.
+type gcFindingResponse struct {
+	Count   int      `json:"count"`
+	Samples []string `json:"samples"`
+}
+
+func newGCFindingResponse(f cache.GCFinding) gcFindingResponse {
+	samples := f.Samples
+	if samples == nil {
+		samples = []string{}
+	}
+
+	return gcFindingResponse{Count: f.Count, Samples: samples}
+}
+
+// getGCReport reports the orphaned and broken rows of the database, with up
+// to ?samples= (defaultGCReportSamples) of each kind, without deleting
+// anything.
+func (s *Server) getGCReport(w http.ResponseWriter, r *http.Request) {
+	samples := defaultGCReportSamples
+
+	if v := r.URL.Query().Get("samples"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxGCReportSamples {
+			writeError(w, r, http.StatusBadRequest, errorCodeBadRequest,
+				"samples must be an integer between 0 and "+strconv.Itoa(maxGCReportSamples))
+
+			return
+		}
+
+		samples = n
+	}
+
+	report, err := s.cache.GCReport(r.Context(), samples)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
+
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, gcReportResponse{
+		OrphanedNarInfos: newGCFindingResponse(report.OrphanedNarInfos),
+		OrphanedNarFiles: newGCFindingResponse(report.OrphanedNarFiles),
+		OrphanedChunks:   newGCFindingResponse(report.OrphanedChunks),
+		BrokenChunkLinks: newGCFindingResponse(report.BrokenChunkLinks),
+	})
+}
+
+// evictNarInfo deletes a narinfo, like DELETE on the narinfo route but
+// regardless of --cache-allow-delete-verb. Its NAR is left to the LRU.
+func (s *Server) evictNarInfo(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+
+	if err := s.cache.DeleteNarInfo(r.Context(), hash); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			writeError(w, r, http.StatusNotFound, errorCodeNarInfoNotFound, err.Error())
+
+			return
+		}
+
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
+
+		return
+	}
+
+	zerolog.Ctx(r.Context()).
+		Info().
+		Str("narinfo_hash", hash).
+		Msg("narinfo evicted via the admin API")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// prefetchRequest lists the store paths, or their narinfo hashes, whose
+// closures are prefetched.
+type prefetchRequest struct {
+	StorePaths []string `json:"storePaths"`
+}
+
+// prefetchResponse is the JSON representation of a cache.PrewarmResult.
+type prefetchResponse struct {
+	Roots   int `json:"roots"`
+	Cached  int `json:"cached"`
+	Fetched int `json:"fetched"`
+	Missing int `json:"missing"`
+	Failed  int `json:"failed"`
+}
+
+// prefetchClosures pulls the closures of the requested store paths from the
+// upstreams. It answers once they are cached.
+func (s *Server) prefetchClosures(w http.ResponseWriter, r *http.Request) {
+	var req prefetchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, errorCodeBadRequest, "error decoding the request: "+err.Error())
+
+		return
+	}
+
+	hashes := make([]string, 0, len(req.StorePaths))
+
+	for _, p := range req.StorePaths {
+		hash, err := narinfo.HashFromStorePath(p)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errorCodeBadRequest, "invalid store path "+p+": "+err.Error())
+
+			return
+		}
+
+		hashes = append(hashes, hash)
+	}
+
+	result, err := s.cache.PrefetchClosures(r.Context(), hashes)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
+
+		return
+	}
+
+	zerolog.Ctx(r.Context()).
+		Info().
+		Int("roots", result.Roots).
+		Int("fetched", result.Fetched).
+		Int("failed", result.Failed).
+		Msg("closures prefetched via the admin API")
+
+	writeJSON(w, r, http.StatusOK, prefetchResponse{
+		Roots:   result.Roots,
+		Cached:  result.Cached,
+		Fetched: result.Fetched,
+		Missing: result.Missing,
+		Failed:  result.Failed,
+	})
+}
+
+// cdcResponse is the JSON representation of a cache.CDCStatus.
+type cdcResponse struct {
+	Enabled      bool   `json:"enabled"`
+	ChunkStore   bool   `json:"chunkStore"`
+	LazyChunking bool   `json:"lazyChunking"`
+	MinSize      uint32 `json:"minSize,omitempty"`
+	AvgSize      uint32 `json:"avgSize,omitempty"`
+	MaxSize      uint32 `json:"maxSize,omitempty"`
+	InFlightJobs int    `json:"inFlightJobs"`
+	ChunkedNars  int    `json:"chunkedNars"`
+}
+
+// cdcEnableRequest sets the chunk sizes of CDC enabled for the first time.
+type cdcEnableRequest struct {
+	MinSize uint32 `json:"minSize"`
+	AvgSize uint32 `json:"avgSize"`
+	MaxSize uint32 `json:"maxSize"`
+}
+
+// cdcDisableRequest acknowledges that the NARs already chunked are served
+// from the chunk store until they are migrated back.
+type cdcDisableRequest struct {
+	Drain bool `json:"drain"`
+}
+
+func (s *Server) getCDC(w http.ResponseWriter, r *http.Request) {
+	s.respondCDC(w, r)
+}
+
+// enableCDC enables CDC with the chunk sizes of the optional request body.
+func (s *Server) enableCDC(w http.ResponseWriter, r *http.Request) {
+	var req cdcEnableRequest
+	if !decodeOptionalJSON(w, r, &req) {
+		return
+	}
+
+	if err := s.cache.EnableCDC(r.Context(), req.MinSize, req.AvgSize, req.MaxSize); err != nil {
+		writeCDCError(w, r, err)
+
+		return
+	}
+
+	zerolog.Ctx(r.Context()).
+		Info().
+		Msg("CDC enabled via the admin API")
+
+	s.respondCDC(w, r)
+}
+
+// disableCDC disables CDC. It answers once the chunking jobs in flight are
+// done.
+func (s *Server) disableCDC(w http.ResponseWriter, r *http.Request) {
+	var req cdcDisableRequest
+	if !decodeOptionalJSON(w, r, &req) {
+		return
+	}
+
+	if err := s.cache.DisableCDC(r.Context(), req.Drain); err != nil {
+		writeCDCError(w, r, err)
+
+		return
+	}
+
+	zerolog.Ctx(r.Context()).
+		Info().
+		Bool("drain", req.Drain).
+		Msg("CDC disabled via the admin API")
+
+	s.respondCDC(w, r)
+}
+
+func (s *Server) respondCDC(w http.ResponseWriter, r *http.Request) {
+	status, err := s.cache.CDCStatus(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
+
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, cdcResponse{
+		Enabled:      status.Enabled,
+		ChunkStore:   status.ChunkStore,
+		LazyChunking: status.LazyChunking,
+		MinSize:      status.MinSize,
+		AvgSize:      status.AvgSize,
+		MaxSize:      status.MaxSize,
+		InFlightJobs: status.InFlightJobs,
+		ChunkedNars:  status.ChunkedNars,
+	})
+}
+
+func writeCDCError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, cache.ErrChunkedNarsRemain):
+		writeError(w, r, http.StatusConflict, errorCodeChunkedNarsRemain, err.Error())
+	case errors.Is(err, cache.ErrChunkStoreRequired):
+		writeError(w, r, http.StatusConflict, errorCodeChunkStoreRequired, err.Error())
+	case errors.Is(err, config.ErrCDCConfigMismatch):
+		writeError(w, r, http.StatusConflict, errorCodeCDCConfigMismatch, err.Error())
+	case errors.Is(err, cache.ErrCDCSizesRequired), errors.Is(err, config.ErrCDCInvalidChunkSizes):
+		writeError(w, r, http.StatusBadRequest, errorCodeBadRequest, err.Error())
+	default:
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
+	}
+}
+
+// jobResponse is the JSON representation of a cache.JobProgress.
+type jobResponse struct {
+	ID         string        `json:"id"`
+	Kind       cache.JobKind `json:"kind"`
+	Hash       string        `json:"hash"`
+	StartedAt  time.Time     `json:"startedAt"`
+	BytesDone  int64         `json:"bytesDone"`
+	BytesTotal int64         `json:"bytesTotal,omitempty"`
+	ChunksDone int64         `json:"chunksDone,omitempty"`
+}
+
+func newJobResponse(job cache.JobProgress) jobResponse {
+	return jobResponse{
+		ID:         job.ID,
+		Kind:       job.Kind,
+		Hash:       job.Hash,
+		StartedAt:  job.StartedAt,
+		BytesDone:  job.BytesDone,
+		BytesTotal: job.BytesTotal,
+		ChunksDone: job.ChunksDone,
+	}
+}
+
+// listJobs reports the progress of the NAR downloads and chunking jobs in
+// flight.
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := s.cache.Jobs()
+
+	resp := make([]jobResponse, 0, len(jobs))
+	for _, job := range jobs {
+		resp = append(resp, newJobResponse(job))
+	}
+
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.cache.Job(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, errorCodeJobNotFound, err.Error())
+
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, newJobResponse(job))
+}
+
+// shadowUpstreamResponse is the JSON representation of a
+// cache.ShadowUpstreamStats.
+type shadowUpstreamResponse struct {
+	Hostname       string     `json:"hostname"`
+	URL            string     `json:"url"`
+	Since          time.Time  `json:"since"`
+	TrialEndsAt    *time.Time `json:"trialEndsAt,omitempty"`
+	Comparisons    int64      `json:"comparisons"`
+	Agreements     int64      `json:"agreements"`
+	Disagreements  int64      `json:"disagreements"`
+	Errors         int64      `json:"errors"`
+	Agreement      float64    `json:"agreement"`
+	ShadowLatency  string     `json:"shadowLatency,omitempty"`
+	PrimaryLatency string     `json:"primaryLatency,omitempty"`
+}
+
+func newShadowUpstreamResponse(stats cache.ShadowUpstreamStats) shadowUpstreamResponse {
+	resp := shadowUpstreamResponse{
+		Hostname:      stats.Hostname,
+		URL:           stats.URL,
+		Since:         stats.Since,
+		Comparisons:   stats.Comparisons,
+		Agreements:    stats.Agreements,
+		Disagreements: stats.Disagreements,
+		Errors:        stats.Errors,
+		Agreement:     stats.Agreement(),
+	}
+
+	if !stats.TrialEndsAt.IsZero() {
+		resp.TrialEndsAt = &stats.TrialEndsAt
+	}
+
+	if stats.Comparisons > 0 {
+		resp.ShadowLatency = stats.ShadowLatency.String()
+		resp.PrimaryLatency = stats.PrimaryLatency.String()
+	}
+
+	return resp
+}
+
+func (s *Server) listShadowUpstreams(w http.ResponseWriter, r *http.Request) {
+	shadows := s.cache.ShadowUpstreamStats()
+
+	resp := make([]shadowUpstreamResponse, 0, len(shadows))
+	for _, stats := range shadows {
+		resp = append(resp, newShadowUpstreamResponse(stats))
+	}
+
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+func (s *Server) promoteShadowUpstream(w http.ResponseWriter, r *http.Request) {
+	hostname := chi.URLParam(r, "hostname")
+
+	if err := s.cache.PromoteShadowUpstream(r.Context(), hostname); err != nil {
+		writeError(w, r, http.StatusNotFound, errorCodeShadowNotFound, err.Error())
+
+		return
+	}
+
+	zerolog.Ctx(r.Context()).
+		Info().
+		Str("hostname", hostname).
+		Msg("shadow upstream promoted via the admin API")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// offlineResponse is the JSON representation of the offline mode.
+type offlineResponse struct {
+	Offline bool `json:"offline"`
+}
+
+func (s *Server) getOffline(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, offlineResponse{Offline: s.cache.IsOffline()})
+}
+
+func (s *Server) enableOffline(w http.ResponseWriter, r *http.Request) {
+	s.cache.SetOffline(r.Context(), true)
+
+	writeJSON(w, r, http.StatusOK, offlineResponse{Offline: true})
+}
+
+func (s *Server) disableOffline(w http.ResponseWriter, r *http.Request) {
+	s.cache.SetOffline(r.Context(), false)
+
+	writeJSON(w, r, http.StatusOK, offlineResponse{Offline: false})
+}
+
+// decodeOptionalJSON decodes the request body, if any, into v. It answers 400
+// Bad Request and returns false if the body is not valid JSON.
+func decodeOptionalJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, r, http.StatusBadRequest, errorCodeBadRequest, "error decoding the request: "+err.Error())
+
+		return false
+	}
+
+	return true
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Set(contentType, contentTypeJSON)
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zerolog.Ctx(r.Context()).
+			Error().
+			Err(err).
+			Msg("error writing the JSON response")
+	}
+}
@@ -0,0 +1,423 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache"
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/server"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestAdminCronJobs(t *testing.T) {
+	t.Parallel()
+
+	const adminToken = "admin-secret"
+
+	c := newProblemTestCache(t)
+	c.SetupCron(newContext(), nil)
+	c.AddInflightStagingGCCronJob(newContext(), cron.Every(time.Hour))
+	c.StartCron(newContext())
+
+	s := server.New(c)
+	s.SetAdminToken(adminToken)
+
+	do := func(t *testing.T, s http.Handler, method, path, token string) *http.Response {
+		t.Helper()
+
+		req := httptest.NewRequestWithContext(t.Context(), method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+
+		resp := w.Result()
+		t.Cleanup(func() { resp.Body.Close() })
+
+		return resp
+	}
+
+	t.Run("disabled without an admin token", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(t, server.New(c), http.MethodGet, "/api/v1/cron/jobs", adminToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("admin handler needs no token", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(t, server.New(c).AdminHandler(), http.MethodGet, "/api/v1/cron/jobs", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = do(t, s.AdminHandler(), http.MethodGet, "/nix-cache-info", "")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "only the admin API is served")
+	})
+
+	t.Run("rejects a wrong token", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(t, s, http.MethodGet, "/api/v1/cron/jobs", "wrong")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, "Bearer", resp.Header.Get("WWW-Authenticate"))
+	})
+
+	t.Run("lists the jobs", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(t, s, http.MethodGet, "/api/v1/cron/jobs", adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+		var jobs []map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&jobs))
+		require.Len(t, jobs, 1)
+		assert.Equal(t, cache.CronJobStagingGC, jobs[0]["name"])
+		assert.NotEmpty(t, jobs[0]["nextRun"])
+	})
+
+	t.Run("unknown job", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(t, s, http.MethodPost, "/api/v1/cron/jobs/nope/trigger", adminToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("health detail", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(t, s, http.MethodGet, "/api/v1/health/detail", adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var report struct {
+			Status     string `json:"status"`
+			Components []struct {
+				Kind    string `json:"kind"`
+				Name    string `json:"name"`
+				Status  string `json:"status"`
+				Latency string `json:"latency"`
+			} `json:"components"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		assert.Equal(t, "ok", report.Status)
+
+		statuses := make(map[string]string)
+		for _, c := range report.Components {
+			statuses[c.Kind+"/"+c.Name] = c.Status
+		}
+
+		assert.Equal(t, "ok", statuses["database/database"])
+		assert.Equal(t, "ok", statuses["storage/nar"])
+		assert.Equal(t, "ok", statuses["lock/lock"])
+		assert.Contains(t, statuses, "cron/"+cache.CronJobStagingGC)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(t, s, http.MethodGet, "/api/v1/stats", adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var stats map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+		assert.Contains(t, stats, "narInfos")
+		assert.Contains(t, stats, "totalSize")
+		assert.Contains(t, stats, "healthyUpstreams")
+	})
+
+	t.Run("gc report", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(t, s, http.MethodGet, "/api/v1/gc/report?samples=5", adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var report map[string]map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+
+		for _, kind := range []string{"orphanedNarInfos", "orphanedNarFiles", "orphanedChunks", "brokenChunkLinks"} {
+			require.Contains(t, report, kind)
+			assert.InDelta(t, 0, report[kind]["count"], 0)
+			assert.Empty(t, report[kind]["samples"])
+		}
+
+		resp = do(t, s, http.MethodGet, "/api/v1/gc/report?samples=-1", adminToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("evict an unknown narinfo", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(t, s, http.MethodDelete, "/api/v1/narinfos/"+strings.Repeat("1", 32), adminToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("pins", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(t, s, http.MethodPost, "/api/v1/pins/"+strings.Repeat("2", 32), adminToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "only a cached narinfo is pinned")
+
+		resp = do(t, s, http.MethodGet, "/api/v1/pins", adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var hashes []string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&hashes))
+		assert.Empty(t, hashes)
+	})
+
+	t.Run("prefetch rejects an invalid store path", func(t *testing.T) {
+		t.Parallel()
+
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/api/v1/prefetch",
+			strings.NewReader(`{"storePaths": ["/nix/store/not-a-store-path"]}`))
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("nar repair needs CDC", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(t, s, http.MethodPost, "/api/v1/nars/"+strings.Repeat("1", 52)+"/repair", adminToken)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("trigger, pause and resume", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(t, s, http.MethodPost, "/api/v1/cron/jobs/"+cache.CronJobStagingGC+"/trigger", adminToken)
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		require.Eventually(t, func() bool {
+			job, err := c.CronJob(cache.CronJobStagingGC)
+			require.NoError(t, err)
+
+			return job.Runs == 1 && !job.Running
+		}, 5*time.Second, 10*time.Millisecond)
+
+		resp = do(t, s, http.MethodGet, "/api/v1/cron/jobs/"+cache.CronJobStagingGC, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var job map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+		assert.Equal(t, true, job["lastSuccess"])
+		assert.InDelta(t, 1, job["runs"], 0)
+
+		resp = do(t, s, http.MethodPost, "/api/v1/cron/jobs/"+cache.CronJobStagingGC+"/pause", adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+		assert.Equal(t, true, job["paused"])
+
+		resp = do(t, s, http.MethodPost, "/api/v1/cron/jobs/"+cache.CronJobStagingGC+"/resume", adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+		assert.Equal(t, false, job["paused"])
+	})
+}
+
+func TestAdminCDC(t *testing.T) {
+	t.Parallel()
+
+	c := newProblemTestCache(t)
+	h := server.New(c).AdminHandler()
+
+	do := func(t *testing.T, method, path, body string) (int, map[string]any) {
+		t.Helper()
+
+		req := httptest.NewRequestWithContext(t.Context(), method, path, strings.NewReader(body))
+		req.Header.Set("Accept", "application/json")
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		var answer map[string]any
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&answer))
+
+		return w.Code, answer
+	}
+
+	code, status := do(t, http.MethodGet, "/api/v1/cdc", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, status["enabled"])
+	assert.Equal(t, false, status["chunkStore"])
+
+	code, _ = do(t, http.MethodPost, "/api/v1/cdc/enable", "")
+	assert.Equal(t, http.StatusBadRequest, code, "the chunk sizes are required the first time")
+
+	const sizes = `{"minSize": 16384, "avgSize": 65536, "maxSize": 262144}`
+
+	code, problem := do(t, http.MethodPost, "/api/v1/cdc/enable", sizes)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "chunk_store_required", problem["code"])
+
+	c.SetChunkStoreFactory(func(context.Context) (chunk.Store, error) {
+		return chunk.NewLocalStore(t.TempDir())
+	})
+
+	code, status = do(t, http.MethodPost, "/api/v1/cdc/enable", sizes)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, status["enabled"])
+	assert.Equal(t, true, status["chunkStore"])
+	assert.InDelta(t, 65536, status["avgSize"], 0)
+
+	code, problem = do(t, http.MethodPost, "/api/v1/cdc/enable", `{"minSize": 1024, "avgSize": 4096, "maxSize": 8192}`)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "cdc_config_mismatch", problem["code"])
+
+	code, status = do(t, http.MethodPost, "/api/v1/cdc/disable", `{"drain": false}`)
+	require.Equal(t, http.StatusOK, code, "no NAR is chunked yet")
+	assert.Equal(t, false, status["enabled"])
+	assert.Equal(t, true, status["chunkStore"], "the chunk store keeps serving the chunked NARs")
+	assert.InDelta(t, 0, status["inFlightJobs"], 0)
+
+	code, status = do(t, http.MethodPost, "/api/v1/cdc/enable", "")
+	require.Equal(t, http.StatusOK, code, "the chunk sizes are remembered")
+	assert.Equal(t, true, status["enabled"])
+}
+
+func TestAdminJobs(t *testing.T) {
+	t.Parallel()
+
+	h := server.New(newProblemTestCache(t)).AdminHandler()
+
+	do := func(t *testing.T, path string, v any) int {
+		t.Helper()
+
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, path, nil)
+		req.Header.Set("Accept", "application/json")
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		require.NoError(t, json.NewDecoder(w.Body).Decode(v))
+
+		return w.Code
+	}
+
+	var jobs []map[string]any
+
+	require.Equal(t, http.StatusOK, do(t, "/api/v1/jobs", &jobs))
+	assert.Empty(t, jobs)
+
+	var problem map[string]any
+
+	assert.Equal(t, http.StatusNotFound, do(t, "/api/v1/jobs/download-unknown", &problem))
+	assert.Equal(t, "job_not_found", problem["code"])
+}
+
+func TestAdminShadowUpstreams(t *testing.T) {
+	t.Parallel()
+
+	c := newProblemTestCache(t)
+
+	hts := testdata.NewTestServer(t, 40)
+	t.Cleanup(hts.Close)
+
+	uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, hts.URL), nil)
+	require.NoError(t, err)
+
+	c.AddShadowUpstreamCaches(newContext(), cache.ShadowTrial{Period: time.Hour}, uc)
+
+	h := server.New(c).AdminHandler()
+
+	do := func(t *testing.T, method, path string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequestWithContext(t.Context(), method, path, nil)
+		req.Header.Set("Accept", "application/json")
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := do(t, http.MethodGet, "/api/v1/upstreams/shadow")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var shadows []map[string]any
+
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&shadows))
+	require.Len(t, shadows, 1)
+	assert.Equal(t, uc.GetHostname(), shadows[0]["hostname"])
+	assert.NotEmpty(t, shadows[0]["trialEndsAt"])
+
+	w = do(t, http.MethodPost, "/api/v1/upstreams/shadow/unknown.example.com/promote")
+	require.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "shadow_upstream_not_found")
+
+	w = do(t, http.MethodPost, "/api/v1/upstreams/shadow/"+uc.GetHostname()+"/promote")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, 1, c.GetUpstreamCount())
+}
+
+func TestAdminOffline(t *testing.T) {
+	t.Parallel()
+
+	c := newProblemTestCache(t)
+
+	hts := testdata.NewTestServer(t, 40)
+	t.Cleanup(hts.Close)
+
+	uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, hts.URL), nil)
+	require.NoError(t, err)
+
+	c.AddUpstreamCaches(newContext(), uc)
+
+	<-c.GetHealthChecker().Trigger()
+
+	s := server.New(c)
+	h := s.AdminHandler()
+
+	do := func(t *testing.T, handler http.Handler, method, path string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequestWithContext(t.Context(), method, path, nil)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := do(t, h, http.MethodPost, "/api/v1/offline/enable")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"offline":true}`, w.Body.String())
+	assert.True(t, c.IsOffline())
+
+	w = do(t, s, http.MethodGet, "/"+testdata.Nar1.NarInfoHash+".narinfo")
+	assert.Equal(t, http.StatusNotFound, w.Code, "an uncached narinfo is not pulled while offline")
+
+	w = do(t, h, http.MethodPost, "/api/v1/offline/disable")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = do(t, h, http.MethodGet, "/api/v1/offline")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"offline":false}`, w.Body.String())
+
+	w = do(t, s, http.MethodGet, "/"+testdata.Nar1.NarInfoHash+".narinfo")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// A cached narinfo is still served offline.
+	c.SetOffline(newContext(), true)
+
+	w = do(t, s, http.MethodGet, "/"+testdata.Nar1.NarInfoHash+".narinfo")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
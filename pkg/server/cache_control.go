@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kalbasit/ncps/pkg/cache"
+)
+
+const (
+	headerCacheControl = "Cache-Control"
+	headerVary         = "Vary"
+
+	cacheControlNoStore = "no-store"
+)
+
+// CacheControl configures the Cache-Control headers of the narinfo and NAR
+// responses, so the cache can be fronted by a CDN. A zero max-age leaves the
+// responses of its class without a Cache-Control header.
+type CacheControl struct {
+	// NarMaxAge is the max-age of the NARs. A NAR URL names the hash of its
+	// content, so its response is also marked immutable.
+	NarMaxAge time.Duration
+
+	// NarInfoMaxAge is the max-age of the narinfos. Keep it short: a narinfo
+	// can be deleted, purged or re-signed.
+	NarInfoMaxAge time.Duration
+}
+
+// SetCacheControl configures the Cache-Control headers of the narinfo and NAR
+// responses. Only the successful responses of the root routes are cacheable:
+// errors, 404s, 503s, redirects to presigned URLs and the responses under
+// /upload are sent with no-store, so a CDN never keeps a transient answer.
+func (s *Server) SetCacheControl(cc CacheControl) { s.cacheControl = cc }
+
+// withCacheControl serves h, adding the Cache-Control header of class to its
+// response.
+func (s *Server) withCacheControl(class string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		value := s.cacheControlValue(class, r)
+		if value == "" {
+			h(w, r)
+
+			return
+		}
+
+		h(&cacheControlWriter{ResponseWriter: w, value: value, nar: class == endpointNar}, r)
+	}
+}
+
+// cacheControlValue returns the Cache-Control header of the successful
+// responses of class to r, or an empty string when none is configured.
+func (s *Server) cacheControlValue(class string, r *http.Request) string {
+	var maxAge time.Duration
+
+	switch class {
+	case endpointNar:
+		maxAge = s.cacheControl.NarMaxAge
+	case endpointNarInfo:
+		maxAge = s.cacheControl.NarInfoMaxAge
+	}
+
+	if maxAge <= 0 {
+		return ""
+	}
+
+	if cache.IsUploadOnly(r.Context()) {
+		return cacheControlNoStore
+	}
+
+	value := "public, max-age=" + strconv.FormatInt(int64(maxAge.Seconds()), 10)
+
+	// A query can select an opaque upstream object, which the hash of the URL
+	// does not describe.
+	if class == endpointNar && r.URL.RawQuery == "" {
+		value += ", immutable"
+	}
+
+	return value
+}
+
+// cacheControlWriter sets the Cache-Control header as the status is written:
+// the configured value on a 200 or 206, no-store on anything else.
+type cacheControlWriter struct {
+	http.ResponseWriter
+
+	value       string
+	nar         bool
+	wroteHeader bool
+}
+
+func (w *cacheControlWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+
+		h := w.Header()
+
+		if status == http.StatusOK || status == http.StatusPartialContent {
+			h.Set(headerCacheControl, w.value)
+
+			// An uncompressed NAR may be compressed on the fly.
+			if w.nar {
+				h.Add(headerVary, "Accept-Encoding")
+			}
+		} else {
+			h.Set(headerCacheControl, cacheControlNoStore)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheControlWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (w *cacheControlWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *cacheControlWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kalbasit/ncps/pkg/cache"
+)
+
+func TestCacheControl(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{}
+	s.SetCacheControl(CacheControl{NarMaxAge: 365 * 24 * time.Hour, NarInfoMaxAge: time.Minute})
+
+	respond := func(status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(status) }
+	}
+
+	serve := func(class string, h http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.withCacheControl(class, h)(w, r)
+
+		return w
+	}
+
+	t.Run("a NAR is immutable", func(t *testing.T) {
+		t.Parallel()
+
+		w := serve(endpointNar, respond(http.StatusOK), httptest.NewRequest(http.MethodGet, "/nar/x.nar", nil))
+		assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	})
+
+	t.Run("a range of a NAR is immutable", func(t *testing.T) {
+		t.Parallel()
+
+		w := serve(endpointNar, respond(http.StatusPartialContent),
+			httptest.NewRequest(http.MethodGet, "/nar/x.nar", nil))
+		assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+	})
+
+	t.Run("a NAR with a query is not immutable", func(t *testing.T) {
+		t.Parallel()
+
+		w := serve(endpointNar, respond(http.StatusOK), httptest.NewRequest(http.MethodGet, "/nar/x.nar?hash=y", nil))
+		assert.Equal(t, "public, max-age=31536000", w.Header().Get("Cache-Control"))
+	})
+
+	t.Run("a narinfo has a short max-age", func(t *testing.T) {
+		t.Parallel()
+
+		w := serve(endpointNarInfo, func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("StorePath: /nix/store/x"))
+		}, httptest.NewRequest(http.MethodGet, "/x.narinfo", nil))
+		assert.Equal(t, "public, max-age=60", w.Header().Get("Cache-Control"))
+		assert.Empty(t, w.Header().Get("Vary"))
+	})
+
+	t.Run("errors and redirects are not stored", func(t *testing.T) {
+		t.Parallel()
+
+		for _, status := range []int{
+			http.StatusNotFound, http.StatusFound, http.StatusInternalServerError, http.StatusServiceUnavailable,
+		} {
+			w := serve(endpointNar, respond(status), httptest.NewRequest(http.MethodGet, "/nar/x.nar", nil))
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"), status)
+			assert.Empty(t, w.Header().Get("Vary"), status)
+		}
+	})
+
+	t.Run("uploads are not stored", func(t *testing.T) {
+		t.Parallel()
+
+		r := httptest.NewRequest(http.MethodGet, "/upload/x.narinfo", nil)
+		r = r.WithContext(cache.WithUploadOnly(r.Context()))
+
+		w := serve(endpointNarInfo, respond(http.StatusOK), r)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	})
+
+	t.Run("a zero max-age sets no header", func(t *testing.T) {
+		t.Parallel()
+
+		s := &Server{}
+		s.SetCacheControl(CacheControl{NarInfoMaxAge: time.Minute})
+
+		w := httptest.NewRecorder()
+		s.withCacheControl(endpointNar, respond(http.StatusOK))(w, httptest.NewRequest(http.MethodGet, "/nar/x.nar", nil))
+		assert.Empty(t, w.Header().Get("Cache-Control"))
+	})
+}
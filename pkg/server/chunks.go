@@ -0,0 +1,132 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/kalbasit/ncps/pkg/cache"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+const (
+	routeNarRecipe = "/nar/{hash:" + nar.NormalizedHashPattern + "}.recipe"
+	routeChunk     = "/chunk/{hash:[0-9a-f]{64}}"
+
+	contentTypeOctetStream = "application/octet-stream"
+)
+
+// chunkRecipeResponse is the JSON representation of a cache.ChunkRecipe.
+type chunkRecipeResponse struct {
+	Hash    string                `json:"hash"`
+	NarSize uint64                `json:"narSize"`
+	Chunks  []recipeChunkResponse `json:"chunks"`
+}
+
+// recipeChunkResponse is the JSON representation of a cache.RecipeChunk.
+type recipeChunkResponse struct {
+	Hash string `json:"hash"`
+	Size uint32 `json:"size"`
+}
+
+// getNarRecipe answers the ordered list of the chunks of a chunked NAR, so
+// that a peer cache or a client fetches from /chunk/{hash} only the chunks it
+// misses.
+func (s *Server) getNarRecipe(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+
+	ctx, span := tracer.Start(
+		r.Context(),
+		"server.getNarRecipe",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("nar_hash", hash),
+		),
+	)
+	defer span.End()
+
+	recipe, err := s.cache.GetChunkRecipe(ctx, nar.URL{Hash: hash})
+	if err != nil {
+		switch {
+		case errors.Is(err, cache.ErrNarNotChunked):
+			writeError(w, r, http.StatusNotFound, errorCodeNarNotChunked, err.Error())
+		case errors.Is(err, storage.ErrNotFound):
+			writeError(w, r, http.StatusNotFound, errorCodeNotFound, err.Error())
+		default:
+			zerolog.Ctx(ctx).
+				Error().
+				Err(err).
+				Str("nar_hash", hash).
+				Msg("error loading the chunk recipe")
+
+			writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
+		}
+
+		return
+	}
+
+	resp := chunkRecipeResponse{
+		Hash:    hash,
+		NarSize: recipe.NarSize,
+		Chunks:  make([]recipeChunkResponse, 0, len(recipe.Chunks)),
+	}
+
+	for _, ch := range recipe.Chunks {
+		resp.Chunks = append(resp.Chunks, recipeChunkResponse{Hash: ch.Hash, Size: ch.Size})
+	}
+
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+// getChunk answers the content of a chunk listed by a recipe.
+func (s *Server) getChunk(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+
+	ctx, span := tracer.Start(
+		r.Context(),
+		"server.getChunk",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("chunk_hash", hash),
+		),
+	)
+	defer span.End()
+
+	rc, err := s.cache.GetChunk(ctx, hash)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			writeError(w, r, http.StatusNotFound, errorCodeNotFound, err.Error())
+
+			return
+		}
+
+		zerolog.Ctx(ctx).
+			Error().
+			Err(err).
+			Str("chunk_hash", hash).
+			Msg("error reading the chunk")
+
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
+
+		return
+	}
+
+	defer rc.Close()
+
+	w.Header().Set(contentType, contentTypeOctetStream)
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, rc); err != nil {
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(err).
+			Str("chunk_hash", hash).
+			Msg("error writing the chunk")
+	}
+}
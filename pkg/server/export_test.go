@@ -1,6 +1,6 @@
 package server
 
-// NarInfoErrorStatus is a test-only export of narInfoErrorStatus.
-func NarInfoErrorStatus(err error) (status int, respond bool) {
-	return narInfoErrorStatus(err)
+// CacheErrorStatus is a test-only export of cacheErrorStatus.
+func CacheErrorStatus(err error) (status int, respond bool) {
+	return cacheErrorStatus(err)
 }
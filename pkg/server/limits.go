@@ -0,0 +1,127 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/sync/semaphore"
+)
+
+// Endpoint classes limited independently of each other by SetRequestLimits.
+const (
+	endpointNarInfo = "narinfo"
+	endpointNar     = "nar"
+	endpointUpload  = "upload"
+
+	// defaultLimitRetryAfter is the Retry-After sent with a 503 when
+	// RequestLimits.RetryAfter is not set.
+	defaultLimitRetryAfter = time.Second
+)
+
+//nolint:gochecknoglobals
+var (
+	requestsInFlight metric.Int64UpDownCounter
+	requestsRejected metric.Int64Counter
+)
+
+//nolint:gochecknoinits
+func init() {
+	meter := otel.Meter(otelPackageName)
+
+	var err error
+
+	requestsInFlight, err = meter.Int64UpDownCounter(
+		"ncps_server_limited_requests_in_flight",
+		metric.WithDescription("Requests in flight per endpoint class limited by the request limits."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	requestsRejected, err = meter.Int64Counter(
+		"ncps_server_limited_requests_rejected_total",
+		metric.WithDescription("Requests rejected with a 503 because their endpoint class was saturated."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// RequestLimits caps the requests served concurrently per endpoint class so
+// one class cannot starve the others. A zero limit leaves the class unlimited.
+type RequestLimits struct {
+	// NarInfo caps the concurrent narinfo GET and HEAD requests.
+	NarInfo int64
+
+	// Nar caps the concurrent NAR GET and HEAD requests.
+	Nar int64
+
+	// Upload caps the concurrent PUT requests.
+	Upload int64
+
+	// RetryAfter is advertised to clients rejected with a 503. It defaults to
+	// one second.
+	RetryAfter time.Duration
+}
+
+// SetRequestLimits configures the concurrency limit of each endpoint class. A
+// request arriving while its class is saturated is rejected at once with a 503
+// Service Unavailable and a Retry-After header rather than queued.
+func (s *Server) SetRequestLimits(limits RequestLimits) {
+	s.limiters = make(map[string]*semaphore.Weighted)
+
+	for class, limit := range map[string]int64{
+		endpointNarInfo: limits.NarInfo,
+		endpointNar:     limits.Nar,
+		endpointUpload:  limits.Upload,
+	} {
+		if limit > 0 {
+			s.limiters[class] = semaphore.NewWeighted(limit)
+		}
+	}
+
+	s.limitRetryAfter = limits.RetryAfter
+	if s.limitRetryAfter <= 0 {
+		s.limitRetryAfter = defaultLimitRetryAfter
+	}
+}
+
+// limit serves h within the concurrency limit of class.
+func (s *Server) limit(class string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sem := s.limiters[class]
+		if sem == nil {
+			h(w, r)
+
+			return
+		}
+
+		ctx := r.Context()
+		attrs := metric.WithAttributes(attribute.String("endpoint", class))
+
+		if !sem.TryAcquire(1) {
+			requestsRejected.Add(ctx, 1, attrs)
+
+			retryAfter := int(math.Ceil(s.limitRetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeError(w, r, http.StatusServiceUnavailable, errorCodeOverloaded,
+				"too many concurrent "+class+" requests, retry later")
+
+			return
+		}
+
+		defer sem.Release(1)
+
+		requestsInFlight.Add(ctx, 1, attrs)
+		defer requestsInFlight.Add(ctx, -1, attrs)
+
+		h(w, r)
+	}
+}
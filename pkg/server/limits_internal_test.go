@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLimits(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{}
+	s.SetRequestLimits(RequestLimits{Nar: 1, RetryAfter: 1500 * time.Millisecond})
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	blocking := s.limit(endpointNar, func(w http.ResponseWriter, _ *http.Request) {
+		close(entered)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ok := func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	done := make(chan int)
+
+	go func() {
+		w := httptest.NewRecorder()
+		blocking(w, httptest.NewRequest(http.MethodGet, "/nar/x.nar", nil))
+		done <- w.Code
+	}()
+
+	<-entered
+
+	// The nar class is saturated: a second nar request is turned away.
+	w := httptest.NewRecorder()
+	s.limit(endpointNar, ok)(w, httptest.NewRequest(http.MethodGet, "/nar/y.nar", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	// The other classes are independent of it, and unlimited here.
+	for _, class := range []string{endpointNarInfo, endpointUpload} {
+		w := httptest.NewRecorder()
+		s.limit(class, ok)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, w.Code, class)
+	}
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+
+	// Once the slot is released, nar requests are served again.
+	w = httptest.NewRecorder()
+	s.limit(endpointNar, ok)(w, httptest.NewRequest(http.MethodGet, "/nar/y.nar", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
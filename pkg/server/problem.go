@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+const contentTypeProblemJSON = "application/problem+json"
+
+// Error codes carried by the problem+json responses so clients and dashboards
+// can tell failure modes apart.
+const (
+	errorCodeBadRequest             = "bad_request"
+	errorCodeDownloadInterrupted    = "download_interrupted"
+	errorCodeIntegrity              = "integrity_error"
+	errorCodeInternal               = "internal_error"
+	errorCodeMethodNotAllowed       = "method_not_allowed"
+	errorCodeNarInfoNotFound        = "narinfo_not_found"
+	errorCodeNarInfoPurged          = "narinfo_purged"
+	errorCodeNarNotInStorage        = "nar_not_in_storage"
+	errorCodeNotFound               = "not_found"
+	errorCodeOverloaded             = "overloaded"
+	errorCodeRequestBudgetExhausted = "request_budget_exhausted"
+	errorCodeStorageFull            = "storage_full"
+	errorCodeUnauthorized           = "unauthorized"
+	errorCodeUpstreamUnreachable    = "upstream_unreachable"
+)
+
+// problem is an RFC 7807 problem details object.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+}
+
+// writeError writes an error response. Clients asking for JSON in their Accept
+// header get an RFC 7807 problem+json body carrying code; everyone else,
+// including nix, gets detail as plain text like http.Error.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	if !acceptsJSON(r) {
+		http.Error(w, detail, status)
+
+		return
+	}
+
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Instance: r.URL.Path,
+		Code:     code,
+	}
+
+	// Only surface details that are not just the status text repeated.
+	if detail != http.StatusText(status) {
+		p.Detail = detail
+	}
+
+	h := w.Header()
+	h.Del(contentLength)
+	h.Set(contentType, contentTypeProblemJSON)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		zerolog.Ctx(r.Context()).
+			Error().
+			Err(err).
+			Msg("error writing the problem response")
+	}
+}
+
+// acceptsJSON reports whether the Accept header of r asks for JSON.
+func acceptsJSON(r *http.Request) bool {
+	for v := range strings.SplitSeq(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+
+		if mediaType == contentTypeProblemJSON || mediaType == contentTypeJSON {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notFound answers requests for unknown routes.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, errorCodeNotFound, "404 page not found")
+}
+
+// methodNotAllowed answers requests using a method a route does not support.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed,
+		http.StatusText(http.StatusMethodNotAllowed))
+}
@@ -0,0 +1,254 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/cache"
+	"github.com/kalbasit/ncps/pkg/cache/upstream"
+	"github.com/kalbasit/ncps/pkg/database"
+	"github.com/kalbasit/ncps/pkg/server"
+	"github.com/kalbasit/ncps/pkg/storage/local"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func newProblemTestCache(t *testing.T) *cache.Cache {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "problem-")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	dbFile := filepath.Join(dir, "var", "ncps", "db", "db.sqlite")
+	testhelper.CreateMigrateDatabase(t, dbFile)
+
+	dbClient, err := database.Open("sqlite:"+dbFile, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = dbClient.Close() })
+
+	localStore, err := local.New(newContext(), dir)
+	require.NoError(t, err)
+
+	c, err := newTestCache(newContext(), dbClient, localStore, localStore, localStore)
+	require.NoError(t, err)
+	t.Cleanup(c.Close)
+
+	return c
+}
+
+func TestProblemResponses(t *testing.T) {
+	t.Parallel()
+
+	s := server.New(newProblemTestCache(t))
+
+	do := func(t *testing.T, s *server.Server, method, path, accept string) *http.Response {
+		t.Helper()
+
+		req := httptest.NewRequestWithContext(t.Context(), method, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+
+		resp := w.Result()
+		t.Cleanup(func() { resp.Body.Close() })
+
+		return resp
+	}
+
+	decode := func(t *testing.T, resp *http.Response) map[string]any {
+		t.Helper()
+
+		assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+		return body
+	}
+
+	narInfoPath := "/" + testdata.Nar1.NarInfoHash + ".narinfo"
+	narPath := "/nar/" + testdata.Nar1.NarHash + ".nar.xz"
+
+	t.Run("plain text without a JSON Accept header", func(t *testing.T) {
+		t.Parallel()
+
+		for _, path := range []string{narInfoPath, narPath} {
+			resp := do(t, s, http.MethodGet, path, "")
+			require.Equal(t, http.StatusNotFound, resp.StatusCode)
+			assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, "Not Found\n", string(body))
+		}
+
+		resp := do(t, s, http.MethodGet, "/does-not-exist", "text/html")
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "404 page not found\n", string(body))
+	})
+
+	t.Run("problem+json with a JSON Accept header", func(t *testing.T) {
+		t.Parallel()
+
+		tests := []struct {
+			method string
+			path   string
+			accept string
+			status int
+			code   string
+		}{
+			{http.MethodGet, narInfoPath, "application/json", http.StatusNotFound, "narinfo_not_found"},
+			{http.MethodGet, narPath, "application/problem+json", http.StatusNotFound, "nar_not_in_storage"},
+			{http.MethodGet, "/does-not-exist", "text/plain, application/json;q=0.5", http.StatusNotFound, "not_found"},
+			{http.MethodPut, narInfoPath, "application/json", http.StatusMethodNotAllowed, "method_not_allowed"},
+			{
+				http.MethodGet, "/nar/" + testdata.Nar1.NarHash + ".recipe", "application/json",
+				http.StatusNotFound, "nar_not_chunked",
+			},
+			{http.MethodGet, "/chunk/" + strings.Repeat("0", 64), "application/json", http.StatusNotFound, "not_found"},
+		}
+
+		for _, tt := range tests {
+			resp := do(t, s, tt.method, tt.path, tt.accept)
+			require.Equal(t, tt.status, resp.StatusCode, tt.path)
+
+			body := decode(t, resp)
+			assert.Equal(t, tt.code, body["code"], tt.path)
+			assert.InDelta(t, tt.status, body["status"], 0, tt.path)
+			assert.Equal(t, http.StatusText(tt.status), body["title"], tt.path)
+			assert.Equal(t, tt.path, body["instance"], tt.path)
+		}
+	})
+
+	t.Run("q=0 does not ask for JSON", func(t *testing.T) {
+		t.Parallel()
+
+		resp := do(t, s, http.MethodGet, narInfoPath, "application/json;q=0")
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	})
+
+	t.Run("upstream_unreachable when every upstream is down", func(t *testing.T) {
+		t.Parallel()
+
+		hts := testdata.NewTestServer(t, 40)
+		hts.Close()
+
+		uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, hts.URL), nil)
+		require.NoError(t, err)
+
+		c := newProblemTestCache(t)
+		c.AddUpstreamCaches(newContext(), uc)
+
+		<-c.GetHealthChecker().Trigger()
+
+		require.Equal(t, 0, c.GetHealthyUpstreamCount())
+
+		s := server.New(c)
+
+		for _, path := range []string{narInfoPath, narPath} {
+			resp := do(t, s, http.MethodGet, path, "application/json")
+			require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, path)
+			assert.Equal(t, "60", resp.Header.Get("Retry-After"), path)
+			assert.Equal(t, "upstream_unreachable", decode(t, resp)["code"], path)
+		}
+
+		// The uploads only look for the objects stored, regardless of the
+		// upstreams.
+		resp := do(t, s, http.MethodGet, "/upload"+narInfoPath, "application/json")
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, "narinfo_not_found", decode(t, resp)["code"])
+	})
+
+	t.Run("not found in offline mode with every upstream down", func(t *testing.T) {
+		t.Parallel()
+
+		hts := testdata.NewTestServer(t, 40)
+		hts.Close()
+
+		uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, hts.URL), nil)
+		require.NoError(t, err)
+
+		c := newProblemTestCache(t)
+		c.AddUpstreamCaches(newContext(), uc)
+
+		<-c.GetHealthChecker().Trigger()
+
+		require.Equal(t, 0, c.GetHealthyUpstreamCount())
+
+		c.SetOffline(newContext(), true)
+
+		s := server.New(c)
+
+		for path, code := range map[string]string{narInfoPath: "narinfo_not_found", narPath: "nar_not_in_storage"} {
+			resp := do(t, s, http.MethodGet, path, "application/json")
+			require.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+			assert.Empty(t, resp.Header.Get("Retry-After"), path)
+			assert.Equal(t, code, decode(t, resp)["code"], path)
+		}
+	})
+}
+
+func TestNarInfoBudget(t *testing.T) {
+	t.Parallel()
+
+	hts := testdata.NewTestServer(t, 40)
+	t.Cleanup(hts.Close)
+
+	uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, hts.URL), nil)
+	require.NoError(t, err)
+
+	c := newProblemTestCache(t)
+	c.AddUpstreamCaches(newContext(), uc)
+
+	<-c.GetHealthChecker().Trigger()
+
+	// The upstream hangs on every narinfo once healthy.
+	hts.AddMaybeHandler(func(_ http.ResponseWriter, r *http.Request) bool {
+		if !strings.HasSuffix(r.URL.Path, ".narinfo") {
+			return false
+		}
+
+		<-r.Context().Done()
+
+		return true
+	})
+
+	s := server.New(c)
+	s.SetNarInfoBudget(100 * time.Millisecond)
+
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/"+testdata.Nar1.NarInfoHash+".narinfo", nil)
+	req.Header.Set("Accept", "application/json")
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+
+	var body map[string]any
+
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "request_budget_exhausted", body["code"])
+}
@@ -14,10 +14,10 @@ import (
 	"github.com/kalbasit/ncps/pkg/storage"
 )
 
-// TestNarInfoErrorStatus verifies that the narinfo GET handler maps a leaked
+// TestCacheErrorStatus verifies that the GET handlers map a leaked
 // errNarInfoPurged sentinel to HTTP 404 (never HTTP 500), as defense in depth,
-// alongside the existing storage.ErrNotFound and context-cancellation handling.
-func TestNarInfoErrorStatus(t *testing.T) {
+// alongside the error taxonomy of the cache and context-cancellation handling.
+func TestCacheErrorStatus(t *testing.T) {
 	t.Parallel()
 
 	tests := []struct {
@@ -36,6 +36,20 @@ func TestNarInfoErrorStatus(t *testing.T) {
 		},
 		{"context canceled writes nothing", context.Canceled, 0, false},
 		{"deadline exceeded writes nothing", context.DeadlineExceeded, 0, false},
+		{
+			"exhausted budget maps to 504",
+			fmt.Errorf("%w: %w", cache.ErrRequestBudgetExhausted, storage.ErrNotFound),
+			http.StatusGatewayTimeout,
+			true,
+		},
+		{
+			"unavailable upstreams map to 503",
+			fmt.Errorf("%w: %w", cache.ErrUpstreamUnavailable, cache.ErrNotFound),
+			http.StatusServiceUnavailable,
+			true,
+		},
+		{"full storage maps to 507", cache.ErrStorageFull, http.StatusInsufficientStorage, true},
+		{"integrity error maps to 502", cache.ErrNarSizeMismatch, http.StatusBadGateway, true},
 		{"unknown error maps to 500", io.ErrUnexpectedEOF, http.StatusInternalServerError, true},
 	}
 
@@ -45,7 +59,7 @@ func TestNarInfoErrorStatus(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			status, respond := server.NarInfoErrorStatus(tt.err)
+			status, respond := server.CacheErrorStatus(tt.err)
 			assert.Equal(t, tt.wantStatus, status)
 			assert.Equal(t, tt.wantRespond, respond)
 		})
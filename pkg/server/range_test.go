@@ -0,0 +1,124 @@
+package server_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/database"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/server"
+	"github.com/kalbasit/ncps/pkg/storage/local"
+	"github.com/kalbasit/ncps/pkg/zstd"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestGetNar_Range(t *testing.T) {
+	t.Parallel()
+
+	dir, err := os.MkdirTemp("", "cache-path-range-")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	dbFile := filepath.Join(dir, "db.sqlite")
+	testhelper.CreateMigrateDatabase(t, dbFile)
+
+	dbClient, err := database.Open("sqlite:"+dbFile, nil)
+	require.NoError(t, err)
+
+	localStore, err := local.New(newContext(), dir)
+	require.NoError(t, err)
+
+	c, err := newTestCache(newContext(), dbClient, localStore, localStore, localStore)
+	require.NoError(t, err)
+
+	s := server.New(c)
+
+	narData := strings.Repeat("0123456789", 1000)
+
+	putZstd := func(t *testing.T, hash string, seekable bool) {
+		t.Helper()
+
+		var buf bytes.Buffer
+
+		if seekable {
+			sw := zstd.NewSeekableWriter(&buf, 1024)
+			_, err := sw.Write([]byte(narData))
+			require.NoError(t, err)
+			require.NoError(t, sw.Close())
+		} else {
+			enc := zstd.GetWriter()
+			buf.Write(enc.EncodeAll([]byte(narData), nil))
+			zstd.PutWriter(enc)
+		}
+
+		narURL := nar.URL{Hash: hash, Compression: nar.CompressionTypeZstd}
+		_, err := localStore.PutNar(newContext(), narURL, &buf, int64(buf.Len()))
+		require.NoError(t, err)
+	}
+
+	get := func(t *testing.T, path, rangeHeader string) (*http.Response, string) {
+		t.Helper()
+
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, path, nil)
+		req.Header.Set("Range", rangeHeader)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+
+		resp := w.Result()
+		t.Cleanup(func() { resp.Body.Close() })
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp, string(body)
+	}
+
+	t.Run("seekable .nar.zst serves the decompressed range", func(t *testing.T) {
+		t.Parallel()
+
+		hash := "0000000000000000000000000000000000000000000000000001"
+		putZstd(t, hash, true)
+
+		resp, body := get(t, "/nar/"+hash+".nar", "bytes=5000-5009")
+
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, "bytes 5000-5009/10000", resp.Header.Get("Content-Range"))
+		assert.Equal(t, "application/x-nix-nar", resp.Header.Get("Content-Type"))
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, narData[5000:5010], body)
+	})
+
+	t.Run("unsatisfiable range", func(t *testing.T) {
+		t.Parallel()
+
+		hash := "0000000000000000000000000000000000000000000000000002"
+		putZstd(t, hash, true)
+
+		resp, _ := get(t, "/nar/"+hash+".nar", "bytes=20000-")
+
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+	})
+
+	t.Run("compressed nar serves the range of its stored bytes", func(t *testing.T) {
+		t.Parallel()
+
+		hash := "0000000000000000000000000000000000000000000000000003"
+		putZstd(t, hash, false)
+
+		resp, body := get(t, "/nar/"+hash+".nar.zst", "bytes=0-3")
+
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, []byte{0x28, 0xb5, 0x2f, 0xfd}, []byte(body), "zstd magic number")
+	})
+}
@@ -1,14 +1,19 @@
 package server
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"net/netip"
+	"path"
 	"runtime/debug"
 	"strconv"
 	"strings"
@@ -23,12 +28,15 @@ import (
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/semaphore"
 
 	promclient "github.com/prometheus/client_golang/prometheus"
 	otelchimetric "github.com/riandyrn/otelchi/metric"
 
+	"github.com/kalbasit/ncps/pkg/accesslog"
 	"github.com/kalbasit/ncps/pkg/analytics"
 	"github.com/kalbasit/ncps/pkg/cache"
+	"github.com/kalbasit/ncps/pkg/cache/healthcheck"
 	"github.com/kalbasit/ncps/pkg/cache/upstream"
 	"github.com/kalbasit/ncps/pkg/nar"
 	"github.com/kalbasit/ncps/pkg/narinfo"
@@ -45,18 +53,34 @@ const (
 	routeCachePublicKey = "/pubkey"
 	routePinClosure     = "/pin/{hash:" + narinfo.HashPattern + "}.narinfo"
 	routePins           = "/pins"
+	routeNarInfoIndex   = "/narinfo-index.zst"
+	routeNarInfoBloom   = "/narinfo-bloom.zst"
 	routeBuildTrace     = "/build-trace-v2/{drvName}/{outputName}"
+	routeAdminAPI       = "/api/v1"
 
 	contentLength      = "Content-Length"
 	contentType        = "Content-Type"
 	contentTypeNar     = "application/x-nix-nar"
 	contentTypeNarInfo = "text/x-nix-narinfo"
 	contentTypeJSON    = "application/json"
+	contentTypeZstd    = "application/zstd"
 	encodingZstd       = "zstd"
 
-	nixCacheInfo = `StoreDir: /nix/store
+	headerNcpsCache    = "X-Ncps-Cache"
+	headerNcpsUpstream = "X-Ncps-Upstream"
+	headerNcpsStore    = "X-Ncps-Store"
+
+	headerNcpsIndexCount = "X-Ncps-Index-Count"
+
+	// nixCacheInfo is completed with the store dir of the served narinfos and
+	// the priority of the cache.
+	nixCacheInfo = `StoreDir: %s
 WantMassQuery: 1
-Priority: 10`
+Priority: %d`
+
+	// DefaultPriority is the priority advertised by nix-cache-info while none
+	// is set. See SetPriority.
+	DefaultPriority = 10
 
 	otelPackageName = "github.com/kalbasit/ncps/pkg/server"
 )
@@ -80,6 +104,38 @@ type Server struct {
 	deletePermitted bool
 	getToken        string
 	putPermitted    bool
+
+	narRedirectExpiry   time.Duration
+	narRedirectNetworks []netip.Prefix
+
+	cacheStatusHeaders bool
+
+	// priority is advertised by nix-cache-info. See SetPriority.
+	priority int
+
+	// narInfoBudget is the time budget of the narinfo requests. See
+	// SetNarInfoBudget.
+	narInfoBudget time.Duration
+
+	adminToken string
+
+	// limiters caps the concurrent requests per endpoint class. See
+	// SetRequestLimits.
+	limiters        map[string]*semaphore.Weighted
+	limitRetryAfter time.Duration
+
+	// cacheControl configures the Cache-Control headers. See SetCacheControl.
+	cacheControl CacheControl
+
+	// abortTracker flags the clients aborting many NAR transfers. See
+	// SetClientAbortTracking.
+	abortTracker *abortTracker
+
+	// accessLog exports the narinfo and NAR requests. See SetAccessLog.
+	accessLog *accesslog.Exporter
+
+	// storeAPI serves the store API. See SetStoreAPI.
+	storeAPI bool
 }
 
 // SetPrometheusGatherer configures the server with a Prometheus gatherer for /metrics endpoint.
@@ -87,7 +143,7 @@ func SetPrometheusGatherer(gatherer promclient.Gatherer) { prometheusGatherer =
 
 // New returns a new server.
 func New(cache *cache.Cache) *Server {
-	s := &Server{cache: cache}
+	s := &Server{cache: cache, priority: DefaultPriority}
 
 	s.createRouter()
 
@@ -99,21 +155,53 @@ func (s *Server) SetDeletePermitted(dp bool) { s.deletePermitted = dp }
 
 // SetGetToken configures a Bearer token required to access GET and HEAD routes.
 // When non-empty, requests without a matching Authorization: Bearer <token> header
-// are rejected with 401 Unauthorized. The /healthz and /metrics routes are always
-// exempt.
+// are rejected with 401 Unauthorized. The /healthz, /readyz and /metrics routes
+// are always exempt.
 func (s *Server) SetGetToken(token string) { s.getToken = token }
 
+// SetAdminToken configures the Bearer token required to access the admin API
+// under /api/v1. The admin API is disabled while the token is empty.
+func (s *Server) SetAdminToken(token string) { s.adminToken = token }
+
 // SetPutPermitted configures the server to either allow or deny access to PUT.
 func (s *Server) SetPutPermitted(pp bool) { s.putPermitted = pp }
 
+// SetNarRedirect configures the server to answer NAR GET requests from clients
+// within one of the given networks with a 302 redirect to a presigned storage URL
+// valid for expiry, instead of proxying the bytes. It only takes effect when the
+// nar store supports presigning (S3); an empty networks list disables it.
+func (s *Server) SetNarRedirect(expiry time.Duration, networks []netip.Prefix) {
+	s.narRedirectExpiry = expiry
+	s.narRedirectNetworks = networks
+}
+
+// SetCacheStatusHeaders configures the server to tell clients how each narinfo
+// and NAR was served via the X-Ncps-Cache (HIT, MISS or PASS), X-Ncps-Upstream
+// and X-Ncps-Store (file, chunks or staging) response headers.
+func (s *Server) SetCacheStatusHeaders(enabled bool) { s.cacheStatusHeaders = enabled }
+
+// SetNarInfoBudget gives every narinfo request a time budget: the upstream
+// probes and fetches made for it share what is left of the budget instead of
+// waiting on their own timeouts, and the request is answered with a 504
+// Gateway Timeout once the budget is spent. Zero disables the budget.
+func (s *Server) SetNarInfoBudget(budget time.Duration) { s.narInfoBudget = budget }
+
+// SetPriority sets the priority advertised by nix-cache-info, which Nix uses to
+// order its substituters, the lower first.
+func (s *Server) SetPriority(priority int) { s.priority = priority }
+
 // ServeHTTP implements http.Handler and turns the Server type into a handler.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) { s.router.ServeHTTP(w, r) }
 
 func (s *Server) createRouter() {
 	s.router = chi.NewRouter()
+	s.router.NotFound(notFound)
+	s.router.MethodNotAllowed(methodNotAllowed)
 
 	s.router.Use(middleware.Heartbeat("/healthz"))
+	s.router.Use(s.readiness)
 	s.router.Use(middleware.ClientIPFromXFF())
+	s.router.Use(withUpstreamClientIP)
 	s.router.Use(recoverer)
 
 	s.router.Use(s.skipTelemetryForInfraRoutes)
@@ -132,6 +220,16 @@ func (s *Server) createRouter() {
 	s.router.Delete(routePinClosure, s.unpinClosure)
 	s.router.Get(routePins, s.listPins)
 
+	// Narinfo index
+	s.router.Head(routeNarInfoIndex, s.getNarInfoIndex)
+	s.router.Get(routeNarInfoIndex, s.getNarInfoIndex)
+	s.router.Head(routeNarInfoBloom, s.getNarInfoBloom)
+	s.router.Get(routeNarInfoBloom, s.getNarInfoBloom)
+
+	// Chunk recipes
+	s.router.Get(routeNarRecipe, s.limit(endpointNar, s.getNarRecipe))
+	s.router.Get(routeChunk, s.limit(endpointNar, s.getChunk))
+
 	// 2. Register "upload only" routes under /upload
 	s.router.Route("/upload", func(r chi.Router) {
 		// Middleware to inject the UploadOnly flag
@@ -146,18 +244,49 @@ func (s *Server) createRouter() {
 		s.registerRoutes(r)
 
 		// register PUT routes
-		r.Put(routeNarInfo, s.putNarInfo)
-		r.Put(routeNarCompression, s.putNar)
-		r.Put(routeNar, s.putNar)
-		r.Put(routeBuildTrace, s.putBuildTrace)
+		r.Put(routeNarInfo, s.limit(endpointUpload, s.putNarInfo))
+		r.Put(routeNarCompression, s.limit(endpointUpload, s.putNar))
+		r.Put(routeNar, s.limit(endpointUpload, s.putNar))
+		r.Put(routeBuildTrace, s.limit(endpointUpload, s.putBuildTrace))
 	})
 
+	// Admin API
+	s.router.Route(routeAdminAPI, s.registerAdminRoutes)
+
+	// Store API
+	s.router.Route(routeStoreAPI, s.registerStoreAPIRoutes)
+
 	// Add Prometheus metrics endpoint if gatherer is configured
 	if prometheusGatherer != nil {
 		s.router.Get("/metrics", promhttp.HandlerFor(prometheusGatherer, promhttp.HandlerOpts{}).ServeHTTP)
 	}
 }
 
+// readiness answers the readiness probes on /readyz, like the liveness probes
+// of /healthz, with a 503 while the cache warms up. See
+// cache.SetNarInfoWarmUp.
+func (s *Server) readiness(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.URL.Path != "/readyz" {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		w.Header().Set(contentType, "text/plain")
+
+		if !s.cache.IsReady() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("warming up"))
+
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("."))
+	})
+}
+
 // Create a middleware skipper that excludes /metrics and /healthz from telemetry.
 func (s *Server) skipTelemetryForInfraRoutes(next http.Handler) http.Handler {
 	mp := otel.GetMeterProvider()
@@ -191,14 +320,20 @@ func (s *Server) registerRoutes(r chi.Router) {
 	r.Get(routeCacheInfo, s.getNixCacheInfo)
 	r.Get(routeCachePublicKey, s.getNixCachePublicKey)
 
-	r.Head(routeNarInfo, s.getNarInfo(false))
-	r.Get(routeNarInfo, s.getNarInfo(true))
+	r.Head(routeNarInfo, s.withAccessLog(accesslog.KindNarInfo,
+		s.withCacheControl(endpointNarInfo, s.limit(endpointNarInfo, s.getNarInfo(false)))))
+	r.Get(routeNarInfo, s.withAccessLog(accesslog.KindNarInfo,
+		s.withCacheControl(endpointNarInfo, s.limit(endpointNarInfo, s.getNarInfo(true)))))
 
-	r.Head(routeNarCompression, s.getNar(false))
-	r.Get(routeNarCompression, s.getNar(true))
+	r.Head(routeNarCompression, s.withAccessLog(accesslog.KindNar,
+		s.withCacheControl(endpointNar, s.limit(endpointNar, s.getNar(false)))))
+	r.Get(routeNarCompression, s.withAccessLog(accesslog.KindNar,
+		s.withCacheControl(endpointNar, s.limit(endpointNar, s.getNar(true)))))
 
-	r.Head(routeNar, s.getNar(false))
-	r.Get(routeNar, s.getNar(true))
+	r.Head(routeNar, s.withAccessLog(accesslog.KindNar,
+		s.withCacheControl(endpointNar, s.limit(endpointNar, s.getNar(false)))))
+	r.Get(routeNar, s.withAccessLog(accesslog.KindNar,
+		s.withCacheControl(endpointNar, s.limit(endpointNar, s.getNar(true)))))
 
 	r.Head(routeBuildTrace, s.getBuildTrace(false))
 	r.Get(routeBuildTrace, s.getBuildTrace(true))
@@ -206,7 +341,8 @@ func (s *Server) registerRoutes(r chi.Router) {
 
 // requireGetToken is a middleware that enforces Bearer token authentication for
 // GET and HEAD requests when s.getToken is non-empty. Infrastructure endpoints
-// (/healthz and /metrics) are always exempt regardless of configuration.
+// (/healthz and /metrics) are always exempt regardless of configuration, and
+// the admin API is guarded by requireAdminToken instead.
 func (s *Server) requireGetToken(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if s.getToken == "" {
@@ -222,32 +358,17 @@ func (s *Server) requireGetToken(next http.Handler) http.Handler {
 			return
 		}
 
-		// Infrastructure routes are always exempt.
-		if r.URL.Path == "/healthz" || r.URL.Path == "/metrics" {
+		// Infrastructure routes are always exempt, and the admin API has its own
+		// token.
+		if r.URL.Path == "/healthz" || r.URL.Path == "/metrics" ||
+			strings.HasPrefix(r.URL.Path, routeAdminAPI+"/") {
 			next.ServeHTTP(w, r)
 
 			return
 		}
 
-		authHeader := r.Header.Get("Authorization")
-
-		const bearerPrefix = "Bearer "
-
-		// Hash both tokens to a fixed length before the constant-time compare.
-		// subtle.ConstantTimeCompare returns early when the slice lengths differ,
-		// so comparing the raw variable-length tokens directly would leak the
-		// secret's length via a timing side-channel. SHA-256 digests are always
-		// 32 bytes, so the comparison time is independent of both token contents
-		// and length.
-		presented := strings.TrimPrefix(authHeader, bearerPrefix)
-		presentedHash := sha256.Sum256([]byte(presented))
-		expectedHash := sha256.Sum256([]byte(s.getToken))
-
-		if !strings.HasPrefix(authHeader, bearerPrefix) ||
-			subtle.ConstantTimeCompare(presentedHash[:], expectedHash[:]) != 1 {
-			// RFC 7235 §4.1: a 401 response must carry a challenge.
-			w.Header().Set("WWW-Authenticate", "Bearer")
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		if !hasBearerToken(r, s.getToken) {
+			unauthorized(w, r)
 
 			return
 		}
@@ -256,6 +377,14 @@ func (s *Server) requireGetToken(next http.Handler) http.Handler {
 	})
 }
 
+// withUpstreamClientIP records the address of the client in the request
+// context so the upstreams forwarding it send it as X-Forwarded-For.
+func withUpstreamClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(upstream.WithClientIP(r.Context(), clientAddr(r))))
+	})
+}
+
 func recoverer(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -350,7 +479,7 @@ func (s *Server) getIndex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewEncoder(w).Encode(body); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 		zerolog.Ctx(r.Context()).
 			Error().
@@ -367,8 +496,8 @@ func (s *Server) getNixCacheInfo(w http.ResponseWriter, r *http.Request) {
 	)
 	defer span.End()
 
-	if _, err := w.Write([]byte(nixCacheInfo)); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if _, err := fmt.Fprintf(w, nixCacheInfo, s.cache.StoreDir(), s.priority); err != nil {
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 		zerolog.Ctx(r.Context()).
 			Error().
@@ -377,6 +506,54 @@ func (s *Server) getNixCacheInfo(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// getNarInfoIndex serves the narinfo index, answering the conditional requests
+// with its ETag and Last-Modified.
+func (s *Server) getNarInfoIndex(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(
+		r.Context(),
+		"server.getNarInfoIndex",
+		trace.WithSpanKind(trace.SpanKindServer),
+	)
+	defer span.End()
+
+	idx := s.cache.NarInfoIndex()
+	if idx == nil {
+		writeError(w, r, http.StatusNotFound, errorCodeNotFound, "the narinfo index is not built")
+
+		return
+	}
+
+	w.Header().Set(contentType, contentTypeZstd)
+	w.Header().Set("ETag", idx.ETag)
+	w.Header().Set(headerNcpsIndexCount, strconv.Itoa(idx.Count))
+
+	http.ServeContent(w, r, "", idx.LastModified, bytes.NewReader(idx.Data))
+}
+
+// getNarInfoBloom serves the bloom filter of the narinfo index, for the peers
+// to skip asking for the narinfos it rules out.
+func (s *Server) getNarInfoBloom(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(
+		r.Context(),
+		"server.getNarInfoBloom",
+		trace.WithSpanKind(trace.SpanKindServer),
+	)
+	defer span.End()
+
+	idx := s.cache.NarInfoIndex()
+	if idx == nil {
+		writeError(w, r, http.StatusNotFound, errorCodeNotFound, "the narinfo index is not built")
+
+		return
+	}
+
+	w.Header().Set(contentType, contentTypeZstd)
+	w.Header().Set("ETag", idx.BloomETag)
+	w.Header().Set(headerNcpsIndexCount, strconv.Itoa(idx.Count))
+
+	http.ServeContent(w, r, "", idx.LastModified, bytes.NewReader(idx.Bloom))
+}
+
 func (s *Server) getNixCachePublicKey(w http.ResponseWriter, r *http.Request) {
 	_, span := tracer.Start(
 
@@ -387,7 +564,7 @@ func (s *Server) getNixCachePublicKey(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 
 	if _, err := w.Write([]byte(s.cache.PublicKey().String())); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 		zerolog.Ctx(r.Context()).
 			Error().
@@ -396,15 +573,24 @@ func (s *Server) getNixCachePublicKey(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// narInfoErrorStatus maps a GetNarInfo error to the HTTP status the narinfo GET
-// handler should return. respond is false when the handler should write nothing
-// (the client is gone). cache.ErrNarInfoPurged is treated as 404 — defense in
-// depth so the internal purge sentinel can never surface to a client as an
-// HTTP 500.
-func narInfoErrorStatus(err error) (status int, respond bool) {
+// cacheErrorStatus maps an error of GetNarInfo or GetNar to the HTTP status of
+// the response, following the error taxonomy of the cache. respond is false
+// when the handler should write nothing (the client is gone). An exhausted
+// request budget is a 504, even when the fetch it cut short reported the object
+// missing. cache.ErrNarInfoPurged is treated as 404 — defense in depth so the
+// internal purge sentinel can never surface to a client as an HTTP 500.
+func cacheErrorStatus(err error) (status int, respond bool) {
 	switch {
-	case errors.Is(err, storage.ErrNotFound), errors.Is(err, cache.ErrNarInfoPurged):
+	case errors.Is(err, cache.ErrRequestBudgetExhausted):
+		return http.StatusGatewayTimeout, true
+	case errors.Is(err, cache.ErrUpstreamUnavailable):
+		return http.StatusServiceUnavailable, true
+	case errors.Is(err, cache.ErrNotFound), errors.Is(err, cache.ErrNarInfoPurged):
 		return http.StatusNotFound, true
+	case errors.Is(err, cache.ErrStorageFull):
+		return http.StatusInsufficientStorage, true
+	case errors.Is(err, cache.ErrIntegrity):
+		return http.StatusBadGateway, true
 	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
 		return 0, false
 	default:
@@ -412,6 +598,100 @@ func narInfoErrorStatus(err error) (status int, respond bool) {
 	}
 }
 
+// writeCacheError answers a request whose object could not be served with
+// status, as mapped by cacheErrorStatus from an error of the cache other than
+// an internal error or an exhausted budget. notFoundCode is the error code of
+// a 404. Only the generic status text is written, never leaking an internal
+// error message to the client.
+func writeCacheError(w http.ResponseWriter, r *http.Request, status int, notFoundCode string) {
+	switch status {
+	case http.StatusServiceUnavailable:
+		// The object may exist upstream: the client retries once the next
+		// health check may have found an upstream back.
+		retryAfter := int(math.Ceil(healthcheck.Interval.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+		writeError(w, r, status, errorCodeUpstreamUnreachable, "no upstream cache is reachable")
+	case http.StatusInsufficientStorage:
+		writeError(w, r, status, errorCodeStorageFull, http.StatusText(status))
+	case http.StatusBadGateway:
+		writeError(w, r, status, errorCodeIntegrity, "the nar does not match its hash or size")
+	default:
+		writeError(w, r, status, notFoundCode, http.StatusText(status))
+	}
+}
+
+// writeGetNarInfoError answers a narinfo request failed with err, an error of
+// GetNarInfo. serveInfo tells a purged narinfo apart.
+func (s *Server) writeGetNarInfoError(w http.ResponseWriter, r *http.Request, err error, serveInfo *cache.ServeInfo) {
+	status, respond := cacheErrorStatus(err)
+	if !respond {
+		return
+	}
+
+	if status == http.StatusGatewayTimeout {
+		zerolog.Ctx(r.Context()).
+			Warn().
+			Err(err).
+			Dur("budget", s.narInfoBudget).
+			Msg("the narinfo request budget is exhausted")
+
+		writeError(w, r, status, errorCodeRequestBudgetExhausted,
+			"the narinfo could not be fetched within the request budget of "+s.narInfoBudget.String())
+
+		return
+	}
+
+	if status == http.StatusInternalServerError {
+		zerolog.Ctx(r.Context()).
+			Error().
+			Err(err).
+			Msg("error fetching the narinfo")
+
+		writeError(w, r, status, errorCodeInternal, err.Error())
+
+		return
+	}
+
+	notFoundCode := errorCodeNarInfoNotFound
+	if serveInfo.NarInfoPurged() {
+		notFoundCode = errorCodeNarInfoPurged
+	}
+
+	writeCacheError(w, r, status, notFoundCode)
+}
+
+// writeGetNarError answers a NAR request failed with err, an error of GetNar.
+func writeGetNarError(w http.ResponseWriter, r *http.Request, err error) {
+	status, respond := cacheErrorStatus(err)
+	if !respond {
+		return
+	}
+
+	if status != http.StatusInternalServerError {
+		writeCacheError(w, r, status, errorCodeNarNotInStorage)
+
+		return
+	}
+
+	var interrupted *cache.DownloadInterruptedError
+	if errors.As(err, &interrupted) {
+		retryAfter := int(math.Ceil(interrupted.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		writeError(w, r, http.StatusServiceUnavailable, errorCodeDownloadInterrupted,
+			"the download of the nar was interrupted by a restart, retry later")
+
+		return
+	}
+
+	zerolog.Ctx(r.Context()).
+		Error().
+		Err(err).
+		Msg("error fetching the nar")
+
+	writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
+}
+
 func (s *Server) getNarInfo(withBody bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		hash := chi.URLParam(r, "hash")
@@ -434,31 +714,20 @@ func (s *Server) getNarInfo(withBody bool) http.HandlerFunc {
 				WithContext(ctx),
 		)
 
-		narInfo, err := s.cache.GetNarInfo(r.Context(), hash)
-		if err != nil {
-			status, respond := narInfoErrorStatus(err)
-			if !respond {
-				return
-			}
+		r, serveInfo := s.withServeInfo(r)
 
-			if status == http.StatusInternalServerError {
-				zerolog.Ctx(r.Context()).
-					Error().
-					Err(err).
-					Msg("error fetching the narinfo")
-
-				http.Error(w, err.Error(), status)
-
-				return
-			}
+		budgetCtx, cancel := cache.WithRequestBudget(r.Context(), s.narInfoBudget)
+		defer cancel()
 
-			// For non-500 outcomes (404, including a purged narinfo) write only the
-			// generic status text — never leak an internal error message to the client.
-			http.Error(w, http.StatusText(status), status)
+		narInfo, err := s.cache.GetNarInfo(budgetCtx, hash)
+		if err != nil {
+			s.writeGetNarInfoError(w, r, err, serveInfo)
 
 			return
 		}
 
+		setAccessStorePath(r, narInfo.StorePath)
+
 		// Create a copy of narInfo to avoid race conditions when modifying
 		narInfoCopy := *narInfo
 
@@ -471,7 +740,7 @@ func (s *Server) getNarInfo(withBody bool) http.HandlerFunc {
 					Err(err).
 					Msg("error parsing the NAR URL")
 
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 				return
 			}
@@ -483,7 +752,7 @@ func (s *Server) getNarInfo(withBody bool) http.HandlerFunc {
 					Err(err).
 					Msg("error normalizing the NAR URL")
 
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 				return
 			}
@@ -491,11 +760,24 @@ func (s *Server) getNarInfo(withBody bool) http.HandlerFunc {
 			narInfoCopy.URL = normalizedURL.String()
 		}
 
-		narInfoBytes := []byte(narInfoCopy.String())
+		served, err := s.cache.ServedNarInfo(r.Context(), hash, &narInfoCopy)
+		if err != nil {
+			zerolog.Ctx(r.Context()).
+				Error().
+				Err(err).
+				Msg("error rewriting the narinfo")
+
+			writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
+
+			return
+		}
+
+		narInfoBytes := []byte(s.cache.FormatNarInfo(served))
 
 		h := w.Header()
 		h.Set(contentType, contentTypeNarInfo)
 		h.Set(contentLength, strconv.Itoa(len(narInfoBytes)))
+		s.setCacheStatusHeaders(h, serveInfo)
 
 		if !withBody {
 			w.WriteHeader(http.StatusOK)
@@ -504,7 +786,7 @@ func (s *Server) getNarInfo(withBody bool) http.HandlerFunc {
 		}
 
 		if _, err := w.Write(narInfoBytes); err != nil { //nolint:gosec // G705: not user input
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 			zerolog.Ctx(r.Context()).
 				Error().
@@ -536,13 +818,21 @@ func (s *Server) putNarInfo(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if !s.putPermitted {
-		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed,
+			http.StatusText(http.StatusMethodNotAllowed))
 
 		return
 	}
 
 	if err := s.cache.PutNarInfo(r.Context(), hash, r.Body); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, cache.ErrStorageFull) {
+			writeError(w, r, http.StatusInsufficientStorage, errorCodeStorageFull,
+				http.StatusText(http.StatusInsufficientStorage))
+
+			return
+		}
+
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 		zerolog.Ctx(r.Context()).
 			Error().
@@ -583,7 +873,7 @@ func (s *Server) getBuildTrace(withBody bool) http.HandlerFunc {
 		data, err := s.cache.GetBuildTrace(r.Context(), drvName, outputName)
 		if err != nil {
 			if errors.Is(err, storage.ErrNotFound) {
-				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				writeError(w, r, http.StatusNotFound, errorCodeNotFound, http.StatusText(http.StatusNotFound))
 
 				return
 			}
@@ -597,7 +887,7 @@ func (s *Server) getBuildTrace(withBody bool) http.HandlerFunc {
 				Err(err).
 				Msg("error fetching build trace")
 
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 			return
 		}
@@ -646,14 +936,15 @@ func (s *Server) putBuildTrace(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if !s.putPermitted {
-		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed,
+			http.StatusText(http.StatusMethodNotAllowed))
 
 		return
 	}
 
 	if err := s.cache.PutBuildTrace(r.Context(), drvName, outputName, r.Body); err != nil {
 		if errors.Is(err, cache.ErrBadRequest) {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, errorCodeBadRequest, err.Error())
 
 			return
 		}
@@ -663,7 +954,7 @@ func (s *Server) putBuildTrace(w http.ResponseWriter, r *http.Request) {
 			Err(err).
 			Msg("error storing build trace")
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 		return
 	}
@@ -693,14 +984,15 @@ func (s *Server) deleteNarInfo(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if !s.deletePermitted {
-		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed,
+			http.StatusText(http.StatusMethodNotAllowed))
 
 		return
 	}
 
 	if err := s.cache.DeleteNarInfo(r.Context(), hash); err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			writeError(w, r, http.StatusNotFound, errorCodeNotFound, http.StatusText(http.StatusNotFound))
 
 			return
 		}
@@ -710,7 +1002,7 @@ func (s *Server) deleteNarInfo(w http.ResponseWriter, r *http.Request) {
 			Err(err).
 			Msg("error deleting the narinfo")
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 		return
 	}
@@ -741,7 +1033,7 @@ func (s *Server) pinClosure(w http.ResponseWriter, r *http.Request) {
 
 	if err := s.cache.PinClosure(r.Context(), hash); err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			writeError(w, r, http.StatusNotFound, errorCodeNotFound, http.StatusText(http.StatusNotFound))
 
 			return
 		}
@@ -751,7 +1043,7 @@ func (s *Server) pinClosure(w http.ResponseWriter, r *http.Request) {
 			Err(err).
 			Msg("error pinning the closure")
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 		return
 	}
@@ -786,7 +1078,7 @@ func (s *Server) unpinClosure(w http.ResponseWriter, r *http.Request) {
 			Err(err).
 			Msg("error unpinning the closure")
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 		return
 	}
@@ -809,7 +1101,7 @@ func (s *Server) listPins(w http.ResponseWriter, r *http.Request) {
 			Err(err).
 			Msg("error listing pinned closures")
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 		return
 	}
@@ -838,10 +1130,11 @@ func (s *Server) withNarURL(
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		hash := chi.URLParam(r, "hash")
+		ext := chi.URLParam(r, "compression")
 
-		comp, err := nar.CompressionTypeFromExtension(chi.URLParam(r, "compression"))
+		comp, err := nar.CompressionTypeFromExtension(ext)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, errorCodeBadRequest, err.Error())
 
 			return
 		}
@@ -852,6 +1145,28 @@ func (s *Server) withNarURL(
 			Query:       r.URL.Query(),
 		}
 
+		// An alias extension (e.g. .nar.zstd) names the NAR stored under the
+		// canonical one: reads are redirected to it, writes report it. Only the
+		// last path segment is replaced so the prefix the server is mounted
+		// under is kept.
+		if nar.IsExtensionAlias(ext) {
+			canonicalNar := nar.URL{Hash: nu.Hash, Compression: nu.Compression}
+
+			canonicalURL := *r.URL
+			canonicalURL.Path = path.Join(path.Dir(r.URL.Path), path.Base(canonicalNar.String()))
+			canonicalURL.RawPath = ""
+
+			canonical := canonicalURL.String()
+
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				http.Redirect(w, r, canonical, http.StatusMovedPermanently)
+
+				return
+			}
+
+			w.Header().Set("Location", canonical)
+		}
+
 		ctx := nu.NewLogger(*zerolog.Ctx(r.Context())).
 			WithContext(r.Context())
 
@@ -894,6 +1209,30 @@ func (s *Server) getNar(withBody bool) http.HandlerFunc {
 			nu.TransparentZstd = true
 		}
 
+		r, serveInfo := s.withServeInfo(r)
+
+		if withBody && s.shouldRedirectNar(r) {
+			if u, err := s.cache.PresignNarURL(r.Context(), nu, s.narRedirectExpiry); err == nil {
+				if serveInfo != nil {
+					serveInfo.Set(cache.ServeStatusPass, "", cache.ServeStoreFile)
+				}
+
+				s.setCacheStatusHeaders(w.Header(), serveInfo)
+				http.Redirect(w, r, u.String(), http.StatusFound)
+
+				return
+			} else if !errors.Is(err, storage.ErrNotFound) {
+				zerolog.Ctx(r.Context()).
+					Warn().
+					Err(err).
+					Msg("error presigning the nar URL, proxying it instead")
+			}
+		}
+
+		if withBody && r.Header.Get("Range") != "" && s.serveNarRange(w, r, nu, serveInfo) {
+			return
+		}
+
 		// optimization: if this is a HEAD request, we can check if we have the
 		// narinfo for this nar and if so, return the size from there.
 		if !withBody {
@@ -913,9 +1252,14 @@ func (s *Server) getNar(withBody bool) http.HandlerFunc {
 			size, err := s.cache.GetNarFileSize(r.Context(), nu)
 			if err == nil && size > 0 {
 				if servable, sErr := s.cache.IsNarServable(r.Context(), nu); sErr == nil && servable {
+					if serveInfo != nil {
+						serveInfo.Set(cache.ServeStatusHit, "", "")
+					}
+
 					h := w.Header()
 					h.Set(contentType, contentTypeNar)
 					h.Set(contentLength, strconv.FormatInt(size, 10))
+					s.setCacheStatusHeaders(h, serveInfo)
 					w.WriteHeader(http.StatusOK)
 
 					return
@@ -925,22 +1269,7 @@ func (s *Server) getNar(withBody bool) http.HandlerFunc {
 
 		nu, size, reader, err := s.cache.GetNar(r.Context(), nu)
 		if err != nil {
-			if errors.Is(err, storage.ErrNotFound) || errors.Is(err, upstream.ErrNotFound) {
-				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
-
-				return
-			}
-
-			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-				return
-			}
-
-			zerolog.Ctx(r.Context()).
-				Error().
-				Err(err).
-				Msg("error fetching the nar")
-
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeGetNarError(w, r, err)
 
 			return
 		}
@@ -949,6 +1278,7 @@ func (s *Server) getNar(withBody bool) http.HandlerFunc {
 
 		h := w.Header()
 		h.Set(contentType, contentTypeNar)
+		s.setCacheStatusHeaders(h, serveInfo)
 
 		// Check for transparent compression support (priority: zstd > br > gzip > raw)
 		var (
@@ -967,12 +1297,16 @@ func (s *Server) getNar(withBody bool) http.HandlerFunc {
 			}
 		}
 
-		var out io.Writer = w
+		// sent counts the bytes sent to the client, to account for the aborted
+		// transfers.
+		sent := &countingWriter{w: w}
+
+		var out io.Writer = sent
 
 		if selectedEncoding != "" && !servedRawZstd {
 			switch selectedEncoding {
 			case encodingZstd:
-				pw := zstd.NewPooledWriter(w)
+				pw := zstd.NewPooledWriter(sent)
 				out = pw
 
 				defer func() {
@@ -981,7 +1315,7 @@ func (s *Server) getNar(withBody bool) http.HandlerFunc {
 					}
 				}()
 			case "br":
-				bw := brotli.NewWriter(w)
+				bw := brotli.NewWriter(sent)
 				out = bw
 
 				defer func() {
@@ -990,7 +1324,7 @@ func (s *Server) getNar(withBody bool) http.HandlerFunc {
 					}
 				}()
 			case "gzip":
-				gw := gzip.NewWriter(w)
+				gw := gzip.NewWriter(sent)
 				out = gw
 
 				defer func() {
@@ -1022,7 +1356,7 @@ func (s *Server) getNar(withBody bool) http.HandlerFunc {
 						Err(err).
 						Msg("error reading the nar to compute its size")
 
-					http.Error(w, err.Error(), http.StatusInternalServerError)
+					writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 					return
 				}
@@ -1039,6 +1373,12 @@ func (s *Server) getNar(withBody bool) http.HandlerFunc {
 
 		written, err := io.Copy(out, reader)
 		if err != nil {
+			if sent.err != nil || r.Context().Err() != nil {
+				s.recordNarAbort(r, nu, sent.n)
+
+				return
+			}
+
 			zerolog.Ctx(r.Context()).
 				Error().
 				Err(err).
@@ -1057,21 +1397,159 @@ func (s *Server) getNar(withBody bool) http.HandlerFunc {
 	})
 }
 
+// serveNarRange serves a Range request from a nar that can be read from any
+// offset, decompressing only the part of a seekable .nar.zst covering the
+// range. It returns false, having written nothing, when the nar cannot be
+// seeked so the caller serves it in full, which is a valid answer to a Range
+// request.
+func (s *Server) serveNarRange(w http.ResponseWriter, r *http.Request, nu nar.URL, serveInfo *cache.ServeInfo) bool {
+	// A range is of the identity encoding; never hand back a zstd stream.
+	nu.TransparentZstd = false
+
+	rs, err := s.cache.GetNarSeeker(r.Context(), nu)
+	if err != nil {
+		if !errors.Is(err, cache.ErrRangeNotSupported) {
+			zerolog.Ctx(r.Context()).
+				Warn().
+				Err(err).
+				Msg("error opening the nar for a range request, serving it in full")
+		}
+
+		return false
+	}
+	defer rs.Close()
+
+	if serveInfo != nil {
+		serveInfo.Set(cache.ServeStatusHit, "", cache.ServeStoreFile)
+	}
+
+	h := w.Header()
+	h.Set(contentType, contentTypeNar)
+	s.setCacheStatusHeaders(h, serveInfo)
+
+	http.ServeContent(w, r, "", time.Time{}, rs)
+
+	return true
+}
+
+// withServeInfo attaches a cache.ServeInfo to the request context so the
+// handler can learn how the cache served the request.
+func (s *Server) withServeInfo(r *http.Request) (*http.Request, *cache.ServeInfo) {
+	ctx, serveInfo := cache.WithServeInfo(r.Context())
+
+	if entry, ok := ctx.Value(accessEntryKey{}).(*accessEntry); ok {
+		entry.serveInfo = serveInfo
+	}
+
+	return r.WithContext(ctx), serveInfo
+}
+
+// setCacheStatusHeaders writes the cache status headers recorded in
+// serveInfo; it is a no-op when the headers are disabled.
+func (s *Server) setCacheStatusHeaders(h http.Header, serveInfo *cache.ServeInfo) {
+	if !s.cacheStatusHeaders || serveInfo == nil {
+		return
+	}
+
+	if status := serveInfo.Status(); status != "" {
+		h.Set(headerNcpsCache, string(status))
+	}
+
+	if upstreamHostname := serveInfo.Upstream(); upstreamHostname != "" {
+		h.Set(headerNcpsUpstream, upstreamHostname)
+	}
+
+	if store := serveInfo.Store(); store != "" {
+		h.Set(headerNcpsStore, string(store))
+	}
+}
+
+// hasBearerToken reports whether r carries an Authorization: Bearer header
+// matching token.
+func hasBearerToken(r *http.Request, token string) bool {
+	authHeader := r.Header.Get("Authorization")
+
+	const bearerPrefix = "Bearer "
+
+	// Hash both tokens to a fixed length before the constant-time compare.
+	// subtle.ConstantTimeCompare returns early when the slice lengths differ,
+	// so comparing the raw variable-length tokens directly would leak the
+	// secret's length via a timing side-channel. SHA-256 digests are always
+	// 32 bytes, so the comparison time is independent of both token contents
+	// and length.
+	presented := strings.TrimPrefix(authHeader, bearerPrefix)
+	presentedHash := sha256.Sum256([]byte(presented))
+	expectedHash := sha256.Sum256([]byte(token))
+
+	return strings.HasPrefix(authHeader, bearerPrefix) &&
+		subtle.ConstantTimeCompare(presentedHash[:], expectedHash[:]) == 1
+}
+
+// unauthorized rejects a request lacking a valid bearer token.
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	// RFC 7235 §4.1: a 401 response must carry a challenge.
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	writeError(w, r, http.StatusUnauthorized, errorCodeUnauthorized, http.StatusText(http.StatusUnauthorized))
+}
+
+// shouldRedirectNar reports whether the client of r is within one of the
+// networks configured via SetNarRedirect. Upload-only requests are never
+// redirected.
+func (s *Server) shouldRedirectNar(r *http.Request) bool {
+	if len(s.narRedirectNetworks) == 0 || cache.IsUploadOnly(r.Context()) {
+		return false
+	}
+
+	addr := middleware.GetClientIPAddr(r.Context())
+	if !addr.IsValid() {
+		ap, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err != nil {
+			return false
+		}
+
+		addr = ap.Addr()
+	}
+
+	addr = addr.Unmap()
+
+	for _, network := range s.narRedirectNetworks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (s *Server) putNar(w http.ResponseWriter, r *http.Request) {
 	s.withNarURL("server.putNar", func(w http.ResponseWriter, r *http.Request, nu nar.URL) {
 		if !s.putPermitted {
-			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			writeError(w, r, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed,
+				http.StatusText(http.StatusMethodNotAllowed))
 
 			return
 		}
 
 		if err := s.cache.PutNar(r.Context(), nu, r.Body); err != nil {
+			if errors.Is(err, cache.ErrIntegrity) {
+				writeError(w, r, http.StatusBadRequest, errorCodeIntegrity, err.Error())
+
+				return
+			}
+
+			if errors.Is(err, cache.ErrStorageFull) {
+				writeError(w, r, http.StatusInsufficientStorage, errorCodeStorageFull,
+					http.StatusText(http.StatusInsufficientStorage))
+
+				return
+			}
+
 			zerolog.Ctx(r.Context()).
 				Error().
 				Err(err).
 				Msg("error putting the NAR in cache")
 
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 			return
 		}
@@ -1083,14 +1561,15 @@ func (s *Server) putNar(w http.ResponseWriter, r *http.Request) {
 func (s *Server) deleteNar(w http.ResponseWriter, r *http.Request) {
 	s.withNarURL("server.deleteNar", func(w http.ResponseWriter, r *http.Request, nu nar.URL) {
 		if !s.deletePermitted {
-			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			writeError(w, r, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed,
+				http.StatusText(http.StatusMethodNotAllowed))
 
 			return
 		}
 
 		if err := s.cache.DeleteNar(r.Context(), nu); err != nil {
 			if errors.Is(err, storage.ErrNotFound) {
-				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				writeError(w, r, http.StatusNotFound, errorCodeNotFound, http.StatusText(http.StatusNotFound))
 
 				return
 			}
@@ -1100,7 +1579,7 @@ func (s *Server) deleteNar(w http.ResponseWriter, r *http.Request) {
 				Err(err).
 				Msg("error deleting the nar")
 
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
 
 			return
 		}
@@ -5,8 +5,10 @@ import (
 	"context"
 	"errors"
 	"io"
+	"maps"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -16,6 +18,7 @@ import (
 	"time"
 
 	"github.com/andybalholm/brotli"
+	"github.com/go-chi/chi/v5"
 	"github.com/nix-community/go-nix/pkg/narinfo"
 	"github.com/nix-community/go-nix/pkg/narinfo/signature"
 	"github.com/rs/zerolog"
@@ -797,6 +800,14 @@ func TestSetGetToken(t *testing.T) {
 			authHeader:     "",
 			wantStatus:     http.StatusOK,
 		},
+		{
+			name:           "token configured: /readyz is always exempt",
+			configureToken: true,
+			method:         http.MethodGet,
+			path:           "/readyz",
+			authHeader:     "",
+			wantStatus:     http.StatusOK,
+		},
 		{
 			name:           "token configured: PUT routes are unaffected",
 			configureToken: true,
@@ -837,6 +848,49 @@ func TestSetGetToken(t *testing.T) {
 	}
 }
 
+func TestReadiness(t *testing.T) {
+	t.Parallel()
+
+	dir, err := os.MkdirTemp("", "cache-path-readiness-")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	dbFile := filepath.Join(dir, "db.sqlite")
+	testhelper.CreateMigrateDatabase(t, dbFile)
+
+	dbClient, err := database.Open("sqlite:"+dbFile, nil)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = dbClient.Close() })
+
+	localStore, err := local.New(newContext(), dir)
+	require.NoError(t, err)
+
+	c, err := newTestCache(newContext(), dbClient, localStore, localStore, localStore)
+	require.NoError(t, err)
+
+	t.Cleanup(c.Close)
+
+	c.SetNarInfoWarmUp(100, time.Hour)
+
+	s := server.New(c)
+
+	readyz := func() int {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/readyz", nil))
+
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, readyz(), "not ready while warming up")
+
+	_, err = c.WarmUp(newContext())
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, readyz(), "ready once warmed up")
+}
+
 func TestGetNar_HeadBytelessNarIs404(t *testing.T) {
 	t.Parallel()
 
@@ -993,6 +1047,99 @@ func TestGetNar_HeadAmbiguousStorageIsNotFalse200(t *testing.T) {
 	resp.Body.Close()
 }
 
+// presigningNarStore wraps a local store and hands out fake presigned URLs so the
+// redirect path can be exercised without S3.
+type presigningNarStore struct {
+	*local.Store
+}
+
+func (s *presigningNarStore) PresignNarURL(_ context.Context, narURL nar.URL, expiry time.Duration) (*url.URL, error) {
+	return url.Parse("https://s3.example.com/" + narURL.String() + "?expires=" + expiry.String())
+}
+
+func TestGetNar_PresignedRedirect(t *testing.T) {
+	t.Parallel()
+
+	dir, err := os.MkdirTemp("", "cache-path-presign-")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	dbFile := filepath.Join(dir, "db.sqlite")
+	testhelper.CreateMigrateDatabase(t, dbFile)
+
+	dbClient, err := database.Open("sqlite:"+dbFile, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = dbClient.Close() })
+
+	localStore, err := local.New(newContext(), dir)
+	require.NoError(t, err)
+
+	narStore := &presigningNarStore{Store: localStore}
+
+	c, err := newTestCache(newContext(), dbClient, localStore, localStore, narStore)
+	require.NoError(t, err)
+	t.Cleanup(c.Close)
+
+	narHash := testdata.Nar1.NarHash
+	nu := nar.URL{Hash: narHash, Compression: nar.CompressionTypeXz}
+
+	require.NoError(t, c.PutNar(newContext(), nu, io.NopCloser(strings.NewReader(testdata.Nar1.NarText))))
+
+	get := func(t *testing.T, s *server.Server, path string) *http.Response {
+		t.Helper()
+
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, path, nil)
+		req.RemoteAddr = "10.1.2.3:4567"
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+
+		return w.Result()
+	}
+
+	t.Run("client in a configured network is redirected", func(t *testing.T) {
+		t.Parallel()
+
+		s := server.New(c)
+		s.SetNarRedirect(5*time.Minute, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+		s.SetCacheStatusHeaders(true)
+
+		resp := get(t, s, "/nar/"+narHash+".nar.xz")
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+		assert.Equal(t, "https://s3.example.com/nar/"+narHash+".nar.xz?expires=5m0s", resp.Header.Get("Location"))
+		assert.Equal(t, "PASS", resp.Header.Get("X-Ncps-Cache"))
+	})
+
+	t.Run("client outside the configured networks is proxied", func(t *testing.T) {
+		t.Parallel()
+
+		s := server.New(c)
+		s.SetNarRedirect(5*time.Minute, []netip.Prefix{netip.MustParsePrefix("192.168.0.0/16")})
+
+		resp := get(t, s, "/nar/"+narHash+".nar.xz")
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Location"))
+	})
+
+	t.Run("nar not stored as requested is proxied", func(t *testing.T) {
+		t.Parallel()
+
+		s := server.New(c)
+		s.SetNarRedirect(5*time.Minute, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+
+		resp := get(t, s, "/nar/"+narHash+".nar")
+		defer resp.Body.Close()
+
+		assert.NotEqual(t, http.StatusFound, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Location"))
+	})
+}
+
 func TestGetNarInfo_Head(t *testing.T) {
 	t.Parallel()
 
@@ -2380,3 +2527,321 @@ func TestPinClosure(t *testing.T) {
 		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "invalid hash should return 404 Not Found")
 	})
 }
+
+func TestCacheStatusHeaders(t *testing.T) {
+	t.Parallel()
+
+	hts := testdata.NewTestServer(t, 40)
+	t.Cleanup(hts.Close)
+
+	uc, err := upstream.New(newContext(), testhelper.MustParseURL(t, hts.URL), &upstream.Options{
+		PublicKeys: testdata.PublicKeys(),
+	})
+	require.NoError(t, err)
+
+	dir, err := os.MkdirTemp("", "cache-status-headers-")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	dbFile := filepath.Join(dir, "var", "ncps", "db", "db.sqlite")
+	testhelper.CreateMigrateDatabase(t, dbFile)
+
+	dbClient, err := database.Open("sqlite:"+dbFile, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = dbClient.Close() })
+
+	localStore, err := local.New(newContext(), dir)
+	require.NoError(t, err)
+
+	c, err := newTestCache(newContext(), dbClient, localStore, localStore, localStore)
+	require.NoError(t, err)
+	t.Cleanup(c.Close)
+
+	c.AddUpstreamCaches(newContext(), uc)
+
+	<-c.GetHealthChecker().Trigger()
+
+	s := server.New(c)
+	s.SetCacheStatusHeaders(true)
+
+	get := func(t *testing.T, s *server.Server, path string) *http.Response {
+		t.Helper()
+
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+
+		return w.Result()
+	}
+
+	narInfoPath := "/" + testdata.Nar1.NarInfoHash + ".narinfo"
+	narPath := "/nar/" + testdata.Nar1.NarHash + ".nar.xz"
+
+	resp := get(t, s, narInfoPath)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Ncps-Cache"))
+	assert.Equal(t, uc.GetHostname(), resp.Header.Get("X-Ncps-Upstream"))
+
+	resp = get(t, s, narInfoPath)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Ncps-Cache"))
+	assert.Empty(t, resp.Header.Get("X-Ncps-Upstream"))
+
+	// The narinfo pull downloads the nar in the background; wait for it to land.
+	require.Eventually(t, func() bool {
+		return c.HasNarInStore(newContext(), nar.URL{Hash: testdata.Nar1.NarHash, Compression: nar.CompressionTypeXz})
+	}, 5*time.Second, 20*time.Millisecond)
+
+	resp = get(t, s, narPath)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Ncps-Cache"))
+	assert.Equal(t, "file", resp.Header.Get("X-Ncps-Store"))
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+
+		resp := get(t, server.New(c), narInfoPath)
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("X-Ncps-Cache"))
+		assert.Empty(t, resp.Header.Get("X-Ncps-Store"))
+	})
+}
+
+func TestStoreDirRewrite(t *testing.T) {
+	t.Parallel()
+
+	c := newProblemTestCache(t)
+	require.NoError(t, c.SetStoreDirRewrite(cache.DefaultStoreDir, "/opt/nix/store"))
+
+	narURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: testdata.Nar1.NarCompression}
+	require.NoError(t, c.PutNar(newContext(), narURL, io.NopCloser(strings.NewReader(testdata.Nar1.NarText))))
+	require.NoError(t, c.PutNarInfo(newContext(), testdata.Nar1.NarInfoHash,
+		io.NopCloser(strings.NewReader(testdata.Nar1.NarInfoText))))
+
+	s := server.New(c)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequestWithContext(t.Context(), http.MethodGet, path, nil))
+
+		return w
+	}
+
+	assert.Contains(t, get("/nix-cache-info").Body.String(), "StoreDir: /opt/nix/store\n")
+
+	w := get("/" + testdata.Nar1.NarInfoHash + ".narinfo")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	ni, err := narinfo.Parse(w.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, "/opt/nix/store/n5glp21rsz314qssw9fbvfswgy3kc68f-hello-2.12.1", ni.StorePath)
+	require.Len(t, ni.Signatures, 1, "the upstream signature does not cover the rewritten store path")
+	assert.Equal(t, c.PublicKey().Name, ni.Signatures[0].Name)
+}
+
+func TestNarInfoFields(t *testing.T) {
+	t.Parallel()
+
+	c := newProblemTestCache(t)
+	c.SetNarInfoFields([]cache.NarInfoField{
+		{Name: "System", Value: "x86_64-linux"},
+		{Name: "Priority", Value: "20"},
+	})
+
+	narURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: testdata.Nar1.NarCompression}
+	require.NoError(t, c.PutNar(newContext(), narURL, io.NopCloser(strings.NewReader(testdata.Nar1.NarText))))
+	require.NoError(t, c.PutNarInfo(newContext(), testdata.Nar1.NarInfoHash,
+		io.NopCloser(strings.NewReader(testdata.Nar1.NarInfoText))))
+
+	s := server.New(c)
+	s.SetPriority(30)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequestWithContext(t.Context(), http.MethodGet, path, nil))
+
+		return w
+	}
+
+	assert.Contains(t, get("/nix-cache-info").Body.String(), "Priority: 30")
+
+	w := get("/" + testdata.Nar1.NarInfoHash + ".narinfo")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	assert.Contains(t, body, "System: x86_64-linux\n")
+	assert.True(t, strings.HasSuffix(body, "\nPriority: 20\n"), body)
+	assert.Equal(t, strconv.Itoa(len(body)), w.Header().Get("Content-Length"))
+}
+
+func TestNarInfoIndex(t *testing.T) {
+	t.Parallel()
+
+	c := newProblemTestCache(t)
+
+	narURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: testdata.Nar1.NarCompression}
+	require.NoError(t, c.PutNar(newContext(), narURL, io.NopCloser(strings.NewReader(testdata.Nar1.NarText))))
+	require.NoError(t, c.PutNarInfo(newContext(), testdata.Nar1.NarInfoHash,
+		io.NopCloser(strings.NewReader(testdata.Nar1.NarInfoText))))
+
+	s := server.New(c)
+
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/narinfo-index.zst", nil)
+		maps.Copy(r.Header, header)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		return w
+	}
+
+	assert.Equal(t, http.StatusNotFound, get(nil).Code, "the index is not built yet")
+
+	idx, err := c.BuildNarInfoIndex(newContext())
+	require.NoError(t, err)
+
+	w := get(nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zstd", w.Header().Get("Content-Type"))
+	assert.Equal(t, idx.ETag, w.Header().Get("ETag"))
+	assert.Equal(t, idx.LastModified.Format(http.TimeFormat), w.Header().Get("Last-Modified"))
+	assert.Equal(t, "1", w.Header().Get("X-Ncps-Index-Count"))
+	assert.Equal(t, idx.Data, w.Body.Bytes())
+
+	w = get(http.Header{"If-None-Match": []string{idx.ETag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = get(http.Header{"If-Modified-Since": []string{idx.LastModified.Format(http.TimeFormat)}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+}
+
+func TestNarInfoBloom(t *testing.T) {
+	t.Parallel()
+
+	c := newProblemTestCache(t)
+	s := server.New(c)
+
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/narinfo-bloom.zst", nil)
+		maps.Copy(r.Header, header)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		return w
+	}
+
+	assert.Equal(t, http.StatusNotFound, get(nil).Code, "the index is not built yet")
+
+	idx, err := c.BuildNarInfoIndex(newContext())
+	require.NoError(t, err)
+
+	w := get(nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zstd", w.Header().Get("Content-Type"))
+	assert.Equal(t, idx.BloomETag, w.Header().Get("ETag"))
+	assert.Equal(t, idx.Bloom, w.Body.Bytes())
+
+	w = get(http.Header{"If-None-Match": []string{idx.BloomETag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+}
+
+func TestNar_AliasExtension(t *testing.T) {
+	t.Parallel()
+
+	ts, _, _, _, _ := setupUploadRouteTest(t)
+
+	aliasPath := "/nar/" + testdata.Nar9.NarHash + ".nar.zstd"
+	canonicalPath := "/nar/" + testdata.Nar9.NarHash + ".nar.zst"
+
+	putReq, err := http.NewRequestWithContext(newContext(),
+		http.MethodPut, ts.URL+"/upload"+aliasPath, strings.NewReader(testdata.Nar9.NarText))
+	require.NoError(t, err)
+
+	putResp, err := ts.Client().Do(putReq)
+	require.NoError(t, err)
+	putResp.Body.Close()
+
+	require.Equal(t, http.StatusNoContent, putResp.StatusCode)
+	assert.Equal(t, "/upload"+canonicalPath, putResp.Header.Get("Location"))
+
+	client := ts.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	getReq, err := http.NewRequestWithContext(newContext(), http.MethodGet, ts.URL+aliasPath, nil)
+	require.NoError(t, err)
+
+	getResp, err := client.Do(getReq)
+	require.NoError(t, err)
+	getResp.Body.Close()
+
+	assert.Equal(t, http.StatusMovedPermanently, getResp.StatusCode)
+	assert.Equal(t, canonicalPath, getResp.Header.Get("Location"))
+
+	getReq, err = http.NewRequestWithContext(newContext(), http.MethodGet, ts.URL+canonicalPath, nil)
+	require.NoError(t, err)
+
+	getResp, err = ts.Client().Do(getReq)
+	require.NoError(t, err)
+
+	defer getResp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, getResp.StatusCode)
+
+	body, err := io.ReadAll(getResp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, testdata.Nar9.NarText, string(body))
+
+	// A narinfo uploaded with the alias URL is served with the canonical one.
+	narInfoText := strings.Replace(testdata.Nar9.NarInfoText, ".nar.zst", ".nar.zstd", 1)
+	require.Contains(t, narInfoText, ".nar.zstd")
+
+	putReq, err = http.NewRequestWithContext(newContext(),
+		http.MethodPut, ts.URL+"/upload/"+testdata.Nar9.NarInfoHash+".narinfo", strings.NewReader(narInfoText))
+	require.NoError(t, err)
+
+	putResp, err = ts.Client().Do(putReq)
+	require.NoError(t, err)
+	putResp.Body.Close()
+
+	require.Equal(t, http.StatusNoContent, putResp.StatusCode)
+
+	getReq, err = http.NewRequestWithContext(newContext(),
+		http.MethodGet, ts.URL+"/"+testdata.Nar9.NarInfoHash+".narinfo", nil)
+	require.NoError(t, err)
+
+	narInfoResp, err := ts.Client().Do(getReq)
+	require.NoError(t, err)
+
+	defer narInfoResp.Body.Close()
+
+	body, err = io.ReadAll(narInfoResp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "URL: nar/"+testdata.Nar9.NarHash+".nar.zst\n")
+}
+
+func TestNar_AliasExtensionMounted(t *testing.T) {
+	t.Parallel()
+
+	router := chi.NewRouter()
+	router.Mount("/cache", server.New(newProblemTestCache(t)))
+
+	r := httptest.NewRequestWithContext(t.Context(), http.MethodGet,
+		"/cache/nar/"+testdata.Nar9.NarHash+".nar.zstd?hash=abc", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "/cache/nar/"+testdata.Nar9.NarHash+".nar.zst?hash=abc", w.Header().Get("Location"))
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	narinfopkg "github.com/nix-community/go-nix/pkg/narinfo"
+
+	"github.com/kalbasit/ncps/pkg/cache"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/narinfo"
+)
+
+const (
+	routeStoreAPI      = "/store/v1"
+	routeStorePathInfo = "/paths/{hash:" + narinfo.HashPattern + "}"
+	routeStorePathNar  = "/paths/{hash:" + narinfo.HashPattern + "}/nar"
+)
+
+// pathInfoResponse is the JSON representation of the path info of a store
+// path, as printed by nix path-info --json.
+type pathInfoResponse struct {
+	Path       string   `json:"path"`
+	NarHash    string   `json:"narHash"`
+	NarSize    uint64   `json:"narSize"`
+	References []string `json:"references"`
+	Deriver    string   `json:"deriver,omitempty"`
+	Signatures []string `json:"signatures"`
+	CA         string   `json:"ca,omitempty"`
+}
+
+// SetStoreAPI enables the experimental store API under /store/v1, serving the
+// cached store paths the way the Nix daemon does, queryPathInfo and
+// narFromPath, for the clients speaking it rather than the binary cache
+// protocol:
+//
+//   - GET (or HEAD) /store/v1/paths/{hash} answers the path info as JSON.
+//   - GET /store/v1/paths/{hash}/nar answers the NAR, uncompressed.
+//
+// The store paths are pulled from the upstreams on a miss, like their
+// narinfos.
+func (s *Server) SetStoreAPI(enabled bool) { s.storeAPI = enabled }
+
+func (s *Server) registerStoreAPIRoutes(r chi.Router) {
+	r.Head(routeStorePathInfo, s.limit(endpointNarInfo, s.getPathInfo(false)))
+	r.Get(routeStorePathInfo, s.limit(endpointNarInfo, s.getPathInfo(true)))
+	r.Get(routeStorePathNar, s.limit(endpointNar, s.getNarFromPath))
+}
+
+// getPathInfo answers queryPathInfo.
+func (s *Server) getPathInfo(withBody bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		narInfo, ok := s.storePathNarInfo(w, r, "server.getPathInfo")
+		if !ok {
+			return
+		}
+
+		if !withBody {
+			w.Header().Set(contentType, contentTypeJSON)
+			w.WriteHeader(http.StatusOK)
+
+			return
+		}
+
+		writeJSON(w, r, http.StatusOK, newPathInfoResponse(narInfo))
+	}
+}
+
+// getNarFromPath answers narFromPath: the NAR of the store path, decompressed.
+func (s *Server) getNarFromPath(w http.ResponseWriter, r *http.Request) {
+	narInfo, ok := s.storePathNarInfo(w, r, "server.getNarFromPath")
+	if !ok {
+		return
+	}
+
+	nu, err := nar.ParseURL(narInfo.URL)
+	if err == nil {
+		nu, err = nu.Normalize()
+	}
+
+	if err != nil {
+		zerolog.Ctx(r.Context()).
+			Error().
+			Err(err).
+			Msg("error parsing the NAR URL")
+
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
+
+		return
+	}
+
+	nu, _, reader, err := s.cache.GetNar(r.Context(), nu)
+	if err != nil {
+		writeGetNarError(w, r, err)
+
+		return
+	}
+
+	defer reader.Close()
+
+	dr, err := nar.DecompressReader(r.Context(), reader, nu.Compression)
+	if err != nil {
+		zerolog.Ctx(r.Context()).
+			Error().
+			Err(err).
+			Msg("error decompressing the nar")
+
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
+
+		return
+	}
+
+	defer dr.Close()
+
+	h := w.Header()
+	h.Set(contentType, contentTypeNar)
+
+	if narInfo.NarSize > 0 {
+		h.Set(contentLength, strconv.FormatUint(narInfo.NarSize, 10))
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, dr); err != nil {
+		zerolog.Ctx(r.Context()).
+			Warn().
+			Err(err).
+			Msg("error writing the nar")
+	}
+}
+
+// storePathNarInfo returns the narinfo of the store path of the request, as
+// served by the binary cache routes. It answers the request itself and
+// returns false when the store API is disabled or the narinfo cannot be
+// served.
+func (s *Server) storePathNarInfo(w http.ResponseWriter, r *http.Request, spanName string) (*narinfopkg.NarInfo, bool) {
+	if !s.storeAPI {
+		notFound(w, r)
+
+		return nil, false
+	}
+
+	hash := chi.URLParam(r, "hash")
+
+	ctx, span := tracer.Start(
+		r.Context(),
+		spanName,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("narinfo_hash", hash),
+		),
+	)
+	defer span.End()
+
+	r = r.WithContext(
+		zerolog.Ctx(ctx).
+			With().
+			Str("narinfo_hash", hash).
+			Logger().
+			WithContext(ctx),
+	)
+
+	r, serveInfo := s.withServeInfo(r)
+
+	budgetCtx, cancel := cache.WithRequestBudget(r.Context(), s.narInfoBudget)
+	defer cancel()
+
+	narInfo, err := s.cache.GetNarInfo(budgetCtx, hash)
+	if err != nil {
+		s.writeGetNarInfoError(w, r, err, serveInfo)
+
+		return nil, false
+	}
+
+	narInfoCopy := *narInfo
+
+	served, err := s.cache.ServedNarInfo(r.Context(), hash, &narInfoCopy)
+	if err != nil {
+		zerolog.Ctx(r.Context()).
+			Error().
+			Err(err).
+			Msg("error rewriting the narinfo")
+
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, err.Error())
+
+		return nil, false
+	}
+
+	// The NAR is fetched from the URL the cache stores it under, whatever the
+	// narinfo served advertises.
+	served.URL = narInfo.URL
+
+	return served, true
+}
+
+// newPathInfoResponse returns the path info of narInfo. The references and the
+// deriver of a narinfo are store path basenames, expanded to full store paths.
+func newPathInfoResponse(narInfo *narinfopkg.NarInfo) pathInfoResponse {
+	storeDir := path.Dir(narInfo.StorePath)
+
+	resp := pathInfoResponse{
+		Path:       narInfo.StorePath,
+		NarSize:    narInfo.NarSize,
+		References: make([]string, 0, len(narInfo.References)),
+		Signatures: make([]string, 0, len(narInfo.Signatures)),
+		CA:         narInfo.CA,
+	}
+
+	if narInfo.NarHash != nil {
+		resp.NarHash = narInfo.NarHash.Format(nixhash.SRI, true)
+	}
+
+	for _, ref := range narInfo.References {
+		resp.References = append(resp.References, path.Join(storeDir, ref))
+	}
+
+	if narInfo.Deriver != "" {
+		resp.Deriver = path.Join(storeDir, narInfo.Deriver)
+	}
+
+	for _, sig := range narInfo.Signatures {
+		resp.Signatures = append(resp.Signatures, sig.String())
+	}
+
+	return resp
+}
@@ -0,0 +1,106 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/database"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/server"
+	"github.com/kalbasit/ncps/pkg/storage/local"
+	"github.com/kalbasit/ncps/testdata"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func TestStoreAPI(t *testing.T) {
+	t.Parallel()
+
+	dir, err := os.MkdirTemp("", "cache-path-store-api-")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	dbFile := filepath.Join(dir, "db.sqlite")
+	testhelper.CreateMigrateDatabase(t, dbFile)
+
+	dbClient, err := database.Open("sqlite:"+dbFile, nil)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = dbClient.Close() })
+
+	localStore, err := local.New(newContext(), dir)
+	require.NoError(t, err)
+
+	c, err := newTestCache(newContext(), dbClient, localStore, localStore, localStore)
+	require.NoError(t, err)
+
+	t.Cleanup(c.Close)
+
+	entry := testdata.Nar7
+
+	narURL := nar.URL{Hash: entry.NarHash, Compression: entry.NarCompression}
+	require.NoError(t, c.PutNar(newContext(), narURL, io.NopCloser(strings.NewReader(entry.NarText))))
+	require.NoError(t, c.PutNarInfo(newContext(), entry.NarInfoHash,
+		io.NopCloser(strings.NewReader(entry.NarInfoText))))
+
+	s := server.New(c)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequestWithContext(t.Context(), http.MethodGet, path, nil))
+
+		return w
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/store/v1/paths/"+entry.NarInfoHash).Code)
+		assert.Equal(t, http.StatusNotFound, get("/store/v1/paths/"+entry.NarInfoHash+"/nar").Code)
+	})
+
+	s.SetStoreAPI(true)
+
+	t.Run("queryPathInfo", func(t *testing.T) {
+		w := get("/store/v1/paths/" + entry.NarInfoHash)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var info struct {
+			Path       string   `json:"path"`
+			NarHash    string   `json:"narHash"`
+			NarSize    uint64   `json:"narSize"`
+			References []string `json:"references"`
+			Deriver    string   `json:"deriver"`
+			Signatures []string `json:"signatures"`
+		}
+
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+
+		assert.Equal(t, "/nix/store/c12lxpykv6sld7a0sakcnr3y0la70x8w-hello-2.12.2", info.Path)
+		assert.True(t, strings.HasPrefix(info.NarHash, "sha256-"), info.NarHash)
+		assert.Equal(t, uint64(len(entry.NarText)), info.NarSize)
+		assert.Equal(t, []string{"/nix/store/7h6icyvqv6lqd0bcx41c8h3615rjcqb2-libiconv-109.100.2"}, info.References)
+		assert.Equal(t, "/nix/store/msnhw2b4dcn9kbswsfz63jplf7ncnxik-hello-2.12.2.drv", info.Deriver)
+		assert.Contains(t, info.Signatures,
+			"cache.nixos.org-1:oPqkkDFlniUh1BaGWwWd7LY2EfUh3r/GBxriDGE7vCfvJ3fKsnIDg1L4QFkuHKWIfwWxWy4FlpO6/5FHPx00AQ==")
+	})
+
+	t.Run("narFromPath", func(t *testing.T) {
+		w := get("/store/v1/paths/" + entry.NarInfoHash + "/nar")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "application/x-nix-nar", w.Header().Get("Content-Type"))
+		assert.Equal(t, entry.NarText, w.Body.String())
+	})
+
+	t.Run("unknown store path", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/store/v1/paths/"+testdata.Nar1.NarInfoHash).Code)
+	})
+}
@@ -0,0 +1,173 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+
+	awssigner "github.com/minio/minio-go/v7/pkg/signer"
+)
+
+const (
+	// awsKMSKeySpec is the key spec of the AWS KMS Ed25519 keys.
+	awsKMSKeySpec = "ECC_NIST_EDWARDS25519"
+
+	// awsKMSSigningAlgorithm signs the message itself (PureEdDSA), as Nix
+	// expects.
+	awsKMSSigningAlgorithm = "ED25519_SHA_512"
+)
+
+// AWSKMSOptions configures an AWS KMS signer.
+type AWSKMSOptions struct {
+	// Region is the AWS region of the key.
+	Region string
+
+	// KeyID is the ID, ARN or alias of the Ed25519 key.
+	KeyID string
+
+	// Endpoint overrides the KMS endpoint of the region, e.g. for a VPC
+	// endpoint.
+	Endpoint string
+
+	// Credentials authenticate to AWS.
+	Credentials *credentials.Credentials
+
+	// Name is the name of the Nix signing key, usually the cache hostname.
+	Name string
+
+	// Client sends the requests; http.DefaultClient when nil.
+	Client *http.Client
+}
+
+// AWSKMS signs with an Ed25519 key of AWS KMS.
+type AWSKMS struct {
+	opts      AWSKMSOptions
+	publicKey signature.PublicKey
+}
+
+// NewAWSKMS returns a Signer signing with the KMS key of opts, after fetching
+// its public key.
+func NewAWSKMS(ctx context.Context, opts AWSKMSOptions) (*AWSKMS, error) {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://kms." + opts.Region + ".amazonaws.com"
+	}
+
+	k := &AWSKMS{opts: opts}
+
+	var resp struct {
+		KeySpec   string `json:"KeySpec"`
+		PublicKey []byte `json:"PublicKey"`
+	}
+
+	if err := k.do(ctx, "GetPublicKey", map[string]any{"KeyId": opts.KeyID}, &resp); err != nil {
+		return nil, fmt.Errorf("error reading the AWS KMS key %q: %w", opts.KeyID, err)
+	}
+
+	if resp.KeySpec != awsKMSKeySpec {
+		return nil, fmt.Errorf("%w: AWS KMS key %q is of spec %q", ErrUnsupportedKeyType, opts.KeyID, resp.KeySpec)
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the public key of the AWS KMS key %q: %w", opts.KeyID, err)
+	}
+
+	edPub, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: AWS KMS key %q has a %T public key", ErrUnsupportedKeyType, opts.KeyID, pub)
+	}
+
+	k.publicKey = signature.PublicKey{Name: opts.Name, Data: edPub}
+
+	return k, nil
+}
+
+// PublicKey returns the public key of the KMS key.
+func (k *AWSKMS) PublicKey() signature.PublicKey { return k.publicKey }
+
+// Sign signs the fingerprint with the KMS key.
+func (k *AWSKMS) Sign(ctx context.Context, fingerprint string) (signature.Signature, error) {
+	req := map[string]any{
+		"KeyId":            k.opts.KeyID,
+		"Message":          []byte(fingerprint),
+		"MessageType":      "RAW",
+		"SigningAlgorithm": awsKMSSigningAlgorithm,
+	}
+
+	var resp struct {
+		Signature []byte `json:"Signature"`
+	}
+
+	if err := k.do(ctx, "Sign", req, &resp); err != nil {
+		return signature.Signature{}, fmt.Errorf("error signing with the AWS KMS key %q: %w", k.opts.KeyID, err)
+	}
+
+	return remoteSignature(k.publicKey, fingerprint, resp.Signature)
+}
+
+// do calls the KMS action and decodes its response into out.
+func (k *AWSKMS) do(ctx context.Context, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("error encoding the request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating the request: %w", err)
+	}
+
+	creds, err := k.opts.Credentials.GetWithContext(&credentials.CredContext{Client: k.opts.Client})
+	if err != nil {
+		return fmt.Errorf("error getting the AWS credentials: %w", err)
+	}
+
+	payloadHash := sha256.Sum256(body)
+
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	req = awssigner.SignV4WithServiceType(
+		*req, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken, k.opts.Region, "kms",
+	)
+
+	resp, err := k.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending the request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+
+		//nolint:errcheck // the status code is reported either way
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&errResp)
+
+		return fmt.Errorf("%w: status %d: %s: %s", ErrUnexpectedResponse, resp.StatusCode, errResp.Type, errResp.Message)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding the response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// Package signer signs narinfo fingerprints, either with a secret key held in
+// memory or through an external key management service so the private key
+// never leaves it.
+package signer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+)
+
+var (
+	// ErrUnsupportedKeyType is returned when the external key is not an Ed25519
+	// key, the only type Nix signatures support.
+	ErrUnsupportedKeyType = errors.New("the signing key must be an Ed25519 key")
+
+	// ErrInvalidSignature is returned when a signature made by an external
+	// service does not verify with its public key.
+	ErrInvalidSignature = errors.New("the signature does not verify with the public key")
+
+	// ErrUnexpectedResponse is returned when an external service answers with an
+	// error or an unexpected body.
+	ErrUnexpectedResponse = errors.New("unexpected response")
+)
+
+// Signer signs the fingerprints of narinfos and build traces.
+type Signer interface {
+	// PublicKey returns the public key verifying the signatures.
+	PublicKey() signature.PublicKey
+
+	// Sign signs the fingerprint.
+	Sign(ctx context.Context, fingerprint string) (signature.Signature, error)
+}
+
+// Local signs with a secret key held in memory.
+type Local struct {
+	secretKey signature.SecretKey
+}
+
+// NewLocal returns a Signer signing with the secret key.
+func NewLocal(secretKey signature.SecretKey) *Local {
+	return &Local{secretKey: secretKey}
+}
+
+// PublicKey returns the public key of the secret key.
+func (l *Local) PublicKey() signature.PublicKey { return l.secretKey.ToPublicKey() }
+
+// Sign signs the fingerprint with the secret key.
+func (l *Local) Sign(_ context.Context, fingerprint string) (signature.Signature, error) {
+	return l.secretKey.Sign(nil, fingerprint)
+}
+
+// remoteSignature checks that data, a signature of fingerprint made by an
+// external service, verifies with pk, so a misconfigured key or algorithm is
+// caught before the signature is served.
+func remoteSignature(pk signature.PublicKey, fingerprint string, data []byte) (signature.Signature, error) {
+	sig := signature.Signature{Name: pk.Name, Data: data}
+
+	if !pk.Verify(fingerprint, sig) {
+		return signature.Signature{}, fmt.Errorf("%w: %s", ErrInvalidSignature, pk)
+	}
+
+	return sig, nil
+}
@@ -0,0 +1,228 @@
+package signer_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/signer"
+)
+
+const fingerprint = "1;/nix/store/abc-hello;sha256:0000;1234;"
+
+func TestLocal(t *testing.T) {
+	t.Parallel()
+
+	sk, pk, err := signature.GenerateKeypair("cache.example.com", nil)
+	require.NoError(t, err)
+
+	s := signer.NewLocal(sk)
+	assert.Equal(t, pk.String(), s.PublicKey().String())
+
+	sig, err := s.Sign(context.Background(), fingerprint)
+	require.NoError(t, err)
+	assert.True(t, pk.Verify(fingerprint, sig))
+}
+
+// newVaultServer serves the transit key "ncps" at version 2 of the mount
+// "transit", signing with signKey.
+func newVaultServer(t *testing.T, pub ed25519.PublicKey, signKey ed25519.PrivateKey) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/transit/keys/ncps":
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data": map[string]any{
+					"type":           "ed25519",
+					"latest_version": 2,
+					"keys": map[string]any{
+						"2": map[string]any{"public_key": base64.StdEncoding.EncodeToString(pub)},
+					},
+				},
+			})
+		case "/v1/transit/sign/ncps":
+			var req struct {
+				Input      string `json:"input"`
+				KeyVersion int    `json:"key_version"`
+			}
+
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.KeyVersion != 2 {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+
+			input, _ := base64.StdEncoding.DecodeString(req.Input)
+
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data": map[string]any{
+					"signature": "vault:v2:" + base64.StdEncoding.EncodeToString(ed25519.Sign(signKey, input)),
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func TestVault(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	t.Run("signs with the transit key", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newVaultServer(t, pub, priv)
+
+		s, err := signer.NewVault(context.Background(), signer.VaultOptions{
+			Address: ts.URL,
+			Token:   "token",
+			Mount:   "transit",
+			Key:     "ncps",
+			Name:    "cache.example.com",
+		})
+		require.NoError(t, err)
+
+		pk := s.PublicKey()
+		assert.Equal(t, "cache.example.com", pk.Name)
+		assert.Equal(t, pub, pk.Data)
+
+		sig, err := s.Sign(context.Background(), fingerprint)
+		require.NoError(t, err)
+		assert.Equal(t, "cache.example.com", sig.Name)
+		assert.True(t, pk.Verify(fingerprint, sig))
+	})
+
+	t.Run("a signature of another key is rejected", func(t *testing.T) {
+		t.Parallel()
+
+		_, other, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		ts := newVaultServer(t, pub, other)
+
+		s, err := signer.NewVault(context.Background(), signer.VaultOptions{
+			Address: ts.URL, Token: "token", Mount: "transit", Key: "ncps", Name: "cache.example.com",
+		})
+		require.NoError(t, err)
+
+		_, err = s.Sign(context.Background(), fingerprint)
+		require.ErrorIs(t, err, signer.ErrInvalidSignature)
+	})
+
+	t.Run("a Vault error is reported", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newVaultServer(t, pub, priv)
+
+		_, err := signer.NewVault(context.Background(), signer.VaultOptions{
+			Address: ts.URL, Token: "wrong", Mount: "transit", Key: "ncps", Name: "cache.example.com",
+		})
+		require.ErrorIs(t, err, signer.ErrUnexpectedResponse)
+		assert.Contains(t, err.Error(), "permission denied")
+	})
+}
+
+func TestAWSKMS(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/kms/aws4_request") {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		var req struct {
+			KeyID            string `json:"KeyId"`
+			Message          []byte `json:"Message"`
+			SigningAlgorithm string `json:"SigningAlgorithm"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.KeyID != "alias/ncps" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"NotFoundException","message":"no such key"}`))
+
+			return
+		}
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GetPublicKey":
+			_ = json.NewEncoder(w).Encode(map[string]any{"KeySpec": "ECC_NIST_EDWARDS25519", "PublicKey": der})
+		case "TrentService.Sign":
+			if req.SigningAlgorithm != "ED25519_SHA_512" {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+
+			_ = json.NewEncoder(w).Encode(map[string]any{"Signature": ed25519.Sign(priv, req.Message)})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	newSigner := func(keyID string) (*signer.AWSKMS, error) {
+		return signer.NewAWSKMS(context.Background(), signer.AWSKMSOptions{
+			Region:      "us-east-1",
+			KeyID:       keyID,
+			Endpoint:    ts.URL,
+			Credentials: credentials.NewStaticV4("access", "secret", ""),
+			Name:        "cache.example.com",
+		})
+	}
+
+	t.Run("signs with the KMS key", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := newSigner("alias/ncps")
+		require.NoError(t, err)
+
+		pk := s.PublicKey()
+		assert.Equal(t, pub, pk.Data)
+
+		sig, err := s.Sign(context.Background(), fingerprint)
+		require.NoError(t, err)
+		assert.True(t, pk.Verify(fingerprint, sig))
+	})
+
+	t.Run("a KMS error is reported", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := newSigner("alias/missing")
+		require.ErrorIs(t, err, signer.ErrUnexpectedResponse)
+		assert.Contains(t, err.Error(), "NotFoundException")
+	})
+}
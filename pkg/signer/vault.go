@@ -0,0 +1,183 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+)
+
+// vaultSignaturePrefix prefixes the signatures returned by Vault transit,
+// followed by the version of the key.
+const vaultSignaturePrefix = "vault:v"
+
+// VaultOptions configures a Vault signer.
+type VaultOptions struct {
+	// Address is the URL of the Vault server, e.g. https://vault:8200.
+	Address string
+
+	// Token authenticates to Vault.
+	Token string
+
+	// Mount is the mount path of the transit secrets engine.
+	Mount string
+
+	// Key is the name of the Ed25519 transit key.
+	Key string
+
+	// Name is the name of the Nix signing key, usually the cache hostname.
+	Name string
+
+	// Client sends the requests; http.DefaultClient when nil.
+	Client *http.Client
+}
+
+// Vault signs with an Ed25519 key of the Vault transit secrets engine. It signs
+// with the version of the key that was the latest one when it was created.
+type Vault struct {
+	opts       VaultOptions
+	keyVersion int
+	publicKey  signature.PublicKey
+}
+
+// NewVault returns a Signer signing with the transit key of opts, after
+// fetching its public key.
+func NewVault(ctx context.Context, opts VaultOptions) (*Vault, error) {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	v := &Vault{opts: opts}
+
+	var resp struct {
+		Data struct {
+			Type          string `json:"type"`
+			LatestVersion int    `json:"latest_version"`
+			Keys          map[string]struct {
+				PublicKey string `json:"public_key"`
+			} `json:"keys"`
+		} `json:"data"`
+	}
+
+	if err := v.do(ctx, http.MethodGet, "keys", nil, &resp); err != nil {
+		return nil, fmt.Errorf("error reading the Vault transit key %q: %w", opts.Key, err)
+	}
+
+	if resp.Data.Type != "ed25519" {
+		return nil, fmt.Errorf("%w: Vault transit key %q is of type %q", ErrUnsupportedKeyType, opts.Key, resp.Data.Type)
+	}
+
+	key, ok := resp.Data.Keys[strconv.Itoa(resp.Data.LatestVersion)]
+	if !ok {
+		return nil, fmt.Errorf("%w: Vault transit key %q has no version %d",
+			ErrUnexpectedResponse, opts.Key, resp.Data.LatestVersion)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	if err != nil || len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: invalid public key of the Vault transit key %q", ErrUnexpectedResponse, opts.Key)
+	}
+
+	v.keyVersion = resp.Data.LatestVersion
+	v.publicKey = signature.PublicKey{Name: opts.Name, Data: data}
+
+	return v, nil
+}
+
+// PublicKey returns the public key of the transit key.
+func (v *Vault) PublicKey() signature.PublicKey { return v.publicKey }
+
+// Sign signs the fingerprint with the transit key.
+func (v *Vault) Sign(ctx context.Context, fingerprint string) (signature.Signature, error) {
+	req := map[string]any{
+		"input":       base64.StdEncoding.EncodeToString([]byte(fingerprint)),
+		"key_version": v.keyVersion,
+	}
+
+	var resp struct {
+		Data struct {
+			Signature string `json:"signature"`
+		} `json:"data"`
+	}
+
+	if err := v.do(ctx, http.MethodPost, "sign", req, &resp); err != nil {
+		return signature.Signature{}, fmt.Errorf("error signing with the Vault transit key %q: %w", v.opts.Key, err)
+	}
+
+	// The signature is of the form vault:v<version>:<base64>.
+	encoded, ok := strings.CutPrefix(resp.Data.Signature, vaultSignaturePrefix+strconv.Itoa(v.keyVersion)+":")
+	if !ok {
+		return signature.Signature{}, fmt.Errorf("%w: Vault signature %q", ErrUnexpectedResponse, resp.Data.Signature)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return signature.Signature{}, fmt.Errorf("error decoding the Vault signature: %w", err)
+	}
+
+	return remoteSignature(v.publicKey, fingerprint, data)
+}
+
+// do sends a request to the endpoint of the transit key and decodes the
+// response into out.
+func (v *Vault) do(ctx context.Context, method, endpoint string, in, out any) error {
+	u, err := url.JoinPath(v.opts.Address, "v1", v.opts.Mount, endpoint, v.opts.Key)
+	if err != nil {
+		return fmt.Errorf("error building the Vault URL: %w", err)
+	}
+
+	var body io.Reader
+
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("error encoding the request: %w", err)
+		}
+
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return fmt.Errorf("error creating the request: %w", err)
+	}
+
+	req.Header.Set("X-Vault-Token", v.opts.Token)
+
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending the request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Errors []string `json:"errors"`
+		}
+
+		//nolint:errcheck // the status code is reported either way
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&errResp)
+
+		return fmt.Errorf("%w: status %d: %s", ErrUnexpectedResponse, resp.StatusCode, strings.Join(errResp.Errors, "; "))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding the response: %w", err)
+	}
+
+	return nil
+}
@@ -7,8 +7,10 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/kalbasit/ncps/pkg/helper"
+	"github.com/kalbasit/ncps/pkg/storage/inventory"
 	"github.com/kalbasit/ncps/pkg/zstd"
 )
 
@@ -27,6 +29,10 @@ func (r *localReadCloser) Close() error {
 // localStore implements Store for local filesystem.
 type localStore struct {
 	baseDir string
+
+	// inventory is the inventory of the chunks, nil unless SetInventory is
+	// called.
+	inventory *inventory.Inventory
 }
 
 // NewLocalStore returns a new local chunk store.
@@ -46,6 +52,18 @@ func (s *localStore) storeDir() string {
 	return filepath.Join(s.baseDir, "chunk")
 }
 
+// SetInventory configures the store to answer HasChunk from an inventory of
+// its chunks instead of a stat. The inventory is saved to inventory/chunk.gz,
+// loaded from it on startup and rebuilt in the background by walking the
+// chunks, on startup and every rebuildInterval if it is positive. Until the
+// first rebuild completes a chunk missing from the inventory is looked up on
+// disk. A chunk stored or deleted by another instance sharing the store is
+// only seen at the next rebuild.
+func (s *localStore) SetInventory(ctx context.Context, rebuildInterval time.Duration) {
+	s.inventory = inventory.New(filepath.Join(s.baseDir, "inventory", "chunk.gz"), s.WalkChunks)
+	s.inventory.Start(ctx, rebuildInterval)
+}
+
 func (s *localStore) WalkChunks(_ context.Context, fn func(hash string) error) error {
 	root := s.storeDir()
 
@@ -81,8 +99,18 @@ func (s *localStore) HasChunk(_ context.Context, hash string) (bool, error) {
 		return false, err
 	}
 
+	if s.inventory != nil {
+		if present, known := s.inventory.Lookup(hash); known {
+			return present, nil
+		}
+	}
+
 	_, err = os.Stat(path)
 	if err == nil {
+		if s.inventory != nil {
+			s.inventory.Add(hash)
+		}
+
 		return true, nil
 	}
 
@@ -102,6 +130,11 @@ func (s *localStore) GetChunk(_ context.Context, hash string) (io.ReadCloser, er
 	f, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
+			// Deleted behind the inventory's back, e.g. by another instance.
+			if s.inventory != nil {
+				s.inventory.Remove(hash)
+			}
+
 			return nil, ErrNotFound
 		}
 
@@ -158,7 +191,7 @@ func (s *localStore) PutChunk(_ context.Context, hash string, data []byte) (bool
 
 	// Use pooled encoder in streaming mode (Reset+Write+Close reuses encoder state,
 	// avoiding the per-call internal allocations that EncodeAll would create).
-	pw := zstd.NewPooledWriter(tmpFile)
+	pw := zstd.NewChunkWriter(tmpFile)
 
 	if _, err = pw.Write(data); err == nil {
 		err = pw.Close()
@@ -188,12 +221,20 @@ func (s *localStore) PutChunk(_ context.Context, hash string, data []byte) (bool
 	if err := os.Link(tmpFile.Name(), path); err != nil {
 		if os.IsExist(err) {
 			// Chunk already exists, which is fine. We didn't create it.
+			if s.inventory != nil {
+				s.inventory.Add(hash)
+			}
+
 			return false, compressedSize, nil
 		}
 
 		return false, 0, err // Some other error
 	}
 
+	if s.inventory != nil {
+		s.inventory.Add(hash)
+	}
+
 	return true, compressedSize, nil
 }
 
@@ -208,6 +249,10 @@ func (s *localStore) DeleteChunk(_ context.Context, hash string) error {
 		return err
 	}
 
+	if s.inventory != nil {
+		s.inventory.Remove(hash)
+	}
+
 	// Attempt to remove parent directories bottom-up.
 	// These will fail silently if a directory is not empty, which is the desired behavior.
 	dir := filepath.Dir(path)
@@ -0,0 +1,396 @@
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	otelPackageName = "github.com/kalbasit/ncps/pkg/storage/chunk"
+
+	// The defaults of MirrorOptions.
+	defaultMirrorQueueSize   = 10000
+	defaultMirrorWorkers     = 4
+	defaultMirrorMaxAttempts = 5
+	defaultMirrorRetryDelay  = time.Second
+
+	mirrorOpPut    = "put"
+	mirrorOpDelete = "delete"
+)
+
+// ErrMirrorClosed is returned by MirrorStore.Close when it is called twice.
+var ErrMirrorClosed = errors.New("the chunk mirror is closed")
+
+//nolint:gochecknoglobals
+var (
+	mirrorOpsMetric        metric.Int64Counter
+	mirrorQueueDepthMetric metric.Int64UpDownCounter
+)
+
+//nolint:gochecknoinits
+func init() {
+	meter := otel.Meter(otelPackageName)
+
+	var err error
+
+	mirrorOpsMetric, err = meter.Int64Counter(
+		"ncps_chunk_mirror_ops_total",
+		metric.WithDescription("Counts the writes replicated to the mirror chunk store, by operation and result."),
+		metric.WithUnit("{operation}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	mirrorQueueDepthMetric, err = meter.Int64UpDownCounter(
+		"ncps_chunk_mirror_queue_depth",
+		metric.WithDescription("The writes waiting to be replicated to the mirror chunk store."),
+		metric.WithUnit("{operation}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// MirrorOptions tunes the replication of a MirrorStore. The zero value uses
+// the defaults.
+type MirrorOptions struct {
+	// QueueSize bounds the writes waiting to be replicated; past it they are
+	// dropped (default 10000).
+	QueueSize int
+
+	// Workers is the number of writes replicated concurrently (default 4).
+	Workers int
+
+	// MaxAttempts is how many times a write is tried before being dropped
+	// (default 5).
+	MaxAttempts int
+
+	// RetryDelay is the delay before the first retry, doubled at each one
+	// (default 1s).
+	RetryDelay time.Duration
+}
+
+// MirrorStore is a Store writing to a primary and a mirror store. Writes go to
+// the primary, then are replicated to the mirror in the background, retried
+// with an exponential backoff. Reads are served by the primary and fall back
+// to the mirror, the chunks it alone holds being copied back to the primary.
+// WalkChunks walks the primary only.
+//
+// The writes of a chunk are replicated in order; Close waits for the queued
+// ones.
+type MirrorStore struct {
+	primary Store
+	mirror  Store
+	opts    MirrorOptions
+
+	// queues are the queues of the workers; the writes of a hash always go to
+	// the same one.
+	queues []chan mirrorOp
+	wg     sync.WaitGroup
+
+	// ctx is cancelled when Close gives up waiting for the queued writes.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+type mirrorOp struct {
+	kind   string
+	hash   string
+	data   []byte
+	logger *zerolog.Logger
+}
+
+// NewMirrorStore returns a MirrorStore writing to primary and mirror, and
+// starts its replication workers.
+func NewMirrorStore(primary, mirror Store, opts MirrorOptions) *MirrorStore {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultMirrorQueueSize
+	}
+
+	if opts.Workers <= 0 {
+		opts.Workers = defaultMirrorWorkers
+	}
+
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMirrorMaxAttempts
+	}
+
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultMirrorRetryDelay
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &MirrorStore{
+		primary: primary,
+		mirror:  mirror,
+		opts:    opts,
+		queues:  make([]chan mirrorOp, opts.Workers),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	for i := range s.queues {
+		s.queues[i] = make(chan mirrorOp, max(opts.QueueSize/opts.Workers, 1))
+
+		s.wg.Add(1)
+
+		go s.replicate(s.queues[i])
+	}
+
+	return s
+}
+
+// Close stops the replication once the queued writes are replicated, or ctx
+// is done, dropping the remaining ones. The writes made afterwards are not
+// replicated.
+func (s *MirrorStore) Close(ctx context.Context) error {
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+
+		return ErrMirrorClosed
+	}
+
+	s.closed = true
+
+	for _, q := range s.queues {
+		close(q)
+	}
+
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.cancel()
+
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-done
+
+		return fmt.Errorf("error waiting for the chunk mirror queue: %w", ctx.Err())
+	}
+}
+
+func (s *MirrorStore) HasChunk(ctx context.Context, hash string) (bool, error) {
+	ok, err := s.primary.HasChunk(ctx, hash)
+	if err == nil && ok {
+		return true, nil
+	}
+
+	mirrorOK, mirrorErr := s.mirror.HasChunk(ctx, hash)
+	if mirrorErr != nil {
+		if err != nil {
+			return false, err
+		}
+
+		return false, mirrorErr
+	}
+
+	return mirrorOK, nil
+}
+
+// GetChunk returns the chunk from the primary, or else from the mirror, in
+// which case it is copied back to the primary.
+func (s *MirrorStore) GetChunk(ctx context.Context, hash string) (io.ReadCloser, error) {
+	rc, err := s.primary.GetChunk(ctx, hash)
+	if err == nil {
+		return rc, nil
+	}
+
+	mirrorRC, mirrorErr := s.mirror.GetChunk(ctx, hash)
+	if mirrorErr != nil {
+		return nil, err
+	}
+	defer mirrorRC.Close()
+
+	data, mirrorErr := io.ReadAll(mirrorRC)
+	if mirrorErr != nil {
+		return nil, fmt.Errorf("error reading chunk %s from the mirror: %w", hash, mirrorErr)
+	}
+
+	if _, _, putErr := s.primary.PutChunk(ctx, hash, data); putErr != nil {
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(putErr).
+			Str("chunk_hash", hash).
+			Msg("error restoring a chunk from the mirror")
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *MirrorStore) GetRawChunk(ctx context.Context, hash string) (io.ReadCloser, error) {
+	rc, err := s.primary.GetRawChunk(ctx, hash)
+	if err == nil {
+		return rc, nil
+	}
+
+	rc, mirrorErr := s.mirror.GetRawChunk(ctx, hash)
+	if mirrorErr != nil {
+		return nil, err
+	}
+
+	return rc, nil
+}
+
+func (s *MirrorStore) PutChunk(ctx context.Context, hash string, data []byte) (bool, int64, error) {
+	created, size, err := s.primary.PutChunk(ctx, hash, data)
+	if err != nil {
+		return false, 0, err
+	}
+
+	// The caller may reuse data once PutChunk returns.
+	s.enqueue(ctx, mirrorOp{kind: mirrorOpPut, hash: hash, data: bytes.Clone(data)})
+
+	return created, size, nil
+}
+
+// DeleteChunk deletes the chunk from the primary, and from the mirror in the
+// background, even if the primary does not hold it.
+func (s *MirrorStore) DeleteChunk(ctx context.Context, hash string) error {
+	err := s.primary.DeleteChunk(ctx, hash)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	s.enqueue(ctx, mirrorOp{kind: mirrorOpDelete, hash: hash})
+
+	return err
+}
+
+func (s *MirrorStore) WalkChunks(ctx context.Context, fn func(hash string) error) error {
+	return s.primary.WalkChunks(ctx, fn)
+}
+
+func (s *MirrorStore) enqueue(ctx context.Context, op mirrorOp) {
+	op.logger = zerolog.Ctx(ctx)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		s.record(ctx, op, "dropped")
+
+		return
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(op.hash))
+
+	select {
+	case s.queues[h.Sum32()%uint32(len(s.queues))] <- op: //nolint:gosec // len(s.queues) is small
+		mirrorQueueDepthMetric.Add(ctx, 1)
+	default:
+		op.logger.
+			Warn().
+			Str("chunk_hash", op.hash).
+			Str("op", op.kind).
+			Msg("the chunk mirror queue is full; dropping the write")
+
+		s.record(ctx, op, "dropped")
+	}
+}
+
+func (s *MirrorStore) replicate(queue <-chan mirrorOp) {
+	defer s.wg.Done()
+
+	for op := range queue {
+		ctx := op.logger.WithContext(s.ctx)
+
+		mirrorQueueDepthMetric.Add(ctx, -1)
+
+		// Close gave up waiting for the queue.
+		if ctx.Err() != nil {
+			s.record(ctx, op, "dropped")
+
+			continue
+		}
+
+		s.apply(ctx, op)
+	}
+}
+
+// apply replicates op, retrying it up to MaxAttempts times.
+func (s *MirrorStore) apply(ctx context.Context, op mirrorOp) {
+	delay := s.opts.RetryDelay
+
+	for attempt := 1; ; attempt++ {
+		var err error
+
+		switch op.kind {
+		case mirrorOpPut:
+			_, _, err = s.mirror.PutChunk(ctx, op.hash, op.data)
+		case mirrorOpDelete:
+			err = s.mirror.DeleteChunk(ctx, op.hash)
+			if errors.Is(err, ErrNotFound) {
+				err = nil
+			}
+		}
+
+		if err == nil {
+			s.record(ctx, op, "ok")
+
+			return
+		}
+
+		if attempt >= s.opts.MaxAttempts || ctx.Err() != nil {
+			zerolog.Ctx(ctx).
+				Error().
+				Err(err).
+				Str("chunk_hash", op.hash).
+				Str("op", op.kind).
+				Int("attempts", attempt).
+				Msg("error replicating to the chunk mirror; dropping the write")
+
+			s.record(ctx, op, "failed")
+
+			return
+		}
+
+		s.record(ctx, op, "retried")
+
+		t := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			t.Stop()
+		case <-t.C:
+		}
+
+		delay *= 2
+	}
+}
+
+func (s *MirrorStore) record(ctx context.Context, op mirrorOp, result string) {
+	mirrorOpsMetric.Add(ctx, 1,
+		metric.WithAttributes(
+			attribute.String("op", op.kind),
+			attribute.String("result", result),
+		),
+	)
+}
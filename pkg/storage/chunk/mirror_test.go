@@ -0,0 +1,149 @@
+package chunk_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+var errFlaky = errors.New("flaky store")
+
+// flakyStore fails the first failures writes.
+type flakyStore struct {
+	chunk.Store
+
+	failures atomic.Int32
+}
+
+func (s *flakyStore) PutChunk(ctx context.Context, hash string, data []byte) (bool, int64, error) {
+	if s.failures.Add(-1) >= 0 {
+		return false, 0, errFlaky
+	}
+
+	return s.Store.PutChunk(ctx, hash, data)
+}
+
+func readChunk(t *testing.T, store chunk.Store, hash string) string {
+	t.Helper()
+
+	rc, err := store.GetChunk(context.Background(), hash)
+	require.NoError(t, err)
+
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func TestMirrorStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	content := strings.Repeat("mirrored chunk", 512)
+
+	t.Run("writes are replicated", func(t *testing.T) {
+		t.Parallel()
+
+		primary, _ := newLocalStore(t)
+		mirror, _ := newLocalStore(t)
+
+		s := chunk.NewMirrorStore(primary, mirror, chunk.MirrorOptions{})
+
+		hash := testhelper.MustRandBase32NarHash()
+		data := []byte(content)
+
+		created, _, err := s.PutChunk(ctx, hash, data)
+		require.NoError(t, err)
+		assert.True(t, created)
+
+		// The caller may reuse its buffer.
+		copy(data, strings.Repeat("x", len(data)))
+
+		require.NoError(t, s.Close(ctx))
+		require.ErrorIs(t, s.Close(ctx), chunk.ErrMirrorClosed)
+
+		assert.Equal(t, content, readChunk(t, primary, hash))
+		assert.Equal(t, content, readChunk(t, mirror, hash))
+	})
+
+	t.Run("failed writes are retried", func(t *testing.T) {
+		t.Parallel()
+
+		primary, _ := newLocalStore(t)
+		mirrorStore, _ := newLocalStore(t)
+		mirror := &flakyStore{Store: mirrorStore}
+		mirror.failures.Store(2)
+
+		s := chunk.NewMirrorStore(primary, mirror, chunk.MirrorOptions{RetryDelay: time.Millisecond})
+
+		hash := testhelper.MustRandBase32NarHash()
+
+		_, _, err := s.PutChunk(ctx, hash, []byte(content))
+		require.NoError(t, err)
+		require.NoError(t, s.Close(ctx))
+
+		assert.Equal(t, content, readChunk(t, mirrorStore, hash))
+	})
+
+	t.Run("reads fall back to the mirror", func(t *testing.T) {
+		t.Parallel()
+
+		primary, _ := newLocalStore(t)
+		mirror, _ := newLocalStore(t)
+
+		s := chunk.NewMirrorStore(primary, mirror, chunk.MirrorOptions{})
+		t.Cleanup(func() { _ = s.Close(ctx) })
+
+		hash := testhelper.MustRandBase32NarHash()
+
+		_, _, err := mirror.PutChunk(ctx, hash, []byte(content))
+		require.NoError(t, err)
+
+		has, err := s.HasChunk(ctx, hash)
+		require.NoError(t, err)
+		assert.True(t, has)
+
+		assert.Equal(t, content, readChunk(t, s, hash))
+
+		has, err = primary.HasChunk(ctx, hash)
+		require.NoError(t, err)
+		assert.True(t, has, "the chunk is restored to the primary")
+
+		_, err = s.GetChunk(ctx, testhelper.MustRandBase32NarHash())
+		require.ErrorIs(t, err, chunk.ErrNotFound)
+	})
+
+	t.Run("deletes are replicated", func(t *testing.T) {
+		t.Parallel()
+
+		primary, _ := newLocalStore(t)
+		mirror, _ := newLocalStore(t)
+
+		s := chunk.NewMirrorStore(primary, mirror, chunk.MirrorOptions{})
+
+		hash := testhelper.MustRandBase32NarHash()
+
+		_, _, err := s.PutChunk(ctx, hash, []byte(content))
+		require.NoError(t, err)
+		require.NoError(t, s.DeleteChunk(ctx, hash))
+		require.NoError(t, s.Close(ctx))
+
+		for _, store := range []chunk.Store{primary, mirror} {
+			has, err := store.HasChunk(ctx, hash)
+			require.NoError(t, err)
+			assert.False(t, has)
+		}
+	})
+}
@@ -206,7 +206,7 @@ func (s *s3Store) PutChunk(ctx context.Context, hash string, data []byte) (bool,
 	// new internal buffers on every call, causing unbounded memory growth.
 	var buf bytes.Buffer
 
-	pw := zstd.NewPooledWriter(&buf)
+	pw := zstd.NewChunkWriter(&buf)
 
 	if _, err = pw.Write(data); err == nil {
 		err = pw.Close()
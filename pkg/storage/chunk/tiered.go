@@ -0,0 +1,282 @@
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	tierDirectionDemote  = "demote"
+	tierDirectionPromote = "promote"
+)
+
+//nolint:gochecknoglobals
+var (
+	tieringChunksMetric metric.Int64Counter
+	tieringBytesMetric  metric.Int64Counter
+)
+
+//nolint:gochecknoinits
+func init() {
+	meter := otel.Meter(otelPackageName)
+
+	var err error
+
+	tieringChunksMetric, err = meter.Int64Counter(
+		"ncps_chunk_tiering_chunks_total",
+		metric.WithDescription("Counts the chunks moved between the hot and cold chunk stores, by direction and result."),
+		metric.WithUnit("{chunk}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	tieringBytesMetric, err = meter.Int64Counter(
+		"ncps_chunk_tiering_bytes_total",
+		metric.WithDescription("Counts the uncompressed bytes of the chunks moved between the hot and cold chunk stores."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// TieredStore is a Store over a hot and a cold store. Chunks are written to
+// the hot store and moved to the cold one by Demote. Reads fall back to the
+// cold store, the chunks read from it being promoted back to the hot store.
+// WalkChunks walks both stores.
+type TieredStore struct {
+	hot  Store
+	cold Store
+}
+
+// NewTieredStore returns a TieredStore over hot and cold.
+func NewTieredStore(hot, cold Store) *TieredStore {
+	return &TieredStore{hot: hot, cold: cold}
+}
+
+// Close closes the hot and cold stores that can be closed, such as a
+// MirrorStore.
+func (s *TieredStore) Close(ctx context.Context) error {
+	var errs []error
+
+	for _, store := range []Store{s.hot, s.cold} {
+		if closer, ok := store.(interface {
+			Close(ctx context.Context) error
+		}); ok {
+			errs = append(errs, closer.Close(ctx))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *TieredStore) HasChunk(ctx context.Context, hash string) (bool, error) {
+	ok, err := s.hot.HasChunk(ctx, hash)
+	if err == nil && ok {
+		return true, nil
+	}
+
+	coldOK, coldErr := s.cold.HasChunk(ctx, hash)
+	if coldErr != nil {
+		if err != nil {
+			return false, err
+		}
+
+		return false, coldErr
+	}
+
+	return coldOK, nil
+}
+
+// GetChunk returns the chunk from the hot store, or else from the cold store,
+// in which case it is promoted to the hot store.
+func (s *TieredStore) GetChunk(ctx context.Context, hash string) (io.ReadCloser, error) {
+	rc, err := s.hot.GetChunk(ctx, hash)
+	if err == nil {
+		return rc, nil
+	}
+
+	data, coldErr := readAllChunk(ctx, s.cold, hash)
+	if errors.Is(coldErr, ErrNotFound) {
+		// A concurrent read may have promoted the chunk meanwhile.
+		return s.hot.GetChunk(ctx, hash)
+	}
+
+	if coldErr != nil {
+		return nil, err
+	}
+
+	if err := s.promote(ctx, hash, data); err != nil {
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(err).
+			Str("chunk_hash", hash).
+			Msg("error promoting a chunk to the hot store")
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// GetRawChunk returns the compressed chunk from the hot store, or else
+// promotes it from the cold store first.
+func (s *TieredStore) GetRawChunk(ctx context.Context, hash string) (io.ReadCloser, error) {
+	rc, err := s.hot.GetRawChunk(ctx, hash)
+	if err == nil {
+		return rc, nil
+	}
+
+	if _, promoteErr := s.Promote(ctx, hash); promoteErr != nil {
+		rc, coldErr := s.cold.GetRawChunk(ctx, hash)
+		if coldErr != nil {
+			return nil, err
+		}
+
+		return rc, nil
+	}
+
+	return s.hot.GetRawChunk(ctx, hash)
+}
+
+func (s *TieredStore) PutChunk(ctx context.Context, hash string, data []byte) (bool, int64, error) {
+	return s.hot.PutChunk(ctx, hash, data)
+}
+
+// DeleteChunk deletes the chunk from both stores. It returns ErrNotFound only
+// if neither holds it.
+func (s *TieredStore) DeleteChunk(ctx context.Context, hash string) error {
+	hotErr := s.hot.DeleteChunk(ctx, hash)
+	if hotErr != nil && !errors.Is(hotErr, ErrNotFound) {
+		return hotErr
+	}
+
+	coldErr := s.cold.DeleteChunk(ctx, hash)
+	if coldErr != nil && !errors.Is(coldErr, ErrNotFound) {
+		return coldErr
+	}
+
+	if hotErr != nil && coldErr != nil {
+		return hotErr
+	}
+
+	return nil
+}
+
+// WalkChunks walks the hot store, then the cold one. A chunk being moved
+// between them may be walked twice.
+func (s *TieredStore) WalkChunks(ctx context.Context, fn func(hash string) error) error {
+	if err := s.hot.WalkChunks(ctx, fn); err != nil {
+		return err
+	}
+
+	return s.cold.WalkChunks(ctx, fn)
+}
+
+// Demote moves a chunk from the hot to the cold store and returns its
+// uncompressed size. A chunk the cold store alone holds is left there, with a
+// size of zero.
+func (s *TieredStore) Demote(ctx context.Context, hash string) (int64, error) {
+	data, err := readAllChunk(ctx, s.hot, hash)
+	if errors.Is(err, ErrNotFound) {
+		if ok, coldErr := s.cold.HasChunk(ctx, hash); coldErr == nil && ok {
+			return 0, nil
+		}
+	}
+
+	if err != nil {
+		s.record(ctx, tierDirectionDemote, "failed", 0)
+
+		return 0, err
+	}
+
+	if _, _, err := s.cold.PutChunk(ctx, hash, data); err != nil {
+		s.record(ctx, tierDirectionDemote, "failed", 0)
+
+		return 0, fmt.Errorf("error writing chunk %s to the cold store: %w", hash, err)
+	}
+
+	if err := s.hot.DeleteChunk(ctx, hash); err != nil && !errors.Is(err, ErrNotFound) {
+		s.record(ctx, tierDirectionDemote, "failed", 0)
+
+		return 0, fmt.Errorf("error deleting chunk %s from the hot store: %w", hash, err)
+	}
+
+	s.record(ctx, tierDirectionDemote, "ok", len(data))
+
+	return int64(len(data)), nil
+}
+
+// Promote moves a chunk from the cold to the hot store and returns its
+// uncompressed size.
+func (s *TieredStore) Promote(ctx context.Context, hash string) (int64, error) {
+	data, err := readAllChunk(ctx, s.cold, hash)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.promote(ctx, hash, data); err != nil {
+		return 0, err
+	}
+
+	return int64(len(data)), nil
+}
+
+// promote writes data to the hot store, then deletes it from the cold one; a
+// failure of the latter is only logged.
+func (s *TieredStore) promote(ctx context.Context, hash string, data []byte) error {
+	if _, _, err := s.hot.PutChunk(ctx, hash, data); err != nil {
+		s.record(ctx, tierDirectionPromote, "failed", 0)
+
+		return fmt.Errorf("error writing chunk %s to the hot store: %w", hash, err)
+	}
+
+	if err := s.cold.DeleteChunk(ctx, hash); err != nil && !errors.Is(err, ErrNotFound) {
+		zerolog.Ctx(ctx).
+			Warn().
+			Err(err).
+			Str("chunk_hash", hash).
+			Msg("error deleting a promoted chunk from the cold store")
+	}
+
+	s.record(ctx, tierDirectionPromote, "ok", len(data))
+
+	return nil
+}
+
+func (s *TieredStore) record(ctx context.Context, direction, result string, size int) {
+	tieringChunksMetric.Add(ctx, 1,
+		metric.WithAttributes(
+			attribute.String("direction", direction),
+			attribute.String("result", result),
+		),
+	)
+
+	if size > 0 {
+		tieringBytesMetric.Add(ctx, int64(size),
+			metric.WithAttributes(attribute.String("direction", direction)),
+		)
+	}
+}
+
+func readAllChunk(ctx context.Context, store Store, hash string) ([]byte, error) {
+	rc, err := store.GetChunk(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("error reading chunk %s: %w", hash, err)
+	}
+
+	return data, nil
+}
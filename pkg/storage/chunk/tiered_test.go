@@ -0,0 +1,122 @@
+package chunk_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+func hasChunk(t *testing.T, store chunk.Store, hash string) bool {
+	t.Helper()
+
+	ok, err := store.HasChunk(context.Background(), hash)
+	require.NoError(t, err)
+
+	return ok
+}
+
+func TestTieredStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	content := strings.Repeat("tiered chunk", 512)
+
+	t.Run("demote then promote on read", func(t *testing.T) {
+		t.Parallel()
+
+		hot, _ := newLocalStore(t)
+		cold, _ := newLocalStore(t)
+
+		s := chunk.NewTieredStore(hot, cold)
+
+		hash := testhelper.MustRandBase32NarHash()
+
+		_, _, err := s.PutChunk(ctx, hash, []byte(content))
+		require.NoError(t, err)
+		assert.True(t, hasChunk(t, hot, hash))
+		assert.False(t, hasChunk(t, cold, hash))
+
+		size, err := s.Demote(ctx, hash)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(content)), size)
+		assert.False(t, hasChunk(t, hot, hash))
+		assert.True(t, hasChunk(t, cold, hash))
+		assert.True(t, hasChunk(t, s, hash))
+
+		// Demoting a cold chunk again is a no-op.
+		size, err = s.Demote(ctx, hash)
+		require.NoError(t, err)
+		assert.Zero(t, size)
+
+		assert.Equal(t, content, readChunk(t, s, hash))
+		assert.True(t, hasChunk(t, hot, hash), "the chunk read is promoted")
+		assert.False(t, hasChunk(t, cold, hash))
+	})
+
+	t.Run("raw reads promote the chunk", func(t *testing.T) {
+		t.Parallel()
+
+		hot, _ := newLocalStore(t)
+		cold, _ := newLocalStore(t)
+
+		s := chunk.NewTieredStore(hot, cold)
+
+		hash := testhelper.MustRandBase32NarHash()
+
+		_, _, err := cold.PutChunk(ctx, hash, []byte(content))
+		require.NoError(t, err)
+
+		rc, err := s.GetRawChunk(ctx, hash)
+		require.NoError(t, err)
+
+		_, err = io.Copy(io.Discard, rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+
+		assert.True(t, hasChunk(t, hot, hash))
+		assert.False(t, hasChunk(t, cold, hash))
+
+		_, err = s.GetRawChunk(ctx, testhelper.MustRandBase32NarHash())
+		require.ErrorIs(t, err, chunk.ErrNotFound)
+	})
+
+	t.Run("deletes and walks cover both stores", func(t *testing.T) {
+		t.Parallel()
+
+		hot, _ := newLocalStore(t)
+		cold, _ := newLocalStore(t)
+
+		s := chunk.NewTieredStore(hot, cold)
+
+		hotHash := testhelper.MustRandBase32NarHash()
+		coldHash := testhelper.MustRandBase32NarHash()
+
+		_, _, err := hot.PutChunk(ctx, hotHash, []byte(content))
+		require.NoError(t, err)
+
+		_, _, err = cold.PutChunk(ctx, coldHash, []byte(content))
+		require.NoError(t, err)
+
+		var walked []string
+
+		require.NoError(t, s.WalkChunks(ctx, func(hash string) error {
+			walked = append(walked, hash)
+
+			return nil
+		}))
+		assert.ElementsMatch(t, []string{hotHash, coldHash}, walked)
+
+		require.NoError(t, s.DeleteChunk(ctx, hotHash))
+		require.NoError(t, s.DeleteChunk(ctx, coldHash))
+
+		assert.False(t, hasChunk(t, s, hotHash))
+		assert.False(t, hasChunk(t, s, coldHash))
+	})
+}
@@ -0,0 +1,359 @@
+// Package inline implements a storage.NarStore that keeps the bodies of small
+// nars in the database instead of the wrapped store. Tiny nars dominate
+// request counts but each costs a filesystem inode (or an object-store request)
+// of its own; storing them in the inline_nars table avoids both. Reads and
+// writes are transparent: callers use the wrapped store through this one and
+// never need to know where a given nar lives.
+package inline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	entinlinenar "github.com/kalbasit/ncps/ent/inlinenar"
+
+	"github.com/kalbasit/ncps/ent"
+	"github.com/kalbasit/ncps/pkg/database"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+)
+
+const (
+	// MaxThreshold is the largest supported inline threshold. The data column
+	// is a BLOB, which MySQL caps at 64 KiB.
+	MaxThreshold = 64 << 10
+
+	otelPackageName = "github.com/kalbasit/ncps/pkg/storage/inline"
+)
+
+var (
+	// ErrInvalidThreshold is returned by New when the threshold is not within
+	// (0, MaxThreshold].
+	ErrInvalidThreshold = errors.New("inline threshold must be between 1 and 65536 bytes")
+
+	//nolint:gochecknoglobals
+	tracer trace.Tracer
+)
+
+//nolint:gochecknoinits
+func init() {
+	tracer = otel.Tracer(otelPackageName)
+}
+
+// Store stores nars of at most threshold bytes in the database and every other
+// nar in the wrapped store. Staging parts always go to the wrapped store.
+type Store struct {
+	storage.NarStore
+
+	db        *database.Client
+	threshold int64
+}
+
+// presigningStore is returned by New when the wrapped store can presign URLs.
+type presigningStore struct {
+	*Store
+
+	presigner storage.NarPresigner
+}
+
+// New returns a storage.NarStore storing the nars of at most threshold bytes
+// in the database and delegating everything else to next. The returned store
+// implements storage.NarPresigner if, and only if, next does.
+func New(next storage.NarStore, db *database.Client, threshold int64) (storage.NarStore, error) {
+	if threshold <= 0 || threshold > MaxThreshold {
+		return nil, fmt.Errorf("%w: got %d", ErrInvalidThreshold, threshold)
+	}
+
+	s := &Store{NarStore: next, db: db, threshold: threshold}
+
+	if presigner, ok := next.(storage.NarPresigner); ok {
+		return &presigningStore{Store: s, presigner: presigner}, nil
+	}
+
+	return s, nil
+}
+
+// HasNar returns true if the store has the nar. Any error collapses to false;
+// use StatNar to distinguish them.
+func (s *Store) HasNar(ctx context.Context, narURL nar.URL) bool {
+	present, _ := s.StatNar(ctx, narURL)
+
+	return present
+}
+
+// StatNar reports whether the nar is stored inline or in the wrapped store.
+// See the storage.NarStore interface for the contract.
+func (s *Store) StatNar(ctx context.Context, narURL nar.URL) (bool, error) {
+	ctx, span := tracer.Start(
+		ctx,
+		"inline.StatNar",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("nar_url", narURL.String()),
+		),
+	)
+	defer span.End()
+
+	inlined, err := s.isInline(ctx, narURL)
+	if err != nil {
+		return false, err
+	}
+
+	if inlined {
+		return true, nil
+	}
+
+	return s.NarStore.StatNar(ctx, narURL)
+}
+
+// GetNar returns the nar from the database if it is stored inline and from
+// the wrapped store otherwise. The reader of an inline nar implements
+// io.ReaderAt so it can serve Range requests.
+// NOTE: The caller must close the returned io.ReadCloser!
+func (s *Store) GetNar(ctx context.Context, narURL nar.URL) (int64, io.ReadCloser, error) {
+	ctx, span := tracer.Start(
+		ctx,
+		"inline.GetNar",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("nar_url", narURL.String()),
+		),
+	)
+	defer span.End()
+
+	hash, compression, err := key(narURL)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	in, err := s.db.Ent().InlineNar.Query().
+		Where(
+			entinlinenar.HashEQ(hash),
+			entinlinenar.CompressionEQ(compression),
+		).
+		Only(ctx)
+	if err == nil {
+		span.SetAttributes(attribute.Bool("inline", true))
+
+		return int64(len(in.Data)), readCloser{Reader: bytes.NewReader(in.Data)}, nil
+	}
+
+	if !ent.IsNotFound(err) {
+		return 0, nil, fmt.Errorf("error querying the inline nar: %w", err)
+	}
+
+	return s.NarStore.GetNar(ctx, narURL)
+}
+
+// PutNar stores the nar in the database when its body is at most threshold
+// bytes and in the wrapped store otherwise. When size is unknown (<= 0), up to
+// threshold+1 bytes are buffered to decide. It returns storage.ErrAlreadyExists
+// if the nar is already stored in either place.
+func (s *Store) PutNar(ctx context.Context, narURL nar.URL, body io.Reader, size int64) (int64, error) {
+	ctx, span := tracer.Start(
+		ctx,
+		"inline.PutNar",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("nar_url", narURL.String()),
+			attribute.Int64("size", size),
+		),
+	)
+	defer span.End()
+
+	if size > s.threshold {
+		return s.putNext(ctx, narURL, body, size)
+	}
+
+	buf := make([]byte, s.threshold+1)
+
+	n, err := io.ReadFull(body, buf)
+	if err == nil {
+		// The body is larger than the threshold: hand it, including what was
+		// already buffered, to the wrapped store.
+		return s.putNext(ctx, narURL, io.MultiReader(bytes.NewReader(buf), body), size)
+	}
+
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, fmt.Errorf("error reading the nar: %w", err)
+	}
+
+	present, err := s.NarStore.StatNar(ctx, narURL)
+	if err != nil {
+		return 0, err
+	}
+
+	if present {
+		return 0, storage.ErrAlreadyExists
+	}
+
+	hash, compression, err := key(narURL)
+	if err != nil {
+		return 0, err
+	}
+
+	span.SetAttributes(attribute.Bool("inline", true))
+
+	err = s.db.Ent().InlineNar.Create().
+		SetHash(hash).
+		SetCompression(compression).
+		SetData(buf[:n]).
+		Exec(ctx)
+	if err != nil {
+		if database.IsDuplicateKeyError(err) {
+			return 0, storage.ErrAlreadyExists
+		}
+
+		return 0, fmt.Errorf("error storing the inline nar: %w", err)
+	}
+
+	return int64(n), nil
+}
+
+// DeleteNar deletes the nar from the database, or from the wrapped store when
+// it is not stored inline.
+func (s *Store) DeleteNar(ctx context.Context, narURL nar.URL) error {
+	ctx, span := tracer.Start(
+		ctx,
+		"inline.DeleteNar",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("nar_url", narURL.String()),
+		),
+	)
+	defer span.End()
+
+	hash, compression, err := key(narURL)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := s.db.Ent().InlineNar.Delete().
+		Where(
+			entinlinenar.HashEQ(hash),
+			entinlinenar.CompressionEQ(compression),
+		).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("error deleting the inline nar: %w", err)
+	}
+
+	if deleted > 0 {
+		return nil
+	}
+
+	return s.NarStore.DeleteNar(ctx, narURL)
+}
+
+// WalkNars calls fn for every inline nar, then walks the wrapped store.
+func (s *Store) WalkNars(ctx context.Context, fn func(narURL nar.URL) error) error {
+	ctx, span := tracer.Start(
+		ctx,
+		"inline.WalkNars",
+		trace.WithSpanKind(trace.SpanKindInternal),
+	)
+	defer span.End()
+
+	ins, err := s.db.Ent().InlineNar.Query().
+		Select(entinlinenar.FieldHash, entinlinenar.FieldCompression).
+		Order(ent.Asc(entinlinenar.FieldID)).
+		All(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing the inline nars: %w", err)
+	}
+
+	for _, in := range ins {
+		if err := fn(nar.URL{Hash: in.Hash, Compression: nar.CompressionTypeFromString(in.Compression)}); err != nil {
+			return err
+		}
+	}
+
+	return s.NarStore.WalkNars(ctx, fn)
+}
+
+// MigrateLegacyNars migrates the legacy nars of the wrapped store, if it can
+// hold any. Inline nars are never stored under a legacy path.
+func (s *Store) MigrateLegacyNars(ctx context.Context) (int, int, error) {
+	migrator, ok := s.NarStore.(storage.LegacyNarMigrator)
+	if !ok {
+		return 0, 0, nil
+	}
+
+	return migrator.MigrateLegacyNars(ctx)
+}
+
+// PresignNarURL presigns the nar through the wrapped store. An inline nar has
+// no backend URL and returns storage.ErrNotFound so the caller serves it
+// through GetNar instead.
+func (s *presigningStore) PresignNarURL(ctx context.Context, narURL nar.URL, expiry time.Duration) (*url.URL, error) {
+	inlined, err := s.isInline(ctx, narURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if inlined {
+		return nil, storage.ErrNotFound
+	}
+
+	return s.presigner.PresignNarURL(ctx, narURL, expiry)
+}
+
+func (s *Store) putNext(ctx context.Context, narURL nar.URL, body io.Reader, size int64) (int64, error) {
+	inlined, err := s.isInline(ctx, narURL)
+	if err != nil {
+		return 0, err
+	}
+
+	if inlined {
+		return 0, storage.ErrAlreadyExists
+	}
+
+	return s.NarStore.PutNar(ctx, narURL, body, size)
+}
+
+func (s *Store) isInline(ctx context.Context, narURL nar.URL) (bool, error) {
+	hash, compression, err := key(narURL)
+	if err != nil {
+		return false, err
+	}
+
+	exists, err := s.db.Ent().InlineNar.Query().
+		Where(
+			entinlinenar.HashEQ(hash),
+			entinlinenar.CompressionEQ(compression),
+		).
+		Exist(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error checking for the inline nar: %w", err)
+	}
+
+	return exists, nil
+}
+
+// key returns the columns identifying narURL: like the file-based stores, the
+// query is not part of the identity.
+func key(narURL nar.URL) (string, string, error) {
+	normalizedURL, err := narURL.Normalize()
+	if err != nil {
+		return "", "", fmt.Errorf("error normalizing the nar URL: %w", err)
+	}
+
+	return normalizedURL.Hash, normalizedURL.Compression.String(), nil
+}
+
+// readCloser serves an inline nar. It keeps bytes.Reader's io.ReaderAt and
+// io.Seeker so the nar can be read from an offset.
+type readCloser struct {
+	*bytes.Reader
+}
+
+func (readCloser) Close() error { return nil }
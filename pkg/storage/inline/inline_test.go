@@ -0,0 +1,172 @@
+package inline_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/database"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+	"github.com/kalbasit/ncps/pkg/storage/inline"
+	"github.com/kalbasit/ncps/pkg/storage/local"
+	"github.com/kalbasit/ncps/testhelper"
+)
+
+const (
+	narHash1 = "1s8p1kgdms8rmxkq24q51wc7zpn0aqcwgzvc473v9cii7z2qyxq0"
+	narHash2 = "123x3zvy8mfbxw8c9i7pqh2cmcya3g6w8y8yhldp5s39685dhsx4"
+)
+
+func newContext() context.Context {
+	return zerolog.New(io.Discard).WithContext(context.Background())
+}
+
+func setup(t *testing.T, threshold int64) (storage.NarStore, *local.Store, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	dbFile := filepath.Join(dir, "db.sqlite")
+	testhelper.CreateMigrateDatabase(t, dbFile)
+
+	db, err := database.Open("sqlite:"+dbFile, nil)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { db.Close() })
+
+	ls, err := local.New(newContext(), dir)
+	require.NoError(t, err)
+
+	s, err := inline.New(ls, db, threshold)
+	require.NoError(t, err)
+
+	return s, ls, dir
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	for _, threshold := range []int64{0, -1, inline.MaxThreshold + 1} {
+		_, err := inline.New(nil, nil, threshold)
+		require.ErrorIs(t, err, inline.ErrInvalidThreshold, "threshold %d", threshold)
+	}
+}
+
+func TestPutNar(t *testing.T) {
+	t.Parallel()
+
+	for _, size := range []int64{-1, 0, 10} {
+		t.Run(fmt.Sprintf("small nar is stored inline with size %d", size), func(t *testing.T) {
+			t.Parallel()
+
+			s, ls, dir := setup(t, 16)
+			narURL := nar.URL{Hash: narHash1, Compression: nar.CompressionTypeXz}
+
+			n, err := s.PutNar(newContext(), narURL, strings.NewReader("0123456789"), size)
+			require.NoError(t, err)
+			assert.Equal(t, int64(10), n)
+
+			assert.False(t, ls.HasNar(newContext(), narURL), "the file store must not have the nar")
+			assert.NoDirExists(t, filepath.Join(dir, "store", "nar", "1"))
+
+			present, err := s.StatNar(newContext(), narURL)
+			require.NoError(t, err)
+			assert.True(t, present)
+
+			gotSize, r, err := s.GetNar(newContext(), narURL)
+			require.NoError(t, err)
+
+			defer r.Close()
+
+			assert.Equal(t, int64(10), gotSize)
+
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, "0123456789", string(got))
+
+			_, ok := r.(io.ReaderAt)
+			assert.True(t, ok, "an inline nar must be readable at an offset")
+
+			_, err = s.PutNar(newContext(), narURL, strings.NewReader("0123456789"), size)
+			require.ErrorIs(t, err, storage.ErrAlreadyExists)
+		})
+	}
+
+	t.Run("large nar is stored in the wrapped store", func(t *testing.T) {
+		t.Parallel()
+
+		s, ls, _ := setup(t, 16)
+		narURL := nar.URL{Hash: narHash1, Compression: nar.CompressionTypeXz}
+		body := strings.Repeat("x", 17)
+
+		// Unknown size: the body is buffered past the threshold and streamed on.
+		n, err := s.PutNar(newContext(), narURL, strings.NewReader(body), -1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(17), n)
+
+		assert.True(t, ls.HasNar(newContext(), narURL))
+
+		_, r, err := s.GetNar(newContext(), narURL)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(got))
+
+		// Even if small, a nar already in the wrapped store is not duplicated inline.
+		_, err = s.PutNar(newContext(), narURL, strings.NewReader("small"), 5)
+		require.ErrorIs(t, err, storage.ErrAlreadyExists)
+	})
+}
+
+func TestDeleteNar(t *testing.T) {
+	t.Parallel()
+
+	s, _, _ := setup(t, 16)
+	smallURL := nar.URL{Hash: narHash1, Compression: nar.CompressionTypeNone}
+	largeURL := nar.URL{Hash: narHash2, Compression: nar.CompressionTypeNone}
+
+	_, err := s.PutNar(newContext(), smallURL, strings.NewReader("small"), 5)
+	require.NoError(t, err)
+
+	_, err = s.PutNar(newContext(), largeURL, strings.NewReader(strings.Repeat("x", 100)), 100)
+	require.NoError(t, err)
+
+	var walked []string
+
+	require.NoError(t, s.WalkNars(newContext(), func(narURL nar.URL) error {
+		walked = append(walked, narURL.Hash)
+
+		return nil
+	}))
+	assert.ElementsMatch(t, []string{narHash1, narHash2}, walked)
+
+	for _, narURL := range []nar.URL{smallURL, largeURL} {
+		require.NoError(t, s.DeleteNar(newContext(), narURL))
+		assert.False(t, s.HasNar(newContext(), narURL))
+
+		_, _, err := s.GetNar(newContext(), narURL)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+
+		require.ErrorIs(t, s.DeleteNar(newContext(), narURL), storage.ErrNotFound)
+	}
+}
+
+func TestPresign(t *testing.T) {
+	t.Parallel()
+
+	s, _, _ := setup(t, 16)
+
+	_, ok := s.(storage.NarPresigner)
+	assert.False(t, ok, "the local store cannot presign so neither can its inline wrapper")
+}
@@ -0,0 +1,372 @@
+// Package inventory keeps the set of the files of a store in memory so their
+// presence is answered without a stat, which is slow on network filesystems.
+// The set is persisted to a manifest file, loaded on startup instead of
+// walking the store, and rebuilt in the background by walking the store, the
+// changes made meanwhile applied over what the walk saw.
+package inventory
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// manifestHeader is the first line of a manifest file.
+	manifestHeader = "ncps-inventory-v1"
+
+	// saveInterval is how often the changed inventory is saved to its manifest.
+	saveInterval = time.Minute
+
+	dirMode  = 0o700
+	fileMode = 0o600
+)
+
+// ErrInvalidManifest is returned by Load when the manifest file is not one.
+var ErrInvalidManifest = errors.New("invalid inventory manifest")
+
+// WalkFunc walks the store and calls fn with the key of each of its files.
+type WalkFunc func(ctx context.Context, fn func(key string) error) error
+
+// Enabler is implemented by the stores that can keep an inventory.
+type Enabler interface {
+	// SetInventory configures the store to answer the presence of its files
+	// from an inventory, rebuilt every rebuildInterval (0 rebuilds it on
+	// startup only), until ctx is done.
+	SetInventory(ctx context.Context, rebuildInterval time.Duration)
+}
+
+// Inventory is the set of the keys of the files of a store.
+type Inventory struct {
+	manifestPath string
+	walk         WalkFunc
+
+	// rebuildMu serializes the rebuilds.
+	rebuildMu sync.Mutex
+
+	mu   sync.RWMutex
+	keys map[string]struct{}
+
+	// complete is set once a rebuild completes: a key missing from the
+	// inventory is then missing from the store.
+	complete bool
+
+	// dirty is set when the inventory changed since it was last saved.
+	dirty bool
+
+	// changes are the keys added (true) or removed (false) while a rebuild
+	// walks the store, nil when none is.
+	changes map[string]bool
+}
+
+// New returns an empty inventory saved to manifestPath and rebuilt with walk.
+func New(manifestPath string, walk WalkFunc) *Inventory {
+	return &Inventory{
+		manifestPath: manifestPath,
+		walk:         walk,
+		keys:         make(map[string]struct{}),
+	}
+}
+
+// Start loads the manifest, then rebuilds the inventory in the background, and
+// again every rebuildInterval if it is positive, saving it every minute it
+// changed and once more when ctx is done.
+func (inv *Inventory) Start(ctx context.Context, rebuildInterval time.Duration) {
+	log := zerolog.Ctx(ctx).With().Str("manifest", inv.manifestPath).Logger()
+
+	if err := inv.Load(); err != nil {
+		log.Warn().Err(err).Msg("error loading the inventory manifest, waiting for its rebuild")
+	}
+
+	go inv.run(log.WithContext(ctx), rebuildInterval)
+}
+
+// Lookup reports whether key is in the store. known is false when the
+// inventory cannot tell, a key missing from it before its first rebuild
+// completes, and the store must be asked.
+func (inv *Inventory) Lookup(key string) (present, known bool) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+
+	if _, ok := inv.keys[key]; ok {
+		return true, true
+	}
+
+	return false, inv.complete
+}
+
+// Add records that the file of key was stored.
+func (inv *Inventory) Add(key string) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	inv.keys[key] = struct{}{}
+	inv.dirty = true
+
+	if inv.changes != nil {
+		inv.changes[key] = true
+	}
+}
+
+// Remove records that the file of key was deleted.
+func (inv *Inventory) Remove(key string) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	delete(inv.keys, key)
+	inv.dirty = true
+
+	if inv.changes != nil {
+		inv.changes[key] = false
+	}
+}
+
+// Len returns the number of keys in the inventory.
+func (inv *Inventory) Len() int {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+
+	return len(inv.keys)
+}
+
+// Rebuild walks the store and replaces the inventory with the keys it saw, the
+// keys added or removed meanwhile applied over them.
+func (inv *Inventory) Rebuild(ctx context.Context) error {
+	inv.rebuildMu.Lock()
+	defer inv.rebuildMu.Unlock()
+
+	inv.mu.Lock()
+	inv.changes = make(map[string]bool)
+	inv.mu.Unlock()
+
+	seen := make(map[string]struct{})
+
+	err := inv.walk(ctx, func(key string) error {
+		seen[key] = struct{}{}
+
+		return ctx.Err()
+	})
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	changes := inv.changes
+	inv.changes = nil
+
+	if err != nil {
+		return fmt.Errorf("error walking the store: %w", err)
+	}
+
+	for key, present := range changes {
+		if present {
+			seen[key] = struct{}{}
+		} else {
+			delete(seen, key)
+		}
+	}
+
+	inv.keys = seen
+	inv.complete = true
+	inv.dirty = true
+
+	return nil
+}
+
+// Load adds the keys of the manifest to the inventory. A missing manifest is
+// not an error.
+func (inv *Inventory) Load() error {
+	f, err := os.Open(inv.manifestPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("error opening the manifest: %w", err)
+	}
+
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidManifest, err)
+	}
+
+	defer zr.Close()
+
+	scanner := bufio.NewScanner(zr)
+
+	if !scanner.Scan() || scanner.Text() != manifestHeader {
+		return fmt.Errorf("%w: missing the %q header", ErrInvalidManifest, manifestHeader)
+	}
+
+	keys := make(map[string]struct{})
+
+	for scanner.Scan() {
+		if key := scanner.Text(); key != "" {
+			keys[key] = struct{}{}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading the manifest: %w", err)
+	}
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	for key := range keys {
+		inv.keys[key] = struct{}{}
+	}
+
+	return nil
+}
+
+// Save writes the inventory to its manifest if it changed since it was last
+// saved. The manifest is replaced atomically.
+func (inv *Inventory) Save() error {
+	inv.mu.Lock()
+
+	if !inv.dirty {
+		inv.mu.Unlock()
+
+		return nil
+	}
+
+	keys := make([]string, 0, len(inv.keys))
+	for key := range inv.keys {
+		keys = append(keys, key)
+	}
+
+	inv.dirty = false
+
+	inv.mu.Unlock()
+
+	if err := inv.writeManifest(keys); err != nil {
+		inv.mu.Lock()
+		inv.dirty = true
+		inv.mu.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+func (inv *Inventory) writeManifest(keys []string) error {
+	dir := filepath.Dir(inv.manifestPath)
+
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return fmt.Errorf("error creating the directory %q: %w", dir, err)
+	}
+
+	f, err := os.CreateTemp(dir, filepath.Base(inv.manifestPath)+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating the temporary manifest: %w", err)
+	}
+
+	defer os.Remove(f.Name())
+
+	zw := gzip.NewWriter(f)
+	bw := bufio.NewWriter(zw)
+
+	_, err = bw.WriteString(manifestHeader + "\n")
+
+	for _, key := range keys {
+		if err != nil {
+			break
+		}
+
+		_, err = bw.WriteString(key + "\n")
+	}
+
+	if err == nil {
+		err = bw.Flush()
+	}
+
+	if err == nil {
+		err = zw.Close()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("error writing the manifest: %w", err)
+	}
+
+	if err := os.Chmod(f.Name(), fileMode); err != nil {
+		return fmt.Errorf("error setting the mode of the manifest: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), inv.manifestPath); err != nil {
+		return fmt.Errorf("error replacing the manifest: %w", err)
+	}
+
+	return nil
+}
+
+func (inv *Inventory) run(ctx context.Context, rebuildInterval time.Duration) {
+	log := zerolog.Ctx(ctx)
+
+	rebuild := func() {
+		start := time.Now()
+
+		if err := inv.Rebuild(ctx); err != nil {
+			if ctx.Err() == nil {
+				log.Error().Err(err).Msg("error rebuilding the inventory")
+			}
+
+			return
+		}
+
+		log.Info().
+			Int("files", inv.Len()).
+			Dur("elapsed", time.Since(start)).
+			Msg("rebuilt the inventory")
+	}
+
+	save := func() {
+		if err := inv.Save(); err != nil {
+			log.Error().Err(err).Msg("error saving the inventory manifest")
+		}
+	}
+
+	rebuild()
+	save()
+
+	saveTicker := time.NewTicker(saveInterval)
+	defer saveTicker.Stop()
+
+	var rebuildC <-chan time.Time
+
+	if rebuildInterval > 0 {
+		rebuildTicker := time.NewTicker(rebuildInterval)
+		defer rebuildTicker.Stop()
+
+		rebuildC = rebuildTicker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			save()
+
+			return
+		case <-saveTicker.C:
+			save()
+		case <-rebuildC:
+			rebuild()
+			save()
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package inventory_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/storage/inventory"
+)
+
+func TestInventory(t *testing.T) {
+	t.Parallel()
+
+	t.Run("a missing key is unknown until a rebuild completes", func(t *testing.T) {
+		t.Parallel()
+
+		inv := inventory.New(filepath.Join(t.TempDir(), "manifest.gz"), walkKeys("a", "b"))
+
+		inv.Add("c")
+
+		present, known := inv.Lookup("c")
+		assert.True(t, present)
+		assert.True(t, known)
+
+		_, known = inv.Lookup("a")
+		assert.False(t, known)
+
+		require.NoError(t, inv.Rebuild(context.Background()))
+
+		for key, want := range map[string]bool{"a": true, "b": true, "c": false, "d": false} {
+			present, known := inv.Lookup(key)
+			assert.True(t, known, key)
+			assert.Equal(t, want, present, key)
+		}
+	})
+
+	t.Run("the changes made during a rebuild are kept", func(t *testing.T) {
+		t.Parallel()
+
+		var inv *inventory.Inventory
+
+		inv = inventory.New(
+			filepath.Join(t.TempDir(), "manifest.gz"),
+			func(_ context.Context, fn func(key string) error) error {
+				// Stored and deleted while the store is walked.
+				inv.Add("added")
+				inv.Remove("a")
+
+				for _, key := range []string{"a", "b"} {
+					if err := fn(key); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		)
+
+		require.NoError(t, inv.Rebuild(context.Background()))
+
+		for key, want := range map[string]bool{"a": false, "b": true, "added": true} {
+			present, _ := inv.Lookup(key)
+			assert.Equal(t, want, present, key)
+		}
+	})
+
+	t.Run("the manifest round-trips", func(t *testing.T) {
+		t.Parallel()
+
+		manifestPath := filepath.Join(t.TempDir(), "inventory", "manifest.gz")
+
+		inv := inventory.New(manifestPath, walkKeys("a", "b"))
+		require.NoError(t, inv.Rebuild(context.Background()))
+		require.NoError(t, inv.Save())
+
+		loaded := inventory.New(manifestPath, walkKeys())
+		require.NoError(t, loaded.Load())
+
+		assert.Equal(t, 2, loaded.Len())
+
+		present, known := loaded.Lookup("a")
+		assert.True(t, present)
+		assert.True(t, known)
+
+		// The manifest does not replace the rebuild.
+		_, known = loaded.Lookup("c")
+		assert.False(t, known)
+	})
+
+	t.Run("a missing manifest loads nothing", func(t *testing.T) {
+		t.Parallel()
+
+		inv := inventory.New(filepath.Join(t.TempDir(), "manifest.gz"), walkKeys())
+
+		require.NoError(t, inv.Load())
+		assert.Zero(t, inv.Len())
+	})
+
+	t.Run("an invalid manifest is rejected", func(t *testing.T) {
+		t.Parallel()
+
+		manifestPath := filepath.Join(t.TempDir(), "manifest.gz")
+		require.NoError(t, os.WriteFile(manifestPath, []byte("not a manifest"), 0o600))
+
+		inv := inventory.New(manifestPath, walkKeys())
+
+		require.ErrorIs(t, inv.Load(), inventory.ErrInvalidManifest)
+	})
+}
+
+func walkKeys(keys ...string) inventory.WalkFunc {
+	return func(_ context.Context, fn func(key string) error) error {
+		for _, key := range keys {
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
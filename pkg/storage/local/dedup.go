@@ -0,0 +1,274 @@
+package local
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// narUsageTTL bounds how often the usage metrics walk the NARs.
+const narUsageTTL = time.Minute
+
+//nolint:gochecknoglobals
+var (
+	narLogicalSizeMetric  metric.Int64ObservableGauge
+	narPhysicalSizeMetric metric.Int64ObservableGauge
+)
+
+//nolint:gochecknoinits
+func init() {
+	meter := otel.Meter(otelPackageName)
+
+	var err error
+
+	narLogicalSizeMetric, err = meter.Int64ObservableGauge(
+		"ncps_storage_local_nar_logical_size_bytes",
+		metric.WithDescription("The sum of the sizes of the NAR files of the local store."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narPhysicalSizeMetric, err = meter.Int64ObservableGauge(
+		"ncps_storage_local_nar_physical_size_bytes",
+		metric.WithDescription("The disk space taken by the NAR files of the local store, counting hard links once."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// NarUsage is the disk usage of the NARs of the store.
+type NarUsage struct {
+	// Logical is the sum of the sizes of the NAR files.
+	Logical int64
+
+	// Physical is the space the NAR files take on disk, counting the files
+	// hard-linked together once.
+	Physical int64
+}
+
+// narDedup hard-links the NARs of identical content. See SetNarDedup.
+type narDedup struct {
+	enabled bool
+
+	metricsOnce sync.Once
+
+	usageMu sync.Mutex
+	usage   NarUsage
+	usageAt time.Time
+}
+
+// SetNarDedup configures the store to hard-link a NAR whose content is already
+// stored under another URL instead of storing it twice, as when the narinfos
+// of several upstreams name the same NAR differently. Two NARs are identical
+// when their FileHash, the sha256 of the file, is. The content is indexed under
+// store/nar-dedup; only the NARs stored while it is enabled are deduplicated.
+// Enabling it also reports the logical and physical usage of the NARs as
+// metrics.
+func (s *Store) SetNarDedup(enabled bool) {
+	s.dedup.enabled = enabled
+
+	if enabled {
+		s.dedup.metricsOnce.Do(s.registerNarUsageMetrics)
+	}
+}
+
+// NarUsage walks the NARs of the store and returns their disk usage.
+func (s *Store) NarUsage(ctx context.Context) (NarUsage, error) {
+	_, span := tracer.Start(ctx, "local.NarUsage")
+	defer span.End()
+
+	type fileID struct{ dev, ino uint64 }
+
+	var usage NarUsage
+
+	seen := make(map[fileID]struct{})
+
+	err := filepath.WalkDir(s.storeNarPath(), func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// Deleted while walking.
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		usage.Logical += info.Size()
+
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			//nolint:unconvert,gosec // G115: the types of Dev and Ino depend on the platform
+			id := fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+			if _, ok := seen[id]; ok {
+				return nil
+			}
+
+			seen[id] = struct{}{}
+		}
+
+		usage.Physical += info.Size()
+
+		return nil
+	})
+	if err != nil {
+		return NarUsage{}, fmt.Errorf("error walking the nars: %w", err)
+	}
+
+	return usage, nil
+}
+
+func (s *Store) registerNarUsageMetrics() {
+	meter := otel.Meter(otelPackageName)
+
+	_, err := meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		s.dedup.usageMu.Lock()
+		defer s.dedup.usageMu.Unlock()
+
+		if time.Since(s.dedup.usageAt) > narUsageTTL {
+			usage, err := s.NarUsage(ctx)
+			if err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to get the nar usage for metrics")
+
+				return nil
+			}
+
+			s.dedup.usage = usage
+			s.dedup.usageAt = time.Now()
+		}
+
+		o.ObserveInt64(narLogicalSizeMetric, s.dedup.usage.Logical)
+		o.ObserveInt64(narPhysicalSizeMetric, s.dedup.usage.Physical)
+
+		return nil
+	}, narLogicalSizeMetric, narPhysicalSizeMetric)
+	if err != nil {
+		zerolog.Ctx(context.Background()).Warn().Err(err).Msg("failed to register the nar usage metrics")
+	}
+}
+
+// putNarDedup moves the NAR written to tmpPath to narPath, or hard-links
+// narPath to the stored NAR of the same sha256 and discards tmpPath. Either
+// way it records the sha256 of narPath so DeleteNar can release its content.
+func (s *Store) putNarDedup(ctx context.Context, tmpPath, narPath, tfp, sum string) error {
+	contentPath := s.dedupContentPath(sum)
+
+	err := os.Link(contentPath, narPath)
+
+	switch {
+	case err == nil, errors.Is(err, fs.ErrExist):
+		os.Remove(tmpPath)
+
+	case errors.Is(err, fs.ErrNotExist):
+		// The first NAR of this content.
+		if err := os.Chmod(tmpPath, fileMode); err != nil {
+			return fmt.Errorf("error setting the mode of the nar: %w", err)
+		}
+
+		if err := os.Rename(tmpPath, narPath); err != nil {
+			return fmt.Errorf("error creating the nar file %q: %w", narPath, err)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(contentPath), dirMode); err != nil {
+			return fmt.Errorf("error creating the directories for %q: %w", contentPath, err)
+		}
+
+		// The content may have been indexed concurrently, by a NAR that then
+		// stays a copy of its own.
+		if err := os.Link(narPath, contentPath); err != nil && !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("error indexing the nar %q: %w", narPath, err)
+		}
+
+	default:
+		os.Remove(tmpPath)
+
+		return fmt.Errorf("error linking the nar %q: %w", narPath, err)
+	}
+
+	refPath := s.dedupRefPath(tfp)
+
+	if err := os.MkdirAll(filepath.Dir(refPath), dirMode); err != nil {
+		return fmt.Errorf("error creating the directories for %q: %w", refPath, err)
+	}
+
+	if err := os.WriteFile(refPath, []byte(sum), 0o600); err != nil {
+		return fmt.Errorf("error recording the content of the nar %q: %w", narPath, err)
+	}
+
+	zerolog.Ctx(ctx).Debug().Str("nar_path", narPath).Str("sha256", sum).Msg("stored the nar deduplicated")
+
+	return nil
+}
+
+// releaseNarDedup forgets the content of the deleted NAR of tfp, and removes
+// the content from the index once no NAR links to it.
+func (s *Store) releaseNarDedup(ctx context.Context, tfp string) {
+	refPath := s.dedupRefPath(tfp)
+
+	sum, err := os.ReadFile(refPath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("ref_path", refPath).Msg("failed to read the content of a deleted nar")
+		}
+
+		return
+	}
+
+	os.Remove(refPath)
+	removeEmptyParentDirs(ctx, refPath, s.storeNarDedupPath())
+
+	hexSum := strings.TrimSpace(string(sum))
+	if len(hexSum) != sha256.Size*2 {
+		zerolog.Ctx(ctx).Warn().Str("ref_path", refPath).Msg("invalid content of a deleted nar")
+
+		return
+	}
+
+	contentPath := s.dedupContentPath(hexSum)
+
+	info, err := os.Stat(contentPath)
+	if err != nil {
+		return
+	}
+
+	// Only the index links to the content.
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Nlink == 1 {
+		os.Remove(contentPath)
+		removeEmptyParentDirs(ctx, contentPath, s.storeNarDedupPath())
+	}
+}
+
+func (s *Store) storeNarDedupPath() string { return filepath.Join(s.storePath(), "nar-dedup") }
+
+// dedupContentPath is the index entry of the NAR content of sha256 sum.
+func (s *Store) dedupContentPath(sum string) string {
+	return filepath.Join(s.storeNarDedupPath(), "content", sum[:2], sum)
+}
+
+// dedupRefPath records the sha256 of the NAR at the file path tfp.
+func (s *Store) dedupRefPath(tfp string) string {
+	return filepath.Join(s.storeNarDedupPath(), "refs", tfp+".sha256")
+}
@@ -0,0 +1,83 @@
+package local_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage/local"
+)
+
+func TestNarDedup(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	s, err := local.New(newContext(), dir)
+	require.NoError(t, err)
+
+	s.SetNarDedup(true)
+
+	const content = "the same nar content"
+
+	// The same NAR named by two upstreams, and a distinct one.
+	url1 := nar.URL{Hash: "1s8p1kgdms8rmxkq24q51wc7zpn0aqcwgzvc473v9cii7z2qyxq0", Compression: nar.CompressionTypeXz}
+	url2 := nar.URL{Hash: "0mw6qwsrz35cck0wnjgmfnjzwnjbspsyihnfkng38kxghdc9k9zd", Compression: nar.CompressionTypeXz}
+	url3 := nar.URL{Hash: "1lid9xrpirkzcpqsxfq02qwiq0yd70chfl860wzsqd1739ih0nri", Compression: nar.CompressionTypeXz}
+
+	for _, u := range []nar.URL{url1, url2} {
+		_, err := s.PutNar(newContext(), u, strings.NewReader(content), int64(len(content)))
+		require.NoError(t, err)
+	}
+
+	_, err = s.PutNar(newContext(), url3, strings.NewReader("another nar"), 11)
+	require.NoError(t, err)
+
+	narPath := func(u nar.URL) string {
+		tfp, err := u.ToFilePath()
+		require.NoError(t, err)
+
+		return filepath.Join(dir, "store", "nar", tfp)
+	}
+
+	info1, err := os.Stat(narPath(url1))
+	require.NoError(t, err)
+
+	info2, err := os.Stat(narPath(url2))
+	require.NoError(t, err)
+
+	info3, err := os.Stat(narPath(url3))
+	require.NoError(t, err)
+
+	assert.True(t, os.SameFile(info1, info2), "identical NARs are hard-linked")
+	assert.False(t, os.SameFile(info1, info3), "distinct NARs are not")
+
+	usage, err := s.NarUsage(newContext())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2*len(content)+11), usage.Logical)
+	assert.Equal(t, int64(len(content)+11), usage.Physical)
+
+	// Deleting one of the links keeps the other one.
+	require.NoError(t, s.DeleteNar(newContext(), url1))
+
+	_, r, err := s.GetNar(newContext(), url2)
+	require.NoError(t, err)
+
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, content, string(b))
+
+	// Deleting every NAR empties the content index.
+	require.NoError(t, s.DeleteNar(newContext(), url2))
+	require.NoError(t, s.DeleteNar(newContext(), url3))
+
+	_, err = os.Stat(filepath.Join(dir, "store", "nar-dedup"))
+	assert.True(t, os.IsNotExist(err), "the content index is cleaned up")
+}
@@ -0,0 +1,59 @@
+package local
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage/inventory"
+)
+
+// SetInventory configures the store to answer StatNar and HasNar from an
+// inventory of its NARs instead of a stat. The inventory is saved to
+// store/inventory/nar.gz, loaded from it on startup and rebuilt in the
+// background by walking the NARs, on startup and every rebuildInterval if it
+// is positive. Until the first rebuild completes a NAR missing from the
+// inventory is looked up on disk. A NAR stored or deleted by another instance
+// sharing the store is only seen at the next rebuild.
+func (s *Store) SetInventory(ctx context.Context, rebuildInterval time.Duration) {
+	s.inventory = inventory.New(
+		filepath.Join(s.storeInventoryPath(), "nar.gz"),
+		func(ctx context.Context, fn func(key string) error) error {
+			return s.WalkNars(ctx, func(narURL nar.URL) error {
+				key, err := narURL.ToFilePath()
+				if err != nil {
+					return nil //nolint:nilerr // skip files that don't match NAR URL pattern
+				}
+
+				return fn(key)
+			})
+		},
+	)
+
+	s.inventory.Start(ctx, rebuildInterval)
+}
+
+// lookupInventory reports whether the NAR at tfp is stored, known is false
+// when the store has no inventory or it cannot tell.
+func (s *Store) lookupInventory(tfp string) (present, known bool) {
+	if s.inventory == nil {
+		return false, false
+	}
+
+	return s.inventory.Lookup(tfp)
+}
+
+// addToInventory records that the NAR at tfp is stored.
+func (s *Store) addToInventory(tfp string) {
+	if s.inventory != nil {
+		s.inventory.Add(tfp)
+	}
+}
+
+// removeFromInventory records that the NAR at tfp is deleted.
+func (s *Store) removeFromInventory(tfp string) {
+	if s.inventory != nil {
+		s.inventory.Remove(tfp)
+	}
+}
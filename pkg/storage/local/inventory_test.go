@@ -0,0 +1,59 @@
+package local_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage/local"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+func TestSetInventory(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	ctx := newContext()
+
+	s, err := local.New(ctx, dir)
+	require.NoError(t, err)
+
+	stored := nar.URL{Hash: testdata.Nar1.NarHash, Compression: testdata.Nar1.NarCompression}
+	_, err = s.PutNar(ctx, stored, strings.NewReader(testdata.Nar1.NarText), 0)
+	require.NoError(t, err)
+
+	s.SetInventory(ctx, 0)
+
+	manifestPath := filepath.Join(dir, "store", "inventory", "nar.gz")
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(manifestPath)
+
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "the inventory is rebuilt and saved")
+
+	// Once rebuilt, the presence is answered from memory.
+	require.NoError(t, os.Remove(filepath.Join(dir, "store", "nar", testdata.Nar1.NarPath)))
+	assert.True(t, s.HasNar(ctx, stored))
+
+	missing := nar.URL{Hash: testdata.Nar2.NarHash, Compression: testdata.Nar2.NarCompression}
+
+	present, err := s.StatNar(ctx, missing)
+	require.NoError(t, err)
+	assert.False(t, present)
+
+	// A NAR stored or deleted through the store updates it.
+	_, err = s.PutNar(ctx, missing, strings.NewReader(testdata.Nar2.NarText), 0)
+	require.NoError(t, err)
+	assert.True(t, s.HasNar(ctx, missing))
+
+	require.NoError(t, s.DeleteNar(ctx, missing))
+	assert.False(t, s.HasNar(ctx, missing))
+}
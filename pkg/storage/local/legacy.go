@@ -0,0 +1,99 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+)
+
+// MigrateLegacyNars moves the nars stored by older versions of ncps, named
+// after a nix-serve-style prefixed hash (<narinfo-hash>-<hash>.nar) or stored
+// outside of their shard, to the path the store reads them from. A legacy nar
+// whose path is already taken is a duplicate and is removed. It is safe to run
+// while the store is in use.
+func (s *Store) MigrateLegacyNars(ctx context.Context) (int, int, error) {
+	ctx, span := tracer.Start(ctx, "local.MigrateLegacyNars")
+	defer span.End()
+
+	var moved, removed int
+
+	root := s.storeNarPath()
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Removed while walking.
+				return nil
+			}
+
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		narURL, err := nar.ParseURL(d.Name())
+		if err != nil {
+			return nil //nolint:nilerr // skip files that don't match NAR URL pattern
+		}
+
+		normalizedURL, err := narURL.Normalize()
+		if err != nil {
+			return nil //nolint:nilerr // skip files that don't match NAR URL pattern
+		}
+
+		tfp, err := normalizedURL.ToFilePath()
+		if err != nil {
+			return nil //nolint:nilerr // skip files that don't match NAR URL pattern
+		}
+
+		narPath := filepath.Join(root, tfp)
+		if path == narPath {
+			return nil
+		}
+
+		log := zerolog.Ctx(ctx).With().Str("legacy_path", path).Str("nar_path", narPath).Logger()
+
+		if _, err := os.Stat(narPath); err == nil {
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("error removing the duplicate legacy nar %q: %w", path, err)
+			}
+
+			log.Info().Msg("removed a legacy nar already stored at its current path")
+
+			removed++
+		} else {
+			if err := os.MkdirAll(filepath.Dir(narPath), dirMode); err != nil {
+				return fmt.Errorf("error creating the directories for %q: %w", narPath, err)
+			}
+
+			// Another instance may have moved it already.
+			if err := os.Rename(path, narPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("error moving the legacy nar %q: %w", path, err)
+			}
+
+			log.Info().Msg("moved a legacy nar to its current path")
+
+			moved++
+		}
+
+		s.addToInventory(tfp)
+
+		removeEmptyParentDirs(ctx, path, root)
+
+		return nil
+	})
+	if err != nil {
+		return moved, removed, fmt.Errorf("error walking the nars: %w", err)
+	}
+
+	return moved, removed, nil
+}
@@ -0,0 +1,76 @@
+package local_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage/local"
+)
+
+func TestMigrateLegacyNars(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	s, err := local.New(newContext(), dir)
+	require.NoError(t, err)
+
+	narDir := filepath.Join(dir, "store", "nar")
+
+	writeLegacy := func(rel, content string) {
+		path := filepath.Join(narDir, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	const (
+		prefixedHash  = "1s8p1kgdms8rmxkq24q51wc7zpn0aqcwgzvc473v9cii7z2qyxq0"
+		unshardedHash = "0mw6qwsrz35cck0wnjgmfnjzwnjbspsyihnfkng38kxghdc9k9zd"
+		storedHash    = "1lid9xrpirkzcpqsxfq02qwiq0yd70chfl860wzsqd1739ih0nri"
+	)
+
+	// A nix-serve-style prefixed nar, an unsharded one, and an unsharded
+	// duplicate of a nar already at its current path.
+	writeLegacy("1/1s/c12lxpykv921zk2ya1iaqp16n1cvqr0a-"+prefixedHash+".nar.xz", "prefixed")
+	writeLegacy(unshardedHash+".nar.xz", "unsharded")
+	writeLegacy(storedHash+".nar.xz", "duplicate")
+
+	storedURL := nar.URL{Hash: storedHash, Compression: nar.CompressionTypeXz}
+	_, err = s.PutNar(newContext(), storedURL, strings.NewReader("stored"), 6)
+	require.NoError(t, err)
+
+	moved, removed, err := s.MigrateLegacyNars(newContext())
+	require.NoError(t, err)
+	assert.Equal(t, 2, moved)
+	assert.Equal(t, 1, removed)
+
+	for hash, content := range map[string]string{
+		prefixedHash:  "prefixed",
+		unshardedHash: "unsharded",
+		storedHash:    "stored",
+	} {
+		_, r, err := s.GetNar(newContext(), nar.URL{Hash: hash, Compression: nar.CompressionTypeXz})
+		require.NoError(t, err, hash)
+
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		assert.Equal(t, content, string(b), hash)
+	}
+
+	_, err = os.Stat(filepath.Join(narDir, unshardedHash+".nar.xz"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// Once migrated, nothing is left to migrate.
+	moved, removed, err = s.MigrateLegacyNars(newContext())
+	require.NoError(t, err)
+	assert.Zero(t, moved)
+	assert.Zero(t, removed)
+}
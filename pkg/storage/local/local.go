@@ -2,6 +2,8 @@ package local
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -22,6 +24,7 @@ import (
 	"github.com/kalbasit/ncps/pkg/nar"
 	"github.com/kalbasit/ncps/pkg/narinfo"
 	"github.com/kalbasit/ncps/pkg/storage"
+	"github.com/kalbasit/ncps/pkg/storage/inventory"
 )
 
 const (
@@ -55,6 +58,12 @@ func init() {
 // Store represents a local store and implements storage.Store.
 type Store struct {
 	path string
+
+	dedup narDedup
+
+	// inventory is the inventory of the NARs, nil unless SetInventory is
+	// called.
+	inventory *inventory.Inventory
 }
 
 func New(ctx context.Context, path string) (*Store, error) {
@@ -364,6 +373,12 @@ func (s *Store) StatNar(ctx context.Context, narURL nar.URL) (bool, error) {
 	)
 	defer span.End()
 
+	if present, known := s.lookupInventory(tfp); known {
+		span.SetAttributes(attribute.Bool("inventory", true))
+
+		return present, nil
+	}
+
 	if _, err := os.Stat(narPath); err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
@@ -374,6 +389,8 @@ func (s *Store) StatNar(ctx context.Context, narURL nar.URL) (bool, error) {
 		return false, fmt.Errorf("error stating the nar file: %w", err)
 	}
 
+	s.addToInventory(tfp)
+
 	return true, nil
 }
 
@@ -407,6 +424,9 @@ func (s *Store) GetNar(ctx context.Context, narURL nar.URL) (int64, io.ReadClose
 	info, err := os.Stat(narPath)
 	if err != nil {
 		if os.IsNotExist(err) {
+			// Deleted behind the inventory's back, e.g. by another instance.
+			s.removeFromInventory(tfp)
+
 			return 0, nil, storage.ErrNotFound
 		}
 
@@ -449,6 +469,8 @@ func (s *Store) PutNar(ctx context.Context, narURL nar.URL, body io.Reader, _ in
 	defer span.End()
 
 	if _, err := os.Stat(narPath); err == nil {
+		s.addToInventory(tfp)
+
 		return 0, storage.ErrAlreadyExists
 	}
 
@@ -466,7 +488,9 @@ func (s *Store) PutNar(ctx context.Context, narURL nar.URL, body io.Reader, _ in
 		return 0, fmt.Errorf("error creating the temporary directory: %w", err)
 	}
 
-	written, err := io.Copy(f, body)
+	h := sha256.New()
+
+	written, err := io.Copy(io.MultiWriter(f, h), body)
 	if err != nil {
 		f.Close()
 		os.Remove(f.Name())
@@ -478,10 +502,22 @@ func (s *Store) PutNar(ctx context.Context, narURL nar.URL, body io.Reader, _ in
 		return 0, fmt.Errorf("error closing the temporary file: %w", err)
 	}
 
+	if s.dedup.enabled {
+		if err := s.putNarDedup(ctx, f.Name(), narPath, tfp, hex.EncodeToString(h.Sum(nil))); err != nil {
+			return written, err
+		}
+
+		s.addToInventory(tfp)
+
+		return written, nil
+	}
+
 	if err := os.Rename(f.Name(), narPath); err != nil {
 		return 0, fmt.Errorf("error creating the nar file %q: %w", narPath, err)
 	}
 
+	s.addToInventory(tfp)
+
 	return written, os.Chmod(narPath, fileMode)
 }
 
@@ -593,15 +629,21 @@ func (s *Store) DeleteNar(ctx context.Context, narURL nar.URL) error {
 
 	if err := os.Remove(narPath); err != nil {
 		if os.IsNotExist(err) {
+			s.removeFromInventory(tfp)
+
 			return storage.ErrNotFound
 		}
 
 		return fmt.Errorf("error deleting nar %q from store: %w", narPath, err)
 	}
 
+	s.removeFromInventory(tfp)
+
 	// Best-effort cleanup of empty parent directories
 	removeEmptyParentDirs(ctx, narPath, s.storeNarPath())
 
+	s.releaseNarDedup(ctx, tfp)
+
 	return nil
 }
 
@@ -705,6 +747,8 @@ func (s *Store) storeNarPath() string     { return filepath.Join(s.storePath(),
 func (s *Store) storeTMPPath() string     { return filepath.Join(s.storePath(), "tmp") }
 func (s *Store) storeStagingPath() string { return filepath.Join(s.storePath(), "staging") }
 
+func (s *Store) storeInventoryPath() string { return filepath.Join(s.storePath(), "inventory") }
+
 // stagingPartDir is the directory holding all part-objects for one NAR hash.
 func (s *Store) stagingPartDir(hash string) string {
 	return filepath.Join(s.storeStagingPath(), hash)
@@ -0,0 +1,728 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	// mediaTypeManifest is the media type of the OCI image manifests the
+	// artifacts are pushed as.
+	mediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+
+	// mediaTypeEmpty is the media type of the empty config of the artifacts.
+	mediaTypeEmpty = "application/vnd.oci.empty.v1+json"
+
+	// emptyDigest is the digest of the empty config, the 2 bytes "{}".
+	emptyDigest = "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+
+	// tagsPageSize is the number of tags requested per page of the tag list.
+	tagsPageSize = 1000
+)
+
+var (
+	// ErrUnexpectedStatus is returned when the registry answers a request with
+	// an unexpected status.
+	ErrUnexpectedStatus = errors.New("unexpected response from the registry")
+
+	// ErrUnauthorized is returned when the registry refuses the credentials.
+	ErrUnauthorized = errors.New("unauthorized by the registry")
+
+	// errManifestNotFound is returned by the client for a missing manifest or
+	// blob, and mapped to the not found error of the store.
+	errManifestNotFound = errors.New("manifest not found")
+
+	//nolint:gochecknoglobals
+	emptyConfig = []byte("{}")
+)
+
+// descriptor describes the content of a blob.
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	Data      []byte `json:"data,omitempty"`
+}
+
+// manifest is an OCI image manifest of an artifact with a single layer.
+type manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        descriptor        `json:"config"`
+	Layers        []descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// client speaks the OCI distribution API to a single repository of a
+// registry.
+type client struct {
+	httpClient *http.Client
+
+	// base is the URL of the API of the registry, e.g.
+	// https://registry.example.com/v2/.
+	base *url.URL
+
+	// name is the name of the repository.
+	name string
+
+	username, password string
+
+	// mu protects authorization.
+	mu sync.RWMutex
+
+	// authorization is the Authorization header of the requests, set once the
+	// registry challenged a request.
+	authorization string
+}
+
+func newClient(base *url.URL, name, username, password string, transport http.RoundTripper) *client {
+	return &client{
+		httpClient: &http.Client{Transport: transport},
+		base:       base,
+		name:       name,
+		username:   username,
+		password:   password,
+	}
+}
+
+// url returns the URL of the API endpoint p of the repository, e.g.
+// manifests/<tag>.
+func (c *client) url(p string) string {
+	return c.base.JoinPath(c.name, p).String()
+}
+
+// ping checks that the registry is reachable with the credentials.
+func (c *client) ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+
+	if err := expectStatus(resp, http.StatusOK); err != nil {
+		return err
+	}
+
+	drain(resp)
+
+	return nil
+}
+
+// do sends the request, authenticating and retrying it once if the registry
+// challenges it.
+func (c *client) do(req *http.Request) (*http.Response, error) {
+	c.mu.RLock()
+	authorization := c.authorization
+	c.mu.RUnlock()
+
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+
+	drain(resp)
+
+	if err := c.authenticate(req.Context(), challenge); err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("%w: %s %s", ErrUnauthorized, req.Method, req.URL.Redacted())
+		}
+
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mu.RLock()
+	retry.Header.Set("Authorization", c.authorization)
+	c.mu.RUnlock()
+
+	resp, err = c.httpClient.Do(retry)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		drain(resp)
+
+		return nil, fmt.Errorf("%w: %s %s", ErrUnauthorized, req.Method, req.URL.Redacted())
+	}
+
+	return resp, nil
+}
+
+// authenticate answers the WWW-Authenticate challenge of the registry with
+// the Basic credentials or a Bearer token granting access to the repository.
+func (c *client) authenticate(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.username == "" {
+			return fmt.Errorf("%w: the registry requires credentials", ErrUnauthorized)
+		}
+
+		c.mu.Lock()
+		c.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(c.username+":"+c.password))
+		c.mu.Unlock()
+
+		return nil
+	case "bearer":
+		token, err := c.fetchToken(ctx, params)
+		if err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		c.authorization = "Bearer " + token
+		c.mu.Unlock()
+
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported challenge %q", ErrUnauthorized, challenge)
+	}
+}
+
+// fetchToken fetches a token to pull, push and delete in the repository from
+// the realm of the Bearer challenge.
+func (c *client) fetchToken(ctx context.Context, params map[string]string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("%w: invalid token realm %q", ErrUnauthorized, params["realm"])
+	}
+
+	q := realm.Query()
+	if service := params["service"]; service != "" {
+		q.Set("service", service)
+	}
+
+	q.Set("scope", "repository:"+c.name+":pull,push,delete")
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error fetching a token from the registry: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: fetching a token: %s", ErrUnauthorized, resp.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("error decoding the token of the registry: %w", err)
+	}
+
+	if body.Token != "" {
+		return body.Token, nil
+	}
+
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+
+	return "", fmt.Errorf("%w: the token response carries no token", ErrUnauthorized)
+}
+
+// parseChallenge parses a WWW-Authenticate challenge, e.g.
+// Bearer realm="https://auth.example.com/token",service="registry".
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := make(map[string]string)
+
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+
+				break
+			}
+
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+
+		rest = strings.TrimLeft(rest, ", ")
+	}
+
+	return scheme, params
+}
+
+// headManifest returns the digest of the manifest tagged tag, or
+// errManifestNotFound.
+func (c *client) headManifest(ctx context.Context, tag string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.url("manifests/"+tag), nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Accept", mediaTypeManifest)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+
+	drain(resp)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Header.Get("Docker-Content-Digest"), nil
+	case http.StatusNotFound:
+		return "", errManifestNotFound
+	default:
+		return "", unexpectedStatus(resp)
+	}
+}
+
+// getManifest returns the manifest tagged tag, and its digest.
+func (c *client) getManifest(ctx context.Context, tag string) (*manifest, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("manifests/"+tag), nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	req.Header.Set("Accept", mediaTypeManifest)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", errManifestNotFound
+	}
+
+	if err := expectStatus(resp, http.StatusOK); err != nil {
+		return nil, "", err
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading the manifest %q: %w", tag, err)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, "", fmt.Errorf("error decoding the manifest %q: %w", tag, err)
+	}
+
+	return &m, digestOf(raw), nil
+}
+
+// putManifest pushes the manifest m tagged tag.
+func (c *client) putManifest(ctx context.Context, tag string, m *manifest) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("error encoding the manifest %q: %w", tag, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.url("manifests/"+tag), bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", mediaTypeManifest)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+
+	return expectStatus(resp, http.StatusCreated)
+}
+
+// deleteManifest deletes the manifest tagged tag, or returns
+// errManifestNotFound. The registry garbage collection reclaims its blobs.
+func (c *client) deleteManifest(ctx context.Context, tag string) error {
+	digest, err := c.headManifest(ctx, tag)
+	if err != nil {
+		return err
+	}
+
+	if digest == "" {
+		// The registry does not tell the digest on HEAD.
+		if _, digest, err = c.getManifest(ctx, tag); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.url("manifests/"+digest), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		drain(resp)
+
+		return errManifestNotFound
+	}
+
+	return expectStatus(resp, http.StatusAccepted, http.StatusOK)
+}
+
+// getBlob returns the content of the blob digest, following the redirects of
+// the registry to its storage.
+func (c *client) getBlob(ctx context.Context, digest string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("blobs/"+digest), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		drain(resp)
+
+		return nil, errManifestNotFound
+	}
+
+	if err := expectStatus(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// hasBlob reports whether the registry has the blob digest.
+func (c *client) hasBlob(ctx context.Context, digest string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.url("blobs/"+digest), nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return false, err
+	}
+
+	drain(resp)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, unexpectedStatus(resp)
+	}
+}
+
+// pushBlobBytes pushes data as a blob in a single request after the upload
+// is started, and returns its digest.
+func (c *client) pushBlobBytes(ctx context.Context, data []byte) (string, error) {
+	digest := digestOf(data)
+
+	location, err := c.startUpload(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, withDigest(location, digest), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+
+	if err := expectStatus(resp, http.StatusCreated); err != nil {
+		return "", err
+	}
+
+	return digest, nil
+}
+
+// pushBlob streams body as a blob, hashing it on the way, and returns its
+// digest and size. If size > 0 it is the known size of body, otherwise body
+// is streamed to EOF.
+func (c *client) pushBlob(ctx context.Context, body io.Reader, size int64) (string, int64, error) {
+	location, err := c.startUpload(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+
+	h := sha256.New()
+	cr := &countingReader{r: io.TeeReader(body, h)}
+
+	// The body cannot be replayed, so the request is not retried: the
+	// credentials were just checked by starting the upload.
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, location, io.NopCloser(cr))
+	if err != nil {
+		return "", 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	if size > 0 {
+		req.ContentLength = size
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if err := expectStatus(resp, http.StatusAccepted, http.StatusNoContent); err != nil {
+		return "", 0, err
+	}
+
+	location, err = resolveLocation(resp)
+	if err != nil {
+		return "", 0, err
+	}
+
+	digest := "sha256:" + hex.EncodeToString(h.Sum(nil))
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodPut, withDigest(location, digest), http.NoBody)
+	if err != nil {
+		return "", 0, err
+	}
+
+	resp, err = c.do(req)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if err := expectStatus(resp, http.StatusCreated); err != nil {
+		return "", 0, err
+	}
+
+	return digest, cr.n, nil
+}
+
+// startUpload starts a blob upload and returns its location.
+func (c *client) startUpload(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("blobs/uploads/"), http.NoBody)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+
+	if err := expectStatus(resp, http.StatusAccepted); err != nil {
+		return "", err
+	}
+
+	return resolveLocation(resp)
+}
+
+// listTags calls fn for each tag of the repository, page by page. A
+// repository that does not exist yet has no tags.
+func (c *client) listTags(ctx context.Context, fn func(tag string) error) error {
+	next := c.url("tags/list") + fmt.Sprintf("?n=%d", tagsPageSize)
+
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := c.do(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusNotFound {
+			drain(resp)
+
+			return nil
+		}
+
+		if err := expectStatus(resp, http.StatusOK); err != nil {
+			return err
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&page)
+
+		drain(resp)
+
+		if err != nil {
+			return fmt.Errorf("error decoding the tag list: %w", err)
+		}
+
+		for _, tag := range page.Tags {
+			if err := fn(tag); err != nil {
+				return err
+			}
+		}
+
+		next = nextLink(resp)
+	}
+
+	return nil
+}
+
+// nextLink returns the URL of the next page of a paginated response, or the
+// empty string on the last page.
+func nextLink(resp *http.Response) string {
+	for _, link := range resp.Header.Values("Link") {
+		target, params, ok := strings.Cut(link, ";")
+		if !ok || !strings.Contains(strings.ReplaceAll(params, " ", ""), `rel="next"`) {
+			continue
+		}
+
+		u, err := url.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return ""
+		}
+
+		return resp.Request.URL.ResolveReference(u).String()
+	}
+
+	return ""
+}
+
+// resolveLocation returns the absolute URL of the Location header of resp.
+func resolveLocation(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("%w: %s %s: no upload location", ErrUnexpectedStatus,
+			resp.Request.Method, resp.Request.URL.Redacted())
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("error parsing the upload location %q: %w", location, err)
+	}
+
+	return resp.Request.URL.ResolveReference(u).String(), nil
+}
+
+// withDigest adds the digest query parameter completing an upload to the
+// upload location.
+func withDigest(location, digest string) string {
+	sep := "?"
+	if strings.Contains(location, "?") {
+		sep = "&"
+	}
+
+	return location + sep + "digest=" + url.QueryEscape(digest)
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// expectStatus closes the body of resp and returns an error unless its status
+// is one of statuses. The body of a successful response is kept open.
+func expectStatus(resp *http.Response, statuses ...int) error {
+	for _, status := range statuses {
+		if resp.StatusCode == status {
+			if resp.Request.Method != http.MethodGet {
+				drain(resp)
+			}
+
+			return nil
+		}
+	}
+
+	defer drain(resp)
+
+	return unexpectedStatus(resp)
+}
+
+func unexpectedStatus(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	return fmt.Errorf("%w: %s %s: %s: %s", ErrUnexpectedStatus,
+		resp.Request.Method, resp.Request.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+}
+
+// drain discards the rest of the body of resp so the connection is reused,
+// and closes it.
+func drain(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	_ = resp.Body.Close()
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+
+	return n, err
+}
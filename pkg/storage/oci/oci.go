@@ -0,0 +1,686 @@
+// Package oci implements an experimental nar and chunk store keeping each
+// object as an OCI artifact in a repository of a container registry, so the
+// registry infrastructure an organization already runs can hold the cache.
+//
+// Every object is the single layer of an OCI image manifest tagged after the
+// object: nar-<hash>.nar[.<ext>] for a nar, chunk-<hash> for a chunk and
+// staging-<hash>-<index> for a staging part. The annotations of the manifest
+// carry the metadata of the object. Deleting an object deletes its manifest;
+// the garbage collection of the registry reclaims the layer.
+package oci
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/kalbasit/ncps/pkg/lock"
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+	"github.com/kalbasit/ncps/pkg/zstd"
+)
+
+const (
+	otelPackageName = "github.com/kalbasit/ncps/pkg/storage/oci"
+
+	// The artifact types of the objects.
+	artifactTypeNar         = "application/vnd.ncps.nar.v1"
+	artifactTypeChunk       = "application/vnd.ncps.chunk.v1"
+	artifactTypeStagingPart = "application/vnd.ncps.staging-part.v1"
+
+	// The prefixes of the tags of the objects.
+	tagPrefixNar     = "nar-"
+	tagPrefixChunk   = "chunk-"
+	tagPrefixStaging = "staging-"
+
+	// annotationTitle names the object of an artifact.
+	annotationTitle = "org.opencontainers.image.title"
+
+	// The annotations of the metadata of a nar.
+	annotationNarHash        = "dev.ncps.nar.hash"
+	annotationNarCompression = "dev.ncps.nar.compression"
+
+	// nameComponentPattern matches a path component of a repository name.
+	nameComponentPattern = `[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*`
+
+	// chunkPutLockTTL is the TTL for the lock acquired when putting a chunk.
+	chunkPutLockTTL = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidRepository is returned by New for a repository that is not of
+	// the form <registry>/<name>.
+	ErrInvalidRepository = errors.New("the OCI repository must be of the form <registry>/<name>")
+
+	// ErrInvalidTag is returned for an object whose tag would not be a valid
+	// OCI tag.
+	ErrInvalidTag = errors.New("invalid OCI tag")
+
+	//nolint:gochecknoglobals
+	tracer trace.Tracer
+
+	//nolint:gochecknoglobals
+	nameRegexp = regexp.MustCompile(`^` + nameComponentPattern + `(?:/` + nameComponentPattern + `)*$`)
+
+	//nolint:gochecknoglobals
+	tagRegexp = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+)
+
+//nolint:gochecknoinits
+func init() {
+	tracer = otel.Tracer(otelPackageName)
+}
+
+// Config holds the configuration of an OCI store.
+type Config struct {
+	// Repository is the repository the artifacts are pushed to, e.g.
+	// registry.example.com/ncps/nars.
+	Repository string
+
+	// Username and Password authenticate to the registry (optional).
+	Username string
+	Password string
+
+	// Insecure talks to the registry over plain HTTP.
+	Insecure bool
+
+	// Locker serializes the concurrent puts of a chunk (optional).
+	Locker lock.Locker
+
+	// Transport is the HTTP transport to use (optional, used for testing).
+	Transport http.RoundTripper
+}
+
+// Store keeps the nars and chunks as OCI artifacts, and implements both
+// storage.NarStore and chunk.Store.
+type Store struct {
+	client *client
+	locker lock.Locker
+
+	// emptyConfigMu protects emptyConfigPushed.
+	emptyConfigMu sync.Mutex
+
+	// emptyConfigPushed is set once the empty config blob of the manifests is
+	// known to be in the repository.
+	emptyConfigPushed bool
+}
+
+// New returns a new OCI store pushing to the repository of cfg.
+func New(ctx context.Context, cfg Config) (*Store, error) {
+	host, name, err := ParseRepository(cfg.Repository)
+	if err != nil {
+		return nil, err
+	}
+
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+
+	scheme := "https"
+	if cfg.Insecure {
+		scheme = "http"
+	}
+
+	s := &Store{
+		client: newClient(&url.URL{Scheme: scheme, Host: host, Path: "/v2/"},
+			name, cfg.Username, cfg.Password, cfg.Transport),
+		locker: cfg.Locker,
+	}
+
+	if err := s.client.ping(ctx); err != nil {
+		return nil, fmt.Errorf("error testing the access to the registry %q: %w", host, err)
+	}
+
+	return s, nil
+}
+
+// ParseRepository splits repository into the host of its registry and its
+// name, e.g. registry.example.com/ncps/nars into registry.example.com and
+// ncps/nars.
+func ParseRepository(repository string) (string, string, error) {
+	host, name, ok := strings.Cut(repository, "/")
+	if !ok || host == "" || !nameRegexp.MatchString(name) {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidRepository, repository)
+	}
+
+	return host, name, nil
+}
+
+// narFileName returns the file name of narURL, e.g. <hash>.nar.xz.
+func narFileName(narURL nar.URL) (string, error) {
+	normalizedURL, err := narURL.Normalize()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Base(nar.URL{Hash: normalizedURL.Hash, Compression: normalizedURL.Compression}.String()), nil
+}
+
+// narTag returns the tag of the artifact of narURL.
+func narTag(narURL nar.URL) (string, error) {
+	fileName, err := narFileName(narURL)
+	if err != nil {
+		return "", err
+	}
+
+	return validTag(tagPrefixNar + fileName)
+}
+
+// chunkTag returns the tag of the artifact of the chunk hash.
+func chunkTag(hash string) (string, error) { return validTag(tagPrefixChunk + hash) }
+
+// stagingPartPrefix returns the prefix of the tags of the staging parts of
+// hash.
+func stagingPartPrefix(hash string) string { return tagPrefixStaging + hash + "-" }
+
+// stagingPartTag returns the tag of the artifact of a staging part.
+func stagingPartTag(hash string, index int64) (string, error) {
+	return validTag(fmt.Sprintf("%s%020d", stagingPartPrefix(hash), index))
+}
+
+func validTag(tag string) (string, error) {
+	if !tagRegexp.MatchString(tag) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+	}
+
+	return tag, nil
+}
+
+// HasNar returns true if the store has the nar.
+func (s *Store) HasNar(ctx context.Context, narURL nar.URL) bool {
+	exists, _ := s.StatNar(ctx, narURL)
+
+	return exists
+}
+
+// StatNar reports whether the store has the nar. A missing manifest is a
+// confirmed absence; any other failure is returned.
+func (s *Store) StatNar(ctx context.Context, narURL nar.URL) (bool, error) {
+	tag, err := narTag(narURL)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, span := s.startSpan(ctx, "oci.StatNar", tag)
+	defer span.End()
+
+	return s.hasTag(ctx, tag)
+}
+
+// GetNar returns nar from the store.
+// NOTE: The caller must close the returned io.ReadCloser!
+func (s *Store) GetNar(ctx context.Context, narURL nar.URL) (int64, io.ReadCloser, error) {
+	tag, err := narTag(narURL)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ctx, span := s.startSpan(ctx, "oci.GetNar", tag)
+	defer span.End()
+
+	size, body, err := s.getArtifact(ctx, tag)
+	if err != nil {
+		if errors.Is(err, errManifestNotFound) {
+			return 0, nil, storage.ErrNotFound
+		}
+
+		return 0, nil, fmt.Errorf("error getting the nar from the registry: %w", err)
+	}
+
+	return size, body, nil
+}
+
+// PutNar puts the nar in the store, streaming its body to the registry. The
+// registry must accept streamed blob uploads.
+func (s *Store) PutNar(ctx context.Context, narURL nar.URL, body io.Reader, size int64) (int64, error) {
+	tag, err := narTag(narURL)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, span := s.startSpan(ctx, "oci.PutNar", tag)
+	defer span.End()
+
+	exists, err := s.hasTag(ctx, tag)
+	if err != nil {
+		return 0, fmt.Errorf("error checking if nar exists: %w", err)
+	}
+
+	if exists {
+		return 0, storage.ErrAlreadyExists
+	}
+
+	normalizedURL, err := narURL.Normalize()
+	if err != nil {
+		return 0, err
+	}
+
+	fileName, err := narFileName(normalizedURL)
+	if err != nil {
+		return 0, err
+	}
+
+	mediaType := "application/x-nix-nar"
+	if ext := narURL.Compression.ToFileExtension(); ext != "" {
+		mediaType = "application/x-nix-nar-" + ext
+	}
+
+	digest, written, err := s.client.pushBlob(ctx, body, size)
+	if err != nil {
+		return 0, fmt.Errorf("error pushing the nar to the registry: %w", err)
+	}
+
+	err = s.putArtifact(ctx, tag, artifactTypeNar, descriptor{MediaType: mediaType, Digest: digest, Size: written},
+		map[string]string{
+			annotationTitle:          fileName,
+			annotationNarHash:        normalizedURL.Hash,
+			annotationNarCompression: normalizedURL.Compression.String(),
+		})
+	if err != nil {
+		return 0, fmt.Errorf("error tagging the nar in the registry: %w", err)
+	}
+
+	return written, nil
+}
+
+// DeleteNar deletes the nar from the store.
+func (s *Store) DeleteNar(ctx context.Context, narURL nar.URL) error {
+	tag, err := narTag(narURL)
+	if err != nil {
+		return err
+	}
+
+	ctx, span := s.startSpan(ctx, "oci.DeleteNar", tag)
+	defer span.End()
+
+	if err := s.client.deleteManifest(ctx, tag); err != nil {
+		if errors.Is(err, errManifestNotFound) {
+			return storage.ErrNotFound
+		}
+
+		return fmt.Errorf("error deleting the nar from the registry: %w", err)
+	}
+
+	return nil
+}
+
+// WalkNars walks all NAR files in the store and calls fn for each one.
+func (s *Store) WalkNars(ctx context.Context, fn func(narURL nar.URL) error) error {
+	ctx, span := s.startSpan(ctx, "oci.WalkNars", tagPrefixNar)
+	defer span.End()
+
+	return s.client.listTags(ctx, func(tag string) error {
+		fileName, ok := strings.CutPrefix(tag, tagPrefixNar)
+		if !ok {
+			return nil
+		}
+
+		narURL, err := nar.ParseURL(fileName)
+		if err != nil {
+			return nil //nolint:nilerr // skip the tags that are not of a nar
+		}
+
+		return fn(narURL)
+	})
+}
+
+// PutStagingPart writes one immutable in-flight staging part-object.
+func (s *Store) PutStagingPart(
+	ctx context.Context,
+	hash string,
+	index int64,
+	body io.Reader,
+	size int64,
+) (int64, error) {
+	if index < 0 {
+		return 0, fmt.Errorf("%w: staging part index %d must be >= 0", storage.ErrInvalidArgument, index)
+	}
+
+	tag, err := stagingPartTag(hash, index)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, span := s.startSpan(ctx, "oci.PutStagingPart", tag)
+	defer span.End()
+
+	digest, written, err := s.client.pushBlob(ctx, body, size)
+	if err != nil {
+		return 0, fmt.Errorf("error pushing the staging part to the registry: %w", err)
+	}
+
+	err = s.putArtifact(ctx, tag, artifactTypeStagingPart,
+		descriptor{MediaType: "application/octet-stream", Digest: digest, Size: written},
+		map[string]string{annotationTitle: hash + "/" + strconv.FormatInt(index, 10)})
+	if err != nil {
+		return 0, fmt.Errorf("error tagging the staging part in the registry: %w", err)
+	}
+
+	return written, nil
+}
+
+// GetStagingPart opens a staging part-object for reading.
+func (s *Store) GetStagingPart(ctx context.Context, hash string, index int64) (io.ReadCloser, error) {
+	tag, err := stagingPartTag(hash, index)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := s.startSpan(ctx, "oci.GetStagingPart", tag)
+	defer span.End()
+
+	_, body, err := s.getArtifact(ctx, tag)
+	if err != nil {
+		if errors.Is(err, errManifestNotFound) {
+			return nil, storage.ErrNotFound
+		}
+
+		return nil, fmt.Errorf("error getting the staging part from the registry: %w", err)
+	}
+
+	return body, nil
+}
+
+// DeleteStagingParts removes all staging part-objects for hash.
+func (s *Store) DeleteStagingParts(ctx context.Context, hash string) error {
+	prefix := stagingPartPrefix(hash)
+
+	ctx, span := s.startSpan(ctx, "oci.DeleteStagingParts", prefix)
+	defer span.End()
+
+	var tags []string
+
+	if err := s.client.listTags(ctx, func(tag string) error {
+		if strings.HasPrefix(tag, prefix) {
+			tags = append(tags, tag)
+		}
+
+		return nil
+	}); err != nil {
+		return fmt.Errorf("error listing staging parts for %q: %w", hash, err)
+	}
+
+	for _, tag := range tags {
+		if err := s.client.deleteManifest(ctx, tag); err != nil && !errors.Is(err, errManifestNotFound) {
+			return fmt.Errorf("error removing staging part %q: %w", tag, err)
+		}
+	}
+
+	return nil
+}
+
+// HasChunk checks if a chunk exists.
+func (s *Store) HasChunk(ctx context.Context, hash string) (bool, error) {
+	tag, err := chunkTag(hash)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, span := s.startSpan(ctx, "oci.HasChunk", tag)
+	defer span.End()
+
+	return s.hasTag(ctx, tag)
+}
+
+// GetChunk retrieves a chunk by hash and decompresses it.
+// NOTE: The caller must close the returned io.ReadCloser!
+func (s *Store) GetChunk(ctx context.Context, hash string) (io.ReadCloser, error) {
+	body, err := s.GetRawChunk(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, err := zstd.NewPooledReader(body)
+	if err != nil {
+		body.Close()
+
+		return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+	}
+
+	return &chunkReadCloser{PooledReader: pr, body: body}, nil
+}
+
+// GetRawChunk retrieves a chunk by hash without decompressing it.
+// NOTE: The caller must close the returned io.ReadCloser!
+func (s *Store) GetRawChunk(ctx context.Context, hash string) (io.ReadCloser, error) {
+	tag, err := chunkTag(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := s.startSpan(ctx, "oci.GetRawChunk", tag)
+	defer span.End()
+
+	_, body, err := s.getArtifact(ctx, tag)
+	if err != nil {
+		if errors.Is(err, errManifestNotFound) {
+			return nil, chunk.ErrNotFound
+		}
+
+		return nil, fmt.Errorf("error getting the chunk from the registry: %w", err)
+	}
+
+	return body, nil
+}
+
+// PutChunk stores a chunk compressed with zstd. Returns true if chunk was new,
+// and the compressed size.
+func (s *Store) PutChunk(ctx context.Context, hash string, data []byte) (bool, int64, error) {
+	tag, err := chunkTag(hash)
+	if err != nil {
+		return false, 0, err
+	}
+
+	ctx, span := s.startSpan(ctx, "oci.PutChunk", tag)
+	defer span.End()
+
+	if s.locker != nil {
+		lockKey := "chunk-put:" + hash
+		if err := s.locker.Lock(ctx, lockKey, chunkPutLockTTL); err != nil {
+			return false, 0, fmt.Errorf("error acquiring lock for chunk put: %w", err)
+		}
+
+		defer func() {
+			_ = s.locker.Unlock(ctx, lockKey)
+		}()
+	}
+
+	exists, err := s.hasTag(ctx, tag)
+	if err != nil {
+		return false, 0, err
+	}
+
+	var buf bytes.Buffer
+
+	pw := zstd.NewChunkWriter(&buf)
+
+	if _, err = pw.Write(data); err == nil {
+		err = pw.Close()
+	} else {
+		_ = pw.Close()
+	}
+
+	if err != nil {
+		return false, 0, err
+	}
+
+	compressed := buf.Bytes()
+
+	if exists {
+		return false, int64(len(compressed)), nil
+	}
+
+	digest, err := s.client.pushBlobBytes(ctx, compressed)
+	if err != nil {
+		return false, 0, fmt.Errorf("error pushing the chunk to the registry: %w", err)
+	}
+
+	err = s.putArtifact(ctx, tag, artifactTypeChunk,
+		descriptor{MediaType: "application/zstd", Digest: digest, Size: int64(len(compressed))},
+		map[string]string{annotationTitle: hash})
+	if err != nil {
+		return false, 0, fmt.Errorf("error tagging the chunk in the registry: %w", err)
+	}
+
+	return true, int64(len(compressed)), nil
+}
+
+// DeleteChunk removes a chunk.
+func (s *Store) DeleteChunk(ctx context.Context, hash string) error {
+	tag, err := chunkTag(hash)
+	if err != nil {
+		return err
+	}
+
+	ctx, span := s.startSpan(ctx, "oci.DeleteChunk", tag)
+	defer span.End()
+
+	if err := s.client.deleteManifest(ctx, tag); err != nil {
+		if errors.Is(err, errManifestNotFound) {
+			return chunk.ErrNotFound
+		}
+
+		return fmt.Errorf("error deleting the chunk from the registry: %w", err)
+	}
+
+	return nil
+}
+
+// WalkChunks walks all chunks in the store and calls fn for each hash.
+func (s *Store) WalkChunks(ctx context.Context, fn func(hash string) error) error {
+	ctx, span := s.startSpan(ctx, "oci.WalkChunks", tagPrefixChunk)
+	defer span.End()
+
+	return s.client.listTags(ctx, func(tag string) error {
+		if hash, ok := strings.CutPrefix(tag, tagPrefixChunk); ok {
+			return fn(hash)
+		}
+
+		return nil
+	})
+}
+
+func (s *Store) startSpan(ctx context.Context, name, tag string) (context.Context, trace.Span) {
+	return tracer.Start(
+		ctx,
+		name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("oci_repository", s.client.base.Host+"/"+s.client.name),
+			attribute.String("oci_tag", tag),
+		),
+	)
+}
+
+// hasTag reports whether the repository has a manifest tagged tag.
+func (s *Store) hasTag(ctx context.Context, tag string) (bool, error) {
+	if _, err := s.client.headManifest(ctx, tag); err != nil {
+		if errors.Is(err, errManifestNotFound) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("error checking the tag %q: %w", tag, err)
+	}
+
+	return true, nil
+}
+
+// getArtifact returns the size and content of the layer of the artifact
+// tagged tag.
+func (s *Store) getArtifact(ctx context.Context, tag string) (int64, io.ReadCloser, error) {
+	m, _, err := s.client.getManifest(ctx, tag)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(m.Layers) != 1 {
+		return 0, nil, fmt.Errorf("%w: the artifact %q has %d layers instead of 1",
+			ErrUnexpectedStatus, tag, len(m.Layers))
+	}
+
+	body, err := s.client.getBlob(ctx, m.Layers[0].Digest)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return m.Layers[0].Size, body, nil
+}
+
+// putArtifact tags the artifact of the pushed layer with tag.
+func (s *Store) putArtifact(
+	ctx context.Context,
+	tag, artifactType string,
+	layer descriptor,
+	annotations map[string]string,
+) error {
+	if err := s.pushEmptyConfig(ctx); err != nil {
+		return err
+	}
+
+	return s.client.putManifest(ctx, tag, &manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeManifest,
+		ArtifactType:  artifactType,
+		Config: descriptor{
+			MediaType: mediaTypeEmpty,
+			Digest:    emptyDigest,
+			Size:      int64(len(emptyConfig)),
+			Data:      emptyConfig,
+		},
+		Layers:      []descriptor{layer},
+		Annotations: annotations,
+	})
+}
+
+// pushEmptyConfig pushes the empty config of the manifests, once.
+func (s *Store) pushEmptyConfig(ctx context.Context) error {
+	s.emptyConfigMu.Lock()
+	defer s.emptyConfigMu.Unlock()
+
+	if s.emptyConfigPushed {
+		return nil
+	}
+
+	exists, err := s.client.hasBlob(ctx, emptyDigest)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		if _, err := s.client.pushBlobBytes(ctx, emptyConfig); err != nil {
+			return fmt.Errorf("error pushing the empty config to the registry: %w", err)
+		}
+	}
+
+	s.emptyConfigPushed = true
+
+	return nil
+}
+
+// chunkReadCloser wraps a pooled zstd reader and the blob body to properly
+// close both.
+type chunkReadCloser struct {
+	*zstd.PooledReader
+	body io.ReadCloser
+}
+
+func (r *chunkReadCloser) Close() error {
+	_ = r.PooledReader.Close()
+
+	return r.body.Close()
+}
@@ -0,0 +1,458 @@
+package oci_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/nar"
+	"github.com/kalbasit/ncps/pkg/storage"
+	"github.com/kalbasit/ncps/pkg/storage/chunk"
+	"github.com/kalbasit/ncps/pkg/storage/oci"
+	"github.com/kalbasit/ncps/testdata"
+)
+
+const (
+	repositoryName = "ncps/cache"
+	registryToken  = "secret-token"
+)
+
+// fakeRegistry is an in-memory registry serving the OCI distribution API of
+// a single repository, requiring a Bearer token obtained with the username
+// and password when they are set.
+type fakeRegistry struct {
+	*httptest.Server
+
+	username, password string
+
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	uploads   map[string]*bytes.Buffer
+	manifests map[string][]byte
+	tags      map[string]string
+	nextID    int
+}
+
+func newFakeRegistry(t *testing.T, username, password string) *fakeRegistry {
+	t.Helper()
+
+	r := &fakeRegistry{
+		username:  username,
+		password:  password,
+		blobs:     make(map[string][]byte),
+		uploads:   make(map[string]*bytes.Buffer),
+		manifests: make(map[string][]byte),
+		tags:      make(map[string]string),
+	}
+
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func (r *fakeRegistry) repository() string {
+	return strings.TrimPrefix(r.URL, "http://") + "/" + repositoryName
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+//nolint:gocyclo,cyclop,funlen // a registry in a single handler.
+func (r *fakeRegistry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		if user, pass, _ := req.BasicAuth(); user != r.username || pass != r.password {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": registryToken})
+
+		return
+	}
+
+	if r.username != "" && req.Header.Get("Authorization") != "Bearer "+registryToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, r.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if p == "" {
+		return
+	}
+
+	rest, ok := strings.CutPrefix(p, repositoryName+"/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	switch {
+	case rest == "blobs/uploads/" && req.Method == http.MethodPost:
+		r.nextID++
+		id := fmt.Sprint(r.nextID)
+		r.uploads[id] = &bytes.Buffer{}
+		w.Header().Set("Location", "/v2/"+repositoryName+"/blobs/uploads/"+id+"?_state=x")
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasPrefix(rest, "blobs/uploads/"):
+		id := strings.TrimPrefix(rest, "blobs/uploads/")
+
+		upload, ok := r.uploads[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		_, _ = io.Copy(upload, req.Body)
+
+		if req.Method == http.MethodPatch {
+			w.Header().Set("Location", req.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+
+			return
+		}
+
+		digest := req.URL.Query().Get("digest")
+		if digest != digestOf(upload.Bytes()) {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		r.blobs[digest] = upload.Bytes()
+		delete(r.uploads, id)
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(rest, "blobs/"):
+		blob, ok := r.blobs[strings.TrimPrefix(rest, "blobs/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(blob)
+		}
+	case strings.HasPrefix(rest, "manifests/"):
+		r.serveManifest(w, req, strings.TrimPrefix(rest, "manifests/"))
+	case rest == "tags/list":
+		r.serveTags(w, req)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *fakeRegistry) serveManifest(w http.ResponseWriter, req *http.Request, reference string) {
+	digest := reference
+	if !strings.HasPrefix(reference, "sha256:") {
+		digest = r.tags[reference]
+	}
+
+	switch req.Method {
+	case http.MethodPut:
+		raw, _ := io.ReadAll(req.Body)
+
+		var m struct {
+			Config struct{ Digest string }
+			Layers []struct{ Digest string }
+		}
+
+		if err := json.Unmarshal(raw, &m); err != nil || r.blobs[m.Config.Digest] == nil ||
+			len(m.Layers) != 1 || r.blobs[m.Layers[0].Digest] == nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		digest = digestOf(raw)
+		r.manifests[digest] = raw
+		r.tags[reference] = digest
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if _, ok := r.manifests[digest]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		delete(r.manifests, digest)
+
+		for tag, d := range r.tags {
+			if d == digest {
+				delete(r.tags, tag)
+			}
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		raw, ok := r.manifests[digest]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		w.Header().Set("Docker-Content-Digest", digest)
+
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(raw)
+		}
+	}
+}
+
+// serveTags lists the tags two per page.
+func (r *fakeRegistry) serveTags(w http.ResponseWriter, req *http.Request) {
+	tags := make([]string, 0, len(r.tags))
+
+	for tag := range r.tags {
+		if tag > req.URL.Query().Get("last") {
+			tags = append(tags, tag)
+		}
+	}
+
+	sort.Strings(tags)
+
+	if len(tags) > 2 {
+		tags = tags[:2]
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?n=2&last=%s>; rel="next"`,
+			repositoryName, url.QueryEscape(tags[1])))
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]any{"name": repositoryName, "tags": tags})
+}
+
+func newStore(t *testing.T, registry *fakeRegistry, username, password string) *oci.Store {
+	t.Helper()
+
+	s, err := oci.New(newContext(), oci.Config{
+		Repository: registry.repository(),
+		Username:   username,
+		Password:   password,
+		Insecure:   true,
+	})
+	require.NoError(t, err)
+
+	return s
+}
+
+func newContext() context.Context {
+	return zerolog.New(io.Discard).WithContext(context.Background())
+}
+
+func TestParseRepository(t *testing.T) {
+	t.Parallel()
+
+	host, name, err := oci.ParseRepository("registry.example.com:5000/ncps/nars")
+	require.NoError(t, err)
+	assert.Equal(t, "registry.example.com:5000", host)
+	assert.Equal(t, "ncps/nars", name)
+
+	for _, repository := range []string{"", "ncps", "registry.example.com/", "registry.example.com/NCPS"} {
+		_, _, err := oci.ParseRepository(repository)
+		require.ErrorIs(t, err, oci.ErrInvalidRepository, repository)
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	registry := newFakeRegistry(t, "user", "pass")
+
+	_, err := oci.New(newContext(), oci.Config{Repository: registry.repository(), Insecure: true})
+	require.ErrorIs(t, err, oci.ErrUnauthorized)
+
+	_, err = oci.New(newContext(), oci.Config{
+		Repository: registry.repository(),
+		Username:   "user",
+		Password:   "wrong",
+		Insecure:   true,
+	})
+	require.ErrorIs(t, err, oci.ErrUnauthorized)
+
+	newStore(t, registry, "user", "pass")
+}
+
+func TestStoreNar(t *testing.T) {
+	t.Parallel()
+
+	registry := newFakeRegistry(t, "user", "pass")
+	s := newStore(t, registry, "user", "pass")
+	ctx := newContext()
+
+	var _ storage.NarStore = s
+
+	narURL := nar.URL{Hash: testdata.Nar1.NarHash, Compression: testdata.Nar1.NarCompression}
+
+	exists, err := s.StatNar(ctx, narURL)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	_, _, err = s.GetNar(ctx, narURL)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	// The size is unknown, the nar is streamed.
+	written, err := s.PutNar(ctx, narURL, strings.NewReader(testdata.Nar1.NarText), -1)
+	require.NoError(t, err)
+	assert.EqualValues(t, len(testdata.Nar1.NarText), written)
+
+	_, err = s.PutNar(ctx, narURL, strings.NewReader(testdata.Nar1.NarText), int64(len(testdata.Nar1.NarText)))
+	require.ErrorIs(t, err, storage.ErrAlreadyExists)
+
+	assert.True(t, s.HasNar(ctx, narURL))
+
+	size, body, err := s.GetNar(ctx, narURL)
+	require.NoError(t, err)
+
+	content, err := io.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.EqualValues(t, len(testdata.Nar1.NarText), size)
+	assert.Equal(t, testdata.Nar1.NarText, string(content))
+
+	assert.Contains(t, registry.tags, "nar-"+testdata.Nar1.NarHash+".nar.xz")
+
+	narURL2 := nar.URL{Hash: testdata.Nar2.NarHash, Compression: testdata.Nar2.NarCompression}
+
+	_, err = s.PutNar(ctx, narURL2, strings.NewReader(testdata.Nar2.NarText), int64(len(testdata.Nar2.NarText)))
+	require.NoError(t, err)
+
+	_, _, err = s.PutChunk(ctx, strings.Repeat("a", 64), []byte("not a nar"))
+	require.NoError(t, err)
+
+	var walked []string
+
+	require.NoError(t, s.WalkNars(ctx, func(narURL nar.URL) error {
+		walked = append(walked, narURL.String())
+
+		return nil
+	}))
+
+	assert.ElementsMatch(t, []string{narURL.String(), narURL2.String()}, walked)
+
+	require.NoError(t, s.DeleteNar(ctx, narURL))
+	require.ErrorIs(t, s.DeleteNar(ctx, narURL), storage.ErrNotFound)
+	assert.False(t, s.HasNar(ctx, narURL))
+}
+
+func TestStoreChunk(t *testing.T) {
+	t.Parallel()
+
+	registry := newFakeRegistry(t, "", "")
+	s := newStore(t, registry, "", "")
+	ctx := newContext()
+
+	var _ chunk.Store = s
+
+	hash := strings.Repeat("b", 64)
+	data := bytes.Repeat([]byte("chunk data "), 100)
+
+	_, err := s.GetChunk(ctx, hash)
+	require.ErrorIs(t, err, chunk.ErrNotFound)
+
+	isNew, size, err := s.PutChunk(ctx, hash, data)
+	require.NoError(t, err)
+	assert.True(t, isNew)
+	assert.Less(t, size, int64(len(data)), "the chunk is stored compressed")
+
+	isNew, _, err = s.PutChunk(ctx, hash, data)
+	require.NoError(t, err)
+	assert.False(t, isNew)
+
+	exists, err := s.HasChunk(ctx, hash)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	rc, err := s.GetChunk(ctx, hash)
+	require.NoError(t, err)
+
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, data, got)
+
+	raw, err := s.GetRawChunk(ctx, hash)
+	require.NoError(t, err)
+
+	compressed, err := io.ReadAll(raw)
+	require.NoError(t, err)
+	require.NoError(t, raw.Close())
+	assert.Len(t, compressed, int(size))
+
+	var hashes []string
+
+	require.NoError(t, s.WalkChunks(ctx, func(hash string) error {
+		hashes = append(hashes, hash)
+
+		return nil
+	}))
+
+	assert.Equal(t, []string{hash}, hashes)
+
+	require.NoError(t, s.DeleteChunk(ctx, hash))
+	require.ErrorIs(t, s.DeleteChunk(ctx, hash), chunk.ErrNotFound)
+}
+
+func TestStoreStagingParts(t *testing.T) {
+	t.Parallel()
+
+	registry := newFakeRegistry(t, "", "")
+	s := newStore(t, registry, "", "")
+	ctx := newContext()
+
+	const hash = "abcdef0123456789abcdef0123456789"
+
+	_, err := s.PutStagingPart(ctx, hash, -1, strings.NewReader("x"), 1)
+	require.ErrorIs(t, err, storage.ErrInvalidArgument)
+
+	for i := range int64(3) {
+		_, err := s.PutStagingPart(ctx, hash, i, strings.NewReader("part"), 4)
+		require.NoError(t, err)
+	}
+
+	_, err = s.PutStagingPart(ctx, "other", 0, strings.NewReader("part"), 0)
+	require.NoError(t, err)
+
+	rc, err := s.GetStagingPart(ctx, hash, 1)
+	require.NoError(t, err)
+
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "part", string(got))
+
+	require.NoError(t, s.DeleteStagingParts(ctx, hash))
+	require.NoError(t, s.DeleteStagingParts(ctx, hash))
+
+	_, err = s.GetStagingPart(ctx, hash, 1)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	assert.Equal(t, []string{"staging-other-00000000000000000000"}, slices.Collect(maps.Keys(registry.tags)))
+}
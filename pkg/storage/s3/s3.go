@@ -6,9 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"path"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -589,6 +591,33 @@ func (s *Store) putObjectStream(
 	return result.Size, nil
 }
 
+// PresignNarURL returns a presigned GET URL for the nar, valid for expiry. The
+// URL is signed locally and does not check that the object exists.
+func (s *Store) PresignNarURL(ctx context.Context, narURL nar.URL, expiry time.Duration) (*url.URL, error) {
+	key, err := s.narPath(narURL)
+	if err != nil {
+		return nil, err
+	}
+
+	_, span := tracer.Start(
+		ctx,
+		"s3.PresignNarURL",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("nar_url", narURL.String()),
+			attribute.String("nar_key", key),
+		),
+	)
+	defer span.End()
+
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, expiry, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error presigning the nar URL: %w", err)
+	}
+
+	return u, nil
+}
+
 // DeleteNar deletes the nar from the store.
 func (s *Store) DeleteNar(ctx context.Context, narURL nar.URL) error {
 	key, err := s.narPath(narURL)
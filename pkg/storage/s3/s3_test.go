@@ -9,6 +9,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/nix-community/go-nix/pkg/narinfo"
@@ -1826,6 +1827,39 @@ func TestNew_BucketNotFound(t *testing.T) {
 	assert.ErrorIs(t, err, storage_s3.ErrBucketNotFound)
 }
 
+func TestPresignNarURL(t *testing.T) {
+	t.Parallel()
+
+	ctx := newContext()
+	cfg := s3config.Config{
+		Bucket:          "test-bucket",
+		Endpoint:        "http://localhost:9000",
+		Region:          "us-east-1",
+		AccessKeyID:     "minioadmin",
+		SecretAccessKey: "minioadmin",
+		Prefix:          "ncps",
+		Transport: roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+			return s3OKResponse("")
+		}),
+	}
+
+	store, err := storage_s3.New(ctx, cfg)
+	require.NoError(t, err)
+
+	narURL := nar.URL{Hash: "1lid9xrpirkzcpqsxfq02qwiq0yd70chfl860wzsqd1739ih0nri", Compression: nar.CompressionTypeXz}
+
+	u, err := store.PresignNarURL(ctx, narURL, 5*time.Minute)
+	require.NoError(t, err)
+
+	assert.Equal(t, "localhost:9000", u.Host)
+	assert.True(t, strings.HasPrefix(u.Path, "/test-bucket/ncps/store/nar/"), u.Path)
+	assert.True(t, strings.HasSuffix(u.Path, "/"+narURL.Hash+".nar.xz"), u.Path)
+	assert.Equal(t, "300", u.Query().Get("X-Amz-Expires"))
+	assert.NotEmpty(t, u.Query().Get("X-Amz-Signature"))
+
+	var _ storage.NarPresigner = store
+}
+
 func newContext() context.Context {
 	return zerolog.
 		New(io.Discard).
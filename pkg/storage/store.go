@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"io"
+	"net/url"
+	"time"
 
 	"github.com/nix-community/go-nix/pkg/narinfo"
 	"github.com/nix-community/go-nix/pkg/narinfo/signature"
@@ -117,3 +119,23 @@ type NarStore interface {
 	// when none exist.
 	DeleteStagingParts(ctx context.Context, hash string) error
 }
+
+// NarPresigner is implemented by NarStores that can hand out time-limited URLs
+// granting direct read access to a stored nar, so the server can redirect the
+// client to the backend instead of proxying the bytes itself.
+type NarPresigner interface {
+	// PresignNarURL returns a URL valid for expiry through which the nar can be
+	// fetched directly from the backend. It does not check that the nar exists.
+	PresignNarURL(ctx context.Context, narURL nar.URL, expiry time.Duration) (*url.URL, error)
+}
+
+// LegacyNarMigrator is implemented by NarStores that may hold nars written by
+// older versions of ncps under a path the current layout no longer reads, e.g.
+// named after a nix-serve-style prefixed hash or not sharded.
+type LegacyNarMigrator interface {
+	// MigrateLegacyNars moves every legacy nar to its current path, without
+	// downloading it again. A legacy nar whose current path is already taken is
+	// a duplicate and is removed. It returns the number of nars moved and
+	// removed.
+	MigrateLegacyNars(ctx context.Context) (moved, removed int, err error)
+}
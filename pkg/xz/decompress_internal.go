@@ -11,11 +11,19 @@ func UseInternal() {
 	store(decompressInternal)
 }
 
-func decompressInternal(_ context.Context, r io.Reader) (io.ReadCloser, error) {
+func decompressInternal(ctx context.Context, r io.Reader) (io.ReadCloser, error) {
+	// The Go implementation decompresses on a single thread.
+	_, release, err := acquireThreads(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+
 	xr, err := xz.NewReader(r)
 	if err != nil {
+		release()
+
 		return nil, err
 	}
 
-	return io.NopCloser(xr), nil
+	return &budgetReadCloser{ReadCloser: io.NopCloser(xr), release: release}, nil
 }
@@ -9,6 +9,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -23,16 +24,25 @@ type xzReadCloser struct {
 	cmd    *exec.Cmd
 	stderr *bytes.Buffer
 
+	// release gives the threads of the process back to the worker budget.
+	release func()
+
 	waitOnce sync.Once
 	waitErr  error
 }
 
+// wait waits for the process to exit, once, and gives its threads back.
+func (x *xzReadCloser) wait() {
+	x.waitOnce.Do(func() {
+		x.waitErr = x.cmd.Wait()
+		x.release()
+	})
+}
+
 func (x *xzReadCloser) Read(p []byte) (n int, err error) {
 	n, err = x.reader.Read(p)
 	if err == io.EOF {
-		x.waitOnce.Do(func() {
-			x.waitErr = x.cmd.Wait()
-		})
+		x.wait()
 
 		if x.waitErr != nil {
 			return n, fmt.Errorf("xz decompression failed: %w, stderr: %s", x.waitErr, x.stderr.String())
@@ -52,9 +62,7 @@ func (x *xzReadCloser) Close() error {
 	}
 
 	// Wait for the command to finish and get the exit status
-	x.waitOnce.Do(func() {
-		x.waitErr = x.cmd.Wait()
-	})
+	x.wait()
 
 	if x.waitErr != nil {
 		// Return the captured stderr to explain WHY it failed
@@ -67,7 +75,12 @@ func (x *xzReadCloser) Close() error {
 // decompressCommand streams the decompression using the system's xz binary.
 func decompressCommand(path string) DecompressorFn {
 	return func(ctx context.Context, r io.Reader) (io.ReadCloser, error) {
-		cmd := exec.CommandContext(ctx, path, "-d", "-c")
+		n, release, err := acquireThreads(ctx, int(threads.Load()))
+		if err != nil {
+			return nil, err
+		}
+
+		cmd := exec.CommandContext(ctx, path, "-d", "-c", "-T", strconv.Itoa(n))
 		cmd.Stdin = r
 
 		var stderr bytes.Buffer
@@ -76,10 +89,14 @@ func decompressCommand(path string) DecompressorFn {
 
 		stdout, err := cmd.StdoutPipe()
 		if err != nil {
+			release()
+
 			return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
 		}
 
 		if err := cmd.Start(); err != nil {
+			release()
+
 			return nil, fmt.Errorf("failed to start xz process: %w", err)
 		}
 
@@ -89,17 +106,16 @@ func decompressCommand(path string) DecompressorFn {
 		_, peekErr := br.Peek(1)
 
 		xrc := &xzReadCloser{
-			reader: br,
-			stdout: stdout,
-			cmd:    cmd,
-			stderr: &stderr,
+			reader:  br,
+			stdout:  stdout,
+			cmd:     cmd,
+			stderr:  &stderr,
+			release: release,
 		}
 
 		if peekErr != nil {
 			// If we got an error (like EOF), the command might have exited.
-			xrc.waitOnce.Do(func() {
-				xrc.waitErr = cmd.Wait()
-			})
+			xrc.wait()
 
 			if xrc.waitErr != nil {
 				return nil, fmt.Errorf("xz decompression failed: %w, stderr: %s", xrc.waitErr, stderr.String())
@@ -36,3 +36,7 @@ func getXZBin() (string, error) {
 
 	return exec.LookPath("xz")
 }
+
+func AcquireThreads(ctx context.Context, want int) (int, func(), error) {
+	return acquireThreads(ctx, want)
+}
@@ -0,0 +1,97 @@
+package xz
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sync/semaphore"
+)
+
+//nolint:gochecknoglobals // Configured once at startup, read by every decompression.
+var (
+	// threads is the number of threads a decompression by the xz binary asks
+	// for; zero is one.
+	threads atomic.Int32
+
+	// workerBudget bounds the threads of all the decompressions running at
+	// once; nil is unbounded.
+	workerBudget atomic.Pointer[semaphore.Weighted]
+)
+
+// SetThreads sets the number of threads each decompression by the xz binary
+// uses (xz -T). Zero or less uses one per CPU. Only the streams compressed in
+// several blocks, e.g. by xz -T, are decompressed in parallel; the others use
+// a single thread.
+func SetThreads(n int) {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+
+	threads.Store(int32(min(n, 1<<16))) //nolint:gosec // bounded above
+}
+
+// SetWorkerBudget bounds the threads used by all the decompressions running
+// at once, such as the NARs of a bulk migration. A decompression takes the
+// threads set by SetThreads while the budget allows, at least one, waiting
+// for one to be free; the internal decompressor takes one. Zero or less
+// removes the bound.
+func SetWorkerBudget(n int) {
+	if n <= 0 {
+		workerBudget.Store(nil)
+
+		return
+	}
+
+	workerBudget.Store(semaphore.NewWeighted(int64(n)))
+}
+
+// acquireThreads takes up to want threads from the worker budget, waiting for
+// at least one. It returns the number taken and the function giving them
+// back, which is safe to call more than once.
+func acquireThreads(ctx context.Context, want int) (int, func(), error) {
+	want = max(want, 1)
+
+	sem := workerBudget.Load()
+	if sem == nil {
+		return want, func() {}, nil
+	}
+
+	if err := sem.Acquire(ctx, 1); err != nil {
+		return 0, nil, err
+	}
+
+	n := 1
+	for n < want && sem.TryAcquire(1) {
+		n++
+	}
+
+	var once sync.Once
+
+	return n, func() { once.Do(func() { sem.Release(int64(n)) }) }, nil
+}
+
+// budgetReadCloser gives its threads back to the worker budget once the
+// decompressed stream is read to its end, fails, or is closed.
+type budgetReadCloser struct {
+	io.ReadCloser
+
+	release func()
+}
+
+func (b *budgetReadCloser) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.release()
+	}
+
+	return n, err
+}
+
+func (b *budgetReadCloser) Close() error {
+	defer b.release()
+
+	return b.ReadCloser.Close()
+}
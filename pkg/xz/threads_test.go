@@ -0,0 +1,90 @@
+package xz_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/xz"
+)
+
+//nolint:paralleltest // the worker budget is global
+func TestWorkerBudget(t *testing.T) {
+	t.Cleanup(func() { xz.SetWorkerBudget(0) })
+
+	xz.SetWorkerBudget(3)
+
+	n, release, err := xz.AcquireThreads(context.Background(), 5)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n, "a decompression takes what the budget allows")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, _, err = xz.AcquireThreads(ctx, 1)
+	require.ErrorIs(t, err, context.DeadlineExceeded, "a decompression waits for a free thread")
+
+	release()
+	release()
+
+	n, release, err = xz.AcquireThreads(context.Background(), 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	release()
+
+	xz.SetWorkerBudget(0)
+
+	n, _, err = xz.AcquireThreads(context.Background(), 8)
+	require.NoError(t, err)
+	assert.Equal(t, 8, n, "without a budget a decompression takes the threads it wants")
+}
+
+//nolint:paralleltest // the worker budget is global
+func TestWorkerBudgetReleasedByDecompressors(t *testing.T) {
+	t.Cleanup(func() {
+		xz.SetWorkerBudget(0)
+		xz.SetThreads(1)
+	})
+
+	xz.SetThreads(4)
+	xz.SetWorkerBudget(1)
+
+	input := generateValidXZ(t)
+
+	for name, fn := range map[string]xz.DecompressorFn{
+		"decompressCommand":  xz.DecompressCommand,
+		"decompressInternal": xz.DecompressInternal,
+	} {
+		t.Run(name, func(t *testing.T) {
+			// The budget allows one decompression at a time: the second one
+			// only starts if the first gave its thread back.
+			for range 2 {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+				rc, err := fn(ctx, bytes.NewReader(input))
+				require.NoError(t, err)
+
+				output, err := io.ReadAll(rc)
+				require.NoError(t, err)
+				assert.Equal(t, "hello world", string(output))
+
+				require.NoError(t, rc.Close())
+				cancel()
+			}
+
+			// A decompression failing to start gives its thread back too.
+			_, err := fn(context.Background(), bytes.NewReader([]byte("not xz")))
+			require.Error(t, err)
+
+			_, release, err := xz.AcquireThreads(context.Background(), 1)
+			require.NoError(t, err)
+			release()
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package zstd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// MinWindowLog is the smallest window log accepted by EncoderOptions.
+	MinWindowLog = 10
+
+	// MaxWindowLog is the largest window log accepted by EncoderOptions. It is
+	// the largest window decoded by default by the reference implementation,
+	// which Nix uses: a larger one would need every client to opt in.
+	MaxWindowLog = 27
+
+	// LongDistanceWindowLog is the window log selected by
+	// EncoderOptions.LongDistanceMatching, the one of zstd --long.
+	LongDistanceWindowLog = 27
+)
+
+// ErrInvalidWindowLog is returned by EncoderOptions.Validate for a window log
+// out of range.
+var ErrInvalidWindowLog = errors.New("the zstd window log must be 0 or between 10 and 27")
+
+// EncoderOptions tunes the zstd encoders of a compression path, trading
+// memory for the compression ratio of large content. The zero value uses the
+// defaults of the encoder: an 8 MiB window at the default level.
+//
+// The window is allocated by every encoder of the path, and by every decoder
+// reading the content back.
+type EncoderOptions struct {
+	// WindowLog is the log2 of the window, the farthest back a match is
+	// searched for, between MinWindowLog and MaxWindowLog. Zero keeps the
+	// default.
+	WindowLog int
+
+	// LongDistanceMatching searches matches across the window of zstd --long
+	// (LongDistanceWindowLog, unless WindowLog is set) at the better
+	// compression level. The encoder has no separate long distance matcher:
+	// its matchers cover the whole window.
+	LongDistanceMatching bool
+}
+
+// Validate returns an error if the options are not valid.
+func (o EncoderOptions) Validate() error {
+	if o.WindowLog != 0 && (o.WindowLog < MinWindowLog || o.WindowLog > MaxWindowLog) {
+		return fmt.Errorf("%w: %d", ErrInvalidWindowLog, o.WindowLog)
+	}
+
+	return nil
+}
+
+// windowSize returns the window of the options, or zero for the default.
+func (o EncoderOptions) windowSize() int {
+	switch {
+	case o.WindowLog != 0:
+		return 1 << o.WindowLog
+	case o.LongDistanceMatching:
+		return 1 << LongDistanceWindowLog
+	default:
+		return 0
+	}
+}
+
+func (o EncoderOptions) encoderOptions() []zstd.EOption {
+	var opts []zstd.EOption
+
+	if ws := o.windowSize(); ws != 0 {
+		opts = append(opts, zstd.WithWindowSize(ws))
+	}
+
+	if o.LongDistanceMatching {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	}
+
+	return opts
+}
+
+// encoderPool is a pool of encoders sharing the same options.
+type encoderPool struct {
+	opts EncoderOptions
+	pool sync.Pool
+}
+
+func newEncoderPool(opts EncoderOptions) (*encoderPool, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	eopts := opts.encoderOptions()
+
+	// Check the options once so the pool never fails to create an encoder.
+	enc, err := zstd.NewWriter(nil, eopts...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating a zstd encoder: %w", err)
+	}
+
+	p := &encoderPool{opts: opts}
+	p.pool.New = func() any {
+		// The options were checked above.
+		enc, _ := zstd.NewWriter(nil, eopts...)
+
+		return enc
+	}
+	p.pool.Put(enc)
+
+	return p, nil
+}
+
+func (p *encoderPool) get() *zstd.Encoder {
+	return p.pool.Get().(*zstd.Encoder)
+}
+
+func (p *encoderPool) put(enc *zstd.Encoder) {
+	if enc != nil {
+		enc.Reset(nil)
+		p.pool.Put(enc)
+	}
+}
+
+// The encoder pools of the chunk and whole-file NAR compression paths; nil
+// uses the default pool. They are replaced, not reconfigured, by
+// SetChunkEncoderOptions and SetNarEncoderOptions so the encoders in use keep
+// the options they were created with.
+//
+//nolint:gochecknoglobals
+var (
+	chunkEncoders atomic.Pointer[encoderPool]
+	narEncoders   atomic.Pointer[encoderPool]
+)
+
+// SetChunkEncoderOptions sets the options of the encoders compressing the CDC
+// chunks. A chunk is compressed on its own, so a window larger than the
+// chunks does not improve their ratio.
+func SetChunkEncoderOptions(opts EncoderOptions) error {
+	return setEncoderOptions(&chunkEncoders, opts)
+}
+
+// SetNarEncoderOptions sets the options of the encoders compressing whole
+// NARs: the NARs recompressed as seekable zstd before being stored and the
+// ones transcoded from their chunks.
+func SetNarEncoderOptions(opts EncoderOptions) error {
+	return setEncoderOptions(&narEncoders, opts)
+}
+
+func setEncoderOptions(dst *atomic.Pointer[encoderPool], opts EncoderOptions) error {
+	if opts == (EncoderOptions{}) {
+		dst.Store(nil)
+
+		return nil
+	}
+
+	p, err := newEncoderPool(opts)
+	if err != nil {
+		return err
+	}
+
+	dst.Store(p)
+
+	return nil
+}
+
+// NewChunkWriter is NewPooledWriter for the chunk compression path, using the
+// encoders set by SetChunkEncoderOptions.
+func NewChunkWriter(w io.Writer) *PooledWriter {
+	return newPooledWriterFrom(chunkEncoders.Load(), w)
+}
+
+// NewNarWriter is NewPooledWriter for the whole-file NAR compression path,
+// using the encoders set by SetNarEncoderOptions.
+func NewNarWriter(w io.Writer) *PooledWriter {
+	return newPooledWriterFrom(narEncoders.Load(), w)
+}
+
+// NewNarSeekableWriter is NewSeekableWriter for the whole-file NAR compression
+// path, using the encoders set by SetNarEncoderOptions. A frame is compressed
+// on its own, so the frames span the window set, if larger than
+// DefaultSeekableFrameSize: a seek then decompresses up to a window.
+func NewNarSeekableWriter(w io.Writer) *SeekableWriter {
+	p := narEncoders.Load()
+	if p == nil {
+		return NewSeekableWriter(w, DefaultSeekableFrameSize)
+	}
+
+	sw := NewSeekableWriter(w, max(DefaultSeekableFrameSize, p.opts.windowSize()))
+	sw.encoders = p
+
+	return sw
+}
+
+func newPooledWriterFrom(p *encoderPool, w io.Writer) *PooledWriter {
+	if p == nil {
+		return NewPooledWriter(w)
+	}
+
+	enc := p.get()
+	enc.Reset(w)
+
+	return &PooledWriter{
+		Encoder:  enc,
+		w:        w,
+		encoders: p,
+	}
+}
@@ -0,0 +1,113 @@
+package zstd_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/zstd"
+)
+
+func TestEncoderOptionsValidate(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, zstd.EncoderOptions{}.Validate())
+	require.NoError(t, zstd.EncoderOptions{WindowLog: zstd.MinWindowLog}.Validate())
+	require.NoError(t, zstd.EncoderOptions{WindowLog: zstd.MaxWindowLog, LongDistanceMatching: true}.Validate())
+
+	require.ErrorIs(t, zstd.EncoderOptions{WindowLog: 9}.Validate(), zstd.ErrInvalidWindowLog)
+	require.ErrorIs(t, zstd.EncoderOptions{WindowLog: 28}.Validate(), zstd.ErrInvalidWindowLog,
+		"Nix clients do not decode windows over 128 MiB by default")
+}
+
+//nolint:paralleltest // the encoder options are global
+func TestNarEncoderOptions(t *testing.T) {
+	t.Cleanup(func() {
+		require.NoError(t, zstd.SetNarEncoderOptions(zstd.EncoderOptions{}))
+	})
+
+	require.ErrorIs(t, zstd.SetNarEncoderOptions(zstd.EncoderOptions{WindowLog: 30}), zstd.ErrInvalidWindowLog)
+
+	require.NoError(t, zstd.SetNarEncoderOptions(zstd.EncoderOptions{WindowLog: 21, LongDistanceMatching: true}))
+
+	// A block repeated 1.5 MiB apart, within the window set.
+	block := make([]byte, 1<<20)
+	for i := range block {
+		block[i] = byte(rand.IntN(256)) //nolint:gosec // test data
+	}
+
+	data := append(append(append([]byte{}, block...), make([]byte, 1<<19)...), block...)
+
+	t.Run("stream", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		pw := zstd.NewNarWriter(&buf)
+		_, err := pw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, pw.Close())
+
+		assert.Less(t, buf.Len(), 3<<19, "the repeated block should be matched")
+
+		pr, err := zstd.NewPooledReader(&buf)
+		require.NoError(t, err)
+
+		defer pr.Close()
+
+		got, err := io.ReadAll(pr)
+		require.NoError(t, err)
+		assert.Equal(t, data, got)
+	})
+
+	t.Run("seekable", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		sw := zstd.NewNarSeekableWriter(&buf)
+		_, err := sw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, sw.Close())
+
+		table, err := zstd.ReadSeekTable(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		require.NoError(t, err)
+		assert.Equal(t, 2, table.NumFrames(), "the frames should span the 2 MiB window")
+
+		sr, err := zstd.NewSeekableReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		require.NoError(t, err)
+
+		defer sr.Close()
+
+		got, err := io.ReadAll(sr)
+		require.NoError(t, err)
+		assert.Equal(t, data, got)
+	})
+}
+
+//nolint:paralleltest // the encoder options are global
+func TestChunkEncoderOptions(t *testing.T) {
+	t.Cleanup(func() {
+		require.NoError(t, zstd.SetChunkEncoderOptions(zstd.EncoderOptions{}))
+	})
+
+	require.NoError(t, zstd.SetChunkEncoderOptions(zstd.EncoderOptions{LongDistanceMatching: true}))
+
+	data := bytes.Repeat([]byte("chunk data "), 1000)
+
+	var buf bytes.Buffer
+
+	pw := zstd.NewChunkWriter(&buf)
+	_, err := pw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, pw.Close())
+
+	pr, err := zstd.NewPooledReader(&buf)
+	require.NoError(t, err)
+
+	defer pr.Close()
+
+	got, err := io.ReadAll(pr)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
@@ -0,0 +1,409 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// The seekable format splits the content into independently compressed zstd
+// frames followed by a seek table stored in a skippable frame, so any zstd
+// decoder reads it as a regular zstd stream while a seekable reader can jump to
+// the frame holding a given offset. See:
+// https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
+const (
+	// DefaultSeekableFrameSize is the amount of uncompressed data in each frame
+	// written by a SeekableWriter. A seek decompresses at most one frame.
+	DefaultSeekableFrameSize = 1 << 20
+
+	seekableSkippableMagic uint32 = 0x184D2A5E
+	seekableFooterMagic    uint32 = 0x8F92EAB1
+
+	seekTableEntrySize         = 8
+	seekTableChecksumEntrySize = 12
+	seekTableFooterSize        = 9
+	skippableHeaderSize        = 8
+
+	seekTableChecksumFlag = 1 << 7
+)
+
+var (
+	// ErrNotSeekable is returned by ReadSeekTable and NewSeekableReader when the
+	// content does not end with a seek table.
+	ErrNotSeekable = errors.New("zstd content is not in the seekable format")
+
+	// ErrCorruptSeekTable is returned when the seek table does not describe the
+	// frames it follows.
+	ErrCorruptSeekTable = errors.New("corrupt zstd seek table")
+
+	// ErrSeekableWriterClosed is returned when writing to a closed SeekableWriter.
+	ErrSeekableWriterClosed = errors.New("seekable writer is closed")
+
+	errNegativeOffset = errors.New("negative offset")
+	errInvalidWhence  = errors.New("invalid whence")
+)
+
+// SeekableWriter compresses the data written to it in the seekable zstd
+// format. Close must be called to write the last frame and the seek table.
+//
+// Example:
+//
+//	sw := NewSeekableWriter(f, DefaultSeekableFrameSize)
+//	if _, err := io.Copy(sw, r); err != nil {
+//		return err
+//	}
+//	return sw.Close()
+type SeekableWriter struct {
+	w         io.Writer
+	frameSize int
+	buf       []byte
+	scratch   []byte
+	frames    []seekFrame
+	closed    bool
+
+	// encoders is the pool the frames are compressed with; nil is the default
+	// pool.
+	encoders *encoderPool
+}
+
+// NewSeekableWriter returns a SeekableWriter writing frames of frameSize
+// uncompressed bytes to w. A frameSize <= 0 selects DefaultSeekableFrameSize.
+func NewSeekableWriter(w io.Writer, frameSize int) *SeekableWriter {
+	if frameSize <= 0 {
+		frameSize = DefaultSeekableFrameSize
+	}
+
+	return &SeekableWriter{
+		w:         w,
+		frameSize: frameSize,
+		buf:       make([]byte, 0, frameSize),
+	}
+}
+
+// Write buffers p and writes every complete frame.
+func (sw *SeekableWriter) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, ErrSeekableWriterClosed
+	}
+
+	written := 0
+
+	for len(p) > 0 {
+		n := min(sw.frameSize-len(sw.buf), len(p))
+		sw.buf = append(sw.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(sw.buf) == sw.frameSize {
+			if err := sw.writeFrame(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// Close writes the buffered data as the last frame followed by the seek table.
+// It does not close the underlying writer. Multiple calls to Close are safe.
+func (sw *SeekableWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+
+	sw.closed = true
+
+	// An empty input still gets one (empty) frame so the output is a valid zstd
+	// stream for decoders that do not accept a stream of skippable frames only.
+	if len(sw.buf) > 0 || len(sw.frames) == 0 {
+		if err := sw.writeFrame(); err != nil {
+			return err
+		}
+	}
+
+	tableSize := len(sw.frames)*seekTableEntrySize + seekTableFooterSize
+	table := make([]byte, 0, skippableHeaderSize+tableSize)
+	table = binary.LittleEndian.AppendUint32(table, seekableSkippableMagic)
+	table = binary.LittleEndian.AppendUint32(table, uint32(tableSize)) //nolint:gosec // bounded by the frame count
+
+	for _, f := range sw.frames {
+		table = binary.LittleEndian.AppendUint32(table, f.compressedSize)
+		table = binary.LittleEndian.AppendUint32(table, f.decompressedSize)
+	}
+
+	table = binary.LittleEndian.AppendUint32(table, uint32(len(sw.frames))) //nolint:gosec // bounded by the frame count
+	table = append(table, 0)                                                // descriptor: no checksums
+	table = binary.LittleEndian.AppendUint32(table, seekableFooterMagic)
+
+	if _, err := sw.w.Write(table); err != nil {
+		return fmt.Errorf("error writing the seek table: %w", err)
+	}
+
+	return nil
+}
+
+func (sw *SeekableWriter) writeFrame() error {
+	if sw.encoders != nil {
+		enc := sw.encoders.get()
+		sw.scratch = enc.EncodeAll(sw.buf, sw.scratch[:0])
+		sw.encoders.put(enc)
+	} else {
+		enc := GetWriter()
+		sw.scratch = enc.EncodeAll(sw.buf, sw.scratch[:0])
+		PutWriter(enc)
+	}
+
+	if _, err := sw.w.Write(sw.scratch); err != nil {
+		return fmt.Errorf("error writing a zstd frame: %w", err)
+	}
+
+	sw.frames = append(sw.frames, seekFrame{
+		compressedSize:   uint32(len(sw.scratch)), //nolint:gosec // a frame is at most a few MiB
+		decompressedSize: uint32(len(sw.buf)),     //nolint:gosec // a frame is at most frameSize
+	})
+	sw.buf = sw.buf[:0]
+
+	return nil
+}
+
+// seekFrame describes one frame of seekable content.
+type seekFrame struct {
+	compressedOffset   int64
+	decompressedOffset int64
+	compressedSize     uint32
+	decompressedSize   uint32
+}
+
+// SeekTable is the index of the frames of seekable zstd content.
+type SeekTable struct {
+	frames           []seekFrame
+	decompressedSize int64
+}
+
+// ReadSeekTable reads the seek table at the end of the size bytes of r. It
+// returns ErrNotSeekable if r does not hold seekable content.
+func ReadSeekTable(r io.ReaderAt, size int64) (*SeekTable, error) {
+	if size < skippableHeaderSize+seekTableFooterSize {
+		return nil, ErrNotSeekable
+	}
+
+	var footer [seekTableFooterSize]byte
+	if err := readFullAt(r, footer[:], size-seekTableFooterSize); err != nil {
+		return nil, fmt.Errorf("error reading the seek table footer: %w", err)
+	}
+
+	if binary.LittleEndian.Uint32(footer[5:]) != seekableFooterMagic {
+		return nil, ErrNotSeekable
+	}
+
+	numFrames := int64(binary.LittleEndian.Uint32(footer[:4]))
+
+	entrySize := int64(seekTableEntrySize)
+	if footer[4]&seekTableChecksumFlag != 0 {
+		entrySize = seekTableChecksumEntrySize
+	}
+
+	tableSize := numFrames*entrySize + seekTableFooterSize
+	if skippableHeaderSize+tableSize > size {
+		return nil, fmt.Errorf("%w: %d frames do not fit in %d bytes", ErrCorruptSeekTable, numFrames, size)
+	}
+
+	table := make([]byte, skippableHeaderSize+tableSize)
+	if err := readFullAt(r, table, size-int64(len(table))); err != nil {
+		return nil, fmt.Errorf("error reading the seek table: %w", err)
+	}
+
+	if binary.LittleEndian.Uint32(table) != seekableSkippableMagic ||
+		int64(binary.LittleEndian.Uint32(table[4:])) != tableSize {
+		return nil, fmt.Errorf("%w: invalid skippable frame header", ErrCorruptSeekTable)
+	}
+
+	st := &SeekTable{frames: make([]seekFrame, 0, numFrames)}
+
+	var compressedOffset int64
+
+	for entry := table[skippableHeaderSize : len(table)-seekTableFooterSize]; len(entry) > 0; entry = entry[entrySize:] {
+		f := seekFrame{
+			compressedOffset:   compressedOffset,
+			decompressedOffset: st.decompressedSize,
+			compressedSize:     binary.LittleEndian.Uint32(entry),
+			decompressedSize:   binary.LittleEndian.Uint32(entry[4:]),
+		}
+
+		st.frames = append(st.frames, f)
+		compressedOffset += int64(f.compressedSize)
+		st.decompressedSize += int64(f.decompressedSize)
+	}
+
+	if compressedOffset != size-int64(len(table)) {
+		return nil, fmt.Errorf("%w: frames span %d bytes, expected %d", ErrCorruptSeekTable,
+			compressedOffset, size-int64(len(table)))
+	}
+
+	return st, nil
+}
+
+// DecompressedSize returns the size of the decompressed content.
+func (st *SeekTable) DecompressedSize() int64 { return st.decompressedSize }
+
+// NumFrames returns the number of frames of the content.
+func (st *SeekTable) NumFrames() int { return len(st.frames) }
+
+// frameAt returns the index of the frame holding the decompressed offset off.
+func (st *SeekTable) frameAt(off int64) int {
+	return sort.Search(len(st.frames), func(i int) bool {
+		return st.frames[i].decompressedOffset+int64(st.frames[i].decompressedSize) > off
+	})
+}
+
+// SeekableReader reads the decompressed content of seekable zstd content,
+// decompressing only the frames it reads from.
+type SeekableReader struct {
+	r     io.ReaderAt
+	table *SeekTable
+	off   int64
+
+	frame      int
+	frameData  []byte
+	compressed []byte
+}
+
+// NewSeekableReader returns a SeekableReader of the size bytes of seekable
+// zstd content in r. It returns ErrNotSeekable if r has no seek table. If r is
+// an io.Closer, Close closes it.
+func NewSeekableReader(r io.ReaderAt, size int64) (*SeekableReader, error) {
+	table, err := ReadSeekTable(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SeekableReader{r: r, table: table, frame: -1}, nil
+}
+
+// Size returns the size of the decompressed content.
+func (sr *SeekableReader) Size() int64 { return sr.table.decompressedSize }
+
+// Read reads the decompressed content from the current offset.
+func (sr *SeekableReader) Read(p []byte) (int, error) {
+	n, err := sr.ReadAt(p, sr.off)
+	sr.off += int64(n)
+
+	if errors.Is(err, io.EOF) && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// ReadAt reads len(p) bytes of decompressed content starting at off.
+func (sr *SeekableReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+
+	n := 0
+
+	for n < len(p) {
+		if off >= sr.table.decompressedSize {
+			return n, io.EOF
+		}
+
+		i := sr.table.frameAt(off)
+		if err := sr.loadFrame(i); err != nil {
+			return n, err
+		}
+
+		copied := copy(p[n:], sr.frameData[off-sr.table.frames[i].decompressedOffset:])
+		n += copied
+		off += int64(copied)
+	}
+
+	return n, nil
+}
+
+// Seek sets the offset of the next Read in the decompressed content.
+func (sr *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += sr.off
+	case io.SeekEnd:
+		offset += sr.table.decompressedSize
+	default:
+		return 0, errInvalidWhence
+	}
+
+	if offset < 0 {
+		return 0, errNegativeOffset
+	}
+
+	sr.off = offset
+
+	return offset, nil
+}
+
+// Close closes the underlying reader if it is an io.Closer.
+func (sr *SeekableReader) Close() error {
+	sr.frameData, sr.compressed = nil, nil
+
+	if c, ok := sr.r.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+func (sr *SeekableReader) loadFrame(i int) error {
+	if sr.frame == i {
+		return nil
+	}
+
+	f := sr.table.frames[i]
+
+	if cap(sr.compressed) < int(f.compressedSize) {
+		sr.compressed = make([]byte, f.compressedSize)
+	}
+
+	sr.compressed = sr.compressed[:f.compressedSize]
+
+	if err := readFullAt(sr.r, sr.compressed, f.compressedOffset); err != nil {
+		return fmt.Errorf("error reading zstd frame %d: %w", i, err)
+	}
+
+	// frameData is reused for the new frame, invalidate the cached one first.
+	sr.frame = -1
+
+	dec := GetReader()
+	data, err := dec.DecodeAll(sr.compressed, sr.frameData[:0])
+	PutReader(dec)
+
+	if err != nil {
+		return fmt.Errorf("error decompressing zstd frame %d: %w", i, err)
+	}
+
+	if len(data) != int(f.decompressedSize) {
+		return fmt.Errorf("%w: frame %d decompressed to %d bytes, expected %d", ErrCorruptSeekTable,
+			i, len(data), f.decompressedSize)
+	}
+
+	sr.frame, sr.frameData = i, data
+
+	return nil
+}
+
+// readFullAt fills p from r at off. Unlike io.ReaderAt it does not return
+// io.EOF when p ends exactly at the end of r.
+func readFullAt(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
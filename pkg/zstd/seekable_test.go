@@ -0,0 +1,141 @@
+package zstd_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kalbasit/ncps/pkg/zstd"
+)
+
+func seekableCompress(t *testing.T, data []byte, frameSize int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	sw := zstd.NewSeekableWriter(&buf, frameSize)
+
+	// Write in odd-sized pieces so frames straddle the writes.
+	for len(data) > 0 {
+		n := min(777, len(data))
+		_, err := sw.Write(data[:n])
+		require.NoError(t, err)
+
+		data = data[n:]
+	}
+
+	require.NoError(t, sw.Close())
+
+	return buf.Bytes()
+}
+
+func TestSeekable(t *testing.T) {
+	t.Parallel()
+
+	data := make([]byte, 10_000)
+	for i := range data {
+		data[i] = byte(rand.IntN(16)) //nolint:gosec // test data
+	}
+
+	compressed := seekableCompress(t, data, 1024)
+
+	t.Run("is readable as a regular zstd stream", func(t *testing.T) {
+		t.Parallel()
+
+		pr, err := zstd.NewPooledReader(bytes.NewReader(compressed))
+		require.NoError(t, err)
+
+		defer pr.Close()
+
+		got, err := io.ReadAll(pr)
+		require.NoError(t, err)
+		assert.Equal(t, data, got)
+	})
+
+	t.Run("seek table describes the frames", func(t *testing.T) {
+		t.Parallel()
+
+		st, err := zstd.ReadSeekTable(bytes.NewReader(compressed), int64(len(compressed)))
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), st.DecompressedSize())
+		assert.Equal(t, 10, st.NumFrames())
+	})
+
+	t.Run("reads from any offset", func(t *testing.T) {
+		t.Parallel()
+
+		sr, err := zstd.NewSeekableReader(bytes.NewReader(compressed), int64(len(compressed)))
+		require.NoError(t, err)
+
+		defer sr.Close()
+
+		assert.Equal(t, int64(len(data)), sr.Size())
+
+		for _, off := range []int64{0, 1, 1023, 1024, 5000, 9999} {
+			_, err := sr.Seek(off, io.SeekStart)
+			require.NoError(t, err)
+
+			got := make([]byte, 1500)
+			n, err := io.ReadFull(sr, got)
+
+			want := data[off:min(off+1500, int64(len(data)))]
+			if len(want) < len(got) {
+				require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+			} else {
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, want, got[:n], "offset %d", off)
+		}
+	})
+
+	t.Run("seeks relative to the end", func(t *testing.T) {
+		t.Parallel()
+
+		sr, err := zstd.NewSeekableReader(bytes.NewReader(compressed), int64(len(compressed)))
+		require.NoError(t, err)
+
+		pos, err := sr.Seek(-10, io.SeekEnd)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)-10), pos)
+
+		got, err := io.ReadAll(sr)
+		require.NoError(t, err)
+		assert.Equal(t, data[len(data)-10:], got)
+	})
+}
+
+func TestSeekableEmpty(t *testing.T) {
+	t.Parallel()
+
+	compressed := seekableCompress(t, nil, 0)
+
+	pr, err := zstd.NewPooledReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+
+	defer pr.Close()
+
+	got, err := io.ReadAll(pr)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	sr, err := zstd.NewSeekableReader(bytes.NewReader(compressed), int64(len(compressed)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), sr.Size())
+}
+
+func TestSeekableRejectsRegularZstd(t *testing.T) {
+	t.Parallel()
+
+	enc := zstd.GetWriter()
+	defer zstd.PutWriter(enc)
+
+	compressed := enc.EncodeAll([]byte("hello world, this is not seekable"), nil)
+
+	_, err := zstd.NewSeekableReader(bytes.NewReader(compressed), int64(len(compressed)))
+	require.ErrorIs(t, err, zstd.ErrNotSeekable)
+}
@@ -139,6 +139,10 @@ func PutReader(dec *zstd.Decoder) {
 type PooledWriter struct {
 	*zstd.Encoder
 	w io.Writer
+
+	// encoders is the pool the encoder is returned to; nil is the default
+	// pool.
+	encoders *encoderPool
 }
 
 // NewPooledWriter creates a new pooled writer that wraps the given io.Writer.
@@ -162,7 +166,13 @@ func (pw *PooledWriter) Close() error {
 	}
 
 	err := pw.Encoder.Close()
-	PutWriter(pw.Encoder)
+
+	if pw.encoders != nil {
+		pw.encoders.put(pw.Encoder)
+	} else {
+		PutWriter(pw.Encoder)
+	}
+
 	pw.Encoder = nil
 
 	return err